@@ -12,6 +12,7 @@ import (
 	"time"
 
 	eewebhooks "github.com/fleetdm/fleet/v4/ee/server/webhooks"
+	"github.com/fleetdm/fleet/v4/pkg/fleethttp"
 	"github.com/fleetdm/fleet/v4/server"
 	"github.com/fleetdm/fleet/v4/server/config"
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
@@ -19,6 +20,7 @@ import (
 	"github.com/fleetdm/fleet/v4/server/datastore/mysql"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	apple_mdm "github.com/fleetdm/fleet/v4/server/mdm/apple"
+	"github.com/fleetdm/fleet/v4/server/mdm/secrets"
 	"github.com/fleetdm/fleet/v4/server/policies"
 	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/service"
@@ -502,6 +504,23 @@ func triggerFailingPoliciesAutomation(
 	}
 
 	err = policies.TriggerFailingPoliciesAutomation(ctx, ds, logger, failingPoliciesSet, func(policy *fleet.Policy, cfg policies.FailingPolicyAutomationConfig) error {
+		// the MDM action is queued first as the automation below removes the
+		// hosts from the set once processed.
+		if cfg.MDMAction != nil {
+			hosts, err := failingPoliciesSet.ListHosts(policy.ID)
+			if err != nil {
+				return ctxerr.Wrapf(ctx, err, "listing hosts for failing policies set %d", policy.ID)
+			}
+			if err := worker.QueueMDMApplePolicyActionJob(ctx, ds, logger, policy, hosts); err != nil {
+				return err
+			}
+			if cfg.AutomationType == "" {
+				if err := failingPoliciesSet.RemoveHosts(policy.ID, hosts); err != nil {
+					return ctxerr.Wrapf(ctx, err, "removing %d hosts from failing policies set %d", len(hosts), policy.ID)
+				}
+			}
+		}
+
 		switch cfg.AutomationType {
 		case policies.FailingPolicyWebhook:
 			return webhooks.SendFailingPoliciesBatchedPOSTs(
@@ -545,6 +564,7 @@ func newWorkerIntegrationsSchedule(
 	instanceID string,
 	ds fleet.Datastore,
 	logger kitlog.Logger,
+	commander *apple_mdm.MDMAppleCommander,
 ) (*schedule.Schedule, error) {
 	const (
 		name = string(fleet.CronWorkerIntegrations)
@@ -577,6 +597,31 @@ func newWorkerIntegrationsSchedule(
 	// the up-to-date config.
 	w.Register(jira)
 	w.Register(zendesk)
+	// the MDM profiles job is registered here as well so that it uses the same
+	// queue, its jobs are only created if a profiles change affects many hosts.
+	w.Register(&worker.MDMAppleBulkSetPending{
+		Datastore: ds,
+		Log:       logger,
+	})
+	w.Register(&worker.TeamDeletion{
+		Datastore: ds,
+		Log:       logger,
+	})
+	// the MDM events webhook job is registered even if the webhook is not
+	// enabled, as that config can change live.
+	w.Register(&worker.MDMWebhook{
+		Datastore: ds,
+		Log:       logger,
+	})
+	// the policy MDM actions job requires the MDM commander, its jobs are only
+	// created if Fleet MDM is configured.
+	if commander != nil {
+		w.Register(&worker.MDMApplePolicyAction{
+			Datastore: ds,
+			Commander: commander,
+			Log:       logger,
+		})
+	}
 
 	// Read app config a first time before starting, to clear up any failer client
 	// configuration if we're not on a fleet-owned server. Technically, the ServerURL
@@ -680,6 +725,7 @@ func newCleanupsAndAggregationSchedule(
 	ds fleet.Datastore,
 	logger kitlog.Logger,
 	enrollHostLimiter fleet.EnrollHostLimiter,
+	mdmAssetStore fleet.MDMAssetStore,
 	config *config.FleetConfig,
 ) (*schedule.Schedule, error) {
 	const (
@@ -744,6 +790,59 @@ func newCleanupsAndAggregationSchedule(
 				return ds.CleanupExpiredPasswordResetRequests(ctx)
 			},
 		),
+		schedule.WithJob(
+			"cleanup_mdm_apple_bootstrap_package_uploads",
+			func(ctx context.Context) error {
+				_, err := ds.CleanupMDMAppleBootstrapPackageUploads(ctx, time.Now())
+				return err
+			},
+		),
+		schedule.WithJob(
+			"cleanup_host_mdm_changes",
+			func(ctx context.Context) error {
+				_, err := ds.CleanupHostMDMChanges(ctx, time.Now())
+				return err
+			},
+		),
+		schedule.WithJob(
+			"cleanup_host_mdm_apple_command_history",
+			func(ctx context.Context) error {
+				_, err := ds.CleanupHostMDMAppleCommandHistory(ctx, time.Now())
+				return err
+			},
+		),
+		schedule.WithJob(
+			"cleanup_mdm_posture_scores",
+			func(ctx context.Context) error {
+				_, err := ds.CleanupMDMPostureScores(ctx, time.Now())
+				return err
+			},
+		),
+		schedule.WithJob(
+			"cleanup_mdm_assets",
+			func(ctx context.Context) error {
+				if mdmAssetStore == nil {
+					return nil
+				}
+				tokens, err := ds.ListMDMAppleAssetTokens(ctx)
+				if err != nil {
+					return err
+				}
+				// only delete assets stored more than a day ago, to leave time
+				// for in-progress uploads to be saved in the database.
+				_, err = mdmAssetStore.Cleanup(ctx, tokens, time.Now().Add(-24*time.Hour))
+				return err
+			},
+		),
+		schedule.WithJob(
+			"verify_mdm_apple_bootstrap_package_signatures",
+			func(ctx context.Context) error {
+				return apple_mdm.VerifyBootstrapPackageSignatures(
+					ctx, ds, mdmAssetStore, fleethttp.NewClient(fleethttp.WithTimeout(30*time.Second)),
+					kitlog.With(logger, "cron", name), time.Now(),
+				)
+			},
+		),
 		// Run aggregation jobs after cleanups.
 		schedule.WithJob(
 			"query_aggregated_stats",
@@ -775,6 +874,12 @@ func newCleanupsAndAggregationSchedule(
 				return ds.UpdateOSVersions(ctx)
 			},
 		),
+		schedule.WithJob(
+			"mdm_posture_scores",
+			func(ctx context.Context) error {
+				return service.ComputeMDMPostureScores(ctx, ds, time.Now())
+			},
+		),
 		schedule.WithJob(
 			"verify_disk_encryption_keys",
 			func(ctx context.Context) error {
@@ -803,7 +908,7 @@ func verifyDiskEncryptionKeys(
 		return err
 	}
 
-	cert, _, _, err := config.MDM.AppleSCEP()
+	certs, err := config.MDM.AppleSCEPCertificates()
 	if err != nil {
 		logger.Log("err", "unable to get SCEP keypair to decrypt keys", "details", err)
 		return err
@@ -816,7 +921,7 @@ func verifyDiskEncryptionKeys(
 		if key.UpdatedAt.After(latest) {
 			latest = key.UpdatedAt
 		}
-		if _, err := apple_mdm.DecryptBase64CMS(key.Base64Encrypted, cert.Leaf, cert.PrivateKey); err != nil {
+		if _, err := apple_mdm.DecryptBase64CMSWithCertificates(key.Base64Encrypted, certs); err != nil {
 			undecryptable = append(undecryptable, key.HostID)
 			continue
 		}
@@ -891,20 +996,26 @@ func newAppleMDMDEPProfileAssigner(
 	ctx context.Context,
 	instanceID string,
 	periodicity time.Duration,
+	syncWindows []config.DailyWindow,
 	ds fleet.Datastore,
 	depStorage *mysql.NanoDEPStorage,
+	mailService fleet.MailService,
+	config config.FleetConfig,
 	logger kitlog.Logger,
 	loggingDebug bool,
 ) (*schedule.Schedule, error) {
 	const name = string(fleet.CronAppleMDMDEPProfileAssigner)
 	logger = kitlog.With(logger, "cron", name, "component", "nanodep-syncer")
-	fleetSyncer := apple_mdm.NewDEPService(ds, depStorage, logger, loggingDebug)
+	fleetSyncer := apple_mdm.NewDEPService(ds, depStorage, logger, loggingDebug, apple_mdm.WithDEPSyncWindows(syncWindows))
 	s := schedule.New(
 		ctx, name, instanceID, periodicity, ds, ds,
 		schedule.WithLogger(logger),
-		schedule.WithJob("dep_syncer", func(ctx context.Context) error {
-			return fleetSyncer.RunAssigner(ctx)
-		}),
+		schedule.WithJob("dep_syncer", service.MonitorMDMCronJob(ds, mailService, config, logger,
+			fleet.MDMMonitoredCronJob{Schedule: fleet.CronAppleMDMDEPProfileAssigner, Job: "dep_syncer"},
+			func(ctx context.Context) error {
+				return fleetSyncer.RunAssigner(ctx)
+			},
+		)),
 	)
 
 	return s, nil
@@ -915,6 +1026,11 @@ func newMDMAppleProfileManager(
 	instanceID string,
 	ds fleet.Datastore,
 	commander *apple_mdm.MDMAppleCommander,
+	secretStore secrets.Store,
+	scepChallenge string,
+	pushCertTopic string,
+	mailService fleet.MailService,
+	config config.FleetConfig,
 	logger kitlog.Logger,
 	loggingDebug bool,
 ) (*schedule.Schedule, error) {
@@ -929,8 +1045,23 @@ func newMDMAppleProfileManager(
 	s := schedule.New(
 		ctx, name, instanceID, defaultInterval, ds, ds,
 		schedule.WithLogger(logger),
-		schedule.WithJob("manage_profiles", func(ctx context.Context) error {
-			return service.ReconcileProfiles(ctx, ds, commander, logger)
+		schedule.WithJob("manage_profiles", service.MonitorMDMCronJob(ds, mailService, config, logger,
+			fleet.MDMMonitoredCronJob{Schedule: fleet.CronMDMAppleProfileManager, Job: "manage_profiles"},
+			func(ctx context.Context) error {
+				return service.ReconcileProfiles(ctx, ds, commander, secretStore, logger)
+			},
+		)),
+		schedule.WithJob("refresh_certificates", func(ctx context.Context) error {
+			return service.RefreshMDMAppleHostCertificates(ctx, ds, commander, logger)
+		}),
+		schedule.WithJob("refresh_profile_lists", func(ctx context.Context) error {
+			return service.RefreshMDMAppleHostProfileLists(ctx, ds, commander, logger)
+		}),
+		schedule.WithJob("redeliver_enrollment_profiles", func(ctx context.Context) error {
+			return service.RedeliverMDMAppleEnrollmentProfiles(ctx, ds, commander, scepChallenge, pushCertTopic, logger)
+		}),
+		schedule.WithJob("notify_filevault_key_rotations", func(ctx context.Context) error {
+			return service.NotifyMDMAppleFileVaultKeyRotations(ctx, ds, logger)
 		}),
 	)
 
@@ -1038,3 +1169,81 @@ func cronActivitiesStreaming(
 		page += 1
 	}
 }
+
+func newSecurityAuditLogStreamingSchedule(
+	ctx context.Context,
+	instanceID string,
+	ds fleet.Datastore,
+	logger kitlog.Logger,
+	securityAuditLogger fleet.JSONLogger,
+) (*schedule.Schedule, error) {
+	const (
+		name     = string(fleet.CronSecurityAuditLogStreaming)
+		interval = 1 * time.Minute
+	)
+	logger = kitlog.With(logger, "cron", name)
+	s := schedule.New(
+		ctx, name, instanceID, interval, ds, ds,
+		schedule.WithLogger(logger),
+		schedule.WithJob(
+			"cron_security_audit_log_streaming",
+			func(ctx context.Context) error {
+				return cronSecurityAuditLogStreaming(ctx, ds, logger, securityAuditLogger)
+			},
+		),
+	)
+	return s, nil
+}
+
+var SecurityAuditLogToStreamBatchCount uint = 500
+
+// cronSecurityAuditLogStreaming streams the entries of the security audit log
+// that weren't streamed yet, in order, so that the external copy can be used
+// to verify the hash chain of the entries stored in the database.
+func cronSecurityAuditLogStreaming(
+	ctx context.Context,
+	ds fleet.Datastore,
+	logger kitlog.Logger,
+	securityAuditLogger fleet.JSONLogger,
+) error {
+	for {
+		entries, err := ds.ListSecurityAuditLogToStream(ctx, SecurityAuditLogToStreamBatchCount)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "list security audit log entries to stream")
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+
+		var (
+			streamedIDs []uint
+			streamErr   error
+		)
+		// the entries are streamed one at a time and the streaming stops at
+		// the first error so that they are always streamed in order.
+		for _, entry := range entries {
+			b, err := json.Marshal(entry)
+			if err != nil {
+				return ctxerr.Wrap(ctx, err, "marshal security audit log entry")
+			}
+			if err := securityAuditLogger.Write(ctx, []json.RawMessage{json.RawMessage(b)}); err != nil {
+				streamErr = ctxerr.Wrapf(ctx, err, "stream security audit log entry: %d", entry.ID)
+				break
+			}
+			streamedIDs = append(streamedIDs, entry.ID)
+		}
+
+		logger.Log("streamed-events", len(streamedIDs))
+
+		if err := ds.MarkSecurityAuditLogAsStreamed(ctx, streamedIDs); err != nil {
+			streamErr = multierror.Append(streamErr, ctxerr.Wrap(ctx, err, "mark security audit log entries as streamed"))
+		}
+		if streamErr != nil {
+			return streamErr
+		}
+
+		if len(entries) < int(SecurityAuditLogToStreamBatchCount) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build boringcrypto
+
+package main
+
+import (
+	"crypto/boring"
+
+	// restrict the TLS connections to the FIPS-approved settings
+	_ "crypto/tls/fipsonly"
+)
+
+// boringCryptoEnabled returns true if the cryptographic operations are
+// performed by the BoringCrypto module, as required by the FIPS mode.
+func boringCryptoEnabled() bool {
+	return boring.Enabled()
+}
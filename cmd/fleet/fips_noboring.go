@@ -0,0 +1,10 @@
+//go:build !boringcrypto
+
+package main
+
+// boringCryptoEnabled returns true if the cryptographic operations are
+// performed by the BoringCrypto module, as required by the FIPS mode. The
+// Fleet server must be built with GOEXPERIMENT=boringcrypto for that.
+func boringCryptoEnabled() bool {
+	return false
+}
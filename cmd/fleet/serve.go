@@ -41,6 +41,8 @@ import (
 	"github.com/fleetdm/fleet/v4/server/logging"
 	"github.com/fleetdm/fleet/v4/server/mail"
 	apple_mdm "github.com/fleetdm/fleet/v4/server/mdm/apple"
+	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
+	"github.com/fleetdm/fleet/v4/server/mdm/secrets"
 	"github.com/fleetdm/fleet/v4/server/pubsub"
 	"github.com/fleetdm/fleet/v4/server/service"
 	"github.com/fleetdm/fleet/v4/server/service/async"
@@ -52,8 +54,6 @@ import (
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	"github.com/kolide/kit/version"
 	"github.com/micromdm/nanomdm/cryptoutil"
-	"github.com/micromdm/nanomdm/push/buford"
-	nanomdm_pushsvc "github.com/micromdm/nanomdm/push/service"
 	scep_depot "github.com/micromdm/scep/v2/depot"
 	"github.com/ngrok/sqlmw"
 	"github.com/prometheus/client_golang/prometheus"
@@ -61,6 +61,7 @@ import (
 	"github.com/spf13/cobra"
 	_ "go.elastic.co/apm/module/apmsql"
 	_ "go.elastic.co/apm/module/apmsql/mysql"
+	"go.mozilla.org/pkcs7"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
@@ -143,6 +144,21 @@ the way that the Fleet server works.
 				initFatal(fmt.Errorf("%s is not a valid value for osquery_host_identifier", config.Osquery.HostIdentifier), "set host identifier")
 			}
 
+			if _, err := config.MDM.ProfileChecksum(); err != nil {
+				initFatal(err, "validate MDM profile checksum")
+			}
+			if config.MDM.FIPSMode {
+				if !boringCryptoEnabled() {
+					initFatal(errors.New("mdm.fips_mode requires a Fleet server built with GOEXPERIMENT=boringcrypto"), "validate FIPS mode")
+				}
+				if err := config.MDM.ValidateFIPS(); err != nil {
+					initFatal(err, "validate FIPS mode")
+				}
+				// the SCEP responses are encrypted with DES-CBC by default
+				pkcs7.ContentEncryptionAlgorithm = pkcs7.EncryptionAlgorithmAES128CBC
+				mobileconfig.SetFIPSMode(true)
+			}
+
 			if len(config.Server.URLPrefix) > 0 {
 				// Massage provided prefix to match expected format
 				config.Server.URLPrefix = strings.TrimSuffix(config.Server.URLPrefix, "/")
@@ -161,6 +177,7 @@ the way that the Fleet server works.
 			var ds fleet.Datastore
 			var carveStore fleet.CarveStore
 			var installerStore fleet.InstallerStore
+			var mdmAssetStore fleet.MDMAssetStore
 
 			opts := []mysql.DBOption{mysql.Logger(logger), mysql.WithFleetConfig(&config)}
 			if config.MysqlReadReplica.Address != "" {
@@ -199,6 +216,19 @@ the way that the Fleet server works.
 				}
 			}
 
+			if config.MDM.S3.Bucket != "" {
+				var err error
+				mdmAssetStore, err = s3.NewMDMAssetStore(config.MDM.S3)
+				if err != nil {
+					initFatal(err, "initializing S3 MDM asset store")
+				}
+			}
+
+			mdmSecretStore, err := secrets.New(config.MDM.Secrets)
+			if err != nil {
+				initFatal(err, "initializing MDM secrets store")
+			}
+
 			migrationStatus, err := ds.MigrationStatus(cmd.Context())
 			if err != nil {
 				initFatal(err, "retrieving migration status")
@@ -417,6 +447,26 @@ the way that the Fleet server works.
 				}
 			}
 
+			var securityAuditLogger fleet.JSONLogger
+			if config.Activity.SecurityAuditLogPlugin != "" {
+				// The security audit log is written to its own file with the
+				// filesystem plugin, the other plugins use their audit
+				// destinations.
+				loggingConfig.Plugin = config.Activity.SecurityAuditLogPlugin
+				loggingConfig.Filesystem.LogFile = config.Filesystem.SecurityAuditLogFile
+				loggingConfig.Firehose.StreamName = config.Firehose.AuditStream
+				loggingConfig.Kinesis.StreamName = config.Kinesis.AuditStream
+				loggingConfig.Lambda.Function = config.Lambda.AuditFunction
+				loggingConfig.PubSub.Topic = config.PubSub.AuditTopic
+				loggingConfig.PubSub.AddAttributes = false // only used by result logs
+				loggingConfig.KafkaREST.Topic = config.KafkaREST.AuditTopic
+
+				securityAuditLogger, err = logging.NewJSONLogger("security_audit", loggingConfig, logger)
+				if err != nil {
+					initFatal(err, "initializing security audit logging")
+				}
+			}
+
 			failingPolicySet := redis_policy_set.NewFailing(redisPool)
 
 			task := async.NewTask(ds, redisPool, clock.C, config.Osquery)
@@ -454,12 +504,22 @@ the way that the Fleet server works.
 				appleAPNsCertPEM            []byte
 				appleAPNsKeyPEM             []byte
 				depStorage                  *mysql.NanoDEPStorage
+				depSyncWindows              []configpkg.DailyWindow
 				mdmStorage                  *mysql.NanoMDMStorage
-				mdmPushService              *nanomdm_pushsvc.PushService
+				mdmPushService              *apple_mdm.PushService
+				apnsProxyURL                *url.URL
 				mdmCheckinAndCommandService *service.MDMAppleCheckinAndCommandService
 				mdmPushCertTopic            string
 			)
 
+			// load the Apple MDM assets set up via the API, unless they are
+			// provided in the server configuration.
+			if !config.MDM.IsAppleAPNsSet() && !config.MDM.IsAppleSCEPSet() && !config.MDM.IsAppleBMSet() {
+				if err := loadMDMConfigAssets(context.Background(), ds, &config.MDM); err != nil {
+					initFatal(err, "load Apple MDM assets")
+				}
+			}
+
 			// validate Apple APNs/SCEP config
 			if config.MDM.IsAppleAPNsSet() || config.MDM.IsAppleSCEPSet() {
 				if !config.MDM.IsAppleAPNsSet() {
@@ -483,6 +543,9 @@ the way that the Fleet server works.
 				if err != nil {
 					initFatal(err, "validate Apple SCEP certificate and key")
 				}
+				if _, err := config.MDM.AppleSCEPPrevious(); err != nil {
+					initFatal(err, "validate previous Apple SCEP certificate and key")
+				}
 
 				const (
 					apnsConnectionTimeout = 10 * time.Second
@@ -505,6 +568,7 @@ the way that the Fleet server works.
 			// everything is properly configured below
 			appCfg.MDM.EnabledAndConfigured = false
 			appCfg.MDM.AppleBMEnabledAndConfigured = false
+			appCfg.MDM.WindowsEnabledAndConfigured = false
 
 			// validate Apple BM config
 			if config.MDM.IsAppleBMSet() {
@@ -520,6 +584,10 @@ the way that the Fleet server works.
 				if err != nil {
 					initFatal(err, "initialize Apple BM DEP storage")
 				}
+				depSyncWindows, err = config.MDM.AppleDEPWindows()
+				if err != nil {
+					initFatal(err, "validate Apple DEP sync windows")
+				}
 				appCfg.MDM.AppleBMEnabledAndConfigured = true
 			}
 
@@ -533,13 +601,55 @@ the way that the Fleet server works.
 					initFatal(err, "initialize mdm apple MySQL storage")
 				}
 				nanoMDMLogger := service.NewNanoMDMLogger(kitlog.With(logger, "component", "apple-mdm-push"))
-				pushProviderFactory := buford.NewPushProviderFactory()
-				mdmPushService = nanomdm_pushsvc.New(mdmStorage, mdmStorage, pushProviderFactory, nanoMDMLogger)
+				apnsProxyURL, err = config.MDM.AppleAPNsProxy()
+				if err != nil {
+					initFatal(err, "validate Apple APNs proxy")
+				}
+				pushProvider, pushWebhookURL, err := config.MDM.ApplePush()
+				if err != nil {
+					initFatal(err, "validate Apple push provider")
+				}
+				switch pushProvider {
+				case "log":
+					level.Warn(logger).Log("msg", "MDM push notifications are only logged, not sent to APNs")
+					mdmPushService = apple_mdm.NewPushServiceWithFactory(mdmStorage, mdmStorage, ds,
+						apple_mdm.LogPushProviderFactory{Logger: nanoMDMLogger}, nanoMDMLogger)
+				case "webhook":
+					level.Info(logger).Log("msg", "MDM push notifications are sent to a webhook instead of APNs", "url", pushWebhookURL.Redacted())
+					mdmPushService = apple_mdm.NewPushServiceWithFactory(mdmStorage, mdmStorage, ds,
+						apple_mdm.WebhookPushProviderFactory{URL: pushWebhookURL}, nanoMDMLogger)
+				default:
+					mdmPushService = apple_mdm.NewPushService(mdmStorage, mdmStorage, ds, apnsProxyURL, nanoMDMLogger)
+				}
 				commander := apple_mdm.NewMDMAppleCommander(mdmStorage, mdmPushService)
-				mdmCheckinAndCommandService = service.NewMDMAppleCheckinAndCommandService(ds, commander, logger)
+				scepCert, _, _, err := config.MDM.AppleSCEP()
+				if err != nil {
+					initFatal(err, "validate Apple SCEP certificate and key")
+				}
+				mdmCheckinAndCommandService = service.NewMDMAppleCheckinAndCommandService(ds, commander, scepCert.Leaf, logger)
 				appCfg.MDM.EnabledAndConfigured = true
 			}
 
+			// validate Microsoft WSTEP config
+			var windowsManagementURL *url.URL
+			if config.MDM.IsMicrosoftWSTEPSet() {
+				if _, _, _, err := config.MDM.MicrosoftWSTEP(); err != nil {
+					initFatal(err, "validate Microsoft WSTEP identity certificate and key")
+				}
+				windowsManagementURL, err = config.MDM.MicrosoftManagementURL()
+				if err != nil {
+					initFatal(err, "validate Microsoft MDM management URL")
+				}
+				clientCertHeader, _, err := config.MDM.MicrosoftClientCertProxies()
+				if err != nil {
+					initFatal(err, "validate Microsoft MDM client certificate configuration")
+				}
+				if (windowsManagementURL == nil || !config.Server.TLS) && clientCertHeader == "" {
+					level.Warn(logger).Log("msg", "Windows MDM is enabled but the client certificates of the Windows devices are neither requested nor forwarded, set mdm.windows_management_url or mdm.windows_client_cert_header for their management sessions")
+				}
+				appCfg.MDM.WindowsEnabledAndConfigured = true
+			}
+
 			// save the app config with the updated MDM.Enabled value
 			if err := ds.SaveAppConfig(context.Background(), appCfg); err != nil {
 				initFatal(err, "saving app config")
@@ -608,6 +718,7 @@ the way that the Fleet server works.
 					apple_mdm.NewMDMAppleCommander(mdmStorage, mdmPushService),
 					mdmPushCertTopic,
 					ssoSessionStore,
+					mdmAssetStore,
 				)
 				if err != nil {
 					initFatal(err, "initial Fleet Premium service")
@@ -648,7 +759,7 @@ the way that the Fleet server works.
 			}()
 
 			if err := cronSchedules.StartCronSchedule(func() (fleet.CronSchedule, error) {
-				return newCleanupsAndAggregationSchedule(ctx, instanceID, ds, logger, redisWrapperDS, &config)
+				return newCleanupsAndAggregationSchedule(ctx, instanceID, ds, logger, redisWrapperDS, mdmAssetStore, &config)
 			}); err != nil {
 				initFatal(err, "failed to register cleanups_then_aggregations schedule")
 			}
@@ -677,14 +788,18 @@ the way that the Fleet server works.
 			}
 
 			if err := cronSchedules.StartCronSchedule(func() (fleet.CronSchedule, error) {
-				return newWorkerIntegrationsSchedule(ctx, instanceID, ds, logger)
+				var commander *apple_mdm.MDMAppleCommander
+				if appCfg.MDM.EnabledAndConfigured {
+					commander = apple_mdm.NewMDMAppleCommander(mdmStorage, mdmPushService)
+				}
+				return newWorkerIntegrationsSchedule(ctx, instanceID, ds, logger, commander)
 			}); err != nil {
 				initFatal(err, "failed to register worker integrations schedule")
 			}
 
 			if license.IsPremium() && appCfg.MDM.EnabledAndConfigured && config.MDM.IsAppleBMSet() {
 				if err := cronSchedules.StartCronSchedule(func() (fleet.CronSchedule, error) {
-					return newAppleMDMDEPProfileAssigner(ctx, instanceID, config.MDM.AppleDEPSyncPeriodicity, ds, depStorage, logger, config.Logging.Debug)
+					return newAppleMDMDEPProfileAssigner(ctx, instanceID, config.MDM.AppleDEPSyncPeriodicity, depSyncWindows, ds, depStorage, mailService, config, logger, config.Logging.Debug)
 				}); err != nil {
 					initFatal(err, "failed to register apple_mdm_dep_profile_assigner schedule")
 				}
@@ -697,6 +812,11 @@ the way that the Fleet server works.
 						instanceID,
 						ds,
 						apple_mdm.NewMDMAppleCommander(mdmStorage, mdmPushService),
+						mdmSecretStore,
+						config.MDM.AppleSCEPChallenge,
+						mdmPushCertTopic,
+						mailService,
+						config,
 						logger,
 						config.Logging.Debug,
 					)
@@ -713,6 +833,14 @@ the way that the Fleet server works.
 				}
 			}
 
+			if config.Activity.SecurityAuditLogPlugin != "" {
+				if err := cronSchedules.StartCronSchedule(func() (fleet.CronSchedule, error) {
+					return newSecurityAuditLogStreamingSchedule(ctx, instanceID, ds, logger, securityAuditLogger)
+				}); err != nil {
+					initFatal(err, "failed to register security audit log streaming schedule")
+				}
+			}
+
 			level.Info(logger).Log("msg", fmt.Sprintf("started cron schedules: %s", strings.Join(cronSchedules.ScheduleNames(), ", ")))
 
 			// StartCollectors starts a goroutine per collector, using ctx to cancel.
@@ -787,6 +915,9 @@ the way that the Fleet server works.
 					"mysql": ds,
 					"redis": resultStore,
 				}
+				if apnsProxyURL != nil {
+					deps["apns_proxy"] = apple_mdm.APNsProxyChecker{ProxyURL: apnsProxyURL}
+				}
 
 				// convert all dependencies to health.Checker if they implement the healthz methods.
 				for name, dep := range deps {
@@ -912,6 +1043,21 @@ the way that the Fleet server works.
 				} else {
 					logger.Log("transport", "https", "address", config.Server.Address, "msg", "listening")
 					srv.TLSConfig = getTLSConfig(config.Server.TLSProfile)
+					if windowsManagementURL != nil {
+						// the Windows devices authenticate their management
+						// sessions with the identity certificate issued when
+						// they enrolled, it is only requested for the
+						// connections to the host name of the management URL.
+						cert, err := tls.LoadX509KeyPair(config.Server.Cert, config.Server.Key)
+						if err != nil {
+							errs <- err
+							return
+						}
+						srv.TLSConfig.Certificates = []tls.Certificate{cert}
+						srv.TLSConfig.GetConfigForClient = requestClientCertForServerName(srv.TLSConfig, windowsManagementURL.Hostname())
+						errs <- srv.ListenAndServeTLS("", "")
+						return
+					}
 					errs <- srv.ListenAndServeTLS(
 						config.Server.Cert,
 						config.Server.Key,
@@ -986,6 +1132,20 @@ func basicAuthHandler(username, password string, next http.Handler) http.Handler
 // value supplied to server_tls_compatibility command line flag. The default
 // profile is 'modern'.
 // See https://wiki.mozilla.org/index.php?title=Security/Server_Side_TLS&oldid=1229478
+// requestClientCertForServerName returns a tls.Config.GetConfigForClient
+// function that requests the client certificate, without requiring it, when
+// the client connects to serverName. The other connections use cfg.
+func requestClientCertForServerName(cfg *tls.Config, serverName string) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	clientCertCfg := cfg.Clone()
+	clientCertCfg.ClientAuth = tls.RequestClientCert
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if strings.EqualFold(hello.ServerName, serverName) {
+			return clientCertCfg, nil
+		}
+		return nil, nil
+	}
+}
+
 func getTLSConfig(profile string) *tls.Config {
 	cfg := tls.Config{
 		PreferServerCipherSuites: true,
@@ -1092,6 +1252,33 @@ func argsToString(args []driver.NamedValue) string {
 	return allArgs.String()
 }
 
+// loadMDMConfigAssets sets the Apple MDM assets of the configuration to the
+// values stored in the database when MDM was set up via the API.
+func loadMDMConfigAssets(ctx context.Context, ds fleet.Datastore, mdmCfg *configpkg.MDMConfig) error {
+	fields := map[fleet.MDMConfigAssetName]*string{
+		fleet.MDMConfigAssetAPNsCert: &mdmCfg.AppleAPNsCertBytes,
+		fleet.MDMConfigAssetAPNsKey:  &mdmCfg.AppleAPNsKeyBytes,
+		fleet.MDMConfigAssetSCEPCert: &mdmCfg.AppleSCEPCertBytes,
+		fleet.MDMConfigAssetSCEPKey:  &mdmCfg.AppleSCEPKeyBytes,
+		fleet.MDMConfigAssetABMCert:  &mdmCfg.AppleBMCertBytes,
+		fleet.MDMConfigAssetABMKey:   &mdmCfg.AppleBMKeyBytes,
+		fleet.MDMConfigAssetABMToken: &mdmCfg.AppleBMServerTokenBytes,
+	}
+	names := make([]fleet.MDMConfigAssetName, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+
+	assets, err := ds.GetMDMConfigAssetsByName(ctx, names)
+	if err != nil {
+		return err
+	}
+	for name, asset := range assets {
+		*fields[name] = string(asset.Value)
+	}
+	return nil
+}
+
 // The debugMux directs the request to either the fleet-authenticated handler,
 // which is the standard handler for debug endpoints (using a Fleet
 // authorization bearer token), or to the token-authenticated handler if a
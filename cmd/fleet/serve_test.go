@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
@@ -970,6 +971,77 @@ func TestCronActivitiesStreaming(t *testing.T) {
 	})
 }
 
+func TestCronSecurityAuditLogStreaming(t *testing.T) {
+	ds := new(mock.Store)
+
+	entries := []*fleet.SecurityAuditLogEntry{
+		{ID: 1, Action: fleet.SecurityAuditActionReadHostDiskEncryptionKey, HostID: 1, Hash: "h1"},
+		{ID: 2, Action: fleet.SecurityAuditActionReadHostUnlockPIN, HostID: 2, PrevHash: "h1", Hash: "h2"},
+		{ID: 3, Action: fleet.SecurityAuditActionReadHostUnlockPIN, HostID: 3, PrevHash: "h2", Hash: "h3"},
+	}
+
+	t.Run("basic", func(t *testing.T) {
+		ds.ListSecurityAuditLogToStreamFunc = func(ctx context.Context, limit uint) ([]*fleet.SecurityAuditLogEntry, error) {
+			require.Equal(t, SecurityAuditLogToStreamBatchCount, limit)
+			return entries, nil
+		}
+		ds.MarkSecurityAuditLogAsStreamedFunc = func(ctx context.Context, ids []uint) error {
+			require.Equal(t, []uint{1, 2, 3}, ids)
+			return nil
+		}
+
+		var logger jsonLogger
+		err := cronSecurityAuditLogStreaming(context.Background(), ds, log.NewNopLogger(), &logger)
+		require.NoError(t, err)
+		require.Len(t, logger.logs, 3)
+		for i, m := range logger.logs {
+			var e *fleet.SecurityAuditLogEntry
+			require.NoError(t, json.Unmarshal([]byte(m), &e))
+			require.Equal(t, entries[i], e)
+		}
+	})
+
+	t.Run("fail_to_stream_an_entry", func(t *testing.T) {
+		ds.ListSecurityAuditLogToStreamFunc = func(ctx context.Context, limit uint) ([]*fleet.SecurityAuditLogEntry, error) {
+			return entries, nil
+		}
+		ds.MarkSecurityAuditLogAsStreamedFunc = func(ctx context.Context, ids []uint) error {
+			require.Equal(t, []uint{1, 2}, ids)
+			return nil
+		}
+
+		logger := jsonLogger{failAfter: 2}
+		err := cronSecurityAuditLogStreaming(context.Background(), ds, log.NewNopLogger(), &logger)
+		require.ErrorIs(t, err, errStreamFailed)
+		require.Len(t, logger.logs, 2)
+	})
+
+	t.Run("bigger_than_batch", func(t *testing.T) {
+		all := make([]*fleet.SecurityAuditLogEntry, SecurityAuditLogToStreamBatchCount+1)
+		for i := range all {
+			all[i] = &fleet.SecurityAuditLogEntry{ID: uint(i + 1)}
+		}
+		var streamed int
+		ds.ListSecurityAuditLogToStreamFunc = func(ctx context.Context, limit uint) ([]*fleet.SecurityAuditLogEntry, error) {
+			rest := all[streamed:]
+			if len(rest) > int(limit) {
+				rest = rest[:limit]
+			}
+			return rest, nil
+		}
+		ds.MarkSecurityAuditLogAsStreamedFunc = func(ctx context.Context, ids []uint) error {
+			streamed += len(ids)
+			return nil
+		}
+
+		var logger jsonLogger
+		err := cronSecurityAuditLogStreaming(context.Background(), ds, log.NewNopLogger(), &logger)
+		require.NoError(t, err)
+		require.Len(t, logger.logs, len(all))
+		require.Equal(t, len(all), streamed)
+	})
+}
+
 var errStreamFailed = errors.New("streaming failed")
 
 type jsonLogger struct {
@@ -1069,3 +1141,56 @@ func TestVerifyDiskEncryptionKeysJob(t *testing.T) {
 		require.Equal(t, 2, calls)
 	})
 }
+
+func TestLoadMDMConfigAssets(t *testing.T) {
+	ctx := context.Background()
+	ds := new(mock.Store)
+
+	ds.GetMDMConfigAssetsByNameFunc = func(ctx context.Context, names []fleet.MDMConfigAssetName) (map[fleet.MDMConfigAssetName]fleet.MDMConfigAsset, error) {
+		require.Len(t, names, 7)
+		return nil, nil
+	}
+	var cfg config.MDMConfig
+	require.NoError(t, loadMDMConfigAssets(ctx, ds, &cfg))
+	require.False(t, cfg.IsAppleAPNsSet())
+	require.False(t, cfg.IsAppleSCEPSet())
+	require.False(t, cfg.IsAppleBMSet())
+
+	ds.GetMDMConfigAssetsByNameFunc = func(ctx context.Context, names []fleet.MDMConfigAssetName) (map[fleet.MDMConfigAssetName]fleet.MDMConfigAsset, error) {
+		return map[fleet.MDMConfigAssetName]fleet.MDMConfigAsset{
+			fleet.MDMConfigAssetAPNsCert: {Name: fleet.MDMConfigAssetAPNsCert, Value: []byte("apns cert")},
+			fleet.MDMConfigAssetAPNsKey:  {Name: fleet.MDMConfigAssetAPNsKey, Value: []byte("apns key")},
+			fleet.MDMConfigAssetSCEPCert: {Name: fleet.MDMConfigAssetSCEPCert, Value: []byte("scep cert")},
+			fleet.MDMConfigAssetSCEPKey:  {Name: fleet.MDMConfigAssetSCEPKey, Value: []byte("scep key")},
+		}, nil
+	}
+	require.NoError(t, loadMDMConfigAssets(ctx, ds, &cfg))
+	require.Equal(t, "apns cert", cfg.AppleAPNsCertBytes)
+	require.Equal(t, "apns key", cfg.AppleAPNsKeyBytes)
+	require.Equal(t, "scep cert", cfg.AppleSCEPCertBytes)
+	require.Equal(t, "scep key", cfg.AppleSCEPKeyBytes)
+	require.False(t, cfg.IsAppleBMSet())
+
+	ds.GetMDMConfigAssetsByNameFunc = func(ctx context.Context, names []fleet.MDMConfigAssetName) (map[fleet.MDMConfigAssetName]fleet.MDMConfigAsset, error) {
+		return nil, errors.New("decrypt failed")
+	}
+	require.ErrorContains(t, loadMDMConfigAssets(ctx, ds, &config.MDMConfig{}), "decrypt failed")
+}
+
+func TestRequestClientCertForServerName(t *testing.T) {
+	cfg := getTLSConfig(config.TLSProfileIntermediate)
+	getConfig := requestClientCertForServerName(cfg, "mdm.example.com")
+
+	got, err := getConfig(&tls.ClientHelloInfo{ServerName: "MDM.example.com"})
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	require.Equal(t, tls.RequestClientCert, got.ClientAuth)
+	require.Equal(t, cfg.MinVersion, got.MinVersion)
+
+	// the other connections use the default configuration, without client
+	// certificate
+	got, err = getConfig(&tls.ClientHelloInfo{ServerName: "fleet.example.com"})
+	require.NoError(t, err)
+	require.Nil(t, got)
+	require.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+}
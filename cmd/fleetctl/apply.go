@@ -13,9 +13,10 @@ import (
 
 func applyCommand() *cli.Command {
 	var (
-		flFilename string
-		flForce    bool
-		flDryRun   bool
+		flFilename        string
+		flForce           bool
+		flDryRun          bool
+		flDeleteUnmanaged bool
 	)
 	return &cli.Command{
 		Name:      "apply",
@@ -41,6 +42,12 @@ func applyCommand() *cli.Command {
 				Destination: &flDryRun,
 				Usage:       "Do not apply the file, just validate it (only supported for 'config' and 'team' specs)",
 			},
+			&cli.BoolFlag{
+				Name:        "delete-unmanaged",
+				EnvVars:     []string{"DELETE_UNMANAGED"},
+				Destination: &flDeleteUnmanaged,
+				Usage:       "Delete the MDM assets (profiles and macOS setup assistants) of the teams in the file that are not in the file. The assets to delete are always listed first, use with --dry-run to review them",
+			},
 			&cli.StringFlag{
 				Name:  "policies-team",
 				Usage: "A team's name, this flag is only used on policies specs (overrides 'team' key in the policies file). This allows to easily import a group of policies to a team.",
@@ -70,8 +77,9 @@ func applyCommand() *cli.Command {
 			}
 
 			opts := fleet.ApplySpecOptions{
-				Force:  flForce,
-				DryRun: flDryRun,
+				Force:           flForce,
+				DryRun:          flDryRun,
+				DeleteUnmanaged: flDeleteUnmanaged,
 			}
 			if policiesTeamName := c.String("policies-team"); policiesTeamName != "" {
 				opts.TeamForPolicies = policiesTeamName
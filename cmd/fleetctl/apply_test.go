@@ -783,9 +783,29 @@ spec:
 	ds.BatchSetMDMAppleProfilesFunc = func(ctx context.Context, teamID *uint, profiles []*fleet.MDMAppleConfigProfile) error {
 		return nil
 	}
+	ds.ListMDMAppleConfigProfilesFunc = func(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleConfigProfile, error) {
+		return nil, nil
+	}
 	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, hostUUIDs []string) error {
 		return nil
 	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, limit int) (int, error) {
+		return 0, nil
+	}
+	ds.ListMDMAppleProfileIdentifierConflictsFunc = func(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+		return nil, nil
+	}
+	var savedExclusions []*fleet.MDMAppleProfileExclusion
+	ds.BatchSetMDMAppleProfileExclusionsFunc = func(ctx context.Context, teamID *uint, exclusions []*fleet.MDMAppleProfileExclusion) error {
+		savedExclusions = exclusions
+		return nil
+	}
+	ds.LabelIDsByNameFunc = func(ctx context.Context, names []string) ([]uint, error) {
+		if len(names) == 1 && names[0] == "Kiosks" {
+			return []uint{5}, nil
+		}
+		return nil, nil
+	}
 
 	mobileConfig := mobileconfigForTest("foo", "bar")
 	mobileConfigPath := filepath.Join(t.TempDir(), "foo.mobileconfig")
@@ -808,17 +828,35 @@ spec:
         deadline: 1992-03-01
       macos_settings:
         custom_settings:
-          - %s
+          - %[1]s
+        custom_settings_exclusions:
+          - profile: %[1]s
+            labels:
+              - Kiosks
         enable_disk_encryption: false
     secrets:
       - secret: BBB
 `, mobileConfigPath))
 
+	ds.GetMDMAppleProfilesPreviewFunc = func(ctx context.Context, teamID *uint, profiles []*fleet.MDMAppleConfigProfile) (*fleet.MDMAppleProfilesPreview, error) {
+		return &fleet.MDMAppleProfilesPreview{TeamID: teamID, EnrolledHosts: 3, InstallHosts: 2, RemoveHosts: 1}, nil
+	}
+	require.Equal(t, "[+] would've applied 1 custom settings for team \"Team1\": 2 of 3 enrolled hosts would install profiles, 1 would remove profiles\n[+] would've applied 1 custom settings exclusions for team \"Team1\"\n[+] would've applied 1 teams\n",
+		runAppForTest(t, []string{"apply", "--dry-run", "-f", name}))
+	assert.True(t, ds.GetMDMAppleProfilesPreviewFuncInvoked)
+	assert.False(t, ds.BatchSetMDMAppleProfilesFuncInvoked)
+	assert.False(t, ds.BatchSetMDMAppleProfileExclusionsFuncInvoked)
+	assert.True(t, ds.LabelIDsByNameFuncInvoked)
+	assert.Nil(t, savedTeam)
+
 	require.Equal(t, "[+] applied 1 teams\n", runAppForTest(t, []string{"apply", "-f", name}))
 	assert.JSONEq(t, string(json.RawMessage(`{"config":{"views":{"foo":"qux"}}}`)), string(*savedTeam.Config.AgentOptions))
 	assert.Equal(t, fleet.TeamMDM{
 		MacOSSettings: fleet.MacOSSettings{
-			CustomSettings:       []string{mobileConfigPath},
+			CustomSettings: []string{mobileConfigPath},
+			CustomSettingsExclusions: []fleet.MacOSCustomSettingsExclusion{
+				{Profile: mobileConfigPath, Labels: []string{"Kiosks"}},
+			},
 			EnableDiskEncryption: false,
 		},
 		MacOSUpdates: fleet.MacOSUpdates{
@@ -826,6 +864,7 @@ spec:
 			Deadline:       "1992-03-01",
 		},
 	}, savedTeam.Config.MDM)
+	assert.Equal(t, []*fleet.MDMAppleProfileExclusion{{ProfileIdentifier: "bar", LabelID: ptr.Uint(5)}}, savedExclusions)
 	assert.Equal(t, []*fleet.EnrollSecret{{Secret: "BBB"}}, teamEnrollSecrets)
 	assert.True(t, ds.ApplyEnrollSecretsFuncInvoked)
 	assert.True(t, ds.BatchSetMDMAppleProfilesFuncInvoked)
@@ -1711,6 +1750,115 @@ spec:
 		assert.Equal(t, "", mockStore.appConfig.MDM.MacOSSetup.BootstrapPackage.Value)
 		mockStore.Unlock()
 	})
+
+	t.Run("eula", func(t *testing.T) {
+		const (
+			eulaAppConfigSpec = `
+apiVersion: v1
+kind: config
+spec:
+  mdm:
+    macos_setup:
+      eula: %s
+`
+			eulaTeamSpec = `
+apiVersion: v1
+kind: team
+spec:
+  team:
+    name: tm1
+    mdm:
+      macos_setup:
+        eula: %s
+`
+		)
+		pdfBytes := []byte("%PDF-1.7\nsome content")
+		pdfHash := sha256.Sum256(pdfBytes)
+
+		ds := setupServer(t, true)
+		var current *fleet.MDMAppleEULA
+		ds.MDMAppleGetEULAMetadataFunc = func(ctx context.Context) (*fleet.MDMAppleEULA, error) {
+			mockStore.Lock()
+			defer mockStore.Unlock()
+			if current == nil {
+				return nil, &notFoundError{}
+			}
+			return current, nil
+		}
+		ds.MDMAppleInsertEULAFunc = func(ctx context.Context, eula *fleet.MDMAppleEULA) error {
+			mockStore.Lock()
+			defer mockStore.Unlock()
+			require.Equal(t, "eula.pdf", eula.Name)
+			require.Equal(t, pdfBytes, eula.Bytes)
+			require.Equal(t, pdfHash[:], eula.Sha256)
+			current = eula
+			return nil
+		}
+		ds.MDMAppleDeleteEULAFunc = func(ctx context.Context, token string) error {
+			mockStore.Lock()
+			defer mockStore.Unlock()
+			require.NotNil(t, current)
+			require.Equal(t, current.Token, token)
+			current = nil
+			return nil
+		}
+		resetInvoked := func() {
+			ds.MDMAppleGetEULAMetadataFuncInvoked = false
+			ds.MDMAppleInsertEULAFuncInvoked = false
+			ds.MDMAppleDeleteEULAFuncInvoked = false
+			ds.SaveAppConfigFuncInvoked = false
+		}
+
+		// a file that is not a PDF is rejected
+		tmpFilename := writeTmpYml(t, fmt.Sprintf(eulaAppConfigSpec, "eula.pdf"))
+		require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(tmpFilename), "eula.pdf"), []byte("not a pdf"), 0o644))
+		runAppCheckErr(t, []string{"apply", "-f", tmpFilename}, "applying fleet config: Couldn’t edit eula. The file must be a PDF (.pdf).")
+		assert.False(t, ds.MDMAppleInsertEULAFuncInvoked)
+		assert.False(t, ds.SaveAppConfigFuncInvoked)
+
+		// the EULA path is relative to the spec file
+		require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(tmpFilename), "eula.pdf"), pdfBytes, 0o644))
+		assert.Equal(t, "[+] applied fleet config\n", runAppForTest(t, []string{"apply", "-f", tmpFilename}))
+		assert.True(t, ds.MDMAppleGetEULAMetadataFuncInvoked)
+		assert.True(t, ds.MDMAppleInsertEULAFuncInvoked)
+		assert.False(t, ds.MDMAppleDeleteEULAFuncInvoked)
+		assert.True(t, ds.SaveAppConfigFuncInvoked)
+		mockStore.Lock()
+		assert.Equal(t, "eula.pdf", mockStore.appConfig.MDM.MacOSSetup.EULA.Value)
+		mockStore.Unlock()
+		resetInvoked()
+
+		// running again should not re-upload
+		assert.Equal(t, "[+] applied fleet config\n", runAppForTest(t, []string{"apply", "-f", tmpFilename}))
+		assert.True(t, ds.MDMAppleGetEULAMetadataFuncInvoked)
+		assert.False(t, ds.MDMAppleInsertEULAFuncInvoked)
+		assert.False(t, ds.MDMAppleDeleteEULAFuncInvoked)
+		resetInvoked()
+
+		// a changed EULA replaces the existing one
+		pdfBytes = []byte("%PDF-1.7\nupdated content")
+		pdfHash = sha256.Sum256(pdfBytes)
+		require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(tmpFilename), "eula.pdf"), pdfBytes, 0o644))
+		assert.Equal(t, "[+] applied fleet config\n", runAppForTest(t, []string{"apply", "-f", tmpFilename}))
+		assert.True(t, ds.MDMAppleInsertEULAFuncInvoked)
+		assert.True(t, ds.MDMAppleDeleteEULAFuncInvoked)
+		resetInvoked()
+
+		// the EULA cannot be set for a team
+		tmpFilename = writeTmpYml(t, fmt.Sprintf(eulaTeamSpec, "eula.pdf"))
+		runAppCheckErr(t, []string{"apply", "-f", tmpFilename}, "applying teams: POST /api/latest/fleet/spec/teams received status 422 Validation Failed: Couldn't update macos_setup.eula. The EULA can only be set in the global configuration, not per team.")
+
+		// an empty value deletes the EULA
+		tmpFilename = writeTmpYml(t, fmt.Sprintf(eulaAppConfigSpec, `""`))
+		assert.Equal(t, "[+] applied fleet config\n", runAppForTest(t, []string{"apply", "-f", tmpFilename}))
+		assert.False(t, ds.MDMAppleInsertEULAFuncInvoked)
+		assert.True(t, ds.MDMAppleDeleteEULAFuncInvoked)
+		assert.True(t, ds.SaveAppConfigFuncInvoked)
+		mockStore.Lock()
+		assert.Equal(t, "", mockStore.appConfig.MDM.MacOSSetup.EULA.Value)
+		assert.Nil(t, current)
+		mockStore.Unlock()
+	})
 }
 
 func TestApplySpecs(t *testing.T) {
@@ -2255,6 +2403,83 @@ spec:
 `,
 			wantErr: `422 Validation Failed: deadline accepts YYYY-MM-DD format only (E.g., "2023-06-01.")`,
 		},
+		{
+			desc: "macos_migration valid in dry-run",
+			spec: `
+apiVersion: v1
+kind: team
+spec:
+  team:
+    name: team1
+    mdm:
+      macos_migration:
+        enable: false
+        mode: forced
+        webhook_url: https://example.com/migration
+        end_user_message: Your device is migrating to Fleet.
+`,
+			flags:      []string{"--dry-run"},
+			wantOutput: `[+] would've applied 1 teams`,
+		},
+		{
+			desc: "macos_migration with invalid mode",
+			spec: `
+apiVersion: v1
+kind: team
+spec:
+  team:
+    name: team1
+    mdm:
+      macos_migration:
+        mode: later
+`,
+			flags:   []string{"--dry-run"},
+			wantErr: `422 Validation Failed: invalid mode "later", must be "voluntary" or "forced"`,
+		},
+		{
+			desc: "macos_migration enabled without mode",
+			spec: `
+apiVersion: v1
+kind: team
+spec:
+  team:
+    name: team1
+    mdm:
+      macos_migration:
+        enable: true
+`,
+			wantErr: `422 Validation Failed: mode is required when the migration is enabled`,
+		},
+		{
+			desc: "macos_migration with invalid webhook_url",
+			spec: `
+apiVersion: v1
+kind: team
+spec:
+  team:
+    name: team1
+    mdm:
+      macos_migration:
+        mode: voluntary
+        webhook_url: example.com/migration
+`,
+			wantErr: `422 Validation Failed: invalid webhook_url "example.com/migration", must be an http or https URL`,
+		},
+		{
+			desc: "macos_migration enabled without MDM",
+			spec: `
+apiVersion: v1
+kind: team
+spec:
+  team:
+    name: team1
+    mdm:
+      macos_migration:
+        enable: true
+        mode: voluntary
+`,
+			wantErr: `422 Validation Failed: Couldn't update macos_migration because MDM features aren't turned on in Fleet.`,
+		},
 		{
 			desc: "missing required sso entity_id",
 			spec: `
@@ -2618,3 +2843,125 @@ spec:
 		})
 	}
 }
+
+func TestApplyDeleteUnmanagedMDMAssets(t *testing.T) {
+	license := &fleet.LicenseInfo{Tier: fleet.TierPremium, Expiration: time.Now().Add(24 * time.Hour)}
+	_, ds := runServerWithMockedDS(t, &service.TestServerOpts{License: license})
+
+	appCfg := &fleet.AppConfig{
+		OrgInfo:        fleet.OrgInfo{OrgName: "Fleet"},
+		ServerSettings: fleet.ServerSettings{ServerURL: "https://example.org"},
+		MDM:            fleet.MDM{EnabledAndConfigured: true},
+	}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return appCfg, nil
+	}
+	ds.SaveAppConfigFunc = func(ctx context.Context, config *fleet.AppConfig) error {
+		appCfg = config
+		return nil
+	}
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		return nil
+	}
+
+	var profiles []*fleet.MDMAppleConfigProfile
+	resetProfiles := func() {
+		profiles = []*fleet.MDMAppleConfigProfile{
+			{ProfileID: 1, Name: "foo", Identifier: "bar"},
+			{ProfileID: 2, Name: "from the UI", Identifier: "ui"},
+		}
+	}
+	ds.ListMDMAppleConfigProfilesFunc = func(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleConfigProfile, error) {
+		return profiles, nil
+	}
+	ds.BatchSetMDMAppleProfilesFunc = func(ctx context.Context, teamID *uint, profs []*fleet.MDMAppleConfigProfile) error {
+		profiles = profs
+		return nil
+	}
+	ds.BatchSetMDMAppleProfileExclusionsFunc = func(ctx context.Context, teamID *uint, exclusions []*fleet.MDMAppleProfileExclusion) error {
+		return nil
+	}
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, hostUUIDs []string) error {
+		return nil
+	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, limit int) (int, error) {
+		return 0, nil
+	}
+	ds.ListMDMAppleProfileIdentifierConflictsFunc = func(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+		return nil, nil
+	}
+	ds.GetMDMAppleProfilesPreviewFunc = func(ctx context.Context, teamID *uint, profs []*fleet.MDMAppleConfigProfile) (*fleet.MDMAppleProfilesPreview, error) {
+		return &fleet.MDMAppleProfilesPreview{TeamID: teamID}, nil
+	}
+
+	var asst *fleet.MDMAppleSetupAssistant
+	ds.GetMDMAppleSetupAssistantFunc = func(ctx context.Context, teamID *uint) (*fleet.MDMAppleSetupAssistant, error) {
+		if asst == nil {
+			return nil, &notFoundError{}
+		}
+		return asst, nil
+	}
+	ds.DeleteMDMAppleSetupAssistantFunc = func(ctx context.Context, teamID *uint) error {
+		asst = nil
+		return nil
+	}
+
+	mobileConfigPath := filepath.Join(t.TempDir(), "foo.mobileconfig")
+	require.NoError(t, os.WriteFile(mobileConfigPath, mobileconfigForTest("foo", "bar"), 0o644))
+
+	withCustomSettings := writeTmpYml(t, fmt.Sprintf(`
+apiVersion: v1
+kind: config
+spec:
+  mdm:
+    macos_settings:
+      custom_settings:
+        - %s
+`, mobileConfigPath))
+	withoutCustomSettings := writeTmpYml(t, `
+apiVersion: v1
+kind: config
+spec:
+  org_info:
+    org_name: Fleet
+`)
+
+	// dry run lists the unmanaged assets without deleting them
+	resetProfiles()
+	asst = &fleet.MDMAppleSetupAssistant{Name: "ui-asst"}
+	out := runAppForTest(t, []string{"apply", "--dry-run", "--delete-unmanaged", "-f", withCustomSettings})
+	assert.Contains(t, out, `[!] would delete profile "from the UI" (ui) for no team, not in the specs`)
+	assert.Contains(t, out, `[!] would delete macOS setup assistant "ui-asst" for no team, not in the specs`)
+	assert.NotContains(t, out, `profile "foo"`)
+	assert.False(t, ds.BatchSetMDMAppleProfilesFuncInvoked)
+	assert.False(t, ds.DeleteMDMAppleSetupAssistantFuncInvoked)
+	require.NotNil(t, asst)
+
+	// the profile is removed by the custom settings, the setup assistant is deleted
+	out = runAppForTest(t, []string{"apply", "--delete-unmanaged", "-f", withCustomSettings})
+	assert.Contains(t, out, `[!] deleting profile "from the UI" (ui) for no team, not in the specs`)
+	assert.Contains(t, out, "[+] deleted unmanaged MDM assets\n")
+	assert.True(t, ds.DeleteMDMAppleSetupAssistantFuncInvoked)
+	require.Nil(t, asst)
+	require.Len(t, profiles, 1)
+	assert.Equal(t, "bar", profiles[0].Identifier)
+
+	// nothing left to delete
+	out = runAppForTest(t, []string{"apply", "--dry-run", "--delete-unmanaged", "-f", withCustomSettings})
+	assert.Contains(t, out, "[+] no unmanaged MDM assets to delete\n")
+
+	// without custom settings in the specs, all profiles are unmanaged
+	resetProfiles()
+	ds.BatchSetMDMAppleProfilesFuncInvoked = false
+	out = runAppForTest(t, []string{"apply", "--delete-unmanaged", "-f", withoutCustomSettings})
+	assert.Contains(t, out, `[!] deleting profile "foo" (bar) for no team, not in the specs`)
+	assert.Contains(t, out, `[!] deleting profile "from the UI" (ui) for no team, not in the specs`)
+	assert.True(t, ds.BatchSetMDMAppleProfilesFuncInvoked)
+	assert.Empty(t, profiles)
+
+	// without the flag, nothing is deleted
+	resetProfiles()
+	out = runAppForTest(t, []string{"apply", "-f", withoutCustomSettings})
+	assert.Equal(t, "[+] applied fleet config\n", out)
+	assert.Len(t, profiles, 2)
+}
@@ -1277,6 +1277,19 @@ func getMDMCommandResultsCommand() *cli.Command {
 				Usage:    "Filter MDM commands by ID.",
 				Required: true,
 			},
+			&cli.BoolFlag{
+				Name:  "follow",
+				Usage: "Wait until all targeted hosts have acknowledged or failed the command, printing updates as they respond. Exits with an error if the command failed on any host.",
+			},
+			&cli.DurationFlag{
+				Name:  "interval",
+				Usage: "How often to check for new results when --follow is set.",
+				Value: 5 * time.Second,
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "How long to wait for all hosts to respond when --follow is set, 0 means no timeout.",
+			},
 		},
 		Action: func(c *cli.Context) error {
 			client, err := clientFromCLI(c)
@@ -1289,24 +1302,25 @@ func getMDMCommandResultsCommand() *cli.Command {
 				return err
 			}
 
-			res, err := client.MDMAppleGetCommandResults(c.String("id"))
+			cmdUUID := c.String("id")
+			res, err := getMDMCommandResults(client, cmdUUID)
 			if err != nil {
-				var nfe service.NotFoundErr
-				if errors.As(err, &nfe) {
-					return errors.New("The command doesn't exist. Please provide a valid command ID. To see a list of commands that were run, run `fleetctl get mdm-commands`.")
-				}
+				return err
+			}
 
-				var sce kithttp.StatusCoder
-				if errors.As(err, &sce) {
-					if sce.StatusCode() == http.StatusForbidden {
-						return fmt.Errorf("Permission denied. You don't have permission to view the results of this MDM command for at least one of the hosts: %w", err)
-					}
+			if c.Bool("follow") {
+				if err := followMDMCommandResults(c, client, cmdUUID); err != nil {
+					return err
+				}
+				// reload the results now that all hosts have responded
+				if res, err = getMDMCommandResults(client, cmdUUID); err != nil {
+					return err
 				}
-				return err
 			}
 
 			// print the results as a table
 			data := [][]string{}
+			var failed int
 			for _, r := range res {
 				data = append(data, []string{
 					r.CommandUUID,
@@ -1316,15 +1330,85 @@ func getMDMCommandResultsCommand() *cli.Command {
 					r.Hostname,
 					string(r.Result),
 				})
+				if r.Status != fleet.MDMAppleStatusAcknowledged {
+					failed++
+				}
 			}
 			columns := []string{"ID", "TIME", "TYPE", "STATUS", "HOSTNAME", "RESULTS"}
 			printTable(c, columns, data)
 
+			if c.Bool("follow") {
+				fmt.Fprintf(c.App.Writer, "\n%d host(s) acknowledged the command, %d host(s) failed.\n", len(res)-failed, failed)
+				if failed > 0 {
+					return fmt.Errorf("The command failed on %d host(s).", failed)
+				}
+			}
+
 			return nil
 		},
 	}
 }
 
+func getMDMCommandResults(client *service.Client, cmdUUID string) ([]*fleet.MDMAppleCommandResult, error) {
+	res, err := client.MDMAppleGetCommandResults(cmdUUID)
+	if err != nil {
+		var nfe service.NotFoundErr
+		if errors.As(err, &nfe) {
+			return nil, errors.New("The command doesn't exist. Please provide a valid command ID. To see a list of commands that were run, run `fleetctl get mdm-commands`.")
+		}
+
+		var sce kithttp.StatusCoder
+		if errors.As(err, &sce) {
+			if sce.StatusCode() == http.StatusForbidden {
+				return nil, fmt.Errorf("Permission denied. You don't have permission to view the results of this MDM command for at least one of the hosts: %w", err)
+			}
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// followMDMCommandResults polls the status of the command on each targeted
+// host until all hosts have responded, printing a line each time a host
+// status changes.
+func followMDMCommandResults(c *cli.Context, client *service.Client, cmdUUID string) error {
+	var timeoutChan <-chan time.Time
+	if timeout := c.Duration("timeout"); timeout > 0 {
+		timeoutChan = time.After(timeout)
+	}
+	tick := time.NewTicker(c.Duration("interval"))
+	defer tick.Stop()
+
+	statuses := make(map[string]string)
+	for {
+		hosts, err := client.MDMAppleListCommandHosts(cmdUUID)
+		if err != nil {
+			return err
+		}
+
+		var pending int
+		for _, h := range hosts {
+			if statuses[h.DeviceID] != h.Status {
+				statuses[h.DeviceID] = h.Status
+				fmt.Fprintf(c.App.Writer, "%s %s: %s\n", h.UpdatedAt.Format(time.RFC3339), h.Hostname, h.Status)
+			}
+			if !fleet.MDMAppleCommandStatusIsFinal(h.Status) {
+				pending++
+			}
+		}
+		if pending == 0 {
+			fmt.Fprintln(c.App.Writer)
+			return nil
+		}
+
+		select {
+		case <-tick.C:
+		case <-timeoutChan:
+			return fmt.Errorf("Stopped by timeout, %d host(s) didn't respond to the command yet.", pending)
+		}
+	}
+}
+
 func getMDMCommandsCommand() *cli.Command {
 	return &cli.Command{
 		Name:    "mdm-commands",
@@ -313,6 +313,9 @@ func TestGetHosts(t *testing.T) {
 	ds.ListHostBatteriesFunc = func(ctx context.Context, hid uint) (batteries []*fleet.HostBattery, err error) {
 		return nil, nil
 	}
+	ds.GetHostAssignedUserFunc = func(ctx context.Context, hostID uint) (*fleet.HostAssignedUser, error) {
+		return nil, &notFoundError{}
+	}
 	defaultPolicyQuery := "select 1 from osquery_info where start_time > 1;"
 	ds.ListPoliciesForHostFunc = func(ctx context.Context, host *fleet.Host) ([]*fleet.HostPolicy, error) {
 		return []*fleet.HostPolicy{
@@ -493,6 +496,9 @@ func TestGetHostsMDM(t *testing.T) {
 	ds.ListHostBatteriesFunc = func(ctx context.Context, hid uint) (batteries []*fleet.HostBattery, err error) {
 		return nil, nil
 	}
+	ds.GetHostAssignedUserFunc = func(ctx context.Context, hostID uint) (*fleet.HostAssignedUser, error) {
+		return nil, &notFoundError{}
+	}
 	ds.ListPoliciesForHostFunc = func(ctx context.Context, host *fleet.Host) ([]*fleet.HostPolicy, error) {
 		return nil, nil
 	}
@@ -1670,9 +1676,21 @@ func TestGetTeamsYAMLAndApply(t *testing.T) {
 	ds.BatchSetMDMAppleProfilesFunc = func(ctx context.Context, teamID *uint, profiles []*fleet.MDMAppleConfigProfile) error {
 		return nil
 	}
+	ds.BatchSetMDMAppleProfileExclusionsFunc = func(ctx context.Context, teamID *uint, exclusions []*fleet.MDMAppleProfileExclusion) error {
+		return nil
+	}
+	ds.ListMDMAppleConfigProfilesFunc = func(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleConfigProfile, error) {
+		return nil, nil
+	}
 	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, uuids []string) error {
 		return nil
 	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, limit int) (int, error) {
+		return 0, nil
+	}
+	ds.ListMDMAppleProfileIdentifierConflictsFunc = func(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+		return nil, nil
+	}
 
 	actualYaml := runAppForTest(t, []string{"get", "teams", "--yaml"})
 	yamlFilePath := writeTmpYml(t, actualYaml)
@@ -1797,6 +1815,40 @@ func TestGetMDMCommandResults(t *testing.T) {
 |           |                      |      |              |          | </plist>                                          |
 +-----------+----------------------+------+--------------+----------+---------------------------------------------------+
 `))
+
+	// follow mode polls until all hosts responded
+	var listCalls int
+	ds.ListMDMAppleCommandsFunc = func(ctx context.Context, tmFilter fleet.TeamFilter, listOpts *fleet.MDMAppleCommandListOptions) ([]*fleet.MDMAppleCommand, error) {
+		require.Equal(t, "valid-cmd", listOpts.CommandUUID)
+		listCalls++
+		status := "Pending"
+		if listCalls > 1 {
+			status = "Error"
+		}
+		return []*fleet.MDMAppleCommand{
+			{DeviceID: "device1", CommandUUID: "valid-cmd", UpdatedAt: time.Date(2023, 4, 4, 15, 29, 0, 0, time.UTC), Status: "Acknowledged", Hostname: "host1"},
+			{DeviceID: "device2", CommandUUID: "valid-cmd", UpdatedAt: time.Date(2023, 4, 4, 15, 29, 0, 0, time.UTC), Status: status, Hostname: "host2"},
+		}, nil
+	}
+	buf, err = runAppNoChecks([]string{"get", "mdm-command-results", "--id", "valid-cmd", "--follow", "--interval", "10ms"})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "The command failed on 1 host(s).")
+	require.Equal(t, 2, listCalls)
+	require.Contains(t, buf.String(), `2023-04-04T15:29:00Z host1: Acknowledged
+2023-04-04T15:29:00Z host2: Pending
+2023-04-04T15:29:00Z host2: Error
+`)
+	require.Contains(t, buf.String(), "1 host(s) acknowledged the command, 1 host(s) failed.")
+
+	// follow mode times out if some hosts don't respond
+	ds.ListMDMAppleCommandsFunc = func(ctx context.Context, tmFilter fleet.TeamFilter, listOpts *fleet.MDMAppleCommandListOptions) ([]*fleet.MDMAppleCommand, error) {
+		return []*fleet.MDMAppleCommand{
+			{DeviceID: "device1", CommandUUID: "valid-cmd", UpdatedAt: time.Date(2023, 4, 4, 15, 29, 0, 0, time.UTC), Status: "NotNow", Hostname: "host1"},
+		}, nil
+	}
+	_, err = runAppNoChecks([]string{"get", "mdm-command-results", "--id", "valid-cmd", "--follow", "--interval", "10ms", "--timeout", "50ms"})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "Stopped by timeout, 1 host(s) didn't respond to the command yet.")
 }
 
 func TestGetMDMCommands(t *testing.T) {
@@ -45,8 +45,22 @@ func TestHostsTransferByHosts(t *testing.T) {
 	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, uuids []string) error {
 		return nil
 	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, limit int) (int, error) {
+		return 0, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: tid, Name: "team1"}, nil
+	}
+	var transferred []uint
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		if act, ok := activity.(*fleet.ActivityTypeTransferredHostsToTeam); ok {
+			transferred = append(transferred, act.HostIDs...)
+		}
+		return nil
+	}
 
 	assert.Equal(t, "", runAppForTest(t, []string{"hosts", "transfer", "--team", "team1", "--hosts", "host1"}))
+	assert.Equal(t, []uint{42}, transferred)
 }
 
 func TestHostsTransferByLabel(t *testing.T) {
@@ -83,8 +97,22 @@ func TestHostsTransferByLabel(t *testing.T) {
 	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, uuids []string) error {
 		return nil
 	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, limit int) (int, error) {
+		return 0, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: tid, Name: "team1"}, nil
+	}
+	var transferred []uint
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		if act, ok := activity.(*fleet.ActivityTypeTransferredHostsToTeam); ok {
+			transferred = append(transferred, act.HostIDs...)
+		}
+		return nil
+	}
 
 	assert.Equal(t, "", runAppForTest(t, []string{"hosts", "transfer", "--team", "team1", "--label", "label1"}))
+	assert.Equal(t, []uint{32, 12}, transferred)
 }
 
 func TestHostsTransferByStatus(t *testing.T) {
@@ -120,9 +148,23 @@ func TestHostsTransferByStatus(t *testing.T) {
 	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, uuids []string) error {
 		return nil
 	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, limit int) (int, error) {
+		return 0, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: tid, Name: "team1"}, nil
+	}
+	var transferred []uint
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		if act, ok := activity.(*fleet.ActivityTypeTransferredHostsToTeam); ok {
+			transferred = append(transferred, act.HostIDs...)
+		}
+		return nil
+	}
 
 	assert.Equal(t, "", runAppForTest(t,
 		[]string{"hosts", "transfer", "--team", "team1", "--status", "online"}))
+	assert.Equal(t, []uint{32, 12}, transferred)
 }
 
 func TestHostsTransferByStatusAndSearchQuery(t *testing.T) {
@@ -159,7 +201,21 @@ func TestHostsTransferByStatusAndSearchQuery(t *testing.T) {
 	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, uuids []string) error {
 		return nil
 	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, limit int) (int, error) {
+		return 0, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: tid, Name: "team1"}, nil
+	}
+	var transferred []uint
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		if act, ok := activity.(*fleet.ActivityTypeTransferredHostsToTeam); ok {
+			transferred = append(transferred, act.HostIDs...)
+		}
+		return nil
+	}
 
 	assert.Equal(t, "", runAppForTest(t,
 		[]string{"hosts", "transfer", "--team", "team1", "--status", "online", "--search_query", "somequery"}))
+	assert.Equal(t, []uint{32, 12}, transferred)
 }
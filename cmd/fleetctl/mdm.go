@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/service"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/urfave/cli/v2"
@@ -24,6 +26,15 @@ func mdmCommand() *cli.Command {
 		},
 		Subcommands: []*cli.Command{
 			mdmRunCommand(),
+			mdmDownloadEnrollmentProfileCommand(),
+			mdmRotateEnrollmentTokenCommand(),
+			mdmAssignDEPTeamsCommand(),
+			mdmExportCommand(),
+			mdmImportCommand(),
+			mdmLockCommand(),
+			mdmWipeCommand(),
+			mdmUnlockCommand(),
+			mdmDoctorCommand(),
 		},
 	}
 }
@@ -44,8 +55,18 @@ func mdmRunCommand() *cli.Command {
 				Usage:    "A path to an XML file containing the raw MDM request payload.",
 				Required: true,
 			},
+			&cli.StringFlag{
+				Name:  "priority",
+				Usage: "The priority of the command in the host's queue, one of urgent, normal or low. Urgent commands are delivered before any other queued command.",
+				Value: string(fleet.MDMAppleCommandPriorityNormal),
+			},
 		},
 		Action: func(c *cli.Context) error {
+			priority := fleet.MDMAppleCommandPriority(c.String("priority"))
+			if !priority.IsValid() {
+				return fmt.Errorf("Invalid --priority value %q, must be one of urgent, normal or low.", priority)
+			}
+
 			client, err := clientFromCLI(c)
 			if err != nil {
 				return fmt.Errorf("create client: %w", err)
@@ -85,7 +106,7 @@ func mdmRunCommand() *cli.Command {
 				return errors.New("Can't run the MDM command because the host doesn't have MDM turned on. Run the following command to see a list of hosts with MDM on: fleetctl get hosts --mdm")
 			}
 
-			result, err := client.EnqueueCommand([]string{host.UUID}, payload)
+			result, err := client.EnqueueCommand([]string{host.UUID}, payload, priority)
 			if err != nil {
 				var sce kithttp.StatusCoder
 				if errors.As(err, &sce) {
@@ -111,3 +132,121 @@ fleetctl get mdm-command-results --id=%v
 		},
 	}
 }
+
+func mdmDownloadEnrollmentProfileCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "download-enrollment-profile",
+		Usage: "Download the reusable enrollment profile of a team, e.g. to include it in a golden image. The macOS hosts that enroll with it are assigned to the team.",
+		Flags: []cli.Flag{
+			&cli.UintFlag{
+				Name:  "team",
+				Usage: "The ID of the team of the enrollment profile. Defaults to no team.",
+			},
+			&cli.StringFlag{
+				Name:     "output",
+				Usage:    "The path of the file to write the enrollment profile to.",
+				Required: true,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			client, err := clientFromCLI(c)
+			if err != nil {
+				return fmt.Errorf("create client: %w", err)
+			}
+
+			// print an error if MDM is not configured
+			if err := client.CheckMDMEnabled(); err != nil {
+				return err
+			}
+
+			var teamID *uint
+			if c.IsSet("team") {
+				teamID = ptr.Uint(c.Uint("team"))
+			}
+			profile, err := client.MDMAppleGetTeamEnrollmentProfile(teamID)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(c.String("output"), profile, 0o644); err != nil {
+				return fmt.Errorf("write enrollment profile: %w", err)
+			}
+
+			fmt.Fprintf(c.App.Writer, "The enrollment profile was written to %s.\n", c.String("output"))
+			return nil
+		},
+	}
+}
+
+func mdmRotateEnrollmentTokenCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "rotate-enrollment-token",
+		Usage: "Rotate the token of the reusable enrollment profile of a team. The hosts that enroll with a previously downloaded profile are no longer assigned to the team.",
+		Flags: []cli.Flag{
+			&cli.UintFlag{
+				Name:  "team",
+				Usage: "The ID of the team of the enrollment profile. Defaults to no team.",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			client, err := clientFromCLI(c)
+			if err != nil {
+				return fmt.Errorf("create client: %w", err)
+			}
+
+			// print an error if MDM is not configured
+			if err := client.CheckMDMEnabled(); err != nil {
+				return err
+			}
+
+			var teamID *uint
+			if c.IsSet("team") {
+				teamID = ptr.Uint(c.Uint("team"))
+			}
+			if err := client.MDMAppleRotateTeamEnrollmentToken(teamID); err != nil {
+				return err
+			}
+
+			fmt.Fprintln(c.App.Writer, "The enrollment token was rotated. Download the enrollment profile again to use the new token.")
+			return nil
+		},
+	}
+}
+
+func mdmAssignDEPTeamsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "assign-dep-teams",
+		Usage: "Assign devices to teams by serial number before they are synced from Apple Business Manager. The CSV file contains one \"serial_number,team\" line per device, an empty team removes the assignment.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "csv",
+				Usage:    "The path to the CSV file of serial numbers and team names.",
+				Required: true,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			client, err := clientFromCLI(c)
+			if err != nil {
+				return fmt.Errorf("create client: %w", err)
+			}
+
+			// print an error if MDM is not configured
+			if err := client.CheckMDMEnabled(); err != nil {
+				return err
+			}
+
+			csvFile := c.String("csv")
+			b, err := os.ReadFile(csvFile)
+			if err != nil {
+				return fmt.Errorf("read CSV file: %w", err)
+			}
+
+			res, err := client.MDMAppleUploadDEPTeamAssignments(filepath.Base(csvFile), b)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(c.App.Writer, "%d device(s) assigned to a team, %d assignment(s) removed.\n", res.Assigned, res.Removed)
+			return nil
+		},
+	}
+}
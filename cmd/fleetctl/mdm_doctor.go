@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/service"
+	"github.com/urfave/cli/v2"
+)
+
+// mdmDoctorExpirationWarning is how long before the expiration of the APNs
+// certificate and ABM token the doctor starts warning about it.
+const mdmDoctorExpirationWarning = 30 * 24 * time.Hour
+
+type mdmDoctorStatus string
+
+const (
+	mdmDoctorPass mdmDoctorStatus = "PASS"
+	mdmDoctorWarn mdmDoctorStatus = "WARN"
+	mdmDoctorFail mdmDoctorStatus = "FAIL"
+	mdmDoctorSkip mdmDoctorStatus = "SKIP"
+)
+
+// mdmDoctorCheck is the result of one of the checks of `fleetctl mdm doctor`.
+type mdmDoctorCheck struct {
+	Name    string
+	Status  mdmDoctorStatus
+	Message string
+	// Hint is the remediation suggested when the check doesn't pass.
+	Hint string
+}
+
+// mdmDoctorClient is the subset of the fleet client used by the doctor
+// checks.
+type mdmDoctorClient interface {
+	GetAppConfig() (*fleet.EnrichedAppConfig, error)
+	GetAppleMDM() (*fleet.AppleMDM, error)
+	GetAppleBM() (*fleet.AppleBM, error)
+	MDMAppleCheckSCEP() error
+	MDMAppleListDEPDevices() ([]fleet.MDMAppleDEPDevice, error)
+	MDMAppleListProfilesSummaryByTeam() ([]*fleet.MDMAppleConfigProfilesTeamSummary, error)
+}
+
+func mdmDoctorCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "doctor",
+		Usage: "Run a series of checks of the MDM configuration of the Fleet server (APNs certificate, SCEP service, Apple Business Manager, automatic enrollment profiles and configuration profiles) and print remediation hints for the problems found.",
+		Action: func(c *cli.Context) error {
+			client, err := clientFromCLI(c)
+			if err != nil {
+				return fmt.Errorf("create client: %w", err)
+			}
+
+			checks := runMDMDoctorChecks(client, time.Now())
+			var failed int
+			for _, check := range checks {
+				printMDMDoctorCheck(c.App.Writer, check)
+				if check.Status == mdmDoctorFail {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d MDM check(s) failed", failed)
+			}
+			return nil
+		},
+	}
+}
+
+func printMDMDoctorCheck(w io.Writer, check mdmDoctorCheck) {
+	var attr color.Attribute
+	switch check.Status {
+	case mdmDoctorPass:
+		attr = color.FgGreen
+	case mdmDoctorWarn:
+		attr = color.FgYellow
+	case mdmDoctorFail:
+		attr = color.FgRed
+	default:
+		attr = color.FgHiBlack
+	}
+	color.New(attr).Fprintf(w, "[%s]", check.Status)
+	fmt.Fprintf(w, " %s: %s\n", check.Name, check.Message)
+	if check.Hint != "" && check.Status != mdmDoctorPass {
+		fmt.Fprintf(w, "       %s\n", check.Hint)
+	}
+}
+
+// runMDMDoctorChecks runs the checks in order. The checks that depend on a
+// failed check are skipped.
+func runMDMDoctorChecks(client mdmDoctorClient, now time.Time) []mdmDoctorCheck {
+	appCfg, err := client.GetAppConfig()
+	if err != nil {
+		return []mdmDoctorCheck{{
+			Name:    "MDM",
+			Status:  mdmDoctorFail,
+			Message: fmt.Sprintf("could not get the server configuration: %s", err),
+			Hint:    "Make sure fleetctl is logged in to the server as a global admin.",
+		}}
+	}
+	if !appCfg.MDM.EnabledAndConfigured {
+		return []mdmDoctorCheck{{
+			Name:    "MDM",
+			Status:  mdmDoctorFail,
+			Message: "MDM features aren't turned on.",
+			Hint:    "Use `fleetctl generate mdm-apple` and then `fleet serve` with `mdm` configuration to turn on MDM features.",
+		}}
+	}
+
+	checks := []mdmDoctorCheck{
+		checkMDMDoctorAPNs(client, now),
+		checkMDMDoctorSCEP(client),
+	}
+
+	abmCheck := checkMDMDoctorABM(client, appCfg, now)
+	checks = append(checks, abmCheck)
+	if abmCheck.Status == mdmDoctorPass || abmCheck.Status == mdmDoctorWarn {
+		checks = append(checks, checkMDMDoctorDEPProfiles(client))
+	} else {
+		checks = append(checks, mdmDoctorCheck{
+			Name:    "Automatic enrollment profiles",
+			Status:  mdmDoctorSkip,
+			Message: "Apple Business Manager is not available.",
+		})
+	}
+
+	checks = append(checks, checkMDMDoctorProfiles(client))
+	return checks
+}
+
+func checkMDMDoctorAPNs(client mdmDoctorClient, now time.Time) mdmDoctorCheck {
+	check := mdmDoctorCheck{
+		Name: "APNs certificate",
+		Hint: "To renew your APNs certificate, follow these instructions: https://fleetdm.com/docs/using-fleet/mdm-setup#renewing-apns",
+	}
+
+	mdm, err := client.GetAppleMDM()
+	if err != nil {
+		check.Status = mdmDoctorFail
+		var nfe service.NotFoundErr
+		if errors.As(err, &nfe) {
+			check.Message = "no certificate found."
+			check.Hint = "Use `fleetctl generate mdm-apple` and then `fleet serve` with `mdm` configuration to turn on MDM features."
+			return check
+		}
+		check.Message = fmt.Sprintf("could not get the certificate: %s", err)
+		check.Hint = "Make sure the mdm.apple_apns_cert and mdm.apple_apns_key configuration of the server is a valid APNs certificate and key."
+		return check
+	}
+
+	switch {
+	case mdm.RenewDate.Before(now):
+		check.Status = mdmDoctorFail
+		check.Message = fmt.Sprintf("expired on %s, MDM features are turned off.", mdm.RenewDate.Format("January 2, 2006"))
+	case mdm.RenewDate.Before(now.Add(mdmDoctorExpirationWarning)):
+		check.Status = mdmDoctorWarn
+		check.Message = fmt.Sprintf("expires on %s, MDM features will be turned off when it expires.", mdm.RenewDate.Format("January 2, 2006"))
+	default:
+		check.Status = mdmDoctorPass
+		check.Message = fmt.Sprintf("valid until %s.", mdm.RenewDate.Format("January 2, 2006"))
+	}
+	return check
+}
+
+func checkMDMDoctorSCEP(client mdmDoctorClient) mdmDoctorCheck {
+	check := mdmDoctorCheck{Name: "SCEP service"}
+	if err := client.MDMAppleCheckSCEP(); err != nil {
+		check.Status = mdmDoctorFail
+		check.Message = fmt.Sprintf("could not get the SCEP CA certificate: %s", err)
+		check.Hint = "Make sure the mdm.apple_scep_cert and mdm.apple_scep_key configuration of the server is valid and that the /mdm/apple/scep path is not blocked by a load balancer or proxy."
+		return check
+	}
+	check.Status = mdmDoctorPass
+	check.Message = "the SCEP CA certificate is served."
+	return check
+}
+
+func checkMDMDoctorABM(client mdmDoctorClient, appCfg *fleet.EnrichedAppConfig, now time.Time) mdmDoctorCheck {
+	check := mdmDoctorCheck{
+		Name: "Apple Business Manager",
+		Hint: "To renew your ABM server token, follow these instructions: https://fleetdm.com/docs/using-fleet/faq#how-can-i-renew-my-apple-business-manager-server-token",
+	}
+	if appCfg.License == nil || !appCfg.License.IsPremium() {
+		check.Status = mdmDoctorSkip
+		check.Message = "automatic enrollment requires Fleet Premium."
+		return check
+	}
+
+	bm, err := client.GetAppleBM()
+	if err != nil {
+		var nfe service.NotFoundErr
+		if errors.As(err, &nfe) {
+			check.Status = mdmDoctorSkip
+			check.Message = "no server token found, automatic enrollment is not configured."
+			return check
+		}
+		check.Status = mdmDoctorFail
+		check.Message = fmt.Sprintf("could not start a session with Apple Business Manager: %s", err)
+		check.Hint = "Make sure the mdm.apple_bm_server_token, mdm.apple_bm_cert and mdm.apple_bm_key configuration of the server is valid. " + check.Hint
+		return check
+	}
+
+	switch {
+	case bm.RenewDate.Before(now):
+		check.Status = mdmDoctorFail
+		check.Message = fmt.Sprintf("the server token expired on %s, newly purchased hosts will not enroll automatically.", bm.RenewDate.Format("January 2, 2006"))
+	case bm.RenewDate.Before(now.Add(mdmDoctorExpirationWarning)):
+		check.Status = mdmDoctorWarn
+		check.Message = fmt.Sprintf("the server token expires on %s.", bm.RenewDate.Format("January 2, 2006"))
+	default:
+		check.Status = mdmDoctorPass
+		check.Message = fmt.Sprintf("connected to %q, the server token is valid until %s.", bm.OrgName, bm.RenewDate.Format("January 2, 2006"))
+	}
+	return check
+}
+
+func checkMDMDoctorDEPProfiles(client mdmDoctorClient) mdmDoctorCheck {
+	check := mdmDoctorCheck{Name: "Automatic enrollment profiles"}
+
+	devices, err := client.MDMAppleListDEPDevices()
+	if err != nil {
+		check.Status = mdmDoctorFail
+		check.Message = fmt.Sprintf("could not list the devices of Apple Business Manager: %s", err)
+		check.Hint = "Make sure the MDM server of Fleet in Apple Business Manager is the one of the server token."
+		return check
+	}
+
+	// profile_status is one of "empty", "assigned", "pushed" or "removed", the
+	// devices without profile will not enroll in Fleet during setup.
+	var missing []string
+	profiles := make(map[string]bool)
+	for _, d := range devices {
+		if d.ProfileUUID == "" || d.ProfileStatus == "empty" || d.ProfileStatus == "removed" {
+			missing = append(missing, d.SerialNumber)
+			continue
+		}
+		profiles[d.ProfileUUID] = true
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		check.Status = mdmDoctorWarn
+		check.Message = fmt.Sprintf("%d of %d device(s) have no enrollment profile assigned: %s.", len(missing), len(devices), mdmDoctorTruncatedList(missing, 10))
+		check.Hint = "The devices will not enroll automatically when they are set up. Make sure they are assigned to Fleet's MDM server in Apple Business Manager, the profile is assigned when they are synced."
+		return check
+	}
+
+	check.Status = mdmDoctorPass
+	check.Message = fmt.Sprintf("%d device(s) have an enrollment profile assigned (%d distinct profile(s)).", len(devices), len(profiles))
+	return check
+}
+
+func checkMDMDoctorProfiles(client mdmDoctorClient) mdmDoctorCheck {
+	check := mdmDoctorCheck{Name: "Configuration profiles"}
+
+	teams, err := client.MDMAppleListProfilesSummaryByTeam()
+	if err != nil {
+		check.Status = mdmDoctorFail
+		check.Message = fmt.Sprintf("could not get the status of the configuration profiles: %s", err)
+		return check
+	}
+
+	var (
+		failed uint
+		parts  []string
+	)
+	for _, tm := range teams {
+		if tm.Failed == 0 {
+			continue
+		}
+		failed += tm.Failed
+		name := tm.TeamName
+		if tm.TeamID == nil {
+			name = "No team"
+		}
+		parts = append(parts, fmt.Sprintf("%s: %d", name, tm.Failed))
+	}
+
+	if failed > 0 {
+		check.Status = mdmDoctorWarn
+		check.Message = fmt.Sprintf("%d host(s) failed to apply one or more profiles (%s).", failed, strings.Join(parts, ", "))
+		check.Hint = "List the affected hosts with the macos_settings=failed filter of the hosts page or API, the error of each profile is in the host's details. Invalid profiles must be fixed and uploaded again."
+		return check
+	}
+
+	check.Status = mdmDoctorPass
+	check.Message = "no host failed to apply a profile."
+	return check
+}
+
+// mdmDoctorTruncatedList joins the first max items of the list, mentioning
+// how many were left out.
+func mdmDoctorTruncatedList(items []string, max int) string {
+	if len(items) <= max {
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(items[:max], ", "), len(items)-max)
+}
@@ -0,0 +1,849 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/pkg/spec"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/fleetdm/fleet/v4/server/service"
+	"github.com/ghodss/yaml"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// mdmExportSpecsFile is the file of an MDM configuration export holding
+	// the config and team specs, with only their mdm section.
+	mdmExportSpecsFile = "mdm.yml"
+	// mdmExportManifestFile is the file of an MDM configuration export holding
+	// the information that can't be applied as specs.
+	mdmExportManifestFile = "export.yml"
+
+	mdmExportGlobalScope = "global"
+)
+
+// mdmExportManifest describes an MDM configuration export.
+type mdmExportManifest struct {
+	ServerURL  string    `json:"server_url"`
+	ExportedAt time.Time `json:"exported_at"`
+	// BootstrapPackages references the bootstrap packages of the exported
+	// server, the packages themselves are not exported.
+	BootstrapPackages []mdmExportBootstrapPackage `json:"bootstrap_packages"`
+}
+
+type mdmExportBootstrapPackage struct {
+	// Team is the name of the team of the package, empty for no team.
+	Team   string `json:"team"`
+	Name   string `json:"name"`
+	Sha256 string `json:"sha256"`
+	// URL is the URL the package was applied from, if any.
+	URL string `json:"url,omitempty"`
+}
+
+func mdmExportCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "export",
+		Usage: "Export the MDM configuration (configuration profiles, macOS setup assistants, settings, bootstrap package references and EULA) of Fleet and its teams to a directory, to import it in another environment with \"fleetctl mdm import\".",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "output-dir",
+				Usage:    "The directory to write the MDM configuration to. It must not exist or be empty.",
+				Required: true,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			client, err := clientFromCLI(c)
+			if err != nil {
+				return fmt.Errorf("create client: %w", err)
+			}
+
+			// print an error if MDM is not configured
+			if err := client.CheckPremiumMDMEnabled(); err != nil {
+				return err
+			}
+
+			dir := c.String("output-dir")
+			entries, err := os.ReadDir(dir)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("read output directory: %w", err)
+			}
+			if len(entries) > 0 {
+				return fmt.Errorf("The output directory %s is not empty.", dir)
+			}
+
+			manifest, err := exportMDMConfig(client, dir)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(c.App.Writer, "The MDM configuration was exported to %s.\n", dir)
+			for _, bp := range manifest.BootstrapPackages {
+				if bp.URL == "" {
+					fmt.Fprintf(c.App.Writer, "[!] the bootstrap package %q of %s was uploaded manually, it must be uploaded manually to the target environment.\n", bp.Name, mdmExportScopeName(bp.Team))
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func mdmImportCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "import",
+		Usage: "Import the MDM configuration exported with \"fleetctl mdm export\". Existing configuration that would be replaced or removed is reported as a conflict and must be confirmed with --force.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "input-dir",
+				Usage:    "The directory of the exported MDM configuration.",
+				Required: true,
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Report the changes and conflicts of the import without applying it.",
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Import the MDM configuration even if conflicts are detected.",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			client, err := clientFromCLI(c)
+			if err != nil {
+				return fmt.Errorf("create client: %w", err)
+			}
+
+			// print an error if MDM is not configured
+			if err := client.CheckPremiumMDMEnabled(); err != nil {
+				return err
+			}
+
+			exp, err := loadMDMExport(c.String("input-dir"))
+			if err != nil {
+				return err
+			}
+
+			teams, err := client.ListTeams("")
+			if err != nil {
+				return fmt.Errorf("list teams: %w", err)
+			}
+			teamsByName := make(map[string]fleet.Team, len(teams))
+			for _, tm := range teams {
+				teamsByName[tm.Name] = tm
+			}
+
+			changes, err := planMDMImport(client, exp, teamsByName)
+			if err != nil {
+				return err
+			}
+
+			var conflicts int
+			for _, ch := range changes {
+				fmt.Fprintln(c.App.Writer, ch)
+				if ch.conflict {
+					conflicts++
+				}
+			}
+			if len(changes) == 0 {
+				fmt.Fprintln(c.App.Writer, "The MDM configuration is up to date.")
+				return nil
+			}
+			fmt.Fprintf(c.App.Writer, "%d change(s), %d conflict(s).\n", len(changes), conflicts)
+
+			if c.Bool("dry-run") {
+				return nil
+			}
+			if conflicts > 0 && !c.Bool("force") {
+				return errors.New("The import would replace or remove existing MDM configuration. Review the conflicts above and run the command again with --force to import it anyway.")
+			}
+
+			if err := applyMDMImport(c, client, exp, teamsByName); err != nil {
+				return err
+			}
+			fmt.Fprintln(c.App.Writer, "The MDM configuration was imported.")
+			return nil
+		},
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Export
+////////////////////////////////////////////////////////////////////////////////
+
+func exportMDMConfig(client *service.Client, dir string) (*mdmExportManifest, error) {
+	appCfg, err := client.GetAppConfig()
+	if err != nil {
+		return nil, fmt.Errorf("get app config: %w", err)
+	}
+	teams, err := client.ListTeams("")
+	if err != nil {
+		return nil, fmt.Errorf("list teams: %w", err)
+	}
+
+	exp := &mdmExporter{client: client, dir: dir, scopeDirs: make(map[string]bool)}
+	manifest := &mdmExportManifest{
+		ServerURL:  appCfg.ServerSettings.ServerURL,
+		ExportedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	globalMDM := mdmGlobalSettingsSpec(appCfg.MDM)
+	if err := exp.exportScope(globalMDM, nil, "", manifest); err != nil {
+		return nil, err
+	}
+	eula, err := exp.exportEULA()
+	if err != nil {
+		return nil, err
+	}
+	globalMDM["macos_setup"].(map[string]interface{})["eula"] = eula
+
+	var specs bytes.Buffer
+	if err := printYaml(specGeneric{
+		Kind:    fleet.AppConfigKind,
+		Version: fleet.ApiVersion,
+		Spec:    map[string]interface{}{"mdm": globalMDM},
+	}, &specs); err != nil {
+		return nil, err
+	}
+
+	for _, tm := range teams {
+		tmMDM := mdmTeamSettingsSpec(tm.Config.MDM)
+		if err := exp.exportScope(tmMDM, ptr.Uint(tm.ID), tm.Name, manifest); err != nil {
+			return nil, err
+		}
+		if err := printYaml(specGeneric{
+			Kind:    fleet.TeamKind,
+			Version: fleet.ApiVersion,
+			Spec: map[string]interface{}{
+				"team": map[string]interface{}{"name": tm.Name, "mdm": tmMDM},
+			},
+		}, &specs); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := exp.writeFile(mdmExportSpecsFile, specs.Bytes()); err != nil {
+		return nil, err
+	}
+	b, err := yaml.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := exp.writeFile(mdmExportManifestFile, b); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+type mdmExporter struct {
+	client *service.Client
+	dir    string
+	// scopeDirs are the directory names used for the files of each team.
+	scopeDirs map[string]bool
+}
+
+// exportScope writes the files of the team (or no team if teamID is nil) and
+// references them in its mdm spec.
+func (e *mdmExporter) exportScope(mdmSpec map[string]interface{}, teamID *uint, teamName string, manifest *mdmExportManifest) error {
+	scopeDir := "no-team"
+	if teamID != nil {
+		scopeDir = "team-" + sanitizeMDMExportFileName(teamName)
+		if e.scopeDirs[scopeDir] {
+			scopeDir = fmt.Sprintf("%s-%d", scopeDir, *teamID)
+		}
+	}
+	e.scopeDirs[scopeDir] = true
+
+	profiles, err := e.client.MDMAppleListConfigProfiles(teamIDOrZero(teamID))
+	if err != nil {
+		return fmt.Errorf("list profiles of %s: %w", mdmExportScopeName(teamName), err)
+	}
+	fleetIdentifiers := mobileconfig.FleetPayloadIdentifiers()
+	customSettings := []string{}
+	for _, prof := range profiles {
+		if _, ok := fleetIdentifiers[prof.Identifier]; ok {
+			// the profiles managed by Fleet are created from the settings
+			continue
+		}
+		b, err := e.client.MDMAppleGetConfigProfileContents(prof.ProfileID)
+		if err != nil {
+			return fmt.Errorf("download profile %q: %w", prof.Identifier, err)
+		}
+		p := path.Join("profiles", scopeDir, sanitizeMDMExportFileName(prof.Identifier)+".mobileconfig")
+		if err := e.writeFile(p, b); err != nil {
+			return err
+		}
+		customSettings = append(customSettings, p)
+	}
+	mdmSpec["macos_settings"].(map[string]interface{})["custom_settings"] = customSettings
+
+	var setupAssistant string
+	asst, err := e.client.MDMAppleGetSetupAssistant(teamID)
+	if err != nil && !isNotFoundErr(err) {
+		return fmt.Errorf("get macOS setup assistant of %s: %w", mdmExportScopeName(teamName), err)
+	}
+	if err == nil {
+		name := sanitizeMDMExportFileName(filepath.Base(asst.Name))
+		if !strings.HasSuffix(name, ".json") {
+			name += ".json"
+		}
+		setupAssistant = path.Join("setup_assistants", scopeDir, name)
+		if err := e.writeFile(setupAssistant, asst.Profile); err != nil {
+			return err
+		}
+	}
+	mdmSpec["macos_setup"].(map[string]interface{})["macos_setup_assistant"] = setupAssistant
+
+	bp, err := e.client.GetBootstrapPackageMetadata(teamIDOrZero(teamID))
+	if err != nil && !isNotFoundErr(err) {
+		return fmt.Errorf("get bootstrap package of %s: %w", mdmExportScopeName(teamName), err)
+	}
+	if err == nil {
+		manifest.BootstrapPackages = append(manifest.BootstrapPackages, mdmExportBootstrapPackage{
+			Team:   teamName,
+			Name:   bp.Name,
+			Sha256: hex.EncodeToString(bp.Sha256),
+			URL:    mdmSpecMacOSSetupValue(mdmSpec, "bootstrap_package"),
+		})
+	}
+	return nil
+}
+
+// exportEULA writes the EULA, if any, and returns its path.
+func (e *mdmExporter) exportEULA() (string, error) {
+	eula, err := e.client.GetEULAMetadata()
+	if err != nil {
+		if isNotFoundErr(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("get EULA: %w", err)
+	}
+	b, err := e.client.DownloadEULA(eula.Token)
+	if err != nil {
+		return "", fmt.Errorf("download EULA: %w", err)
+	}
+	p := path.Join("eula", sanitizeMDMExportFileName(eula.Name))
+	if err := e.writeFile(p, b); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+func (e *mdmExporter) writeFile(name string, b []byte) error {
+	p := filepath.Join(e.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	if err := os.WriteFile(p, b, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
+
+// mdmGlobalSettingsSpec returns the mdm section of the config spec for the
+// settings. The fields that are set automatically by Fleet are not included.
+func mdmGlobalSettingsSpec(mdm fleet.MDM) map[string]interface{} {
+	return map[string]interface{}{
+		"apple_bm_default_team":            mdm.AppleBMDefaultTeam,
+		"apple_bm_enrich_display_name":     mdm.AppleBMEnrichDisplayName,
+		"apple_bm_default_device_families": mdm.AppleBMDefaultDeviceFamilies,
+		"block_invalid_bootstrap_packages": mdm.BlockInvalidBootstrapPackages,
+		"disk_encryption_key_view_ttl":     mdm.DiskEncryptionKeyViewTTL,
+		"profile_change_approval":          mdm.ProfileChangeApproval,
+		"posture_score":                    mdm.PostureScore,
+		"macos_updates":                    mdm.MacOSUpdates,
+		"macos_settings":                   mdm.MacOSSettings.ToMap(),
+		"macos_setup": map[string]interface{}{
+			"bootstrap_package":     mdm.MacOSSetup.BootstrapPackage.Value,
+			"macos_setup_assistant": mdm.MacOSSetup.MacOSSetupAssistant.Value,
+			"eula":                  mdm.MacOSSetup.EULA.Value,
+		},
+		"end_user_authentication": mdm.EndUserAuthentication,
+	}
+}
+
+// mdmTeamSettingsSpec returns the mdm section of the team spec for the
+// settings.
+func mdmTeamSettingsSpec(mdm fleet.TeamMDM) map[string]interface{} {
+	return map[string]interface{}{
+		"macos_updates":  mdm.MacOSUpdates,
+		"macos_settings": mdm.MacOSSettings.ToMap(),
+		"macos_setup": map[string]interface{}{
+			"bootstrap_package":     mdm.MacOSSetup.BootstrapPackage.Value,
+			"macos_setup_assistant": mdm.MacOSSetup.MacOSSetupAssistant.Value,
+		},
+	}
+}
+
+var mdmExportFileNameRegexp = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func sanitizeMDMExportFileName(s string) string {
+	s = strings.Trim(mdmExportFileNameRegexp.ReplaceAllString(s, "_"), "._")
+	if s == "" {
+		s = "unnamed"
+	}
+	return s
+}
+
+func mdmExportScopeName(teamName string) string {
+	if teamName == "" {
+		return "no team"
+	}
+	return fmt.Sprintf("team %q", teamName)
+}
+
+func isNotFoundErr(err error) bool {
+	var nfe service.NotFoundErr
+	return errors.As(err, &nfe)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Import
+////////////////////////////////////////////////////////////////////////////////
+
+type mdmExport struct {
+	dir       string
+	appConfig map[string]interface{}
+	teams     []mdmExportTeam
+	manifest  mdmExportManifest
+}
+
+type mdmExportTeam struct {
+	Name string                 `json:"name"`
+	MDM  map[string]interface{} `json:"mdm"`
+	raw  json.RawMessage
+}
+
+func loadMDMExport(dir string) (*mdmExport, error) {
+	b, err := os.ReadFile(filepath.Join(dir, mdmExportSpecsFile))
+	if err != nil {
+		return nil, fmt.Errorf("read MDM configuration: %w", err)
+	}
+	group, err := spec.GroupFromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("parse MDM configuration: %w", err)
+	}
+
+	exp := &mdmExport{dir: dir}
+	appCfg, _ := group.AppConfig.(map[string]interface{})
+	if _, ok := appCfg["mdm"].(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("parse MDM configuration: missing mdm section in %s", mdmExportSpecsFile)
+	}
+	exp.appConfig = appCfg
+	for _, raw := range group.Teams {
+		tm := mdmExportTeam{raw: raw}
+		if err := json.Unmarshal(raw, &tm); err != nil {
+			return nil, fmt.Errorf("parse MDM configuration: %w", err)
+		}
+		exp.teams = append(exp.teams, tm)
+	}
+
+	b, err = os.ReadFile(filepath.Join(dir, mdmExportManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("read export manifest: %w", err)
+	}
+	if err := yaml.Unmarshal(b, &exp.manifest); err != nil {
+		return nil, fmt.Errorf("parse export manifest: %w", err)
+	}
+	return exp, nil
+}
+
+// mdmImportChange is a change that the import makes to the MDM configuration
+// of the server.
+type mdmImportChange struct {
+	scope    string
+	msg      string
+	conflict bool
+	warning  bool
+}
+
+func (c mdmImportChange) String() string {
+	switch {
+	case c.conflict:
+		return fmt.Sprintf("[!] %s: %s (conflict)", c.scope, c.msg)
+	case c.warning:
+		return fmt.Sprintf("[!] %s: %s", c.scope, c.msg)
+	default:
+		return fmt.Sprintf("[+] %s: %s", c.scope, c.msg)
+	}
+}
+
+type mdmImportPlanner struct {
+	client  *service.Client
+	exp     *mdmExport
+	changes []mdmImportChange
+}
+
+func (p *mdmImportPlanner) add(scope, format string, args ...interface{}) {
+	p.changes = append(p.changes, mdmImportChange{scope: scope, msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *mdmImportPlanner) conflict(scope, format string, args ...interface{}) {
+	p.changes = append(p.changes, mdmImportChange{scope: scope, msg: fmt.Sprintf(format, args...), conflict: true})
+}
+
+func (p *mdmImportPlanner) warn(scope, format string, args ...interface{}) {
+	p.changes = append(p.changes, mdmImportChange{scope: scope, msg: fmt.Sprintf(format, args...), warning: true})
+}
+
+// planMDMImport compares the exported MDM configuration with the one of the
+// server and returns the changes the import would make.
+func planMDMImport(client *service.Client, exp *mdmExport, teamsByName map[string]fleet.Team) ([]mdmImportChange, error) {
+	appCfg, err := client.GetAppConfig()
+	if err != nil {
+		return nil, fmt.Errorf("get app config: %w", err)
+	}
+
+	p := &mdmImportPlanner{client: client, exp: exp}
+	globalMDM := exp.appConfig["mdm"].(map[string]interface{})
+	if err := p.planScope(mdmExportGlobalScope, globalMDM, mdmGlobalSettingsSpec(appCfg.MDM), nil, ""); err != nil {
+		return nil, err
+	}
+	if err := p.planEULA(globalMDM, appCfg.MDM.MacOSSetup.EULA.Value); err != nil {
+		return nil, err
+	}
+
+	for _, tm := range exp.teams {
+		scope := mdmExportScopeName(tm.Name)
+		cur, ok := teamsByName[tm.Name]
+		if !ok {
+			p.add(scope, "team will be created")
+			if err := p.planScope(scope, tm.MDM, nil, nil, tm.Name); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := p.planScope(scope, tm.MDM, mdmTeamSettingsSpec(cur.Config.MDM), ptr.Uint(cur.ID), tm.Name); err != nil {
+			return nil, err
+		}
+	}
+	return p.changes, nil
+}
+
+// planScope compares the exported mdm spec of a team (or global) with the
+// current one, which is nil if the team doesn't exist.
+func (p *mdmImportPlanner) planScope(scope string, exported, current map[string]interface{}, teamID *uint, teamName string) error {
+	if current != nil {
+		if err := p.planSettings(scope, exported, current); err != nil {
+			return err
+		}
+	}
+	if err := p.planProfiles(scope, exported, current != nil, teamID); err != nil {
+		return err
+	}
+	if err := p.planSetupAssistant(scope, exported, current, teamID); err != nil {
+		return err
+	}
+	return p.planBootstrapPackage(scope, exported, current, teamID, teamName)
+}
+
+// mdmImportFileSettings are the settings that reference files or packages,
+// their changes are reported with the changes of the files.
+var mdmImportFileSettings = map[string]bool{
+	"macos_settings.custom_settings":    true,
+	"macos_setup.macos_setup_assistant": true,
+	"macos_setup.eula":                  true,
+	"macos_setup.bootstrap_package":     true,
+}
+
+func (p *mdmImportPlanner) planSettings(scope string, exported, current map[string]interface{}) error {
+	// compare the JSON representations, so that the exported values parsed
+	// from YAML have the same types as the current ones.
+	var exportedJSON, currentJSON map[string]interface{}
+	if err := jsonRoundtrip(exported, &exportedJSON); err != nil {
+		return err
+	}
+	if err := jsonRoundtrip(current, &currentJSON); err != nil {
+		return err
+	}
+
+	for _, k := range sortedKeys(exportedJSON) {
+		expSub, expIsMap := exportedJSON[k].(map[string]interface{})
+		curSub, curIsMap := currentJSON[k].(map[string]interface{})
+		if !expIsMap || !curIsMap {
+			if !reflect.DeepEqual(exportedJSON[k], currentJSON[k]) {
+				p.conflict(scope, "setting %q will be changed", k)
+			}
+			continue
+		}
+		for _, sk := range sortedKeys(expSub) {
+			name := k + "." + sk
+			if mdmImportFileSettings[name] {
+				continue
+			}
+			if !reflect.DeepEqual(expSub[sk], curSub[sk]) {
+				p.conflict(scope, "setting %q will be changed", name)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *mdmImportPlanner) planProfiles(scope string, exported map[string]interface{}, exists bool, teamID *uint) error {
+	settings, _ := exported["macos_settings"].(map[string]interface{})
+	paths, _ := settings["custom_settings"].([]interface{})
+
+	exportedProfiles := make(map[string][]byte, len(paths))
+	for _, v := range paths {
+		s, _ := v.(string)
+		b, err := os.ReadFile(filepath.Join(p.exp.dir, filepath.FromSlash(s)))
+		if err != nil {
+			return fmt.Errorf("read profile: %w", err)
+		}
+		prof, err := fleet.NewMDMAppleConfigProfile(b, nil)
+		if err != nil {
+			return fmt.Errorf("parse profile %s: %w", s, err)
+		}
+		exportedProfiles[prof.Identifier] = b
+	}
+
+	currentProfiles := make(map[string][]byte)
+	if exists {
+		profs, err := p.client.MDMAppleListConfigProfiles(teamIDOrZero(teamID))
+		if err != nil {
+			return fmt.Errorf("list profiles: %w", err)
+		}
+		fleetIdentifiers := mobileconfig.FleetPayloadIdentifiers()
+		for _, prof := range profs {
+			if _, ok := fleetIdentifiers[prof.Identifier]; ok {
+				continue
+			}
+			b, err := p.client.MDMAppleGetConfigProfileContents(prof.ProfileID)
+			if err != nil {
+				return fmt.Errorf("download profile %q: %w", prof.Identifier, err)
+			}
+			currentProfiles[prof.Identifier] = b
+		}
+	}
+
+	for _, ident := range sortedKeys(exportedProfiles) {
+		cur, ok := currentProfiles[ident]
+		switch {
+		case !ok:
+			p.add(scope, "profile %q will be added", ident)
+		case !bytes.Equal(cur, exportedProfiles[ident]):
+			p.conflict(scope, "profile %q will be replaced", ident)
+		}
+	}
+	for _, ident := range sortedKeys(currentProfiles) {
+		if _, ok := exportedProfiles[ident]; !ok {
+			p.conflict(scope, "profile %q will be removed", ident)
+		}
+	}
+	return nil
+}
+
+func (p *mdmImportPlanner) planSetupAssistant(scope string, exported, current map[string]interface{}, teamID *uint) error {
+	exportedPath := mdmSpecMacOSSetupValue(exported, "macos_setup_assistant")
+	currentValue := mdmSpecMacOSSetupValue(current, "macos_setup_assistant")
+
+	var cur *fleet.MDMAppleSetupAssistant
+	if current != nil {
+		asst, err := p.client.MDMAppleGetSetupAssistant(teamID)
+		if err != nil && !isNotFoundErr(err) {
+			return fmt.Errorf("get macOS setup assistant: %w", err)
+		}
+		if err == nil {
+			cur = asst
+		}
+	}
+
+	if exportedPath == "" {
+		// the setup assistant is cleared only if it was set in the settings
+		if currentValue != "" {
+			p.conflict(scope, "macOS setup assistant %q will be removed", currentValue)
+		}
+		return nil
+	}
+
+	b, err := os.ReadFile(filepath.Join(p.exp.dir, filepath.FromSlash(exportedPath)))
+	if err != nil {
+		return fmt.Errorf("read macOS setup assistant: %w", err)
+	}
+	if cur == nil {
+		p.add(scope, "macOS setup assistant %q will be added", path.Base(exportedPath))
+		return nil
+	}
+	equal, err := jsonEqual(b, cur.Profile)
+	if err != nil {
+		return fmt.Errorf("compare macOS setup assistants: %w", err)
+	}
+	if !equal {
+		p.conflict(scope, "macOS setup assistant %q will be replaced", cur.Name)
+	}
+	return nil
+}
+
+func (p *mdmImportPlanner) planBootstrapPackage(scope string, exported, current map[string]interface{}, teamID *uint, teamName string) error {
+	exportedURL := mdmSpecMacOSSetupValue(exported, "bootstrap_package")
+	currentURL := mdmSpecMacOSSetupValue(current, "bootstrap_package")
+
+	var ref *mdmExportBootstrapPackage
+	for _, bp := range p.exp.manifest.BootstrapPackages {
+		if bp.Team == teamName {
+			bp := bp
+			ref = &bp
+			break
+		}
+	}
+
+	var cur *fleet.MDMAppleBootstrapPackage
+	if current != nil {
+		bp, err := p.client.GetBootstrapPackageMetadata(teamIDOrZero(teamID))
+		if err != nil && !isNotFoundErr(err) {
+			return fmt.Errorf("get bootstrap package: %w", err)
+		}
+		if err == nil {
+			cur = bp
+		}
+	}
+
+	switch {
+	case ref == nil:
+		// the bootstrap package is cleared only if it was set in the settings
+		if exportedURL == "" && currentURL != "" {
+			p.conflict(scope, "bootstrap package %q will be removed", currentURL)
+		}
+	case cur != nil && hex.EncodeToString(cur.Sha256) == ref.Sha256:
+		// same package
+	case exportedURL == "":
+		p.warn(scope, "bootstrap package %q must be uploaded manually, it was not applied from a URL", ref.Name)
+	case cur == nil:
+		p.add(scope, "bootstrap package %q will be uploaded from %s", ref.Name, exportedURL)
+	default:
+		p.conflict(scope, "bootstrap package %q will be replaced by %q from %s", cur.Name, ref.Name, exportedURL)
+	}
+	return nil
+}
+
+func (p *mdmImportPlanner) planEULA(exported map[string]interface{}, currentValue string) error {
+	exportedPath := mdmSpecMacOSSetupValue(exported, "eula")
+
+	var cur *fleet.MDMAppleEULA
+	eula, err := p.client.GetEULAMetadata()
+	if err != nil && !isNotFoundErr(err) {
+		return fmt.Errorf("get EULA: %w", err)
+	}
+	if err == nil {
+		cur = eula
+	}
+
+	if exportedPath == "" {
+		// the EULA is cleared only if it was set in the settings
+		if currentValue != "" {
+			p.conflict(mdmExportGlobalScope, "EULA %q will be removed", currentValue)
+		}
+		return nil
+	}
+
+	f, err := os.Open(filepath.Join(p.exp.dir, filepath.FromSlash(exportedPath)))
+	if err != nil {
+		return fmt.Errorf("read EULA: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("read EULA: %w", err)
+	}
+
+	switch {
+	case cur == nil:
+		p.add(mdmExportGlobalScope, "EULA %q will be added", path.Base(exportedPath))
+	case !bytes.Equal(cur.Sha256, h.Sum(nil)):
+		p.conflict(mdmExportGlobalScope, "EULA %q will be replaced", cur.Name)
+	}
+	return nil
+}
+
+// applyMDMImport applies the exported specs. The existing teams keep their
+// settings other than MDM.
+func applyMDMImport(c *cli.Context, client *service.Client, exp *mdmExport, teamsByName map[string]fleet.Team) error {
+	logf := func(format string, a ...interface{}) {
+		fmt.Fprintf(c.App.Writer, format, a...)
+	}
+
+	teamSpecs := make([]json.RawMessage, 0, len(exp.teams))
+	for _, tm := range exp.teams {
+		raw := tm.raw
+		if cur, ok := teamsByName[tm.Name]; ok {
+			tmSpec, err := fleet.TeamSpecFromTeam(&cur)
+			if err != nil {
+				return err
+			}
+			var m map[string]interface{}
+			if err := jsonRoundtrip(tmSpec, &m); err != nil {
+				return err
+			}
+			m["mdm"] = tm.MDM
+			if raw, err = json.Marshal(m); err != nil {
+				return err
+			}
+		}
+		teamSpecs = append(teamSpecs, raw)
+	}
+
+	// apply the teams first, so that the global settings can reference them
+	// (e.g. the default team of Apple Business Manager hosts).
+	if len(teamSpecs) > 0 {
+		if err := client.ApplyGroup(c.Context, &spec.Group{Teams: teamSpecs}, exp.dir, logf, fleet.ApplySpecOptions{}); err != nil {
+			return err
+		}
+	}
+	return client.ApplyGroup(c.Context, &spec.Group{AppConfig: exp.appConfig}, exp.dir, logf, fleet.ApplySpecOptions{})
+}
+
+func mdmSpecMacOSSetupValue(mdmSpec map[string]interface{}, key string) string {
+	setup, _ := mdmSpec["macos_setup"].(map[string]interface{})
+	s, _ := setup[key].(string)
+	return s
+}
+
+func jsonRoundtrip(v interface{}, dst interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+func jsonEqual(a, b []byte) (bool, error) {
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(va, vb), nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// teamIDOrZero returns the team ID, or 0 for no team as expected by the
+// endpoints that take the team ID in the path.
+func teamIDOrZero(teamID *uint) uint {
+	if teamID == nil {
+		return 0
+	}
+	return *teamID
+}
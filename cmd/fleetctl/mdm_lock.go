@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/service"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/urfave/cli/v2"
+)
+
+// mdmCommandResultPollInterval is the interval between two checks of the
+// result of an MDM command while waiting for it.
+var mdmCommandResultPollInterval = 5 * time.Second
+
+func mdmLockHostFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "host",
+			Usage:    "The host, specified by hostname, uuid, osquery_host_id or node_key.",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "Skip the confirmation prompt.",
+		},
+		&cli.DurationFlag{
+			Name:  "wait",
+			Usage: "Wait up to this duration for the host to run the command, e.g. 10m. By default, the command doesn't wait.",
+		},
+	}
+}
+
+func mdmLockCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "lock",
+		Usage: "Lock a macOS host. The PIN that unlocks the host is printed once the command is sent.",
+		Flags: mdmLockHostFlags(),
+		Action: func(c *cli.Context) error {
+			client, host, err := mdmLockHostFromCLI(c, "lock")
+			if err != nil {
+				return err
+			}
+
+			ok, err := confirmMDMLockAction(c, fmt.Sprintf("This will lock the host %q. It can only be unlocked with the PIN printed by this command.", host.DisplayName))
+			if err != nil || !ok {
+				return err
+			}
+
+			pin, err := client.MDMAppleLockHost(host.ID)
+			if err != nil {
+				return mdmLockHostError(err, "lock")
+			}
+
+			fmt.Fprintf(c.App.Writer, `
+The host will be locked the next time it checks into Fleet.
+
+PIN to unlock the host: %s
+
+Run this command to see the PIN again:
+
+fleetctl mdm unlock --host=%s
+`, pin.PIN, c.String("host"))
+
+			return waitMDMCommandResult(c, client, pin.CommandUUID)
+		},
+	}
+}
+
+func mdmWipeCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "wipe",
+		Usage: "Wipe a macOS host. All the content of the host is erased, this can't be undone.",
+		Flags: mdmLockHostFlags(),
+		Action: func(c *cli.Context) error {
+			client, host, err := mdmLockHostFromCLI(c, "wipe")
+			if err != nil {
+				return err
+			}
+
+			ok, err := confirmMDMLockAction(c, fmt.Sprintf("This will erase all the content of the host %q. This can't be undone.", host.DisplayName))
+			if err != nil || !ok {
+				return err
+			}
+
+			pin, err := client.MDMAppleWipeHost(host.ID)
+			if err != nil {
+				return mdmLockHostError(err, "wipe")
+			}
+
+			fmt.Fprintf(c.App.Writer, `
+The host will be wiped the next time it checks into Fleet.
+
+PIN to unlock the host if Find My locks it after it is wiped: %s
+
+Run this command to see the PIN again:
+
+fleetctl mdm unlock --host=%s
+`, pin.PIN, c.String("host"))
+
+			return waitMDMCommandResult(c, client, pin.CommandUUID)
+		},
+	}
+}
+
+func mdmUnlockCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "unlock",
+		Usage: "Print the PIN that unlocks a macOS host after it was locked or wiped. The PIN must be entered on the host.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "host",
+				Usage:    "The host, specified by hostname, uuid, osquery_host_id or node_key.",
+				Required: true,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			client, host, err := mdmLockHostFromCLI(c, "unlock")
+			if err != nil {
+				return err
+			}
+
+			pin, err := client.MDMAppleGetHostUnlockPIN(host.ID)
+			if err != nil {
+				var nfe service.NotFoundErr
+				if errors.As(err, &nfe) {
+					return errors.New("The host was never locked or wiped by Fleet.")
+				}
+				return mdmLockHostError(err, "unlock")
+			}
+
+			action := "locked"
+			if pin.RequestType == "EraseDevice" {
+				action = "wiped"
+			}
+			fmt.Fprintf(c.App.Writer, "The host was %s on %s.\n\nEnter this PIN on the host to unlock it: %s\n",
+				action, pin.UpdatedAt.UTC().Format(time.RFC3339), pin.PIN)
+			return nil
+		},
+	}
+}
+
+// mdmLockHostFromCLI returns the client and the host targeted by the --host
+// flag, checking that the host has Fleet's MDM turned on.
+func mdmLockHostFromCLI(c *cli.Context, action string) (*service.Client, *service.HostDetailResponse, error) {
+	client, err := clientFromCLI(c)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create client: %w", err)
+	}
+
+	// print an error if premium MDM is not configured
+	if err := client.CheckPremiumMDMEnabled(); err != nil {
+		return nil, nil, err
+	}
+
+	host, err := client.HostByIdentifier(c.String("host"))
+	if err != nil {
+		var nfe service.NotFoundErr
+		if errors.As(err, &nfe) {
+			return nil, nil, errors.New("The host doesn't exist. Please provide a valid hostname, uuid, osquery_host_id or node_key.")
+		}
+		return nil, nil, mdmLockHostError(err, action)
+	}
+
+	if host.MDM.EnrollmentStatus == nil || !strings.HasPrefix(*host.MDM.EnrollmentStatus, "On") ||
+		host.MDM.Name != fleet.WellKnownMDMFleet {
+		return nil, nil, fmt.Errorf("Can't %s the host because it doesn't have MDM turned on. Run the following command to see a list of hosts with MDM on: fleetctl get hosts --mdm", action)
+	}
+	return client, host, nil
+}
+
+func mdmLockHostError(err error, action string) error {
+	var sce kithttp.StatusCoder
+	if errors.As(err, &sce) && sce.StatusCode() == http.StatusForbidden {
+		return fmt.Errorf("Permission denied. You don't have permission to %s this host: %w", action, err)
+	}
+	return err
+}
+
+// confirmMDMLockAction prints the warning and asks the user to confirm the
+// action, unless the --yes flag is set. It returns false if the user didn't
+// confirm.
+func confirmMDMLockAction(c *cli.Context, warning string) (bool, error) {
+	if c.Bool("yes") {
+		return true, nil
+	}
+
+	fmt.Fprintf(c.App.Writer, "%s\nType \"yes\" to continue: ", warning)
+	answer, err := bufio.NewReader(c.App.Reader).ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(c.App.Writer, "\nAborted.")
+		return false, nil
+	}
+	if !strings.EqualFold(strings.TrimSpace(answer), "yes") {
+		fmt.Fprintln(c.App.Writer, "Aborted.")
+		return false, nil
+	}
+	return true, nil
+}
+
+// waitMDMCommandResult waits for the host to run the command for up to the
+// duration of the --wait flag, if set.
+func waitMDMCommandResult(c *cli.Context, client *service.Client, commandUUID string) error {
+	timeout := c.Duration("wait")
+	if timeout <= 0 {
+		return nil
+	}
+
+	fmt.Fprintln(c.App.Writer, "\nWaiting for the host to run the command...")
+	deadline := time.Now().Add(timeout)
+	for {
+		results, err := client.MDMAppleGetCommandResults(commandUUID)
+		if err != nil {
+			return fmt.Errorf("get command results: %w", err)
+		}
+		for _, res := range results {
+			switch res.Status {
+			case fleet.MDMAppleStatusAcknowledged:
+				fmt.Fprintln(c.App.Writer, "The host ran the command.")
+				return nil
+			case fleet.MDMAppleStatusError, fleet.MDMAppleStatusCommandFormatError:
+				return fmt.Errorf("The host failed to run the command. Run this command to see its result: fleetctl get mdm-command-results --id=%s", commandUUID)
+			}
+		}
+
+		if time.Now().Add(mdmCommandResultPollInterval).After(deadline) {
+			return fmt.Errorf("The host didn't run the command within %s. Run this command to see its result later: fleetctl get mdm-command-results --id=%s", timeout, commandUUID)
+		}
+		time.Sleep(mdmCommandResultPollInterval)
+	}
+}
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
 	mock "github.com/fleetdm/fleet/v4/server/mock/nanomdm"
 	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/service"
 	"github.com/google/uuid"
+	"github.com/micromdm/nanodep/godep"
 	"github.com/micromdm/nanomdm/mdm"
 	"github.com/micromdm/nanomdm/push"
 	"github.com/stretchr/testify/require"
@@ -59,6 +68,9 @@ func TestMDMRunCommand(t *testing.T) {
 	ds.ListHostBatteriesFunc = func(ctx context.Context, id uint) ([]*fleet.HostBattery, error) {
 		return nil, nil
 	}
+	ds.GetHostAssignedUserFunc = func(ctx context.Context, hostID uint) (*fleet.HostAssignedUser, error) {
+		return nil, &notFoundError{}
+	}
 	ds.ListPoliciesForHostFunc = func(ctx context.Context, host *fleet.Host) ([]*fleet.HostPolicy, error) {
 		return nil, nil
 	}
@@ -68,6 +80,12 @@ func TestMDMRunCommand(t *testing.T) {
 	ds.GetHostMDMMacOSSetupFunc = func(ctx context.Context, hostID uint) (*fleet.HostMDMMacOSSetup, error) {
 		return nil, nil
 	}
+	ds.GetHostMDMIdPAccountFunc = func(ctx context.Context, hostUUID string) (*fleet.MDMIdPAccount, error) {
+		return nil, &notFoundError{}
+	}
+	ds.FilterMDMAppleHostUUIDsPendingApprovalFunc = func(ctx context.Context, hostUUIDs []string) ([]string, error) {
+		return nil, nil
+	}
 	ds.ListHostsLiteByUUIDsFunc = func(ctx context.Context, filter fleet.TeamFilter, uuids []string) ([]*fleet.Host, error) {
 		if len(uuids) == 0 {
 			return nil, nil
@@ -131,6 +149,15 @@ func TestMDMRunCommand(t *testing.T) {
 	require.Contains(t, buf.String(), `The hosts will run the command the next time it checks into Fleet.`)
 	require.Contains(t, buf.String(), `fleetctl get mdm-command-results --id=`)
 
+	// with a priority
+	buf, err = runAppNoChecks([]string{"mdm", "run-command", "--host", "valid-host", "--payload", cmdFilePath, "--priority", "urgent"})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), `The hosts will run the command the next time it checks into Fleet.`)
+
+	_, err = runAppNoChecks([]string{"mdm", "run-command", "--host", "valid-host", "--payload", cmdFilePath, "--priority", "asap"})
+	require.Error(t, err)
+	require.ErrorContains(t, err, `Invalid --priority value "asap"`)
+
 	// try to run a fleet premium command
 	cmdFilePath = writeTmpMDMCmd(t, "EraseDevice")
 	_, err = runAppNoChecks([]string{"mdm", "run-command", "--host", "valid-host", "--payload", cmdFilePath})
@@ -138,6 +165,247 @@ func TestMDMRunCommand(t *testing.T) {
 	require.ErrorContains(t, err, `missing or invalid license`)
 }
 
+func TestMDMTeamEnrollmentProfile(t *testing.T) {
+	license := &fleet.LicenseInfo{Tier: fleet.TierPremium, Expiration: time.Now().Add(24 * time.Hour)}
+	_, ds := runServerWithMockedDS(t, &service.TestServerOpts{License: license})
+
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{
+			MDM:            fleet.MDM{EnabledAndConfigured: true},
+			ServerSettings: fleet.ServerSettings{ServerURL: "https://example.com"},
+		}, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		if tid == 1 {
+			return &fleet.Team{ID: tid, Name: "team1"}, nil
+		}
+		return nil, &notFoundError{}
+	}
+	tokens := map[uint]string{}
+	ds.EnsureMDMAppleTeamEnrollmentTokenFunc = func(ctx context.Context, teamID *uint, token string) (*fleet.MDMAppleTeamEnrollmentToken, error) {
+		var tmID uint
+		if teamID != nil {
+			tmID = *teamID
+		}
+		if _, ok := tokens[tmID]; !ok {
+			tokens[tmID] = token
+		}
+		return &fleet.MDMAppleTeamEnrollmentToken{TeamID: teamID, Token: tokens[tmID]}, nil
+	}
+	ds.SetMDMAppleTeamEnrollmentTokenFunc = func(ctx context.Context, teamID *uint, token string) (*fleet.MDMAppleTeamEnrollmentToken, error) {
+		var tmID uint
+		if teamID != nil {
+			tmID = *teamID
+		}
+		tokens[tmID] = token
+		return &fleet.MDMAppleTeamEnrollmentToken{TeamID: teamID, Token: token}, nil
+	}
+
+	_, err := runAppNoChecks([]string{"mdm", "download-enrollment-profile"})
+	require.ErrorContains(t, err, `Required flag "output" not set`)
+
+	outFile := filepath.Join(t.TempDir(), "enroll.mobileconfig")
+	buf, err := runAppNoChecks([]string{"mdm", "download-enrollment-profile", "--team", "1", "--output", outFile})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "The enrollment profile was written to "+outFile)
+	profile, err := os.ReadFile(outFile)
+	require.NoError(t, err)
+	require.Contains(t, string(profile), "enrollment_team_token="+url.QueryEscape(tokens[1]))
+
+	// the team does not exist
+	_, err = runAppNoChecks([]string{"mdm", "download-enrollment-profile", "--team", "2", "--output", outFile})
+	require.Error(t, err)
+
+	buf, err = runAppNoChecks([]string{"mdm", "rotate-enrollment-token", "--team", "1"})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "The enrollment token was rotated.")
+	_, err = runAppNoChecks([]string{"mdm", "download-enrollment-profile", "--team", "1", "--output", outFile})
+	require.NoError(t, err)
+	rotated, err := os.ReadFile(outFile)
+	require.NoError(t, err)
+	require.NotEqual(t, profile, rotated)
+	require.Contains(t, string(rotated), "enrollment_team_token="+url.QueryEscape(tokens[1]))
+}
+
+func TestMDMAssignDEPTeams(t *testing.T) {
+	_, ds := runServerWithMockedDS(t)
+
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{MDM: fleet.MDM{EnabledAndConfigured: true}}, nil
+	}
+	ds.TeamByNameFunc = func(ctx context.Context, name string) (*fleet.Team, error) {
+		if name == "team1" {
+			return &fleet.Team{ID: 1, Name: name}, nil
+		}
+		return nil, &notFoundError{}
+	}
+	var gotAssignments []*fleet.MDMAppleDEPTeamAssignment
+	ds.SetMDMAppleDEPTeamAssignmentsFunc = func(ctx context.Context, assignments []*fleet.MDMAppleDEPTeamAssignment) error {
+		gotAssignments = assignments
+		return nil
+	}
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		return nil
+	}
+
+	_, err := runAppNoChecks([]string{"mdm", "assign-dep-teams"})
+	require.ErrorContains(t, err, `Required flag "csv" not set`)
+
+	csvFile := filepath.Join(t.TempDir(), "teams.csv")
+	require.NoError(t, os.WriteFile(csvFile, []byte("serial_number,team\nABC,team1\nDEF,no-such-team\n"), 0o644))
+	_, err = runAppNoChecks([]string{"mdm", "assign-dep-teams", "--csv", csvFile})
+	require.ErrorContains(t, err, "line 3: team no-such-team does not exist")
+	require.False(t, ds.SetMDMAppleDEPTeamAssignmentsFuncInvoked)
+
+	require.NoError(t, os.WriteFile(csvFile, []byte("serial_number,team\nABC,team1\nDEF,\n"), 0o644))
+	buf, err := runAppNoChecks([]string{"mdm", "assign-dep-teams", "--csv", csvFile})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "1 device(s) assigned to a team, 1 assignment(s) removed.")
+	require.Len(t, gotAssignments, 2)
+	require.Equal(t, ptr.Uint(1), gotAssignments[0].TeamID)
+	require.Nil(t, gotAssignments[1].TeamID)
+}
+
+func TestMDMExportImport(t *testing.T) {
+	license := &fleet.LicenseInfo{Tier: fleet.TierPremium, Expiration: time.Now().Add(24 * time.Hour)}
+	_, ds := runServerWithMockedDS(t, &service.TestServerOpts{License: license})
+
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{
+			MDM: fleet.MDM{
+				EnabledAndConfigured: true,
+				MacOSUpdates:         fleet.MacOSUpdates{MinimumVersion: "13.3", Deadline: "2023-06-01"},
+			},
+			ServerSettings: fleet.ServerSettings{ServerURL: "https://example.com"},
+		}, nil
+	}
+	teams := []*fleet.Team{{ID: 1, Name: "team1"}}
+	ds.ListTeamsFunc = func(ctx context.Context, filter fleet.TeamFilter, opt fleet.ListOptions) ([]*fleet.Team, error) {
+		return teams, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		for _, tm := range teams {
+			if tm.ID == tid {
+				return tm, nil
+			}
+		}
+		return nil, &notFoundError{}
+	}
+
+	profiles := map[uint]*fleet.MDMAppleConfigProfile{
+		1: {ProfileID: 1, Identifier: "com.example.global", Name: "Global", Mobileconfig: mobileconfigForTest("Global", "com.example.global")},
+		2: {ProfileID: 2, TeamID: ptr.Uint(1), Identifier: "com.example.team", Name: "Team", Mobileconfig: mobileconfigForTest("Team", "com.example.team")},
+		3: {ProfileID: 3, TeamID: ptr.Uint(1), Identifier: mobileconfig.FleetFileVaultPayloadIdentifier, Name: "Disk encryption", Mobileconfig: mobileconfigForTest("Disk encryption", mobileconfig.FleetFileVaultPayloadIdentifier)},
+	}
+	ds.ListMDMAppleConfigProfilesFunc = func(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleConfigProfile, error) {
+		var res []*fleet.MDMAppleConfigProfile
+		for _, id := range []uint{1, 2, 3, 4} {
+			if p, ok := profiles[id]; ok && teamIDOrZero(p.TeamID) == teamIDOrZero(teamID) {
+				res = append(res, p)
+			}
+		}
+		return res, nil
+	}
+	ds.GetMDMAppleConfigProfileFunc = func(ctx context.Context, profileID uint) (*fleet.MDMAppleConfigProfile, error) {
+		if p, ok := profiles[profileID]; ok {
+			return p, nil
+		}
+		return nil, &notFoundError{}
+	}
+	ds.GetMDMAppleSetupAssistantFunc = func(ctx context.Context, teamID *uint) (*fleet.MDMAppleSetupAssistant, error) {
+		if teamID != nil {
+			return &fleet.MDMAppleSetupAssistant{TeamID: teamID, Name: "assistant.json", Profile: json.RawMessage(`{"skip_setup_items": ["Location"]}`)}, nil
+		}
+		return nil, &notFoundError{}
+	}
+	ds.GetMDMAppleBootstrapPackageMetaFunc = func(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackage, error) {
+		if teamID == 1 {
+			return &fleet.MDMAppleBootstrapPackage{TeamID: teamID, Name: "bootstrap.pkg", Sha256: []byte{1, 2, 3}, Token: "bp-token"}, nil
+		}
+		return nil, &notFoundError{}
+	}
+	eula := &fleet.MDMAppleEULA{Name: "eula.pdf", Token: "eula-token", Bytes: []byte("%PDF-eula"), Sha256: []byte{4, 5, 6}}
+	ds.MDMAppleGetEULAMetadataFunc = func(ctx context.Context) (*fleet.MDMAppleEULA, error) {
+		return eula, nil
+	}
+	ds.MDMAppleGetEULABytesFunc = func(ctx context.Context, token string) (*fleet.MDMAppleEULA, error) {
+		return eula, nil
+	}
+
+	_, err := runAppNoChecks([]string{"mdm", "export"})
+	require.ErrorContains(t, err, `Required flag "output-dir" not set`)
+
+	dir := filepath.Join(t.TempDir(), "export")
+	buf, err := runAppNoChecks([]string{"mdm", "export", "--output-dir", dir})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "The MDM configuration was exported to "+dir)
+	require.Contains(t, buf.String(), `the bootstrap package "bootstrap.pkg" of team "team1" was uploaded manually`)
+
+	b, err := os.ReadFile(filepath.Join(dir, "profiles", "no-team", "com.example.global.mobileconfig"))
+	require.NoError(t, err)
+	require.Equal(t, profiles[1].Mobileconfig, mobileconfig.Mobileconfig(b))
+	_, err = os.Stat(filepath.Join(dir, "profiles", "team-team1", "com.example.team.mobileconfig"))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "profiles", "team-team1", mobileconfig.FleetFileVaultPayloadIdentifier+".mobileconfig"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	b, err = os.ReadFile(filepath.Join(dir, "setup_assistants", "team-team1", "assistant.json"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"skip_setup_items": ["Location"]}`, string(b))
+	b, err = os.ReadFile(filepath.Join(dir, "eula", "eula.pdf"))
+	require.NoError(t, err)
+	require.Equal(t, "%PDF-eula", string(b))
+	b, err = os.ReadFile(filepath.Join(dir, "mdm.yml"))
+	require.NoError(t, err)
+	require.Contains(t, string(b), "- profiles/no-team/com.example.global.mobileconfig")
+	require.Contains(t, string(b), "macos_setup_assistant: setup_assistants/team-team1/assistant.json")
+	require.Contains(t, string(b), "minimum_version: \"13.3\"")
+
+	// the output directory must be empty
+	_, err = runAppNoChecks([]string{"mdm", "export", "--output-dir", dir})
+	require.ErrorContains(t, err, "is not empty")
+
+	// importing to the same server changes nothing, once the checksum of the
+	// mocked EULA matches its contents
+	eula.Sha256 = sha256Sum([]byte("%PDF-eula"))
+	buf, err = runAppNoChecks([]string{"mdm", "import", "--input-dir", dir, "--dry-run"})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "The MDM configuration is up to date.")
+
+	// change the target server
+	profiles[1].Mobileconfig = mobileconfigForTest("Global changed", "com.example.global")
+	delete(profiles, 2)
+	profiles[4] = &fleet.MDMAppleConfigProfile{ProfileID: 4, Identifier: "com.example.other", Name: "Other", Mobileconfig: mobileconfigForTest("Other", "com.example.other")}
+	teams = nil
+	// the app config is cached by the server, change the exported one instead
+	b, err = os.ReadFile(filepath.Join(dir, "mdm.yml"))
+	require.NoError(t, err)
+	b = bytes.Replace(b, []byte(`minimum_version: "13.3"`), []byte(`minimum_version: "13.4"`), 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mdm.yml"), b, 0o644))
+
+	buf, err = runAppNoChecks([]string{"mdm", "import", "--input-dir", dir, "--dry-run"})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), `[!] global: setting "macos_updates.minimum_version" will be changed (conflict)`)
+	require.Contains(t, buf.String(), `[!] global: profile "com.example.global" will be replaced (conflict)`)
+	require.Contains(t, buf.String(), `[!] global: profile "com.example.other" will be removed (conflict)`)
+	require.Contains(t, buf.String(), `[+] team "team1": team will be created`)
+	require.Contains(t, buf.String(), `[+] team "team1": profile "com.example.team" will be added`)
+	require.Contains(t, buf.String(), `[+] team "team1": macOS setup assistant "assistant.json" will be added`)
+	require.Contains(t, buf.String(), `[!] team "team1": bootstrap package "bootstrap.pkg" must be uploaded manually`)
+	require.Contains(t, buf.String(), "7 change(s), 3 conflict(s).")
+
+	// conflicts must be confirmed
+	_, err = runAppNoChecks([]string{"mdm", "import", "--input-dir", dir})
+	require.ErrorContains(t, err, "run the command again with --force")
+
+	_, err = runAppNoChecks([]string{"mdm", "import", "--input-dir", t.TempDir()})
+	require.ErrorContains(t, err, "read MDM configuration")
+}
+
+func sha256Sum(b []byte) []byte {
+	h := sha256.Sum256(b)
+	return h[:]
+}
+
 func writeTmpMDMCmd(t *testing.T, commandName string) string {
 	tmpFile, err := os.CreateTemp(t.TempDir(), "*.xml")
 	require.NoError(t, err)
@@ -165,3 +433,267 @@ func writeTmpMobileconfig(t *testing.T, name string) string {
 	require.NoError(t, err)
 	return tmpFile.Name()
 }
+
+func TestMDMLockWipeUnlock(t *testing.T) {
+	enqueuer := new(mock.Storage)
+	license := &fleet.LicenseInfo{Tier: fleet.TierPremium, Expiration: time.Now().Add(24 * time.Hour)}
+	_, ds := runServerWithMockedDS(t, &service.TestServerOpts{MDMStorage: enqueuer, MDMPusher: mockPusher{}, License: license})
+
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{MDM: fleet.MDM{EnabledAndConfigured: true}}, nil
+	}
+	ds.HostByIdentifierFunc = func(ctx context.Context, identifier string) (*fleet.Host, error) {
+		switch identifier {
+		case "no-such-host":
+			return nil, &notFoundError{}
+		case "no-mdm-host":
+			return &fleet.Host{ID: 1, UUID: identifier}, nil
+		default:
+			return &fleet.Host{ID: 4, UUID: identifier, Hostname: identifier, MDM: fleet.MDMHostData{Name: fleet.WellKnownMDMFleet, EnrollmentStatus: ptr.String("On (manual)")}}, nil
+		}
+	}
+	ds.LoadHostSoftwareFunc = func(ctx context.Context, host *fleet.Host, includeCVEScores bool) error {
+		return nil
+	}
+	ds.ListLabelsForHostFunc = func(ctx context.Context, hid uint) ([]*fleet.Label, error) {
+		return nil, nil
+	}
+	ds.ListPacksForHostFunc = func(ctx context.Context, hid uint) (packs []*fleet.Pack, err error) {
+		return nil, nil
+	}
+	ds.ListHostBatteriesFunc = func(ctx context.Context, id uint) ([]*fleet.HostBattery, error) {
+		return nil, nil
+	}
+	ds.GetHostAssignedUserFunc = func(ctx context.Context, hostID uint) (*fleet.HostAssignedUser, error) {
+		return nil, &notFoundError{}
+	}
+	ds.ListPoliciesForHostFunc = func(ctx context.Context, host *fleet.Host) ([]*fleet.HostPolicy, error) {
+		return nil, nil
+	}
+	ds.GetHostMDMProfilesFunc = func(ctx context.Context, hostUUID string) ([]fleet.HostMDMAppleProfile, error) {
+		return nil, nil
+	}
+	ds.GetHostMDMMacOSSetupFunc = func(ctx context.Context, hostID uint) (*fleet.HostMDMMacOSSetup, error) {
+		return nil, nil
+	}
+	ds.GetHostMDMIdPAccountFunc = func(ctx context.Context, hostUUID string) (*fleet.MDMIdPAccount, error) {
+		return nil, &notFoundError{}
+	}
+	ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
+		return &fleet.Host{ID: id, UUID: "valid-host"}, nil
+	}
+	ds.GetHostMDMCheckinInfoFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		return &fleet.HostMDMCheckinInfo{}, nil
+	}
+	var lockPIN *fleet.HostMDMAppleLockPIN
+	ds.SetHostMDMAppleLockPINFunc = func(ctx context.Context, pin *fleet.HostMDMAppleLockPIN) error {
+		pin.UpdatedAt = time.Date(2023, 6, 21, 10, 0, 0, 0, time.UTC)
+		lockPIN = pin
+		return nil
+	}
+	ds.GetHostMDMAppleLockPINFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMAppleLockPIN, error) {
+		if lockPIN == nil {
+			return nil, &notFoundError{}
+		}
+		return lockPIN, nil
+	}
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		return nil
+	}
+	ds.NewSecurityAuditLogEntryFunc = func(ctx context.Context, entry *fleet.SecurityAuditLogEntry) error {
+		return nil
+	}
+	ds.GetMDMAppleCommandRequestTypeFunc = func(ctx context.Context, commandUUID string) (string, error) {
+		return lockPIN.RequestType, nil
+	}
+	var status string
+	ds.GetMDMAppleCommandResultsFunc = func(ctx context.Context, commandUUID string) ([]*fleet.MDMAppleCommandResult, error) {
+		if status == "" {
+			return nil, nil
+		}
+		return []*fleet.MDMAppleCommandResult{{DeviceID: "valid-host", CommandUUID: commandUUID, Status: status}}, nil
+	}
+	ds.ListHostsLiteByUUIDsFunc = func(ctx context.Context, filter fleet.TeamFilter, uuids []string) ([]*fleet.Host, error) {
+		return []*fleet.Host{{ID: 4, UUID: "valid-host"}}, nil
+	}
+	var enqueued []string
+	enqueuer.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+		enqueued = append(enqueued, cmd.Command.RequestType)
+		return map[string]error{}, nil
+	}
+
+	mdmCommandResultPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { mdmCommandResultPollInterval = 5 * time.Second })
+
+	_, err := runAppNoChecks([]string{"mdm", "lock"})
+	require.ErrorContains(t, err, `Required flag "host" not set`)
+
+	_, err = runAppNoChecks([]string{"mdm", "lock", "--host", "no-such-host", "--yes"})
+	require.ErrorContains(t, err, `The host doesn't exist.`)
+
+	_, err = runAppNoChecks([]string{"mdm", "wipe", "--host", "no-mdm-host", "--yes"})
+	require.ErrorContains(t, err, `Can't wipe the host because it doesn't have MDM turned on.`)
+
+	_, err = runAppNoChecks([]string{"mdm", "unlock", "--host", "valid-host"})
+	require.ErrorContains(t, err, `The host was never locked or wiped by Fleet.`)
+
+	// without confirmation, nothing is sent
+	buf, err := runAppNoChecks([]string{"mdm", "lock", "--host", "valid-host"})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), `This will lock the host "valid-host".`)
+	require.Contains(t, buf.String(), `Aborted.`)
+	require.Empty(t, enqueued)
+
+	buf, err = runAppNoChecks([]string{"mdm", "lock", "--host", "valid-host", "--yes"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"DeviceLock"}, enqueued)
+	require.NotNil(t, lockPIN)
+	require.Contains(t, buf.String(), `The host will be locked the next time it checks into Fleet.`)
+	require.Contains(t, buf.String(), "PIN to unlock the host: "+lockPIN.PIN)
+	require.Contains(t, buf.String(), `fleetctl mdm unlock --host=valid-host`)
+	require.NotContains(t, buf.String(), `Waiting for the host`)
+
+	buf, err = runAppNoChecks([]string{"mdm", "unlock", "--host", "valid-host"})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "The host was locked on 2023-06-21T10:00:00Z.")
+	require.Contains(t, buf.String(), "Enter this PIN on the host to unlock it: "+lockPIN.PIN)
+
+	// the host doesn't run the command in time
+	_, err = runAppNoChecks([]string{"mdm", "wipe", "--host", "valid-host", "--yes", "--wait", "50ms"})
+	require.ErrorContains(t, err, `The host didn't run the command within 50ms.`)
+	require.Equal(t, []string{"DeviceLock", "EraseDevice"}, enqueued)
+	require.Equal(t, "EraseDevice", lockPIN.RequestType)
+
+	status = fleet.MDMAppleStatusError
+	_, err = runAppNoChecks([]string{"mdm", "wipe", "--host", "valid-host", "--yes", "--wait", "1m"})
+	require.ErrorContains(t, err, `The host failed to run the command.`)
+
+	status = fleet.MDMAppleStatusAcknowledged
+	buf, err = runAppNoChecks([]string{"mdm", "wipe", "--host", "valid-host", "--yes", "--wait", "1m"})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "PIN to unlock the host if Find My locks it after it is wiped: "+lockPIN.PIN)
+	require.Contains(t, buf.String(), `The host ran the command.`)
+
+	buf, err = runAppNoChecks([]string{"mdm", "unlock", "--host", "valid-host"})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "The host was wiped on 2023-06-21T10:00:00Z.")
+}
+
+type clientNotFoundErr struct{}
+
+func (clientNotFoundErr) Error() string  { return "The resource was not found" }
+func (clientNotFoundErr) NotFound() bool { return true }
+
+type mockMDMDoctorClient struct {
+	appCfg     *fleet.EnrichedAppConfig
+	apns       *fleet.AppleMDM
+	apnsErr    error
+	scepErr    error
+	abm        *fleet.AppleBM
+	abmErr     error
+	devices    []fleet.MDMAppleDEPDevice
+	devicesErr error
+	summary    []*fleet.MDMAppleConfigProfilesTeamSummary
+}
+
+func (m *mockMDMDoctorClient) GetAppConfig() (*fleet.EnrichedAppConfig, error) { return m.appCfg, nil }
+func (m *mockMDMDoctorClient) GetAppleMDM() (*fleet.AppleMDM, error)           { return m.apns, m.apnsErr }
+func (m *mockMDMDoctorClient) GetAppleBM() (*fleet.AppleBM, error)             { return m.abm, m.abmErr }
+func (m *mockMDMDoctorClient) MDMAppleCheckSCEP() error                        { return m.scepErr }
+func (m *mockMDMDoctorClient) MDMAppleListDEPDevices() ([]fleet.MDMAppleDEPDevice, error) {
+	return m.devices, m.devicesErr
+}
+
+func (m *mockMDMDoctorClient) MDMAppleListProfilesSummaryByTeam() ([]*fleet.MDMAppleConfigProfilesTeamSummary, error) {
+	return m.summary, nil
+}
+
+func TestMDMDoctorChecks(t *testing.T) {
+	now := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	statuses := func(checks []mdmDoctorCheck) []mdmDoctorStatus {
+		var res []mdmDoctorStatus
+		for _, c := range checks {
+			res = append(res, c.Status)
+		}
+		return res
+	}
+
+	client := &mockMDMDoctorClient{
+		appCfg: &fleet.EnrichedAppConfig{AppConfig: fleet.AppConfig{MDM: fleet.MDM{EnabledAndConfigured: false}}},
+	}
+	checks := runMDMDoctorChecks(client, now)
+	require.Len(t, checks, 1)
+	require.Equal(t, mdmDoctorFail, checks[0].Status)
+	require.Contains(t, checks[0].Message, "MDM features aren't turned on")
+
+	// all good
+	client.appCfg.MDM.EnabledAndConfigured = true
+	client.appCfg.License = &fleet.LicenseInfo{Tier: fleet.TierPremium}
+	client.apns = &fleet.AppleMDM{RenewDate: now.Add(365 * 24 * time.Hour)}
+	client.abm = &fleet.AppleBM{OrgName: "Acme", RenewDate: now.Add(365 * 24 * time.Hour)}
+	client.devices = []fleet.MDMAppleDEPDevice{
+		{Device: godep.Device{SerialNumber: "A", ProfileUUID: "p1", ProfileStatus: "pushed"}},
+		{Device: godep.Device{SerialNumber: "B", ProfileUUID: "p1", ProfileStatus: "assigned"}},
+	}
+	client.summary = []*fleet.MDMAppleConfigProfilesTeamSummary{{TeamName: "", Verifying: 2}}
+	checks = runMDMDoctorChecks(client, now)
+	require.Equal(t, []mdmDoctorStatus{mdmDoctorPass, mdmDoctorPass, mdmDoctorPass, mdmDoctorPass, mdmDoctorPass}, statuses(checks))
+	require.Contains(t, checks[3].Message, "2 device(s) have an enrollment profile assigned (1 distinct profile(s))")
+
+	// certificates about to expire, devices without profile and failed profiles
+	client.apns.RenewDate = now.Add(10 * 24 * time.Hour)
+	client.abm.RenewDate = now.Add(10 * 24 * time.Hour)
+	client.devices = append(client.devices, fleet.MDMAppleDEPDevice{Device: godep.Device{SerialNumber: "C", ProfileStatus: "empty"}})
+	client.summary = []*fleet.MDMAppleConfigProfilesTeamSummary{
+		{Failed: 1},
+		{TeamID: ptr.Uint(1), TeamName: "team1", Failed: 3},
+		{TeamID: ptr.Uint(2), TeamName: "team2", Verifying: 1},
+	}
+	checks = runMDMDoctorChecks(client, now)
+	require.Equal(t, []mdmDoctorStatus{mdmDoctorWarn, mdmDoctorPass, mdmDoctorWarn, mdmDoctorWarn, mdmDoctorWarn}, statuses(checks))
+	require.Contains(t, checks[0].Message, "expires on July 11, 2023")
+	require.Contains(t, checks[3].Message, "1 of 3 device(s) have no enrollment profile assigned: C.")
+	require.Contains(t, checks[4].Message, "4 host(s) failed to apply one or more profiles (No team: 1, team1: 3).")
+
+	// expired certificate, SCEP unreachable, broken ABM session
+	client.apns.RenewDate = now.Add(-time.Hour)
+	client.scepErr = errors.New("unexpected status 502")
+	client.abmErr = errors.New("unauthorized")
+	checks = runMDMDoctorChecks(client, now)
+	require.Equal(t, []mdmDoctorStatus{mdmDoctorFail, mdmDoctorFail, mdmDoctorFail, mdmDoctorSkip, mdmDoctorWarn}, statuses(checks))
+	require.Contains(t, checks[1].Message, "unexpected status 502")
+	require.Contains(t, checks[2].Hint, "mdm.apple_bm_server_token")
+
+	// no APNs certificate, no ABM token
+	client.apnsErr = clientNotFoundErr{}
+	client.abmErr = clientNotFoundErr{}
+	client.scepErr = nil
+	checks = runMDMDoctorChecks(client, now)
+	require.Equal(t, []mdmDoctorStatus{mdmDoctorFail, mdmDoctorPass, mdmDoctorSkip, mdmDoctorSkip, mdmDoctorWarn}, statuses(checks))
+	require.Contains(t, checks[0].Message, "no certificate found")
+
+	// ABM requires premium
+	client.appCfg.License = nil
+	checks = runMDMDoctorChecks(client, now)
+	require.Equal(t, mdmDoctorSkip, checks[2].Status)
+	require.Contains(t, checks[2].Message, "requires Fleet Premium")
+}
+
+func TestMDMDoctor(t *testing.T) {
+	_, ds := runServerWithMockedDS(t)
+
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{MDM: fleet.MDM{EnabledAndConfigured: true}}, nil
+	}
+	ds.ListMDMAppleHostsProfilesSummaryByTeamFunc = func(ctx context.Context) ([]*fleet.MDMAppleConfigProfilesTeamSummary, error) {
+		return []*fleet.MDMAppleConfigProfilesTeamSummary{{Failed: 2}}, nil
+	}
+
+	// the test server has no APNs and SCEP certificates.
+	buf, err := runAppNoChecks([]string{"mdm", "doctor"})
+	require.ErrorContains(t, err, "2 MDM check(s) failed")
+	require.Contains(t, buf.String(), "[FAIL] APNs certificate: no certificate found.")
+	require.Contains(t, buf.String(), "[FAIL] SCEP service:")
+	require.Contains(t, buf.String(), "[SKIP] Apple Business Manager: automatic enrollment requires Fleet Premium.")
+	require.Contains(t, buf.String(), "[WARN] Configuration profiles: 2 host(s) failed to apply one or more profiles (No team: 2).")
+}
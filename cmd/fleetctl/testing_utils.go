@@ -51,6 +51,10 @@ func runServerWithMockedDS(t *testing.T, opts ...*service.TestServerOpts) (*http
 	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
 		return &fleet.AppConfig{}, nil
 	}
+	// loaded to report the MDM cron health in the app config
+	ds.ListLatestCompletedCronStatsFunc = func(ctx context.Context, name string, limit int) ([]fleet.CronStats, error) {
+		return nil, nil
+	}
 
 	cachedDS := cached_mysql.New(ds)
 	_, server := service.RunServerForTestsWithDS(t, cachedDS, opts...)
@@ -5,19 +5,28 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/pkg/file"
+	"github.com/fleetdm/fleet/v4/server"
 	"github.com/fleetdm/fleet/v4/server/authz"
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/contexts/logging"
+	"github.com/fleetdm/fleet/v4/server/contexts/publicip"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	apple_mdm "github.com/fleetdm/fleet/v4/server/mdm/apple"
 	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
+	"github.com/fleetdm/fleet/v4/server/mdm/assetcdn"
+	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/sso"
+	"github.com/fleetdm/fleet/v4/server/worker"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/google/uuid"
@@ -80,50 +89,281 @@ func getAppleBMAccountDetail(ctx context.Context, depStorage storage.AllStorage,
 	}, nil
 }
 
-func (svc *Service) MDMAppleDeviceLock(ctx context.Context, hostID uint) error {
+func (svc *Service) MDMAppleDeviceLock(ctx context.Context, hostID uint) (*fleet.HostMDMAppleLockPIN, error) {
 	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
-		return err
+		return nil, err
 	}
 
 	host, err := svc.ds.HostLite(ctx, hostID)
 	if err != nil {
-		return ctxerr.Wrap(ctx, err, "host lite")
+		return nil, ctxerr.Wrap(ctx, err, "host lite")
 	}
 
 	// TODO: define and use right permissions according to the spec.
 	if err := svc.authz.Authorize(ctx, host, fleet.ActionWrite); err != nil {
-		return err
+		return nil, err
 	}
 
-	// TODO: save the pin (first return value) in the database
-	err = svc.mdmAppleCommander.DeviceLock(ctx, []string{host.UUID}, uuid.New().String())
+	cmdUUID := uuid.New().String()
+	pin, err := svc.mdmAppleCommander.DeviceLock(ctx, []string{host.UUID}, cmdUUID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return svc.saveHostLockPIN(ctx, host.UUID, cmdUUID, "DeviceLock", pin)
+}
+
+// saveHostLockPIN stores the PIN of the DeviceLock or EraseDevice command
+// that was sent to the host, so that it can be unlocked afterwards.
+func (svc *Service) saveHostLockPIN(ctx context.Context, hostUUID, cmdUUID, requestType, pin string) (*fleet.HostMDMAppleLockPIN, error) {
+	lockPIN := &fleet.HostMDMAppleLockPIN{
+		HostUUID:    hostUUID,
+		CommandUUID: cmdUUID,
+		RequestType: requestType,
+		PIN:         pin,
+	}
+	if err := svc.ds.SetHostMDMAppleLockPIN(ctx, lockPIN); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "save host lock pin")
+	}
+	return lockPIN, nil
+}
+
+func (svc *Service) HostMDMAppleLockPIN(ctx context.Context, hostID uint) (*fleet.HostMDMAppleLockPIN, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, err
+	}
+
+	host, err := svc.ds.HostLite(ctx, hostID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "host lite")
+	}
+
+	// the PIN is readable by the users that can lock or wipe the host.
+	if err := svc.authz.Authorize(ctx, host, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	pin, err := svc.ds.GetHostMDMAppleLockPIN(ctx, host.UUID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get host lock pin")
+	}
+
+	if err := svc.ds.NewSecurityAuditLogEntry(ctx, fleet.NewSecurityAuditLogEntry(
+		fleet.SecurityAuditActionReadHostUnlockPIN, authz.UserFromContext(ctx), publicip.FromContext(ctx), host,
+	)); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "record host unlock pin read in security audit log")
+	}
+
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), fleet.ActivityTypeReadHostUnlockPIN{
+		HostID:          host.ID,
+		HostDisplayName: host.DisplayName(),
+	}); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "create read host unlock pin activity")
+	}
+	return pin, nil
 }
 
-func (svc *Service) MDMAppleEraseDevice(ctx context.Context, hostID uint) error {
+func (svc *Service) MDMAppleQuarantineHost(ctx context.Context, hostID, teamID uint, caseID string, lock bool) (*fleet.HostQuarantine, error) {
 	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, err
+	}
+
+	host, err := svc.ds.HostLite(ctx, hostID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "host lite")
+	}
+
+	// the host is moved to the quarantine team, the user must be able to write
+	// to both teams.
+	if err := svc.authz.Authorize(ctx, host, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+	if err := svc.authz.Authorize(ctx, &fleet.Host{TeamID: &teamID}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	if caseID == "" {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("case_id", "case_id is required"))
+	}
+	if teamID == 0 {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("team_id", "team_id is required"))
+	}
+	if host.TeamID != nil && *host.TeamID == teamID {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("team_id", "The host is already in the quarantine team."))
+	}
+	tm, err := svc.ds.Team(ctx, teamID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get quarantine team")
+	}
+
+	enrollment, err := svc.ds.GetNanoMDMEnrollment(ctx, host.UUID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get host mdm enrollment")
+	}
+	if enrollment == nil || !enrollment.Enabled {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("id", "The host is not enrolled in Fleet's MDM."))
+	}
+
+	// each step registers how to undo it, so that the changes are rolled back
+	// if a subsequent step fails.
+	var undos []func() error
+	rollback := func(cause error) error {
+		for i := len(undos) - 1; i >= 0; i-- {
+			if err := undos[i](); err != nil {
+				level.Error(svc.logger).Log("msg", "rollback host quarantine", "host_id", host.ID, "err", err)
+			}
+		}
+		return cause
+	}
+
+	created, err := svc.ensureQuarantineProfile(ctx, teamID)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		undos = append(undos, func() error {
+			return svc.ds.DeleteMDMAppleConfigProfileByTeamAndIdentifier(ctx, &teamID, mobileconfig.FleetQuarantinePayloadIdentifier)
+		})
+	}
+
+	if err := svc.ds.AddHostsToTeam(ctx, &teamID, []uint{host.ID}); err != nil {
+		return nil, rollback(ctxerr.Wrap(ctx, err, "move host to quarantine team"))
+	}
+	undos = append(undos, func() error {
+		if err := svc.ds.AddHostsToTeam(ctx, host.TeamID, []uint{host.ID}); err != nil {
+			return err
+		}
+		_, err := worker.BulkSetPendingMDMAppleHostProfiles(ctx, svc.ds, svc.logger, []uint{host.ID}, nil, nil)
 		return err
+	})
+
+	if _, err := worker.BulkSetPendingMDMAppleHostProfiles(ctx, svc.ds, svc.logger, []uint{host.ID}, nil, nil); err != nil {
+		return nil, rollback(ctxerr.Wrap(ctx, err, "bulk set pending host profiles"))
+	}
+
+	q, err := svc.ds.NewHostQuarantine(ctx, &fleet.HostQuarantine{
+		HostID:         host.ID,
+		CaseID:         caseID,
+		TeamID:         teamID,
+		PreviousTeamID: host.TeamID,
+		Locked:         lock,
+	})
+	if err != nil {
+		return nil, rollback(ctxerr.Wrap(ctx, err, "record host quarantine"))
+	}
+	undos = append(undos, func() error { return svc.ds.DeleteHostQuarantine(ctx, q.ID) })
+
+	// locking the host can't be undone, so it is the last step.
+	if lock {
+		cmdUUID := uuid.New().String()
+		pin, err := svc.mdmAppleCommander.DeviceLock(ctx, []string{host.UUID}, cmdUUID)
+		if err != nil {
+			return nil, rollback(ctxerr.Wrap(ctx, err, "lock host"))
+		}
+		if _, err := svc.saveHostLockPIN(ctx, host.UUID, cmdUUID, "DeviceLock", pin); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), &fleet.ActivityTypeQuarantinedHost{
+		HostID:          host.ID,
+		HostDisplayName: host.DisplayName(),
+		CaseID:          caseID,
+		TeamID:          tm.ID,
+		TeamName:        tm.Name,
+		Locked:          lock,
+	}); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "create activity for host quarantine")
+	}
+	return q, nil
+}
+
+// ensureQuarantineProfile creates the quarantine profile for the team if it
+// doesn't have it already, it returns true if the profile was created.
+func (svc *Service) ensureQuarantineProfile(ctx context.Context, teamID uint) (bool, error) {
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return false, ctxerr.Wrap(ctx, err, "get app config")
+	}
+
+	var contents bytes.Buffer
+	params := quarantineProfileOptions{
+		PayloadIdentifier: mobileconfig.FleetQuarantinePayloadIdentifier,
+		ServerURL:         appCfg.ServerSettings.ServerURL,
+	}
+	if err := quarantineProfileTemplate.Execute(&contents, params); err != nil {
+		return false, ctxerr.Wrap(ctx, err, "generate quarantine profile")
+	}
+
+	cp, err := fleet.NewMDMAppleConfigProfile(contents.Bytes(), &teamID)
+	if err != nil {
+		return false, ctxerr.Wrap(ctx, err, "generate quarantine profile")
+	}
+	if _, err := svc.ds.NewMDMAppleConfigProfile(ctx, *cp); err != nil {
+		var existsErr fleet.AlreadyExistsError
+		if errors.As(err, &existsErr) && existsErr.IsExists() {
+			return false, nil
+		}
+		return false, ctxerr.Wrap(ctx, err, "create quarantine profile")
+	}
+	return true, nil
+}
+
+func (svc *Service) MDMAppleEraseDevice(ctx context.Context, hostID uint) (*fleet.HostMDMAppleLockPIN, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, err
 	}
 
 	host, err := svc.ds.HostLite(ctx, hostID)
 	if err != nil {
-		return ctxerr.Wrap(ctx, err, "host lite")
+		return nil, ctxerr.Wrap(ctx, err, "host lite")
 	}
 
 	// TODO: define and use right permissions according to the spec.
 	if err := svc.authz.Authorize(ctx, host, fleet.ActionWrite); err != nil {
-		return err
+		return nil, err
+	}
+
+	if err := svc.checkActivationLockBypassCodeEscrowed(ctx, host.UUID); err != nil {
+		return nil, err
 	}
 
-	// TODO: save the pin (first return value) in the database
-	err = svc.mdmAppleCommander.EraseDevice(ctx, []string{host.UUID}, uuid.New().String())
+	cmdUUID := uuid.New().String()
+	pin, err := svc.mdmAppleCommander.EraseDevice(ctx, []string{host.UUID}, cmdUUID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return svc.saveHostLockPIN(ctx, host.UUID, cmdUUID, "EraseDevice", pin)
+}
+
+// checkActivationLockBypassCodeEscrowed returns a conflict error if the host
+// is supervised and its Activation Lock bypass code is not escrowed, as it
+// could not be activated after it is wiped. In that case, the code is
+// requested again so that the wipe can be retried once the host reports it.
+func (svc *Service) checkActivationLockBypassCodeEscrowed(ctx context.Context, hostUUID string) error {
+	info, err := svc.ds.GetHostMDMCheckinInfo(ctx, hostUUID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get host MDM checkin info")
+	}
+	// only hosts enrolled via DEP are supervised.
+	if !info.InstalledFromDEP {
+		return nil
+	}
+
+	_, err = svc.ds.GetHostMDMActivationLockBypassCode(ctx, hostUUID)
+	switch {
+	case err == nil:
+		return nil
+	case !fleet.IsNotFound(err):
+		return ctxerr.Wrap(ctx, err, "get host activation lock bypass code")
+	}
+
+	if err := svc.mdmAppleCommander.ActivationLockBypassCode(ctx, []string{hostUUID}, uuid.New().String()); err != nil {
+		return ctxerr.Wrap(ctx, err, "request activation lock bypass code")
+	}
+	return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("id",
+		"The Activation Lock bypass code of this host is not escrowed, it could not be activated after it is wiped. "+
+			"Fleet requested the code, try again once the host has reported it.").WithStatus(http.StatusConflict),
+		"activation lock bypass code not escrowed")
 }
 
 func (svc *Service) MDMAppleEnableFileVaultAndEscrow(ctx context.Context, teamID *uint) error {
@@ -155,6 +395,90 @@ func (svc *Service) MDMAppleDisableFileVaultAndEscrow(ctx context.Context, teamI
 	return ctxerr.Wrap(ctx, err, "disabling FileVault")
 }
 
+func (svc *Service) RotateMDMAppleFileVaultKeys(ctx context.Context, teamID, targetID *uint) (int, error) {
+	if targetID != nil {
+		return svc.rotateMDMAppleFileVaultKeysForTarget(ctx, teamID, *targetID)
+	}
+
+	if err := svc.authz.Authorize(ctx, fleet.MDMAppleConfigProfile{TeamID: teamID}, fleet.ActionWrite); err != nil {
+		return 0, ctxerr.Wrap(ctx, err)
+	}
+
+	var teamName *string
+	if teamID != nil && *teamID > 0 {
+		tm, err := svc.ds.Team(ctx, *teamID)
+		if err != nil {
+			return 0, ctxerr.Wrap(ctx, err, "get team")
+		}
+		teamName = &tm.Name
+	} else {
+		teamID = nil
+	}
+
+	n, err := svc.ds.RequestMDMAppleFileVaultKeyRotation(ctx, teamID)
+	if err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "request FileVault key rotation")
+	}
+
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), fleet.ActivityTypeRotatedMacosDiskEncryptionKeys{
+		TeamID:    teamID,
+		TeamName:  teamName,
+		HostCount: n,
+	}); err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "create activity for FileVault key rotation")
+	}
+	return n, nil
+}
+
+func (svc *Service) rotateMDMAppleFileVaultKeysForTarget(ctx context.Context, teamID *uint, targetID uint) (int, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMHostTarget{}, fleet.ActionRead); err != nil {
+		return 0, ctxerr.Wrap(ctx, err)
+	}
+	if teamID != nil {
+		return 0, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("target_id", "only one of team_id or target_id can be provided"))
+	}
+
+	target, err := svc.ds.MDMHostTarget(ctx, targetID)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			return 0, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("target_id", "target does not exist"))
+		}
+		return 0, ctxerr.Wrap(ctx, err, "get mdm host target")
+	}
+
+	// the user must be allowed to rotate the keys of every team the target may
+	// match, a target without teams matches the hosts of all teams so it
+	// requires the global permission.
+	if len(target.TeamIDs) == 0 {
+		if err := svc.authz.Authorize(ctx, fleet.MDMAppleConfigProfile{}, fleet.ActionWrite); err != nil {
+			return 0, ctxerr.Wrap(ctx, err)
+		}
+	}
+	for _, tmID := range target.TeamIDs {
+		var id *uint
+		if tmID > 0 {
+			id = ptr.Uint(tmID)
+		}
+		if err := svc.authz.Authorize(ctx, fleet.MDMAppleConfigProfile{TeamID: id}, fleet.ActionWrite); err != nil {
+			return 0, ctxerr.Wrap(ctx, err)
+		}
+	}
+
+	n, err := svc.ds.RequestMDMAppleFileVaultKeyRotationForTarget(ctx, targetID)
+	if err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "request FileVault key rotation for target")
+	}
+
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), fleet.ActivityTypeRotatedMacosDiskEncryptionKeys{
+		TargetID:   &target.ID,
+		TargetName: &target.Name,
+		HostCount:  n,
+	}); err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "create activity for FileVault key rotation")
+	}
+	return n, nil
+}
+
 func (svc *Service) MDMAppleUploadBootstrapPackage(ctx context.Context, name string, pkg io.Reader, teamID uint) error {
 	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleBootstrapPackage{TeamID: teamID}, fleet.ActionWrite); err != nil {
 		return err
@@ -197,7 +521,14 @@ func (svc *Service) MDMAppleUploadBootstrapPackage(ctx context.Context, name str
 		Sha256: hash.Sum(nil),
 		Bytes:  pkgBuf.Bytes(),
 	}
+	if svc.mdmAssetStore != nil {
+		if err := svc.mdmAssetStore.Put(ctx, fleet.MDMAssetBootstrapPackage, bp.Token, bytes.NewReader(bp.Bytes)); err != nil {
+			return ctxerr.Wrap(ctx, err, "storing bootstrap package")
+		}
+		bp.Bytes = nil
+	}
 	if err := svc.ds.InsertMDMAppleBootstrapPackage(ctx, bp); err != nil {
+		svc.deleteMDMAsset(ctx, fleet.MDMAssetBootstrapPackage, bp.Token)
 		return err
 	}
 
@@ -216,6 +547,22 @@ func (svc *Service) GetMDMAppleBootstrapPackageBytes(ctx context.Context, token
 	if err != nil {
 		return nil, ctxerr.Wrap(ctx, err)
 	}
+	switch {
+	case pkg.UploadID != nil:
+		pkg.Content = apple_mdm.NewBootstrapPackageChunkReader(ctx, svc.ds, *pkg.UploadID, pkg.Size)
+	case pkg.Bytes == nil && svc.mdmAssetStore != nil:
+		pkg.CDNURL, err = svc.mdmAssetCDNURL(ctx, fleet.MDMAssetBootstrapPackage, token)
+		if err != nil {
+			return nil, err
+		}
+		if pkg.CDNURL != "" {
+			break
+		}
+		pkg.Content, pkg.Size, err = svc.mdmAssetStore.Get(ctx, fleet.MDMAssetBootstrapPackage, token)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "retrieving bootstrap package from store")
+		}
+	}
 	return pkg, nil
 }
 
@@ -261,6 +608,7 @@ func (svc *Service) DeleteMDMAppleBootstrapPackage(ctx context.Context, teamID *
 	if err := svc.ds.DeleteMDMAppleBootstrapPackage(ctx, tmID); err != nil {
 		return ctxerr.Wrap(ctx, err, "deleting bootstrap package")
 	}
+	svc.deleteMDMAsset(ctx, fleet.MDMAssetBootstrapPackage, meta.Token)
 
 	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), fleet.ActivityTypeDeletedBootstrapPackage{BootstrapPackageName: meta.Name, TeamID: ptrTeamID, TeamName: ptrTeamName}); err != nil {
 		return ctxerr.Wrap(ctx, err, "create activity for delete bootstrap package")
@@ -294,6 +642,217 @@ func (svc *Service) GetMDMAppleBootstrapPackageSummary(ctx context.Context, team
 	return summary, nil
 }
 
+func (svc *Service) GetMDMAppleBootstrapPackageSignatures(ctx context.Context) (*fleet.MDMAppleBootstrapPackageSignaturesSummary, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleBootstrapPackage{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	pkgs, err := svc.ds.ListMDMAppleBootstrapPackages(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "listing bootstrap packages")
+	}
+
+	summary := &fleet.MDMAppleBootstrapPackageSignaturesSummary{Packages: pkgs}
+	for _, pkg := range pkgs {
+		switch pkg.SignatureStatus {
+		case fleet.MDMAppleBootstrapPackageSignatureValid:
+			summary.Valid++
+		case fleet.MDMAppleBootstrapPackageSignatureInvalid:
+			summary.Invalid++
+		default:
+			summary.Unverified++
+		}
+	}
+	return summary, nil
+}
+
+func (svc *Service) GetMDMAppleOSUpdatesSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleOSUpdatesSummary, error) {
+	if err := svc.authz.Authorize(ctx, fleet.MDMAppleConfigProfile{TeamID: teamID}, fleet.ActionRead); err != nil {
+		return &fleet.MDMAppleOSUpdatesSummary{}, err
+	}
+
+	var tmID uint
+	if teamID != nil {
+		tmID = *teamID
+		if _, err := svc.ds.Team(ctx, tmID); err != nil {
+			return &fleet.MDMAppleOSUpdatesSummary{}, err
+		}
+	}
+
+	summary, err := svc.ds.GetMDMAppleOSUpdatesSummary(ctx, tmID)
+	if err != nil {
+		return &fleet.MDMAppleOSUpdatesSummary{}, ctxerr.Wrap(ctx, err, "getting os updates summary")
+	}
+
+	return summary, nil
+}
+
+func (svc *Service) InitiateMDMAppleBootstrapPackageUpload(ctx context.Context, teamID uint, name string, size int64, checksum []byte) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleBootstrapPackage{TeamID: teamID}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	invalid := &fleet.InvalidArgumentError{}
+	if name == "" {
+		invalid.Append("name", "name is required")
+	}
+	if size <= 0 {
+		invalid.Append("size", "size must be greater than zero")
+	}
+	if len(checksum) != sha256.Size {
+		invalid.Append("sha256", "sha256 must be a hex-encoded SHA-256 checksum")
+	}
+	if invalid.HasErrors() {
+		return nil, ctxerr.Wrap(ctx, invalid)
+	}
+
+	if teamID >= 1 {
+		if _, err := svc.teamByIDOrName(ctx, &teamID, nil); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "get team for bootstrap package upload")
+		}
+	}
+
+	// fail early if the team already has a bootstrap package, instead of after
+	// the whole package has been uploaded.
+	if _, err := svc.ds.GetMDMAppleBootstrapPackageMeta(ctx, teamID); err == nil {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("team_id", "a bootstrap package already exists for this team, delete it before uploading a new one"))
+	} else if !fleet.IsNotFound(err) {
+		return nil, ctxerr.Wrap(ctx, err, "fetching bootstrap package metadata")
+	}
+
+	upload, err := svc.ds.NewMDMAppleBootstrapPackageUpload(ctx, &fleet.MDMAppleBootstrapPackageUpload{
+		Token:  uuid.New().String(),
+		TeamID: teamID,
+		Name:   name,
+		Size:   size,
+		Sha256: checksum,
+	})
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "creating bootstrap package upload")
+	}
+	return upload, nil
+}
+
+func (svc *Service) GetMDMAppleBootstrapPackageUpload(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+	return svc.authorizedBootstrapPackageUpload(ctx, token)
+}
+
+func (svc *Service) AppendMDMAppleBootstrapPackageUpload(ctx context.Context, token string, offset int64, chunk []byte) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+	upload, err := svc.authorizedBootstrapPackageUpload(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset+int64(len(chunk)) > upload.Size {
+		return nil, &fleet.BadRequestError{
+			Message: fmt.Sprintf("chunk exceeds the size of the package, expected %d bytes in total", upload.Size),
+		}
+	}
+
+	upload, err = svc.ds.AppendMDMAppleBootstrapPackageUploadChunk(ctx, token, offset, chunk)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "appending bootstrap package upload chunk")
+	}
+	return upload, nil
+}
+
+func (svc *Service) CommitMDMAppleBootstrapPackageUpload(ctx context.Context, token string) error {
+	upload, err := svc.authorizedBootstrapPackageUpload(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	if upload.Offset != upload.Size {
+		return &fleet.BadRequestError{
+			Message: fmt.Sprintf("upload is incomplete, received %d of %d bytes", upload.Offset, upload.Size),
+		}
+	}
+
+	var ptrTeamName *string
+	var ptrTeamId *uint
+	if upload.TeamID >= 1 {
+		tm, err := svc.teamByIDOrName(ctx, &upload.TeamID, nil)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "get team name for upload bootstrap package activity details")
+		}
+		ptrTeamName = &tm.Name
+		ptrTeamId = &upload.TeamID
+	}
+
+	// stream the chunks to verify the package, only one chunk is kept in
+	// memory at any given time.
+	hash := sha256.New()
+	pkg := io.TeeReader(apple_mdm.NewBootstrapPackageChunkReader(ctx, svc.ds, upload.ID, upload.Size), hash)
+	if err := file.CheckPKGSignatureStream(pkg); err != nil {
+		msg := "invalid package"
+		if errors.Is(err, file.ErrInvalidType) || errors.Is(err, file.ErrNotSigned) {
+			msg = err.Error()
+		}
+
+		return &fleet.BadRequestError{
+			Message:     msg,
+			InternalErr: err,
+		}
+	}
+	if _, err := io.Copy(io.Discard, pkg); err != nil {
+		return ctxerr.Wrap(ctx, err, "computing bootstrap package checksum")
+	}
+	if !bytes.Equal(hash.Sum(nil), upload.Sha256) {
+		return &fleet.BadRequestError{
+			Message: "the checksum of the uploaded package does not match the provided sha256 checksum",
+		}
+	}
+
+	pkgToken := uuid.New().String()
+	if svc.mdmAssetStore != nil {
+		// move the package to the store, the upload session is not referenced
+		// by the package and is deleted by the cleanup cron job.
+		content := apple_mdm.NewBootstrapPackageChunkReader(ctx, svc.ds, upload.ID, upload.Size)
+		if err := svc.mdmAssetStore.Put(ctx, fleet.MDMAssetBootstrapPackage, pkgToken, content); err != nil {
+			return ctxerr.Wrap(ctx, err, "storing bootstrap package")
+		}
+		bp := &fleet.MDMAppleBootstrapPackage{
+			TeamID: upload.TeamID,
+			Name:   upload.Name,
+			Token:  pkgToken,
+			Sha256: upload.Sha256,
+		}
+		if err := svc.ds.InsertMDMAppleBootstrapPackage(ctx, bp); err != nil {
+			svc.deleteMDMAsset(ctx, fleet.MDMAssetBootstrapPackage, pkgToken)
+			return ctxerr.Wrap(ctx, err, "inserting bootstrap package")
+		}
+	} else if err := svc.ds.CommitMDMAppleBootstrapPackageUpload(ctx, token, pkgToken); err != nil {
+		return ctxerr.Wrap(ctx, err, "committing bootstrap package upload")
+	}
+
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), fleet.ActivityTypeAddedBootstrapPackage{BootstrapPackageName: upload.Name, TeamID: ptrTeamId, TeamName: ptrTeamName}); err != nil {
+		return ctxerr.Wrap(ctx, err, "create activity for upload bootstrap package")
+	}
+
+	return nil
+}
+
+// authorizedBootstrapPackageUpload returns the bootstrap package upload
+// session identified by token if the user is authorized to write the
+// bootstrap package of the session's team.
+func (svc *Service) authorizedBootstrapPackageUpload(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+	// first we perform a basic authz check
+	if err := svc.authz.Authorize(ctx, &fleet.Team{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	upload, err := svc.ds.GetMDMAppleBootstrapPackageUpload(ctx, token)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "fetching bootstrap package upload")
+	}
+
+	// now we can do a specific authz check based on the team of the upload
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleBootstrapPackage{TeamID: upload.TeamID}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
 func (svc *Service) MDMAppleCreateEULA(ctx context.Context, name string, f io.ReadSeeker) error {
 	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleEULA{}, fleet.ActionWrite); err != nil {
 		return err
@@ -321,13 +880,25 @@ func (svc *Service) MDMAppleCreateEULA(ctx context.Context, name string, f io.Re
 		return ctxerr.Wrap(ctx, err, "reading EULA bytes")
 	}
 
+	checksum := sha256.Sum256(bytes)
 	eula := &fleet.MDMAppleEULA{
-		Name:  name,
-		Token: uuid.New().String(),
-		Bytes: bytes,
+		Name:   name,
+		Token:  uuid.New().String(),
+		Bytes:  bytes,
+		Sha256: checksum[:],
+	}
+	if svc.mdmAssetStore != nil {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return ctxerr.Wrap(ctx, err, "seeking start of PDF file")
+		}
+		if err := svc.mdmAssetStore.Put(ctx, fleet.MDMAssetEULA, eula.Token, f); err != nil {
+			return ctxerr.Wrap(ctx, err, "storing EULA")
+		}
+		eula.Bytes = nil
 	}
 
 	if err := svc.ds.MDMAppleInsertEULA(ctx, eula); err != nil {
+		svc.deleteMDMAsset(ctx, fleet.MDMAssetEULA, eula.Token)
 		return ctxerr.Wrap(ctx, err, "inserting EULA")
 	}
 
@@ -339,7 +910,24 @@ func (svc *Service) MDMAppleGetEULABytes(ctx context.Context, token string) (*fl
 	// request.
 	svc.authz.SkipAuthorization(ctx)
 
-	return svc.ds.MDMAppleGetEULABytes(ctx, token)
+	eula, err := svc.ds.MDMAppleGetEULABytes(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if eula.Bytes == nil && svc.mdmAssetStore != nil {
+		eula.CDNURL, err = svc.mdmAssetCDNURL(ctx, fleet.MDMAssetEULA, token)
+		if err != nil {
+			return nil, err
+		}
+		if eula.CDNURL != "" {
+			return eula, nil
+		}
+		eula.Content, eula.Size, err = svc.mdmAssetStore.Get(ctx, fleet.MDMAssetEULA, token)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "retrieving EULA from store")
+		}
+	}
+	return eula, nil
 }
 
 func (svc *Service) MDMAppleDeleteEULA(ctx context.Context, token string) error {
@@ -350,10 +938,82 @@ func (svc *Service) MDMAppleDeleteEULA(ctx context.Context, token string) error
 	if err := svc.ds.MDMAppleDeleteEULA(ctx, token); err != nil {
 		return ctxerr.Wrap(ctx, err, "deleting EULA")
 	}
+	svc.deleteMDMAsset(ctx, fleet.MDMAssetEULA, token)
 
 	return nil
 }
 
+// mdmAppleDeleteEULA deletes the EULA if there is one, it is used when the
+// EULA is cleared from the app config via fleetctl apply.
+func (svc *Service) mdmAppleDeleteEULA(ctx context.Context) error {
+	eula, err := svc.ds.MDMAppleGetEULAMetadata(ctx)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			return nil
+		}
+		return ctxerr.Wrap(ctx, err, "get EULA metadata")
+	}
+	return svc.MDMAppleDeleteEULA(ctx, eula.Token)
+}
+
+// deleteMDMAsset deletes an asset from the MDM asset store, if one is
+// configured. Failures are only logged, as the asset is not referenced anymore
+// and is eventually deleted by the cleanup cron job.
+func (svc *Service) deleteMDMAsset(ctx context.Context, kind fleet.MDMAssetKind, token string) {
+	if svc.mdmAssetStore == nil {
+		return
+	}
+	if err := svc.mdmAssetStore.Delete(ctx, kind, token); err != nil {
+		level.Info(svc.logger).Log("err", err, "msg", "deleting mdm asset from store", "kind", kind)
+	}
+}
+
+// mdmAssetCDNURL returns the signed URL of the asset on the MDM assets CDN,
+// or an empty string if there is no MDM asset store or the CDN is not
+// enabled.
+func (svc *Service) mdmAssetCDNURL(ctx context.Context, kind fleet.MDMAssetKind, token string) (string, error) {
+	if svc.mdmAssetStore == nil {
+		return "", nil
+	}
+	appConfig, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return "", ctxerr.Wrap(ctx, err, "get app config")
+	}
+	signer, err := assetcdn.New(appConfig.MDM.AssetCDN)
+	if err != nil {
+		return "", ctxerr.Wrap(ctx, err, "create MDM assets CDN signer")
+	}
+	if signer == nil {
+		return "", nil
+	}
+	signedURL, err := signer.SignURL(svc.mdmAssetStore.Path(kind, token), time.Now())
+	if err != nil {
+		return "", ctxerr.Wrap(ctx, err, "sign MDM asset CDN URL")
+	}
+	return signedURL, nil
+}
+
+func (svc *Service) MDMAppleEnrollmentProfileCDNURL(ctx context.Context, profile []byte) (string, error) {
+	// skipauth: the caller already authorized the download of the profile.
+	svc.authz.SkipAuthorization(ctx)
+
+	// the profile is generated on each request, it is stored under the hash of
+	// its contents so that the downloads of the same profile share the same
+	// asset.
+	sum := sha256.Sum256(profile)
+	token := hex.EncodeToString(sum[:])
+	cdnURL, err := svc.mdmAssetCDNURL(ctx, fleet.MDMAssetEnrollmentProfile, token)
+	if err != nil || cdnURL == "" {
+		return "", err
+	}
+	// storing it again also refreshes its modification time, so that it is not
+	// deleted by the cleanup cron job while it is in use.
+	if err := svc.mdmAssetStore.Put(ctx, fleet.MDMAssetEnrollmentProfile, token, bytes.NewReader(profile)); err != nil {
+		return "", ctxerr.Wrap(ctx, err, "storing enrollment profile")
+	}
+	return cdnURL, nil
+}
+
 func (svc *Service) MDMAppleGetEULAMetadata(ctx context.Context) (*fleet.MDMAppleEULA, error) {
 	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleEULA{}, fleet.ActionRead); err != nil {
 		return nil, err
@@ -387,6 +1047,11 @@ func (svc *Service) SetOrUpdateMDMAppleSetupAssistant(ctx context.Context, asst
 			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("profile", msg))
 		}
 	}
+	// the variables are resolved when the profile is registered with Apple, but
+	// only the supported ones are accepted.
+	if err := apple_mdm.ValidateSetupAssistantVariables(asst.Profile); err != nil {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("profile", "Couldn’t edit macos_setup_assistant. The automatic enrollment profile includes "+err.Error()+"."))
+	}
 	// TODO(mna): svc.depService.RegisterProfileWithAppleDEPServer()
 
 	// must read the existing setup assistant first to detect if it did change
@@ -466,6 +1131,95 @@ func (svc *Service) DeleteMDMAppleSetupAssistant(ctx context.Context, teamID *ui
 	return nil
 }
 
+// authorizeHostDEPProfileOverride authorizes the action on the host and
+// returns it.
+func (svc *Service) authorizeHostDEPProfileOverride(ctx context.Context, hostID uint, action string) (*fleet.Host, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, err
+	}
+
+	host, err := svc.ds.HostLite(ctx, hostID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "host lite")
+	}
+
+	if err := svc.authz.Authorize(ctx, host, action); err != nil {
+		return nil, err
+	}
+	return host, nil
+}
+
+func (svc *Service) GetHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint) (*fleet.HostMDMAppleDEPProfileOverride, error) {
+	if _, err := svc.authorizeHostDEPProfileOverride(ctx, hostID, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	override, err := svc.ds.GetHostMDMAppleDEPProfileOverride(ctx, hostID)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get host dep profile override")
+	}
+	return override, nil
+}
+
+func (svc *Service) SetHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint, profile json.RawMessage) (*fleet.HostMDMAppleDEPProfileOverride, error) {
+	host, err := svc.authorizeHostDEPProfileOverride(ctx, hostID, fleet.ActionWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	// the override is assigned by serial number, so the host must be a device
+	// assigned to Fleet in Apple Business Manager.
+	if host.Platform != "darwin" {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("dep_profile", "Couldn’t edit the DEP profile. The host isn’t a macOS host."))
+	}
+	if _, err := svc.ds.GetHostMDMAppleDEPDevice(ctx, hostID); err != nil {
+		if fleet.IsNotFound(err) {
+			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("dep_profile", "Couldn’t edit the DEP profile. The host isn’t assigned to Fleet in Apple Business Manager."))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get host dep device")
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(profile, &m); err != nil || m == nil {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("dep_profile", "Couldn’t edit the DEP profile. The profile must be a JSON object."))
+	}
+	for _, k := range []string{"configuration_web_url", "await_device_configured", "url"} {
+		if _, ok := m[k]; ok {
+			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("dep_profile", fmt.Sprintf("Couldn’t edit the DEP profile. The automatic enrollment profile can’t include %s.", k)))
+		}
+	}
+	if err := apple_mdm.ValidateSetupAssistantVariables(profile); err != nil {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("dep_profile", "Couldn’t edit the DEP profile. The automatic enrollment profile includes "+err.Error()+"."))
+	}
+
+	// the profile is registered with Apple and assigned to the device the next
+	// time the DEP assigner runs.
+	if err := svc.ds.SetHostMDMAppleDEPProfileOverride(ctx, hostID, &profile); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "set host dep profile override")
+	}
+
+	override, err := svc.ds.GetHostMDMAppleDEPProfileOverride(ctx, hostID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get host dep profile override")
+	}
+	return override, nil
+}
+
+func (svc *Service) DeleteHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint) error {
+	if _, err := svc.authorizeHostDEPProfileOverride(ctx, hostID, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	// the default profile is assigned back the next time the DEP assigner runs.
+	if err := svc.ds.SetHostMDMAppleDEPProfileOverride(ctx, hostID, nil); err != nil {
+		return ctxerr.Wrap(ctx, err, "delete host dep profile override")
+	}
+	return nil
+}
+
 func (svc *Service) InitiateMDMAppleSSO(ctx context.Context) (string, error) {
 	// skipauth: User context does not yet exist. Unauthenticated users may
 	// initiate SSO.
@@ -509,6 +1263,10 @@ func (svc *Service) InitiateMDMAppleSSO(ctx context.Context) (string, error) {
 
 }
 
+// mdmAppleSSOEnrollmentTokenExpiration is the time the end user has to
+// download the enrollment profile after authenticating with the IdP.
+const mdmAppleSSOEnrollmentTokenExpiration = 15 * time.Minute
+
 func (svc *Service) InitiateMDMAppleSSOCallback(ctx context.Context, auth fleet.Auth) (string, error) {
 	// skipauth: User context does not yet exist. Unauthenticated users may
 	// hit the SSO callback.
@@ -538,6 +1296,14 @@ func (svc *Service) InitiateMDMAppleSSOCallback(ctx context.Context, auth fleet.
 		return "", ctxerr.Wrap(ctx, err, "validating sso response")
 	}
 
+	// record the end user's account, the enrollment reference links it to the
+	// host when it checks in.
+	idpAcc := mdmIdPAccountFromSSO(auth, appConfig.MDM.EndUserAuthentication.AttributeMapping)
+	idpAcc.UUID = uuid.New().String()
+	if err := svc.ds.InsertMDMIdPAccount(ctx, idpAcc); err != nil {
+		return "", ctxerr.Wrap(ctx, err, "saving account data from IdP")
+	}
+
 	eula, err := svc.ds.MDMAppleGetEULAMetadata(ctx)
 	if err != nil && !fleet.IsNotFound(err) {
 		return "", ctxerr.Wrap(ctx, err, "getting EULA metadata")
@@ -552,7 +1318,25 @@ func (svc *Service) InitiateMDMAppleSSOCallback(ctx context.Context, auth fleet.
 		return "", ctxerr.Wrap(ctx, err, "missing profile")
 	}
 
-	q := url.Values{"profile_token": {depProf.Token}}
+	// the end user gets a short-lived, single-use token bound to the account
+	// instead of the token of the automatic enrollment profile, which never
+	// expires.
+	token, err := server.GenerateRandomText(24)
+	if err != nil {
+		return "", ctxerr.Wrap(ctx, err, "generate sso enrollment token")
+	}
+	if _, err := svc.ds.NewMDMAppleSSOEnrollmentToken(ctx, &fleet.MDMAppleSSOEnrollmentToken{
+		Token:          token,
+		IdPAccountUUID: idpAcc.UUID,
+		ExpiresAt:      svc.clock.Now().Add(mdmAppleSSOEnrollmentTokenExpiration).UTC(),
+	}); err != nil {
+		return "", ctxerr.Wrap(ctx, err, "saving sso enrollment token")
+	}
+
+	q := url.Values{
+		"profile_token":              {token},
+		apple_mdm.EnrollReferenceKey: {idpAcc.UUID},
+	}
 	if eula != nil {
 		q.Add("eula_token", eula.Token)
 	}
@@ -560,6 +1344,62 @@ func (svc *Service) InitiateMDMAppleSSOCallback(ctx context.Context, auth fleet.
 	return appConfig.ServerSettings.ServerURL + "/mdm/sso/callback?" + q.Encode(), nil
 }
 
+func (svc *Service) GetMDMAppleSSOEndUserStrings(ctx context.Context, auth fleet.Auth) (*fleet.MDMEndUserStrings, error) {
+	// skipauth: The strings are rendered to the end users, on pages that
+	// aren't authenticated.
+	svc.authz.SkipAuthorization(ctx)
+
+	appConfig, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get app config")
+	}
+
+	// the SAML assertion may be invalid, it is only used to pick the team of
+	// the IdP team rules whose strings are rendered.
+	var username string
+	var groups []string
+	if auth != nil {
+		acc := mdmIdPAccountFromSSO(auth, appConfig.MDM.EndUserAuthentication.AttributeMapping)
+		username, groups = acc.Username, acc.Groups
+	}
+	teamID, err := apple_mdm.EnrollmentTeamID(ctx, svc.ds, appConfig, username, groups)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get enrollment team")
+	}
+	return svc.Service.GetMDMEndUserStrings(ctx, teamID)
+}
+
+// mdmIdPAccountFromSSO builds the account of the end user from the SAML
+// assertion, using the configured attribute mapping.
+func mdmIdPAccountFromSSO(auth fleet.Auth, mapping fleet.MDMSSOAttributeMapping) *fleet.MDMIdPAccount {
+	acc := &fleet.MDMIdPAccount{
+		Username: auth.UserID(),
+		FullName: auth.UserDisplayName(),
+		// no local account password is derived during SSO, but the columns
+		// are required.
+		SaltedSHA512PBKDF2Dictionary: fleet.SaltedSHA512PBKDF2Dictionary{
+			Salt:    []byte{},
+			Entropy: []byte{},
+		},
+	}
+
+	for _, attr := range auth.AssertionAttributes() {
+		switch {
+		case len(attr.Values) == 0:
+			continue
+		case mapping.Username != "" && attr.Name == mapping.Username:
+			acc.Username = attr.Values[0].Value
+		case mapping.FullName != "" && attr.Name == mapping.FullName:
+			acc.FullName = attr.Values[0].Value
+		case mapping.Groups != "" && attr.Name == mapping.Groups:
+			for _, v := range attr.Values {
+				acc.Groups = append(acc.Groups, v.Value)
+			}
+		}
+	}
+	return acc
+}
+
 func (svc *Service) mdmAppleSyncDEPProfile(ctx context.Context) error {
 	depProf, err := svc.getAutomaticEnrollmentProfile(ctx)
 	if err != nil {
@@ -88,3 +88,59 @@ var fileVaultProfileTemplate = template.Must(template.New("").Option("missingkey
 	<integer>1</integer>
 </dict>
 </plist>`))
+
+type quarantineProfileOptions struct {
+	PayloadIdentifier string
+	ServerURL         string
+}
+
+// quarantineProfileTemplate restricts the network access of the host to the
+// Fleet server, via a built-in web content filter.
+var quarantineProfileTemplate = template.Must(template.New("").Option("missingkey=error").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>AutoFilterEnabled</key>
+			<false/>
+			<key>FilterBrowsers</key>
+			<true/>
+			<key>FilterSockets</key>
+			<true/>
+			<key>FilterType</key>
+			<string>BuiltIn</string>
+			<key>PayloadDisplayName</key>
+			<string>Web Content Filter</string>
+			<key>PayloadIdentifier</key>
+			<string>com.apple.webcontent-filter.9C3C3F7B-5E06-4C11-9A54-2B6A0C3B6D41</string>
+			<key>PayloadType</key>
+			<string>com.apple.webcontent-filter</string>
+			<key>PayloadUUID</key>
+			<string>9C3C3F7B-5E06-4C11-9A54-2B6A0C3B6D41</string>
+			<key>PayloadVersion</key>
+			<integer>1</integer>
+			<key>WhitelistedBookmarks</key>
+			<array>
+				<dict>
+					<key>Title</key>
+					<string>Fleet</string>
+					<key>URL</key>
+					<string>{{ html .ServerURL }}</string>
+				</dict>
+			</array>
+		</dict>
+	</array>
+	<key>PayloadDisplayName</key>
+	<string>Fleet quarantine network restrictions</string>
+	<key>PayloadIdentifier</key>
+	<string>{{ .PayloadIdentifier }}</string>
+	<key>PayloadType</key>
+	<string>Configuration</string>
+	<key>PayloadUUID</key>
+	<string>0F6E2F0A-8C1B-4C61-8E3B-6A9B1D0C7E52</string>
+	<key>PayloadVersion</key>
+	<integer>1</integer>
+</dict>
+</plist>`))
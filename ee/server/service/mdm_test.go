@@ -1,16 +1,25 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"io"
+	"net/http"
+	"os"
 	"strings"
 	"testing"
 
+	"github.com/fleetdm/fleet/v4/server/authz"
 	"github.com/fleetdm/fleet/v4/server/config"
+	"github.com/fleetdm/fleet/v4/server/contexts/viewer"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
 	"github.com/fleetdm/fleet/v4/server/mock"
+	mdmassets_mock "github.com/fleetdm/fleet/v4/server/mock/mdmassets"
 	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/fleetdm/fleet/v4/server/test"
+	kitlog "github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/require"
 )
 
@@ -136,6 +145,563 @@ b1xn1jGQd/o0xFf9ojpDNy6vNojidQGHh6E3h0GYvxbnQmVNq5U=
 -----END RSA TESTING KEY-----`)
 )
 
+// bypassCodeCommander records the Activation Lock bypass code commands.
+type bypassCodeCommander struct {
+	fleet.MDMAppleCommandIssuer
+	hostUUIDs []string
+}
+
+func (c *bypassCodeCommander) ActivationLockBypassCode(ctx context.Context, hostUUIDs []string, uuid string) error {
+	c.hostUUIDs = append(c.hostUUIDs, hostUUIDs...)
+	return nil
+}
+
+func TestCheckActivationLockBypassCodeEscrowed(t *testing.T) {
+	ctx := context.Background()
+	ds, svc := setup(t)
+	cmdr := &bypassCodeCommander{}
+	svc.mdmAppleCommander = cmdr
+
+	installedFromDEP := false
+	ds.GetHostMDMCheckinInfoFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		return &fleet.HostMDMCheckinInfo{InstalledFromDEP: installedFromDEP}, nil
+	}
+	escrowed := false
+	ds.GetHostMDMActivationLockBypassCodeFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMActivationLockBypassCode, error) {
+		if !escrowed {
+			return nil, &notFoundError{}
+		}
+		return &fleet.HostMDMActivationLockBypassCode{HostUUID: hostUUID, BypassCode: "AAAA-BBBB"}, nil
+	}
+
+	// hosts not enrolled via DEP are not supervised
+	require.NoError(t, svc.checkActivationLockBypassCodeEscrowed(ctx, "uuid-1"))
+	require.False(t, ds.GetHostMDMActivationLockBypassCodeFuncInvoked)
+
+	// supervised host without a code, the code is requested again
+	installedFromDEP = true
+	err := svc.checkActivationLockBypassCodeEscrowed(ctx, "uuid-1")
+	require.ErrorContains(t, err, "The Activation Lock bypass code of this host is not escrowed")
+	var statusErr interface{ Status() int }
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, http.StatusConflict, statusErr.Status())
+	require.Equal(t, []string{"uuid-1"}, cmdr.hostUUIDs)
+
+	// supervised host with a code
+	escrowed = true
+	require.NoError(t, svc.checkActivationLockBypassCodeEscrowed(ctx, "uuid-1"))
+	require.Len(t, cmdr.hostUUIDs, 1)
+}
+
 // prevent static analysis tools from raising issues due to detection of
 // private key in code.
 func testingKey(s string) string { return strings.ReplaceAll(s, "TESTING KEY", "PRIVATE KEY") }
+
+type alreadyExistsError struct{}
+
+func (e *alreadyExistsError) Error() string  { return "already exists" }
+func (e *alreadyExistsError) IsExists() bool { return true }
+
+// lockCommander records the Device Lock commands.
+type lockCommander struct {
+	fleet.MDMAppleCommandIssuer
+	err       error
+	hostUUIDs []string
+}
+
+func (c *lockCommander) DeviceLock(ctx context.Context, hostUUIDs []string, uuid string) (string, error) {
+	c.hostUUIDs = append(c.hostUUIDs, hostUUIDs...)
+	return "123456", c.err
+}
+
+func TestMDMAppleQuarantineHost(t *testing.T) {
+	authorizer, err := authz.NewAuthorizer()
+	require.NoError(t, err)
+	ctx := viewer.NewContext(context.Background(), viewer.Viewer{User: test.UserAdmin})
+
+	const quarantineTeamID uint = 2
+	setupQuarantine := func(t *testing.T) (*mock.Store, *Service, *lockCommander) {
+		ds, svc := setup(t)
+		cmdr := &lockCommander{}
+		svc.authz = authorizer
+		svc.logger = kitlog.NewNopLogger()
+		svc.mdmAppleCommander = cmdr
+
+		ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
+			return &fleet.Host{ID: id, UUID: "uuid-1", Hostname: "host1", TeamID: ptr.Uint(1)}, nil
+		}
+		ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+			return &fleet.Team{ID: tid, Name: "quarantine"}, nil
+		}
+		ds.GetNanoMDMEnrollmentFunc = func(ctx context.Context, id string) (*fleet.NanoEnrollment, error) {
+			return &fleet.NanoEnrollment{ID: id, Enabled: true}, nil
+		}
+		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+			return &fleet.AppConfig{ServerSettings: fleet.ServerSettings{ServerURL: "https://example.com"}}, nil
+		}
+		ds.NewMDMAppleConfigProfileFunc = func(ctx context.Context, p fleet.MDMAppleConfigProfile) (*fleet.MDMAppleConfigProfile, error) {
+			require.Equal(t, ptr.Uint(quarantineTeamID), p.TeamID)
+			require.Equal(t, mobileconfig.FleetQuarantinePayloadIdentifier, p.Identifier)
+			require.Contains(t, string(p.Mobileconfig), "https://example.com")
+			return &p, nil
+		}
+		ds.DeleteMDMAppleConfigProfileByTeamAndIdentifierFunc = func(ctx context.Context, teamID *uint, profileIdentifier string) error {
+			return nil
+		}
+		ds.AddHostsToTeamFunc = func(ctx context.Context, teamID *uint, hostIDs []uint) error {
+			return nil
+		}
+		ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, limit int) (int, error) {
+			return 1, nil
+		}
+		ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, hostUUIDs []string) error {
+			return nil
+		}
+		ds.NewHostQuarantineFunc = func(ctx context.Context, q *fleet.HostQuarantine) (*fleet.HostQuarantine, error) {
+			q.ID = 1
+			return q, nil
+		}
+		ds.DeleteHostQuarantineFunc = func(ctx context.Context, id uint) error {
+			return nil
+		}
+		ds.SetHostMDMAppleLockPINFunc = func(ctx context.Context, pin *fleet.HostMDMAppleLockPIN) error {
+			require.Equal(t, "uuid-1", pin.HostUUID)
+			require.Equal(t, "DeviceLock", pin.RequestType)
+			require.Equal(t, "123456", pin.PIN)
+			return nil
+		}
+		ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+			return nil
+		}
+		return ds, svc, cmdr
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		ds, svc, cmdr := setupQuarantine(t)
+		q, err := svc.MDMAppleQuarantineHost(ctx, 1, quarantineTeamID, "case-1", true)
+		require.NoError(t, err)
+		require.Equal(t, "case-1", q.CaseID)
+		require.Equal(t, quarantineTeamID, q.TeamID)
+		require.Equal(t, ptr.Uint(1), q.PreviousTeamID)
+		require.True(t, q.Locked)
+		require.Equal(t, []string{"uuid-1"}, cmdr.hostUUIDs)
+		require.True(t, ds.SetHostMDMAppleLockPINFuncInvoked)
+		require.True(t, ds.NewActivityFuncInvoked)
+		require.False(t, ds.DeleteHostQuarantineFuncInvoked)
+		require.False(t, ds.DeleteMDMAppleConfigProfileByTeamAndIdentifierFuncInvoked)
+	})
+
+	t.Run("profile already exists and no lock", func(t *testing.T) {
+		ds, svc, cmdr := setupQuarantine(t)
+		ds.NewMDMAppleConfigProfileFunc = func(ctx context.Context, p fleet.MDMAppleConfigProfile) (*fleet.MDMAppleConfigProfile, error) {
+			return nil, &alreadyExistsError{}
+		}
+		q, err := svc.MDMAppleQuarantineHost(ctx, 1, quarantineTeamID, "case-1", false)
+		require.NoError(t, err)
+		require.False(t, q.Locked)
+		require.Empty(t, cmdr.hostUUIDs)
+	})
+
+	t.Run("rollback if the lock fails", func(t *testing.T) {
+		ds, svc, cmdr := setupQuarantine(t)
+		var hostTeamID *uint
+		ds.AddHostsToTeamFunc = func(ctx context.Context, teamID *uint, hostIDs []uint) error {
+			hostTeamID = teamID
+			return nil
+		}
+		cmdr.err = errors.New("lock failed")
+		_, err := svc.MDMAppleQuarantineHost(ctx, 1, quarantineTeamID, "case-1", true)
+		require.ErrorIs(t, err, cmdr.err)
+		require.Equal(t, ptr.Uint(1), hostTeamID)
+		require.True(t, ds.DeleteHostQuarantineFuncInvoked)
+		require.True(t, ds.DeleteMDMAppleConfigProfileByTeamAndIdentifierFuncInvoked)
+		require.False(t, ds.NewActivityFuncInvoked)
+	})
+
+	t.Run("rollback if the quarantine can't be recorded", func(t *testing.T) {
+		ds, svc, cmdr := setupQuarantine(t)
+		var hostTeamID *uint
+		ds.AddHostsToTeamFunc = func(ctx context.Context, teamID *uint, hostIDs []uint) error {
+			hostTeamID = teamID
+			return nil
+		}
+		testErr := errors.New("test")
+		ds.NewHostQuarantineFunc = func(ctx context.Context, q *fleet.HostQuarantine) (*fleet.HostQuarantine, error) {
+			return nil, testErr
+		}
+		_, err := svc.MDMAppleQuarantineHost(ctx, 1, quarantineTeamID, "case-1", true)
+		require.ErrorIs(t, err, testErr)
+		require.Equal(t, ptr.Uint(1), hostTeamID)
+		require.False(t, ds.DeleteHostQuarantineFuncInvoked)
+		require.True(t, ds.DeleteMDMAppleConfigProfileByTeamAndIdentifierFuncInvoked)
+		require.Empty(t, cmdr.hostUUIDs)
+	})
+
+	t.Run("host not enrolled", func(t *testing.T) {
+		ds, svc, _ := setupQuarantine(t)
+		ds.GetNanoMDMEnrollmentFunc = func(ctx context.Context, id string) (*fleet.NanoEnrollment, error) {
+			return nil, nil
+		}
+		_, err := svc.MDMAppleQuarantineHost(ctx, 1, quarantineTeamID, "case-1", true)
+		require.ErrorContains(t, err, "not enrolled in Fleet's MDM")
+		require.False(t, ds.AddHostsToTeamFuncInvoked)
+	})
+
+	t.Run("missing case id", func(t *testing.T) {
+		_, svc, _ := setupQuarantine(t)
+		_, err := svc.MDMAppleQuarantineHost(ctx, 1, quarantineTeamID, "", true)
+		require.ErrorContains(t, err, "case_id is required")
+	})
+}
+
+func TestMDMAppleDeviceLockPIN(t *testing.T) {
+	authorizer, err := authz.NewAuthorizer()
+	require.NoError(t, err)
+	ctx := viewer.NewContext(context.Background(), viewer.Viewer{User: test.UserAdmin})
+
+	ds, svc := setup(t)
+	cmdr := &lockCommander{}
+	svc.authz = authorizer
+	svc.mdmAppleCommander = cmdr
+
+	ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
+		return &fleet.Host{ID: id, UUID: "uuid-1", Hostname: "host1"}, nil
+	}
+	var stored *fleet.HostMDMAppleLockPIN
+	ds.SetHostMDMAppleLockPINFunc = func(ctx context.Context, pin *fleet.HostMDMAppleLockPIN) error {
+		stored = pin
+		return nil
+	}
+	ds.GetHostMDMAppleLockPINFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMAppleLockPIN, error) {
+		require.Equal(t, "uuid-1", hostUUID)
+		return stored, nil
+	}
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		act, ok := activity.(fleet.ActivityTypeReadHostUnlockPIN)
+		require.True(t, ok)
+		require.Equal(t, uint(1), act.HostID)
+		return nil
+	}
+	ds.NewSecurityAuditLogEntryFunc = func(ctx context.Context, entry *fleet.SecurityAuditLogEntry) error {
+		require.Equal(t, fleet.SecurityAuditActionReadHostUnlockPIN, entry.Action)
+		require.Equal(t, uint(1), entry.HostID)
+		require.Equal(t, "host1", entry.HostDisplayName)
+		return nil
+	}
+
+	pin, err := svc.MDMAppleDeviceLock(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"uuid-1"}, cmdr.hostUUIDs)
+	require.Equal(t, "123456", pin.PIN)
+	require.Equal(t, "DeviceLock", pin.RequestType)
+	require.NotEmpty(t, pin.CommandUUID)
+	require.Equal(t, stored, pin)
+
+	got, err := svc.HostMDMAppleLockPIN(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, pin, got)
+	require.True(t, ds.NewActivityFuncInvoked)
+	require.True(t, ds.NewSecurityAuditLogEntryFuncInvoked)
+
+	// observers can't read the PIN
+	ctx = viewer.NewContext(context.Background(), viewer.Viewer{User: test.UserObserver})
+	_, err = svc.HostMDMAppleLockPIN(ctx, 1)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+
+	// the PIN is not stored if the command can't be enqueued
+	ctx = viewer.NewContext(context.Background(), viewer.Viewer{User: test.UserAdmin})
+	stored = nil
+	cmdr.err = errors.New("enqueue failed")
+	_, err = svc.MDMAppleDeviceLock(ctx, 1)
+	require.ErrorContains(t, err, "enqueue failed")
+	require.Nil(t, stored)
+}
+
+func TestRotateMDMAppleFileVaultKeys(t *testing.T) {
+	authorizer, err := authz.NewAuthorizer()
+	require.NoError(t, err)
+
+	ds, svc := setup(t)
+	svc.authz = authorizer
+
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: tid, Name: "team1"}, nil
+	}
+	var gotTeamID *uint
+	ds.RequestMDMAppleFileVaultKeyRotationFunc = func(ctx context.Context, teamID *uint) (int, error) {
+		gotTeamID = teamID
+		return 3, nil
+	}
+	var gotActivity fleet.ActivityTypeRotatedMacosDiskEncryptionKeys
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		act, ok := activity.(fleet.ActivityTypeRotatedMacosDiskEncryptionKeys)
+		require.True(t, ok)
+		gotActivity = act
+		return nil
+	}
+
+	// observers can't rotate the keys
+	ctx := viewer.NewContext(context.Background(), viewer.Viewer{User: test.UserObserver})
+	_, err = svc.RotateMDMAppleFileVaultKeys(ctx, nil, nil)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	require.False(t, ds.RequestMDMAppleFileVaultKeyRotationFuncInvoked)
+
+	ctx = viewer.NewContext(context.Background(), viewer.Viewer{User: test.UserAdmin})
+	n, err := svc.RotateMDMAppleFileVaultKeys(ctx, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.Nil(t, gotTeamID)
+	require.Equal(t, fleet.ActivityTypeRotatedMacosDiskEncryptionKeys{HostCount: 3}, gotActivity)
+
+	n, err = svc.RotateMDMAppleFileVaultKeys(ctx, ptr.Uint(1), nil)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.Equal(t, ptr.Uint(1), gotTeamID)
+	require.Equal(t, fleet.ActivityTypeRotatedMacosDiskEncryptionKeys{
+		TeamID:    ptr.Uint(1),
+		TeamName:  ptr.String("team1"),
+		HostCount: 3,
+	}, gotActivity)
+
+	// rotate the keys of the hosts of a target
+	targetTeamIDs := fleet.MDMHostTargetTeamIDs{1}
+	ds.MDMHostTargetFunc = func(ctx context.Context, id uint) (*fleet.MDMHostTarget, error) {
+		return &fleet.MDMHostTarget{
+			ID:                   id,
+			Name:                 "arm",
+			MDMHostTargetFilters: fleet.MDMHostTargetFilters{TeamIDs: targetTeamIDs},
+		}, nil
+	}
+	ds.RequestMDMAppleFileVaultKeyRotationForTargetFunc = func(ctx context.Context, targetID uint) (int, error) {
+		return 2, nil
+	}
+
+	_, err = svc.RotateMDMAppleFileVaultKeys(ctx, ptr.Uint(1), ptr.Uint(5))
+	require.ErrorContains(t, err, "only one of team_id or target_id can be provided")
+
+	n, err = svc.RotateMDMAppleFileVaultKeys(ctx, nil, ptr.Uint(5))
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, fleet.ActivityTypeRotatedMacosDiskEncryptionKeys{
+		TargetID:   ptr.Uint(5),
+		TargetName: ptr.String("arm"),
+		HostCount:  2,
+	}, gotActivity)
+
+	// team users can rotate the keys of targets restricted to their teams
+	ctx = viewer.NewContext(context.Background(), viewer.Viewer{User: test.UserTeamMaintainerTeam1})
+	_, err = svc.RotateMDMAppleFileVaultKeys(ctx, nil, ptr.Uint(5))
+	require.NoError(t, err)
+	targetTeamIDs = fleet.MDMHostTargetTeamIDs{1, 2}
+	_, err = svc.RotateMDMAppleFileVaultKeys(ctx, nil, ptr.Uint(5))
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	targetTeamIDs = nil
+	_, err = svc.RotateMDMAppleFileVaultKeys(ctx, nil, ptr.Uint(5))
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+}
+
+func TestMDMAssetStore(t *testing.T) {
+	ds := new(mock.Store)
+	authorizer, err := authz.NewAuthorizer()
+	require.NoError(t, err)
+
+	assets := make(map[string][]byte)
+	store := new(mdmassets_mock.MDMAssetStore)
+	store.PutFunc = func(ctx context.Context, kind fleet.MDMAssetKind, token string, content io.Reader) error {
+		b, err := io.ReadAll(content)
+		require.NoError(t, err)
+		assets[string(kind)+"/"+token] = b
+		return nil
+	}
+	store.GetFunc = func(ctx context.Context, kind fleet.MDMAssetKind, token string) (io.ReadCloser, int64, error) {
+		b, ok := assets[string(kind)+"/"+token]
+		if !ok {
+			return nil, 0, errors.New("not found")
+		}
+		return io.NopCloser(bytes.NewReader(b)), int64(len(b)), nil
+	}
+	store.DeleteFunc = func(ctx context.Context, kind fleet.MDMAssetKind, token string) error {
+		delete(assets, string(kind)+"/"+token)
+		return nil
+	}
+
+	svc := &Service{
+		ds:            ds,
+		authz:         authorizer,
+		logger:        kitlog.NewNopLogger(),
+		mdmAssetStore: store,
+	}
+	ctx := viewer.NewContext(context.Background(), viewer.Viewer{User: test.UserAdmin})
+
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		return nil
+	}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+
+	t.Run("bootstrap package", func(t *testing.T) {
+		pkg, err := os.ReadFile("../../../pkg/file/testdata/signed.pkg")
+		require.NoError(t, err)
+
+		var inserted *fleet.MDMAppleBootstrapPackage
+		ds.InsertMDMAppleBootstrapPackageFunc = func(ctx context.Context, bp *fleet.MDMAppleBootstrapPackage) error {
+			inserted = bp
+			return nil
+		}
+		err = svc.MDMAppleUploadBootstrapPackage(ctx, "signed.pkg", bytes.NewReader(pkg), 0)
+		require.NoError(t, err)
+		require.NotNil(t, inserted)
+		require.Nil(t, inserted.Bytes)
+		require.Equal(t, pkg, assets["bootstrap_packages/"+inserted.Token])
+
+		ds.GetMDMAppleBootstrapPackageBytesFunc = func(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackage, error) {
+			return &fleet.MDMAppleBootstrapPackage{Name: inserted.Name, Token: token}, nil
+		}
+		got, err := svc.GetMDMAppleBootstrapPackageBytes(ctx, inserted.Token)
+		require.NoError(t, err)
+		require.NotNil(t, got.Content)
+		require.EqualValues(t, len(pkg), got.Size)
+		b, err := io.ReadAll(got.Content)
+		require.NoError(t, err)
+		require.Equal(t, pkg, b)
+
+		// packages stored in the database before the store was configured are
+		// still served from the database.
+		ds.GetMDMAppleBootstrapPackageBytesFunc = func(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackage, error) {
+			return &fleet.MDMAppleBootstrapPackage{Name: "old.pkg", Token: token, Bytes: []byte("old")}, nil
+		}
+		got, err = svc.GetMDMAppleBootstrapPackageBytes(ctx, "old")
+		require.NoError(t, err)
+		require.Nil(t, got.Content)
+		require.Equal(t, []byte("old"), got.Bytes)
+
+		ds.GetMDMAppleBootstrapPackageMetaFunc = func(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackage, error) {
+			return inserted, nil
+		}
+		ds.DeleteMDMAppleBootstrapPackageFunc = func(ctx context.Context, teamID uint) error {
+			return nil
+		}
+		err = svc.DeleteMDMAppleBootstrapPackage(ctx, nil)
+		require.NoError(t, err)
+		require.NotContains(t, assets, "bootstrap_packages/"+inserted.Token)
+	})
+
+	t.Run("bootstrap package insert fails", func(t *testing.T) {
+		pkg, err := os.ReadFile("../../../pkg/file/testdata/signed.pkg")
+		require.NoError(t, err)
+
+		testErr := errors.New("test")
+		ds.InsertMDMAppleBootstrapPackageFunc = func(ctx context.Context, bp *fleet.MDMAppleBootstrapPackage) error {
+			return testErr
+		}
+		err = svc.MDMAppleUploadBootstrapPackage(ctx, "signed.pkg", bytes.NewReader(pkg), 0)
+		require.ErrorIs(t, err, testErr)
+		require.Empty(t, assets)
+	})
+
+	t.Run("EULA", func(t *testing.T) {
+		pdf := []byte("%PDF-1.7 some content")
+
+		var inserted *fleet.MDMAppleEULA
+		ds.MDMAppleInsertEULAFunc = func(ctx context.Context, eula *fleet.MDMAppleEULA) error {
+			inserted = eula
+			return nil
+		}
+		err := svc.MDMAppleCreateEULA(ctx, "eula.pdf", bytes.NewReader(pdf))
+		require.NoError(t, err)
+		require.NotNil(t, inserted)
+		require.Nil(t, inserted.Bytes)
+		require.Equal(t, pdf, assets["eulas/"+inserted.Token])
+
+		ds.MDMAppleGetEULABytesFunc = func(ctx context.Context, token string) (*fleet.MDMAppleEULA, error) {
+			return &fleet.MDMAppleEULA{Name: inserted.Name}, nil
+		}
+		got, err := svc.MDMAppleGetEULABytes(ctx, inserted.Token)
+		require.NoError(t, err)
+		require.EqualValues(t, len(pdf), got.Size)
+		b, err := io.ReadAll(got.Content)
+		require.NoError(t, err)
+		require.Equal(t, pdf, b)
+
+		ds.MDMAppleDeleteEULAFunc = func(ctx context.Context, token string) error {
+			return nil
+		}
+		err = svc.MDMAppleDeleteEULA(ctx, inserted.Token)
+		require.NoError(t, err)
+		require.Empty(t, assets)
+	})
+}
+
+func TestGetMDMAppleBootstrapPackageSignatures(t *testing.T) {
+	ds := new(mock.Store)
+	authorizer, err := authz.NewAuthorizer()
+	require.NoError(t, err)
+	svc := &Service{ds: ds, authz: authorizer}
+
+	ds.ListMDMAppleBootstrapPackagesFunc = func(ctx context.Context) ([]*fleet.MDMAppleBootstrapPackage, error) {
+		return []*fleet.MDMAppleBootstrapPackage{
+			{TeamID: 0, SignatureStatus: fleet.MDMAppleBootstrapPackageSignatureValid},
+			{TeamID: 1, SignatureStatus: fleet.MDMAppleBootstrapPackageSignatureInvalid, SignatureDetail: ptr.String("expired")},
+			{TeamID: 2, SignatureStatus: fleet.MDMAppleBootstrapPackageSignatureInvalid},
+			{TeamID: 3},
+		}, nil
+	}
+
+	ctx := viewer.NewContext(context.Background(), viewer.Viewer{User: test.UserAdmin})
+	summary, err := svc.GetMDMAppleBootstrapPackageSignatures(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint(1), summary.Valid)
+	require.Equal(t, uint(2), summary.Invalid)
+	require.Equal(t, uint(1), summary.Unverified)
+	require.Len(t, summary.Packages, 4)
+
+	ctx = viewer.NewContext(context.Background(), viewer.Viewer{User: test.UserNoRoles})
+	_, err = svc.GetMDMAppleBootstrapPackageSignatures(ctx)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+}
+
+type testAuth struct {
+	userID              string
+	userDisplayName     string
+	assertionAttributes []fleet.SAMLAttribute
+}
+
+var _ fleet.Auth = (*testAuth)(nil)
+
+func (a *testAuth) UserID() string                             { return a.userID }
+func (a *testAuth) UserDisplayName() string                    { return a.userDisplayName }
+func (a *testAuth) RequestID() string                          { return "" }
+func (a *testAuth) AssertionAttributes() []fleet.SAMLAttribute { return a.assertionAttributes }
+
+func TestMDMIdPAccountFromSSO(t *testing.T) {
+	auth := &testAuth{
+		userID:          "jane@example.com",
+		userDisplayName: "Jane",
+		assertionAttributes: []fleet.SAMLAttribute{
+			{Name: "uid", Values: []fleet.SAMLAttributeValue{{Value: "jdoe"}}},
+			{Name: "displayName", Values: []fleet.SAMLAttributeValue{{Value: "Jane Doe"}}},
+			{Name: "memberOf", Values: []fleet.SAMLAttributeValue{{Value: "engineering"}, {Value: "everyone"}}},
+			{Name: "empty"},
+		},
+	}
+
+	// without a mapping, the name identifier and display name are used
+	acc := mdmIdPAccountFromSSO(auth, fleet.MDMSSOAttributeMapping{})
+	require.Equal(t, "jane@example.com", acc.Username)
+	require.Equal(t, "Jane", acc.FullName)
+	require.Nil(t, acc.Groups)
+
+	acc = mdmIdPAccountFromSSO(auth, fleet.MDMSSOAttributeMapping{
+		Username: "uid",
+		FullName: "displayName",
+		Groups:   "memberOf",
+	})
+	require.Equal(t, "jdoe", acc.Username)
+	require.Equal(t, "Jane Doe", acc.FullName)
+	require.Equal(t, []string{"engineering", "everyone"}, acc.Groups)
+
+	// attributes without values are ignored
+	acc = mdmIdPAccountFromSSO(auth, fleet.MDMSSOAttributeMapping{Username: "empty"})
+	require.Equal(t, "jane@example.com", acc.Username)
+}
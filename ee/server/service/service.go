@@ -27,6 +27,7 @@ type Service struct {
 	mdmPushCertTopic  string
 	ssoSessionStore   sso.SessionStore
 	depService        *apple_mdm.DEPService
+	mdmAssetStore     fleet.MDMAssetStore
 }
 
 func NewService(
@@ -40,6 +41,7 @@ func NewService(
 	mdmAppleCommander fleet.MDMAppleCommandIssuer,
 	mdmPushCertTopic string,
 	sso sso.SessionStore,
+	mdmAssetStore fleet.MDMAssetStore,
 ) (*Service, error) {
 	authorizer, err := authz.NewAuthorizer()
 	if err != nil {
@@ -58,6 +60,7 @@ func NewService(
 		mdmPushCertTopic:  mdmPushCertTopic,
 		ssoSessionStore:   sso,
 		depService:        apple_mdm.NewDEPService(ds, depStorage, logger, false),
+		mdmAssetStore:     mdmAssetStore,
 	}
 
 	// Override methods that can't be easily overriden via
@@ -71,6 +74,7 @@ func NewService(
 		DeleteMDMAppleSetupAssistant:      eeservice.DeleteMDMAppleSetupAssistant,
 		MDMAppleSyncDEPProfile:            eeservice.mdmAppleSyncDEPProfile,
 		DeleteMDMAppleBootstrapPackage:    eeservice.DeleteMDMAppleBootstrapPackage,
+		DeleteMDMAppleEULA:                eeservice.mdmAppleDeleteEULA,
 	})
 
 	return eeservice, nil
@@ -17,6 +17,8 @@ import (
 	"github.com/fleetdm/fleet/v4/server/contexts/viewer"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/fleetdm/fleet/v4/server/service/schedule"
+	"github.com/fleetdm/fleet/v4/server/worker"
 	"github.com/go-kit/kit/log/level"
 )
 
@@ -128,6 +130,20 @@ func (svc *Service) ModifyTeam(ctx context.Context, teamID uint, payload fleet.T
 			macOSDiskEncryptionUpdated = team.Config.MDM.MacOSSettings.EnableDiskEncryption != payload.MDM.MacOSSettings.EnableDiskEncryption
 			team.Config.MDM.MacOSSettings.EnableDiskEncryption = payload.MDM.MacOSSettings.EnableDiskEncryption
 		}
+
+		if payload.MDM.Timezone != nil {
+			if _, err := schedule.LoadLocation(*payload.MDM.Timezone); err != nil {
+				return nil, fleet.NewInvalidArgumentError("timezone", err.Error())
+			}
+			team.Config.MDM.Timezone = *payload.MDM.Timezone
+		}
+
+		if payload.MDM.Localization != nil {
+			if err := payload.MDM.Localization.Validate(); err != nil {
+				return nil, fleet.NewInvalidArgumentError("localization", err.Error())
+			}
+			team.Config.MDM.Localization = *payload.MDM.Localization
+		}
 	}
 
 	if payload.Integrations != nil {
@@ -373,25 +389,25 @@ func (svc *Service) ListAvailableTeamsForUser(ctx context.Context, user *fleet.U
 	return availableTeams, nil
 }
 
-func (svc *Service) DeleteTeam(ctx context.Context, teamID uint) error {
+func (svc *Service) DeleteTeam(ctx context.Context, teamID uint) (*fleet.Job, error) {
 	if err := svc.authz.Authorize(ctx, &fleet.Team{ID: teamID}, fleet.ActionWrite); err != nil {
-		return err
+		return nil, err
 	}
 
 	team, err := svc.ds.Team(ctx, teamID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	name := team.Name
 
 	vc, ok := viewer.FromContext(ctx)
 	if !ok {
-		return fleet.ErrNoContext
+		return nil, fleet.ErrNoContext
 	}
 	filter := fleet.TeamFilter{User: vc.User, IncludeObserver: true}
 	hosts, err := svc.ds.ListHosts(ctx, filter, fleet.HostListOptions{TeamFilter: &teamID})
 	if err != nil {
-		return ctxerr.Wrap(ctx, err, "list hosts for reconcile profiles on team change")
+		return nil, ctxerr.Wrap(ctx, err, "list hosts for reconcile profiles on team change")
 	}
 	hostIDs := make([]uint, 0, len(hosts))
 	for _, host := range hosts {
@@ -399,15 +415,16 @@ func (svc *Service) DeleteTeam(ctx context.Context, teamID uint) error {
 	}
 
 	if err := svc.ds.DeleteTeam(ctx, teamID); err != nil {
-		return err
+		return nil, err
 	}
 	// team id 0 is provided since the team's hosts are now part of no team
-	if err := svc.ds.BulkSetPendingMDMAppleHostProfiles(ctx, nil, []uint{0}, nil, nil); err != nil {
-		return ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
+	job, err := worker.BulkSetPendingMDMAppleHostProfiles(ctx, svc.ds, svc.logger, nil, []uint{0}, nil)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
 	}
 
 	if err := svc.ds.CleanupDiskEncryptionKeysOnTeamChange(ctx, hostIDs, ptr.Uint(0)); err != nil {
-		return ctxerr.Wrap(ctx, err, "reconcile profiles on team change cleanup disk encryption keys")
+		return nil, ctxerr.Wrap(ctx, err, "reconcile profiles on team change cleanup disk encryption keys")
 	}
 
 	logging.WithExtras(ctx, "id", teamID)
@@ -420,9 +437,116 @@ func (svc *Service) DeleteTeam(ctx context.Context, teamID uint) error {
 			Name: name,
 		},
 	); err != nil {
-		return ctxerr.Wrap(ctx, err, "create activity for team deletion")
+		return nil, ctxerr.Wrap(ctx, err, "create activity for team deletion")
 	}
-	return nil
+	return job, nil
+}
+
+func (svc *Service) StageTeamDeletion(ctx context.Context, teamID uint, destinationTeamID uint) (*fleet.Job, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Team{ID: teamID}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+	var destTeamID *uint
+	if destinationTeamID != 0 {
+		destTeamID = &destinationTeamID
+	}
+	// the hosts are transferred to the destination team, which requires the
+	// same permissions as transferring them via the API.
+	if err := svc.authz.Authorize(ctx, &fleet.Host{TeamID: destTeamID}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	if destinationTeamID == teamID {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("destination_team_id", "must be different from the deleted team"))
+	}
+	team, err := svc.ds.Team(ctx, teamID)
+	if err != nil {
+		return nil, err
+	}
+	if destTeamID != nil {
+		if _, err := svc.ds.Team(ctx, destinationTeamID); err != nil {
+			return nil, err
+		}
+	}
+
+	args := &worker.TeamDeletionArgs{
+		TeamID:            teamID,
+		TeamName:          team.Name,
+		DestinationTeamID: destinationTeamID,
+		HostsCount:        uint(team.HostCount),
+	}
+	if user := authz.UserFromContext(ctx); user != nil {
+		args.UserID = user.ID
+	}
+	job, err := worker.QueueJob(ctx, svc.ds, worker.TeamDeletionName, args)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "queueing team deletion job")
+	}
+
+	logging.WithExtras(ctx, "id", teamID, "destination_team_id", destinationTeamID, "job_id", job.ID)
+	return job, nil
+}
+
+func (svc *Service) GetTeamDeletionReport(ctx context.Context, teamID uint) (*fleet.TeamDeletionReport, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Team{ID: teamID}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	team, err := svc.ds.Team(ctx, teamID)
+	if err != nil {
+		return nil, err
+	}
+	report, err := svc.ds.TeamDeletionReport(ctx, teamID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get team deletion report")
+	}
+	report.TeamName = team.Name
+	return report, nil
+}
+
+func (svc *Service) GetTeamDeletionProgress(ctx context.Context, jobID uint) (*fleet.TeamDeletionProgress, error) {
+	// first we perform a perform basic authz check
+	if err := svc.authz.Authorize(ctx, &fleet.Team{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	job, err := svc.ds.GetJob(ctx, jobID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	if job.Name != worker.TeamDeletionName {
+		return nil, ctxerr.Wrap(ctx, notFoundError{}, "job is not a team deletion job")
+	}
+	var args worker.TeamDeletionArgs
+	if job.Args != nil {
+		if err := json.Unmarshal(*job.Args, &args); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "unmarshal job args")
+		}
+	}
+	// the users that can delete the team can check the progress of its
+	// deletion.
+	if err := svc.authz.Authorize(ctx, &fleet.Team{ID: args.TeamID}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	progress := &fleet.TeamDeletionProgress{
+		TeamID:            args.TeamID,
+		TeamName:          args.TeamName,
+		DestinationTeamID: args.DestinationTeamID,
+		HostsCount:        args.HostsCount,
+		Job:               job,
+	}
+	team, err := svc.ds.Team(ctx, args.TeamID)
+	switch {
+	case fleet.IsNotFound(err):
+		progress.TeamDeleted = true
+		progress.HostsMoved = args.HostsCount
+	case err != nil:
+		return nil, ctxerr.Wrap(ctx, err, "get team")
+	case uint(team.HostCount) < args.HostsCount:
+		progress.HostsMoved = args.HostsCount - uint(team.HostCount)
+	}
+	return progress, nil
 }
 
 func (svc *Service) GetTeam(ctx context.Context, teamID uint) (*fleet.Team, error) {
@@ -593,6 +717,26 @@ func (svc *Service) ApplyTeamSpecs(ctx context.Context, specs []*fleet.TeamSpec,
 		if err := spec.MDM.MacOSUpdates.Validate(); err != nil {
 			return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("macos_updates", err.Error()))
 		}
+		if spec.MDM.MacOSSetup.EULA.Value != "" {
+			return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("macos_setup.eula",
+				"Couldn't update macos_setup.eula. The EULA can only be set in the global configuration, not per team."))
+		}
+		if err := spec.MDM.MacOSMigration.Validate(); err != nil {
+			return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("macos_migration", err.Error()))
+		}
+		if spec.MDM.MacOSMigration.Enable && !appConfig.MDM.EnabledAndConfigured {
+			return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("macos_migration.enable",
+				`Couldn't update macos_migration because MDM features aren't turned on in Fleet. Use fleetctl generate mdm-apple and then fleet serve with mdm configuration to turn on MDM features.`))
+		}
+		if err := spec.MDM.MacOSEnrollmentEligibility.Validate(); err != nil {
+			return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("macos_enrollment_eligibility", err.Error()))
+		}
+		if _, err := schedule.LoadLocation(spec.MDM.Timezone); err != nil {
+			return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("timezone", err.Error()))
+		}
+		if err := spec.MDM.Localization.Validate(); err != nil {
+			return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("localization", err.Error()))
+		}
 
 		if create {
 			team, err := svc.createTeamFromSpec(ctx, spec, appConfig, secrets, applyOpts.DryRun)
@@ -679,9 +823,13 @@ func (svc *Service) createTeamFromSpec(
 			AgentOptions: agentOptions,
 			Features:     features,
 			MDM: fleet.TeamMDM{
-				MacOSUpdates:  spec.MDM.MacOSUpdates,
-				MacOSSettings: macOSSettings,
-				MacOSSetup:    macOSSetup,
+				MacOSUpdates:               spec.MDM.MacOSUpdates,
+				MacOSSettings:              macOSSettings,
+				MacOSSetup:                 macOSSetup,
+				MacOSMigration:             spec.MDM.MacOSMigration,
+				MacOSEnrollmentEligibility: spec.MDM.MacOSEnrollmentEligibility,
+				Timezone:                   spec.MDM.Timezone,
+				Localization:               spec.MDM.Localization,
 			},
 		},
 		Secrets: secrets,
@@ -734,6 +882,10 @@ func (svc *Service) editTeamFromSpec(
 	}
 	team.Config.Features = features
 	team.Config.MDM.MacOSUpdates = spec.MDM.MacOSUpdates
+	team.Config.MDM.MacOSMigration = spec.MDM.MacOSMigration
+	team.Config.MDM.MacOSEnrollmentEligibility = spec.MDM.MacOSEnrollmentEligibility
+	team.Config.MDM.Timezone = spec.MDM.Timezone
+	team.Config.MDM.Localization = spec.MDM.Localization
 
 	oldMacOSDiskEncryption := team.Config.MDM.MacOSSettings.EnableDiskEncryption
 	if err := svc.applyTeamMacOSSettings(ctx, spec, &team.Config.MDM.MacOSSettings); err != nil {
@@ -817,6 +969,15 @@ func (svc *Service) applyTeamMacOSSettings(ctx context.Context, spec *fleet.Team
 	if err != nil {
 		return fleet.NewUserMessageError(err, http.StatusBadRequest)
 	}
+	if err := applyUpon.ProfileFailureGracePeriod.Validate(); err != nil {
+		return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("macos_settings.profile_failure_grace_period", err.Error()))
+	}
+	if err := applyUpon.ProfileRetryPolicy.Validate(); err != nil {
+		return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("macos_settings.profile_retry_policy", err.Error()))
+	}
+	if err := applyUpon.ValidateCustomSettingsExclusions(); err != nil {
+		return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("macos_settings.custom_settings_exclusions", err.Error()))
+	}
 
 	appCfg, err := svc.ds.AppConfig(ctx)
 	if err != nil {
@@ -864,6 +1025,12 @@ func (svc *Service) updateTeamMDMAppleSettings(ctx context.Context, tm *fleet.Te
 			didUpdateMacOSDiskEncryption = true
 		}
 	}
+	if payload.AllowReservedPayloads != nil {
+		if tm.Config.MDM.MacOSSettings.AllowReservedPayloads != *payload.AllowReservedPayloads {
+			tm.Config.MDM.MacOSSettings.AllowReservedPayloads = *payload.AllowReservedPayloads
+			didUpdate = true
+		}
+	}
 
 	if didUpdate {
 		if _, err := svc.ds.SaveTeam(ctx, tm); err != nil {
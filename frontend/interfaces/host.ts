@@ -111,6 +111,12 @@ interface IMdmMacOsSetup {
   bootstrap_package_name: string;
 }
 
+interface IMdmEndUser {
+  username: string;
+  full_name: string;
+  groups: string[] | null;
+}
+
 export interface IHostMdmData {
   encryption_key_available: boolean;
   enrollment_status: MdmEnrollmentStatus | null;
@@ -120,6 +126,7 @@ export interface IHostMdmData {
   profiles: IHostMacMdmProfile[] | null;
   macos_settings?: IMdmMacOsSettings;
   macos_setup?: IMdmMacOsSetup;
+  end_user?: IMdmEndUser;
 }
 
 export interface IMunkiIssue {
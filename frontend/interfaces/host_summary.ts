@@ -10,6 +10,17 @@ export interface IHostSummaryLabel {
   label_type: "regular" | "builtin";
 }
 
+export interface IHostSummaryMdm {
+  enrolled_hosts_count: number;
+  enrolled_manual_hosts_count: number;
+  enrolled_automated_hosts_count: number;
+  pending_hosts_count: number;
+  unenrolled_hosts_count: number;
+  profiles_failed_hosts_count: number;
+  disk_encryption_enabled_hosts_count: number;
+  disk_encryption_key_escrowed_hosts_count: number;
+}
+
 export interface IHostSummary {
   all_linux_count: number;
   totals_hosts_count: number;
@@ -21,4 +32,5 @@ export interface IHostSummary {
   missing_30_days_count?: number; // premium feature
   low_disk_space_count?: number; // premium feature
   builtin_labels: IHostSummaryLabel[];
+  mdm?: IHostSummaryMdm;
 }
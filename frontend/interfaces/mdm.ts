@@ -70,6 +70,19 @@ export interface IMdmProfilesResponse {
   profiles: IMdmProfile[] | null;
 }
 
+export interface IMdmApplePendingEnrollment {
+  host_id: number;
+  host_uuid: string;
+  hardware_serial: string;
+  host_display_name: string;
+  team_id: number | null;
+  enrolled_at: string;
+}
+
+export interface IMdmApplePendingEnrollmentsResponse {
+  pending_enrollments: IMdmApplePendingEnrollment[];
+}
+
 export type MdmProfileStatus = "verifying" | "pending" | "failed";
 
 export type MacMdmProfileOperationType = "remove" | "install";
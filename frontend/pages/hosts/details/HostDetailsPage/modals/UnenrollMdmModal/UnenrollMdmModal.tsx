@@ -24,8 +24,15 @@ const UnenrollMdmModal = ({ hostId, onClose }: IUnenrollMdmModalProps) => {
   const submitUnenrollMdm = async () => {
     setRequestState("unenrolling");
     try {
-      await mdmAPI.unenrollHostFromMdm(hostId, 5000);
-      renderFlash("success", "Successfully turned off MDM.");
+      const { status } = await mdmAPI.unenrollHostFromMdm(hostId, 10000);
+      if (status === "pending") {
+        renderFlash(
+          "success",
+          "MDM will be turned off the next time the host checks in."
+        );
+      } else {
+        renderFlash("success", "Successfully turned off MDM.");
+      }
       onClose();
     } catch (unenrollMdmError: unknown) {
       console.log(unenrollMdmError);
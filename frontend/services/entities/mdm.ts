@@ -1,4 +1,5 @@
 /* eslint-disable @typescript-eslint/explicit-module-boundary-types */
+import { IMdmApplePendingEnrollmentsResponse } from "interfaces/mdm";
 import { APP_CONTEXT_NO_TEAM_ID } from "interfaces/team";
 import sendRequest from "services";
 import endpoints from "utilities/endpoints";
@@ -23,6 +24,14 @@ export default {
       timeout
     );
   },
+  getPendingEnrollments: (): Promise<IMdmApplePendingEnrollmentsResponse> => {
+    const { MDM_APPLE_PENDING_ENROLLMENTS } = endpoints;
+    return sendRequest("GET", MDM_APPLE_PENDING_ENROLLMENTS);
+  },
+  approveHostEnrollment: (hostId: number) => {
+    const { HOST_MDM_APPROVE_ENROLLMENT } = endpoints;
+    return sendRequest("POST", HOST_MDM_APPROVE_ENROLLMENT(hostId));
+  },
   requestCSR: (email: string, organization: string) => {
     const { MDM_REQUEST_CSR } = endpoints;
 
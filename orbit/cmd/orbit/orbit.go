@@ -624,6 +624,10 @@ func main() {
 			})
 
 			configFetcher = update.ApplyDiskEncryptionRunnerMiddleware(configFetcher)
+
+			// add middleware to report the local mdm enrollment status
+			const mdmEnrollmentStatusReportFrequency = time.Hour
+			configFetcher = update.ApplyMDMEnrollmentStatusConfigFetcherMiddleware(configFetcher, orbitClient, mdmEnrollmentStatusReportFrequency)
 		}
 
 		const orbitFlagsUpdateInterval = 30 * time.Second
@@ -3,9 +3,11 @@
 package profiles
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"os/exec"
+	"strings"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
@@ -57,6 +59,73 @@ func getProfile(identifier string) (*profilePayload, error) {
 	return nil, ErrNotFound
 }
 
+// GetMDMEnrollmentStatus returns the MDM enrollment status of the device as
+// reported by the `profiles` command, along with the identifiers of the
+// device-level configuration profiles installed.
+func GetMDMEnrollmentStatus() (*fleet.OrbitMDMEnrollmentStatus, error) {
+	outBuf, err := execEnrollmentStatusCmd()
+	if err != nil {
+		return nil, fmt.Errorf("get enrollment status: %w", err)
+	}
+	status := parseEnrollmentStatus(outBuf)
+
+	outBuf, err = execProfileCmd()
+	if err != nil {
+		return nil, fmt.Errorf("get enrollment status: %w", err)
+	}
+	var profiles profilesOutput
+	if err := plist.Unmarshal(outBuf.Bytes(), &profiles); err != nil {
+		return nil, fmt.Errorf("get enrollment status: %w", err)
+	}
+	status.Profiles = make([]string, 0, len(profiles.ComputerLevel))
+	for _, profile := range profiles.ComputerLevel {
+		status.Profiles = append(status.Profiles, profile.ProfileIdentifier)
+	}
+
+	return status, nil
+}
+
+// parseEnrollmentStatus parses the output of `profiles status -type
+// enrollment`, which looks like:
+//
+//	Enrolled via DEP: Yes
+//	MDM enrollment: Yes (User Approved)
+//	MDM server: https://fleet.example.com/mdm/apple/mdm
+func parseEnrollmentStatus(out *bytes.Buffer) *fleet.OrbitMDMEnrollmentStatus {
+	var status fleet.OrbitMDMEnrollmentStatus
+	sc := bufio.NewScanner(out)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "Enrolled via DEP":
+			status.InstalledFromDEP = strings.HasPrefix(value, "Yes")
+		case "MDM enrollment":
+			status.Enrolled = strings.HasPrefix(value, "Yes")
+		case "MDM server":
+			status.ServerURL = value
+		}
+	}
+	return &status
+}
+
+// execEnrollmentStatusCmd is declared as a variable so it can be overwritten
+// by tests.
+var execEnrollmentStatusCmd = func() (*bytes.Buffer, error) {
+	var outBuf bytes.Buffer
+	cmd := exec.Command("/usr/bin/profiles", "status", "-type", "enrollment")
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &outBuf
+
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return &outBuf, nil
+}
+
 // execProfileCmd is declared as a variable so it can be overwritten by tests.
 var execProfileCmd = func() (*bytes.Buffer, error) {
 	var outBuf bytes.Buffer
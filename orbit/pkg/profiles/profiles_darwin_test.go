@@ -47,7 +47,53 @@ func TestGetFleetdConfig(t *testing.T) {
 
 }
 
+func TestGetMDMEnrollmentStatus(t *testing.T) {
+	testErr := errors.New("test error")
+	cases := []struct {
+		statusOut  *string
+		profileOut *string
+		wantOut    *fleet.OrbitMDMEnrollmentStatus
+		wantErr    error
+	}{
+		{nil, &emptyOutput, nil, testErr},
+		{&enrolledStatusOutput, nil, nil, testErr},
+		{&enrolledStatusOutput, ptr.String("invalid-xml"), nil, io.EOF},
+		{ptr.String("Enrolled via DEP: No\nMDM enrollment: No\n"), &emptyOutput, &fleet.OrbitMDMEnrollmentStatus{Profiles: []string{}}, nil},
+		{&enrolledStatusOutput, &withFleetdConfig, &fleet.OrbitMDMEnrollmentStatus{
+			Enrolled:         true,
+			InstalledFromDEP: true,
+			ServerURL:        "https://test.example.com/mdm/apple/mdm",
+			Profiles:         []string{"com.fleetdm.fleetd.config"},
+		}, nil},
+	}
+
+	cmdOutput := func(out *string) func() (*bytes.Buffer, error) {
+		return func() (*bytes.Buffer, error) {
+			if out == nil {
+				return nil, testErr
+			}
+			return bytes.NewBufferString(*out), nil
+		}
+	}
+
+	origExecProfileCmd, origExecEnrollmentStatusCmd := execProfileCmd, execEnrollmentStatusCmd
+	t.Cleanup(func() { execProfileCmd, execEnrollmentStatusCmd = origExecProfileCmd, origExecEnrollmentStatusCmd })
+	for _, c := range cases {
+		execEnrollmentStatusCmd = cmdOutput(c.statusOut)
+		execProfileCmd = cmdOutput(c.profileOut)
+
+		out, err := GetMDMEnrollmentStatus()
+		require.ErrorIs(t, err, c.wantErr)
+		require.Equal(t, c.wantOut, out)
+	}
+}
+
 var (
+	enrolledStatusOutput = `Enrolled via DEP: Yes
+MDM enrollment: Yes (User Approved)
+MDM server: https://test.example.com/mdm/apple/mdm
+`
+
 	emptyOutput = `<?xml version="1.0" encoding="UTF-8"?>
 <!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
 <plist version="1.0">
@@ -7,3 +7,7 @@ import "github.com/fleetdm/fleet/v4/server/fleet"
 func GetFleetdConfig() (*fleet.MDMAppleFleetdConfig, error) {
 	return nil, ErrNotImplemented
 }
+
+func GetMDMEnrollmentStatus() (*fleet.OrbitMDMEnrollmentStatus, error) {
+	return nil, ErrNotImplemented
+}
@@ -13,3 +13,9 @@ func TestGetFleetdConfig(t *testing.T) {
 	require.ErrorIs(t, ErrNotImplemented, err)
 	require.Nil(t, config)
 }
+
+func TestGetMDMEnrollmentStatus(t *testing.T) {
+	status, err := GetMDMEnrollmentStatus()
+	require.ErrorIs(t, ErrNotImplemented, err)
+	require.Nil(t, status)
+}
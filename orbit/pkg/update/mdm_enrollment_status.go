@@ -0,0 +1,72 @@
+package update
+
+import (
+	"time"
+
+	"github.com/fleetdm/fleet/v4/orbit/pkg/profiles"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/rs/zerolog/log"
+)
+
+// MDMEnrollmentStatusReporter sends the MDM enrollment status read locally to
+// the Fleet server.
+type MDMEnrollmentStatusReporter interface {
+	SetMDMEnrollmentStatus(status *fleet.OrbitMDMEnrollmentStatus) error
+	GetServerCapabilities() fleet.CapabilityMap
+}
+
+// MDMEnrollmentStatusConfigFetcher is a kind of middleware that wraps an
+// OrbitConfigFetcher and periodically reports the MDM enrollment status of
+// the device, as read locally, to the Fleet server, so that it can be
+// cross-checked with the server's view of the enrollment.
+type MDMEnrollmentStatusConfigFetcher struct {
+	// Fetcher is the OrbitConfigFetcher that will be wrapped. It is responsible
+	// for actually returning the orbit configuration or an error.
+	Fetcher OrbitConfigFetcher
+	// Reporter is used to send the enrollment status to the Fleet server.
+	Reporter MDMEnrollmentStatusReporter
+	// Frequency is the minimum amount of time that must pass between two
+	// reports of the enrollment status.
+	Frequency time.Duration
+
+	// for tests, to be able to mock reading the enrollment status. If nil,
+	// will use profiles.GetMDMEnrollmentStatus.
+	getStatusFn func() (*fleet.OrbitMDMEnrollmentStatus, error)
+
+	lastRun time.Time
+}
+
+func ApplyMDMEnrollmentStatusConfigFetcherMiddleware(fetcher OrbitConfigFetcher, reporter MDMEnrollmentStatusReporter, frequency time.Duration) OrbitConfigFetcher {
+	return &MDMEnrollmentStatusConfigFetcher{Fetcher: fetcher, Reporter: reporter, Frequency: frequency}
+}
+
+// GetConfig calls the wrapped Fetcher's GetConfig method, and if enough time
+// has passed since the last report and the Fleet server supports it, reports
+// the MDM enrollment status of the device.
+func (h *MDMEnrollmentStatusConfigFetcher) GetConfig() (*fleet.OrbitConfig, error) {
+	cfg, err := h.Fetcher.GetConfig()
+	if err != nil || time.Since(h.lastRun) <= h.Frequency {
+		return cfg, err
+	}
+	if !h.Reporter.GetServerCapabilities().Has(fleet.CapabilityMDMEnrollmentStatus) {
+		log.Debug().Msg("fleet server doesn't support mdm enrollment status reports, skipping")
+		return cfg, err
+	}
+
+	fn := h.getStatusFn
+	if fn == nil {
+		fn = profiles.GetMDMEnrollmentStatus
+	}
+	status, statusErr := fn()
+	if statusErr != nil {
+		log.Info().Err(statusErr).Msg("reading mdm enrollment status failed")
+		return cfg, err
+	}
+	if reportErr := h.Reporter.SetMDMEnrollmentStatus(status); reportErr != nil {
+		log.Info().Err(reportErr).Msg("reporting mdm enrollment status failed")
+		return cfg, err
+	}
+	h.lastRun = time.Now()
+	log.Debug().Msg("successfully reported mdm enrollment status")
+	return cfg, err
+}
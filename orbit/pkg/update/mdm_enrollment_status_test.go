@@ -0,0 +1,92 @@
+package update
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/require"
+)
+
+type dummyMDMEnrollmentStatusReporter struct {
+	capabilities fleet.CapabilityMap
+	err          error
+	reported     []*fleet.OrbitMDMEnrollmentStatus
+}
+
+func (r *dummyMDMEnrollmentStatusReporter) SetMDMEnrollmentStatus(status *fleet.OrbitMDMEnrollmentStatus) error {
+	r.reported = append(r.reported, status)
+	return r.err
+}
+
+func (r *dummyMDMEnrollmentStatusReporter) GetServerCapabilities() fleet.CapabilityMap {
+	return r.capabilities
+}
+
+func TestMDMEnrollmentStatusReport(t *testing.T) {
+	var logBuf bytes.Buffer
+
+	oldLog := log.Logger
+	log.Logger = log.Output(&logBuf)
+	t.Cleanup(func() { log.Logger = oldLog })
+
+	status := &fleet.OrbitMDMEnrollmentStatus{Enrolled: true, ServerURL: "https://test.example.com/mdm/apple/mdm"}
+	supported := fleet.CapabilityMap{fleet.CapabilityMDMEnrollmentStatus: {}}
+
+	cases := []struct {
+		desc         string
+		capabilities fleet.CapabilityMap
+		statusErr    error
+		reportErr    error
+		wantReported bool
+		wantLog      string
+	}{
+		{"not supported by server", fleet.CapabilityMap{}, nil, nil, false, ""},
+		{"status read fails", supported, io.ErrUnexpectedEOF, nil, false, "reading mdm enrollment status failed"},
+		{"report fails", supported, nil, io.ErrUnexpectedEOF, true, "reporting mdm enrollment status failed"},
+		{"success", supported, nil, nil, true, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			logBuf.Reset()
+
+			fetcher := &dummyConfigFetcher{cfg: &fleet.OrbitConfig{}}
+			reporter := &dummyMDMEnrollmentStatusReporter{capabilities: c.capabilities, err: c.reportErr}
+			statusFetcher := &MDMEnrollmentStatusConfigFetcher{
+				Fetcher:   fetcher,
+				Reporter:  reporter,
+				Frequency: time.Hour,
+				getStatusFn: func() (*fleet.OrbitMDMEnrollmentStatus, error) {
+					if c.statusErr != nil {
+						return nil, c.statusErr
+					}
+					return status, nil
+				},
+			}
+
+			cfg, err := statusFetcher.GetConfig()
+			require.NoError(t, err)
+			require.Equal(t, fetcher.cfg, cfg)
+			if c.wantReported {
+				require.Equal(t, []*fleet.OrbitMDMEnrollmentStatus{status}, reporter.reported)
+			} else {
+				require.Empty(t, reporter.reported)
+			}
+			require.Contains(t, logBuf.String(), c.wantLog)
+
+			// a successful report is not sent again until the frequency is elapsed,
+			// a failed one is retried.
+			_, err = statusFetcher.GetConfig()
+			require.NoError(t, err)
+			if c.wantReported && c.reportErr == nil {
+				require.Len(t, reporter.reported, 1)
+			} else if c.wantReported {
+				require.Len(t, reporter.reported, 2)
+			}
+		})
+	}
+}
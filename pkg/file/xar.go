@@ -22,11 +22,15 @@ import (
 	"bytes"
 	"compress/zlib"
 	"crypto"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"time"
 )
 
 // xarMagic is the [file signature][1] (or magic bytes) for xar
@@ -65,8 +69,14 @@ type tocXar struct {
 }
 
 type toc struct {
-	Signature  *any `xml:"signature"`
-	XSignature *any `xml:"x-signature"`
+	Signature  *tocSignature `xml:"signature"`
+	XSignature *tocSignature `xml:"x-signature"`
+}
+
+type tocSignature struct {
+	// Certificates are the base64-encoded DER certificates of the signing
+	// chain, the signing certificate first.
+	Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
 }
 
 // CheckPKGSignature checks if the provided bytes correspond to a signed pkg
@@ -99,6 +109,125 @@ func CheckPKGSignature(pkg io.Reader) error {
 	return nil
 }
 
+// CheckPKGSignatureStream is like CheckPKGSignature, but it only consumes the
+// bytes of the reader needed to parse the xar header and TOC instead of
+// buffering the whole package in memory. This makes it suitable to validate
+// very large packages, the caller is responsible for consuming the rest of
+// the reader if needed (e.g. to compute a checksum).
+func CheckPKGSignatureStream(pkg io.Reader) error {
+	hdr, hashType, err := parseHeader(pkg)
+	if err != nil {
+		return err
+	}
+
+	// skip any extra bytes in the header, the TOC starts right after it.
+	if extra := int64(hdr.HeaderSize) - int64(binary.Size(hdr)); extra > 0 {
+		if _, err := io.CopyN(io.Discard, pkg, extra); err != nil {
+			return fmt.Errorf("reading header: %w", err)
+		}
+	}
+
+	toc, err := parseTOC(io.LimitReader(pkg, hdr.CompressedSize), hashType)
+	if err != nil {
+		return err
+	}
+
+	if toc.Signature == nil && toc.XSignature == nil {
+		return ErrNotSigned
+	}
+
+	return nil
+}
+
+// SigningChainError is returned by VerifyPKGSigningChain when the signing
+// certificate chain of a package is not valid.
+type SigningChainError struct {
+	Reason string
+}
+
+func (e *SigningChainError) Error() string {
+	return "invalid signing certificate chain: " + e.Reason
+}
+
+// PKGSigningCertificates returns the certificates of the signing chain of the
+// provided pkg (xar) file, the signing certificate first. Like
+// CheckPKGSignatureStream, it only consumes the bytes of the reader needed to
+// parse the xar header and TOC.
+//
+// - If the file is not xar, it returns a ErrInvalidType error
+// - If the file is not signed, it returns a ErrNotSigned error
+func PKGSigningCertificates(pkg io.Reader) ([]*x509.Certificate, error) {
+	hdr, hashType, err := parseHeader(pkg)
+	if err != nil {
+		return nil, err
+	}
+	if extra := int64(hdr.HeaderSize) - int64(binary.Size(hdr)); extra > 0 {
+		if _, err := io.CopyN(io.Discard, pkg, extra); err != nil {
+			return nil, fmt.Errorf("reading header: %w", err)
+		}
+	}
+
+	toc, err := parseTOC(io.LimitReader(pkg, hdr.CompressedSize), hashType)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := toc.Signature
+	if sig == nil {
+		sig = toc.XSignature
+	}
+	if sig == nil {
+		return nil, ErrNotSigned
+	}
+
+	certs := make([]*x509.Certificate, 0, len(sig.Certificates))
+	for i, enc := range sig.Certificates {
+		der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(enc), ""))
+		if err != nil {
+			return nil, fmt.Errorf("decoding certificate %d: %w", i, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate %d: %w", i, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// VerifyPKGSigningChain checks that the signing certificate chain of the
+// provided pkg (xar) file is valid at the given time: every certificate of
+// the chain must be within its validity period and be signed by the next
+// certificate of the chain. It doesn't check the revocation status of the
+// certificates.
+//
+// It returns the certificates of the chain, and a *SigningChainError if the
+// chain is not valid.
+func VerifyPKGSigningChain(pkg io.Reader, now time.Time) ([]*x509.Certificate, error) {
+	certs, err := PKGSigningCertificates(pkg)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, &SigningChainError{Reason: "the signature has no certificates"}
+	}
+
+	for i, cert := range certs {
+		switch {
+		case now.Before(cert.NotBefore):
+			return certs, &SigningChainError{Reason: fmt.Sprintf("certificate %q is not valid before %s", cert.Subject.CommonName, cert.NotBefore.UTC().Format(time.RFC3339))}
+		case now.After(cert.NotAfter):
+			return certs, &SigningChainError{Reason: fmt.Sprintf("certificate %q expired on %s", cert.Subject.CommonName, cert.NotAfter.UTC().Format(time.RFC3339))}
+		}
+		if i+1 < len(certs) {
+			if err := cert.CheckSignatureFrom(certs[i+1]); err != nil {
+				return certs, &SigningChainError{Reason: fmt.Sprintf("certificate %q is not signed by %q: %v", cert.Subject.CommonName, certs[i+1].Subject.CommonName, err)}
+			}
+		}
+	}
+	return certs, nil
+}
+
 func decompress(r io.Reader) ([]byte, error) {
 	zr, err := zlib.NewReader(r)
 	if err != nil {
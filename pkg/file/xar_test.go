@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -47,5 +48,47 @@ func TestCheckPKGSignature(t *testing.T) {
 		} else {
 			require.NoError(t, err)
 		}
+
+		// the streaming version must report the same errors
+		r = bytes.NewReader(c.in)
+		err = CheckPKGSignatureStream(r)
+		if c.out != nil {
+			require.ErrorContains(t, err, c.out.Error())
+			continue
+		}
+		require.NoError(t, err)
+
+		// and must not consume the bytes that follow the TOC (i.e. the heap)
+		heap := []byte("heap contents")
+		r = bytes.NewReader(append(append([]byte{}, c.in...), heap...))
+		require.NoError(t, CheckPKGSignatureStream(r))
+		require.Equal(t, len(heap), r.Len())
 	}
 }
+
+func TestVerifyPKGSigningChain(t *testing.T) {
+	signed, err := os.ReadFile("./testdata/signed.pkg")
+	require.NoError(t, err)
+	unsigned, err := os.ReadFile("./testdata/unsigned.pkg")
+	require.NoError(t, err)
+
+	_, err = VerifyPKGSigningChain(bytes.NewReader(unsigned), time.Now())
+	require.ErrorIs(t, err, ErrNotSigned)
+
+	certs, err := VerifyPKGSigningChain(bytes.NewReader(signed), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, certs, 3)
+	require.Equal(t, "Developer ID Installer: Roberto Dip (UK9WG435C6)", certs[0].Subject.CommonName)
+	require.Equal(t, "Apple Root CA", certs[2].Subject.CommonName)
+
+	// the signing certificate is not valid yet
+	_, err = VerifyPKGSigningChain(bytes.NewReader(signed), time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	var chainErr *SigningChainError
+	require.ErrorAs(t, err, &chainErr)
+	require.Contains(t, chainErr.Reason, "is not valid before 2023-04-04")
+
+	// the signing certificate and its issuer expired
+	_, err = VerifyPKGSigningChain(bytes.NewReader(signed), time.Date(2028, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.ErrorAs(t, err, &chainErr)
+	require.Equal(t, `certificate "Developer ID Installer: Roberto Dip (UK9WG435C6)" expired on 2027-02-01T22:12:15Z`, chainErr.Reason)
+}
@@ -0,0 +1,373 @@
+// Package mdmclient provides a typed client for the MDM endpoints of the Fleet
+// API (configuration profiles, commands and their results, summaries and disk
+// encryption keys).
+//
+// The request and response types are shared with the Fleet server's handlers.
+package mdmclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/fleetdm/fleet/v4/pkg/fleethttp"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+)
+
+// Client is a client for the MDM endpoints of the Fleet API. It is safe for
+// concurrent use.
+type Client struct {
+	baseURL *url.URL
+	token   string
+	http    *http.Client
+}
+
+// Option configures a Client.
+type Option func(c *Client)
+
+// WithHTTPClient sets the HTTP client used to send the requests. By default,
+// a client created by fleethttp.NewClient is used.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// New returns a Client for the Fleet server at addr (e.g.
+// https://fleet.example.com) that authenticates with the API token.
+func New(addr, token string, opts ...Option) (*Client, error) {
+	baseURL, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parse address: %w", err)
+	}
+	if baseURL.Scheme != "https" && baseURL.Scheme != "http" {
+		return nil, fmt.Errorf("address must start with https:// or http://: %s", addr)
+	}
+	if token == "" {
+		return nil, errors.New("missing API token")
+	}
+
+	c := &Client{
+		baseURL: baseURL,
+		token:   token,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.http == nil {
+		c.http = fleethttp.NewClient()
+	}
+	return c, nil
+}
+
+// Error is the error returned by the Client when the server responds with an
+// unsuccessful status code.
+type Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int `json:"-"`
+	// Message is the message of the error returned by the server.
+	Message string `json:"message"`
+	// Errors are the details of the error returned by the server, usually
+	// with "name" and "reason" keys.
+	Errors []map[string]string `json:"errors,omitempty"`
+}
+
+func (e *Error) Error() string {
+	reasons := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		if r := err["reason"]; r != "" {
+			reasons = append(reasons, r)
+		}
+	}
+	if len(reasons) == 0 {
+		return fmt.Sprintf("fleet: status %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("fleet: status %d: %s: %s", e.StatusCode, e.Message, strings.Join(reasons, "; "))
+}
+
+// IsNotFound returns true if the error is an *Error with a 404 status code.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// ListProfiles returns the configuration profiles of the team. A nil teamID
+// lists the profiles of hosts with no team.
+func (c *Client) ListProfiles(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleConfigProfile, error) {
+	query := url.Values{}
+	if teamID != nil {
+		query.Set("team_id", fmt.Sprint(*teamID))
+	}
+	var res ListProfilesResponse
+	if err := c.do(ctx, http.MethodGet, "/mdm/apple/profiles", query, nil, &res); err != nil {
+		return nil, err
+	}
+	return res.ConfigProfiles, nil
+}
+
+// ListAllTeamsProfiles returns the configuration profiles that apply to the
+// hosts of all teams and of no team.
+func (c *Client) ListAllTeamsProfiles(ctx context.Context) ([]*fleet.MDMAppleConfigProfile, error) {
+	query := url.Values{}
+	query.Set("all_teams", "true")
+	var res ListProfilesResponse
+	if err := c.do(ctx, http.MethodGet, "/mdm/apple/profiles", query, nil, &res); err != nil {
+		return nil, err
+	}
+	return res.ConfigProfiles, nil
+}
+
+// GetProfile returns the contents of the .mobileconfig file of the
+// configuration profile.
+func (c *Client) GetProfile(ctx context.Context, profileID uint) ([]byte, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("/mdm/apple/profiles/%d", profileID), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read profile: %w", err)
+	}
+	return b, nil
+}
+
+// NewProfileOptions are the options of NewProfile.
+type NewProfileOptions struct {
+	// Force uploads the profile even if its identifier is already used by a
+	// profile from another source on hosts of the team.
+	Force bool
+	// AcknowledgeReservedPayloads uploads the profile even if it contains
+	// payloads with a PayloadType reserved by Fleet.
+	AcknowledgeReservedPayloads bool
+}
+
+// NewProfile uploads the .mobileconfig file as a configuration profile of the
+// team (0 for no team) and returns the id of the new profile.
+func (c *Client) NewProfile(ctx context.Context, teamID uint, mobileconfig []byte, opts NewProfileOptions) (uint, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fw, err := w.CreateFormFile("profile", "profile.mobileconfig")
+	if err != nil {
+		return 0, fmt.Errorf("create profile form file: %w", err)
+	}
+	if _, err := fw.Write(mobileconfig); err != nil {
+		return 0, fmt.Errorf("write profile form file: %w", err)
+	}
+	fields := map[string]string{
+		"team_id":                       fmt.Sprint(teamID),
+		"force":                         strconv.FormatBool(opts.Force),
+		"acknowledge_reserved_payloads": strconv.FormatBool(opts.AcknowledgeReservedPayloads),
+	}
+	for k, v := range fields {
+		if err := w.WriteField(k, v); err != nil {
+			return 0, fmt.Errorf("write %s form field: %w", k, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return 0, fmt.Errorf("close multipart writer: %w", err)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/mdm/apple/profiles", nil, &body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	var res NewProfileResponse
+	if err := c.send(req, &res); err != nil {
+		return 0, err
+	}
+	return res.ProfileID, nil
+}
+
+// DeleteProfile deletes the configuration profile.
+func (c *Client) DeleteProfile(ctx context.Context, profileID uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/mdm/apple/profiles/%d", profileID), nil, nil, nil)
+}
+
+// EnqueueCommand enqueues the MDM command for the hosts of the request. The
+// command must be base64-encoded.
+func (c *Client) EnqueueCommand(ctx context.Context, cmd EnqueueCommandRequest) (*fleet.CommandEnqueueResult, error) {
+	var res EnqueueCommandResponse
+	if err := c.do(ctx, http.MethodPost, "/mdm/apple/enqueue", nil, cmd, &res); err != nil {
+		return nil, err
+	}
+	return res.CommandEnqueueResult, nil
+}
+
+// GetCommandResults returns the results of the command for the hosts that
+// responded to it.
+func (c *Client) GetCommandResults(ctx context.Context, commandUUID string) ([]*fleet.MDMAppleCommandResult, error) {
+	query := url.Values{}
+	query.Set("command_uuid", commandUUID)
+	var res GetCommandResultsResponse
+	if err := c.do(ctx, http.MethodGet, "/mdm/apple/commandresults", query, nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Results, nil
+}
+
+// ListCommandsOptions are the options of ListCommands.
+type ListCommandsOptions struct {
+	Page    uint
+	PerPage uint
+	// OrderKey is the field to sort the commands by (e.g. "updated_at").
+	OrderKey string
+	// OrderDescending sorts the commands in descending order.
+	OrderDescending bool
+	// CommandUUID lists the status of that command for each of the hosts it
+	// targets.
+	CommandUUID string
+}
+
+// ListCommands returns the MDM commands enqueued for the hosts.
+func (c *Client) ListCommands(ctx context.Context, opts ListCommandsOptions) ([]*fleet.MDMAppleCommand, error) {
+	query := url.Values{}
+	query.Set("page", fmt.Sprint(opts.Page))
+	if opts.PerPage > 0 {
+		query.Set("per_page", fmt.Sprint(opts.PerPage))
+	}
+	if opts.OrderKey != "" {
+		query.Set("order_key", opts.OrderKey)
+		if opts.OrderDescending {
+			query.Set("order_direction", "desc")
+		}
+	}
+	if opts.CommandUUID != "" {
+		query.Set("command_uuid", opts.CommandUUID)
+	}
+	var res ListCommandsResponse
+	if err := c.do(ctx, http.MethodGet, "/mdm/apple/commands", query, nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Results, nil
+}
+
+// GetProfilesSummary returns the number of hosts of the team by status of
+// their configuration profiles. A nil teamID summarizes the hosts with no
+// team.
+func (c *Client) GetProfilesSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleConfigProfilesSummary, error) {
+	var res GetProfilesSummaryResponse
+	if err := c.do(ctx, http.MethodGet, "/mdm/apple/profiles/summary", teamQuery(teamID), nil, &res); err != nil {
+		return nil, err
+	}
+	return &res.MDMAppleConfigProfilesSummary, nil
+}
+
+// GetFileVaultSummary returns the number of hosts of the team by status of
+// their disk encryption. A nil teamID summarizes the hosts with no team.
+func (c *Client) GetFileVaultSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleFileVaultSummary, error) {
+	var res GetFileVaultSummaryResponse
+	if err := c.do(ctx, http.MethodGet, "/mdm/apple/filevault/summary", teamQuery(teamID), nil, &res); err != nil {
+		return nil, err
+	}
+	return res.MDMAppleFileVaultSummary, nil
+}
+
+// GetHostEncryptionKey returns the disk encryption key of the host. The
+// justification is recorded with the access to the key.
+func (c *Client) GetHostEncryptionKey(ctx context.Context, hostID uint, justification string) (*fleet.HostDiskEncryptionKey, error) {
+	query := url.Values{}
+	if justification != "" {
+		query.Set("justification", justification)
+	}
+	var res GetHostEncryptionKeyResponse
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/mdm/hosts/%d/encryption_key", hostID), query, nil, &res); err != nil {
+		return nil, err
+	}
+	return res.EncryptionKey, nil
+}
+
+func teamQuery(teamID *uint) url.Values {
+	query := url.Values{}
+	if teamID != nil {
+		query.Set("team_id", fmt.Sprint(*teamID))
+	}
+	return query
+}
+
+// do sends the request with the JSON-encoded body (if not nil) and decodes
+// the JSON response in dst (if not nil).
+func (c *Client) do(ctx context.Context, verb, path string, query url.Values, body, dst interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encode request body: %w", err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := c.newRequest(ctx, verb, path, query, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req, dst)
+}
+
+func (c *Client) newRequest(ctx context.Context, verb, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := *c.baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/latest/fleet" + path
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, verb, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+func (c *Client) send(req *http.Request, dst interface{}) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	if dst == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(dst); err != nil {
+		return fmt.Errorf("decode %s %s response: %w", req.Method, req.URL.Path, err)
+	}
+	return nil
+}
+
+// checkResponse returns an *Error if the response has an unsuccessful status
+// code.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	e := &Error{StatusCode: resp.StatusCode}
+	b, err := io.ReadAll(resp.Body)
+	if err == nil && json.Unmarshal(b, e) != nil {
+		e.Message = strings.TrimSpace(string(b))
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
@@ -0,0 +1,145 @@
+package mdmclient
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New("fleet.example.com", "token")
+	require.ErrorContains(t, err, "address must start with https:// or http://")
+
+	_, err = New("https://fleet.example.com", "")
+	require.ErrorContains(t, err, "missing API token")
+
+	c, err := New("https://fleet.example.com", "token")
+	require.NoError(t, err)
+	require.NotNil(t, c.http)
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/latest/fleet/mdm/apple/profiles", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			require.Equal(t, "2", r.URL.Query().Get("team_id"))
+			_, _ = w.Write([]byte(`{"profiles": [{"profile_id": 1, "identifier": "com.example"}]}`))
+		case http.MethodPost:
+			require.NoError(t, r.ParseMultipartForm(1<<20))
+			require.Equal(t, "2", r.FormValue("team_id"))
+			require.Equal(t, "true", r.FormValue("force"))
+			require.Equal(t, "false", r.FormValue("acknowledge_reserved_payloads"))
+			f, _, err := r.FormFile("profile")
+			require.NoError(t, err)
+			b, err := io.ReadAll(f)
+			require.NoError(t, err)
+			require.Equal(t, "<plist/>", string(b))
+			_, _ = w.Write([]byte(`{"profile_id": 3}`))
+		}
+	})
+	mux.HandleFunc("/api/latest/fleet/mdm/apple/profiles/1", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = w.Write([]byte("<plist/>"))
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+	mux.HandleFunc("/api/latest/fleet/mdm/apple/profiles/2", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message": "Resource Not Found", "errors": [{"name": "base", "reason": "profile not found"}]}`))
+	})
+	mux.HandleFunc("/api/latest/fleet/mdm/apple/enqueue", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var req EnqueueCommandRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, EnqueueCommandRequest{Command: "Y21k", DeviceIDs: []string{"uuid-1"}}, req)
+		_, _ = w.Write([]byte(`{"command_uuid": "cmd-1", "request_type": "ProfileList"}`))
+	})
+	mux.HandleFunc("/api/latest/fleet/mdm/apple/commandresults", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "cmd-1", r.URL.Query().Get("command_uuid"))
+		_, _ = w.Write([]byte(`{"results": [{"device_id": "uuid-1", "command_uuid": "cmd-1", "status": "Acknowledged"}]}`))
+	})
+	mux.HandleFunc("/api/latest/fleet/mdm/apple/commands", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "updated_at", r.URL.Query().Get("order_key"))
+		require.Equal(t, "desc", r.URL.Query().Get("order_direction"))
+		_, _ = w.Write([]byte(`{"results": [{"device_id": "uuid-1", "command_uuid": "cmd-1"}]}`))
+	})
+	mux.HandleFunc("/api/latest/fleet/mdm/apple/profiles/summary", func(w http.ResponseWriter, r *http.Request) {
+		require.False(t, r.URL.Query().Has("team_id"))
+		_, _ = w.Write([]byte(`{"verifying": 1, "pending": 2, "failed": 3}`))
+	})
+	mux.HandleFunc("/api/latest/fleet/mdm/apple/filevault/summary", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "0", r.URL.Query().Get("team_id"))
+		_, _ = w.Write([]byte(`{"enforcing": 4}`))
+	})
+	mux.HandleFunc("/api/latest/fleet/mdm/hosts/5/encryption_key", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "audit", r.URL.Query().Get("justification"))
+		_, _ = w.Write([]byte(`{"host_id": 5, "encryption_key": {"key": "secret"}}`))
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, "token")
+	require.NoError(t, err)
+
+	teamID := uint(2)
+	profs, err := c.ListProfiles(ctx, &teamID)
+	require.NoError(t, err)
+	require.Len(t, profs, 1)
+	require.Equal(t, "com.example", profs[0].Identifier)
+
+	id, err := c.NewProfile(ctx, 2, []byte("<plist/>"), NewProfileOptions{Force: true})
+	require.NoError(t, err)
+	require.Equal(t, uint(3), id)
+
+	b, err := c.GetProfile(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "<plist/>", string(b))
+
+	require.NoError(t, c.DeleteProfile(ctx, 1))
+
+	err = c.DeleteProfile(ctx, 2)
+	require.True(t, IsNotFound(err))
+	require.EqualError(t, err, "fleet: status 404: Resource Not Found: profile not found")
+
+	res, err := c.EnqueueCommand(ctx, EnqueueCommandRequest{Command: "Y21k", DeviceIDs: []string{"uuid-1"}})
+	require.NoError(t, err)
+	require.Equal(t, &fleet.CommandEnqueueResult{CommandUUID: "cmd-1", RequestType: "ProfileList"}, res)
+
+	results, err := c.GetCommandResults(ctx, "cmd-1")
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, "Acknowledged", results[0].Status)
+
+	cmds, err := c.ListCommands(ctx, ListCommandsOptions{OrderKey: "updated_at", OrderDescending: true})
+	require.NoError(t, err)
+	require.Len(t, cmds, 1)
+	require.Equal(t, "cmd-1", cmds[0].CommandUUID)
+
+	ps, err := c.GetProfilesSummary(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint(1), ps.Verifying)
+	require.Equal(t, uint(2), ps.Pending)
+	require.Equal(t, uint(3), ps.Failed)
+
+	fvs, err := c.GetFileVaultSummary(ctx, new(uint))
+	require.NoError(t, err)
+	require.Equal(t, uint(4), fvs.Enforcing)
+
+	key, err := c.GetHostEncryptionKey(ctx, 5, "audit")
+	require.NoError(t, err)
+	require.Equal(t, "secret", key.DecryptedValue)
+}
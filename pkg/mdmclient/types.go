@@ -0,0 +1,92 @@
+package mdmclient
+
+import (
+	"github.com/fleetdm/fleet/v4/server/fleet"
+)
+
+// The types below are the request and response bodies of the MDM endpoints
+// of the Fleet API. They are shared with the server's handlers, so that the
+// client and the server can't diverge. The response types don't include the
+// error field of the server's responses, errors are returned as *Error by the
+// Client.
+
+// EnqueueCommandRequest is the body of POST /mdm/apple/enqueue.
+type EnqueueCommandRequest struct {
+	// Command is the base64-encoded plist of the MDM command.
+	Command string `json:"command"`
+	// DeviceIDs are the UUIDs of the hosts targeted by the command.
+	DeviceIDs []string `json:"device_ids"`
+	// TargetID is the id of the MDM host target whose hosts are targeted by
+	// the command, instead of DeviceIDs.
+	TargetID *uint `json:"target_id,omitempty"`
+	// TeamIDs are the ids of the teams whose hosts enrolled in Fleet's MDM
+	// are targeted by the command, instead of DeviceIDs. The id 0 targets the
+	// hosts that don't belong to any team.
+	TeamIDs []uint `json:"team_ids,omitempty"`
+	// LabelID is the id of the label whose hosts enrolled in Fleet's MDM are
+	// targeted by the command, instead of DeviceIDs.
+	LabelID  *uint                         `json:"label_id,omitempty"`
+	Priority fleet.MDMAppleCommandPriority `json:"priority"`
+}
+
+// EnqueueCommandResponse is the response of POST /mdm/apple/enqueue.
+type EnqueueCommandResponse struct {
+	*fleet.CommandEnqueueResult
+}
+
+// GetCommandResultsRequest is the query of GET /mdm/apple/commandresults.
+type GetCommandResultsRequest struct {
+	CommandUUID string `query:"command_uuid,optional"`
+}
+
+// GetCommandResultsResponse is the response of GET /mdm/apple/commandresults.
+type GetCommandResultsResponse struct {
+	Results []*fleet.MDMAppleCommandResult `json:"results,omitempty"`
+}
+
+// ListCommandsResponse is the response of GET /mdm/apple/commands.
+type ListCommandsResponse struct {
+	Results []*fleet.MDMAppleCommand `json:"results"`
+}
+
+// ListProfilesRequest is the query of GET /mdm/apple/profiles.
+type ListProfilesRequest struct {
+	TeamID   uint `query:"team_id,optional"`
+	AllTeams bool `query:"all_teams,optional"`
+}
+
+// ListProfilesResponse is the response of GET /mdm/apple/profiles.
+type ListProfilesResponse struct {
+	ConfigProfiles []*fleet.MDMAppleConfigProfile `json:"profiles"`
+}
+
+// NewProfileResponse is the response of POST /mdm/apple/profiles.
+type NewProfileResponse struct {
+	ProfileID uint `json:"profile_id"`
+}
+
+// GetProfilesSummaryRequest is the query of GET /mdm/apple/profiles/summary.
+type GetProfilesSummaryRequest struct {
+	TeamID *uint `query:"team_id,optional"`
+}
+
+// GetProfilesSummaryResponse is the response of GET /mdm/apple/profiles/summary.
+type GetProfilesSummaryResponse struct {
+	fleet.MDMAppleConfigProfilesSummary
+}
+
+// GetFileVaultSummaryRequest is the query of GET /mdm/apple/filevault/summary.
+type GetFileVaultSummaryRequest struct {
+	TeamID *uint `query:"team_id,optional"`
+}
+
+// GetFileVaultSummaryResponse is the response of GET /mdm/apple/filevault/summary.
+type GetFileVaultSummaryResponse struct {
+	*fleet.MDMAppleFileVaultSummary
+}
+
+// GetHostEncryptionKeyResponse is the response of GET /mdm/hosts/{id}/encryption_key.
+type GetHostEncryptionKeyResponse struct {
+	EncryptionKey *fleet.HostDiskEncryptionKey `json:"encryption_key,omitempty"`
+	HostID        uint                         `json:"host_id,omitempty"`
+}
@@ -207,6 +207,30 @@ allow {
 
 # (Observers are not granted read for enroll secrets)
 
+##
+# Fleetd configuration profiles
+##
+
+# Global admins, maintainers and observer_plus can download the fleetd
+# configuration profiles. The profiles embed the enroll secret of the team, or
+# the global one, in plaintext, so downloading a profile gives access to that
+# secret even though the enroll secrets endpoints can't be read.
+allow {
+  object.type == "fleetd_profile"
+  subject.global_role == [admin, maintainer, observer_plus][_]
+  action == read
+}
+
+# Team admins, maintainers and observer_plus can download the fleetd
+# configuration profile of their teams, if the team has its own enroll secret
+# (the global one is not embedded for them, see GetFleetdProfile).
+allow {
+  not is_null(object.team_id)
+  object.type == "fleetd_profile"
+  team_role(subject, object.team_id) == [admin, maintainer, observer_plus][_]
+  action == read
+}
+
 ##
 # Hosts
 ##
@@ -578,6 +602,13 @@ allow {
   action == [read, write][_]
 }
 
+# Global observers and observer_plus can read Apple MDM config profiles.
+allow {
+  object.type == "mdm_apple_config_profile"
+  subject.global_role == [observer, observer_plus][_]
+  action == read
+}
+
 # Global gitops can write Apple MDM config profiles.
 allow {
   object.type == "mdm_apple_config_profile"
@@ -594,6 +625,15 @@ allow {
   action == [read, write][_]
 }
 
+# Team observers and observer_plus can read Apple MDM config profiles on their teams.
+allow {
+  not is_null(object.team_id)
+  object.team_id != 0
+  object.type == "mdm_apple_config_profile"
+  team_role(subject, object.team_id) == [observer, observer_plus][_]
+  action == read
+}
+
 # Team gitops can write Apple MDM config profiles on their teams.
 allow {
   not is_null(object.team_id)
@@ -603,6 +643,42 @@ allow {
   action == write
 }
 
+# Global admins and maintainers can read and write MDM host targets.
+allow {
+  object.type == "mdm_host_target"
+  subject.global_role == [admin, maintainer][_]
+  action == [read, write][_]
+}
+
+# Global observers and observer_plus can read MDM host targets.
+allow {
+  object.type == "mdm_host_target"
+  subject.global_role == [observer, observer_plus][_]
+  action == read
+}
+
+# Global gitops can write MDM host targets.
+allow {
+  object.type == "mdm_host_target"
+  subject.global_role == gitops
+  action == write
+}
+
+# Team admins and maintainers can read MDM host targets, to use them for the
+# profiles and commands of their teams.
+allow {
+  object.type == "mdm_host_target"
+  team_role(subject, subject.teams[_].id) == [admin, maintainer][_]
+  action == read
+}
+
+# Only global admins can read the security audit log.
+allow {
+  object.type == "security_audit_log"
+  subject.global_role == admin
+  action == read
+}
+
 # Global admins can read and write MDM apple information.
 allow {
   object.type == "mdm_apple"
@@ -632,6 +708,23 @@ allow {
   action == write
 }
 
+# Global gitops can write (execute) the MDM Apple commands allowed for gitops.
+allow {
+  object.type == "mdm_apple_command"
+  object.gitops_allowed == true
+  subject.global_role == gitops
+  action == write
+}
+
+# Team gitops can write (execute) the MDM Apple commands allowed for gitops on hosts of their teams.
+allow {
+  not is_null(object.team_id)
+  object.type == "mdm_apple_command"
+  object.gitops_allowed == true
+  team_role(subject, object.team_id) == gitops
+  action == write
+}
+
 # Global admins, maintainers, observers and observer_plus can read MDM Apple commands.
 allow {
   object.type == "mdm_apple_command"
@@ -647,6 +740,36 @@ allow {
   action == read
 }
 
+# Global admins can read the Activation Lock bypass codes of hosts.
+allow {
+  object.type == "mdm_apple_activation_lock_bypass_code"
+  subject.global_role == admin
+  action == read
+}
+
+# Team admins can read the Activation Lock bypass codes of hosts of their teams.
+allow {
+  not is_null(object.team_id)
+  object.type == "mdm_apple_activation_lock_bypass_code"
+  team_role(subject, object.team_id) == admin
+  action == read
+}
+
+# Global admins can write (purge) the MDM data of hosts.
+allow {
+  object.type == "mdm_apple_host_data"
+  subject.global_role == admin
+  action == write
+}
+
+# Team admins can write (purge) the MDM data of hosts of their teams.
+allow {
+  not is_null(object.team_id)
+  object.type == "mdm_apple_host_data"
+  team_role(subject, object.team_id) == admin
+  action == write
+}
+
 # Global admins can read and write Apple MDM installers.
 allow {
   object.type == "mdm_apple_installer"
@@ -761,6 +884,18 @@ allow {
   action == [read, write][_]
 }
 
+##
+# SCIM
+##
+
+# Global admins can read and write the users and groups provisioned via SCIM.
+# The IdP authenticates with the API token of an API-only global admin.
+allow {
+  object.type == "scim"
+  subject.global_role == admin
+  action == [read, write][_]
+}
+
 ##
 # Version
 ##
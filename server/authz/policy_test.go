@@ -371,6 +371,89 @@ func TestAuthorizeEnrollSecret(t *testing.T) {
 	})
 }
 
+func TestAuthorizeFleetdProfile(t *testing.T) {
+	t.Parallel()
+
+	teamMaintainer := &fleet.User{
+		Teams: []fleet.UserTeam{
+			{Team: fleet.Team{ID: 1}, Role: fleet.RoleMaintainer},
+		},
+	}
+	teamObserver := &fleet.User{
+		Teams: []fleet.UserTeam{
+			{Team: fleet.Team{ID: 1}, Role: fleet.RoleObserver},
+		},
+	}
+	teamObserverPlus := &fleet.User{
+		Teams: []fleet.UserTeam{
+			{Team: fleet.Team{ID: 1}, Role: fleet.RoleObserverPlus},
+		},
+	}
+	teamGitOps := &fleet.User{
+		Teams: []fleet.UserTeam{
+			{Team: fleet.Team{ID: 1}, Role: fleet.RoleGitOps},
+		},
+	}
+	globalProfile := fleet.FleetdProfileAuthz{}
+	teamProfile := fleet.FleetdProfileAuthz{TeamID: ptr.Uint(1)}
+	otherTeamProfile := fleet.FleetdProfileAuthz{TeamID: ptr.Uint(2)}
+	runTestCases(t, []authTestCase{
+		{user: nil, object: globalProfile, action: read, allow: false},
+		{user: test.UserNoRoles, object: globalProfile, action: read, allow: false},
+		{user: test.UserNoRoles, object: teamProfile, action: read, allow: false},
+
+		{user: test.UserAdmin, object: globalProfile, action: read, allow: true},
+		{user: test.UserAdmin, object: teamProfile, action: read, allow: true},
+		{user: test.UserMaintainer, object: globalProfile, action: read, allow: true},
+		{user: test.UserMaintainer, object: teamProfile, action: read, allow: true},
+		{user: test.UserGitOps, object: globalProfile, action: read, allow: false},
+		{user: test.UserGitOps, object: teamProfile, action: read, allow: false},
+
+		// observer_plus can download the profiles, but can't read the enroll
+		// secrets.
+		{user: test.UserObserverPlus, object: globalProfile, action: read, allow: true},
+		{user: test.UserObserverPlus, object: teamProfile, action: read, allow: true},
+		{user: test.UserObserver, object: globalProfile, action: read, allow: false},
+		{user: test.UserObserver, object: teamProfile, action: read, allow: false},
+
+		{user: teamMaintainer, object: globalProfile, action: read, allow: false},
+		{user: teamMaintainer, object: teamProfile, action: read, allow: true},
+		{user: teamMaintainer, object: otherTeamProfile, action: read, allow: false},
+		{user: teamObserverPlus, object: globalProfile, action: read, allow: false},
+		{user: teamObserverPlus, object: teamProfile, action: read, allow: true},
+		{user: teamObserverPlus, object: otherTeamProfile, action: read, allow: false},
+		{user: teamObserver, object: teamProfile, action: read, allow: false},
+		{user: teamGitOps, object: teamProfile, action: read, allow: false},
+	})
+}
+
+func TestAuthorizeScim(t *testing.T) {
+	t.Parallel()
+
+	teamAdmin := &fleet.User{
+		Teams: []fleet.UserTeam{
+			{Team: fleet.Team{ID: 1}, Role: fleet.RoleAdmin},
+		},
+	}
+	scim := fleet.ScimAuthz{}
+	runTestCases(t, []authTestCase{
+		{user: nil, object: scim, action: read, allow: false},
+		{user: nil, object: scim, action: write, allow: false},
+		{user: test.UserNoRoles, object: scim, action: read, allow: false},
+		{user: test.UserNoRoles, object: scim, action: write, allow: false},
+
+		{user: test.UserAdmin, object: scim, action: read, allow: true},
+		{user: test.UserAdmin, object: scim, action: write, allow: true},
+		{user: test.UserMaintainer, object: scim, action: read, allow: false},
+		{user: test.UserMaintainer, object: scim, action: write, allow: false},
+		{user: test.UserObserverPlus, object: scim, action: read, allow: false},
+		{user: test.UserObserver, object: scim, action: read, allow: false},
+		{user: test.UserGitOps, object: scim, action: write, allow: false},
+
+		{user: teamAdmin, object: scim, action: read, allow: false},
+		{user: teamAdmin, object: scim, action: write, allow: false},
+	})
+}
 func TestAuthorizeTeam(t *testing.T) {
 	t.Parallel()
 
@@ -1210,6 +1293,65 @@ func TestAuthorizeTeamPolicy(t *testing.T) {
 	})
 }
 
+func TestAuthorizeMDMHostTarget(t *testing.T) {
+	t.Parallel()
+
+	target := &fleet.MDMHostTarget{}
+	runTestCases(t, []authTestCase{
+		{user: test.UserNoRoles, object: target, action: write, allow: false},
+		{user: test.UserNoRoles, object: target, action: read, allow: false},
+
+		{user: test.UserAdmin, object: target, action: write, allow: true},
+		{user: test.UserAdmin, object: target, action: read, allow: true},
+
+		{user: test.UserMaintainer, object: target, action: write, allow: true},
+		{user: test.UserMaintainer, object: target, action: read, allow: true},
+
+		{user: test.UserObserver, object: target, action: write, allow: false},
+		{user: test.UserObserver, object: target, action: read, allow: true},
+
+		{user: test.UserObserverPlus, object: target, action: write, allow: false},
+		{user: test.UserObserverPlus, object: target, action: read, allow: true},
+
+		{user: test.UserGitOps, object: target, action: write, allow: true},
+		{user: test.UserGitOps, object: target, action: read, allow: false},
+
+		{user: test.UserTeamAdminTeam1, object: target, action: write, allow: false},
+		{user: test.UserTeamAdminTeam1, object: target, action: read, allow: true},
+
+		{user: test.UserTeamMaintainerTeam1, object: target, action: write, allow: false},
+		{user: test.UserTeamMaintainerTeam1, object: target, action: read, allow: true},
+
+		{user: test.UserTeamObserverTeam1, object: target, action: write, allow: false},
+		{user: test.UserTeamObserverTeam1, object: target, action: read, allow: false},
+
+		{user: test.UserTeamObserverPlusTeam1, object: target, action: write, allow: false},
+		{user: test.UserTeamObserverPlusTeam1, object: target, action: read, allow: false},
+
+		{user: test.UserTeamGitOpsTeam1, object: target, action: write, allow: false},
+		{user: test.UserTeamGitOpsTeam1, object: target, action: read, allow: false},
+	})
+}
+
+func TestAuthorizeSecurityAuditLog(t *testing.T) {
+	t.Parallel()
+
+	log := &fleet.SecurityAuditLogEntry{}
+	runTestCases(t, []authTestCase{
+		{user: test.UserNoRoles, object: log, action: read, allow: false},
+		{user: test.UserAdmin, object: log, action: read, allow: true},
+		{user: test.UserMaintainer, object: log, action: read, allow: false},
+		{user: test.UserObserver, object: log, action: read, allow: false},
+		{user: test.UserObserverPlus, object: log, action: read, allow: false},
+		{user: test.UserGitOps, object: log, action: read, allow: false},
+		{user: test.UserTeamAdminTeam1, object: log, action: read, allow: false},
+		{user: test.UserTeamMaintainerTeam1, object: log, action: read, allow: false},
+
+		// the log is append-only, no one can write it
+		{user: test.UserAdmin, object: log, action: write, allow: false},
+	})
+}
+
 func TestAuthorizeMDMAppleConfigProfile(t *testing.T) {
 	t.Parallel()
 
@@ -1234,14 +1376,14 @@ func TestAuthorizeMDMAppleConfigProfile(t *testing.T) {
 		{user: test.UserMaintainer, object: team1Profile, action: read, allow: true},
 
 		{user: test.UserObserver, object: globalProfile, action: write, allow: false},
-		{user: test.UserObserver, object: globalProfile, action: read, allow: false},
+		{user: test.UserObserver, object: globalProfile, action: read, allow: true},
 		{user: test.UserObserver, object: team1Profile, action: write, allow: false},
-		{user: test.UserObserver, object: team1Profile, action: read, allow: false},
+		{user: test.UserObserver, object: team1Profile, action: read, allow: true},
 
 		{user: test.UserObserverPlus, object: globalProfile, action: write, allow: false},
-		{user: test.UserObserverPlus, object: globalProfile, action: read, allow: false},
+		{user: test.UserObserverPlus, object: globalProfile, action: read, allow: true},
 		{user: test.UserObserverPlus, object: team1Profile, action: write, allow: false},
-		{user: test.UserObserverPlus, object: team1Profile, action: read, allow: false},
+		{user: test.UserObserverPlus, object: team1Profile, action: read, allow: true},
 
 		{user: test.UserGitOps, object: globalProfile, action: write, allow: true},
 		{user: test.UserGitOps, object: globalProfile, action: read, allow: false},
@@ -1271,7 +1413,7 @@ func TestAuthorizeMDMAppleConfigProfile(t *testing.T) {
 		{user: test.UserTeamObserverTeam1, object: globalProfile, action: write, allow: false},
 		{user: test.UserTeamObserverTeam1, object: globalProfile, action: read, allow: false},
 		{user: test.UserTeamObserverTeam1, object: team1Profile, action: write, allow: false},
-		{user: test.UserTeamObserverTeam1, object: team1Profile, action: read, allow: false},
+		{user: test.UserTeamObserverTeam1, object: team1Profile, action: read, allow: true},
 
 		{user: test.UserTeamObserverTeam2, object: globalProfile, action: write, allow: false},
 		{user: test.UserTeamObserverTeam2, object: globalProfile, action: read, allow: false},
@@ -1281,7 +1423,7 @@ func TestAuthorizeMDMAppleConfigProfile(t *testing.T) {
 		{user: test.UserTeamObserverPlusTeam1, object: globalProfile, action: write, allow: false},
 		{user: test.UserTeamObserverPlusTeam1, object: globalProfile, action: read, allow: false},
 		{user: test.UserTeamObserverPlusTeam1, object: team1Profile, action: write, allow: false},
-		{user: test.UserTeamObserverPlusTeam1, object: team1Profile, action: read, allow: false},
+		{user: test.UserTeamObserverPlusTeam1, object: team1Profile, action: read, allow: true},
 
 		{user: test.UserTeamObserverPlusTeam2, object: globalProfile, action: write, allow: false},
 		{user: test.UserTeamObserverPlusTeam2, object: globalProfile, action: read, allow: false},
@@ -1538,6 +1680,132 @@ func runTestCases(t *testing.T, testCases []authTestCase) {
 	}
 }
 
+func TestAuthorizeMDMAppleActivationLockBypassCode(t *testing.T) {
+	t.Parallel()
+
+	globalCode := &fleet.MDMAppleActivationLockBypassCodeAuthz{}
+	team1Code := &fleet.MDMAppleActivationLockBypassCodeAuthz{
+		TeamID: ptr.Uint(1),
+	}
+	runTestCases(t, []authTestCase{
+		{user: test.UserNoRoles, object: globalCode, action: write, allow: false},
+		{user: test.UserNoRoles, object: globalCode, action: read, allow: false},
+		{user: test.UserNoRoles, object: team1Code, action: write, allow: false},
+		{user: test.UserNoRoles, object: team1Code, action: read, allow: false},
+
+		{user: test.UserAdmin, object: globalCode, action: write, allow: false},
+		{user: test.UserAdmin, object: globalCode, action: read, allow: true},
+		{user: test.UserAdmin, object: team1Code, action: write, allow: false},
+		{user: test.UserAdmin, object: team1Code, action: read, allow: true},
+
+		{user: test.UserMaintainer, object: globalCode, action: write, allow: false},
+		{user: test.UserMaintainer, object: globalCode, action: read, allow: false},
+		{user: test.UserMaintainer, object: team1Code, action: write, allow: false},
+		{user: test.UserMaintainer, object: team1Code, action: read, allow: false},
+
+		{user: test.UserObserver, object: globalCode, action: write, allow: false},
+		{user: test.UserObserver, object: globalCode, action: read, allow: false},
+		{user: test.UserObserver, object: team1Code, action: write, allow: false},
+		{user: test.UserObserver, object: team1Code, action: read, allow: false},
+
+		{user: test.UserObserverPlus, object: globalCode, action: write, allow: false},
+		{user: test.UserObserverPlus, object: globalCode, action: read, allow: false},
+		{user: test.UserObserverPlus, object: team1Code, action: write, allow: false},
+		{user: test.UserObserverPlus, object: team1Code, action: read, allow: false},
+
+		{user: test.UserGitOps, object: globalCode, action: write, allow: false},
+		{user: test.UserGitOps, object: globalCode, action: read, allow: false},
+		{user: test.UserGitOps, object: team1Code, action: write, allow: false},
+		{user: test.UserGitOps, object: team1Code, action: read, allow: false},
+
+		{user: test.UserTeamAdminTeam1, object: globalCode, action: write, allow: false},
+		{user: test.UserTeamAdminTeam1, object: globalCode, action: read, allow: false},
+		{user: test.UserTeamAdminTeam1, object: team1Code, action: write, allow: false},
+		{user: test.UserTeamAdminTeam1, object: team1Code, action: read, allow: true},
+
+		{user: test.UserTeamAdminTeam2, object: globalCode, action: write, allow: false},
+		{user: test.UserTeamAdminTeam2, object: globalCode, action: read, allow: false},
+		{user: test.UserTeamAdminTeam2, object: team1Code, action: write, allow: false},
+		{user: test.UserTeamAdminTeam2, object: team1Code, action: read, allow: false},
+
+		{user: test.UserTeamMaintainerTeam1, object: globalCode, action: write, allow: false},
+		{user: test.UserTeamMaintainerTeam1, object: globalCode, action: read, allow: false},
+		{user: test.UserTeamMaintainerTeam1, object: team1Code, action: write, allow: false},
+		{user: test.UserTeamMaintainerTeam1, object: team1Code, action: read, allow: false},
+
+		{user: test.UserTeamMaintainerTeam2, object: globalCode, action: write, allow: false},
+		{user: test.UserTeamMaintainerTeam2, object: globalCode, action: read, allow: false},
+		{user: test.UserTeamMaintainerTeam2, object: team1Code, action: write, allow: false},
+		{user: test.UserTeamMaintainerTeam2, object: team1Code, action: read, allow: false},
+
+		{user: test.UserTeamObserverTeam1, object: globalCode, action: write, allow: false},
+		{user: test.UserTeamObserverTeam1, object: globalCode, action: read, allow: false},
+		{user: test.UserTeamObserverTeam1, object: team1Code, action: write, allow: false},
+		{user: test.UserTeamObserverTeam1, object: team1Code, action: read, allow: false},
+
+		{user: test.UserTeamObserverTeam2, object: globalCode, action: write, allow: false},
+		{user: test.UserTeamObserverTeam2, object: globalCode, action: read, allow: false},
+		{user: test.UserTeamObserverTeam2, object: team1Code, action: write, allow: false},
+		{user: test.UserTeamObserverTeam2, object: team1Code, action: read, allow: false},
+
+		{user: test.UserTeamObserverPlusTeam1, object: globalCode, action: write, allow: false},
+		{user: test.UserTeamObserverPlusTeam1, object: globalCode, action: read, allow: false},
+		{user: test.UserTeamObserverPlusTeam1, object: team1Code, action: write, allow: false},
+		{user: test.UserTeamObserverPlusTeam1, object: team1Code, action: read, allow: false},
+
+		{user: test.UserTeamObserverPlusTeam2, object: globalCode, action: write, allow: false},
+		{user: test.UserTeamObserverPlusTeam2, object: globalCode, action: read, allow: false},
+		{user: test.UserTeamObserverPlusTeam2, object: team1Code, action: write, allow: false},
+		{user: test.UserTeamObserverPlusTeam2, object: team1Code, action: read, allow: false},
+
+		{user: test.UserTeamGitOpsTeam1, object: globalCode, action: write, allow: false},
+		{user: test.UserTeamGitOpsTeam1, object: globalCode, action: read, allow: false},
+		{user: test.UserTeamGitOpsTeam1, object: team1Code, action: write, allow: false},
+		{user: test.UserTeamGitOpsTeam1, object: team1Code, action: read, allow: false},
+
+		{user: test.UserTeamGitOpsTeam2, object: globalCode, action: write, allow: false},
+		{user: test.UserTeamGitOpsTeam2, object: globalCode, action: read, allow: false},
+		{user: test.UserTeamGitOpsTeam2, object: team1Code, action: write, allow: false},
+		{user: test.UserTeamGitOpsTeam2, object: team1Code, action: read, allow: false},
+	})
+}
+
+func TestAuthorizeMDMAppleHostData(t *testing.T) {
+	t.Parallel()
+
+	globalHost := &fleet.MDMAppleHostDataAuthz{}
+	team1Host := &fleet.MDMAppleHostDataAuthz{
+		TeamID: ptr.Uint(1),
+	}
+	runTestCases(t, []authTestCase{
+		{user: test.UserNoRoles, object: globalHost, action: write, allow: false},
+		{user: test.UserNoRoles, object: team1Host, action: write, allow: false},
+
+		{user: test.UserAdmin, object: globalHost, action: write, allow: true},
+		{user: test.UserAdmin, object: globalHost, action: read, allow: false},
+		{user: test.UserAdmin, object: team1Host, action: write, allow: true},
+
+		{user: test.UserMaintainer, object: globalHost, action: write, allow: false},
+		{user: test.UserMaintainer, object: team1Host, action: write, allow: false},
+
+		{user: test.UserObserver, object: globalHost, action: write, allow: false},
+		{user: test.UserObserverPlus, object: globalHost, action: write, allow: false},
+		{user: test.UserGitOps, object: globalHost, action: write, allow: false},
+		{user: test.UserGitOps, object: team1Host, action: write, allow: false},
+
+		{user: test.UserTeamAdminTeam1, object: globalHost, action: write, allow: false},
+		{user: test.UserTeamAdminTeam1, object: team1Host, action: write, allow: true},
+		{user: test.UserTeamAdminTeam1, object: team1Host, action: read, allow: false},
+
+		{user: test.UserTeamAdminTeam2, object: team1Host, action: write, allow: false},
+
+		{user: test.UserTeamMaintainerTeam1, object: team1Host, action: write, allow: false},
+		{user: test.UserTeamObserverTeam1, object: team1Host, action: write, allow: false},
+		{user: test.UserTeamObserverPlusTeam1, object: team1Host, action: write, allow: false},
+		{user: test.UserTeamGitOpsTeam1, object: team1Host, action: write, allow: false},
+	})
+}
+
 func TestAuthorizeMDMAppleCommand(t *testing.T) {
 	t.Parallel()
 
@@ -1545,6 +1813,13 @@ func TestAuthorizeMDMAppleCommand(t *testing.T) {
 	team1Command := &fleet.MDMAppleCommandAuthz{
 		TeamID: ptr.Uint(1),
 	}
+	globalGitOpsCommand := &fleet.MDMAppleCommandAuthz{
+		GitOpsAllowed: true,
+	}
+	team1GitOpsCommand := &fleet.MDMAppleCommandAuthz{
+		TeamID:        ptr.Uint(1),
+		GitOpsAllowed: true,
+	}
 	runTestCases(t, []authTestCase{
 		{user: test.UserNoRoles, object: globalCommand, action: write, allow: false},
 		{user: test.UserNoRoles, object: globalCommand, action: read, allow: false},
@@ -1625,6 +1900,24 @@ func TestAuthorizeMDMAppleCommand(t *testing.T) {
 		{user: test.UserTeamGitOpsTeam2, object: globalCommand, action: read, allow: false},
 		{user: test.UserTeamGitOpsTeam2, object: team1Command, action: write, allow: false},
 		{user: test.UserTeamGitOpsTeam2, object: team1Command, action: read, allow: false},
+
+		// commands allowed for gitops
+		{user: test.UserGitOps, object: globalGitOpsCommand, action: write, allow: true},
+		{user: test.UserGitOps, object: globalGitOpsCommand, action: read, allow: false},
+		{user: test.UserGitOps, object: team1GitOpsCommand, action: write, allow: true},
+		{user: test.UserGitOps, object: team1GitOpsCommand, action: read, allow: false},
+
+		{user: test.UserTeamGitOpsTeam1, object: globalGitOpsCommand, action: write, allow: false},
+		{user: test.UserTeamGitOpsTeam1, object: team1GitOpsCommand, action: write, allow: true},
+		{user: test.UserTeamGitOpsTeam1, object: team1GitOpsCommand, action: read, allow: false},
+
+		{user: test.UserTeamGitOpsTeam2, object: globalGitOpsCommand, action: write, allow: false},
+		{user: test.UserTeamGitOpsTeam2, object: team1GitOpsCommand, action: write, allow: false},
+
+		{user: test.UserObserver, object: globalGitOpsCommand, action: write, allow: false},
+		{user: test.UserTeamObserverTeam1, object: team1GitOpsCommand, action: write, allow: false},
+		{user: test.UserMaintainer, object: globalGitOpsCommand, action: write, allow: true},
+		{user: test.UserTeamMaintainerTeam1, object: team1GitOpsCommand, action: write, allow: true},
 	})
 }
 
@@ -2,6 +2,9 @@ package config
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -94,6 +97,7 @@ type ServerConfig struct {
 	Keepalive                   bool   `yaml:"keepalive"`
 	SandboxEnabled              bool   `yaml:"sandbox_enabled"`
 	WebsocketsAllowUnsafeOrigin bool   `yaml:"websockets_allow_unsafe_origin"`
+	PrivateKey                  string `yaml:"private_key"`
 }
 
 func (s *ServerConfig) DefaultHTTPServer(ctx context.Context, handler http.Handler) *http.Server {
@@ -233,6 +237,10 @@ type ActivityConfig struct {
 	EnableAuditLog bool `yaml:"enable_audit_log"`
 	// AuditLogPlugin sets the plugin to use to log activities.
 	AuditLogPlugin string `yaml:"audit_log_plugin"`
+	// SecurityAuditLogPlugin sets the plugin to use to stream the security
+	// audit log. If empty, the security audit log is only stored in the
+	// database.
+	SecurityAuditLogPlugin string `yaml:"security_audit_log_plugin"`
 }
 
 // FirehoseConfig defines configs for the AWS Firehose logging plugin
@@ -311,6 +319,7 @@ type FilesystemConfig struct {
 	StatusLogFile        string `json:"status_log_file" yaml:"status_log_file"`
 	ResultLogFile        string `json:"result_log_file" yaml:"result_log_file"`
 	AuditLogFile         string `json:"audit_log_file" yaml:"audit_log_file"`
+	SecurityAuditLogFile string `json:"security_audit_log_file" yaml:"security_audit_log_file"`
 	EnableLogRotation    bool   `json:"enable_log_rotation" yaml:"enable_log_rotation"`
 	EnableLogCompression bool   `json:"enable_log_compression" yaml:"enable_log_compression"`
 	MaxSize              int    `json:"max_size" yaml:"max_size"`
@@ -430,6 +439,15 @@ type MDMConfig struct {
 	AppleSCEPKey       string `yaml:"apple_scep_key"`
 	AppleSCEPKeyBytes  string `yaml:"apple_scep_key_bytes"`
 
+	// AppleSCEPPrevious* hold the SCEP CA certificate and key that were
+	// replaced by AppleSCEP* during a CA rotation. The previous CA doesn't
+	// issue new identities, but the identities it issued are still accepted
+	// until the devices renew them or re-enroll.
+	AppleSCEPPreviousCert      string `yaml:"apple_scep_previous_cert"`
+	AppleSCEPPreviousCertBytes string `yaml:"apple_scep_previous_cert_bytes"`
+	AppleSCEPPreviousKey       string `yaml:"apple_scep_previous_key"`
+	AppleSCEPPreviousKeyBytes  string `yaml:"apple_scep_previous_key_bytes"`
+
 	// the following fields hold the parsed, validated TLS certificate set the
 	// first time AppleAPNs or AppleSCEP is called, as well as the PEM-encoded
 	// bytes for the certificate and private key.
@@ -439,6 +457,35 @@ type MDMConfig struct {
 	appleSCEP        *tls.Certificate
 	appleSCEPPEMCert []byte
 	appleSCEPPEMKey  []byte
+	appleSCEPPrev    *tls.Certificate
+
+	// WindowsWSTEPIdentity* hold the certificate and key of the certificate
+	// authority that issues the identity certificates of the Windows devices
+	// that enroll in Fleet's MDM (via the MS-WSTEP enrollment protocol).
+	WindowsWSTEPIdentityCert      string `yaml:"windows_wstep_identity_cert"`
+	WindowsWSTEPIdentityCertBytes string `yaml:"windows_wstep_identity_cert_bytes"`
+	WindowsWSTEPIdentityKey       string `yaml:"windows_wstep_identity_key"`
+	WindowsWSTEPIdentityKeyBytes  string `yaml:"windows_wstep_identity_key_bytes"`
+
+	// the following fields hold the parsed, validated TLS certificate set the
+	// first time MicrosoftWSTEP is called, as well as the PEM-encoded bytes
+	// for the certificate and private key.
+	microsoftWSTEP        *tls.Certificate
+	microsoftWSTEPPEMCert []byte
+	microsoftWSTEPPEMKey  []byte
+
+	// WindowsManagementURL is the base URL that the Windows devices use for
+	// their management sessions, it defaults to the server URL. When set, the
+	// TLS client certificate is only requested for the connections to its
+	// host name.
+	WindowsManagementURL string `yaml:"windows_management_url"`
+	// WindowsClientCertHeader is the header in which a TLS-terminating proxy
+	// forwards the URL-encoded PEM client certificate of the Windows devices.
+	// It is only trusted for the requests of the proxies in
+	// WindowsClientCertTrustedProxies, a comma-separated list of IP addresses
+	// or CIDR ranges.
+	WindowsClientCertHeader         string `yaml:"windows_client_cert_header"`
+	WindowsClientCertTrustedProxies string `yaml:"windows_client_cert_trusted_proxies"`
 
 	AppleBMServerToken      string `yaml:"apple_bm_server_token"`
 	AppleBMServerTokenBytes string `yaml:"apple_bm_server_token_bytes"`
@@ -456,6 +503,10 @@ type MDMConfig struct {
 	// AppleDEPSyncPeriodicity is the duration between DEP device syncing
 	// (fetching and setting of DEP profiles).
 	AppleDEPSyncPeriodicity time.Duration `yaml:"apple_dep_sync_periodicity"`
+	// AppleDEPSyncWindows is a comma-separated list of daily windows of time,
+	// in UTC and in the HH:MM-HH:MM format, during which the DEP devices are
+	// synced. If empty, they are synced at any time.
+	AppleDEPSyncWindows string `yaml:"apple_dep_sync_windows"`
 	// AppleSCEPChallenge is the SCEP challenge for SCEP enrollment requests.
 	AppleSCEPChallenge string `yaml:"apple_scep_challenge"`
 	// AppleSCEPSignerValidityDays are the days signed client certificates will
@@ -464,6 +515,84 @@ type MDMConfig struct {
 	// AppleSCEPSignerAllowRenewalDays are the allowable renewal days for
 	// certificates.
 	AppleSCEPSignerAllowRenewalDays int `yaml:"apple_scep_signer_allow_renewal_days"`
+	// AppleAPNsProxyURL is the URL of an HTTP(S) proxy used to send push
+	// notifications to APNs. If empty, APNs is reached directly.
+	AppleAPNsProxyURL string `yaml:"apple_apns_proxy_url"`
+	// AppleAPNsProxyUsername and AppleAPNsProxyPassword are the credentials
+	// used to authenticate with the APNs proxy.
+	AppleAPNsProxyUsername string `yaml:"apple_apns_proxy_username"`
+	AppleAPNsProxyPassword string `yaml:"apple_apns_proxy_password"`
+	// ApplePushProvider is the provider used to send the MDM push
+	// notifications, one of "apns" (the default), "log" to only log them or
+	// "webhook" to POST them to ApplePushWebhookURL. The non-APNs providers are
+	// meant for test or air-gapped environments.
+	ApplePushProvider string `yaml:"apple_push_provider"`
+	// ApplePushWebhookURL is the URL that receives the push notifications when
+	// ApplePushProvider is "webhook".
+	ApplePushWebhookURL string `yaml:"apple_push_webhook_url"`
+
+	// EnrollmentMaxConcurrent is the maximum number of enrollment requests
+	// (SCEP certificate requests and Authenticate/TokenUpdate check-ins)
+	// processed concurrently by this Fleet instance. The requests above the
+	// limit are queued. If 0, the enrollment requests are not limited.
+	EnrollmentMaxConcurrent int `yaml:"enrollment_max_concurrent"`
+	// EnrollmentMaxQueued is the maximum number of enrollment requests waiting
+	// to be processed, the requests above it are rejected with a Retry-After.
+	EnrollmentMaxQueued int `yaml:"enrollment_max_queued"`
+	// EnrollmentQueueTimeout is the maximum time an enrollment request waits
+	// in the queue before it is rejected with a Retry-After.
+	EnrollmentQueueTimeout time.Duration `yaml:"enrollment_queue_timeout"`
+
+	// FIPSMode restricts the MDM subsystem to FIPS 140-2 approved algorithms.
+	// It requires a Fleet server built with GOEXPERIMENT=boringcrypto.
+	FIPSMode bool `yaml:"fips_mode"`
+	// ProfileChecksumAlgorithm is the hash algorithm of the checksums used to
+	// detect changes of the configuration profiles, "md5" or "sha256". It
+	// defaults to "md5", or to "sha256" in FIPS mode.
+	ProfileChecksumAlgorithm string `yaml:"profile_checksum_algorithm"`
+
+	// GitOpsAllowedCommands is a comma-separated list of the request types of
+	// the MDM commands that users with the GitOps role can enqueue (e.g.
+	// "InstallProfile,ProfileList"). If empty, GitOps users can't enqueue
+	// commands.
+	GitOpsAllowedCommands string `yaml:"gitops_allowed_commands"`
+
+	// CronFailureThreshold is the number of consecutive runs in which an MDM
+	// cron job (the DEP syncer or the profiles reconciliation) must fail for
+	// the failure to be notified and reported in the app config.
+	CronFailureThreshold int `yaml:"cron_failure_threshold"`
+
+	// S3 configures the bucket used to store the contents of bootstrap
+	// packages and EULAs. If not set, they are stored in the database.
+	S3 S3Config `yaml:"s3"`
+
+	// Secrets configures the secrets manager that stores the secrets
+	// referenced by the configuration profiles, e.g. Wi-Fi passwords.
+	Secrets MDMSecretsConfig `yaml:"secrets"`
+}
+
+// MDMSecretsConfig configures the external secrets manager from which the
+// secrets referenced by the configuration profiles are resolved when the
+// profiles are delivered.
+type MDMSecretsConfig struct {
+	// Provider is the secrets manager, "vault" (HashiCorp Vault KV v2) or
+	// "aws" (AWS Secrets Manager). The profiles can't reference secrets if it
+	// is empty.
+	Provider string `yaml:"provider"`
+	// Prefix is prepended to the name of a secret to get the path of the Vault
+	// secret or the id of the AWS secret.
+	Prefix string `yaml:"prefix"`
+
+	VaultAddress string `yaml:"vault_address"`
+	VaultToken   string `yaml:"vault_token"`
+	// VaultMount is the mount path of the KV v2 secrets engine.
+	VaultMount string `yaml:"vault_mount"`
+
+	AWSRegion           string `yaml:"aws_region"`
+	AWSEndpointURL      string `yaml:"aws_endpoint_url"`
+	AWSAccessKeyID      string `yaml:"aws_access_key_id"`
+	AWSSecretAccessKey  string `yaml:"aws_secret_access_key"`
+	AWSStsAssumeRoleArn string `yaml:"aws_sts_assume_role_arn"`
 }
 
 type x509KeyPairConfig struct {
@@ -543,6 +672,16 @@ func (m *MDMConfig) IsAppleSCEPSet() bool {
 	return pair.IsSet()
 }
 
+func (m *MDMConfig) IsAppleSCEPPreviousSet() bool {
+	pair := x509KeyPairConfig{
+		m.AppleSCEPPreviousCert,
+		[]byte(m.AppleSCEPPreviousCertBytes),
+		m.AppleSCEPPreviousKey,
+		[]byte(m.AppleSCEPPreviousKeyBytes),
+	}
+	return pair.IsSet()
+}
+
 func (m *MDMConfig) IsAppleBMSet() bool {
 	pair := x509KeyPairConfig{
 		m.AppleBMCert,
@@ -554,6 +693,94 @@ func (m *MDMConfig) IsAppleBMSet() bool {
 	return pair.IsSet() || m.AppleBMServerToken != "" || m.AppleBMServerTokenBytes != ""
 }
 
+func (m *MDMConfig) IsMicrosoftWSTEPSet() bool {
+	pair := x509KeyPairConfig{
+		m.WindowsWSTEPIdentityCert,
+		[]byte(m.WindowsWSTEPIdentityCertBytes),
+		m.WindowsWSTEPIdentityKey,
+		[]byte(m.WindowsWSTEPIdentityKeyBytes),
+	}
+	return pair.IsSet()
+}
+
+// MicrosoftWSTEP returns the parsed and validated TLS certificate for the
+// Windows MDM enrollment (WSTEP). It parses and validates it if it hasn't been
+// done yet.
+func (m *MDMConfig) MicrosoftWSTEP() (cert *tls.Certificate, pemCert, pemKey []byte, err error) {
+	if m.microsoftWSTEP == nil {
+		pair := x509KeyPairConfig{
+			m.WindowsWSTEPIdentityCert,
+			[]byte(m.WindowsWSTEPIdentityCertBytes),
+			m.WindowsWSTEPIdentityKey,
+			[]byte(m.WindowsWSTEPIdentityKeyBytes),
+		}
+		cert, err := pair.Parse(true)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("Microsoft MDM WSTEP configuration: %w", err)
+		}
+		m.microsoftWSTEP = cert
+		m.microsoftWSTEPPEMCert = pair.certBytes
+		m.microsoftWSTEPPEMKey = pair.keyBytes
+	}
+	return m.microsoftWSTEP, m.microsoftWSTEPPEMCert, m.microsoftWSTEPPEMKey, nil
+}
+
+// MicrosoftManagementURL returns the parsed and validated base URL of the
+// Windows management sessions, or nil if it is not set.
+func (m *MDMConfig) MicrosoftManagementURL() (*url.URL, error) {
+	if m.WindowsManagementURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(m.WindowsManagementURL)
+	if err != nil {
+		return nil, fmt.Errorf("Microsoft MDM management URL: %w", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, errors.New("Microsoft MDM management URL: URL must be an absolute https URL")
+	}
+	return u, nil
+}
+
+// MicrosoftClientCertProxies returns the header in which the trusted proxies
+// forward the client certificate of the Windows devices, and the parsed
+// networks of those proxies. The header is empty if it is not set.
+func (m *MDMConfig) MicrosoftClientCertProxies() (header string, trusted []*net.IPNet, err error) {
+	if m.WindowsClientCertHeader == "" {
+		if m.WindowsClientCertTrustedProxies != "" {
+			return "", nil, errors.New("Microsoft MDM client certificate configuration: trusted proxies provided without a header")
+		}
+		return "", nil, nil
+	}
+
+	for _, part := range strings.Split(m.WindowsClientCertTrustedProxies, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return "", nil, fmt.Errorf("Microsoft MDM client certificate configuration: invalid trusted proxy %q", part)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(part)
+		if err != nil {
+			return "", nil, fmt.Errorf("Microsoft MDM client certificate configuration: invalid trusted proxy %q: %w", part, err)
+		}
+		trusted = append(trusted, ipNet)
+	}
+	if len(trusted) == 0 {
+		return "", nil, errors.New("Microsoft MDM client certificate configuration: header provided without trusted proxies")
+	}
+	return m.WindowsClientCertHeader, trusted, nil
+}
+
 // AppleAPNs returns the parsed and validated TLS certificate for Apple APNs.
 // It parses and validates it if it hasn't been done yet.
 func (m *MDMConfig) AppleAPNs() (cert *tls.Certificate, pemCert, pemKey []byte, err error) {
@@ -596,6 +823,287 @@ func (m *MDMConfig) AppleSCEP() (cert *tls.Certificate, pemCert, pemKey []byte,
 	return m.appleSCEP, m.appleSCEPPEMCert, m.appleSCEPPEMKey, nil
 }
 
+// AppleSCEPPrevious returns the parsed and validated TLS certificate of the
+// previous Apple SCEP CA, or nil if no CA rotation is in progress. It parses
+// and validates it if it hasn't been done yet.
+func (m *MDMConfig) AppleSCEPPrevious() (*tls.Certificate, error) {
+	if !m.IsAppleSCEPPreviousSet() {
+		return nil, nil
+	}
+	if m.appleSCEPPrev == nil {
+		pair := x509KeyPairConfig{
+			m.AppleSCEPPreviousCert,
+			[]byte(m.AppleSCEPPreviousCertBytes),
+			m.AppleSCEPPreviousKey,
+			[]byte(m.AppleSCEPPreviousKeyBytes),
+		}
+		cert, err := pair.Parse(true)
+		if err != nil {
+			return nil, fmt.Errorf("Apple MDM previous SCEP configuration: %w", err)
+		}
+		m.appleSCEPPrev = cert
+	}
+	return m.appleSCEPPrev, nil
+}
+
+// AppleSCEPCertificates returns the SCEP CA certificates whose keys can
+// decrypt the data encrypted by the devices, the current CA first followed
+// by the previous one if a CA rotation is in progress.
+func (m *MDMConfig) AppleSCEPCertificates() ([]*tls.Certificate, error) {
+	cert, _, _, err := m.AppleSCEP()
+	if err != nil {
+		return nil, err
+	}
+	prev, err := m.AppleSCEPPrevious()
+	if err != nil {
+		return nil, err
+	}
+	if prev == nil {
+		return []*tls.Certificate{cert}, nil
+	}
+	return []*tls.Certificate{cert, prev}, nil
+}
+
+// AppleAPNsProxy returns the parsed and validated URL of the proxy used to send
+// push notifications to APNs, with the configured credentials set. It returns
+// nil if no proxy is configured.
+func (m *MDMConfig) AppleAPNsProxy() (*url.URL, error) {
+	if m.AppleAPNsProxyURL == "" {
+		if m.AppleAPNsProxyUsername != "" || m.AppleAPNsProxyPassword != "" {
+			return nil, errors.New("Apple APNs proxy configuration: credentials provided without a proxy URL")
+		}
+		return nil, nil
+	}
+
+	u, err := url.Parse(m.AppleAPNsProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("Apple APNs proxy configuration: parse URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("Apple APNs proxy configuration: URL must be an absolute http or https URL")
+	}
+	if m.AppleAPNsProxyUsername != "" || m.AppleAPNsProxyPassword != "" {
+		u.User = url.UserPassword(m.AppleAPNsProxyUsername, m.AppleAPNsProxyPassword)
+	}
+	return u, nil
+}
+
+// ApplePush returns the validated provider used to send MDM push
+// notifications and, for the "webhook" provider, the parsed webhook URL.
+func (m *MDMConfig) ApplePush() (provider string, webhookURL *url.URL, err error) {
+	provider = m.ApplePushProvider
+	if provider == "" {
+		provider = "apns"
+	}
+
+	switch provider {
+	case "apns":
+		if m.ApplePushWebhookURL != "" {
+			return "", nil, errors.New("Apple push provider configuration: webhook URL provided without the webhook provider")
+		}
+		return provider, nil, nil
+
+	case "log", "webhook":
+		if m.AppleAPNsProxyURL != "" {
+			return "", nil, fmt.Errorf("Apple push provider configuration: APNs proxy can't be used with the %s provider", provider)
+		}
+		if provider == "log" {
+			if m.ApplePushWebhookURL != "" {
+				return "", nil, errors.New("Apple push provider configuration: webhook URL provided without the webhook provider")
+			}
+			return provider, nil, nil
+		}
+
+		if m.ApplePushWebhookURL == "" {
+			return "", nil, errors.New("Apple push provider configuration: webhook URL is required with the webhook provider")
+		}
+		u, err := url.Parse(m.ApplePushWebhookURL)
+		if err != nil {
+			return "", nil, fmt.Errorf("Apple push provider configuration: parse webhook URL: %w", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", nil, errors.New("Apple push provider configuration: webhook URL must be an absolute http or https URL")
+		}
+		return provider, u, nil
+
+	default:
+		return "", nil, fmt.Errorf("Apple push provider configuration: unknown provider %q", provider)
+	}
+}
+
+// ProfileChecksum returns the validated hash algorithm of the checksums of
+// the configuration profiles.
+func (m *MDMConfig) ProfileChecksum() (string, error) {
+	switch m.ProfileChecksumAlgorithm {
+	case "":
+		if m.FIPSMode {
+			return "sha256", nil
+		}
+		return "md5", nil
+	case "md5":
+		if m.FIPSMode {
+			return "", errors.New("MDM profile checksum configuration: md5 can't be used in FIPS mode")
+		}
+		return "md5", nil
+	case "sha256":
+		return "sha256", nil
+	default:
+		return "", fmt.Errorf("MDM profile checksum configuration: unknown algorithm %q", m.ProfileChecksumAlgorithm)
+	}
+}
+
+// ValidateFIPS checks that the MDM configuration only uses FIPS 140-2
+// approved algorithms, it returns nil if FIPS mode is not enabled. The
+// certificates must use RSA keys of at least 2048 bits or ECDSA keys on the
+// P-256, P-384 or P-521 curves, and must not be signed with MD5 or SHA-1.
+func (m *MDMConfig) ValidateFIPS() error {
+	if !m.FIPSMode {
+		return nil
+	}
+	if _, err := m.ProfileChecksum(); err != nil {
+		return err
+	}
+
+	if m.IsAppleAPNsSet() {
+		cert, _, _, err := m.AppleAPNs()
+		if err != nil {
+			return err
+		}
+		if err := checkFIPSCertificate(cert.Leaf); err != nil {
+			return fmt.Errorf("Apple MDM APNs configuration: %w", err)
+		}
+	}
+	if m.IsAppleSCEPSet() {
+		cert, _, _, err := m.AppleSCEP()
+		if err != nil {
+			return err
+		}
+		if err := checkFIPSCertificate(cert.Leaf); err != nil {
+			return fmt.Errorf("Apple MDM SCEP configuration: %w", err)
+		}
+	}
+	if m.IsAppleSCEPPreviousSet() {
+		cert, err := m.AppleSCEPPrevious()
+		if err != nil {
+			return err
+		}
+		if err := checkFIPSCertificate(cert.Leaf); err != nil {
+			return fmt.Errorf("Apple MDM previous SCEP configuration: %w", err)
+		}
+	}
+	if m.IsAppleBMSet() {
+		pair := x509KeyPairConfig{
+			m.AppleBMCert,
+			[]byte(m.AppleBMCertBytes),
+			m.AppleBMKey,
+			[]byte(m.AppleBMKeyBytes),
+		}
+		cert, err := pair.Parse(true)
+		if err != nil {
+			return fmt.Errorf("Apple BM configuration: %w", err)
+		}
+		if err := checkFIPSCertificate(cert.Leaf); err != nil {
+			return fmt.Errorf("Apple BM configuration: %w", err)
+		}
+	}
+	return nil
+}
+
+func checkFIPSCertificate(cert *x509.Certificate) error {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return fmt.Errorf("RSA key of %d bits can't be used in FIPS mode, at least 2048 bits are required", pub.N.BitLen())
+		}
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		default:
+			return fmt.Errorf("ECDSA curve %s can't be used in FIPS mode", pub.Curve.Params().Name)
+		}
+	default:
+		return fmt.Errorf("%s key can't be used in FIPS mode", cert.PublicKeyAlgorithm)
+	}
+
+	switch cert.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		return fmt.Errorf("certificate signed with %s can't be used in FIPS mode", cert.SignatureAlgorithm)
+	}
+	return nil
+}
+
+// DailyWindow is a window of time that repeats every day, in UTC. End is
+// before Start if the window spans midnight.
+type DailyWindow struct {
+	// Start and End are the durations since midnight of the bounds of the
+	// window, Start is included and End is excluded.
+	Start time.Duration
+	End   time.Duration
+}
+
+// Contains returns true if t is within the window.
+func (w DailyWindow) Contains(t time.Time) bool {
+	t = t.UTC()
+	d := t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+	if w.Start <= w.End {
+		return d >= w.Start && d < w.End
+	}
+	return d >= w.Start || d < w.End
+}
+
+// AppleDEPWindows returns the parsed and validated daily windows during which
+// the DEP devices are synced. It returns nil if the devices can be synced at
+// any time.
+func (m *MDMConfig) AppleDEPWindows() ([]DailyWindow, error) {
+	if strings.TrimSpace(m.AppleDEPSyncWindows) == "" {
+		return nil, nil
+	}
+
+	parseTime := func(s string) (time.Duration, error) {
+		t, err := time.Parse("15:04", strings.TrimSpace(s))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+	}
+
+	var windows []DailyWindow
+	for _, part := range strings.Split(m.AppleDEPSyncWindows, ",") {
+		start, end, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("Apple DEP sync windows configuration: invalid window %q, must be HH:MM-HH:MM", strings.TrimSpace(part))
+		}
+		var w DailyWindow
+		var err error
+		if w.Start, err = parseTime(start); err != nil {
+			return nil, fmt.Errorf("Apple DEP sync windows configuration: invalid start of window %q: %w", strings.TrimSpace(part), err)
+		}
+		if w.End, err = parseTime(end); err != nil {
+			return nil, fmt.Errorf("Apple DEP sync windows configuration: invalid end of window %q: %w", strings.TrimSpace(part), err)
+		}
+		if w.Start == w.End {
+			return nil, fmt.Errorf("Apple DEP sync windows configuration: empty window %q", strings.TrimSpace(part))
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// GitOpsAllowedCommand returns true if users with the GitOps role can
+// enqueue MDM commands of the given request type.
+func (m *MDMConfig) GitOpsAllowedCommand(requestType string) bool {
+	requestType = strings.TrimSpace(requestType)
+	if requestType == "" {
+		return false
+	}
+	for _, allowed := range strings.Split(m.GitOpsAllowedCommands, ",") {
+		if strings.TrimSpace(allowed) == requestType {
+			return true
+		}
+	}
+	return false
+}
+
 // AppleBM returns the parsed, validated and decrypted server token for Apple
 // Business Manager. It also parses and validates the Apple BM certificate and
 // private key in the process, in order to decrypt the token.
@@ -776,6 +1284,8 @@ func (man Manager) addConfigs() {
 	man.addConfigBool("server.sandbox_enabled", false,
 		"When enabled, Fleet limits some features for the Sandbox")
 	man.addConfigBool("server.websockets_allow_unsafe_origin", false, "Disable checking the origin header on websocket connections, this is sometimes necessary when proxies rewrite origin headers between the client and the Fleet webserver")
+	man.addConfigString("server.private_key", "",
+		"Key used to encrypt the secrets stored in the database, such as the MDM assets (at least 32 bytes)")
 
 	// Hide the sandbox flag as we don't want it to be discoverable for users for now
 	sandboxFlag := man.command.PersistentFlags().Lookup(flagNameFromConfigKey("server.sandbox_enabled"))
@@ -858,6 +1368,8 @@ func (man Manager) addConfigs() {
 		"Enable audit logs")
 	man.addConfigString("activity.audit_log_plugin", "filesystem",
 		"Log plugin to use for audit logs")
+	man.addConfigString("activity.security_audit_log_plugin", "",
+		"Log plugin to use to stream the security audit log (disabled if empty)")
 
 	// Logging
 	man.addConfigBool("logging.debug", false,
@@ -951,6 +1463,8 @@ func (man Manager) addConfigs() {
 		"Log file path to use for result logs")
 	man.addConfigString("filesystem.audit_log_file", filepath.Join(os.TempDir(), "audit"),
 		"Log file path to use for audit logs")
+	man.addConfigString("filesystem.security_audit_log_file", filepath.Join(os.TempDir(), "security_audit"),
+		"Log file path to use for the security audit log")
 	man.addConfigBool("filesystem.enable_log_rotation", false,
 		"Enable automatic rotation for osquery log files")
 	man.addConfigBool("filesystem.enable_log_compression", false,
@@ -1033,6 +1547,17 @@ func (man Manager) addConfigs() {
 	man.addConfigString("mdm.apple_scep_cert_bytes", "", "Apple SCEP PEM-encoded certificate bytes")
 	man.addConfigString("mdm.apple_scep_key", "", "Apple SCEP PEM-encoded private key path")
 	man.addConfigString("mdm.apple_scep_key_bytes", "", "Apple SCEP PEM-encoded private key bytes")
+	man.addConfigString("mdm.apple_scep_previous_cert", "", "Previous Apple SCEP PEM-encoded certificate path, during a CA rotation")
+	man.addConfigString("mdm.apple_scep_previous_cert_bytes", "", "Previous Apple SCEP PEM-encoded certificate bytes, during a CA rotation")
+	man.addConfigString("mdm.apple_scep_previous_key", "", "Previous Apple SCEP PEM-encoded private key path, during a CA rotation")
+	man.addConfigString("mdm.apple_scep_previous_key_bytes", "", "Previous Apple SCEP PEM-encoded private key bytes, during a CA rotation")
+	man.addConfigString("mdm.windows_wstep_identity_cert", "", "Microsoft WSTEP PEM-encoded certificate path")
+	man.addConfigString("mdm.windows_wstep_identity_cert_bytes", "", "Microsoft WSTEP PEM-encoded certificate bytes")
+	man.addConfigString("mdm.windows_wstep_identity_key", "", "Microsoft WSTEP PEM-encoded private key path")
+	man.addConfigString("mdm.windows_wstep_identity_key_bytes", "", "Microsoft WSTEP PEM-encoded private key bytes")
+	man.addConfigString("mdm.windows_management_url", "", "Base URL of the Windows MDM management sessions, the TLS client certificate is only requested for its host name")
+	man.addConfigString("mdm.windows_client_cert_header", "", "Header in which a trusted TLS-terminating proxy forwards the URL-encoded PEM client certificate of the Windows devices")
+	man.addConfigString("mdm.windows_client_cert_trusted_proxies", "", "Comma-separated IP addresses or CIDR ranges of the proxies trusted to forward the client certificate of the Windows devices")
 	man.addConfigString("mdm.apple_bm_server_token", "", "Apple Business Manager encrypted server token path (.p7m file)")
 	man.addConfigString("mdm.apple_bm_server_token_bytes", "", "Apple Business Manager encrypted server token bytes")
 	man.addConfigString("mdm.apple_bm_cert", "", "Apple Business Manager PEM-encoded certificate path")
@@ -1044,6 +1569,38 @@ func (man Manager) addConfigs() {
 	man.addConfigInt("mdm.apple_scep_signer_allow_renewal_days", 14, "Allowable renewal days for client certificates")
 	man.addConfigString("mdm.apple_scep_challenge", "", "SCEP static challenge for enrollment")
 	man.addConfigDuration("mdm.apple_dep_sync_periodicity", 1*time.Minute, "How much time to wait for DEP profile assignment")
+	man.addConfigString("mdm.apple_dep_sync_windows", "", "Comma-separated daily windows (HH:MM-HH:MM, in UTC) during which DEP devices are synced")
+	man.addConfigString("mdm.apple_apns_proxy_url", "", "URL of the HTTP proxy used to send push notifications to APNs")
+	man.addConfigString("mdm.apple_apns_proxy_username", "", "Username to authenticate with the APNs proxy")
+	man.addConfigString("mdm.apple_apns_proxy_password", "", "Password to authenticate with the APNs proxy")
+	man.addConfigString("mdm.apple_push_provider", "apns", "Provider used to send MDM push notifications (apns, log or webhook)")
+	man.addConfigString("mdm.apple_push_webhook_url", "", "URL that receives the MDM push notifications with the webhook provider")
+	man.addConfigInt("mdm.enrollment_max_concurrent", 0, "Maximum number of MDM enrollment requests processed concurrently (0 means no limit)")
+	man.addConfigInt("mdm.enrollment_max_queued", 1000, "Maximum number of MDM enrollment requests waiting to be processed")
+	man.addConfigDuration("mdm.enrollment_queue_timeout", 30*time.Second, "Maximum time an MDM enrollment request waits to be processed")
+	man.addConfigBool("mdm.fips_mode", false, "Restrict the MDM cryptography to FIPS 140-2 approved algorithms")
+	man.addConfigString("mdm.profile_checksum_algorithm", "", "Hash algorithm of the configuration profiles checksums (md5 or sha256)")
+	man.addConfigString("mdm.gitops_allowed_commands", "", "Comma-separated request types of the MDM commands that GitOps users can enqueue")
+	man.addConfigInt("mdm.cron_failure_threshold", 3, "Number of consecutive failed runs of an MDM cron job before it is notified")
+	man.addConfigString("mdm.s3.bucket", "", "Bucket where to store bootstrap packages and EULAs")
+	man.addConfigString("mdm.s3.prefix", "", "Prefix under which bootstrap packages and EULAs are stored")
+	man.addConfigString("mdm.s3.region", "", "AWS Region (if blank region is derived)")
+	man.addConfigString("mdm.s3.endpoint_url", "", "AWS Service Endpoint to use (leave blank for default service endpoints)")
+	man.addConfigString("mdm.s3.access_key_id", "", "Access Key ID for AWS authentication")
+	man.addConfigString("mdm.s3.secret_access_key", "", "Secret Access Key for AWS authentication")
+	man.addConfigString("mdm.s3.sts_assume_role_arn", "", "ARN of role to assume for AWS")
+	man.addConfigBool("mdm.s3.disable_ssl", false, "Disable SSL (typically for local testing)")
+	man.addConfigBool("mdm.s3.force_s3_path_style", false, "Set this to true to force path-style addressing, i.e., `http://s3.amazonaws.com/BUCKET/KEY`")
+	man.addConfigString("mdm.secrets.provider", "", "Secrets manager of the secrets referenced by configuration profiles (vault or aws)")
+	man.addConfigString("mdm.secrets.prefix", "", "Prefix of the Vault path or AWS id of the secrets referenced by configuration profiles")
+	man.addConfigString("mdm.secrets.vault_address", "", "Address of the Vault server")
+	man.addConfigString("mdm.secrets.vault_token", "", "Token to authenticate with the Vault server")
+	man.addConfigString("mdm.secrets.vault_mount", "secret", "Mount path of the Vault KV v2 secrets engine")
+	man.addConfigString("mdm.secrets.aws_region", "", "AWS Region of the AWS Secrets Manager")
+	man.addConfigString("mdm.secrets.aws_endpoint_url", "", "AWS Secrets Manager endpoint to use (leave blank for the default endpoint)")
+	man.addConfigString("mdm.secrets.aws_access_key_id", "", "Access Key ID for AWS authentication")
+	man.addConfigString("mdm.secrets.aws_secret_access_key", "", "Secret Access Key for AWS authentication")
+	man.addConfigString("mdm.secrets.aws_sts_assume_role_arn", "", "ARN of role to assume for AWS")
 }
 
 // LoadConfig will load the config variables into a fully initialized
@@ -1109,6 +1666,7 @@ func (man Manager) LoadConfig() FleetConfig {
 			Keepalive:                   man.getConfigBool("server.keepalive"),
 			SandboxEnabled:              man.getConfigBool("server.sandbox_enabled"),
 			WebsocketsAllowUnsafeOrigin: man.getConfigBool("server.websockets_allow_unsafe_origin"),
+			PrivateKey:                  man.getConfigString("server.private_key"),
 		},
 		Auth: AuthConfig{
 			BcryptCost:  man.getConfigInt("auth.bcrypt_cost"),
@@ -1151,8 +1709,9 @@ func (man Manager) LoadConfig() FleetConfig {
 			MinSoftwareLastOpenedAtDiff:      man.getConfigDuration("osquery.min_software_last_opened_at_diff"),
 		},
 		Activity: ActivityConfig{
-			EnableAuditLog: man.getConfigBool("activity.enable_audit_log"),
-			AuditLogPlugin: man.getConfigString("activity.audit_log_plugin"),
+			EnableAuditLog:         man.getConfigBool("activity.enable_audit_log"),
+			AuditLogPlugin:         man.getConfigString("activity.audit_log_plugin"),
+			SecurityAuditLogPlugin: man.getConfigString("activity.security_audit_log_plugin"),
 		},
 		Logging: LoggingConfig{
 			Debug:                man.getConfigBool("logging.debug"),
@@ -1224,6 +1783,7 @@ func (man Manager) LoadConfig() FleetConfig {
 			StatusLogFile:        man.getConfigString("filesystem.status_log_file"),
 			ResultLogFile:        man.getConfigString("filesystem.result_log_file"),
 			AuditLogFile:         man.getConfigString("filesystem.audit_log_file"),
+			SecurityAuditLogFile: man.getConfigString("filesystem.security_audit_log_file"),
 			EnableLogRotation:    man.getConfigBool("filesystem.enable_log_rotation"),
 			EnableLogCompression: man.getConfigBool("filesystem.enable_log_compression"),
 			MaxSize:              man.getConfigInt("filesystem.max_size"),
@@ -1293,6 +1853,17 @@ func (man Manager) LoadConfig() FleetConfig {
 			AppleSCEPCertBytes:              man.getConfigString("mdm.apple_scep_cert_bytes"),
 			AppleSCEPKey:                    man.getConfigString("mdm.apple_scep_key"),
 			AppleSCEPKeyBytes:               man.getConfigString("mdm.apple_scep_key_bytes"),
+			AppleSCEPPreviousCert:           man.getConfigString("mdm.apple_scep_previous_cert"),
+			AppleSCEPPreviousCertBytes:      man.getConfigString("mdm.apple_scep_previous_cert_bytes"),
+			AppleSCEPPreviousKey:            man.getConfigString("mdm.apple_scep_previous_key"),
+			AppleSCEPPreviousKeyBytes:       man.getConfigString("mdm.apple_scep_previous_key_bytes"),
+			WindowsWSTEPIdentityCert:        man.getConfigString("mdm.windows_wstep_identity_cert"),
+			WindowsWSTEPIdentityCertBytes:   man.getConfigString("mdm.windows_wstep_identity_cert_bytes"),
+			WindowsWSTEPIdentityKey:         man.getConfigString("mdm.windows_wstep_identity_key"),
+			WindowsWSTEPIdentityKeyBytes:    man.getConfigString("mdm.windows_wstep_identity_key_bytes"),
+			WindowsManagementURL:            man.getConfigString("mdm.windows_management_url"),
+			WindowsClientCertHeader:         man.getConfigString("mdm.windows_client_cert_header"),
+			WindowsClientCertTrustedProxies: man.getConfigString("mdm.windows_client_cert_trusted_proxies"),
 			AppleBMServerToken:              man.getConfigString("mdm.apple_bm_server_token"),
 			AppleBMServerTokenBytes:         man.getConfigString("mdm.apple_bm_server_token_bytes"),
 			AppleBMCert:                     man.getConfigString("mdm.apple_bm_cert"),
@@ -1304,6 +1875,42 @@ func (man Manager) LoadConfig() FleetConfig {
 			AppleSCEPSignerAllowRenewalDays: man.getConfigInt("mdm.apple_scep_signer_allow_renewal_days"),
 			AppleSCEPChallenge:              man.getConfigString("mdm.apple_scep_challenge"),
 			AppleDEPSyncPeriodicity:         man.getConfigDuration("mdm.apple_dep_sync_periodicity"),
+			AppleDEPSyncWindows:             man.getConfigString("mdm.apple_dep_sync_windows"),
+			AppleAPNsProxyURL:               man.getConfigString("mdm.apple_apns_proxy_url"),
+			AppleAPNsProxyUsername:          man.getConfigString("mdm.apple_apns_proxy_username"),
+			AppleAPNsProxyPassword:          man.getConfigString("mdm.apple_apns_proxy_password"),
+			ApplePushProvider:               man.getConfigString("mdm.apple_push_provider"),
+			ApplePushWebhookURL:             man.getConfigString("mdm.apple_push_webhook_url"),
+			EnrollmentMaxConcurrent:         man.getConfigInt("mdm.enrollment_max_concurrent"),
+			EnrollmentMaxQueued:             man.getConfigInt("mdm.enrollment_max_queued"),
+			EnrollmentQueueTimeout:          man.getConfigDuration("mdm.enrollment_queue_timeout"),
+			FIPSMode:                        man.getConfigBool("mdm.fips_mode"),
+			ProfileChecksumAlgorithm:        man.getConfigString("mdm.profile_checksum_algorithm"),
+			GitOpsAllowedCommands:           man.getConfigString("mdm.gitops_allowed_commands"),
+			CronFailureThreshold:            man.getConfigInt("mdm.cron_failure_threshold"),
+			S3: S3Config{
+				Bucket:           man.getConfigString("mdm.s3.bucket"),
+				Prefix:           man.getConfigString("mdm.s3.prefix"),
+				Region:           man.getConfigString("mdm.s3.region"),
+				EndpointURL:      man.getConfigString("mdm.s3.endpoint_url"),
+				AccessKeyID:      man.getConfigString("mdm.s3.access_key_id"),
+				SecretAccessKey:  man.getConfigString("mdm.s3.secret_access_key"),
+				StsAssumeRoleArn: man.getConfigString("mdm.s3.sts_assume_role_arn"),
+				DisableSSL:       man.getConfigBool("mdm.s3.disable_ssl"),
+				ForceS3PathStyle: man.getConfigBool("mdm.s3.force_s3_path_style"),
+			},
+			Secrets: MDMSecretsConfig{
+				Provider:            man.getConfigString("mdm.secrets.provider"),
+				Prefix:              man.getConfigString("mdm.secrets.prefix"),
+				VaultAddress:        man.getConfigString("mdm.secrets.vault_address"),
+				VaultToken:          man.getConfigString("mdm.secrets.vault_token"),
+				VaultMount:          man.getConfigString("mdm.secrets.vault_mount"),
+				AWSRegion:           man.getConfigString("mdm.secrets.aws_region"),
+				AWSEndpointURL:      man.getConfigString("mdm.secrets.aws_endpoint_url"),
+				AWSAccessKeyID:      man.getConfigString("mdm.secrets.aws_access_key_id"),
+				AWSSecretAccessKey:  man.getConfigString("mdm.secrets.aws_secret_access_key"),
+				AWSStsAssumeRoleArn: man.getConfigString("mdm.secrets.aws_sts_assume_role_arn"),
+			},
 		},
 	}
 
@@ -1615,10 +2222,11 @@ func TestConfig() FleetConfig {
 			DisableBanner: true,
 		},
 		Filesystem: FilesystemConfig{
-			StatusLogFile: testLogFile,
-			ResultLogFile: testLogFile,
-			AuditLogFile:  testLogFile,
-			MaxSize:       500,
+			StatusLogFile:        testLogFile,
+			ResultLogFile:        testLogFile,
+			AuditLogFile:         testLogFile,
+			SecurityAuditLogFile: testLogFile,
+			MaxSize:              500,
 		},
 	}
 }
@@ -1658,3 +2266,25 @@ func SetTestMDMConfig(t testing.TB, cfg *FleetConfig, cert, key []byte, appleBMT
 	cfg.MDM.AppleSCEPSignerValidityDays = 365
 	cfg.MDM.AppleSCEPChallenge = "testchallenge"
 }
+
+// SetTestWindowsMDMConfig sets the Windows MDM configuration of the config,
+// with the provided WSTEP certificate and key, for tests.
+func SetTestWindowsMDMConfig(t testing.TB, cfg *FleetConfig, cert, key []byte) {
+	tlsCert, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCert.Leaf = parsed
+
+	cfg.MDM.WindowsWSTEPIdentityCertBytes = string(cert)
+	cfg.MDM.WindowsWSTEPIdentityKeyBytes = string(key)
+
+	cfg.MDM.microsoftWSTEP = &tlsCert
+	cfg.MDM.microsoftWSTEPPEMCert = cert
+	cfg.MDM.microsoftWSTEPPEMKey = key
+}
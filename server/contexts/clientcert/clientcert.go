@@ -0,0 +1,26 @@
+package clientcert
+
+import (
+	"context"
+	"crypto/x509"
+)
+
+type key int
+
+const certsKey key = 0
+
+// NewContext returns a new context carrying the client certificates of the
+// current request, presented in the TLS handshake or forwarded by a trusted
+// TLS-terminating proxy.
+func NewContext(ctx context.Context, certs []*x509.Certificate) context.Context {
+	return context.WithValue(ctx, certsKey, certs)
+}
+
+// FromContext extracts the client certificates from context if present.
+func FromContext(ctx context.Context) []*x509.Certificate {
+	certs, ok := ctx.Value(certsKey).([]*x509.Certificate)
+	if !ok {
+		return nil
+	}
+	return certs
+}
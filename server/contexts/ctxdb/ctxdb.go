@@ -0,0 +1,26 @@
+// Package ctxdb provides functions to carry the database-related options of
+// an operation in the context.
+package ctxdb
+
+import (
+	"context"
+)
+
+type key int
+
+const requirePrimaryKey key = 0
+
+// RequirePrimary returns a new context that indicates whether the reads of
+// the datastore must be done on the primary instead of the read replica, e.g.
+// to read the rows written earlier in the same operation without replication
+// lag.
+func RequirePrimary(ctx context.Context, requirePrimary bool) context.Context {
+	return context.WithValue(ctx, requirePrimaryKey, requirePrimary)
+}
+
+// IsPrimaryRequired returns true if the context requires the reads to be done
+// on the primary.
+func IsPrimaryRequired(ctx context.Context) bool {
+	v, _ := ctx.Value(requirePrimaryKey).(bool)
+	return v
+}
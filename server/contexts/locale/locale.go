@@ -0,0 +1,24 @@
+package locale
+
+import (
+	"context"
+)
+
+type key int
+
+const acceptLanguageKey key = 0
+
+// NewContext returns a new context carrying the Accept-Language header of the
+// current request.
+func NewContext(ctx context.Context, acceptLanguage string) context.Context {
+	return context.WithValue(ctx, acceptLanguageKey, acceptLanguage)
+}
+
+// FromContext extracts the Accept-Language header from context if present.
+func FromContext(ctx context.Context) string {
+	acceptLanguage, ok := ctx.Value(acceptLanguageKey).(string)
+	if !ok {
+		return ""
+	}
+	return acceptLanguage
+}
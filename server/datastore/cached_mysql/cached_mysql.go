@@ -151,6 +151,7 @@ func New(ds fleet.Datastore, opts ...Option) fleet.Datastore {
 		scheduledQueriesExp: defaultScheduledQueriesExpiration,
 		teamAgentOptionsExp: defaultTeamAgentOptionsExpiration,
 		teamFeaturesExp:     defaultTeamFeaturesExpiration,
+		teamMDMConfigExp:    defaultTeamMDMConfigExpiration,
 	}
 	for _, fn := range opts {
 		fn(c)
@@ -284,6 +285,9 @@ func (ds *cachedMysql) TeamMDMConfig(ctx context.Context, teamID uint) (*fleet.T
 	if err != nil {
 		return nil, err
 	}
+
+	ds.c.Set(key, cfg, ds.teamMDMConfigExp)
+
 	return cfg, nil
 }
 
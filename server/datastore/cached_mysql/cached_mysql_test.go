@@ -536,3 +536,67 @@ func TestCachedTeamMDMConfig(t *testing.T) {
 	_, err = ds.TeamMDMConfig(context.Background(), testTeam.ID)
 	require.Error(t, err)
 }
+
+func TestCachedTeamMDMConfigMultipleServers(t *testing.T) {
+	t.Parallel()
+
+	// two Fleet servers sharing the same database, each with its own cache
+	mockedDS := new(mock.Store)
+	ds1 := New(mockedDS, WithTeamMDMConfigExpiration(100*time.Millisecond))
+	ds2 := New(mockedDS, WithTeamMDMConfigExpiration(100*time.Millisecond))
+	ao := json.RawMessage(`{}`)
+
+	stored := fleet.TeamMDM{
+		MacOSUpdates: fleet.MacOSUpdates{
+			MinimumVersion: "10.10.10",
+			Deadline:       "1992-03-01",
+		},
+	}
+	mockedDS.TeamMDMConfigFunc = func(ctx context.Context, teamID uint) (*fleet.TeamMDM, error) {
+		cfg := stored
+		return &cfg, nil
+	}
+	mockedDS.SaveTeamFunc = func(ctx context.Context, team *fleet.Team) (*fleet.Team, error) {
+		stored = team.Config.MDM
+		return team, nil
+	}
+
+	mdmConfig, err := ds1.TeamMDMConfig(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, "10.10.10", mdmConfig.MacOSUpdates.MinimumVersion)
+
+	// the team is updated through the other server
+	_, err = ds2.SaveTeam(context.Background(), &fleet.Team{
+		ID:   1,
+		Name: "test",
+		Config: fleet.TeamConfig{
+			MDM: fleet.TeamMDM{
+				MacOSUpdates: fleet.MacOSUpdates{
+					MinimumVersion: "13.13.13",
+					Deadline:       "2022-03-01",
+				},
+			},
+			AgentOptions: &ao,
+		},
+	})
+	require.NoError(t, err)
+
+	mdmConfig, err = ds2.TeamMDMConfig(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, "13.13.13", mdmConfig.MacOSUpdates.MinimumVersion)
+
+	// the first server serves its cached config until it expires
+	mdmConfig, err = ds1.TeamMDMConfig(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, "10.10.10", mdmConfig.MacOSUpdates.MinimumVersion)
+
+	time.Sleep(200 * time.Millisecond)
+
+	mdmConfig, err = ds1.TeamMDMConfig(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, "13.13.13", mdmConfig.MacOSUpdates.MinimumVersion)
+
+	// without options, the team MDM config has the same bounded staleness on
+	// all servers instead of the cache's default expiration
+	require.Equal(t, defaultTeamMDMConfigExpiration, New(mockedDS).(*cachedMysql).teamMDMConfigExp)
+}
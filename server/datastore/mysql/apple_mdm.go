@@ -3,9 +3,11 @@ package mysql
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/fleet"
@@ -17,20 +19,26 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/micromdm/nanodep/godep"
 	"github.com/micromdm/nanomdm/mdm"
+	"golang.org/x/exp/slices"
 )
 
 func (ds *Datastore) NewMDMAppleConfigProfile(ctx context.Context, cp fleet.MDMAppleConfigProfile) (*fleet.MDMAppleConfigProfile, error) {
 	stmt := `
 INSERT INTO
-    mdm_apple_configuration_profiles (team_id, identifier, name, mobileconfig, checksum)
-VALUES (?, ?, ?, ?, UNHEX(MD5(mobileconfig)))`
+    mdm_apple_configuration_profiles (team_id, host_id, identifier, name, mobileconfig, checksum, reserved_payload_types)
+VALUES (?, ?, ?, ?, ?, ?, ?)`
 
 	var teamID uint
 	if cp.TeamID != nil {
 		teamID = *cp.TeamID
 	}
 
-	res, err := ds.writer.ExecContext(ctx, stmt, teamID, cp.Identifier, cp.Name, cp.Mobileconfig)
+	reservedTypes, err := marshalReservedPayloadTypes(cp.ReservedPayloadTypes)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "marshal reserved payload types")
+	}
+
+	res, err := ds.writer.ExecContext(ctx, stmt, teamID, cp.HostID, cp.Identifier, cp.Name, cp.Mobileconfig, ds.profileChecksum.Sum(cp.Mobileconfig), reservedTypes)
 	if err != nil {
 		switch {
 		case isDuplicate(err):
@@ -43,14 +51,26 @@ VALUES (?, ?, ?, ?, UNHEX(MD5(mobileconfig)))`
 	id, _ := res.LastInsertId()
 
 	return &fleet.MDMAppleConfigProfile{
-		ProfileID:    uint(id),
-		Identifier:   cp.Identifier,
-		Name:         cp.Name,
-		Mobileconfig: cp.Mobileconfig,
-		TeamID:       cp.TeamID,
+		ProfileID:            uint(id),
+		Identifier:           cp.Identifier,
+		Name:                 cp.Name,
+		Mobileconfig:         cp.Mobileconfig,
+		TeamID:               cp.TeamID,
+		HostID:               cp.HostID,
+		ReservedPayloadTypes: cp.ReservedPayloadTypes,
 	}, nil
 }
 
+// marshalReservedPayloadTypes returns the JSON representation of the reserved
+// payload types of a profile as stored in the database, which is NULL if the
+// profile has none.
+func marshalReservedPayloadTypes(types []string) ([]byte, error) {
+	if len(types) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(types)
+}
+
 func formatErrorDuplicateConfigProfile(err error, cp *fleet.MDMAppleConfigProfile) error {
 	switch {
 	case strings.Contains(err.Error(), "idx_mdm_apple_config_prof_team_identifier"):
@@ -78,6 +98,9 @@ SELECT
 	name,
 	identifier,
 	mobileconfig,
+	target_id,
+	min_macos_version,
+	max_macos_version,
 	created_at,
 	updated_at
 FROM
@@ -106,14 +129,61 @@ ORDER BY name`
 	return res, nil
 }
 
+func (ds *Datastore) ListMDMAppleProfileIdentifierConflicts(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+	if len(identifiers) == 0 {
+		return nil, nil
+	}
+
+	// a host profile conflicts if it was not delivered by one of the team's
+	// profiles, e.g. it comes from the team the host was in before, or from a
+	// profile that was deleted but is not removed from the host yet.
+	stmt := `
+SELECT
+	hmap.profile_identifier AS identifier,
+	COUNT(DISTINCT hmap.host_uuid) AS hosts_count
+FROM
+	host_mdm_apple_profiles hmap
+	JOIN hosts h ON h.uuid = hmap.host_uuid
+WHERE
+	COALESCE(h.team_id, 0) = ? AND
+	hmap.profile_identifier IN (?) AND
+	NOT EXISTS (
+		SELECT 1 FROM mdm_apple_configuration_profiles macp
+		WHERE macp.profile_id = hmap.profile_id AND macp.team_id = ?
+	)
+GROUP BY
+	hmap.profile_identifier
+ORDER BY
+	hmap.profile_identifier`
+
+	var tmID uint
+	if teamID != nil {
+		tmID = *teamID
+	}
+	stmt, args, err := sqlx.In(stmt, tmID, identifiers, tmID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "sqlx.In ListMDMAppleProfileIdentifierConflicts")
+	}
+
+	var res []*fleet.MDMAppleProfileIdentifierConflict
+	if err := sqlx.SelectContext(ctx, ds.reader, &res, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list profile identifier conflicts")
+	}
+	return res, nil
+}
+
 func (ds *Datastore) GetMDMAppleConfigProfile(ctx context.Context, profileID uint) (*fleet.MDMAppleConfigProfile, error) {
 	stmt := `
 SELECT
 	profile_id,
 	team_id,
+	host_id,
 	name,
 	identifier,
 	mobileconfig,
+	target_id,
+	min_macos_version,
+	max_macos_version,
 	created_at,
 	updated_at
 FROM
@@ -150,6 +220,119 @@ func (ds *Datastore) DeleteMDMAppleConfigProfile(ctx context.Context, profileID
 	return nil
 }
 
+func (ds *Datastore) ListMDMAppleConfigProfilesByIDsOrIdentifiers(ctx context.Context, profileIDs []uint, identifiers []string) ([]*fleet.MDMAppleConfigProfile, error) {
+	if len(profileIDs) == 0 && len(identifiers) == 0 {
+		return nil, nil
+	}
+
+	stmt := `
+SELECT
+	profile_id,
+	team_id,
+	name,
+	identifier,
+	mobileconfig,
+	target_id,
+	min_macos_version,
+	max_macos_version,
+	created_at,
+	updated_at
+FROM
+	mdm_apple_configuration_profiles
+WHERE
+	%s
+ORDER BY
+	team_id, profile_id`
+
+	var conds []string
+	var args []interface{}
+	if len(profileIDs) > 0 {
+		conds = append(conds, "profile_id IN (?)")
+		args = append(args, profileIDs)
+	}
+	if len(identifiers) > 0 {
+		conds = append(conds, "identifier IN (?)")
+		args = append(args, identifiers)
+	}
+
+	stmt, args, err := sqlx.In(fmt.Sprintf(stmt, strings.Join(conds, " OR ")), args...)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "building in statement")
+	}
+
+	var res []*fleet.MDMAppleConfigProfile
+	if err := sqlx.SelectContext(ctx, ds.reader, &res, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list mdm apple config profiles by ids or identifiers")
+	}
+	return res, nil
+}
+
+func (ds *Datastore) DeleteMDMAppleConfigProfiles(ctx context.Context, profileIDs []uint) error {
+	if len(profileIDs) == 0 {
+		return nil
+	}
+
+	stmt, args, err := sqlx.In(`DELETE FROM mdm_apple_configuration_profiles WHERE profile_id IN (?)`, profileIDs)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "building in statement")
+	}
+
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		res, err := tx.ExecContext(ctx, stmt, args...)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "delete mdm apple config profiles")
+		}
+		// all the profiles must exist, otherwise none is deleted
+		if deleted, _ := res.RowsAffected(); deleted != int64(len(profileIDs)) {
+			return ctxerr.Wrap(ctx, notFound("MDMAppleConfigProfile").WithMessage("some profiles do not exist"))
+		}
+		return nil
+	})
+}
+
+func (ds *Datastore) CopyMDMAppleConfigProfileToTeams(ctx context.Context, cp *fleet.MDMAppleConfigProfile, teamIDs []uint) ([]*fleet.MDMAppleConfigProfile, error) {
+	stmt := `
+INSERT INTO
+    mdm_apple_configuration_profiles (team_id, identifier, name, mobileconfig, checksum, reserved_payload_types)
+VALUES (?, ?, ?, ?, ?, ?)`
+
+	reservedTypes, err := marshalReservedPayloadTypes(cp.ReservedPayloadTypes)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "marshal reserved payload types")
+	}
+
+	var copies []*fleet.MDMAppleConfigProfile
+	err = ds.withTx(ctx, func(tx sqlx.ExtContext) error {
+		copies = make([]*fleet.MDMAppleConfigProfile, 0, len(teamIDs))
+		for _, teamID := range teamIDs {
+			teamID := teamID
+			copied := &fleet.MDMAppleConfigProfile{
+				Identifier:           cp.Identifier,
+				Name:                 cp.Name,
+				Mobileconfig:         cp.Mobileconfig,
+				TeamID:               &teamID,
+				ReservedPayloadTypes: cp.ReservedPayloadTypes,
+			}
+
+			res, err := tx.ExecContext(ctx, stmt, teamID, cp.Identifier, cp.Name, cp.Mobileconfig, ds.profileChecksum.Sum(cp.Mobileconfig), reservedTypes)
+			if err != nil {
+				if isDuplicate(err) {
+					return ctxerr.Wrap(ctx, formatErrorDuplicateConfigProfile(err, copied))
+				}
+				return ctxerr.Wrap(ctx, err, "copy mdm config profile")
+			}
+			id, _ := res.LastInsertId()
+			copied.ProfileID = uint(id)
+			copies = append(copies, copied)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copies, nil
+}
+
 func (ds *Datastore) DeleteMDMAppleConfigProfileByTeamAndIdentifier(ctx context.Context, teamID *uint, profileIdentifier string) error {
 	if teamID == nil {
 		teamID = ptr.Uint(0)
@@ -171,28 +354,91 @@ func (ds *Datastore) DeleteMDMAppleConfigProfileByTeamAndIdentifier(ctx context.
 func (ds *Datastore) GetHostMDMProfiles(ctx context.Context, hostUUID string) ([]fleet.HostMDMAppleProfile, error) {
 	stmt := fmt.Sprintf(`
 SELECT
-	profile_id,
-	profile_name AS name,
-	profile_identifier AS identifier,
+	hmap.profile_id,
+	hmap.profile_name AS name,
+	hmap.profile_identifier AS identifier,
 	-- internally, a NULL status implies that the cron needs to pick up
 	-- this profile, for the user that difference doesn't exist, the
 	-- profile is effectively pending. This is consistent with all our
 	-- aggregation functions.
-	COALESCE(status, '%s') AS status,
-	COALESCE(operation_type, '') AS operation_type,
-	COALESCE(detail, '') AS detail
+	COALESCE(hmap.status, '%[1]s') AS status,
+	COALESCE(hmap.operation_type, '') AS operation_type,
+	COALESCE(hmap.detail, '') AS detail,
+	COALESCE(macp.team_id = %[2]d, FALSE) AS ad_hoc,
+	CASE
+		WHEN macp.profile_id IS NULL THEN ''
+		WHEN hmap.profile_identifier IN (?) THEN '%[6]s'
+		WHEN macp.team_id = %[2]d THEN '%[7]s'
+		WHEN macp.team_id = 0 OR macp.team_id = %[8]d THEN '%[9]s'
+		ELSE '%[10]s'
+	END AS origin
 FROM
-	host_mdm_apple_profiles
+	host_mdm_apple_profiles hmap
+	LEFT JOIN mdm_apple_configuration_profiles macp ON macp.profile_id = hmap.profile_id
 WHERE
-	host_uuid = ? AND NOT (operation_type = '%s' AND COALESCE(status, '%s') = '%s')`,
+	hmap.host_uuid = ? AND NOT (hmap.operation_type = '%[3]s' AND COALESCE(hmap.status, '%[4]s') = '%[5]s')`,
 		fleet.MDMAppleDeliveryPending,
+		fleet.MDMAppleHostProfilesTeamID,
 		fleet.MDMAppleOperationTypeRemove,
 		fleet.MDMAppleDeliveryPending,
 		fleet.MDMAppleDeliveryVerifying,
+		fleet.MDMAppleProfileOriginFleet,
+		fleet.MDMAppleProfileOriginAdHoc,
+		fleet.MDMAppleAllTeamsProfilesTeamID,
+		fleet.MDMAppleProfileOriginGlobal,
+		fleet.MDMAppleProfileOriginTeam,
 	)
 
+	// the profiles in the scope of the host that are not installed because the
+	// host's macOS version is outside of their version range are reported as
+	// not applicable.
+	stmt += fmt.Sprintf(`
+UNION ALL
+SELECT
+	macp.profile_id,
+	macp.name,
+	macp.identifier,
+	'%[1]s' AS status,
+	'' AS operation_type,
+	'' AS detail,
+	macp.team_id = %[2]d AS ad_hoc,
+	CASE
+		WHEN macp.team_id = %[2]d THEN '%[3]s'
+		WHEN macp.team_id = 0 OR macp.team_id = %[4]d THEN '%[5]s'
+		ELSE '%[6]s'
+	END AS origin
+FROM
+	mdm_apple_configuration_profiles macp
+	JOIN hosts h ON `,
+		fleet.MDMAppleDeliveryNotApplicable,
+		fleet.MDMAppleHostProfilesTeamID,
+		fleet.MDMAppleProfileOriginAdHoc,
+		fleet.MDMAppleAllTeamsProfilesTeamID,
+		fleet.MDMAppleProfileOriginGlobal,
+		fleet.MDMAppleProfileOriginTeam,
+	) + mdmAppleProfileHostScopeCond + `
+WHERE
+	h.uuid = ? AND
+	` + mdmAppleProfileNotExcludedCond + ` AND
+	` + mdmAppleProfileInTargetCond + ` AND
+	NOT ` + mdmAppleProfileInMacOSVersionRangeCond + ` AND
+	NOT EXISTS (
+		SELECT 1
+		FROM host_mdm_apple_profiles hmap
+		WHERE hmap.host_uuid = h.uuid AND hmap.profile_id = macp.profile_id
+	)`
+
+	fleetIdents := []string{}
+	for ident := range mobileconfig.FleetPayloadIdentifiers() {
+		fleetIdents = append(fleetIdents, ident)
+	}
+	stmt, args, err := sqlx.In(stmt, fleetIdents, hostUUID, hostUUID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "sqlx.In GetHostMDMProfiles")
+	}
+
 	var profiles []fleet.HostMDMAppleProfile
-	if err := sqlx.SelectContext(ctx, ds.reader, &profiles, stmt, hostUUID); err != nil {
+	if err := sqlx.SelectContext(ctx, ds.reader, &profiles, stmt, args...); err != nil {
 		return nil, err
 	}
 	return profiles, nil
@@ -294,7 +540,8 @@ SELECT
     ncr.status,
     ncr.result,
 		ncr.updated_at,
-		nc.request_type
+		nc.request_type,
+    COALESCE(ncr.trace_id, '') as trace_id
 FROM
     nano_command_results ncr
 INNER JOIN
@@ -319,6 +566,30 @@ WHERE
 	return results, nil
 }
 
+func (ds *Datastore) RedactMDMAppleCommandResult(ctx context.Context, hostUUID, commandUUID string, redactedResult []byte) error {
+	const stmt = `UPDATE nano_command_results SET result = ? WHERE id = ? AND command_uuid = ?`
+
+	_, err := ds.writer.ExecContext(ctx, stmt, redactedResult, hostUUID, commandUUID)
+	return ctxerr.Wrap(ctx, err, "redact command result")
+}
+
+func (ds *Datastore) RedactMDMAppleCommand(ctx context.Context, commandUUID, redactedCommand string) error {
+	// the command is still due to be delivered to the hosts that have it
+	// queued without a result, or that answered NotNow.
+	const stmt = `
+UPDATE nano_commands nc
+SET nc.command = ?
+WHERE nc.command_uuid = ? AND NOT EXISTS (
+  SELECT 1
+  FROM nano_enrollment_queue neq
+  LEFT JOIN nano_command_results ncr ON ncr.id = neq.id AND ncr.command_uuid = neq.command_uuid
+  WHERE neq.command_uuid = nc.command_uuid AND neq.active = 1 AND (ncr.status IS NULL OR ncr.status = ?)
+)`
+
+	_, err := ds.writer.ExecContext(ctx, stmt, redactedCommand, commandUUID, fleet.MDMAppleStatusNotNow)
+	return ctxerr.Wrap(ctx, err, "redact command")
+}
+
 func (ds *Datastore) ListMDMAppleCommands(
 	ctx context.Context,
 	tmFilter fleet.TeamFilter,
@@ -342,7 +613,13 @@ ON
 WHERE
     %s
 `, ds.whereFilterHostsByTeams(tmFilter, "h"))
-	stmt, params := appendListOptionsWithCursorToSQL(stmt, nil, &listOpts.ListOptions)
+
+	var params []interface{}
+	if listOpts.CommandUUID != "" {
+		stmt += ` AND nvq.command_uuid = ?`
+		params = append(params, listOpts.CommandUUID)
+	}
+	stmt, params = appendListOptionsWithCursorToSQL(stmt, params, &listOpts.ListOptions)
 
 	var results []*fleet.MDMAppleCommand
 	if err := sqlx.SelectContext(ctx, ds.reader, &results, stmt, params...); err != nil {
@@ -469,7 +746,17 @@ func (ds *Datastore) IngestMDMAppleDeviceFromCheckin(ctx context.Context, mdmHos
 		return ctxerr.Wrap(ctx, err, "ingest mdm apple host get app config")
 	}
 	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
-		return ingestMDMAppleDeviceFromCheckinDB(ctx, tx, mdmHost, ds.logger, appCfg)
+		if err := ingestMDMAppleDeviceFromCheckinDB(ctx, tx, mdmHost, ds.logger, appCfg); err != nil {
+			return err
+		}
+		return recordHostMDMChangesDB(ctx, tx, []hostMDMChange{{
+			HostUUID: mdmHost.UDID,
+			Type:     fleet.HostMDMChangeEnrolled,
+			Details: map[string]string{
+				"serial_number": mdmHost.SerialNumber,
+				"model":         mdmHost.Model,
+			},
+		}})
 	})
 }
 
@@ -509,6 +796,24 @@ func updateMDMAppleHostDB(
 	mdmHost fleet.MDMAppleHostDetails,
 	appCfg *fleet.AppConfig,
 ) error {
+	// a host that enrolls again with the same serial number but a new UDID was
+	// re-provisioned (e.g. wiped and re-imaged), the MDM state of its previous
+	// installation is stale.
+	var prevUUID string
+	if err := sqlx.GetContext(ctx, tx, &prevUUID, `SELECT uuid FROM hosts WHERE id = ?`, hostID); err != nil {
+		return ctxerr.Wrap(ctx, err, "get previous uuid of mdm apple host")
+	}
+	if prevUUID != "" && !strings.EqualFold(prevUUID, mdmHost.UDID) {
+		if err := purgeHostMDMAppleDataDB(ctx, tx, hostID, prevUUID); err != nil {
+			return ctxerr.Wrap(ctx, err, "purge mdm data of re-provisioned host")
+		}
+		// the enrollment of the previous installation can't be used anymore,
+		// deleting the device cascades to its enrollments and queued commands.
+		if _, err := tx.ExecContext(ctx, `DELETE FROM nano_devices WHERE id = ?`, prevUUID); err != nil {
+			return ctxerr.Wrap(ctx, err, "delete previous nano device of re-provisioned host")
+		}
+	}
+
 	updateStmt := `
 		UPDATE hosts SET
 			hardware_serial = ?,
@@ -599,11 +904,114 @@ func insertMDMAppleHostDB(
 	return nil
 }
 
+// mdmAppleDEPTeamAssignmentsBatchSize is the number of team assignments
+// written or read at once.
+const mdmAppleDEPTeamAssignmentsBatchSize = 1000
+
+func (ds *Datastore) SetMDMAppleDEPTeamAssignments(ctx context.Context, assignments []*fleet.MDMAppleDEPTeamAssignment) error {
+	var toUpsert []*fleet.MDMAppleDEPTeamAssignment
+	var toDelete []string
+	for _, a := range assignments {
+		if a.TeamID == nil {
+			toDelete = append(toDelete, a.SerialNumber)
+			continue
+		}
+		toUpsert = append(toUpsert, a)
+	}
+
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		for i := 0; i < len(toUpsert); i += mdmAppleDEPTeamAssignmentsBatchSize {
+			end := i + mdmAppleDEPTeamAssignmentsBatchSize
+			if end > len(toUpsert) {
+				end = len(toUpsert)
+			}
+			batch := toUpsert[i:end]
+
+			args := make([]interface{}, 0, 2*len(batch))
+			for _, a := range batch {
+				args = append(args, a.SerialNumber, *a.TeamID)
+			}
+			stmt := `
+          INSERT INTO mdm_apple_dep_team_assignments (serial_number, team_id)
+          VALUES ` + strings.TrimSuffix(strings.Repeat("(?, ?),", len(batch)), ",") + `
+          ON DUPLICATE KEY UPDATE team_id = VALUES(team_id)`
+			if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+				return ctxerr.Wrap(ctx, err, "upsert dep team assignments")
+			}
+		}
+
+		for i := 0; i < len(toDelete); i += mdmAppleDEPTeamAssignmentsBatchSize {
+			end := i + mdmAppleDEPTeamAssignmentsBatchSize
+			if end > len(toDelete) {
+				end = len(toDelete)
+			}
+			stmt, args, err := sqlx.In(`DELETE FROM mdm_apple_dep_team_assignments WHERE serial_number IN (?)`, toDelete[i:end])
+			if err != nil {
+				return ctxerr.Wrap(ctx, err, "prepare delete dep team assignments")
+			}
+			if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+				return ctxerr.Wrap(ctx, err, "delete dep team assignments")
+			}
+		}
+		return nil
+	})
+}
+
+func (ds *Datastore) ListMDMAppleDEPTeamAssignments(ctx context.Context) ([]*fleet.MDMAppleDEPTeamAssignment, error) {
+	stmt := `
+          SELECT
+            mdta.serial_number,
+            mdta.team_id,
+            t.name AS team_name,
+            mdta.updated_at
+          FROM mdm_apple_dep_team_assignments mdta
+          JOIN teams t ON t.id = mdta.team_id
+          ORDER BY mdta.serial_number`
+
+	assignments := []*fleet.MDMAppleDEPTeamAssignment{}
+	if err := sqlx.SelectContext(ctx, ds.reader, &assignments, stmt); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list dep team assignments")
+	}
+	return assignments, nil
+}
+
+func (ds *Datastore) GetMDMAppleDEPTeamAssignments(ctx context.Context, serials []string) (map[string]uint, error) {
+	teamIDs := make(map[string]uint)
+	for i := 0; i < len(serials); i += mdmAppleDEPTeamAssignmentsBatchSize {
+		end := i + mdmAppleDEPTeamAssignmentsBatchSize
+		if end > len(serials) {
+			end = len(serials)
+		}
+		stmt, args, err := sqlx.In(`
+          SELECT serial_number, team_id
+          FROM mdm_apple_dep_team_assignments
+          WHERE serial_number IN (?)`, serials[i:end])
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "prepare get dep team assignments")
+		}
+		var batch []*fleet.MDMAppleDEPTeamAssignment
+		if err := sqlx.SelectContext(ctx, ds.reader, &batch, stmt, args...); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "get dep team assignments")
+		}
+		for _, a := range batch {
+			teamIDs[a.SerialNumber] = *a.TeamID
+		}
+	}
+	return teamIDs, nil
+}
+
 func (ds *Datastore) IngestMDMAppleDevicesFromDEPSync(ctx context.Context, devices []godep.Device) (int64, error) {
 	if len(devices) < 1 {
 		level.Debug(ds.logger).Log("msg", "ingesting devices from DEP received < 1 device, skipping", "len(devices)", len(devices))
 		return 0, nil
 	}
+	if err := updateModifiedMDMAppleDEPDevicesDB(ctx, ds.writer, devices); err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "ingest mdm apple host update modified dep devices")
+	}
+	if err := updateUnassignedMDMAppleDEPDevicesDB(ctx, ds.writer, devices); err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "ingest mdm apple host update unassigned dep devices")
+	}
+
 	filteredDevices := filterMDMAppleDevices(devices, ds.logger)
 	if len(filteredDevices) < 1 {
 		level.Debug(ds.logger).Log("msg", "ingesting devices from DEP filtered all devices, skipping", "len(devices)", len(devices))
@@ -659,7 +1067,11 @@ func (ds *Datastore) IngestMDMAppleDevicesFromDEPSync(ctx context.Context, devic
 				'2000-01-01 00:00:00' AS detail_updated_at,
 				NULL AS osquery_host_id,
 				1 AS refetch_requested,
-				? AS team_id
+				COALESCE((
+					SELECT mdta.team_id
+					FROM mdm_apple_dep_team_assignments mdta
+					WHERE mdta.serial_number = us.hardware_serial
+				), ?) AS team_id
 			FROM (%s) us
 			LEFT JOIN hosts h ON us.hardware_serial = h.hardware_serial
 		WHERE
@@ -689,14 +1101,34 @@ func (ds *Datastore) IngestMDMAppleDevicesFromDEPSync(ctx context.Context, devic
 		}
 		var hosts []fleet.Host
 		err = sqlx.SelectContext(ctx, tx, &hosts, fmt.Sprintf(`
-			SELECT id, hardware_model, hardware_serial FROM hosts WHERE hardware_serial IN(%s)`,
+			SELECT id, hostname, computer_name, hardware_model, hardware_serial FROM hosts WHERE hardware_serial IN(%s)`,
 			strings.Join(parts, ",")),
 			args...)
 		if err != nil {
 			return ctxerr.Wrap(ctx, err, "ingest mdm apple host get host ids")
 		}
 
-		if err := upsertMDMAppleHostDisplayNamesDB(ctx, tx, hosts...); err != nil {
+		devicesBySerial := make(map[string]godep.Device, len(filteredDevices))
+		for _, d := range filteredDevices {
+			devicesBySerial[d.SerialNumber] = d
+		}
+		if err := upsertMDMAppleHostDEPDevicesDB(ctx, tx, hosts, devicesBySerial); err != nil {
+			return ctxerr.Wrap(ctx, err, "ingest mdm apple host upsert dep devices")
+		}
+		if err := upsertHostDEPCustomAttributesDB(ctx, tx, hosts, devicesBySerial); err != nil {
+			return ctxerr.Wrap(ctx, err, "ingest mdm apple host upsert dep custom attributes")
+		}
+
+		if appCfg.MDM.AppleBMEnrichDisplayName {
+			names := make(map[uint]string, len(hosts))
+			for _, h := range hosts {
+				names[h.ID] = depHostDisplayName(h, devicesBySerial[h.HardwareSerial])
+			}
+			err = upsertHostDisplayNamesDB(ctx, tx, names)
+		} else {
+			err = upsertMDMAppleHostDisplayNamesDB(ctx, tx, hosts...)
+		}
+		if err != nil {
 			return ctxerr.Wrap(ctx, err, "ingest mdm apple host upsert display names")
 		}
 
@@ -718,11 +1150,59 @@ func (ds *Datastore) IngestMDMAppleDevicesFromDEPSync(ctx context.Context, devic
 	return resCount, err
 }
 
-func upsertMDMAppleHostDisplayNamesDB(ctx context.Context, tx sqlx.ExtContext, hosts ...fleet.Host) error {
+// upsertHostDEPCustomAttributesDB sets the custom attributes of the hosts
+// that are provided by Apple Business Manager, for the devices that have a
+// value for them. Those attributes are typically set before the host enrolls,
+// so the profiles that reference them are installed with their values.
+func upsertHostDEPCustomAttributesDB(ctx context.Context, tx sqlx.ExtContext, hosts []fleet.Host, devicesBySerial map[string]godep.Device) error {
 	args := []interface{}{}
 	parts := []string{}
 	for _, h := range hosts {
-		args = append(args, h.ID, h.DisplayName())
+		dev := devicesBySerial[h.HardwareSerial]
+		for name, value := range map[string]string{
+			fleet.HostCustomAttributeDEPAssignedBy: dev.DeviceAssignedBy,
+			fleet.HostCustomAttributeDEPAssetTag:   dev.AssetTag,
+		} {
+			if value == "" {
+				continue
+			}
+			args = append(args, h.ID, name, value, fleet.HostCustomAttributeSourceDEP)
+			parts = append(parts, "(?, ?, ?, ?)")
+		}
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO host_custom_attributes (host_id, name, value, source) VALUES %s
+			ON DUPLICATE KEY UPDATE value = VALUES(value), source = VALUES(source)`, strings.Join(parts, ",")),
+		args...)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "upsert host dep custom attributes")
+	}
+	return nil
+}
+
+func upsertMDMAppleHostDisplayNamesDB(ctx context.Context, tx sqlx.ExtContext, hosts ...fleet.Host) error {
+	names := make(map[uint]string, len(hosts))
+	for _, h := range hosts {
+		names[h.ID] = h.DisplayName()
+	}
+	return upsertHostDisplayNamesDB(ctx, tx, names)
+}
+
+// upsertHostDisplayNamesDB sets the display names of the hosts, keyed by host
+// id.
+func upsertHostDisplayNamesDB(ctx context.Context, tx sqlx.ExtContext, names map[uint]string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	args := []interface{}{}
+	parts := []string{}
+	for id, name := range names {
+		args = append(args, id, name)
 		parts = append(parts, "(?, ?)")
 	}
 
@@ -737,6 +1217,132 @@ func upsertMDMAppleHostDisplayNamesDB(ctx context.Context, tx sqlx.ExtContext, h
 	return nil
 }
 
+// depHostDisplayName returns the display name of a host created from the DEP
+// device sync, built from the description and asset tag provided by Apple
+// Business Manager. The default display name is returned if the host already
+// reported its name or if there is no description for the device.
+func depHostDisplayName(h fleet.Host, dev godep.Device) string {
+	if h.ComputerName != "" || h.Hostname != "" || dev.Description == "" {
+		return h.DisplayName()
+	}
+	if dev.AssetTag != "" {
+		return fmt.Sprintf("%s (%s, %s)", dev.Description, h.HardwareSerial, dev.AssetTag)
+	}
+	return fmt.Sprintf("%s (%s)", dev.Description, h.HardwareSerial)
+}
+
+// upsertMDMAppleHostDEPDevicesDB stores the Apple Business Manager device
+// information of the hosts, matched by serial number.
+func upsertMDMAppleHostDEPDevicesDB(ctx context.Context, tx sqlx.ExtContext, hosts []fleet.Host, devicesBySerial map[string]godep.Device) error {
+	args := []interface{}{}
+	parts := []string{}
+	for _, h := range hosts {
+		dev, ok := devicesBySerial[h.HardwareSerial]
+		if !ok {
+			continue
+		}
+		args = append(args, h.ID, dev.Description, dev.Color, dev.AssetTag, dev.DeviceFamily, dev.OS, dev.DeviceAssignedBy,
+			nullTime(dev.DeviceAssignedDate), dev.ProfileStatus, dev.ProfileUUID, nullTime(dev.ProfileAssignTime), nullTime(dev.ProfilePushTime))
+		parts = append(parts, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO host_mdm_apple_dep_devices
+				(host_id, description, color, asset_tag, device_family, os, device_assigned_by, device_assigned_date,
+				profile_status, profile_uuid, profile_assign_time, profile_push_time)
+			VALUES %s
+			ON DUPLICATE KEY UPDATE
+				description = VALUES(description),
+				color = VALUES(color),
+				asset_tag = VALUES(asset_tag),
+				device_family = VALUES(device_family),
+				os = VALUES(os),
+				device_assigned_by = VALUES(device_assigned_by),
+				device_assigned_date = VALUES(device_assigned_date),
+				profile_status = VALUES(profile_status),
+				profile_uuid = VALUES(profile_uuid),
+				profile_assign_time = VALUES(profile_assign_time),
+				profile_push_time = VALUES(profile_push_time),
+				unassigned_at = NULL`, strings.Join(parts, ",")),
+		args...)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "upsert host dep devices")
+	}
+	return nil
+}
+
+// nullTime returns nil for the zero time, which the DEP API uses for the
+// dates that are not set.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// updateModifiedMDMAppleDEPDevicesDB updates the Apple Business Manager device
+// information of the existing hosts of the devices that were modified in ABM.
+// Those devices are otherwise ignored by the DEP sync.
+func updateModifiedMDMAppleDEPDevicesDB(ctx context.Context, tx sqlx.ExtContext, devices []godep.Device) error {
+	devicesBySerial := make(map[string]godep.Device)
+	for _, d := range devices {
+		if strings.ToLower(d.OpType) == "modified" {
+			devicesBySerial[d.SerialNumber] = d
+		}
+	}
+	if len(devicesBySerial) == 0 {
+		return nil
+	}
+
+	serials := make([]string, 0, len(devicesBySerial))
+	for serial := range devicesBySerial {
+		serials = append(serials, serial)
+	}
+	stmt, args, err := sqlx.In(`SELECT id, hardware_serial FROM hosts WHERE hardware_serial IN (?)`, serials)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "build query to get modified dep hosts")
+	}
+	var hosts []fleet.Host
+	if err := sqlx.SelectContext(ctx, tx, &hosts, stmt, args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "get modified dep hosts")
+	}
+	return upsertMDMAppleHostDEPDevicesDB(ctx, tx, hosts, devicesBySerial)
+}
+
+// updateUnassignedMDMAppleDEPDevicesDB records when the devices that were
+// removed from Fleet's MDM server in Apple Business Manager (e.g. because they
+// were assigned to another MDM server) were reported as such by the DEP sync.
+// The time is cleared when the devices are synced again as added or modified.
+func updateUnassignedMDMAppleDEPDevicesDB(ctx context.Context, tx sqlx.ExtContext, devices []godep.Device) error {
+	var serials []string
+	for _, d := range devices {
+		if strings.ToLower(d.OpType) == "deleted" {
+			serials = append(serials, d.SerialNumber)
+		}
+	}
+	if len(serials) == 0 {
+		return nil
+	}
+
+	// the row is created for the hosts ingested before the device information
+	// was stored, the first time the device was reported unassigned is kept.
+	stmt, args, err := sqlx.In(`
+			INSERT INTO host_mdm_apple_dep_devices (host_id, unassigned_at)
+			SELECT id, CURRENT_TIMESTAMP FROM hosts WHERE hardware_serial IN (?)
+			ON DUPLICATE KEY UPDATE
+				unassigned_at = COALESCE(unassigned_at, VALUES(unassigned_at))`, serials)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "build query to update unassigned dep devices")
+	}
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "update unassigned dep devices")
+	}
+	return nil
+}
+
 func upsertMDMAppleHostMDMInfoDB(ctx context.Context, tx sqlx.ExtContext, serverSettings fleet.ServerSettings, fromSync bool, hostIDs ...uint) error {
 	serverURL, err := apple_mdm.ResolveAppleMDMURL(serverSettings.ServerURL)
 	if err != nil {
@@ -856,7 +1462,15 @@ func (ds *Datastore) UpdateHostTablesOnMDMUnenroll(ctx context.Context, uuid str
 			return ctxerr.Wrap(ctx, err, "removing all profiles from host")
 		}
 
-		return nil
+		// a new manual enrollment of the host must be approved again.
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM host_mdm_apple_enrollment_approvals
+			WHERE host_uuid = ?`, uuid)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "removing enrollment approval of host")
+		}
+
+		return recordHostMDMChangesDB(ctx, tx, []hostMDMChange{{HostUUID: uuid, Type: fleet.HostMDMChangeUnenrolled}})
 	})
 }
 
@@ -908,6 +1522,32 @@ func (ds *Datastore) GetNanoMDMEnrollment(ctx context.Context, id string) (*flee
 }
 
 func (ds *Datastore) BatchSetMDMAppleProfiles(ctx context.Context, tmID *uint, profiles []*fleet.MDMAppleConfigProfile) error {
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		return batchSetMDMAppleProfilesDB(ctx, tx, tmID, profiles, ds.profileChecksum)
+	})
+}
+
+func (ds *Datastore) BatchSetMDMAppleTeamsProfiles(ctx context.Context, teams []*fleet.MDMAppleTeamProfiles) error {
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		for _, tm := range teams {
+			if err := batchSetMDMAppleProfilesDB(ctx, tx, tm.TeamID, tm.Profiles, ds.profileChecksum); err != nil {
+				return err
+			}
+			if err := batchSetMDMAppleProfileExclusionsDB(ctx, tx, tm.TeamID, tm.Exclusions); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func batchSetMDMAppleProfilesDB(
+	ctx context.Context,
+	tx sqlx.ExtContext,
+	tmID *uint,
+	profiles []*fleet.MDMAppleConfigProfile,
+	checksum fleet.MDMProfileChecksumAlgorithm,
+) error {
 	const loadExistingProfiles = `
 SELECT
   identifier,
@@ -930,14 +1570,15 @@ WHERE
 	const insertNewOrEditedProfile = `
 INSERT INTO
   mdm_apple_configuration_profiles (
-    team_id, identifier, name, mobileconfig, checksum
+    team_id, identifier, name, mobileconfig, checksum, reserved_payload_types
   )
 VALUES
-  ( ?, ?, ?, ?, UNHEX(MD5(mobileconfig)) )
+  ( ?, ?, ?, ?, ?, ? )
 ON DUPLICATE KEY UPDATE
   name = VALUES(name),
   mobileconfig = VALUES(mobileconfig),
-  checksum = UNHEX(MD5(VALUES(mobileconfig)))
+  checksum = VALUES(checksum),
+  reserved_payload_types = VALUES(reserved_payload_types)
 `
 
 	// use a profile team id of 0 if no-team
@@ -957,132 +1598,638 @@ ON DUPLICATE KEY UPDATE
 		incomingProfs[p.Identifier] = p
 	}
 
-	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
-		var existingProfiles []*fleet.MDMAppleConfigProfile
+	var existingProfiles []*fleet.MDMAppleConfigProfile
 
-		if len(incomingIdents) > 0 {
-			// load existing profiles that match the incoming profiles by identifiers
-			stmt, args, err := sqlx.In(loadExistingProfiles, profTeamID, incomingIdents)
-			if err != nil {
-				return ctxerr.Wrap(ctx, err, "build query to load existing profiles")
-			}
-			if err := sqlx.SelectContext(ctx, tx, &existingProfiles, stmt, args...); err != nil {
-				return ctxerr.Wrap(ctx, err, "load existing profiles")
-			}
+	if len(incomingIdents) > 0 {
+		// load existing profiles that match the incoming profiles by identifiers
+		stmt, args, err := sqlx.In(loadExistingProfiles, profTeamID, incomingIdents)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "build query to load existing profiles")
 		}
-
-		// figure out if we need to delete any profiles
-		keepIdents := make([]string, 0, len(incomingIdents))
-		for _, p := range existingProfiles {
-			if newP := incomingProfs[p.Identifier]; newP != nil {
-				keepIdents = append(keepIdents, p.Identifier)
-			}
+		if err := sqlx.SelectContext(ctx, tx, &existingProfiles, stmt, args...); err != nil {
+			return ctxerr.Wrap(ctx, err, "load existing profiles")
 		}
+	}
 
-		// profiles that are managed and delivered by Fleet
-		fleetIdents := []string{}
-		for ident := range mobileconfig.FleetPayloadIdentifiers() {
-			fleetIdents = append(fleetIdents, ident)
+	// figure out if we need to delete any profiles
+	keepIdents := make([]string, 0, len(incomingIdents))
+	for _, p := range existingProfiles {
+		if newP := incomingProfs[p.Identifier]; newP != nil {
+			keepIdents = append(keepIdents, p.Identifier)
 		}
+	}
 
-		var (
-			stmt string
-			args []interface{}
-			err  error
-		)
-		// delete the obsolete profiles (all those that are not in keepIdents or delivered by Fleet)
-		stmt, args, err = sqlx.In(deleteProfilesNotInList, profTeamID, append(keepIdents, fleetIdents...))
-		if err != nil {
-			return ctxerr.Wrap(ctx, err, "build statement to delete obsolete profiles")
+	// profiles that are managed and delivered by Fleet
+	fleetIdents := []string{}
+	for ident := range mobileconfig.FleetPayloadIdentifiers() {
+		fleetIdents = append(fleetIdents, ident)
+	}
+
+	var (
+		stmt string
+		args []interface{}
+		err  error
+	)
+	// delete the obsolete profiles (all those that are not in keepIdents or delivered by Fleet)
+	stmt, args, err = sqlx.In(deleteProfilesNotInList, profTeamID, append(keepIdents, fleetIdents...))
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "build statement to delete obsolete profiles")
+	}
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "delete obsolete profiles")
+	}
+
+	// insert the new profiles and the ones that have changed
+	for _, p := range incomingProfs {
+		reservedTypes, err := marshalReservedPayloadTypes(p.ReservedPayloadTypes)
+		if err != nil {
+			return ctxerr.Wrapf(ctx, err, "marshal reserved payload types of profile with identifier %q", p.Identifier)
 		}
-		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
-			return ctxerr.Wrap(ctx, err, "delete obsolete profiles")
+		if _, err := tx.ExecContext(ctx, insertNewOrEditedProfile, profTeamID, p.Identifier, p.Name, p.Mobileconfig, checksum.Sum(p.Mobileconfig), reservedTypes); err != nil {
+			return ctxerr.Wrapf(ctx, err, "insert new/edited profile with identifier %q", p.Identifier)
 		}
+	}
+	return nil
+}
 
-		// insert the new profiles and the ones that have changed
-		for _, p := range incomingProfs {
-			if _, err := tx.ExecContext(ctx, insertNewOrEditedProfile, profTeamID, p.Identifier, p.Name, p.Mobileconfig); err != nil {
-				return ctxerr.Wrapf(ctx, err, "insert new/edited profile with identifier %q", p.Identifier)
-			}
-		}
-		return nil
+func (ds *Datastore) BatchSetMDMAppleProfileExclusions(ctx context.Context, tmID *uint, exclusions []*fleet.MDMAppleProfileExclusion) error {
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		return batchSetMDMAppleProfileExclusionsDB(ctx, tx, tmID, exclusions)
 	})
 }
 
-// Note that team ID 0 is used for profiles that apply to hosts in no team
-// (i.e. pass 0 in that case as part of the teamIDs slice). Only one of the
-// slice arguments can have values.
-func (ds *Datastore) BulkSetPendingMDMAppleHostProfiles(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, hostUUIDs []string) error {
-	return ds.withTx(ctx, func(tx sqlx.ExtContext) error {
-		var countArgs int
-		if len(hostIDs) > 0 {
-			countArgs++
+func batchSetMDMAppleProfileExclusionsDB(ctx context.Context, tx sqlx.ExtContext, tmID *uint, exclusions []*fleet.MDMAppleProfileExclusion) error {
+	const deleteExclusions = `
+DELETE FROM
+  mdm_apple_configuration_profile_exclusions
+WHERE
+  team_id = ?
+`
+
+	const insertExclusions = `
+INSERT INTO
+  mdm_apple_configuration_profile_exclusions (
+    team_id, profile_identifier, label_id, host_id, idp_group
+  )
+VALUES
+  %s
+`
+
+	// use a profile team id of 0 if no-team
+	var profTeamID uint
+	if tmID != nil {
+		profTeamID = *tmID
+	}
+
+	if _, err := tx.ExecContext(ctx, deleteExclusions, profTeamID); err != nil {
+		return ctxerr.Wrap(ctx, err, "delete existing exclusions")
+	}
+	if len(exclusions) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	args := make([]interface{}, 0, len(exclusions)*5)
+	for i, e := range exclusions {
+		if i > 0 {
+			sb.WriteString(",")
 		}
-		if len(teamIDs) > 0 {
-			countArgs++
+		sb.WriteString("(?,?,?,?,?)")
+		args = append(args, profTeamID, e.ProfileIdentifier, e.LabelID, e.HostID, e.IdPGroup)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(insertExclusions, sb.String()), args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "insert exclusions")
+	}
+	return nil
+}
+
+func (ds *Datastore) ListMDMAppleProfileExclusions(ctx context.Context, tmID *uint) ([]*fleet.MDMAppleProfileExclusion, error) {
+	const stmt = `
+SELECT
+  team_id,
+  profile_identifier,
+  label_id,
+  host_id,
+  idp_group
+FROM
+  mdm_apple_configuration_profile_exclusions
+WHERE
+  team_id = ?
+ORDER BY
+  profile_identifier, id
+`
+
+	// use a profile team id of 0 if no-team
+	var profTeamID uint
+	if tmID != nil {
+		profTeamID = *tmID
+	}
+
+	var exclusions []*fleet.MDMAppleProfileExclusion
+	if err := sqlx.SelectContext(ctx, ds.reader, &exclusions, stmt, profTeamID); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list profile exclusions")
+	}
+	return exclusions, nil
+}
+
+// policyMDMActionRow is used to scan a row of mdm_apple_policy_actions, the
+// team_ids being stored as a JSON array.
+type policyMDMActionRow struct {
+	fleet.MDMApplePolicyAction
+	TeamIDsJSON []byte `db:"team_ids"`
+}
+
+func (r *policyMDMActionRow) toAction() (*fleet.MDMApplePolicyAction, error) {
+	action := r.MDMApplePolicyAction
+	if len(r.TeamIDsJSON) > 0 {
+		if err := json.Unmarshal(r.TeamIDsJSON, &action.TeamIDs); err != nil {
+			return nil, err
 		}
-		if len(profileIDs) > 0 {
-			countArgs++
+	}
+	return &action, nil
+}
+
+const selectMDMApplePolicyActionsStmt = `
+SELECT
+  policy_id,
+  COALESCE(remediation_profile, '') AS remediation_profile,
+  COALESCE(command_template, '') AS command_template,
+  team_ids,
+  created_at,
+  updated_at
+FROM
+  mdm_apple_policy_actions
+`
+
+func (ds *Datastore) SetMDMApplePolicyAction(ctx context.Context, action *fleet.MDMApplePolicyAction) error {
+	const stmt = `
+INSERT INTO mdm_apple_policy_actions
+  (policy_id, remediation_profile, command_template, team_ids)
+VALUES
+  (?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE
+  remediation_profile = VALUES(remediation_profile),
+  command_template = VALUES(command_template),
+  team_ids = VALUES(team_ids)
+`
+
+	var (
+		profile  []byte
+		template *string
+		teamIDs  []byte
+	)
+	if len(action.RemediationProfile) > 0 {
+		profile = action.RemediationProfile
+	}
+	if action.CommandTemplate != "" {
+		template = &action.CommandTemplate
+	}
+	if len(action.TeamIDs) > 0 {
+		b, err := json.Marshal(action.TeamIDs)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "marshal team ids")
 		}
-		if len(hostUUIDs) > 0 {
-			countArgs++
+		teamIDs = b
+	}
+
+	_, err := ds.writer.ExecContext(ctx, stmt, action.PolicyID, profile, template, teamIDs)
+	return ctxerr.Wrap(ctx, err, "set policy mdm action")
+}
+
+func (ds *Datastore) GetMDMApplePolicyAction(ctx context.Context, policyID uint) (*fleet.MDMApplePolicyAction, error) {
+	var row policyMDMActionRow
+	if err := sqlx.GetContext(ctx, ds.reader, &row, selectMDMApplePolicyActionsStmt+` WHERE policy_id = ?`, policyID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ctxerr.Wrap(ctx, notFound("MDMApplePolicyAction").WithID(policyID))
 		}
-		if countArgs > 1 {
-			return errors.New("only one of hostIDs, teamIDs, profileIDs or hostUUIDs can be provided")
+		return nil, ctxerr.Wrap(ctx, err, "get policy mdm action")
+	}
+	action, err := row.toAction()
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "unmarshal policy mdm action team ids")
+	}
+	return action, nil
+}
+
+func (ds *Datastore) DeleteMDMApplePolicyAction(ctx context.Context, policyID uint) error {
+	res, err := ds.writer.ExecContext(ctx, `DELETE FROM mdm_apple_policy_actions WHERE policy_id = ?`, policyID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "delete policy mdm action")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ctxerr.Wrap(ctx, notFound("MDMApplePolicyAction").WithID(policyID))
+	}
+	return nil
+}
+
+func (ds *Datastore) ListMDMApplePolicyActions(ctx context.Context) ([]*fleet.MDMApplePolicyAction, error) {
+	var rows []*policyMDMActionRow
+	if err := sqlx.SelectContext(ctx, ds.reader, &rows, selectMDMApplePolicyActionsStmt+` ORDER BY policy_id`); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list policy mdm actions")
+	}
+
+	actions := make([]*fleet.MDMApplePolicyAction, 0, len(rows))
+	for _, row := range rows {
+		action, err := row.toAction()
+		if err != nil {
+			return nil, ctxerr.Wrapf(ctx, err, "unmarshal team ids of policy mdm action %d", row.PolicyID)
 		}
-		if countArgs == 0 {
-			return nil
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+func (ds *Datastore) ListMDMApplePolicyActionPolicyIDs(ctx context.Context) ([]uint, error) {
+	var ids []uint
+	if err := sqlx.SelectContext(ctx, ds.reader, &ids, `SELECT policy_id FROM mdm_apple_policy_actions`); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list policy mdm action policy ids")
+	}
+	return ids, nil
+}
+
+func (ds *Datastore) ListMDMApplePolicyActionHosts(ctx context.Context, hostIDs []uint) ([]*fleet.MDMApplePolicyActionHost, error) {
+	if len(hostIDs) == 0 {
+		return nil, nil
+	}
+
+	stmt := `
+SELECT
+  h.id,
+  h.uuid,
+  h.hardware_serial,
+  COALESCE(NULLIF(h.computer_name, ''), h.hostname) AS display_name,
+  h.team_id
+FROM
+  hosts h
+  JOIN nano_enrollments ne ON ne.device_id = h.uuid
+WHERE
+  h.platform = 'darwin' AND
+  ne.enabled = 1 AND
+  ne.type = 'Device' AND
+  h.id IN (?)
+ORDER BY
+  h.id
+`
+	stmt, args, err := sqlx.In(stmt, hostIDs)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "prepare policy mdm action hosts arguments")
+	}
+
+	var hosts []*fleet.MDMApplePolicyActionHost
+	if err := sqlx.SelectContext(ctx, ds.reader, &hosts, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list policy mdm action hosts")
+	}
+	return hosts, nil
+}
+
+func (ds *Datastore) GetMDMAppleProfilesPreview(ctx context.Context, tmID *uint, profiles []*fleet.MDMAppleConfigProfile) (*fleet.MDMAppleProfilesPreview, error) {
+	const enrolledHostsCond = `
+  h.platform = 'darwin' AND
+  ne.enabled = 1 AND
+  ne.type = 'Device' AND
+  %s
+`
+
+	const countEnrolledHosts = `
+SELECT
+  COUNT(*)
+FROM
+  hosts h
+  JOIN nano_enrollments ne ON ne.device_id = h.uuid
+WHERE
+` + enrolledHostsCond
+
+	// a host needs to install profiles unless it already has all the incoming
+	// profiles installed with the same checksum
+	const countInstallHosts = `
+SELECT
+  COUNT(*)
+FROM (
+  SELECT
+    h.uuid
+  FROM
+    hosts h
+    JOIN nano_enrollments ne ON ne.device_id = h.uuid
+    LEFT JOIN host_mdm_apple_profiles hmap ON
+      hmap.host_uuid = h.uuid AND
+      hmap.operation_type = ? AND
+      (hmap.profile_identifier, hmap.checksum) IN (%s)
+  WHERE
+` + enrolledHostsCond + `
+  GROUP BY
+    h.uuid
+  HAVING
+    COUNT(hmap.profile_identifier) < ?
+) t
+`
+
+	// a host needs to remove profiles if it has any profile of the team
+	// installed that is not in the incoming profiles (the profiles delivered by
+	// Fleet are never removed)
+	const countRemoveHosts = `
+SELECT
+  COUNT(DISTINCT h.uuid)
+FROM
+  hosts h
+  JOIN nano_enrollments ne ON ne.device_id = h.uuid
+  JOIN host_mdm_apple_profiles hmap ON hmap.host_uuid = h.uuid
+  JOIN mdm_apple_configuration_profiles macp ON macp.profile_id = hmap.profile_id
+WHERE
+  hmap.operation_type = ? AND
+  macp.team_id = ? AND
+  macp.identifier NOT IN (?) AND
+` + enrolledHostsCond
+
+	// use a profile team id of 0 if no-team
+	var profTeamID uint
+	teamCond := `h.team_id IS NULL`
+	var teamArgs []interface{}
+	if tmID != nil {
+		profTeamID = *tmID
+		teamCond = `h.team_id = ?`
+		teamArgs = append(teamArgs, *tmID)
+	}
+
+	preview := &fleet.MDMAppleProfilesPreview{TeamID: tmID}
+	if err := sqlx.GetContext(ctx, ds.reader, &preview.EnrolledHosts,
+		fmt.Sprintf(countEnrolledHosts, teamCond), teamArgs...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "count enrolled hosts")
+	}
+	if preview.EnrolledHosts == 0 {
+		return preview, nil
+	}
+
+	keepIdents := make([]string, 0, len(profiles))
+	if len(profiles) > 0 {
+		var sb strings.Builder
+		args := []interface{}{fleet.MDMAppleOperationTypeInstall}
+		for i, p := range profiles {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString("(?,?)")
+			args = append(args, p.Identifier, ds.profileChecksum.Sum(p.Mobileconfig))
+			keepIdents = append(keepIdents, p.Identifier)
 		}
+		args = append(args, teamArgs...)
+		args = append(args, len(profiles))
 
-		var (
-			uuids    []string
-			args     []any
-			uuidStmt string
-		)
+		stmt := fmt.Sprintf(countInstallHosts, sb.String(), teamCond)
+		if err := sqlx.GetContext(ctx, ds.reader, &preview.InstallHosts, stmt, args...); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "count hosts to install profiles")
+		}
+	}
 
-		switch {
-		case len(hostUUIDs) > 0:
-			// no need to run a query to load host UUIDs, that's what we received
-			// directly.
-			uuids = hostUUIDs
-
-		case len(hostIDs) > 0:
-			uuidStmt = `SELECT uuid FROM hosts WHERE id IN (?)`
-			args = append(args, hostIDs)
-
-		case len(teamIDs) > 0:
-			uuidStmt = `SELECT uuid FROM hosts WHERE `
-			if len(teamIDs) == 1 && teamIDs[0] == 0 {
-				uuidStmt += `team_id IS NULL`
-			} else {
-				uuidStmt += `team_id IN (?)`
-				args = append(args, teamIDs)
-				for _, tmID := range teamIDs {
-					if tmID == 0 {
-						uuidStmt += ` OR team_id IS NULL`
-						break
-					}
+	for ident := range mobileconfig.FleetPayloadIdentifiers() {
+		keepIdents = append(keepIdents, ident)
+	}
+	stmt, args, err := sqlx.In(fmt.Sprintf(countRemoveHosts, teamCond),
+		append([]interface{}{fleet.MDMAppleOperationTypeInstall, profTeamID, keepIdents}, teamArgs...)...)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "build query to count hosts to remove profiles")
+	}
+	if err := sqlx.GetContext(ctx, ds.reader, &preview.RemoveHosts, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "count hosts to remove profiles")
+	}
+
+	return preview, nil
+}
+
+// mdmAppleProfileNotExcludedCond is the condition that filters out, from the
+// desired state of the hosts' profiles, the profiles that a host is excluded
+// from, either directly, via a label or via an IdP group its assigned user is
+// an active member of. It expects the profiles to be aliased as macp and the
+// hosts as h.
+const mdmAppleProfileNotExcludedCond = `NOT EXISTS (
+  SELECT 1
+  FROM mdm_apple_configuration_profile_exclusions mape
+  LEFT JOIN label_membership lm ON lm.label_id = mape.label_id AND lm.host_id = h.id
+  WHERE
+    mape.team_id = macp.team_id AND
+    mape.profile_identifier = macp.identifier AND
+    (
+      mape.host_id = h.id OR
+      lm.host_id IS NOT NULL OR
+      (
+        mape.idp_group IS NOT NULL AND
+        EXISTS (
+          SELECT 1
+          FROM host_assigned_users hau
+          JOIN scim_users su ON su.user_name = hau.email AND su.active = 1
+          JOIN scim_user_groups sug ON sug.scim_user_id = su.id
+          JOIN scim_groups sg ON sg.id = sug.group_id
+          WHERE hau.host_id = h.id AND sg.display_name = mape.idp_group
+        )
+      )
+    )
+)`
+
+// mdmAppleHostNotPendingApprovalCond is the condition that filters out the
+// hosts whose manual enrollment waits to be approved, those hosts receive no
+// profiles and no commands from Fleet. It expects the hosts to be aliased as
+// h.
+const mdmAppleHostNotPendingApprovalCond = `NOT EXISTS (
+  SELECT 1
+  FROM host_mdm_apple_enrollment_approvals hmaea
+  WHERE hmaea.host_uuid = h.uuid AND hmaea.status = 'pending'
+)`
+
+// mdmAppleProfileDeliveryChecksum is the checksum of the profile delivered to
+// the hosts: the checksum of its contents, combined with the checksum of the
+// versions of the secrets it references, if any, so that the profile is
+// installed again when one of its secrets is rotated. It expects the profiles
+// to be aliased as macp.
+const mdmAppleProfileDeliveryChecksum = `IF(macp.secrets_checksum IS NULL, macp.checksum, UNHEX(MD5(CONCAT(macp.checksum, macp.secrets_checksum))))`
+
+// mdmAppleProfileHostScopeCond is the condition that matches the profiles in
+// the scope of a host: the profiles of the host's team (or no team), the
+// ad-hoc profiles installed on the host and the profiles that apply to all
+// teams, unless the host's team (or no team) opted out of the profile or has
+// its own profile with the same identifier. It expects the profiles to be
+// aliased as macp and the hosts as h.
+var mdmAppleProfileHostScopeCond = fmt.Sprintf(`(
+  h.team_id = macp.team_id OR
+  (h.team_id IS NULL AND macp.team_id = 0) OR
+  (macp.team_id = %d AND macp.host_id = h.id) OR
+  (
+    macp.team_id = %d AND
+    NOT EXISTS (
+      SELECT 1
+      FROM mdm_apple_configuration_profiles macp_tm
+      WHERE macp_tm.team_id = COALESCE(h.team_id, 0) AND macp_tm.identifier = macp.identifier
+    ) AND
+    NOT EXISTS (
+      SELECT 1
+      FROM teams t
+      WHERE
+        t.id = h.team_id AND
+        JSON_CONTAINS(COALESCE(JSON_EXTRACT(t.config, '$.mdm.macos_settings.all_teams_custom_settings_opt_out'), JSON_ARRAY()), JSON_QUOTE(macp.identifier))
+    ) AND
+    NOT EXISTS (
+      SELECT 1
+      FROM app_config_json acj
+      WHERE
+        h.team_id IS NULL AND
+        JSON_CONTAINS(COALESCE(JSON_EXTRACT(acj.json_value, '$.mdm.macos_settings.all_teams_custom_settings_opt_out'), JSON_ARRAY()), JSON_QUOTE(macp.identifier))
+    )
+  )
+)`, fleet.MDMAppleHostProfilesTeamID, fleet.MDMAppleAllTeamsProfilesTeamID)
+
+// mdmAppleProfileInMacOSVersionRangeCond is the condition that filters out,
+// from the desired state of the hosts' profiles, the profiles restricted to a
+// macOS version range that the host's version is outside of. The hosts that
+// didn't report their version are outside of any range. It expects the
+// profiles to be aliased as macp and the hosts as h.
+var mdmAppleProfileInMacOSVersionRangeCond = fmt.Sprintf(`(
+  (macp.min_macos_version = '' OR (h.os_version LIKE 'macOS %%' AND %[1]s >= %[2]s)) AND
+  (macp.max_macos_version = '' OR (h.os_version LIKE 'macOS %%' AND LEFT(%[1]s, %[4]s) <= LEFT(%[3]s, %[4]s)))
+)`,
+	sqlVersionKey("SUBSTRING_INDEX(h.os_version, ' ', -1)"),
+	sqlVersionKey("macp.min_macos_version"),
+	sqlVersionKey("macp.max_macos_version"),
+	// the max version is compared up to its own precision, each component of
+	// the version keys is 5 characters long plus the dot separator.
+	"6 * (1 + LENGTH(macp.max_macos_version) - LENGTH(REPLACE(macp.max_macos_version, '.', ''))) - 1",
+)
+
+func (ds *Datastore) SetMDMAppleConfigProfileMacOSVersions(ctx context.Context, profileID uint, min, max string) error {
+	res, err := ds.writer.ExecContext(ctx,
+		`UPDATE mdm_apple_configuration_profiles SET min_macos_version = ?, max_macos_version = ? WHERE profile_id = ?`, min, max, profileID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "set mdm apple config profile macOS versions")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// the profile may exist with the same versions already, check it
+		if _, err := ds.GetMDMAppleConfigProfile(ctx, profileID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *Datastore) ListMDMAppleBulkSetPendingHostUUIDs(ctx context.Context, hostIDs, teamIDs, profileIDs []uint) ([]string, error) {
+	return listBulkSetPendingHostUUIDsDB(ctx, ds.writer, hostIDs, teamIDs, profileIDs)
+}
+
+func (ds *Datastore) CountMDMAppleBulkSetPendingHosts(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, limit int) (int, error) {
+	uuidStmt, args, err := bulkSetPendingHostUUIDsStmt(hostIDs, teamIDs, profileIDs)
+	if err != nil || uuidStmt == "" {
+		return 0, err
+	}
+
+	// the hosts are only counted up to the limit, to not load all the hosts
+	// of large teams.
+	stmt, args, err := sqlx.In(`SELECT COUNT(*) FROM (`+uuidStmt+` LIMIT ?) t`, append(args, limit)...)
+	if err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "prepare query to count hosts")
+	}
+	var count int
+	if err := sqlx.GetContext(ctx, ds.writer, &count, stmt, args...); err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "execute query to count hosts")
+	}
+	return count, nil
+}
+
+// listBulkSetPendingHostUUIDsDB returns the UUIDs of the hosts targeted by the
+// hostIDs, teamIDs or profileIDs. Only one of the slice arguments can have
+// values.
+func listBulkSetPendingHostUUIDsDB(ctx context.Context, q sqlx.QueryerContext, hostIDs, teamIDs, profileIDs []uint) ([]string, error) {
+	uuidStmt, args, err := bulkSetPendingHostUUIDsStmt(hostIDs, teamIDs, profileIDs)
+	if err != nil || uuidStmt == "" {
+		return nil, err
+	}
+
+	uuidStmt, args, err = sqlx.In(uuidStmt, args...)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "prepare query to load host UUIDs")
+	}
+	var uuids []string
+	if err := sqlx.SelectContext(ctx, q, &uuids, uuidStmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "execute query to load host UUIDs")
+	}
+	return uuids, nil
+}
+
+// bulkSetPendingHostUUIDsStmt returns the statement, with its arguments to
+// expand with sqlx.In, that selects the UUIDs of the hosts targeted by the
+// hostIDs, teamIDs or profileIDs. The statement is empty if no IDs are
+// provided.
+func bulkSetPendingHostUUIDsStmt(hostIDs, teamIDs, profileIDs []uint) (string, []any, error) {
+	var countArgs int
+	if len(hostIDs) > 0 {
+		countArgs++
+	}
+	if len(teamIDs) > 0 {
+		countArgs++
+	}
+	if len(profileIDs) > 0 {
+		countArgs++
+	}
+	if countArgs > 1 {
+		return "", nil, errors.New("only one of hostIDs, teamIDs or profileIDs can be provided")
+	}
+	if countArgs == 0 {
+		return "", nil, nil
+	}
+
+	var (
+		args     []any
+		uuidStmt string
+	)
+
+	switch {
+	case len(hostIDs) > 0:
+		uuidStmt = `SELECT uuid FROM hosts WHERE id IN (?)`
+		args = append(args, hostIDs)
+
+	case len(teamIDs) > 0:
+		uuidStmt = `SELECT uuid FROM hosts WHERE `
+		if slices.Contains(teamIDs, fleet.MDMAppleAllTeamsProfilesTeamID) {
+			// the profiles that apply to all teams target every host
+			uuidStmt += `TRUE`
+		} else if len(teamIDs) == 1 && teamIDs[0] == 0 {
+			uuidStmt += `team_id IS NULL`
+		} else {
+			uuidStmt += `team_id IN (?)`
+			args = append(args, teamIDs)
+			for _, tmID := range teamIDs {
+				if tmID == 0 {
+					uuidStmt += ` OR team_id IS NULL`
+					break
 				}
 			}
+		}
 
-		case len(profileIDs) > 0:
-			uuidStmt = `
+	case len(profileIDs) > 0:
+		uuidStmt = `
 SELECT DISTINCT h.uuid
 FROM hosts h
 JOIN mdm_apple_configuration_profiles macp
-	ON h.team_id = macp.team_id OR (h.team_id IS NULL AND macp.team_id = 0)
+	ON ` + mdmAppleProfileHostScopeCond + `
 WHERE
 	macp.profile_id IN (?)`
-			args = append(args, profileIDs)
-		}
+		args = append(args, profileIDs)
+	}
+	return uuidStmt, args, nil
+}
 
-		if len(uuids) == 0 {
-			uuidStmt, args, err := sqlx.In(uuidStmt, args...)
-			if err != nil {
-				return ctxerr.Wrap(ctx, err, "prepare query to load host UUIDs")
+// Note that team ID 0 is used for profiles that apply to hosts in no team
+// (i.e. pass 0 in that case as part of the teamIDs slice). Only one of the
+// slice arguments can have values.
+func (ds *Datastore) BulkSetPendingMDMAppleHostProfiles(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, hostUUIDs []string) error {
+	return ds.withTx(ctx, func(tx sqlx.ExtContext) error {
+		uuids := hostUUIDs
+		if len(hostUUIDs) > 0 {
+			// no need to run a query to load host UUIDs, that's what we received
+			// directly.
+			if len(hostIDs) > 0 || len(teamIDs) > 0 || len(profileIDs) > 0 {
+				return errors.New("only one of hostIDs, teamIDs, profileIDs or hostUUIDs can be provided")
 			}
-			if err := sqlx.SelectContext(ctx, tx, &uuids, uuidStmt, args...); err != nil {
-				return ctxerr.Wrap(ctx, err, "execute query to load host UUIDs")
+		} else {
+			var err error
+			uuids, err = listBulkSetPendingHostUUIDsDB(ctx, tx, hostIDs, teamIDs, profileIDs)
+			if err != nil {
+				return err
 			}
 		}
 
@@ -1090,7 +2237,7 @@ WHERE
 			return nil
 		}
 
-		const profilesToInstallStmt = `
+		profilesToInstallStmt := `
 		SELECT
 			ds.profile_id as profile_id,
 			ds.host_uuid as host_uuid,
@@ -1103,11 +2250,14 @@ WHERE
 				h.uuid as host_uuid,
 				macp.identifier as profile_identifier,
 				macp.name as profile_name,
-				macp.checksum as checksum
+				` + mdmAppleProfileDeliveryChecksum + ` as checksum
 			FROM mdm_apple_configuration_profiles macp
-				JOIN hosts h ON h.team_id = macp.team_id OR (h.team_id IS NULL AND macp.team_id = 0)
+				JOIN hosts h ON ` + mdmAppleProfileHostScopeCond + `
 				JOIN nano_enrollments ne ON ne.device_id = h.uuid
-			WHERE h.platform = 'darwin' AND ne.enabled = 1 AND ne.type = 'Device' AND h.uuid IN (?)
+			WHERE h.platform = 'darwin' AND ne.enabled = 1 AND ne.type = 'Device' AND h.uuid IN (?) AND
+				` + mdmAppleProfileNotExcludedCond + ` AND
+				` + mdmAppleProfileInTargetCond + ` AND
+				` + mdmAppleProfileInMacOSVersionRangeCond + `
 		) as ds
 		LEFT JOIN host_mdm_apple_profiles hmap
 			ON hmap.profile_id = ds.profile_id AND hmap.host_uuid = ds.host_uuid
@@ -1152,9 +2302,12 @@ WHERE
 			SELECT
 				h.uuid, macp.profile_id
 			FROM mdm_apple_configuration_profiles macp
-				JOIN hosts h ON h.team_id = macp.team_id OR (h.team_id IS NULL AND macp.team_id = 0)
+				JOIN hosts h ON ` + mdmAppleProfileHostScopeCond + `
 				JOIN nano_enrollments ne ON ne.device_id = h.uuid
-			WHERE h.platform = 'darwin' AND ne.enabled = 1 AND ne.type = 'Device' AND h.uuid IN (?)
+			WHERE h.platform = 'darwin' AND ne.enabled = 1 AND ne.type = 'Device' AND h.uuid IN (?) AND
+				` + mdmAppleProfileNotExcludedCond + ` AND
+				` + mdmAppleProfileInTargetCond + ` AND
+				` + mdmAppleProfileInMacOSVersionRangeCond + `
 		) as ds
 		RIGHT JOIN host_mdm_apple_profiles hmap
 			ON hmap.profile_id = ds.profile_id AND hmap.host_uuid = ds.uuid
@@ -1281,11 +2434,15 @@ func (ds *Datastore) ListMDMAppleProfilesToInstall(ctx context.Context) ([]*flee
               h.uuid as host_uuid,
               macp.identifier as profile_identifier,
               macp.name as profile_name,
-	      macp.checksum as checksum
+	      ` + mdmAppleProfileDeliveryChecksum + ` as checksum
             FROM mdm_apple_configuration_profiles macp
-            JOIN hosts h ON h.team_id = macp.team_id OR (h.team_id IS NULL AND macp.team_id = 0)
+            JOIN hosts h ON ` + mdmAppleProfileHostScopeCond + `
             JOIN nano_enrollments ne ON ne.device_id = h.uuid
-            WHERE h.platform = 'darwin' AND ne.enabled = 1 AND ne.type = 'Device'
+            WHERE h.platform = 'darwin' AND ne.enabled = 1 AND ne.type = 'Device' AND
+              ` + mdmAppleProfileNotExcludedCond + ` AND
+              ` + mdmAppleProfileInTargetCond + ` AND
+              ` + mdmAppleProfileInMacOSVersionRangeCond + ` AND
+              ` + mdmAppleHostNotPendingApprovalCond + `
           ) as ds
           LEFT JOIN host_mdm_apple_profiles hmap
             ON hmap.profile_id = ds.profile_id AND hmap.host_uuid = ds.host_uuid
@@ -1300,8 +2457,10 @@ func (ds *Datastore) ListMDMAppleProfilesToInstall(ctx context.Context) ([]*flee
           ( hmap.host_uuid IS NOT NULL AND hmap.operation_type = ? AND hmap.status IS NULL )
 `
 
+	// the reconciliation requires the primary to see the profiles queued for
+	// a retry earlier in the same run.
 	var profiles []*fleet.MDMAppleProfilePayload
-	err := sqlx.SelectContext(ctx, ds.reader, &profiles, query, fleet.MDMAppleOperationTypeRemove, fleet.MDMAppleOperationTypeInstall)
+	err := sqlx.SelectContext(ctx, ds.readerFor(ctx), &profiles, query, fleet.MDMAppleOperationTypeRemove, fleet.MDMAppleOperationTypeInstall)
 	return profiles, err
 }
 
@@ -1327,9 +2486,12 @@ func (ds *Datastore) ListMDMAppleProfilesToRemove(ctx context.Context) ([]*fleet
           FROM (
             SELECT h.uuid, macp.profile_id
             FROM mdm_apple_configuration_profiles macp
-            JOIN hosts h ON h.team_id = macp.team_id OR (h.team_id IS NULL AND macp.team_id = 0)
+            JOIN hosts h ON ` + mdmAppleProfileHostScopeCond + `
             JOIN nano_enrollments ne ON ne.device_id = h.uuid
-            WHERE h.platform = 'darwin' AND ne.enabled = 1 AND ne.type = 'Device'
+            WHERE h.platform = 'darwin' AND ne.enabled = 1 AND ne.type = 'Device' AND
+              ` + mdmAppleProfileNotExcludedCond + ` AND
+              ` + mdmAppleProfileInTargetCond + ` AND
+              ` + mdmAppleProfileInMacOSVersionRangeCond + `
           ) as ds
           RIGHT JOIN host_mdm_apple_profiles hmap
             ON hmap.profile_id = ds.profile_id AND hmap.host_uuid = ds.uuid
@@ -1340,25 +2502,190 @@ func (ds *Datastore) ListMDMAppleProfilesToRemove(ctx context.Context) ([]*fleet
 `
 
 	var profiles []*fleet.MDMAppleProfilePayload
-	err := sqlx.SelectContext(ctx, ds.reader, &profiles, query, fleet.MDMAppleOperationTypeRemove)
+	err := sqlx.SelectContext(ctx, ds.readerFor(ctx), &profiles, query, fleet.MDMAppleOperationTypeRemove)
 	return profiles, err
 }
 
-func (ds *Datastore) GetMDMAppleProfilesContents(ctx context.Context, ids []uint) (map[uint]mobileconfig.Mobileconfig, error) {
-	if len(ids) == 0 {
-		return nil, nil
+func (ds *Datastore) ListMDMAppleReconciliationPlan(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleReconciliationPlanItem, error) {
+	// The queries below are the same set differences as the ones of
+	// ListMDMAppleProfilesToInstall and ListMDMAppleProfilesToRemove,
+	// restricted to the hosts of the team, with the reason of each operation
+	// derived from the current state of the host's profile.
+	//
+	// A host changed teams if it has profiles of another team than its own
+	// (besides the profiles that apply to all teams and its ad-hoc profiles).
+	installStmt := `
+          SELECT
+            ds.host_id, ds.host_uuid, ds.hostname, ds.profile_id, ds.profile_identifier, ds.profile_name, ds.checksum,
+            ? AS operation_type,
+            CASE
+              WHEN hmap.host_uuid IS NULL AND EXISTS (
+                SELECT 1
+                FROM host_mdm_apple_profiles hmap_prev
+                JOIN mdm_apple_configuration_profiles macp_prev ON macp_prev.profile_id = hmap_prev.profile_id
+                WHERE hmap_prev.host_uuid = ds.host_uuid AND macp_prev.team_id NOT IN (?, ?, ?)
+              ) THEN ?
+              WHEN hmap.host_uuid IS NULL THEN ?
+              WHEN hmap.checksum != ds.checksum THEN ?
+              WHEN hmap.operation_type IS NULL OR hmap.operation_type = ? THEN ?
+              WHEN hmap.failure_count > 0 OR hmap.retry_count > 0 THEN ?
+              ELSE ?
+            END AS reason
+          FROM (
+            SELECT
+              h.id as host_id,
+              h.uuid as host_uuid,
+              h.hostname,
+              macp.profile_id,
+              macp.identifier as profile_identifier,
+              macp.name as profile_name,
+              ` + mdmAppleProfileDeliveryChecksum + ` as checksum
+            FROM mdm_apple_configuration_profiles macp
+            JOIN hosts h ON ` + mdmAppleProfileHostScopeCond + `
+            JOIN nano_enrollments ne ON ne.device_id = h.uuid
+            WHERE h.platform = 'darwin' AND ne.enabled = 1 AND ne.type = 'Device' AND
+              COALESCE(h.team_id, 0) = ? AND
+              ` + mdmAppleProfileNotExcludedCond + ` AND
+              ` + mdmAppleProfileInTargetCond + ` AND
+              ` + mdmAppleProfileInMacOSVersionRangeCond + ` AND
+              ` + mdmAppleHostNotPendingApprovalCond + `
+          ) as ds
+          LEFT JOIN host_mdm_apple_profiles hmap
+            ON hmap.profile_id = ds.profile_id AND hmap.host_uuid = ds.host_uuid
+          WHERE
+            ( hmap.checksum != ds.checksum ) OR
+            ( hmap.profile_id IS NULL AND hmap.host_uuid IS NULL ) OR
+            ( hmap.host_uuid IS NOT NULL AND ( hmap.operation_type = ? OR hmap.operation_type IS NULL ) ) OR
+            ( hmap.host_uuid IS NOT NULL AND hmap.operation_type = ? AND hmap.status IS NULL )
+          ORDER BY
+            ds.hostname, ds.host_uuid, ds.profile_identifier
+`
+
+	removeStmt := `
+          SELECT
+            hh.id AS host_id, hmap.host_uuid, hh.hostname, hmap.profile_id, hmap.profile_identifier, hmap.profile_name, hmap.checksum,
+            ? AS operation_type,
+            CASE
+              WHEN mp.profile_id IS NULL THEN ?
+              WHEN mp.team_id NOT IN (COALESCE(hh.team_id, 0), ?, ?) THEN ?
+              ELSE ?
+            END AS reason
+          FROM host_mdm_apple_profiles hmap
+          JOIN hosts hh ON hh.uuid = hmap.host_uuid
+          LEFT JOIN mdm_apple_configuration_profiles mp ON mp.profile_id = hmap.profile_id
+          LEFT JOIN (
+            SELECT h.uuid, macp.profile_id
+            FROM mdm_apple_configuration_profiles macp
+            JOIN hosts h ON ` + mdmAppleProfileHostScopeCond + `
+            JOIN nano_enrollments ne ON ne.device_id = h.uuid
+            WHERE h.platform = 'darwin' AND ne.enabled = 1 AND ne.type = 'Device' AND
+              COALESCE(h.team_id, 0) = ? AND
+              ` + mdmAppleProfileNotExcludedCond + ` AND
+              ` + mdmAppleProfileInTargetCond + ` AND
+              ` + mdmAppleProfileInMacOSVersionRangeCond + `
+          ) as ds
+            ON hmap.profile_id = ds.profile_id AND hmap.host_uuid = ds.uuid
+          WHERE
+            COALESCE(hh.team_id, 0) = ? AND
+            ds.profile_id IS NULL AND ds.uuid IS NULL AND
+            ( hmap.operation_type IS NULL OR hmap.operation_type != ? OR hmap.status IS NULL )
+          ORDER BY
+            hh.hostname, hmap.host_uuid, hmap.profile_identifier
+`
+
+	var tmID uint
+	if teamID != nil {
+		tmID = *teamID
 	}
 
-	stmt := `
-          SELECT profile_id, mobileconfig as mobileconfig
-          FROM mdm_apple_configuration_profiles WHERE profile_id IN (?)
-	`
-	query, args, err := sqlx.In(stmt, ids)
-	if err != nil {
-		return nil, err
+	var toInstall []*fleet.MDMAppleReconciliationPlanItem
+	if err := sqlx.SelectContext(ctx, ds.reader, &toInstall, installStmt,
+		fleet.MDMAppleOperationTypeInstall,
+		tmID, fleet.MDMAppleAllTeamsProfilesTeamID, fleet.MDMAppleHostProfilesTeamID,
+		fleet.MDMAppleReconciliationReasonTeamChange,
+		fleet.MDMAppleReconciliationReasonNewProfile,
+		fleet.MDMAppleReconciliationReasonProfileUpdated,
+		fleet.MDMAppleOperationTypeRemove, fleet.MDMAppleReconciliationReasonReinstall,
+		fleet.MDMAppleReconciliationReasonFailedRetry,
+		fleet.MDMAppleReconciliationReasonResend,
+		tmID,
+		fleet.MDMAppleOperationTypeRemove, fleet.MDMAppleOperationTypeInstall,
+	); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list profiles to install of reconciliation plan")
 	}
-	rows, err := ds.reader.QueryxContext(ctx, query, args...)
-	if err != nil {
+
+	var toRemove []*fleet.MDMAppleReconciliationPlanItem
+	if err := sqlx.SelectContext(ctx, ds.reader, &toRemove, removeStmt,
+		fleet.MDMAppleOperationTypeRemove,
+		fleet.MDMAppleReconciliationReasonProfileDeleted,
+		fleet.MDMAppleAllTeamsProfilesTeamID, fleet.MDMAppleHostProfilesTeamID, fleet.MDMAppleReconciliationReasonTeamChange,
+		fleet.MDMAppleReconciliationReasonOutOfScope,
+		tmID,
+		tmID,
+		fleet.MDMAppleOperationTypeRemove,
+	); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list profiles to remove of reconciliation plan")
+	}
+
+	return append(toInstall, toRemove...), nil
+}
+
+func (ds *Datastore) ListMDMAppleHostProfilesWithQueuedCommand(ctx context.Context, opType fleet.MDMAppleOperationType, hostUUIDs []string) ([]*fleet.MDMAppleProfilePayload, error) {
+	if len(hostUUIDs) == 0 {
+		return nil, nil
+	}
+
+	// a command is still queued if it is active and the host didn't report a
+	// result for it, or only reported it was busy (NotNow), in which case it
+	// will be delivered again.
+	const stmt = `
+          SELECT hmap.profile_id, hmap.profile_identifier, hmap.profile_name, hmap.host_uuid, hmap.checksum, hmap.command_uuid
+          FROM host_mdm_apple_profiles hmap
+          JOIN nano_enrollment_queue neq
+            ON neq.id = hmap.host_uuid AND neq.command_uuid = hmap.command_uuid
+          LEFT JOIN nano_command_results ncr
+            ON ncr.id = neq.id AND ncr.command_uuid = neq.command_uuid
+          WHERE
+            hmap.host_uuid IN (?) AND
+            hmap.operation_type = ? AND
+            neq.active = 1 AND
+            ( ncr.status IS NULL OR ncr.status = 'NotNow' )`
+
+	const batchSize = 10000
+	var profiles []*fleet.MDMAppleProfilePayload
+	for i := 0; i < len(hostUUIDs); i += batchSize {
+		end := i + batchSize
+		if end > len(hostUUIDs) {
+			end = len(hostUUIDs)
+		}
+		query, args, err := sqlx.In(stmt, hostUUIDs[i:end], opType)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "building query to list profiles with queued command")
+		}
+		var batch []*fleet.MDMAppleProfilePayload
+		if err := sqlx.SelectContext(ctx, ds.reader, &batch, query, args...); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "list profiles with queued command")
+		}
+		profiles = append(profiles, batch...)
+	}
+	return profiles, nil
+}
+
+func (ds *Datastore) GetMDMAppleProfilesContents(ctx context.Context, ids []uint) (map[uint]mobileconfig.Mobileconfig, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	stmt := `
+          SELECT profile_id, mobileconfig as mobileconfig
+          FROM mdm_apple_configuration_profiles WHERE profile_id IN (?)
+	`
+	query, args, err := sqlx.In(stmt, ids)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := ds.reader.QueryxContext(ctx, query, args...)
+	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
@@ -1374,6 +2701,37 @@ func (ds *Datastore) GetMDMAppleProfilesContents(ctx context.Context, ids []uint
 	return results, nil
 }
 
+func (ds *Datastore) ListMDMAppleProfilesReferencingSecrets(ctx context.Context) (map[uint]mobileconfig.Mobileconfig, error) {
+	// the contents are matched roughly, the callers extract the secret
+	// variables from the contents.
+	const stmt = `
+          SELECT profile_id, mobileconfig
+          FROM mdm_apple_configuration_profiles WHERE mobileconfig LIKE '%FLEET\_SECRET\_%'
+	`
+	rows, err := ds.reader.QueryxContext(ctx, stmt)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list profiles referencing secrets")
+	}
+	defer rows.Close()
+	results := make(map[uint]mobileconfig.Mobileconfig)
+	for rows.Next() {
+		var id uint
+		var mobileconfig mobileconfig.Mobileconfig
+		if err := rows.Scan(&id, &mobileconfig); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "scan profile referencing secrets")
+		}
+		results[id] = mobileconfig
+	}
+	return results, ctxerr.Wrap(ctx, rows.Err(), "iterate profiles referencing secrets")
+}
+
+func (ds *Datastore) SetMDMAppleProfileSecretsChecksum(ctx context.Context, profileID uint, checksum []byte) error {
+	const stmt = `UPDATE mdm_apple_configuration_profiles SET secrets_checksum = ? WHERE profile_id = ?`
+
+	_, err := ds.writer.ExecContext(ctx, stmt, checksum, profileID)
+	return ctxerr.Wrap(ctx, err, "set profile secrets checksum")
+}
+
 func (ds *Datastore) BulkUpsertMDMAppleHostProfiles(ctx context.Context, payload []*fleet.MDMAppleBulkUpsertHostProfilePayload) error {
 	if len(payload) == 0 {
 		return nil
@@ -1381,10 +2739,23 @@ func (ds *Datastore) BulkUpsertMDMAppleHostProfiles(ctx context.Context, payload
 
 	var args []any
 	var sb strings.Builder
+	changes := make([]hostMDMChange, 0, len(payload))
 
 	for _, p := range payload {
-		args = append(args, p.ProfileID, p.ProfileIdentifier, p.ProfileName, p.HostUUID, p.Status, p.OperationType, p.CommandUUID, p.Checksum)
-		sb.WriteString("(?, ?, ?, ?, ?, ?, ?, ?),")
+		args = append(args, p.ProfileID, p.ProfileIdentifier, p.ProfileName, p.HostUUID, p.Status, p.OperationType, p.CommandUUID, p.Checksum, p.Detail)
+		sb.WriteString("(?, ?, ?, ?, ?, ?, ?, ?, ?),")
+
+		// each payload is a new command for the profile, i.e. a status change.
+		change := fleet.HostMDMProfileStatusChange{
+			ProfileIdentifier: p.ProfileIdentifier,
+			ProfileName:       p.ProfileName,
+			OperationType:     p.OperationType,
+			Status:            p.Status,
+		}
+		if p.Detail != nil {
+			change.Detail = *p.Detail
+		}
+		changes = append(changes, hostMDMChange{HostUUID: p.HostUUID, Type: fleet.HostMDMChangeProfileStatus, Details: change})
 	}
 
 	stmt := fmt.Sprintf(`
@@ -1396,36 +2767,230 @@ func (ds *Datastore) BulkUpsertMDMAppleHostProfiles(ctx context.Context, payload
               status,
               operation_type,
               command_uuid,
-	      checksum
+	      checksum,
+              detail
             )
             VALUES %s
 	    ON DUPLICATE KEY UPDATE
               status = VALUES(status),
               operation_type = VALUES(operation_type),
-              command_uuid = VALUES(command_uuid)`,
+              command_uuid = VALUES(command_uuid),
+              detail = COALESCE(VALUES(detail), detail)`,
 		strings.TrimSuffix(sb.String(), ","),
 	)
 
-	_, err := ds.writer.ExecContext(ctx, stmt, args...)
-	return err
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return ctxerr.Wrap(ctx, err, "bulk upsert host profiles")
+		}
+		return recordHostMDMChangesDB(ctx, tx, changes)
+	})
+}
+
+// recordHostMDMAppleProfileStatusChangeDB records the change of status of the
+// host profile of the command, if its status changes.
+func recordHostMDMAppleProfileStatusChangeDB(ctx context.Context, tx sqlx.ExtContext, profile *fleet.HostMDMAppleProfile, status *fleet.MDMAppleDeliveryStatus) error {
+	var current struct {
+		Identifier string                        `db:"profile_identifier"`
+		Name       string                        `db:"profile_name"`
+		Status     *fleet.MDMAppleDeliveryStatus `db:"status"`
+	}
+	if err := sqlx.GetContext(ctx, tx, &current, `
+          SELECT profile_identifier, profile_name, status
+          FROM host_mdm_apple_profiles
+          WHERE host_uuid = ? AND command_uuid = ?
+          FOR UPDATE`, profile.HostUUID, profile.CommandUUID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return ctxerr.Wrap(ctx, err, "select host profile status")
+	}
+	if (current.Status == nil && status == nil) || (current.Status != nil && status != nil && *current.Status == *status) {
+		return nil
+	}
+	return recordHostMDMChangesDB(ctx, tx, []hostMDMChange{{
+		HostUUID: profile.HostUUID,
+		Type:     fleet.HostMDMChangeProfileStatus,
+		Details: fleet.HostMDMProfileStatusChange{
+			ProfileIdentifier: current.Identifier,
+			ProfileName:       current.Name,
+			OperationType:     profile.OperationType,
+			Status:            status,
+			Detail:            profile.Detail,
+		},
+	}})
 }
 
 func (ds *Datastore) UpdateOrDeleteHostMDMAppleProfile(ctx context.Context, profile *fleet.HostMDMAppleProfile) error {
-	if profile.OperationType == fleet.MDMAppleOperationTypeRemove &&
-		profile.Status != nil && (*profile.Status == fleet.MDMAppleDeliveryVerifying || profile.IgnoreMDMClientError()) {
-		_, err := ds.writer.ExecContext(ctx, `
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		if err := recordHostMDMAppleProfileStatusChangeDB(ctx, tx, profile, profile.Status); err != nil {
+			return err
+		}
+
+		if profile.OperationType == fleet.MDMAppleOperationTypeRemove &&
+			profile.Status != nil && (*profile.Status == fleet.MDMAppleDeliveryVerifying || profile.IgnoreMDMClientError()) {
+			_, err := tx.ExecContext(ctx, `
           DELETE FROM host_mdm_apple_profiles
           WHERE host_uuid = ? AND command_uuid = ?
         `, profile.HostUUID, profile.CommandUUID)
-		return err
-	}
+			return err
+		}
 
-	_, err := ds.writer.ExecContext(ctx, `
+		// the consecutive retryable failures are reset unless the command is still
+		// in flight (e.g. the device answered NotNow), and the retries of the
+		// retry policy are reset once the profile is applied. A profile that
+		// fails again gets its next retry scheduled by the profile manager cron.
+		resetFailures := profile.Status == nil || *profile.Status != fleet.MDMAppleDeliveryPending
+		resetRetries := profile.Status != nil && *profile.Status == fleet.MDMAppleDeliveryVerifying
+		_, err := tx.ExecContext(ctx, `
           UPDATE host_mdm_apple_profiles
-          SET status = ?, operation_type = ?, detail = ?
+          SET status = ?, operation_type = ?, detail = ?,
+            failure_count = IF(?, 0, failure_count),
+            first_failed_at = IF(?, NULL, first_failed_at),
+            retry_count = IF(?, 0, retry_count),
+            retry_at = NULL
           WHERE host_uuid = ? AND command_uuid = ?
-        `, profile.Status, profile.OperationType, profile.Detail, profile.HostUUID, profile.CommandUUID)
-	return err
+        `, profile.Status, profile.OperationType, profile.Detail, resetFailures, resetFailures, resetRetries, profile.HostUUID, profile.CommandUUID)
+		return err
+	})
+}
+
+func (ds *Datastore) UpdateHostMDMAppleProfileRetryableFailure(ctx context.Context, profile *fleet.HostMDMAppleProfile, gracePeriod fleet.MacOSProfileFailureGracePeriod) (bool, error) {
+	var failed bool
+	err := ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		failed = false
+
+		var state struct {
+			FailureCount  int        `db:"failure_count"`
+			FirstFailedAt *time.Time `db:"first_failed_at"`
+		}
+		if err := sqlx.GetContext(ctx, tx, &state, `
+          SELECT failure_count, first_failed_at
+          FROM host_mdm_apple_profiles
+          WHERE host_uuid = ? AND command_uuid = ?
+          FOR UPDATE`, profile.HostUUID, profile.CommandUUID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				// the profile is not tracked for the host anymore, nothing to do
+				return nil
+			}
+			return ctxerr.Wrap(ctx, err, "select host profile failures")
+		}
+
+		now := time.Now().UTC()
+		firstFailedAt := now
+		if state.FirstFailedAt != nil {
+			firstFailedAt = *state.FirstFailedAt
+		}
+		failureCount := state.FailureCount + 1
+
+		// a NULL status queues the profile to be applied again by the profile
+		// manager cron.
+		var status *fleet.MDMAppleDeliveryStatus
+		failedAt := &firstFailedAt
+		if gracePeriod.ShouldFail(failureCount, firstFailedAt, now) {
+			failed = true
+			status = &fleet.MDMAppleDeliveryFailed
+			failureCount, failedAt = 0, nil
+		}
+
+		if failed {
+			if err := recordHostMDMAppleProfileStatusChangeDB(ctx, tx, profile, status); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, `
+          UPDATE host_mdm_apple_profiles
+          SET status = ?, operation_type = ?, detail = ?, failure_count = ?, first_failed_at = ?, retry_at = NULL
+          WHERE host_uuid = ? AND command_uuid = ?`,
+			status, profile.OperationType, profile.Detail, failureCount, failedAt, profile.HostUUID, profile.CommandUUID)
+		return ctxerr.Wrap(ctx, err, "update host profile failures")
+	})
+	return failed, err
+}
+
+func (ds *Datastore) ListTeamsMacOSProfileRetryPolicies(ctx context.Context) (map[uint]fleet.MacOSProfileRetryPolicy, error) {
+	var rows []struct {
+		TeamID uint             `db:"id"`
+		Policy *json.RawMessage `db:"policy"`
+	}
+	if err := sqlx.SelectContext(ctx, ds.reader, &rows, `
+          SELECT id, config->'$.mdm.macos_settings.profile_retry_policy' AS policy
+          FROM teams`); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "select teams profile retry policies")
+	}
+
+	policies := make(map[uint]fleet.MacOSProfileRetryPolicy, len(rows))
+	for _, r := range rows {
+		var policy fleet.MacOSProfileRetryPolicy
+		if r.Policy != nil {
+			if err := json.Unmarshal(*r.Policy, &policy); err != nil {
+				return nil, ctxerr.Wrapf(ctx, err, "unmarshal profile retry policy of team %d", r.TeamID)
+			}
+		}
+		policies[r.TeamID] = policy
+	}
+	return policies, nil
+}
+
+func (ds *Datastore) RetryFailedHostMDMAppleProfiles(ctx context.Context, teamID uint, policy fleet.MacOSProfileRetryPolicy) (int64, error) {
+	if !policy.Enabled() {
+		return 0, nil
+	}
+	initial, max := policy.Backoffs()
+
+	var retried int64
+	err := ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		// a NULL status queues the profile to be installed again by the profile
+		// manager cron.
+		res, err := tx.ExecContext(ctx, `
+          UPDATE host_mdm_apple_profiles hmap
+          JOIN hosts h ON h.uuid = hmap.host_uuid
+          SET hmap.status = NULL, hmap.detail = '', hmap.retry_count = hmap.retry_count + 1, hmap.retry_at = NULL
+          WHERE COALESCE(h.team_id, 0) = ? AND hmap.status = ? AND hmap.operation_type = ? AND
+            hmap.retry_at IS NOT NULL AND hmap.retry_at <= NOW()`,
+			teamID, fleet.MDMAppleDeliveryFailed, fleet.MDMAppleOperationTypeInstall)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "queue due host profile retries")
+		}
+		retried, _ = res.RowsAffected()
+
+		// the delay before the retry doubles with each retry, the exponent is
+		// capped to keep the computation in range whatever the maximum.
+		_, err = tx.ExecContext(ctx, `
+          UPDATE host_mdm_apple_profiles hmap
+          JOIN hosts h ON h.uuid = hmap.host_uuid
+          SET hmap.retry_at = DATE_ADD(NOW(), INTERVAL LEAST(?, ? * POW(2, LEAST(hmap.retry_count, 30))) SECOND)
+          WHERE COALESCE(h.team_id, 0) = ? AND hmap.status = ? AND hmap.operation_type = ? AND
+            hmap.retry_at IS NULL AND hmap.retry_count < ?`,
+			int64(max.Seconds()), int64(initial.Seconds()),
+			teamID, fleet.MDMAppleDeliveryFailed, fleet.MDMAppleOperationTypeInstall, policy.MaxRetries)
+		return ctxerr.Wrap(ctx, err, "schedule host profile retries")
+	})
+	return retried, err
+}
+
+func (ds *Datastore) ResetFailedHostMDMAppleProfile(ctx context.Context, profileID uint, hostUUID string, teamID uint) (int64, error) {
+	stmt := `
+          UPDATE host_mdm_apple_profiles hmap
+          JOIN hosts h ON h.uuid = hmap.host_uuid
+          SET hmap.status = NULL, hmap.detail = '', hmap.retry_count = 0, hmap.retry_at = NULL
+          WHERE hmap.profile_id = ? AND hmap.status = ? AND hmap.operation_type = ? AND `
+	args := []interface{}{profileID, fleet.MDMAppleDeliveryFailed, fleet.MDMAppleOperationTypeInstall}
+	if hostUUID != "" {
+		stmt += `h.uuid = ?`
+		args = append(args, hostUUID)
+	} else {
+		stmt += `COALESCE(h.team_id, 0) = ?`
+		args = append(args, teamID)
+	}
+
+	res, err := ds.writer.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "reset failed host profiles")
+	}
+	reset, _ := res.RowsAffected()
+	return reset, nil
 }
 
 func subqueryHostsMacOSSettingsStatusFailing() (string, []interface{}) {
@@ -1523,24 +3088,25 @@ func (ds *Datastore) GetMDMAppleHostsProfilesSummary(ctx context.Context, teamID
 	subqueryVerifying, subqueryVeryingingArgs := subqueryHostsMacOSSetttingsStatusVerifying()
 	args = append(args, subqueryVeryingingArgs...)
 
+	// the statuses are mutually exclusive, so each host's status is resolved
+	// once in the derived table (stopping at the first matching subquery)
+	// instead of evaluating every subquery for every host.
 	sqlFmt := `
 SELECT
-    COUNT(
-        CASE WHEN EXISTS (%s)
-            THEN 1
-        END) AS failed,
-    COUNT(
-        CASE WHEN EXISTS (%s)
-            THEN 1
-        END) AS pending,
-    COUNT(
-        CASE WHEN EXISTS (%s)
-            THEN 1
-        END) AS verifying
-FROM
-    hosts h
-WHERE
-    %s`
+    COUNT(CASE WHEN hs.status = 'failed' THEN 1 END) AS failed,
+    COUNT(CASE WHEN hs.status = 'pending' THEN 1 END) AS pending,
+    COUNT(CASE WHEN hs.status = 'verifying' THEN 1 END) AS verifying
+FROM (
+    SELECT
+        CASE
+            WHEN EXISTS (%s) THEN 'failed'
+            WHEN EXISTS (%s) THEN 'pending'
+            WHEN EXISTS (%s) THEN 'verifying'
+        END AS status
+    FROM
+        hosts h
+    WHERE
+        %s) hs`
 
 	teamFilter := "h.team_id IS NULL"
 	if teamID != nil && *teamID > 0 {
@@ -1559,98 +3125,1101 @@ WHERE
 	return &res, nil
 }
 
+func (ds *Datastore) ListMDMAppleHostsProfilesSummaryByTeam(ctx context.Context) ([]*fleet.MDMAppleConfigProfilesTeamSummary, error) {
+	var args []interface{}
+	subqueryFailed, subqueryFailedArgs := subqueryHostsMacOSSettingsStatusFailing()
+	args = append(args, subqueryFailedArgs...)
+	subqueryPending, subqueryPendingArgs := subqueryHostsMacOSSettingsStatusPending()
+	args = append(args, subqueryPendingArgs...)
+	subqueryVerifying, subqueryVeryingingArgs := subqueryHostsMacOSSetttingsStatusVerifying()
+	args = append(args, subqueryVeryingingArgs...)
+
+	// same as GetMDMAppleHostsProfilesSummary, but grouped by team so that
+	// all teams are summarized in a single query.
+	sqlFmt := `
+SELECT
+    hs.team_id,
+    COALESCE(t.name, '') AS team_name,
+    COUNT(CASE WHEN hs.status = 'failed' THEN 1 END) AS failed,
+    COUNT(CASE WHEN hs.status = 'pending' THEN 1 END) AS pending,
+    COUNT(CASE WHEN hs.status = 'verifying' THEN 1 END) AS verifying
+FROM (
+    SELECT
+        h.team_id,
+        CASE
+            WHEN EXISTS (%s) THEN 'failed'
+            WHEN EXISTS (%s) THEN 'pending'
+            WHEN EXISTS (%s) THEN 'verifying'
+        END AS status
+    FROM
+        hosts h) hs
+    LEFT JOIN teams t ON t.id = hs.team_id
+GROUP BY
+    hs.team_id, t.name
+ORDER BY
+    hs.team_id IS NOT NULL, t.name`
+
+	stmt := fmt.Sprintf(sqlFmt, subqueryFailed, subqueryPending, subqueryVerifying)
+
+	var res []*fleet.MDMAppleConfigProfilesTeamSummary
+	if err := sqlx.SelectContext(ctx, ds.reader, &res, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list hosts profiles summary by team")
+	}
+	return res, nil
+}
+
+func (ds *Datastore) ListMDMAppleReservedPayloadConflicts(ctx context.Context, teamID *uint) ([]fleet.MDMAppleReservedPayloadConflict, error) {
+	stmt := `
+SELECT
+	profile_id,
+	name,
+	identifier,
+	reserved_payload_types
+FROM
+	mdm_apple_configuration_profiles
+WHERE
+	team_id = ? AND
+	reserved_payload_types IS NOT NULL
+ORDER BY name`
+
+	var tmID uint
+	if teamID != nil {
+		tmID = *teamID
+	}
+
+	var rows []struct {
+		ProfileID    uint   `db:"profile_id"`
+		Name         string `db:"name"`
+		Identifier   string `db:"identifier"`
+		PayloadTypes []byte `db:"reserved_payload_types"`
+	}
+	if err := sqlx.SelectContext(ctx, ds.reader, &rows, stmt, tmID); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list reserved payload conflicts")
+	}
+
+	res := make([]fleet.MDMAppleReservedPayloadConflict, 0, len(rows))
+	for _, r := range rows {
+		conflict := fleet.MDMAppleReservedPayloadConflict{
+			ProfileID:  r.ProfileID,
+			Name:       r.Name,
+			Identifier: r.Identifier,
+		}
+		if err := json.Unmarshal(r.PayloadTypes, &conflict.PayloadTypes); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "unmarshal reserved payload types")
+		}
+		res = append(res, conflict)
+	}
+	return res, nil
+}
+
 func (ds *Datastore) InsertMDMIdPAccount(ctx context.Context, account *fleet.MDMIdPAccount) error {
 	stmt := `
       INSERT INTO mdm_idp_accounts
-        (uuid, username, salt, entropy, iterations)
+        (uuid, username, salt, entropy, iterations, fullname, idp_groups)
       VALUES
-        (?, ?, ?, ?, ?)
+        (?, ?, ?, ?, ?, ?, ?)
       ON DUPLICATE KEY UPDATE
         username   = VALUES(username),
         salt       = VALUES(salt),
         entropy    = VALUES(entropy),
-        iterations = VALUES(iterations)`
+        iterations = VALUES(iterations),
+        fullname   = VALUES(fullname),
+        idp_groups = VALUES(idp_groups)`
+
+	var groups []byte
+	if account.Groups != nil {
+		b, err := json.Marshal(account.Groups)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "marshal MDM IdP account groups")
+		}
+		groups = b
+	}
 
-	_, err := ds.writer.ExecContext(ctx, stmt, account.UUID, account.Username, account.Salt, account.Entropy, account.Iterations)
+	_, err := ds.writer.ExecContext(ctx, stmt, account.UUID, account.Username, account.Salt, account.Entropy, account.Iterations,
+		account.FullName, groups)
 	return ctxerr.Wrap(ctx, err, "creating new MDM IdP account")
 }
 
-func subqueryDiskEncryptionVerifying() (string, []interface{}) {
-	sql := `
-            SELECT
-                1 FROM host_mdm_apple_profiles hmap
-            WHERE
-                h.uuid = hmap.host_uuid
-                AND hdek.decryptable = 1
-                AND hmap.profile_identifier = ?
-                AND hmap.status = ?
-                AND hmap.operation_type = ?`
-	args := []interface{}{
-		mobileconfig.FleetFileVaultPayloadIdentifier,
-		fleet.MDMAppleDeliveryVerifying,
-		fleet.MDMAppleOperationTypeInstall,
-	}
-	return sql, args
+// mdmIdPAccountRow is used to load an MDM IdP account from the database, as
+// the groups are stored as a JSON array.
+type mdmIdPAccountRow struct {
+	UUID       string  `db:"uuid"`
+	Username   string  `db:"username"`
+	FullName   string  `db:"fullname"`
+	Salt       []byte  `db:"salt"`
+	Entropy    []byte  `db:"entropy"`
+	Iterations int     `db:"iterations"`
+	Groups     *[]byte `db:"idp_groups"`
 }
 
-func subqueryDiskEncryptionActionRequired() (string, []interface{}) {
-	sql := `
-            SELECT
-                1 FROM host_mdm_apple_profiles hmap
-            WHERE
-                h.uuid = hmap.host_uuid
-                AND(hdek.decryptable = 0
-                    OR (hdek.host_id IS NULL AND hdek.decryptable IS NULL))
-                AND hmap.profile_identifier = ?
-                AND hmap.status = ?
-                AND hmap.operation_type = ?`
-	args := []interface{}{
-		mobileconfig.FleetFileVaultPayloadIdentifier,
-		fleet.MDMAppleDeliveryVerifying,
-		fleet.MDMAppleOperationTypeInstall,
+func (r mdmIdPAccountRow) toAccount() (*fleet.MDMIdPAccount, error) {
+	acc := &fleet.MDMIdPAccount{
+		SaltedSHA512PBKDF2Dictionary: fleet.SaltedSHA512PBKDF2Dictionary{
+			Iterations: r.Iterations,
+			Salt:       r.Salt,
+			Entropy:    r.Entropy,
+		},
+		UUID:     r.UUID,
+		Username: r.Username,
+		FullName: r.FullName,
 	}
-	return sql, args
+	if r.Groups != nil {
+		if err := json.Unmarshal(*r.Groups, &acc.Groups); err != nil {
+			return nil, err
+		}
+	}
+	return acc, nil
 }
 
-func subqueryDiskEncryptionEnforcing() (string, []interface{}) {
-	sql := `
-            SELECT
-                1 FROM host_mdm_apple_profiles hmap
-            WHERE
-                h.uuid = hmap.host_uuid
-                AND hmap.profile_identifier = ?
-                AND (hmap.status IS NULL OR hmap.status = ?)
-                AND hmap.operation_type = ?
-                UNION SELECT
-                    1 FROM host_mdm_apple_profiles hmap
-                WHERE
-                    h.uuid = hmap.host_uuid
-                    AND hmap.profile_identifier = ?
-                    AND (hmap.status IS NOT NULL AND hmap.status = ?)
-                    AND hmap.operation_type = ?
-                    AND hdek.decryptable IS NULL
-                    AND hdek.host_id IS NOT NULL`
-	args := []interface{}{
-		mobileconfig.FleetFileVaultPayloadIdentifier,
-		fleet.MDMAppleDeliveryPending,
-		fleet.MDMAppleOperationTypeInstall,
-		mobileconfig.FleetFileVaultPayloadIdentifier,
-		fleet.MDMAppleDeliveryVerifying,
-		fleet.MDMAppleOperationTypeInstall,
+func (ds *Datastore) GetMDMIdPAccount(ctx context.Context, uuid string) (*fleet.MDMIdPAccount, error) {
+	stmt := `
+      SELECT
+        uuid, username, fullname, salt, entropy, iterations, idp_groups
+      FROM
+        mdm_idp_accounts
+      WHERE
+        uuid = ?`
+
+	var row mdmIdPAccountRow
+	if err := sqlx.GetContext(ctx, ds.reader, &row, stmt, uuid); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("MDMIdPAccount").WithName(uuid))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get MDM IdP account")
 	}
-	return sql, args
+
+	acc, err := row.toAccount()
+	return acc, ctxerr.Wrap(ctx, err, "unmarshal MDM IdP account groups")
 }
 
-func subqueryDiskEncryptionFailed() (string, []interface{}) {
-	sql := `
-            SELECT
-                1 FROM host_mdm_apple_profiles hmap
-            WHERE
-			    h.uuid = hmap.host_uuid
-                AND hmap.profile_identifier = ?
-                AND hmap.status = ?`
-	args := []interface{}{mobileconfig.FleetFileVaultPayloadIdentifier, fleet.MDMAppleDeliveryFailed}
-	return sql, args
+func (ds *Datastore) AssociateHostMDMIdPAccount(ctx context.Context, hostUUID, accountUUID string) error {
+	stmt := `
+      INSERT INTO host_mdm_idp_accounts
+        (host_uuid, account_uuid)
+      VALUES
+        (?, ?)
+      ON DUPLICATE KEY UPDATE
+        account_uuid = VALUES(account_uuid)`
+
+	_, err := ds.writer.ExecContext(ctx, stmt, hostUUID, accountUUID)
+	return ctxerr.Wrap(ctx, err, "associate host with MDM IdP account")
+}
+
+func (ds *Datastore) GetHostMDMIdPAccount(ctx context.Context, hostUUID string) (*fleet.MDMIdPAccount, error) {
+	stmt := `
+      SELECT
+        mia.uuid, mia.username, mia.fullname, mia.salt, mia.entropy, mia.iterations, mia.idp_groups
+      FROM
+        host_mdm_idp_accounts hmia
+      JOIN
+        mdm_idp_accounts mia ON mia.uuid = hmia.account_uuid
+      WHERE
+        hmia.host_uuid = ?`
+
+	var row mdmIdPAccountRow
+	if err := sqlx.GetContext(ctx, ds.reader, &row, stmt, hostUUID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("HostMDMIdPAccount").WithName(hostUUID))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get host MDM IdP account")
+	}
+
+	acc, err := row.toAccount()
+	return acc, ctxerr.Wrap(ctx, err, "unmarshal MDM IdP account groups")
+}
+
+func (ds *Datastore) SetHostMDMActivationLockBypassCode(ctx context.Context, hostUUID, base64Encrypted string) error {
+	// the plain text code escrowed before the codes were encrypted is cleared.
+	stmt := `
+      INSERT INTO host_mdm_activation_lock_bypass_codes
+        (host_uuid, bypass_code, base64_encrypted)
+      VALUES
+        (?, '', ?)
+      ON DUPLICATE KEY UPDATE
+        bypass_code = '',
+        base64_encrypted = VALUES(base64_encrypted)`
+
+	_, err := ds.writer.ExecContext(ctx, stmt, hostUUID, base64Encrypted)
+	return ctxerr.Wrap(ctx, err, "set host activation lock bypass code")
+}
+
+func (ds *Datastore) GetHostMDMActivationLockBypassCode(ctx context.Context, hostUUID string) (*fleet.HostMDMActivationLockBypassCode, error) {
+	stmt := `
+      SELECT
+        host_uuid, bypass_code, base64_encrypted, updated_at
+      FROM
+        host_mdm_activation_lock_bypass_codes
+      WHERE
+        host_uuid = ?`
+
+	var code fleet.HostMDMActivationLockBypassCode
+	if err := sqlx.GetContext(ctx, ds.reader, &code, stmt, hostUUID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("HostMDMActivationLockBypassCode").WithName(hostUUID))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get host activation lock bypass code")
+	}
+	return &code, nil
+}
+
+func (ds *Datastore) SetHostMDMAppleLockPIN(ctx context.Context, pin *fleet.HostMDMAppleLockPIN) error {
+	stmt := `
+      INSERT INTO host_mdm_apple_lock_pins
+        (host_uuid, command_uuid, request_type, pin)
+      VALUES
+        (?, ?, ?, ?)
+      ON DUPLICATE KEY UPDATE
+        command_uuid = VALUES(command_uuid),
+        request_type = VALUES(request_type),
+        pin = VALUES(pin)`
+
+	_, err := ds.writer.ExecContext(ctx, stmt, pin.HostUUID, pin.CommandUUID, pin.RequestType, pin.PIN)
+	return ctxerr.Wrap(ctx, err, "set host lock pin")
+}
+
+func (ds *Datastore) GetHostMDMAppleLockPIN(ctx context.Context, hostUUID string) (*fleet.HostMDMAppleLockPIN, error) {
+	stmt := `
+      SELECT
+        host_uuid, command_uuid, request_type, pin, updated_at
+      FROM
+        host_mdm_apple_lock_pins
+      WHERE
+        host_uuid = ?`
+
+	var pin fleet.HostMDMAppleLockPIN
+	if err := sqlx.GetContext(ctx, ds.reader, &pin, stmt, hostUUID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("HostMDMAppleLockPIN").WithName(hostUUID))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get host lock pin")
+	}
+	return &pin, nil
+}
+
+func (ds *Datastore) GetHostMDMAppleDEPDevice(ctx context.Context, hostID uint) (*fleet.HostMDMAppleDEPDevice, error) {
+	stmt := `
+      SELECT
+        host_id, org_name, description, color, asset_tag, device_family, os, device_assigned_by, device_assigned_date,
+        profile_status, profile_uuid, profile_assign_time, profile_push_time
+      FROM
+        host_mdm_apple_dep_devices
+      WHERE
+        host_id = ?`
+
+	var dev fleet.HostMDMAppleDEPDevice
+	if err := sqlx.GetContext(ctx, ds.reader, &dev, stmt, hostID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("HostMDMAppleDEPDevice").WithID(hostID))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get host dep device")
+	}
+	return &dev, nil
+}
+
+func (ds *Datastore) GetHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint) (*fleet.HostMDMAppleDEPProfileOverride, error) {
+	stmt := `
+      SELECT
+        host_id, profile, profile_uuid, assigned_at, updated_at
+      FROM
+        host_mdm_apple_dep_profile_overrides
+      WHERE
+        host_id = ? AND
+        profile IS NOT NULL`
+
+	var override fleet.HostMDMAppleDEPProfileOverride
+	if err := sqlx.GetContext(ctx, ds.reader, &override, stmt, hostID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("HostMDMAppleDEPProfileOverride").WithID(hostID))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get host dep profile override")
+	}
+	return &override, nil
+}
+
+func (ds *Datastore) SetHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint, profile *json.RawMessage) error {
+	if profile == nil {
+		// only the existing overrides need the default profile assigned back
+		const clearStmt = `
+      UPDATE host_mdm_apple_dep_profile_overrides
+      SET profile = NULL, profile_uuid = '', assigned_at = NULL
+      WHERE host_id = ? AND profile IS NOT NULL`
+		if _, err := ds.writer.ExecContext(ctx, clearStmt, hostID); err != nil {
+			return ctxerr.Wrap(ctx, err, "clear host dep profile override")
+		}
+		return nil
+	}
+
+	const upsertStmt = `
+      INSERT INTO host_mdm_apple_dep_profile_overrides
+        (host_id, profile, profile_uuid, assigned_at)
+      VALUES
+        (?, ?, '', NULL)
+      ON DUPLICATE KEY UPDATE
+        profile = VALUES(profile),
+        profile_uuid = '',
+        assigned_at = NULL`
+	if _, err := ds.writer.ExecContext(ctx, upsertStmt, hostID, profile); err != nil {
+		return ctxerr.Wrap(ctx, err, "set host dep profile override")
+	}
+	return nil
+}
+
+func (ds *Datastore) ListHostMDMAppleDEPProfileOverridesBySerials(ctx context.Context, serials []string) (map[string]*fleet.HostMDMAppleDEPProfileOverride, error) {
+	overrides := make(map[string]*fleet.HostMDMAppleDEPProfileOverride)
+	for i := 0; i < len(serials); i += mdmAppleDEPTeamAssignmentsBatchSize {
+		end := i + mdmAppleDEPTeamAssignmentsBatchSize
+		if end > len(serials) {
+			end = len(serials)
+		}
+		stmt, args, err := sqlx.In(`
+          SELECT
+            o.host_id, o.profile, o.profile_uuid, o.assigned_at, o.updated_at, h.hardware_serial
+          FROM
+            host_mdm_apple_dep_profile_overrides o
+          JOIN
+            hosts h ON h.id = o.host_id
+          WHERE
+            h.hardware_serial IN (?) AND
+            o.profile IS NOT NULL`, serials[i:end])
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "prepare list host dep profile overrides by serials")
+		}
+		var batch []*fleet.HostMDMAppleDEPProfileOverride
+		if err := sqlx.SelectContext(ctx, ds.reader, &batch, stmt, args...); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "list host dep profile overrides by serials")
+		}
+		for _, o := range batch {
+			overrides[o.HardwareSerial] = o
+		}
+	}
+	return overrides, nil
+}
+
+func (ds *Datastore) ListPendingHostMDMAppleDEPProfileOverrides(ctx context.Context) ([]*fleet.HostMDMAppleDEPProfileOverride, error) {
+	stmt := `
+      SELECT
+        o.host_id, o.profile, o.profile_uuid, o.assigned_at, o.updated_at, h.hardware_serial, t.name AS team_name
+      FROM
+        host_mdm_apple_dep_profile_overrides o
+      JOIN
+        hosts h ON h.id = o.host_id
+      LEFT JOIN
+        teams t ON t.id = h.team_id
+      WHERE
+        o.assigned_at IS NULL AND
+        h.hardware_serial != ''
+      ORDER BY
+        o.host_id`
+
+	var overrides []*fleet.HostMDMAppleDEPProfileOverride
+	if err := sqlx.SelectContext(ctx, ds.reader, &overrides, stmt); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list pending host dep profile overrides")
+	}
+	return overrides, nil
+}
+
+func (ds *Datastore) SetHostMDMAppleDEPProfileOverridesPending(ctx context.Context, hostIDs []uint) error {
+	if len(hostIDs) == 0 {
+		return nil
+	}
+	stmt, args, err := sqlx.In(`
+      UPDATE host_mdm_apple_dep_profile_overrides
+      SET assigned_at = NULL
+      WHERE host_id IN (?)`, hostIDs)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "prepare set host dep profile overrides pending")
+	}
+	if _, err := ds.writer.ExecContext(ctx, stmt, args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "set host dep profile overrides pending")
+	}
+	return nil
+}
+
+func (ds *Datastore) SetHostMDMAppleDEPProfileOverrideAssigned(ctx context.Context, hostID uint, profileUUID string) error {
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		const deleteStmt = `DELETE FROM host_mdm_apple_dep_profile_overrides WHERE host_id = ? AND profile IS NULL`
+		res, err := tx.ExecContext(ctx, deleteStmt, hostID)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "delete cleared host dep profile override")
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			return nil
+		}
+
+		const updateStmt = `
+      UPDATE host_mdm_apple_dep_profile_overrides
+      SET profile_uuid = ?, assigned_at = CURRENT_TIMESTAMP
+      WHERE host_id = ?`
+		if _, err := tx.ExecContext(ctx, updateStmt, profileUUID, hostID); err != nil {
+			return ctxerr.Wrap(ctx, err, "set host dep profile override assigned")
+		}
+		return nil
+	})
+}
+
+func (ds *Datastore) SetMDMAppleDEPDevicesOrgName(ctx context.Context, orgName string) error {
+	stmt := `UPDATE host_mdm_apple_dep_devices SET org_name = ? WHERE org_name != ?`
+	if _, err := ds.writer.ExecContext(ctx, stmt, orgName, orgName); err != nil {
+		return ctxerr.Wrap(ctx, err, "set dep devices org name")
+	}
+	return nil
+}
+
+func (ds *Datastore) ListMDMAppleDEPHostsReport(ctx context.Context, filter fleet.TeamFilter, opts fleet.ListOptions) ([]*fleet.MDMAppleDEPHostReportItem, error) {
+	stmt := fmt.Sprintf(`
+      SELECT
+        h.id,
+        COALESCE(hdn.display_name, '') AS display_name,
+        h.hardware_serial,
+        h.hardware_model,
+        h.team_id,
+        d.host_id, d.org_name, d.description, d.color, d.asset_tag, d.device_family, d.os,
+        d.device_assigned_by, d.device_assigned_date,
+        d.profile_status, d.profile_uuid, d.profile_assign_time, d.profile_push_time
+      FROM
+        hosts h
+        JOIN host_mdm_apple_dep_devices d ON d.host_id = h.id
+        LEFT JOIN host_display_names hdn ON hdn.host_id = h.id
+      WHERE
+        %s`, ds.whereFilterHostsByTeams(filter, "h"))
+
+	if opts.OrderKey == "" || opts.OrderKey == "host_id" {
+		opts.OrderKey = "h.id"
+	}
+	stmt = appendListOptionsToSQL(stmt, &opts)
+
+	var items []*fleet.MDMAppleDEPHostReportItem
+	if err := sqlx.SelectContext(ctx, ds.reader, &items, stmt); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list dep hosts report")
+	}
+	return items, nil
+}
+
+func (ds *Datastore) ListMDMAppleDEPAssignmentDiscrepancies(ctx context.Context, filter fleet.TeamFilter, defaultFamilies []string) ([]*fleet.MDMAppleDEPAssignmentDiscrepancy, error) {
+	// the hosts released by Fleet are not installed from DEP anymore, their
+	// removal from Fleet's MDM server is expected. The device family is empty
+	// for the hosts unassigned before their device information was stored,
+	// they are always reported.
+	unassignedCond := `d.unassigned_at IS NOT NULL`
+	args := []interface{}{
+		fleet.MDMAppleDEPAssignmentDiscrepancyUnexpectedDeviceFamily,
+		fleet.MDMAppleDEPAssignmentDiscrepancyUnassigned,
+	}
+	var unexpectedFamilyCond string
+	if len(defaultFamilies) > 0 {
+		unassignedCond += ` AND (d.device_family = '' OR d.device_family IN (?))`
+		unexpectedFamilyCond = ` OR (d.unassigned_at IS NULL AND d.device_family != '' AND d.device_family NOT IN (?))`
+		args = append(args, defaultFamilies, defaultFamilies)
+	}
+
+	stmt := fmt.Sprintf(`
+      SELECT
+        h.id AS host_id,
+        COALESCE(hdn.display_name, '') AS display_name,
+        h.hardware_serial,
+        h.hardware_model,
+        h.team_id,
+        d.device_family,
+        IF(d.unassigned_at IS NULL, ?, ?) AS reason,
+        d.unassigned_at
+      FROM
+        hosts h
+        JOIN host_mdm_apple_dep_devices d ON d.host_id = h.id
+        JOIN host_mdm hm ON hm.host_id = h.id AND hm.installed_from_dep = 1
+        LEFT JOIN host_display_names hdn ON hdn.host_id = h.id
+      WHERE
+        ((%s)%s) AND %s
+      ORDER BY
+        h.id`, unassignedCond, unexpectedFamilyCond, ds.whereFilterHostsByTeams(filter, "h"))
+
+	stmt, args, err := sqlx.In(stmt, args...)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "build query to list dep assignment discrepancies")
+	}
+	var items []*fleet.MDMAppleDEPAssignmentDiscrepancy
+	if err := sqlx.SelectContext(ctx, ds.reader, &items, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list dep assignment discrepancies")
+	}
+	return items, nil
+}
+
+func (ds *Datastore) ListMDMAppleHostQueuedCommands(ctx context.Context, hostUUID string) ([]*fleet.MDMAppleHostQueuedCommand, error) {
+	// the order matches the one of the nano_view_queue view, which is the
+	// order in which the commands are delivered.
+	const stmt = `
+          SELECT
+            nvq.command_uuid,
+            nvq.request_type,
+            COALESCE(NULLIF(nvq.status, ''), 'Pending') AS status,
+            nvq.priority,
+            nvq.created_at,
+            hmap.profile_identifier
+          FROM
+            nano_view_queue nvq
+          LEFT JOIN
+            host_mdm_apple_profiles hmap ON hmap.host_uuid = nvq.id AND hmap.command_uuid = nvq.command_uuid
+          WHERE
+            nvq.id = ? AND
+            nvq.active = 1 AND
+            ( nvq.status IS NULL OR nvq.status = 'NotNow' )
+          ORDER BY
+            nvq.priority DESC, nvq.created_at, nvq.command_uuid`
+
+	var cmds []*fleet.MDMAppleHostQueuedCommand
+	if err := sqlx.SelectContext(ctx, ds.reader, &cmds, stmt, hostUUID); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list host queued commands")
+	}
+	return cmds, nil
+}
+
+func (ds *Datastore) CancelMDMAppleHostQueuedCommand(ctx context.Context, hostUUID, commandUUID string) error {
+	// the command is marked inactive like nanomdm does to clear the queue, so
+	// that it is never delivered.
+	const stmt = `
+          UPDATE
+            nano_enrollment_queue neq
+          LEFT JOIN
+            nano_command_results ncr ON ncr.id = neq.id AND ncr.command_uuid = neq.command_uuid
+          SET
+            neq.active = 0
+          WHERE
+            neq.id = ? AND
+            neq.command_uuid = ? AND
+            neq.active = 1 AND
+            ( ncr.status IS NULL OR ncr.status = 'NotNow' )`
+
+	const historyStmt = `
+          UPDATE host_mdm_apple_command_history
+          SET status = 'Canceled'
+          WHERE host_uuid = ? AND command_uuid = ?`
+
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		res, err := tx.ExecContext(ctx, stmt, hostUUID, commandUUID)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "cancel host queued command")
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ctxerr.Wrap(ctx, notFound("MDMAppleCommand").WithName(commandUUID))
+		}
+		if _, err := tx.ExecContext(ctx, historyStmt, hostUUID, commandUUID); err != nil {
+			return ctxerr.Wrap(ctx, err, "record canceled command in host history")
+		}
+		return nil
+	})
+}
+
+func (ds *Datastore) ListMDMAppleHostCommands(ctx context.Context, hostUUID string, opt fleet.ListOptions) ([]*fleet.MDMAppleHostCommand, *fleet.PaginationMetadata, error) {
+	query := `
+          SELECT
+            command_uuid, request_type, status, created_at, updated_at
+          FROM
+            host_mdm_apple_command_history
+          WHERE host_uuid = ?`
+
+	if opt.OrderKey == "" {
+		opt.OrderKey = "created_at"
+		opt.OrderDirection = fleet.OrderDescending
+	}
+	opt.IncludeMetadata = true
+	query, args := appendListOptionsWithCursorToSQL(query, []interface{}{hostUUID}, &opt)
+
+	cmds := []*fleet.MDMAppleHostCommand{}
+	if err := sqlx.SelectContext(ctx, ds.reader, &cmds, query, args...); err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list host commands history")
+	}
+
+	metaData := &fleet.PaginationMetadata{HasPreviousResults: opt.Page > 0}
+	if len(cmds) > int(opt.PerPage) {
+		metaData.HasNextResults = true
+		cmds = cmds[:len(cmds)-1]
+	}
+	return cmds, metaData, nil
+}
+
+func (ds *Datastore) CleanupHostMDMAppleCommandHistory(ctx context.Context, now time.Time) (int64, error) {
+	res, err := ds.writer.ExecContext(ctx, `DELETE FROM host_mdm_apple_command_history WHERE created_at < ?`,
+		now.Add(-fleet.HostMDMAppleCommandHistoryRetention))
+	if err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "cleanup host MDM commands history")
+	}
+	deleted, _ := res.RowsAffected()
+	return deleted, nil
+}
+
+func (ds *Datastore) NewMDMAppleEnrollmentLink(ctx context.Context, link *fleet.MDMAppleEnrollmentLink) (*fleet.MDMAppleEnrollmentLink, error) {
+	stmt := `
+      INSERT INTO mdm_apple_enrollment_links
+        (token, team_id, max_uses, expires_at)
+      VALUES
+        (?, ?, ?, ?)`
+
+	res, err := ds.writer.ExecContext(ctx, stmt, link.Token, link.TeamID, link.MaxUses, link.ExpiresAt)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "creating MDM Apple enrollment link")
+	}
+	id, _ := res.LastInsertId()
+	return getMDMAppleEnrollmentLinkDB(ctx, ds.writer, uint(id))
+}
+
+const selectMDMAppleEnrollmentLinkStmt = `
+      SELECT
+        id, token, team_id, max_uses, uses_count, expires_at, created_at
+      FROM
+        mdm_apple_enrollment_links`
+
+func (ds *Datastore) GetMDMAppleEnrollmentLink(ctx context.Context, id uint) (*fleet.MDMAppleEnrollmentLink, error) {
+	return getMDMAppleEnrollmentLinkDB(ctx, ds.reader, id)
+}
+
+func getMDMAppleEnrollmentLinkDB(ctx context.Context, q sqlx.QueryerContext, id uint) (*fleet.MDMAppleEnrollmentLink, error) {
+	var link fleet.MDMAppleEnrollmentLink
+	if err := sqlx.GetContext(ctx, q, &link, selectMDMAppleEnrollmentLinkStmt+` WHERE id = ?`, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("MDMAppleEnrollmentLink").WithID(id))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get MDM Apple enrollment link")
+	}
+
+	const usesStmt = `
+      SELECT
+        u.host_uuid, h.id AS host_id, u.created_at
+      FROM
+        mdm_apple_enrollment_link_uses u
+      LEFT JOIN
+        hosts h ON h.uuid = u.host_uuid
+      WHERE
+        u.link_id = ?
+      ORDER BY
+        u.created_at, u.host_uuid`
+	link.Uses = []fleet.MDMAppleEnrollmentLinkUse{}
+	if err := sqlx.SelectContext(ctx, q, &link.Uses, usesStmt, id); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list MDM Apple enrollment link uses")
+	}
+	return &link, nil
+}
+
+func (ds *Datastore) GetMDMAppleEnrollmentLinkByToken(ctx context.Context, token string) (*fleet.MDMAppleEnrollmentLink, error) {
+	stmt := selectMDMAppleEnrollmentLinkStmt + `
+      WHERE
+        token = ? AND
+        expires_at > CURRENT_TIMESTAMP AND
+        uses_count < max_uses`
+
+	var link fleet.MDMAppleEnrollmentLink
+	if err := sqlx.GetContext(ctx, ds.reader, &link, stmt, token); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("MDMAppleEnrollmentLink"))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get MDM Apple enrollment link by token")
+	}
+	return &link, nil
+}
+
+func (ds *Datastore) ConsumeMDMAppleEnrollmentLink(ctx context.Context, token, hostUUID string) (*fleet.MDMAppleEnrollmentLink, error) {
+	var link *fleet.MDMAppleEnrollmentLink
+	err := ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		// a host that already consumed the link (e.g. it re-enrolled) does not
+		// use it again.
+		const usedStmt = `
+      SELECT
+        l.id
+      FROM
+        mdm_apple_enrollment_links l
+      JOIN
+        mdm_apple_enrollment_link_uses u ON u.link_id = l.id
+      WHERE
+        l.token = ? AND
+        u.host_uuid = ?`
+		var id uint
+		err := sqlx.GetContext(ctx, tx, &id, usedStmt, token, hostUUID)
+		switch {
+		case err == nil:
+			link, err = getMDMAppleEnrollmentLinkDB(ctx, tx, id)
+			return err
+		case !errors.Is(err, sql.ErrNoRows):
+			return ctxerr.Wrap(ctx, err, "check MDM Apple enrollment link use")
+		}
+
+		const useStmt = `
+      UPDATE
+        mdm_apple_enrollment_links
+      SET
+        uses_count = uses_count + 1
+      WHERE
+        token = ? AND
+        expires_at > CURRENT_TIMESTAMP AND
+        uses_count < max_uses`
+		res, err := tx.ExecContext(ctx, useStmt, token)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "increment MDM Apple enrollment link uses")
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ctxerr.Wrap(ctx, notFound("MDMAppleEnrollmentLink"))
+		}
+
+		if err := sqlx.GetContext(ctx, tx, &id, `SELECT id FROM mdm_apple_enrollment_links WHERE token = ?`, token); err != nil {
+			return ctxerr.Wrap(ctx, err, "get MDM Apple enrollment link id")
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO mdm_apple_enrollment_link_uses (link_id, host_uuid) VALUES (?, ?)`, id, hostUUID); err != nil {
+			return ctxerr.Wrap(ctx, err, "insert MDM Apple enrollment link use")
+		}
+		link, err = getMDMAppleEnrollmentLinkDB(ctx, tx, id)
+		return err
+	})
+	return link, err
+}
+
+const selectMDMAppleSSOEnrollmentTokenStmt = `
+      SELECT
+        t.id, t.token, t.idp_account_uuid, t.expires_at, t.consumed_at, t.revoked_at, t.created_at,
+        a.username AS idp_username, a.fullname AS idp_full_name
+      FROM
+        mdm_apple_sso_enrollment_tokens t
+      JOIN
+        mdm_idp_accounts a ON a.uuid = t.idp_account_uuid`
+
+func getMDMAppleSSOEnrollmentTokenDB(ctx context.Context, q sqlx.QueryerContext, id uint) (*fleet.MDMAppleSSOEnrollmentToken, error) {
+	var tok fleet.MDMAppleSSOEnrollmentToken
+	if err := sqlx.GetContext(ctx, q, &tok, selectMDMAppleSSOEnrollmentTokenStmt+` WHERE t.id = ?`, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("MDMAppleSSOEnrollmentToken").WithID(id))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get MDM Apple SSO enrollment token")
+	}
+	return &tok, nil
+}
+
+func (ds *Datastore) NewMDMAppleSSOEnrollmentToken(ctx context.Context, token *fleet.MDMAppleSSOEnrollmentToken) (*fleet.MDMAppleSSOEnrollmentToken, error) {
+	stmt := `
+      INSERT INTO mdm_apple_sso_enrollment_tokens
+        (token, idp_account_uuid, expires_at)
+      VALUES
+        (?, ?, ?)`
+
+	res, err := ds.writer.ExecContext(ctx, stmt, token.Token, token.IdPAccountUUID, token.ExpiresAt)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "creating MDM Apple SSO enrollment token")
+	}
+	id, _ := res.LastInsertId()
+	return getMDMAppleSSOEnrollmentTokenDB(ctx, ds.writer, uint(id))
+}
+
+func (ds *Datastore) ConsumeMDMAppleSSOEnrollmentToken(ctx context.Context, token string) (*fleet.MDMAppleSSOEnrollmentToken, error) {
+	var tok *fleet.MDMAppleSSOEnrollmentToken
+	err := ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		const useStmt = `
+      UPDATE
+        mdm_apple_sso_enrollment_tokens
+      SET
+        consumed_at = CURRENT_TIMESTAMP
+      WHERE
+        token = ? AND
+        expires_at > CURRENT_TIMESTAMP AND
+        consumed_at IS NULL AND
+        revoked_at IS NULL`
+		res, err := tx.ExecContext(ctx, useStmt, token)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "consume MDM Apple SSO enrollment token")
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ctxerr.Wrap(ctx, notFound("MDMAppleSSOEnrollmentToken"))
+		}
+
+		var id uint
+		if err := sqlx.GetContext(ctx, tx, &id, `SELECT id FROM mdm_apple_sso_enrollment_tokens WHERE token = ?`, token); err != nil {
+			return ctxerr.Wrap(ctx, err, "get MDM Apple SSO enrollment token id")
+		}
+		tok, err = getMDMAppleSSOEnrollmentTokenDB(ctx, tx, id)
+		return err
+	})
+	return tok, err
+}
+
+func (ds *Datastore) RevokeMDMAppleSSOEnrollmentToken(ctx context.Context, id uint) (*fleet.MDMAppleSSOEnrollmentToken, error) {
+	const stmt = `
+      UPDATE
+        mdm_apple_sso_enrollment_tokens
+      SET
+        revoked_at = CURRENT_TIMESTAMP
+      WHERE
+        id = ? AND
+        expires_at > CURRENT_TIMESTAMP AND
+        consumed_at IS NULL AND
+        revoked_at IS NULL`
+	res, err := ds.writer.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "revoke MDM Apple SSO enrollment token")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ctxerr.Wrap(ctx, notFound("MDMAppleSSOEnrollmentToken").WithID(id))
+	}
+	return getMDMAppleSSOEnrollmentTokenDB(ctx, ds.writer, id)
+}
+
+func (ds *Datastore) ListMDMAppleSSOEnrollmentTokens(ctx context.Context, opt fleet.ListOptions) ([]*fleet.MDMAppleSSOEnrollmentToken, error) {
+	if opt.OrderKey == "" {
+		opt.OrderKey = "t.id"
+		opt.OrderDirection = fleet.OrderDescending
+	}
+	stmt := appendListOptionsToSQL(selectMDMAppleSSOEnrollmentTokenStmt, &opt)
+
+	var toks []*fleet.MDMAppleSSOEnrollmentToken
+	if err := sqlx.SelectContext(ctx, ds.reader, &toks, stmt); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list MDM Apple SSO enrollment tokens")
+	}
+	return toks, nil
+}
+
+func (ds *Datastore) EnsureMDMAppleTeamEnrollmentToken(ctx context.Context, teamID *uint, token string) (*fleet.MDMAppleTeamEnrollmentToken, error) {
+	// the existing token of the team, if any, is kept as it may already be
+	// embedded in enrollment profiles.
+	const stmt = `
+		INSERT IGNORE INTO
+			mdm_apple_team_enrollment_tokens (team_id, global_or_team_id, token)
+		VALUES
+			(?, ?, ?)`
+
+	var globalOrTmID uint
+	if teamID != nil {
+		globalOrTmID = *teamID
+	}
+	if _, err := ds.writer.ExecContext(ctx, stmt, teamID, globalOrTmID, token); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "insert mdm apple team enrollment token")
+	}
+	return getMDMAppleTeamEnrollmentTokenDB(ctx, ds.writer, `global_or_team_id = ?`, globalOrTmID)
+}
+
+func (ds *Datastore) SetMDMAppleTeamEnrollmentToken(ctx context.Context, teamID *uint, token string) (*fleet.MDMAppleTeamEnrollmentToken, error) {
+	const stmt = `
+		INSERT INTO
+			mdm_apple_team_enrollment_tokens (team_id, global_or_team_id, token)
+		VALUES
+			(?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			token = VALUES(token)`
+
+	var globalOrTmID uint
+	if teamID != nil {
+		globalOrTmID = *teamID
+	}
+	if _, err := ds.writer.ExecContext(ctx, stmt, teamID, globalOrTmID, token); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "upsert mdm apple team enrollment token")
+	}
+	return getMDMAppleTeamEnrollmentTokenDB(ctx, ds.writer, `global_or_team_id = ?`, globalOrTmID)
+}
+
+func (ds *Datastore) GetMDMAppleTeamEnrollmentTokenByToken(ctx context.Context, token string) (*fleet.MDMAppleTeamEnrollmentToken, error) {
+	return getMDMAppleTeamEnrollmentTokenDB(ctx, ds.reader, `token = ?`, token)
+}
+
+func getMDMAppleTeamEnrollmentTokenDB(ctx context.Context, q sqlx.QueryerContext, where string, arg interface{}) (*fleet.MDMAppleTeamEnrollmentToken, error) {
+	stmt := `
+		SELECT
+			team_id, token, created_at, updated_at
+		FROM
+			mdm_apple_team_enrollment_tokens
+		WHERE ` + where
+
+	var tok fleet.MDMAppleTeamEnrollmentToken
+	if err := sqlx.GetContext(ctx, q, &tok, stmt, arg); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("MDMAppleTeamEnrollmentToken"))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get mdm apple team enrollment token")
+	}
+	return &tok, nil
+}
+
+func (ds *Datastore) RecordMDMApplePushResults(ctx context.Context, results []fleet.MDMApplePushResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	// the failure count restarts if the failure is for a new token of the
+	// enrollment.
+	const failStmt = `
+		INSERT INTO
+			mdm_apple_push_failures (enrollment_id, token_hex, failure_count, last_error, token_invalid, last_failed_at)
+		SELECT
+			id, token_hex, 1, ?, ?, CURRENT_TIMESTAMP
+		FROM
+			nano_enrollments
+		WHERE
+			token_hex = ?
+		ON DUPLICATE KEY UPDATE
+			failure_count = IF(token_hex = VALUES(token_hex), failure_count + 1, 1),
+			token_hex = VALUES(token_hex),
+			last_error = VALUES(last_error),
+			token_invalid = VALUES(token_invalid),
+			last_failed_at = VALUES(last_failed_at)`
+
+	const successStmt = `
+		DELETE FROM
+			mdm_apple_push_failures
+		WHERE
+			token_hex IN (?)`
+
+	var succeeded []string
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		succeeded = succeeded[:0]
+		for _, res := range results {
+			if res.Err == "" {
+				succeeded = append(succeeded, res.TokenHex)
+				continue
+			}
+			lastErr := res.Err
+			if len(lastErr) > 255 {
+				lastErr = lastErr[:255]
+			}
+			if _, err := tx.ExecContext(ctx, failStmt, lastErr, res.TokenInvalid, res.TokenHex); err != nil {
+				return ctxerr.Wrap(ctx, err, "record mdm apple push failure")
+			}
+		}
+
+		if len(succeeded) > 0 {
+			stmt, args, err := sqlx.In(successStmt, succeeded)
+			if err != nil {
+				return ctxerr.Wrap(ctx, err, "prepare clear mdm apple push failures")
+			}
+			if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+				return ctxerr.Wrap(ctx, err, "clear mdm apple push failures")
+			}
+		}
+		return nil
+	})
+}
+
+func (ds *Datastore) ListMDMAppleInvalidPushTokenEnrollmentIDs(ctx context.Context, enrollmentIDs []string) ([]string, error) {
+	if len(enrollmentIDs) == 0 {
+		return nil, nil
+	}
+
+	// the failures of a previous token of the enrollment don't apply.
+	const stmt = `
+		SELECT
+			f.enrollment_id
+		FROM
+			mdm_apple_push_failures f
+		JOIN
+			nano_enrollments ne ON ne.id = f.enrollment_id AND ne.token_hex = f.token_hex
+		WHERE
+			f.token_invalid = 1 AND
+			f.enrollment_id IN (?)`
+
+	query, args, err := sqlx.In(stmt, enrollmentIDs)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "prepare list mdm apple invalid push tokens")
+	}
+	var ids []string
+	if err := sqlx.SelectContext(ctx, ds.reader, &ids, query, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list mdm apple invalid push tokens")
+	}
+	return ids, nil
+}
+
+func (ds *Datastore) ClearMDMApplePushFailures(ctx context.Context, enrollmentID string) error {
+	_, err := ds.writer.ExecContext(ctx, `DELETE FROM mdm_apple_push_failures WHERE enrollment_id = ?`, enrollmentID)
+	return ctxerr.Wrap(ctx, err, "clear mdm apple push failures")
+}
+
+func (ds *Datastore) GetHostMDMApplePushFailure(ctx context.Context, hostUUID string) (*fleet.HostMDMApplePushFailure, error) {
+	const stmt = `
+		SELECT
+			f.failure_count, f.last_error, f.token_invalid, f.last_failed_at
+		FROM
+			mdm_apple_push_failures f
+		JOIN
+			nano_enrollments ne ON ne.id = f.enrollment_id AND ne.token_hex = f.token_hex
+		WHERE
+			ne.type = 'Device' AND
+			ne.device_id = ?`
+
+	var failure fleet.HostMDMApplePushFailure
+	if err := sqlx.GetContext(ctx, ds.reader, &failure, stmt, hostUUID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("HostMDMApplePushFailure").WithName(hostUUID))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get host mdm apple push failure")
+	}
+	return &failure, nil
+}
+
+func subqueryDiskEncryptionVerifying() (string, []interface{}) {
+	sql := `
+            SELECT
+                1 FROM host_mdm_apple_profiles hmap
+            WHERE
+                h.uuid = hmap.host_uuid
+                AND hdek.decryptable = 1
+                AND hmap.profile_identifier = ?
+                AND hmap.status = ?
+                AND hmap.operation_type = ?`
+	args := []interface{}{
+		mobileconfig.FleetFileVaultPayloadIdentifier,
+		fleet.MDMAppleDeliveryVerifying,
+		fleet.MDMAppleOperationTypeInstall,
+	}
+	return sql, args
+}
+
+func subqueryDiskEncryptionActionRequired() (string, []interface{}) {
+	sql := `
+            SELECT
+                1 FROM host_mdm_apple_profiles hmap
+            WHERE
+                h.uuid = hmap.host_uuid
+                AND(hdek.decryptable = 0
+                    OR (hdek.host_id IS NULL AND hdek.decryptable IS NULL))
+                AND hmap.profile_identifier = ?
+                AND hmap.status = ?
+                AND hmap.operation_type = ?`
+	args := []interface{}{
+		mobileconfig.FleetFileVaultPayloadIdentifier,
+		fleet.MDMAppleDeliveryVerifying,
+		fleet.MDMAppleOperationTypeInstall,
+	}
+	return sql, args
+}
+
+func subqueryDiskEncryptionEnforcing() (string, []interface{}) {
+	sql := `
+            SELECT
+                1 FROM host_mdm_apple_profiles hmap
+            WHERE
+                h.uuid = hmap.host_uuid
+                AND hmap.profile_identifier = ?
+                AND (hmap.status IS NULL OR hmap.status = ?)
+                AND hmap.operation_type = ?
+                UNION SELECT
+                    1 FROM host_mdm_apple_profiles hmap
+                WHERE
+                    h.uuid = hmap.host_uuid
+                    AND hmap.profile_identifier = ?
+                    AND (hmap.status IS NOT NULL AND hmap.status = ?)
+                    AND hmap.operation_type = ?
+                    AND hdek.decryptable IS NULL
+                    AND hdek.host_id IS NOT NULL`
+	args := []interface{}{
+		mobileconfig.FleetFileVaultPayloadIdentifier,
+		fleet.MDMAppleDeliveryPending,
+		fleet.MDMAppleOperationTypeInstall,
+		mobileconfig.FleetFileVaultPayloadIdentifier,
+		fleet.MDMAppleDeliveryVerifying,
+		fleet.MDMAppleOperationTypeInstall,
+	}
+	return sql, args
+}
+
+func subqueryDiskEncryptionFailed() (string, []interface{}) {
+	sql := `
+            SELECT
+                1 FROM host_mdm_apple_profiles hmap
+            WHERE
+			    h.uuid = hmap.host_uuid
+                AND hmap.profile_identifier = ?
+                AND hmap.status = ?`
+	args := []interface{}{mobileconfig.FleetFileVaultPayloadIdentifier, fleet.MDMAppleDeliveryFailed}
+	return sql, args
 }
 
 func subqueryDiskEncryptionRemovingEnforcement() (string, []interface{}) {
@@ -1666,447 +4235,1817 @@ func subqueryDiskEncryptionRemovingEnforcement() (string, []interface{}) {
 	return sql, args
 }
 
-func (ds *Datastore) GetMDMAppleFileVaultSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleFileVaultSummary, error) {
-	sqlFmt := `
-SELECT
-    COUNT(
-        CASE WHEN EXISTS (%s)
-            THEN 1
-        END) AS verifying,
-    COUNT(
-        CASE WHEN EXISTS (%s)
-            THEN 1
-        END) AS action_required,
-    COUNT(
-        CASE WHEN EXISTS (%s)
-            THEN 1
-        END) AS enforcing,
-    COUNT(
-		CASE WHEN EXISTS (%s)
-            THEN 1
-        END) AS failed,
-    COUNT(
-        CASE WHEN EXISTS (%s)
-            THEN 1
-        END) AS removing_enforcement
-FROM
-    hosts h
-    LEFT JOIN host_disk_encryption_keys hdek ON h.id = hdek.host_id
-WHERE
-    %s`
+func (ds *Datastore) GetMDMAppleFileVaultSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleFileVaultSummary, error) {
+	sqlFmt := `
+SELECT
+    COUNT(
+        CASE WHEN EXISTS (%s)
+            THEN 1
+        END) AS verifying,
+    COUNT(
+        CASE WHEN EXISTS (%s)
+            THEN 1
+        END) AS action_required,
+    COUNT(
+        CASE WHEN EXISTS (%s)
+            THEN 1
+        END) AS enforcing,
+    COUNT(
+		CASE WHEN EXISTS (%s)
+            THEN 1
+        END) AS failed,
+    COUNT(
+        CASE WHEN EXISTS (%s)
+            THEN 1
+        END) AS removing_enforcement
+FROM
+    hosts h
+    LEFT JOIN host_disk_encryption_keys hdek ON h.id = hdek.host_id
+WHERE
+    %s`
+
+	var args []interface{}
+	subqueryVerifying, subqueryVerifyingArgs := subqueryDiskEncryptionVerifying()
+	args = append(args, subqueryVerifyingArgs...)
+	subqueryActionRequired, subqueryActionRequiredArgs := subqueryDiskEncryptionActionRequired()
+	args = append(args, subqueryActionRequiredArgs...)
+	subqueryEnforcing, subqueryEnforcingArgs := subqueryDiskEncryptionEnforcing()
+	args = append(args, subqueryEnforcingArgs...)
+	subqueryFailed, subqueryFailedArgs := subqueryDiskEncryptionFailed()
+	args = append(args, subqueryFailedArgs...)
+	subqueryRemovingEnforcement, subqueryRemovingEnforcementArgs := subqueryDiskEncryptionRemovingEnforcement()
+	args = append(args, subqueryRemovingEnforcementArgs...)
+
+	teamFilter := "h.team_id IS NULL"
+	if teamID != nil && *teamID > 0 {
+		teamFilter = "h.team_id = ?"
+		args = append(args, *teamID)
+	}
+
+	stmt := fmt.Sprintf(sqlFmt, subqueryVerifying, subqueryActionRequired, subqueryEnforcing, subqueryFailed, subqueryRemovingEnforcement, teamFilter)
+
+	var res fleet.MDMAppleFileVaultSummary
+	err := sqlx.GetContext(ctx, ds.reader, &res, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	rotationStmt := `
+SELECT
+    COUNT(CASE WHEN hdekr.notified_at IS NULL THEN 1 END) AS pending,
+    COUNT(CASE WHEN hdekr.notified_at IS NOT NULL AND hdekr.completed_at IS NULL THEN 1 END) AS notified,
+    COUNT(CASE WHEN hdekr.completed_at IS NOT NULL THEN 1 END) AS completed
+FROM
+    host_disk_encryption_key_rotations hdekr
+    JOIN hosts h ON h.id = hdekr.host_id
+WHERE
+    ` + teamFilter
+	var rotationArgs []interface{}
+	if teamID != nil && *teamID > 0 {
+		rotationArgs = append(rotationArgs, *teamID)
+	}
+	if err := sqlx.GetContext(ctx, ds.reader, &res.KeyRotation, rotationStmt, rotationArgs...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get disk encryption key rotation summary")
+	}
+
+	return &res, nil
+}
+
+func (ds *Datastore) BulkUpsertMDMAppleConfigProfiles(ctx context.Context, payload []*fleet.MDMAppleConfigProfile) error {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	var args []any
+	var sb strings.Builder
+	for _, cp := range payload {
+		var teamID uint
+		if cp.TeamID != nil {
+			teamID = *cp.TeamID
+		}
+
+		args = append(args, teamID, cp.Identifier, cp.Name, cp.Mobileconfig, ds.profileChecksum.Sum(cp.Mobileconfig))
+		sb.WriteString("(?, ?, ?, ?, ?),")
+	}
+
+	stmt := fmt.Sprintf(`
+          INSERT INTO
+              mdm_apple_configuration_profiles (team_id, identifier, name, mobileconfig, checksum)
+          VALUES %s
+          ON DUPLICATE KEY UPDATE
+            mobileconfig = VALUES(mobileconfig),
+	    checksum = VALUES(checksum)`, strings.TrimSuffix(sb.String(), ","))
+
+	if _, err := ds.writer.ExecContext(ctx, stmt, args...); err != nil {
+		return ctxerr.Wrapf(ctx, err, "upsert mdm config profiles")
+	}
+
+	return nil
+}
+
+func (ds *Datastore) InsertMDMAppleBootstrapPackage(ctx context.Context, bp *fleet.MDMAppleBootstrapPackage) error {
+	stmt := `
+          INSERT INTO mdm_apple_bootstrap_packages (team_id, name, sha256, bytes, token)
+	  VALUES (?, ?, ?, ?, ?)
+	`
+
+	_, err := ds.writer.ExecContext(ctx, stmt, bp.TeamID, bp.Name, bp.Sha256, bp.Bytes, bp.Token)
+	if err != nil {
+		if isDuplicate(err) {
+			return ctxerr.Wrap(ctx, alreadyExists("BootstrapPackage", fmt.Sprintf("for team %d", bp.TeamID)))
+		}
+		return ctxerr.Wrap(ctx, err, "create bootstrap pacckage")
+	}
+
+	return nil
+}
+
+func (ds *Datastore) DeleteMDMAppleBootstrapPackage(ctx context.Context, teamID uint) error {
+	return ds.withTx(ctx, func(tx sqlx.ExtContext) error {
+		var uploadID *uint
+		if err := sqlx.GetContext(ctx, tx, &uploadID, `SELECT upload_id FROM mdm_apple_bootstrap_packages WHERE team_id = ? FOR UPDATE`, teamID); err != nil {
+			if err == sql.ErrNoRows {
+				return ctxerr.Wrap(ctx, notFound("BootstrapPackage").WithID(teamID))
+			}
+			return ctxerr.Wrap(ctx, err, "get bootstrap package upload id")
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM mdm_apple_bootstrap_packages WHERE team_id = ?`, teamID); err != nil {
+			return ctxerr.Wrap(ctx, err, "delete bootstrap package")
+		}
+
+		// the chunks of the upload are deleted via the foreign key's cascade
+		if uploadID != nil {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM mdm_apple_bootstrap_package_uploads WHERE id = ?`, *uploadID); err != nil {
+				return ctxerr.Wrap(ctx, err, "delete bootstrap package upload")
+			}
+		}
+		return nil
+	})
+}
+
+func (ds *Datastore) GetMDMAppleBootstrapPackageBytes(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackage, error) {
+	stmt := `
+          SELECT
+              bp.name,
+              bp.bytes,
+              bp.upload_id,
+              COALESCE(u.size, 0) AS size
+          FROM
+              mdm_apple_bootstrap_packages bp
+          LEFT JOIN mdm_apple_bootstrap_package_uploads u ON
+              u.id = bp.upload_id
+          WHERE
+              bp.token = ?`
+	var bp fleet.MDMAppleBootstrapPackage
+	if err := sqlx.GetContext(ctx, ds.reader, &bp, stmt, token); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ctxerr.Wrap(ctx, notFound("BootstrapPackage").WithMessage(token))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get bootstrap package bytes")
+	}
+	return &bp, nil
+}
+
+func (ds *Datastore) GetMDMAppleBootstrapPackageSummary(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackageSummary, error) {
+	stmt := `
+          SELECT
+              COUNT(IF(ncr.status = 'Acknowledged', 1, NULL)) AS installed,
+              COUNT(IF(ncr.status = 'Error', 1, NULL)) AS failed,
+              COUNT(IF(ncr.status IS NULL OR (ncr.status != 'Acknowledged' AND ncr.status != 'Error'), 1, NULL)) AS pending
+          FROM
+              hosts h
+          LEFT JOIN host_mdm_apple_bootstrap_packages hmabp ON
+              hmabp.host_uuid = h.uuid
+          LEFT JOIN nano_command_results ncr ON
+              ncr.command_uuid  = hmabp.command_uuid
+          JOIN host_mdm hm ON
+              hm.host_id = h.id
+          WHERE
+              hm.installed_from_dep = 1 AND COALESCE(h.team_id, 0) = ?`
+
+	var bp fleet.MDMAppleBootstrapPackageSummary
+	if err := sqlx.GetContext(ctx, ds.reader, &bp, stmt, teamID); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get bootstrap package summary")
+	}
+	return &bp, nil
+}
+
+// sqlVersionKey returns an SQL expression that converts the "major.minor.patch"
+// version string expr to a key that sorts in the same order as the versions
+// (e.g. "13.3.1" becomes "00013.00003.00001"). Missing parts count as 0.
+func sqlVersionKey(expr string) string {
+	v := fmt.Sprintf("CONCAT(%s, '.0.0')", expr)
+	return fmt.Sprintf(`CONCAT(
+      LPAD(SUBSTRING_INDEX(%[1]s, '.', 1), 5, '0'), '.',
+      LPAD(SUBSTRING_INDEX(SUBSTRING_INDEX(%[1]s, '.', 2), '.', -1), 5, '0'), '.',
+      LPAD(SUBSTRING_INDEX(SUBSTRING_INDEX(%[1]s, '.', 3), '.', -1), 5, '0'))`, v)
+}
+
+// hostOSUpdateStatusSelect selects the host_id, team_id, pending_updates and
+// the status (one of the fleet.OSUpdateStatus values) of each host that
+// reported its OS version, compared to the macOS updates settings of its team
+// (or the global settings for hosts with no team). Hosts of a team without
+// macOS updates settings are not selected. It takes the current time as single
+// parameter, to determine if the deadline has passed (Nudge enforces the update
+// at 04:00 UTC on the deadline date).
+var hostOSUpdateStatusSelect = fmt.Sprintf(`
+      SELECT
+          hou.host_id,
+          hh.team_id,
+          hou.pending_updates,
+          CASE
+              WHEN %s >= %s THEN '%s'
+              WHEN ? < TIMESTAMP(mu.deadline, '04:00:00') THEN '%s'
+              ELSE '%s'
+          END AS status
+      FROM
+          host_os_updates hou
+      JOIN hosts hh ON
+          hh.id = hou.host_id
+      JOIN (
+          SELECT
+              NULL AS team_id,
+              JSON_UNQUOTE(JSON_EXTRACT(json_value, '$.mdm.macos_updates.minimum_version')) AS minimum_version,
+              JSON_UNQUOTE(JSON_EXTRACT(json_value, '$.mdm.macos_updates.deadline')) AS deadline
+          FROM app_config_json
+          UNION ALL
+          SELECT
+              id AS team_id,
+              JSON_UNQUOTE(JSON_EXTRACT(config, '$.mdm.macos_updates.minimum_version')) AS minimum_version,
+              JSON_UNQUOTE(JSON_EXTRACT(config, '$.mdm.macos_updates.deadline')) AS deadline
+          FROM teams
+      ) mu ON
+          mu.team_id <=> hh.team_id
+      WHERE
+          COALESCE(mu.minimum_version, '') != '' AND
+          COALESCE(mu.deadline, '') != ''`,
+	sqlVersionKey("hou.os_version"), sqlVersionKey("mu.minimum_version"),
+	fleet.OSUpdateCompliant, fleet.OSUpdateDeferred, fleet.OSUpdateBehind,
+)
+
+func (ds *Datastore) GetMDMAppleOSUpdatesSummary(ctx context.Context, teamID uint) (*fleet.MDMAppleOSUpdatesSummary, error) {
+	stmt := fmt.Sprintf(`
+          SELECT
+              COUNT(IF(s.status = ?, 1, NULL)) AS compliant,
+              COUNT(IF(s.status = ?, 1, NULL)) AS deferred,
+              COUNT(IF(s.status = ?, 1, NULL)) AS behind,
+              COUNT(IF(s.pending_updates > 0, 1, NULL)) AS updates_available
+          FROM (%s) s
+          WHERE
+              COALESCE(s.team_id, 0) = ?`, hostOSUpdateStatusSelect)
+
+	var summary fleet.MDMAppleOSUpdatesSummary
+	if err := sqlx.GetContext(ctx, ds.reader, &summary, stmt,
+		fleet.OSUpdateCompliant, fleet.OSUpdateDeferred, fleet.OSUpdateBehind,
+		ds.clock.Now(), teamID); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get os updates summary")
+	}
+	return &summary, nil
+}
+
+func (ds *Datastore) RecordHostBootstrapPackage(ctx context.Context, commandUUID string, hostUUID string) error {
+	stmt := `INSERT INTO host_mdm_apple_bootstrap_packages (command_uuid, host_uuid) VALUES (?, ?)
+        ON DUPLICATE KEY UPDATE command_uuid = command_uuid`
+	_, err := ds.writer.ExecContext(ctx, stmt, commandUUID, hostUUID)
+	return ctxerr.Wrap(ctx, err, "record bootstrap package command")
+}
+
+const mdmAppleBootstrapPackageUploadColumns = `
+    id, token, team_id, name, size, sha256, received_bytes, chunks, created_at, updated_at`
+
+func (ds *Datastore) NewMDMAppleBootstrapPackageUpload(ctx context.Context, upload *fleet.MDMAppleBootstrapPackageUpload) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+	stmt := `
+          INSERT INTO mdm_apple_bootstrap_package_uploads (token, team_id, name, size, sha256)
+          VALUES (?, ?, ?, ?, ?)`
+
+	if _, err := ds.writer.ExecContext(ctx, stmt, upload.Token, upload.TeamID, upload.Name, upload.Size, upload.Sha256); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "create bootstrap package upload")
+	}
+	return getMDMAppleBootstrapPackageUploadDB(ctx, ds.writer, upload.Token, false)
+}
+
+func (ds *Datastore) GetMDMAppleBootstrapPackageUpload(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+	return getMDMAppleBootstrapPackageUploadDB(ctx, ds.reader, token, false)
+}
+
+func getMDMAppleBootstrapPackageUploadDB(ctx context.Context, q sqlx.QueryerContext, token string, forUpdate bool) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+	stmt := `SELECT ` + mdmAppleBootstrapPackageUploadColumns + ` FROM mdm_apple_bootstrap_package_uploads WHERE token = ?`
+	if forUpdate {
+		stmt += ` FOR UPDATE`
+	}
+
+	var upload fleet.MDMAppleBootstrapPackageUpload
+	if err := sqlx.GetContext(ctx, q, &upload, stmt, token); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ctxerr.Wrap(ctx, notFound("BootstrapPackageUpload").WithName(token))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get bootstrap package upload")
+	}
+	return &upload, nil
+}
+
+func (ds *Datastore) AppendMDMAppleBootstrapPackageUploadChunk(ctx context.Context, token string, offset int64, chunk []byte) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+	var upload *fleet.MDMAppleBootstrapPackageUpload
+	err := ds.withTx(ctx, func(tx sqlx.ExtContext) error {
+		var err error
+		// lock the upload row so that concurrent requests for the same session
+		// (e.g. a client retrying a request that timed out) are serialized and
+		// validated against the actual offset.
+		upload, err = getMDMAppleBootstrapPackageUploadDB(ctx, tx, token, true)
+		if err != nil {
+			return err
+		}
+		if upload.Offset != offset {
+			return ctxerr.Wrap(ctx, &fleet.MDMAppleBootstrapPackageUploadOffsetError{Expected: upload.Offset, Got: offset})
+		}
+
+		const insertStmt = `
+          INSERT INTO mdm_apple_bootstrap_package_upload_chunks (upload_id, chunk_index, bytes)
+          VALUES (?, ?, ?)`
+		if _, err := tx.ExecContext(ctx, insertStmt, upload.ID, upload.Chunks, chunk); err != nil {
+			return ctxerr.Wrap(ctx, err, "insert bootstrap package upload chunk")
+		}
+
+		const updateStmt = `
+          UPDATE mdm_apple_bootstrap_package_uploads
+          SET received_bytes = received_bytes + ?, chunks = chunks + 1
+          WHERE id = ?`
+		if _, err := tx.ExecContext(ctx, updateStmt, len(chunk), upload.ID); err != nil {
+			return ctxerr.Wrap(ctx, err, "update bootstrap package upload")
+		}
+
+		upload.Offset += int64(len(chunk))
+		upload.Chunks++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (ds *Datastore) GetMDMAppleBootstrapPackageUploadChunk(ctx context.Context, uploadID uint, index int) ([]byte, error) {
+	stmt := `SELECT bytes FROM mdm_apple_bootstrap_package_upload_chunks WHERE upload_id = ? AND chunk_index = ?`
+	// use the primary, the chunks are read to verify the upload right after
+	// they are written.
+	var chunk []byte
+	if err := sqlx.GetContext(ctx, ds.writer, &chunk, stmt, uploadID, index); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ctxerr.Wrap(ctx, notFound("BootstrapPackageUploadChunk").WithID(uint(index)))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get bootstrap package upload chunk")
+	}
+	return chunk, nil
+}
+
+func (ds *Datastore) CommitMDMAppleBootstrapPackageUpload(ctx context.Context, token string, pkgToken string) error {
+	return ds.withTx(ctx, func(tx sqlx.ExtContext) error {
+		upload, err := getMDMAppleBootstrapPackageUploadDB(ctx, tx, token, true)
+		if err != nil {
+			return err
+		}
+
+		// the package bytes are not copied, the package references the upload
+		// and its contents are read from the upload's chunks.
+		const stmt = `
+          INSERT INTO mdm_apple_bootstrap_packages (team_id, name, sha256, bytes, token, upload_id)
+          VALUES (?, ?, ?, NULL, ?, ?)`
+		if _, err := tx.ExecContext(ctx, stmt, upload.TeamID, upload.Name, upload.Sha256, pkgToken, upload.ID); err != nil {
+			if isDuplicate(err) {
+				return ctxerr.Wrap(ctx, alreadyExists("BootstrapPackage", fmt.Sprintf("for team %d", upload.TeamID)))
+			}
+			return ctxerr.Wrap(ctx, err, "create bootstrap package from upload")
+		}
+		return nil
+	})
+}
+
+func (ds *Datastore) CleanupMDMAppleBootstrapPackageUploads(ctx context.Context, now time.Time) (int64, error) {
+	// uploads referenced by a bootstrap package were committed and hold the
+	// package's contents, they are deleted along with the package.
+	stmt := `
+          DELETE u FROM mdm_apple_bootstrap_package_uploads u
+          LEFT JOIN mdm_apple_bootstrap_packages bp ON
+              bp.upload_id = u.id
+          WHERE
+              bp.upload_id IS NULL AND
+              u.updated_at < DATE_SUB(?, INTERVAL 24 HOUR)`
+
+	res, err := ds.writer.ExecContext(ctx, stmt, now)
+	if err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "cleanup bootstrap package uploads")
+	}
+	deleted, _ := res.RowsAffected()
+	return deleted, nil
+}
+
+func (ds *Datastore) ListMDMAppleAssetTokens(ctx context.Context) ([]string, error) {
+	stmt := `
+          SELECT token FROM mdm_apple_bootstrap_packages WHERE token IS NOT NULL
+          UNION ALL
+          SELECT token FROM eulas WHERE token IS NOT NULL`
+
+	var tokens []string
+	if err := sqlx.SelectContext(ctx, ds.reader, &tokens, stmt); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list mdm apple asset tokens")
+	}
+	return tokens, nil
+}
+
+func (ds *Datastore) GetHostMDMMacOSSetup(ctx context.Context, hostID uint) (*fleet.HostMDMMacOSSetup, error) {
+	stmt := `
+SELECT
+    CASE
+        WHEN ncr.status = 'Acknowledged' THEN ?
+        WHEN ncr.status = 'Error' THEN ?
+        ELSE ?
+    END AS bootstrap_package_status,
+    COALESCE(ncr.result, '') AS result,
+		mabs.name AS bootstrap_package_name
+FROM
+    hosts h
+JOIN host_mdm_apple_bootstrap_packages hmabp ON
+    hmabp.host_uuid = h.uuid
+LEFT JOIN nano_command_results ncr ON
+    ncr.command_uuid = hmabp.command_uuid
+JOIN host_mdm hm ON
+    hm.host_id = h.id
+JOIN mdm_apple_bootstrap_packages mabs ON
+		COALESCE(h.team_id, 0) = mabs.team_id
+WHERE
+    h.id = ? AND hm.installed_from_dep = 1`
+
+	args := []interface{}{fleet.MDMBootstrapPackageInstalled, fleet.MDMBootstrapPackageFailed, fleet.MDMBootstrapPackagePending, hostID}
+
+	var dest fleet.HostMDMMacOSSetup
+	if err := sqlx.GetContext(ctx, ds.reader, &dest, stmt, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ctxerr.Wrap(ctx, notFound("HostMDMMacOSSetup").WithID(hostID))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get host mdm macos setup")
+	}
+
+	if dest.BootstrapPackageStatus == fleet.MDMBootstrapPackageFailed {
+		decoded, err := mdm.DecodeCommandResults(dest.Result)
+		if err != nil {
+			dest.Detail = "Unable to decode command result"
+		} else {
+			dest.Detail = apple_mdm.FmtErrorChain(decoded.ErrorChain)
+		}
+	}
+	return &dest, nil
+}
+
+func (ds *Datastore) GetMDMAppleBootstrapPackageMeta(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackage, error) {
+	stmt := `
+          SELECT
+              team_id,
+              name,
+              sha256,
+              token,
+              created_at,
+              updated_at,
+              signature_status,
+              signature_detail,
+              signature_verified_at
+          FROM
+              mdm_apple_bootstrap_packages
+          WHERE
+              team_id = ?`
+	var bp fleet.MDMAppleBootstrapPackage
+	if err := sqlx.GetContext(ctx, ds.reader, &bp, stmt, teamID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ctxerr.Wrap(ctx, notFound("BootstrapPackage").WithID(teamID))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get bootstrap package meta")
+	}
+	return &bp, nil
+}
+
+func (ds *Datastore) ListMDMAppleBootstrapPackages(ctx context.Context) ([]*fleet.MDMAppleBootstrapPackage, error) {
+	stmt := `
+          SELECT
+              bp.team_id,
+              bp.name,
+              bp.sha256,
+              bp.token,
+              bp.created_at,
+              bp.updated_at,
+              bp.upload_id,
+              COALESCE(u.size, 0) AS size,
+              bp.signature_status,
+              bp.signature_detail,
+              bp.signature_verified_at
+          FROM
+              mdm_apple_bootstrap_packages bp
+          LEFT JOIN mdm_apple_bootstrap_package_uploads u ON
+              u.id = bp.upload_id
+          ORDER BY
+              bp.team_id`
+	var bps []*fleet.MDMAppleBootstrapPackage
+	if err := sqlx.SelectContext(ctx, ds.reader, &bps, stmt); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list bootstrap packages")
+	}
+	return bps, nil
+}
+
+func (ds *Datastore) SetMDMAppleBootstrapPackageSignatureStatus(ctx context.Context, token string, status fleet.MDMAppleBootstrapPackageSignatureStatus, detail string, verifiedAt time.Time) error {
+	stmt := `
+          UPDATE
+              mdm_apple_bootstrap_packages
+          SET
+              signature_status = ?,
+              signature_detail = NULLIF(?, ''),
+              signature_verified_at = ?
+          WHERE
+              token = ?`
+	// the package may have been deleted or replaced while it was being
+	// verified, in which case there's nothing to update.
+	if _, err := ds.writer.ExecContext(ctx, stmt, status, detail, verifiedAt, token); err != nil {
+		return ctxerr.Wrap(ctx, err, "set bootstrap package signature status")
+	}
+	return nil
+}
+
+func (ds *Datastore) CleanupDiskEncryptionKeysOnTeamChange(ctx context.Context, hostIDs []uint, newTeamID *uint) error {
+	return ds.withTx(ctx, func(tx sqlx.ExtContext) error {
+		return cleanupDiskEncryptionKeysOnTeamChangeDB(ctx, tx, hostIDs, newTeamID)
+	})
+}
+
+func cleanupDiskEncryptionKeysOnTeamChangeDB(ctx context.Context, tx sqlx.ExtContext, hostIDs []uint, newTeamID *uint) error {
+	_, err := getMDMAppleConfigProfileByTeamAndIdentifierDB(ctx, tx, newTeamID, mobileconfig.FleetFileVaultPayloadIdentifier)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			// the new team does not have a filevault profile so we need to delete the existing ones
+			if err := bulkDeleteHostDiskEncryptionKeysDB(ctx, tx, hostIDs); err != nil {
+				return ctxerr.Wrap(ctx, err, "reconcile filevault profiles on team change bulk delete host disk encryption keys")
+			}
+		} else {
+			return ctxerr.Wrap(ctx, err, "reconcile filevault profiles on team change get profile")
+		}
+	}
+	return nil
+}
+
+func getMDMAppleConfigProfileByTeamAndIdentifierDB(ctx context.Context, tx sqlx.QueryerContext, teamID *uint, profileIdentifier string) (*fleet.MDMAppleConfigProfile, error) {
+	if teamID == nil {
+		teamID = ptr.Uint(0)
+	}
+
+	stmt := `
+SELECT
+	profile_id,
+	team_id,
+	name,
+	identifier,
+	mobileconfig,
+	target_id,
+	min_macos_version,
+	max_macos_version,
+	created_at,
+	updated_at
+FROM
+	mdm_apple_configuration_profiles
+WHERE
+	team_id=? AND identifier=?`
+
+	var profile fleet.MDMAppleConfigProfile
+	err := sqlx.GetContext(ctx, tx, &profile, stmt, teamID, profileIdentifier)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &fleet.MDMAppleConfigProfile{}, ctxerr.Wrap(ctx, notFound("MDMAppleConfigProfile").WithName(profileIdentifier))
+		}
+		return &fleet.MDMAppleConfigProfile{}, ctxerr.Wrap(ctx, err, "get mdm apple config profile by team and identifier")
+	}
+	return &profile, nil
+}
+
+func bulkDeleteHostDiskEncryptionKeysDB(ctx context.Context, tx sqlx.ExtContext, hostIDs []uint) error {
+	if len(hostIDs) == 0 {
+		return nil
+	}
+
+	query, args, err := sqlx.In(
+		"DELETE FROM host_disk_encryption_keys WHERE host_id IN (?)",
+		hostIDs,
+	)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "building query")
+	}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (ds *Datastore) MDMAppleGetEULAMetadata(ctx context.Context) (*fleet.MDMAppleEULA, error) {
+	// Currently, there can only be one EULA in the database, and we're
+	// hardcoding it's id to be 1 in order to enforce this restriction.
+	stmt := "SELECT name, created_at, token, sha256 FROM eulas WHERE id = 1"
+	var eula fleet.MDMAppleEULA
+	if err := sqlx.GetContext(ctx, ds.reader, &eula, stmt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ctxerr.Wrap(ctx, notFound("MDMAppleEULA"))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get EULA metadata")
+	}
+	return &eula, nil
+}
+
+func (ds *Datastore) MDMAppleGetEULABytes(ctx context.Context, token string) (*fleet.MDMAppleEULA, error) {
+	stmt := "SELECT name, bytes FROM eulas WHERE token = ?"
+	var eula fleet.MDMAppleEULA
+	if err := sqlx.GetContext(ctx, ds.reader, &eula, stmt, token); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ctxerr.Wrap(ctx, notFound("MDMAppleEULA"))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get EULA bytes")
+	}
+	return &eula, nil
+}
+
+func (ds *Datastore) MDMAppleInsertEULA(ctx context.Context, eula *fleet.MDMAppleEULA) error {
+	// We're intentionally hardcoding the id to be 1 because we only want to
+	// allow one EULA.
+	stmt := `
+          INSERT INTO eulas (id, name, bytes, token, sha256)
+	  VALUES (1, ?, ?, ?, ?)
+	`
+
+	_, err := ds.writer.ExecContext(ctx, stmt, eula.Name, eula.Bytes, eula.Token, eula.Sha256)
+	if err != nil {
+		if isDuplicate(err) {
+			return ctxerr.Wrap(ctx, alreadyExists("MDMAppleEULA", eula.Token))
+		}
+		return ctxerr.Wrap(ctx, err, "create EULA")
+	}
+
+	return nil
+}
+
+func (ds *Datastore) MDMAppleDeleteEULA(ctx context.Context, token string) error {
+	stmt := "DELETE FROM eulas WHERE token = ?"
+	res, err := ds.writer.ExecContext(ctx, stmt, token)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "delete EULA")
+	}
+
+	deleted, _ := res.RowsAffected()
+	if deleted != 1 {
+		return ctxerr.Wrap(ctx, notFound("MDMAppleEULA"))
+	}
+	return nil
+}
+
+func (ds *Datastore) SetOrUpdateMDMAppleSetupAssistant(ctx context.Context, asst *fleet.MDMAppleSetupAssistant) (*fleet.MDMAppleSetupAssistant, error) {
+	const stmt = `
+		INSERT INTO
+			mdm_apple_setup_assistants (team_id, global_or_team_id, name, profile)
+		VALUES
+			(?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			updated_at = IF(profile = VALUES(profile) AND name = VALUES(name), updated_at, CURRENT_TIMESTAMP),
+			profile_uuid = IF(profile = VALUES(profile) AND name = VALUES(name), profile_uuid, ''),
+			name = VALUES(name),
+			profile = VALUES(profile)
+`
+	var globalOrTmID uint
+	if asst.TeamID != nil {
+		globalOrTmID = *asst.TeamID
+	}
+	_, err := ds.writer.ExecContext(ctx, stmt, asst.TeamID, globalOrTmID, asst.Name, asst.Profile)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "upsert mdm apple setup assistant")
+	}
+
+	// reload to return the proper timestamp and id
+	return ds.getMDMAppleSetupAssistant(ctx, ds.writer, asst.TeamID)
+}
+
+func (ds *Datastore) SetMDMAppleSetupAssistantProfileUUID(ctx context.Context, teamID *uint, profileUUID string) error {
+	const stmt = `
+	UPDATE
+		mdm_apple_setup_assistants
+	SET
+		profile_uuid = ?,
+		-- ensure updated_at does not change, as it is used to reflect the time
+		-- the setup assistant was uploaded, not when its profile was defined
+		-- with Apple's API.
+		updated_at = updated_at
+	WHERE global_or_team_id = ?`
+
+	var globalOrTmID uint
+	if teamID != nil {
+		globalOrTmID = *teamID
+	}
+	res, err := ds.writer.ExecContext(ctx, stmt, profileUUID, globalOrTmID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "set mdm apple setup assistant profile uuid")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ctxerr.Wrap(ctx, notFound("MDMAppleSetupAssistant").WithID(globalOrTmID))
+	}
+	return nil
+}
+
+func (ds *Datastore) GetMDMAppleSetupAssistant(ctx context.Context, teamID *uint) (*fleet.MDMAppleSetupAssistant, error) {
+	return ds.getMDMAppleSetupAssistant(ctx, ds.reader, teamID)
+}
+
+func (ds *Datastore) getMDMAppleSetupAssistant(ctx context.Context, q sqlx.QueryerContext, teamID *uint) (*fleet.MDMAppleSetupAssistant, error) {
+	const stmt = `
+	SELECT
+		id,
+		team_id,
+		name,
+		profile,
+		profile_uuid,
+		updated_at as uploaded_at
+	FROM
+		mdm_apple_setup_assistants
+	WHERE global_or_team_id = ?`
+
+	var asst fleet.MDMAppleSetupAssistant
+	var globalOrTmID uint
+	if teamID != nil {
+		globalOrTmID = *teamID
+	}
+	if err := sqlx.GetContext(ctx, q, &asst, stmt, globalOrTmID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ctxerr.Wrap(ctx, notFound("MDMAppleSetupAssistant").WithID(globalOrTmID))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get mdm apple setup assistant")
+	}
+	return &asst, nil
+}
+
+func (ds *Datastore) DeleteMDMAppleSetupAssistant(ctx context.Context, teamID *uint) error {
+	const stmt = `
+		DELETE FROM mdm_apple_setup_assistants
+		WHERE global_or_team_id = ?`
+
+	var globalOrTmID uint
+	if teamID != nil {
+		globalOrTmID = *teamID
+	}
+	_, err := ds.writer.ExecContext(ctx, stmt, globalOrTmID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "delete mdm apple setup assistant")
+	}
+	return nil
+}
+
+func (ds *Datastore) SetMDMAppleEnrollmentPendingApproval(ctx context.Context, hostUUID string) (bool, error) {
+	// INSERT IGNORE keeps the status of a host already pending or approved.
+	stmt := `INSERT IGNORE INTO host_mdm_apple_enrollment_approvals (host_uuid, status) VALUES (?, ?)`
+	res, err := ds.writer.ExecContext(ctx, stmt, hostUUID, fleet.MDMAppleEnrollmentApprovalPending)
+	if err != nil {
+		return false, ctxerr.Wrap(ctx, err, "set enrollment pending approval")
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (ds *Datastore) ApproveMDMAppleEnrollment(ctx context.Context, hostUUID string) error {
+	stmt := `
+          UPDATE host_mdm_apple_enrollment_approvals
+          SET status = ?, approved_at = NOW()
+          WHERE host_uuid = ? AND status = ?`
+	res, err := ds.writer.ExecContext(ctx, stmt, fleet.MDMAppleEnrollmentApprovalApproved, hostUUID, fleet.MDMAppleEnrollmentApprovalPending)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "approve enrollment")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ctxerr.Wrap(ctx, notFound("MDMApplePendingEnrollment").WithName(hostUUID))
+	}
+	return nil
+}
+
+// hostMDMAppleStateTables are the tables of the MDM state of a host that are
+// keyed by the host's uuid, in a host_uuid column.
+var hostMDMAppleStateTables = []string{
+	"host_mdm_apple_profiles",
+	"host_mdm_apple_bootstrap_packages",
+	"host_mdm_apple_certificates",
+	"host_mdm_apple_certificate_refreshes",
+	"host_mdm_apple_profile_list_refreshes",
+	"host_mdm_activation_lock_bypass_codes",
+}
+
+func (ds *Datastore) PurgeHostMDMAppleData(ctx context.Context, hostID uint, hostUUID string) error {
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		return purgeHostMDMAppleDataDB(ctx, tx, hostID, hostUUID)
+	})
+}
+
+func purgeHostMDMAppleDataDB(ctx context.Context, tx sqlx.ExtContext, hostID uint, hostUUID string) error {
+	for _, table := range hostMDMAppleStateTables {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM `%s` WHERE host_uuid = ?", table), hostUUID); err != nil {
+			return ctxerr.Wrapf(ctx, err, "deleting %s for host uuid %s", table, hostUUID)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM host_disk_encryption_keys WHERE host_id = ?`, hostID); err != nil {
+		return ctxerr.Wrap(ctx, err, "deleting disk encryption key of host")
+	}
+	const delQueueStmt = `
+          DELETE neq
+          FROM nano_enrollment_queue neq
+          JOIN nano_enrollments ne ON ne.id = neq.id
+          WHERE ne.device_id = ?`
+	if _, err := tx.ExecContext(ctx, delQueueStmt, hostUUID); err != nil {
+		return ctxerr.Wrap(ctx, err, "deleting queued commands of host")
+	}
+	return nil
+}
+
+func (ds *Datastore) ListMDMApplePendingEnrollments(ctx context.Context, filter fleet.TeamFilter) ([]*fleet.MDMApplePendingEnrollment, error) {
+	stmt := fmt.Sprintf(`
+          SELECT
+            h.id AS host_id,
+            h.uuid AS host_uuid,
+            h.hardware_serial,
+            COALESCE(NULLIF(h.computer_name, ''), h.hostname) AS host_display_name,
+            h.team_id,
+            hmaea.created_at AS enrolled_at
+          FROM host_mdm_apple_enrollment_approvals hmaea
+          JOIN hosts h ON h.uuid = hmaea.host_uuid
+          WHERE hmaea.status = ? AND %s
+          ORDER BY hmaea.created_at ASC, h.id ASC`, ds.whereFilterHostsByTeams(filter, "h"))
+
+	var pending []*fleet.MDMApplePendingEnrollment
+	if err := sqlx.SelectContext(ctx, ds.reader, &pending, stmt, fleet.MDMAppleEnrollmentApprovalPending); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list pending enrollments")
+	}
+	return pending, nil
+}
+
+func (ds *Datastore) FilterMDMAppleHostUUIDsPendingApproval(ctx context.Context, hostUUIDs []string) ([]string, error) {
+	if len(hostUUIDs) == 0 {
+		return nil, nil
+	}
+
+	stmt, args, err := sqlx.In(`
+          SELECT host_uuid
+          FROM host_mdm_apple_enrollment_approvals
+          WHERE status = ? AND host_uuid IN (?)`, fleet.MDMAppleEnrollmentApprovalPending, hostUUIDs)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "prepare hosts pending approval query")
+	}
+	var uuids []string
+	if err := sqlx.SelectContext(ctx, ds.reader, &uuids, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "filter hosts pending approval")
+	}
+	return uuids, nil
+}
+
+func (ds *Datastore) UpsertMDMAppleBlockedEnrollment(ctx context.Context, blocked *fleet.MDMAppleBlockedEnrollment) error {
+	// updated_at is always set so that the record reflects the latest check,
+	// even if nothing else changed.
+	stmt := `
+          INSERT INTO mdm_apple_blocked_enrollments
+            (serial_number, host_uuid, team_id, hardware_model, source, action, reason)
+          VALUES
+            (?, ?, ?, ?, ?, ?, ?)
+          ON DUPLICATE KEY UPDATE
+            host_uuid = IF(VALUES(host_uuid) = '', host_uuid, VALUES(host_uuid)),
+            team_id = VALUES(team_id),
+            hardware_model = IF(VALUES(hardware_model) = '', hardware_model, VALUES(hardware_model)),
+            source = VALUES(source),
+            action = VALUES(action),
+            reason = VALUES(reason),
+            updated_at = CURRENT_TIMESTAMP`
+	if _, err := ds.writer.ExecContext(ctx, stmt, blocked.SerialNumber, blocked.HostUUID, blocked.TeamID,
+		blocked.HardwareModel, blocked.Source, blocked.Action, blocked.Reason); err != nil {
+		return ctxerr.Wrap(ctx, err, "upsert blocked enrollment")
+	}
+	return nil
+}
+
+func (ds *Datastore) DeleteMDMAppleBlockedEnrollments(ctx context.Context, serials []string) error {
+	if len(serials) == 0 {
+		return nil
+	}
+
+	stmt, args, err := sqlx.In(`DELETE FROM mdm_apple_blocked_enrollments WHERE serial_number IN (?)`, serials)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "prepare delete blocked enrollments query")
+	}
+	if _, err := ds.writer.ExecContext(ctx, stmt, args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "delete blocked enrollments")
+	}
+	return nil
+}
+
+func (ds *Datastore) ListMDMAppleBlockedEnrollments(ctx context.Context, filter fleet.TeamFilter) ([]*fleet.MDMAppleBlockedEnrollment, error) {
+	stmt := fmt.Sprintf(`
+          SELECT
+            mabe.serial_number,
+            mabe.host_uuid,
+            mabe.team_id,
+            mabe.hardware_model,
+            mabe.source,
+            mabe.action,
+            mabe.reason,
+            mabe.updated_at
+          FROM mdm_apple_blocked_enrollments mabe
+          WHERE %s
+          ORDER BY mabe.updated_at DESC, mabe.serial_number ASC`, ds.whereFilterHostsByTeams(filter, "mabe"))
+
+	var blocked []*fleet.MDMAppleBlockedEnrollment
+	if err := sqlx.SelectContext(ctx, ds.reader, &blocked, stmt); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list blocked enrollments")
+	}
+	return blocked, nil
+}
+
+const mdmAppleProfileChangeColumns = `
+    id, profile_id, profile_identifier, profile_name, checksum, operation_type,
+    host_count, status, created_at, expires_at, reviewed_at`
+
+func (ds *Datastore) RequestMDMAppleProfileChanges(ctx context.Context, changes []*fleet.MDMAppleProfileChange, expiresAt time.Time) ([]*fleet.MDMAppleProfileChange, error) {
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	changeKey := func(c *fleet.MDMAppleProfileChange) string {
+		return fmt.Sprintf("%d\x00%s\x00%s", c.ProfileID, c.OperationType, c.Checksum)
+	}
+	profileIDs := make([]uint, 0, len(changes))
+	seen := make(map[uint]bool, len(changes))
+	for _, c := range changes {
+		if !seen[c.ProfileID] {
+			seen[c.ProfileID] = true
+			profileIDs = append(profileIDs, c.ProfileID)
+		}
+	}
+
+	const insertStmt = `
+          INSERT INTO mdm_apple_profile_changes
+            (profile_id, profile_identifier, profile_name, checksum, operation_type, host_count, status, expires_at)
+          VALUES
+            (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	var created []*fleet.MDMAppleProfileChange
+	err := ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		created = nil
+
+		stmt, args, err := sqlx.In(`
+          SELECT `+mdmAppleProfileChangeColumns+`
+          FROM mdm_apple_profile_changes
+          WHERE profile_id IN (?) AND status != ?`, profileIDs, fleet.MDMAppleProfileChangeExpired)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "prepare profile changes query")
+		}
+		var existing []*fleet.MDMAppleProfileChange
+		if err := sqlx.SelectContext(ctx, tx, &existing, stmt, args...); err != nil {
+			return ctxerr.Wrap(ctx, err, "select profile changes")
+		}
+		byKey := make(map[string]*fleet.MDMAppleProfileChange, len(existing))
+		for _, e := range existing {
+			byKey[changeKey(e)] = e
+		}
+
+		for _, c := range changes {
+			if e, ok := byKey[changeKey(c)]; ok {
+				if e.Status == fleet.MDMAppleProfileChangePending && e.HostCount != c.HostCount {
+					if _, err := tx.ExecContext(ctx, `UPDATE mdm_apple_profile_changes SET host_count = ? WHERE id = ?`, c.HostCount, e.ID); err != nil {
+						return ctxerr.Wrap(ctx, err, "update profile change host count")
+					}
+				}
+				c.ID, c.Status, c.CreatedAt, c.ExpiresAt, c.ReviewedAt = e.ID, e.Status, e.CreatedAt, e.ExpiresAt, e.ReviewedAt
+				continue
+			}
+
+			res, err := tx.ExecContext(ctx, insertStmt, c.ProfileID, c.ProfileIdentifier, c.ProfileName, c.Checksum,
+				c.OperationType, c.HostCount, fleet.MDMAppleProfileChangePending, expiresAt)
+			if err != nil {
+				return ctxerr.Wrap(ctx, err, "insert profile change")
+			}
+			id, _ := res.LastInsertId()
+			c.ID = uint(id)
+			c.Status = fleet.MDMAppleProfileChangePending
+			c.CreatedAt = time.Now().UTC()
+			c.ExpiresAt = expiresAt
+			c.ReviewedAt = nil
+			created = append(created, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "request profile changes")
+	}
+	return created, nil
+}
+
+func (ds *Datastore) ExpireMDMAppleProfileChanges(ctx context.Context) error {
+	stmt := `
+          UPDATE mdm_apple_profile_changes
+          SET status = ?
+          WHERE status = ? AND expires_at <= NOW()`
+	if _, err := ds.writer.ExecContext(ctx, stmt, fleet.MDMAppleProfileChangeExpired, fleet.MDMAppleProfileChangePending); err != nil {
+		return ctxerr.Wrap(ctx, err, "expire profile changes")
+	}
+	return nil
+}
 
+func (ds *Datastore) ListMDMAppleProfileChanges(ctx context.Context, status fleet.MDMAppleProfileChangeStatus) ([]*fleet.MDMAppleProfileChange, error) {
+	stmt := `SELECT ` + mdmAppleProfileChangeColumns + ` FROM mdm_apple_profile_changes`
 	var args []interface{}
-	subqueryVerifying, subqueryVerifyingArgs := subqueryDiskEncryptionVerifying()
-	args = append(args, subqueryVerifyingArgs...)
-	subqueryActionRequired, subqueryActionRequiredArgs := subqueryDiskEncryptionActionRequired()
-	args = append(args, subqueryActionRequiredArgs...)
-	subqueryEnforcing, subqueryEnforcingArgs := subqueryDiskEncryptionEnforcing()
-	args = append(args, subqueryEnforcingArgs...)
-	subqueryFailed, subqueryFailedArgs := subqueryDiskEncryptionFailed()
-	args = append(args, subqueryFailedArgs...)
-	subqueryRemovingEnforcement, subqueryRemovingEnforcementArgs := subqueryDiskEncryptionRemovingEnforcement()
-	args = append(args, subqueryRemovingEnforcementArgs...)
-
-	teamFilter := "h.team_id IS NULL"
-	if teamID != nil && *teamID > 0 {
-		teamFilter = "h.team_id = ?"
-		args = append(args, *teamID)
+	if status != "" {
+		stmt += ` WHERE status = ?`
+		args = append(args, status)
 	}
+	stmt += ` ORDER BY created_at DESC, id DESC`
 
-	stmt := fmt.Sprintf(sqlFmt, subqueryVerifying, subqueryActionRequired, subqueryEnforcing, subqueryFailed, subqueryRemovingEnforcement, teamFilter)
+	changes := []*fleet.MDMAppleProfileChange{}
+	if err := sqlx.SelectContext(ctx, ds.reader, &changes, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list profile changes")
+	}
+	return changes, nil
+}
 
-	var res fleet.MDMAppleFileVaultSummary
-	err := sqlx.GetContext(ctx, ds.reader, &res, stmt, args...)
+func (ds *Datastore) ReviewMDMAppleProfileChange(ctx context.Context, id uint, status fleet.MDMAppleProfileChangeStatus) (*fleet.MDMAppleProfileChange, error) {
+	stmt := `
+          UPDATE mdm_apple_profile_changes
+          SET status = ?, reviewed_at = NOW()
+          WHERE id = ? AND status = ? AND expires_at > NOW()`
+	res, err := ds.writer.ExecContext(ctx, stmt, status, id, fleet.MDMAppleProfileChangePending)
 	if err != nil {
-		return nil, err
+		return nil, ctxerr.Wrap(ctx, err, "review profile change")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ctxerr.Wrap(ctx, notFound("MDMAppleProfileChange").WithID(id))
 	}
 
-	return &res, nil
+	var change fleet.MDMAppleProfileChange
+	if err := sqlx.GetContext(ctx, ds.writer, &change, `SELECT `+mdmAppleProfileChangeColumns+` FROM mdm_apple_profile_changes WHERE id = ?`, id); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get reviewed profile change")
+	}
+	return &change, nil
 }
 
-func (ds *Datastore) BulkUpsertMDMAppleConfigProfiles(ctx context.Context, payload []*fleet.MDMAppleConfigProfile) error {
-	if len(payload) == 0 {
+func (ds *Datastore) ListMDMAppleHostUUIDsToRefreshCertificates(ctx context.Context, interval time.Duration, limit int) ([]string, error) {
+	stmt := `
+          SELECT
+            h.uuid
+          FROM hosts h
+          JOIN nano_enrollments ne ON ne.device_id = h.uuid
+          LEFT JOIN host_mdm_apple_certificate_refreshes hmacr ON hmacr.host_uuid = h.uuid
+          WHERE
+            h.platform = 'darwin' AND
+            ne.enabled = 1 AND
+            ne.type = 'Device' AND
+            ` + mdmAppleHostNotPendingApprovalCond + ` AND
+            (hmacr.requested_at IS NULL OR hmacr.requested_at < DATE_SUB(NOW(), INTERVAL ? SECOND))
+          ORDER BY hmacr.requested_at ASC
+          LIMIT ?`
+
+	var uuids []string
+	if err := sqlx.SelectContext(ctx, ds.reader, &uuids, stmt, int(interval.Seconds()), limit); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list host uuids to refresh certificates")
+	}
+	return uuids, nil
+}
+
+func (ds *Datastore) SetMDMAppleHostCertificatesRefreshRequested(ctx context.Context, hostUUIDs []string) error {
+	if len(hostUUIDs) == 0 {
 		return nil
 	}
 
-	var args []any
-	var sb strings.Builder
-	for _, cp := range payload {
-		var teamID uint
-		if cp.TeamID != nil {
-			teamID = *cp.TeamID
-		}
+	stmt := `
+          INSERT INTO host_mdm_apple_certificate_refreshes (host_uuid, requested_at)
+          VALUES %s
+          ON DUPLICATE KEY UPDATE requested_at = VALUES(requested_at)`
 
-		args = append(args, teamID, cp.Identifier, cp.Name, cp.Mobileconfig)
-		sb.WriteString("(?, ?, ?, ?, UNHEX(MD5(mobileconfig))),")
+	values := strings.TrimSuffix(strings.Repeat("(?, NOW()),", len(hostUUIDs)), ",")
+	args := make([]interface{}, 0, len(hostUUIDs))
+	for _, uuid := range hostUUIDs {
+		args = append(args, uuid)
+	}
+	if _, err := ds.writer.ExecContext(ctx, fmt.Sprintf(stmt, values), args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "set host certificates refresh requested")
 	}
+	return nil
+}
 
-	stmt := fmt.Sprintf(`
-          INSERT INTO
-              mdm_apple_configuration_profiles (team_id, identifier, name, mobileconfig, checksum)
-          VALUES %s
-          ON DUPLICATE KEY UPDATE
-            mobileconfig = VALUES(mobileconfig),
-	    checksum = UNHEX(MD5(VALUES(mobileconfig)))`, strings.TrimSuffix(sb.String(), ","))
+func (ds *Datastore) ListMDMAppleHostUUIDsToRefreshProfileList(ctx context.Context, interval time.Duration, limit int) ([]string, error) {
+	stmt := `
+          SELECT
+            h.uuid
+          FROM hosts h
+          JOIN nano_enrollments ne ON ne.device_id = h.uuid
+          LEFT JOIN host_mdm_apple_profile_list_refreshes hmaplr ON hmaplr.host_uuid = h.uuid
+          WHERE
+            h.platform = 'darwin' AND
+            ne.enabled = 1 AND
+            ne.type = 'Device' AND
+            EXISTS (
+              SELECT 1 FROM host_mdm_apple_profiles hmap
+              WHERE hmap.host_uuid = h.uuid AND hmap.operation_type = ?
+            ) AND
+            (hmaplr.requested_at IS NULL OR hmaplr.requested_at < DATE_SUB(NOW(), INTERVAL ? SECOND))
+          ORDER BY hmaplr.requested_at ASC
+          LIMIT ?`
+
+	var uuids []string
+	if err := sqlx.SelectContext(ctx, ds.reader, &uuids, stmt, fleet.MDMAppleOperationTypeInstall, int(interval.Seconds()), limit); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list host uuids to refresh profile list")
+	}
+	return uuids, nil
+}
 
-	if _, err := ds.writer.ExecContext(ctx, stmt, args...); err != nil {
-		return ctxerr.Wrapf(ctx, err, "upsert mdm config profiles")
+func (ds *Datastore) SetMDMAppleHostProfileListRefreshRequested(ctx context.Context, hostUUIDs []string) error {
+	if len(hostUUIDs) == 0 {
+		return nil
 	}
 
+	stmt := `
+          INSERT INTO host_mdm_apple_profile_list_refreshes (host_uuid, requested_at)
+          VALUES %s
+          ON DUPLICATE KEY UPDATE requested_at = VALUES(requested_at)`
+
+	values := strings.TrimSuffix(strings.Repeat("(?, NOW()),", len(hostUUIDs)), ",")
+	args := make([]interface{}, 0, len(hostUUIDs))
+	for _, uuid := range hostUUIDs {
+		args = append(args, uuid)
+	}
+	if _, err := ds.writer.ExecContext(ctx, fmt.Sprintf(stmt, values), args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "set host profile list refresh requested")
+	}
 	return nil
 }
 
-func (ds *Datastore) InsertMDMAppleBootstrapPackage(ctx context.Context, bp *fleet.MDMAppleBootstrapPackage) error {
+func (ds *Datastore) ListHostMDMAppleProfileInstalls(ctx context.Context, hostUUID string) ([]*fleet.HostMDMAppleProfileInstall, error) {
 	stmt := `
-          INSERT INTO mdm_apple_bootstrap_packages (team_id, name, sha256, bytes, token)
-	  VALUES (?, ?, ?, ?, ?)
-	`
+          SELECT
+            hmap.profile_id,
+            hmap.profile_identifier,
+            hmap.status,
+            hmap.installed_payload_uuid,
+            macp.mobileconfig
+          FROM host_mdm_apple_profiles hmap
+          JOIN mdm_apple_configuration_profiles macp ON macp.profile_id = hmap.profile_id
+          WHERE
+            hmap.host_uuid = ? AND
+            hmap.operation_type = ?`
 
-	_, err := ds.writer.ExecContext(ctx, stmt, bp.TeamID, bp.Name, bp.Sha256, bp.Bytes, bp.Token)
-	if err != nil {
-		if isDuplicate(err) {
-			return ctxerr.Wrap(ctx, alreadyExists("BootstrapPackage", fmt.Sprintf("for team %d", bp.TeamID)))
-		}
-		return ctxerr.Wrap(ctx, err, "create bootstrap pacckage")
+	var profiles []*fleet.HostMDMAppleProfileInstall
+	if err := sqlx.SelectContext(ctx, ds.reader, &profiles, stmt, hostUUID, fleet.MDMAppleOperationTypeInstall); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list host profile installs")
 	}
+	return profiles, nil
+}
 
-	return nil
+func (ds *Datastore) UpdateHostMDMAppleProfilesInstalledPayloadUUIDs(ctx context.Context, hostUUID string, payloadUUIDs map[uint]string) error {
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		const clearStmt = `UPDATE host_mdm_apple_profiles SET installed_payload_uuid = NULL WHERE host_uuid = ?`
+		if _, err := tx.ExecContext(ctx, clearStmt, hostUUID); err != nil {
+			return ctxerr.Wrap(ctx, err, "clear host installed payload uuids")
+		}
+
+		const updateStmt = `UPDATE host_mdm_apple_profiles SET installed_payload_uuid = ? WHERE host_uuid = ? AND profile_id = ?`
+		for profileID, payloadUUID := range payloadUUIDs {
+			if _, err := tx.ExecContext(ctx, updateStmt, payloadUUID, hostUUID, profileID); err != nil {
+				return ctxerr.Wrap(ctx, err, "update host installed payload uuid")
+			}
+		}
+		return nil
+	})
 }
 
-func (ds *Datastore) DeleteMDMAppleBootstrapPackage(ctx context.Context, teamID uint) error {
-	stmt := "DELETE FROM mdm_apple_bootstrap_packages WHERE team_id = ?"
-	res, err := ds.writer.ExecContext(ctx, stmt, teamID)
-	if err != nil {
-		return ctxerr.Wrap(ctx, err, "delete bootstrap package")
+func (ds *Datastore) SetHostMDMAppleProfilesToReinstall(ctx context.Context, hostUUID string, profileIDs []uint) error {
+	if len(profileIDs) == 0 {
+		return nil
 	}
 
-	deleted, _ := res.RowsAffected()
-	if deleted != 1 {
-		return ctxerr.Wrap(ctx, notFound("BootstrapPackage").WithID(teamID))
+	stmt, args, err := sqlx.In(`
+          UPDATE host_mdm_apple_profiles
+          SET status = NULL, detail = ''
+          WHERE host_uuid = ? AND operation_type = ? AND profile_id IN (?)`,
+		hostUUID, fleet.MDMAppleOperationTypeInstall, profileIDs)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "build reinstall host profiles statement")
+	}
+	if _, err := ds.writer.ExecContext(ctx, stmt, args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "set host profiles to reinstall")
 	}
 	return nil
 }
 
-func (ds *Datastore) GetMDMAppleBootstrapPackageBytes(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackage, error) {
-	stmt := "SELECT name, bytes FROM mdm_apple_bootstrap_packages WHERE token = ?"
-	var bp fleet.MDMAppleBootstrapPackage
-	if err := sqlx.GetContext(ctx, ds.reader, &bp, stmt, token); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ctxerr.Wrap(ctx, notFound("BootstrapPackage").WithMessage(token))
+func (ds *Datastore) ReplaceHostMDMAppleCertificates(ctx context.Context, hostUUID string, certs []*fleet.HostMDMCertificate) error {
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		sums := make([]string, 0, len(certs))
+		for _, cert := range certs {
+			sums = append(sums, cert.SHA1Sum)
 		}
-		return nil, ctxerr.Wrap(ctx, err, "get bootstrap package bytes")
-	}
-	return &bp, nil
+
+		// delete the certificates that are not installed anymore
+		delStmt := `DELETE FROM host_mdm_apple_certificates WHERE host_uuid = ?`
+		delArgs := []interface{}{hostUUID}
+		if len(sums) > 0 {
+			stmt, args, err := sqlx.In(delStmt+` AND sha1_sum NOT IN (?)`, hostUUID, sums)
+			if err != nil {
+				return ctxerr.Wrap(ctx, err, "build delete host certificates statement")
+			}
+			delStmt, delArgs = stmt, args
+		}
+		if _, err := tx.ExecContext(ctx, delStmt, delArgs...); err != nil {
+			return ctxerr.Wrap(ctx, err, "delete host certificates")
+		}
+
+		// the origin of the existing certificates is kept, it is set when the
+		// list of managed certificates is received.
+		return upsertHostMDMAppleCertificatesDB(ctx, tx, hostUUID, certs, "")
+	})
 }
 
-func (ds *Datastore) GetMDMAppleBootstrapPackageSummary(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackageSummary, error) {
+func (ds *Datastore) UpdateHostMDMAppleManagedCertificates(ctx context.Context, hostUUID string, certs []*fleet.HostMDMCertificate) error {
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		resetStmt := `UPDATE host_mdm_apple_certificates SET origin = ? WHERE host_uuid = ?`
+		if _, err := tx.ExecContext(ctx, resetStmt, fleet.HostMDMCertificateOriginOther, hostUUID); err != nil {
+			return ctxerr.Wrap(ctx, err, "reset host certificates origin")
+		}
+		return upsertHostMDMAppleCertificatesDB(ctx, tx, hostUUID, certs, fleet.HostMDMCertificateOriginMDM)
+	})
+}
+
+// upsertHostMDMAppleCertificatesDB inserts or updates the certificates of the
+// host. If origin is empty, new certificates are inserted with the "other"
+// origin and the origin of existing ones is left unchanged.
+func upsertHostMDMAppleCertificatesDB(ctx context.Context, tx sqlx.ExtContext, hostUUID string, certs []*fleet.HostMDMCertificate, origin fleet.HostMDMCertificateOrigin) error {
+	if len(certs) == 0 {
+		return nil
+	}
+
 	stmt := `
+          INSERT INTO host_mdm_apple_certificates
+            (host_uuid, sha1_sum, common_name, issuer, serial_number, not_valid_before, not_valid_after, is_identity, origin)
+          VALUES %s
+          ON DUPLICATE KEY UPDATE
+            common_name = VALUES(common_name),
+            issuer = VALUES(issuer),
+            serial_number = VALUES(serial_number),
+            not_valid_before = VALUES(not_valid_before),
+            not_valid_after = VALUES(not_valid_after),
+            is_identity = VALUES(is_identity)`
+	insertOrigin := origin
+	if origin == "" {
+		insertOrigin = fleet.HostMDMCertificateOriginOther
+	} else {
+		stmt += `,
+            origin = VALUES(origin)`
+	}
+
+	values := strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?, ?, ?, ?, ?, ?),", len(certs)), ",")
+	args := make([]interface{}, 0, len(certs)*9)
+	for _, cert := range certs {
+		args = append(args, hostUUID, cert.SHA1Sum, cert.CommonName, cert.Issuer, cert.SerialNumber,
+			cert.NotValidBefore, cert.NotValidAfter, cert.IsIdentity, insertOrigin)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(stmt, values), args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "upsert host certificates")
+	}
+	return nil
+}
+
+func (ds *Datastore) ListHostMDMAppleCertificates(ctx context.Context, hostUUID string, opt fleet.ListOptions) ([]*fleet.HostMDMCertificate, *fleet.PaginationMetadata, error) {
+	query := `
           SELECT
-              COUNT(IF(ncr.status = 'Acknowledged', 1, NULL)) AS installed,
-              COUNT(IF(ncr.status = 'Error', 1, NULL)) AS failed,
-              COUNT(IF(ncr.status IS NULL OR (ncr.status != 'Acknowledged' AND ncr.status != 'Error'), 1, NULL)) AS pending
+            id, host_uuid, sha1_sum, common_name, issuer, serial_number, not_valid_before,
+            not_valid_after, is_identity, origin, updated_at
           FROM
-              hosts h
-          LEFT JOIN host_mdm_apple_bootstrap_packages hmabp ON
-              hmabp.host_uuid = h.uuid
-          LEFT JOIN nano_command_results ncr ON
-              ncr.command_uuid  = hmabp.command_uuid
-          JOIN host_mdm hm ON
-              hm.host_id = h.id
-          WHERE
-              hm.installed_from_dep = 1 AND COALESCE(h.team_id, 0) = ?`
+            host_mdm_apple_certificates
+          WHERE host_uuid = ?`
 
-	var bp fleet.MDMAppleBootstrapPackageSummary
-	if err := sqlx.GetContext(ctx, ds.reader, &bp, stmt, teamID); err != nil {
-		return nil, ctxerr.Wrap(ctx, err, "get bootstrap package summary")
+	if opt.OrderKey == "" {
+		opt.OrderKey = "not_valid_after"
 	}
-	return &bp, nil
-}
+	opt.IncludeMetadata = true
+	query, args := appendListOptionsWithCursorToSQL(query, []interface{}{hostUUID}, &opt)
 
-func (ds *Datastore) RecordHostBootstrapPackage(ctx context.Context, commandUUID string, hostUUID string) error {
-	stmt := `INSERT INTO host_mdm_apple_bootstrap_packages (command_uuid, host_uuid) VALUES (?, ?)
-        ON DUPLICATE KEY UPDATE command_uuid = command_uuid`
-	_, err := ds.writer.ExecContext(ctx, stmt, commandUUID, hostUUID)
-	return ctxerr.Wrap(ctx, err, "record bootstrap package command")
+	certs := []*fleet.HostMDMCertificate{}
+	if err := sqlx.SelectContext(ctx, ds.reader, &certs, query, args...); err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list host certificates")
+	}
+
+	metaData := &fleet.PaginationMetadata{HasPreviousResults: opt.Page > 0}
+	if len(certs) > int(opt.PerPage) {
+		metaData.HasNextResults = true
+		certs = certs[:len(certs)-1]
+	}
+	return certs, metaData, nil
 }
 
-func (ds *Datastore) GetHostMDMMacOSSetup(ctx context.Context, hostID uint) (*fleet.HostMDMMacOSSetup, error) {
-	stmt := `
-SELECT
-    CASE
-        WHEN ncr.status = 'Acknowledged' THEN ?
-        WHEN ncr.status = 'Error' THEN ?
-        ELSE ?
-    END AS bootstrap_package_status,
-    COALESCE(ncr.result, '') AS result,
-		mabs.name AS bootstrap_package_name
-FROM
-    hosts h
-JOIN host_mdm_apple_bootstrap_packages hmabp ON
-    hmabp.host_uuid = h.uuid
-LEFT JOIN nano_command_results ncr ON
-    ncr.command_uuid = hmabp.command_uuid
-JOIN host_mdm hm ON
-    hm.host_id = h.id
-JOIN mdm_apple_bootstrap_packages mabs ON
-		COALESCE(h.team_id, 0) = mabs.team_id
-WHERE
-    h.id = ? AND hm.installed_from_dep = 1`
+func (ds *Datastore) ListMDMAppleSCEPCertificates(ctx context.Context, filter fleet.TeamFilter, opt fleet.MDMAppleSCEPCertificateListOptions) ([]*fleet.MDMAppleSCEPCertificate, *fleet.PaginationMetadata, error) {
+	// the certificates are selected in a derived table so that the list options
+	// can filter and order by its columns without ambiguity.
+	query := fmt.Sprintf(`
+          SELECT
+            serial, name, sha256, not_valid_before, not_valid_after, revoked, host_uuid,
+            host_id, hostname, renewed_from_serial, renewed_by_serial, created_at
+          FROM (
+            SELECT
+              sc.serial,
+              sc.name,
+              sc.sha256,
+              sc.not_valid_before,
+              sc.not_valid_after,
+              sc.revoked,
+              sc.host_uuid,
+              h.id AS host_id,
+              h.hostname,
+              sc.renewed_from_serial,
+              nsc.serial AS renewed_by_serial,
+              sc.created_at
+            FROM
+              scep_certificates sc
+              LEFT JOIN hosts h ON h.uuid = sc.host_uuid
+              LEFT JOIN scep_certificates nsc ON nsc.renewed_from_serial = sc.serial
+            WHERE
+              %s
+          ) c
+          WHERE TRUE`, ds.whereFilterHostsByTeams(filter, "h"))
 
-	args := []interface{}{fleet.MDMBootstrapPackageInstalled, fleet.MDMBootstrapPackageFailed, fleet.MDMBootstrapPackagePending, hostID}
+	var args []interface{}
+	if opt.HostID != nil {
+		query += ` AND host_id = ?`
+		args = append(args, *opt.HostID)
+	}
+	if opt.ExpiresWithinDays != nil {
+		query += ` AND not_valid_after > NOW() AND not_valid_after <= DATE_ADD(NOW(), INTERVAL ? DAY)`
+		args = append(args, *opt.ExpiresWithinDays)
+	}
+	if opt.ExcludeRenewed {
+		query += ` AND renewed_by_serial IS NULL`
+	}
+	query, args = searchLike(query, args, opt.MatchQuery, "hostname", "host_uuid", "sha256")
 
-	var dest fleet.HostMDMMacOSSetup
-	if err := sqlx.GetContext(ctx, ds.reader, &dest, stmt, args...); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ctxerr.Wrap(ctx, notFound("HostMDMMacOSSetup").WithID(hostID))
-		}
-		return nil, ctxerr.Wrap(ctx, err, "get host mdm macos setup")
+	if opt.OrderKey == "" {
+		opt.OrderKey = "not_valid_after"
 	}
+	opt.IncludeMetadata = true
+	query, args = appendListOptionsWithCursorToSQL(query, args, &opt.ListOptions)
 
-	if dest.BootstrapPackageStatus == fleet.MDMBootstrapPackageFailed {
-		decoded, err := mdm.DecodeCommandResults(dest.Result)
-		if err != nil {
-			dest.Detail = "Unable to decode command result"
-		} else {
-			dest.Detail = apple_mdm.FmtErrorChain(decoded.ErrorChain)
-		}
+	certs := []*fleet.MDMAppleSCEPCertificate{}
+	if err := sqlx.SelectContext(ctx, ds.reader, &certs, query, args...); err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list scep certificates")
 	}
-	return &dest, nil
-}
 
-func (ds *Datastore) GetMDMAppleBootstrapPackageMeta(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackage, error) {
-	stmt := "SELECT team_id, name, sha256, token, created_at, updated_at FROM mdm_apple_bootstrap_packages WHERE team_id = ?"
-	var bp fleet.MDMAppleBootstrapPackage
-	if err := sqlx.GetContext(ctx, ds.reader, &bp, stmt, teamID); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ctxerr.Wrap(ctx, notFound("BootstrapPackage").WithID(teamID))
-		}
-		return nil, ctxerr.Wrap(ctx, err, "get bootstrap package meta")
+	metaData := &fleet.PaginationMetadata{HasPreviousResults: opt.Page > 0}
+	if len(certs) > int(opt.PerPage) {
+		metaData.HasNextResults = true
+		certs = certs[:len(certs)-1]
 	}
-	return &bp, nil
+	return certs, metaData, nil
 }
 
-func (ds *Datastore) CleanupDiskEncryptionKeysOnTeamChange(ctx context.Context, hostIDs []uint, newTeamID *uint) error {
-	return ds.withTx(ctx, func(tx sqlx.ExtContext) error {
-		return cleanupDiskEncryptionKeysOnTeamChangeDB(ctx, tx, hostIDs, newTeamID)
-	})
+func (ds *Datastore) ListMDMAppleSCEPIdentityCertificates(ctx context.Context, afterSerial int64, limit uint) ([]*fleet.MDMAppleSCEPIdentityCertificate, error) {
+	// the identity of a host is the latest certificate it authenticated with,
+	// i.e. the one that wasn't renewed by another certificate.
+	const stmt = `
+    SELECT
+      sc.serial,
+      sc.host_uuid,
+      sc.certificate_pem
+    FROM
+      scep_certificates sc
+      JOIN nano_enrollments ne ON ne.id = sc.host_uuid
+    WHERE
+      ne.type = 'Device' AND
+      ne.enabled = 1 AND
+      sc.serial > ? AND
+      NOT EXISTS (
+        SELECT 1 FROM scep_certificates nsc WHERE nsc.renewed_from_serial = sc.serial
+      )
+    ORDER BY sc.serial
+    LIMIT ?`
+
+	certs := []*fleet.MDMAppleSCEPIdentityCertificate{}
+	if err := sqlx.SelectContext(ctx, ds.reader, &certs, stmt, afterSerial, limit); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list scep identity certificates")
+	}
+	return certs, nil
 }
 
-func cleanupDiskEncryptionKeysOnTeamChangeDB(ctx context.Context, tx sqlx.ExtContext, hostIDs []uint, newTeamID *uint) error {
-	_, err := getMDMAppleConfigProfileByTeamAndIdentifierDB(ctx, tx, newTeamID, mobileconfig.FleetFileVaultPayloadIdentifier)
-	if err != nil {
-		if fleet.IsNotFound(err) {
-			// the new team does not have a filevault profile so we need to delete the existing ones
-			if err := bulkDeleteHostDiskEncryptionKeysDB(ctx, tx, hostIDs); err != nil {
-				return ctxerr.Wrap(ctx, err, "reconcile filevault profiles on team change bulk delete host disk encryption keys")
-			}
-		} else {
-			return ctxerr.Wrap(ctx, err, "reconcile filevault profiles on team change get profile")
+func (ds *Datastore) SetOrUpdateHostOrbitMDMStatus(ctx context.Context, hostID uint, status *fleet.OrbitMDMEnrollmentStatus) error {
+	var profiles interface{}
+	if status.Profiles != nil {
+		b, err := json.Marshal(status.Profiles)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "marshal host mdm profiles")
 		}
+		profiles = b
+	}
+
+	// updated_at is explicitly set so that it reflects the last report even if
+	// the status didn't change.
+	const stmt = `
+    INSERT INTO host_orbit_mdm_status
+      (host_id, enrolled, installed_from_dep, server_url, profiles)
+    VALUES
+      (?, ?, ?, ?, ?)
+    ON DUPLICATE KEY UPDATE
+      enrolled = VALUES(enrolled),
+      installed_from_dep = VALUES(installed_from_dep),
+      server_url = VALUES(server_url),
+      profiles = VALUES(profiles),
+      updated_at = CURRENT_TIMESTAMP`
+
+	if _, err := ds.writer.ExecContext(ctx, stmt, hostID, status.Enrolled, status.InstalledFromDEP, status.ServerURL, profiles); err != nil {
+		return ctxerr.Wrap(ctx, err, "upsert host orbit mdm status")
 	}
 	return nil
 }
 
-func getMDMAppleConfigProfileByTeamAndIdentifierDB(ctx context.Context, tx sqlx.QueryerContext, teamID *uint, profileIdentifier string) (*fleet.MDMAppleConfigProfile, error) {
-	if teamID == nil {
-		teamID = ptr.Uint(0)
+func (ds *Datastore) ListMDMAppleEnrollmentMismatches(ctx context.Context, teamID *uint, mdmServerURL string) ([]*fleet.MDMAppleEnrollmentMismatch, error) {
+	stmt := `
+    SELECT
+      h.id as host_id,
+      COALESCE(NULLIF(hdn.display_name, ''), h.hostname) as display_name,
+      h.hardware_serial,
+      COALESCE(ne.enabled, 0) as server_enrolled,
+      homs.enrolled as device_enrolled,
+      homs.server_url as device_server_url,
+      homs.updated_at as reported_at
+    FROM
+      hosts h
+    JOIN
+      host_orbit_mdm_status homs ON homs.host_id = h.id
+    LEFT JOIN
+      host_display_names hdn ON hdn.host_id = h.id
+    LEFT JOIN
+      nano_enrollments ne ON ne.id = h.uuid AND ne.type = 'Device'
+    WHERE
+      %s AND
+      COALESCE(ne.enabled, 0) != (homs.enrolled = 1 AND homs.server_url = ?)
+    ORDER BY
+      h.id`
+
+	teamFilter := "h.team_id IS NULL"
+	args := []interface{}{}
+	if teamID != nil && *teamID > 0 {
+		teamFilter = "h.team_id = ?"
+		args = append(args, *teamID)
+	}
+	args = append(args, mdmServerURL)
+
+	mismatches := []*fleet.MDMAppleEnrollmentMismatch{}
+	if err := sqlx.SelectContext(ctx, ds.reader, &mismatches, fmt.Sprintf(stmt, teamFilter), args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list mdm enrollment mismatches")
 	}
+	return mismatches, nil
+}
 
+func (ds *Datastore) ListMDMAppleHostsMissingFleetd(ctx context.Context, teamID *uint, enrolledBefore time.Time) ([]*fleet.MDMAppleHostMissingFleetd, error) {
+	// a host enrolled only in MDM has no osquery node key and no orbit node
+	// key, they are set when fleetd enrolls.
 	stmt := `
-SELECT
-	profile_id,
-	team_id,
-	name,
-	identifier,
-	mobileconfig,
-	created_at,
-	updated_at
-FROM
-	mdm_apple_configuration_profiles
-WHERE
-	team_id=? AND identifier=?`
+    SELECT
+      h.id as host_id,
+      COALESCE(NULLIF(hdn.display_name, ''), h.hostname) as display_name,
+      h.hardware_serial,
+      h.uuid,
+      COALESCE(hm.installed_from_dep, 0) as installed_from_dep,
+      ne.created_at as mdm_enrolled_at
+    FROM
+      hosts h
+    JOIN
+      nano_enrollments ne ON ne.id = h.uuid AND ne.type = 'Device' AND ne.enabled = 1
+    LEFT JOIN
+      host_mdm hm ON hm.host_id = h.id
+    LEFT JOIN
+      host_display_names hdn ON hdn.host_id = h.id
+    WHERE
+      %s AND
+      h.platform = 'darwin' AND
+      h.node_key IS NULL AND
+      h.orbit_node_key IS NULL AND
+      ne.created_at <= ?
+    ORDER BY
+      h.id`
 
-	var profile fleet.MDMAppleConfigProfile
-	err := sqlx.GetContext(ctx, tx, &profile, stmt, teamID, profileIdentifier)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return &fleet.MDMAppleConfigProfile{}, ctxerr.Wrap(ctx, notFound("MDMAppleConfigProfile").WithName(profileIdentifier))
-		}
-		return &fleet.MDMAppleConfigProfile{}, ctxerr.Wrap(ctx, err, "get mdm apple config profile by team and identifier")
+	teamFilter := "h.team_id IS NULL"
+	args := []interface{}{}
+	if teamID != nil && *teamID > 0 {
+		teamFilter = "h.team_id = ?"
+		args = append(args, *teamID)
 	}
-	return &profile, nil
+	args = append(args, enrolledBefore)
+
+	hosts := []*fleet.MDMAppleHostMissingFleetd{}
+	if err := sqlx.SelectContext(ctx, ds.reader, &hosts, fmt.Sprintf(stmt, teamFilter), args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list mdm hosts missing fleetd")
+	}
+	return hosts, nil
 }
 
-func bulkDeleteHostDiskEncryptionKeysDB(ctx context.Context, tx sqlx.ExtContext, hostIDs []uint) error {
-	if len(hostIDs) == 0 {
-		return nil
+func (ds *Datastore) ReleaseMDMAppleDEPHost(ctx context.Context, serial string) (uint, bool, error) {
+	var host struct {
+		ID      uint `db:"id"`
+		Pending bool `db:"pending"`
+	}
+	// a host ingested from the DEP sync that never enrolled has no osquery
+	// identifier and is not enrolled in MDM yet.
+	err := sqlx.GetContext(ctx, ds.writer, &host, `
+      SELECT
+        h.id,
+        (h.osquery_host_id IS NULL AND COALESCE(hm.installed_from_dep, 0) = 1 AND COALESCE(hm.enrolled, 0) = 0) AS pending
+      FROM
+        hosts h
+        LEFT JOIN host_mdm hm ON hm.host_id = h.id
+      WHERE
+        h.hardware_serial = ?
+      LIMIT 1`, serial)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return 0, false, nil
+	case err != nil:
+		return 0, false, ctxerr.Wrap(ctx, err, "get host of released dep device")
 	}
 
-	query, args, err := sqlx.In(
-		"DELETE FROM host_disk_encryption_keys WHERE host_id IN (?)",
-		hostIDs,
-	)
-	if err != nil {
-		return ctxerr.Wrap(ctx, err, "building query")
+	if host.Pending {
+		if err := ds.DeleteHost(ctx, host.ID); err != nil {
+			return 0, false, ctxerr.Wrap(ctx, err, "delete pending host of released dep device")
+		}
+		return host.ID, true, nil
 	}
 
-	_, err = tx.ExecContext(ctx, query, args...)
-	return err
+	if _, err := ds.writer.ExecContext(ctx, `UPDATE host_mdm SET installed_from_dep = 0 WHERE host_id = ?`, host.ID); err != nil {
+		return 0, false, ctxerr.Wrap(ctx, err, "update host mdm of released dep device")
+	}
+	return host.ID, false, nil
 }
 
-func (ds *Datastore) MDMAppleGetEULAMetadata(ctx context.Context) (*fleet.MDMAppleEULA, error) {
-	// Currently, there can only be one EULA in the database, and we're
-	// hardcoding it's id to be 1 in order to enforce this restriction.
-	stmt := "SELECT name, created_at, token FROM eulas WHERE id = 1"
-	var eula fleet.MDMAppleEULA
-	if err := sqlx.GetContext(ctx, ds.reader, &eula, stmt); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ctxerr.Wrap(ctx, notFound("MDMAppleEULA"))
-		}
-		return nil, ctxerr.Wrap(ctx, err, "get EULA metadata")
+// nanoEnrollmentsSelect selects the enrollments stored in the nano tables
+// along with the id of the Fleet host linked to their device.
+const nanoEnrollmentsSelect = `
+          SELECT
+            id, device_id, user_id, type, enabled, serial_number, last_seen_at, created_at, host_id
+          FROM (
+            SELECT
+              ne.id,
+              ne.device_id,
+              ne.user_id,
+              ne.type,
+              ne.enabled,
+              nd.serial_number,
+              ne.last_seen_at,
+              ne.created_at,
+              h.id AS host_id
+            FROM
+              nano_enrollments ne
+              JOIN nano_devices nd ON nd.id = ne.device_id
+              LEFT JOIN hosts h ON h.uuid = ne.device_id
+          ) e`
+
+func (ds *Datastore) ListMDMAppleNanoEnrollments(ctx context.Context, opt fleet.MDMAppleNanoEnrollmentListOptions) ([]*fleet.MDMAppleNanoEnrollment, *fleet.PaginationMetadata, error) {
+	query := nanoEnrollmentsSelect + ` WHERE TRUE`
+
+	var args []interface{}
+	if opt.OrphanedOnly {
+		query += ` AND host_id IS NULL`
 	}
-	return &eula, nil
+	query, args = searchLike(query, args, opt.MatchQuery, "id", "device_id", "serial_number")
+
+	if opt.OrderKey == "" {
+		opt.OrderKey = "id"
+	}
+	opt.IncludeMetadata = true
+	query, args = appendListOptionsWithCursorToSQL(query, args, &opt.ListOptions)
+
+	enrollments := []*fleet.MDMAppleNanoEnrollment{}
+	if err := sqlx.SelectContext(ctx, ds.reader, &enrollments, query, args...); err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list nano enrollments")
+	}
+
+	metaData := &fleet.PaginationMetadata{HasPreviousResults: opt.Page > 0}
+	if len(enrollments) > int(opt.PerPage) {
+		metaData.HasNextResults = true
+		enrollments = enrollments[:len(enrollments)-1]
+	}
+	return enrollments, metaData, nil
 }
 
-func (ds *Datastore) MDMAppleGetEULABytes(ctx context.Context, token string) (*fleet.MDMAppleEULA, error) {
-	stmt := "SELECT name, bytes FROM eulas WHERE token = ?"
-	var eula fleet.MDMAppleEULA
-	if err := sqlx.GetContext(ctx, ds.reader, &eula, stmt, token); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ctxerr.Wrap(ctx, notFound("MDMAppleEULA"))
+func (ds *Datastore) GetMDMAppleNanoEnrollment(ctx context.Context, id string) (*fleet.MDMAppleNanoEnrollment, error) {
+	var enrollment fleet.MDMAppleNanoEnrollment
+	if err := sqlx.GetContext(ctx, ds.reader, &enrollment, nanoEnrollmentsSelect+` WHERE id = ?`, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("MDMAppleNanoEnrollment").WithName(id))
 		}
-		return nil, ctxerr.Wrap(ctx, err, "get EULA bytes")
+		return nil, ctxerr.Wrap(ctx, err, "get nano enrollment")
 	}
-	return &eula, nil
+	return &enrollment, nil
 }
 
-func (ds *Datastore) MDMAppleInsertEULA(ctx context.Context, eula *fleet.MDMAppleEULA) error {
-	// We're intentionally hardcoding the id to be 1 because we only want to
-	// allow one EULA.
-	stmt := `
-          INSERT INTO eulas (id, name, bytes, token)
-	  VALUES (1, ?, ?, ?)
-	`
-
-	_, err := ds.writer.ExecContext(ctx, stmt, eula.Name, eula.Bytes, eula.Token)
-	if err != nil {
-		if isDuplicate(err) {
-			return ctxerr.Wrap(ctx, alreadyExists("MDMAppleEULA", eula.Token))
+func (ds *Datastore) DeleteMDMAppleNanoEnrollment(ctx context.Context, id string) error {
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		var enrollment fleet.NanoEnrollment
+		err := sqlx.GetContext(ctx, tx, &enrollment, `SELECT id, device_id, type, enabled, token_update_tally FROM nano_enrollments WHERE id = ?`, id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ctxerr.Wrap(ctx, notFound("MDMAppleNanoEnrollment").WithName(id))
+			}
+			return ctxerr.Wrap(ctx, err, "get nano enrollment to delete")
 		}
-		return ctxerr.Wrap(ctx, err, "create EULA")
-	}
 
-	return nil
+		// deleting the device cascades to all of its enrollments (and their
+		// queued commands), a user enrollment is deleted on its own.
+		stmt, arg := `DELETE FROM nano_enrollments WHERE id = ?`, id
+		if enrollment.Type == "Device" {
+			stmt, arg = `DELETE FROM nano_devices WHERE id = ?`, enrollment.DeviceID
+		}
+		if _, err := tx.ExecContext(ctx, stmt, arg); err != nil {
+			return ctxerr.Wrap(ctx, err, "delete nano enrollment")
+		}
+		return nil
+	})
 }
 
-func (ds *Datastore) MDMAppleDeleteEULA(ctx context.Context, token string) error {
-	stmt := "DELETE FROM eulas WHERE token = ?"
-	res, err := ds.writer.ExecContext(ctx, stmt, token)
+func (ds *Datastore) LinkMDMAppleNanoEnrollmentToHost(ctx context.Context, id string, hostID uint) error {
+	appCfg, err := ds.AppConfig(ctx)
 	if err != nil {
-		return ctxerr.Wrap(ctx, err, "delete EULA")
+		return ctxerr.Wrap(ctx, err, "link nano enrollment get app config")
 	}
 
-	deleted, _ := res.RowsAffected()
-	if deleted != 1 {
-		return ctxerr.Wrap(ctx, notFound("MDMAppleEULA"))
-	}
-	return nil
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		var enrollment fleet.NanoEnrollment
+		err := sqlx.GetContext(ctx, tx, &enrollment, `SELECT id, device_id, type, enabled, token_update_tally FROM nano_enrollments WHERE id = ?`, id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ctxerr.Wrap(ctx, notFound("MDMAppleNanoEnrollment").WithName(id))
+			}
+			return ctxerr.Wrap(ctx, err, "get nano enrollment to link")
+		}
+
+		var linkedHostID uint
+		err = sqlx.GetContext(ctx, tx, &linkedHostID, `SELECT id FROM hosts WHERE uuid = ? LIMIT 1`, enrollment.DeviceID)
+		switch {
+		case err == nil:
+			if linkedHostID == hostID {
+				return nil
+			}
+			return ctxerr.Wrap(ctx, alreadyExists("Host", enrollment.DeviceID))
+		case !errors.Is(err, sql.ErrNoRows):
+			return ctxerr.Wrap(ctx, err, "get host linked to nano enrollment")
+		}
+
+		res, err := tx.ExecContext(ctx, `UPDATE hosts SET uuid = ?, refetch_requested = 1 WHERE id = ?`, enrollment.DeviceID, hostID)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "link nano enrollment to host")
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ctxerr.Wrap(ctx, notFound("Host").WithID(hostID))
+		}
+
+		if enrollment.Enabled {
+			if err := upsertMDMAppleHostMDMInfoDB(ctx, tx, appCfg.ServerSettings, false, hostID); err != nil {
+				return ctxerr.Wrap(ctx, err, "link nano enrollment upsert MDM info")
+			}
+		}
+		return nil
+	})
 }
 
-func (ds *Datastore) SetOrUpdateMDMAppleSetupAssistant(ctx context.Context, asst *fleet.MDMAppleSetupAssistant) (*fleet.MDMAppleSetupAssistant, error) {
-	const stmt = `
-		INSERT INTO
-			mdm_apple_setup_assistants (team_id, global_or_team_id, name, profile)
-		VALUES
-			(?, ?, ?, ?)
-		ON DUPLICATE KEY UPDATE
-			updated_at = IF(profile = VALUES(profile) AND name = VALUES(name), updated_at, CURRENT_TIMESTAMP),
-			profile_uuid = IF(profile = VALUES(profile) AND name = VALUES(name), profile_uuid, ''),
-			name = VALUES(name),
-			profile = VALUES(profile)
-`
-	var globalOrTmID uint
-	if asst.TeamID != nil {
-		globalOrTmID = *asst.TeamID
-	}
-	_, err := ds.writer.ExecContext(ctx, stmt, asst.TeamID, globalOrTmID, asst.Name, asst.Profile)
+func (ds *Datastore) NewMDMAppleServerURLMigration(ctx context.Context, oldServerURL, newServerURL string) (*fleet.MDMAppleServerURLMigration, error) {
+	const insertMigration = `
+          INSERT INTO mdm_apple_server_url_migrations (old_server_url, new_server_url)
+          VALUES (?, ?)`
+
+	// the enrollment profile is redelivered to the macOS hosts that are
+	// currently enrolled.
+	const insertHosts = `
+          INSERT INTO mdm_apple_server_url_migration_hosts (migration_id, host_uuid, status)
+          SELECT
+            ?, h.uuid, ?
+          FROM hosts h
+          JOIN nano_enrollments ne ON ne.device_id = h.uuid
+          WHERE
+            h.platform = 'darwin' AND
+            ne.enabled = 1 AND
+            ne.type = 'Device'`
+
+	var id uint
+	err := ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		res, err := tx.ExecContext(ctx, insertMigration, oldServerURL, newServerURL)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "insert server url migration")
+		}
+		insertID, _ := res.LastInsertId()
+		id = uint(insertID)
+
+		if _, err := tx.ExecContext(ctx, insertHosts, id, fleet.MDMAppleServerURLMigrationHostPending); err != nil {
+			return ctxerr.Wrap(ctx, err, "insert server url migration hosts")
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, ctxerr.Wrap(ctx, err, "upsert mdm apple setup assistant")
+		return nil, err
 	}
+	return getMDMAppleServerURLMigrationDB(ctx, ds.writer, &id)
+}
 
-	// reload to return the proper timestamp and id
-	return ds.getMDMAppleSetupAssistant(ctx, ds.writer, asst.TeamID)
+func (ds *Datastore) GetLatestMDMAppleServerURLMigration(ctx context.Context) (*fleet.MDMAppleServerURLMigration, error) {
+	return getMDMAppleServerURLMigrationDB(ctx, ds.reader, nil)
 }
 
-func (ds *Datastore) SetMDMAppleSetupAssistantProfileUUID(ctx context.Context, teamID *uint, profileUUID string) error {
+// getMDMAppleServerURLMigrationDB returns the migration with the provided id,
+// or the latest one if id is nil, along with its progress.
+func getMDMAppleServerURLMigrationDB(ctx context.Context, q sqlx.QueryerContext, id *uint) (*fleet.MDMAppleServerURLMigration, error) {
 	const stmt = `
-	UPDATE
-		mdm_apple_setup_assistants
-	SET
-		profile_uuid = ?,
-		-- ensure updated_at does not change, as it is used to reflect the time
-		-- the setup assistant was uploaded, not when its profile was defined
-		-- with Apple's API.
-		updated_at = updated_at
-	WHERE global_or_team_id = ?`
+          SELECT
+            m.id,
+            m.old_server_url,
+            m.new_server_url,
+            m.created_at,
+            COUNT(mh.host_uuid) AS hosts,
+            COALESCE(SUM(mh.status = ?), 0) AS pending,
+            COALESCE(SUM(mh.status = ?), 0) AS sent,
+            COALESCE(SUM(mh.status = ?), 0) AS acknowledged,
+            COALESCE(SUM(mh.status = ?), 0) AS failed
+          FROM mdm_apple_server_url_migrations m
+          LEFT JOIN mdm_apple_server_url_migration_hosts mh ON mh.migration_id = m.id
+          WHERE
+            m.id = %s
+          GROUP BY
+            m.id`
 
-	var globalOrTmID uint
-	if teamID != nil {
-		globalOrTmID = *teamID
+	args := []interface{}{
+		fleet.MDMAppleServerURLMigrationHostPending,
+		fleet.MDMAppleServerURLMigrationHostSent,
+		fleet.MDMAppleServerURLMigrationHostAcknowledged,
+		fleet.MDMAppleServerURLMigrationHostFailed,
 	}
-	res, err := ds.writer.ExecContext(ctx, stmt, profileUUID, globalOrTmID)
-	if err != nil {
-		return ctxerr.Wrap(ctx, err, "set mdm apple setup assistant profile uuid")
+	idCond := "(SELECT MAX(id) FROM mdm_apple_server_url_migrations)"
+	if id != nil {
+		idCond = "?"
+		args = append(args, *id)
 	}
-	if n, _ := res.RowsAffected(); n == 0 {
-		return ctxerr.Wrap(ctx, notFound("MDMAppleSetupAssistant").WithID(globalOrTmID))
+
+	var m fleet.MDMAppleServerURLMigration
+	if err := sqlx.GetContext(ctx, q, &m, fmt.Sprintf(stmt, idCond), args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("MDMAppleServerURLMigration"))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get server url migration")
 	}
-	return nil
+	return &m, nil
 }
 
-func (ds *Datastore) GetMDMAppleSetupAssistant(ctx context.Context, teamID *uint) (*fleet.MDMAppleSetupAssistant, error) {
-	return ds.getMDMAppleSetupAssistant(ctx, ds.reader, teamID)
+func (ds *Datastore) ListMDMAppleServerURLMigrationPendingHosts(ctx context.Context, migrationID uint, limit int) ([]string, error) {
+	const stmt = `
+          SELECT
+            host_uuid
+          FROM mdm_apple_server_url_migration_hosts
+          WHERE
+            migration_id = ? AND
+            status = ?
+          ORDER BY host_uuid
+          LIMIT ?`
+
+	var uuids []string
+	if err := sqlx.SelectContext(ctx, ds.reader, &uuids, stmt, migrationID, fleet.MDMAppleServerURLMigrationHostPending, limit); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list server url migration pending hosts")
+	}
+	return uuids, nil
 }
 
-func (ds *Datastore) getMDMAppleSetupAssistant(ctx context.Context, q sqlx.QueryerContext, teamID *uint) (*fleet.MDMAppleSetupAssistant, error) {
+func (ds *Datastore) SetMDMAppleServerURLMigrationHostsSent(ctx context.Context, migrationID uint, hostUUIDs []string, commandUUID string) error {
+	if len(hostUUIDs) == 0 {
+		return nil
+	}
+
 	const stmt = `
-	SELECT
-		id,
-		team_id,
-		name,
-		profile,
-		profile_uuid,
-		updated_at as uploaded_at
-	FROM
-		mdm_apple_setup_assistants
-	WHERE global_or_team_id = ?`
+          UPDATE mdm_apple_server_url_migration_hosts
+          SET
+            status = ?,
+            command_uuid = ?
+          WHERE
+            migration_id = ? AND
+            host_uuid IN (?)`
 
-	var asst fleet.MDMAppleSetupAssistant
-	var globalOrTmID uint
-	if teamID != nil {
-		globalOrTmID = *teamID
+	query, args, err := sqlx.In(stmt, fleet.MDMAppleServerURLMigrationHostSent, commandUUID, migrationID, hostUUIDs)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "building in statement")
 	}
-	if err := sqlx.GetContext(ctx, q, &asst, stmt, globalOrTmID); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ctxerr.Wrap(ctx, notFound("MDMAppleSetupAssistant").WithID(globalOrTmID))
-		}
-		return nil, ctxerr.Wrap(ctx, err, "get mdm apple setup assistant")
+	if _, err := ds.writer.ExecContext(ctx, query, args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "set server url migration hosts sent")
 	}
-	return &asst, nil
+	return nil
 }
 
-func (ds *Datastore) DeleteMDMAppleSetupAssistant(ctx context.Context, teamID *uint) error {
+func (ds *Datastore) SetMDMAppleServerURLMigrationHostResult(ctx context.Context, hostUUID, commandUUID string, status fleet.MDMAppleServerURLMigrationHostStatus, detail string) error {
 	const stmt = `
-		DELETE FROM mdm_apple_setup_assistants
-		WHERE global_or_team_id = ?`
+          UPDATE mdm_apple_server_url_migration_hosts
+          SET
+            status = ?,
+            detail = ?
+          WHERE
+            host_uuid = ? AND
+            command_uuid = ?`
 
-	var globalOrTmID uint
-	if teamID != nil {
-		globalOrTmID = *teamID
-	}
-	_, err := ds.writer.ExecContext(ctx, stmt, globalOrTmID)
-	if err != nil {
-		return ctxerr.Wrap(ctx, err, "delete mdm apple setup assistant")
+	if _, err := ds.writer.ExecContext(ctx, stmt, status, detail, hostUUID, commandUUID); err != nil {
+		return ctxerr.Wrap(ctx, err, "set server url migration host result")
 	}
 	return nil
 }
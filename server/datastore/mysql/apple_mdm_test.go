@@ -2,12 +2,16 @@ package mysql
 
 import (
 	"context"
-	"crypto/md5" // nolint:gosec // used only to hash for efficient comparisons
 	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -20,6 +24,7 @@ import (
 	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	nanodep_client "github.com/micromdm/nanodep/client"
 	"github.com/micromdm/nanodep/godep"
 	"github.com/micromdm/nanodep/tokenpki"
 	"github.com/micromdm/nanomdm/mdm"
@@ -42,6 +47,7 @@ func TestMDMApple(t *testing.T) {
 		{"TestListMDMAppleConfigProfiles", testListMDMAppleConfigProfiles},
 		{"TestHostDetailsMDMProfiles", testHostDetailsMDMProfiles},
 		{"TestBatchSetMDMAppleProfiles", testBatchSetMDMAppleProfiles},
+		{"TestBatchSetMDMAppleTeamsProfiles", testBatchSetMDMAppleTeamsProfiles},
 		{"TestMDMAppleProfileManagement", testMDMAppleProfileManagement},
 		{"TestGetMDMAppleProfilesContents", testGetMDMAppleProfilesContents},
 		{"TestAggregateMacOSSettingsStatusWithFileVault", testAggregateMacOSSettingsStatusWithFileVault},
@@ -50,12 +56,53 @@ func TestMDMApple(t *testing.T) {
 		{"TestIgnoreMDMClientError", testIgnoreMDMClientError},
 		{"TestDeleteMDMAppleProfilesForHost", testDeleteMDMAppleProfilesForHost},
 		{"TestBulkSetPendingMDMAppleHostProfiles", testBulkSetPendingMDMAppleHostProfiles},
+		{"TestListMDMAppleBulkSetPendingHostUUIDs", testListMDMAppleBulkSetPendingHostUUIDs},
 		{"TestGetMDMAppleCommandResults", testGetMDMAppleCommandResults},
 		{"TestBulkUpsertMDMAppleConfigProfiles", testBulkUpsertMDMAppleConfigProfile},
 		{"TestMDMAppleBootstrapPackageCRUD", testMDMAppleBootstrapPackageCRUD},
+		{"TestMDMAppleBootstrapPackageUpload", testMDMAppleBootstrapPackageUpload},
+		{"TestMDMAppleBootstrapPackageSignatureStatus", testMDMAppleBootstrapPackageSignatureStatus},
 		{"TestListMDMAppleCommands", testListMDMAppleCommands},
 		{"TestMDMAppleEULA", testMDMAppleEULA},
 		{"TestMDMAppleSetupAssistant", testMDMAppleSetupAssistant},
+		{"TestMDMAppleHostIdPAccount", testMDMAppleHostIdPAccount},
+		{"TestMDMAppleOSUpdatesSummary", testMDMAppleOSUpdatesSummary},
+		{"TestListMDMAppleProfileIdentifierConflicts", testListMDMAppleProfileIdentifierConflicts},
+		{"TestMDMAppleEnrollmentLinks", testMDMAppleEnrollmentLinks},
+		{"TestMDMAppleSSOEnrollmentTokens", testMDMAppleSSOEnrollmentTokens},
+		{"TestMDMAppleHostActivationLockBypassCode", testMDMAppleHostActivationLockBypassCode},
+		{"TestMDMAppleHostLockPIN", testMDMAppleHostLockPIN},
+		{"TestMDMAppleHostProfilesWithQueuedCommand", testMDMAppleHostProfilesWithQueuedCommand},
+		{"TestMDMAppleHostQueuedCommands", testMDMAppleHostQueuedCommands},
+		{"TestMDMAppleHostCommandsHistory", testMDMAppleHostCommandsHistory},
+		{"TestMDMAppleTeamEnrollmentTokens", testMDMAppleTeamEnrollmentTokens},
+		{"TestMDMApplePushFailures", testMDMApplePushFailures},
+		{"TestMDMAppleReservedPayloadConflicts", testMDMAppleReservedPayloadConflicts},
+		{"TestMDMAppleProfileRetryableFailures", testMDMAppleProfileRetryableFailures},
+		{"TestMDMAppleProfileRetryPolicy", testMDMAppleProfileRetryPolicy},
+		{"TestMDMAppleHostCertificates", testMDMAppleHostCertificates},
+		{"TestMDMAppleConfigProfilesBulkOperations", testMDMAppleConfigProfilesBulkOperations},
+		{"TestMDMAppleEnrollmentMismatches", testMDMAppleEnrollmentMismatches},
+		{"TestMDMAppleHostsMissingFleetd", testMDMAppleHostsMissingFleetd},
+		{"TestMDMAppleProfilesPreview", testMDMAppleProfilesPreview},
+		{"TestMDMAppleSCEPCertificates", testMDMAppleSCEPCertificates},
+		{"TestMDMAppleProfileExclusions", testMDMAppleProfileExclusions},
+		{"TestMDMApplePolicyActions", testMDMApplePolicyActions},
+		{"TestMDMAppleHostProfileInstalls", testMDMAppleHostProfileInstalls},
+		{"TestMDMAppleCommandPriorities", testMDMAppleCommandPriorities},
+		{"TestMDMAppleAllTeamsProfiles", testMDMAppleAllTeamsProfiles},
+		{"TestMDMAppleHostProfiles", testMDMAppleHostProfiles},
+		{"TestGetHostMDMProfilesOrigin", testGetHostMDMProfilesOrigin},
+		{"TestPurgeHostMDMAppleData", testPurgeHostMDMAppleData},
+		{"TestMDMAppleEnrollmentApprovals", testMDMAppleEnrollmentApprovals},
+		{"TestMDMAppleBlockedEnrollments", testMDMAppleBlockedEnrollments},
+		{"TestMDMAppleNanoEnrollments", testMDMAppleNanoEnrollments},
+		{"TestMDMAppleServerURLMigrations", testMDMAppleServerURLMigrations},
+		{"TestMDMAppleProfileChecksumAlgorithm", testMDMAppleProfileChecksumAlgorithm},
+		{"TestMDMAppleProfileChanges", testMDMAppleProfileChanges},
+		{"TestMDMAppleReconciliationPlan", testMDMAppleReconciliationPlan},
+		{"TestMDMAppleHostDEPProfileOverrides", testMDMAppleHostDEPProfileOverrides},
+		{"TestMDMAppleProfileSecrets", testMDMAppleProfileSecrets},
 	}
 
 	for _, c := range cases {
@@ -458,6 +505,47 @@ func TestIngestMDMAppleDevicesFromDEPSync(t *testing.T) {
 	require.ElementsMatch(t, wantSerials, gotSerials)
 }
 
+func TestMDMAppleReleaseDEPHost(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	ctx := context.Background()
+	createBuiltinLabels(t, ds)
+
+	n, err := ds.IngestMDMAppleDevicesFromDEPSync(ctx, []godep.Device{
+		{SerialNumber: "pending", Model: "MacBook Pro", OS: "OSX", OpType: "added"},
+		{SerialNumber: "enrolled", Model: "MacBook Pro", OS: "OSX", OpType: "added"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+
+	// the "enrolled" host completed its enrollment
+	require.NoError(t, ds.IngestMDMAppleDeviceFromCheckin(ctx, fleet.MDMAppleHostDetails{
+		SerialNumber: "enrolled",
+		UDID:         "enrolled-uuid",
+		Model:        "MacBook Pro",
+	}))
+
+	hostID, deleted, err := ds.ReleaseMDMAppleDEPHost(ctx, "pending")
+	require.NoError(t, err)
+	require.NotZero(t, hostID)
+	require.True(t, deleted)
+	_, err = ds.Host(ctx, hostID)
+	require.True(t, fleet.IsNotFound(err))
+
+	hostID, deleted, err = ds.ReleaseMDMAppleDEPHost(ctx, "enrolled")
+	require.NoError(t, err)
+	require.NotZero(t, hostID)
+	require.False(t, deleted)
+	hmdm, err := ds.GetHostMDM(ctx, hostID)
+	require.NoError(t, err)
+	require.True(t, hmdm.Enrolled)
+	require.False(t, hmdm.InstalledFromDep)
+
+	hostID, deleted, err = ds.ReleaseMDMAppleDEPHost(ctx, "no-such-serial")
+	require.NoError(t, err)
+	require.Zero(t, hostID)
+	require.False(t, deleted)
+}
+
 func TestDEPSyncTeamAssignment(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	ctx := context.Background()
@@ -526,6 +614,312 @@ func TestDEPSyncTeamAssignment(t *testing.T) {
 	}
 }
 
+func TestDEPSyncTeamPreAssignment(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	ctx := context.Background()
+	createBuiltinLabels(t, ds)
+
+	team1, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	team2, err := ds.NewTeam(ctx, &fleet.Team{Name: "team2"})
+	require.NoError(t, err)
+
+	ac, err := ds.AppConfig(ctx)
+	require.NoError(t, err)
+	ac.MDM.AppleBMDefaultTeam = team1.Name
+	require.NoError(t, ds.SaveAppConfig(ctx, ac))
+
+	assignments, err := ds.ListMDMAppleDEPTeamAssignments(ctx)
+	require.NoError(t, err)
+	require.Empty(t, assignments)
+
+	err = ds.SetMDMAppleDEPTeamAssignments(ctx, []*fleet.MDMAppleDEPTeamAssignment{
+		{SerialNumber: "abc", TeamID: &team1.ID},
+		{SerialNumber: "def", TeamID: &team1.ID},
+		{SerialNumber: "ghi", TeamID: &team2.ID},
+	})
+	require.NoError(t, err)
+
+	// update an assignment and remove another one
+	err = ds.SetMDMAppleDEPTeamAssignments(ctx, []*fleet.MDMAppleDEPTeamAssignment{
+		{SerialNumber: "abc", TeamID: &team2.ID},
+		{SerialNumber: "ghi"},
+		{SerialNumber: "no-such-serial"},
+	})
+	require.NoError(t, err)
+
+	assignments, err = ds.ListMDMAppleDEPTeamAssignments(ctx)
+	require.NoError(t, err)
+	require.Len(t, assignments, 2)
+	require.Equal(t, "abc", assignments[0].SerialNumber)
+	require.Equal(t, &team2.ID, assignments[0].TeamID)
+	require.Equal(t, "team2", assignments[0].TeamName)
+	require.Equal(t, "def", assignments[1].SerialNumber)
+	require.Equal(t, "team1", assignments[1].TeamName)
+
+	teamIDs, err := ds.GetMDMAppleDEPTeamAssignments(ctx, []string{"abc", "ghi", "xyz"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]uint{"abc": team2.ID}, teamIDs)
+
+	// the pre-assigned devices land in their team, the others in the default
+	// team
+	n, err := ds.IngestMDMAppleDevicesFromDEPSync(ctx, []godep.Device{
+		{SerialNumber: "abc", Model: "MacBook Pro", OS: "OSX", OpType: "added"},
+		{SerialNumber: "ghi", Model: "MacBook Pro", OS: "OSX", OpType: "added"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+
+	hosts := listHostsCheckCount(t, ds, fleet.TeamFilter{User: test.UserAdmin}, fleet.HostListOptions{}, 2)
+	for _, h := range hosts {
+		require.NotNil(t, h.TeamID)
+		switch h.HardwareSerial {
+		case "abc":
+			require.Equal(t, team2.ID, *h.TeamID)
+		case "ghi":
+			require.Equal(t, team1.ID, *h.TeamID)
+		}
+	}
+
+	// the assignments of a deleted team are deleted
+	require.NoError(t, ds.DeleteTeam(ctx, team2.ID))
+	assignments, err = ds.ListMDMAppleDEPTeamAssignments(ctx)
+	require.NoError(t, err)
+	require.Len(t, assignments, 1)
+	require.Equal(t, "def", assignments[0].SerialNumber)
+}
+
+func TestDEPSyncDEPDevices(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	ctx := context.Background()
+	createBuiltinLabels(t, ds)
+
+	assignedDate := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	depDevices := []godep.Device{
+		{
+			SerialNumber: "abc", Model: "MacBook Pro", Description: "MBP 13.3 SPG", Color: "SPACE GRAY", AssetTag: "A-1",
+			DeviceFamily: "Mac", OS: "OSX", DeviceAssignedBy: "admin@example.com", DeviceAssignedDate: assignedDate, OpType: "added",
+		},
+		{SerialNumber: "def", Model: "MacBook Air", OS: "OSX", OpType: "added"},
+	}
+
+	n, err := ds.IngestMDMAppleDevicesFromDEPSync(ctx, depDevices)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+
+	displayNames := func(count int) map[string]string {
+		hosts := listHostsCheckCount(t, ds, fleet.TeamFilter{User: test.UserAdmin}, fleet.HostListOptions{}, count)
+		names := make(map[string]string, len(hosts))
+		for _, h := range hosts {
+			var name string
+			err := sqlx.GetContext(ctx, ds.reader, &name, `SELECT display_name FROM host_display_names WHERE host_id = ?`, h.ID)
+			require.NoError(t, err)
+			names[h.HardwareSerial] = name
+		}
+		return names
+	}
+
+	hosts := listHostsCheckCount(t, ds, fleet.TeamFilter{User: test.UserAdmin}, fleet.HostListOptions{}, 2)
+	hostsBySerial := make(map[string]*fleet.Host, len(hosts))
+	for _, h := range hosts {
+		hostsBySerial[h.HardwareSerial] = h
+	}
+
+	// the display names are not enriched by default
+	require.Equal(t, map[string]string{
+		"abc": "MacBook Pro (abc)",
+		"def": "MacBook Air (def)",
+	}, displayNames(2))
+
+	dev, err := ds.GetHostMDMAppleDEPDevice(ctx, hostsBySerial["abc"].ID)
+	require.NoError(t, err)
+	require.Equal(t, "MBP 13.3 SPG", dev.Description)
+	require.Equal(t, "SPACE GRAY", dev.Color)
+	require.Equal(t, "A-1", dev.AssetTag)
+	require.Equal(t, "Mac", dev.DeviceFamily)
+	require.Equal(t, "OSX", dev.OS)
+	require.Equal(t, "admin@example.com", dev.DeviceAssignedBy)
+	require.NotNil(t, dev.DeviceAssignedDate)
+	require.True(t, assignedDate.Equal(*dev.DeviceAssignedDate))
+
+	dev, err = ds.GetHostMDMAppleDEPDevice(ctx, hostsBySerial["def"].ID)
+	require.NoError(t, err)
+	require.Empty(t, dev.Description)
+	require.Nil(t, dev.DeviceAssignedDate)
+
+	_, err = ds.GetHostMDMAppleDEPDevice(ctx, hostsBySerial["def"].ID+1000)
+	require.True(t, fleet.IsNotFound(err))
+
+	// the DEP custom attributes are set for the devices that have values
+	attrs, err := ds.ListHostCustomAttributes(ctx, hostsBySerial["abc"].ID)
+	require.NoError(t, err)
+	require.Len(t, attrs, 2)
+	require.Equal(t, fleet.HostCustomAttributeDEPAssetTag, attrs[0].Name)
+	require.Equal(t, "A-1", attrs[0].Value)
+	require.Equal(t, fleet.HostCustomAttributeDEPAssignedBy, attrs[1].Name)
+	require.Equal(t, "admin@example.com", attrs[1].Value)
+	require.Equal(t, fleet.HostCustomAttributeSourceDEP, attrs[1].Source)
+	attrs, err = ds.ListHostCustomAttributes(ctx, hostsBySerial["def"].ID)
+	require.NoError(t, err)
+	require.Empty(t, attrs)
+
+	// enable the display name enrichment, and sync again with updated
+	// information
+	ac, err := ds.AppConfig(ctx)
+	require.NoError(t, err)
+	ac.MDM.AppleBMEnrichDisplayName = true
+	err = ds.SaveAppConfig(ctx, ac)
+	require.NoError(t, err)
+
+	depDevices[0].AssetTag = "A-2"
+	depDevices[1].Description = "MBA 13.6 MDN"
+	depDevices = append(depDevices, godep.Device{SerialNumber: "ghi", Model: "Mac mini", Description: "MM M2", OS: "OSX", OpType: "added"})
+	n, err = ds.IngestMDMAppleDevicesFromDEPSync(ctx, depDevices)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	require.Equal(t, map[string]string{
+		"abc": "MBP 13.3 SPG (abc, A-2)",
+		"def": "MBA 13.6 MDN (def)",
+		"ghi": "MM M2 (ghi)",
+	}, displayNames(3))
+
+	dev, err = ds.GetHostMDMAppleDEPDevice(ctx, hostsBySerial["abc"].ID)
+	require.NoError(t, err)
+	require.Equal(t, "A-2", dev.AssetTag)
+
+	// the display name of a host that reported its name is not overridden
+	hostsBySerial["abc"].ComputerName = "Alice's MacBook"
+	err = ds.UpdateHost(ctx, hostsBySerial["abc"])
+	require.NoError(t, err)
+	_, err = ds.IngestMDMAppleDevicesFromDEPSync(ctx, depDevices)
+	require.NoError(t, err)
+	require.Equal(t, "Alice's MacBook", displayNames(3)["abc"])
+
+	// the devices modified in ABM update the information of existing hosts,
+	// without creating hosts for unknown devices
+	assignTime := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	n, err = ds.IngestMDMAppleDevicesFromDEPSync(ctx, []godep.Device{
+		{
+			SerialNumber: "def", Model: "MacBook Air", Description: "MBA 13.6 MDN", OS: "OSX", OpType: "modified",
+			ProfileStatus: "assigned", ProfileUUID: "profile-1", ProfileAssignTime: assignTime,
+		},
+		{SerialNumber: "xyz", Model: "MacBook Air", OS: "OSX", OpType: "modified"},
+	})
+	require.NoError(t, err)
+	require.Zero(t, n)
+	listHostsCheckCount(t, ds, fleet.TeamFilter{User: test.UserAdmin}, fleet.HostListOptions{}, 3)
+
+	dev, err = ds.GetHostMDMAppleDEPDevice(ctx, hostsBySerial["def"].ID)
+	require.NoError(t, err)
+	require.Equal(t, "assigned", dev.ProfileStatus)
+	require.Equal(t, "profile-1", dev.ProfileUUID)
+	require.NotNil(t, dev.ProfileAssignTime)
+	require.True(t, assignTime.Equal(*dev.ProfileAssignTime))
+	require.Nil(t, dev.ProfilePushTime)
+	require.Empty(t, dev.OrgName)
+
+	// the org name is set for all the devices
+	err = ds.SetMDMAppleDEPDevicesOrgName(ctx, "Acme Inc.")
+	require.NoError(t, err)
+	dev, err = ds.GetHostMDMAppleDEPDevice(ctx, hostsBySerial["def"].ID)
+	require.NoError(t, err)
+	require.Equal(t, "Acme Inc.", dev.OrgName)
+
+	// the report lists the hosts with their device information, filtered by
+	// the teams of the user
+	items, err := ds.ListMDMAppleDEPHostsReport(ctx, fleet.TeamFilter{User: test.UserAdmin}, fleet.ListOptions{OrderKey: "hardware_serial"})
+	require.NoError(t, err)
+	require.Len(t, items, 3)
+	require.Equal(t, hostsBySerial["abc"].ID, items[0].ID)
+	require.Equal(t, hostsBySerial["abc"].ID, items[0].HostID)
+	require.Equal(t, "Alice's MacBook", items[0].DisplayName)
+	require.Equal(t, "abc", items[0].HardwareSerial)
+	require.Equal(t, "A-2", items[0].AssetTag)
+	require.Equal(t, "Acme Inc.", items[0].OrgName)
+	require.Equal(t, "def", items[1].HardwareSerial)
+	require.Equal(t, "assigned", items[1].ProfileStatus)
+	require.Equal(t, "ghi", items[2].HardwareSerial)
+
+	items, err = ds.ListMDMAppleDEPHostsReport(ctx, fleet.TeamFilter{User: test.UserAdmin}, fleet.ListOptions{OrderKey: "hardware_serial", PerPage: 1, Page: 1})
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, "def", items[0].HardwareSerial)
+
+	items, err = ds.ListMDMAppleDEPHostsReport(ctx, fleet.TeamFilter{User: test.UserNoRoles}, fleet.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, items)
+}
+
+func TestDEPSyncAssignmentDiscrepancies(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	ctx := context.Background()
+	createBuiltinLabels(t, ds)
+
+	n, err := ds.IngestMDMAppleDevicesFromDEPSync(ctx, []godep.Device{
+		{SerialNumber: "mac-1", Model: "MacBook Pro", DeviceFamily: "Mac", OS: "OSX", OpType: "added"},
+		{SerialNumber: "mac-2", Model: "MacBook Air", DeviceFamily: "Mac", OS: "OSX", OpType: "added"},
+		{SerialNumber: "ipad-1", Model: "iPad Pro", DeviceFamily: "iPad", OS: "iOS", OpType: "added"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), n)
+	listHostsCheckCount(t, ds, fleet.TeamFilter{User: test.UserAdmin}, fleet.HostListOptions{}, 3)
+
+	discrepancies := func(families ...string) map[string]fleet.MDMAppleDEPAssignmentDiscrepancyReason {
+		items, err := ds.ListMDMAppleDEPAssignmentDiscrepancies(ctx, fleet.TeamFilter{User: test.UserAdmin}, families)
+		require.NoError(t, err)
+		got := make(map[string]fleet.MDMAppleDEPAssignmentDiscrepancyReason, len(items))
+		for _, it := range items {
+			got[it.HardwareSerial] = it.Reason
+			if it.Reason == fleet.MDMAppleDEPAssignmentDiscrepancyUnassigned {
+				require.NotNil(t, it.UnassignedAt)
+			} else {
+				require.Nil(t, it.UnassignedAt)
+			}
+		}
+		return got
+	}
+	require.Empty(t, discrepancies())
+	require.Equal(t, map[string]fleet.MDMAppleDEPAssignmentDiscrepancyReason{
+		"ipad-1": fleet.MDMAppleDEPAssignmentDiscrepancyUnexpectedDeviceFamily,
+	}, discrepancies("Mac"))
+
+	// mac-1 and mac-2 are removed from Fleet's MDM server, mac-2 is released by
+	// Fleet first
+	_, _, err = ds.ReleaseMDMAppleDEPHost(ctx, "mac-2")
+	require.NoError(t, err)
+	n, err = ds.IngestMDMAppleDevicesFromDEPSync(ctx, []godep.Device{
+		{SerialNumber: "mac-1", Model: "MacBook Pro", DeviceFamily: "Mac", OS: "OSX", OpType: "deleted"},
+		{SerialNumber: "mac-2", Model: "MacBook Air", DeviceFamily: "Mac", OS: "OSX", OpType: "deleted"},
+	})
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	require.Equal(t, map[string]fleet.MDMAppleDEPAssignmentDiscrepancyReason{
+		"mac-1": fleet.MDMAppleDEPAssignmentDiscrepancyUnassigned,
+	}, discrepancies())
+	require.Equal(t, map[string]fleet.MDMAppleDEPAssignmentDiscrepancyReason{
+		"mac-1": fleet.MDMAppleDEPAssignmentDiscrepancyUnassigned,
+	}, discrepancies("Mac", "iPad"))
+	// unassigned devices of the other families are expected, Macs are not
+	// expected to be assigned to Fleet
+	require.Equal(t, map[string]fleet.MDMAppleDEPAssignmentDiscrepancyReason{
+		"mac-3": fleet.MDMAppleDEPAssignmentDiscrepancyUnexpectedDeviceFamily,
+	}, discrepancies("iPad"))
+
+	// filtered by the teams of the user
+	items, err := ds.ListMDMAppleDEPAssignmentDiscrepancies(ctx, fleet.TeamFilter{User: test.UserNoRoles}, nil)
+	require.NoError(t, err)
+	require.Empty(t, items)
+
+	// mac-1 is assigned back to Fleet's MDM server
+	_, err = ds.IngestMDMAppleDevicesFromDEPSync(ctx, []godep.Device{
+		{SerialNumber: "mac-1", Model: "MacBook Pro", DeviceFamily: "Mac", OS: "OSX", OpType: "added"},
+	})
+	require.NoError(t, err)
+	require.Empty(t, discrepancies())
+}
+
 func TestMDMEnrollment(t *testing.T) {
 	ds := CreateMySQLDS(t)
 
@@ -801,7 +1195,7 @@ func testBatchSetMDMAppleProfiles(t *testing.T, ds *Datastore) {
 		// fleet-managed profiles.
 		var got []*fleet.MDMAppleConfigProfile
 		ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
-			return sqlx.SelectContext(ctx, q, &got, `SELECT * FROM mdm_apple_configuration_profiles WHERE team_id = ?`, tmID)
+			return sqlx.SelectContext(ctx, q, &got, `SELECT profile_id, team_id, identifier, name, mobileconfig, checksum, created_at, updated_at FROM mdm_apple_configuration_profiles WHERE team_id = ?`, tmID)
 		})
 
 		// compare only the fields we care about, and build the resulting map of
@@ -923,6 +1317,55 @@ func testBatchSetMDMAppleProfiles(t *testing.T, ds *Datastore) {
 	applyAndExpect(nil, ptr.Uint(1), expectFleetProfiles)
 }
 
+func testBatchSetMDMAppleTeamsProfiles(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	listIdents := func(tmID uint) []string {
+		var idents []string
+		ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+			return sqlx.SelectContext(ctx, q, &idents, `SELECT identifier FROM mdm_apple_configuration_profiles WHERE team_id = ? ORDER BY identifier`, tmID)
+		})
+		return idents
+	}
+
+	err := ds.BatchSetMDMAppleTeamsProfiles(ctx, []*fleet.MDMAppleTeamProfiles{
+		{TeamID: nil, Profiles: []*fleet.MDMAppleConfigProfile{configProfileForTest(t, "N1", "I1", "a")}},
+		{
+			TeamID:     ptr.Uint(1),
+			Profiles:   []*fleet.MDMAppleConfigProfile{configProfileForTest(t, "N1", "I1", "a"), configProfileForTest(t, "N2", "I2", "b")},
+			Exclusions: []*fleet.MDMAppleProfileExclusion{{ProfileIdentifier: "I2", HostID: ptr.Uint(5)}},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"I1"}, listIdents(0))
+	require.Equal(t, []string{"I1", "I2"}, listIdents(1))
+	excls, err := ds.ListMDMAppleProfileExclusions(ctx, ptr.Uint(1))
+	require.NoError(t, err)
+	require.Len(t, excls, 1)
+	require.Equal(t, "I2", excls[0].ProfileIdentifier)
+
+	// if any team fails, none of the teams is changed
+	err = ds.BatchSetMDMAppleTeamsProfiles(ctx, []*fleet.MDMAppleTeamProfiles{
+		{TeamID: nil, Profiles: []*fleet.MDMAppleConfigProfile{configProfileForTest(t, "N3", "I3", "c")}},
+		{TeamID: ptr.Uint(1), Profiles: []*fleet.MDMAppleConfigProfile{configProfileForTest(t, "N1", "I1", "a"), configProfileForTest(t, "N1", "I3", "c")}},
+	})
+	require.Error(t, err)
+	require.Equal(t, []string{"I1"}, listIdents(0))
+	require.Equal(t, []string{"I1", "I2"}, listIdents(1))
+
+	// the profiles and exclusions of the provided teams are replaced
+	err = ds.BatchSetMDMAppleTeamsProfiles(ctx, []*fleet.MDMAppleTeamProfiles{
+		{TeamID: nil, Profiles: []*fleet.MDMAppleConfigProfile{configProfileForTest(t, "N3", "I3", "c")}},
+		{TeamID: ptr.Uint(1)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"I3"}, listIdents(0))
+	require.Empty(t, listIdents(1))
+	excls, err = ds.ListMDMAppleProfileExclusions(ctx, ptr.Uint(1))
+	require.NoError(t, err)
+	require.Empty(t, excls)
+}
+
 func configProfileForTest(t *testing.T, name, identifier, uuid string) *fleet.MDMAppleConfigProfile {
 	prof := []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
 <!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
@@ -944,8 +1387,7 @@ func configProfileForTest(t *testing.T, name, identifier, uuid string) *fleet.MD
 </plist>
 `, name, identifier, uuid))
 	cp, err := fleet.NewMDMAppleConfigProfile(prof, nil)
-	sum := md5.Sum(prof) // nolint:gosec // used only to hash for efficient comparisons
-	cp.Checksum = sum[:]
+	cp.Checksum = fleet.MDMProfileChecksumMD5.Sum(prof)
 	require.NoError(t, err)
 	return cp
 }
@@ -1542,6 +1984,22 @@ func testAggregateMacOSSettingsStatusWithFileVault(t *testing.T, ds *Datastore)
 	require.Equal(t, uint(0), res.Failed)
 	require.Equal(t, uint(0), res.Verifying)
 
+	// the summary of all teams matches the summary of each team
+	byTeam, err := ds.ListMDMAppleHostsProfilesSummaryByTeam(ctx)
+	require.NoError(t, err)
+	require.Len(t, byTeam, 2)
+	require.Nil(t, byTeam[0].TeamID)
+	require.Empty(t, byTeam[0].TeamName)
+	require.Equal(t, &team.ID, byTeam[1].TeamID)
+	require.Equal(t, team.Name, byTeam[1].TeamName)
+	for _, ts := range byTeam {
+		res, err := ds.GetMDMAppleHostsProfilesSummary(ctx, ts.TeamID)
+		require.NoError(t, err)
+		require.Equal(t, res.Pending, ts.Pending)
+		require.Equal(t, res.Failed, ts.Failed)
+		require.Equal(t, res.Verifying, ts.Verifying)
+	}
+
 	err = ds.SetOrUpdateHostDiskEncryptionKey(ctx, hosts[9].ID, "baz")
 	require.NoError(t, err)
 	err = ds.SetHostsDiskEncryptionKeyStatus(ctx, []uint{hosts[9].ID}, true, time.Now().Add(1*time.Hour))
@@ -1831,10 +2289,62 @@ func testMDMAppleInsertIdPAccount(t *testing.T, ds *Datastore) {
 	acc.SaltedSHA512PBKDF2Dictionary.Entropy = []byte("yportne")
 	err = ds.InsertMDMIdPAccount(ctx, acc)
 	require.NoError(t, err)
-	var out fleet.MDMIdPAccount
-	err = sqlx.GetContext(ctx, ds.reader, &out, "SELECT * FROM mdm_idp_accounts WHERE uuid = 'ABC-DEF'")
+	out, err := ds.GetMDMIdPAccount(ctx, "ABC-DEF")
+	require.NoError(t, err)
+	require.Equal(t, acc, out)
+
+	// full name and groups are stored
+	acc.FullName = "Jane Doe"
+	acc.Groups = []string{"engineering", "admins"}
+	err = ds.InsertMDMIdPAccount(ctx, acc)
+	require.NoError(t, err)
+	out, err = ds.GetMDMIdPAccount(ctx, "ABC-DEF")
+	require.NoError(t, err)
+	require.Equal(t, acc, out)
+
+	_, err = ds.GetMDMIdPAccount(ctx, "no-such-account")
+	require.True(t, fleet.IsNotFound(err))
+}
+
+func testMDMAppleHostIdPAccount(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	_, err := ds.GetHostMDMIdPAccount(ctx, "host-uuid")
+	require.True(t, fleet.IsNotFound(err))
+
+	acc1 := &fleet.MDMIdPAccount{
+		UUID:     "acc-1",
+		Username: "jane@example.com",
+		FullName: "Jane Doe",
+		Groups:   []string{"engineering"},
+		SaltedSHA512PBKDF2Dictionary: fleet.SaltedSHA512PBKDF2Dictionary{
+			Salt:    []byte("salt"),
+			Entropy: []byte("entropy"),
+		},
+	}
+	acc2 := &fleet.MDMIdPAccount{
+		UUID:     "acc-2",
+		Username: "john@example.com",
+		SaltedSHA512PBKDF2Dictionary: fleet.SaltedSHA512PBKDF2Dictionary{
+			Salt:    []byte("salt"),
+			Entropy: []byte("entropy"),
+		},
+	}
+	require.NoError(t, ds.InsertMDMIdPAccount(ctx, acc1))
+	require.NoError(t, ds.InsertMDMIdPAccount(ctx, acc2))
+
+	err = ds.AssociateHostMDMIdPAccount(ctx, "host-uuid", acc1.UUID)
+	require.NoError(t, err)
+	got, err := ds.GetHostMDMIdPAccount(ctx, "host-uuid")
+	require.NoError(t, err)
+	require.Equal(t, acc1, got)
+
+	// re-enrolling the host with a different account replaces the association
+	err = ds.AssociateHostMDMIdPAccount(ctx, "host-uuid", acc2.UUID)
+	require.NoError(t, err)
+	got, err = ds.GetHostMDMIdPAccount(ctx, "host-uuid")
 	require.NoError(t, err)
-	require.Equal(t, acc, &out)
+	require.Equal(t, acc2, got)
 }
 
 func testIgnoreMDMClientError(t *testing.T, ds *Datastore) {
@@ -2165,6 +2675,84 @@ func TestMDMAppleFileVaultSummary(t *testing.T) {
 	require.Equal(t, uint(1), allProfilesSummary.Verifying)
 }
 
+func testListMDMAppleBulkSetPendingHostUUIDs(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	team, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+
+	var hosts []*fleet.Host
+	for i := 0; i < 3; i++ {
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:      fmt.Sprintf("test-host%d-name", i),
+			OsqueryHostID: ptr.String(fmt.Sprintf("osquery-%d", i)),
+			NodeKey:       ptr.String(fmt.Sprintf("nodekey-%d", i)),
+			UUID:          fmt.Sprintf("test-uuid-%d", i),
+			Platform:      "darwin",
+		})
+		require.NoError(t, err)
+		hosts = append(hosts, h)
+	}
+	err = ds.AddHostsToTeam(ctx, &team.ID, []uint{hosts[0].ID})
+	require.NoError(t, err)
+
+	globalProf, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "G1", "G1", "a"))
+	require.NoError(t, err)
+	teamProf := configProfileForTest(t, "T1", "T1", "b")
+	teamProf.TeamID = &team.ID
+	teamProf, err = ds.NewMDMAppleConfigProfile(ctx, *teamProf)
+	require.NoError(t, err)
+
+	// no criteria, no hosts
+	uuids, err := ds.ListMDMAppleBulkSetPendingHostUUIDs(ctx, nil, nil, nil)
+	require.NoError(t, err)
+	require.Empty(t, uuids)
+
+	// combination of criteria, not allowed
+	_, err = ds.ListMDMAppleBulkSetPendingHostUUIDs(ctx, []uint{hosts[0].ID}, []uint{team.ID}, nil)
+	require.Error(t, err)
+
+	uuids, err = ds.ListMDMAppleBulkSetPendingHostUUIDs(ctx, []uint{hosts[0].ID, hosts[2].ID}, nil, nil)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{hosts[0].UUID, hosts[2].UUID}, uuids)
+
+	uuids, err = ds.ListMDMAppleBulkSetPendingHostUUIDs(ctx, nil, []uint{team.ID}, nil)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{hosts[0].UUID}, uuids)
+
+	uuids, err = ds.ListMDMAppleBulkSetPendingHostUUIDs(ctx, nil, []uint{0}, nil)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{hosts[1].UUID, hosts[2].UUID}, uuids)
+
+	uuids, err = ds.ListMDMAppleBulkSetPendingHostUUIDs(ctx, nil, []uint{0, team.ID}, nil)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{hosts[0].UUID, hosts[1].UUID, hosts[2].UUID}, uuids)
+
+	uuids, err = ds.ListMDMAppleBulkSetPendingHostUUIDs(ctx, nil, nil, []uint{globalProf.ProfileID})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{hosts[1].UUID, hosts[2].UUID}, uuids)
+
+	uuids, err = ds.ListMDMAppleBulkSetPendingHostUUIDs(ctx, nil, nil, []uint{teamProf.ProfileID})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{hosts[0].UUID}, uuids)
+
+	// the count of hosts is bounded by the limit
+	count, err := ds.CountMDMAppleBulkSetPendingHosts(ctx, nil, nil, nil, 10)
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	_, err = ds.CountMDMAppleBulkSetPendingHosts(ctx, []uint{hosts[0].ID}, []uint{team.ID}, nil, 10)
+	require.Error(t, err)
+
+	count, err = ds.CountMDMAppleBulkSetPendingHosts(ctx, nil, []uint{0, team.ID}, nil, 10)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	count, err = ds.CountMDMAppleBulkSetPendingHosts(ctx, nil, []uint{0, team.ID}, nil, 2)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+}
+
 func testBulkSetPendingMDMAppleHostProfiles(t *testing.T, ds *Datastore) {
 	ctx := context.Background()
 
@@ -2770,10 +3358,10 @@ func testGetMDMAppleCommandResults(t *testing.T, ds *Datastore) {
 		Result:      []byte(rawCmd2),
 	})
 
-	// simulate a result for enrolledHosts[1]
+	// simulate a result for enrolledHosts[1], reported in a traced request
 	err = storage.StoreCommandReport(&mdm.Request{
 		EnrollID: &mdm.EnrollID{ID: enrolledHosts[1].UUID},
-		Context:  ctx,
+		Context:  apple_mdm.NewContextWithTraceID(ctx, "trace-1"),
 	}, &mdm.CommandResults{
 		CommandUUID: uuid2,
 		Status:      "Error",
@@ -2806,6 +3394,7 @@ func testGetMDMAppleCommandResults(t *testing.T, ds *Datastore) {
 			Status:      "Error",
 			RequestType: "ProfileList",
 			Result:      []byte(rawCmd2),
+			TraceID:     "trace-1",
 		},
 	})
 
@@ -2835,6 +3424,7 @@ func testGetMDMAppleCommandResults(t *testing.T, ds *Datastore) {
 			Status:      "Error",
 			RequestType: "ProfileList",
 			Result:      []byte(rawCmd2),
+			TraceID:     "trace-1",
 		},
 	})
 }
@@ -2964,29 +3554,185 @@ func testMDMAppleBootstrapPackageCRUD(t *testing.T, ds *Datastore) {
 	require.Nil(t, meta)
 }
 
-func testListMDMAppleCommands(t *testing.T, ds *Datastore) {
+func testMDMAppleBootstrapPackageSignatureStatus(t *testing.T, ds *Datastore) {
 	ctx := context.Background()
 
-	createRawCmd := func(reqType, cmdUUID string) string {
-		return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
-<plist version="1.0">
-<dict>
-    <key>Command</key>
-    <dict>
-        <key>ManagedOnly</key>
-        <false/>
-        <key>RequestType</key>
-        <string>%s</string>
-    </dict>
-    <key>CommandUUID</key>
-    <string>%s</string>
-</dict>
-</plist>`, reqType, cmdUUID)
+	pkgs, err := ds.ListMDMAppleBootstrapPackages(ctx)
+	require.NoError(t, err)
+	require.Empty(t, pkgs)
+
+	var bps []*fleet.MDMAppleBootstrapPackage
+	for _, teamID := range []uint{0, 1} {
+		bp := &fleet.MDMAppleBootstrapPackage{
+			TeamID: teamID,
+			Name:   t.Name(),
+			Sha256: sha256.New().Sum(nil),
+			Bytes:  []byte("content"),
+			Token:  uuid.New().String(),
+		}
+		require.NoError(t, ds.InsertMDMAppleBootstrapPackage(ctx, bp))
+		bps = append(bps, bp)
 	}
 
-	// create some enrolled hosts
-	enrolledHosts := make([]*fleet.Host, 3)
+	pkgs, err = ds.ListMDMAppleBootstrapPackages(ctx)
+	require.NoError(t, err)
+	require.Len(t, pkgs, 2)
+	for i, pkg := range pkgs {
+		require.Equal(t, bps[i].Token, pkg.Token)
+		require.Equal(t, bps[i].TeamID, pkg.TeamID)
+		require.Nil(t, pkg.UploadID)
+		require.Empty(t, pkg.Bytes)
+		require.Equal(t, fleet.MDMAppleBootstrapPackageSignatureUnverified, pkg.SignatureStatus)
+		require.Nil(t, pkg.SignatureDetail)
+		require.Nil(t, pkg.SignatureVerifiedAt)
+	}
+
+	verifiedAt := time.Now().UTC().Truncate(time.Second)
+	err = ds.SetMDMAppleBootstrapPackageSignatureStatus(ctx, bps[0].Token, fleet.MDMAppleBootstrapPackageSignatureValid, "", verifiedAt)
+	require.NoError(t, err)
+	err = ds.SetMDMAppleBootstrapPackageSignatureStatus(ctx, bps[1].Token, fleet.MDMAppleBootstrapPackageSignatureInvalid, "expired", verifiedAt)
+	require.NoError(t, err)
+	// unknown tokens are ignored
+	err = ds.SetMDMAppleBootstrapPackageSignatureStatus(ctx, "fake", fleet.MDMAppleBootstrapPackageSignatureValid, "", verifiedAt)
+	require.NoError(t, err)
+
+	meta, err := ds.GetMDMAppleBootstrapPackageMeta(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, fleet.MDMAppleBootstrapPackageSignatureValid, meta.SignatureStatus)
+	require.Nil(t, meta.SignatureDetail)
+	require.NotNil(t, meta.SignatureVerifiedAt)
+	require.True(t, verifiedAt.Equal(*meta.SignatureVerifiedAt))
+
+	meta, err = ds.GetMDMAppleBootstrapPackageMeta(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, fleet.MDMAppleBootstrapPackageSignatureInvalid, meta.SignatureStatus)
+	require.Equal(t, ptr.String("expired"), meta.SignatureDetail)
+}
+
+func testMDMAppleBootstrapPackageUpload(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+	var nfe fleet.NotFoundError
+	var aerr fleet.AlreadyExistsError
+	var oerr *fleet.MDMAppleBootstrapPackageUploadOffsetError
+
+	content := []byte("some bootstrap package content")
+	checksum := sha256.Sum256(content)
+
+	_, err := ds.GetMDMAppleBootstrapPackageUpload(ctx, "fake")
+	require.ErrorAs(t, err, &nfe)
+
+	up, err := ds.NewMDMAppleBootstrapPackageUpload(ctx, &fleet.MDMAppleBootstrapPackageUpload{
+		Token:  uuid.New().String(),
+		TeamID: 1,
+		Name:   t.Name(),
+		Size:   int64(len(content)),
+		Sha256: checksum[:],
+	})
+	require.NoError(t, err)
+	require.NotZero(t, up.ID)
+	require.Zero(t, up.Offset)
+
+	// append the content in chunks of 10 bytes
+	for offset := 0; offset < len(content); offset += 10 {
+		end := offset + 10
+		if end > len(content) {
+			end = len(content)
+		}
+		up, err = ds.AppendMDMAppleBootstrapPackageUploadChunk(ctx, up.Token, int64(offset), content[offset:end])
+		require.NoError(t, err)
+		require.EqualValues(t, end, up.Offset)
+	}
+	require.Equal(t, 3, up.Chunks)
+
+	// appending at the wrong offset fails
+	_, err = ds.AppendMDMAppleBootstrapPackageUploadChunk(ctx, up.Token, 5, []byte("a"))
+	require.ErrorAs(t, err, &oerr)
+	require.EqualValues(t, len(content), oerr.Expected)
+	require.EqualValues(t, 5, oerr.Got)
+
+	got, err := ds.GetMDMAppleBootstrapPackageUpload(ctx, up.Token)
+	require.NoError(t, err)
+	require.Equal(t, up.Offset, got.Offset)
+	require.Equal(t, checksum[:], got.Sha256)
+
+	chunk, err := ds.GetMDMAppleBootstrapPackageUploadChunk(ctx, up.ID, 1)
+	require.NoError(t, err)
+	require.Equal(t, content[10:20], chunk)
+	_, err = ds.GetMDMAppleBootstrapPackageUploadChunk(ctx, up.ID, 3)
+	require.ErrorAs(t, err, &nfe)
+
+	pkgToken := uuid.New().String()
+	err = ds.CommitMDMAppleBootstrapPackageUpload(ctx, up.Token, pkgToken)
+	require.NoError(t, err)
+	err = ds.CommitMDMAppleBootstrapPackageUpload(ctx, up.Token, uuid.New().String())
+	require.ErrorAs(t, err, &aerr)
+
+	meta, err := ds.GetMDMAppleBootstrapPackageMeta(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, t.Name(), meta.Name)
+	require.Equal(t, checksum[:], meta.Sha256)
+
+	pkg, err := ds.GetMDMAppleBootstrapPackageBytes(ctx, pkgToken)
+	require.NoError(t, err)
+	require.NotNil(t, pkg.UploadID)
+	require.Equal(t, up.ID, *pkg.UploadID)
+	require.EqualValues(t, len(content), pkg.Size)
+
+	// an abandoned upload is cleaned up, the committed one is kept
+	abandoned, err := ds.NewMDMAppleBootstrapPackageUpload(ctx, &fleet.MDMAppleBootstrapPackageUpload{
+		Token:  uuid.New().String(),
+		TeamID: 2,
+		Name:   t.Name(),
+		Size:   int64(len(content)),
+		Sha256: checksum[:],
+	})
+	require.NoError(t, err)
+
+	n, err := ds.CleanupMDMAppleBootstrapPackageUploads(ctx, time.Now())
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	n, err = ds.CleanupMDMAppleBootstrapPackageUploads(ctx, time.Now().Add(48*time.Hour))
+	require.NoError(t, err)
+	require.EqualValues(t, 1, n)
+
+	_, err = ds.GetMDMAppleBootstrapPackageUpload(ctx, abandoned.Token)
+	require.ErrorAs(t, err, &nfe)
+	_, err = ds.GetMDMAppleBootstrapPackageUpload(ctx, up.Token)
+	require.NoError(t, err)
+
+	// deleting the package deletes its upload and chunks
+	err = ds.DeleteMDMAppleBootstrapPackage(ctx, 1)
+	require.NoError(t, err)
+	_, err = ds.GetMDMAppleBootstrapPackageUpload(ctx, up.Token)
+	require.ErrorAs(t, err, &nfe)
+	_, err = ds.GetMDMAppleBootstrapPackageUploadChunk(ctx, up.ID, 0)
+	require.ErrorAs(t, err, &nfe)
+}
+
+func testListMDMAppleCommands(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	createRawCmd := func(reqType, cmdUUID string) string {
+		return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>Command</key>
+    <dict>
+        <key>ManagedOnly</key>
+        <false/>
+        <key>RequestType</key>
+        <string>%s</string>
+    </dict>
+    <key>CommandUUID</key>
+    <string>%s</string>
+</dict>
+</plist>`, reqType, cmdUUID)
+	}
+
+	// create some enrolled hosts
+	enrolledHosts := make([]*fleet.Host, 3)
 	for i := 0; i < 3; i++ {
 		h, err := ds.NewHost(ctx, &fleet.Host{
 			Hostname:      fmt.Sprintf("test-host%d-name", i),
@@ -3164,6 +3910,17 @@ func testListMDMAppleCommands(t *testing.T, ds *Datastore) {
 	require.NoError(t, err)
 	require.Len(t, res, 4)
 
+	// filter by command
+	res, err = ds.ListMDMAppleCommands(ctx, fleet.TeamFilter{User: test.UserAdmin}, &fleet.MDMAppleCommandListOptions{CommandUUID: uuid2})
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	for _, r := range res {
+		require.Equal(t, uuid2, r.CommandUUID)
+	}
+	res, err = ds.ListMDMAppleCommands(ctx, fleet.TeamFilter{User: test.UserAdmin}, &fleet.MDMAppleCommandListOptions{CommandUUID: "no-such-command"})
+	require.NoError(t, err)
+	require.Len(t, res, 0)
+
 	// page-by-page: first page
 	res, err = ds.ListMDMAppleCommands(ctx, fleet.TeamFilter{User: test.UserAdmin}, &fleet.MDMAppleCommandListOptions{
 		ListOptions: fleet.ListOptions{Page: 0, PerPage: 3, OrderKey: "device_id", OrderDirection: fleet.OrderDescending},
@@ -3407,3 +4164,3196 @@ func testMDMAppleSetupAssistant(t *testing.T, ds *Datastore) {
 	err = ds.DeleteMDMAppleSetupAssistant(ctx, &tm.ID)
 	require.NoError(t, err)
 }
+
+func testMDMAppleOSUpdatesSummary(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	// global settings with a deadline that passed, team settings with a
+	// deadline in the future
+	ac, err := ds.AppConfig(ctx)
+	require.NoError(t, err)
+	ac.MDM.MacOSUpdates = fleet.MacOSUpdates{MinimumVersion: "13.3.1", Deadline: "2020-01-01"}
+	require.NoError(t, ds.SaveAppConfig(ctx, ac))
+
+	team, err := ds.NewTeam(ctx, &fleet.Team{
+		Name: "team",
+		Config: fleet.TeamConfig{
+			MDM: fleet.TeamMDM{MacOSUpdates: fleet.MacOSUpdates{MinimumVersion: "13.4", Deadline: "2099-01-01"}},
+		},
+	})
+	require.NoError(t, err)
+	emptyTeam, err := ds.NewTeam(ctx, &fleet.Team{Name: "no settings"})
+	require.NoError(t, err)
+
+	newHost := func(name string, teamID *uint, version *string, pending uint) *fleet.Host {
+		h := test.NewHost(t, ds, name, "1.1.1.1", name, name, time.Now())
+		if teamID != nil {
+			require.NoError(t, ds.AddHostsToTeam(ctx, teamID, []uint{h.ID}))
+		}
+		if version != nil {
+			require.NoError(t, ds.SetOrUpdateHostOSUpdates(ctx, h.ID, *version, pending))
+		}
+		return h
+	}
+
+	// no team hosts
+	noTeamBehind := newHost("h1", nil, ptr.String("13.2"), 1)
+	noTeamCompliant := newHost("h2", nil, ptr.String("13.3.1"), 0)
+	newHost("h3", nil, ptr.String("13.10"), 0)
+	newHost("h4", nil, nil, 0)
+	// team hosts
+	teamDeferred := newHost("h5", &team.ID, ptr.String("13.3.1"), 2)
+	newHost("h6", &team.ID, ptr.String("13.4.0"), 0)
+	// team without settings
+	newHost("h7", &emptyTeam.ID, ptr.String("12.0.1"), 1)
+
+	summary, err := ds.GetMDMAppleOSUpdatesSummary(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, fleet.MDMAppleOSUpdatesSummary{Compliant: 2, Behind: 1, UpdatesAvailable: 1}, *summary)
+
+	summary, err = ds.GetMDMAppleOSUpdatesSummary(ctx, team.ID)
+	require.NoError(t, err)
+	require.Equal(t, fleet.MDMAppleOSUpdatesSummary{Compliant: 1, Deferred: 1, UpdatesAvailable: 1}, *summary)
+
+	summary, err = ds.GetMDMAppleOSUpdatesSummary(ctx, emptyTeam.ID)
+	require.NoError(t, err)
+	require.Equal(t, fleet.MDMAppleOSUpdatesSummary{}, *summary)
+
+	// the host reports an updated OS version
+	require.NoError(t, ds.SetOrUpdateHostOSUpdates(ctx, noTeamBehind.ID, "13.3.2", 0))
+	summary, err = ds.GetMDMAppleOSUpdatesSummary(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, fleet.MDMAppleOSUpdatesSummary{Compliant: 3}, *summary)
+
+	// filter the list of hosts by status
+	userFilter := fleet.TeamFilter{User: test.UserAdmin}
+	status := fleet.OSUpdateDeferred
+	hosts := listHostsCheckCount(t, ds, userFilter, fleet.HostListOptions{OSUpdateStatusFilter: &status}, 1)
+	require.Equal(t, teamDeferred.ID, hosts[0].ID)
+
+	status = fleet.OSUpdateBehind
+	listHostsCheckCount(t, ds, userFilter, fleet.HostListOptions{OSUpdateStatusFilter: &status}, 0)
+
+	status = fleet.OSUpdateCompliant
+	listHostsCheckCount(t, ds, userFilter, fleet.HostListOptions{OSUpdateStatusFilter: &status}, 4)
+	hosts = listHostsCheckCount(t, ds, userFilter, fleet.HostListOptions{OSUpdateStatusFilter: &status, TeamFilter: ptr.Uint(0)}, 3)
+	require.Contains(t, []uint{hosts[0].ID, hosts[1].ID, hosts[2].ID}, noTeamCompliant.ID)
+}
+
+func testListMDMAppleProfileIdentifierConflicts(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tmA, err := ds.NewTeam(ctx, &fleet.Team{Name: "A"})
+	require.NoError(t, err)
+	tmB, err := ds.NewTeam(ctx, &fleet.Team{Name: "B"})
+	require.NoError(t, err)
+
+	profA := configProfileForTest(t, "A1", "I1", "UA1")
+	profA.TeamID = &tmA.ID
+	profA, err = ds.NewMDMAppleConfigProfile(ctx, *profA)
+	require.NoError(t, err)
+	profB := configProfileForTest(t, "B2", "I2", "UB2")
+	profB.TeamID = &tmB.ID
+	profB, err = ds.NewMDMAppleConfigProfile(ctx, *profB)
+	require.NoError(t, err)
+
+	newHost := func(name string, teamID *uint, profs ...*fleet.MDMAppleConfigProfile) *fleet.Host {
+		h := test.NewHost(t, ds, name, "1.1.1.1", name, name, time.Now())
+		if teamID != nil {
+			require.NoError(t, ds.AddHostsToTeam(ctx, teamID, []uint{h.ID}))
+		}
+		var payload []*fleet.MDMAppleBulkUpsertHostProfilePayload
+		for _, p := range profs {
+			payload = append(payload, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+				ProfileID:         p.ProfileID,
+				ProfileIdentifier: p.Identifier,
+				ProfileName:       p.Name,
+				HostUUID:          h.UUID,
+				CommandUUID:       uuid.NewString(),
+				OperationType:     fleet.MDMAppleOperationTypeInstall,
+				Status:            &fleet.MDMAppleDeliveryVerifying,
+				Checksum:          []byte("csum"),
+			})
+		}
+		if len(payload) > 0 {
+			require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, payload))
+		}
+		return h
+	}
+
+	// a host of team A with the team's profile, no conflict
+	newHost("h1", &tmA.ID, profA)
+	// hosts of team B that still have the profile of team A
+	newHost("h2", &tmB.ID, profA, profB)
+	newHost("h3", &tmB.ID, profA)
+	// a host with no team that has the profiles of both teams
+	newHost("h4", nil, profA, profB)
+
+	conflicts, err := ds.ListMDMAppleProfileIdentifierConflicts(ctx, &tmA.ID, []string{"I1", "I2", "I3"})
+	require.NoError(t, err)
+	require.Empty(t, conflicts)
+
+	conflicts, err = ds.ListMDMAppleProfileIdentifierConflicts(ctx, &tmB.ID, []string{"I1", "I2", "I3"})
+	require.NoError(t, err)
+	require.Equal(t, []*fleet.MDMAppleProfileIdentifierConflict{{Identifier: "I1", HostsCount: 2}}, conflicts)
+
+	conflicts, err = ds.ListMDMAppleProfileIdentifierConflicts(ctx, nil, []string{"I1", "I2", "I3"})
+	require.NoError(t, err)
+	require.Equal(t, []*fleet.MDMAppleProfileIdentifierConflict{
+		{Identifier: "I1", HostsCount: 1},
+		{Identifier: "I2", HostsCount: 1},
+	}, conflicts)
+
+	conflicts, err = ds.ListMDMAppleProfileIdentifierConflicts(ctx, nil, nil)
+	require.NoError(t, err)
+	require.Empty(t, conflicts)
+}
+
+func testMDMAppleEnrollmentLinks(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	team, err := ds.NewTeam(ctx, &fleet.Team{Name: "test team"})
+	require.NoError(t, err)
+
+	_, err = ds.GetMDMAppleEnrollmentLink(ctx, 999)
+	require.True(t, fleet.IsNotFound(err))
+
+	link, err := ds.NewMDMAppleEnrollmentLink(ctx, &fleet.MDMAppleEnrollmentLink{
+		Token:     "tok1",
+		TeamID:    &team.ID,
+		MaxUses:   2,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+	require.NotZero(t, link.ID)
+	require.Equal(t, "tok1", link.Token)
+	require.Equal(t, &team.ID, link.TeamID)
+	require.EqualValues(t, 2, link.MaxUses)
+	require.Zero(t, link.UsesCount)
+	require.Empty(t, link.Uses)
+
+	expired, err := ds.NewMDMAppleEnrollmentLink(ctx, &fleet.MDMAppleEnrollmentLink{
+		Token:     "tok2",
+		MaxUses:   1,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	require.NoError(t, err)
+	require.Nil(t, expired.TeamID)
+
+	// only valid links are returned by token
+	got, err := ds.GetMDMAppleEnrollmentLinkByToken(ctx, "tok1")
+	require.NoError(t, err)
+	require.Equal(t, link.ID, got.ID)
+	_, err = ds.GetMDMAppleEnrollmentLinkByToken(ctx, "tok2")
+	require.True(t, fleet.IsNotFound(err))
+	_, err = ds.GetMDMAppleEnrollmentLinkByToken(ctx, "no-such-token")
+	require.True(t, fleet.IsNotFound(err))
+	_, err = ds.ConsumeMDMAppleEnrollmentLink(ctx, "tok2", "uuid-1")
+	require.True(t, fleet.IsNotFound(err))
+
+	h1, err := ds.NewHost(ctx, &fleet.Host{
+		Hostname:      "host1",
+		OsqueryHostID: ptr.String("host1"),
+		NodeKey:       ptr.String("host1"),
+		UUID:          "uuid-1",
+		Platform:      "ios",
+	})
+	require.NoError(t, err)
+
+	// consume the link with two hosts, consuming it again with the same host
+	// does not count as a new use
+	got, err = ds.ConsumeMDMAppleEnrollmentLink(ctx, "tok1", "uuid-1")
+	require.NoError(t, err)
+	require.EqualValues(t, 1, got.UsesCount)
+	got, err = ds.ConsumeMDMAppleEnrollmentLink(ctx, "tok1", "uuid-1")
+	require.NoError(t, err)
+	require.EqualValues(t, 1, got.UsesCount)
+	got, err = ds.ConsumeMDMAppleEnrollmentLink(ctx, "tok1", "uuid-2")
+	require.NoError(t, err)
+	require.EqualValues(t, 2, got.UsesCount)
+
+	// the link reached its maximum number of uses
+	_, err = ds.ConsumeMDMAppleEnrollmentLink(ctx, "tok1", "uuid-3")
+	require.True(t, fleet.IsNotFound(err))
+	_, err = ds.GetMDMAppleEnrollmentLinkByToken(ctx, "tok1")
+	require.True(t, fleet.IsNotFound(err))
+	// but a host that already used it can re-enroll
+	_, err = ds.ConsumeMDMAppleEnrollmentLink(ctx, "tok1", "uuid-2")
+	require.NoError(t, err)
+
+	got, err = ds.GetMDMAppleEnrollmentLink(ctx, link.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, got.UsesCount)
+	require.Len(t, got.Uses, 2)
+	uses := map[string]*uint{}
+	for _, u := range got.Uses {
+		uses[u.HostUUID] = u.HostID
+	}
+	require.Equal(t, map[string]*uint{"uuid-1": &h1.ID, "uuid-2": nil}, uses)
+
+	// deleting the team deletes its links
+	require.NoError(t, ds.DeleteTeam(ctx, team.ID))
+	_, err = ds.GetMDMAppleEnrollmentLink(ctx, link.ID)
+	require.True(t, fleet.IsNotFound(err))
+}
+
+func testMDMAppleSSOEnrollmentTokens(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	acc := &fleet.MDMIdPAccount{UUID: "acc-1", Username: "jdoe@example.com", FullName: "John Doe"}
+	require.NoError(t, ds.InsertMDMIdPAccount(ctx, acc))
+
+	newToken := func(token string, expiresAt time.Time) *fleet.MDMAppleSSOEnrollmentToken {
+		tok, err := ds.NewMDMAppleSSOEnrollmentToken(ctx, &fleet.MDMAppleSSOEnrollmentToken{
+			Token:          token,
+			IdPAccountUUID: acc.UUID,
+			ExpiresAt:      expiresAt,
+		})
+		require.NoError(t, err)
+		return tok
+	}
+	tok1 := newToken("tok1", time.Now().Add(time.Hour))
+	require.NotZero(t, tok1.ID)
+	require.Equal(t, "jdoe@example.com", tok1.IdPUsername)
+	require.Equal(t, "John Doe", tok1.IdPFullName)
+	require.Nil(t, tok1.ConsumedAt)
+	require.Nil(t, tok1.RevokedAt)
+	tok2 := newToken("tok2", time.Now().Add(time.Hour))
+	expired := newToken("tok3", time.Now().Add(-time.Minute))
+
+	// the token can only be consumed once
+	got, err := ds.ConsumeMDMAppleSSOEnrollmentToken(ctx, "tok1")
+	require.NoError(t, err)
+	require.Equal(t, tok1.ID, got.ID)
+	require.Equal(t, acc.UUID, got.IdPAccountUUID)
+	require.NotNil(t, got.ConsumedAt)
+	_, err = ds.ConsumeMDMAppleSSOEnrollmentToken(ctx, "tok1")
+	require.True(t, fleet.IsNotFound(err))
+	_, err = ds.ConsumeMDMAppleSSOEnrollmentToken(ctx, "tok3")
+	require.True(t, fleet.IsNotFound(err))
+	_, err = ds.ConsumeMDMAppleSSOEnrollmentToken(ctx, "no-such-token")
+	require.True(t, fleet.IsNotFound(err))
+
+	// only unused tokens can be revoked, and revoked tokens can't be consumed
+	_, err = ds.RevokeMDMAppleSSOEnrollmentToken(ctx, tok1.ID)
+	require.True(t, fleet.IsNotFound(err))
+	_, err = ds.RevokeMDMAppleSSOEnrollmentToken(ctx, expired.ID)
+	require.True(t, fleet.IsNotFound(err))
+	got, err = ds.RevokeMDMAppleSSOEnrollmentToken(ctx, tok2.ID)
+	require.NoError(t, err)
+	require.NotNil(t, got.RevokedAt)
+	_, err = ds.ConsumeMDMAppleSSOEnrollmentToken(ctx, "tok2")
+	require.True(t, fleet.IsNotFound(err))
+
+	// the most recent tokens are listed first
+	toks, err := ds.ListMDMAppleSSOEnrollmentTokens(ctx, fleet.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, toks, 3)
+	require.Equal(t, []uint{expired.ID, tok2.ID, tok1.ID}, []uint{toks[0].ID, toks[1].ID, toks[2].ID})
+	require.NotNil(t, toks[2].ConsumedAt)
+	require.NotNil(t, toks[1].RevokedAt)
+	require.Nil(t, toks[0].ConsumedAt)
+	require.Nil(t, toks[0].RevokedAt)
+	toks, err = ds.ListMDMAppleSSOEnrollmentTokens(ctx, fleet.ListOptions{PerPage: 1, Page: 1})
+	require.NoError(t, err)
+	require.Len(t, toks, 1)
+	require.Equal(t, tok2.ID, toks[0].ID)
+}
+
+func testMDMAppleHostActivationLockBypassCode(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	_, err := ds.GetHostMDMActivationLockBypassCode(ctx, "uuid-1")
+	require.True(t, fleet.IsNotFound(err))
+
+	require.NoError(t, ds.SetHostMDMActivationLockBypassCode(ctx, "uuid-1", "ZW5jcnlwdGVkMQ=="))
+	code, err := ds.GetHostMDMActivationLockBypassCode(ctx, "uuid-1")
+	require.NoError(t, err)
+	require.Equal(t, "uuid-1", code.HostUUID)
+	require.Equal(t, "ZW5jcnlwdGVkMQ==", code.Base64Encrypted)
+	require.Empty(t, code.BypassCode)
+	require.False(t, code.UpdatedAt.IsZero())
+
+	// a new code replaces the previous one
+	require.NoError(t, ds.SetHostMDMActivationLockBypassCode(ctx, "uuid-1", "ZW5jcnlwdGVkMg=="))
+	code, err = ds.GetHostMDMActivationLockBypassCode(ctx, "uuid-1")
+	require.NoError(t, err)
+	require.Equal(t, "ZW5jcnlwdGVkMg==", code.Base64Encrypted)
+
+	// a code escrowed in plain text is cleared when it is encrypted
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `INSERT INTO host_mdm_activation_lock_bypass_codes (host_uuid, bypass_code, base64_encrypted) VALUES ('uuid-2', 'AAAA-BBBB', '')`)
+		return err
+	})
+	code, err = ds.GetHostMDMActivationLockBypassCode(ctx, "uuid-2")
+	require.NoError(t, err)
+	require.Equal(t, "AAAA-BBBB", code.BypassCode)
+	require.Empty(t, code.Base64Encrypted)
+	require.NoError(t, ds.SetHostMDMActivationLockBypassCode(ctx, "uuid-2", "ZW5jcnlwdGVkMw=="))
+	code, err = ds.GetHostMDMActivationLockBypassCode(ctx, "uuid-2")
+	require.NoError(t, err)
+	require.Empty(t, code.BypassCode)
+	require.Equal(t, "ZW5jcnlwdGVkMw==", code.Base64Encrypted)
+
+	_, err = ds.GetHostMDMActivationLockBypassCode(ctx, "uuid-3")
+	require.True(t, fleet.IsNotFound(err))
+}
+
+func testMDMAppleHostLockPIN(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	_, err := ds.GetHostMDMAppleLockPIN(ctx, "uuid-1")
+	require.True(t, fleet.IsNotFound(err))
+
+	require.NoError(t, ds.SetHostMDMAppleLockPIN(ctx, &fleet.HostMDMAppleLockPIN{
+		HostUUID: "uuid-1", CommandUUID: "cmd-1", RequestType: "DeviceLock", PIN: "123456",
+	}))
+	pin, err := ds.GetHostMDMAppleLockPIN(ctx, "uuid-1")
+	require.NoError(t, err)
+	require.Equal(t, "uuid-1", pin.HostUUID)
+	require.Equal(t, "cmd-1", pin.CommandUUID)
+	require.Equal(t, "DeviceLock", pin.RequestType)
+	require.Equal(t, "123456", pin.PIN)
+	require.False(t, pin.UpdatedAt.IsZero())
+
+	// a new command replaces the previous PIN
+	require.NoError(t, ds.SetHostMDMAppleLockPIN(ctx, &fleet.HostMDMAppleLockPIN{
+		HostUUID: "uuid-1", CommandUUID: "cmd-2", RequestType: "EraseDevice", PIN: "654321",
+	}))
+	pin, err = ds.GetHostMDMAppleLockPIN(ctx, "uuid-1")
+	require.NoError(t, err)
+	require.Equal(t, "cmd-2", pin.CommandUUID)
+	require.Equal(t, "EraseDevice", pin.RequestType)
+	require.Equal(t, "654321", pin.PIN)
+
+	_, err = ds.GetHostMDMAppleLockPIN(ctx, "uuid-2")
+	require.True(t, fleet.IsNotFound(err))
+}
+
+func testMDMAppleHostProfilesWithQueuedCommand(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	var hosts []*fleet.Host
+	for i := 0; i < 4; i++ {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo.local.%d", i), "1.1.1.1",
+			fmt.Sprintf("%d", i), fmt.Sprintf("uuid-%d", i), time.Now())
+		nanoEnroll(t, ds, h, false)
+		hosts = append(hosts, h)
+	}
+	prof, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "N1", "I1", "a"))
+	require.NoError(t, err)
+
+	commander, storage := createMDMAppleCommanderAndStorage(t, ds)
+
+	// hosts[0] acknowledged its command, hosts[1] was busy, hosts[2] didn't
+	// report anything yet and hosts[3] has no command.
+	var payloads []*fleet.MDMAppleBulkUpsertHostProfilePayload
+	for i, h := range hosts[:3] {
+		cmdUUID := fmt.Sprintf("cmd-%d", i)
+		require.NoError(t, commander.InstallProfile(ctx, []string{h.UUID}, prof.Mobileconfig, cmdUUID))
+		payloads = append(payloads, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+			ProfileID:         prof.ProfileID,
+			ProfileIdentifier: prof.Identifier,
+			ProfileName:       prof.Name,
+			HostUUID:          h.UUID,
+			OperationType:     fleet.MDMAppleOperationTypeInstall,
+			Status:            &fleet.MDMAppleDeliveryPending,
+			CommandUUID:       cmdUUID,
+			Checksum:          []byte("csum"),
+		})
+
+		var status string
+		switch i {
+		case 0:
+			status = "Acknowledged"
+		case 1:
+			status = "NotNow"
+		default:
+			continue
+		}
+		err = storage.StoreCommandReport(&mdm.Request{
+			EnrollID: &mdm.EnrollID{ID: h.UUID},
+			Context:  ctx,
+		}, &mdm.CommandResults{
+			CommandUUID: cmdUUID,
+			Status:      status,
+			RequestType: "InstallProfile",
+			Raw:         []byte("<?xml"),
+		})
+		require.NoError(t, err)
+	}
+	payloads = append(payloads, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+		ProfileID:         prof.ProfileID,
+		ProfileIdentifier: prof.Identifier,
+		ProfileName:       prof.Name,
+		HostUUID:          hosts[3].UUID,
+		OperationType:     fleet.MDMAppleOperationTypeInstall,
+		Checksum:          []byte("csum"),
+	})
+	require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, payloads))
+
+	hostUUIDs := []string{hosts[0].UUID, hosts[1].UUID, hosts[2].UUID, hosts[3].UUID}
+	res, err := ds.ListMDMAppleHostProfilesWithQueuedCommand(ctx, fleet.MDMAppleOperationTypeInstall, hostUUIDs)
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	byHost := make(map[string]*fleet.MDMAppleProfilePayload)
+	for _, p := range res {
+		byHost[p.HostUUID] = p
+	}
+	require.Equal(t, "cmd-1", byHost[hosts[1].UUID].CommandUUID)
+	require.Equal(t, "cmd-2", byHost[hosts[2].UUID].CommandUUID)
+	require.Equal(t, prof.ProfileID, byHost[hosts[2].UUID].ProfileID)
+	require.Equal(t, []byte("csum"), byHost[hosts[2].UUID].Checksum)
+
+	// only the requested hosts and operation are returned
+	res, err = ds.ListMDMAppleHostProfilesWithQueuedCommand(ctx, fleet.MDMAppleOperationTypeInstall, []string{hosts[0].UUID, hosts[2].UUID})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, hosts[2].UUID, res[0].HostUUID)
+
+	res, err = ds.ListMDMAppleHostProfilesWithQueuedCommand(ctx, fleet.MDMAppleOperationTypeRemove, hostUUIDs)
+	require.NoError(t, err)
+	require.Empty(t, res)
+
+	res, err = ds.ListMDMAppleHostProfilesWithQueuedCommand(ctx, fleet.MDMAppleOperationTypeInstall, nil)
+	require.NoError(t, err)
+	require.Empty(t, res)
+}
+
+func testMDMAppleHostQueuedCommands(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	h := test.NewHost(t, ds, "foo.local", "1.1.1.1", "1", "uuid-1", time.Now())
+	nanoEnroll(t, ds, h, false)
+	prof, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "N1", "I1", "a"))
+	require.NoError(t, err)
+
+	commander, storage := createMDMAppleCommanderAndStorage(t, ds)
+
+	cmds, err := ds.ListMDMAppleHostQueuedCommands(ctx, h.UUID)
+	require.NoError(t, err)
+	require.Empty(t, cmds)
+
+	// the profile command is pending, "busy" was reported as NotNow and "ack"
+	// was acknowledged.
+	require.NoError(t, commander.InstallProfile(ctx, []string{h.UUID}, prof.Mobileconfig, "profile"))
+	require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, []*fleet.MDMAppleBulkUpsertHostProfilePayload{{
+		ProfileID:         prof.ProfileID,
+		ProfileIdentifier: prof.Identifier,
+		ProfileName:       prof.Name,
+		HostUUID:          h.UUID,
+		OperationType:     fleet.MDMAppleOperationTypeInstall,
+		Status:            &fleet.MDMAppleDeliveryPending,
+		CommandUUID:       "profile",
+		Checksum:          []byte("csum"),
+	}}))
+	for _, cmdUUID := range []string{"busy", "ack"} {
+		require.NoError(t, commander.ProfileList(ctx, []string{h.UUID}, cmdUUID))
+	}
+	for cmdUUID, status := range map[string]string{"busy": "NotNow", "ack": "Acknowledged"} {
+		err = storage.StoreCommandReport(&mdm.Request{
+			EnrollID: &mdm.EnrollID{ID: h.UUID},
+			Context:  ctx,
+		}, &mdm.CommandResults{
+			CommandUUID: cmdUUID,
+			Status:      status,
+			RequestType: "ProfileList",
+			Raw:         []byte("<?xml"),
+		})
+		require.NoError(t, err)
+	}
+	// the wipe is urgent, it is delivered first
+	_, err = commander.EraseDevice(ctx, []string{h.UUID}, "wipe")
+	require.NoError(t, err)
+
+	cmds, err = ds.ListMDMAppleHostQueuedCommands(ctx, h.UUID)
+	require.NoError(t, err)
+	require.Len(t, cmds, 3)
+	require.Equal(t, "wipe", cmds[0].CommandUUID)
+	require.Equal(t, "EraseDevice", cmds[0].RequestType)
+	require.Equal(t, "Pending", cmds[0].Status)
+	require.Nil(t, cmds[0].ProfileIdentifier)
+	byUUID := make(map[string]*fleet.MDMAppleHostQueuedCommand)
+	for _, c := range cmds[1:] {
+		byUUID[c.CommandUUID] = c
+	}
+	require.Len(t, byUUID, 2)
+	require.Equal(t, "NotNow", byUUID["busy"].Status)
+	require.Nil(t, byUUID["busy"].ProfileIdentifier)
+	require.Equal(t, "Pending", byUUID["profile"].Status)
+	require.NotNil(t, byUUID["profile"].ProfileIdentifier)
+	require.Equal(t, prof.Identifier, *byUUID["profile"].ProfileIdentifier)
+
+	// only queued commands can be canceled
+	err = ds.CancelMDMAppleHostQueuedCommand(ctx, h.UUID, "ack")
+	require.True(t, fleet.IsNotFound(err))
+	err = ds.CancelMDMAppleHostQueuedCommand(ctx, "no-such-host", "wipe")
+	require.True(t, fleet.IsNotFound(err))
+	require.NoError(t, ds.CancelMDMAppleHostQueuedCommand(ctx, h.UUID, "wipe"))
+	require.NoError(t, ds.CancelMDMAppleHostQueuedCommand(ctx, h.UUID, "busy"))
+	err = ds.CancelMDMAppleHostQueuedCommand(ctx, h.UUID, "wipe")
+	require.True(t, fleet.IsNotFound(err))
+
+	cmds, err = ds.ListMDMAppleHostQueuedCommands(ctx, h.UUID)
+	require.NoError(t, err)
+	require.Len(t, cmds, 1)
+	require.Equal(t, "profile", cmds[0].CommandUUID)
+
+	// the canceled command is not delivered
+	cmd, err := storage.RetrieveNextCommand(&mdm.Request{EnrollID: &mdm.EnrollID{ID: h.UUID}, Context: ctx}, false)
+	require.NoError(t, err)
+	require.Equal(t, "profile", cmd.CommandUUID)
+}
+
+func testMDMAppleHostCommandsHistory(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	h := test.NewHost(t, ds, "foo.local", "1.1.1.1", "1", "uuid-1", time.Now())
+	nanoEnroll(t, ds, h, false)
+	commander, storage := createMDMAppleCommanderAndStorage(t, ds)
+
+	cmds, meta, err := ds.ListMDMAppleHostCommands(ctx, h.UUID, fleet.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, cmds)
+	require.False(t, meta.HasNextResults)
+
+	for _, cmdUUID := range []string{"ack", "err", "busy"} {
+		require.NoError(t, commander.ProfileList(ctx, []string{h.UUID}, cmdUUID))
+	}
+	_, err = commander.EraseDevice(ctx, []string{h.UUID}, "wipe")
+	require.NoError(t, err)
+	_, err = commander.DeviceLock(ctx, []string{h.UUID}, "lock")
+	require.NoError(t, err)
+	for cmdUUID, status := range map[string]string{"ack": "Acknowledged", "err": "Error", "busy": "NotNow"} {
+		err = storage.StoreCommandReport(&mdm.Request{
+			EnrollID: &mdm.EnrollID{ID: h.UUID},
+			Context:  ctx,
+		}, &mdm.CommandResults{
+			CommandUUID: cmdUUID,
+			Status:      status,
+			RequestType: "ProfileList",
+			Raw:         []byte("<?xml"),
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, ds.CancelMDMAppleHostQueuedCommand(ctx, h.UUID, "wipe"))
+
+	// set distinct creation times to have a deterministic order
+	for i, cmdUUID := range []string{"ack", "err", "busy", "wipe", "lock"} {
+		_, err := ds.writer.ExecContext(ctx, `UPDATE host_mdm_apple_command_history SET created_at = ? WHERE command_uuid = ?`,
+			time.Now().Add(-time.Duration(5-i)*time.Minute), cmdUUID)
+		require.NoError(t, err)
+	}
+
+	assertHistory := func(want map[string]string) {
+		cmds, _, err := ds.ListMDMAppleHostCommands(ctx, h.UUID, fleet.ListOptions{})
+		require.NoError(t, err)
+		got := make(map[string]string, len(cmds))
+		for _, c := range cmds {
+			got[c.CommandUUID] = c.Status
+		}
+		require.Equal(t, want, got)
+	}
+	assertHistory(map[string]string{
+		"ack":  "Acknowledged",
+		"err":  "Error",
+		"busy": "NotNow",
+		"wipe": "Canceled",
+		"lock": "Pending",
+	})
+
+	// most recent first, paginated
+	cmds, meta, err = ds.ListMDMAppleHostCommands(ctx, h.UUID, fleet.ListOptions{PerPage: 2})
+	require.NoError(t, err)
+	require.Len(t, cmds, 2)
+	require.Equal(t, "lock", cmds[0].CommandUUID)
+	require.Equal(t, "DeviceLock", cmds[0].RequestType)
+	require.Equal(t, "wipe", cmds[1].CommandUUID)
+	require.True(t, meta.HasNextResults)
+	require.False(t, meta.HasPreviousResults)
+	cmds, meta, err = ds.ListMDMAppleHostCommands(ctx, h.UUID, fleet.ListOptions{PerPage: 2, Page: 2})
+	require.NoError(t, err)
+	require.Len(t, cmds, 1)
+	require.Equal(t, "ack", cmds[0].CommandUUID)
+	require.False(t, meta.HasNextResults)
+	require.True(t, meta.HasPreviousResults)
+
+	// the history is kept when the MDM data of the host is purged
+	require.NoError(t, ds.PurgeHostMDMAppleData(ctx, h.ID, h.UUID))
+	queued, err := ds.ListMDMAppleHostQueuedCommands(ctx, h.UUID)
+	require.NoError(t, err)
+	require.Empty(t, queued)
+	assertHistory(map[string]string{
+		"ack":  "Acknowledged",
+		"err":  "Error",
+		"busy": "NotNow",
+		"wipe": "Canceled",
+		"lock": "Pending",
+	})
+
+	// other hosts have their own history
+	cmds, _, err = ds.ListMDMAppleHostCommands(ctx, "no-such-host", fleet.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, cmds)
+
+	// the entries older than the retention period are deleted
+	_, err = ds.writer.ExecContext(ctx, `UPDATE host_mdm_apple_command_history SET created_at = ? WHERE command_uuid IN ('ack', 'err')`,
+		time.Now().Add(-fleet.HostMDMAppleCommandHistoryRetention-time.Hour))
+	require.NoError(t, err)
+	n, err := ds.CleanupHostMDMAppleCommandHistory(ctx, time.Now())
+	require.NoError(t, err)
+	require.EqualValues(t, 2, n)
+	assertHistory(map[string]string{
+		"busy": "NotNow",
+		"wipe": "Canceled",
+		"lock": "Pending",
+	})
+}
+
+func testMDMAppleTeamEnrollmentTokens(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	team, err := ds.NewTeam(ctx, &fleet.Team{Name: "test team"})
+	require.NoError(t, err)
+
+	_, err = ds.GetMDMAppleTeamEnrollmentTokenByToken(ctx, "no-such-token")
+	require.True(t, fleet.IsNotFound(err))
+
+	// the token is created for no team and for the team
+	noTeamTok, err := ds.EnsureMDMAppleTeamEnrollmentToken(ctx, nil, "tok0")
+	require.NoError(t, err)
+	require.Nil(t, noTeamTok.TeamID)
+	require.Equal(t, "tok0", noTeamTok.Token)
+	teamTok, err := ds.EnsureMDMAppleTeamEnrollmentToken(ctx, &team.ID, "tok1")
+	require.NoError(t, err)
+	require.Equal(t, &team.ID, teamTok.TeamID)
+	require.Equal(t, "tok1", teamTok.Token)
+
+	// the existing token is kept
+	teamTok, err = ds.EnsureMDMAppleTeamEnrollmentToken(ctx, &team.ID, "tok2")
+	require.NoError(t, err)
+	require.Equal(t, "tok1", teamTok.Token)
+
+	got, err := ds.GetMDMAppleTeamEnrollmentTokenByToken(ctx, "tok1")
+	require.NoError(t, err)
+	require.Equal(t, &team.ID, got.TeamID)
+	got, err = ds.GetMDMAppleTeamEnrollmentTokenByToken(ctx, "tok0")
+	require.NoError(t, err)
+	require.Nil(t, got.TeamID)
+
+	// rotate the token of the team
+	teamTok, err = ds.SetMDMAppleTeamEnrollmentToken(ctx, &team.ID, "tok3")
+	require.NoError(t, err)
+	require.Equal(t, "tok3", teamTok.Token)
+	_, err = ds.GetMDMAppleTeamEnrollmentTokenByToken(ctx, "tok1")
+	require.True(t, fleet.IsNotFound(err))
+	got, err = ds.GetMDMAppleTeamEnrollmentTokenByToken(ctx, "tok3")
+	require.NoError(t, err)
+	require.Equal(t, &team.ID, got.TeamID)
+
+	// setting a token that doesn't exist yet creates it
+	otherTeam, err := ds.NewTeam(ctx, &fleet.Team{Name: "other team"})
+	require.NoError(t, err)
+	otherTok, err := ds.SetMDMAppleTeamEnrollmentToken(ctx, &otherTeam.ID, "tok4")
+	require.NoError(t, err)
+	require.Equal(t, &otherTeam.ID, otherTok.TeamID)
+
+	// tokens are unique across teams
+	_, err = ds.SetMDMAppleTeamEnrollmentToken(ctx, &otherTeam.ID, "tok3")
+	require.Error(t, err)
+
+	// deleting the team deletes its token
+	require.NoError(t, ds.DeleteTeam(ctx, team.ID))
+	_, err = ds.GetMDMAppleTeamEnrollmentTokenByToken(ctx, "tok3")
+	require.True(t, fleet.IsNotFound(err))
+	_, err = ds.GetMDMAppleTeamEnrollmentTokenByToken(ctx, "tok0")
+	require.NoError(t, err)
+}
+
+func testMDMApplePushFailures(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	h1, h2 := &fleet.Host{UUID: "uuid-1"}, &fleet.Host{UUID: "uuid-2"}
+	nanoEnroll(t, ds, h1, false)
+	nanoEnroll(t, ds, h2, false)
+
+	_, err := ds.GetHostMDMApplePushFailure(ctx, h1.UUID)
+	require.True(t, fleet.IsNotFound(err))
+	ids, err := ds.ListMDMAppleInvalidPushTokenEnrollmentIDs(ctx, []string{h1.UUID, h2.UUID})
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	// the tokens of the enrollments are their uuid, an unknown token is ignored
+	err = ds.RecordMDMApplePushResults(ctx, []fleet.MDMApplePushResult{
+		{TokenHex: h1.UUID, Err: "too many requests"},
+		{TokenHex: h2.UUID},
+		{TokenHex: "no-such-token", Err: "bad device token", TokenInvalid: true},
+	})
+	require.NoError(t, err)
+	failure, err := ds.GetHostMDMApplePushFailure(ctx, h1.UUID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, failure.FailureCount)
+	require.Equal(t, "too many requests", failure.LastError)
+	require.False(t, failure.TokenInvalid)
+	_, err = ds.GetHostMDMApplePushFailure(ctx, h2.UUID)
+	require.True(t, fleet.IsNotFound(err))
+
+	// the token is reported as invalid
+	err = ds.RecordMDMApplePushResults(ctx, []fleet.MDMApplePushResult{
+		{TokenHex: h1.UUID, Err: "device token is inactive", TokenInvalid: true},
+	})
+	require.NoError(t, err)
+	failure, err = ds.GetHostMDMApplePushFailure(ctx, h1.UUID)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, failure.FailureCount)
+	require.Equal(t, "device token is inactive", failure.LastError)
+	require.True(t, failure.TokenInvalid)
+	ids, err = ds.ListMDMAppleInvalidPushTokenEnrollmentIDs(ctx, []string{h1.UUID, h2.UUID})
+	require.NoError(t, err)
+	require.Equal(t, []string{h1.UUID}, ids)
+
+	// the failures don't apply once the enrollment has a new token
+	_, err = ds.writer.ExecContext(ctx, `UPDATE nano_enrollments SET token_hex = 'new-token' WHERE id = ?`, h1.UUID)
+	require.NoError(t, err)
+	_, err = ds.GetHostMDMApplePushFailure(ctx, h1.UUID)
+	require.True(t, fleet.IsNotFound(err))
+	ids, err = ds.ListMDMAppleInvalidPushTokenEnrollmentIDs(ctx, []string{h1.UUID})
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	// a failure of the new token restarts the count
+	err = ds.RecordMDMApplePushResults(ctx, []fleet.MDMApplePushResult{
+		{TokenHex: "new-token", Err: "too many requests"},
+	})
+	require.NoError(t, err)
+	failure, err = ds.GetHostMDMApplePushFailure(ctx, h1.UUID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, failure.FailureCount)
+	require.False(t, failure.TokenInvalid)
+
+	// a successful push clears the failures
+	err = ds.RecordMDMApplePushResults(ctx, []fleet.MDMApplePushResult{{TokenHex: "new-token"}})
+	require.NoError(t, err)
+	_, err = ds.GetHostMDMApplePushFailure(ctx, h1.UUID)
+	require.True(t, fleet.IsNotFound(err))
+
+	// clearing the failures of the enrollment, e.g. on token update
+	err = ds.RecordMDMApplePushResults(ctx, []fleet.MDMApplePushResult{
+		{TokenHex: h2.UUID, Err: "device token is inactive", TokenInvalid: true},
+	})
+	require.NoError(t, err)
+	require.NoError(t, ds.ClearMDMApplePushFailures(ctx, h2.UUID))
+	_, err = ds.GetHostMDMApplePushFailure(ctx, h2.UUID)
+	require.True(t, fleet.IsNotFound(err))
+}
+
+func testMDMAppleReservedPayloadConflicts(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "A"})
+	require.NoError(t, err)
+
+	conflicts, err := ds.ListMDMAppleReservedPayloadConflicts(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, conflicts)
+
+	// a profile without reserved payloads is not a conflict
+	prof := configProfileForTest(t, "N1", "I1", "U1")
+	_, err = ds.NewMDMAppleConfigProfile(ctx, *prof)
+	require.NoError(t, err)
+
+	// a no-team profile with reserved payloads
+	prof = configProfileForTest(t, "N2", "I2", "U2")
+	prof.ReservedPayloadTypes = []string{"com.apple.MCX.FileVault2"}
+	prof2, err := ds.NewMDMAppleConfigProfile(ctx, *prof)
+	require.NoError(t, err)
+
+	conflicts, err = ds.ListMDMAppleReservedPayloadConflicts(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []fleet.MDMAppleReservedPayloadConflict{
+		{ProfileID: prof2.ProfileID, Name: "N2", Identifier: "I2", PayloadTypes: []string{"com.apple.MCX.FileVault2"}},
+	}, conflicts)
+
+	conflicts, err = ds.ListMDMAppleReservedPayloadConflicts(ctx, &tm.ID)
+	require.NoError(t, err)
+	require.Empty(t, conflicts)
+
+	// batch-set the team's profiles with reserved payloads
+	profA := configProfileForTest(t, "A1", "IA1", "UA1")
+	profA.ReservedPayloadTypes = []string{"com.apple.security.FDERecoveryKeyEscrow", "com.apple.MCX.FileVault2"}
+	profB := configProfileForTest(t, "B1", "IB1", "UB1")
+	err = ds.BatchSetMDMAppleProfiles(ctx, &tm.ID, []*fleet.MDMAppleConfigProfile{profA, profB})
+	require.NoError(t, err)
+
+	conflicts, err = ds.ListMDMAppleReservedPayloadConflicts(ctx, &tm.ID)
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	require.Equal(t, "IA1", conflicts[0].Identifier)
+	require.Equal(t, []string{"com.apple.security.FDERecoveryKeyEscrow", "com.apple.MCX.FileVault2"}, conflicts[0].PayloadTypes)
+
+	// editing the profile to remove the reserved payloads clears the conflict
+	profA.ReservedPayloadTypes = nil
+	err = ds.BatchSetMDMAppleProfiles(ctx, &tm.ID, []*fleet.MDMAppleConfigProfile{profA, profB})
+	require.NoError(t, err)
+
+	conflicts, err = ds.ListMDMAppleReservedPayloadConflicts(ctx, &tm.ID)
+	require.NoError(t, err)
+	require.Empty(t, conflicts)
+}
+
+func testMDMAppleProfileRetryableFailures(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	upsertPending := func(cmdUUID string) {
+		require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, []*fleet.MDMAppleBulkUpsertHostProfilePayload{{
+			ProfileID:         uint(1),
+			ProfileIdentifier: "p1",
+			ProfileName:       "name1",
+			HostUUID:          "h1",
+			CommandUUID:       cmdUUID,
+			OperationType:     fleet.MDMAppleOperationTypeInstall,
+			Status:            &fleet.MDMAppleDeliveryPending,
+			Checksum:          []byte("csum"),
+		}}))
+	}
+
+	type profileState struct {
+		Status        *fleet.MDMAppleDeliveryStatus `db:"status"`
+		FailureCount  int                           `db:"failure_count"`
+		FirstFailedAt *time.Time                    `db:"first_failed_at"`
+	}
+	getState := func() profileState {
+		var state profileState
+		ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+			return sqlx.GetContext(ctx, q, &state, `SELECT status, failure_count, first_failed_at FROM host_mdm_apple_profiles WHERE host_uuid = 'h1' AND profile_id = 1`)
+		})
+		return state
+	}
+	failure := func(cmdUUID string) *fleet.HostMDMAppleProfile {
+		return &fleet.HostMDMAppleProfile{
+			CommandUUID:   cmdUUID,
+			HostUUID:      "h1",
+			Status:        &fleet.MDMAppleDeliveryFailed,
+			Detail:        "MDMClientError (12021): timeout",
+			OperationType: fleet.MDMAppleOperationTypeInstall,
+		}
+	}
+
+	grace := fleet.MacOSProfileFailureGracePeriod{RetryableErrorCodes: []int{12021}, MinConsecutiveFailures: 2}
+
+	// unknown command, nothing to do
+	failed, err := ds.UpdateHostMDMAppleProfileRetryableFailure(ctx, failure("no-such-command"), grace)
+	require.NoError(t, err)
+	require.False(t, failed)
+
+	// first failure queues the profile again
+	upsertPending("c1")
+	failed, err = ds.UpdateHostMDMAppleProfileRetryableFailure(ctx, failure("c1"), grace)
+	require.NoError(t, err)
+	require.False(t, failed)
+	state := getState()
+	require.Nil(t, state.Status)
+	require.Equal(t, 1, state.FailureCount)
+	require.NotNil(t, state.FirstFailedAt)
+
+	// a NotNow answer keeps the count
+	upsertPending("c2")
+	require.NoError(t, ds.UpdateOrDeleteHostMDMAppleProfile(ctx, &fleet.HostMDMAppleProfile{
+		CommandUUID:   "c2",
+		HostUUID:      "h1",
+		Status:        &fleet.MDMAppleDeliveryPending,
+		OperationType: fleet.MDMAppleOperationTypeInstall,
+	}))
+	require.Equal(t, 1, getState().FailureCount)
+
+	// second consecutive failure marks it as failed and resets the count
+	failed, err = ds.UpdateHostMDMAppleProfileRetryableFailure(ctx, failure("c2"), grace)
+	require.NoError(t, err)
+	require.True(t, failed)
+	state = getState()
+	require.NotNil(t, state.Status)
+	require.Equal(t, fleet.MDMAppleDeliveryFailed, *state.Status)
+	require.Zero(t, state.FailureCount)
+	require.Nil(t, state.FirstFailedAt)
+
+	// with a failure window, the profile is queued again until the window is over
+	grace = fleet.MacOSProfileFailureGracePeriod{RetryableErrorCodes: []int{12021}, MinConsecutiveFailures: 1, MinFailureWindow: fleet.Duration{Duration: time.Hour}}
+	upsertPending("c3")
+	failed, err = ds.UpdateHostMDMAppleProfileRetryableFailure(ctx, failure("c3"), grace)
+	require.NoError(t, err)
+	require.False(t, failed)
+	require.Equal(t, 1, getState().FailureCount)
+
+	// a success resets the count
+	upsertPending("c4")
+	require.NoError(t, ds.UpdateOrDeleteHostMDMAppleProfile(ctx, &fleet.HostMDMAppleProfile{
+		CommandUUID:   "c4",
+		HostUUID:      "h1",
+		Status:        &fleet.MDMAppleDeliveryVerifying,
+		OperationType: fleet.MDMAppleOperationTypeInstall,
+	}))
+	state = getState()
+	require.NotNil(t, state.Status)
+	require.Equal(t, fleet.MDMAppleDeliveryVerifying, *state.Status)
+	require.Zero(t, state.FailureCount)
+	require.Nil(t, state.FirstFailedAt)
+}
+
+func testMDMAppleProfileRetryPolicy(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	h1 := test.NewHost(t, ds, "h1.local", "1.1.1.1", "1", "h1", time.Now())
+	h2 := test.NewHost(t, ds, "h2.local", "1.1.1.2", "2", "h2", time.Now())
+	require.NoError(t, ds.AddHostsToTeam(ctx, &tm.ID, []uint{h2.ID}))
+
+	upsertFailed := func(hostUUID, cmdUUID string) {
+		require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, []*fleet.MDMAppleBulkUpsertHostProfilePayload{{
+			ProfileID:         uint(1),
+			ProfileIdentifier: "p1",
+			ProfileName:       "name1",
+			HostUUID:          hostUUID,
+			CommandUUID:       cmdUUID,
+			OperationType:     fleet.MDMAppleOperationTypeInstall,
+			Status:            &fleet.MDMAppleDeliveryFailed,
+			Checksum:          []byte("csum"),
+		}}))
+	}
+
+	type profileState struct {
+		Status     *fleet.MDMAppleDeliveryStatus `db:"status"`
+		RetryCount int                           `db:"retry_count"`
+		RetryAt    *time.Time                    `db:"retry_at"`
+	}
+	getState := func(hostUUID string) profileState {
+		var state profileState
+		ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+			return sqlx.GetContext(ctx, q, &state, `SELECT status, retry_count, retry_at FROM host_mdm_apple_profiles WHERE host_uuid = ? AND profile_id = 1`, hostUUID)
+		})
+		return state
+	}
+	makeDue := func(hostUUID string) {
+		ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+			_, err := q.ExecContext(ctx, `UPDATE host_mdm_apple_profiles SET retry_at = NOW() - INTERVAL 1 SECOND WHERE host_uuid = ?`, hostUUID)
+			return err
+		})
+	}
+
+	// the policies of the teams are loaded in a single query
+	tm2, err := ds.NewTeam(ctx, &fleet.Team{Name: "team2"})
+	require.NoError(t, err)
+	tm2.Config.MDM.MacOSSettings.ProfileRetryPolicy = fleet.MacOSProfileRetryPolicy{MaxRetries: 4, MaxBackoff: fleet.Duration{Duration: time.Hour}}
+	_, err = ds.SaveTeam(ctx, tm2)
+	require.NoError(t, err)
+	policies, err := ds.ListTeamsMacOSProfileRetryPolicies(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[uint]fleet.MacOSProfileRetryPolicy{
+		tm.ID:  {},
+		tm2.ID: {MaxRetries: 4, MaxBackoff: fleet.Duration{Duration: time.Hour}},
+	}, policies)
+
+	policy := fleet.MacOSProfileRetryPolicy{MaxRetries: 2, InitialBackoff: fleet.Duration{Duration: time.Hour}}
+	upsertFailed(h1.UUID, "c1")
+	upsertFailed(h2.UUID, "c2")
+
+	// a disabled policy does nothing
+	n, err := ds.RetryFailedHostMDMAppleProfiles(ctx, 0, fleet.MacOSProfileRetryPolicy{})
+	require.NoError(t, err)
+	require.Zero(t, n)
+	require.Nil(t, getState(h1.UUID).RetryAt)
+
+	// the first run schedules the retry of the failed profile of the hosts in
+	// no team, after the initial backoff
+	n, err = ds.RetryFailedHostMDMAppleProfiles(ctx, 0, policy)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	state := getState(h1.UUID)
+	require.Zero(t, state.RetryCount)
+	require.NotNil(t, state.RetryAt)
+	require.WithinDuration(t, time.Now().Add(time.Hour), *state.RetryAt, time.Minute)
+	require.Nil(t, getState(h2.UUID).RetryAt)
+
+	// once due, the profile is queued again
+	makeDue(h1.UUID)
+	n, err = ds.RetryFailedHostMDMAppleProfiles(ctx, 0, policy)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, n)
+	state = getState(h1.UUID)
+	require.Nil(t, state.Status)
+	require.Equal(t, 1, state.RetryCount)
+	require.Nil(t, state.RetryAt)
+
+	// it fails again, the backoff doubles
+	upsertFailed(h1.UUID, "c3")
+	_, err = ds.RetryFailedHostMDMAppleProfiles(ctx, 0, policy)
+	require.NoError(t, err)
+	state = getState(h1.UUID)
+	require.NotNil(t, state.RetryAt)
+	require.WithinDuration(t, time.Now().Add(2*time.Hour), *state.RetryAt, time.Minute)
+
+	// after the maximum of retries, it is not scheduled anymore
+	makeDue(h1.UUID)
+	n, err = ds.RetryFailedHostMDMAppleProfiles(ctx, 0, policy)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, n)
+	upsertFailed(h1.UUID, "c4")
+	_, err = ds.RetryFailedHostMDMAppleProfiles(ctx, 0, policy)
+	require.NoError(t, err)
+	state = getState(h1.UUID)
+	require.Equal(t, 2, state.RetryCount)
+	require.Nil(t, state.RetryAt)
+
+	// forcing the retry queues the profile and resets the retries
+	n, err = ds.ResetFailedHostMDMAppleProfile(ctx, 1, h1.UUID, 0)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, n)
+	state = getState(h1.UUID)
+	require.Nil(t, state.Status)
+	require.Zero(t, state.RetryCount)
+
+	// nothing to force if the profile did not fail
+	n, err = ds.ResetFailedHostMDMAppleProfile(ctx, 1, h1.UUID, 0)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// forcing the retry for a team only affects the hosts of the team
+	upsertFailed(h1.UUID, "c5")
+	n, err = ds.ResetFailedHostMDMAppleProfile(ctx, 1, "", tm.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, n)
+	require.Nil(t, getState(h2.UUID).Status)
+	require.NotNil(t, getState(h1.UUID).Status)
+
+	// a successful installation resets the retries
+	upsertFailed(h1.UUID, "c6")
+	makeDue(h1.UUID)
+	_, err = ds.RetryFailedHostMDMAppleProfiles(ctx, 0, policy)
+	require.NoError(t, err)
+	require.Equal(t, 1, getState(h1.UUID).RetryCount)
+	require.NoError(t, ds.UpdateOrDeleteHostMDMAppleProfile(ctx, &fleet.HostMDMAppleProfile{
+		CommandUUID:   "c6",
+		HostUUID:      h1.UUID,
+		Status:        &fleet.MDMAppleDeliveryVerifying,
+		OperationType: fleet.MDMAppleOperationTypeInstall,
+	}))
+	require.Zero(t, getState(h1.UUID).RetryCount)
+}
+
+func testMDMAppleHostCertificates(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	h1 := test.NewHost(t, ds, "h1.local", "1.1.1.1", "1", "1", time.Now())
+	h2 := test.NewHost(t, ds, "h2.local", "1.1.1.2", "2", "2", time.Now())
+	// h3 is not enrolled in MDM
+	test.NewHost(t, ds, "h3.local", "1.1.1.3", "3", "3", time.Now())
+	nanoEnroll(t, ds, h1, false)
+	nanoEnroll(t, ds, h2, true)
+
+	uuids, err := ds.ListMDMAppleHostUUIDsToRefreshCertificates(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{h1.UUID, h2.UUID}, uuids)
+
+	uuids, err = ds.ListMDMAppleHostUUIDsToRefreshCertificates(ctx, time.Hour, 1)
+	require.NoError(t, err)
+	require.Len(t, uuids, 1)
+
+	require.NoError(t, ds.SetMDMAppleHostCertificatesRefreshRequested(ctx, []string{h1.UUID}))
+	uuids, err = ds.ListMDMAppleHostUUIDsToRefreshCertificates(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{h2.UUID}, uuids)
+
+	// requested more than the interval ago
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `UPDATE host_mdm_apple_certificate_refreshes SET requested_at = DATE_SUB(NOW(), INTERVAL 2 HOUR)`)
+		return err
+	})
+	uuids, err = ds.ListMDMAppleHostUUIDsToRefreshCertificates(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{h1.UUID, h2.UUID}, uuids)
+
+	soon, later := time.Now().UTC().Add(24*time.Hour).Truncate(time.Second), time.Date(2043, 1, 1, 0, 0, 0, 0, time.UTC)
+	wifi := &fleet.HostMDMCertificate{SHA1Sum: strings.Repeat("a", 40), CommonName: "Wi-Fi", Issuer: "CA", SerialNumber: "1", NotValidAfter: &soon, IsIdentity: true}
+	vpn := &fleet.HostMDMCertificate{SHA1Sum: strings.Repeat("b", 40), CommonName: "VPN", Issuer: "CA", SerialNumber: "2", NotValidAfter: &later}
+	root := &fleet.HostMDMCertificate{SHA1Sum: strings.Repeat("c", 40), CommonName: "Root CA"}
+
+	require.NoError(t, ds.ReplaceHostMDMAppleCertificates(ctx, h1.UUID, []*fleet.HostMDMCertificate{wifi, vpn, root}))
+	require.NoError(t, ds.UpdateHostMDMAppleManagedCertificates(ctx, h1.UUID, []*fleet.HostMDMCertificate{wifi, vpn}))
+
+	certs, meta, err := ds.ListHostMDMAppleCertificates(ctx, h1.UUID, fleet.ListOptions{PerPage: 10})
+	require.NoError(t, err)
+	require.False(t, meta.HasNextResults)
+	require.Len(t, certs, 3)
+	// sorted by expiration, certificates without expiration first
+	require.Equal(t, "Root CA", certs[0].CommonName)
+	require.Equal(t, fleet.HostMDMCertificateOriginOther, certs[0].Origin)
+	require.Equal(t, "Wi-Fi", certs[1].CommonName)
+	require.Equal(t, fleet.HostMDMCertificateOriginMDM, certs[1].Origin)
+	require.True(t, certs[1].IsIdentity)
+	require.Equal(t, soon, *certs[1].NotValidAfter)
+	require.Equal(t, "VPN", certs[2].CommonName)
+	require.Equal(t, fleet.HostMDMCertificateOriginMDM, certs[2].Origin)
+
+	certs, meta, err = ds.ListHostMDMAppleCertificates(ctx, h1.UUID, fleet.ListOptions{PerPage: 2})
+	require.NoError(t, err)
+	require.True(t, meta.HasNextResults)
+	require.Len(t, certs, 2)
+
+	// the vpn certificate was removed, the origin of the others is kept
+	require.NoError(t, ds.ReplaceHostMDMAppleCertificates(ctx, h1.UUID, []*fleet.HostMDMCertificate{wifi, root}))
+	certs, _, err = ds.ListHostMDMAppleCertificates(ctx, h1.UUID, fleet.ListOptions{PerPage: 10})
+	require.NoError(t, err)
+	require.Len(t, certs, 2)
+	require.Equal(t, fleet.HostMDMCertificateOriginMDM, certs[1].Origin)
+
+	// the wifi certificate is not managed anymore
+	require.NoError(t, ds.UpdateHostMDMAppleManagedCertificates(ctx, h1.UUID, nil))
+	certs, _, err = ds.ListHostMDMAppleCertificates(ctx, h1.UUID, fleet.ListOptions{PerPage: 10})
+	require.NoError(t, err)
+	require.Len(t, certs, 2)
+	require.Equal(t, fleet.HostMDMCertificateOriginOther, certs[1].Origin)
+
+	// filter hosts by certificate expiration
+	require.NoError(t, ds.ReplaceHostMDMAppleCertificates(ctx, h2.UUID, []*fleet.HostMDMCertificate{vpn}))
+	userFilter := fleet.TeamFilter{User: test.UserAdmin}
+	hosts, err := ds.ListHosts(ctx, userFilter, fleet.HostListOptions{CertificateExpiringWithinDaysFilter: ptr.Int(7)})
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	require.Equal(t, h1.ID, hosts[0].ID)
+	hosts, err = ds.ListHosts(ctx, userFilter, fleet.HostListOptions{CertificateExpiringWithinDaysFilter: ptr.Int(365 * 30)})
+	require.NoError(t, err)
+	require.Len(t, hosts, 2)
+
+	// no certificates left
+	require.NoError(t, ds.ReplaceHostMDMAppleCertificates(ctx, h1.UUID, nil))
+	certs, _, err = ds.ListHostMDMAppleCertificates(ctx, h1.UUID, fleet.ListOptions{PerPage: 10})
+	require.NoError(t, err)
+	require.Empty(t, certs)
+}
+
+func testMDMAppleConfigProfilesBulkOperations(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm1, err := ds.NewTeam(ctx, &fleet.Team{Name: "tm1"})
+	require.NoError(t, err)
+	tm2, err := ds.NewTeam(ctx, &fleet.Team{Name: "tm2"})
+	require.NoError(t, err)
+
+	cp1, err := ds.NewMDMAppleConfigProfile(ctx, *generateCP("N1", "I1", 0))
+	require.NoError(t, err)
+	cp2, err := ds.NewMDMAppleConfigProfile(ctx, *generateCP("N1", "I1", tm1.ID))
+	require.NoError(t, err)
+	cp3, err := ds.NewMDMAppleConfigProfile(ctx, *generateCP("N2", "I2", tm1.ID))
+	require.NoError(t, err)
+
+	profs, err := ds.ListMDMAppleConfigProfilesByIDsOrIdentifiers(ctx, nil, nil)
+	require.NoError(t, err)
+	require.Empty(t, profs)
+
+	profs, err = ds.ListMDMAppleConfigProfilesByIDsOrIdentifiers(ctx, []uint{cp3.ProfileID}, []string{"I1"})
+	require.NoError(t, err)
+	require.Len(t, profs, 3)
+	require.Equal(t, cp1.ProfileID, profs[0].ProfileID)
+	require.Equal(t, cp2.ProfileID, profs[1].ProfileID)
+	require.Equal(t, cp3.ProfileID, profs[2].ProfileID)
+	require.Equal(t, "N2", profs[2].Name)
+	require.NotEmpty(t, profs[2].Mobileconfig)
+
+	// copy to the other team and no team, fails if a team already has it
+	_, err = ds.CopyMDMAppleConfigProfileToTeams(ctx, cp3, []uint{tm2.ID, tm1.ID})
+	require.Error(t, err)
+	var existsErr *existsError
+	require.ErrorAs(t, err, &existsErr)
+	profs, err = ds.ListMDMAppleConfigProfilesByIDsOrIdentifiers(ctx, nil, []string{"I2"})
+	require.NoError(t, err)
+	require.Len(t, profs, 1)
+
+	copies, err := ds.CopyMDMAppleConfigProfileToTeams(ctx, cp3, []uint{tm2.ID, 0})
+	require.NoError(t, err)
+	require.Len(t, copies, 2)
+	require.Equal(t, tm2.ID, *copies[0].TeamID)
+	require.Equal(t, uint(0), *copies[1].TeamID)
+	got, err := ds.GetMDMAppleConfigProfile(ctx, copies[0].ProfileID)
+	require.NoError(t, err)
+	require.Equal(t, "N2", got.Name)
+	require.Equal(t, "I2", got.Identifier)
+	require.Equal(t, cp3.Mobileconfig, got.Mobileconfig)
+
+	// deleting fails without deleting anything if a profile does not exist
+	err = ds.DeleteMDMAppleConfigProfiles(ctx, []uint{cp1.ProfileID, 9999})
+	require.True(t, fleet.IsNotFound(err))
+	_, err = ds.GetMDMAppleConfigProfile(ctx, cp1.ProfileID)
+	require.NoError(t, err)
+
+	require.NoError(t, ds.DeleteMDMAppleConfigProfiles(ctx, []uint{cp1.ProfileID, copies[0].ProfileID}))
+	profs, err = ds.ListMDMAppleConfigProfilesByIDsOrIdentifiers(ctx, nil, []string{"I1", "I2"})
+	require.NoError(t, err)
+	require.Len(t, profs, 3)
+	for _, p := range profs {
+		require.NotEqual(t, cp1.ProfileID, p.ProfileID)
+		require.NotEqual(t, copies[0].ProfileID, p.ProfileID)
+	}
+}
+
+func testMDMAppleEnrollmentMismatches(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+	const fleetURL = "https://fleet.example.com/mdm/apple/mdm"
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "tm"})
+	require.NoError(t, err)
+
+	var hosts []*fleet.Host
+	for i := 0; i < 6; i++ {
+		hosts = append(hosts, test.NewHost(t, ds, fmt.Sprintf("h%d.local", i), fmt.Sprintf("1.1.1.%d", i), fmt.Sprint(i), fmt.Sprint(i), time.Now()))
+	}
+	// hosts [0], [1], [4] and [5] are enrolled in Fleet's MDM
+	for _, i := range []int{0, 1, 4, 5} {
+		nanoEnroll(t, ds, hosts[i], false)
+	}
+	// host [5] is in the team
+	require.NoError(t, ds.AddHostsToTeam(ctx, &tm.ID, []uint{hosts[5].ID}))
+
+	mismatches, err := ds.ListMDMAppleEnrollmentMismatches(ctx, nil, fleetURL)
+	require.NoError(t, err)
+	require.Empty(t, mismatches)
+
+	// [0] agrees, [1] says it's not enrolled, [2] says it's enrolled in Fleet,
+	// [3] is enrolled in another MDM, [4] is enrolled in another MDM, [5] says
+	// it's not enrolled.
+	reports := []*fleet.OrbitMDMEnrollmentStatus{
+		{Enrolled: true, ServerURL: fleetURL, Profiles: []string{"com.fleetdm.fleetd.config"}},
+		{Enrolled: false},
+		{Enrolled: true, ServerURL: fleetURL},
+		{Enrolled: true, ServerURL: "https://other.example.com/mdm"},
+		{Enrolled: true, ServerURL: "https://other.example.com/mdm"},
+		{Enrolled: false},
+	}
+	for i, r := range reports {
+		require.NoError(t, ds.SetOrUpdateHostOrbitMDMStatus(ctx, hosts[i].ID, r))
+	}
+
+	mismatches, err = ds.ListMDMAppleEnrollmentMismatches(ctx, nil, fleetURL)
+	require.NoError(t, err)
+	require.Len(t, mismatches, 3)
+	require.Equal(t, hosts[1].ID, mismatches[0].HostID)
+	require.True(t, mismatches[0].ServerEnrolled)
+	require.False(t, mismatches[0].DeviceEnrolled)
+	require.Equal(t, "h1.local", mismatches[0].DisplayName)
+	require.NotZero(t, mismatches[0].ReportedAt)
+	require.Equal(t, hosts[2].ID, mismatches[1].HostID)
+	require.False(t, mismatches[1].ServerEnrolled)
+	require.True(t, mismatches[1].DeviceEnrolled)
+	require.Equal(t, fleetURL, mismatches[1].DeviceServerURL)
+	require.Equal(t, hosts[4].ID, mismatches[2].HostID)
+	require.True(t, mismatches[2].ServerEnrolled)
+	require.True(t, mismatches[2].DeviceEnrolled)
+	require.Equal(t, "https://other.example.com/mdm", mismatches[2].DeviceServerURL)
+
+	mismatches, err = ds.ListMDMAppleEnrollmentMismatches(ctx, &tm.ID, fleetURL)
+	require.NoError(t, err)
+	require.Len(t, mismatches, 1)
+	require.Equal(t, hosts[5].ID, mismatches[0].HostID)
+
+	// host [1] reports again, now enrolled
+	require.NoError(t, ds.SetOrUpdateHostOrbitMDMStatus(ctx, hosts[1].ID, &fleet.OrbitMDMEnrollmentStatus{Enrolled: true, ServerURL: fleetURL}))
+	mismatches, err = ds.ListMDMAppleEnrollmentMismatches(ctx, nil, fleetURL)
+	require.NoError(t, err)
+	require.Len(t, mismatches, 2)
+	require.Equal(t, hosts[2].ID, mismatches[0].HostID)
+	require.Equal(t, hosts[4].ID, mismatches[1].HostID)
+}
+
+func testMDMAppleHostsMissingFleetd(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "tm"})
+	require.NoError(t, err)
+
+	var hosts []*fleet.Host
+	for i := 0; i < 4; i++ {
+		hosts = append(hosts, test.NewHost(t, ds, fmt.Sprintf("h%d.local", i), fmt.Sprintf("1.1.1.%d", i), fmt.Sprint(i), fmt.Sprint(i), time.Now()))
+	}
+	// hosts [0], [1] and [3] are enrolled in Fleet's MDM, [2] is not
+	for _, i := range []int{0, 1, 3} {
+		nanoEnroll(t, ds, hosts[i], false)
+	}
+	// host [3] is in the team
+	require.NoError(t, ds.AddHostsToTeam(ctx, &tm.ID, []uint{hosts[3].ID}))
+
+	// all hosts have fleetd
+	missing, err := ds.ListMDMAppleHostsMissingFleetd(ctx, nil, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Empty(t, missing)
+
+	// fleetd never enrolled on hosts [1], [2] and [3]
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `UPDATE hosts SET node_key = NULL, orbit_node_key = NULL WHERE id IN (?, ?, ?)`, hosts[1].ID, hosts[2].ID, hosts[3].ID)
+		return err
+	})
+
+	missing, err = ds.ListMDMAppleHostsMissingFleetd(ctx, nil, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, missing, 1)
+	require.Equal(t, hosts[1].ID, missing[0].HostID)
+	require.Equal(t, "h1.local", missing[0].DisplayName)
+	require.Equal(t, hosts[1].UUID, missing[0].UUID)
+	require.NotZero(t, missing[0].MDMEnrolledAt)
+
+	missing, err = ds.ListMDMAppleHostsMissingFleetd(ctx, &tm.ID, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, missing, 1)
+	require.Equal(t, hosts[3].ID, missing[0].HostID)
+
+	// the hosts enrolled recently are not reported
+	missing, err = ds.ListMDMAppleHostsMissingFleetd(ctx, nil, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, missing)
+}
+
+func testMDMAppleProfilesPreview(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+
+	newHost := func(name string, teamID *uint, enroll bool) *fleet.Host {
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:      name,
+			OsqueryHostID: ptr.String(name),
+			NodeKey:       ptr.String(name),
+			UUID:          name,
+			TeamID:        teamID,
+			Platform:      "darwin",
+		})
+		require.NoError(t, err)
+		if enroll {
+			nanoEnroll(t, ds, h, false)
+		}
+		return h
+	}
+	installed := func(h *fleet.Host, profs ...*fleet.MDMAppleConfigProfile) {
+		var payload []*fleet.MDMAppleBulkUpsertHostProfilePayload
+		for _, p := range profs {
+			payload = append(payload, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+				ProfileID:         p.ProfileID,
+				ProfileIdentifier: p.Identifier,
+				ProfileName:       p.Name,
+				HostUUID:          h.UUID,
+				CommandUUID:       uuid.NewString(),
+				OperationType:     fleet.MDMAppleOperationTypeInstall,
+				Status:            &fleet.MDMAppleDeliveryVerifying,
+				Checksum:          fleet.MDMProfileChecksumMD5.Sum(p.Mobileconfig),
+			})
+		}
+		require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, payload))
+	}
+	expectPreview := func(tmID *uint, profs []*fleet.MDMAppleConfigProfile, enrolled, install, remove uint) {
+		preview, err := ds.GetMDMAppleProfilesPreview(ctx, tmID, profs)
+		require.NoError(t, err)
+		require.Equal(t, &fleet.MDMAppleProfilesPreview{
+			TeamID:        tmID,
+			EnrolledHosts: enrolled,
+			InstallHosts:  install,
+			RemoveHosts:   remove,
+		}, preview)
+	}
+
+	// no hosts
+	expectPreview(nil, []*fleet.MDMAppleConfigProfile{configProfileForTest(t, "N1", "I1", "a")}, 0, 0, 0)
+
+	noTm1 := newHost("no-team-1", nil, true)
+	noTm2 := newHost("no-team-2", nil, true)
+	newHost("no-team-unenrolled", nil, false)
+	tm1 := newHost("team-1", &tm.ID, true)
+
+	// apply the current profiles of no team and of the team
+	require.NoError(t, ds.BatchSetMDMAppleProfiles(ctx, nil, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N1", "I1", "a"),
+		configProfileForTest(t, "N2", "I2", "b"),
+	}))
+	require.NoError(t, ds.BatchSetMDMAppleProfiles(ctx, &tm.ID, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N1", "I1", "c"),
+	}))
+	noTmProfs, err := ds.ListMDMAppleConfigProfiles(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, noTmProfs, 2)
+	tmProfs, err := ds.ListMDMAppleConfigProfiles(ctx, &tm.ID)
+	require.NoError(t, err)
+	require.Len(t, tmProfs, 1)
+
+	// noTm1 has all profiles installed, noTm2 only one of them
+	installed(noTm1, noTmProfs...)
+	installed(noTm2, noTmProfs[0])
+	installed(tm1, tmProfs...)
+
+	// same profiles, only noTm2 needs to install
+	expectPreview(nil, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N1", "I1", "a"),
+		configProfileForTest(t, "N2", "I2", "b"),
+	}, 2, 1, 0)
+
+	// remove I2, nothing to install, only noTm1 has it installed
+	expectPreview(nil, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N1", "I1", "a"),
+	}, 2, 0, 1)
+
+	// edit I1 and remove I2, all hosts need to install
+	expectPreview(nil, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N1", "I1", "z"),
+	}, 2, 2, 1)
+
+	// remove everything
+	expectPreview(nil, nil, 2, 0, 2)
+
+	// team profiles are independent of no team
+	expectPreview(&tm.ID, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N1", "I1", "c"),
+	}, 1, 0, 0)
+	expectPreview(&tm.ID, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N1", "I1", "c"),
+		configProfileForTest(t, "N3", "I3", "d"),
+	}, 1, 1, 0)
+	expectPreview(&tm.ID, nil, 1, 0, 1)
+}
+
+func testMDMAppleSCEPCertificates(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	caCert, caKey, err := apple_mdm.NewSCEPCACertKey()
+	require.NoError(t, err)
+	depot, err := ds.NewSCEPDepot(tokenpki.PEMCertificate(caCert.Raw), tokenpki.PEMRSAPrivateKey(caKey))
+	require.NoError(t, err)
+	_, mdmStorage := createMDMAppleCommanderAndStorage(t, ds)
+
+	// issue a certificate that expires in the provided duration, returns its
+	// serial and fingerprint
+	issue := func(name string, expiresIn time.Duration) (int64, string) {
+		serial, err := depot.Serial()
+		require.NoError(t, err)
+		crt := &x509.Certificate{
+			SerialNumber: serial,
+			Subject:      pkix.Name{CommonName: "fleet-identity"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(expiresIn),
+			Raw:          []byte(name),
+		}
+		require.NoError(t, depot.Put("fleet-identity", crt))
+		return serial.Int64(), fmt.Sprintf("%x", sha256.Sum256(crt.Raw))
+	}
+	associate := func(h *fleet.Host, sum string) {
+		err := mdmStorage.AssociateCertHash(&mdm.Request{
+			EnrollID: &mdm.EnrollID{Type: mdm.Device, ID: h.UUID},
+			Context:  ctx,
+		}, sum)
+		require.NoError(t, err)
+	}
+	expectSerials := func(filter fleet.TeamFilter, opt fleet.MDMAppleSCEPCertificateListOptions, want ...int64) []*fleet.MDMAppleSCEPCertificate {
+		certs, _, err := ds.ListMDMAppleSCEPCertificates(ctx, filter, opt)
+		require.NoError(t, err)
+		got := make([]int64, 0, len(certs))
+		for _, c := range certs {
+			got = append(got, c.Serial)
+		}
+		require.Equal(t, want, got)
+		return certs
+	}
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	h1 := test.NewHost(t, ds, "host1", "1", "host1key", "host1uuid", time.Now())
+	require.NoError(t, ds.AddHostsToTeam(ctx, &tm.ID, []uint{h1.ID}))
+	h2 := test.NewHost(t, ds, "host2", "2", "host2key", "host2uuid", time.Now())
+
+	c1, sum1 := issue("c1", 10*24*time.Hour)
+	c2, sum2 := issue("c2", 365*24*time.Hour)
+	c3, sum3 := issue("c3", 20*24*time.Hour)
+	c4, _ := issue("c4", 5*24*time.Hour)
+	c5, _ := issue("c5", -time.Hour)
+
+	// h1 enrolls with c1 and then renews it with c2, h2 enrolls with c3
+	associate(h1, sum1)
+	associate(h1, sum2)
+	associate(h2, sum3)
+	// associating again is a no-op
+	associate(h1, sum2)
+
+	globalFilter := fleet.TeamFilter{User: test.UserAdmin}
+	certs := expectSerials(globalFilter, fleet.MDMAppleSCEPCertificateListOptions{}, c5, c4, c1, c3, c2)
+	bySerial := make(map[int64]*fleet.MDMAppleSCEPCertificate, len(certs))
+	for _, c := range certs {
+		bySerial[c.Serial] = c
+	}
+	require.Equal(t, "fleet-identity", bySerial[c1].CommonName)
+	require.NotNil(t, bySerial[c1].SHA256Sum)
+	require.Equal(t, sum1, *bySerial[c1].SHA256Sum)
+	require.NotNil(t, bySerial[c1].HostID)
+	require.Equal(t, h1.ID, *bySerial[c1].HostID)
+	require.Equal(t, h1.Hostname, *bySerial[c1].Hostname)
+	require.Nil(t, bySerial[c1].RenewedFromSerial)
+	require.Equal(t, &c2, bySerial[c1].RenewedBySerial)
+	require.Equal(t, &c1, bySerial[c2].RenewedFromSerial)
+	require.Nil(t, bySerial[c2].RenewedBySerial)
+	require.Nil(t, bySerial[c3].RenewedFromSerial)
+	require.Nil(t, bySerial[c4].HostUUID)
+	require.Nil(t, bySerial[c4].HostID)
+
+	// certificates expiring in the next 30 days
+	expectSerials(globalFilter, fleet.MDMAppleSCEPCertificateListOptions{ExpiresWithinDays: ptr.Uint(30)}, c4, c1, c3)
+	expectSerials(globalFilter, fleet.MDMAppleSCEPCertificateListOptions{ExpiresWithinDays: ptr.Uint(30), ExcludeRenewed: true}, c4, c3)
+
+	// certificates of a host
+	expectSerials(globalFilter, fleet.MDMAppleSCEPCertificateListOptions{HostID: &h1.ID}, c1, c2)
+
+	// search by hostname
+	expectSerials(globalFilter, fleet.MDMAppleSCEPCertificateListOptions{ListOptions: fleet.ListOptions{MatchQuery: "host2"}}, c3)
+
+	// order and pagination
+	certs, meta, err := ds.ListMDMAppleSCEPCertificates(ctx, globalFilter, fleet.MDMAppleSCEPCertificateListOptions{
+		ListOptions: fleet.ListOptions{OrderKey: "serial", OrderDirection: fleet.OrderDescending, PerPage: 2},
+	})
+	require.NoError(t, err)
+	require.Len(t, certs, 2)
+	require.Equal(t, c5, certs[0].Serial)
+	require.Equal(t, c4, certs[1].Serial)
+	require.Equal(t, &fleet.PaginationMetadata{HasNextResults: true}, meta)
+
+	// a team user only sees the certificates of the hosts of the team
+	tmFilter := fleet.TeamFilter{User: &fleet.User{Teams: []fleet.UserTeam{{Team: *tm, Role: fleet.RoleObserver}}}, IncludeObserver: true}
+	expectSerials(tmFilter, fleet.MDMAppleSCEPCertificateListOptions{}, c1, c2)
+
+	// the identity certificates are those of the enrolled hosts that weren't
+	// renewed
+	expectIdentities := func(afterSerial int64, limit uint, want ...int64) {
+		certs, err := ds.ListMDMAppleSCEPIdentityCertificates(ctx, afterSerial, limit)
+		require.NoError(t, err)
+		got := make([]int64, 0, len(certs))
+		for _, c := range certs {
+			got = append(got, c.Serial)
+			require.NotEmpty(t, c.HostUUID)
+			require.NotEmpty(t, c.CertificatePEM)
+		}
+		require.Equal(t, want, got)
+	}
+	expectIdentities(0, 10)
+	nanoEnroll(t, ds, h1, false)
+	expectIdentities(0, 10, c2)
+	nanoEnroll(t, ds, h2, false)
+	expectIdentities(0, 10, c2, c3)
+	expectIdentities(0, 1, c2)
+	expectIdentities(c2, 1, c3)
+	expectIdentities(c3, 1)
+}
+
+func testMDMAppleProfileExclusions(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	var hosts []*fleet.Host
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("host-%d", i)
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:      name,
+			OsqueryHostID: ptr.String(name),
+			NodeKey:       ptr.String(name),
+			UUID:          name,
+			Platform:      "darwin",
+		})
+		require.NoError(t, err)
+		nanoEnroll(t, ds, h, false)
+		hosts = append(hosts, h)
+	}
+
+	lbl, err := ds.NewLabel(ctx, &fleet.Label{Name: "kiosks", Query: "select 1"})
+	require.NoError(t, err)
+	err = ds.RecordLabelQueryExecutions(ctx, hosts[1], map[uint]*bool{lbl.ID: ptr.Bool(true)}, time.Now(), false)
+	require.NoError(t, err)
+
+	require.NoError(t, ds.BatchSetMDMAppleProfiles(ctx, nil, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N1", "I1", "a"),
+		configProfileForTest(t, "N2", "I2", "b"),
+	}))
+
+	type hostProfile struct{ host, ident string }
+	asSet := func(profs []*fleet.MDMAppleProfilePayload) []hostProfile {
+		var set []hostProfile
+		for _, p := range profs {
+			set = append(set, hostProfile{p.HostUUID, p.ProfileIdentifier})
+		}
+		return set
+	}
+
+	// exclude the label from I1 and the last host from I2
+	err = ds.BatchSetMDMAppleProfileExclusions(ctx, nil, []*fleet.MDMAppleProfileExclusion{
+		{ProfileIdentifier: "I1", LabelID: &lbl.ID},
+		{ProfileIdentifier: "I2", HostID: &hosts[2].ID},
+	})
+	require.NoError(t, err)
+
+	excls, err := ds.ListMDMAppleProfileExclusions(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []*fleet.MDMAppleProfileExclusion{
+		{ProfileIdentifier: "I1", LabelID: &lbl.ID},
+		{ProfileIdentifier: "I2", HostID: &hosts[2].ID},
+	}, excls)
+
+	// the exclusions of a team are independent of no team
+	excls, err = ds.ListMDMAppleProfileExclusions(ctx, ptr.Uint(1))
+	require.NoError(t, err)
+	require.Empty(t, excls)
+
+	toInstall, err := ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []hostProfile{
+		{"host-0", "I1"}, {"host-0", "I2"},
+		{"host-1", "I2"},
+		{"host-2", "I1"},
+	}, asSet(toInstall))
+
+	// install them
+	err = ds.BulkSetPendingMDMAppleHostProfiles(ctx, nil, []uint{0}, nil, nil)
+	require.NoError(t, err)
+	toInstall, err = ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []hostProfile{
+		{"host-0", "I1"}, {"host-0", "I2"},
+		{"host-1", "I2"},
+		{"host-2", "I1"},
+	}, asSet(toInstall))
+	var payload []*fleet.MDMAppleBulkUpsertHostProfilePayload
+	for _, p := range toInstall {
+		payload = append(payload, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+			ProfileID:         p.ProfileID,
+			ProfileIdentifier: p.ProfileIdentifier,
+			ProfileName:       p.ProfileName,
+			HostUUID:          p.HostUUID,
+			CommandUUID:       uuid.NewString(),
+			OperationType:     fleet.MDMAppleOperationTypeInstall,
+			Status:            &fleet.MDMAppleDeliveryVerifying,
+			Checksum:          p.Checksum,
+		})
+	}
+	require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, payload))
+
+	toInstall, err = ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	require.Empty(t, toInstall)
+	toRemove, err := ds.ListMDMAppleProfilesToRemove(ctx)
+	require.NoError(t, err)
+	require.Empty(t, toRemove)
+
+	// exclude the first host from I1, it needs to be removed
+	err = ds.BatchSetMDMAppleProfileExclusions(ctx, nil, []*fleet.MDMAppleProfileExclusion{
+		{ProfileIdentifier: "I1", LabelID: &lbl.ID},
+		{ProfileIdentifier: "I1", HostID: &hosts[0].ID},
+		{ProfileIdentifier: "I2", HostID: &hosts[2].ID},
+	})
+	require.NoError(t, err)
+	toRemove, err = ds.ListMDMAppleProfilesToRemove(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []hostProfile{{"host-0", "I1"}}, asSet(toRemove))
+
+	// clear the exclusions, the profiles are installed on all hosts
+	err = ds.BatchSetMDMAppleProfileExclusions(ctx, nil, nil)
+	require.NoError(t, err)
+	excls, err = ds.ListMDMAppleProfileExclusions(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, excls)
+	toRemove, err = ds.ListMDMAppleProfilesToRemove(ctx)
+	require.NoError(t, err)
+	require.Empty(t, toRemove)
+	toInstall, err = ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []hostProfile{
+		{"host-1", "I1"},
+		{"host-2", "I2"},
+	}, asSet(toInstall))
+
+	// exclude an IdP group from I1, the host assigned to an active member of
+	// the group is excluded
+	err = ds.SetHostAssignedUser(ctx, hosts[1].ID, &fleet.HostAssignedUser{Email: "a@example.com", Source: fleet.HostAssignedUserSourceAPI})
+	require.NoError(t, err)
+	err = ds.SetHostAssignedUser(ctx, hosts[2].ID, &fleet.HostAssignedUser{Email: "b@example.com", Source: fleet.HostAssignedUserSourceAPI})
+	require.NoError(t, err)
+	su1, err := ds.CreateScimUser(ctx, &fleet.ScimUser{UserName: "a@example.com", Active: true})
+	require.NoError(t, err)
+	su2, err := ds.CreateScimUser(ctx, &fleet.ScimUser{UserName: "b@example.com", Active: false})
+	require.NoError(t, err)
+	_, err = ds.CreateScimGroup(ctx, &fleet.ScimGroup{DisplayName: "Kiosks", MemberIDs: []uint{su1.ID, su2.ID}})
+	require.NoError(t, err)
+
+	err = ds.BatchSetMDMAppleProfileExclusions(ctx, nil, []*fleet.MDMAppleProfileExclusion{
+		{ProfileIdentifier: "I1", IdPGroup: ptr.String("Kiosks")},
+	})
+	require.NoError(t, err)
+	excls, err = ds.ListMDMAppleProfileExclusions(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []*fleet.MDMAppleProfileExclusion{
+		{ProfileIdentifier: "I1", IdPGroup: ptr.String("Kiosks")},
+	}, excls)
+	toInstall, err = ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []hostProfile{
+		{"host-2", "I2"},
+	}, asSet(toInstall))
+}
+
+func testMDMApplePolicyActions(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	pol1, err := ds.NewGlobalPolicy(ctx, nil, fleet.PolicyPayload{Name: "p1", Query: "select 1"})
+	require.NoError(t, err)
+	pol2, err := ds.NewGlobalPolicy(ctx, nil, fleet.PolicyPayload{Name: "p2", Query: "select 2"})
+	require.NoError(t, err)
+
+	_, err = ds.GetMDMApplePolicyAction(ctx, pol1.ID)
+	require.True(t, fleet.IsNotFound(err))
+	err = ds.DeleteMDMApplePolicyAction(ctx, pol1.ID)
+	require.True(t, fleet.IsNotFound(err))
+	ids, err := ds.ListMDMApplePolicyActionPolicyIDs(ctx)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	err = ds.SetMDMApplePolicyAction(ctx, &fleet.MDMApplePolicyAction{PolicyID: pol1.ID, RemediationProfile: []byte("profile")})
+	require.NoError(t, err)
+	err = ds.SetMDMApplePolicyAction(ctx, &fleet.MDMApplePolicyAction{PolicyID: pol2.ID, CommandTemplate: "cmd", TeamIDs: []uint{0, 3}})
+	require.NoError(t, err)
+
+	action, err := ds.GetMDMApplePolicyAction(ctx, pol1.ID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("profile"), action.RemediationProfile)
+	require.Empty(t, action.CommandTemplate)
+	require.Empty(t, action.TeamIDs)
+
+	// replace the action of the first policy
+	err = ds.SetMDMApplePolicyAction(ctx, &fleet.MDMApplePolicyAction{PolicyID: pol1.ID, CommandTemplate: "cmd1"})
+	require.NoError(t, err)
+
+	actions, err := ds.ListMDMApplePolicyActions(ctx)
+	require.NoError(t, err)
+	require.Len(t, actions, 2)
+	require.Equal(t, pol1.ID, actions[0].PolicyID)
+	require.Empty(t, actions[0].RemediationProfile)
+	require.Equal(t, "cmd1", actions[0].CommandTemplate)
+	require.Equal(t, pol2.ID, actions[1].PolicyID)
+	require.Equal(t, "cmd", actions[1].CommandTemplate)
+	require.Equal(t, []uint{0, 3}, actions[1].TeamIDs)
+
+	ids, err = ds.ListMDMApplePolicyActionPolicyIDs(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []uint{pol1.ID, pol2.ID}, ids)
+
+	// the action is deleted with its policy
+	_, err = ds.DeleteGlobalPolicies(ctx, []uint{pol2.ID})
+	require.NoError(t, err)
+	err = ds.DeleteMDMApplePolicyAction(ctx, pol1.ID)
+	require.NoError(t, err)
+	actions, err = ds.ListMDMApplePolicyActions(ctx)
+	require.NoError(t, err)
+	require.Empty(t, actions)
+
+	// only the macOS hosts enrolled in MDM are returned
+	var hostIDs []uint
+	for i, platform := range []string{"darwin", "darwin", "windows"} {
+		name := fmt.Sprintf("host-%d", i)
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:       name,
+			ComputerName:   "computer-" + name,
+			OsqueryHostID:  ptr.String(name),
+			NodeKey:        ptr.String(name),
+			UUID:           name,
+			HardwareSerial: "serial-" + name,
+			Platform:       platform,
+		})
+		require.NoError(t, err)
+		if i != 1 {
+			nanoEnroll(t, ds, h, false)
+		}
+		hostIDs = append(hostIDs, h.ID)
+	}
+	hosts, err := ds.ListMDMApplePolicyActionHosts(ctx, hostIDs)
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	require.Equal(t, hostIDs[0], hosts[0].ID)
+	require.Equal(t, "host-0", hosts[0].UUID)
+	require.Equal(t, "serial-host-0", hosts[0].HardwareSerial)
+	require.Equal(t, "computer-host-0", hosts[0].DisplayName)
+	require.Nil(t, hosts[0].TeamID)
+}
+
+func testMDMAppleHostProfileInstalls(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	h1 := test.NewHost(t, ds, "h1.local", "1.1.1.1", "1", "1", time.Now())
+	h2 := test.NewHost(t, ds, "h2.local", "1.1.1.2", "2", "2", time.Now())
+	// h3 has no profile installed by Fleet
+	h3 := test.NewHost(t, ds, "h3.local", "1.1.1.3", "3", "3", time.Now())
+	nanoEnroll(t, ds, h1, false)
+	nanoEnroll(t, ds, h2, false)
+	nanoEnroll(t, ds, h3, false)
+
+	cp1, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "N1", "I1", "U1"))
+	require.NoError(t, err)
+	cp2, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "N2", "I2", "U2"))
+	require.NoError(t, err)
+
+	var upserts []*fleet.MDMAppleBulkUpsertHostProfilePayload
+	for _, h := range []*fleet.Host{h1, h2} {
+		for _, cp := range []*fleet.MDMAppleConfigProfile{cp1, cp2} {
+			upserts = append(upserts, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+				ProfileID:         cp.ProfileID,
+				ProfileIdentifier: cp.Identifier,
+				ProfileName:       cp.Name,
+				HostUUID:          h.UUID,
+				CommandUUID:       "cmd-" + h.UUID + cp.Identifier,
+				OperationType:     fleet.MDMAppleOperationTypeInstall,
+				Status:            &fleet.MDMAppleDeliveryVerifying,
+				Checksum:          []byte("csum"),
+			})
+		}
+	}
+	require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, upserts))
+
+	uuids, err := ds.ListMDMAppleHostUUIDsToRefreshProfileList(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{h1.UUID, h2.UUID}, uuids)
+
+	require.NoError(t, ds.SetMDMAppleHostProfileListRefreshRequested(ctx, []string{h1.UUID}))
+	uuids, err = ds.ListMDMAppleHostUUIDsToRefreshProfileList(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{h2.UUID}, uuids)
+
+	// requested more than the interval ago
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `UPDATE host_mdm_apple_profile_list_refreshes SET requested_at = DATE_SUB(NOW(), INTERVAL 2 HOUR)`)
+		return err
+	})
+	uuids, err = ds.ListMDMAppleHostUUIDsToRefreshProfileList(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{h1.UUID, h2.UUID}, uuids)
+
+	installs, err := ds.ListHostMDMAppleProfileInstalls(ctx, h1.UUID)
+	require.NoError(t, err)
+	require.Len(t, installs, 2)
+	sort.Slice(installs, func(i, j int) bool { return installs[i].ProfileID < installs[j].ProfileID })
+	require.Equal(t, cp1.ProfileID, installs[0].ProfileID)
+	require.Equal(t, "I1", installs[0].ProfileIdentifier)
+	require.Nil(t, installs[0].InstalledPayloadUUID)
+	require.Equal(t, fleet.MDMAppleDeliveryVerifying, *installs[0].Status)
+	parsed, err := installs[0].Mobileconfig.ParseConfigProfile()
+	require.NoError(t, err)
+	require.Equal(t, "U1", parsed.PayloadUUID)
+
+	require.NoError(t, ds.UpdateHostMDMAppleProfilesInstalledPayloadUUIDs(ctx, h1.UUID, map[uint]string{cp1.ProfileID: "U1", cp2.ProfileID: "U2-old"}))
+	require.NoError(t, ds.SetHostMDMAppleProfilesToReinstall(ctx, h1.UUID, []uint{cp2.ProfileID}))
+
+	installs, err = ds.ListHostMDMAppleProfileInstalls(ctx, h1.UUID)
+	require.NoError(t, err)
+	sort.Slice(installs, func(i, j int) bool { return installs[i].ProfileID < installs[j].ProfileID })
+	require.Equal(t, "U1", *installs[0].InstalledPayloadUUID)
+	require.Equal(t, fleet.MDMAppleDeliveryVerifying, *installs[0].Status)
+	require.Equal(t, "U2-old", *installs[1].InstalledPayloadUUID)
+	require.Nil(t, installs[1].Status)
+
+	// profiles not reported anymore are cleared, other hosts are not affected
+	require.NoError(t, ds.UpdateHostMDMAppleProfilesInstalledPayloadUUIDs(ctx, h1.UUID, map[uint]string{cp1.ProfileID: "U1"}))
+	installs, err = ds.ListHostMDMAppleProfileInstalls(ctx, h1.UUID)
+	require.NoError(t, err)
+	sort.Slice(installs, func(i, j int) bool { return installs[i].ProfileID < installs[j].ProfileID })
+	require.Equal(t, "U1", *installs[0].InstalledPayloadUUID)
+	require.Nil(t, installs[1].InstalledPayloadUUID)
+
+	installs, err = ds.ListHostMDMAppleProfileInstalls(ctx, h2.UUID)
+	require.NoError(t, err)
+	require.Len(t, installs, 2)
+	for _, inst := range installs {
+		require.Nil(t, inst.InstalledPayloadUUID)
+		require.Equal(t, fleet.MDMAppleDeliveryVerifying, *inst.Status)
+	}
+}
+
+func testMDMAppleCommandPriorities(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	h1 := test.NewHost(t, ds, "h1.local", "1.1.1.1", "1", "1", time.Now())
+	h2 := test.NewHost(t, ds, "h2.local", "1.1.1.2", "2", "2", time.Now())
+	nanoEnroll(t, ds, h1, false)
+	nanoEnroll(t, ds, h2, false)
+	commander, storage := createMDMAppleCommanderAndStorage(t, ds)
+
+	// enqueue commands with increasing priorities, the most recent ones must
+	// be delivered first
+	lowUUID, normalUUID, urgentUUID := "low-"+uuid.NewString(), "normal-"+uuid.NewString(), "urgent-"+uuid.NewString()
+	err := commander.ProfileList(ctx, []string{h1.UUID, h2.UUID}, lowUUID)
+	require.NoError(t, err)
+	err = commander.RemoveProfile(ctx, []string{h1.UUID, h2.UUID}, "com.example", normalUUID)
+	require.NoError(t, err)
+	pin, err := commander.DeviceLock(ctx, []string{h1.UUID}, urgentUUID)
+	require.NoError(t, err)
+	require.Len(t, pin, 6)
+
+	nextCommands := func(hostUUID string) []string {
+		var uuids []string
+		for {
+			r := &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: hostUUID}}
+			cmd, err := storage.RetrieveNextCommand(r, false)
+			require.NoError(t, err)
+			if cmd == nil {
+				return uuids
+			}
+			uuids = append(uuids, cmd.CommandUUID)
+			err = storage.StoreCommandReport(r, &mdm.CommandResults{
+				CommandUUID: cmd.CommandUUID,
+				Status:      "Acknowledged",
+				RequestType: cmd.Command.RequestType,
+				Raw:         []byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?><plist version=\"1.0\"><dict></dict></plist>"),
+			})
+			require.NoError(t, err)
+		}
+	}
+	require.Equal(t, []string{urgentUUID, normalUUID, lowUUID}, nextCommands(h1.UUID))
+	require.Equal(t, []string{normalUUID, lowUUID}, nextCommands(h2.UUID))
+
+	// an invalid host fails the whole enqueue
+	_, err = commander.DeviceLock(ctx, []string{h1.UUID, "no-such-host"}, "urgent-"+uuid.NewString())
+	require.Error(t, err)
+	require.Empty(t, nextCommands(h1.UUID))
+}
+
+func testMDMAppleAllTeamsProfiles(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm1, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	tm2, err := ds.NewTeam(ctx, &fleet.Team{
+		Name: "team2",
+		Config: fleet.TeamConfig{
+			MDM: fleet.TeamMDM{
+				MacOSSettings: fleet.MacOSSettings{AllTeamsCustomSettingsOptOut: []string{"I2"}},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	// host-0 is in no team, host-1 in team1 and host-2 in team2
+	for i, tmID := range []*uint{nil, &tm1.ID, &tm2.ID} {
+		name := fmt.Sprintf("host-%d", i)
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:      name,
+			OsqueryHostID: ptr.String(name),
+			NodeKey:       ptr.String(name),
+			UUID:          name,
+			Platform:      "darwin",
+			TeamID:        tmID,
+		})
+		require.NoError(t, err)
+		nanoEnroll(t, ds, h, false)
+	}
+
+	allTeamsID := ptr.Uint(fleet.MDMAppleAllTeamsProfilesTeamID)
+	require.NoError(t, ds.BatchSetMDMAppleProfiles(ctx, allTeamsID, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N1", "I1", "a"),
+		configProfileForTest(t, "N2", "I2", "b"),
+	}))
+	// team1 has its own profile with the I1 identifier, which takes
+	// precedence over the all teams one
+	require.NoError(t, ds.BatchSetMDMAppleProfiles(ctx, &tm1.ID, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "T1", "I1", "c"),
+	}))
+
+	type hostProfile struct{ host, name string }
+	asSet := func(profs []*fleet.MDMAppleProfilePayload) []hostProfile {
+		var set []hostProfile
+		for _, p := range profs {
+			set = append(set, hostProfile{p.HostUUID, p.ProfileName})
+		}
+		return set
+	}
+
+	toInstall, err := ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []hostProfile{
+		{"host-0", "N1"}, {"host-0", "N2"},
+		{"host-1", "T1"}, {"host-1", "N2"},
+		{"host-2", "N1"},
+	}, asSet(toInstall))
+
+	// changing the all teams profiles affects the hosts of all teams
+	uuids, err := ds.ListMDMAppleBulkSetPendingHostUUIDs(ctx, nil, []uint{fleet.MDMAppleAllTeamsProfilesTeamID}, nil)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"host-0", "host-1", "host-2"}, uuids)
+
+	// hosts in no team opt out via the app config
+	appCfg, err := ds.AppConfig(ctx)
+	require.NoError(t, err)
+	appCfg.MDM.MacOSSettings.AllTeamsCustomSettingsOptOut = []string{"I1"}
+	require.NoError(t, ds.SaveAppConfig(ctx, appCfg))
+	t.Cleanup(func() {
+		appCfg.MDM.MacOSSettings.AllTeamsCustomSettingsOptOut = nil
+		require.NoError(t, ds.SaveAppConfig(ctx, appCfg))
+	})
+
+	toInstall, err = ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []hostProfile{
+		{"host-0", "N2"},
+		{"host-1", "T1"}, {"host-1", "N2"},
+		{"host-2", "N1"},
+	}, asSet(toInstall))
+}
+
+func testMDMAppleHostProfiles(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	var hosts []*fleet.Host
+	for i := 0; i < 2; i++ {
+		name := fmt.Sprintf("host-%d", i)
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:      name,
+			OsqueryHostID: ptr.String(name),
+			NodeKey:       ptr.String(name),
+			UUID:          name,
+			Platform:      "darwin",
+		})
+		require.NoError(t, err)
+		nanoEnroll(t, ds, h, false)
+		hosts = append(hosts, h)
+	}
+	require.NoError(t, ds.BatchSetMDMAppleProfiles(ctx, nil, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N1", "I1", "a"),
+	}))
+
+	// the same ad-hoc profile can be installed on several hosts, but only once
+	// per host
+	newHostProfile := func(h *fleet.Host) (*fleet.MDMAppleConfigProfile, error) {
+		cp := configProfileForTest(t, "H1", "IH", "b")
+		cp.TeamID = ptr.Uint(fleet.MDMAppleHostProfilesTeamID)
+		cp.HostID = h.ID
+		return ds.NewMDMAppleConfigProfile(ctx, *cp)
+	}
+	hp0, err := newHostProfile(hosts[0])
+	require.NoError(t, err)
+	require.Equal(t, hosts[0].ID, hp0.HostID)
+	_, err = newHostProfile(hosts[1])
+	require.NoError(t, err)
+	_, err = newHostProfile(hosts[0])
+	var aerr fleet.AlreadyExistsError
+	require.ErrorAs(t, err, &aerr)
+
+	got, err := ds.GetMDMAppleConfigProfile(ctx, hp0.ProfileID)
+	require.NoError(t, err)
+	require.Equal(t, hosts[0].ID, got.HostID)
+
+	// ad-hoc profiles are not part of the team's profiles
+	teamProfs, err := ds.ListMDMAppleConfigProfiles(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, teamProfs, 1)
+
+	type hostProfile struct{ host, name string }
+	asSet := func(profs []*fleet.MDMAppleProfilePayload) []hostProfile {
+		var set []hostProfile
+		for _, p := range profs {
+			set = append(set, hostProfile{p.HostUUID, p.ProfileName})
+		}
+		return set
+	}
+	toInstall, err := ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []hostProfile{
+		{"host-0", "N1"}, {"host-0", "H1"},
+		{"host-1", "N1"}, {"host-1", "H1"},
+	}, asSet(toInstall))
+
+	var upserts []*fleet.MDMAppleBulkUpsertHostProfilePayload
+	for _, p := range toInstall {
+		if p.HostUUID != "host-0" {
+			continue
+		}
+		upserts = append(upserts, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+			ProfileID:         p.ProfileID,
+			ProfileIdentifier: p.ProfileIdentifier,
+			ProfileName:       p.ProfileName,
+			HostUUID:          p.HostUUID,
+			CommandUUID:       uuid.New().String(),
+			OperationType:     fleet.MDMAppleOperationTypeInstall,
+			Status:            &fleet.MDMAppleDeliveryVerifying,
+			Checksum:          p.Checksum,
+		})
+	}
+	require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, upserts))
+
+	hostProfs, err := ds.GetHostMDMProfiles(ctx, "host-0")
+	require.NoError(t, err)
+	require.Len(t, hostProfs, 2)
+	for _, p := range hostProfs {
+		require.Equal(t, p.Name == "H1", p.AdHoc, p.Name)
+		require.Equal(t, p.Name == "H1", p.Origin == fleet.MDMAppleProfileOriginAdHoc, p.Name)
+	}
+
+	// deleting the ad-hoc profile removes it from the host
+	require.NoError(t, ds.DeleteMDMAppleConfigProfile(ctx, hp0.ProfileID))
+	toRemove, err := ds.ListMDMAppleProfilesToRemove(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []hostProfile{{"host-0", "H1"}}, asSet(toRemove))
+
+	// deleting the host deletes its ad-hoc profiles
+	require.NoError(t, ds.DeleteHost(ctx, hosts[1].ID))
+	_, err = newHostProfile(hosts[1])
+	require.NoError(t, err)
+}
+
+func testMDMAppleEnrollmentApprovals(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+
+	var hosts []*fleet.Host
+	for i, tmID := range []*uint{nil, &tm.ID} {
+		name := fmt.Sprintf("host-%d", i)
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:      name,
+			OsqueryHostID: ptr.String(name),
+			NodeKey:       ptr.String(name),
+			UUID:          name,
+			Platform:      "darwin",
+			TeamID:        tmID,
+		})
+		require.NoError(t, err)
+		nanoEnroll(t, ds, h, false)
+		hosts = append(hosts, h)
+	}
+	require.NoError(t, ds.BatchSetMDMAppleProfiles(ctx, nil, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N1", "I1", "a"),
+	}))
+	require.NoError(t, ds.BatchSetMDMAppleProfiles(ctx, &tm.ID, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N2", "I2", "b"),
+	}))
+
+	toInstallHosts := func() []string {
+		profs, err := ds.ListMDMAppleProfilesToInstall(ctx)
+		require.NoError(t, err)
+		var uuids []string
+		for _, p := range profs {
+			uuids = append(uuids, p.HostUUID)
+		}
+		return uuids
+	}
+	require.ElementsMatch(t, []string{"host-0", "host-1"}, toInstallHosts())
+
+	// host-1 enrolled manually and waits for approval
+	queued, err := ds.SetMDMAppleEnrollmentPendingApproval(ctx, "host-1")
+	require.NoError(t, err)
+	require.True(t, queued)
+	queued, err = ds.SetMDMAppleEnrollmentPendingApproval(ctx, "host-1")
+	require.NoError(t, err)
+	require.False(t, queued)
+
+	require.ElementsMatch(t, []string{"host-0"}, toInstallHosts())
+	uuids, err := ds.FilterMDMAppleHostUUIDsPendingApproval(ctx, []string{"host-0", "host-1"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"host-1"}, uuids)
+	uuids, err = ds.ListMDMAppleHostUUIDsToRefreshCertificates(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"host-0"}, uuids)
+
+	pending, err := ds.ListMDMApplePendingEnrollments(ctx, fleet.TeamFilter{User: test.UserAdmin})
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, hosts[1].ID, pending[0].HostID)
+	require.Equal(t, "host-1", pending[0].HostUUID)
+	require.Equal(t, &tm.ID, pending[0].TeamID)
+
+	// a user of another team doesn't see it
+	pending, err = ds.ListMDMApplePendingEnrollments(ctx, fleet.TeamFilter{User: test.UserTeamAdminTeam2})
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	// approve it, it can only be approved once
+	require.NoError(t, ds.ApproveMDMAppleEnrollment(ctx, "host-1"))
+	err = ds.ApproveMDMAppleEnrollment(ctx, "host-1")
+	var nfe fleet.NotFoundError
+	require.ErrorAs(t, err, &nfe)
+	err = ds.ApproveMDMAppleEnrollment(ctx, "host-0")
+	require.ErrorAs(t, err, &nfe)
+
+	require.ElementsMatch(t, []string{"host-0", "host-1"}, toInstallHosts())
+	pending, err = ds.ListMDMApplePendingEnrollments(ctx, fleet.TeamFilter{User: test.UserAdmin})
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	// an approved host stays approved on a new Authenticate, until it unenrolls
+	queued, err = ds.SetMDMAppleEnrollmentPendingApproval(ctx, "host-1")
+	require.NoError(t, err)
+	require.False(t, queued)
+	require.NoError(t, ds.UpdateHostTablesOnMDMUnenroll(ctx, "host-1"))
+	queued, err = ds.SetMDMAppleEnrollmentPendingApproval(ctx, "host-1")
+	require.NoError(t, err)
+	require.True(t, queued)
+}
+
+func testMDMAppleNanoEnrollments(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	h, err := ds.NewHost(ctx, &fleet.Host{
+		Hostname:       "host-1",
+		OsqueryHostID:  ptr.String("host-1"),
+		NodeKey:        ptr.String("host-1"),
+		UUID:           "host-1",
+		HardwareSerial: "serial-1",
+		Platform:       "darwin",
+	})
+	require.NoError(t, err)
+	nanoEnroll(t, ds, h, true)
+
+	// the orphaned device enrolled but its host was deleted from Fleet
+	orphan := &fleet.Host{UUID: "orphan"}
+	nanoEnroll(t, ds, orphan, false)
+
+	enrollments, meta, err := ds.ListMDMAppleNanoEnrollments(ctx, fleet.MDMAppleNanoEnrollmentListOptions{})
+	require.NoError(t, err)
+	require.False(t, meta.HasNextResults)
+	require.Len(t, enrollments, 3)
+	require.Equal(t, "host-1", enrollments[0].ID)
+	require.Equal(t, &h.ID, enrollments[0].HostID)
+	require.Equal(t, "host-1:Device", enrollments[1].ID)
+	require.Equal(t, "User", enrollments[1].Type)
+	require.Equal(t, &h.ID, enrollments[1].HostID)
+	require.Equal(t, "orphan", enrollments[2].ID)
+	require.Nil(t, enrollments[2].HostID)
+
+	enrollments, meta, err = ds.ListMDMAppleNanoEnrollments(ctx, fleet.MDMAppleNanoEnrollmentListOptions{
+		ListOptions: fleet.ListOptions{PerPage: 1},
+	})
+	require.NoError(t, err)
+	require.True(t, meta.HasNextResults)
+	require.Len(t, enrollments, 1)
+
+	enrollments, _, err = ds.ListMDMAppleNanoEnrollments(ctx, fleet.MDMAppleNanoEnrollmentListOptions{OrphanedOnly: true})
+	require.NoError(t, err)
+	require.Len(t, enrollments, 1)
+	require.Equal(t, "orphan", enrollments[0].ID)
+
+	enrollment, err := ds.GetMDMAppleNanoEnrollment(ctx, "orphan")
+	require.NoError(t, err)
+	require.Equal(t, "orphan", enrollment.DeviceID)
+	require.Nil(t, enrollment.HostID)
+	_, err = ds.GetMDMAppleNanoEnrollment(ctx, "no-such-enrollment")
+	require.True(t, fleet.IsNotFound(err))
+
+	// the enrollment cannot be linked to a host already linked to another device
+	err = ds.LinkMDMAppleNanoEnrollmentToHost(ctx, "host-1", h.ID+1)
+	require.True(t, fleet.IsNotFound(err))
+	h2, err := ds.NewHost(ctx, &fleet.Host{
+		Hostname:      "host-2",
+		OsqueryHostID: ptr.String("host-2"),
+		NodeKey:       ptr.String("host-2"),
+		UUID:          "host-2",
+		Platform:      "darwin",
+	})
+	require.NoError(t, err)
+	err = ds.LinkMDMAppleNanoEnrollmentToHost(ctx, "host-1", h2.ID)
+	var existsErr interface{ IsExists() bool }
+	require.ErrorAs(t, err, &existsErr)
+
+	// re-link the orphaned device to host-2
+	require.NoError(t, ds.LinkMDMAppleNanoEnrollmentToHost(ctx, "orphan", h2.ID))
+	h2, err = ds.Host(ctx, h2.ID)
+	require.NoError(t, err)
+	require.Equal(t, "orphan", h2.UUID)
+	require.True(t, h2.RefetchRequested)
+	enrollments, _, err = ds.ListMDMAppleNanoEnrollments(ctx, fleet.MDMAppleNanoEnrollmentListOptions{OrphanedOnly: true})
+	require.NoError(t, err)
+	require.Empty(t, enrollments)
+	// linking again to the same host is a no-op
+	require.NoError(t, ds.LinkMDMAppleNanoEnrollmentToHost(ctx, "orphan", h2.ID))
+
+	// deleting the device enrollment deletes the user enrollment too
+	require.NoError(t, ds.DeleteMDMAppleNanoEnrollment(ctx, "host-1"))
+	enrollments, _, err = ds.ListMDMAppleNanoEnrollments(ctx, fleet.MDMAppleNanoEnrollmentListOptions{})
+	require.NoError(t, err)
+	require.Len(t, enrollments, 1)
+	require.Equal(t, "orphan", enrollments[0].ID)
+	err = ds.DeleteMDMAppleNanoEnrollment(ctx, "host-1")
+	require.True(t, fleet.IsNotFound(err))
+}
+
+func testMDMAppleServerURLMigrations(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	_, err := ds.GetLatestMDMAppleServerURLMigration(ctx)
+	require.True(t, fleet.IsNotFound(err))
+
+	// two enrolled macOS hosts, one enrolled Windows host and one
+	// non-enrolled macOS host
+	newHost := func(i int, platform string) *fleet.Host {
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:      fmt.Sprintf("test-host%d-name", i),
+			OsqueryHostID: ptr.String(fmt.Sprintf("osquery-%d", i)),
+			NodeKey:       ptr.String(fmt.Sprintf("nodekey-%d", i)),
+			UUID:          fmt.Sprintf("test-uuid-%d", i),
+			Platform:      platform,
+		})
+		require.NoError(t, err)
+		return h
+	}
+	h0, h1 := newHost(0, "darwin"), newHost(1, "darwin")
+	nanoEnroll(t, ds, h0, true)
+	nanoEnroll(t, ds, h1, false)
+	nanoEnroll(t, ds, newHost(2, "windows"), false)
+	newHost(3, "darwin")
+
+	m1, err := ds.NewMDMAppleServerURLMigration(ctx, "https://old.example.com", "https://new.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "https://old.example.com", m1.OldServerURL)
+	require.Equal(t, "https://new.example.com", m1.NewServerURL)
+	require.Equal(t, uint(2), m1.Hosts)
+	require.Equal(t, uint(2), m1.Pending)
+	require.True(t, m1.InProgress())
+
+	pending, err := ds.ListMDMAppleServerURLMigrationPendingHosts(ctx, m1.ID, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{h0.UUID}, pending)
+
+	err = ds.SetMDMAppleServerURLMigrationHostsSent(ctx, m1.ID, pending, "SRVURL-1")
+	require.NoError(t, err)
+	err = ds.SetMDMAppleServerURLMigrationHostsSent(ctx, m1.ID, nil, "SRVURL-2")
+	require.NoError(t, err)
+	pending, err = ds.ListMDMAppleServerURLMigrationPendingHosts(ctx, m1.ID, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{h1.UUID}, pending)
+
+	err = ds.SetMDMAppleServerURLMigrationHostsSent(ctx, m1.ID, pending, "SRVURL-2")
+	require.NoError(t, err)
+	latest, err := ds.GetLatestMDMAppleServerURLMigration(ctx)
+	require.NoError(t, err)
+	require.Equal(t, m1.ID, latest.ID)
+	require.Equal(t, uint(2), latest.Sent)
+	require.False(t, latest.InProgress())
+
+	// results are only recorded for the matching command
+	err = ds.SetMDMAppleServerURLMigrationHostResult(ctx, h0.UUID, "SRVURL-1", fleet.MDMAppleServerURLMigrationHostAcknowledged, "")
+	require.NoError(t, err)
+	err = ds.SetMDMAppleServerURLMigrationHostResult(ctx, h1.UUID, "SRVURL-1", fleet.MDMAppleServerURLMigrationHostFailed, "error")
+	require.NoError(t, err)
+	err = ds.SetMDMAppleServerURLMigrationHostResult(ctx, h1.UUID, "SRVURL-2", fleet.MDMAppleServerURLMigrationHostFailed, "error")
+	require.NoError(t, err)
+	latest, err = ds.GetLatestMDMAppleServerURLMigration(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint(0), latest.Sent)
+	require.Equal(t, uint(1), latest.Acknowledged)
+	require.Equal(t, uint(1), latest.Failed)
+
+	// a new migration becomes the latest one
+	m2, err := ds.NewMDMAppleServerURLMigration(ctx, "https://new.example.com", "https://newer.example.com")
+	require.NoError(t, err)
+	latest, err = ds.GetLatestMDMAppleServerURLMigration(ctx)
+	require.NoError(t, err)
+	require.Equal(t, m2.ID, latest.ID)
+	require.Equal(t, uint(2), latest.Pending)
+}
+
+func testMDMAppleProfileChecksumAlgorithm(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	getChecksum := func(ident string) []byte {
+		var sum []byte
+		ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+			return sqlx.GetContext(ctx, q, &sum, `SELECT checksum FROM mdm_apple_configuration_profiles WHERE team_id = 0 AND identifier = ?`, ident)
+		})
+		return sum
+	}
+
+	cp1 := configProfileForTest(t, "N1", "I1", "a")
+	_, err := ds.NewMDMAppleConfigProfile(ctx, *cp1)
+	require.NoError(t, err)
+	require.Equal(t, fleet.MDMProfileChecksumMD5.Sum(cp1.Mobileconfig), getChecksum("I1"))
+
+	ds.profileChecksum = fleet.MDMProfileChecksumSHA256
+	t.Cleanup(func() { ds.profileChecksum = "" })
+
+	cp2 := configProfileForTest(t, "N2", "I2", "b")
+	_, err = ds.NewMDMAppleConfigProfile(ctx, *cp2)
+	require.NoError(t, err)
+	require.Equal(t, fleet.MDMProfileChecksumSHA256.Sum(cp2.Mobileconfig), getChecksum("I2"))
+
+	// the checksums are recomputed when the profiles are batch-set
+	err = ds.BatchSetMDMAppleProfiles(ctx, nil, []*fleet.MDMAppleConfigProfile{cp1, cp2})
+	require.NoError(t, err)
+	require.Equal(t, fleet.MDMProfileChecksumSHA256.Sum(cp1.Mobileconfig), getChecksum("I1"))
+	require.Equal(t, fleet.MDMProfileChecksumSHA256.Sum(cp2.Mobileconfig), getChecksum("I2"))
+}
+
+func testGetHostMDMProfilesOrigin(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: t.Name()})
+	require.NoError(t, err)
+
+	newProfile := func(name, identifier string, teamID uint) *fleet.MDMAppleConfigProfile {
+		cp := configProfileForTest(t, name, identifier, name)
+		cp.TeamID = &teamID
+		got, err := ds.NewMDMAppleConfigProfile(ctx, *cp)
+		require.NoError(t, err)
+		return got
+	}
+	profs := []*fleet.MDMAppleConfigProfile{
+		newProfile("FileVault", mobileconfig.FleetFileVaultPayloadIdentifier, tm.ID),
+		newProfile("Team", "I1", tm.ID),
+		newProfile("NoTeam", "I2", 0),
+		newProfile("AllTeams", "I3", fleet.MDMAppleAllTeamsProfilesTeamID),
+		newProfile("AdHoc", "I4", fleet.MDMAppleHostProfilesTeamID),
+		newProfile("Deleted", "I5", tm.ID),
+	}
+
+	var upserts []*fleet.MDMAppleBulkUpsertHostProfilePayload
+	for _, p := range profs {
+		upserts = append(upserts, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+			ProfileID:         p.ProfileID,
+			ProfileIdentifier: p.Identifier,
+			ProfileName:       p.Name,
+			HostUUID:          "host-origin",
+			CommandUUID:       uuid.New().String(),
+			OperationType:     fleet.MDMAppleOperationTypeInstall,
+			Status:            &fleet.MDMAppleDeliveryVerifying,
+			Checksum:          []byte("csum"),
+		})
+	}
+	// the deleted profile is being removed from the host
+	upserts[len(upserts)-1].OperationType = fleet.MDMAppleOperationTypeRemove
+	upserts[len(upserts)-1].Status = &fleet.MDMAppleDeliveryPending
+	require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, upserts))
+	require.NoError(t, ds.DeleteMDMAppleConfigProfile(ctx, profs[len(profs)-1].ProfileID))
+
+	hostProfs, err := ds.GetHostMDMProfiles(ctx, "host-origin")
+	require.NoError(t, err)
+	origins := make(map[string]fleet.MDMAppleProfileOrigin, len(hostProfs))
+	for _, p := range hostProfs {
+		origins[p.Name] = p.Origin
+	}
+	require.Equal(t, map[string]fleet.MDMAppleProfileOrigin{
+		"FileVault": fleet.MDMAppleProfileOriginFleet,
+		"Team":      fleet.MDMAppleProfileOriginTeam,
+		"NoTeam":    fleet.MDMAppleProfileOriginGlobal,
+		"AllTeams":  fleet.MDMAppleProfileOriginGlobal,
+		"AdHoc":     fleet.MDMAppleProfileOriginAdHoc,
+		"Deleted":   "",
+	}, origins)
+}
+
+func testPurgeHostMDMAppleData(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	h, err := ds.NewHost(ctx, &fleet.Host{
+		Hostname:       "test-host-purge",
+		OsqueryHostID:  ptr.String("osquery-purge"),
+		NodeKey:        ptr.String("nodekey-purge"),
+		UUID:           "uuid-purge",
+		Platform:       "darwin",
+		HardwareSerial: "serial-purge",
+	})
+	require.NoError(t, err)
+	nanoEnroll(t, ds, h, false)
+
+	cp, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "N1", "I1", "a"))
+	require.NoError(t, err)
+
+	setState := func(hostUUID string) {
+		cmdUUID := uuid.New().String()
+		require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, []*fleet.MDMAppleBulkUpsertHostProfilePayload{{
+			ProfileID:         cp.ProfileID,
+			ProfileIdentifier: cp.Identifier,
+			ProfileName:       cp.Name,
+			HostUUID:          hostUUID,
+			CommandUUID:       cmdUUID,
+			OperationType:     fleet.MDMAppleOperationTypeInstall,
+			Status:            &fleet.MDMAppleDeliveryVerifying,
+			Checksum:          []byte("csum"),
+		}}))
+		require.NoError(t, ds.SetOrUpdateHostDiskEncryptionKey(ctx, h.ID, "key"))
+		ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+			if _, err := q.ExecContext(ctx, `INSERT INTO host_mdm_activation_lock_bypass_codes (host_uuid, bypass_code) VALUES (?, 'code')`, hostUUID); err != nil {
+				return err
+			}
+			if _, err := q.ExecContext(ctx, `INSERT INTO nano_commands (command_uuid, request_type, command) VALUES (?, 'ProfileList', '<?xml')`, cmdUUID); err != nil {
+				return err
+			}
+			_, err := q.ExecContext(ctx, `INSERT INTO nano_enrollment_queue (id, command_uuid) VALUES (?, ?)`, hostUUID, cmdUUID)
+			return err
+		})
+	}
+	countState := func(hostUUID string) map[string]int {
+		counts := make(map[string]int)
+		for table, stmt := range map[string]string{
+			"profiles": `SELECT COUNT(*) FROM host_mdm_apple_profiles WHERE host_uuid = ?`,
+			"codes":    `SELECT COUNT(*) FROM host_mdm_activation_lock_bypass_codes WHERE host_uuid = ?`,
+			"queue":    `SELECT COUNT(*) FROM nano_enrollment_queue WHERE id = ?`,
+			"devices":  `SELECT COUNT(*) FROM nano_devices WHERE id = ?`,
+		} {
+			var n int
+			require.NoError(t, sqlx.GetContext(ctx, ds.reader, &n, stmt, hostUUID))
+			counts[table] = n
+		}
+		var n int
+		require.NoError(t, sqlx.GetContext(ctx, ds.reader, &n, `SELECT COUNT(*) FROM host_disk_encryption_keys WHERE host_id = ?`, h.ID))
+		counts["keys"] = n
+		return counts
+	}
+
+	setState(h.UUID)
+	require.Equal(t, map[string]int{"profiles": 1, "codes": 1, "queue": 1, "devices": 1, "keys": 1}, countState(h.UUID))
+
+	// purging the data keeps the enrollment
+	require.NoError(t, ds.PurgeHostMDMAppleData(ctx, h.ID, h.UUID))
+	require.Equal(t, map[string]int{"profiles": 0, "codes": 0, "queue": 0, "devices": 1, "keys": 0}, countState(h.UUID))
+
+	// checking in again with the same UDID doesn't purge the data
+	setState(h.UUID)
+	err = ds.IngestMDMAppleDeviceFromCheckin(ctx, fleet.MDMAppleHostDetails{SerialNumber: h.HardwareSerial, UDID: h.UUID, Model: "MacBookPro16,1"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"profiles": 1, "codes": 1, "queue": 1, "devices": 1, "keys": 1}, countState(h.UUID))
+
+	// the host is re-provisioned and checks in with a new UDID, the state of
+	// the previous installation is deleted along with its enrollment
+	err = ds.IngestMDMAppleDeviceFromCheckin(ctx, fleet.MDMAppleHostDetails{SerialNumber: h.HardwareSerial, UDID: "new-uuid-purge", Model: "MacBookPro16,1"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"profiles": 0, "codes": 0, "queue": 0, "devices": 0, "keys": 0}, countState(h.UUID))
+
+	got, err := ds.HostLite(ctx, h.ID)
+	require.NoError(t, err)
+	require.Equal(t, "new-uuid-purge", got.UUID)
+}
+
+func testMDMAppleBlockedEnrollments(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm1, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	tm2, err := ds.NewTeam(ctx, &fleet.Team{Name: "team2"})
+	require.NoError(t, err)
+
+	blocked, err := ds.ListMDMAppleBlockedEnrollments(ctx, fleet.TeamFilter{User: test.UserAdmin})
+	require.NoError(t, err)
+	require.Empty(t, blocked)
+
+	// a device is blocked when synced from ABM
+	err = ds.UpsertMDMAppleBlockedEnrollment(ctx, &fleet.MDMAppleBlockedEnrollment{
+		SerialNumber:  "serial-1",
+		TeamID:        &tm1.ID,
+		HardwareModel: "iMac 27\"",
+		Source:        fleet.MDMAppleBlockedEnrollmentSourceDEP,
+		Action:        fleet.MacOSEnrollmentEligibilityActionBlock,
+		Reason:        "architecture x86_64 is not the required architecture arm64",
+	})
+	require.NoError(t, err)
+	time.Sleep(time.Second) // ensure a different updated_at
+
+	err = ds.UpsertMDMAppleBlockedEnrollment(ctx, &fleet.MDMAppleBlockedEnrollment{
+		SerialNumber:  "serial-2",
+		HostUUID:      "uuid-2",
+		TeamID:        &tm2.ID,
+		HardwareModel: "MacBookPro11,1",
+		Source:        fleet.MDMAppleBlockedEnrollmentSourceEnrollment,
+		Action:        fleet.MacOSEnrollmentEligibilityActionFlag,
+		Reason:        "model year 2013 is older than the minimum model year 2018",
+	})
+	require.NoError(t, err)
+
+	blocked, err = ds.ListMDMAppleBlockedEnrollments(ctx, fleet.TeamFilter{User: test.UserAdmin})
+	require.NoError(t, err)
+	require.Len(t, blocked, 2)
+	require.Equal(t, "serial-2", blocked[0].SerialNumber)
+	require.Equal(t, "uuid-2", blocked[0].HostUUID)
+	require.Equal(t, fleet.MacOSEnrollmentEligibilityActionFlag, blocked[0].Action)
+	require.Equal(t, "serial-1", blocked[1].SerialNumber)
+	require.Empty(t, blocked[1].HostUUID)
+	require.Equal(t, &tm1.ID, blocked[1].TeamID)
+	require.NotZero(t, blocked[1].UpdatedAt)
+
+	// team users only see the devices of their teams
+	blocked, err = ds.ListMDMAppleBlockedEnrollments(ctx, fleet.TeamFilter{User: test.UserTeamAdminTeam2})
+	require.NoError(t, err)
+	require.Len(t, blocked, 1)
+	require.Equal(t, "serial-2", blocked[0].SerialNumber)
+	blocked, err = ds.ListMDMAppleBlockedEnrollments(ctx, fleet.TeamFilter{User: test.UserAdmin, TeamID: &tm1.ID})
+	require.NoError(t, err)
+	require.Len(t, blocked, 1)
+	require.Equal(t, "serial-1", blocked[0].SerialNumber)
+
+	// the device blocked in ABM enrolls, the host UUID is recorded and the
+	// hardware model is kept if not provided
+	err = ds.UpsertMDMAppleBlockedEnrollment(ctx, &fleet.MDMAppleBlockedEnrollment{
+		SerialNumber: "serial-1",
+		HostUUID:     "uuid-1",
+		TeamID:       &tm1.ID,
+		Source:       fleet.MDMAppleBlockedEnrollmentSourceEnrollment,
+		Action:       fleet.MacOSEnrollmentEligibilityActionBlock,
+		Reason:       "model year 2015 is older than the minimum model year 2018",
+	})
+	require.NoError(t, err)
+	blocked, err = ds.ListMDMAppleBlockedEnrollments(ctx, fleet.TeamFilter{User: test.UserAdmin})
+	require.NoError(t, err)
+	require.Len(t, blocked, 2)
+	require.Equal(t, "serial-1", blocked[0].SerialNumber)
+	require.Equal(t, "uuid-1", blocked[0].HostUUID)
+	require.Equal(t, "iMac 27\"", blocked[0].HardwareModel)
+	require.Equal(t, fleet.MDMAppleBlockedEnrollmentSourceEnrollment, blocked[0].Source)
+	require.Contains(t, blocked[0].Reason, "model year 2015")
+
+	// eligible devices are deleted
+	require.NoError(t, ds.DeleteMDMAppleBlockedEnrollments(ctx, nil))
+	require.NoError(t, ds.DeleteMDMAppleBlockedEnrollments(ctx, []string{"serial-1", "no-such-serial"}))
+	blocked, err = ds.ListMDMAppleBlockedEnrollments(ctx, fleet.TeamFilter{User: test.UserAdmin})
+	require.NoError(t, err)
+	require.Len(t, blocked, 1)
+	require.Equal(t, "serial-2", blocked[0].SerialNumber)
+}
+
+func testMDMAppleProfileChanges(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	newChange := func(profileID uint, op fleet.MDMAppleOperationType, checksum string, hostCount int) *fleet.MDMAppleProfileChange {
+		return &fleet.MDMAppleProfileChange{
+			ProfileID:         profileID,
+			ProfileIdentifier: fmt.Sprintf("I%d", profileID),
+			ProfileName:       fmt.Sprintf("N%d", profileID),
+			Checksum:          []byte(checksum + strings.Repeat("0", 16-len(checksum))),
+			OperationType:     op,
+			HostCount:         hostCount,
+		}
+	}
+
+	changes, err := ds.ListMDMAppleProfileChanges(ctx, "")
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	c1 := newChange(1, fleet.MDMAppleOperationTypeInstall, "a", 10)
+	c2 := newChange(1, fleet.MDMAppleOperationTypeRemove, "a", 20)
+	created, err := ds.RequestMDMAppleProfileChanges(ctx, []*fleet.MDMAppleProfileChange{c1, c2}, expiresAt)
+	require.NoError(t, err)
+	require.Len(t, created, 2)
+	require.NotZero(t, c1.ID)
+	require.NotEqual(t, c1.ID, c2.ID)
+	require.Equal(t, fleet.MDMAppleProfileChangePending, c1.Status)
+
+	// requesting the same changes again keeps them, with the host count updated
+	c1 = newChange(1, fleet.MDMAppleOperationTypeInstall, "a", 15)
+	c3 := newChange(1, fleet.MDMAppleOperationTypeInstall, "b", 30)
+	created, err = ds.RequestMDMAppleProfileChanges(ctx, []*fleet.MDMAppleProfileChange{c1, c3}, expiresAt.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, created, 1)
+	require.Equal(t, c3.ID, created[0].ID)
+	require.Equal(t, expiresAt, c1.ExpiresAt)
+
+	changes, err = ds.ListMDMAppleProfileChanges(ctx, fleet.MDMAppleProfileChangePending)
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+	byID := make(map[uint]*fleet.MDMAppleProfileChange)
+	for _, c := range changes {
+		byID[c.ID] = c
+	}
+	require.Equal(t, 15, byID[c1.ID].HostCount)
+	require.Equal(t, "I1", byID[c1.ID].ProfileIdentifier)
+
+	// approve and reject changes
+	reviewed, err := ds.ReviewMDMAppleProfileChange(ctx, c1.ID, fleet.MDMAppleProfileChangeApproved)
+	require.NoError(t, err)
+	require.Equal(t, fleet.MDMAppleProfileChangeApproved, reviewed.Status)
+	require.NotNil(t, reviewed.ReviewedAt)
+	_, err = ds.ReviewMDMAppleProfileChange(ctx, c1.ID, fleet.MDMAppleProfileChangeRejected)
+	require.True(t, fleet.IsNotFound(err))
+	_, err = ds.ReviewMDMAppleProfileChange(ctx, c2.ID, fleet.MDMAppleProfileChangeRejected)
+	require.NoError(t, err)
+
+	// the host count of reviewed changes is not updated
+	c1 = newChange(1, fleet.MDMAppleOperationTypeInstall, "a", 50)
+	created, err = ds.RequestMDMAppleProfileChanges(ctx, []*fleet.MDMAppleProfileChange{c1}, expiresAt)
+	require.NoError(t, err)
+	require.Empty(t, created)
+	require.Equal(t, fleet.MDMAppleProfileChangeApproved, c1.Status)
+	changes, err = ds.ListMDMAppleProfileChanges(ctx, fleet.MDMAppleProfileChangeApproved)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	require.Equal(t, 15, changes[0].HostCount)
+
+	// expire the pending change, it can't be reviewed anymore and a new one
+	// is created if requested again
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `UPDATE mdm_apple_profile_changes SET expires_at = DATE_SUB(NOW(), INTERVAL 1 MINUTE) WHERE id = ?`, c3.ID)
+		return err
+	})
+	_, err = ds.ReviewMDMAppleProfileChange(ctx, c3.ID, fleet.MDMAppleProfileChangeApproved)
+	require.True(t, fleet.IsNotFound(err))
+	require.NoError(t, ds.ExpireMDMAppleProfileChanges(ctx))
+	changes, err = ds.ListMDMAppleProfileChanges(ctx, fleet.MDMAppleProfileChangeExpired)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	require.Equal(t, c3.ID, changes[0].ID)
+
+	prevID := c3.ID
+	c3 = newChange(1, fleet.MDMAppleOperationTypeInstall, "b", 30)
+	created, err = ds.RequestMDMAppleProfileChanges(ctx, []*fleet.MDMAppleProfileChange{c3}, expiresAt)
+	require.NoError(t, err)
+	require.Len(t, created, 1)
+	require.NotEqual(t, prevID, c3.ID)
+
+	changes, err = ds.ListMDMAppleProfileChanges(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, changes, 4)
+}
+
+func testMDMAppleReconciliationPlan(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	var hosts []*fleet.Host
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("host-%d", i)
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:      name,
+			OsqueryHostID: ptr.String(name),
+			NodeKey:       ptr.String(name),
+			UUID:          name,
+			Platform:      "darwin",
+		})
+		require.NoError(t, err)
+		nanoEnroll(t, ds, h, false)
+		hosts = append(hosts, h)
+	}
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	require.NoError(t, ds.BatchSetMDMAppleProfiles(ctx, nil, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N1", "I1", "a"),
+		configProfileForTest(t, "N2", "I2", "b"),
+	}))
+	require.NoError(t, ds.BatchSetMDMAppleProfiles(ctx, &tm.ID, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "T1", "I3", "c"),
+	}))
+
+	type planItem struct {
+		host, ident string
+		op          fleet.MDMAppleOperationType
+		reason      fleet.MDMAppleReconciliationReason
+	}
+	asSet := func(items []*fleet.MDMAppleReconciliationPlanItem) []planItem {
+		var set []planItem
+		for _, it := range items {
+			require.Equal(t, it.HostUUID, it.Hostname)
+			set = append(set, planItem{it.HostUUID, it.ProfileIdentifier, it.OperationType, it.Reason})
+		}
+		return set
+	}
+	install, remove := fleet.MDMAppleOperationTypeInstall, fleet.MDMAppleOperationTypeRemove
+
+	plan, err := ds.ListMDMAppleReconciliationPlan(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []planItem{
+		{"host-0", "I1", install, fleet.MDMAppleReconciliationReasonNewProfile},
+		{"host-0", "I2", install, fleet.MDMAppleReconciliationReasonNewProfile},
+		{"host-1", "I1", install, fleet.MDMAppleReconciliationReasonNewProfile},
+		{"host-1", "I2", install, fleet.MDMAppleReconciliationReasonNewProfile},
+		{"host-2", "I1", install, fleet.MDMAppleReconciliationReasonNewProfile},
+		{"host-2", "I2", install, fleet.MDMAppleReconciliationReasonNewProfile},
+	}, asSet(plan))
+	require.Equal(t, hosts[0].ID, plan[0].HostID)
+
+	// the team has no hosts
+	plan, err = ds.ListMDMAppleReconciliationPlan(ctx, &tm.ID)
+	require.NoError(t, err)
+	require.Empty(t, plan)
+
+	// install the profiles
+	toInstall, err := ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	var payload []*fleet.MDMAppleBulkUpsertHostProfilePayload
+	for _, p := range toInstall {
+		payload = append(payload, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+			ProfileID:         p.ProfileID,
+			ProfileIdentifier: p.ProfileIdentifier,
+			ProfileName:       p.ProfileName,
+			HostUUID:          p.HostUUID,
+			CommandUUID:       uuid.NewString(),
+			OperationType:     fleet.MDMAppleOperationTypeInstall,
+			Status:            &fleet.MDMAppleDeliveryVerifying,
+			Checksum:          p.Checksum,
+		})
+	}
+	require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, payload))
+	plan, err = ds.ListMDMAppleReconciliationPlan(ctx, ptr.Uint(0))
+	require.NoError(t, err)
+	require.Empty(t, plan)
+
+	// a failed profile is retried, an outdated one is updated
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `UPDATE host_mdm_apple_profiles SET status = NULL, failure_count = 1
+			WHERE host_uuid = 'host-0' AND profile_identifier = 'I1'`)
+		if err != nil {
+			return err
+		}
+		_, err = q.ExecContext(ctx, `UPDATE host_mdm_apple_profiles SET checksum = UNHEX(MD5('outdated'))
+			WHERE host_uuid = 'host-1' AND profile_identifier = 'I2'`)
+		return err
+	})
+
+	// move the last host to the team
+	require.NoError(t, ds.AddHostsToTeam(ctx, &tm.ID, []uint{hosts[2].ID}))
+
+	plan, err = ds.ListMDMAppleReconciliationPlan(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []planItem{
+		{"host-0", "I1", install, fleet.MDMAppleReconciliationReasonFailedRetry},
+		{"host-1", "I2", install, fleet.MDMAppleReconciliationReasonProfileUpdated},
+	}, asSet(plan))
+
+	plan, err = ds.ListMDMAppleReconciliationPlan(ctx, &tm.ID)
+	require.NoError(t, err)
+	require.Equal(t, []planItem{
+		{"host-2", "I3", install, fleet.MDMAppleReconciliationReasonTeamChange},
+		{"host-2", "I1", remove, fleet.MDMAppleReconciliationReasonTeamChange},
+		{"host-2", "I2", remove, fleet.MDMAppleReconciliationReasonTeamChange},
+	}, asSet(plan))
+
+	// delete the first profile, it is removed from the hosts of no team
+	require.NoError(t, ds.BatchSetMDMAppleProfiles(ctx, nil, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N2", "I2", "b"),
+	}))
+	plan, err = ds.ListMDMAppleReconciliationPlan(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []planItem{
+		{"host-1", "I2", install, fleet.MDMAppleReconciliationReasonProfileUpdated},
+		{"host-0", "I1", remove, fleet.MDMAppleReconciliationReasonProfileDeleted},
+		{"host-1", "I1", remove, fleet.MDMAppleReconciliationReasonProfileDeleted},
+	}, asSet(plan))
+}
+
+func testMDMAppleHostDEPProfileOverrides(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	team, err := ds.NewTeam(ctx, &fleet.Team{Name: "kiosks"})
+	require.NoError(t, err)
+
+	var hosts []*fleet.Host
+	for i := 0; i < 3; i++ {
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			DetailUpdatedAt: time.Now(),
+			LabelUpdatedAt:  time.Now(),
+			PolicyUpdatedAt: time.Now(),
+			SeenTime:        time.Now(),
+			OsqueryHostID:   ptr.String(fmt.Sprintf("dep-override-osquery-id-%d", i)),
+			NodeKey:         ptr.String(fmt.Sprintf("dep-override-node-key-%d", i)),
+			UUID:            fmt.Sprintf("dep-override-uuid-%d", i),
+			Hostname:        fmt.Sprintf("dep-override-%d", i),
+			HardwareSerial:  fmt.Sprintf("serial-%d", i),
+		})
+		require.NoError(t, err)
+		hosts = append(hosts, h)
+	}
+	require.NoError(t, ds.AddHostsToTeam(ctx, &team.ID, []uint{hosts[1].ID}))
+
+	_, err = ds.GetHostMDMAppleDEPProfileOverride(ctx, hosts[0].ID)
+	require.True(t, fleet.IsNotFound(err))
+
+	// clearing a missing override is a no-op
+	err = ds.SetHostMDMAppleDEPProfileOverride(ctx, hosts[0].ID, nil)
+	require.NoError(t, err)
+	pending, err := ds.ListPendingHostMDMAppleDEPProfileOverrides(ctx)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	kiosk := json.RawMessage(`{"profile_name": "kiosk", "skip_setup_items": ["Siri"]}`)
+	err = ds.SetHostMDMAppleDEPProfileOverride(ctx, hosts[0].ID, &kiosk)
+	require.NoError(t, err)
+	err = ds.SetHostMDMAppleDEPProfileOverride(ctx, hosts[1].ID, &kiosk)
+	require.NoError(t, err)
+
+	override, err := ds.GetHostMDMAppleDEPProfileOverride(ctx, hosts[0].ID)
+	require.NoError(t, err)
+	require.JSONEq(t, string(kiosk), string(*override.Profile))
+	require.Empty(t, override.ProfileUUID)
+	require.Nil(t, override.AssignedAt)
+
+	pending, err = ds.ListPendingHostMDMAppleDEPProfileOverrides(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	require.Equal(t, "serial-0", pending[0].HardwareSerial)
+	require.Nil(t, pending[0].TeamName)
+	require.Equal(t, "serial-1", pending[1].HardwareSerial)
+	require.NotNil(t, pending[1].TeamName)
+	require.Equal(t, "kiosks", *pending[1].TeamName)
+
+	// assign both
+	err = ds.SetHostMDMAppleDEPProfileOverrideAssigned(ctx, hosts[0].ID, "uuid-0")
+	require.NoError(t, err)
+	err = ds.SetHostMDMAppleDEPProfileOverrideAssigned(ctx, hosts[1].ID, "uuid-1")
+	require.NoError(t, err)
+	pending, err = ds.ListPendingHostMDMAppleDEPProfileOverrides(ctx)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	override, err = ds.GetHostMDMAppleDEPProfileOverride(ctx, hosts[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, "uuid-0", override.ProfileUUID)
+	require.NotNil(t, override.AssignedAt)
+
+	bySerial, err := ds.ListHostMDMAppleDEPProfileOverridesBySerials(ctx, []string{"serial-0", "serial-1", "serial-2"})
+	require.NoError(t, err)
+	require.Len(t, bySerial, 2)
+	require.Equal(t, hosts[0].ID, bySerial["serial-0"].HostID)
+	require.Equal(t, hosts[1].ID, bySerial["serial-1"].HostID)
+
+	// mark the first one pending again, e.g. when the device is synced
+	err = ds.SetHostMDMAppleDEPProfileOverridesPending(ctx, []uint{hosts[0].ID})
+	require.NoError(t, err)
+	pending, err = ds.ListPendingHostMDMAppleDEPProfileOverrides(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, hosts[0].ID, pending[0].HostID)
+	require.Equal(t, "uuid-0", pending[0].ProfileUUID)
+
+	// updating the profile resets its UUID
+	other := json.RawMessage(`{"profile_name": "other"}`)
+	err = ds.SetHostMDMAppleDEPProfileOverride(ctx, hosts[0].ID, &other)
+	require.NoError(t, err)
+	override, err = ds.GetHostMDMAppleDEPProfileOverride(ctx, hosts[0].ID)
+	require.NoError(t, err)
+	require.JSONEq(t, string(other), string(*override.Profile))
+	require.Empty(t, override.ProfileUUID)
+
+	// clear the second one, it is pending until the default profile is
+	// assigned back
+	err = ds.SetHostMDMAppleDEPProfileOverride(ctx, hosts[1].ID, nil)
+	require.NoError(t, err)
+	_, err = ds.GetHostMDMAppleDEPProfileOverride(ctx, hosts[1].ID)
+	require.True(t, fleet.IsNotFound(err))
+	bySerial, err = ds.ListHostMDMAppleDEPProfileOverridesBySerials(ctx, []string{"serial-1"})
+	require.NoError(t, err)
+	require.Empty(t, bySerial)
+
+	pending, err = ds.ListPendingHostMDMAppleDEPProfileOverrides(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	require.Equal(t, hosts[1].ID, pending[1].HostID)
+	require.Nil(t, pending[1].Profile)
+
+	err = ds.SetHostMDMAppleDEPProfileOverrideAssigned(ctx, hosts[1].ID, "default-uuid")
+	require.NoError(t, err)
+	var count int
+	err = sqlx.GetContext(ctx, ds.reader, &count, `SELECT COUNT(*) FROM host_mdm_apple_dep_profile_overrides WHERE host_id = ?`, hosts[1].ID)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+func TestNanoDEPStorageConcurrentRunners(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	ctx := context.Background()
+
+	depStorage, err := ds.NewMDMAppleDEPStorage(nanodep_client.OAuth1Tokens{})
+	require.NoError(t, err)
+
+	// runConcurrently runs fn on n goroutines and returns their errors.
+	runConcurrently := func(n int, fn func(i int) error) []error {
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = fn(i)
+			}(i)
+		}
+		wg.Wait()
+		return errs
+	}
+
+	// no cursor stored yet, only one of the runners swapping from the empty
+	// cursor to a different cursor succeeds
+	errs := runConcurrently(10, func(i int) error {
+		return depStorage.SwapCursor(ctx, apple_mdm.DEPName, "", fmt.Sprintf("c1-%d", i))
+	})
+	var winner string
+	for i, err := range errs {
+		if err == nil {
+			require.Empty(t, winner)
+			winner = fmt.Sprintf("c1-%d", i)
+			continue
+		}
+		require.ErrorIs(t, err, apple_mdm.ErrDEPCursorConflict)
+	}
+	require.NotEmpty(t, winner)
+	cursor, cursorAt, err := depStorage.RetrieveCursor(ctx, apple_mdm.DEPName)
+	require.NoError(t, err)
+	require.Equal(t, winner, cursor)
+
+	// make sure the modification time would change if the cursor was updated
+	time.Sleep(time.Second)
+
+	// all runners swapping to the same cursor succeed, it is updated once
+	errs = runConcurrently(10, func(i int) error {
+		return depStorage.SwapCursor(ctx, apple_mdm.DEPName, winner, "c2")
+	})
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	cursor, cursorAt2, err := depStorage.RetrieveCursor(ctx, apple_mdm.DEPName)
+	require.NoError(t, err)
+	require.Equal(t, "c2", cursor)
+	require.True(t, cursorAt2.After(cursorAt))
+
+	// a runner late by one page cannot move the cursor back
+	err = depStorage.SwapCursor(ctx, apple_mdm.DEPName, "", winner)
+	require.ErrorIs(t, err, apple_mdm.ErrDEPCursorConflict)
+	cursor, _, err = depStorage.RetrieveCursor(ctx, apple_mdm.DEPName)
+	require.NoError(t, err)
+	require.Equal(t, "c2", cursor)
+
+	time.Sleep(time.Second)
+
+	// storing the same cursor again keeps its modification time
+	errs = runConcurrently(10, func(i int) error {
+		return depStorage.StoreCursor(ctx, apple_mdm.DEPName, "c2")
+	})
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	cursor, cursorAt3, err := depStorage.RetrieveCursor(ctx, apple_mdm.DEPName)
+	require.NoError(t, err)
+	require.Equal(t, "c2", cursor)
+	require.Equal(t, cursorAt2, cursorAt3)
+
+	// storing a different cursor updates it
+	err = depStorage.StoreCursor(ctx, apple_mdm.DEPName, "c3")
+	require.NoError(t, err)
+	cursor, cursorAt3, err = depStorage.RetrieveCursor(ctx, apple_mdm.DEPName)
+	require.NoError(t, err)
+	require.Equal(t, "c3", cursor)
+	require.True(t, cursorAt3.After(cursorAt2))
+
+	// storing the same assigner profile concurrently keeps its modification
+	// time, so that the cursor is not reset by every runner
+	err = depStorage.StoreAssignerProfile(ctx, apple_mdm.DEPName, "profile-1")
+	require.NoError(t, err)
+	profileUUID, profileAt, err := depStorage.RetrieveAssignerProfile(ctx, apple_mdm.DEPName)
+	require.NoError(t, err)
+	require.Equal(t, "profile-1", profileUUID)
+
+	time.Sleep(time.Second)
+
+	errs = runConcurrently(10, func(i int) error {
+		return depStorage.StoreAssignerProfile(ctx, apple_mdm.DEPName, "profile-1")
+	})
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	profileUUID, profileAt2, err := depStorage.RetrieveAssignerProfile(ctx, apple_mdm.DEPName)
+	require.NoError(t, err)
+	require.Equal(t, "profile-1", profileUUID)
+	require.Equal(t, profileAt, profileAt2)
+
+	err = depStorage.StoreAssignerProfile(ctx, apple_mdm.DEPName, "profile-2")
+	require.NoError(t, err)
+	profileUUID, profileAt2, err = depStorage.RetrieveAssignerProfile(ctx, apple_mdm.DEPName)
+	require.NoError(t, err)
+	require.Equal(t, "profile-2", profileUUID)
+	require.True(t, profileAt2.After(profileAt))
+}
+
+func testMDMAppleProfileSecrets(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	var hosts []*fleet.Host
+	for i := 0; i < 2; i++ {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo.local.%d", i), "1.1.1.1",
+			fmt.Sprintf("%d", i), fmt.Sprintf("uuid-%d", i), time.Now())
+		nanoEnroll(t, ds, h, false)
+		hosts = append(hosts, h)
+	}
+	secretProf, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "N1", "I1", "$FLEET_SECRET_WIFI_PSK"))
+	require.NoError(t, err)
+	otherProf, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "N2", "I2", "b"))
+	require.NoError(t, err)
+
+	profs, err := ds.ListMDMAppleProfilesReferencingSecrets(ctx)
+	require.NoError(t, err)
+	require.Len(t, profs, 1)
+	require.Contains(t, string(profs[secretProf.ProfileID]), "$FLEET_SECRET_WIFI_PSK")
+
+	// the delivered checksums of the profiles of hosts[0], with the profiles
+	// that need to be installed.
+	toInstall := func() map[uint][]byte {
+		profiles, err := ds.ListMDMAppleProfilesToInstall(ctx)
+		require.NoError(t, err)
+		sums := make(map[uint][]byte)
+		for _, p := range profiles {
+			if p.HostUUID == hosts[0].UUID {
+				sums[p.ProfileID] = p.Checksum
+			}
+		}
+		return sums
+	}
+	initial := toInstall()
+	require.Len(t, initial, 2)
+
+	// the checksum of the versions of the secrets changes the delivered
+	// checksum of the profile only
+	require.NoError(t, ds.SetMDMAppleProfileSecretsChecksum(ctx, secretProf.ProfileID, []byte("0123456789abcdef")))
+	v1 := toInstall()
+	require.NotEqual(t, initial[secretProf.ProfileID], v1[secretProf.ProfileID])
+	require.Equal(t, initial[otherProf.ProfileID], v1[otherProf.ProfileID])
+
+	// once delivered, the profile is installed again when a secret rotates
+	var payloads []*fleet.MDMAppleBulkUpsertHostProfilePayload
+	for _, prof := range []*fleet.MDMAppleConfigProfile{secretProf, otherProf} {
+		payloads = append(payloads, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+			ProfileID:         prof.ProfileID,
+			ProfileIdentifier: prof.Identifier,
+			ProfileName:       prof.Name,
+			HostUUID:          hosts[0].UUID,
+			OperationType:     fleet.MDMAppleOperationTypeInstall,
+			Status:            &fleet.MDMAppleDeliveryVerifying,
+			CommandUUID:       "cmd-" + prof.Identifier,
+			Checksum:          v1[prof.ProfileID],
+		})
+	}
+	require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, payloads))
+	require.Empty(t, toInstall())
+
+	require.NoError(t, ds.SetMDMAppleProfileSecretsChecksum(ctx, secretProf.ProfileID, []byte("fedcba9876543210")))
+	v2 := toInstall()
+	require.Len(t, v2, 1)
+	require.NotEqual(t, v1[secretProf.ProfileID], v2[secretProf.ProfileID])
+
+	// the command is only redacted once all its hosts reported a result for
+	// it, a NotNow result is delivered again.
+	commander, storage := createMDMAppleCommanderAndStorage(t, ds)
+	cmdUUID := fleet.MDMAppleProfileSecretsCommandPrefix + "cmd"
+	require.NoError(t, commander.InstallProfile(ctx, []string{hosts[0].UUID, hosts[1].UUID}, []byte("secret"), cmdUUID))
+	report := func(h *fleet.Host, status string) {
+		err := storage.StoreCommandReport(&mdm.Request{
+			EnrollID: &mdm.EnrollID{ID: h.UUID},
+			Context:  ctx,
+		}, &mdm.CommandResults{
+			CommandUUID: cmdUUID,
+			Status:      status,
+			RequestType: "InstallProfile",
+			Raw:         []byte("<?xml"),
+		})
+		require.NoError(t, err)
+	}
+	redacted := apple_mdm.RedactedInstallProfileCommand(cmdUUID)
+	storedCommand := func() string {
+		var cmd string
+		require.NoError(t, sqlx.GetContext(ctx, ds.reader, &cmd, `SELECT command FROM nano_commands WHERE command_uuid = ?`, cmdUUID))
+		return cmd
+	}
+
+	report(hosts[0], fleet.MDMAppleStatusAcknowledged)
+	require.NoError(t, ds.RedactMDMAppleCommand(ctx, cmdUUID, redacted))
+	require.Contains(t, storedCommand(), base64.StdEncoding.EncodeToString([]byte("secret")))
+
+	report(hosts[1], fleet.MDMAppleStatusNotNow)
+	require.NoError(t, ds.RedactMDMAppleCommand(ctx, cmdUUID, redacted))
+	require.Contains(t, storedCommand(), base64.StdEncoding.EncodeToString([]byte("secret")))
+
+	report(hosts[1], fleet.MDMAppleStatusError)
+	require.NoError(t, ds.RedactMDMAppleCommand(ctx, cmdUUID, redacted))
+	require.Equal(t, redacted, storedCommand())
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/fleetdm/fleet/v4/server/config"
+	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/go-kit/kit/log"
 	"github.com/ngrok/sqlmw"
 )
@@ -25,6 +26,8 @@ type dbOptions struct {
 	tracingConfig       *config.LoggingConfig
 	minLastOpenedAtDiff time.Duration
 	sqlMode             string
+	profileChecksum     fleet.MDMProfileChecksumAlgorithm
+	serverPrivateKey    string
 }
 
 // Logger adds a logger to the datastore.
@@ -73,6 +76,12 @@ func TracingEnabled(lconfig *config.LoggingConfig) DBOption {
 func WithFleetConfig(conf *config.FleetConfig) DBOption {
 	return func(o *dbOptions) error {
 		o.minLastOpenedAtDiff = conf.Osquery.MinSoftwareLastOpenedAtDiff
+		alg, err := conf.MDM.ProfileChecksum()
+		if err != nil {
+			return err
+		}
+		o.profileChecksum = fleet.MDMProfileChecksumAlgorithm(alg)
+		o.serverPrivateKey = conf.Server.PrivateKey
 		return nil
 	}
 }
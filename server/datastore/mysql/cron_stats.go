@@ -2,6 +2,7 @@ package mysql
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/fleet"
@@ -63,16 +64,59 @@ func (ds *Datastore) InsertCronStats(ctx context.Context, statsType fleet.CronSt
 	return int(id), nil
 }
 
-func (ds *Datastore) UpdateCronStats(ctx context.Context, id int, status fleet.CronStatsStatus) error {
-	stmt := `UPDATE cron_stats SET status = ? WHERE id = ?`
+func (ds *Datastore) UpdateCronStats(ctx context.Context, id int, status fleet.CronStatsStatus, cronErrors fleet.CronScheduleErrors) error {
+	stmt := `UPDATE cron_stats SET status = ?, errors = ? WHERE id = ?`
 
-	if _, err := ds.writer.ExecContext(ctx, stmt, status, id); err != nil {
+	if _, err := ds.writer.ExecContext(ctx, stmt, status, cronErrors, id); err != nil {
 		return ctxerr.Wrap(ctx, err, "update cron stats")
 	}
 
 	return nil
 }
 
+func (ds *Datastore) GetLatestCompletedCronStats(ctx context.Context, name string) (*fleet.CronStats, error) {
+	stmt := `
+	SELECT
+		id, name, instance, stats_type, status, created_at, updated_at, errors
+	FROM
+		cron_stats
+	WHERE
+		name = ?
+		AND status = 'completed'
+	ORDER BY
+		created_at DESC, id DESC
+	LIMIT 1`
+
+	var res fleet.CronStats
+	if err := sqlx.GetContext(ctx, ds.reader, &res, stmt, name); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ctxerr.Wrap(ctx, notFound("CronStats").WithName(name))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "select latest completed cron stats")
+	}
+	return &res, nil
+}
+
+func (ds *Datastore) ListLatestCompletedCronStats(ctx context.Context, name string, limit int) ([]fleet.CronStats, error) {
+	stmt := `
+	SELECT
+		id, name, instance, stats_type, status, created_at, updated_at, errors
+	FROM
+		cron_stats
+	WHERE
+		name = ?
+		AND status = 'completed'
+	ORDER BY
+		created_at DESC, id DESC
+	LIMIT ?`
+
+	var res []fleet.CronStats
+	if err := sqlx.SelectContext(ctx, ds.reader, &res, stmt, name, limit); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "select latest completed cron stats")
+	}
+	return res, nil
+}
+
 func (ds *Datastore) UpdateAllCronStatsForInstance(ctx context.Context, instance string, fromStatus fleet.CronStatsStatus, toStatus fleet.CronStatsStatus) error {
 	stmt := `UPDATE cron_stats SET status = ? WHERE instance = ? AND status = ?`
 
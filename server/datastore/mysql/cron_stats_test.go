@@ -28,7 +28,10 @@ func TestInsertUpdateCronStats(t *testing.T) {
 	require.Equal(t, fleet.CronStatsTypeScheduled, res[0].StatsType)
 	require.Equal(t, fleet.CronStatsStatusPending, res[0].Status)
 
-	err = ds.UpdateCronStats(ctx, id, fleet.CronStatsStatusCompleted)
+	_, err = ds.GetLatestCompletedCronStats(ctx, scheduleName)
+	require.True(t, fleet.IsNotFound(err))
+
+	err = ds.UpdateCronStats(ctx, id, fleet.CronStatsStatusCompleted, fleet.CronScheduleErrors{"test_job": "failed"})
 	require.NoError(t, err)
 
 	res, err = ds.GetLatestCronStats(ctx, scheduleName)
@@ -37,6 +40,44 @@ func TestInsertUpdateCronStats(t *testing.T) {
 	require.Equal(t, id, res[0].ID)
 	require.Equal(t, fleet.CronStatsTypeScheduled, res[0].StatsType)
 	require.Equal(t, fleet.CronStatsStatusCompleted, res[0].Status)
+
+	completed, err := ds.GetLatestCompletedCronStats(ctx, scheduleName)
+	require.NoError(t, err)
+	require.Equal(t, id, completed.ID)
+	require.Equal(t, fleet.CronScheduleErrors{"test_job": "failed"}, completed.Errors)
+
+	// a new run without errors clears them
+	id2, err := ds.InsertCronStats(ctx, fleet.CronStatsTypeTriggered, scheduleName, instanceID, fleet.CronStatsStatusPending)
+	require.NoError(t, err)
+	err = ds.UpdateCronStats(ctx, id2, fleet.CronStatsStatusCompleted, nil)
+	require.NoError(t, err)
+
+	completed, err = ds.GetLatestCompletedCronStats(ctx, scheduleName)
+	require.NoError(t, err)
+	require.Equal(t, id2, completed.ID)
+	require.Equal(t, fleet.CronStatsTypeTriggered, completed.StatsType)
+	require.Nil(t, completed.Errors)
+
+	// the pending runs are not listed
+	_, err = ds.InsertCronStats(ctx, fleet.CronStatsTypeScheduled, scheduleName, instanceID, fleet.CronStatsStatusPending)
+	require.NoError(t, err)
+
+	runs, err := ds.ListLatestCompletedCronStats(ctx, scheduleName, 10)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	require.Equal(t, id2, runs[0].ID)
+	require.Nil(t, runs[0].Errors)
+	require.Equal(t, id, runs[1].ID)
+	require.Equal(t, fleet.CronScheduleErrors{"test_job": "failed"}, runs[1].Errors)
+
+	runs, err = ds.ListLatestCompletedCronStats(ctx, scheduleName, 1)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	require.Equal(t, id2, runs[0].ID)
+
+	runs, err = ds.ListLatestCompletedCronStats(ctx, "no_such_sched", 10)
+	require.NoError(t, err)
+	require.Empty(t, runs)
 }
 
 func TestGetLatestCronStats(t *testing.T) {
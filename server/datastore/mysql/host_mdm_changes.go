@@ -0,0 +1,96 @@
+package mysql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+)
+
+// hostMDMChange is a change of the MDM state of a host to record. The changes
+// are recorded in the transaction that makes them so that the consumers of
+// the MDM changes API don't miss any.
+type hostMDMChange struct {
+	HostUUID string
+	Type     fleet.HostMDMChangeType
+	// Details is encoded as JSON, it is not stored if nil.
+	Details interface{}
+}
+
+func recordHostMDMChangesDB(ctx context.Context, tx sqlx.ExtContext, changes []hostMDMChange) error {
+	const batchSize = 1000
+
+	for len(changes) > 0 {
+		batch := changes
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		changes = changes[len(batch):]
+
+		var sb strings.Builder
+		args := make([]interface{}, 0, len(batch)*3)
+		for _, c := range batch {
+			var details []byte
+			if c.Details != nil {
+				b, err := json.Marshal(c.Details)
+				if err != nil {
+					return ctxerr.Wrap(ctx, err, "marshal host MDM change details")
+				}
+				details = b
+			}
+			args = append(args, c.HostUUID, c.Type, details)
+			sb.WriteString("(?, ?, ?),")
+		}
+
+		stmt := fmt.Sprintf(`INSERT INTO host_mdm_changes (host_uuid, change_type, details) VALUES %s`, strings.TrimSuffix(sb.String(), ","))
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return ctxerr.Wrap(ctx, err, "insert host MDM changes")
+		}
+	}
+	return nil
+}
+
+// hostMDMChangesSettleDelay is how long a change must have been recorded to be
+// listed. The ids are allocated when the changes are inserted, but the
+// transactions may commit in a different order: without this delay, a
+// consumer could advance its cursor past the id of a change that is not
+// committed yet and never see it.
+const hostMDMChangesSettleDelay = 5 * time.Second
+
+func (ds *Datastore) ListHostMDMChanges(ctx context.Context, since uint64, limit int) ([]*fleet.HostMDMChange, error) {
+	const stmt = `
+          SELECT
+            hmc.id,
+            hmc.host_uuid,
+            h.id AS host_id,
+            h.hardware_serial,
+            hmc.change_type,
+            hmc.details,
+            hmc.created_at
+          FROM host_mdm_changes hmc
+          LEFT JOIN hosts h ON h.uuid = hmc.host_uuid
+          WHERE hmc.id > ? AND hmc.created_at <= ?
+          ORDER BY hmc.id
+          LIMIT ?`
+
+	settled := time.Now().Add(-hostMDMChangesSettleDelay)
+	var changes []*fleet.HostMDMChange
+	if err := sqlx.SelectContext(ctx, ds.reader, &changes, stmt, since, settled, limit); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list host MDM changes")
+	}
+	return changes, nil
+}
+
+func (ds *Datastore) CleanupHostMDMChanges(ctx context.Context, now time.Time) (int64, error) {
+	res, err := ds.writer.ExecContext(ctx, `DELETE FROM host_mdm_changes WHERE created_at < ?`, now.Add(-fleet.HostMDMChangesRetention))
+	if err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "cleanup host MDM changes")
+	}
+	deleted, _ := res.RowsAffected()
+	return deleted, nil
+}
@@ -0,0 +1,170 @@
+package mysql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostMDMChanges(t *testing.T) {
+	ds := CreateMySQLDS(t)
+
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, ds *Datastore)
+	}{
+		{"RecordAndList", testHostMDMChangesRecordAndList},
+		{"Cleanup", testHostMDMChangesCleanup},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer TruncateTables(t, ds)
+			c.fn(t, ds)
+		})
+	}
+}
+
+// settleHostMDMChanges backdates the recorded changes so that they can be
+// listed.
+func settleHostMDMChanges(t *testing.T, ds *Datastore) {
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(context.Background(), `UPDATE host_mdm_changes SET created_at = created_at - INTERVAL 1 MINUTE`)
+		return err
+	})
+}
+
+func testHostMDMChangesRecordAndList(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	h1, err := ds.NewHost(ctx, &fleet.Host{
+		Hostname:        "h1",
+		OsqueryHostID:   ptr.String("h1"),
+		NodeKey:         ptr.String("h1"),
+		UUID:            "h1-uuid",
+		Platform:        "darwin",
+		DetailUpdatedAt: time.Now(),
+		LabelUpdatedAt:  time.Now(),
+		PolicyUpdatedAt: time.Now(),
+		SeenTime:        time.Now(),
+	})
+	require.NoError(t, err)
+
+	// a host enrolls
+	err = ds.IngestMDMAppleDeviceFromCheckin(ctx, fleet.MDMAppleHostDetails{UDID: "h2-uuid", SerialNumber: "S2", Model: "MacBook Pro"})
+	require.NoError(t, err)
+
+	// a profile is sent to h1, then verified, the same status is not a change
+	err = ds.BulkUpsertMDMAppleHostProfiles(ctx, []*fleet.MDMAppleBulkUpsertHostProfilePayload{{
+		ProfileID:         1,
+		ProfileIdentifier: "com.example.p1",
+		ProfileName:       "P1",
+		HostUUID:          h1.UUID,
+		CommandUUID:       "cmd-1",
+		OperationType:     fleet.MDMAppleOperationTypeInstall,
+		Status:            &fleet.MDMAppleDeliveryPending,
+		Checksum:          []byte("checksum"),
+	}})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		err = ds.UpdateOrDeleteHostMDMAppleProfile(ctx, &fleet.HostMDMAppleProfile{
+			HostUUID:      h1.UUID,
+			CommandUUID:   "cmd-1",
+			Status:        &fleet.MDMAppleDeliveryVerifying,
+			OperationType: fleet.MDMAppleOperationTypeInstall,
+		})
+		require.NoError(t, err)
+	}
+
+	// h1 escrows a key, escrowing the same key again is not a change
+	require.NoError(t, ds.SetOrUpdateHostDiskEncryptionKey(ctx, h1.ID, "a2V5"))
+	require.NoError(t, ds.SetOrUpdateHostDiskEncryptionKey(ctx, h1.ID, "a2V5"))
+
+	// h1 unenrolls
+	require.NoError(t, ds.UpdateHostTablesOnMDMUnenroll(ctx, h1.UUID))
+
+	// the changes are not listed until they are settled
+	changes, err := ds.ListHostMDMChanges(ctx, 0, 100)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	settleHostMDMChanges(t, ds)
+	changes, err = ds.ListHostMDMChanges(ctx, 0, 100)
+	require.NoError(t, err)
+	require.Len(t, changes, 5)
+
+	type change struct {
+		HostUUID string
+		Type     fleet.HostMDMChangeType
+	}
+	var got []change
+	for i, c := range changes {
+		got = append(got, change{c.HostUUID, c.Type})
+		if i > 0 {
+			require.Greater(t, c.ID, changes[i-1].ID)
+		}
+	}
+	require.Equal(t, []change{
+		{"h2-uuid", fleet.HostMDMChangeEnrolled},
+		{h1.UUID, fleet.HostMDMChangeProfileStatus},
+		{h1.UUID, fleet.HostMDMChangeProfileStatus},
+		{h1.UUID, fleet.HostMDMChangeKeyEscrowed},
+		{h1.UUID, fleet.HostMDMChangeUnenrolled},
+	}, got)
+
+	require.NotNil(t, changes[0].HostID)
+	require.Equal(t, "S2", *changes[0].HardwareSerial)
+	require.JSONEq(t, `{"serial_number": "S2", "model": "MacBook Pro"}`, string(*changes[0].Details))
+
+	var status fleet.HostMDMProfileStatusChange
+	require.NoError(t, json.Unmarshal(*changes[2].Details, &status))
+	require.Equal(t, "com.example.p1", status.ProfileIdentifier)
+	require.Equal(t, "P1", status.ProfileName)
+	require.Equal(t, fleet.MDMAppleOperationTypeInstall, status.OperationType)
+	require.Equal(t, &fleet.MDMAppleDeliveryVerifying, status.Status)
+	require.Nil(t, changes[4].Details)
+
+	// the changes are paginated by cursor
+	changes, err = ds.ListHostMDMChanges(ctx, changes[1].ID, 2)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.Equal(t, fleet.HostMDMChangeProfileStatus, changes[0].Type)
+	require.Equal(t, fleet.HostMDMChangeKeyEscrowed, changes[1].Type)
+
+	changes, err = ds.ListHostMDMChanges(ctx, changes[1].ID+1, 2)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	// the host of the changes was deleted
+	require.NoError(t, ds.DeleteHost(ctx, h1.ID))
+	changes, err = ds.ListHostMDMChanges(ctx, 0, 100)
+	require.NoError(t, err)
+	require.Nil(t, changes[4].HostID)
+	require.Nil(t, changes[4].HardwareSerial)
+}
+
+func testHostMDMChangesCleanup(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `INSERT INTO host_mdm_changes (host_uuid, change_type, created_at) VALUES (?, ?, ?), (?, ?, ?)`,
+			"uuid-1", fleet.HostMDMChangeEnrolled, time.Now().Add(-fleet.HostMDMChangesRetention-time.Hour),
+			"uuid-1", fleet.HostMDMChangeUnenrolled, time.Now().Add(-time.Hour),
+		)
+		return err
+	})
+
+	deleted, err := ds.CleanupHostMDMChanges(ctx, time.Now())
+	require.NoError(t, err)
+	require.EqualValues(t, 1, deleted)
+
+	changes, err := ds.ListHostMDMChanges(ctx, 0, 100)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	require.Equal(t, fleet.HostMDMChangeUnenrolled, changes[0].Type)
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/fleetdm/fleet/v4/server/config"
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	apple_mdm "github.com/fleetdm/fleet/v4/server/mdm/apple"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/jmoiron/sqlx"
@@ -25,8 +26,9 @@ var hostSearchColumns = []string{"hostname", "computer_name", "uuid", "hardware_
 
 // Fixme: We should not make implementation details of the database schema part of the API.
 var defaultHostColumnTableAliases = map[string]string{
-	"created_at": "h.created_at",
-	"updated_at": "h.updated_at",
+	"created_at":          "h.created_at",
+	"updated_at":          "h.updated_at",
+	"last_mdm_checkin_at": "hmct.checkin_time",
 }
 
 func defaultHostColumnTableAlias(s string) string {
@@ -311,6 +313,7 @@ func loadHostUsersDB(ctx context.Context, db sqlx.QueryerContext, hostID uint) (
 // Defined here for testing purposes.
 var hostRefs = []string{
 	"host_seen_times",
+	"host_mdm_checkin_times",
 	"host_software",
 	"host_users",
 	"host_emails",
@@ -331,6 +334,16 @@ var hostRefs = []string{
 	"operating_system_vulnerabilities",
 	"host_updates",
 	"host_disk_encryption_keys",
+	"host_disk_encryption_key_rotations",
+	"host_os_updates",
+	"host_mdm_apple_dep_devices",
+	"host_orbit_mdm_status",
+	"host_quarantines",
+	"mdm_apple_configuration_profile_exclusions",
+	"host_custom_attributes",
+	"mdm_apple_configuration_profiles",
+	"host_assigned_users",
+	"host_mdm_apple_dep_profile_overrides",
 }
 
 // those host refs cannot be deleted using the host.id like the hostRefs above,
@@ -338,7 +351,12 @@ var hostRefs = []string{
 // the host.uuid is not always named the same, so the map key is the table name
 // and the map value is the column name to match to the host.uuid.
 var additionalHostRefsByUUID = map[string]string{
-	"host_mdm_apple_profiles": "host_uuid",
+	"host_mdm_apple_profiles":               "host_uuid",
+	"host_mdm_activation_lock_bypass_codes": "host_uuid",
+	"host_mdm_apple_certificates":           "host_uuid",
+	"host_mdm_apple_certificate_refreshes":  "host_uuid",
+	"host_mdm_apple_profile_list_refreshes": "host_uuid",
+	"host_mdm_apple_command_history":        "host_uuid",
 }
 
 func (ds *Datastore) DeleteHost(ctx context.Context, hid uint) error {
@@ -432,6 +450,7 @@ SELECT
   COALESCE(hd.percent_disk_space_available, 0) as percent_disk_space_available,
   hd.encrypted as disk_encryption_enabled,
   COALESCE(hst.seen_time, h.created_at) AS seen_time,
+  hmct.checkin_time AS last_mdm_checkin_at,
   t.name AS team_name,
   COALESCE(hu.software_updated_at, h.created_at) AS software_updated_at,
   (
@@ -449,6 +468,7 @@ FROM
   hosts h
   LEFT JOIN teams t ON (h.team_id = t.id)
   LEFT JOIN host_seen_times hst ON (h.id = hst.host_id)
+  LEFT JOIN host_mdm_checkin_times hmct ON (h.id = hmct.host_id)
   LEFT JOIN host_updates hu ON (h.id = hu.host_id)
   LEFT JOIN host_disks hd ON hd.host_id = h.id
   ` + hostMDMJoin + `
@@ -627,6 +647,7 @@ func (ds *Datastore) ListHosts(ctx context.Context, filter fleet.TeamFilter, opt
     COALESCE(hd.gigs_disk_space_available, 0) as gigs_disk_space_available,
     COALESCE(hd.percent_disk_space_available, 0) as percent_disk_space_available,
     COALESCE(hst.seen_time, h.created_at) AS seen_time,
+    hmct.checkin_time AS last_mdm_checkin_at,
     t.name AS team_name,
     COALESCE(hu.software_updated_at, h.created_at) AS software_updated_at
 	`
@@ -742,6 +763,7 @@ func (ds *Datastore) applyHostFilters(opt fleet.HostListOptions, sql string, fil
 
 	sql += fmt.Sprintf(`FROM hosts h
     LEFT JOIN host_seen_times hst ON (h.id = hst.host_id)
+    LEFT JOIN host_mdm_checkin_times hmct ON (h.id = hmct.host_id)
     LEFT JOIN host_updates hu ON (h.id = hu.host_id)
     LEFT JOIN teams t ON (h.team_id = t.id)
     LEFT JOIN host_disks hd ON hd.host_id = h.id
@@ -779,6 +801,11 @@ func (ds *Datastore) applyHostFilters(opt fleet.HostListOptions, sql string, fil
 	sql, params = filterHostsByMacOSSettingsStatus(sql, opt, params)
 	sql, params = filterHostsByMacOSDiskEncryptionStatus(sql, opt, params)
 	sql, params = filterHostsByMDMBootstrapPackageStatus(sql, opt, params)
+	sql, params = filterHostsByOSUpdateStatus(now, sql, opt, params)
+	sql, params = filterHostsByCertificateExpiry(now, sql, opt, params)
+	sql, params = filterHostsByMDMCheckin(now, sql, opt, params)
+	sql, params = filterHostsByDiskEncryptionKeyEscrow(sql, opt, params)
+	sql, params = filterHostsByCustomAttribute(sql, opt, params)
 	sql, params = filterHostsByOS(sql, opt, params)
 	sql, params = hostSearchLike(sql, params, opt.MatchQuery, hostSearchColumns...)
 	sql, params = appendListOptionsWithCursorToSQL(sql, params, &opt.ListOptions)
@@ -969,6 +996,79 @@ func filterHostsByMDMBootstrapPackageStatus(sql string, opt fleet.HostListOption
 	return sql + newSQL, params
 }
 
+func filterHostsByOSUpdateStatus(now time.Time, sql string, opt fleet.HostListOptions, params []interface{}) (string, []interface{}) {
+	if opt.OSUpdateStatusFilter == nil || !opt.OSUpdateStatusFilter.IsValid() {
+		return sql, params
+	}
+
+	sql += fmt.Sprintf(` AND h.id IN (
+        SELECT hos_status.host_id FROM (%s) hos_status WHERE hos_status.status = ?
+    )
+    `, hostOSUpdateStatusSelect)
+	return sql, append(params, now, *opt.OSUpdateStatusFilter)
+}
+
+func filterHostsByCertificateExpiry(now time.Time, sql string, opt fleet.HostListOptions, params []interface{}) (string, []interface{}) {
+	if opt.CertificateExpiringWithinDaysFilter == nil {
+		return sql, params
+	}
+
+	sql += ` AND EXISTS (
+        SELECT 1 FROM host_mdm_apple_certificates hmac WHERE hmac.host_uuid = h.uuid AND hmac.not_valid_after < ?
+    )
+    `
+	return sql, append(params, now.AddDate(0, 0, *opt.CertificateExpiringWithinDaysFilter))
+}
+
+func filterHostsByMDMCheckin(now time.Time, sql string, opt fleet.HostListOptions, params []interface{}) (string, []interface{}) {
+	if opt.LastMDMCheckinWithinDaysFilter == nil {
+		return sql, params
+	}
+
+	sql += ` AND hmct.checkin_time >= ?`
+	return sql, append(params, now.AddDate(0, 0, -*opt.LastMDMCheckinWithinDaysFilter))
+}
+
+func filterHostsByDiskEncryptionKeyEscrow(sql string, opt fleet.HostListOptions, params []interface{}) (string, []interface{}) {
+	if opt.DiskEncryptionKeyEscrowedFilter == nil && opt.KeyEscrowedBeforeFilter == nil {
+		return sql, params
+	}
+
+	// an empty key means that the host reported that its disk is encrypted but
+	// the key must be reset, so it is not escrowed.
+	escrowed := `
+        SELECT 1 FROM host_disk_encryption_keys hdek WHERE hdek.host_id = h.id AND hdek.base64_encrypted != ''`
+	if opt.DiskEncryptionKeyEscrowedFilter != nil && !*opt.DiskEncryptionKeyEscrowedFilter {
+		return sql + ` AND NOT EXISTS (` + escrowed + `
+    )
+    `, params
+	}
+	if opt.KeyEscrowedBeforeFilter != nil {
+		escrowed += ` AND hdek.updated_at < ?`
+		params = append(params, *opt.KeyEscrowedBeforeFilter)
+	}
+	return sql + ` AND EXISTS (` + escrowed + `
+    )
+    `, params
+}
+
+func filterHostsByCustomAttribute(sql string, opt fleet.HostListOptions, params []interface{}) (string, []interface{}) {
+	if opt.CustomAttributeNameFilter == nil {
+		return sql, params
+	}
+
+	sql += ` AND EXISTS (
+        SELECT 1 FROM host_custom_attributes hca WHERE hca.host_id = h.id AND hca.name = ?`
+	params = append(params, *opt.CustomAttributeNameFilter)
+	if opt.CustomAttributeValueFilter != nil {
+		sql += ` AND hca.value = ?`
+		params = append(params, *opt.CustomAttributeValueFilter)
+	}
+	return sql + `
+    )
+    `, params
+}
+
 func (ds *Datastore) CountHosts(ctx context.Context, filter fleet.TeamFilter, opt fleet.HostListOptions) (int, error) {
 	sql := `SELECT count(*) `
 
@@ -1108,6 +1208,45 @@ func (ds *Datastore) GenerateHostStatusStatistics(ctx context.Context, filter fl
 	return &summary, nil
 }
 
+func (ds *Datastore) GenerateHostMDMStatistics(ctx context.Context, filter fleet.TeamFilter, platform *string) (*fleet.HostSummaryMDM, error) {
+	subqueryFailed, args := subqueryHostsMacOSSettingsStatusFailing()
+
+	whereClause := ds.whereFilterHostsByTeams(filter, "h")
+	if platform != nil {
+		whereClause += " AND h.platform IN (?) "
+		args = append(args, fleet.ExpandPlatform(*platform))
+	}
+
+	// hosts that are MDM servers are excluded from the enrollment counts, same
+	// as when filtering hosts by MDM enrollment status.
+	sqlStatement := fmt.Sprintf(`
+			SELECT
+				COALESCE(SUM(CASE WHEN hmdm.enrolled = 1 THEN 1 ELSE 0 END), 0) enrolled,
+				COALESCE(SUM(CASE WHEN hmdm.enrolled = 1 AND hmdm.installed_from_dep = 0 THEN 1 ELSE 0 END), 0) enrolled_manual,
+				COALESCE(SUM(CASE WHEN hmdm.enrolled = 1 AND hmdm.installed_from_dep = 1 THEN 1 ELSE 0 END), 0) enrolled_automated,
+				COALESCE(SUM(CASE WHEN hmdm.enrolled = 0 AND hmdm.installed_from_dep = 1 THEN 1 ELSE 0 END), 0) pending,
+				COALESCE(SUM(CASE WHEN hmdm.enrolled = 0 AND hmdm.installed_from_dep = 0 THEN 1 ELSE 0 END), 0) unenrolled,
+				COUNT(CASE WHEN EXISTS (%s) THEN 1 END) profiles_failed,
+				COALESCE(SUM(CASE WHEN h.platform = 'darwin' AND hd.encrypted = 1 THEN 1 ELSE 0 END), 0) disk_encryption_enabled,
+				COALESCE(SUM(CASE WHEN hdek.decryptable = 1 THEN 1 ELSE 0 END), 0) disk_encryption_key_escrowed
+			FROM hosts h
+			LEFT JOIN host_mdm hmdm ON (h.id = hmdm.host_id AND NOT COALESCE(hmdm.is_server, false))
+			LEFT JOIN host_disks hd ON (h.id = hd.host_id)
+			LEFT JOIN host_disk_encryption_keys hdek ON (h.id = hdek.host_id)
+			WHERE %s
+		`, subqueryFailed, whereClause)
+
+	stmt, args, err := sqlx.In(sqlStatement, args...)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "generating host mdm statistics statement")
+	}
+	var summary fleet.HostSummaryMDM
+	if err := sqlx.GetContext(ctx, ds.reader, &summary, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "generating host mdm statistics")
+	}
+	return &summary, nil
+}
+
 // Attempts to find the matching host ID by osqueryID, host UUID or serial
 // number. Any of those fields can be left empty if not available, and it will
 // use the best match in this order:
@@ -1695,6 +1834,34 @@ func (ds *Datastore) SetOrUpdateDeviceAuthToken(ctx context.Context, hostID uint
 	return nil
 }
 
+func (ds *Datastore) NewHostQuarantine(ctx context.Context, q *fleet.HostQuarantine) (*fleet.HostQuarantine, error) {
+	const stmt = `
+		INSERT INTO
+			host_quarantines (host_id, case_id, team_id, previous_team_id, locked)
+		VALUES
+			(?, ?, ?, ?, ?)
+`
+	res, err := ds.writer.ExecContext(ctx, stmt, q.HostID, q.CaseID, q.TeamID, q.PreviousTeamID, q.Locked)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "insert host quarantine")
+	}
+	id, _ := res.LastInsertId()
+
+	var created fleet.HostQuarantine
+	if err := sqlx.GetContext(ctx, ds.writer, &created,
+		`SELECT id, host_id, case_id, team_id, previous_team_id, locked, created_at FROM host_quarantines WHERE id = ?`, id); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get created host quarantine")
+	}
+	return &created, nil
+}
+
+func (ds *Datastore) DeleteHostQuarantine(ctx context.Context, id uint) error {
+	if _, err := ds.writer.ExecContext(ctx, `DELETE FROM host_quarantines WHERE id = ?`, id); err != nil {
+		return ctxerr.Wrap(ctx, err, "delete host quarantine")
+	}
+	return nil
+}
+
 func (ds *Datastore) MarkHostsSeen(ctx context.Context, hostIDs []uint, t time.Time) error {
 	if len(hostIDs) == 0 {
 		return nil
@@ -1927,10 +2094,12 @@ func (ds *Datastore) HostByIdentifier(ctx context.Context, identifier string) (*
       COALESCE(hd.gigs_disk_space_available, 0) as gigs_disk_space_available,
       COALESCE(hd.percent_disk_space_available, 0) as percent_disk_space_available,
       COALESCE(hst.seen_time, h.created_at) AS seen_time,
+      hmct.checkin_time AS last_mdm_checkin_at,
 	  COALESCE(hu.software_updated_at, h.created_at) AS software_updated_at
 	  ` + hostMDMSelect + `
     FROM hosts h
     LEFT JOIN host_seen_times hst ON (h.id = hst.host_id)
+    LEFT JOIN host_mdm_checkin_times hmct ON (h.id = hmct.host_id)
 	LEFT JOIN host_updates hu ON (h.id = hu.host_id)
     LEFT JOIN host_disks hd ON hd.host_id = h.id
 	` + hostMDMJoin + `
@@ -2159,10 +2328,7 @@ func (ds *Datastore) CleanupExpiredHosts(ctx context.Context) ([]uint, error) {
 	err = ds.writer.SelectContext(
 		ctx,
 		&ids,
-		`SELECT h.id FROM hosts h
-		LEFT JOIN host_seen_times hst
-		ON h.id = hst.host_id
-		WHERE COALESCE(hst.seen_time, h.created_at) < DATE_SUB(NOW(), INTERVAL ? DAY)`,
+		expiredHostsQuery(ac.HostExpirySettings.HostExpiryMDMEnrolled),
 		ac.HostExpirySettings.HostExpiryWindow,
 	)
 	if err != nil {
@@ -2176,13 +2342,50 @@ func (ds *Datastore) CleanupExpiredHosts(ctx context.Context) ([]uint, error) {
 		}
 	}
 
-	_, err = ds.writer.ExecContext(ctx, `DELETE FROM host_seen_times WHERE seen_time < DATE_SUB(NOW(), INTERVAL ? DAY)`, ac.HostExpirySettings.HostExpiryWindow)
+	// only the seen times of hosts that don't exist anymore are deleted, as
+	// MDM-enrolled hosts may have been exempted from the expiration.
+	_, err = ds.writer.ExecContext(ctx, `
+		DELETE hst FROM host_seen_times hst
+		LEFT JOIN hosts h ON h.id = hst.host_id
+		WHERE h.id IS NULL AND hst.seen_time < DATE_SUB(NOW(), INTERVAL ? DAY)`, ac.HostExpirySettings.HostExpiryWindow)
 	if err != nil {
 		return nil, ctxerr.Wrap(ctx, err, "deleting expired host seen times")
 	}
 	return ids, nil
 }
 
+// expiredHostsQuery returns the query that selects the ids of the expired
+// hosts, taking into account how the MDM-enrolled hosts must be expired. The
+// query takes the host expiry window in days as argument.
+func expiredHostsQuery(mdmEnrolled fleet.HostExpiryMDMEnrolled) string {
+	switch mdmEnrolled {
+	case fleet.HostExpiryMDMEnrolledExempt:
+		return `SELECT h.id FROM hosts h
+		LEFT JOIN host_seen_times hst
+		ON h.id = hst.host_id
+		LEFT JOIN host_mdm hm
+		ON h.id = hm.host_id
+		WHERE COALESCE(hst.seen_time, h.created_at) < DATE_SUB(NOW(), INTERVAL ? DAY)
+		AND COALESCE(hm.enrolled, 0) = 0`
+
+	case fleet.HostExpiryMDMEnrolledCheckIn:
+		// the last check-in of the device enrollment is used as liveness signal
+		// if it is more recent than the last time the host was seen.
+		return `SELECT h.id FROM hosts h
+		LEFT JOIN host_seen_times hst
+		ON h.id = hst.host_id
+		LEFT JOIN nano_enrollments ne
+		ON ne.device_id = h.uuid AND ne.type = 'Device' AND ne.enabled = 1
+		WHERE GREATEST(COALESCE(hst.seen_time, h.created_at), COALESCE(ne.last_seen_at, h.created_at)) < DATE_SUB(NOW(), INTERVAL ? DAY)`
+
+	default:
+		return `SELECT h.id FROM hosts h
+		LEFT JOIN host_seen_times hst
+		ON h.id = hst.host_id
+		WHERE COALESCE(hst.seen_time, h.created_at) < DATE_SUB(NOW(), INTERVAL ? DAY)`
+	}
+}
+
 func (ds *Datastore) ListHostDeviceMapping(ctx context.Context, id uint) ([]*fleet.HostDeviceMapping, error) {
 	stmt := `
     SELECT
@@ -2682,7 +2885,38 @@ func (ds *Datastore) SetOrUpdateHostDisksEncryption(ctx context.Context, hostID
 }
 
 func (ds *Datastore) SetOrUpdateHostDiskEncryptionKey(ctx context.Context, hostID uint, encryptedBase64Key string) error {
-	_, err := ds.writer.ExecContext(ctx, `
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		// if the host was notified to rotate its key and escrows a different
+		// one, the rotation is completed.
+		if _, err := tx.ExecContext(ctx, `
+          UPDATE host_disk_encryption_key_rotations hdekr
+          JOIN host_disk_encryption_keys hdek ON hdek.host_id = hdekr.host_id
+          SET hdekr.completed_at = CURRENT_TIMESTAMP
+          WHERE
+            hdekr.host_id = ? AND
+            hdekr.notified_at IS NOT NULL AND
+            hdekr.completed_at IS NULL AND
+            hdek.base64_encrypted != ?`, hostID, encryptedBase64Key); err != nil {
+			return ctxerr.Wrap(ctx, err, "complete disk encryption key rotation")
+		}
+
+		// a new or different key is escrowed.
+		var hostUUID string
+		if err := sqlx.GetContext(ctx, tx, &hostUUID, `
+          SELECT h.uuid
+          FROM hosts h
+          LEFT JOIN host_disk_encryption_keys hdek ON hdek.host_id = h.id
+          WHERE h.id = ? AND (hdek.base64_encrypted IS NULL OR hdek.base64_encrypted != ?)`,
+			hostID, encryptedBase64Key); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return ctxerr.Wrap(ctx, err, "check escrowed disk encryption key")
+		}
+		if hostUUID != "" {
+			if err := recordHostMDMChangesDB(ctx, tx, []hostMDMChange{{HostUUID: hostUUID, Type: fleet.HostMDMChangeKeyEscrowed}}); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, `
            INSERT INTO host_disk_encryption_keys (host_id, base64_encrypted)
 	   VALUES (?, ?)
 	   ON DUPLICATE KEY UPDATE
@@ -2690,7 +2924,8 @@ func (ds *Datastore) SetOrUpdateHostDiskEncryptionKey(ctx context.Context, hostI
              decryptable = IF(base64_encrypted = VALUES(base64_encrypted), decryptable, NULL),
    	     base64_encrypted = VALUES(base64_encrypted)
       `, hostID, encryptedBase64Key)
-	return err
+		return err
+	})
 }
 
 func (ds *Datastore) GetUnverifiedDiskEncryptionKeys(ctx context.Context) ([]fleet.HostDiskEncryptionKey, error) {
@@ -2747,6 +2982,51 @@ func (ds *Datastore) GetHostDiskEncryptionKey(ctx context.Context, hostID uint)
 	return &key, nil
 }
 
+func (ds *Datastore) NewHostDiskEncryptionKeyAccess(ctx context.Context, access *fleet.HostDiskEncryptionKeyAccess) error {
+	const stmt = `
+          INSERT INTO host_disk_encryption_key_accesses
+            (host_id, user_id, user_name, user_email, ip_address, justification)
+          VALUES
+            (?, ?, ?, ?, ?, ?)`
+
+	res, err := ds.writer.ExecContext(ctx, stmt, access.HostID, access.UserID, access.UserName,
+		access.UserEmail, access.IPAddress, access.Justification)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "insert host disk encryption key access")
+	}
+	id, _ := res.LastInsertId()
+	access.ID = uint(id)
+	return nil
+}
+
+func (ds *Datastore) ListHostDiskEncryptionKeyAccesses(ctx context.Context, hostID uint, opt fleet.ListOptions) ([]*fleet.HostDiskEncryptionKeyAccess, *fleet.PaginationMetadata, error) {
+	query := `
+          SELECT
+            id, host_id, user_id, user_name, user_email, ip_address, justification, created_at
+          FROM
+            host_disk_encryption_key_accesses
+          WHERE host_id = ?`
+
+	if opt.OrderKey == "" {
+		opt.OrderKey = "created_at"
+		opt.OrderDirection = fleet.OrderDescending
+	}
+	opt.IncludeMetadata = true
+	query, args := appendListOptionsWithCursorToSQL(query, []interface{}{hostID}, &opt)
+
+	accesses := []*fleet.HostDiskEncryptionKeyAccess{}
+	if err := sqlx.SelectContext(ctx, ds.reader, &accesses, query, args...); err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list host disk encryption key accesses")
+	}
+
+	metaData := &fleet.PaginationMetadata{HasPreviousResults: opt.Page > 0}
+	if len(accesses) > int(opt.PerPage) {
+		metaData.HasNextResults = true
+		accesses = accesses[:len(accesses)-1]
+	}
+	return accesses, metaData, nil
+}
+
 func (ds *Datastore) SetOrUpdateHostOrbitInfo(ctx context.Context, hostID uint, version string) error {
 	return ds.updateOrInsert(
 		ctx,
@@ -2756,6 +3036,15 @@ func (ds *Datastore) SetOrUpdateHostOrbitInfo(ctx context.Context, hostID uint,
 	)
 }
 
+func (ds *Datastore) SetOrUpdateHostOSUpdates(ctx context.Context, hostID uint, osVersion string, pendingUpdates uint) error {
+	return ds.updateOrInsert(
+		ctx,
+		`UPDATE host_os_updates SET os_version = ?, pending_updates = ? WHERE host_id = ?`,
+		`INSERT INTO host_os_updates (os_version, pending_updates, host_id) VALUES (?, ?, ?)`,
+		osVersion, pendingUpdates, hostID,
+	)
+}
+
 func (ds *Datastore) getOrInsertMDMSolution(ctx context.Context, serverURL string, mdmName string) (mdmID uint, err error) {
 	readStmt := &parameterizedStmt{
 		Statement: `SELECT id FROM mobile_device_management_solutions WHERE name = ? AND server_url = ?`,
@@ -2801,6 +3090,17 @@ func (ds *Datastore) GetHostMDM(ctx context.Context, hostID uint) (*fleet.HostMD
 	return &hmdm, nil
 }
 
+func (ds *Datastore) MarkHostMDMCheckedIn(ctx context.Context, hostUUID string, t time.Time) error {
+	const stmt = `
+		INSERT INTO host_mdm_checkin_times (host_id, checkin_time)
+		SELECT id, ? FROM hosts WHERE uuid = ?
+		ON DUPLICATE KEY UPDATE checkin_time = VALUES(checkin_time)`
+	if _, err := ds.writer.ExecContext(ctx, stmt, t, hostUUID); err != nil {
+		return ctxerr.Wrap(ctx, err, "mark host MDM checked in")
+	}
+	return nil
+}
+
 func (ds *Datastore) GetHostMDMCheckinInfo(ctx context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
 	var hmdm fleet.HostMDMCheckinInfo
 	err := sqlx.GetContext(ctx, ds.reader, &hmdm, `
@@ -3714,6 +4014,29 @@ func (ds *Datastore) HostIDsByOSID(
 	return ids, nil
 }
 
+// HostIDsByTeam retrieves the IDs of up to limit hosts of the team. It reads
+// from the primary, as it is used to move the hosts of a team in batches.
+func (ds *Datastore) HostIDsByTeam(ctx context.Context, teamID uint, limit int) ([]uint, error) {
+	var ids []uint
+
+	stmt := dialect.From("hosts").
+		Select("id").
+		Where(goqu.C("team_id").Eq(teamID)).
+		Order(goqu.I("id").Asc()).
+		Limit(uint(limit))
+
+	sql, args, err := stmt.ToSQL()
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get team host IDs")
+	}
+
+	if err := sqlx.SelectContext(ctx, ds.writer, &ids, sql, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get team host IDs")
+	}
+
+	return ids, nil
+}
+
 // TODO Refactor this: We should be using the operating system type for this
 func (ds *Datastore) HostIDsByOSVersion(
 	ctx context.Context,
@@ -3770,6 +4093,287 @@ func (ds *Datastore) ListHostBatteries(ctx context.Context, hid uint) ([]*fleet.
 	return batteries, nil
 }
 
+func (ds *Datastore) ListHostCustomAttributes(ctx context.Context, hostID uint) ([]*fleet.HostCustomAttribute, error) {
+	const stmt = `
+    SELECT
+      name,
+      value,
+      source,
+      updated_at
+    FROM
+      host_custom_attributes
+    WHERE
+      host_id = ?
+    ORDER BY
+      name
+`
+
+	var attrs []*fleet.HostCustomAttribute
+	if err := sqlx.SelectContext(ctx, ds.reader, &attrs, stmt, hostID); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "select host custom attributes")
+	}
+	return attrs, nil
+}
+
+func (ds *Datastore) ListHostCustomAttributesByHostUUIDs(ctx context.Context, hostUUIDs []string) (map[string]map[string]string, error) {
+	if len(hostUUIDs) == 0 {
+		return nil, nil
+	}
+
+	const stmt = `
+    SELECT
+      h.uuid as host_uuid,
+      hca.name,
+      hca.value
+    FROM
+      host_custom_attributes hca
+      JOIN hosts h ON h.id = hca.host_id
+    WHERE
+      h.uuid IN (?)
+`
+
+	query, args, err := sqlx.In(stmt, hostUUIDs)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "building query to select host custom attributes by uuids")
+	}
+
+	var rows []struct {
+		HostUUID string `db:"host_uuid"`
+		Name     string `db:"name"`
+		Value    string `db:"value"`
+	}
+	if err := sqlx.SelectContext(ctx, ds.reader, &rows, query, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "select host custom attributes by uuids")
+	}
+
+	attrsByHost := make(map[string]map[string]string)
+	for _, r := range rows {
+		if attrsByHost[r.HostUUID] == nil {
+			attrsByHost[r.HostUUID] = make(map[string]string)
+		}
+		attrsByHost[r.HostUUID][r.Name] = r.Value
+	}
+	return attrsByHost, nil
+}
+
+func (ds *Datastore) SetHostCustomAttributes(ctx context.Context, hostID uint, attrs map[string]*string, source string) error {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	const (
+		selectStmt = `SELECT name, value FROM host_custom_attributes WHERE host_id = ? FOR UPDATE`
+		upsertStmt = `
+    INSERT INTO
+      host_custom_attributes (host_id, name, value, source)
+    VALUES
+      (?, ?, ?, ?)
+    ON DUPLICATE KEY UPDATE
+      value = VALUES(value),
+      source = VALUES(source)
+`
+		deleteStmt = `DELETE FROM host_custom_attributes WHERE host_id = ? AND name = ?`
+
+		// the profiles that reference the custom attributes are installed with
+		// the values of the host, they need to be installed again when a value
+		// changes.
+		reinstallStmt = `
+    UPDATE
+      host_mdm_apple_profiles hmap
+      JOIN hosts h ON h.uuid = hmap.host_uuid
+      JOIN mdm_apple_configuration_profiles macp ON macp.profile_id = hmap.profile_id
+    SET
+      hmap.status = NULL,
+      hmap.detail = ''
+    WHERE
+      h.id = ? AND
+      hmap.operation_type = ? AND
+      macp.mobileconfig LIKE ?
+`
+	)
+
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		var current []struct {
+			Name  string `db:"name"`
+			Value string `db:"value"`
+		}
+		if err := sqlx.SelectContext(ctx, tx, &current, selectStmt, hostID); err != nil {
+			return ctxerr.Wrap(ctx, err, "select current host custom attributes")
+		}
+		currentValues := make(map[string]string, len(current))
+		for _, c := range current {
+			currentValues[c.Name] = c.Value
+		}
+
+		var changed bool
+		for name, value := range attrs {
+			curValue, exists := currentValues[name]
+			if value == nil {
+				if !exists {
+					continue
+				}
+				if _, err := tx.ExecContext(ctx, deleteStmt, hostID, name); err != nil {
+					return ctxerr.Wrap(ctx, err, "delete host custom attribute")
+				}
+				changed = true
+				continue
+			}
+
+			if _, err := tx.ExecContext(ctx, upsertStmt, hostID, name, *value, source); err != nil {
+				return ctxerr.Wrap(ctx, err, "upsert host custom attribute")
+			}
+			if !exists || curValue != *value {
+				changed = true
+			}
+		}
+
+		if changed {
+			if _, err := tx.ExecContext(ctx, reinstallStmt, hostID, fleet.MDMAppleOperationTypeInstall,
+				"%FLEET_VAR_"+apple_mdm.HostCustomAttributeVarPrefix+"%"); err != nil {
+				return ctxerr.Wrap(ctx, err, "set profiles referencing custom attributes to reinstall")
+			}
+		}
+		return nil
+	})
+}
+
+func (ds *Datastore) GetHostAssignedUser(ctx context.Context, hostID uint) (*fleet.HostAssignedUser, error) {
+	const stmt = `
+    SELECT
+      host_id,
+      email,
+      full_name,
+      source,
+      updated_at
+    FROM
+      host_assigned_users
+    WHERE
+      host_id = ?
+`
+
+	var user fleet.HostAssignedUser
+	if err := sqlx.GetContext(ctx, ds.reader, &user, stmt, hostID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("HostAssignedUser").WithID(hostID))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "select host assigned user")
+	}
+	return &user, nil
+}
+
+func (ds *Datastore) ListHostAssignedUsersByHostUUIDs(ctx context.Context, hostUUIDs []string) (map[string]*fleet.HostAssignedUser, error) {
+	if len(hostUUIDs) == 0 {
+		return nil, nil
+	}
+
+	const stmt = `
+    SELECT
+      h.uuid as host_uuid,
+      hau.host_id,
+      hau.email,
+      hau.full_name,
+      hau.source,
+      hau.updated_at
+    FROM
+      host_assigned_users hau
+      JOIN hosts h ON h.id = hau.host_id
+    WHERE
+      h.uuid IN (?)
+`
+
+	query, args, err := sqlx.In(stmt, hostUUIDs)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "building query to select host assigned users by uuids")
+	}
+
+	var rows []struct {
+		HostUUID string `db:"host_uuid"`
+		fleet.HostAssignedUser
+	}
+	if err := sqlx.SelectContext(ctx, ds.reader, &rows, query, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "select host assigned users by uuids")
+	}
+
+	usersByHost := make(map[string]*fleet.HostAssignedUser, len(rows))
+	for _, r := range rows {
+		user := r.HostAssignedUser
+		usersByHost[r.HostUUID] = &user
+	}
+	return usersByHost, nil
+}
+
+func (ds *Datastore) SetHostAssignedUser(ctx context.Context, hostID uint, user *fleet.HostAssignedUser) error {
+	const (
+		selectStmt = `SELECT email, full_name FROM host_assigned_users WHERE host_id = ? FOR UPDATE`
+		upsertStmt = `
+    INSERT INTO
+      host_assigned_users (host_id, email, full_name, source)
+    VALUES
+      (?, ?, ?, ?)
+    ON DUPLICATE KEY UPDATE
+      email = VALUES(email),
+      full_name = VALUES(full_name),
+      source = VALUES(source)
+`
+		deleteStmt = `DELETE FROM host_assigned_users WHERE host_id = ?`
+
+		// the profiles that reference the assigned user are installed with the
+		// values of the host, they need to be installed again when the user
+		// changes.
+		reinstallStmt = `
+    UPDATE
+      host_mdm_apple_profiles hmap
+      JOIN hosts h ON h.uuid = hmap.host_uuid
+      JOIN mdm_apple_configuration_profiles macp ON macp.profile_id = hmap.profile_id
+    SET
+      hmap.status = NULL,
+      hmap.detail = ''
+    WHERE
+      h.id = ? AND
+      hmap.operation_type = ? AND
+      macp.mobileconfig LIKE ?
+`
+	)
+
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		var current struct {
+			Email    string `db:"email"`
+			FullName string `db:"full_name"`
+		}
+		exists := true
+		if err := sqlx.GetContext(ctx, tx, &current, selectStmt, hostID); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				return ctxerr.Wrap(ctx, err, "select current host assigned user")
+			}
+			exists = false
+		}
+
+		var changed bool
+		if user == nil {
+			if !exists {
+				return nil
+			}
+			if _, err := tx.ExecContext(ctx, deleteStmt, hostID); err != nil {
+				return ctxerr.Wrap(ctx, err, "delete host assigned user")
+			}
+			changed = true
+		} else {
+			if _, err := tx.ExecContext(ctx, upsertStmt, hostID, user.Email, user.FullName, user.Source); err != nil {
+				return ctxerr.Wrap(ctx, err, "upsert host assigned user")
+			}
+			changed = !exists || current.Email != user.Email || current.FullName != user.FullName
+		}
+
+		if changed {
+			if _, err := tx.ExecContext(ctx, reinstallStmt, hostID, fleet.MDMAppleOperationTypeInstall,
+				"%FLEET_VAR_HOST_ASSIGNED_USER_%"); err != nil {
+				return ctxerr.Wrap(ctx, err, "set profiles referencing assigned user to reinstall")
+			}
+		}
+		return nil
+	})
+}
+
 func (ds *Datastore) SetDiskEncryptionResetStatus(ctx context.Context, hostID uint, status bool) error {
 	const stmt = `
           INSERT INTO host_disk_encryption_keys (host_id, reset_requested, base64_encrypted)
@@ -3784,6 +4388,108 @@ func (ds *Datastore) SetDiskEncryptionResetStatus(ctx context.Context, hostID ui
 	return nil
 }
 
+func (ds *Datastore) RequestMDMAppleFileVaultKeyRotation(ctx context.Context, teamID *uint) (int, error) {
+	teamFilter := "h.team_id IS NULL"
+	var args []interface{}
+	if teamID != nil && *teamID > 0 {
+		teamFilter = "h.team_id = ?"
+		args = append(args, *teamID)
+	}
+
+	// only the hosts with a verified escrowed key can rotate it. The hosts
+	// already flagged are notified again.
+	selectStmt := fmt.Sprintf(`
+          SELECT h.id
+          FROM hosts h
+          JOIN host_disk_encryption_keys hdek ON hdek.host_id = h.id
+          WHERE h.platform = 'darwin' AND hdek.decryptable = 1 AND %s`, teamFilter)
+
+	return ds.requestMDMAppleFileVaultKeyRotation(ctx, selectStmt, args...)
+}
+
+func (ds *Datastore) RequestMDMAppleFileVaultKeyRotationForTarget(ctx context.Context, targetID uint) (int, error) {
+	selectStmt := `
+          SELECT h.id
+          FROM hosts h
+          JOIN host_disk_encryption_keys hdek ON hdek.host_id = h.id
+          JOIN nano_enrollments ne ON ne.id = h.uuid AND ne.type = 'Device' AND ne.enabled = 1
+          JOIN mdm_host_targets mht ON mht.id = ?
+          WHERE h.platform = 'darwin' AND hdek.decryptable = 1 AND ` + mdmHostTargetMatchCond
+
+	return ds.requestMDMAppleFileVaultKeyRotation(ctx, selectStmt, targetID)
+}
+
+// requestMDMAppleFileVaultKeyRotation flags the hosts selected by selectStmt
+// for the rotation of their disk encryption key, and returns the number of
+// hosts flagged.
+func (ds *Datastore) requestMDMAppleFileVaultKeyRotation(ctx context.Context, selectStmt string, args ...interface{}) (int, error) {
+	var count int
+	err := ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		var hostIDs []uint
+		if err := sqlx.SelectContext(ctx, tx, &hostIDs, selectStmt, args...); err != nil {
+			return ctxerr.Wrap(ctx, err, "select hosts to rotate disk encryption key")
+		}
+		count = len(hostIDs)
+		if len(hostIDs) == 0 {
+			return nil
+		}
+
+		stmt, args, err := sqlx.In(`
+          INSERT INTO host_disk_encryption_key_rotations (host_id)
+          SELECT id FROM hosts WHERE id IN (?)
+          ON DUPLICATE KEY UPDATE
+            requested_at = CURRENT_TIMESTAMP,
+            notified_at = NULL,
+            completed_at = NULL`, hostIDs)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "prepare disk encryption key rotations insert")
+		}
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return ctxerr.Wrap(ctx, err, "insert disk encryption key rotations")
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (ds *Datastore) NotifyMDMAppleFileVaultKeyRotations(ctx context.Context, limit int) (int, error) {
+	var count int
+	err := ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		var hostIDs []uint
+		if err := sqlx.SelectContext(ctx, tx, &hostIDs, `
+          SELECT host_id
+          FROM host_disk_encryption_key_rotations
+          WHERE notified_at IS NULL
+          ORDER BY requested_at, host_id
+          LIMIT ?
+          FOR UPDATE`, limit); err != nil {
+			return ctxerr.Wrap(ctx, err, "select disk encryption key rotations to notify")
+		}
+		count = len(hostIDs)
+		if len(hostIDs) == 0 {
+			return nil
+		}
+
+		stmt, args, err := sqlx.In(`UPDATE host_disk_encryption_keys SET reset_requested = 1 WHERE host_id IN (?)`, hostIDs)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "prepare disk encryption reset query")
+		}
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return ctxerr.Wrap(ctx, err, "request disk encryption reset")
+		}
+
+		stmt, args, err = sqlx.In(`UPDATE host_disk_encryption_key_rotations SET notified_at = CURRENT_TIMESTAMP WHERE host_id IN (?)`, hostIDs)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "prepare disk encryption key rotations notified query")
+		}
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return ctxerr.Wrap(ctx, err, "mark disk encryption key rotations notified")
+		}
+		return nil
+	})
+	return count, err
+}
+
 // countHostNotResponding counts the hosts that haven't been submitting results for sent queries.
 //
 // Notes:
@@ -89,6 +89,7 @@ func TestHosts(t *testing.T) {
 		{"Search", testHostsSearch},
 		{"SearchLimit", testHostsSearchLimit},
 		{"GenerateStatusStatistics", testHostsGenerateStatusStatistics},
+		{"GenerateMDMStatistics", testHostsGenerateMDMStatistics},
 		{"MarkSeen", testHostsMarkSeen},
 		{"MarkSeenMany", testHostsMarkSeenMany},
 		{"CleanupIncoming", testHostsCleanupIncoming},
@@ -112,6 +113,7 @@ func TestHosts(t *testing.T) {
 		{"HostsListByDiskEncryptionStatus", testHostsListDiskEncryptionStatus},
 		{"HostsListFailingPolicies", printReadsInTest(testHostsListFailingPolicies)},
 		{"HostsExpiration", testHostsExpiration},
+		{"HostsExpirationMDMEnrolled", testHostsExpirationMDMEnrolled},
 		{"HostsAllPackStats", testHostsAllPackStats},
 		{"HostsPackStatsMultipleHosts", testHostsPackStatsMultipleHosts},
 		{"HostsPackStatsForPlatform", testHostsPackStatsForPlatform},
@@ -141,12 +143,19 @@ func TestHosts(t *testing.T) {
 		{"UnenrollFromMDM", testHostsUnenrollFromMDM},
 		{"LoadHostByOrbitNodeKey", testHostsLoadHostByOrbitNodeKey},
 		{"SetOrUpdateHostDiskEncryptionKeys", testHostsSetOrUpdateHostDisksEncryptionKey},
+		{"MDMAppleFileVaultKeyRotation", testHostsMDMAppleFileVaultKeyRotation},
 		{"SetHostsDiskEncryptionKeyStatus", testHostsSetDiskEncryptionKeyStatus},
 		{"GetUnverifiedDiskEncryptionKeys", testHostsGetUnverifiedDiskEncryptionKeys},
+		{"DiskEncryptionKeyAccesses", testHostsDiskEncryptionKeyAccesses},
 		{"EnrollOrbit", testHostsEnrollOrbit},
 		{"EnrollUpdatesMissingInfo", testHostsEnrollUpdatesMissingInfo},
 		{"EncryptionKeyRawDecryption", testHostsEncryptionKeyRawDecryption},
 		{"ListHostsLiteByUUIDs", testHostsListHostsLiteByUUIDs},
+		{"HostQuarantine", testHostsHostQuarantine},
+		{"MarkHostMDMCheckedIn", testHostsMarkHostMDMCheckedIn},
+		{"CustomAttributes", testHostsCustomAttributes},
+		{"AssignedUser", testHostsAssignedUser},
+		{"ListHostsByDiskEncryptionKeyEscrow", testHostsListByDiskEncryptionKeyEscrow},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
@@ -1793,6 +1802,94 @@ func testHostsGenerateStatusStatistics(t *testing.T, ds *Datastore) {
 	assert.Equal(t, uint(1), *summary.LowDiskSpaceCount)
 }
 
+func testHostsGenerateMDMStatistics(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+
+	summary, err := ds.GenerateHostMDMStatistics(ctx, filter, nil)
+	require.NoError(t, err)
+	require.Equal(t, fleet.HostSummaryMDM{}, *summary)
+
+	team, err := ds.NewTeam(ctx, &fleet.Team{Name: t.Name()})
+	require.NoError(t, err)
+
+	newHost := func(name, platform string) *fleet.Host {
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			OsqueryHostID:   ptr.String(name),
+			NodeKey:         ptr.String(name),
+			UUID:            name,
+			Hostname:        name,
+			Platform:        platform,
+			DetailUpdatedAt: time.Now(),
+			LabelUpdatedAt:  time.Now(),
+			PolicyUpdatedAt: time.Now(),
+			SeenTime:        time.Now(),
+		})
+		require.NoError(t, err)
+		return h
+	}
+
+	manual := newHost("manual", "darwin")
+	automated := newHost("automated", "darwin")
+	pending := newHost("pending", "darwin")
+	unenrolled := newHost("unenrolled", "windows")
+	server := newHost("server", "darwin")
+	newHost("no-mdm", "ubuntu")
+
+	require.NoError(t, ds.SetOrUpdateMDMData(ctx, manual.ID, false, true, "https://fleet.example.com", false, fleet.WellKnownMDMFleet))
+	require.NoError(t, ds.SetOrUpdateMDMData(ctx, automated.ID, false, true, "https://fleet.example.com", true, fleet.WellKnownMDMFleet))
+	require.NoError(t, ds.SetOrUpdateMDMData(ctx, pending.ID, false, false, "https://fleet.example.com", true, fleet.WellKnownMDMFleet))
+	require.NoError(t, ds.SetOrUpdateMDMData(ctx, unenrolled.ID, false, false, "", false, ""))
+	require.NoError(t, ds.SetOrUpdateMDMData(ctx, server.ID, true, true, "https://fleet.example.com", false, fleet.WellKnownMDMFleet))
+
+	// the manually enrolled host has FileVault enabled with its key escrowed,
+	// the automatically enrolled host has a failed profile
+	require.NoError(t, ds.SetOrUpdateHostDisksEncryption(ctx, manual.ID, true))
+	require.NoError(t, ds.SetOrUpdateHostDisksEncryption(ctx, automated.ID, false))
+	require.NoError(t, ds.SetOrUpdateHostDiskEncryptionKey(ctx, manual.ID, "key"))
+	require.NoError(t, ds.SetHostsDiskEncryptionKeyStatus(ctx, []uint{manual.ID}, true, time.Now().Add(time.Hour)))
+	require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, []*fleet.MDMAppleBulkUpsertHostProfilePayload{
+		{
+			ProfileID:         1,
+			ProfileIdentifier: "p1",
+			ProfileName:       "name1",
+			HostUUID:          automated.UUID,
+			CommandUUID:       "cmd1",
+			OperationType:     fleet.MDMAppleOperationTypeInstall,
+			Status:            &fleet.MDMAppleDeliveryFailed,
+			Checksum:          []byte("csum"),
+		},
+	}))
+
+	summary, err = ds.GenerateHostMDMStatistics(ctx, filter, nil)
+	require.NoError(t, err)
+	require.Equal(t, fleet.HostSummaryMDM{
+		EnrolledCount:                  2,
+		EnrolledManualCount:            1,
+		EnrolledAutomatedCount:         1,
+		PendingCount:                   1,
+		UnenrolledCount:                1,
+		ProfilesFailedCount:            1,
+		DiskEncryptionEnabledCount:     1,
+		DiskEncryptionKeyEscrowedCount: 1,
+	}, *summary)
+
+	summary, err = ds.GenerateHostMDMStatistics(ctx, filter, ptr.String("windows"))
+	require.NoError(t, err)
+	require.Equal(t, fleet.HostSummaryMDM{UnenrolledCount: 1}, *summary)
+
+	// move the manually enrolled host to the team
+	require.NoError(t, ds.AddHostsToTeam(ctx, &team.ID, []uint{manual.ID}))
+	summary, err = ds.GenerateHostMDMStatistics(ctx, fleet.TeamFilter{User: test.UserAdmin, TeamID: &team.ID}, nil)
+	require.NoError(t, err)
+	require.Equal(t, fleet.HostSummaryMDM{
+		EnrolledCount:                  1,
+		EnrolledManualCount:            1,
+		DiskEncryptionEnabledCount:     1,
+		DiskEncryptionKeyEscrowedCount: 1,
+	}, *summary)
+}
+
 func testHostsMarkSeen(t *testing.T, ds *Datastore) {
 	mockClock := clock.NewMockClock()
 
@@ -3431,6 +3528,73 @@ func testHostsExpiration(t *testing.T, ds *Datastore) {
 	require.Len(t, hosts, 5)
 }
 
+func testHostsExpirationMDMEnrolled(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+	hostExpiryWindow := 70
+	expiredTime := time.Now().Add(time.Duration(-1*(hostExpiryWindow+1)*24) * time.Hour)
+
+	ac, err := ds.AppConfig(ctx)
+	require.NoError(t, err)
+	ac.HostExpirySettings.HostExpiryEnabled = true
+	ac.HostExpirySettings.HostExpiryWindow = hostExpiryWindow
+
+	// all hosts were last seen before the expiry window
+	hosts := make([]*fleet.Host, 4)
+	for i := range hosts {
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			DetailUpdatedAt: time.Now(),
+			LabelUpdatedAt:  time.Now(),
+			PolicyUpdatedAt: time.Now(),
+			SeenTime:        expiredTime,
+			OsqueryHostID:   ptr.String(strconv.Itoa(i)),
+			NodeKey:         ptr.String(strconv.Itoa(i)),
+			UUID:            fmt.Sprintf("uuid-%d", i),
+			Hostname:        fmt.Sprintf("foo.local%d", i),
+			Platform:        "darwin",
+		})
+		require.NoError(t, err)
+		hosts[i] = h
+	}
+	// hosts[0] is not enrolled in an MDM, hosts[1] is enrolled in a third-party
+	// MDM, hosts[2] and hosts[3] are enrolled in Fleet's MDM, but only hosts[2]
+	// checked in recently.
+	for _, h := range hosts[1:] {
+		err = ds.SetOrUpdateMDMData(ctx, h.ID, false, true, "https://mdm.example.com", false, "")
+		require.NoError(t, err)
+	}
+	for _, h := range hosts[2:] {
+		nanoEnroll(t, ds, h, false)
+	}
+	_, err = ds.writer.ExecContext(ctx, `UPDATE nano_enrollments SET last_seen_at = ? WHERE id = ?`, expiredTime, hosts[3].UUID)
+	require.NoError(t, err)
+
+	cases := []struct {
+		mode        fleet.HostExpiryMDMEnrolled
+		wantDeleted []uint
+	}{
+		{fleet.HostExpiryMDMEnrolledExempt, []uint{hosts[0].ID}},
+		{fleet.HostExpiryMDMEnrolledCheckIn, []uint{hosts[1].ID, hosts[3].ID}},
+		{fleet.HostExpiryMDMEnrolledExpire, []uint{hosts[2].ID}},
+	}
+	for _, c := range cases {
+		ac.HostExpirySettings.HostExpiryMDMEnrolled = c.mode
+		err = ds.SaveAppConfig(ctx, ac)
+		require.NoError(t, err)
+
+		deleted, err := ds.CleanupExpiredHosts(ctx)
+		require.NoError(t, err)
+		require.ElementsMatch(t, c.wantDeleted, deleted, string(c.mode))
+
+		if c.mode == fleet.HostExpiryMDMEnrolledExempt {
+			// the seen time of an exempted host is kept
+			h, err := ds.Host(ctx, hosts[1].ID)
+			require.NoError(t, err)
+			require.WithinDuration(t, expiredTime, h.SeenTime, time.Second)
+		}
+	}
+	listHostsCheckCount(t, ds, fleet.TeamFilter{User: test.UserAdmin}, fleet.HostListOptions{}, 0)
+}
+
 func testHostsAllPackStats(t *testing.T, ds *Datastore) {
 	host, err := ds.NewHost(context.Background(), &fleet.Host{
 		DetailUpdatedAt: time.Now(),
@@ -5521,6 +5685,9 @@ func testHostsDeleteHosts(t *testing.T, ds *Datastore) {
 	// set an encryption key
 	err = ds.SetOrUpdateHostDiskEncryptionKey(context.Background(), host.ID, "TESTKEY")
 	require.NoError(t, err)
+	// set os updates info
+	err = ds.SetOrUpdateHostOSUpdates(context.Background(), host.ID, "13.3.1", 1)
+	require.NoError(t, err)
 	// set an mdm profile
 	prof, err := ds.NewMDMAppleConfigProfile(context.Background(), *configProfileForTest(t, "N1", "I1", "U1"))
 	require.NoError(t, err)
@@ -5528,6 +5695,33 @@ func testHostsDeleteHosts(t *testing.T, ds *Datastore) {
 		{ProfileID: prof.ProfileID, ProfileIdentifier: prof.Identifier, ProfileName: prof.Name, HostUUID: host.UUID, OperationType: fleet.MDMAppleOperationTypeInstall, Checksum: []byte("csum")},
 	})
 	require.NoError(t, err)
+	// set an activation lock bypass code
+	err = ds.SetHostMDMActivationLockBypassCode(context.Background(), host.UUID, "ZW5jcnlwdGVk")
+	require.NoError(t, err)
+	// record a command in its MDM commands history
+	_, err = ds.writer.Exec(`INSERT INTO host_mdm_apple_command_history (host_uuid, command_uuid, request_type) VALUES (?, ?, ?)`, host.UUID, "cmd-uuid", "ProfileList")
+	require.NoError(t, err)
+	// set the DEP device information
+	_, err = ds.writer.Exec(`INSERT INTO host_mdm_apple_dep_devices (host_id, description) VALUES (?, ?)`, host.ID, "MBP 13.3 SPG")
+	require.NoError(t, err)
+	// override its DEP profile
+	err = ds.SetHostMDMAppleDEPProfileOverride(context.Background(), host.ID, ptr.RawMessage(json.RawMessage(`{"profile_name": "kiosk"}`)))
+	require.NoError(t, err)
+	// set the MDM enrollment status reported by orbit
+	err = ds.SetOrUpdateHostOrbitMDMStatus(context.Background(), host.ID, &fleet.OrbitMDMEnrollmentStatus{Enrolled: true})
+	require.NoError(t, err)
+	// record a quarantine
+	_, err = ds.NewHostQuarantine(context.Background(), &fleet.HostQuarantine{HostID: host.ID, CaseID: "case", TeamID: 1})
+	require.NoError(t, err)
+	// exclude the host from a profile
+	err = ds.BatchSetMDMAppleProfileExclusions(context.Background(), nil, []*fleet.MDMAppleProfileExclusion{
+		{ProfileIdentifier: prof.Identifier, HostID: &host.ID},
+	})
+	require.NoError(t, err)
+
+	// request the rotation of its disk encryption key
+	_, err = ds.writer.Exec(`INSERT INTO host_disk_encryption_key_rotations (host_id) VALUES (?)`, host.ID)
+	require.NoError(t, err)
 
 	// Operating system vulnerabilities
 	_, err = ds.writer.Exec(
@@ -6368,6 +6562,100 @@ func testHostsSetOrUpdateHostDisksEncryptionKey(t *testing.T, ds *Datastore) {
 	checkEncryptionKeyStatus(t, ds, host.ID, nil)
 }
 
+func testHostsMDMAppleFileVaultKeyRotation(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+
+	var hosts []*fleet.Host
+	for i := 0; i < 4; i++ {
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			DetailUpdatedAt: time.Now(),
+			LabelUpdatedAt:  time.Now(),
+			PolicyUpdatedAt: time.Now(),
+			SeenTime:        time.Now(),
+			NodeKey:         ptr.String(fmt.Sprint(i)),
+			UUID:            fmt.Sprint(i),
+			OsqueryHostID:   ptr.String(fmt.Sprint(i)),
+			Hostname:        fmt.Sprintf("foo.local%d", i),
+			Platform:        "darwin",
+		})
+		require.NoError(t, err)
+		hosts = append(hosts, h)
+	}
+	// hosts[3] is in the team, the others are in no team
+	require.NoError(t, ds.AddHostsToTeam(ctx, &tm.ID, []uint{hosts[3].ID}))
+
+	// hosts[0], hosts[1] and hosts[3] have a verified key, hosts[2] has a key
+	// that couldn't be decrypted
+	for i, h := range hosts {
+		require.NoError(t, ds.SetOrUpdateHostDiskEncryptionKey(ctx, h.ID, fmt.Sprintf("key%d", i)))
+	}
+	require.NoError(t, ds.SetHostsDiskEncryptionKeyStatus(ctx, []uint{hosts[0].ID, hosts[1].ID, hosts[3].ID}, true, time.Now().Add(time.Hour)))
+	require.NoError(t, ds.SetHostsDiskEncryptionKeyStatus(ctx, []uint{hosts[2].ID}, false, time.Now().Add(time.Hour)))
+
+	checkRotation := func(teamID *uint, want fleet.MDMAppleFileVaultKeyRotationSummary) {
+		summary, err := ds.GetMDMAppleFileVaultSummary(ctx, teamID)
+		require.NoError(t, err)
+		require.Equal(t, want, summary.KeyRotation)
+	}
+	checkResetRequested := func(hostID uint, want bool) {
+		h, err := ds.Host(ctx, hostID)
+		require.NoError(t, err)
+		require.Equal(t, want, h.DiskEncryptionResetRequested != nil && *h.DiskEncryptionResetRequested)
+	}
+
+	// nothing to notify
+	n, err := ds.NotifyMDMAppleFileVaultKeyRotations(ctx, 10)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	checkRotation(nil, fleet.MDMAppleFileVaultKeyRotationSummary{})
+
+	// only the hosts with a verified key in no team are flagged
+	n, err = ds.RequestMDMAppleFileVaultKeyRotation(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	checkRotation(nil, fleet.MDMAppleFileVaultKeyRotationSummary{Pending: 2})
+	checkRotation(&tm.ID, fleet.MDMAppleFileVaultKeyRotationSummary{})
+
+	// notify in batches
+	n, err = ds.NotifyMDMAppleFileVaultKeyRotations(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	checkRotation(nil, fleet.MDMAppleFileVaultKeyRotationSummary{Pending: 1, Notified: 1})
+
+	n, err = ds.NotifyMDMAppleFileVaultKeyRotations(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	checkRotation(nil, fleet.MDMAppleFileVaultKeyRotationSummary{Notified: 2})
+	checkResetRequested(hosts[0].ID, true)
+	checkResetRequested(hosts[1].ID, true)
+	checkResetRequested(hosts[2].ID, false)
+
+	n, err = ds.NotifyMDMAppleFileVaultKeyRotations(ctx, 1)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// escrowing the same key doesn't complete the rotation, a new one does
+	require.NoError(t, ds.SetOrUpdateHostDiskEncryptionKey(ctx, hosts[0].ID, "key0"))
+	checkRotation(nil, fleet.MDMAppleFileVaultKeyRotationSummary{Notified: 2})
+	require.NoError(t, ds.SetOrUpdateHostDiskEncryptionKey(ctx, hosts[0].ID, "newkey0"))
+	checkRotation(nil, fleet.MDMAppleFileVaultKeyRotationSummary{Notified: 1, Completed: 1})
+
+	// requesting the rotation again resets the progress
+	n, err = ds.RequestMDMAppleFileVaultKeyRotation(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, n) // hosts[0] key is not verified anymore
+	checkRotation(nil, fleet.MDMAppleFileVaultKeyRotationSummary{Pending: 1, Completed: 1})
+
+	// the team's hosts
+	n, err = ds.RequestMDMAppleFileVaultKeyRotation(ctx, &tm.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	checkRotation(&tm.ID, fleet.MDMAppleFileVaultKeyRotationSummary{Pending: 1})
+}
+
 func testHostsSetDiskEncryptionKeyStatus(t *testing.T, ds *Datastore) {
 	ctx := context.Background()
 	host, err := ds.NewHost(context.Background(), &fleet.Host{
@@ -6435,6 +6723,70 @@ func testHostsSetDiskEncryptionKeyStatus(t *testing.T, ds *Datastore) {
 	checkEncryptionKeyStatus(t, ds, host2.ID, ptr.Bool(false))
 }
 
+func testHostsDiskEncryptionKeyAccesses(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	accesses, meta, err := ds.ListHostDiskEncryptionKeyAccesses(ctx, 1, fleet.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, accesses)
+	require.False(t, meta.HasNextResults)
+
+	var users []*fleet.User
+	for i, host := range []uint{1, 1, 2, 1} {
+		user := test.NewUser(t, ds, fmt.Sprintf("user%d", i), fmt.Sprintf("user%d@example.com", i), true)
+		users = append(users, user)
+		err := ds.NewHostDiskEncryptionKeyAccess(ctx, &fleet.HostDiskEncryptionKeyAccess{
+			HostID:        host,
+			UserID:        ptr.Uint(user.ID),
+			UserName:      fmt.Sprintf("user%d", i),
+			UserEmail:     fmt.Sprintf("user%d@example.com", i),
+			IPAddress:     "1.2.3.4",
+			Justification: fmt.Sprintf("reason %d", i),
+		})
+		require.NoError(t, err)
+	}
+
+	// most recent first by default
+	accesses, meta, err = ds.ListHostDiskEncryptionKeyAccesses(ctx, 1, fleet.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, accesses, 3)
+	require.False(t, meta.HasNextResults)
+	require.Equal(t, "reason 3", accesses[0].Justification)
+	require.Equal(t, "user3@example.com", accesses[0].UserEmail)
+	require.Equal(t, "1.2.3.4", accesses[0].IPAddress)
+	require.Equal(t, "reason 1", accesses[1].Justification)
+	require.Equal(t, "reason 0", accesses[2].Justification)
+
+	accesses, meta, err = ds.ListHostDiskEncryptionKeyAccesses(ctx, 1, fleet.ListOptions{PerPage: 2})
+	require.NoError(t, err)
+	require.Len(t, accesses, 2)
+	require.True(t, meta.HasNextResults)
+	require.False(t, meta.HasPreviousResults)
+
+	accesses, meta, err = ds.ListHostDiskEncryptionKeyAccesses(ctx, 1, fleet.ListOptions{PerPage: 2, Page: 1})
+	require.NoError(t, err)
+	require.Len(t, accesses, 1)
+	require.False(t, meta.HasNextResults)
+	require.True(t, meta.HasPreviousResults)
+
+	// the accesses do not depend on the host, they are kept after it is deleted
+	accesses, _, err = ds.ListHostDiskEncryptionKeyAccesses(ctx, 2, fleet.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, accesses, 1)
+	require.Equal(t, "reason 2", accesses[0].Justification)
+
+	// the accesses are kept after the user is deleted, without the user id
+	err = ds.DeleteUser(ctx, users[3].ID)
+	require.NoError(t, err)
+	accesses, _, err = ds.ListHostDiskEncryptionKeyAccesses(ctx, 1, fleet.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, accesses, 3)
+	require.Nil(t, accesses[0].UserID)
+	require.Equal(t, "user3@example.com", accesses[0].UserEmail)
+	require.NotNil(t, accesses[1].UserID)
+	require.Equal(t, users[1].ID, *accesses[1].UserID)
+}
+
 func testHostsGetUnverifiedDiskEncryptionKeys(t *testing.T, ds *Datastore) {
 	ctx := context.Background()
 	host, err := ds.NewHost(context.Background(), &fleet.Host{
@@ -6886,3 +7238,455 @@ func testHostsListHostsLiteByUUIDs(t *testing.T, ds *Datastore) {
 		})
 	}
 }
+
+func testHostsHostQuarantine(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+	host := test.NewHost(t, ds, "host1", "10.0.0.1", "1", "1", time.Now())
+	team, err := ds.NewTeam(ctx, &fleet.Team{Name: "quarantine"})
+	require.NoError(t, err)
+
+	q, err := ds.NewHostQuarantine(ctx, &fleet.HostQuarantine{
+		HostID:         host.ID,
+		CaseID:         "case-1",
+		TeamID:         team.ID,
+		PreviousTeamID: ptr.Uint(42),
+		Locked:         true,
+	})
+	require.NoError(t, err)
+	require.NotZero(t, q.ID)
+	require.Equal(t, host.ID, q.HostID)
+	require.Equal(t, "case-1", q.CaseID)
+	require.Equal(t, team.ID, q.TeamID)
+	require.Equal(t, ptr.Uint(42), q.PreviousTeamID)
+	require.True(t, q.Locked)
+	require.False(t, q.CreatedAt.IsZero())
+
+	q2, err := ds.NewHostQuarantine(ctx, &fleet.HostQuarantine{HostID: host.ID, CaseID: "case-2", TeamID: team.ID})
+	require.NoError(t, err)
+	require.Nil(t, q2.PreviousTeamID)
+	require.False(t, q2.Locked)
+
+	require.NoError(t, ds.DeleteHostQuarantine(ctx, q.ID))
+	var count int
+	require.NoError(t, sqlx.GetContext(ctx, ds.reader, &count, `SELECT COUNT(*) FROM host_quarantines WHERE host_id = ?`, host.ID))
+	require.Equal(t, 1, count)
+}
+
+func testHostsMarkHostMDMCheckedIn(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	newHost := func(name string) *fleet.Host {
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:        name,
+			OsqueryHostID:   ptr.String(name),
+			NodeKey:         ptr.String(name),
+			UUID:            name + "-uuid",
+			Platform:        "darwin",
+			DetailUpdatedAt: time.Now(),
+			LabelUpdatedAt:  time.Now(),
+			PolicyUpdatedAt: time.Now(),
+			SeenTime:        time.Now(),
+		})
+		require.NoError(t, err)
+		return h
+	}
+	h1, h2, h3 := newHost("h1"), newHost("h2"), newHost("h3")
+
+	// no check-in yet
+	host, err := ds.Host(ctx, h1.ID)
+	require.NoError(t, err)
+	require.Nil(t, host.LastMDMCheckinAt)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, ds.MarkHostMDMCheckedIn(ctx, h1.UUID, now.Add(-10*24*time.Hour)))
+	require.NoError(t, ds.MarkHostMDMCheckedIn(ctx, h2.UUID, now.Add(-time.Hour)))
+	// unknown host is ignored
+	require.NoError(t, ds.MarkHostMDMCheckedIn(ctx, "no-such-uuid", now))
+
+	host, err = ds.Host(ctx, h2.ID)
+	require.NoError(t, err)
+	require.NotNil(t, host.LastMDMCheckinAt)
+	require.Equal(t, now.Add(-time.Hour), host.LastMDMCheckinAt.UTC())
+
+	// updating the check-in time
+	require.NoError(t, ds.MarkHostMDMCheckedIn(ctx, h1.UUID, now.Add(-2*24*time.Hour)))
+	host, err = ds.HostByIdentifier(ctx, h1.UUID)
+	require.NoError(t, err)
+	require.NotNil(t, host.LastMDMCheckinAt)
+	require.Equal(t, now.Add(-2*24*time.Hour), host.LastMDMCheckinAt.UTC())
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+
+	// sort by last check-in
+	hosts, err := ds.ListHosts(ctx, filter, fleet.HostListOptions{ListOptions: fleet.ListOptions{OrderKey: "last_mdm_checkin_at", OrderDirection: fleet.OrderDescending}})
+	require.NoError(t, err)
+	require.Len(t, hosts, 3)
+	require.Equal(t, []uint{h2.ID, h1.ID, h3.ID}, []uint{hosts[0].ID, hosts[1].ID, hosts[2].ID})
+	require.Nil(t, hosts[2].LastMDMCheckinAt)
+
+	// filter by last check-in
+	opts := fleet.HostListOptions{LastMDMCheckinWithinDaysFilter: ptr.Int(1)}
+	hosts, err = ds.ListHosts(ctx, filter, opts)
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	require.Equal(t, h2.ID, hosts[0].ID)
+	count, err := ds.CountHosts(ctx, filter, opts)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	opts.LastMDMCheckinWithinDaysFilter = ptr.Int(7)
+	opts.ListOptions = fleet.ListOptions{OrderKey: "last_mdm_checkin_at"}
+	hosts, err = ds.ListHosts(ctx, filter, opts)
+	require.NoError(t, err)
+	require.Len(t, hosts, 2)
+	require.Equal(t, []uint{h1.ID, h2.ID}, []uint{hosts[0].ID, hosts[1].ID})
+	count, err = ds.CountHosts(ctx, filter, opts)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	// the check-in time is deleted with the host
+	require.NoError(t, ds.DeleteHost(ctx, h1.ID))
+	var n int
+	require.NoError(t, sqlx.GetContext(ctx, ds.reader, &n, `SELECT COUNT(*) FROM host_mdm_checkin_times WHERE host_id = ?`, h1.ID))
+	require.Zero(t, n)
+}
+
+func testHostsCustomAttributes(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	newHost := func(name string) *fleet.Host {
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:        name,
+			OsqueryHostID:   ptr.String(name),
+			NodeKey:         ptr.String(name),
+			UUID:            name + "-uuid",
+			Platform:        "darwin",
+			DetailUpdatedAt: time.Now(),
+			LabelUpdatedAt:  time.Now(),
+			PolicyUpdatedAt: time.Now(),
+			SeenTime:        time.Now(),
+		})
+		require.NoError(t, err)
+		return h
+	}
+	h1, h2, h3 := newHost("h1"), newHost("h2"), newHost("h3")
+
+	attrNames := func(attrs []*fleet.HostCustomAttribute) map[string]string {
+		m := make(map[string]string, len(attrs))
+		for _, a := range attrs {
+			m[a.Name] = a.Value + "/" + a.Source
+		}
+		return m
+	}
+
+	attrs, err := ds.ListHostCustomAttributes(ctx, h1.ID)
+	require.NoError(t, err)
+	require.Empty(t, attrs)
+
+	// nothing to set is a no-op
+	require.NoError(t, ds.SetHostCustomAttributes(ctx, h1.ID, nil, fleet.HostCustomAttributeSourceAPI))
+
+	err = ds.SetHostCustomAttributes(ctx, h1.ID, map[string]*string{
+		"cost_center": ptr.String("CC-1"),
+		"owner":       ptr.String("jane"),
+		"unknown":     nil,
+	}, fleet.HostCustomAttributeSourceAPI)
+	require.NoError(t, err)
+	err = ds.SetHostCustomAttributes(ctx, h2.ID, map[string]*string{
+		"cost_center": ptr.String("CC-2"),
+	}, fleet.HostCustomAttributeSourceDEP)
+	require.NoError(t, err)
+
+	attrs, err = ds.ListHostCustomAttributes(ctx, h1.ID)
+	require.NoError(t, err)
+	require.Len(t, attrs, 2)
+	require.Equal(t, "cost_center", attrs[0].Name)
+	require.Equal(t, "owner", attrs[1].Name)
+	require.Equal(t, map[string]string{"cost_center": "CC-1/api", "owner": "jane/api"}, attrNames(attrs))
+
+	// update one, delete the other
+	err = ds.SetHostCustomAttributes(ctx, h1.ID, map[string]*string{
+		"cost_center": ptr.String("CC-3"),
+		"owner":       nil,
+	}, fleet.HostCustomAttributeSourceIdP)
+	require.NoError(t, err)
+	attrs, err = ds.ListHostCustomAttributes(ctx, h1.ID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"cost_center": "CC-3/idp"}, attrNames(attrs))
+
+	byUUID, err := ds.ListHostCustomAttributesByHostUUIDs(ctx, []string{h1.UUID, h2.UUID, h3.UUID, "no-such-uuid"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]map[string]string{
+		h1.UUID: {"cost_center": "CC-3"},
+		h2.UUID: {"cost_center": "CC-2"},
+	}, byUUID)
+	byUUID, err = ds.ListHostCustomAttributesByHostUUIDs(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, byUUID)
+
+	// filter the hosts by custom attribute
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	for _, c := range []struct {
+		name, value *string
+		want        []uint
+	}{
+		{ptr.String("cost_center"), nil, []uint{h1.ID, h2.ID}},
+		{ptr.String("cost_center"), ptr.String("CC-2"), []uint{h2.ID}},
+		{ptr.String("cost_center"), ptr.String("CC-9"), nil},
+		{ptr.String("owner"), nil, nil},
+	} {
+		opts := fleet.HostListOptions{CustomAttributeNameFilter: c.name, CustomAttributeValueFilter: c.value}
+		hosts, err := ds.ListHosts(ctx, filter, opts)
+		require.NoError(t, err)
+		var got []uint
+		for _, h := range hosts {
+			got = append(got, h.ID)
+		}
+		require.ElementsMatch(t, c.want, got)
+		count, err := ds.CountHosts(ctx, filter, opts)
+		require.NoError(t, err)
+		require.Equal(t, len(c.want), count)
+	}
+
+	// the installed profiles that reference custom attributes are marked for
+	// reinstall when a value changes
+	templated, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "$FLEET_VAR_HOST_CUSTOM_ATTRIBUTE_COST_CENTER", "com.templated", "templated-uuid"))
+	require.NoError(t, err)
+	static, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "Static", "com.static", "static-uuid"))
+	require.NoError(t, err)
+	var payloads []*fleet.MDMAppleBulkUpsertHostProfilePayload
+	for _, p := range []*fleet.MDMAppleConfigProfile{templated, static} {
+		payloads = append(payloads, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+			ProfileID:         p.ProfileID,
+			ProfileIdentifier: p.Identifier,
+			ProfileName:       p.Name,
+			HostUUID:          h1.UUID,
+			CommandUUID:       "cmd-" + p.Identifier,
+			OperationType:     fleet.MDMAppleOperationTypeInstall,
+			Status:            &fleet.MDMAppleDeliveryVerifying,
+			Checksum:          p.Checksum,
+		})
+	}
+	require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, payloads))
+
+	profileStatuses := func() map[uint]*fleet.MDMAppleDeliveryStatus {
+		var rows []struct {
+			ProfileID uint                          `db:"profile_id"`
+			Status    *fleet.MDMAppleDeliveryStatus `db:"status"`
+		}
+		err := sqlx.SelectContext(ctx, ds.reader, &rows, `SELECT profile_id, status FROM host_mdm_apple_profiles WHERE host_uuid = ?`, h1.UUID)
+		require.NoError(t, err)
+		m := make(map[uint]*fleet.MDMAppleDeliveryStatus, len(rows))
+		for _, r := range rows {
+			m[r.ProfileID] = r.Status
+		}
+		return m
+	}
+
+	// same value, nothing to reinstall
+	err = ds.SetHostCustomAttributes(ctx, h1.ID, map[string]*string{"cost_center": ptr.String("CC-3"), "owner": nil}, fleet.HostCustomAttributeSourceAPI)
+	require.NoError(t, err)
+	statuses := profileStatuses()
+	require.Equal(t, &fleet.MDMAppleDeliveryVerifying, statuses[templated.ProfileID])
+	require.Equal(t, &fleet.MDMAppleDeliveryVerifying, statuses[static.ProfileID])
+
+	err = ds.SetHostCustomAttributes(ctx, h1.ID, map[string]*string{"cost_center": ptr.String("CC-4")}, fleet.HostCustomAttributeSourceAPI)
+	require.NoError(t, err)
+	statuses = profileStatuses()
+	require.Nil(t, statuses[templated.ProfileID])
+	require.Equal(t, &fleet.MDMAppleDeliveryVerifying, statuses[static.ProfileID])
+
+	// the custom attributes are deleted with the host
+	require.NoError(t, ds.DeleteHost(ctx, h1.ID))
+	attrs, err = ds.ListHostCustomAttributes(ctx, h1.ID)
+	require.NoError(t, err)
+	require.Empty(t, attrs)
+}
+
+func testHostsAssignedUser(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	newHost := func(name string) *fleet.Host {
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:        name,
+			OsqueryHostID:   ptr.String(name),
+			NodeKey:         ptr.String(name),
+			UUID:            name + "-uuid",
+			Platform:        "darwin",
+			DetailUpdatedAt: time.Now(),
+			LabelUpdatedAt:  time.Now(),
+			PolicyUpdatedAt: time.Now(),
+			SeenTime:        time.Now(),
+		})
+		require.NoError(t, err)
+		return h
+	}
+	h1, h2, h3 := newHost("h1"), newHost("h2"), newHost("h3")
+
+	_, err := ds.GetHostAssignedUser(ctx, h1.ID)
+	require.True(t, fleet.IsNotFound(err))
+
+	// removing a missing assignment is a no-op
+	require.NoError(t, ds.SetHostAssignedUser(ctx, h1.ID, nil))
+
+	err = ds.SetHostAssignedUser(ctx, h1.ID, &fleet.HostAssignedUser{Email: "jane@example.com", FullName: "Jane Doe", Source: fleet.HostAssignedUserSourceSSO})
+	require.NoError(t, err)
+	err = ds.SetHostAssignedUser(ctx, h2.ID, &fleet.HostAssignedUser{Email: "john@example.com", Source: fleet.HostAssignedUserSourceAPI})
+	require.NoError(t, err)
+
+	user, err := ds.GetHostAssignedUser(ctx, h1.ID)
+	require.NoError(t, err)
+	require.Equal(t, h1.ID, user.HostID)
+	require.Equal(t, "jane@example.com", user.Email)
+	require.Equal(t, "Jane Doe", user.FullName)
+	require.Equal(t, fleet.HostAssignedUserSourceSSO, user.Source)
+	require.False(t, user.UpdatedAt.IsZero())
+
+	// replace the assignment
+	err = ds.SetHostAssignedUser(ctx, h2.ID, &fleet.HostAssignedUser{Email: "john.doe@example.com", FullName: "John Doe", Source: fleet.HostAssignedUserSourceIdPSync})
+	require.NoError(t, err)
+	user, err = ds.GetHostAssignedUser(ctx, h2.ID)
+	require.NoError(t, err)
+	require.Equal(t, "john.doe@example.com", user.Email)
+	require.Equal(t, "John Doe", user.FullName)
+	require.Equal(t, fleet.HostAssignedUserSourceIdPSync, user.Source)
+
+	byUUID, err := ds.ListHostAssignedUsersByHostUUIDs(ctx, []string{h1.UUID, h2.UUID, h3.UUID, "no-such-uuid"})
+	require.NoError(t, err)
+	require.Len(t, byUUID, 2)
+	require.Equal(t, "jane@example.com", byUUID[h1.UUID].Email)
+	require.Equal(t, "john.doe@example.com", byUUID[h2.UUID].Email)
+	byUUID, err = ds.ListHostAssignedUsersByHostUUIDs(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, byUUID)
+
+	// search the hosts by assigned user email
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	hosts, err := ds.ListHosts(ctx, filter, fleet.HostListOptions{ListOptions: fleet.ListOptions{MatchQuery: "jane@example.com"}})
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	require.Equal(t, h1.ID, hosts[0].ID)
+	hosts, err = ds.SearchHosts(ctx, filter, "john.doe@example.com")
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	require.Equal(t, h2.ID, hosts[0].ID)
+
+	// the installed profiles that reference the assigned user are marked for
+	// reinstall when the user changes
+	templated, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "$FLEET_VAR_HOST_ASSIGNED_USER_EMAIL", "com.templated", "templated-uuid"))
+	require.NoError(t, err)
+	static, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "Static", "com.static", "static-uuid"))
+	require.NoError(t, err)
+	var payloads []*fleet.MDMAppleBulkUpsertHostProfilePayload
+	for _, p := range []*fleet.MDMAppleConfigProfile{templated, static} {
+		payloads = append(payloads, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+			ProfileID:         p.ProfileID,
+			ProfileIdentifier: p.Identifier,
+			ProfileName:       p.Name,
+			HostUUID:          h1.UUID,
+			CommandUUID:       "cmd-" + p.Identifier,
+			OperationType:     fleet.MDMAppleOperationTypeInstall,
+			Status:            &fleet.MDMAppleDeliveryVerifying,
+			Checksum:          p.Checksum,
+		})
+	}
+	require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, payloads))
+
+	profileStatuses := func() map[uint]*fleet.MDMAppleDeliveryStatus {
+		var rows []struct {
+			ProfileID uint                          `db:"profile_id"`
+			Status    *fleet.MDMAppleDeliveryStatus `db:"status"`
+		}
+		err := sqlx.SelectContext(ctx, ds.reader, &rows, `SELECT profile_id, status FROM host_mdm_apple_profiles WHERE host_uuid = ?`, h1.UUID)
+		require.NoError(t, err)
+		m := make(map[uint]*fleet.MDMAppleDeliveryStatus, len(rows))
+		for _, r := range rows {
+			m[r.ProfileID] = r.Status
+		}
+		return m
+	}
+
+	// same user from another source, nothing to reinstall
+	err = ds.SetHostAssignedUser(ctx, h1.ID, &fleet.HostAssignedUser{Email: "jane@example.com", FullName: "Jane Doe", Source: fleet.HostAssignedUserSourceAPI})
+	require.NoError(t, err)
+	statuses := profileStatuses()
+	require.Equal(t, &fleet.MDMAppleDeliveryVerifying, statuses[templated.ProfileID])
+	require.Equal(t, &fleet.MDMAppleDeliveryVerifying, statuses[static.ProfileID])
+	user, err = ds.GetHostAssignedUser(ctx, h1.ID)
+	require.NoError(t, err)
+	require.Equal(t, fleet.HostAssignedUserSourceAPI, user.Source)
+
+	// removing the assignment reinstalls the templated profiles
+	require.NoError(t, ds.SetHostAssignedUser(ctx, h1.ID, nil))
+	statuses = profileStatuses()
+	require.Nil(t, statuses[templated.ProfileID])
+	require.Equal(t, &fleet.MDMAppleDeliveryVerifying, statuses[static.ProfileID])
+	_, err = ds.GetHostAssignedUser(ctx, h1.ID)
+	require.True(t, fleet.IsNotFound(err))
+
+	// the assigned user is deleted with the host
+	require.NoError(t, ds.DeleteHost(ctx, h2.ID))
+	_, err = ds.GetHostAssignedUser(ctx, h2.ID)
+	require.True(t, fleet.IsNotFound(err))
+}
+
+func testHostsListByDiskEncryptionKeyEscrow(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	newHost := func(name string) *fleet.Host {
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:        name,
+			OsqueryHostID:   ptr.String(name),
+			NodeKey:         ptr.String(name),
+			UUID:            name + "-uuid",
+			Platform:        "darwin",
+			DetailUpdatedAt: time.Now(),
+			LabelUpdatedAt:  time.Now(),
+			PolicyUpdatedAt: time.Now(),
+			SeenTime:        time.Now(),
+		})
+		require.NoError(t, err)
+		return h
+	}
+	h1, h2, h3, h4 := newHost("h1"), newHost("h2"), newHost("h3"), newHost("h4")
+
+	// h1 has an old key, h2 a recent one, h3 must reset its key and h4 never
+	// reported one.
+	require.NoError(t, ds.SetOrUpdateHostDiskEncryptionKey(ctx, h1.ID, "a2V5MQ=="))
+	require.NoError(t, ds.SetOrUpdateHostDiskEncryptionKey(ctx, h2.ID, "a2V5Mg=="))
+	require.NoError(t, ds.SetOrUpdateHostDiskEncryptionKey(ctx, h3.ID, ""))
+	now := time.Now().UTC().Truncate(time.Second)
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `UPDATE host_disk_encryption_keys SET updated_at = ? WHERE host_id = ?`, now.Add(-100*24*time.Hour), h1.ID)
+		return err
+	})
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	cases := []struct {
+		desc string
+		opts fleet.HostListOptions
+		want []uint
+	}{
+		{"escrowed", fleet.HostListOptions{DiskEncryptionKeyEscrowedFilter: ptr.Bool(true)}, []uint{h1.ID, h2.ID}},
+		{"not escrowed", fleet.HostListOptions{DiskEncryptionKeyEscrowedFilter: ptr.Bool(false)}, []uint{h3.ID, h4.ID}},
+		{"escrowed before 90 days", fleet.HostListOptions{KeyEscrowedBeforeFilter: ptr.Time(now.Add(-90 * 24 * time.Hour))}, []uint{h1.ID}},
+		{"escrowed before tomorrow", fleet.HostListOptions{DiskEncryptionKeyEscrowedFilter: ptr.Bool(true), KeyEscrowedBeforeFilter: ptr.Time(now.Add(24 * time.Hour))}, []uint{h1.ID, h2.ID}},
+		{"escrowed before 200 days", fleet.HostListOptions{KeyEscrowedBeforeFilter: ptr.Time(now.Add(-200 * 24 * time.Hour))}, []uint{}},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			c.opts.ListOptions = fleet.ListOptions{OrderKey: "id"}
+			hosts, err := ds.ListHosts(ctx, filter, c.opts)
+			require.NoError(t, err)
+			ids := make([]uint, 0, len(hosts))
+			for _, h := range hosts {
+				ids = append(ids, h.ID)
+			}
+			require.Equal(t, c.want, ids)
+
+			count, err := ds.CountHosts(ctx, filter, c.opts)
+			require.NoError(t, err)
+			require.Equal(t, len(c.want), count)
+		})
+	}
+}
@@ -2,8 +2,10 @@ package mysql
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/jmoiron/sqlx"
 )
@@ -38,7 +40,7 @@ VALUES (?, ?, ?, ?, ?, COALESCE(?, NOW()))
 func (ds *Datastore) GetQueuedJobs(ctx context.Context, maxNumJobs int) ([]*fleet.Job, error) {
 	query := `
 SELECT
-    id, created_at, updated_at, name, args, state, retries, error, not_before
+    id, created_at, updated_at, name, args, state, retries, error, not_before, hosts_done, hosts_count
 FROM
     jobs
 WHERE
@@ -58,6 +60,50 @@ LIMIT ?
 	return jobs, nil
 }
 
+func (ds *Datastore) GetJob(ctx context.Context, id uint) (*fleet.Job, error) {
+	query := `
+SELECT
+    id, created_at, updated_at, name, args, state, retries, error, not_before, hosts_done, hosts_count
+FROM
+    jobs
+WHERE
+    id = ?
+`
+
+	var job fleet.Job
+	if err := sqlx.GetContext(ctx, ds.reader, &job, query, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ctxerr.Wrap(ctx, notFound("Job").WithID(id))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get job")
+	}
+
+	return &job, nil
+}
+
+func (ds *Datastore) ListJobs(ctx context.Context, name string, state fleet.JobState, opt fleet.ListOptions) ([]*fleet.Job, error) {
+	query := `
+SELECT
+    id, created_at, updated_at, name, args, state, retries, error, not_before, hosts_done, hosts_count
+FROM
+    jobs
+WHERE
+    name = ? AND
+    state = ?
+`
+	if opt.OrderKey == "" {
+		opt.OrderKey = "updated_at"
+		opt.OrderDirection = fleet.OrderDescending
+	}
+	query = appendListOptionsToSQL(query, &opt)
+
+	var jobs []*fleet.Job
+	if err := sqlx.SelectContext(ctx, ds.reader, &jobs, query, name, state); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list jobs")
+	}
+	return jobs, nil
+}
+
 func (ds *Datastore) UpdateJob(ctx context.Context, id uint, job *fleet.Job) (*fleet.Job, error) {
 	query := `
 UPDATE jobs
@@ -80,3 +126,16 @@ WHERE
 
 	return job, nil
 }
+
+func (ds *Datastore) UpdateJobProgress(ctx context.Context, id uint, hostsDone, hostsCount int) error {
+	query := `
+UPDATE jobs
+SET
+    hosts_done = ?,
+    hosts_count = ?
+WHERE
+    id = ?
+`
+	_, err := ds.writer.ExecContext(ctx, query, hostsDone, hostsCount, id)
+	return ctxerr.Wrap(ctx, err, "update job progress")
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/stretchr/testify/require"
 )
 
@@ -17,6 +18,7 @@ func TestJobs(t *testing.T) {
 		fn   func(t *testing.T, ds *Datastore)
 	}{
 		{"QueueAndProcessJobs", testQueueAndProcessJobs},
+		{"ListJobs", testListJobs},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
@@ -57,6 +59,29 @@ func testQueueAndProcessJobs(t *testing.T, ds *Datastore) {
 	_, err = ds.UpdateJob(ctx, j1.ID, j1)
 	require.NoError(t, err)
 
+	// get j1 by id reflects the update
+	job, err := ds.GetJob(ctx, j1.ID)
+	require.NoError(t, err)
+	require.Equal(t, "j1", job.Name)
+	require.Equal(t, fleet.JobStateSuccess, job.State)
+	require.Nil(t, job.HostsDone)
+	require.Nil(t, job.HostsCount)
+
+	// record the progress of j1, it is kept by the updates of the job
+	err = ds.UpdateJobProgress(ctx, j1.ID, 2, 5)
+	require.NoError(t, err)
+	_, err = ds.UpdateJob(ctx, j1.ID, j1)
+	require.NoError(t, err)
+	job, err = ds.GetJob(ctx, j1.ID)
+	require.NoError(t, err)
+	require.Equal(t, ptr.Int(2), job.HostsDone)
+	require.Equal(t, ptr.Int(5), job.HostsCount)
+
+	// get an unknown job
+	_, err = ds.GetJob(ctx, j2.ID+1)
+	require.Error(t, err)
+	require.True(t, fleet.IsNotFound(err))
+
 	// no jobs queued for now
 	jobs, err = ds.GetQueuedJobs(ctx, 10)
 	require.NoError(t, err)
@@ -75,3 +100,42 @@ func testQueueAndProcessJobs(t *testing.T, ds *Datastore) {
 	require.NotZero(t, jobs[0].NotBefore)
 	require.False(t, jobs[0].NotBefore.After(time.Now())) // before or equal
 }
+
+func testListJobs(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	jobs, err := ds.ListJobs(ctx, "j1", fleet.JobStateFailure, fleet.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, jobs)
+
+	j1, err := ds.NewJob(ctx, &fleet.Job{Name: "j1", State: fleet.JobStateQueued})
+	require.NoError(t, err)
+	j2, err := ds.NewJob(ctx, &fleet.Job{Name: "j1", State: fleet.JobStateQueued})
+	require.NoError(t, err)
+	j3, err := ds.NewJob(ctx, &fleet.Job{Name: "j2", State: fleet.JobStateQueued})
+	require.NoError(t, err)
+
+	// mark all jobs as failed
+	for _, j := range []*fleet.Job{j1, j2, j3} {
+		j.State = fleet.JobStateFailure
+		j.Error = "boom"
+		_, err = ds.UpdateJob(ctx, j.ID, j)
+		require.NoError(t, err)
+	}
+
+	jobs, err = ds.ListJobs(ctx, "j1", fleet.JobStateQueued, fleet.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, jobs)
+
+	jobs, err = ds.ListJobs(ctx, "j1", fleet.JobStateFailure, fleet.ListOptions{OrderKey: "id"})
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	require.Equal(t, j1.ID, jobs[0].ID)
+	require.Equal(t, j2.ID, jobs[1].ID)
+	require.Equal(t, "boom", jobs[0].Error)
+
+	jobs, err = ds.ListJobs(ctx, "j1", fleet.JobStateFailure, fleet.ListOptions{OrderKey: "id", PerPage: 1, Page: 1})
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, j2.ID, jobs[0].ID)
+}
@@ -246,6 +246,11 @@ func (ds *Datastore) DeleteLabel(ctx context.Context, name string) error {
 			return ctxerr.Wrapf(ctx, err, "delete label_membership")
 		}
 
+		_, err = tx.ExecContext(ctx, `DELETE FROM mdm_apple_configuration_profile_exclusions WHERE label_id = ?`, labelID)
+		if err != nil {
+			return ctxerr.Wrapf(ctx, err, "delete mdm_apple_configuration_profile_exclusions")
+		}
+
 		_, err = tx.ExecContext(ctx, `DELETE FROM pack_targets WHERE type=? AND target_id=?`, fleet.TargetLabel, labelID)
 		if err != nil {
 			return ctxerr.Wrapf(ctx, err, "deleting pack_targets for label %d", labelID)
@@ -0,0 +1,147 @@
+package mysql
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+)
+
+// serverPrivateKeyLen is the length of the AES-256 key derived from the
+// server private key.
+const serverPrivateKeyLen = 32
+
+func (ds *Datastore) SetupMDMAppleConfigAssets(ctx context.Context, assets []fleet.MDMConfigAsset) error {
+	if len(assets) == 0 {
+		return nil
+	}
+
+	var enableABM bool
+	args := make([]interface{}, 0, 2*len(assets))
+	for _, a := range assets {
+		value, err := encrypt(a.Value, ds.serverPrivateKey)
+		if err != nil {
+			return ctxerr.Wrapf(ctx, err, "encrypting mdm config asset %s", a.Name)
+		}
+		args = append(args, a.Name, value)
+		if a.Name == fleet.MDMConfigAssetABMToken {
+			enableABM = true
+		}
+	}
+
+	stmt := fmt.Sprintf(`
+          INSERT INTO mdm_config_assets (name, value)
+          VALUES %s
+          ON DUPLICATE KEY UPDATE value = VALUES(value)`,
+		strings.TrimSuffix(strings.Repeat("(?, ?),", len(assets)), ","))
+
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return ctxerr.Wrap(ctx, err, "insert mdm config assets")
+		}
+
+		appCfg, err := appConfigDB(ctx, tx)
+		if err != nil {
+			return err
+		}
+		appCfg.MDM.EnabledAndConfigured = true
+		if enableABM {
+			appCfg.MDM.AppleBMEnabledAndConfigured = true
+		}
+		configBytes, err := json.Marshal(appCfg)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "marshaling config")
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO app_config_json(json_value) VALUES(?) ON DUPLICATE KEY UPDATE json_value = VALUES(json_value)`,
+			configBytes,
+		); err != nil {
+			return ctxerr.Wrap(ctx, err, "update app_config_json")
+		}
+		return nil
+	})
+}
+
+func (ds *Datastore) GetMDMConfigAssetsByName(ctx context.Context, names []fleet.MDMConfigAssetName) (map[fleet.MDMConfigAssetName]fleet.MDMConfigAsset, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	stmt, args, err := sqlx.In(`SELECT name, value FROM mdm_config_assets WHERE name IN (?)`, names)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "building query to get mdm config assets")
+	}
+
+	var rows []struct {
+		Name  fleet.MDMConfigAssetName `db:"name"`
+		Value []byte                   `db:"value"`
+	}
+	if err := sqlx.SelectContext(ctx, ds.reader, &rows, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get mdm config assets")
+	}
+
+	res := make(map[fleet.MDMConfigAssetName]fleet.MDMConfigAsset, len(rows))
+	for _, r := range rows {
+		value, err := decrypt(r.Value, ds.serverPrivateKey)
+		if err != nil {
+			return nil, ctxerr.Wrapf(ctx, err, "decrypting mdm config asset %s", r.Name)
+		}
+		res[r.Name] = fleet.MDMConfigAsset{Name: r.Name, Value: value}
+	}
+	return res, nil
+}
+
+// encrypt encrypts the plain text with AES-256-GCM, using the first 32 bytes
+// of the private key. The nonce is prepended to the returned cipher text.
+func encrypt(plainText []byte, privateKey string) ([]byte, error) {
+	aesGCM, err := newServerPrivateKeyCipher(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aesGCM.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return aesGCM.Seal(nonce, nonce, plainText, nil), nil
+}
+
+// decrypt decrypts the cipher text returned by encrypt.
+func decrypt(cipherText []byte, privateKey string) ([]byte, error) {
+	aesGCM, err := newServerPrivateKeyCipher(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(cipherText) < aesGCM.NonceSize() {
+		return nil, errors.New("cipher text is too short")
+	}
+	nonce, data := cipherText[:aesGCM.NonceSize()], cipherText[aesGCM.NonceSize():]
+	plainText, err := aesGCM.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	return plainText, nil
+}
+
+func newServerPrivateKeyCipher(privateKey string) (cipher.AEAD, error) {
+	if len(privateKey) < serverPrivateKeyLen {
+		return nil, fmt.Errorf("server private key must be at least %d bytes long", serverPrivateKeyLen)
+	}
+	block, err := aes.NewCipher([]byte(privateKey)[:serverPrivateKeyLen])
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create GCM cipher: %w", err)
+	}
+	return aesGCM, nil
+}
@@ -0,0 +1,120 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMDMConfigAssets(t *testing.T) {
+	ds := CreateMySQLDS(t)
+
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, ds *Datastore)
+	}{
+		{"SetupMDMAppleConfigAssets", testSetupMDMAppleConfigAssets},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer TruncateTables(t, ds)
+			c.fn(t, ds)
+		})
+	}
+}
+
+func testSetupMDMAppleConfigAssets(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	defer func(key string) { ds.serverPrivateKey = key }(ds.serverPrivateKey)
+	ds.serverPrivateKey = "too short"
+
+	assets := []fleet.MDMConfigAsset{
+		{Name: fleet.MDMConfigAssetAPNsCert, Value: []byte("apns cert")},
+		{Name: fleet.MDMConfigAssetAPNsKey, Value: []byte("apns key")},
+		{Name: fleet.MDMConfigAssetSCEPCert, Value: []byte("scep cert")},
+		{Name: fleet.MDMConfigAssetSCEPKey, Value: []byte("scep key")},
+	}
+	err := ds.SetupMDMAppleConfigAssets(ctx, assets)
+	require.ErrorContains(t, err, "server private key must be at least 32 bytes long")
+
+	appCfg, err := ds.AppConfig(ctx)
+	require.NoError(t, err)
+	require.False(t, appCfg.MDM.EnabledAndConfigured)
+
+	ds.serverPrivateKey = "0123456789abcdef0123456789abcdef-ignored"
+	err = ds.SetupMDMAppleConfigAssets(ctx, assets)
+	require.NoError(t, err)
+
+	appCfg, err = ds.AppConfig(ctx)
+	require.NoError(t, err)
+	require.True(t, appCfg.MDM.EnabledAndConfigured)
+	require.False(t, appCfg.MDM.AppleBMEnabledAndConfigured)
+
+	// the values are encrypted at rest
+	var stored []byte
+	err = ds.writer.GetContext(ctx, &stored, `SELECT value FROM mdm_config_assets WHERE name = ?`, fleet.MDMConfigAssetAPNsCert)
+	require.NoError(t, err)
+	require.NotContains(t, string(stored), "apns cert")
+
+	got, err := ds.GetMDMConfigAssetsByName(ctx, []fleet.MDMConfigAssetName{
+		fleet.MDMConfigAssetAPNsCert, fleet.MDMConfigAssetSCEPKey, fleet.MDMConfigAssetABMToken,
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[fleet.MDMConfigAssetName]fleet.MDMConfigAsset{
+		fleet.MDMConfigAssetAPNsCert: {Name: fleet.MDMConfigAssetAPNsCert, Value: []byte("apns cert")},
+		fleet.MDMConfigAssetSCEPKey:  {Name: fleet.MDMConfigAssetSCEPKey, Value: []byte("scep key")},
+	}, got)
+
+	// adding the ABM token enables ABM, and the existing assets are replaced
+	err = ds.SetupMDMAppleConfigAssets(ctx, []fleet.MDMConfigAsset{
+		{Name: fleet.MDMConfigAssetAPNsCert, Value: []byte("new apns cert")},
+		{Name: fleet.MDMConfigAssetABMToken, Value: []byte("abm token")},
+	})
+	require.NoError(t, err)
+	appCfg, err = ds.AppConfig(ctx)
+	require.NoError(t, err)
+	require.True(t, appCfg.MDM.AppleBMEnabledAndConfigured)
+
+	got, err = ds.GetMDMConfigAssetsByName(ctx, []fleet.MDMConfigAssetName{fleet.MDMConfigAssetAPNsCert, fleet.MDMConfigAssetABMToken})
+	require.NoError(t, err)
+	require.Equal(t, []byte("new apns cert"), got[fleet.MDMConfigAssetAPNsCert].Value)
+	require.Equal(t, []byte("abm token"), got[fleet.MDMConfigAssetABMToken].Value)
+
+	// the assets can't be decrypted with another key
+	ds.serverPrivateKey = "fedcba9876543210fedcba9876543210"
+	_, err = ds.GetMDMConfigAssetsByName(ctx, []fleet.MDMConfigAssetName{fleet.MDMConfigAssetAPNsCert})
+	require.ErrorContains(t, err, "decrypting mdm config asset apns_cert")
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef"
+
+	cipherText, err := encrypt([]byte("secret"), key)
+	require.NoError(t, err)
+	require.NotContains(t, string(cipherText), "secret")
+
+	// the nonce is random, so the same value is encrypted differently
+	cipherText2, err := encrypt([]byte("secret"), key)
+	require.NoError(t, err)
+	require.NotEqual(t, cipherText, cipherText2)
+
+	plainText, err := decrypt(cipherText, key)
+	require.NoError(t, err)
+	require.Equal(t, "secret", string(plainText))
+
+	// only the first 32 bytes of the key are used
+	plainText, err = decrypt(cipherText, key+"suffix")
+	require.NoError(t, err)
+	require.Equal(t, "secret", string(plainText))
+
+	_, err = decrypt(cipherText, "fedcba9876543210fedcba9876543210")
+	require.Error(t, err)
+	_, err = decrypt([]byte("abc"), key)
+	require.ErrorContains(t, err, "cipher text is too short")
+	_, err = encrypt([]byte("secret"), "short")
+	require.ErrorContains(t, err, "at least 32 bytes")
+}
@@ -0,0 +1,150 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+)
+
+// mdmHostTargetMatchCond is the condition that matches the hosts that match
+// all the filters of an MDM host target. It expects the target to be aliased
+// as mht and the hosts as h. The hosts report their OS version as e.g.
+// "macOS 13.4.1".
+var mdmHostTargetMatchCond = fmt.Sprintf(`(
+  (
+    COALESCE(JSON_LENGTH(mht.team_ids), 0) = 0 OR
+    JSON_CONTAINS(mht.team_ids, CAST(COALESCE(h.team_id, 0) AS JSON))
+  ) AND
+  (mht.architecture = '' OR h.cpu_type LIKE CONCAT(mht.architecture, '%%')) AND
+  (mht.os_version_at_least = '' OR (h.os_version LIKE 'macOS %%' AND %[1]s >= %[2]s)) AND
+  (mht.os_version_below = '' OR (h.os_version LIKE 'macOS %%' AND %[1]s < %[3]s))
+)`,
+	sqlVersionKey("SUBSTRING_INDEX(h.os_version, ' ', -1)"),
+	sqlVersionKey("mht.os_version_at_least"),
+	sqlVersionKey("mht.os_version_below"),
+)
+
+// mdmAppleProfileInTargetCond is the condition that filters out, from the
+// desired state of the hosts' profiles, the profiles restricted to an MDM
+// host target that the host doesn't match. It expects the profiles to be
+// aliased as macp and the hosts as h.
+var mdmAppleProfileInTargetCond = `(
+  macp.target_id IS NULL OR
+  EXISTS (
+    SELECT 1
+    FROM mdm_host_targets mht
+    WHERE mht.id = macp.target_id AND ` + mdmHostTargetMatchCond + `
+  )
+)`
+
+const mdmHostTargetColumns = `
+    id, name, description, team_ids, architecture, os_version_at_least,
+    os_version_below, created_at, updated_at`
+
+func (ds *Datastore) NewMDMHostTarget(ctx context.Context, target *fleet.MDMHostTarget) (*fleet.MDMHostTarget, error) {
+	stmt := `
+INSERT INTO
+    mdm_host_targets (name, description, team_ids, architecture, os_version_at_least, os_version_below)
+VALUES (?, ?, ?, ?, ?, ?)`
+
+	res, err := ds.writer.ExecContext(ctx, stmt, target.Name, target.Description, target.TeamIDs,
+		target.Architecture, target.OSVersionAtLeast, target.OSVersionBelow)
+	if err != nil {
+		if isDuplicate(err) {
+			return nil, ctxerr.Wrap(ctx, alreadyExists("MDMHostTarget", target.Name))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "insert mdm host target")
+	}
+
+	id, _ := res.LastInsertId()
+	return ds.MDMHostTarget(ctx, uint(id))
+}
+
+func (ds *Datastore) MDMHostTarget(ctx context.Context, id uint) (*fleet.MDMHostTarget, error) {
+	stmt := `SELECT ` + mdmHostTargetColumns + ` FROM mdm_host_targets WHERE id = ?`
+
+	var target fleet.MDMHostTarget
+	if err := sqlx.GetContext(ctx, ds.writer, &target, stmt, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("MDMHostTarget").WithID(id))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get mdm host target")
+	}
+	return &target, nil
+}
+
+func (ds *Datastore) ListMDMHostTargets(ctx context.Context) ([]*fleet.MDMHostTarget, error) {
+	stmt := `SELECT ` + mdmHostTargetColumns + ` FROM mdm_host_targets ORDER BY name`
+
+	targets := []*fleet.MDMHostTarget{}
+	if err := sqlx.SelectContext(ctx, ds.reader, &targets, stmt); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list mdm host targets")
+	}
+	return targets, nil
+}
+
+func (ds *Datastore) DeleteMDMHostTarget(ctx context.Context, id uint) error {
+	res, err := ds.writer.ExecContext(ctx, `DELETE FROM mdm_host_targets WHERE id = ?`, id)
+	if err != nil {
+		if isMySQLForeignKey(err) {
+			return ctxerr.Wrap(ctx, foreignKey("mdm_host_targets", fmt.Sprint(id)))
+		}
+		return ctxerr.Wrap(ctx, err, "delete mdm host target")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ctxerr.Wrap(ctx, notFound("MDMHostTarget").WithID(id))
+	}
+	return nil
+}
+
+func (ds *Datastore) ListMDMHostTargetHosts(ctx context.Context, id uint) ([]*fleet.MDMHostTargetHost, error) {
+	stmt := `
+    SELECT
+      h.id,
+      h.uuid,
+      h.team_id,
+      COALESCE(NULLIF(hdn.display_name, ''), h.hostname) as display_name,
+      h.hardware_serial
+    FROM
+      hosts h
+    JOIN
+      mdm_host_targets mht ON mht.id = ?
+    JOIN
+      nano_enrollments ne ON ne.id = h.uuid AND ne.type = 'Device' AND ne.enabled = 1
+    LEFT JOIN
+      host_display_names hdn ON hdn.host_id = h.id
+    WHERE
+      h.platform = 'darwin' AND
+      ` + mdmHostTargetMatchCond + `
+    ORDER BY
+      h.id`
+
+	hosts := []*fleet.MDMHostTargetHost{}
+	if err := sqlx.SelectContext(ctx, ds.reader, &hosts, stmt, id); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list mdm host target hosts")
+	}
+	return hosts, nil
+}
+
+func (ds *Datastore) SetMDMAppleConfigProfileTarget(ctx context.Context, profileID uint, targetID *uint) error {
+	res, err := ds.writer.ExecContext(ctx,
+		`UPDATE mdm_apple_configuration_profiles SET target_id = ? WHERE profile_id = ?`, targetID, profileID)
+	if err != nil {
+		if isChildForeignKeyError(err) {
+			return ctxerr.Wrap(ctx, notFound("MDMHostTarget").WithID(*targetID))
+		}
+		return ctxerr.Wrap(ctx, err, "set mdm apple config profile target")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// the profile may exist with the same target already, check it
+		if _, err := ds.GetMDMAppleConfigProfile(ctx, profileID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,421 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/test"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMDMHostTargets(t *testing.T) {
+	ds := CreateMySQLDS(t)
+
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, ds *Datastore)
+	}{
+		{"CRUD", testMDMHostTargetsCRUD},
+		{"ListHosts", testMDMHostTargetsListHosts},
+		{"Profiles", testMDMHostTargetsProfiles},
+		{"ProfileMacOSVersions", testMDMHostTargetsProfileMacOSVersions},
+		{"FileVaultKeyRotation", testMDMHostTargetsFileVaultKeyRotation},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer TruncateTables(t, ds)
+			c.fn(t, ds)
+		})
+	}
+}
+
+func testMDMHostTargetsCRUD(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	targets, err := ds.ListMDMHostTargets(ctx)
+	require.NoError(t, err)
+	require.Empty(t, targets)
+
+	m1, err := ds.NewMDMHostTarget(ctx, &fleet.MDMHostTarget{
+		Name:        "M1 Macs",
+		Description: "Apple silicon Macs on macOS < 14",
+		MDMHostTargetFilters: fleet.MDMHostTargetFilters{
+			TeamIDs:        []uint{0, 2},
+			Architecture:   fleet.MacOSArchitectureARM64,
+			OSVersionBelow: "14",
+		},
+	})
+	require.NoError(t, err)
+	require.NotZero(t, m1.ID)
+	require.Equal(t, "M1 Macs", m1.Name)
+	require.Equal(t, fleet.MDMHostTargetTeamIDs{0, 2}, m1.TeamIDs)
+	require.Equal(t, "14", m1.OSVersionBelow)
+	require.NotZero(t, m1.CreatedAt)
+
+	_, err = ds.NewMDMHostTarget(ctx, &fleet.MDMHostTarget{Name: "M1 Macs"})
+	require.Error(t, err)
+	var existsErr fleet.AlreadyExistsError
+	require.ErrorAs(t, err, &existsErr)
+
+	all, err := ds.NewMDMHostTarget(ctx, &fleet.MDMHostTarget{Name: "All"})
+	require.NoError(t, err)
+	require.Empty(t, all.TeamIDs)
+
+	got, err := ds.MDMHostTarget(ctx, m1.ID)
+	require.NoError(t, err)
+	require.Equal(t, m1, got)
+
+	targets, err = ds.ListMDMHostTargets(ctx)
+	require.NoError(t, err)
+	require.Len(t, targets, 2)
+	require.Equal(t, "All", targets[0].Name)
+	require.Equal(t, "M1 Macs", targets[1].Name)
+
+	// a target used by a profile can't be deleted
+	prof, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "N1", "I1", "a"))
+	require.NoError(t, err)
+	require.NoError(t, ds.SetMDMAppleConfigProfileTarget(ctx, prof.ProfileID, &m1.ID))
+	prof, err = ds.GetMDMAppleConfigProfile(ctx, prof.ProfileID)
+	require.NoError(t, err)
+	require.Equal(t, &m1.ID, prof.TargetID)
+
+	err = ds.DeleteMDMHostTarget(ctx, m1.ID)
+	require.Error(t, err)
+	require.True(t, fleet.IsForeignKey(err))
+
+	require.NoError(t, ds.SetMDMAppleConfigProfileTarget(ctx, prof.ProfileID, nil))
+	require.NoError(t, ds.DeleteMDMHostTarget(ctx, m1.ID))
+
+	_, err = ds.MDMHostTarget(ctx, m1.ID)
+	require.True(t, fleet.IsNotFound(err))
+	err = ds.DeleteMDMHostTarget(ctx, m1.ID)
+	require.True(t, fleet.IsNotFound(err))
+
+	// unknown target or profile
+	err = ds.SetMDMAppleConfigProfileTarget(ctx, prof.ProfileID, &m1.ID)
+	require.True(t, fleet.IsNotFound(err))
+	err = ds.SetMDMAppleConfigProfileTarget(ctx, prof.ProfileID+1, nil)
+	require.True(t, fleet.IsNotFound(err))
+}
+
+// createMDMHostTargetsTestHosts creates 4 macOS hosts enrolled in Fleet's MDM:
+// [0] Apple silicon on 13.4.1 with no team, [1] Apple silicon on 14.0 in the
+// team, [2] Intel on 12.6 in the team and [3] Intel on 13.0 with no team.
+func createMDMHostTargetsTestHosts(t *testing.T, ds *Datastore, tmID uint) []*fleet.Host {
+	ctx := context.Background()
+
+	specs := []struct {
+		cpuType   string
+		osVersion string
+		teamID    *uint
+	}{
+		{"arm64e", "macOS 13.4.1", nil},
+		{"arm64e", "macOS 14.0", &tmID},
+		{"x86_64h", "macOS 12.6", &tmID},
+		{"x86_64", "macOS 13.0", nil},
+	}
+	var hosts []*fleet.Host
+	for i, spec := range specs {
+		h := test.NewHost(t, ds, fmt.Sprintf("h%d.local", i), fmt.Sprintf("1.1.1.%d", i), fmt.Sprint(i), fmt.Sprint(i), time.Now())
+		nanoEnroll(t, ds, h, false)
+		ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+			_, err := q.ExecContext(ctx, `UPDATE hosts SET cpu_type = ?, os_version = ? WHERE id = ?`, spec.cpuType, spec.osVersion, h.ID)
+			return err
+		})
+		if spec.teamID != nil {
+			require.NoError(t, ds.AddHostsToTeam(ctx, spec.teamID, []uint{h.ID}))
+		}
+		hosts = append(hosts, h)
+	}
+	// a host not enrolled in Fleet's MDM never matches
+	test.NewHost(t, ds, "unenrolled.local", "1.1.1.9", "9", "9", time.Now())
+	return hosts
+}
+
+func testMDMHostTargetsListHosts(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "tm"})
+	require.NoError(t, err)
+	hosts := createMDMHostTargetsTestHosts(t, ds, tm.ID)
+
+	cases := []struct {
+		name    string
+		filters fleet.MDMHostTargetFilters
+		want    []int
+	}{
+		{"no filters", fleet.MDMHostTargetFilters{}, []int{0, 1, 2, 3}},
+		{"no team", fleet.MDMHostTargetFilters{TeamIDs: []uint{0}}, []int{0, 3}},
+		{"team", fleet.MDMHostTargetFilters{TeamIDs: []uint{tm.ID}}, []int{1, 2}},
+		{"team and no team", fleet.MDMHostTargetFilters{TeamIDs: []uint{0, tm.ID}}, []int{0, 1, 2, 3}},
+		{"arm64", fleet.MDMHostTargetFilters{Architecture: fleet.MacOSArchitectureARM64}, []int{0, 1}},
+		{"x86_64", fleet.MDMHostTargetFilters{Architecture: fleet.MacOSArchitectureX8664}, []int{2, 3}},
+		{"below 14", fleet.MDMHostTargetFilters{OSVersionBelow: "14"}, []int{0, 2, 3}},
+		{"below 13.0.1", fleet.MDMHostTargetFilters{OSVersionBelow: "13.0.1"}, []int{2, 3}},
+		{"at least 13", fleet.MDMHostTargetFilters{OSVersionAtLeast: "13"}, []int{0, 1, 3}},
+		{"13.x", fleet.MDMHostTargetFilters{OSVersionAtLeast: "13", OSVersionBelow: "14"}, []int{0, 3}},
+		{"arm64 below 14 in no team", fleet.MDMHostTargetFilters{
+			TeamIDs: []uint{0}, Architecture: fleet.MacOSArchitectureARM64, OSVersionBelow: "14",
+		}, []int{0}},
+		{"none", fleet.MDMHostTargetFilters{Architecture: fleet.MacOSArchitectureX8664, OSVersionAtLeast: "14"}, nil},
+	}
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			target, err := ds.NewMDMHostTarget(ctx, &fleet.MDMHostTarget{Name: fmt.Sprint(i), MDMHostTargetFilters: c.filters})
+			require.NoError(t, err)
+
+			got, err := ds.ListMDMHostTargetHosts(ctx, target.ID)
+			require.NoError(t, err)
+			gotIDs := make([]uint, 0, len(got))
+			for _, h := range got {
+				gotIDs = append(gotIDs, h.ID)
+			}
+			wantIDs := make([]uint, 0, len(c.want))
+			for _, i := range c.want {
+				wantIDs = append(wantIDs, hosts[i].ID)
+			}
+			require.Equal(t, wantIDs, gotIDs)
+		})
+	}
+
+	// the hosts are matched with their current data
+	target, err := ds.NewMDMHostTarget(ctx, &fleet.MDMHostTarget{
+		Name:                 "below 14",
+		MDMHostTargetFilters: fleet.MDMHostTargetFilters{OSVersionBelow: "14"},
+	})
+	require.NoError(t, err)
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `UPDATE hosts SET os_version = 'macOS 14.1' WHERE id = ?`, hosts[0].ID)
+		return err
+	})
+	got, err := ds.ListMDMHostTargetHosts(ctx, target.ID)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	require.Equal(t, hosts[2].ID, got[0].ID)
+	require.Equal(t, hosts[2].UUID, got[0].UUID)
+	require.Equal(t, &tm.ID, got[0].TeamID)
+	require.Equal(t, "h2.local", got[0].DisplayName)
+	require.Equal(t, hosts[3].ID, got[1].ID)
+	require.Nil(t, got[1].TeamID)
+}
+
+func testMDMHostTargetsProfiles(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "tm"})
+	require.NoError(t, err)
+	hosts := createMDMHostTargetsTestHosts(t, ds, tm.ID)
+
+	target, err := ds.NewMDMHostTarget(ctx, &fleet.MDMHostTarget{
+		Name:                 "arm64",
+		MDMHostTargetFilters: fleet.MDMHostTargetFilters{Architecture: fleet.MacOSArchitectureARM64},
+	})
+	require.NoError(t, err)
+
+	// a no team profile and a team profile
+	noTeamProf, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "N1", "I1", "a"))
+	require.NoError(t, err)
+	tmProf := configProfileForTest(t, "N2", "I2", "b")
+	tmProf.TeamID = &tm.ID
+	tmProf, err = ds.NewMDMAppleConfigProfile(ctx, *tmProf)
+	require.NoError(t, err)
+
+	profileHosts := func(profs []*fleet.MDMAppleProfilePayload) map[uint][]string {
+		m := make(map[uint][]string)
+		for _, p := range profs {
+			m[p.ProfileID] = append(m[p.ProfileID], p.HostUUID)
+		}
+		return m
+	}
+
+	toInstall, err := ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	got := profileHosts(toInstall)
+	require.ElementsMatch(t, []string{hosts[0].UUID, hosts[3].UUID}, got[noTeamProf.ProfileID])
+	require.ElementsMatch(t, []string{hosts[1].UUID, hosts[2].UUID}, got[tmProf.ProfileID])
+
+	// restrict both profiles to the Apple silicon hosts, which doesn't install
+	// the team profile on the no team hosts
+	require.NoError(t, ds.SetMDMAppleConfigProfileTarget(ctx, noTeamProf.ProfileID, &target.ID))
+	require.NoError(t, ds.SetMDMAppleConfigProfileTarget(ctx, tmProf.ProfileID, &target.ID))
+
+	toInstall, err = ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	got = profileHosts(toInstall)
+	require.Equal(t, []string{hosts[0].UUID}, got[noTeamProf.ProfileID])
+	require.Equal(t, []string{hosts[1].UUID}, got[tmProf.ProfileID])
+
+	// install the profiles, then the host [0] is replaced by an Intel host, the
+	// profile is removed from it
+	err = ds.BulkUpsertMDMAppleHostProfiles(ctx, []*fleet.MDMAppleBulkUpsertHostProfilePayload{
+		{
+			ProfileID: noTeamProf.ProfileID, ProfileIdentifier: noTeamProf.Identifier, ProfileName: noTeamProf.Name,
+			HostUUID: hosts[0].UUID, CommandUUID: "cmd1", OperationType: fleet.MDMAppleOperationTypeInstall,
+			Status: &fleet.MDMAppleDeliveryVerifying, Checksum: []byte("csum"),
+		},
+		{
+			ProfileID: tmProf.ProfileID, ProfileIdentifier: tmProf.Identifier, ProfileName: tmProf.Name,
+			HostUUID: hosts[1].UUID, CommandUUID: "cmd2", OperationType: fleet.MDMAppleOperationTypeInstall,
+			Status: &fleet.MDMAppleDeliveryVerifying, Checksum: []byte("csum"),
+		},
+	})
+	require.NoError(t, err)
+	toRemove, err := ds.ListMDMAppleProfilesToRemove(ctx)
+	require.NoError(t, err)
+	require.Empty(t, toRemove)
+
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `UPDATE hosts SET cpu_type = 'x86_64' WHERE id = ?`, hosts[0].ID)
+		return err
+	})
+	toRemove, err = ds.ListMDMAppleProfilesToRemove(ctx)
+	require.NoError(t, err)
+	require.Len(t, toRemove, 1)
+	require.Equal(t, noTeamProf.ProfileID, toRemove[0].ProfileID)
+	require.Equal(t, hosts[0].UUID, toRemove[0].HostUUID)
+
+	// removing the restriction installs the profile on all the hosts again
+	require.NoError(t, ds.SetMDMAppleConfigProfileTarget(ctx, noTeamProf.ProfileID, nil))
+	toInstall, err = ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	got = profileHosts(toInstall)
+	require.Equal(t, []string{hosts[3].UUID}, got[noTeamProf.ProfileID])
+	toRemove, err = ds.ListMDMAppleProfilesToRemove(ctx)
+	require.NoError(t, err)
+	require.Empty(t, toRemove)
+
+	// the team's hosts pending install via the bulk set also follow the target
+	err = ds.BulkSetPendingMDMAppleHostProfiles(ctx, nil, nil, []uint{tmProf.ProfileID}, nil)
+	require.NoError(t, err)
+	var pending []string
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		return sqlx.SelectContext(ctx, q, &pending, `SELECT host_uuid FROM host_mdm_apple_profiles WHERE profile_id = ?`, tmProf.ProfileID)
+	})
+	require.Equal(t, []string{hosts[1].UUID}, pending)
+}
+
+func testMDMHostTargetsProfileMacOSVersions(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "tm"})
+	require.NoError(t, err)
+	hosts := createMDMHostTargetsTestHosts(t, ds, tm.ID)
+
+	noTeamProf, err := ds.NewMDMAppleConfigProfile(ctx, *configProfileForTest(t, "N1", "I1", "a"))
+	require.NoError(t, err)
+	tmProf := configProfileForTest(t, "N2", "I2", "b")
+	tmProf.TeamID = &tm.ID
+	tmProf, err = ds.NewMDMAppleConfigProfile(ctx, *tmProf)
+	require.NoError(t, err)
+
+	profileHosts := func(profs []*fleet.MDMAppleProfilePayload) map[uint][]string {
+		m := make(map[uint][]string)
+		for _, p := range profs {
+			m[p.ProfileID] = append(m[p.ProfileID], p.HostUUID)
+		}
+		return m
+	}
+
+	// the no team profile requires 13.1 or later, the team profile requires
+	// 13.x or older, the max version is inclusive at its own precision
+	require.NoError(t, ds.SetMDMAppleConfigProfileMacOSVersions(ctx, noTeamProf.ProfileID, "13.1", ""))
+	require.NoError(t, ds.SetMDMAppleConfigProfileMacOSVersions(ctx, tmProf.ProfileID, "", "13"))
+	prof, err := ds.GetMDMAppleConfigProfile(ctx, tmProf.ProfileID)
+	require.NoError(t, err)
+	require.Empty(t, prof.MinMacOSVersion)
+	require.Equal(t, "13", prof.MaxMacOSVersion)
+
+	toInstall, err := ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	got := profileHosts(toInstall)
+	require.Equal(t, []string{hosts[0].UUID}, got[noTeamProf.ProfileID])
+	require.Equal(t, []string{hosts[2].UUID}, got[tmProf.ProfileID])
+
+	require.NoError(t, ds.SetMDMAppleConfigProfileMacOSVersions(ctx, tmProf.ProfileID, "12.6", "14"))
+	toInstall, err = ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	got = profileHosts(toInstall)
+	require.ElementsMatch(t, []string{hosts[1].UUID, hosts[2].UUID}, got[tmProf.ProfileID])
+
+	// the hosts outside of the range report the profile as not applicable
+	profs, err := ds.GetHostMDMProfiles(ctx, hosts[3].UUID)
+	require.NoError(t, err)
+	require.Len(t, profs, 1)
+	require.Equal(t, noTeamProf.ProfileID, profs[0].ProfileID)
+	require.Equal(t, &fleet.MDMAppleDeliveryNotApplicable, profs[0].Status)
+	require.Equal(t, fleet.MDMAppleProfileOriginGlobal, profs[0].Origin)
+	profs, err = ds.GetHostMDMProfiles(ctx, hosts[1].UUID)
+	require.NoError(t, err)
+	require.Empty(t, profs)
+
+	// install the profile on host [0], then the host is downgraded, the
+	// profile is removed from it and it is not applicable anymore
+	err = ds.BulkUpsertMDMAppleHostProfiles(ctx, []*fleet.MDMAppleBulkUpsertHostProfilePayload{{
+		ProfileID: noTeamProf.ProfileID, ProfileIdentifier: noTeamProf.Identifier, ProfileName: noTeamProf.Name,
+		HostUUID: hosts[0].UUID, CommandUUID: "cmd1", OperationType: fleet.MDMAppleOperationTypeInstall,
+		Status: &fleet.MDMAppleDeliveryVerifying, Checksum: []byte("csum"),
+	}})
+	require.NoError(t, err)
+	toRemove, err := ds.ListMDMAppleProfilesToRemove(ctx)
+	require.NoError(t, err)
+	require.Empty(t, toRemove)
+
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `UPDATE hosts SET os_version = 'macOS 13.0.1' WHERE id = ?`, hosts[0].ID)
+		return err
+	})
+	toRemove, err = ds.ListMDMAppleProfilesToRemove(ctx)
+	require.NoError(t, err)
+	require.Len(t, toRemove, 1)
+	require.Equal(t, noTeamProf.ProfileID, toRemove[0].ProfileID)
+	require.Equal(t, hosts[0].UUID, toRemove[0].HostUUID)
+
+	// removing the range installs the profile on all the hosts again
+	require.NoError(t, ds.SetMDMAppleConfigProfileMacOSVersions(ctx, noTeamProf.ProfileID, "", ""))
+	toInstall, err = ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	got = profileHosts(toInstall)
+	require.Equal(t, []string{hosts[3].UUID}, got[noTeamProf.ProfileID])
+
+	// unknown profile
+	err = ds.SetMDMAppleConfigProfileMacOSVersions(ctx, tmProf.ProfileID+100, "", "")
+	require.True(t, fleet.IsNotFound(err))
+}
+
+func testMDMHostTargetsFileVaultKeyRotation(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "tm"})
+	require.NoError(t, err)
+	hosts := createMDMHostTargetsTestHosts(t, ds, tm.ID)
+
+	target, err := ds.NewMDMHostTarget(ctx, &fleet.MDMHostTarget{
+		Name:                 "below 14",
+		MDMHostTargetFilters: fleet.MDMHostTargetFilters{OSVersionBelow: "14"},
+	})
+	require.NoError(t, err)
+
+	n, err := ds.RequestMDMAppleFileVaultKeyRotationForTarget(ctx, target.ID)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// hosts [0], [1] and [2] escrowed a key
+	for _, h := range hosts[:3] {
+		require.NoError(t, ds.SetOrUpdateHostDiskEncryptionKey(ctx, h.ID, "key"))
+	}
+	require.NoError(t, ds.SetHostsDiskEncryptionKeyStatus(ctx, []uint{hosts[0].ID, hosts[1].ID, hosts[2].ID}, true, time.Now()))
+
+	n, err = ds.RequestMDMAppleFileVaultKeyRotationForTarget(ctx, target.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	var flagged []uint
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		return sqlx.SelectContext(ctx, q, &flagged, `SELECT host_id FROM host_disk_encryption_key_rotations ORDER BY host_id`)
+	})
+	require.Equal(t, []uint{hosts[0].ID, hosts[2].ID}, flagged)
+}
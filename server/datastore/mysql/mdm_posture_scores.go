@@ -0,0 +1,106 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+)
+
+func (ds *Datastore) GetMDMPostureScoreHostCounts(ctx context.Context, teamID *uint) (*fleet.MDMPostureScoreHostCounts, error) {
+	args := []interface{}{fleet.WellKnownMDMFleet}
+	teamFilter := "h.team_id IS NULL"
+	if teamID != nil && *teamID > 0 {
+		teamFilter = "h.team_id = ?"
+		args = append(args, *teamID)
+	}
+
+	stmt := fmt.Sprintf(`
+SELECT
+    COUNT(*) AS macos_hosts,
+    COUNT(CASE WHEN hm.enrolled = 1 AND mdms.name = ? THEN 1 END) AS enrolled_hosts
+FROM
+    hosts h
+    LEFT JOIN host_mdm hm ON hm.host_id = h.id
+    LEFT JOIN mobile_device_management_solutions mdms ON mdms.id = hm.mdm_id
+WHERE
+    h.platform = 'darwin' AND %s`, teamFilter)
+
+	var res fleet.MDMPostureScoreHostCounts
+	if err := sqlx.GetContext(ctx, ds.reader, &res, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "count hosts for mdm posture score")
+	}
+	return &res, nil
+}
+
+func (ds *Datastore) SaveMDMPostureScores(ctx context.Context, scores []*fleet.MDMPostureScore) error {
+	if len(scores) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	args := make([]interface{}, 0, len(scores)*5)
+	for _, s := range scores {
+		var teamID uint
+		if s.TeamID != nil {
+			teamID = *s.TeamID
+		}
+		args = append(args, teamID, s.TeamID == nil, s.Date.UTC().Format("2006-01-02"), s.Score, s.Components)
+		sb.WriteString("(?, ?, ?, ?, ?),")
+	}
+
+	stmt := fmt.Sprintf(`
+INSERT INTO mdm_posture_scores
+    (team_id, global_stats, score_date, score, components)
+VALUES
+    %s
+ON DUPLICATE KEY UPDATE
+    score = VALUES(score),
+    components = VALUES(components),
+    updated_at = CURRENT_TIMESTAMP`, strings.TrimSuffix(sb.String(), ","))
+
+	if _, err := ds.writer.ExecContext(ctx, stmt, args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "save mdm posture scores")
+	}
+	return nil
+}
+
+func (ds *Datastore) ListMDMPostureScores(ctx context.Context, teamID *uint, since time.Time) ([]*fleet.MDMPostureScore, error) {
+	stmt := `
+SELECT
+    IF(global_stats = 1, NULL, team_id) AS team_id,
+    score_date,
+    score,
+    components,
+    updated_at
+FROM
+    mdm_posture_scores
+WHERE
+    team_id = ? AND global_stats = ? AND score_date >= ?
+ORDER BY
+    score_date`
+
+	var tmID uint
+	if teamID != nil {
+		tmID = *teamID
+	}
+	var scores []*fleet.MDMPostureScore
+	if err := sqlx.SelectContext(ctx, ds.reader, &scores, stmt, tmID, teamID == nil, since.UTC().Format("2006-01-02")); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list mdm posture scores")
+	}
+	return scores, nil
+}
+
+func (ds *Datastore) CleanupMDMPostureScores(ctx context.Context, now time.Time) (int64, error) {
+	res, err := ds.writer.ExecContext(ctx, `DELETE FROM mdm_posture_scores WHERE score_date < ?`,
+		now.Add(-fleet.MDMPostureScoreHistoryRetention).UTC().Format("2006-01-02"))
+	if err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "cleanup mdm posture scores")
+	}
+	deleted, _ := res.RowsAffected()
+	return deleted, nil
+}
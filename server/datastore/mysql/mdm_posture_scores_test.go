@@ -0,0 +1,166 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMDMPostureScores(t *testing.T) {
+	ds := CreateMySQLDS(t)
+
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, ds *Datastore)
+	}{
+		{"HostCounts", testMDMPostureScoreHostCounts},
+		{"SaveAndList", testMDMPostureScoresSaveAndList},
+		{"Cleanup", testMDMPostureScoresCleanup},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer TruncateTables(t, ds)
+			c.fn(t, ds)
+		})
+	}
+}
+
+func testMDMPostureScoreHostCounts(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+
+	newHost := func(name, platform string, teamID *uint) *fleet.Host {
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:        name,
+			OsqueryHostID:   ptr.String(name),
+			NodeKey:         ptr.String(name),
+			UUID:            name + "-uuid",
+			Platform:        platform,
+			TeamID:          teamID,
+			DetailUpdatedAt: time.Now(),
+			LabelUpdatedAt:  time.Now(),
+			PolicyUpdatedAt: time.Now(),
+			SeenTime:        time.Now(),
+		})
+		require.NoError(t, err)
+		return h
+	}
+
+	h1 := newHost("h1", "darwin", nil)
+	h2 := newHost("h2", "darwin", nil)
+	newHost("h3", "darwin", nil)
+	newHost("h4", "windows", nil)
+	h5 := newHost("h5", "darwin", &tm.ID)
+
+	require.NoError(t, ds.SetOrUpdateMDMData(ctx, h1.ID, false, true, "https://fleetdm.com", false, fleet.WellKnownMDMFleet))
+	require.NoError(t, ds.SetOrUpdateMDMData(ctx, h2.ID, false, true, "https://simplemdm.com", false, "SimpleMDM"))
+	require.NoError(t, ds.SetOrUpdateMDMData(ctx, h5.ID, false, true, "https://fleetdm.com", false, fleet.WellKnownMDMFleet))
+
+	counts, err := ds.GetMDMPostureScoreHostCounts(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint(3), counts.MacOSHosts)
+	require.Equal(t, uint(1), counts.EnrolledHosts)
+
+	counts, err = ds.GetMDMPostureScoreHostCounts(ctx, &tm.ID)
+	require.NoError(t, err)
+	require.Equal(t, uint(1), counts.MacOSHosts)
+	require.Equal(t, uint(1), counts.EnrolledHosts)
+
+	counts, err = ds.GetMDMPostureScoreHostCounts(ctx, ptr.Uint(tm.ID+1))
+	require.NoError(t, err)
+	require.Zero(t, counts.MacOSHosts)
+	require.Zero(t, counts.EnrolledHosts)
+}
+
+func testMDMPostureScoresSaveAndList(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+
+	day1 := time.Date(2023, 7, 9, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	components := fleet.MDMPostureScoreComponents{
+		{Name: fleet.MDMPostureScoreEnrollment, Weight: 25, Hosts: 4, CompliantHosts: 3, Score: ptr.Float64(75)},
+	}
+
+	err = ds.SaveMDMPostureScores(ctx, []*fleet.MDMPostureScore{
+		{TeamID: nil, Date: day1, Score: ptr.Float64(50), Components: components},
+		{TeamID: ptr.Uint(0), Date: day1, Score: nil},
+		{TeamID: &tm.ID, Date: day1, Score: ptr.Float64(75), Components: components},
+	})
+	require.NoError(t, err)
+
+	// the scores of the day are replaced
+	err = ds.SaveMDMPostureScores(ctx, []*fleet.MDMPostureScore{
+		{TeamID: nil, Date: day1.Add(time.Hour), Score: ptr.Float64(60), Components: components},
+		{TeamID: nil, Date: day2, Score: ptr.Float64(70)},
+	})
+	require.NoError(t, err)
+
+	scores, err := ds.ListMDMPostureScores(ctx, nil, day1)
+	require.NoError(t, err)
+	require.Len(t, scores, 2)
+	require.Nil(t, scores[0].TeamID)
+	require.Equal(t, day1, scores[0].Date.UTC())
+	require.Equal(t, ptr.Float64(60), scores[0].Score)
+	require.Equal(t, components, scores[0].Components)
+	require.Equal(t, day2, scores[1].Date.UTC())
+	require.Equal(t, ptr.Float64(70), scores[1].Score)
+	require.Empty(t, scores[1].Components)
+
+	scores, err = ds.ListMDMPostureScores(ctx, nil, day2)
+	require.NoError(t, err)
+	require.Len(t, scores, 1)
+	require.Equal(t, day2, scores[0].Date.UTC())
+
+	scores, err = ds.ListMDMPostureScores(ctx, ptr.Uint(0), day1)
+	require.NoError(t, err)
+	require.Len(t, scores, 1)
+	require.Equal(t, ptr.Uint(0), scores[0].TeamID)
+	require.Nil(t, scores[0].Score)
+
+	scores, err = ds.ListMDMPostureScores(ctx, &tm.ID, day1)
+	require.NoError(t, err)
+	require.Len(t, scores, 1)
+	require.Equal(t, &tm.ID, scores[0].TeamID)
+	require.Equal(t, ptr.Float64(75), scores[0].Score)
+
+	// the scores of a team are deleted with the team
+	require.NoError(t, ds.DeleteTeam(ctx, tm.ID))
+	scores, err = ds.ListMDMPostureScores(ctx, &tm.ID, day1)
+	require.NoError(t, err)
+	require.Empty(t, scores)
+	scores, err = ds.ListMDMPostureScores(ctx, nil, day1)
+	require.NoError(t, err)
+	require.Len(t, scores, 2)
+}
+
+func testMDMPostureScoresCleanup(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	var scores []*fleet.MDMPostureScore
+	for _, days := range []int{0, 10, 364, 366, 400} {
+		scores = append(scores, &fleet.MDMPostureScore{Date: now.AddDate(0, 0, -days), Score: ptr.Float64(float64(days))})
+	}
+	require.NoError(t, ds.SaveMDMPostureScores(ctx, scores))
+
+	deleted, err := ds.CleanupMDMPostureScores(ctx, now)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+
+	got, err := ds.ListMDMPostureScores(ctx, nil, now.AddDate(-2, 0, 0))
+	require.NoError(t, err)
+	require.Len(t, got, 3)
+	for i, days := range []int{364, 10, 0} {
+		require.Equal(t, ptr.Float64(float64(days)), got[i].Score, fmt.Sprint(i))
+	}
+}
@@ -0,0 +1,128 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+)
+
+func (ds *Datastore) MDMWindowsInsertEnrolledDevice(ctx context.Context, device *fleet.MDMWindowsEnrolledDevice) error {
+	stmt := `
+INSERT INTO mdm_windows_enrollments (
+    mdm_device_id,
+    mdm_hardware_id,
+    device_state,
+    device_type,
+    device_name,
+    enroll_type,
+    enroll_user_id,
+    enroll_proto_version,
+    enroll_client_version,
+    not_in_oobe,
+    identity_cert_serial,
+    team_id,
+    host_uuid'
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE
+    mdm_hardware_id = VALUES(mdm_hardware_id),
+    device_state = VALUES(device_state),
+    device_type = VALUES(device_type),
+    device_name = VALUES(device_name),
+    enroll_type = VALUES(enroll_type),
+    enroll_user_id = VALUES(enroll_user_id),
+    enroll_proto_version = VALUES(enroll_proto_version),
+    enroll_client_version = VALUES(enroll_client_version),
+    not_in_oobe = VALUES(not_in_oobe),
+    identity_cert_serial = VALUES(identity_cert_serial),
+    team_id = VALUES(team_id),
+    host_uuid = VALUES(host_uuid)`
+
+	_, err := ds.writer.ExecContext(ctx, stmt,
+		device.MDMDeviceID,
+		device.MDMHardwareID,
+		device.MDMDeviceState,
+		device.MDMDeviceType,
+		device.MDMDeviceName,
+		device.MDMEnrollType,
+		device.MDMEnrollUserID,
+		device.MDMEnrollProtoVersion,
+		device.MDMEnrollClientVersion,
+		device.MDMNotInOOBE,
+		device.MDMIdentityCertSerial,
+		device.TeamID,
+		device.HostUUID,
+	)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "insert windows enrolled device")
+	}
+	return nil
+}
+
+func (ds *Datastore) MDMWindowsGetEnrolledDevice(ctx context.Context, mdmDeviceID string) (*fleet.MDMWindowsEnrolledDevice, error) {
+	stmt := `
+SELECT
+    id,
+    mdm_device_id,
+    mdm_hardware_id,
+    device_state,
+    device_type,
+    device_name,
+    enroll_type,
+    enroll_user_id,
+    enroll_proto_version,
+    enroll_client_version,
+    not_in_oobe,
+    identity_cert_serial,
+    team_id,
+    host_uuid,
+    created_at,
+    updated_at
+FROM
+    mdm_windows_enrollments
+WHERE
+    mdm_device_id = ?`
+
+	// use the primary, a device starts its first management session right
+	// after its enrollment.
+	var device fleet.MDMWindowsEnrolledDevice
+	if err := sqlx.GetContext(ctx, ds.writer, &device, stmt, mdmDeviceID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("MDMWindowsEnrolledDevice").WithName(mdmDeviceID))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get windows enrolled device")
+	}
+	return &device, nil
+}
+
+func (ds *Datastore) MDMWindowsDeleteEnrolledDevice(ctx context.Context, mdmDeviceID string) error {
+	res, err := ds.writer.ExecContext(ctx, `DELETE FROM mdm_windows_enrollments WHERE mdm_device_id = ?`, mdmDeviceID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "delete windows enrolled device")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ctxerr.Wrap(ctx, notFound("MDMWindowsEnrolledDevice").WithName(mdmDeviceID))
+	}
+	return nil
+}
+
+func (ds *Datastore) MDMWindowsLinkEnrolledDeviceToHost(ctx context.Context, mdmDeviceID, hostUUID string) error {
+	// the device is only linked if it is not linked yet, a device that
+	// enrolls again is unlinked until its host reports it.
+	stmt := `
+UPDATE
+    mdm_windows_enrollments
+SET
+    host_uuid = ?
+WHERE
+    mdm_device_id = ? AND
+    host_uuid = ''`
+
+	if _, err := ds.writer.ExecContext(ctx, stmt, hostUUID, mdmDeviceID); err != nil {
+		return ctxerr.Wrap(ctx, err, "link windows enrolled device to host")
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMicrosoftMDM(t *testing.T) {
+	ds := CreateMySQLDS(t)
+
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, ds *Datastore)
+	}{
+		{"EnrolledDevices", testMDMWindowsEnrolledDevices},
+		{"LinkEnrolledDeviceToHost", testMDMWindowsLinkEnrolledDeviceToHost},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer TruncateTables(t, ds)
+			c.fn(t, ds)
+		})
+	}
+}
+
+func testMDMWindowsEnrolledDevices(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	tm, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+
+	_, err = ds.MDMWindowsGetEnrolledDevice(ctx, "device1")
+	require.True(t, fleet.IsNotFound(err))
+	err = ds.MDMWindowsDeleteEnrolledDevice(ctx, "device1")
+	require.True(t, fleet.IsNotFound(err))
+
+	device := &fleet.MDMWindowsEnrolledDevice{
+		MDMDeviceID:            "device1",
+		MDMHardwareID:          "hw1",
+		MDMDeviceState:         fleet.MDMDeviceStateEnrolled,
+		MDMDeviceType:          "CIMClient_Windows",
+		MDMDeviceName:          "DESKTOP-1",
+		MDMEnrollType:          "Full",
+		MDMEnrollUserID:        "user@example.com",
+		MDMEnrollProtoVersion:  "4.0",
+		MDMEnrollClientVersion: "10.0.19045.2965",
+		MDMNotInOOBE:           true,
+		MDMIdentityCertSerial:  "abc",
+		HostUUID:               "host-uuid",
+	}
+	require.NoError(t, ds.MDMWindowsInsertEnrolledDevice(ctx, device))
+
+	got, err := ds.MDMWindowsGetEnrolledDevice(ctx, "device1")
+	require.NoError(t, err)
+	require.NotZero(t, got.ID)
+	require.NotZero(t, got.CreatedAt)
+	require.Nil(t, got.TeamID)
+	device.ID, device.CreatedAt, device.UpdatedAt = got.ID, got.CreatedAt, got.UpdatedAt
+	require.Equal(t, device, got)
+
+	// enrolling the device again replaces it
+	device.MDMIdentityCertSerial = "def"
+	device.TeamID = ptr.Uint(tm.ID)
+	require.NoError(t, ds.MDMWindowsInsertEnrolledDevice(ctx, device))
+	got, err = ds.MDMWindowsGetEnrolledDevice(ctx, "device1")
+	require.NoError(t, err)
+	require.Equal(t, device.ID, got.ID)
+	require.Equal(t, "def", got.MDMIdentityCertSerial)
+	require.Equal(t, ptr.Uint(tm.ID), got.TeamID)
+
+	require.NoError(t, ds.MDMWindowsDeleteEnrolledDevice(ctx, "device1"))
+	_, err = ds.MDMWindowsGetEnrolledDevice(ctx, "device1")
+	require.True(t, fleet.IsNotFound(err))
+}
+
+func testMDMWindowsLinkEnrolledDeviceToHost(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	// no enrolled device with that ID
+	require.NoError(t, ds.MDMWindowsLinkEnrolledDeviceToHost(ctx, "device1", "host1-uuid"))
+
+	device := &fleet.MDMWindowsEnrolledDevice{
+		MDMDeviceID:    "device1",
+		MDMHardwareID:  "hw1",
+		MDMDeviceState: fleet.MDMDeviceStateEnrolled,
+		MDMDeviceName:  "DESKTOP-1",
+	}
+	require.NoError(t, ds.MDMWindowsInsertEnrolledDevice(ctx, device))
+	got, err := ds.MDMWindowsGetEnrolledDevice(ctx, "device1")
+	require.NoError(t, err)
+	require.Empty(t, got.HostUUID)
+
+	require.NoError(t, ds.MDMWindowsLinkEnrolledDeviceToHost(ctx, "device1", "host1-uuid"))
+	got, err = ds.MDMWindowsGetEnrolledDevice(ctx, "device1")
+	require.NoError(t, err)
+	require.Equal(t, "host1-uuid", got.HostUUID)
+
+	// a linked device is not linked to another host
+	require.NoError(t, ds.MDMWindowsLinkEnrolledDeviceToHost(ctx, "device1", "host2-uuid"))
+	got, err = ds.MDMWindowsGetEnrolledDevice(ctx, "device1")
+	require.NoError(t, err)
+	require.Equal(t, "host1-uuid", got.HostUUID)
+
+	// enrolling the device again unlinks it until its host reports it
+	require.NoError(t, ds.MDMWindowsInsertEnrolledDevice(ctx, device))
+	got, err = ds.MDMWindowsGetEnrolledDevice(ctx, "device1")
+	require.NoError(t, err)
+	require.Empty(t, got.HostUUID)
+	require.NoError(t, ds.MDMWindowsLinkEnrolledDeviceToHost(ctx, "device1", "host2-uuid"))
+	got, err = ds.MDMWindowsGetEnrolledDevice(ctx, "device1")
+	require.NoError(t, err)
+	require.Equal(t, "host2-uuid", got.HostUUID)
+}
@@ -0,0 +1,58 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230505153421, Down_20230505153421)
+}
+
+func Up_20230505153421(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+          CREATE TABLE mdm_apple_bootstrap_package_uploads (
+            id             int(10) unsigned NOT NULL AUTO_INCREMENT,
+            token          varchar(36) NOT NULL,
+            team_id        int(10) unsigned NOT NULL,
+            name           varchar(255) NOT NULL,
+            size           bigint(20) NOT NULL,
+            sha256         BINARY(32) NOT NULL,
+            received_bytes bigint(20) NOT NULL DEFAULT 0,
+            chunks         int(10) unsigned NOT NULL DEFAULT 0,
+            created_at     timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            updated_at     timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+            PRIMARY KEY (id),
+            UNIQUE KEY idx_mdm_apple_bootstrap_package_uploads_token (token)
+          )`)
+	if err != nil {
+		return errors.Wrap(err, "create mdm_apple_bootstrap_package_uploads")
+	}
+
+	_, err = tx.Exec(`
+          CREATE TABLE mdm_apple_bootstrap_package_upload_chunks (
+            upload_id   int(10) unsigned NOT NULL,
+            chunk_index int(10) unsigned NOT NULL,
+            bytes       longblob NOT NULL,
+
+            PRIMARY KEY (upload_id, chunk_index),
+            FOREIGN KEY (upload_id) REFERENCES mdm_apple_bootstrap_package_uploads (id) ON DELETE CASCADE
+          )`)
+	if err != nil {
+		return errors.Wrap(err, "create mdm_apple_bootstrap_package_upload_chunks")
+	}
+
+	// upload_id is set for packages uploaded via a resumable upload session,
+	// in which case the bytes column is NULL and the package contents are
+	// stored in the upload's chunks.
+	_, err = tx.Exec(`
+          ALTER TABLE mdm_apple_bootstrap_packages
+          ADD COLUMN upload_id int(10) unsigned NULL DEFAULT NULL`)
+	return errors.Wrap(err, "add upload_id to mdm_apple_bootstrap_packages")
+}
+
+func Down_20230505153421(tx *sql.Tx) error {
+	return nil
+}
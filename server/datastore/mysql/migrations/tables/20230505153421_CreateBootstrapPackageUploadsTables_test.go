@@ -0,0 +1,41 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230505153421(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`INSERT INTO mdm_apple_bootstrap_packages (team_id, name, sha256, bytes, token) VALUES (?, ?, ?, ?, ?)`,
+		0, "pkg.pkg", make([]byte, 32), []byte("abc"), "token")
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	// existing packages have no upload
+	var uploadID *uint
+	err = db.Get(&uploadID, `SELECT upload_id FROM mdm_apple_bootstrap_packages WHERE team_id = 0`)
+	require.NoError(t, err)
+	require.Nil(t, uploadID)
+
+	r, err := db.Exec(`INSERT INTO mdm_apple_bootstrap_package_uploads (token, team_id, name, size, sha256) VALUES (?, ?, ?, ?, ?)`,
+		"upload-token", 0, "pkg.pkg", 6, make([]byte, 32))
+	require.NoError(t, err)
+	id, _ := r.LastInsertId()
+
+	_, err = db.Exec(`INSERT INTO mdm_apple_bootstrap_package_upload_chunks (upload_id, chunk_index, bytes) VALUES (?, 0, ?), (?, 1, ?)`,
+		id, []byte("abc"), id, []byte("def"))
+	require.NoError(t, err)
+
+	// deleting the upload deletes its chunks
+	_, err = db.Exec(`DELETE FROM mdm_apple_bootstrap_package_uploads WHERE id = ?`, id)
+	require.NoError(t, err)
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM mdm_apple_bootstrap_package_upload_chunks`)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
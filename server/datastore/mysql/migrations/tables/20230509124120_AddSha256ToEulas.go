@@ -0,0 +1,24 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230509124120, Down_20230509124120)
+}
+
+func Up_20230509124120(tx *sql.Tx) error {
+	// the checksum is used by fleetctl apply to skip re-uploading an unchanged
+	// EULA, it is NULL for EULAs uploaded before this migration.
+	_, err := tx.Exec(`
+ALTER TABLE eulas ADD COLUMN sha256 BINARY(32) NULL;
+`)
+	return errors.Wrap(err, "add sha256 to eulas")
+}
+
+func Down_20230509124120(tx *sql.Tx) error {
+	return nil
+}
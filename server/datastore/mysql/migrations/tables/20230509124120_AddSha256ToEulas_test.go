@@ -0,0 +1,30 @@
+package tables
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230509124120(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`INSERT INTO eulas (id, token, name, bytes) VALUES (1, 'abc', 'eula.pdf', 'content')`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var checksum []byte
+	err = db.Get(&checksum, `SELECT sha256 FROM eulas WHERE id = 1`)
+	require.NoError(t, err)
+	require.Nil(t, checksum)
+
+	sum := sha256.Sum256([]byte("content"))
+	_, err = db.Exec(`UPDATE eulas SET sha256 = ? WHERE id = 1`, sum[:])
+	require.NoError(t, err)
+	err = db.Get(&checksum, `SELECT sha256 FROM eulas WHERE id = 1`)
+	require.NoError(t, err)
+	require.Equal(t, sum[:], checksum)
+}
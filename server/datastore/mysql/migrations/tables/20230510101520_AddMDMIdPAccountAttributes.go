@@ -0,0 +1,38 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230510101520, Down_20230510101520)
+}
+
+func Up_20230510101520(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE mdm_idp_accounts
+  ADD COLUMN fullname varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  ADD COLUMN idp_groups json DEFAULT NULL
+`)
+	if err != nil {
+		return errors.Wrap(err, "add attributes to mdm_idp_accounts")
+	}
+
+	_, err = tx.Exec(`
+CREATE TABLE host_mdm_idp_accounts (
+  host_uuid    varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  account_uuid varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  created_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (host_uuid)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+`)
+	return errors.Wrap(err, "create host_mdm_idp_accounts table")
+}
+
+func Down_20230510101520(tx *sql.Tx) error {
+	return nil
+}
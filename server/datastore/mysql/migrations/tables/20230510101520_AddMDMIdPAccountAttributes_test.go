@@ -0,0 +1,33 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230510101520(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`
+    INSERT INTO mdm_idp_accounts (uuid, username, salt, entropy, iterations)
+    VALUES ('abc', 'test@example.com', 'salt', 'entropy', 10000)`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var acc struct {
+		FullName string  `db:"fullname"`
+		Groups   *string `db:"idp_groups"`
+	}
+	err = db.Get(&acc, "SELECT fullname, idp_groups FROM mdm_idp_accounts WHERE uuid = 'abc'")
+	require.NoError(t, err)
+	require.Empty(t, acc.FullName)
+	require.Nil(t, acc.Groups)
+
+	_, err = db.Exec(`INSERT INTO host_mdm_idp_accounts (host_uuid, account_uuid) VALUES ('host', 'abc')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO host_mdm_idp_accounts (host_uuid, account_uuid) VALUES ('host', 'def')`)
+	require.ErrorContains(t, err, "Duplicate entry")
+}
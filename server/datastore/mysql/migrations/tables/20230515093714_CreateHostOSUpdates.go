@@ -0,0 +1,30 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230515093714, Down_20230515093714)
+}
+
+func Up_20230515093714(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+CREATE TABLE host_os_updates (
+  host_id         int(10) unsigned NOT NULL,
+  os_version      varchar(50) COLLATE utf8mb4_unicode_ci NOT NULL,
+  pending_updates int(10) unsigned NOT NULL DEFAULT 0,
+  created_at      timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at      timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (host_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+`)
+	return errors.Wrap(err, "create host_os_updates table")
+}
+
+func Down_20230515093714(tx *sql.Tx) error {
+	return nil
+}
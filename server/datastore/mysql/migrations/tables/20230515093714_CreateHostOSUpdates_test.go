@@ -0,0 +1,24 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230515093714(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO host_os_updates (host_id, os_version) VALUES (1, '13.3.1')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO host_os_updates (host_id, os_version, pending_updates) VALUES (1, '13.4', 2)`)
+	require.ErrorContains(t, err, "Duplicate entry")
+
+	var pending uint
+	err = db.Get(&pending, `SELECT pending_updates FROM host_os_updates WHERE host_id = 1`)
+	require.NoError(t, err)
+	require.Zero(t, pending)
+}
@@ -0,0 +1,48 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230516101522, Down_20230516101522)
+}
+
+func Up_20230516101522(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+CREATE TABLE mdm_apple_enrollment_links (
+  id          int(10) unsigned NOT NULL AUTO_INCREMENT,
+  token       varchar(64) COLLATE utf8mb4_unicode_ci NOT NULL,
+  team_id     int(10) unsigned DEFAULT NULL,
+  max_uses    int(10) unsigned NOT NULL,
+  uses_count  int(10) unsigned NOT NULL DEFAULT 0,
+  expires_at  timestamp NOT NULL,
+  created_at  timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (id),
+  UNIQUE KEY idx_mdm_apple_enrollment_links_token (token),
+  FOREIGN KEY (team_id) REFERENCES teams (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+`)
+	if err != nil {
+		return errors.Wrap(err, "create mdm_apple_enrollment_links table")
+	}
+
+	_, err = tx.Exec(`
+CREATE TABLE mdm_apple_enrollment_link_uses (
+  link_id    int(10) unsigned NOT NULL,
+  host_uuid  varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (link_id, host_uuid),
+  FOREIGN KEY (link_id) REFERENCES mdm_apple_enrollment_links (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+`)
+	return errors.Wrap(err, "create mdm_apple_enrollment_link_uses table")
+}
+
+func Down_20230516101522(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,32 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230516101522(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	res, err := db.Exec(`INSERT INTO mdm_apple_enrollment_links (token, max_uses, expires_at) VALUES ('abc', 2, NOW() + INTERVAL 1 HOUR)`)
+	require.NoError(t, err)
+	linkID, err := res.LastInsertId()
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO mdm_apple_enrollment_links (token, max_uses, expires_at) VALUES ('abc', 1, NOW())`)
+	require.ErrorContains(t, err, "Duplicate entry")
+
+	_, err = db.Exec(`INSERT INTO mdm_apple_enrollment_link_uses (link_id, host_uuid) VALUES (?, 'uuid-1')`, linkID)
+	require.NoError(t, err)
+
+	// deleting the link deletes its uses
+	_, err = db.Exec(`DELETE FROM mdm_apple_enrollment_links WHERE id = ?`, linkID)
+	require.NoError(t, err)
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM mdm_apple_enrollment_link_uses`)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
@@ -0,0 +1,29 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230517083012, Down_20230517083012)
+}
+
+func Up_20230517083012(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+CREATE TABLE host_mdm_activation_lock_bypass_codes (
+  host_uuid   varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  bypass_code varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  created_at  timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at  timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (host_uuid)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+`)
+	return errors.Wrap(err, "create host_mdm_activation_lock_bypass_codes table")
+}
+
+func Down_20230517083012(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,24 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230517083012(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO host_mdm_activation_lock_bypass_codes (host_uuid, bypass_code) VALUES ('uuid-1', 'AAAA-BBBB')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO host_mdm_activation_lock_bypass_codes (host_uuid, bypass_code) VALUES ('uuid-1', 'CCCC-DDDD')`)
+	require.ErrorContains(t, err, "Duplicate entry")
+
+	var code string
+	err = db.Get(&code, `SELECT bypass_code FROM host_mdm_activation_lock_bypass_codes WHERE host_uuid = 'uuid-1'`)
+	require.NoError(t, err)
+	require.Equal(t, "AAAA-BBBB", code)
+}
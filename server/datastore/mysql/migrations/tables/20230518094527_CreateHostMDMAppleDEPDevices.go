@@ -0,0 +1,35 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230518094527, Down_20230518094527)
+}
+
+func Up_20230518094527(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+CREATE TABLE host_mdm_apple_dep_devices (
+  host_id              int(10) unsigned NOT NULL,
+  description          varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  color                varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  asset_tag            varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  device_family        varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  os                   varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  device_assigned_by   varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  device_assigned_date timestamp NULL DEFAULT NULL,
+  created_at           timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at           timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (host_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+`)
+	return errors.Wrap(err, "create host_mdm_apple_dep_devices table")
+}
+
+func Down_20230518094527(tx *sql.Tx) error {
+	return nil
+}
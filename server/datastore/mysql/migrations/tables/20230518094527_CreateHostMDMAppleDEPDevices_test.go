@@ -0,0 +1,30 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230518094527(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO host_mdm_apple_dep_devices (host_id, description, color) VALUES (1, 'MBP 13.3 SPG', 'SPACE GRAY')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO host_mdm_apple_dep_devices (host_id) VALUES (1)`)
+	require.ErrorContains(t, err, "Duplicate entry")
+
+	var dev struct {
+		Description        string  `db:"description"`
+		AssetTag           string  `db:"asset_tag"`
+		DeviceAssignedDate *string `db:"device_assigned_date"`
+	}
+	err = db.Get(&dev, `SELECT description, asset_tag, device_assigned_date FROM host_mdm_apple_dep_devices WHERE host_id = 1`)
+	require.NoError(t, err)
+	require.Equal(t, "MBP 13.3 SPG", dev.Description)
+	require.Empty(t, dev.AssetTag)
+	require.Nil(t, dev.DeviceAssignedDate)
+}
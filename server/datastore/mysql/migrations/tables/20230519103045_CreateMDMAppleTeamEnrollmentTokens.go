@@ -0,0 +1,34 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230519103045, Down_20230519103045)
+}
+
+func Up_20230519103045(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+CREATE TABLE mdm_apple_team_enrollment_tokens (
+  id                int(10) unsigned NOT NULL AUTO_INCREMENT,
+  team_id           int(10) unsigned DEFAULT NULL,
+  global_or_team_id int(10) unsigned NOT NULL DEFAULT 0,
+  token             varchar(64) COLLATE utf8mb4_unicode_ci NOT NULL,
+  created_at        timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at        timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (id),
+  UNIQUE KEY idx_mdm_apple_team_enrollment_tokens_global_or_team_id (global_or_team_id),
+  UNIQUE KEY idx_mdm_apple_team_enrollment_tokens_token (token),
+  FOREIGN KEY (team_id) REFERENCES teams (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+`)
+	return errors.Wrap(err, "create mdm_apple_team_enrollment_tokens table")
+}
+
+func Down_20230519103045(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,36 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230519103045(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	res, err := db.Exec(`INSERT INTO teams (name) VALUES ('team1')`)
+	require.NoError(t, err)
+	teamID, err := res.LastInsertId()
+	require.NoError(t, err)
+
+	_, err = db.Exec(`INSERT INTO mdm_apple_team_enrollment_tokens (token) VALUES ('abc')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO mdm_apple_team_enrollment_tokens (token) VALUES ('def')`)
+	require.ErrorContains(t, err, "Duplicate entry")
+	_, err = db.Exec(`INSERT INTO mdm_apple_team_enrollment_tokens (team_id, global_or_team_id, token) VALUES (?, ?, 'abc')`, teamID, teamID)
+	require.ErrorContains(t, err, "Duplicate entry")
+	_, err = db.Exec(`INSERT INTO mdm_apple_team_enrollment_tokens (team_id, global_or_team_id, token) VALUES (?, ?, 'def')`, teamID, teamID)
+	require.NoError(t, err)
+
+	// deleting the team deletes its token
+	_, err = db.Exec(`DELETE FROM teams WHERE id = ?`, teamID)
+	require.NoError(t, err)
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM mdm_apple_team_enrollment_tokens`)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
@@ -0,0 +1,36 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230522091406, Down_20230522091406)
+}
+
+func Up_20230522091406(tx *sql.Tx) error {
+	// token_hex is the push token that failed, the failures do not apply
+	// anymore once the enrollment has a new token.
+	_, err := tx.Exec(`
+CREATE TABLE mdm_apple_push_failures (
+  enrollment_id  varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  token_hex      varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  failure_count  int(10) unsigned NOT NULL DEFAULT 0,
+  last_error     varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  token_invalid  tinyint(1) NOT NULL DEFAULT 0,
+  last_failed_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  created_at     timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (enrollment_id),
+  KEY idx_mdm_apple_push_failures_token_hex (token_hex),
+  FOREIGN KEY (enrollment_id) REFERENCES nano_enrollments (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+`)
+	return errors.Wrap(err, "create mdm_apple_push_failures table")
+}
+
+func Down_20230522091406(tx *sql.Tx) error {
+	return nil
+}
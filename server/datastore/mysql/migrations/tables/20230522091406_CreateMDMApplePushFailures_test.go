@@ -0,0 +1,34 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230522091406(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO nano_devices (id, authenticate) VALUES ('uuid-1', 'auth')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		INSERT INTO nano_enrollments (id, device_id, type, topic, push_magic, token_hex)
+		VALUES ('uuid-1', 'uuid-1', 'Device', 'topic', 'magic', 'abcd')`)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`INSERT INTO mdm_apple_push_failures (enrollment_id, token_hex, failure_count, token_invalid) VALUES ('uuid-1', 'abcd', 1, 1)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO mdm_apple_push_failures (enrollment_id, token_hex) VALUES ('no-such-enrollment', 'abcd')`)
+	require.Error(t, err)
+
+	// deleting the enrollment deletes its failures
+	_, err = db.Exec(`DELETE FROM nano_enrollments WHERE id = 'uuid-1'`)
+	require.NoError(t, err)
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM mdm_apple_push_failures`)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
@@ -0,0 +1,23 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230523101207, Down_20230523101207)
+}
+
+func Up_20230523101207(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE mdm_apple_configuration_profiles
+  ADD COLUMN reserved_payload_types json DEFAULT NULL
+`)
+	return errors.Wrap(err, "add reserved_payload_types to mdm_apple_configuration_profiles")
+}
+
+func Down_20230523101207(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230523101207(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`
+    INSERT INTO mdm_apple_configuration_profiles (team_id, identifier, name, mobileconfig, checksum)
+    VALUES (0, 'com.example', 'Example', '<plist></plist>', UNHEX(MD5('<plist></plist>')))`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var types *string
+	err = db.Get(&types, "SELECT reserved_payload_types FROM mdm_apple_configuration_profiles WHERE identifier = 'com.example'")
+	require.NoError(t, err)
+	require.Nil(t, types)
+
+	_, err = db.Exec(`UPDATE mdm_apple_configuration_profiles SET reserved_payload_types = '["com.apple.MCX.FileVault2"]' WHERE identifier = 'com.example'`)
+	require.NoError(t, err)
+}
@@ -0,0 +1,37 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230524083012, Down_20230524083012)
+}
+
+func Up_20230524083012(tx *sql.Tx) error {
+	// the accesses are kept for compliance evidence, so there is no foreign key
+	// to the hosts or users, and the user's name and email are copied like for
+	// activities.
+	_, err := tx.Exec(`
+CREATE TABLE host_disk_encryption_key_accesses (
+  id            int(10) unsigned NOT NULL AUTO_INCREMENT,
+  host_id       int(10) unsigned NOT NULL,
+  user_id       int(10) unsigned DEFAULT NULL,
+  user_name     varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  user_email    varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  ip_address    varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  justification text COLLATE utf8mb4_unicode_ci NOT NULL,
+  created_at    timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (id),
+  KEY idx_host_disk_encryption_key_accesses_host_id_created_at (host_id, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+`)
+	return errors.Wrap(err, "create host_disk_encryption_key_accesses table")
+}
+
+func Down_20230524083012(tx *sql.Tx) error {
+	return nil
+}
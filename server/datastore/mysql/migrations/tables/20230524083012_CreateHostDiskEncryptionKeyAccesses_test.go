@@ -0,0 +1,24 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230524083012(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`
+    INSERT INTO host_disk_encryption_key_accesses (host_id, user_id, user_name, user_email, ip_address, justification)
+    VALUES (1, 2, 'Jane', 'jane@example.com', '1.2.3.4', 'Ticket 123')`)
+	require.NoError(t, err)
+
+	var count int
+	err = db.Get(&count, "SELECT COUNT(*) FROM host_disk_encryption_key_accesses WHERE host_id = 1")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
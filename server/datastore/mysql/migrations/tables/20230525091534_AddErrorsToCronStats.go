@@ -0,0 +1,23 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230525091534, Down_20230525091534)
+}
+
+func Up_20230525091534(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE cron_stats
+  ADD COLUMN errors json DEFAULT NULL
+`)
+	return errors.Wrap(err, "add errors to cron_stats")
+}
+
+func Down_20230525091534(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,25 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230525091534(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`INSERT INTO cron_stats (name, instance, stats_type, status) VALUES ('test_sched', 'test_instance', 'scheduled', 'completed')`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var cronErrors *string
+	err = db.Get(&cronErrors, "SELECT errors FROM cron_stats WHERE name = 'test_sched'")
+	require.NoError(t, err)
+	require.Nil(t, cronErrors)
+
+	_, err = db.Exec(`UPDATE cron_stats SET errors = '{"test_job": "failed"}' WHERE name = 'test_sched'`)
+	require.NoError(t, err)
+}
@@ -0,0 +1,24 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230526102345, Down_20230526102345)
+}
+
+func Up_20230526102345(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE host_mdm_apple_profiles
+  ADD COLUMN failure_count int(10) unsigned NOT NULL DEFAULT 0,
+  ADD COLUMN first_failed_at timestamp NULL DEFAULT NULL
+`)
+	return errors.Wrap(err, "add failure_count and first_failed_at to host_mdm_apple_profiles")
+}
+
+func Down_20230526102345(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,29 @@
+package tables
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230526102345(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`
+    INSERT INTO host_mdm_apple_profiles (profile_id, profile_identifier, host_uuid, command_uuid, checksum)
+    VALUES (1, 'com.example', 'host-uuid', 'command-uuid', UNHEX(MD5('<plist></plist>')))`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var state struct {
+		FailureCount  uint       `db:"failure_count"`
+		FirstFailedAt *time.Time `db:"first_failed_at"`
+	}
+	err = db.Get(&state, "SELECT failure_count, first_failed_at FROM host_mdm_apple_profiles WHERE host_uuid = 'host-uuid'")
+	require.NoError(t, err)
+	require.Zero(t, state.FailureCount)
+	require.Nil(t, state.FirstFailedAt)
+}
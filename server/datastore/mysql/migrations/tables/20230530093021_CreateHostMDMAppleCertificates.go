@@ -0,0 +1,51 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230530093021, Down_20230530093021)
+}
+
+func Up_20230530093021(tx *sql.Tx) error {
+	// not_valid_before and not_valid_after are datetime as certificates are
+	// often valid beyond the range of the timestamp type.
+	_, err := tx.Exec(`
+CREATE TABLE host_mdm_apple_certificates (
+  id               INT(10) UNSIGNED NOT NULL AUTO_INCREMENT,
+  host_uuid        VARCHAR(255) NOT NULL,
+  sha1_sum         CHAR(40) NOT NULL,
+  common_name      VARCHAR(255) NOT NULL DEFAULT '',
+  issuer           VARCHAR(255) NOT NULL DEFAULT '',
+  serial_number    VARCHAR(255) NOT NULL DEFAULT '',
+  not_valid_before DATETIME NULL DEFAULT NULL,
+  not_valid_after  DATETIME NULL DEFAULT NULL,
+  is_identity      TINYINT(1) NOT NULL DEFAULT 0,
+  origin           VARCHAR(10) NOT NULL DEFAULT 'other',
+  created_at       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (id),
+  UNIQUE KEY idx_host_mdm_apple_certificates_host_uuid_sha1_sum (host_uuid, sha1_sum),
+  KEY idx_host_mdm_apple_certificates_not_valid_after (not_valid_after)
+)`)
+	if err != nil {
+		return errors.Wrap(err, "create host_mdm_apple_certificates table")
+	}
+
+	_, err = tx.Exec(`
+CREATE TABLE host_mdm_apple_certificate_refreshes (
+  host_uuid    VARCHAR(255) NOT NULL,
+  requested_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (host_uuid)
+)`)
+	return errors.Wrap(err, "create host_mdm_apple_certificate_refreshes table")
+}
+
+func Down_20230530093021(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,33 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230530093021(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`
+    INSERT INTO host_mdm_apple_certificates (host_uuid, sha1_sum, common_name, not_valid_after)
+    VALUES ('host-uuid', 'da39a3ee5e6b4b0d3255bfef95601890afd80709', 'Wi-Fi', '2049-12-31 00:00:00')`)
+	require.NoError(t, err)
+
+	// the same certificate cannot be inserted twice for a host
+	_, err = db.Exec(`
+    INSERT INTO host_mdm_apple_certificates (host_uuid, sha1_sum)
+    VALUES ('host-uuid', 'da39a3ee5e6b4b0d3255bfef95601890afd80709')`)
+	require.Error(t, err)
+
+	var origin string
+	err = db.Get(&origin, `SELECT origin FROM host_mdm_apple_certificates WHERE host_uuid = 'host-uuid'`)
+	require.NoError(t, err)
+	require.Equal(t, "other", origin)
+
+	_, err = db.Exec(`INSERT INTO host_mdm_apple_certificate_refreshes (host_uuid) VALUES ('host-uuid')`)
+	require.NoError(t, err)
+}
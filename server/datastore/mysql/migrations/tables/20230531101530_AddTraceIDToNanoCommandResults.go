@@ -0,0 +1,23 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230531101530, Down_20230531101530)
+}
+
+func Up_20230531101530(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE nano_command_results
+  ADD COLUMN trace_id varchar(64) COLLATE utf8mb4_unicode_ci NULL
+`)
+	return errors.Wrap(err, "add trace_id to nano_command_results")
+}
+
+func Down_20230531101530(tx *sql.Tx) error {
+	return nil
+}
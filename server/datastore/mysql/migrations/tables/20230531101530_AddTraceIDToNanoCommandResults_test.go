@@ -0,0 +1,19 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230531101530(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var traceIDs []string
+	err := db.Select(&traceIDs, "SELECT trace_id FROM nano_command_results")
+	require.NoError(t, err)
+	require.Empty(t, traceIDs)
+}
@@ -0,0 +1,31 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230601093015, Down_20230601093015)
+}
+
+func Up_20230601093015(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+CREATE TABLE host_orbit_mdm_status (
+  host_id            INT(10) UNSIGNED NOT NULL,
+  enrolled           TINYINT(1) NOT NULL DEFAULT 0,
+  installed_from_dep TINYINT(1) NOT NULL DEFAULT 0,
+  server_url         VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  profiles           JSON NULL,
+  created_at         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (host_id)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create host_orbit_mdm_status table")
+}
+
+func Down_20230601093015(tx *sql.Tx) error {
+	return nil
+}
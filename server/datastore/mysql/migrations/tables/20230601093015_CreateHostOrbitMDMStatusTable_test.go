@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230601093015(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO host_orbit_mdm_status (host_id, enrolled, server_url) VALUES (1, 1, 'https://example.com/mdm/apple/mdm')`)
+	require.NoError(t, err)
+
+	var status struct {
+		Enrolled  bool   `db:"enrolled"`
+		ServerURL string `db:"server_url"`
+	}
+	err = db.Get(&status, `SELECT enrolled, server_url FROM host_orbit_mdm_status WHERE host_id = 1`)
+	require.NoError(t, err)
+	require.True(t, status.Enrolled)
+	require.Equal(t, "https://example.com/mdm/apple/mdm", status.ServerURL)
+}
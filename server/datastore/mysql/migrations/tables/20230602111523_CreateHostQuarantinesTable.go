@@ -0,0 +1,32 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230602111523, Down_20230602111523)
+}
+
+func Up_20230602111523(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+CREATE TABLE host_quarantines (
+  id               INT(10) UNSIGNED NOT NULL AUTO_INCREMENT,
+  host_id          INT(10) UNSIGNED NOT NULL,
+  case_id          VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  team_id          INT(10) UNSIGNED NOT NULL,
+  previous_team_id INT(10) UNSIGNED NULL,
+  locked           TINYINT(1) NOT NULL DEFAULT 0,
+  created_at       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (id),
+  KEY idx_host_quarantines_host_id (host_id)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create host_quarantines table")
+}
+
+func Down_20230602111523(tx *sql.Tx) error {
+	return nil
+}
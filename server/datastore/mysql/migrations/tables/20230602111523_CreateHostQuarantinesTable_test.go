@@ -0,0 +1,28 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230602111523(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO host_quarantines (host_id, case_id, team_id, locked) VALUES (1, 'case-1', 2, 1)`)
+	require.NoError(t, err)
+
+	var q struct {
+		CaseID         string `db:"case_id"`
+		PreviousTeamID *uint  `db:"previous_team_id"`
+		Locked         bool   `db:"locked"`
+	}
+	err = db.Get(&q, `SELECT case_id, previous_team_id, locked FROM host_quarantines WHERE host_id = 1`)
+	require.NoError(t, err)
+	require.Equal(t, "case-1", q.CaseID)
+	require.Nil(t, q.PreviousTeamID)
+	require.True(t, q.Locked)
+}
@@ -0,0 +1,78 @@
+package tables
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230605094512, Down_20230605094512)
+}
+
+func Up_20230605094512(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE scep_certificates
+  ADD COLUMN sha256              CHAR(64) COLLATE utf8mb4_unicode_ci NULL,
+  ADD COLUMN host_uuid           VARCHAR(255) COLLATE utf8mb4_unicode_ci NULL,
+  ADD COLUMN renewed_from_serial BIGINT(20) NULL,
+  ADD KEY idx_scep_certificates_sha256 (sha256),
+  ADD KEY idx_scep_certificates_host_uuid (host_uuid),
+  ADD KEY idx_scep_certificates_not_valid_after (not_valid_after),
+  ADD KEY idx_scep_certificates_renewed_from_serial (renewed_from_serial)`)
+	if err != nil {
+		return errors.Wrap(err, "add issuance columns to scep_certificates")
+	}
+
+	// compute the fingerprint of the existing certificates, it is the same as
+	// the one nanomdm associates with the enrollments.
+	rows, err := tx.Query(`SELECT serial, certificate_pem FROM scep_certificates`)
+	if err != nil {
+		return errors.Wrap(err, "select scep certificates")
+	}
+	sums := make(map[int64]string)
+	for rows.Next() {
+		var (
+			serial  int64
+			certPEM []byte
+		)
+		if err := rows.Scan(&serial, &certPEM); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "scan scep certificate")
+		}
+		block, _ := pem.Decode(certPEM)
+		if block == nil {
+			continue
+		}
+		sums[serial] = fmt.Sprintf("%x", sha256.Sum256(block.Bytes))
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return errors.Wrap(err, "iterate scep certificates")
+	}
+	rows.Close()
+
+	for serial, sum := range sums {
+		if _, err := tx.Exec(`UPDATE scep_certificates SET sha256 = ? WHERE serial = ?`, sum, serial); err != nil {
+			return errors.Wrap(err, "set scep certificate sha256")
+		}
+	}
+
+	// associate the certificates currently in use with their host, the renewal
+	// chain of previous certificates cannot be recovered.
+	_, err = tx.Exec(`
+UPDATE
+  scep_certificates sc
+  JOIN nano_cert_auth_associations ca ON ca.sha256 = sc.sha256
+  JOIN nano_enrollments ne ON ne.id = ca.id AND ne.type = 'Device'
+SET
+  sc.host_uuid = ne.device_id`)
+	return errors.Wrap(err, "associate scep certificates with hosts")
+}
+
+func Down_20230605094512(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,56 @@
+package tables
+
+import (
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230605094512(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	insertCert := func(serial int64, der string) string {
+		_, err := db.Exec(`INSERT INTO scep_serials (serial) VALUES (?)`, serial)
+		require.NoError(t, err)
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte(der)})
+		_, err = db.Exec(`
+			INSERT INTO scep_certificates (serial, name, not_valid_before, not_valid_after, certificate_pem)
+			VALUES (?, 'fleet-identity', NOW(), NOW() + INTERVAL 1 YEAR, ?)`, serial, certPEM)
+		require.NoError(t, err)
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(der)))
+	}
+
+	sum1 := insertCert(10, "cert-1")
+	sum2 := insertCert(11, "cert-2")
+
+	// the first certificate is in use by a device enrollment
+	_, err := db.Exec(`INSERT INTO nano_devices (id, authenticate) VALUES ('uuid-1', 'auth')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		INSERT INTO nano_enrollments (id, device_id, type, topic, push_magic, token_hex)
+		VALUES ('uuid-1', 'uuid-1', 'Device', 'topic', 'magic', 'abcd')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO nano_cert_auth_associations (id, sha256) VALUES ('uuid-1', ?)`, sum1)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	type certRow struct {
+		Serial            int64   `db:"serial"`
+		SHA256            *string `db:"sha256"`
+		HostUUID          *string `db:"host_uuid"`
+		RenewedFromSerial *int64  `db:"renewed_from_serial"`
+	}
+	var rows []certRow
+	err = db.Select(&rows, `SELECT serial, sha256, host_uuid, renewed_from_serial FROM scep_certificates ORDER BY serial`)
+	require.NoError(t, err)
+	require.Equal(t, []certRow{
+		{Serial: 10, SHA256: &sum1, HostUUID: ptr.String("uuid-1")},
+		{Serial: 11, SHA256: &sum2},
+	}, rows)
+}
@@ -0,0 +1,37 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230606101533, Down_20230606101533)
+}
+
+func Up_20230606101533(tx *sql.Tx) error {
+	// the exclusions reference the profiles by identifier instead of id so that
+	// they survive a profile being replaced by a new version with the same
+	// identifier. team_id is 0 for the profiles of hosts in no team, as in
+	// mdm_apple_configuration_profiles.
+	_, err := tx.Exec(`
+CREATE TABLE mdm_apple_configuration_profile_exclusions (
+  id                 INT(10) UNSIGNED NOT NULL AUTO_INCREMENT,
+  team_id            INT(10) UNSIGNED NOT NULL DEFAULT 0,
+  profile_identifier VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  label_id           INT(10) UNSIGNED NULL,
+  host_id            INT(10) UNSIGNED NULL,
+  created_at         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (id),
+  KEY idx_mdm_apple_profile_exclusions_team_identifier (team_id, profile_identifier),
+  KEY idx_mdm_apple_profile_exclusions_label_id (label_id),
+  KEY idx_mdm_apple_profile_exclusions_host_id (host_id)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create mdm_apple_configuration_profile_exclusions table")
+}
+
+func Down_20230606101533(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,36 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230606101533(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO mdm_apple_configuration_profile_exclusions (profile_identifier, label_id) VALUES ('com.example.a', 1)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO mdm_apple_configuration_profile_exclusions (team_id, profile_identifier, host_id) VALUES (2, 'com.example.b', 3)`)
+	require.NoError(t, err)
+
+	var excls []struct {
+		TeamID            uint   `db:"team_id"`
+		ProfileIdentifier string `db:"profile_identifier"`
+		LabelID           *uint  `db:"label_id"`
+		HostID            *uint  `db:"host_id"`
+	}
+	err = db.Select(&excls, `SELECT team_id, profile_identifier, label_id, host_id FROM mdm_apple_configuration_profile_exclusions ORDER BY id`)
+	require.NoError(t, err)
+	require.Len(t, excls, 2)
+	require.Zero(t, excls[0].TeamID)
+	require.Equal(t, "com.example.a", excls[0].ProfileIdentifier)
+	require.NotNil(t, excls[0].LabelID)
+	require.Nil(t, excls[0].HostID)
+	require.EqualValues(t, 2, excls[1].TeamID)
+	require.Nil(t, excls[1].LabelID)
+	require.NotNil(t, excls[1].HostID)
+}
@@ -0,0 +1,36 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230607093012, Down_20230607093012)
+}
+
+func Up_20230607093012(tx *sql.Tx) error {
+	// a policy has at most one MDM action, which may install a remediation
+	// profile, enqueue a command built from a template, or both. team_ids is a
+	// JSON array that restricts the hosts of a global policy on which the
+	// action runs (0 is for hosts in no team), NULL means all hosts.
+	_, err := tx.Exec(`
+CREATE TABLE mdm_apple_policy_actions (
+  policy_id           INT(10) UNSIGNED NOT NULL,
+  remediation_profile MEDIUMBLOB NULL,
+  command_template    MEDIUMTEXT COLLATE utf8mb4_unicode_ci NULL,
+  team_ids            JSON NULL,
+  created_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (policy_id),
+  CONSTRAINT fk_mdm_apple_policy_actions_policy_id
+    FOREIGN KEY (policy_id) REFERENCES policies (id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create mdm_apple_policy_actions table")
+}
+
+func Down_20230607093012(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,33 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230607093012(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	res, err := db.Exec(`INSERT INTO policies (name, query, description) VALUES ('p1', 'SELECT 1', '')`)
+	require.NoError(t, err)
+	policyID, _ := res.LastInsertId()
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err = db.Exec(`INSERT INTO mdm_apple_policy_actions (policy_id, command_template, team_ids) VALUES (?, 'cmd', '[0, 1]')`, policyID)
+	require.NoError(t, err)
+
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM mdm_apple_policy_actions WHERE policy_id = ?`, policyID)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// the action is deleted with its policy
+	_, err = db.Exec(`DELETE FROM policies WHERE id = ?`, policyID)
+	require.NoError(t, err)
+	err = db.Get(&count, `SELECT COUNT(*) FROM mdm_apple_policy_actions`)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
@@ -0,0 +1,29 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230608090512, Down_20230608090512)
+}
+
+func Up_20230608090512(tx *sql.Tx) error {
+	// like host_seen_times, but for the last time the host checked in via MDM,
+	// which is kept separate from the hosts table as it's updated frequently.
+	_, err := tx.Exec(`
+CREATE TABLE host_mdm_checkin_times (
+  host_id      INT(10) UNSIGNED NOT NULL,
+  checkin_time TIMESTAMP NULL DEFAULT NULL,
+
+  PRIMARY KEY (host_id),
+  KEY idx_host_mdm_checkin_times_checkin_time (checkin_time)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create host_mdm_checkin_times table")
+}
+
+func Down_20230608090512(tx *sql.Tx) error {
+	return nil
+}
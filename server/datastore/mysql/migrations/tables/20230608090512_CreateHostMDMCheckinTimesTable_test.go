@@ -0,0 +1,24 @@
+package tables
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230608090512(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	_, err := db.Exec(`INSERT INTO host_mdm_checkin_times (host_id, checkin_time) VALUES (1, ?)`, now)
+	require.NoError(t, err)
+
+	var checkinTime time.Time
+	err = db.Get(&checkinTime, `SELECT checkin_time FROM host_mdm_checkin_times WHERE host_id = 1`)
+	require.NoError(t, err)
+	require.Equal(t, now, checkinTime.UTC())
+}
@@ -0,0 +1,36 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230609081523, Down_20230609081523)
+}
+
+func Up_20230609081523(tx *sql.Tx) error {
+	// installed_payload_uuid is the PayloadUUID of the profile as reported
+	// installed by the host in the result of a ProfileList command, NULL if the
+	// host did not report it.
+	_, err := tx.Exec(`
+ALTER TABLE host_mdm_apple_profiles
+  ADD COLUMN installed_payload_uuid VARCHAR(255) COLLATE utf8mb4_unicode_ci NULL DEFAULT NULL`)
+	if err != nil {
+		return errors.Wrap(err, "add installed_payload_uuid to host_mdm_apple_profiles")
+	}
+
+	_, err = tx.Exec(`
+CREATE TABLE host_mdm_apple_profile_list_refreshes (
+  host_uuid    VARCHAR(255) NOT NULL,
+  requested_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (host_uuid)
+)`)
+	return errors.Wrap(err, "create host_mdm_apple_profile_list_refreshes table")
+}
+
+func Down_20230609081523(tx *sql.Tx) error {
+	return nil
+}
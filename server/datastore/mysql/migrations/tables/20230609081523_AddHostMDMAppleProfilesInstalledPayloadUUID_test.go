@@ -0,0 +1,30 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230609081523(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`
+    INSERT INTO host_mdm_apple_profiles (profile_id, profile_identifier, host_uuid, command_uuid, checksum)
+    VALUES (1, 'com.example', 'host-uuid', 'cmd-uuid', UNHEX(MD5('abc')))`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var payloadUUID *string
+	err = db.Get(&payloadUUID, `SELECT installed_payload_uuid FROM host_mdm_apple_profiles WHERE host_uuid = 'host-uuid'`)
+	require.NoError(t, err)
+	require.Nil(t, payloadUUID)
+
+	_, err = db.Exec(`UPDATE host_mdm_apple_profiles SET installed_payload_uuid = 'payload-uuid' WHERE host_uuid = 'host-uuid'`)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`INSERT INTO host_mdm_apple_profile_list_refreshes (host_uuid) VALUES ('host-uuid')`)
+	require.NoError(t, err)
+}
@@ -0,0 +1,33 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230612093215, Down_20230612093215)
+}
+
+func Up_20230612093215(tx *sql.Tx) error {
+	// source is where the attribute's value comes from, e.g. "api" if it was
+	// set via the REST API or "idp"/"dep" if it was set by an MDM workflow.
+	_, err := tx.Exec(`
+CREATE TABLE host_custom_attributes (
+  host_id    INT(10) UNSIGNED NOT NULL,
+  name       VARCHAR(64) COLLATE utf8mb4_unicode_ci NOT NULL,
+  value      TEXT COLLATE utf8mb4_unicode_ci NOT NULL,
+  source     VARCHAR(32) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (host_id, name),
+  KEY idx_host_custom_attributes_name (name)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create host_custom_attributes table")
+}
+
+func Down_20230612093215(tx *sql.Tx) error {
+	return nil
+}
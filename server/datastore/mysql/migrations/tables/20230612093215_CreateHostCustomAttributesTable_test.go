@@ -0,0 +1,28 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230612093215(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO host_custom_attributes (host_id, name, value, source) VALUES (1, 'cost_center', 'CC-123', 'api')`)
+	require.NoError(t, err)
+
+	// the name is unique per host
+	_, err = db.Exec(`INSERT INTO host_custom_attributes (host_id, name, value, source) VALUES (1, 'cost_center', 'CC-456', 'api')`)
+	require.Error(t, err)
+	_, err = db.Exec(`INSERT INTO host_custom_attributes (host_id, name, value, source) VALUES (2, 'cost_center', 'CC-456', 'api')`)
+	require.NoError(t, err)
+
+	var value string
+	err = db.Get(&value, `SELECT value FROM host_custom_attributes WHERE host_id = 1 AND name = 'cost_center'`)
+	require.NoError(t, err)
+	require.Equal(t, "CC-123", value)
+}
@@ -0,0 +1,33 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230613101744, Down_20230613101744)
+}
+
+func Up_20230613101744(tx *sql.Tx) error {
+	// a row is created when a host enrolls manually while the approval of
+	// manual enrollments is enabled, the host receives no profiles and no
+	// commands while its status is "pending".
+	_, err := tx.Exec(`
+CREATE TABLE host_mdm_apple_enrollment_approvals (
+  host_uuid   VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  status      VARCHAR(16) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT 'pending',
+  approved_at TIMESTAMP NULL DEFAULT NULL,
+  created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (host_uuid),
+  KEY idx_host_mdm_apple_enrollment_approvals_status (status)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create host_mdm_apple_enrollment_approvals table")
+}
+
+func Down_20230613101744(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,22 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230613101744(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO host_mdm_apple_enrollment_approvals (host_uuid) VALUES ('abc')`)
+	require.NoError(t, err)
+
+	var status string
+	err = db.Get(&status, `SELECT status FROM host_mdm_apple_enrollment_approvals WHERE host_uuid = 'abc'`)
+	require.NoError(t, err)
+	require.Equal(t, "pending", status)
+}
@@ -0,0 +1,35 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230614093012, Down_20230614093012)
+}
+
+func Up_20230614093012(tx *sql.Tx) error {
+	// the macOS settings summary and the hosts filter by macOS settings status
+	// evaluate correlated subqueries on host_mdm_apple_profiles for every host
+	// of a team, these indexes allow both sides of those subqueries to be
+	// resolved from the index alone.
+	_, err := tx.Exec(`
+ALTER TABLE host_mdm_apple_profiles
+  ADD INDEX idx_host_mdm_apple_profiles_host_status (host_uuid, status, operation_type, profile_identifier)`)
+	if err != nil {
+		return errors.Wrap(err, "add host_mdm_apple_profiles host status index")
+	}
+
+	_, err = tx.Exec(`ALTER TABLE hosts ADD INDEX idx_hosts_team_id_uuid (team_id, uuid)`)
+	if err != nil {
+		return errors.Wrap(err, "add hosts team_id uuid index")
+	}
+
+	return nil
+}
+
+func Down_20230614093012(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230614093012(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var count int
+	err := db.Get(&count, `
+SELECT COUNT(*) FROM information_schema.statistics
+WHERE table_schema = DATABASE() AND table_name = 'host_mdm_apple_profiles' AND index_name = 'idx_host_mdm_apple_profiles_host_status'`)
+	require.NoError(t, err)
+	require.Equal(t, 4, count)
+
+	err = db.Get(&count, `
+SELECT COUNT(*) FROM information_schema.statistics
+WHERE table_schema = DATABASE() AND table_name = 'hosts' AND index_name = 'idx_hosts_team_id_uuid'`)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+}
@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230615101523, Down_20230615101523)
+}
+
+func Up_20230615101523(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE host_mdm_apple_dep_devices
+  ADD COLUMN org_name            varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '' AFTER host_id,
+  ADD COLUMN profile_status      varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '' AFTER device_assigned_date,
+  ADD COLUMN profile_uuid        varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '' AFTER profile_status,
+  ADD COLUMN profile_assign_time timestamp NULL DEFAULT NULL AFTER profile_uuid,
+  ADD COLUMN profile_push_time   timestamp NULL DEFAULT NULL AFTER profile_assign_time
+`)
+	return errors.Wrap(err, "add abm fields to host_mdm_apple_dep_devices")
+}
+
+func Down_20230615101523(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,33 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230615101523(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`INSERT INTO host_mdm_apple_dep_devices (host_id, description) VALUES (1, 'MBP 13.3 SPG')`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var dev struct {
+		Description       string  `db:"description"`
+		OrgName           string  `db:"org_name"`
+		ProfileStatus     string  `db:"profile_status"`
+		ProfileAssignTime *string `db:"profile_assign_time"`
+	}
+	err = db.Get(&dev, `SELECT description, org_name, profile_status, profile_assign_time FROM host_mdm_apple_dep_devices WHERE host_id = 1`)
+	require.NoError(t, err)
+	require.Equal(t, "MBP 13.3 SPG", dev.Description)
+	require.Empty(t, dev.OrgName)
+	require.Empty(t, dev.ProfileStatus)
+	require.Nil(t, dev.ProfileAssignTime)
+
+	_, err = db.Exec(`UPDATE host_mdm_apple_dep_devices SET org_name = 'Acme', profile_status = 'assigned', profile_assign_time = NOW() WHERE host_id = 1`)
+	require.NoError(t, err)
+}
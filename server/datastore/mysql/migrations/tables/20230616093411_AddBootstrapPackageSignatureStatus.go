@@ -0,0 +1,25 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230616093411, Down_20230616093411)
+}
+
+func Up_20230616093411(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE mdm_apple_bootstrap_packages
+  ADD COLUMN signature_status      varchar(20) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  ADD COLUMN signature_detail      text COLLATE utf8mb4_unicode_ci,
+  ADD COLUMN signature_verified_at datetime NULL DEFAULT NULL
+`)
+	return errors.Wrap(err, "add signature status to mdm_apple_bootstrap_packages")
+}
+
+func Down_20230616093411(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,28 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230616093411(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`INSERT INTO mdm_apple_bootstrap_packages (team_id, name, sha256, bytes, token) VALUES (0, 'pkg.pkg', REPEAT('a', 32), 'abc', 'token')`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var bp struct {
+		SignatureStatus     string  `db:"signature_status"`
+		SignatureDetail     *string `db:"signature_detail"`
+		SignatureVerifiedAt *string `db:"signature_verified_at"`
+	}
+	err = db.Get(&bp, `SELECT signature_status, signature_detail, signature_verified_at FROM mdm_apple_bootstrap_packages WHERE team_id = 0`)
+	require.NoError(t, err)
+	require.Empty(t, bp.SignatureStatus)
+	require.Nil(t, bp.SignatureDetail)
+	require.Nil(t, bp.SignatureVerifiedAt)
+}
@@ -0,0 +1,30 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230619101532, Down_20230619101532)
+}
+
+func Up_20230619101532(tx *sql.Tx) error {
+	// host_id is set for the ad-hoc profiles installed on a single host, 0
+	// for the team profiles. The unique keys include it so that the same
+	// ad-hoc profile can be installed on several hosts.
+	_, err := tx.Exec(`
+ALTER TABLE mdm_apple_configuration_profiles
+  ADD COLUMN host_id int(10) unsigned NOT NULL DEFAULT 0 AFTER team_id,
+  DROP INDEX idx_mdm_apple_config_prof_team_identifier,
+  DROP INDEX idx_mdm_apple_config_prof_team_name,
+  ADD UNIQUE KEY idx_mdm_apple_config_prof_team_identifier (team_id, host_id, identifier),
+  ADD UNIQUE KEY idx_mdm_apple_config_prof_team_name (team_id, host_id, name)
+`)
+	return errors.Wrap(err, "add host_id to mdm_apple_configuration_profiles")
+}
+
+func Down_20230619101532(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,39 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230619101532(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	insertStmt := `INSERT INTO mdm_apple_configuration_profiles (team_id, identifier, name, mobileconfig, checksum) VALUES (?, ?, ?, '<plist></plist>', REPEAT('a', 16))`
+	_, err := db.Exec(insertStmt, 0, "com.example", "Example")
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var hostID uint
+	err = db.Get(&hostID, `SELECT host_id FROM mdm_apple_configuration_profiles WHERE identifier = 'com.example'`)
+	require.NoError(t, err)
+	require.Zero(t, hostID)
+
+	insertStmt = `INSERT INTO mdm_apple_configuration_profiles (team_id, host_id, identifier, name, mobileconfig, checksum) VALUES (?, ?, ?, ?, '<plist></plist>', REPEAT('a', 16))`
+
+	// the team profiles are still unique by identifier and name
+	_, err = db.Exec(insertStmt, 0, 0, "com.example", "Other")
+	require.ErrorContains(t, err, "Duplicate entry")
+	_, err = db.Exec(insertStmt, 0, 0, "com.other", "Example")
+	require.ErrorContains(t, err, "Duplicate entry")
+
+	// the same profile can be installed on several hosts
+	_, err = db.Exec(insertStmt, 4294967294, 1, "com.example", "Example")
+	require.NoError(t, err)
+	_, err = db.Exec(insertStmt, 4294967294, 2, "com.example", "Example")
+	require.NoError(t, err)
+	_, err = db.Exec(insertStmt, 4294967294, 2, "com.example", "Example")
+	require.ErrorContains(t, err, "Duplicate entry")
+}
@@ -0,0 +1,51 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230620093215, Down_20230620093215)
+}
+
+func Up_20230620093215(tx *sql.Tx) error {
+	// a migration is created when the server URL is changed with the MDM
+	// server URL migration flow, with a row for each host that was enrolled at
+	// that time. The enrollment profile with the new server URL is redelivered
+	// to the hosts in batches, the status of each host tracks the delivery.
+	_, err := tx.Exec(`
+CREATE TABLE mdm_apple_server_url_migrations (
+  id             INT(10) UNSIGNED NOT NULL AUTO_INCREMENT,
+  old_server_url VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  new_server_url VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (id)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	if err != nil {
+		return errors.Wrap(err, "create mdm_apple_server_url_migrations table")
+	}
+
+	_, err = tx.Exec(`
+CREATE TABLE mdm_apple_server_url_migration_hosts (
+  migration_id INT(10) UNSIGNED NOT NULL,
+  host_uuid    VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  status       VARCHAR(20) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT 'pending',
+  command_uuid VARCHAR(127) COLLATE utf8mb4_unicode_ci NULL,
+  detail       TEXT COLLATE utf8mb4_unicode_ci NULL,
+  updated_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (migration_id, host_uuid),
+  KEY idx_mdm_apple_server_url_migration_hosts_status (migration_id, status),
+  KEY idx_mdm_apple_server_url_migration_hosts_command_uuid (command_uuid),
+  CONSTRAINT fk_mdm_apple_server_url_migration_hosts_migration_id
+    FOREIGN KEY (migration_id) REFERENCES mdm_apple_server_url_migrations (id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create mdm_apple_server_url_migration_hosts table")
+}
+
+func Down_20230620093215(tx *sql.Tx) error {
+	return nil
+}
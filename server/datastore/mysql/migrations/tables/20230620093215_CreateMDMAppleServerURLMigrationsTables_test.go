@@ -0,0 +1,35 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230620093215(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	res, err := db.Exec(`INSERT INTO mdm_apple_server_url_migrations (old_server_url, new_server_url) VALUES ('https://old.example.com', 'https://new.example.com')`)
+	require.NoError(t, err)
+	id, err := res.LastInsertId()
+	require.NoError(t, err)
+
+	_, err = db.Exec(`INSERT INTO mdm_apple_server_url_migration_hosts (migration_id, host_uuid) VALUES (?, 'abc')`, id)
+	require.NoError(t, err)
+
+	var status string
+	err = db.Get(&status, `SELECT status FROM mdm_apple_server_url_migration_hosts WHERE host_uuid = 'abc'`)
+	require.NoError(t, err)
+	require.Equal(t, "pending", status)
+
+	// the hosts are deleted with the migration
+	_, err = db.Exec(`DELETE FROM mdm_apple_server_url_migrations WHERE id = ?`, id)
+	require.NoError(t, err)
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM mdm_apple_server_url_migration_hosts`)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
@@ -0,0 +1,32 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230621101412, Down_20230621101412)
+}
+
+func Up_20230621101412(tx *sql.Tx) error {
+	// the PIN of the latest DeviceLock or EraseDevice command sent to a host,
+	// needed to unlock the host afterwards.
+	_, err := tx.Exec(`
+CREATE TABLE host_mdm_apple_lock_pins (
+  host_uuid    VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  command_uuid VARCHAR(127) COLLATE utf8mb4_unicode_ci NOT NULL,
+  request_type VARCHAR(31) COLLATE utf8mb4_unicode_ci NOT NULL,
+  pin          VARCHAR(6) COLLATE utf8mb4_unicode_ci NOT NULL,
+  created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (host_uuid)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create host_mdm_apple_lock_pins table")
+}
+
+func Down_20230621101412(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,22 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230621101412(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO host_mdm_apple_lock_pins (host_uuid, command_uuid, request_type, pin) VALUES ('abc', 'cmd', 'DeviceLock', '123456')`)
+	require.NoError(t, err)
+
+	var pin string
+	err = db.Get(&pin, `SELECT pin FROM host_mdm_apple_lock_pins WHERE host_uuid = 'abc'`)
+	require.NoError(t, err)
+	require.Equal(t, "123456", pin)
+}
@@ -0,0 +1,30 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230622093045, Down_20230622093045)
+}
+
+func Up_20230622093045(tx *sql.Tx) error {
+	// the MDM certificates, keys and tokens uploaded via the API, encrypted
+	// with the server private key.
+	_, err := tx.Exec(`
+CREATE TABLE mdm_config_assets (
+  name       VARCHAR(63) COLLATE utf8mb4_unicode_ci NOT NULL,
+  value      BLOB NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (name)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create mdm_config_assets table")
+}
+
+func Down_20230622093045(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,22 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230622093045(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO mdm_config_assets (name, value) VALUES ('apns_cert', 'abc')`)
+	require.NoError(t, err)
+
+	var value []byte
+	err = db.Get(&value, `SELECT value FROM mdm_config_assets WHERE name = 'apns_cert'`)
+	require.NoError(t, err)
+	require.Equal(t, []byte("abc"), value)
+}
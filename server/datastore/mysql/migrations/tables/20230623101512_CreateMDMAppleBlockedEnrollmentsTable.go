@@ -0,0 +1,37 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230623101512, Down_20230623101512)
+}
+
+func Up_20230623101512(tx *sql.Tx) error {
+	// the macOS devices whose hardware doesn't meet the enrollment eligibility
+	// rules of their team. host_uuid is empty for the devices that were
+	// blocked when synced from Apple Business Manager, before they enrolled.
+	_, err := tx.Exec(`
+CREATE TABLE mdm_apple_blocked_enrollments (
+  serial_number  VARCHAR(127) COLLATE utf8mb4_unicode_ci NOT NULL,
+  host_uuid      VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  team_id        INT(10) UNSIGNED NULL,
+  hardware_model VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  source         VARCHAR(31) COLLATE utf8mb4_unicode_ci NOT NULL,
+  action         VARCHAR(31) COLLATE utf8mb4_unicode_ci NOT NULL,
+  reason         VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (serial_number),
+  KEY idx_mdm_apple_blocked_enrollments_team_id (team_id)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create mdm_apple_blocked_enrollments table")
+}
+
+func Down_20230623101512(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,28 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230623101512(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`
+          INSERT INTO mdm_apple_blocked_enrollments (serial_number, source, action, reason)
+          VALUES ('ABC', 'dep', 'block', 'too old')`)
+	require.NoError(t, err)
+
+	var row struct {
+		HostUUID string `db:"host_uuid"`
+		TeamID   *uint  `db:"team_id"`
+	}
+	err = db.Get(&row, `SELECT host_uuid, team_id FROM mdm_apple_blocked_enrollments WHERE serial_number = 'ABC'`)
+	require.NoError(t, err)
+	require.Empty(t, row.HostUUID)
+	require.Nil(t, row.TeamID)
+}
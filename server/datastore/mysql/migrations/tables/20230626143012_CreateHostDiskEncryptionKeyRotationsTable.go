@@ -0,0 +1,31 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230626143012, Down_20230626143012)
+}
+
+func Up_20230626143012(tx *sql.Tx) error {
+	// the hosts flagged for the rotation of their FileVault key, notified in
+	// batches via fleetd so that the end users aren't all prompted at once.
+	_, err := tx.Exec(`
+CREATE TABLE host_disk_encryption_key_rotations (
+  host_id      INT(10) UNSIGNED NOT NULL,
+  requested_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  notified_at  TIMESTAMP NULL,
+  completed_at TIMESTAMP NULL,
+
+  PRIMARY KEY (host_id),
+  KEY idx_host_disk_encryption_key_rotations_notified_at (notified_at, requested_at)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create host_disk_encryption_key_rotations table")
+}
+
+func Down_20230626143012(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,22 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230626143012(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO host_disk_encryption_key_rotations (host_id) VALUES (1)`)
+	require.NoError(t, err)
+
+	var notified []uint
+	err = db.Select(&notified, `SELECT host_id FROM host_disk_encryption_key_rotations WHERE notified_at IS NULL`)
+	require.NoError(t, err)
+	require.Equal(t, []uint{1}, notified)
+}
@@ -0,0 +1,22 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230627110412, Down_20230627110412)
+}
+
+func Up_20230627110412(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE sessions ADD COLUMN reauthenticated_at TIMESTAMP NULL DEFAULT NULL;
+`)
+	return errors.Wrap(err, "add reauthenticated_at")
+}
+
+func Down_20230627110412(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,30 @@
+package tables
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230627110412(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	r, err := db.Exec("INSERT INTO sessions (user_id, `key`) VALUES (?, ?)", 1, "abc")
+	require.NoError(t, err)
+	id, _ := r.LastInsertId()
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var reauthAt sql.NullTime
+	err = db.Get(&reauthAt, `SELECT reauthenticated_at FROM sessions WHERE id = ?`, id)
+	require.NoError(t, err)
+	require.False(t, reauthAt.Valid)
+
+	_, err = db.Exec(`UPDATE sessions SET reauthenticated_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	require.NoError(t, err)
+	err = db.Get(&reauthAt, `SELECT reauthenticated_at FROM sessions WHERE id = ?`, id)
+	require.NoError(t, err)
+	require.True(t, reauthAt.Valid)
+}
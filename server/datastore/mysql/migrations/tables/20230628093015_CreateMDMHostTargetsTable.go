@@ -0,0 +1,45 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230628093015, Down_20230628093015)
+}
+
+func Up_20230628093015(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+CREATE TABLE mdm_host_targets (
+  id                  INT(10) UNSIGNED NOT NULL AUTO_INCREMENT,
+  name                VARCHAR(255) NOT NULL,
+  description         TEXT NOT NULL,
+  team_ids            JSON DEFAULT NULL,
+  architecture        VARCHAR(20) NOT NULL DEFAULT '',
+  os_version_at_least VARCHAR(50) NOT NULL DEFAULT '',
+  os_version_below    VARCHAR(50) NOT NULL DEFAULT '',
+  created_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (id),
+  UNIQUE KEY idx_mdm_host_targets_name (name)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+`)
+	if err != nil {
+		return errors.Wrap(err, "create mdm_host_targets table")
+	}
+
+	_, err = tx.Exec(`
+ALTER TABLE mdm_apple_configuration_profiles
+  ADD COLUMN target_id INT(10) UNSIGNED NULL DEFAULT NULL,
+  ADD CONSTRAINT fk_mdm_apple_configuration_profiles_target_id
+    FOREIGN KEY (target_id) REFERENCES mdm_host_targets (id);
+`)
+	return errors.Wrap(err, "add target_id to mdm_apple_configuration_profiles")
+}
+
+func Down_20230628093015(tx *sql.Tx) error {
+	return nil
+}
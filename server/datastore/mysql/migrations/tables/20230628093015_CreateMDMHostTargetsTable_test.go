@@ -0,0 +1,39 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230628093015(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	r, err := db.Exec(`
+INSERT INTO mdm_apple_configuration_profiles (team_id, identifier, name, mobileconfig, checksum)
+VALUES (0, 'TestPayloadIdentifier', 'TestPayloadName', '<?xml', '0123456789abcdef')`)
+	require.NoError(t, err)
+	profID, _ := r.LastInsertId()
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	r, err = db.Exec(`
+INSERT INTO mdm_host_targets (name, description, team_ids, architecture, os_version_below)
+VALUES ('M1 Macs', '', '[1, 2]', 'arm64', '14')`)
+	require.NoError(t, err)
+	targetID, _ := r.LastInsertId()
+
+	// existing profiles are not targeted
+	var profTargetID *uint
+	err = db.Get(&profTargetID, `SELECT target_id FROM mdm_apple_configuration_profiles WHERE profile_id = ?`, profID)
+	require.NoError(t, err)
+	require.Nil(t, profTargetID)
+
+	_, err = db.Exec(`UPDATE mdm_apple_configuration_profiles SET target_id = ? WHERE profile_id = ?`, targetID, profID)
+	require.NoError(t, err)
+
+	// a target used by a profile can't be deleted
+	_, err = db.Exec(`DELETE FROM mdm_host_targets WHERE id = ?`, targetID)
+	require.Error(t, err)
+}
@@ -0,0 +1,40 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230629101500, Down_20230629101500)
+}
+
+func Up_20230629101500(tx *sql.Tx) error {
+	// the entries of the security audit log are never updated, except for the
+	// streamed flag which is not covered by their hash.
+	_, err := tx.Exec(`
+CREATE TABLE security_audit_log (
+  id                BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+  created_at        TIMESTAMP(6) NOT NULL,
+  action            VARCHAR(64) NOT NULL,
+  user_id           INT(10) UNSIGNED DEFAULT NULL,
+  user_name         VARCHAR(255) NOT NULL DEFAULT '',
+  user_email        VARCHAR(255) NOT NULL DEFAULT '',
+  ip_address        VARCHAR(255) NOT NULL DEFAULT '',
+  host_id           INT(10) UNSIGNED NOT NULL,
+  host_display_name VARCHAR(255) NOT NULL DEFAULT '',
+  prev_hash         CHAR(64) NOT NULL DEFAULT '',
+  hash              CHAR(64) NOT NULL,
+  streamed          TINYINT(1) NOT NULL DEFAULT 0,
+
+  PRIMARY KEY (id),
+  KEY idx_security_audit_log_streamed (streamed)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+`)
+	return errors.Wrap(err, "create security_audit_log table")
+}
+
+func Down_20230629101500(tx *sql.Tx) error {
+	return nil
+}
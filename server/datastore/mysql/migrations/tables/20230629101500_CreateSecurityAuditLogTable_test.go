@@ -0,0 +1,24 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230629101500(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`
+INSERT INTO security_audit_log (created_at, action, user_id, host_id, hash)
+VALUES (NOW(6), 'read_host_unlock_pin', 1, 1, REPEAT('a', 64))`)
+	require.NoError(t, err)
+
+	var streamed bool
+	err = db.Get(&streamed, `SELECT streamed FROM security_audit_log`)
+	require.NoError(t, err)
+	require.False(t, streamed)
+}
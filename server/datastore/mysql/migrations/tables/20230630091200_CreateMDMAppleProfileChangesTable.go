@@ -0,0 +1,42 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230630091200, Down_20230630091200)
+}
+
+func Up_20230630091200(tx *sql.Tx) error {
+	// a row is created when the reconciler computes a change of a profile
+	// (install or removal of a given version of the profile) that affects
+	// more hosts than allowed without approval. The commands of the change
+	// are only sent once its status is "approved".
+	_, err := tx.Exec(`
+CREATE TABLE mdm_apple_profile_changes (
+  id                 INT(10) UNSIGNED NOT NULL AUTO_INCREMENT,
+  profile_id         INT(10) UNSIGNED NOT NULL,
+  profile_identifier VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  profile_name       VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  checksum           BINARY(16) NOT NULL,
+  operation_type     VARCHAR(20) COLLATE utf8mb4_unicode_ci NOT NULL,
+  host_count         INT(10) UNSIGNED NOT NULL DEFAULT 0,
+  status             VARCHAR(16) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT 'pending',
+  expires_at         TIMESTAMP NOT NULL,
+  reviewed_at        TIMESTAMP NULL DEFAULT NULL,
+  created_at         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (id),
+  KEY idx_mdm_apple_profile_changes_profile_id (profile_id),
+  KEY idx_mdm_apple_profile_changes_status (status)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create mdm_apple_profile_changes table")
+}
+
+func Down_20230630091200(tx *sql.Tx) error {
+	return nil
+}
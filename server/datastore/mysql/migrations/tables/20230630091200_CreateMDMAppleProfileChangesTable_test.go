@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230630091200(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`
+		INSERT INTO mdm_apple_profile_changes
+			(profile_id, profile_identifier, profile_name, checksum, operation_type, host_count, expires_at)
+		VALUES
+			(1, 'com.example', 'Example', 'abcdefghijklmnop', 'install', 600, NOW())`)
+	require.NoError(t, err)
+
+	var status string
+	err = db.Get(&status, `SELECT status FROM mdm_apple_profile_changes WHERE profile_id = 1`)
+	require.NoError(t, err)
+	require.Equal(t, "pending", status)
+}
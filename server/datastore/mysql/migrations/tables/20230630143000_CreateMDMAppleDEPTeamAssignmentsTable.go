@@ -0,0 +1,31 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230630143000, Down_20230630143000)
+}
+
+func Up_20230630143000(tx *sql.Tx) error {
+	// the team of a serial number is used instead of the default team when
+	// the device is first synced from Apple Business Manager.
+	_, err := tx.Exec(`
+CREATE TABLE mdm_apple_dep_team_assignments (
+  serial_number VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  team_id       INT(10) UNSIGNED NOT NULL,
+  created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (serial_number),
+  CONSTRAINT fk_mdm_apple_dep_team_assignments_team_id FOREIGN KEY (team_id) REFERENCES teams (id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create mdm_apple_dep_team_assignments table")
+}
+
+func Down_20230630143000(tx *sql.Tx) error {
+	return nil
+}
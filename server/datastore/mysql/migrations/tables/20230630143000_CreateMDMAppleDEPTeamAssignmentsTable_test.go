@@ -0,0 +1,29 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230630143000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	res, err := db.Exec(`INSERT INTO teams (name) VALUES ('team1')`)
+	require.NoError(t, err)
+	teamID, _ := res.LastInsertId()
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err = db.Exec(`INSERT INTO mdm_apple_dep_team_assignments (serial_number, team_id) VALUES ('ABC', ?)`, teamID)
+	require.NoError(t, err)
+
+	// the assignments of a deleted team are deleted
+	_, err = db.Exec(`DELETE FROM teams WHERE id = ?`, teamID)
+	require.NoError(t, err)
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM mdm_apple_dep_team_assignments`)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
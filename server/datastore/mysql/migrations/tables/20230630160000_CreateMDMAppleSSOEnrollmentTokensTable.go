@@ -0,0 +1,34 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230630160000, Down_20230630160000)
+}
+
+func Up_20230630160000(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+CREATE TABLE mdm_apple_sso_enrollment_tokens (
+  id               int(10) unsigned NOT NULL AUTO_INCREMENT,
+  token            varchar(64) COLLATE utf8mb4_unicode_ci NOT NULL,
+  idp_account_uuid varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  expires_at       timestamp NOT NULL,
+  consumed_at      timestamp NULL DEFAULT NULL,
+  revoked_at       timestamp NULL DEFAULT NULL,
+  created_at       timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (id),
+  UNIQUE KEY idx_mdm_apple_sso_enrollment_tokens_token (token),
+  FOREIGN KEY (idp_account_uuid) REFERENCES mdm_idp_accounts (uuid) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+`)
+	return errors.Wrap(err, "create mdm_apple_sso_enrollment_tokens table")
+}
+
+func Down_20230630160000(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,32 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230630160000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`INSERT INTO mdm_idp_accounts (uuid, username, salt, entropy, iterations) VALUES ('acc-1', 'user', '', '', 0)`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err = db.Exec(`INSERT INTO mdm_apple_sso_enrollment_tokens (token, idp_account_uuid, expires_at) VALUES ('abc', 'acc-1', NOW() + INTERVAL 15 MINUTE)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO mdm_apple_sso_enrollment_tokens (token, idp_account_uuid, expires_at) VALUES ('abc', 'acc-1', NOW())`)
+	require.ErrorContains(t, err, "Duplicate entry")
+	_, err = db.Exec(`INSERT INTO mdm_apple_sso_enrollment_tokens (token, idp_account_uuid, expires_at) VALUES ('def', 'no-such-account', NOW())`)
+	require.ErrorContains(t, err, "foreign key constraint fails")
+
+	// deleting the account deletes its tokens
+	_, err = db.Exec(`DELETE FROM mdm_idp_accounts WHERE uuid = 'acc-1'`)
+	require.NoError(t, err)
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM mdm_apple_sso_enrollment_tokens`)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
@@ -0,0 +1,33 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230703100000, Down_20230703100000)
+}
+
+func Up_20230703100000(tx *sql.Tx) error {
+	// the auto-increment id is the cursor of the MDM changes API, it is a
+	// bigint as the table records every profile status transition.
+	_, err := tx.Exec(`
+CREATE TABLE host_mdm_changes (
+  id          bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  host_uuid   varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  change_type varchar(31) COLLATE utf8mb4_unicode_ci NOT NULL,
+  details     json DEFAULT NULL,
+  created_at  timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (id),
+  KEY idx_host_mdm_changes_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+`)
+	return errors.Wrap(err, "create host_mdm_changes table")
+}
+
+func Down_20230703100000(tx *sql.Tx) error {
+	return nil
+}
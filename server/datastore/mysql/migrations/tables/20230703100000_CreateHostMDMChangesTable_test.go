@@ -0,0 +1,23 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230703100000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO host_mdm_changes (host_uuid, change_type, details) VALUES ('uuid-1', 'enrolled', '{"serial_number": "ABC"}'), ('uuid-1', 'unenrolled', NULL)`)
+	require.NoError(t, err)
+
+	var ids []uint64
+	err = db.Select(&ids, `SELECT id FROM host_mdm_changes ORDER BY id`)
+	require.NoError(t, err)
+	require.Len(t, ids, 2)
+	require.Less(t, ids[0], ids[1])
+}
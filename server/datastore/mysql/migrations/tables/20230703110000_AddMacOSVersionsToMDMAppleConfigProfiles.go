@@ -0,0 +1,24 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230703110000, Down_20230703110000)
+}
+
+func Up_20230703110000(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE mdm_apple_configuration_profiles
+  ADD COLUMN min_macos_version varchar(50) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  ADD COLUMN max_macos_version varchar(50) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT ''
+`)
+	return errors.Wrap(err, "add macOS versions to mdm_apple_configuration_profiles")
+}
+
+func Down_20230703110000(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,30 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230703110000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`INSERT INTO mdm_apple_configuration_profiles (team_id, identifier, name, mobileconfig, checksum) VALUES (0, 'com.example', 'Example', '<plist></plist>', '1234567890123456')`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	// existing profiles have no version range
+	var versions struct {
+		Min string `db:"min_macos_version"`
+		Max string `db:"max_macos_version"`
+	}
+	err = db.Get(&versions, `SELECT min_macos_version, max_macos_version FROM mdm_apple_configuration_profiles WHERE identifier = 'com.example'`)
+	require.NoError(t, err)
+	require.Empty(t, versions.Min)
+	require.Empty(t, versions.Max)
+
+	_, err = db.Exec(`UPDATE mdm_apple_configuration_profiles SET min_macos_version = '13.0', max_macos_version = '14' WHERE identifier = 'com.example'`)
+	require.NoError(t, err)
+}
@@ -0,0 +1,35 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230703120000, Down_20230703120000)
+}
+
+func Up_20230703120000(tx *sql.Tx) error {
+	// a host has at most one assigned user, source is where the assignment
+	// comes from: "sso" if the user authenticated during the MDM enrollment,
+	// "api" if it was set via the REST API or "idp_sync" if it was synced from
+	// the IdP.
+	_, err := tx.Exec(`
+CREATE TABLE host_assigned_users (
+  host_id    INT(10) UNSIGNED NOT NULL,
+  email      VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  full_name  VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  source     VARCHAR(32) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (host_id),
+  KEY idx_host_assigned_users_email (email)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create host_assigned_users table")
+}
+
+func Down_20230703120000(tx *sql.Tx) error {
+	return nil
+}
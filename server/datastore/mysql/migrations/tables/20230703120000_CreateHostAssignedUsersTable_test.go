@@ -0,0 +1,28 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230703120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO host_assigned_users (host_id, email, full_name, source) VALUES (1, 'jane@example.com', 'Jane Doe', 'sso')`)
+	require.NoError(t, err)
+
+	// a host has at most one assigned user
+	_, err = db.Exec(`INSERT INTO host_assigned_users (host_id, email, source) VALUES (1, 'john@example.com', 'api')`)
+	require.Error(t, err)
+	_, err = db.Exec(`INSERT INTO host_assigned_users (host_id, email, source) VALUES (2, 'jane@example.com', 'api')`)
+	require.NoError(t, err)
+
+	var fullName string
+	err = db.Get(&fullName, `SELECT full_name FROM host_assigned_users WHERE host_id = 2`)
+	require.NoError(t, err)
+	require.Empty(t, fullName)
+}
@@ -0,0 +1,91 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230704120000, Down_20230704120000)
+}
+
+func Up_20230704120000(tx *sql.Tx) error {
+	// the users and groups pushed by the IdP via the SCIM API. The SCIM id of
+	// the resources is the auto-increment id, the external_id is the IdP's own
+	// identifier.
+	_, err := tx.Exec(`
+CREATE TABLE scim_users (
+  id          INT(10) UNSIGNED NOT NULL AUTO_INCREMENT,
+  external_id VARCHAR(255) COLLATE utf8mb4_unicode_ci NULL,
+  user_name   VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  given_name  VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  family_name VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  email       VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  active      TINYINT(1) NOT NULL DEFAULT 1,
+  created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (id),
+  UNIQUE KEY idx_scim_users_user_name (user_name),
+  KEY idx_scim_users_email (email)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	if err != nil {
+		return errors.Wrap(err, "create scim_users table")
+	}
+
+	_, err = tx.Exec(`
+CREATE TABLE scim_groups (
+  id           INT(10) UNSIGNED NOT NULL AUTO_INCREMENT,
+  external_id  VARCHAR(255) COLLATE utf8mb4_unicode_ci NULL,
+  display_name VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (id),
+  UNIQUE KEY idx_scim_groups_display_name (display_name)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	if err != nil {
+		return errors.Wrap(err, "create scim_groups table")
+	}
+
+	_, err = tx.Exec(`
+CREATE TABLE scim_user_groups (
+  group_id     INT(10) UNSIGNED NOT NULL,
+  scim_user_id INT(10) UNSIGNED NOT NULL,
+
+  PRIMARY KEY (group_id, scim_user_id),
+  KEY idx_scim_user_groups_scim_user_id (scim_user_id),
+  FOREIGN KEY (group_id) REFERENCES scim_groups (id) ON DELETE CASCADE,
+  FOREIGN KEY (scim_user_id) REFERENCES scim_users (id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	if err != nil {
+		return errors.Wrap(err, "create scim_user_groups table")
+	}
+
+	// the outcome of the last SCIM request that changed the users or groups,
+	// a single row is stored.
+	_, err = tx.Exec(`
+CREATE TABLE scim_last_request (
+  id         TINYINT(1) UNSIGNED NOT NULL,
+  status     VARCHAR(31) COLLATE utf8mb4_unicode_ci NOT NULL,
+  details    TEXT COLLATE utf8mb4_unicode_ci NOT NULL,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (id)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	if err != nil {
+		return errors.Wrap(err, "create scim_last_request table")
+	}
+
+	// the hosts whose assigned user is a member of the IdP group are excluded
+	// from the profile.
+	_, err = tx.Exec(`
+ALTER TABLE mdm_apple_configuration_profile_exclusions
+  ADD COLUMN idp_group VARCHAR(255) COLLATE utf8mb4_unicode_ci NULL DEFAULT NULL AFTER host_id`)
+	return errors.Wrap(err, "add idp_group to mdm_apple_configuration_profile_exclusions")
+}
+
+func Down_20230704120000(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,42 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230704120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`INSERT INTO mdm_apple_configuration_profile_exclusions (team_id, profile_identifier, label_id) VALUES (0, 'com.example', 1)`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var idpGroup *string
+	err = db.Get(&idpGroup, `SELECT idp_group FROM mdm_apple_configuration_profile_exclusions`)
+	require.NoError(t, err)
+	require.Nil(t, idpGroup)
+
+	res, err := db.Exec(`INSERT INTO scim_users (user_name, email) VALUES ('jane@example.com', 'jane@example.com')`)
+	require.NoError(t, err)
+	userID, _ := res.LastInsertId()
+	_, err = db.Exec(`INSERT INTO scim_users (user_name) VALUES ('JANE@example.com')`)
+	require.Error(t, err) // user names are unique, case-insensitive
+
+	res, err = db.Exec(`INSERT INTO scim_groups (display_name) VALUES ('Engineering')`)
+	require.NoError(t, err)
+	groupID, _ := res.LastInsertId()
+	_, err = db.Exec(`INSERT INTO scim_user_groups (group_id, scim_user_id) VALUES (?, ?)`, groupID, userID)
+	require.NoError(t, err)
+
+	// memberships are deleted with the user
+	_, err = db.Exec(`DELETE FROM scim_users WHERE id = ?`, userID)
+	require.NoError(t, err)
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM scim_user_groups`)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
@@ -0,0 +1,36 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230705120000, Down_20230705120000)
+}
+
+func Up_20230705120000(tx *sql.Tx) error {
+	// the DEP profile assigned to a host instead of the default one. A NULL
+	// profile means that the override was cleared and the default profile must
+	// be assigned again, the row is deleted once it is. profile_uuid is the
+	// UUID returned by Apple when the profile was defined, and assigned_at is
+	// NULL until the profile is assigned to the host's serial number.
+	_, err := tx.Exec(`
+CREATE TABLE host_mdm_apple_dep_profile_overrides (
+  host_id      INT(10) UNSIGNED NOT NULL,
+  profile      JSON NULL,
+  profile_uuid VARCHAR(37) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+  assigned_at  TIMESTAMP NULL,
+  created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (host_id),
+  KEY idx_host_mdm_apple_dep_profile_overrides_assigned_at (assigned_at)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	return errors.Wrap(err, "create host_mdm_apple_dep_profile_overrides table")
+}
+
+func Down_20230705120000(tx *sql.Tx) error {
+	return nil
+}
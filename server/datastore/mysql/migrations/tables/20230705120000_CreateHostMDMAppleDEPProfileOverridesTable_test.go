@@ -0,0 +1,34 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230705120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO host_mdm_apple_dep_profile_overrides (host_id, profile) VALUES (1, '{"profile_name": "kiosk"}')`)
+	require.NoError(t, err)
+
+	// a host has at most one override
+	_, err = db.Exec(`INSERT INTO host_mdm_apple_dep_profile_overrides (host_id, profile) VALUES (1, '{"profile_name": "other"}')`)
+	require.Error(t, err)
+
+	// a cleared override has no profile
+	_, err = db.Exec(`INSERT INTO host_mdm_apple_dep_profile_overrides (host_id) VALUES (2)`)
+	require.NoError(t, err)
+
+	var row struct {
+		ProfileUUID string  `db:"profile_uuid"`
+		AssignedAt  *string `db:"assigned_at"`
+	}
+	err = db.Get(&row, `SELECT profile_uuid, assigned_at FROM host_mdm_apple_dep_profile_overrides WHERE host_id = 1`)
+	require.NoError(t, err)
+	require.Empty(t, row.ProfileUUID)
+	require.Nil(t, row.AssignedAt)
+}
@@ -0,0 +1,23 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230706120000, Down_20230706120000)
+}
+
+func Up_20230706120000(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE policies
+  ADD COLUMN mdm_conditions JSON NULL
+`)
+	return errors.Wrap(err, "add mdm_conditions to policies")
+}
+
+func Down_20230706120000(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230706120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`INSERT INTO policies (name, query, description) VALUES ('p1', 'SELECT 1;', '')`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	// existing policies have no MDM conditions
+	var conds *string
+	err = db.Get(&conds, `SELECT mdm_conditions FROM policies WHERE name = 'p1'`)
+	require.NoError(t, err)
+	require.Nil(t, conds)
+
+	_, err = db.Exec(`UPDATE policies SET mdm_conditions = '[{"kind": "disk_encryption", "status": "verifying"}]' WHERE name = 'p1'`)
+	require.NoError(t, err)
+}
@@ -0,0 +1,86 @@
+package tables
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec // used only to detect changes of the profiles
+	"crypto/sha256"
+	"database/sql"
+
+	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230707120000, Down_20230707120000)
+}
+
+func Up_20230707120000(tx *sql.Tx) error {
+	// the checksums of the profiles are now computed on their canonical form.
+	// The algorithm of the existing checksums (MD5 or truncated SHA-256,
+	// depending on the server configuration) is detected from the checksum
+	// itself, and the profiles that match neither are left as-is.
+	rows, err := tx.Query(`SELECT profile_id, mobileconfig, checksum FROM mdm_apple_configuration_profiles`)
+	if err != nil {
+		return errors.Wrap(err, "select mdm apple configuration profiles")
+	}
+	type rehash struct {
+		oldSum, newSum []byte
+	}
+	rehashes := make(map[uint]rehash)
+	for rows.Next() {
+		var (
+			profileID uint
+			mc        []byte
+			checksum  []byte
+		)
+		if err := rows.Scan(&profileID, &mc, &checksum); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "scan mdm apple configuration profile")
+		}
+		canonical, err := mobileconfig.Mobileconfig(mc).Canonical()
+		if err != nil {
+			continue
+		}
+
+		var newSum []byte
+		md5Sum := md5.Sum(mc) //nolint:gosec
+		sha256Sum := sha256.Sum256(mc)
+		switch {
+		case bytes.Equal(checksum, md5Sum[:]):
+			sum := md5.Sum(canonical) //nolint:gosec
+			newSum = sum[:]
+		case bytes.Equal(checksum, sha256Sum[:md5.Size]):
+			sum := sha256.Sum256(canonical)
+			newSum = sum[:md5.Size]
+		default:
+			continue
+		}
+		if !bytes.Equal(checksum, newSum) {
+			rehashes[profileID] = rehash{oldSum: checksum, newSum: newSum}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return errors.Wrap(err, "iterate mdm apple configuration profiles")
+	}
+	rows.Close()
+
+	// the hosts that have the current version of a profile get the new
+	// checksum too, so that they don't reinstall it.
+	for profileID, r := range rehashes {
+		if _, err := tx.Exec(`UPDATE mdm_apple_configuration_profiles SET checksum = ? WHERE profile_id = ?`, r.newSum, profileID); err != nil {
+			return errors.Wrap(err, "update mdm apple configuration profile checksum")
+		}
+		if _, err := tx.Exec(`UPDATE host_mdm_apple_profiles SET checksum = ? WHERE profile_id = ? AND checksum = ?`, r.newSum, profileID, r.oldSum); err != nil {
+			return errors.Wrap(err, "update host mdm apple profiles checksum")
+		}
+		if _, err := tx.Exec(`UPDATE mdm_apple_profile_changes SET checksum = ? WHERE profile_id = ? AND checksum = ?`, r.newSum, profileID, r.oldSum); err != nil {
+			return errors.Wrap(err, "update mdm apple profile changes checksum")
+		}
+	}
+	return nil
+}
+
+func Down_20230707120000(tx *sql.Tx) error {
+	return nil
+}
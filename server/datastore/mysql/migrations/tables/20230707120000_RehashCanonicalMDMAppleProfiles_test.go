@@ -0,0 +1,62 @@
+package tables
+
+import (
+	"crypto/md5" //nolint:gosec // used only to detect changes of the profiles
+	"crypto/sha256"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230707120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	mc := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0"><dict>
+  <key>PayloadVersion</key><integer>1</integer>
+  <key>PayloadUUID</key><string>uuid</string>
+  <key>PayloadType</key><string>Configuration</string>
+  <key>PayloadIdentifier</key><string>I1</string>
+  <key>PayloadDisplayName</key><string>N1</string>
+  <key>PayloadContent</key><array/>
+</dict></plist>`)
+	canonical, err := mobileconfig.Mobileconfig(mc).Canonical()
+	require.NoError(t, err)
+
+	md5Old, md5New := md5.Sum(mc), md5.Sum(canonical) //nolint:gosec
+	sha256Old, sha256New := sha256.Sum256(mc), sha256.Sum256(canonical)
+	invalid := []byte("<?xml not a plist")
+	md5Invalid := md5.Sum(invalid) //nolint:gosec
+
+	insertProf := `INSERT INTO mdm_apple_configuration_profiles (team_id, identifier, name, mobileconfig, checksum) VALUES (?, ?, ?, ?, ?)`
+	_, err = db.Exec(insertProf, 1, "I1", "N1", mc, md5Old[:])
+	require.NoError(t, err)
+	_, err = db.Exec(insertProf, 2, "I1", "N1", mc, sha256Old[:16])
+	require.NoError(t, err)
+	_, err = db.Exec(insertProf, 3, "I1", "N1", invalid, md5Invalid[:])
+	require.NoError(t, err)
+
+	// host1 has the current version of the first profile, host2 an older one
+	insertHostProf := `INSERT INTO host_mdm_apple_profiles (profile_id, profile_identifier, host_uuid, status, operation_type, command_uuid, checksum) VALUES (?, ?, ?, 'verifying', 'install', ?, ?)`
+	_, err = db.Exec(insertHostProf, 1, "I1", "host1", "cmd1", md5Old[:])
+	require.NoError(t, err)
+	_, err = db.Exec(insertHostProf, 1, "I1", "host2", "cmd2", []byte("0123456789abcdef"))
+	require.NoError(t, err)
+	_, err = db.Exec(insertHostProf, 2, "I1", "host3", "cmd3", sha256Old[:16])
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var sums [][]byte
+	err = db.Select(&sums, `SELECT checksum FROM mdm_apple_configuration_profiles ORDER BY profile_id`)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{md5New[:], sha256New[:16], md5Invalid[:]}, sums)
+
+	sums = nil
+	err = db.Select(&sums, `SELECT checksum FROM host_mdm_apple_profiles ORDER BY host_uuid`)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{md5New[:], []byte("0123456789abcdef"), sha256New[:16]}, sums)
+}
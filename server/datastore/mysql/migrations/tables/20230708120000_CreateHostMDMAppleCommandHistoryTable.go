@@ -0,0 +1,55 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230708120000, Down_20230708120000)
+}
+
+func Up_20230708120000(tx *sql.Tx) error {
+	// the history of the MDM commands sent to the hosts, kept independently of
+	// the nano tables so that it survives the purge of the hosts' queues. The
+	// column sizes match the ones of the nano tables.
+	_, err := tx.Exec(`
+CREATE TABLE host_mdm_apple_command_history (
+  host_uuid    VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  command_uuid VARCHAR(127) COLLATE utf8mb4_unicode_ci NOT NULL,
+  request_type VARCHAR(63) COLLATE utf8mb4_unicode_ci NOT NULL,
+  status       VARCHAR(31) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT 'Pending',
+  created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (host_uuid, command_uuid),
+  KEY idx_host_mdm_apple_command_history_created_at (created_at)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	if err != nil {
+		return errors.Wrap(err, "create host_mdm_apple_command_history table")
+	}
+
+	// backfill the history with the commands still in the nano tables.
+	_, err = tx.Exec(`
+INSERT INTO host_mdm_apple_command_history
+  (host_uuid, command_uuid, request_type, status, created_at, updated_at)
+SELECT
+  neq.id,
+  neq.command_uuid,
+  nc.request_type,
+  COALESCE(NULLIF(ncr.status, ''), IF(neq.active = 1, 'Pending', 'Canceled')),
+  neq.created_at,
+  COALESCE(ncr.updated_at, neq.updated_at)
+FROM
+  nano_enrollment_queue neq
+JOIN
+  nano_commands nc ON nc.command_uuid = neq.command_uuid
+LEFT JOIN
+  nano_command_results ncr ON ncr.id = neq.id AND ncr.command_uuid = neq.command_uuid`)
+	return errors.Wrap(err, "backfill host_mdm_apple_command_history")
+}
+
+func Down_20230708120000(tx *sql.Tx) error {
+	return nil
+}
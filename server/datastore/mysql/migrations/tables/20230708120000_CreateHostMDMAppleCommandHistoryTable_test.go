@@ -0,0 +1,59 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230708120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`INSERT INTO nano_devices (id, authenticate) VALUES ('uuid-1', 'auth')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		INSERT INTO nano_enrollments (id, device_id, type, topic, push_magic, token_hex)
+		VALUES ('uuid-1', 'uuid-1', 'Device', 'topic', 'magic', 'abcd')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		INSERT INTO nano_commands (command_uuid, request_type, command)
+		VALUES ('cmd-1', 'ProfileList', '<?xml'), ('cmd-2', 'InstallProfile', '<?xml'), ('cmd-3', 'DeviceLock', '<?xml')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		INSERT INTO nano_enrollment_queue (id, command_uuid, active)
+		VALUES ('uuid-1', 'cmd-1', 1), ('uuid-1', 'cmd-2', 1), ('uuid-1', 'cmd-3', 0)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		INSERT INTO nano_command_results (id, command_uuid, status, result)
+		VALUES ('uuid-1', 'cmd-2', 'Error', '<?xml')`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	type historyRow struct {
+		CommandUUID string `db:"command_uuid"`
+		RequestType string `db:"request_type"`
+		Status      string `db:"status"`
+	}
+	var rows []historyRow
+	err = db.Select(&rows, `SELECT command_uuid, request_type, status FROM host_mdm_apple_command_history WHERE host_uuid = 'uuid-1' ORDER BY command_uuid`)
+	require.NoError(t, err)
+	require.Equal(t, []historyRow{
+		{"cmd-1", "ProfileList", "Pending"},
+		{"cmd-2", "InstallProfile", "Error"},
+		{"cmd-3", "DeviceLock", "Canceled"},
+	}, rows)
+
+	// the history is kept when the nano data of the host is deleted
+	_, err = db.Exec(`DELETE FROM nano_enrollments WHERE id = 'uuid-1'`)
+	require.NoError(t, err)
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM host_mdm_apple_command_history`)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	// a command is recorded once per host
+	_, err = db.Exec(`INSERT INTO host_mdm_apple_command_history (host_uuid, command_uuid, request_type) VALUES ('uuid-1', 'cmd-1', 'ProfileList')`)
+	require.Error(t, err)
+}
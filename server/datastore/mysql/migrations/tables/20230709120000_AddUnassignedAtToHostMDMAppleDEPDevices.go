@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230709120000, Down_20230709120000)
+}
+
+func Up_20230709120000(tx *sql.Tx) error {
+	// unassigned_at is set when the DEP sync reports that the device was
+	// removed from Fleet's MDM server in Apple Business Manager, and cleared
+	// when it is assigned to it again.
+	_, err := tx.Exec(`
+ALTER TABLE host_mdm_apple_dep_devices
+  ADD COLUMN unassigned_at TIMESTAMP NULL DEFAULT NULL
+`)
+	return errors.Wrap(err, "add unassigned_at to host_mdm_apple_dep_devices")
+}
+
+func Down_20230709120000(tx *sql.Tx) error {
+	return nil
+}
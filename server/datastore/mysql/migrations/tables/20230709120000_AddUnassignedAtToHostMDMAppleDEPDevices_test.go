@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230709120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`INSERT INTO host_mdm_apple_dep_devices (host_id, device_family) VALUES (1, 'Mac')`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	// existing devices are assigned
+	var unassignedAt *string
+	err = db.Get(&unassignedAt, `SELECT unassigned_at FROM host_mdm_apple_dep_devices WHERE host_id = 1`)
+	require.NoError(t, err)
+	require.Nil(t, unassignedAt)
+
+	_, err = db.Exec(`UPDATE host_mdm_apple_dep_devices SET unassigned_at = CURRENT_TIMESTAMP WHERE host_id = 1`)
+	require.NoError(t, err)
+}
@@ -0,0 +1,38 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230710120000, Down_20230710120000)
+}
+
+func Up_20230710120000(tx *sql.Tx) error {
+	// the daily MDM posture score of each team. As in aggregated_stats, the
+	// score of all hosts is stored with global_stats = 1, and the score of
+	// the hosts in no team with team_id = 0.
+	_, err := tx.Exec(`
+CREATE TABLE mdm_posture_scores (
+  team_id      INT(10) UNSIGNED NOT NULL DEFAULT 0,
+  global_stats TINYINT(1) NOT NULL DEFAULT 0,
+  score_date   DATE NOT NULL,
+  score        DOUBLE DEFAULT NULL,
+  components   JSON DEFAULT NULL,
+  created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (team_id, global_stats, score_date),
+  KEY idx_mdm_posture_scores_score_date (score_date)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	if err != nil {
+		return errors.Wrap(err, "create mdm_posture_scores table")
+	}
+	return nil
+}
+
+func Down_20230710120000(tx *sql.Tx) error {
+	return nil
+}
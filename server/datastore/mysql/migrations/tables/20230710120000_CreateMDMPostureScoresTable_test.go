@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230710120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO mdm_posture_scores (team_id, global_stats, score_date, score) VALUES (0, 1, '2023-07-10', 87.5), (0, 0, '2023-07-10', NULL)`)
+	require.NoError(t, err)
+
+	// a single score per team and day
+	_, err = db.Exec(`INSERT INTO mdm_posture_scores (team_id, global_stats, score_date, score) VALUES (0, 1, '2023-07-10', 90)`)
+	require.Error(t, err)
+
+	var score *float64
+	err = db.Get(&score, `SELECT score FROM mdm_posture_scores WHERE team_id = 0 AND global_stats = 0`)
+	require.NoError(t, err)
+	require.Nil(t, score)
+}
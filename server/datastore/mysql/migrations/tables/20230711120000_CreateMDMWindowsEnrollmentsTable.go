@@ -0,0 +1,48 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230711120000, Down_20230711120000)
+}
+
+func Up_20230711120000(tx *sql.Tx) error {
+	// the Windows devices enrolled in Fleet's MDM, the counterpart of the
+	// nano_devices and nano_enrollments tables of the Apple MDM. The
+	// host_uuid is filled when the host enrolls in Fleet with orbit.
+	_, err := tx.Exec(`
+CREATE TABLE mdm_windows_enrollments (
+  id                    INT(10) UNSIGNED NOT NULL AUTO_INCREMENT,
+  mdm_device_id         VARCHAR(255) NOT NULL,
+  mdm_hardware_id       VARCHAR(255) NOT NULL,
+  device_state          VARCHAR(255) NOT NULL,
+  device_type           VARCHAR(255) NOT NULL,
+  device_name           VARCHAR(255) NOT NULL,
+  enroll_type           VARCHAR(255) NOT NULL,
+  enroll_user_id        VARCHAR(255) NOT NULL,
+  enroll_proto_version  VARCHAR(255) NOT NULL,
+  enroll_client_version VARCHAR(255) NOT NULL,
+  not_in_oobe           TINYINT(1) NOT NULL DEFAULT 0,
+  identity_cert_serial  VARCHAR(255) NOT NULL DEFAULT '',
+  team_id               INT(10) UNSIGNED DEFAULT NULL,
+  host_uuid             VARCHAR(255) NOT NULL DEFAULT '',
+  created_at            TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at            TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (id),
+  UNIQUE KEY idx_mdm_windows_enrollments_mdm_device_id (mdm_device_id),
+  KEY idx_mdm_windows_enrollments_host_uuid (host_uuid)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	if err != nil {
+		return errors.Wrap(err, "create mdm_windows_enrollments table")
+	}
+	return nil
+}
+
+func Down_20230711120000(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,32 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230711120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	const insertStmt = `
+INSERT INTO mdm_windows_enrollments
+  (mdm_device_id, mdm_hardware_id, device_state, device_type, device_name, enroll_type, enroll_user_id, enroll_proto_version, enroll_client_version)
+VALUES
+  (?, 'hw1', 'Enrolled', 'CIMClient_Windows', 'DESKTOP-1', 'Full', '', '4.0', '10.0.19045.2965')`
+
+	_, err := db.Exec(insertStmt, "device1")
+	require.NoError(t, err)
+
+	// the device ID is unique
+	_, err = db.Exec(insertStmt, "device1")
+	require.Error(t, err)
+
+	var teamID *uint
+	err = db.Get(&teamID, `SELECT team_id FROM mdm_windows_enrollments WHERE mdm_device_id = 'device1'`)
+	require.NoError(t, err)
+	require.Nil(t, teamID)
+}
@@ -0,0 +1,29 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230712120000, Down_20230712120000)
+}
+
+func Up_20230712120000(tx *sql.Tx) error {
+	// retry_count is the number of times the failed profile was retried by the
+	// profile retry policy, and retry_at the time of the next retry, NULL if
+	// none is scheduled.
+	_, err := tx.Exec(`
+ALTER TABLE host_mdm_apple_profiles
+  ADD COLUMN retry_count INT(10) UNSIGNED NOT NULL DEFAULT 0,
+  ADD COLUMN retry_at TIMESTAMP NULL DEFAULT NULL`)
+	if err != nil {
+		return errors.Wrap(err, "add retry columns to host_mdm_apple_profiles")
+	}
+	return nil
+}
+
+func Down_20230712120000(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,32 @@
+package tables
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230712120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`
+    INSERT INTO host_mdm_apple_profiles (profile_id, profile_identifier, host_uuid, command_uuid, checksum)
+    VALUES (1, 'com.example', 'host-uuid', 'cmd-uuid', UNHEX(MD5('abc')))`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var state struct {
+		RetryCount int        `db:"retry_count"`
+		RetryAt    *time.Time `db:"retry_at"`
+	}
+	err = db.Get(&state, `SELECT retry_count, retry_at FROM host_mdm_apple_profiles WHERE host_uuid = 'host-uuid'`)
+	require.NoError(t, err)
+	require.Zero(t, state.RetryCount)
+	require.Nil(t, state.RetryAt)
+
+	_, err = db.Exec(`UPDATE host_mdm_apple_profiles SET retry_count = 1, retry_at = NOW() WHERE host_uuid = 'host-uuid'`)
+	require.NoError(t, err)
+}
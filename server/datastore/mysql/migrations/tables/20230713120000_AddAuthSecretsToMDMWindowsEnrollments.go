@@ -0,0 +1,30 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230713120000, Down_20230713120000)
+}
+
+func Up_20230713120000(tx *sql.Tx) error {
+	// the secrets of the DM protocol digest authentication, generated for each
+	// device when it enrolls. The devices enrolled before the migration keep
+	// the placeholder credentials of their provisioning document until they
+	// enroll again.
+	_, err := tx.Exec(`
+ALTER TABLE mdm_windows_enrollments
+  ADD COLUMN client_auth_secret VARCHAR(255) NOT NULL DEFAULT '',
+  ADD COLUMN server_auth_secret VARCHAR(255) NOT NULL DEFAULT ''`)
+	if err != nil {
+		return errors.Wrap(err, "add auth secret columns to mdm_windows_enrollments")
+	}
+	return nil
+}
+
+func Down_20230713120000(tx *sql.Tx) error {
+	return nil
+}
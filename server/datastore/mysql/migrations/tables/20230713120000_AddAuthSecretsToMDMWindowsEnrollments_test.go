@@ -0,0 +1,32 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230713120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`
+    INSERT INTO mdm_windows_enrollments (mdm_device_id, mdm_hardware_id, device_state, device_type, device_name,
+      enroll_type, enroll_user_id, enroll_proto_version, enroll_client_version)
+    VALUES ('device1', 'hw1', 'MDMDeviceEnrolled', 'CIMClient_Windows', 'DESKTOP-1', 'Full', '', '4.0', '10.0')`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var secrets struct {
+		ClientAuthSecret string `db:"client_auth_secret"`
+		ServerAuthSecret string `db:"server_auth_secret"`
+	}
+	err = db.Get(&secrets, `SELECT client_auth_secret, server_auth_secret FROM mdm_windows_enrollments WHERE mdm_device_id = 'device1'`)
+	require.NoError(t, err)
+	require.Empty(t, secrets.ClientAuthSecret)
+	require.Empty(t, secrets.ServerAuthSecret)
+
+	_, err = db.Exec(`UPDATE mdm_windows_enrollments SET client_auth_secret = 'a', server_auth_secret = 'b' WHERE mdm_device_id = 'device1'`)
+	require.NoError(t, err)
+}
@@ -0,0 +1,37 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230714120000, Down_20230714120000)
+}
+
+func Up_20230714120000(tx *sql.Tx) error {
+	// the accesses are kept when the user is deleted, with the name and email
+	// of the user recorded at the time of the access.
+	_, err := tx.Exec(`
+UPDATE host_disk_encryption_key_accesses a
+  LEFT JOIN users u ON u.id = a.user_id
+SET a.user_id = NULL
+WHERE a.user_id IS NOT NULL AND u.id IS NULL`)
+	if err != nil {
+		return errors.Wrap(err, "clear deleted users of host_disk_encryption_key_accesses")
+	}
+
+	_, err = tx.Exec(`
+ALTER TABLE host_disk_encryption_key_accesses
+  ADD CONSTRAINT fk_host_disk_encryption_key_accesses_user_id
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE SET NULL`)
+	if err != nil {
+		return errors.Wrap(err, "add user foreign key to host_disk_encryption_key_accesses")
+	}
+	return nil
+}
+
+func Down_20230714120000(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,43 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230714120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	res, err := db.Exec(`
+    INSERT INTO users (name, email, password, salt, global_role)
+    VALUES ('Jane', 'jane@example.com', 'pwd', 'salt', 'admin')`)
+	require.NoError(t, err)
+	userID, err := res.LastInsertId()
+	require.NoError(t, err)
+
+	// the access of a user that was already deleted, and of a current user
+	_, err = db.Exec(`
+    INSERT INTO host_disk_encryption_key_accesses (host_id, user_id, user_name, user_email, ip_address, justification)
+    VALUES (1, ?, 'Jane', 'jane@example.com', '1.2.3.4', 'Ticket 123'), (1, ?, 'John', 'john@example.com', '1.2.3.4', 'Ticket 456')`,
+		userID, userID+1)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var userIDs []*int64
+	err = db.Select(&userIDs, `SELECT user_id FROM host_disk_encryption_key_accesses ORDER BY id`)
+	require.NoError(t, err)
+	require.Len(t, userIDs, 2)
+	require.NotNil(t, userIDs[0])
+	require.Equal(t, userID, *userIDs[0])
+	require.Nil(t, userIDs[1])
+
+	// deleting the user keeps its accesses
+	_, err = db.Exec(`DELETE FROM users WHERE id = ?`, userID)
+	require.NoError(t, err)
+	err = db.Select(&userIDs, `SELECT user_id FROM host_disk_encryption_key_accesses ORDER BY id`)
+	require.NoError(t, err)
+	require.Equal(t, []*int64{nil, nil}, userIDs)
+}
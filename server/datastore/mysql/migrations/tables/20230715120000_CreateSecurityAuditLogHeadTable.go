@@ -0,0 +1,40 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230715120000, Down_20230715120000)
+}
+
+func Up_20230715120000(tx *sql.Tx) error {
+	// the single row of this table holds the hash of the last entry of the
+	// security audit log, it is locked by the writers so that the entries are
+	// chained one after the other.
+	_, err := tx.Exec(`
+CREATE TABLE security_audit_log_head (
+  id   TINYINT(1) UNSIGNED NOT NULL,
+  hash CHAR(64) NOT NULL DEFAULT '',
+
+  PRIMARY KEY (id)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+`)
+	if err != nil {
+		return errors.Wrap(err, "create security_audit_log_head table")
+	}
+
+	_, err = tx.Exec(`
+INSERT INTO security_audit_log_head (id, hash)
+SELECT 1, hash FROM security_audit_log ORDER BY id DESC LIMIT 1`)
+	if err != nil {
+		return errors.Wrap(err, "insert security audit log chain head")
+	}
+	return nil
+}
+
+func Down_20230715120000(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,28 @@
+package tables
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230715120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`
+INSERT INTO security_audit_log (created_at, action, user_id, host_id, prev_hash, hash)
+VALUES
+  (NOW(6), 'read_host_unlock_pin', 1, 1, '', REPEAT('a', 64)),
+  (NOW(6), 'read_host_unlock_pin', 1, 2, REPEAT('a', 64), REPEAT('b', 64))`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	// the chain head is the hash of the last entry
+	var hash string
+	err = db.Get(&hash, `SELECT hash FROM security_audit_log_head WHERE id = 1`)
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("b", 64), hash)
+}
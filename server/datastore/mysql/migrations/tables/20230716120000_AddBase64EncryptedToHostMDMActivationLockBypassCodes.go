@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230716120000, Down_20230716120000)
+}
+
+func Up_20230716120000(tx *sql.Tx) error {
+	// the bypass codes are now stored encrypted with the SCEP CA in
+	// base64_encrypted, the codes already escrowed in bypass_code are encrypted
+	// by the server the next time they are read.
+	_, err := tx.Exec(`
+ALTER TABLE host_mdm_activation_lock_bypass_codes
+  ADD COLUMN base64_encrypted TEXT COLLATE utf8mb4_unicode_ci NOT NULL AFTER bypass_code,
+  MODIFY bypass_code VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT ''`)
+	return errors.Wrap(err, "add base64_encrypted to host_mdm_activation_lock_bypass_codes")
+}
+
+func Down_20230716120000(tx *sql.Tx) error {
+	return nil
+}
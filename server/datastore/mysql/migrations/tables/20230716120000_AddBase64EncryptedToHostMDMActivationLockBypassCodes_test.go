@@ -0,0 +1,34 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230716120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`INSERT INTO host_mdm_activation_lock_bypass_codes (host_uuid, bypass_code) VALUES ('uuid-1', 'AAAA-BBBB')`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	// the existing code is kept until it is encrypted
+	var code struct {
+		BypassCode      string `db:"bypass_code"`
+		Base64Encrypted string `db:"base64_encrypted"`
+	}
+	err = db.Get(&code, `SELECT bypass_code, base64_encrypted FROM host_mdm_activation_lock_bypass_codes WHERE host_uuid = 'uuid-1'`)
+	require.NoError(t, err)
+	require.Equal(t, "AAAA-BBBB", code.BypassCode)
+	require.Empty(t, code.Base64Encrypted)
+
+	_, err = db.Exec(`INSERT INTO host_mdm_activation_lock_bypass_codes (host_uuid, base64_encrypted) VALUES ('uuid-2', 'ZW5jcnlwdGVk')`)
+	require.NoError(t, err)
+	err = db.Get(&code, `SELECT bypass_code, base64_encrypted FROM host_mdm_activation_lock_bypass_codes WHERE host_uuid = 'uuid-2'`)
+	require.NoError(t, err)
+	require.Empty(t, code.BypassCode)
+	require.Equal(t, "ZW5jcnlwdGVk", code.Base64Encrypted)
+}
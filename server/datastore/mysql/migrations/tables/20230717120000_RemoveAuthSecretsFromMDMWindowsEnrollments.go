@@ -0,0 +1,29 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230717120000, Down_20230717120000)
+}
+
+func Up_20230717120000(tx *sql.Tx) error {
+	// the secrets of the DM protocol digest authentication are not used, the
+	// management sessions are authenticated with the identity certificate of
+	// the device, so they are not kept in the database.
+	_, err := tx.Exec(`
+ALTER TABLE mdm_windows_enrollments
+  DROP COLUMN client_auth_secret,
+  DROP COLUMN server_auth_secret`)
+	if err != nil {
+		return errors.Wrap(err, "drop auth secret columns from mdm_windows_enrollments")
+	}
+	return nil
+}
+
+func Down_20230717120000(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,28 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230717120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`
+    INSERT INTO mdm_windows_enrollments (mdm_device_id, mdm_hardware_id, device_state, device_type, device_name,
+      enroll_type, enroll_user_id, enroll_proto_version, enroll_client_version, client_auth_secret, server_auth_secret)
+    VALUES ('device1', 'hw1', 'MDMDeviceEnrolled', 'CIMClient_Windows', 'DESKTOP-1', 'Full', '', '4.0', '10.0', 'a', 'b')`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var deviceName string
+	err = db.Get(&deviceName, `SELECT device_name FROM mdm_windows_enrollments WHERE mdm_device_id = 'device1'`)
+	require.NoError(t, err)
+	require.Equal(t, "DESKTOP-1", deviceName)
+
+	_, err = db.Exec(`SELECT client_auth_secret FROM mdm_windows_enrollments`)
+	require.ErrorContains(t, err, "Unknown column")
+}
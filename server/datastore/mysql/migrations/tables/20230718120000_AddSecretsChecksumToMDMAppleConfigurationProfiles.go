@@ -0,0 +1,28 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230718120000, Down_20230718120000)
+}
+
+func Up_20230718120000(tx *sql.Tx) error {
+	// the checksum of the versions of the secrets referenced by the profile,
+	// it is part of the checksum of the profile delivered to the hosts so
+	// that the profile is installed again when one of its secrets is rotated.
+	_, err := tx.Exec(`
+ALTER TABLE mdm_apple_configuration_profiles
+  ADD COLUMN secrets_checksum BINARY(16) NULL`)
+	if err != nil {
+		return errors.Wrap(err, "add secrets_checksum to mdm_apple_configuration_profiles")
+	}
+	return nil
+}
+
+func Down_20230718120000(tx *sql.Tx) error {
+	return nil
+}
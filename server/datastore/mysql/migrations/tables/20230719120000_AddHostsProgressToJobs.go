@@ -0,0 +1,28 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20230719120000, Down_20230719120000)
+}
+
+func Up_20230719120000(tx *sql.Tx) error {
+	// the progress of the jobs that process hosts in batches, it is NULL for
+	// the other jobs.
+	_, err := tx.Exec(`
+ALTER TABLE jobs
+  ADD COLUMN hosts_done INT UNSIGNED NULL,
+  ADD COLUMN hosts_count INT UNSIGNED NULL`)
+	if err != nil {
+		return errors.Wrap(err, "add hosts progress to jobs")
+	}
+	return nil
+}
+
+func Down_20230719120000(tx *sql.Tx) error {
+	return nil
+}
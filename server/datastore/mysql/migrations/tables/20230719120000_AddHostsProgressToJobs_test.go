@@ -0,0 +1,29 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20230719120000(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`INSERT INTO jobs (name, args, state) VALUES ('mdm_apple_bulk_set_pending', '{"team_ids": [1]}', 'queued')`)
+	require.NoError(t, err)
+
+	// Apply current migration.
+	applyNext(t, db)
+
+	var progress struct {
+		HostsDone  *int `db:"hosts_done"`
+		HostsCount *int `db:"hosts_count"`
+	}
+	err = db.Get(&progress, `SELECT hosts_done, hosts_count FROM jobs WHERE name = 'mdm_apple_bulk_set_pending'`)
+	require.NoError(t, err)
+	require.Nil(t, progress.HostsDone)
+	require.Nil(t, progress.HostsCount)
+
+	_, err = db.Exec(`UPDATE jobs SET hosts_done = 1000, hosts_count = 5000 WHERE name = 'mdm_apple_bulk_set_pending'`)
+	require.NoError(t, err)
+}
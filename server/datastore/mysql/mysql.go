@@ -22,10 +22,12 @@ import (
 	"github.com/doug-martin/goqu/v9"
 	"github.com/doug-martin/goqu/v9/exp"
 	"github.com/fleetdm/fleet/v4/server/config"
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxdb"
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/datastore/mysql/migrations/data"
 	"github.com/fleetdm/fleet/v4/server/datastore/mysql/migrations/tables"
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	apple_mdm "github.com/fleetdm/fleet/v4/server/mdm/apple"
 	"github.com/fleetdm/goose"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -34,6 +36,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	nanodep_client "github.com/micromdm/nanodep/client"
 	nanodep_mysql "github.com/micromdm/nanodep/storage/mysql"
+	"github.com/micromdm/nanomdm/mdm"
 	nanomdm_mysql "github.com/micromdm/nanomdm/storage/mysql"
 	scep_depot "github.com/micromdm/scep/v2/depot"
 	"github.com/ngrok/sqlmw"
@@ -74,6 +77,14 @@ type Datastore struct {
 	// database (see file software.go).
 	minLastOpenedAtDiff time.Duration
 
+	// hash algorithm of the checksums of the configuration profiles (see file
+	// apple_mdm.go).
+	profileChecksum fleet.MDMProfileChecksumAlgorithm
+
+	// key used to encrypt the secrets stored in the database (see file
+	// mdm_config_assets.go).
+	serverPrivateKey string
+
 	writeCh chan itemToWrite
 
 	// stmtCacheMu protects access to stmtCache.
@@ -82,6 +93,16 @@ type Datastore struct {
 	stmtCache map[string]*sqlx.Stmt
 }
 
+// readerFor returns the connection to use for the reads of the operation,
+// which is the primary if the context requires it (see ctxdb.RequirePrimary)
+// and the read replica otherwise.
+func (ds *Datastore) readerFor(ctx context.Context) dbReader {
+	if ctxdb.IsPrimaryRequired(ctx) {
+		return ds.writer
+	}
+	return ds.reader
+}
+
 // loadOrPrepareStmt will load a statement from the statements cache.
 // If not available, it will attempt to prepare (create) it.
 //
@@ -122,6 +143,7 @@ func (ds *Datastore) NewMDMAppleMDMStorage(pushCertPEM []byte, pushKeyPEM []byte
 	}
 	return &NanoMDMStorage{
 		MySQLStorage: s,
+		db:           ds.writer.DB,
 		pushCertPEM:  pushCertPEM,
 		pushKeyPEM:   pushKeyPEM,
 	}, nil
@@ -131,10 +153,128 @@ func (ds *Datastore) NewMDMAppleMDMStorage(pushCertPEM []byte, pushKeyPEM []byte
 type NanoMDMStorage struct {
 	*nanomdm_mysql.MySQLStorage
 
+	db          *sql.DB
 	pushCertPEM []byte
 	pushKeyPEM  []byte
 }
 
+// StoreCommandReport overrides nanomdm_mysql.MySQLStorage.StoreCommandReport
+// to also store the trace ID of the MDM request with the command result, and
+// to record the status of the command in the history of the commands of the
+// host.
+func (s *NanoMDMStorage) StoreCommandReport(r *mdm.Request, result *mdm.CommandResults) error {
+	if err := s.MySQLStorage.StoreCommandReport(r, result); err != nil {
+		return err
+	}
+
+	if result.Status == "Idle" || result.CommandUUID == "" {
+		return nil
+	}
+	if _, err := s.db.ExecContext(r.Context,
+		`UPDATE host_mdm_apple_command_history SET status = ? WHERE host_uuid = ? AND command_uuid = ?`,
+		result.Status, r.ID, result.CommandUUID,
+	); err != nil {
+		return err
+	}
+
+	traceID := apple_mdm.TraceIDFromRequest(r)
+	if traceID == "" {
+		return nil
+	}
+	_, err := s.db.ExecContext(r.Context,
+		`UPDATE nano_command_results SET trace_id = ? WHERE id = ? AND command_uuid = ?`,
+		traceID, r.ID, result.CommandUUID,
+	)
+	return err
+}
+
+// EnqueueCommand overrides nanomdm_mysql.MySQLStorage.EnqueueCommand to also
+// record the command in the history of the commands of the hosts. The MySQL
+// implementation always returns nil for the first return value.
+func (s *NanoMDMStorage) EnqueueCommand(ctx context.Context, ids []string, cmd *mdm.Command) (map[string]error, error) {
+	return nil, s.EnqueueCommandWithPriority(ctx, ids, cmd, 0)
+}
+
+// EnqueueCommandWithPriority enqueues the command for the enrollment ids like
+// nanomdm_mysql.MySQLStorage.EnqueueCommand, storing the priority with the
+// queued commands. Devices receive their queued commands by decreasing
+// priority (see the nano_view_queue view).
+func (s *NanoMDMStorage) EnqueueCommandWithPriority(ctx context.Context, ids []string, cmd *mdm.Command, priority int) error {
+	if len(ids) == 0 {
+		return errors.New("no id(s) supplied to queue command to")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := enqueueNanoCommand(ctx, tx, ids, cmd, priority); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func enqueueNanoCommand(ctx context.Context, tx *sql.Tx, ids []string, cmd *mdm.Command, priority int) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO nano_commands (command_uuid, request_type, command) VALUES (?, ?, ?)`,
+		cmd.CommandUUID, cmd.Command.RequestType, cmd.Raw,
+	)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO nano_enrollment_queue (id, command_uuid, priority) VALUES ` +
+		strings.TrimSuffix(strings.Repeat("(?, ?, ?),", len(ids)), ",")
+	args := make([]any, 0, len(ids)*3)
+	for _, id := range ids {
+		args = append(args, id, cmd.CommandUUID, priority)
+	}
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return err
+	}
+
+	// the history is kept by Fleet, so it is not lost when nanomdm clears the
+	// queue of the host.
+	stmt = `INSERT INTO host_mdm_apple_command_history (host_uuid, command_uuid, request_type) VALUES ` +
+		strings.TrimSuffix(strings.Repeat("(?, ?, ?),", len(ids)), ",")
+	args = args[:0]
+	for _, id := range ids {
+		args = append(args, id, cmd.CommandUUID, cmd.Command.RequestType)
+	}
+	_, err = tx.ExecContext(ctx, stmt, args...)
+	return err
+}
+
+// AssociateCertHash overrides nanomdm_mysql.MySQLStorage.AssociateCertHash to
+// also record the host that uses the SCEP certificate, and the certificate it
+// renews if the host was already associated with one.
+func (s *NanoMDMStorage) AssociateCertHash(r *mdm.Request, hash string) error {
+	if err := s.MySQLStorage.AssociateCertHash(r, hash); err != nil {
+		return err
+	}
+	if r.EnrollID == nil || r.Type != mdm.Device {
+		return nil
+	}
+
+	hash = strings.ToLower(hash)
+	var prevSerial sql.NullInt64
+	err := s.db.QueryRowContext(r.Context,
+		`SELECT MAX(serial) FROM scep_certificates WHERE host_uuid = ? AND sha256 != ?`,
+		r.ID, hash,
+	).Scan(&prevSerial)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(r.Context,
+		`UPDATE scep_certificates SET host_uuid = ?, renewed_from_serial = ? WHERE sha256 = ? AND host_uuid IS NULL`,
+		r.ID, prevSerial, hash,
+	)
+	return err
+}
+
 // RetrievePushCert partially implements nanomdm_storage.PushCertStore.
 //
 // Always returns "0" as stale token because we are not storing the APNS in MySQL storage,
@@ -177,15 +317,18 @@ func (ds *Datastore) NewMDMAppleDEPStorage(tok nanodep_client.OAuth1Tokens) (*Na
 
 	return &NanoDEPStorage{
 		MySQLStorage: s,
+		ds:           ds,
 		tokens:       tok,
 	}, nil
 }
 
 // NanoDEPStorage wraps a *nanodep_mysql.MySQLStorage and overrides functionality to load
-// DEP auth tokens from memory.
+// DEP auth tokens from memory and to store the DEP cursor and assigner profile
+// safely when the DEP syncer runs concurrently on multiple Fleet instances.
 type NanoDEPStorage struct {
 	*nanodep_mysql.MySQLStorage
 
+	ds     *Datastore
 	tokens nanodep_client.OAuth1Tokens
 }
 
@@ -204,6 +347,96 @@ func (s *NanoDEPStorage) StoreAuthTokens(ctx context.Context, name string, token
 	return errors.New("unimplemented")
 }
 
+// StoreCursor partially implements nanodep_sync.CursorStorage.
+//
+// Storing the cursor that is already stored is a no-op that keeps its
+// modification time, so that a cursor stored again by a concurrent runner
+// does not look more recent than the assigner profile.
+func (s *NanoDEPStorage) StoreCursor(ctx context.Context, name, cursor string) error {
+	return s.ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		current, found, err := lockDEPNameColumn(ctx, tx, name, "syncer_cursor")
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "lock dep cursor")
+		}
+		if found && current == cursor {
+			return nil
+		}
+		return upsertDEPNameColumn(ctx, tx, name, "syncer_cursor", cursor)
+	})
+}
+
+// SwapCursor stores newCursor as the DEP cursor only if the stored cursor is
+// still oldCursor, in a transaction. It is a no-op if newCursor is already
+// stored (i.e. another runner stored the same cursor), and it returns
+// apple_mdm.ErrDEPCursorConflict if the stored cursor was changed to a
+// different value since it was retrieved.
+func (s *NanoDEPStorage) SwapCursor(ctx context.Context, name, oldCursor, newCursor string) error {
+	return s.ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		current, _, err := lockDEPNameColumn(ctx, tx, name, "syncer_cursor")
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "lock dep cursor")
+		}
+		switch current {
+		case newCursor:
+			return nil
+		case oldCursor:
+			return upsertDEPNameColumn(ctx, tx, name, "syncer_cursor", newCursor)
+		default:
+			return ctxerr.Wrap(ctx, apple_mdm.ErrDEPCursorConflict, "swap dep cursor")
+		}
+	})
+}
+
+// StoreAssignerProfile partially implements nanodep_storage.AssignerProfileStorage.
+//
+// Storing the profile UUID that is already stored is a no-op that keeps its
+// modification time, otherwise the DEP cursor would be reset (and all devices
+// assigned again) every time a runner stores the same profile.
+func (s *NanoDEPStorage) StoreAssignerProfile(ctx context.Context, name, profileUUID string) error {
+	return s.ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		current, found, err := lockDEPNameColumn(ctx, tx, name, "assigner_profile_uuid")
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "lock dep assigner profile")
+		}
+		if found && current == profileUUID {
+			return nil
+		}
+		return upsertDEPNameColumn(ctx, tx, name, "assigner_profile_uuid", profileUUID)
+	})
+}
+
+// lockDEPNameColumn reads the value of the column of the nano_dep_names row
+// for the DEP name, locking the row until the end of the transaction. found is
+// false if the row does not exist or the value is NULL.
+func lockDEPNameColumn(ctx context.Context, tx sqlx.ExtContext, name, column string) (value string, found bool, err error) {
+	var v sql.NullString
+	stmt := fmt.Sprintf(`SELECT %s FROM nano_dep_names WHERE name = ? FOR UPDATE`, column)
+	if err := sqlx.GetContext(ctx, tx, &v, stmt, name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return v.String, v.Valid, nil
+}
+
+// upsertDEPNameColumn sets the value of the column of the nano_dep_names row
+// for the DEP name, along with its "_at" modification time column.
+func upsertDEPNameColumn(ctx context.Context, tx sqlx.ExtContext, name, column, value string) error {
+	stmt := fmt.Sprintf(`
+INSERT INTO nano_dep_names
+	(name, %[1]s, %[1]s_at)
+VALUES
+	(?, ?, CURRENT_TIMESTAMP)
+ON DUPLICATE KEY UPDATE
+	%[1]s = VALUES(%[1]s),
+	%[1]s_at = VALUES(%[1]s_at)`, column)
+	if _, err := tx.ExecContext(ctx, stmt, name, value); err != nil {
+		return ctxerr.Wrapf(ctx, err, "store dep %s", column)
+	}
+	return nil
+}
+
 type txFn func(tx sqlx.ExtContext) error
 
 type entity struct {
@@ -211,12 +444,14 @@ type entity struct {
 }
 
 var (
-	hostsTable    = entity{"hosts"}
-	invitesTable  = entity{"invites"}
-	packsTable    = entity{"packs"}
-	queriesTable  = entity{"queries"}
-	sessionsTable = entity{"sessions"}
-	usersTable    = entity{"users"}
+	hostsTable      = entity{"hosts"}
+	invitesTable    = entity{"invites"}
+	packsTable      = entity{"packs"}
+	queriesTable    = entity{"queries"}
+	scimGroupsTable = entity{"scim_groups"}
+	scimUsersTable  = entity{"scim_users"}
+	sessionsTable   = entity{"sessions"}
+	usersTable      = entity{"users"}
 )
 
 var doRetryErr = errors.New("fleet datastore retry")
@@ -368,6 +603,8 @@ func New(config config.MysqlConfig, c clock.Clock, opts ...DBOption) (*Datastore
 		writeCh:             make(chan itemToWrite),
 		stmtCache:           make(map[string]*sqlx.Stmt),
 		minLastOpenedAtDiff: options.minLastOpenedAtDiff,
+		profileChecksum:     options.profileChecksum,
+		serverPrivateKey:    options.serverPrivateKey,
 	}
 
 	go ds.writeChanLoop()
@@ -866,6 +1103,11 @@ func (ds *Datastore) whereFilterHostsByTeams(filter fleet.TeamFilter, hostKey st
 				return defaultAllowClause
 			}
 			return "FALSE"
+		case fleet.RoleGitOps:
+			if filter.IncludeGitOps {
+				return defaultAllowClause
+			}
+			return "FALSE"
 		default:
 			// Fall through to specific teams
 		}
@@ -878,7 +1120,8 @@ func (ds *Datastore) whereFilterHostsByTeams(filter fleet.TeamFilter, hostKey st
 		if team.Role == fleet.RoleAdmin ||
 			team.Role == fleet.RoleMaintainer ||
 			team.Role == fleet.RoleObserverPlus ||
-			(team.Role == fleet.RoleObserver && filter.IncludeObserver) {
+			(team.Role == fleet.RoleObserver && filter.IncludeObserver) ||
+			(team.Role == fleet.RoleGitOps && filter.IncludeGitOps) {
 			idStrs = append(idStrs, strconv.Itoa(int(team.ID)))
 			if filter.TeamID != nil && *filter.TeamID == team.ID {
 				teamIDSeen = true
@@ -1075,11 +1318,13 @@ func hostSearchLike(sql string, params []interface{}, match string, columns ...s
 	base, args := searchLike(sql, params, match, columns...)
 
 	// special-case for hosts: if match looks like an email address, add searching
-	// in host_emails table as an option, in addition to the provided columns.
+	// in host_emails and host_assigned_users tables as an option, in addition to
+	// the provided columns.
 	if rxLooseEmail.MatchString(match) {
-		// remove the closing paren and add the email condition to the list
-		base = strings.TrimSuffix(base, ")") + " OR (" + ` EXISTS (SELECT 1 FROM host_emails he WHERE he.host_id = h.id AND he.email LIKE ?)))`
-		args = append(args, likePattern(match))
+		// remove the closing paren and add the email conditions to the list
+		base = strings.TrimSuffix(base, ")") + " OR (" + ` EXISTS (SELECT 1 FROM host_emails he WHERE he.host_id = h.id AND he.email LIKE ?)` +
+			` OR EXISTS (SELECT 1 FROM host_assigned_users hau WHERE hau.host_id = h.id AND hau.email LIKE ?)))`
+		args = append(args, likePattern(match), likePattern(match))
 	}
 	return base, args
 }
@@ -211,8 +211,8 @@ func TestHostSearchLike(t *testing.T) {
 			inParams:  []interface{}{1},
 			match:     "a@b.c",
 			columns:   []string{"ipv4"},
-			outSQL:    "SELECT * FROM HOSTS h WHERE 1=1 AND (ipv4 LIKE ? OR ( EXISTS (SELECT 1 FROM host_emails he WHERE he.host_id = h.id AND he.email LIKE ?)))",
-			outParams: []interface{}{1, "%a@b.c%", "%a@b.c%"},
+			outSQL:    "SELECT * FROM HOSTS h WHERE 1=1 AND (ipv4 LIKE ? OR ( EXISTS (SELECT 1 FROM host_emails he WHERE he.host_id = h.id AND he.email LIKE ?) OR EXISTS (SELECT 1 FROM host_assigned_users hau WHERE hau.host_id = h.id AND hau.email LIKE ?)))",
+			outParams: []interface{}{1, "%a@b.c%", "%a@b.c%", "%a@b.c%"},
 		},
 	}
 
@@ -439,8 +439,44 @@ func TestWhereFilterHostsByTeams(t *testing.T) {
 			},
 			expected: "TRUE",
 		},
+		{
+			filter: fleet.TeamFilter{
+				User: &fleet.User{GlobalRole: ptr.String(fleet.RoleGitOps)},
+			},
+			expected: "FALSE",
+		},
+		{
+			filter: fleet.TeamFilter{
+				User:          &fleet.User{GlobalRole: ptr.String(fleet.RoleGitOps)},
+				IncludeGitOps: true,
+			},
+			expected: "TRUE",
+		},
 
 		// Team roles
+		{
+			filter: fleet.TeamFilter{
+				User: &fleet.User{
+					Teams: []fleet.UserTeam{
+						{Role: fleet.RoleGitOps, Team: fleet.Team{ID: 1}},
+						{Role: fleet.RoleMaintainer, Team: fleet.Team{ID: 2}},
+					},
+				},
+			},
+			expected: "hosts.team_id IN (2)",
+		},
+		{
+			filter: fleet.TeamFilter{
+				User: &fleet.User{
+					Teams: []fleet.UserTeam{
+						{Role: fleet.RoleGitOps, Team: fleet.Team{ID: 1}},
+						{Role: fleet.RoleMaintainer, Team: fleet.Team{ID: 2}},
+					},
+				},
+				IncludeGitOps: true,
+			},
+			expected: "hosts.team_id IN (1,2)",
+		},
 		{
 			filter: fleet.TeamFilter{
 				User: &fleet.User{
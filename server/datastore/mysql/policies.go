@@ -18,7 +18,8 @@ import (
 
 const policyCols = `
 	p.id, p.team_id, p.resolution, p.name, p.query, p.description,
-	p.author_id, p.platforms, p.created_at, p.updated_at, p.critical
+	p.author_id, p.platforms, p.created_at, p.updated_at, p.critical,
+	p.mdm_conditions
 `
 
 func (ds *Datastore) NewGlobalPolicy(ctx context.Context, authorID *uint, args fleet.PolicyPayload) (*fleet.Policy, error) {
@@ -32,8 +33,8 @@ func (ds *Datastore) NewGlobalPolicy(ctx context.Context, authorID *uint, args f
 		args.Description = q.Description
 	}
 	res, err := ds.writer.ExecContext(ctx,
-		`INSERT INTO policies (name, query, description, resolution, author_id, platforms, critical) VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		args.Name, args.Query, args.Description, args.Resolution, authorID, args.Platform, args.Critical,
+		`INSERT INTO policies (name, query, description, resolution, author_id, platforms, critical, mdm_conditions) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		args.Name, args.Query, args.Description, args.Resolution, authorID, args.Platform, args.Critical, args.MDMConditions,
 	)
 	switch {
 	case err == nil:
@@ -88,10 +89,10 @@ func policyDB(ctx context.Context, q sqlx.QueryerContext, id uint, teamID *uint)
 func (ds *Datastore) SavePolicy(ctx context.Context, p *fleet.Policy) error {
 	sql := `
 		UPDATE policies
-			SET name = ?, query = ?, description = ?, resolution = ?, platforms = ?, critical = ?
+			SET name = ?, query = ?, description = ?, resolution = ?, platforms = ?, critical = ?, mdm_conditions = ?
 			WHERE id = ?
 	`
-	result, err := ds.writer.ExecContext(ctx, sql, p.Name, p.Query, p.Description, p.Resolution, p.Platform, p.Critical, p.ID)
+	result, err := ds.writer.ExecContext(ctx, sql, p.Name, p.Query, p.Description, p.Resolution, p.Platform, p.Critical, p.MDMConditions, p.ID)
 	if err != nil {
 		return ctxerr.Wrap(ctx, err, "updating policy")
 	}
@@ -411,11 +412,53 @@ func (ds *Datastore) PolicyQueriesForHost(ctx context.Context, host *fleet.Host)
 	}
 	results := make(map[string]string)
 	for _, row := range rows {
-		results[row.ID] = row.Query
+		query := row.Query
+		if query == "" {
+			// the policies with only MDM conditions still run a query, so that
+			// Fleet evaluates their conditions when the host reports the result.
+			query = policyMDMConditionsOnlyQuery
+		}
+		results[row.ID] = query
 	}
 	return results, nil
 }
 
+// policyMDMConditionsOnlyQuery is the query that runs on the hosts for the
+// policies that have MDM conditions but no query.
+const policyMDMConditionsOnlyQuery = "SELECT 1;"
+
+// PolicyMDMConditions returns the MDM conditions of the provided policies,
+// indexed by policy id. The policies without MDM conditions are omitted.
+func (ds *Datastore) PolicyMDMConditions(ctx context.Context, policyIDs []uint) (map[uint]fleet.PolicyMDMConditions, error) {
+	if len(policyIDs) == 0 {
+		return nil, nil
+	}
+
+	stmt, args, err := sqlx.In(`
+      SELECT id, mdm_conditions
+      FROM policies
+      WHERE id IN (?) AND mdm_conditions IS NOT NULL`, policyIDs)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "build policy mdm conditions query")
+	}
+
+	var rows []struct {
+		ID            uint                      `db:"id"`
+		MDMConditions fleet.PolicyMDMConditions `db:"mdm_conditions"`
+	}
+	if err := sqlx.SelectContext(ctx, ds.reader, &rows, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "select policy mdm conditions")
+	}
+
+	conds := make(map[uint]fleet.PolicyMDMConditions, len(rows))
+	for _, row := range rows {
+		if len(row.MDMConditions) > 0 {
+			conds[row.ID] = row.MDMConditions
+		}
+	}
+	return conds, nil
+}
+
 func (ds *Datastore) NewTeamPolicy(ctx context.Context, teamID uint, authorID *uint, args fleet.PolicyPayload) (*fleet.Policy, error) {
 	if args.QueryID != nil {
 		q, err := ds.Query(ctx, *args.QueryID)
@@ -427,8 +470,8 @@ func (ds *Datastore) NewTeamPolicy(ctx context.Context, teamID uint, authorID *u
 		args.Description = q.Description
 	}
 	res, err := ds.writer.ExecContext(ctx,
-		`INSERT INTO policies (name, query, description, team_id, resolution, author_id, platforms, critical) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		args.Name, args.Query, args.Description, teamID, args.Resolution, authorID, args.Platform, args.Critical)
+		`INSERT INTO policies (name, query, description, team_id, resolution, author_id, platforms, critical, mdm_conditions) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		args.Name, args.Query, args.Description, teamID, args.Resolution, authorID, args.Platform, args.Critical, args.MDMConditions)
 	switch {
 	case err == nil:
 		// OK
@@ -483,8 +526,9 @@ func (ds *Datastore) ApplyPolicySpecs(ctx context.Context, authorID uint, specs
 			resolution,
 			team_id,
 			platforms,
-		    critical
-		) VALUES ( ?, ?, ?, ?, ?, (SELECT IFNULL(MIN(id), NULL) FROM teams WHERE name = ?), ?, ?)
+		    critical,
+			mdm_conditions
+		) VALUES ( ?, ?, ?, ?, ?, (SELECT IFNULL(MIN(id), NULL) FROM teams WHERE name = ?), ?, ?, ?)
 		ON DUPLICATE KEY UPDATE
 			name = VALUES(name),
 			query = VALUES(query),
@@ -492,11 +536,12 @@ func (ds *Datastore) ApplyPolicySpecs(ctx context.Context, authorID uint, specs
 			author_id = VALUES(author_id),
 			resolution = VALUES(resolution),
 			platforms = VALUES(platforms),
-			critical = VALUES(critical)
+			critical = VALUES(critical),
+			mdm_conditions = VALUES(mdm_conditions)
 		`
 		for _, spec := range specs {
 			res, err := tx.ExecContext(ctx,
-				sql, spec.Name, spec.Query, spec.Description, authorID, spec.Resolution, spec.Team, spec.Platform, spec.Critical,
+				sql, spec.Name, spec.Query, spec.Description, authorID, spec.Resolution, spec.Team, spec.Platform, spec.Critical, spec.MDMConditions,
 			)
 			if err != nil {
 				return ctxerr.Wrap(ctx, err, "exec ApplyPolicySpecs insert")
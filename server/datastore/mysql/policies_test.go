@@ -47,6 +47,7 @@ func TestPolicies(t *testing.T) {
 		{"PolicyViolationDays", testPolicyViolationDays},
 		{"IncreasePolicyAutomationIteration", testIncreasePolicyAutomationIteration},
 		{"OutdatedAutomationBatch", testOutdatedAutomationBatch},
+		{"PolicyMDMConditions", testPolicyMDMConditions},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
@@ -2174,3 +2175,82 @@ func testOutdatedAutomationBatch(t *testing.T, ds *Datastore) {
 	require.NoError(t, err)
 	require.ElementsMatch(t, batch, []fleet.PolicyFailure{})
 }
+
+func testPolicyMDMConditions(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+	user := test.NewUser(t, ds, "Alice", "alice@example.com", true)
+	team, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+
+	escrowed := fleet.PolicyMDMConditions{{Kind: fleet.PolicyMDMConditionDiskEncryption, Status: fleet.MDMAppleDeliveryVerifying}}
+	kiosk := fleet.PolicyMDMConditions{{Kind: fleet.PolicyMDMConditionProfile, ProfileName: "Kiosk", Status: fleet.MDMAppleDeliveryVerifying}}
+
+	// a policy without a query runs a query that always passes
+	p1, err := ds.NewGlobalPolicy(ctx, &user.ID, fleet.PolicyPayload{Name: "p1", MDMConditions: escrowed})
+	require.NoError(t, err)
+	require.Equal(t, escrowed, p1.MDMConditions)
+	require.Empty(t, p1.Query)
+
+	p2, err := ds.NewTeamPolicy(ctx, team.ID, &user.ID, fleet.PolicyPayload{Name: "p2", Query: "select 2;", MDMConditions: kiosk})
+	require.NoError(t, err)
+	require.Equal(t, kiosk, p2.MDMConditions)
+
+	p3, err := ds.NewGlobalPolicy(ctx, &user.ID, fleet.PolicyPayload{Name: "p3", Query: "select 3;"})
+	require.NoError(t, err)
+	require.Nil(t, p3.MDMConditions)
+
+	host, err := ds.NewHost(ctx, &fleet.Host{
+		OsqueryHostID:   ptr.String("1"),
+		NodeKey:         ptr.String("1"),
+		UUID:            "1",
+		Hostname:        "foo.local",
+		Platform:        "darwin",
+		DetailUpdatedAt: time.Now(),
+		LabelUpdatedAt:  time.Now(),
+		PolicyUpdatedAt: time.Now(),
+		SeenTime:        time.Now(),
+		TeamID:          &team.ID,
+	})
+	require.NoError(t, err)
+	queries, err := ds.PolicyQueriesForHost(ctx, host)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		fmt.Sprint(p1.ID): policyMDMConditionsOnlyQuery,
+		fmt.Sprint(p2.ID): "select 2;",
+		fmt.Sprint(p3.ID): "select 3;",
+	}, queries)
+
+	conds, err := ds.PolicyMDMConditions(ctx, []uint{p1.ID, p2.ID, p3.ID})
+	require.NoError(t, err)
+	require.Equal(t, map[uint]fleet.PolicyMDMConditions{p1.ID: escrowed, p2.ID: kiosk}, conds)
+
+	// the conditions are updated and removed with the policy
+	p1.MDMConditions = append(escrowed, kiosk...)
+	require.NoError(t, ds.SavePolicy(ctx, p1))
+	p2.MDMConditions = nil
+	require.NoError(t, ds.SavePolicy(ctx, p2))
+	conds, err = ds.PolicyMDMConditions(ctx, []uint{p1.ID, p2.ID})
+	require.NoError(t, err)
+	require.Equal(t, map[uint]fleet.PolicyMDMConditions{p1.ID: append(escrowed, kiosk...)}, conds)
+
+	// the conditions are applied with the policy specs
+	require.NoError(t, ds.ApplyPolicySpecs(ctx, user.ID, []*fleet.PolicySpec{
+		{Name: "p3", Query: "select 3;", MDMConditions: kiosk},
+		{Name: "p4", MDMConditions: escrowed, Team: "team1"},
+	}))
+	teamPolicies, _, err := ds.ListTeamPolicies(ctx, team.ID)
+	require.NoError(t, err)
+	require.Len(t, teamPolicies, 2)
+	for _, p := range teamPolicies {
+		if p.Name == "p4" {
+			require.Equal(t, escrowed, p.MDMConditions)
+		}
+	}
+	p3b, err := ds.Policy(ctx, p3.ID)
+	require.NoError(t, err)
+	require.Equal(t, kiosk, p3b.MDMConditions)
+
+	conds, err = ds.PolicyMDMConditions(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, conds)
+}
@@ -92,9 +92,14 @@ func (d *SCEPDepot) HasCN(cn string, allowTime int, cert *x509.Certificate, revo
 //
 // If the provided certificate has empty crt.Subject.CommonName,
 // then the hex sha256 of the crt.Raw is used as name.
+//
+// The hex sha256 of crt.Raw is also stored as the certificate's fingerprint,
+// it is the hash that nanomdm associates with the enrollment that uses the
+// certificate (see NanoMDMStorage.AssociateCertHash).
 func (d *SCEPDepot) Put(name string, crt *x509.Certificate) error {
+	sum := fmt.Sprintf("%x", sha256.Sum256(crt.Raw))
 	if crt.Subject.CommonName == "" {
-		name = fmt.Sprintf("%x", sha256.Sum256(crt.Raw))
+		name = sum
 	}
 	if !crt.SerialNumber.IsInt64() {
 		return errors.New("cannot represent serial number as int64")
@@ -102,14 +107,15 @@ func (d *SCEPDepot) Put(name string, crt *x509.Certificate) error {
 	certPEM := apple_mdm.EncodeCertPEM(crt)
 	_, err := d.db.Exec(`
 INSERT INTO scep_certificates
-    (serial, name, not_valid_before, not_valid_after, certificate_pem)
+    (serial, name, not_valid_before, not_valid_after, certificate_pem, sha256)
 VALUES
-    (?, ?, ?, ?, ?)`,
+    (?, ?, ?, ?, ?, ?)`,
 		crt.SerialNumber.Int64(),
 		name,
 		crt.NotBefore,
 		crt.NotAfter,
 		certPEM,
+		sum,
 	)
 	return err
 }
@@ -0,0 +1,443 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+)
+
+func (ds *Datastore) CreateScimUser(ctx context.Context, user *fleet.ScimUser) (*fleet.ScimUser, error) {
+	const stmt = `
+    INSERT INTO
+      scim_users (external_id, user_name, given_name, family_name, email, active)
+    VALUES
+      (?, ?, ?, ?, ?, ?)
+`
+
+	res, err := ds.writer.ExecContext(ctx, stmt, user.ExternalID, user.UserName, user.GivenName, user.FamilyName, user.Email, user.Active)
+	if err != nil {
+		if isDuplicate(err) {
+			return nil, ctxerr.Wrap(ctx, alreadyExists("ScimUser", user.UserName))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "insert scim user")
+	}
+	id, _ := res.LastInsertId()
+	return ds.scimUserByID(ctx, ds.writer, uint(id))
+}
+
+func (ds *Datastore) ScimUserByID(ctx context.Context, id uint) (*fleet.ScimUser, error) {
+	return ds.scimUserByID(ctx, ds.reader, id)
+}
+
+func (ds *Datastore) scimUserByID(ctx context.Context, q sqlx.QueryerContext, id uint) (*fleet.ScimUser, error) {
+	const stmt = `
+    SELECT
+      id, external_id, user_name, given_name, family_name, email, active, created_at, updated_at
+    FROM
+      scim_users
+    WHERE
+      id = ?
+`
+
+	var user fleet.ScimUser
+	if err := sqlx.GetContext(ctx, q, &user, stmt, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("ScimUser").WithID(id))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "select scim user")
+	}
+	if err := loadScimUsersGroupsDB(ctx, q, []*fleet.ScimUser{&user}); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (ds *Datastore) ListScimUsers(ctx context.Context, opts fleet.ScimListOptions) ([]*fleet.ScimUser, int, error) {
+	const (
+		countStmt = `SELECT COUNT(*) FROM scim_users WHERE %s`
+		listStmt  = `
+    SELECT
+      id, external_id, user_name, given_name, family_name, email, active, created_at, updated_at
+    FROM
+      scim_users
+    WHERE
+      %s
+    ORDER BY
+      id
+    LIMIT ? OFFSET ?
+`
+	)
+
+	where, args := "TRUE", []interface{}{}
+	if opts.UserNameFilter != nil {
+		where, args = "user_name = ?", append(args, *opts.UserNameFilter)
+	}
+
+	var total int
+	if err := sqlx.GetContext(ctx, ds.reader, &total, strings.Replace(countStmt, "%s", where, 1), args...); err != nil {
+		return nil, 0, ctxerr.Wrap(ctx, err, "count scim users")
+	}
+	if opts.Count == 0 {
+		return nil, total, nil
+	}
+
+	var users []*fleet.ScimUser
+	args = append(args, opts.Count, scimOffset(opts))
+	if err := sqlx.SelectContext(ctx, ds.reader, &users, strings.Replace(listStmt, "%s", where, 1), args...); err != nil {
+		return nil, 0, ctxerr.Wrap(ctx, err, "list scim users")
+	}
+	if err := loadScimUsersGroupsDB(ctx, ds.reader, users); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// scimOffset returns the SQL offset of the page, the SCIM start index is
+// 1-based.
+func scimOffset(opts fleet.ScimListOptions) uint {
+	if opts.StartIndex <= 1 {
+		return 0
+	}
+	return opts.StartIndex - 1
+}
+
+func loadScimUsersGroupsDB(ctx context.Context, q sqlx.QueryerContext, users []*fleet.ScimUser) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	const stmt = `
+    SELECT
+      sug.scim_user_id, sg.id, sg.display_name
+    FROM
+      scim_user_groups sug
+      JOIN scim_groups sg ON sg.id = sug.group_id
+    WHERE
+      sug.scim_user_id IN (?)
+    ORDER BY
+      sg.display_name
+`
+
+	byID := make(map[uint]*fleet.ScimUser, len(users))
+	ids := make([]uint, 0, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+		ids = append(ids, u.ID)
+	}
+	query, args, err := sqlx.In(stmt, ids)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "building query to select scim users groups")
+	}
+
+	var rows []struct {
+		ScimUserID uint `db:"scim_user_id"`
+		fleet.ScimUserGroup
+	}
+	if err := sqlx.SelectContext(ctx, q, &rows, query, args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "select scim users groups")
+	}
+	for _, r := range rows {
+		u := byID[r.ScimUserID]
+		u.Groups = append(u.Groups, r.ScimUserGroup)
+	}
+	return nil
+}
+
+func (ds *Datastore) ReplaceScimUser(ctx context.Context, user *fleet.ScimUser) error {
+	const stmt = `
+    UPDATE
+      scim_users
+    SET
+      external_id = ?,
+      user_name = ?,
+      given_name = ?,
+      family_name = ?,
+      email = ?,
+      active = ?
+    WHERE
+      id = ?
+`
+
+	res, err := ds.writer.ExecContext(ctx, stmt, user.ExternalID, user.UserName, user.GivenName, user.FamilyName, user.Email, user.Active, user.ID)
+	if err != nil {
+		if isDuplicate(err) {
+			return ctxerr.Wrap(ctx, alreadyExists("ScimUser", user.UserName))
+		}
+		return ctxerr.Wrap(ctx, err, "update scim user")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// the row may be unchanged, make sure it exists
+		var exists bool
+		if err := sqlx.GetContext(ctx, ds.writer, &exists, `SELECT 1 FROM scim_users WHERE id = ?`, user.ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ctxerr.Wrap(ctx, notFound("ScimUser").WithID(user.ID))
+			}
+			return ctxerr.Wrap(ctx, err, "check scim user exists")
+		}
+	}
+	return nil
+}
+
+func (ds *Datastore) DeleteScimUser(ctx context.Context, id uint) error {
+	return ds.deleteEntity(ctx, scimUsersTable, id)
+}
+
+func (ds *Datastore) CreateScimGroup(ctx context.Context, group *fleet.ScimGroup) (*fleet.ScimGroup, error) {
+	const stmt = `
+    INSERT INTO
+      scim_groups (external_id, display_name)
+    VALUES
+      (?, ?)
+`
+
+	var id uint
+	err := ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		res, err := tx.ExecContext(ctx, stmt, group.ExternalID, group.DisplayName)
+		if err != nil {
+			if isDuplicate(err) {
+				return ctxerr.Wrap(ctx, alreadyExists("ScimGroup", group.DisplayName))
+			}
+			return ctxerr.Wrap(ctx, err, "insert scim group")
+		}
+		lastID, _ := res.LastInsertId()
+		id = uint(lastID)
+		return setScimGroupMembersDB(ctx, tx, id, group.MemberIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ds.scimGroupByID(ctx, ds.writer, id)
+}
+
+func (ds *Datastore) ScimGroupByID(ctx context.Context, id uint) (*fleet.ScimGroup, error) {
+	return ds.scimGroupByID(ctx, ds.reader, id)
+}
+
+func (ds *Datastore) scimGroupByID(ctx context.Context, q sqlx.QueryerContext, id uint) (*fleet.ScimGroup, error) {
+	const stmt = `
+    SELECT
+      id, external_id, display_name, created_at, updated_at
+    FROM
+      scim_groups
+    WHERE
+      id = ?
+`
+
+	var group fleet.ScimGroup
+	if err := sqlx.GetContext(ctx, q, &group, stmt, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("ScimGroup").WithID(id))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "select scim group")
+	}
+	if err := loadScimGroupsMembersDB(ctx, q, []*fleet.ScimGroup{&group}); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (ds *Datastore) ListScimGroups(ctx context.Context, opts fleet.ScimListOptions) ([]*fleet.ScimGroup, int, error) {
+	const (
+		countStmt = `SELECT COUNT(*) FROM scim_groups WHERE %s`
+		listStmt  = `
+    SELECT
+      id, external_id, display_name, created_at, updated_at
+    FROM
+      scim_groups
+    WHERE
+      %s
+    ORDER BY
+      id
+    LIMIT ? OFFSET ?
+`
+	)
+
+	where, args := "TRUE", []interface{}{}
+	if opts.DisplayNameFilter != nil {
+		where, args = "display_name = ?", append(args, *opts.DisplayNameFilter)
+	}
+
+	var total int
+	if err := sqlx.GetContext(ctx, ds.reader, &total, strings.Replace(countStmt, "%s", where, 1), args...); err != nil {
+		return nil, 0, ctxerr.Wrap(ctx, err, "count scim groups")
+	}
+	if opts.Count == 0 {
+		return nil, total, nil
+	}
+
+	var groups []*fleet.ScimGroup
+	args = append(args, opts.Count, scimOffset(opts))
+	if err := sqlx.SelectContext(ctx, ds.reader, &groups, strings.Replace(listStmt, "%s", where, 1), args...); err != nil {
+		return nil, 0, ctxerr.Wrap(ctx, err, "list scim groups")
+	}
+	if err := loadScimGroupsMembersDB(ctx, ds.reader, groups); err != nil {
+		return nil, 0, err
+	}
+	return groups, total, nil
+}
+
+func loadScimGroupsMembersDB(ctx context.Context, q sqlx.QueryerContext, groups []*fleet.ScimGroup) error {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	const stmt = `
+    SELECT
+      group_id, scim_user_id
+    FROM
+      scim_user_groups
+    WHERE
+      group_id IN (?)
+    ORDER BY
+      scim_user_id
+`
+
+	byID := make(map[uint]*fleet.ScimGroup, len(groups))
+	ids := make([]uint, 0, len(groups))
+	for _, g := range groups {
+		byID[g.ID] = g
+		ids = append(ids, g.ID)
+	}
+	query, args, err := sqlx.In(stmt, ids)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "building query to select scim groups members")
+	}
+
+	var rows []struct {
+		GroupID    uint `db:"group_id"`
+		ScimUserID uint `db:"scim_user_id"`
+	}
+	if err := sqlx.SelectContext(ctx, q, &rows, query, args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "select scim groups members")
+	}
+	for _, r := range rows {
+		g := byID[r.GroupID]
+		g.MemberIDs = append(g.MemberIDs, r.ScimUserID)
+	}
+	return nil
+}
+
+func (ds *Datastore) ReplaceScimGroup(ctx context.Context, group *fleet.ScimGroup) error {
+	const stmt = `
+    UPDATE
+      scim_groups
+    SET
+      external_id = ?,
+      display_name = ?
+    WHERE
+      id = ?
+`
+
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		var exists bool
+		if err := sqlx.GetContext(ctx, tx, &exists, `SELECT 1 FROM scim_groups WHERE id = ? FOR UPDATE`, group.ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ctxerr.Wrap(ctx, notFound("ScimGroup").WithID(group.ID))
+			}
+			return ctxerr.Wrap(ctx, err, "check scim group exists")
+		}
+		if _, err := tx.ExecContext(ctx, stmt, group.ExternalID, group.DisplayName, group.ID); err != nil {
+			if isDuplicate(err) {
+				return ctxerr.Wrap(ctx, alreadyExists("ScimGroup", group.DisplayName))
+			}
+			return ctxerr.Wrap(ctx, err, "update scim group")
+		}
+		return setScimGroupMembersDB(ctx, tx, group.ID, group.MemberIDs)
+	})
+}
+
+// setScimGroupMembersDB replaces the members of the group. It returns a
+// NotFound error if a member is not an existing SCIM user.
+func setScimGroupMembersDB(ctx context.Context, tx sqlx.ExtContext, groupID uint, memberIDs []uint) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM scim_user_groups WHERE group_id = ?`, groupID); err != nil {
+		return ctxerr.Wrap(ctx, err, "delete scim group members")
+	}
+	if len(memberIDs) == 0 {
+		return nil
+	}
+
+	uniq := make(map[uint]bool, len(memberIDs))
+	var sb strings.Builder
+	args := make([]interface{}, 0, len(memberIDs)*2)
+	for _, id := range memberIDs {
+		if uniq[id] {
+			continue
+		}
+		uniq[id] = true
+		if sb.Len() > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?,?)")
+		args = append(args, groupID, id)
+	}
+	stmt := `INSERT INTO scim_user_groups (group_id, scim_user_id) VALUES ` + sb.String()
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		if isChildForeignKeyError(err) {
+			return ctxerr.Wrap(ctx, notFound("ScimUser").WithMessage("member of the group"))
+		}
+		return ctxerr.Wrap(ctx, err, "insert scim group members")
+	}
+	return nil
+}
+
+func (ds *Datastore) DeleteScimGroup(ctx context.Context, id uint) error {
+	return ds.deleteEntity(ctx, scimGroupsTable, id)
+}
+
+func (ds *Datastore) ScimGroupNamesForUserName(ctx context.Context, userName string) ([]string, error) {
+	const stmt = `
+    SELECT
+      sg.display_name
+    FROM
+      scim_users su
+      JOIN scim_user_groups sug ON sug.scim_user_id = su.id
+      JOIN scim_groups sg ON sg.id = sug.group_id
+    WHERE
+      su.user_name = ? AND
+      su.active = 1
+    ORDER BY
+      sg.display_name
+`
+
+	var names []string
+	if err := sqlx.SelectContext(ctx, ds.reader, &names, stmt, userName); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "select scim group names for user")
+	}
+	return names, nil
+}
+
+func (ds *Datastore) SetScimLastRequest(ctx context.Context, req *fleet.ScimLastRequest) error {
+	const stmt = `
+    INSERT INTO
+      scim_last_request (id, status, details)
+    VALUES
+      (1, ?, ?)
+    ON DUPLICATE KEY UPDATE
+      status = VALUES(status),
+      details = VALUES(details),
+      updated_at = CURRENT_TIMESTAMP
+`
+
+	if _, err := ds.writer.ExecContext(ctx, stmt, req.Status, req.Details); err != nil {
+		return ctxerr.Wrap(ctx, err, "upsert scim last request")
+	}
+	return nil
+}
+
+func (ds *Datastore) ScimLastRequest(ctx context.Context) (*fleet.ScimLastRequest, error) {
+	const stmt = `SELECT status, details, updated_at FROM scim_last_request WHERE id = 1`
+
+	var req fleet.ScimLastRequest
+	if err := sqlx.GetContext(ctx, ds.reader, &req, stmt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("ScimLastRequest"))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "select scim last request")
+	}
+	return &req, nil
+}
@@ -0,0 +1,213 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScim(t *testing.T) {
+	ds := CreateMySQLDS(t)
+
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, ds *Datastore)
+	}{
+		{"Users", testScimUsers},
+		{"Groups", testScimGroups},
+		{"LastRequest", testScimLastRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer TruncateTables(t, ds)
+			c.fn(t, ds)
+		})
+	}
+}
+
+func testScimUsers(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	u1, err := ds.CreateScimUser(ctx, &fleet.ScimUser{
+		ExternalID: ptr.String("ext-1"),
+		UserName:   "a@example.com",
+		GivenName:  "A",
+		FamilyName: "One",
+		Email:      "a@example.com",
+		Active:     true,
+	})
+	require.NoError(t, err)
+	require.NotZero(t, u1.ID)
+	require.Equal(t, "ext-1", *u1.ExternalID)
+	require.True(t, u1.Active)
+	require.Empty(t, u1.Groups)
+
+	_, err = ds.CreateScimUser(ctx, &fleet.ScimUser{UserName: "a@example.com"})
+	var aeErr fleet.AlreadyExistsError
+	require.ErrorAs(t, err, &aeErr)
+
+	u2, err := ds.CreateScimUser(ctx, &fleet.ScimUser{UserName: "b@example.com"})
+	require.NoError(t, err)
+	require.Nil(t, u2.ExternalID)
+	require.False(t, u2.Active)
+
+	_, err = ds.ScimUserByID(ctx, u2.ID+1)
+	require.True(t, fleet.IsNotFound(err))
+
+	users, total, err := ds.ListScimUsers(ctx, fleet.ScimListOptions{StartIndex: 1, Count: 10})
+	require.NoError(t, err)
+	require.Equal(t, 2, total)
+	require.Len(t, users, 2)
+	require.Equal(t, u1.ID, users[0].ID)
+	require.Equal(t, u2.ID, users[1].ID)
+
+	users, total, err = ds.ListScimUsers(ctx, fleet.ScimListOptions{StartIndex: 2, Count: 1})
+	require.NoError(t, err)
+	require.Equal(t, 2, total)
+	require.Len(t, users, 1)
+	require.Equal(t, u2.ID, users[0].ID)
+
+	users, total, err = ds.ListScimUsers(ctx, fleet.ScimListOptions{Count: 10, UserNameFilter: ptr.String("B@example.com")})
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	require.Len(t, users, 1)
+	require.Equal(t, u2.ID, users[0].ID)
+
+	// a count of 0 only returns the total
+	users, total, err = ds.ListScimUsers(ctx, fleet.ScimListOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 2, total)
+	require.Empty(t, users)
+
+	// replace the second user, the user name can't be the one of the first
+	u2.UserName = "a@example.com"
+	err = ds.ReplaceScimUser(ctx, u2)
+	require.ErrorAs(t, err, &aeErr)
+	u2.UserName = "c@example.com"
+	u2.Active = true
+	require.NoError(t, ds.ReplaceScimUser(ctx, u2))
+	// unchanged
+	require.NoError(t, ds.ReplaceScimUser(ctx, u2))
+	got, err := ds.ScimUserByID(ctx, u2.ID)
+	require.NoError(t, err)
+	require.Equal(t, "c@example.com", got.UserName)
+	require.True(t, got.Active)
+
+	err = ds.ReplaceScimUser(ctx, &fleet.ScimUser{ID: u2.ID + 1, UserName: "d@example.com"})
+	require.True(t, fleet.IsNotFound(err))
+
+	require.NoError(t, ds.DeleteScimUser(ctx, u1.ID))
+	_, err = ds.ScimUserByID(ctx, u1.ID)
+	require.True(t, fleet.IsNotFound(err))
+	err = ds.DeleteScimUser(ctx, u1.ID)
+	require.True(t, fleet.IsNotFound(err))
+}
+
+func testScimGroups(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	u1, err := ds.CreateScimUser(ctx, &fleet.ScimUser{UserName: "a@example.com", Active: true})
+	require.NoError(t, err)
+	u2, err := ds.CreateScimUser(ctx, &fleet.ScimUser{UserName: "b@example.com", Active: false})
+	require.NoError(t, err)
+
+	g1, err := ds.CreateScimGroup(ctx, &fleet.ScimGroup{DisplayName: "Engineering", MemberIDs: []uint{u2.ID, u1.ID, u1.ID}})
+	require.NoError(t, err)
+	require.Equal(t, []uint{u1.ID, u2.ID}, g1.MemberIDs)
+
+	_, err = ds.CreateScimGroup(ctx, &fleet.ScimGroup{DisplayName: "Engineering"})
+	var aeErr fleet.AlreadyExistsError
+	require.ErrorAs(t, err, &aeErr)
+
+	// members must exist
+	_, err = ds.CreateScimGroup(ctx, &fleet.ScimGroup{DisplayName: "Sales", MemberIDs: []uint{u2.ID + 1}})
+	require.True(t, fleet.IsNotFound(err))
+
+	g2, err := ds.CreateScimGroup(ctx, &fleet.ScimGroup{DisplayName: "Sales", ExternalID: ptr.String("ext-2"), MemberIDs: []uint{u1.ID}})
+	require.NoError(t, err)
+	require.Equal(t, "ext-2", *g2.ExternalID)
+
+	user, err := ds.ScimUserByID(ctx, u1.ID)
+	require.NoError(t, err)
+	require.Equal(t, []fleet.ScimUserGroup{{ID: g1.ID, DisplayName: "Engineering"}, {ID: g2.ID, DisplayName: "Sales"}}, user.Groups)
+
+	groups, total, err := ds.ListScimGroups(ctx, fleet.ScimListOptions{StartIndex: 1, Count: 10})
+	require.NoError(t, err)
+	require.Equal(t, 2, total)
+	require.Len(t, groups, 2)
+	require.Equal(t, g1.ID, groups[0].ID)
+	require.Equal(t, []uint{u1.ID, u2.ID}, groups[0].MemberIDs)
+	require.Equal(t, []uint{u1.ID}, groups[1].MemberIDs)
+
+	groups, total, err = ds.ListScimGroups(ctx, fleet.ScimListOptions{Count: 10, DisplayNameFilter: ptr.String("sales")})
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	require.Len(t, groups, 1)
+	require.Equal(t, g2.ID, groups[0].ID)
+
+	// only the groups of active users are returned
+	names, err := ds.ScimGroupNamesForUserName(ctx, "a@example.com")
+	require.NoError(t, err)
+	require.Equal(t, []string{"Engineering", "Sales"}, names)
+	names, err = ds.ScimGroupNamesForUserName(ctx, "b@example.com")
+	require.NoError(t, err)
+	require.Empty(t, names)
+	names, err = ds.ScimGroupNamesForUserName(ctx, "unknown@example.com")
+	require.NoError(t, err)
+	require.Empty(t, names)
+
+	// replace the first group
+	g1.DisplayName = "Sales"
+	err = ds.ReplaceScimGroup(ctx, g1)
+	require.ErrorAs(t, err, &aeErr)
+	g1.DisplayName = "Eng"
+	g1.MemberIDs = []uint{u2.ID}
+	require.NoError(t, ds.ReplaceScimGroup(ctx, g1))
+	got, err := ds.ScimGroupByID(ctx, g1.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Eng", got.DisplayName)
+	require.Equal(t, []uint{u2.ID}, got.MemberIDs)
+
+	err = ds.ReplaceScimGroup(ctx, &fleet.ScimGroup{ID: g2.ID + 1, DisplayName: "Other"})
+	require.True(t, fleet.IsNotFound(err))
+
+	// deleting a user removes it from the groups
+	require.NoError(t, ds.DeleteScimUser(ctx, u1.ID))
+	got, err = ds.ScimGroupByID(ctx, g2.ID)
+	require.NoError(t, err)
+	require.Empty(t, got.MemberIDs)
+
+	// deleting a group removes the memberships
+	require.NoError(t, ds.DeleteScimGroup(ctx, g1.ID))
+	_, err = ds.ScimGroupByID(ctx, g1.ID)
+	require.True(t, fleet.IsNotFound(err))
+	user, err = ds.ScimUserByID(ctx, u2.ID)
+	require.NoError(t, err)
+	require.Empty(t, user.Groups)
+}
+
+func testScimLastRequest(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	_, err := ds.ScimLastRequest(ctx)
+	require.True(t, fleet.IsNotFound(err))
+
+	err = ds.SetScimLastRequest(ctx, &fleet.ScimLastRequest{Status: fleet.ScimRequestStatusError, Details: "conflict"})
+	require.NoError(t, err)
+	req, err := ds.ScimLastRequest(ctx)
+	require.NoError(t, err)
+	require.Equal(t, fleet.ScimRequestStatusError, req.Status)
+	require.Equal(t, "conflict", req.Details)
+	require.NotZero(t, req.RequestedAt)
+
+	err = ds.SetScimLastRequest(ctx, &fleet.ScimLastRequest{Status: fleet.ScimRequestStatusSuccess})
+	require.NoError(t, err)
+	req, err = ds.ScimLastRequest(ctx)
+	require.NoError(t, err)
+	require.Equal(t, fleet.ScimRequestStatusSuccess, req.Status)
+	require.Empty(t, req.Details)
+}
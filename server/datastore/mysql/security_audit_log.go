@@ -0,0 +1,136 @@
+package mysql
+
+import (
+	"context"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+)
+
+const securityAuditLogColumns = `
+    id, created_at, action, user_id, user_name, user_email, ip_address,
+    host_id, host_display_name, prev_hash, hash`
+
+// securityAuditLogVerifyBatchSize is the number of entries loaded at once to
+// verify the hash chain of the security audit log.
+var securityAuditLogVerifyBatchSize = 1000
+
+func (ds *Datastore) NewSecurityAuditLogEntry(ctx context.Context, entry *fleet.SecurityAuditLogEntry) error {
+	const insertStmt = `
+    INSERT INTO security_audit_log
+      (created_at, action, user_id, user_name, user_email, ip_address, host_id, host_display_name, prev_hash, hash)
+    VALUES
+      (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		// lock the chain head (created with the first entry) until the
+		// transaction commits, so that concurrent entries are chained one after
+		// the other.
+		if _, err := tx.ExecContext(ctx, `INSERT INTO security_audit_log_head (id, hash) VALUES (1, '') ON DUPLICATE KEY UPDATE id = id`); err != nil {
+			return ctxerr.Wrap(ctx, err, "create security audit log chain head")
+		}
+		var prevHash string
+		if err := sqlx.GetContext(ctx, tx, &prevHash, `SELECT hash FROM security_audit_log_head WHERE id = 1 FOR UPDATE`); err != nil {
+			return ctxerr.Wrap(ctx, err, "get security audit log chain head")
+		}
+
+		// the timestamp is truncated to the precision of the column so that
+		// the hash matches the stored entry.
+		entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		entry.PrevHash = prevHash
+		entry.Hash = entry.ComputeHash()
+
+		res, err := tx.ExecContext(ctx, insertStmt, entry.CreatedAt, entry.Action, entry.UserID, entry.UserName,
+			entry.UserEmail, entry.IPAddress, entry.HostID, entry.HostDisplayName, entry.PrevHash, entry.Hash)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "insert security audit log entry")
+		}
+		id, _ := res.LastInsertId()
+		entry.ID = uint(id)
+
+		if _, err := tx.ExecContext(ctx, `UPDATE security_audit_log_head SET hash = ? WHERE id = 1`, entry.Hash); err != nil {
+			return ctxerr.Wrap(ctx, err, "update security audit log chain head")
+		}
+		return nil
+	})
+}
+
+func (ds *Datastore) ListSecurityAuditLog(ctx context.Context, opt fleet.ListOptions) ([]*fleet.SecurityAuditLogEntry, *fleet.PaginationMetadata, error) {
+	query := `SELECT ` + securityAuditLogColumns + ` FROM security_audit_log`
+
+	if opt.OrderKey == "" {
+		opt.OrderKey = "id"
+		opt.OrderDirection = fleet.OrderDescending
+	}
+	opt.IncludeMetadata = true
+	query, args := appendListOptionsWithCursorToSQL(query, nil, &opt)
+
+	entries := []*fleet.SecurityAuditLogEntry{}
+	if err := sqlx.SelectContext(ctx, ds.reader, &entries, query, args...); err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list security audit log")
+	}
+
+	metaData := &fleet.PaginationMetadata{HasPreviousResults: opt.Page > 0}
+	if len(entries) > int(opt.PerPage) {
+		metaData.HasNextResults = true
+		entries = entries[:len(entries)-1]
+	}
+	return entries, metaData, nil
+}
+
+func (ds *Datastore) ListSecurityAuditLogToStream(ctx context.Context, limit uint) ([]*fleet.SecurityAuditLogEntry, error) {
+	query := `SELECT ` + securityAuditLogColumns + ` FROM security_audit_log WHERE streamed = 0 ORDER BY id LIMIT ?`
+
+	entries := []*fleet.SecurityAuditLogEntry{}
+	if err := sqlx.SelectContext(ctx, ds.reader, &entries, query, limit); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list security audit log entries to stream")
+	}
+	return entries, nil
+}
+
+func (ds *Datastore) MarkSecurityAuditLogAsStreamed(ctx context.Context, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	query, args, err := sqlx.In(`UPDATE security_audit_log SET streamed = 1 WHERE id IN (?)`, ids)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "sqlx.In mark security audit log as streamed")
+	}
+	if _, err := ds.writer.ExecContext(ctx, query, args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "mark security audit log as streamed")
+	}
+	return nil
+}
+
+func (ds *Datastore) VerifySecurityAuditLog(ctx context.Context) (*fleet.SecurityAuditLogVerification, error) {
+	query := `SELECT ` + securityAuditLogColumns + ` FROM security_audit_log WHERE id > ? ORDER BY id LIMIT ?`
+
+	res := &fleet.SecurityAuditLogVerification{Valid: true}
+	var (
+		lastID   uint
+		prevHash string
+	)
+	for {
+		var entries []*fleet.SecurityAuditLogEntry
+		if err := sqlx.SelectContext(ctx, ds.reader, &entries, query, lastID, securityAuditLogVerifyBatchSize); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "load security audit log entries")
+		}
+
+		for _, e := range entries {
+			if e.PrevHash != prevHash || e.ComputeHash() != e.Hash {
+				res.Valid = false
+				res.FirstInvalidID = &e.ID
+				return res, nil
+			}
+			res.EntryCount++
+			prevHash = e.Hash
+			lastID = e.ID
+		}
+
+		if len(entries) < securityAuditLogVerifyBatchSize {
+			return res, nil
+		}
+	}
+}
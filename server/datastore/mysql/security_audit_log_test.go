@@ -0,0 +1,176 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityAuditLog(t *testing.T) {
+	ds := CreateMySQLDS(t)
+
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, ds *Datastore)
+	}{
+		{"NewAndList", testSecurityAuditLogNewAndList},
+		{"Verify", testSecurityAuditLogVerify},
+		{"Concurrent", testSecurityAuditLogConcurrent},
+		{"Streaming", testSecurityAuditLogStreaming},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer TruncateTables(t, ds)
+			c.fn(t, ds)
+		})
+	}
+}
+
+func newTestSecurityAuditLogEntry(t *testing.T, ds *Datastore, action string, hostID uint) *fleet.SecurityAuditLogEntry {
+	user := &fleet.User{ID: 1, Name: "Alice", Email: "alice@example.com"}
+	host := &fleet.Host{ID: hostID, ComputerName: fmt.Sprintf("host%d", hostID)}
+	entry := fleet.NewSecurityAuditLogEntry(action, user, "1.2.3.4", host)
+	require.NoError(t, ds.NewSecurityAuditLogEntry(context.Background(), entry))
+	return entry
+}
+
+func testSecurityAuditLogNewAndList(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	entries, _, err := ds.ListSecurityAuditLog(ctx, fleet.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	e1 := newTestSecurityAuditLogEntry(t, ds, fleet.SecurityAuditActionReadHostDiskEncryptionKey, 1)
+	require.NotZero(t, e1.ID)
+	require.Empty(t, e1.PrevHash)
+	require.Len(t, e1.Hash, 64)
+
+	e2 := newTestSecurityAuditLogEntry(t, ds, fleet.SecurityAuditActionReadHostUnlockPIN, 2)
+	require.Equal(t, e1.Hash, e2.PrevHash)
+	require.NotEqual(t, e1.Hash, e2.Hash)
+
+	// most recent first
+	entries, meta, err := ds.ListSecurityAuditLog(ctx, fleet.ListOptions{PerPage: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.True(t, meta.HasNextResults)
+	require.Equal(t, e2.ID, entries[0].ID)
+	require.Equal(t, fleet.SecurityAuditActionReadHostUnlockPIN, entries[0].Action)
+	require.Equal(t, ptr.Uint(1), entries[0].UserID)
+	require.Equal(t, "alice@example.com", entries[0].UserEmail)
+	require.Equal(t, "1.2.3.4", entries[0].IPAddress)
+	require.Equal(t, "host2", entries[0].HostDisplayName)
+	require.Equal(t, e2.Hash, entries[0].Hash)
+	// the stored entry matches its hash
+	require.Equal(t, e2.Hash, entries[0].ComputeHash())
+}
+
+func testSecurityAuditLogVerify(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	// an empty log is valid
+	res, err := ds.VerifySecurityAuditLog(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &fleet.SecurityAuditLogVerification{Valid: true}, res)
+
+	defer func(size int) { securityAuditLogVerifyBatchSize = size }(securityAuditLogVerifyBatchSize)
+	securityAuditLogVerifyBatchSize = 2
+
+	var entries []*fleet.SecurityAuditLogEntry
+	for i := 1; i <= 5; i++ {
+		entries = append(entries, newTestSecurityAuditLogEntry(t, ds, fleet.SecurityAuditActionReadHostDiskEncryptionKey, uint(i)))
+	}
+
+	res, err = ds.VerifySecurityAuditLog(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &fleet.SecurityAuditLogVerification{Valid: true, EntryCount: 5}, res)
+
+	// tampering with an entry is detected
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `UPDATE security_audit_log SET user_email = 'bob@example.com' WHERE id = ?`, entries[2].ID)
+		return err
+	})
+	res, err = ds.VerifySecurityAuditLog(ctx)
+	require.NoError(t, err)
+	require.False(t, res.Valid)
+	require.Equal(t, 2, res.EntryCount)
+	require.Equal(t, &entries[2].ID, res.FirstInvalidID)
+
+	// restore it, then delete an entry, the next one doesn't match anymore
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `UPDATE security_audit_log SET user_email = 'alice@example.com' WHERE id = ?`, entries[2].ID)
+		if err != nil {
+			return err
+		}
+		_, err = q.ExecContext(ctx, `DELETE FROM security_audit_log WHERE id = ?`, entries[1].ID)
+		return err
+	})
+	res, err = ds.VerifySecurityAuditLog(ctx)
+	require.NoError(t, err)
+	require.False(t, res.Valid)
+	require.Equal(t, 1, res.EntryCount)
+	require.Equal(t, &entries[2].ID, res.FirstInvalidID)
+}
+
+func testSecurityAuditLogConcurrent(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(hostID uint) {
+			defer wg.Done()
+			newTestSecurityAuditLogEntry(t, ds, fleet.SecurityAuditActionReadHostActivationLockBypassCode, hostID)
+		}(uint(i))
+	}
+	wg.Wait()
+
+	// the entries form a single chain
+	res, err := ds.VerifySecurityAuditLog(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &fleet.SecurityAuditLogVerification{Valid: true, EntryCount: 10}, res)
+
+	// the chain head is the hash of the last entry
+	entries, _, err := ds.ListSecurityAuditLog(ctx, fleet.ListOptions{PerPage: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	var head string
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		return sqlx.GetContext(ctx, q, &head, `SELECT hash FROM security_audit_log_head WHERE id = 1`)
+	})
+	require.Equal(t, entries[0].Hash, head)
+}
+
+func testSecurityAuditLogStreaming(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	e1 := newTestSecurityAuditLogEntry(t, ds, fleet.SecurityAuditActionReadHostDiskEncryptionKey, 1)
+	e2 := newTestSecurityAuditLogEntry(t, ds, fleet.SecurityAuditActionReadHostUnlockPIN, 2)
+	e3 := newTestSecurityAuditLogEntry(t, ds, fleet.SecurityAuditActionReadHostUnlockPIN, 3)
+
+	entries, err := ds.ListSecurityAuditLogToStream(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, e1.ID, entries[0].ID)
+	require.Equal(t, e2.ID, entries[1].ID)
+
+	require.NoError(t, ds.MarkSecurityAuditLogAsStreamed(ctx, []uint{e1.ID, e2.ID}))
+	require.NoError(t, ds.MarkSecurityAuditLogAsStreamed(ctx, nil))
+
+	entries, err = ds.ListSecurityAuditLogToStream(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, e3.ID, entries[0].ID)
+
+	// marking the entries as streamed doesn't break the chain
+	res, err := ds.VerifySecurityAuditLog(ctx)
+	require.NoError(t, err)
+	require.True(t, res.Valid)
+}
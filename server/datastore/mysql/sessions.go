@@ -106,6 +106,16 @@ func (ds *Datastore) DestroyAllSessionsForUser(ctx context.Context, id uint) err
 	return nil
 }
 
+func (ds *Datastore) MarkSessionReauthenticated(ctx context.Context, session *fleet.Session) error {
+	now := ds.clock.Now()
+	_, err := ds.writer.ExecContext(ctx, `UPDATE sessions SET reauthenticated_at = ? WHERE id = ?`, now, session.ID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "updating session reauthenticated_at")
+	}
+	session.ReauthenticatedAt = &now
+	return nil
+}
+
 func (ds *Datastore) MarkSessionAccessed(ctx context.Context, session *fleet.Session) error {
 	sqlStatement := `
 		UPDATE sessions SET
@@ -74,6 +74,14 @@ func testSessionsGetters(t *testing.T, ds *Datastore) {
 	require.NoError(t, err)
 	require.Len(t, sessions, 1)
 	require.NotEqual(t, prevAccessedAt, sessions[0].AccessedAt)
+	require.Nil(t, sessions[0].ReauthenticatedAt)
+
+	require.NoError(t, ds.MarkSessionReauthenticated(context.Background(), newSession))
+	require.NotNil(t, newSession.ReauthenticatedAt)
+	gotByID, err = ds.SessionByID(context.Background(), newSession.ID)
+	require.NoError(t, err)
+	require.NotNil(t, gotByID.ReauthenticatedAt)
+	require.WithinDuration(t, *newSession.ReauthenticatedAt, *gotByID.ReauthenticatedAt, time.Second)
 
 	require.NoError(t, ds.DestroyAllSessionsForUser(context.Background(), user.ID))
 
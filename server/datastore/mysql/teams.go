@@ -106,10 +106,45 @@ func (ds *Datastore) DeleteTeam(ctx context.Context, tid uint) error {
 			return ctxerr.Wrapf(ctx, err, "deleting team global packs for team %d", tid)
 		}
 
+		_, err = tx.ExecContext(ctx, `DELETE FROM mdm_posture_scores WHERE team_id = ? AND global_stats = 0`, tid)
+		if err != nil {
+			return ctxerr.Wrapf(ctx, err, "deleting mdm posture scores for team %d", tid)
+		}
+
 		return nil
 	})
 }
 
+func (ds *Datastore) TeamDeletionReport(ctx context.Context, tid uint) (*fleet.TeamDeletionReport, error) {
+	// the profiles to remove are those of the team that are installed, or
+	// being installed, on its hosts, as deleting the team flips them to
+	// pending removal.
+	stmt := `
+		SELECT
+			(SELECT COUNT(*) FROM hosts h WHERE h.team_id = ?) AS hosts_count,
+			(
+				SELECT COUNT(*)
+				FROM hosts h
+				JOIN nano_enrollments ne ON ne.device_id = h.uuid
+				WHERE h.team_id = ? AND ne.enabled = 1 AND ne.type = 'Device'
+			) AS mdm_enrolled_hosts_count,
+			(SELECT COUNT(*) FROM mdm_apple_configuration_profiles macp WHERE macp.team_id = ?) AS profiles_count,
+			(
+				SELECT COUNT(*)
+				FROM host_mdm_apple_profiles hmap
+				JOIN hosts h ON h.uuid = hmap.host_uuid
+				JOIN mdm_apple_configuration_profiles macp ON macp.profile_id = hmap.profile_id
+				WHERE h.team_id = ? AND macp.team_id = ? AND
+					( hmap.operation_type IS NULL OR hmap.operation_type = ? )
+			) AS host_profiles_to_remove_count
+	`
+	report := &fleet.TeamDeletionReport{TeamID: tid}
+	if err := sqlx.GetContext(ctx, ds.reader, report, stmt, tid, tid, tid, tid, tid, fleet.MDMAppleOperationTypeInstall); err != nil {
+		return nil, ctxerr.Wrapf(ctx, err, "get deletion report for team %d", tid)
+	}
+	return report, nil
+}
+
 func (ds *Datastore) TeamByName(ctx context.Context, name string) (*fleet.Team, error) {
 	stmt := `
 		SELECT * FROM teams
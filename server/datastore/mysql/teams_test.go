@@ -3,6 +3,7 @@ package mysql
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"sort"
 	"testing"
 	"time"
@@ -11,6 +12,7 @@ import (
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/test"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -34,6 +36,7 @@ func TestTeams(t *testing.T) {
 		{"DeleteIntegrationsFromTeams", testTeamsDeleteIntegrationsFromTeams},
 		{"TeamsFeatures", testTeamsFeatures},
 		{"TeamsMDMConfig", testTeamsMDMConfig},
+		{"TeamsDeletionReport", testTeamsDeletionReport},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
@@ -605,7 +608,87 @@ func testTeamsMDMConfig(t *testing.T, ds *Datastore) {
 			MacOSSetup: fleet.MacOSSetup{
 				BootstrapPackage:    optjson.SetString("bootstrap"),
 				MacOSSetupAssistant: optjson.SetString("assistant"),
+				EULA:                optjson.String{Set: true},
 			},
 		}, mdm)
 	})
 }
+
+func testTeamsDeletionReport(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	team, err := ds.NewTeam(ctx, &fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+
+	report, err := ds.TeamDeletionReport(ctx, team.ID)
+	require.NoError(t, err)
+	require.Equal(t, &fleet.TeamDeletionReport{TeamID: team.ID}, report)
+
+	var hosts []*fleet.Host
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("host-%d", i)
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			Hostname:      name,
+			OsqueryHostID: ptr.String(name),
+			NodeKey:       ptr.String(name),
+			UUID:          name,
+			Platform:      "darwin",
+			TeamID:        &team.ID,
+		})
+		require.NoError(t, err)
+		hosts = append(hosts, h)
+	}
+	// only the first two hosts are enrolled in MDM
+	nanoEnroll(t, ds, hosts[0], false)
+	nanoEnroll(t, ds, hosts[1], false)
+
+	require.NoError(t, ds.BatchSetMDMAppleProfiles(ctx, &team.ID, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N1", "I1", "a"),
+		configProfileForTest(t, "N2", "I2", "b"),
+	}))
+	require.NoError(t, ds.BatchSetMDMAppleProfiles(ctx, nil, []*fleet.MDMAppleConfigProfile{
+		configProfileForTest(t, "N3", "I3", "c"),
+	}))
+
+	// install the profiles of the team, and mark one as being removed
+	toInstall, err := ds.ListMDMAppleProfilesToInstall(ctx)
+	require.NoError(t, err)
+	require.Len(t, toInstall, 4)
+	var payload []*fleet.MDMAppleBulkUpsertHostProfilePayload
+	for i, p := range toInstall {
+		op := fleet.MDMAppleOperationTypeInstall
+		if i == 0 {
+			op = fleet.MDMAppleOperationTypeRemove
+		}
+		payload = append(payload, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+			ProfileID:         p.ProfileID,
+			ProfileIdentifier: p.ProfileIdentifier,
+			ProfileName:       p.ProfileName,
+			HostUUID:          p.HostUUID,
+			CommandUUID:       uuid.NewString(),
+			OperationType:     op,
+			Status:            &fleet.MDMAppleDeliveryPending,
+			Checksum:          p.Checksum,
+		})
+	}
+	require.NoError(t, ds.BulkUpsertMDMAppleHostProfiles(ctx, payload))
+
+	report, err = ds.TeamDeletionReport(ctx, team.ID)
+	require.NoError(t, err)
+	require.Equal(t, &fleet.TeamDeletionReport{
+		TeamID:                    team.ID,
+		HostsCount:                3,
+		MDMEnrolledHostsCount:     2,
+		ProfilesCount:             2,
+		HostProfilesToRemoveCount: 3,
+	}, report)
+
+	// the hosts are listed in batches
+	ids, err := ds.HostIDsByTeam(ctx, team.ID, 2)
+	require.NoError(t, err)
+	require.Equal(t, []uint{hosts[0].ID, hosts[1].ID}, ids)
+	require.NoError(t, ds.AddHostsToTeam(ctx, nil, ids))
+	ids, err = ds.HostIDsByTeam(ctx, team.ID, 2)
+	require.NoError(t, err)
+	require.Equal(t, []uint{hosts[2].ID}, ids)
+}
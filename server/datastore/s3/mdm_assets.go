@@ -0,0 +1,143 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/fleetdm/fleet/v4/server/config"
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+)
+
+type mdmAssetNotFoundError struct{}
+
+var _ fleet.NotFoundError = (*mdmAssetNotFoundError)(nil)
+
+func (p mdmAssetNotFoundError) Error() string {
+	return "mdm asset not found"
+}
+
+func (p mdmAssetNotFoundError) IsNotFound() bool {
+	return true
+}
+
+// MDMAssetStore contains methods to store and retrieve MDM assets (bootstrap
+// packages and EULAs) from S3
+type MDMAssetStore struct {
+	*s3store
+}
+
+var _ fleet.MDMAssetStore = (*MDMAssetStore)(nil)
+
+// NewMDMAssetStore creates a new instance with the given S3 config
+func NewMDMAssetStore(config config.S3Config) (*MDMAssetStore, error) {
+	s3store, err := newS3store(config)
+	if err != nil {
+		return nil, err
+	}
+	return &MDMAssetStore{s3store}, nil
+}
+
+// Get retrieves the requested asset from S3
+func (m *MDMAssetStore) Get(ctx context.Context, kind fleet.MDMAssetKind, token string) (io.ReadCloser, int64, error) {
+	key := m.keyForAsset(kind, token)
+	req, err := m.s3client.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: &m.bucket, Key: &key})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchBucket, "NotFound":
+				return nil, int64(0), mdmAssetNotFoundError{}
+			}
+		}
+
+		return nil, int64(0), ctxerr.Wrap(ctx, err, "retrieving mdm asset from store")
+	}
+
+	return req.Body, *req.ContentLength, nil
+}
+
+// Put uploads an asset to S3. The content is streamed in parts, so it does
+// not need to be held in memory.
+func (m *MDMAssetStore) Put(ctx context.Context, kind fleet.MDMAssetKind, token string, content io.Reader) error {
+	key := m.keyForAsset(kind, token)
+	uploader := s3manager.NewUploaderWithClient(m.s3client)
+	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: &m.bucket,
+		Key:    &key,
+		Body:   content,
+	})
+	return ctxerr.Wrap(ctx, err, "uploading mdm asset to store")
+}
+
+// Delete removes an asset from S3, deleting a missing asset is not an error.
+func (m *MDMAssetStore) Delete(ctx context.Context, kind fleet.MDMAssetKind, token string) error {
+	key := m.keyForAsset(kind, token)
+	_, err := m.s3client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: &m.bucket, Key: &key})
+	return ctxerr.Wrap(ctx, err, "deleting mdm asset from store")
+}
+
+// Cleanup deletes the assets that are not referenced anymore, e.g. because
+// the request that stored them failed before the database was updated, or
+// because deleting them failed after the database row was removed. Assets
+// stored after olderThan are kept to avoid racing with in-progress uploads.
+func (m *MDMAssetStore) Cleanup(ctx context.Context, keep []string, olderThan time.Time) (int, error) {
+	keepSet := make(map[string]bool, len(keep))
+	for _, token := range keep {
+		keepSet[token] = true
+	}
+
+	var deleted int
+	for _, kind := range []fleet.MDMAssetKind{fleet.MDMAssetBootstrapPackage, fleet.MDMAssetEULA, fleet.MDMAssetEnrollmentProfile} {
+		var toDelete []*s3.ObjectIdentifier
+		prefix := m.keyForAsset(kind, "") + "/"
+		err := m.s3client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket: &m.bucket,
+			Prefix: &prefix,
+		}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, obj := range page.Contents {
+				if keepSet[path.Base(*obj.Key)] || !obj.LastModified.Before(olderThan) {
+					continue
+				}
+				toDelete = append(toDelete, &s3.ObjectIdentifier{Key: obj.Key})
+			}
+			return true
+		})
+		if err != nil {
+			return deleted, ctxerr.Wrap(ctx, err, "listing mdm assets")
+		}
+
+		// DeleteObjects accepts up to 1000 keys per request
+		for len(toDelete) > 0 {
+			batch := toDelete
+			if len(batch) > defaultMaxS3Keys {
+				batch = batch[:defaultMaxS3Keys]
+			}
+			toDelete = toDelete[len(batch):]
+
+			if _, err := m.s3client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+				Bucket: &m.bucket,
+				Delete: &s3.Delete{Objects: batch},
+			}); err != nil {
+				return deleted, ctxerr.Wrap(ctx, err, "deleting unused mdm assets")
+			}
+			deleted += len(batch)
+		}
+	}
+
+	return deleted, nil
+}
+
+// Path returns the S3 key of the asset.
+func (m *MDMAssetStore) Path(kind fleet.MDMAssetKind, token string) string {
+	return m.keyForAsset(kind, token)
+}
+
+// keyForAsset builds the S3 key of an asset
+func (m *MDMAssetStore) keyForAsset(kind fleet.MDMAssetKind, token string) string {
+	return path.Join(m.prefix, string(kind), token)
+}
@@ -0,0 +1,79 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMDMAssetStore(t *testing.T) {
+	ctx := context.Background()
+	store := SetupTestMDMAssetStore(t, "mdm-assets-unit-test", "prefix")
+
+	t.Run("put, get and delete an asset", func(t *testing.T) {
+		err := store.Put(ctx, fleet.MDMAssetBootstrapPackage, "abc", strings.NewReader("package"))
+		require.NoError(t, err)
+
+		blob, length, err := store.Get(ctx, fleet.MDMAssetBootstrapPackage, "abc")
+		require.NoError(t, err)
+		contents, err := io.ReadAll(blob)
+		require.NoError(t, err)
+		require.NoError(t, blob.Close())
+		require.Equal(t, "package", string(contents))
+		require.EqualValues(t, len(contents), length)
+
+		// assets are scoped by kind
+		_, _, err = store.Get(ctx, fleet.MDMAssetEULA, "abc")
+		require.True(t, fleet.IsNotFound(err))
+
+		err = store.Delete(ctx, fleet.MDMAssetBootstrapPackage, "abc")
+		require.NoError(t, err)
+		_, _, err = store.Get(ctx, fleet.MDMAssetBootstrapPackage, "abc")
+		require.True(t, fleet.IsNotFound(err))
+
+		// deleting a missing asset is not an error
+		err = store.Delete(ctx, fleet.MDMAssetBootstrapPackage, "abc")
+		require.NoError(t, err)
+	})
+
+	t.Run("cleanup unused assets", func(t *testing.T) {
+		require.NoError(t, store.Put(ctx, fleet.MDMAssetBootstrapPackage, "used-pkg", strings.NewReader("package")))
+		require.NoError(t, store.Put(ctx, fleet.MDMAssetBootstrapPackage, "unused-pkg", strings.NewReader("package")))
+		require.NoError(t, store.Put(ctx, fleet.MDMAssetEULA, "used-eula", strings.NewReader("eula")))
+		require.NoError(t, store.Put(ctx, fleet.MDMAssetEULA, "unused-eula", strings.NewReader("eula")))
+		keep := []string{"used-pkg", "used-eula"}
+
+		// recently stored assets are kept
+		n, err := store.Cleanup(ctx, keep, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		require.Zero(t, n)
+
+		n, err = store.Cleanup(ctx, keep, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+
+		for _, tc := range []struct {
+			kind  fleet.MDMAssetKind
+			token string
+			found bool
+		}{
+			{fleet.MDMAssetBootstrapPackage, "used-pkg", true},
+			{fleet.MDMAssetBootstrapPackage, "unused-pkg", false},
+			{fleet.MDMAssetEULA, "used-eula", true},
+			{fleet.MDMAssetEULA, "unused-eula", false},
+		} {
+			blob, _, err := store.Get(ctx, tc.kind, tc.token)
+			if tc.found {
+				require.NoError(t, err)
+				blob.Close()
+			} else {
+				require.True(t, fleet.IsNotFound(err))
+			}
+		}
+	})
+}
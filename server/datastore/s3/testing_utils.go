@@ -40,7 +40,32 @@ func SetupTestInstallerStore(tb testing.TB, bucket, prefix string) *InstallerSto
 	err = store.CreateTestBucket(bucket)
 	require.NoError(tb, err)
 
-	tb.Cleanup(func() { cleanupStore(tb, store) })
+	tb.Cleanup(func() { cleanupStore(tb, store.s3store) })
+
+	return store
+}
+
+// SetupTestMDMAssetStore creates a new store with minio as a back-end
+// for local testing
+func SetupTestMDMAssetStore(tb testing.TB, bucket, prefix string) *MDMAssetStore {
+	checkEnv(tb)
+
+	store, err := NewMDMAssetStore(config.S3Config{
+		Bucket:           bucket,
+		Prefix:           prefix,
+		Region:           "minio",
+		EndpointURL:      testEndpoint,
+		AccessKeyID:      accessKeyID,
+		SecretAccessKey:  secretAccessKey,
+		ForceS3PathStyle: true,
+		DisableSSL:       true,
+	})
+	require.Nil(tb, err)
+
+	err = store.CreateTestBucket(bucket)
+	require.NoError(tb, err)
+
+	tb.Cleanup(func() { cleanupStore(tb, store.s3store) })
 
 	return store
 }
@@ -76,7 +101,7 @@ func mockInstaller(secret, kind string, desktop bool) fleet.Installer {
 	}
 }
 
-func cleanupStore(tb testing.TB, store *InstallerStore) {
+func cleanupStore(tb testing.TB, store *s3store) {
 	checkEnv(tb)
 	resp, err := store.s3client.ListObjects(&s3.ListObjectsInput{
 		Bucket: &store.bucket,
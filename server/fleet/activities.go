@@ -27,6 +27,7 @@ var ActivityDetailsList = []ActivityDetails{
 	ActivityTypeCreatedTeam{},
 	ActivityTypeDeletedTeam{},
 	ActivityTypeAppliedSpecTeam{},
+	ActivityTypeTransferredHostsToTeam{},
 
 	ActivityTypeEditedAgentOptions{},
 
@@ -46,23 +47,45 @@ var ActivityDetailsList = []ActivityDetails{
 
 	ActivityTypeMDMEnrolled{},
 	ActivityTypeMDMUnenrolled{},
+	ActivityTypeMDMEnrollmentPendingApproval{},
+	ActivityTypeApprovedMDMEnrollment{},
+	ActivityTypeMDMProfileChangePendingApproval{},
+	ActivityTypeApprovedMDMProfileChange{},
+	ActivityTypeRejectedMDMProfileChange{},
+	ActivityTypeRequestedFleetdInstall{},
+	ActivityTypeReleasedMDMAppleDEPDevice{},
+	ActivityTypeUploadedMDMAppleDEPTeamAssignments{},
+	ActivityTypeRevokedMDMAppleSSOEnrollmentToken{},
+	ActivityTypeCanceledMDMAppleCommand{},
+	ActivityTypePurgedHostMDMData{},
 
 	ActivityTypeEditedMacOSMinVersion{},
 
 	ActivityTypeReadHostDiskEncryptionKey{},
+	ActivityTypeAccessedMDMSecret{},
+	ActivityTypeReadHostActivationLockBypassCode{},
+	ActivityTypeReadHostUnlockPIN{},
+	ActivityTypeQuarantinedHost{},
 
 	ActivityTypeCreatedMacosProfile{},
 	ActivityTypeDeletedMacosProfile{},
 	ActivityTypeEditedMacosProfile{},
+	ActivityTypeDeletedMultipleMacosProfiles{},
+	ActivityTypeCopiedMacosProfile{},
+	ActivityTypeInstalledHostMacosProfile{},
+	ActivityTypeDeletedHostMacosProfile{},
 
 	ActivityTypeChangedMacosSetupAssistant{},
 	ActivityTypeDeletedMacosSetupAssistant{},
 
 	ActivityTypeEnabledMacosDiskEncryption{},
 	ActivityTypeDisabledMacosDiskEncryption{},
+	ActivityTypeRotatedMacosDiskEncryptionKeys{},
 
 	ActivityTypeAddedBootstrapPackage{},
 	ActivityTypeDeletedBootstrapPackage{},
+
+	ActivityTypeMigratedMDMServerURL{},
 }
 
 type ActivityDetails interface {
@@ -393,6 +416,28 @@ func (a ActivityTypeAppliedSpecTeam) Documentation() (activity string, details s
 }`
 }
 
+type ActivityTypeTransferredHostsToTeam struct {
+	TeamID   *uint   `json:"team_id"`
+	TeamName *string `json:"team_name"`
+	HostIDs  []uint  `json:"host_ids"`
+}
+
+func (a ActivityTypeTransferredHostsToTeam) ActivityName() string {
+	return "transferred_hosts"
+}
+
+func (a ActivityTypeTransferredHostsToTeam) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when hosts are transferred to a team, by a user or automatically when a host enrolls in Fleet's MDM (the activity has no user in that case).`,
+		`This activity contains the following fields:
+- "team_id": The ID of the team the hosts were transferred to, null if they were transferred to no team.
+- "team_name": The name of the team the hosts were transferred to, null if they were transferred to no team.
+- "host_ids": The IDs of the transferred hosts.`, `{
+  "team_id": 123,
+  "team_name": "Workstations",
+  "host_ids": [1, 2, 3]
+}`
+}
+
 type ActivityTypeEditedAgentOptions struct {
 	Global   bool    `json:"global"`
 	TeamID   *uint   `json:"team_id"`
@@ -660,6 +705,7 @@ type ActivityTypeMDMEnrolled struct {
 	HostSerial       string `json:"host_serial"`
 	HostDisplayName  string `json:"host_display_name"`
 	InstalledFromDEP bool   `json:"installed_from_dep"`
+	MDMPlatform      string `json:"mdm_platform"`
 }
 
 func (a ActivityTypeMDMEnrolled) ActivityName() string {
@@ -671,10 +717,300 @@ func (a ActivityTypeMDMEnrolled) Documentation() (activity string, details strin
 		`This activity contains the following fields:
 - "host_serial": Serial number of the host.
 - "host_display_name": Display name of the host.
-- "installed_from_dep": Whether the host was enrolled via DEP.`, `{
+- "installed_from_dep": Whether the host was enrolled via DEP.
+- "mdm_platform": The MDM protocol of the host, "apple" or "microsoft". The serial number of a Windows host is empty.`, `{
   "host_serial": "C08VQ2AXHT96",
   "host_display_name": "MacBookPro16,1 (C08VQ2AXHT96)",
-  "installed_from_dep": true
+  "installed_from_dep": true,
+  "mdm_platform": "apple"
+}`
+}
+
+type ActivityTypeMDMEnrollmentPendingApproval struct {
+	HostSerial      string `json:"host_serial"`
+	HostDisplayName string `json:"host_display_name"`
+}
+
+func (a ActivityTypeMDMEnrollmentPendingApproval) ActivityName() string {
+	return "mdm_enrollment_pending_approval"
+}
+
+func (a ActivityTypeMDMEnrollmentPendingApproval) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when a host enrolls manually in Fleet's MDM while manual enrollments must be approved. The host receives no profiles and no commands until its enrollment is approved.`,
+		`This activity contains the following fields:
+- "host_serial": Serial number of the host.
+- "host_display_name": Display name of the host.`, `{
+  "host_serial": "C08VQ2AXHT96",
+  "host_display_name": "MacBookPro16,1 (C08VQ2AXHT96)"
+}`
+}
+
+type ActivityTypeApprovedMDMEnrollment struct {
+	HostID          uint   `json:"host_id"`
+	HostSerial      string `json:"host_serial"`
+	HostDisplayName string `json:"host_display_name"`
+}
+
+func (a ActivityTypeApprovedMDMEnrollment) ActivityName() string {
+	return "approved_mdm_enrollment"
+}
+
+func (a ActivityTypeApprovedMDMEnrollment) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when a user approves the pending manual enrollment of a host in Fleet's MDM.`,
+		`This activity contains the following fields:
+- "host_id": ID of the host.
+- "host_serial": Serial number of the host.
+- "host_display_name": Display name of the host.`, `{
+  "host_id": 1,
+  "host_serial": "C08VQ2AXHT96",
+  "host_display_name": "MacBookPro16,1 (C08VQ2AXHT96)"
+}`
+}
+
+type ActivityTypeRequestedFleetdInstall struct {
+	HostID          uint   `json:"host_id"`
+	HostDisplayName string `json:"host_display_name"`
+	CommandUUID     string `json:"command_uuid"`
+}
+
+func (a ActivityTypeRequestedFleetdInstall) ActivityName() string {
+	return "requested_fleetd_install"
+}
+
+func (a ActivityTypeRequestedFleetdInstall) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when a user requests the installation of fleetd on a host enrolled in Fleet's MDM, usually because fleetd never enrolled.`,
+		`This activity contains the following fields:
+- "host_id": ID of the host.
+- "host_display_name": Display name of the host.
+- "command_uuid": UUID of the MDM command that installs fleetd.`, `{
+  "host_id": 1,
+  "host_display_name": "MacBookPro16,1 (C08VQ2AXHT96)",
+  "command_uuid": "d6fbe0dd-93e0-4db9-8b48-1c6b8fa7e7a4"
+}`
+}
+
+type ActivityTypePurgedHostMDMData struct {
+	HostID          uint   `json:"host_id"`
+	HostSerial      string `json:"host_serial"`
+	HostDisplayName string `json:"host_display_name"`
+}
+
+func (a ActivityTypePurgedHostMDMData) ActivityName() string {
+	return "purged_host_mdm_data"
+}
+
+func (a ActivityTypePurgedHostMDMData) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when a user purges the MDM data of a host, e.g. after it was wiped and re-imaged.`,
+		`This activity contains the following fields:
+- "host_id": ID of the host.
+- "host_serial": Serial number of the host.
+- "host_display_name": Display name of the host.`, `{
+  "host_id": 1,
+  "host_serial": "C08VQ2AXHT96",
+  "host_display_name": "MacBookPro16,1 (C08VQ2AXHT96)"
+}`
+}
+
+type ActivityTypeReleasedMDMAppleDEPDevice struct {
+	HostSerial         string `json:"host_serial"`
+	HostID             *uint  `json:"host_id"`
+	DeletedPendingHost bool   `json:"deleted_pending_host"`
+}
+
+func (a ActivityTypeReleasedMDMAppleDEPDevice) ActivityName() string {
+	return "released_mdm_apple_dep_device"
+}
+
+func (a ActivityTypeReleasedMDMAppleDEPDevice) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when a user releases a device from Fleet's MDM server in Apple Business Manager.`,
+		`This activity contains the following fields:
+- "host_serial": Serial number of the device.
+- "host_id": ID of the host with that serial number, or null if there is no such host.
+- "deleted_pending_host": Whether the host was deleted because it was waiting to enroll via automatic enrollment (DEP).`, `{
+  "host_serial": "C08VQ2AXHT96",
+  "host_id": 1,
+  "deleted_pending_host": true
+}`
+}
+
+type ActivityTypeUploadedMDMAppleDEPTeamAssignments struct {
+	AssignedCount int `json:"assigned_count"`
+	RemovedCount  int `json:"removed_count"`
+}
+
+func (a ActivityTypeUploadedMDMAppleDEPTeamAssignments) ActivityName() string {
+	return "uploaded_mdm_apple_dep_team_assignments"
+}
+
+func (a ActivityTypeUploadedMDMAppleDEPTeamAssignments) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when a user uploads the teams that devices are assigned to when they are first synced from Apple Business Manager.`,
+		`This activity contains the following fields:
+- "assigned_count": Number of serial numbers assigned to a team.
+- "removed_count": Number of serial numbers whose team assignment was removed.`, `{
+  "assigned_count": 120,
+  "removed_count": 2
+}`
+}
+
+type ActivityTypeRevokedMDMAppleSSOEnrollmentToken struct {
+	TokenID     uint   `json:"token_id"`
+	IdPUsername string `json:"idp_username"`
+}
+
+func (a ActivityTypeRevokedMDMAppleSSOEnrollmentToken) ActivityName() string {
+	return "revoked_mdm_apple_sso_enrollment_token"
+}
+
+func (a ActivityTypeRevokedMDMAppleSSOEnrollmentToken) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when a user revokes the enrollment token issued to an end user that authenticated with the identity provider (IdP) during automatic enrollment.`,
+		`This activity contains the following fields:
+- "token_id": The ID of the revoked token.
+- "idp_username": The username of the end user the token was issued to.`, `{
+  "token_id": 12,
+  "idp_username": "jdoe@example.com"
+}`
+}
+
+type ActivityTypeCanceledMDMAppleCommand struct {
+	HostID          uint   `json:"host_id"`
+	HostDisplayName string `json:"host_display_name"`
+	CommandUUID     string `json:"command_uuid"`
+	RequestType     string `json:"request_type"`
+}
+
+func (a ActivityTypeCanceledMDMAppleCommand) ActivityName() string {
+	return "canceled_mdm_apple_command"
+}
+
+func (a ActivityTypeCanceledMDMAppleCommand) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when a user cancels an MDM command queued for a macOS host before it was delivered.`,
+		`This activity contains the following fields:
+- "host_id": ID of the host.
+- "host_display_name": Display name of the host.
+- "command_uuid": UUID of the canceled command.
+- "request_type": The request type of the canceled command.`, `{
+  "host_id": 1,
+  "host_display_name": "Anna's MacBook Pro",
+  "command_uuid": "a2064cef-0000-1234-afb9-283e3c1d487e",
+  "request_type": "EraseDevice"
+}`
+}
+
+type ActivityTypeAccessedMDMSecret struct {
+	SecretName  string `json:"secret_name"`
+	ProfileID   uint   `json:"profile_id"`
+	ProfileName string `json:"profile_name"`
+	HostCount   int    `json:"host_count"`
+}
+
+func (a ActivityTypeAccessedMDMSecret) ActivityName() string {
+	return "accessed_mdm_secret"
+}
+
+func (a ActivityTypeAccessedMDMSecret) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when Fleet reads a secret from the external secrets manager to deliver a configuration profile that references it.`,
+		`This activity contains the following fields:
+- "secret_name": Name of the secret.
+- "profile_id": ID of the configuration profile.
+- "profile_name": Name of the configuration profile.
+- "host_count": Number of hosts the profile was delivered to with the secret.`, `{
+  "secret_name": "WIFI_PSK",
+  "profile_id": 42,
+  "profile_name": "Office Wi-Fi",
+  "host_count": 12
+}`
+}
+
+type ActivityTypeMDMProfileChangePendingApproval struct {
+	ChangeID          uint                  `json:"change_id"`
+	ProfileID         uint                  `json:"profile_id"`
+	ProfileName       string                `json:"profile_name"`
+	ProfileIdentifier string                `json:"profile_identifier"`
+	OperationType     MDMAppleOperationType `json:"operation_type"`
+	HostCount         int                   `json:"host_count"`
+}
+
+func (a ActivityTypeMDMProfileChangePendingApproval) ActivityName() string {
+	return "mdm_profile_change_pending_approval"
+}
+
+func (a ActivityTypeMDMProfileChangePendingApproval) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when the installation or removal of a macOS configuration profile affects more hosts than allowed without approval. Its commands are not sent until the change is approved.`,
+		`This activity contains the following fields:
+- "change_id": ID of the profile change.
+- "profile_id": ID of the profile.
+- "profile_name": Name of the profile.
+- "profile_identifier": Identifier of the profile.
+- "operation_type": The operation of the change, "install" or "remove".
+- "host_count": Number of hosts affected by the change.`, `{
+  "change_id": 1,
+  "profile_id": 42,
+  "profile_name": "Restrictions",
+  "profile_identifier": "com.example.restrictions",
+  "operation_type": "install",
+  "host_count": 1200
+}`
+}
+
+type ActivityTypeApprovedMDMProfileChange struct {
+	ChangeID          uint                  `json:"change_id"`
+	ProfileID         uint                  `json:"profile_id"`
+	ProfileName       string                `json:"profile_name"`
+	ProfileIdentifier string                `json:"profile_identifier"`
+	OperationType     MDMAppleOperationType `json:"operation_type"`
+	HostCount         int                   `json:"host_count"`
+}
+
+func (a ActivityTypeApprovedMDMProfileChange) ActivityName() string {
+	return "approved_mdm_profile_change"
+}
+
+func (a ActivityTypeApprovedMDMProfileChange) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when a user approves a pending change of a macOS configuration profile.`,
+		`This activity contains the following fields:
+- "change_id": ID of the profile change.
+- "profile_id": ID of the profile.
+- "profile_name": Name of the profile.
+- "profile_identifier": Identifier of the profile.
+- "operation_type": The operation of the change, "install" or "remove".
+- "host_count": Number of hosts affected by the change.`, `{
+  "change_id": 1,
+  "profile_id": 42,
+  "profile_name": "Restrictions",
+  "profile_identifier": "com.example.restrictions",
+  "operation_type": "install",
+  "host_count": 1200
+}`
+}
+
+type ActivityTypeRejectedMDMProfileChange struct {
+	ChangeID          uint                  `json:"change_id"`
+	ProfileID         uint                  `json:"profile_id"`
+	ProfileName       string                `json:"profile_name"`
+	ProfileIdentifier string                `json:"profile_identifier"`
+	OperationType     MDMAppleOperationType `json:"operation_type"`
+	HostCount         int                   `json:"host_count"`
+}
+
+func (a ActivityTypeRejectedMDMProfileChange) ActivityName() string {
+	return "rejected_mdm_profile_change"
+}
+
+func (a ActivityTypeRejectedMDMProfileChange) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when a user rejects a pending change of a macOS configuration profile. Its commands are not sent unless the profile is modified.`,
+		`This activity contains the following fields:
+- "change_id": ID of the profile change.
+- "profile_id": ID of the profile.
+- "profile_name": Name of the profile.
+- "profile_identifier": Identifier of the profile.
+- "operation_type": The operation of the change, "install" or "remove".
+- "host_count": Number of hosts affected by the change.`, `{
+  "change_id": 1,
+  "profile_id": 42,
+  "profile_name": "Restrictions",
+  "profile_identifier": "com.example.restrictions",
+  "operation_type": "install",
+  "host_count": 1200
 }`
 }
 
@@ -682,6 +1018,7 @@ type ActivityTypeMDMUnenrolled struct {
 	HostSerial       string `json:"host_serial"`
 	HostDisplayName  string `json:"host_display_name"`
 	InstalledFromDEP bool   `json:"installed_from_dep"`
+	MDMPlatform      string `json:"mdm_platform"`
 }
 
 func (a ActivityTypeMDMUnenrolled) ActivityName() string {
@@ -693,10 +1030,12 @@ func (a ActivityTypeMDMUnenrolled) Documentation() (activity string, details str
 		`This activity contains the following fields:
 - "host_serial": Serial number of the host.
 - "host_display_name": Display name of the host.
-- "installed_from_dep": Whether the host was enrolled via DEP.`, `{
+- "installed_from_dep": Whether the host was enrolled via DEP.
+- "mdm_platform": The MDM protocol of the host, "apple" or "microsoft". The serial number of a Windows host is empty.`, `{
   "host_serial": "C08VQ2AXHT96",
   "host_display_name": "MacBookPro16,1 (C08VQ2AXHT96)",
-  "installed_from_dep": true
+  "installed_from_dep": true,
+  "mdm_platform": "apple"
 }`
 }
 
@@ -744,6 +1083,75 @@ func (a ActivityTypeReadHostDiskEncryptionKey) Documentation() (activity string,
 }`
 }
 
+type ActivityTypeReadHostActivationLockBypassCode struct {
+	HostID          uint   `json:"host_id"`
+	HostDisplayName string `json:"host_display_name"`
+}
+
+func (a ActivityTypeReadHostActivationLockBypassCode) ActivityName() string {
+	return "read_host_activation_lock_bypass_code"
+}
+
+func (a ActivityTypeReadHostActivationLockBypassCode) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when a user reads the Activation Lock bypass code for a host.`,
+		`This activity contains the following fields:
+- "host_id": ID of the host.
+- "host_display_name": Display name of the host.`, `{
+  "host_id": 1,
+  "host_display_name": "Anna's iPad",
+}`
+}
+
+type ActivityTypeReadHostUnlockPIN struct {
+	HostID          uint   `json:"host_id"`
+	HostDisplayName string `json:"host_display_name"`
+}
+
+func (a ActivityTypeReadHostUnlockPIN) ActivityName() string {
+	return "read_host_unlock_pin"
+}
+
+func (a ActivityTypeReadHostUnlockPIN) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when a user reads the PIN that unlocks a host after it was locked or wiped.`,
+		`This activity contains the following fields:
+- "host_id": ID of the host.
+- "host_display_name": Display name of the host.`, `{
+  "host_id": 1,
+  "host_display_name": "Anna's MacBook Pro"
+}`
+}
+
+type ActivityTypeQuarantinedHost struct {
+	HostID          uint   `json:"host_id"`
+	HostDisplayName string `json:"host_display_name"`
+	CaseID          string `json:"case_id"`
+	TeamID          uint   `json:"team_id"`
+	TeamName        string `json:"team_name"`
+	Locked          bool   `json:"locked"`
+}
+
+func (a ActivityTypeQuarantinedHost) ActivityName() string {
+	return "quarantined_host"
+}
+
+func (a ActivityTypeQuarantinedHost) Documentation() (activity string, details string, detailsExample string) {
+	return `Generated when a user quarantines a host.`,
+		`This activity contains the following fields:
+- "host_id": ID of the host.
+- "host_display_name": Display name of the host.
+- "case_id": The case ID recorded with the quarantine.
+- "team_id": The ID of the quarantine team the host was moved to.
+- "team_name": The name of the quarantine team the host was moved to.
+- "locked": Whether the host was locked.`, `{
+  "host_id": 1,
+  "host_display_name": "Anna's MacBook Pro",
+  "case_id": "IR-2023-0042",
+  "team_id": 123,
+  "team_name": "Quarantine",
+  "locked": true
+}`
+}
+
 type ActivityTypeCreatedMacosProfile struct {
 	ProfileName       string  `json:"profile_name"`
 	ProfileIdentifier string  `json:"profile_identifier"`
@@ -794,9 +1202,37 @@ func (a ActivityTypeDeletedMacosProfile) Documentation() (activity, details, det
 }`
 }
 
+// MaxActivityMacosProfileChanges is the maximum number of profiles recorded
+// in each list of profile changes of the edited_macos_profile activity, to
+// bound the size of the activity.
+const MaxActivityMacosProfileChanges = 50
+
+// ActivityMacosProfileChange is a macOS profile that was added, removed or
+// changed by a batch edit of the macOS profiles.
+type ActivityMacosProfileChange struct {
+	Name       string `json:"name"`
+	Identifier string `json:"identifier"`
+	// Checksum is the hex-encoded checksum of the profile, for a removed
+	// profile it is the checksum of the profile that was removed.
+	Checksum string `json:"checksum"`
+	// PreviousName is the name of a changed profile before the change, only
+	// set if the name changed.
+	PreviousName string `json:"previous_name,omitempty"`
+	// PreviousChecksum is the checksum of a changed profile before the change.
+	PreviousChecksum string `json:"previous_checksum,omitempty"`
+}
+
 type ActivityTypeEditedMacosProfile struct {
-	TeamID   *uint   `json:"team_id"`
-	TeamName *string `json:"team_name"`
+	TeamID          *uint                        `json:"team_id"`
+	TeamName        *string                      `json:"team_name"`
+	AddedProfiles   []ActivityMacosProfileChange `json:"added_profiles"`
+	RemovedProfiles []ActivityMacosProfileChange `json:"removed_profiles"`
+	ChangedProfiles []ActivityMacosProfileChange `json:"changed_profiles"`
+	// ProfilesTruncated is true if some profile changes are not recorded
+	// because a list exceeded MaxActivityMacosProfileChanges.
+	ProfilesTruncated bool `json:"profiles_truncated"`
+	// AllTeams is true if the edited profiles apply to all teams.
+	AllTeams bool `json:"all_teams,omitempty"`
 }
 
 func (a ActivityTypeEditedMacosProfile) ActivityName() string {
@@ -807,9 +1243,167 @@ func (a ActivityTypeEditedMacosProfile) Documentation() (activity, details, deta
 	return `Generated when a user edits the macOS profiles of a team (or no team) via the fleetctl CLI.`,
 		`This activity contains the following fields:
 - "team_id": The ID of the team that the profiles apply to, null if they apply to devices that are not in a team.
-- "team_name": The name of the team that the profiles apply to, null if they apply to devices that are not in a team.`, `{
+- "team_name": The name of the team that the profiles apply to, null if they apply to devices that are not in a team.
+- "added_profiles": The profiles that were added, with their "name", "identifier" and "checksum" (hex-encoded MD5 of the canonical form of the profile, with sorted keys and normalized whitespace, or SHA-256 truncated to 16 bytes if the profile_checksum_algorithm MDM configuration is sha256).
+- "removed_profiles": The profiles that were removed, with their "name", "identifier" and "checksum".
+- "changed_profiles": The profiles whose content changed, with their "name", "identifier" and "checksum", as well as their "previous_checksum" and their "previous_name" if it changed.
+- "profiles_truncated": Whether some profiles were left out of the lists above, each list records at most 50 profiles.
+- "all_teams": Present and true if the profiles apply to the hosts of all teams (and no team), in which case "team_id" and "team_name" are null.`, `{
   "team_id": 123,
-  "team_name": "Workstations"
+  "team_name": "Workstations",
+  "added_profiles": [
+    {
+      "name": "Restrictions",
+      "identifier": "com.example.restrictions",
+      "checksum": "f3c2b6f9e0a1d8e5a4b1e2c3d4f5a6b7"
+    }
+  ],
+  "removed_profiles": [],
+  "changed_profiles": [
+    {
+      "name": "Wi-Fi",
+      "identifier": "com.example.wifi",
+      "checksum": "0a1b2c3d4e5f60718293a4b5c6d7e8f9",
+      "previous_checksum": "9f8e7d6c5b4a39281706f5e4d3c2b1a0"
+    }
+  ],
+  "profiles_truncated": false
+}`
+}
+
+// ActivityMacosProfile is a macOS profile of a team (or no team) affected by
+// a bulk operation on the macOS profiles.
+type ActivityMacosProfile struct {
+	ProfileName       string  `json:"profile_name"`
+	ProfileIdentifier string  `json:"profile_identifier"`
+	TeamID            *uint   `json:"team_id"`
+	TeamName          *string `json:"team_name"`
+}
+
+type ActivityTypeDeletedMultipleMacosProfiles struct {
+	Profiles []ActivityMacosProfile `json:"profiles"`
+	// ProfilesTruncated is true if some deleted profiles are not recorded
+	// because the list exceeded MaxActivityMacosProfileChanges.
+	ProfilesTruncated bool `json:"profiles_truncated"`
+}
+
+func (a ActivityTypeDeletedMultipleMacosProfiles) ActivityName() string {
+	return "deleted_multiple_macos_profiles"
+}
+
+func (a ActivityTypeDeletedMultipleMacosProfiles) Documentation() (activity, details, detailsExample string) {
+	return `Generated when a user deletes multiple macOS profiles, possibly from multiple teams, in a single operation.`,
+		`This activity contains the following fields:
+- "profiles": The deleted profiles, with their "profile_name", "profile_identifier", and the "team_id" and "team_name" of the team they applied to (null if they applied to devices that are not in a team).
+- "profiles_truncated": Whether some profiles were left out of the list above, it records at most 50 profiles.`, `{
+  "profiles": [
+    {
+      "profile_name": "Custom settings 1",
+      "profile_identifier": "com.my.profile",
+      "team_id": 123,
+      "team_name": "Workstations"
+    },
+    {
+      "profile_name": "Custom settings 1",
+      "profile_identifier": "com.my.profile",
+      "team_id": null,
+      "team_name": null
+    }
+  ],
+  "profiles_truncated": false
+}`
+}
+
+// ActivityTeam is a team (or no team) affected by an operation.
+type ActivityTeam struct {
+	TeamID   *uint   `json:"team_id"`
+	TeamName *string `json:"team_name"`
+}
+
+type ActivityTypeCopiedMacosProfile struct {
+	ProfileName       string         `json:"profile_name"`
+	ProfileIdentifier string         `json:"profile_identifier"`
+	TeamID            *uint          `json:"team_id"`
+	TeamName          *string        `json:"team_name"`
+	TargetTeams       []ActivityTeam `json:"target_teams"`
+}
+
+func (a ActivityTypeCopiedMacosProfile) ActivityName() string {
+	return "copied_macos_profile"
+}
+
+func (a ActivityTypeCopiedMacosProfile) Documentation() (activity, details, detailsExample string) {
+	return `Generated when a user copies a macOS profile to other teams.`,
+		`This activity contains the following fields:
+- "profile_name": Name of the copied profile.
+- "profile_identifier": Identifier of the copied profile.
+- "team_id": The ID of the team of the source profile, null if it applies to devices that are not in a team.
+- "team_name": The name of the team of the source profile, null if it applies to devices that are not in a team.
+- "target_teams": The teams the profile was copied to, with their "team_id" and "team_name" (null for devices that are not in a team).`, `{
+  "profile_name": "Custom settings 1",
+  "profile_identifier": "com.my.profile",
+  "team_id": 123,
+  "team_name": "Workstations",
+  "target_teams": [
+    {
+      "team_id": 124,
+      "team_name": "Servers"
+    },
+    {
+      "team_id": null,
+      "team_name": null
+    }
+  ]
+}`
+}
+
+type ActivityTypeInstalledHostMacosProfile struct {
+	HostID            uint   `json:"host_id"`
+	HostDisplayName   string `json:"host_display_name"`
+	ProfileName       string `json:"profile_name"`
+	ProfileIdentifier string `json:"profile_identifier"`
+}
+
+func (a ActivityTypeInstalledHostMacosProfile) ActivityName() string {
+	return "installed_host_macos_profile"
+}
+
+func (a ActivityTypeInstalledHostMacosProfile) Documentation() (activity, details, detailsExample string) {
+	return `Generated when a user installs an ad-hoc macOS profile on a single host.`,
+		`This activity contains the following fields:
+- "host_id": ID of the host.
+- "host_display_name": Display name of the host.
+- "profile_name": Name of the installed profile.
+- "profile_identifier": Identifier of the installed profile.`, `{
+  "host_id": 1,
+  "host_display_name": "Anna's MacBook Pro",
+  "profile_name": "Debug logging",
+  "profile_identifier": "com.my.debug"
+}`
+}
+
+type ActivityTypeDeletedHostMacosProfile struct {
+	HostID            uint   `json:"host_id"`
+	HostDisplayName   string `json:"host_display_name"`
+	ProfileName       string `json:"profile_name"`
+	ProfileIdentifier string `json:"profile_identifier"`
+}
+
+func (a ActivityTypeDeletedHostMacosProfile) ActivityName() string {
+	return "deleted_host_macos_profile"
+}
+
+func (a ActivityTypeDeletedHostMacosProfile) Documentation() (activity, details, detailsExample string) {
+	return `Generated when a user deletes an ad-hoc macOS profile from a single host.`,
+		`This activity contains the following fields:
+- "host_id": ID of the host.
+- "host_display_name": Display name of the host.
+- "profile_name": Name of the deleted profile.
+- "profile_identifier": Identifier of the deleted profile.`, `{
+  "host_id": 1,
+  "host_display_name": "Anna's MacBook Pro",
+  "profile_name": "Debug logging",
+  "profile_identifier": "com.my.debug"
 }`
 }
 
@@ -895,6 +1489,32 @@ func (a ActivityTypeDisabledMacosDiskEncryption) Documentation() (activity, deta
 }`
 }
 
+type ActivityTypeRotatedMacosDiskEncryptionKeys struct {
+	TeamID     *uint   `json:"team_id"`
+	TeamName   *string `json:"team_name"`
+	TargetID   *uint   `json:"target_id,omitempty"`
+	TargetName *string `json:"target_name,omitempty"`
+	HostCount  int     `json:"host_count"`
+}
+
+func (a ActivityTypeRotatedMacosDiskEncryptionKeys) ActivityName() string {
+	return "rotated_macos_disk_encryption_keys"
+}
+
+func (a ActivityTypeRotatedMacosDiskEncryptionKeys) Documentation() (activity, details, detailsExample string) {
+	return `Generated when a user requests the rotation of the macOS disk encryption keys of all hosts in a team (or no team), or of the hosts that match an MDM host target.`,
+		`This activity contains the following fields:
+- "team_id": The ID of the team whose hosts rotate their key, null if it applies to devices that are not in a team or to a target.
+- "team_name": The name of the team whose hosts rotate their key, null if it applies to devices that are not in a team or to a target.
+- "target_id": The ID of the MDM host target whose hosts rotate their key, only present if it applies to a target.
+- "target_name": The name of the MDM host target whose hosts rotate their key, only present if it applies to a target.
+- "host_count": The number of hosts flagged for the rotation of their key.`, `{
+  "team_id": 123,
+  "team_name": "Workstations",
+  "host_count": 42
+}`
+}
+
 type ActivityTypeAddedBootstrapPackage struct {
 	BootstrapPackageName string  `json:"bootstrap_package_name"`
 	TeamID               *uint   `json:"team_id"`
@@ -939,6 +1559,28 @@ func (a ActivityTypeDeletedBootstrapPackage) Documentation() (activity, details,
 }`
 }
 
+type ActivityTypeMigratedMDMServerURL struct {
+	OldServerURL string `json:"old_server_url"`
+	NewServerURL string `json:"new_server_url"`
+	HostsCount   uint   `json:"hosts_count"`
+}
+
+func (a ActivityTypeMigratedMDMServerURL) ActivityName() string {
+	return "migrated_mdm_server_url"
+}
+
+func (a ActivityTypeMigratedMDMServerURL) Documentation() (activity, details, detailsExample string) {
+	return `Generated when a user changes the Fleet server URL with the MDM server URL migration flow.`,
+		`This activity contains the following fields:
+- "old_server_url": The previous server URL.
+- "new_server_url": The new server URL.
+- "hosts_count": The number of enrolled hosts the enrollment profile with the new URL is redelivered to.`, `{
+  "old_server_url": "https://fleet.example.com",
+  "new_server_url": "https://mdm.example.com",
+  "hosts_count": 1200
+}`
+}
+
 // LogRoleChangeActivities logs activities for each role change, globally and one for each change in teams.
 func LogRoleChangeActivities(ctx context.Context, ds Datastore, adminUser *User, oldGlobalRole *string, oldTeamRoles []UserTeam, user *User) error {
 	if user.GlobalRole != nil && (oldGlobalRole == nil || *oldGlobalRole != *user.GlobalRole) {
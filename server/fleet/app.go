@@ -10,6 +10,7 @@ import (
 	"reflect"
 	"regexp"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/fleetdm/fleet/v4/pkg/optjson"
@@ -119,6 +120,24 @@ type VulnerabilitySettings struct {
 type MDM struct {
 	AppleBMDefaultTeam string `json:"apple_bm_default_team"`
 
+	// AppleBMEnrichDisplayName indicates if the display name of the hosts
+	// created from the Apple Business Manager device sync is built from the
+	// device description and asset tag provided by Apple Business Manager
+	// instead of its model.
+	AppleBMEnrichDisplayName bool `json:"apple_bm_enrich_display_name"`
+
+	// AppleBMDefaultDeviceFamilies are the device families (see
+	// AppleBMDeviceFamilies) for which Fleet's MDM server is expected to be
+	// the default MDM server in Apple Business Manager. Apple Business Manager
+	// doesn't expose its default assignments, they are verified against the
+	// devices synced from Apple Business Manager instead.
+	AppleBMDefaultDeviceFamilies []string `json:"apple_bm_default_device_families"`
+
+	// BlockInvalidBootstrapPackages indicates if the bootstrap packages whose
+	// signing certificate chain was found invalid (expired or revoked) are
+	// not installed on the hosts that enroll.
+	BlockInvalidBootstrapPackages bool `json:"block_invalid_bootstrap_packages"`
+
 	// AppleBMEnabledAndConfigured is set to true if Fleet has been
 	// configured with the required Apple BM key pair or token. It can't be set
 	// manually via the PATCH /config API, it's only set automatically when
@@ -139,17 +158,83 @@ type MDM struct {
 	// the server starts.
 	EnabledAndConfigured bool `json:"enabled_and_configured"`
 
+	// WindowsEnabledAndConfigured is set to true if Fleet has been configured
+	// with the required WSTEP identity certificate to enroll Windows devices.
+	// It can't be set manually via the PATCH /config API, it's only set
+	// automatically when the server starts.
+	WindowsEnabledAndConfigured bool `json:"windows_enabled_and_configured"`
+
 	MacOSUpdates          MacOSUpdates             `json:"macos_updates"`
 	MacOSSettings         MacOSSettings            `json:"macos_settings"`
 	MacOSSetup            MacOSSetup               `json:"macos_setup"`
 	EndUserAuthentication MDMEndUserAuthentication `json:"end_user_authentication"`
 
+	// AllTeamsMacOSSettings are the macOS settings that apply to the hosts of
+	// every team and to the hosts in no team.
+	AllTeamsMacOSSettings AllTeamsMacOSSettings `json:"all_teams_macos_settings"`
+
+	// ManualEnrollmentApproval configures the approval of manual enrollments
+	// before Fleet starts managing the hosts.
+	ManualEnrollmentApproval MDMManualEnrollmentApproval `json:"manual_enrollment_approval"`
+
+	// DiskEncryptionKeyViewTTL is how long a decrypted disk encryption key can
+	// be displayed after it was read. DefaultDiskEncryptionKeyViewTTL is used
+	// if it is not set.
+	DiskEncryptionKeyViewTTL Duration `json:"disk_encryption_key_view_ttl"`
+
+	// ProfileChangeApproval configures the approval of the profile changes
+	// that affect many hosts before their commands are sent.
+	ProfileChangeApproval MDMProfileChangeApproval `json:"profile_change_approval"`
+
+	// AssetCDN configures the CDN that serves the EULAs, bootstrap packages
+	// and manual enrollment profiles stored in the MDM assets S3 bucket.
+	AssetCDN MDMAssetCDN `json:"asset_cdn"`
+
+	// PostureScore configures the MDM posture score computed daily for each
+	// team and for all hosts.
+	PostureScore MDMPostureScoreSettings `json:"posture_score"`
+
+	// Localization configures the translations of the strings Fleet renders
+	// to the end users, teams can override them.
+	Localization MDMLocalization `json:"localization"`
+
 	/////////////////////////////////////////////////////////////////
 	// WARNING: If you add to this struct make sure it's taken into
 	// account in the AppConfig Clone implementation!
 	/////////////////////////////////////////////////////////////////
 }
 
+const (
+	// DefaultDiskEncryptionKeyViewTTL is the default duration a decrypted disk
+	// encryption key can be displayed.
+	DefaultDiskEncryptionKeyViewTTL = time.Minute
+	// MaxDiskEncryptionKeyViewTTL is the maximum configurable duration a
+	// decrypted disk encryption key can be displayed.
+	MaxDiskEncryptionKeyViewTTL = 15 * time.Minute
+	// DefaultMDMProfileChangeApprovalExpiry is the default duration a profile
+	// change can wait for approval before it expires.
+	DefaultMDMProfileChangeApprovalExpiry = 7 * 24 * time.Hour
+	// DefaultMDMAssetCDNURLExpiry is the default duration the signed URLs of
+	// the MDM assets CDN are valid.
+	DefaultMDMAssetCDNURLExpiry = 15 * time.Minute
+)
+
+// AppleBMDeviceFamilies are the device families reported by Apple Business
+// Manager for the devices of the organization.
+var AppleBMDeviceFamilies = []string{"Mac", "iPhone", "iPad", "iPod", "AppleTV"}
+
+// NormalizeAppleBMDeviceFamily returns the device family as reported by Apple
+// Business Manager for the case-insensitive family, and false if it is not a
+// known device family.
+func NormalizeAppleBMDeviceFamily(family string) (string, bool) {
+	for _, f := range AppleBMDeviceFamilies {
+		if strings.EqualFold(f, strings.TrimSpace(family)) {
+			return f, true
+		}
+	}
+	return "", false
+}
+
 // versionStringRegex is used to validate that a version string is in the x.y.z
 // format only (no prerelease or build metadata).
 var versionStringRegex = regexp.MustCompile(`^\d+(\.\d+)?(\.\d+)?$`)
@@ -195,17 +280,205 @@ type MacOSSettings struct {
 	// NOTE: These are only present here for informational purposes.
 	// (The source of truth for profiles is in MySQL.)
 	CustomSettings []string `json:"custom_settings"`
+	// CustomSettingsExclusions excludes hosts from some of the CustomSettings
+	// profiles, by label or by host identifier.
+	//
+	// NOTE: These are only present here for informational purposes.
+	// (The source of truth for exclusions is in MySQL.)
+	CustomSettingsExclusions []MacOSCustomSettingsExclusion `json:"custom_settings_exclusions"`
 	// EnableDiskEncryption enables disk encryption on hosts such that the hosts'
 	// disk encryption keys will be stored in Fleet.
 	EnableDiskEncryption bool `json:"enable_disk_encryption"`
+	// AllowReservedPayloads permits uploading custom profiles with payloads of
+	// PayloadTypes reserved by Fleet (e.g. FileVault), if explicitly
+	// acknowledged in the upload request. Those profiles are reported as
+	// conflicts in the profiles summary instead of being rejected.
+	AllowReservedPayloads bool `json:"allow_reserved_payloads"`
+	// ProfileFailureGracePeriod configures the retries of profiles that failed
+	// to apply with a transient error before they are reported as failed.
+	ProfileFailureGracePeriod MacOSProfileFailureGracePeriod `json:"profile_failure_grace_period"`
+	// ProfileRetryPolicy configures the automatic retries of the profiles that
+	// were reported as failed.
+	ProfileRetryPolicy MacOSProfileRetryPolicy `json:"profile_retry_policy"`
+	// AllTeamsCustomSettingsOptOut is the list of identifiers
+	// (PayloadIdentifier) of the all teams profiles that must not be installed
+	// on the hosts of this team (or no team).
+	AllTeamsCustomSettingsOptOut []string `json:"all_teams_custom_settings_opt_out"`
 
 	// NOTE: make sure to update the ToMap/FromMap methods when adding/updating fields.
 }
 
+// MDMManualEnrollmentApproval is part of AppConfig and defines whether the
+// hosts that enroll manually in Fleet's MDM must be approved before they
+// receive any profile or command.
+type MDMManualEnrollmentApproval struct {
+	Enable bool `json:"enable"`
+}
+
+// MDMProfileChangeApproval is part of AppConfig and defines whether the
+// profile changes computed by the reconciler that affect more than
+// HostThreshold hosts must be approved before their commands are sent.
+type MDMProfileChangeApproval struct {
+	Enable bool `json:"enable"`
+	// HostThreshold is the number of hosts a change can affect without
+	// approval.
+	HostThreshold int `json:"host_threshold"`
+	// Expiry is how long a change can wait for approval, after which it
+	// expires and is requested again with the hosts it then affects.
+	// DefaultMDMProfileChangeApprovalExpiry is used if it is not set.
+	Expiry Duration `json:"expiry"`
+}
+
+// MDMAssetCDNProvider is the CDN provider of the MDM assets.
+type MDMAssetCDNProvider string
+
+const (
+	MDMAssetCDNProviderCloudFront MDMAssetCDNProvider = "cloudfront"
+	MDMAssetCDNProviderFastly     MDMAssetCDNProvider = "fastly"
+)
+
+// MDMAssetCDN is part of AppConfig and configures a CDN whose origin is the
+// MDM assets S3 bucket. When enabled, the endpoints that download the assets
+// stored in the bucket redirect to a signed URL of the CDN instead of
+// streaming the contents through the Fleet server.
+type MDMAssetCDN struct {
+	Enable   bool                `json:"enable"`
+	Provider MDMAssetCDNProvider `json:"provider"`
+	// URL is the base URL of the CDN, the path of the asset in the bucket is
+	// appended to it.
+	URL string `json:"url"`
+	// KeyID is the ID of the CloudFront public key that verifies the
+	// signatures, it is not used by Fastly.
+	KeyID string `json:"key_id"`
+	// PrivateKey is the PEM-encoded RSA private key that signs the CloudFront
+	// URLs, or the base64-encoded secret that signs the Fastly tokens.
+	PrivateKey string `json:"private_key"`
+	// URLExpiry is how long the signed URLs are valid.
+	// DefaultMDMAssetCDNURLExpiry is used if it is not set.
+	URLExpiry Duration `json:"url_expiry"`
+}
+
+// MDMPostureScoreSettings is part of AppConfig and defines how the MDM
+// posture score is computed.
+type MDMPostureScoreSettings struct {
+	// Weights are the relative weights of the components of the score.
+	// DefaultMDMPostureScoreWeights are used if no weight is set.
+	Weights MDMPostureScoreWeights `json:"weights"`
+}
+
+// MDMPostureScoreWeights are the relative weights of the components of the
+// MDM posture score. A component with a zero weight is not scored.
+type MDMPostureScoreWeights struct {
+	DiskEncryption int `json:"disk_encryption"`
+	Profiles       int `json:"profiles"`
+	Enrollment     int `json:"enrollment"`
+	OSCurrency     int `json:"os_currency"`
+}
+
+// DefaultMDMPostureScoreWeights are the weights of the components of the MDM
+// posture score when none is configured.
+var DefaultMDMPostureScoreWeights = MDMPostureScoreWeights{
+	DiskEncryption: 25,
+	Profiles:       25,
+	Enrollment:     25,
+	OSCurrency:     25,
+}
+
+// OrDefault returns the weights, or DefaultMDMPostureScoreWeights if no
+// weight is set.
+func (w MDMPostureScoreWeights) OrDefault() MDMPostureScoreWeights {
+	if w == (MDMPostureScoreWeights{}) {
+		return DefaultMDMPostureScoreWeights
+	}
+	return w
+}
+
+// MDMEndUserStringMaxLength is the maximum length of the strings rendered to
+// the end users.
+const MDMEndUserStringMaxLength = 1000
+
+// MDMLocalization configures the translations of the strings Fleet renders to
+// the end users (enrollment profiles, SSO error page and migration prompt).
+type MDMLocalization struct {
+	// DefaultLanguage is the language used when none of the languages
+	// accepted by the end user is translated. English defaults are used if it
+	// is not set.
+	DefaultLanguage string `json:"default_language"`
+	// Translations are the strings in each language, a string that isn't
+	// translated falls back to the next language.
+	Translations []MDMEndUserStrings `json:"translations"`
+}
+
+// MDMEndUserStrings are the strings Fleet renders to the end users, in a
+// language.
+type MDMEndUserStrings struct {
+	// Language is the tag of the language (e.g. "fr" or "pt-BR").
+	Language string `json:"language"`
+	// EnrollmentProfileDisplayName is the name of the enrollment profile
+	// displayed on the hosts, "<organization name> enrollment" by default.
+	EnrollmentProfileDisplayName string `json:"enrollment_profile_display_name,omitempty"`
+	// EnrollmentProfileDescription is the description of the enrollment
+	// profile displayed on the hosts.
+	EnrollmentProfileDescription string `json:"enrollment_profile_description,omitempty"`
+	// SSOErrorMessage is the message of the page displayed when the end user
+	// fails to authenticate before enrolling.
+	SSOErrorMessage string `json:"sso_error_message,omitempty"`
+	// MigrationMessage is the message displayed to the end users when they
+	// are asked to migrate to Fleet's MDM, the end_user_message of the
+	// team's macos_migration by default.
+	MigrationMessage string `json:"migration_message,omitempty"`
+}
+
+// languageTagRegexp matches the BCP 47 language tags, without validating the
+// subtags against the registry.
+var languageTagRegexp = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+func (l MDMLocalization) Validate() error {
+	if l.DefaultLanguage != "" && !languageTagRegexp.MatchString(l.DefaultLanguage) {
+		return fmt.Errorf("invalid default_language %q, must be a language tag such as \"en\" or \"pt-BR\"", l.DefaultLanguage)
+	}
+
+	seen := make(map[string]bool, len(l.Translations))
+	for _, t := range l.Translations {
+		if !languageTagRegexp.MatchString(t.Language) {
+			return fmt.Errorf("invalid language %q, must be a language tag such as \"en\" or \"pt-BR\"", t.Language)
+		}
+		lang := strings.ToLower(t.Language)
+		if seen[lang] {
+			return fmt.Errorf("duplicate translations for language %q", t.Language)
+		}
+		seen[lang] = true
+
+		for _, str := range []string{t.EnrollmentProfileDisplayName, t.EnrollmentProfileDescription, t.SSOErrorMessage, t.MigrationMessage} {
+			if len(str) > MDMEndUserStringMaxLength {
+				return fmt.Errorf("the translations for language %q must be at most %d characters", t.Language, MDMEndUserStringMaxLength)
+			}
+		}
+	}
+	return nil
+}
+
+// AllTeamsMacOSSettings contains the macOS settings that apply to all teams.
+type AllTeamsMacOSSettings struct {
+	// CustomSettings is a slice of configuration profile file paths. The
+	// profiles are installed on the hosts of every team (and no team), unless
+	// the team opted out of the profile or has its own profile with the same
+	// identifier.
+	//
+	// NOTE: These are only present here for informational purposes.
+	// (The source of truth for profiles is in MySQL.)
+	CustomSettings []string `json:"custom_settings"`
+}
+
 func (s MacOSSettings) ToMap() map[string]interface{} {
 	return map[string]interface{}{
-		"custom_settings":        s.CustomSettings,
-		"enable_disk_encryption": s.EnableDiskEncryption,
+		"custom_settings":                   s.CustomSettings,
+		"custom_settings_exclusions":        s.CustomSettingsExclusions,
+		"enable_disk_encryption":            s.EnableDiskEncryption,
+		"allow_reserved_payloads":           s.AllowReservedPayloads,
+		"profile_failure_grace_period":      s.ProfileFailureGracePeriod,
+		"profile_retry_policy":              s.ProfileRetryPolicy,
+		"all_teams_custom_settings_opt_out": s.AllTeamsCustomSettingsOptOut,
 	}
 }
 
@@ -239,6 +512,22 @@ func (s *MacOSSettings) FromMap(m map[string]interface{}) (map[string]bool, erro
 		}
 	}
 
+	if v, ok := m["custom_settings_exclusions"]; ok {
+		set["custom_settings_exclusions"] = true
+		// the exclusions are a list of objects, decode them as JSON.
+		var excls []MacOSCustomSettingsExclusion
+		if v != nil {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(b, &excls); err != nil {
+				return nil, fmt.Errorf("macos_settings.custom_settings_exclusions: %w", err)
+			}
+		}
+		s.CustomSettingsExclusions = excls
+	}
+
 	if v, ok := m["enable_disk_encryption"]; ok {
 		set["enable_disk_encryption"] = true
 		b, ok := v.(bool)
@@ -253,13 +542,359 @@ func (s *MacOSSettings) FromMap(m map[string]interface{}) (map[string]bool, erro
 		s.EnableDiskEncryption = b
 	}
 
+	if v, ok := m["allow_reserved_payloads"]; ok {
+		set["allow_reserved_payloads"] = true
+		b, ok := v.(bool)
+		if !ok {
+			// error, must be a bool
+			return nil, &json.UnmarshalTypeError{
+				Value: fmt.Sprintf("%T", v),
+				Type:  reflect.TypeOf(s.AllowReservedPayloads),
+				Field: "macos_settings.allow_reserved_payloads",
+			}
+		}
+		s.AllowReservedPayloads = b
+	}
+
+	if v, ok := m["profile_failure_grace_period"]; ok {
+		set["profile_failure_grace_period"] = true
+		// the grace period is a nested object, decode it as JSON so that the
+		// duration is parsed the same way as in the app config.
+		var grace MacOSProfileFailureGracePeriod
+		if v != nil {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(b, &grace); err != nil {
+				return nil, fmt.Errorf("macos_settings.profile_failure_grace_period: %w", err)
+			}
+		}
+		s.ProfileFailureGracePeriod = grace
+	}
+
+	if v, ok := m["profile_retry_policy"]; ok {
+		set["profile_retry_policy"] = true
+		// the retry policy is a nested object, decode it as JSON so that the
+		// durations are parsed the same way as in the app config.
+		var policy MacOSProfileRetryPolicy
+		if v != nil {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(b, &policy); err != nil {
+				return nil, fmt.Errorf("macos_settings.profile_retry_policy: %w", err)
+			}
+		}
+		s.ProfileRetryPolicy = policy
+	}
+
+	if v, ok := m["all_teams_custom_settings_opt_out"]; ok {
+		set["all_teams_custom_settings_opt_out"] = true
+
+		vals, ok := v.([]interface{})
+		if v == nil || ok {
+			strs := make([]string, 0, len(vals))
+			for _, v := range vals {
+				str, ok := v.(string)
+				if !ok {
+					// error, must be a []string
+					return nil, &json.UnmarshalTypeError{
+						Value: fmt.Sprintf("%T", v),
+						Type:  reflect.TypeOf(s.AllTeamsCustomSettingsOptOut),
+						Field: "macos_settings.all_teams_custom_settings_opt_out",
+					}
+				}
+				strs = append(strs, str)
+			}
+			s.AllTeamsCustomSettingsOptOut = strs
+		}
+	}
+
 	return set, nil
 }
 
+// ValidateCustomSettingsExclusions returns an error if an exclusion does not
+// reference one of the custom settings or does not exclude any host.
+func (s MacOSSettings) ValidateCustomSettingsExclusions() error {
+	paths := make(map[string]bool, len(s.CustomSettings))
+	for _, p := range s.CustomSettings {
+		paths[p] = true
+	}
+	for _, e := range s.CustomSettingsExclusions {
+		if !paths[e.Profile] {
+			return fmt.Errorf("excluded profile %q is not in custom_settings", e.Profile)
+		}
+		if len(e.Labels) == 0 && len(e.Hosts) == 0 && len(e.IdPGroups) == 0 {
+			return fmt.Errorf("exclusion of profile %q must specify labels, hosts or idp_groups", e.Profile)
+		}
+	}
+	return nil
+}
+
+// MacOSCustomSettingsExclusion excludes the hosts that are members of any of
+// the Labels, that match any of the Hosts (hostname, UUID or serial number) or
+// whose assigned user is a member of any of the IdPGroups (SCIM groups) from
+// the configuration profile at the Profile file path, which must be one of the
+// custom settings.
+type MacOSCustomSettingsExclusion struct {
+	Profile   string   `json:"profile"`
+	Labels    []string `json:"labels,omitempty"`
+	Hosts     []string `json:"hosts,omitempty"`
+	IdPGroups []string `json:"idp_groups,omitempty"`
+}
+
+// MacOSProfileFailureGracePeriod configures the retries of the profiles that
+// failed to apply to a host with an error considered transient (e.g. the
+// device was asleep and the command timed out). A profile that fails with one
+// of the retryable error codes is queued to be applied again instead of being
+// marked as failed, until it failed at least MinConsecutiveFailures times in a
+// row and the first of those failures is older than MinFailureWindow.
+type MacOSProfileFailureGracePeriod struct {
+	// RetryableErrorCodes are the Apple MDM error codes that are retried. An
+	// error is retryable if any of the codes in its error chain is in this
+	// list.
+	RetryableErrorCodes []int `json:"retryable_error_codes"`
+	// MinConsecutiveFailures is the number of consecutive retryable failures
+	// after which the profile is reported as failed.
+	MinConsecutiveFailures int `json:"min_consecutive_failures"`
+	// MinFailureWindow is the minimum time since the first of the consecutive
+	// retryable failures after which the profile is reported as failed.
+	MinFailureWindow Duration `json:"min_failure_window"`
+}
+
+// IsRetryable returns true if any of the provided error codes is retryable.
+func (g MacOSProfileFailureGracePeriod) IsRetryable(errorCodes []int) bool {
+	for _, code := range errorCodes {
+		for _, retryable := range g.RetryableErrorCodes {
+			if code == retryable {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Validate returns an error if the grace period settings are invalid.
+func (g MacOSProfileFailureGracePeriod) Validate() error {
+	if g.MinConsecutiveFailures < 0 {
+		return errors.New("min_consecutive_failures must not be negative")
+	}
+	if g.MinFailureWindow.Duration < 0 {
+		return errors.New("min_failure_window must not be negative")
+	}
+	return nil
+}
+
+// ShouldFail returns true if a profile that failed with a retryable error
+// consecutiveFailures times in a row, the first time at firstFailedAt, must be
+// reported as failed.
+func (g MacOSProfileFailureGracePeriod) ShouldFail(consecutiveFailures int, firstFailedAt, now time.Time) bool {
+	return consecutiveFailures >= g.MinConsecutiveFailures && now.Sub(firstFailedAt) >= g.MinFailureWindow.Duration
+}
+
+const (
+	// DefaultMacOSProfileRetryInitialBackoff is the delay before the first
+	// retry of a failed profile when none is configured.
+	DefaultMacOSProfileRetryInitialBackoff = 5 * time.Minute
+	// DefaultMacOSProfileRetryMaxBackoff is the maximum delay between the
+	// retries of a failed profile when none is configured.
+	DefaultMacOSProfileRetryMaxBackoff = 24 * time.Hour
+)
+
+// MacOSProfileRetryPolicy configures the automatic retries of the profiles
+// that were reported as failed on a host. A failed profile is queued to be
+// installed again after a delay that starts at InitialBackoff and doubles
+// with each retry, up to MaxBackoff, until it was retried MaxRetries times.
+type MacOSProfileRetryPolicy struct {
+	// MaxRetries is the maximum number of retries of a failed profile, the
+	// failed profiles are not retried if it is zero.
+	MaxRetries int `json:"max_retries"`
+	// InitialBackoff is the delay before the first retry.
+	// DefaultMacOSProfileRetryInitialBackoff is used if it is not set.
+	InitialBackoff Duration `json:"initial_backoff"`
+	// MaxBackoff is the maximum delay between two retries.
+	// DefaultMacOSProfileRetryMaxBackoff is used if it is not set.
+	MaxBackoff Duration `json:"max_backoff"`
+}
+
+// Enabled returns true if the failed profiles are retried.
+func (p MacOSProfileRetryPolicy) Enabled() bool {
+	return p.MaxRetries > 0
+}
+
+// Backoffs returns the initial and maximum delays between the retries, with
+// their defaults if they are not set.
+func (p MacOSProfileRetryPolicy) Backoffs() (initial, max time.Duration) {
+	return p.InitialBackoff.ValueOr(DefaultMacOSProfileRetryInitialBackoff), p.MaxBackoff.ValueOr(DefaultMacOSProfileRetryMaxBackoff)
+}
+
+// Validate returns an error if the retry policy settings are invalid.
+func (p MacOSProfileRetryPolicy) Validate() error {
+	if p.MaxRetries < 0 {
+		return errors.New("max_retries must not be negative")
+	}
+	if p.InitialBackoff.Duration < 0 {
+		return errors.New("initial_backoff must not be negative")
+	}
+	if p.MaxBackoff.Duration < 0 {
+		return errors.New("max_backoff must not be negative")
+	}
+	if initial, max := p.Backoffs(); initial > max {
+		return errors.New("initial_backoff must not be greater than max_backoff")
+	}
+	return nil
+}
+
 // MacOSSetup contains settings related to the setup of DEP enrolled devices.
 type MacOSSetup struct {
 	BootstrapPackage    optjson.String `json:"bootstrap_package"`
 	MacOSSetupAssistant optjson.String `json:"macos_setup_assistant"`
+	// EULA is the path or URL of the PDF end user license agreement that must
+	// be accepted during enrollment. It is only supported globally, not per
+	// team.
+	EULA optjson.String `json:"eula"`
+}
+
+// MacOSMigrationMode defines how the end users are asked to migrate their
+// macOS hosts from another MDM solution to Fleet.
+type MacOSMigrationMode string
+
+const (
+	// MacOSMigrationModeVoluntary lets the end users start the migration when
+	// they want.
+	MacOSMigrationModeVoluntary MacOSMigrationMode = "voluntary"
+	// MacOSMigrationModeForced prompts the end users to migrate until they do.
+	MacOSMigrationModeForced MacOSMigrationMode = "forced"
+)
+
+// MacOSMigrationEndUserMessageMaxLength is the maximum length of the message
+// displayed to the end users during the migration.
+const MacOSMigrationEndUserMessageMaxLength = 1000
+
+// MacOSMigration contains settings related to the migration of the macOS
+// hosts of a team from another MDM solution to Fleet.
+type MacOSMigration struct {
+	// Enable turns on the migration of the team's hosts.
+	Enable bool `json:"enable"`
+	// Mode is the migration mode, required if Enable is true.
+	Mode MacOSMigrationMode `json:"mode"`
+	// WebhookURL is the URL that receives the migration events of the team's
+	// hosts, e.g. to unassign them from the previous MDM solution.
+	WebhookURL string `json:"webhook_url"`
+	// EndUserMessage is the message displayed to the end users when they are
+	// asked to migrate.
+	EndUserMessage string `json:"end_user_message"`
+}
+
+func (m MacOSMigration) Validate() error {
+	switch m.Mode {
+	case "":
+		if m.Enable {
+			return errors.New(`mode is required when the migration is enabled, must be "voluntary" or "forced"`)
+		}
+	case MacOSMigrationModeVoluntary, MacOSMigrationModeForced:
+	default:
+		return fmt.Errorf(`invalid mode %q, must be "voluntary" or "forced"`, m.Mode)
+	}
+
+	if m.WebhookURL != "" {
+		u, err := url.Parse(m.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook_url %q, must be an http or https URL", m.WebhookURL)
+		}
+	}
+
+	if len(m.EndUserMessage) > MacOSMigrationEndUserMessageMaxLength {
+		return fmt.Errorf("end_user_message must be at most %d characters", MacOSMigrationEndUserMessageMaxLength)
+	}
+	return nil
+}
+
+// MacOSEnrollmentEligibilityAction defines what happens to the macOS hosts
+// whose hardware doesn't meet the enrollment eligibility rules of their team.
+type MacOSEnrollmentEligibilityAction string
+
+const (
+	// MacOSEnrollmentEligibilityActionBlock rejects the enrollment of the
+	// ineligible hosts: they aren't assigned the automatic enrollment profile
+	// and their manual enrollment fails.
+	MacOSEnrollmentEligibilityActionBlock MacOSEnrollmentEligibilityAction = "block"
+	// MacOSEnrollmentEligibilityActionFlag lets the ineligible hosts enroll,
+	// but holds their enrollment for approval so they receive no profiles.
+	MacOSEnrollmentEligibilityActionFlag MacOSEnrollmentEligibilityAction = "flag"
+)
+
+// MacOSEnrollmentEligibilityMinimumModelYear is the smallest minimum model
+// year that can be configured, the year of the first Intel-based Macs.
+const MacOSEnrollmentEligibilityMinimumModelYear = 2006
+
+// Architectures of the macOS hosts' chips that can be required to enroll.
+const (
+	MacOSArchitectureARM64 = "arm64"
+	MacOSArchitectureX8664 = "x86_64"
+)
+
+// MacOSEnrollmentEligibility contains the hardware rules the macOS hosts of a
+// team must meet to enroll in Fleet's MDM.
+type MacOSEnrollmentEligibility struct {
+	// MinimumModelYear is the oldest model year allowed to enroll, 0 if any
+	// model year is allowed.
+	MinimumModelYear int `json:"minimum_model_year"`
+	// RequiredArchitecture is the chip architecture required to enroll, empty
+	// if any architecture is allowed.
+	RequiredArchitecture string `json:"required_architecture"`
+	// Action is what happens to the hosts that don't meet the rules, defaults
+	// to "block".
+	Action MacOSEnrollmentEligibilityAction `json:"action"`
+}
+
+func (e MacOSEnrollmentEligibility) Validate() error {
+	if e.MinimumModelYear != 0 {
+		if e.MinimumModelYear < MacOSEnrollmentEligibilityMinimumModelYear || e.MinimumModelYear > time.Now().Year() {
+			return fmt.Errorf("invalid minimum_model_year %d, must be between %d and the current year",
+				e.MinimumModelYear, MacOSEnrollmentEligibilityMinimumModelYear)
+		}
+	}
+	switch e.RequiredArchitecture {
+	case "", MacOSArchitectureARM64, MacOSArchitectureX8664:
+	default:
+		return fmt.Errorf(`invalid required_architecture %q, must be "arm64" or "x86_64"`, e.RequiredArchitecture)
+	}
+	switch e.Action {
+	case "", MacOSEnrollmentEligibilityActionBlock, MacOSEnrollmentEligibilityActionFlag:
+	default:
+		return fmt.Errorf(`invalid action %q, must be "block" or "flag"`, e.Action)
+	}
+	return nil
+}
+
+// IsSet returns true if at least one eligibility rule is configured.
+func (e MacOSEnrollmentEligibility) IsSet() bool {
+	return e.MinimumModelYear != 0 || e.RequiredArchitecture != ""
+}
+
+// EffectiveAction returns the action that applies to ineligible hosts.
+func (e MacOSEnrollmentEligibility) EffectiveAction() MacOSEnrollmentEligibilityAction {
+	if e.Action == "" {
+		return MacOSEnrollmentEligibilityActionBlock
+	}
+	return e.Action
+}
+
+// IneligibilityReason returns the reason why hardware of the provided model
+// year and architecture doesn't meet the rules, or an empty string if it
+// does. A zero model year or an empty architecture means that it is unknown,
+// and the corresponding rule is not checked.
+func (e MacOSEnrollmentEligibility) IneligibilityReason(modelYear int, architecture string) string {
+	if e.MinimumModelYear != 0 && modelYear != 0 && modelYear < e.MinimumModelYear {
+		return fmt.Sprintf("model year %d is older than the minimum model year %d", modelYear, e.MinimumModelYear)
+	}
+	if e.RequiredArchitecture != "" && architecture != "" && architecture != e.RequiredArchitecture {
+		return fmt.Sprintf("architecture %s is not the required architecture %s", architecture, e.RequiredArchitecture)
+	}
+	return ""
 }
 
 // MDMEndUserAuthentication contains settings related to end user authentication
@@ -269,6 +904,59 @@ type MDMEndUserAuthentication struct {
 	// SSOProviderSettings are top-level keys under this struct, that's why
 	// it's embedded.
 	SSOProviderSettings
+
+	// AttributeMapping configures the SAML attributes used to fill the end
+	// user's account information recorded for the enrolling host.
+	AttributeMapping MDMSSOAttributeMapping `json:"attribute_mapping"`
+	// TeamRules assign the enrolling host to a team based on the end user's
+	// IdP group membership.
+	TeamRules MDMSSOTeamRules `json:"team_rules"`
+}
+
+// MDMSSOAttributeMapping contains the names of the SAML assertion attributes
+// that hold the end user's information. When an attribute name is empty, the
+// username defaults to the subject's name identifier and the full name to the
+// display name found in the assertion.
+type MDMSSOAttributeMapping struct {
+	Username string `json:"username"`
+	FullName string `json:"full_name"`
+	Groups   string `json:"groups"`
+}
+
+// MDMSSOTeamRule assigns hosts enrolled by members of the IdP group to the
+// team.
+type MDMSSOTeamRule struct {
+	Group string `json:"group"`
+	Team  string `json:"team"`
+}
+
+// MDMSSOTeamRules is an ordered list of team assignment rules.
+type MDMSSOTeamRules []MDMSSOTeamRule
+
+// Equal returns true if both lists contain the same rules in the same order.
+func (rules MDMSSOTeamRules) Equal(other MDMSSOTeamRules) bool {
+	if len(rules) != len(other) {
+		return false
+	}
+	for i := range rules {
+		if rules[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TeamForGroups returns the name of the team of the first rule that matches
+// one of the groups, or an empty string if no rule matches.
+func (rules MDMSSOTeamRules) TeamForGroups(groups []string) string {
+	for _, rule := range rules {
+		for _, g := range groups {
+			if rule.Group == g {
+				return rule.Team
+			}
+		}
+	}
+	return ""
 }
 
 // AppConfig holds server configuration that can be changed via the API.
@@ -322,6 +1010,12 @@ func (c *AppConfig) Obfuscate() {
 	for _, zdIntegration := range c.Integrations.Zendesk {
 		zdIntegration.APIToken = MaskedPassword
 	}
+	if c.WebhookSettings.MDMEventsWebhook.Secret != "" {
+		c.WebhookSettings.MDMEventsWebhook.Secret = MaskedPassword
+	}
+	if c.MDM.AssetCDN.PrivateKey != "" {
+		c.MDM.AssetCDN.PrivateKey = MaskedPassword
+	}
 }
 
 // legacyConfig holds settings that have been replaced, superceded or
@@ -389,10 +1083,34 @@ func (c *AppConfig) Copy() *AppConfig {
 		}
 	}
 
+	if c.MDM.AppleBMDefaultDeviceFamilies != nil {
+		clone.MDM.AppleBMDefaultDeviceFamilies = make([]string, len(c.MDM.AppleBMDefaultDeviceFamilies))
+		copy(clone.MDM.AppleBMDefaultDeviceFamilies, c.MDM.AppleBMDefaultDeviceFamilies)
+	}
 	if c.MDM.MacOSSettings.CustomSettings != nil {
 		clone.MDM.MacOSSettings.CustomSettings = make([]string, len(c.MDM.MacOSSettings.CustomSettings))
 		copy(clone.MDM.MacOSSettings.CustomSettings, c.MDM.MacOSSettings.CustomSettings)
 	}
+	if c.MDM.MacOSSettings.CustomSettingsExclusions != nil {
+		clone.MDM.MacOSSettings.CustomSettingsExclusions = make([]MacOSCustomSettingsExclusion, len(c.MDM.MacOSSettings.CustomSettingsExclusions))
+		copy(clone.MDM.MacOSSettings.CustomSettingsExclusions, c.MDM.MacOSSettings.CustomSettingsExclusions)
+	}
+	if c.MDM.MacOSSettings.AllTeamsCustomSettingsOptOut != nil {
+		clone.MDM.MacOSSettings.AllTeamsCustomSettingsOptOut = make([]string, len(c.MDM.MacOSSettings.AllTeamsCustomSettingsOptOut))
+		copy(clone.MDM.MacOSSettings.AllTeamsCustomSettingsOptOut, c.MDM.MacOSSettings.AllTeamsCustomSettingsOptOut)
+	}
+	if c.MDM.AllTeamsMacOSSettings.CustomSettings != nil {
+		clone.MDM.AllTeamsMacOSSettings.CustomSettings = make([]string, len(c.MDM.AllTeamsMacOSSettings.CustomSettings))
+		copy(clone.MDM.AllTeamsMacOSSettings.CustomSettings, c.MDM.AllTeamsMacOSSettings.CustomSettings)
+	}
+	if c.MDM.EndUserAuthentication.TeamRules != nil {
+		clone.MDM.EndUserAuthentication.TeamRules = make(MDMSSOTeamRules, len(c.MDM.EndUserAuthentication.TeamRules))
+		copy(clone.MDM.EndUserAuthentication.TeamRules, c.MDM.EndUserAuthentication.TeamRules)
+	}
+	if c.MDM.Localization.Translations != nil {
+		clone.MDM.Localization.Translations = make([]MDMEndUserStrings, len(c.MDM.Localization.Translations))
+		copy(clone.MDM.Localization.Translations, c.MDM.Localization.Translations)
+	}
 
 	return &clone
 }
@@ -471,6 +1189,7 @@ type WebhookSettings struct {
 	HostStatusWebhook      HostStatusWebhookSettings      `json:"host_status_webhook"`
 	FailingPoliciesWebhook FailingPoliciesWebhookSettings `json:"failing_policies_webhook"`
 	VulnerabilitiesWebhook VulnerabilitiesWebhookSettings `json:"vulnerabilities_webhook"`
+	MDMEventsWebhook       MDMEventsWebhookSettings       `json:"mdm_events_webhook"`
 	// Interval is the interval for running the webhooks.
 	//
 	// This value currently configures both the host status and failing policies webhooks.
@@ -508,6 +1227,19 @@ type VulnerabilitiesWebhookSettings struct {
 	HostBatchSize int `json:"host_batch_size"`
 }
 
+// MDMEventsWebhookSettings holds the settings for the MDM events webhook, which
+// delivers MDM events (enrollments, command results, key escrows) as they
+// happen.
+type MDMEventsWebhookSettings struct {
+	// Enable indicates whether the webhook for MDM events is enabled.
+	Enable bool `json:"enable_mdm_events_webhook"`
+	// DestinationURL is the webhook's URL.
+	DestinationURL string `json:"destination_url"`
+	// Secret is the shared secret used to sign the payload of each delivery
+	// with HMAC-SHA256. No signature is sent if it is empty.
+	Secret string `json:"secret"`
+}
+
 func (c *AppConfig) ApplyDefaultsForNewInstalls() {
 	c.ServerSettings.EnableAnalytics = true
 
@@ -594,6 +1326,36 @@ type ServerSettings struct {
 type HostExpirySettings struct {
 	HostExpiryEnabled bool `json:"host_expiry_enabled"`
 	HostExpiryWindow  int  `json:"host_expiry_window"`
+	// HostExpiryMDMEnrolled defines how the hosts enrolled in an MDM solution
+	// are expired, see the HostExpiryMDMEnrolled constants. Defaults to
+	// HostExpiryMDMEnrolledExpire.
+	HostExpiryMDMEnrolled HostExpiryMDMEnrolled `json:"host_expiry_mdm_enrolled"`
+}
+
+// HostExpiryMDMEnrolled defines how the hosts enrolled in an MDM solution are
+// expired.
+type HostExpiryMDMEnrolled string
+
+const (
+	// HostExpiryMDMEnrolledExpire expires the MDM-enrolled hosts like any other
+	// host, based on the last time they communicated with Fleet via osquery.
+	HostExpiryMDMEnrolledExpire HostExpiryMDMEnrolled = "expire"
+	// HostExpiryMDMEnrolledExempt never expires the MDM-enrolled hosts.
+	HostExpiryMDMEnrolledExempt HostExpiryMDMEnrolled = "exempt"
+	// HostExpiryMDMEnrolledCheckIn expires the hosts enrolled in Fleet's MDM
+	// based on the last time they communicated with Fleet either via osquery or
+	// via an MDM check-in.
+	HostExpiryMDMEnrolledCheckIn HostExpiryMDMEnrolled = "mdm_check_in"
+)
+
+// Validate adds an error to invalid if the value is not supported.
+func (m HostExpiryMDMEnrolled) Validate(invalid *InvalidArgumentError) {
+	switch m {
+	case "", HostExpiryMDMEnrolledExpire, HostExpiryMDMEnrolledExempt, HostExpiryMDMEnrolledCheckIn:
+	default:
+		invalid.Append("host_expiry_mdm_enrolled", fmt.Sprintf("unsupported value %q, must be one of %q, %q or %q",
+			m, HostExpiryMDMEnrolledExpire, HostExpiryMDMEnrolledExempt, HostExpiryMDMEnrolledCheckIn))
+	}
 }
 
 type Features struct {
@@ -689,6 +1451,10 @@ type ApplySpecOptions struct {
 	DryRun bool
 	// TeamForPolicies is the name of the team to set in policy specs.
 	TeamForPolicies string
+	// DeleteUnmanaged indicates that the MDM assets (configuration profiles
+	// and macOS setup assistants) of the teams targeted by the specs that are
+	// not in the specs should be deleted. It is only used by the client.
+	DeleteUnmanaged bool
 }
 
 // RawQuery returns the ApplySpecOptions url-encoded for use in an URL's
@@ -738,6 +1504,18 @@ func (e *EnrollSecret) IsGlobalSecret() bool {
 	return e.TeamID == nil
 }
 
+// FleetdProfileAuthz is used to check user authorization to download the
+// fleetd configuration profile of a team (or no team if TeamID is nil), which
+// embeds an enroll secret of the team.
+type FleetdProfileAuthz struct {
+	TeamID *uint `json:"team_id"`
+}
+
+// AuthzType implements authz.AuthzTyper.
+func (f FleetdProfileAuthz) AuthzType() string {
+	return "fleetd_profile"
+}
+
 const (
 	EnrollSecretKind          = "enroll_secret"
 	EnrollSecretDefaultLength = 24
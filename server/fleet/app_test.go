@@ -1,7 +1,9 @@
 package fleet
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -114,7 +116,215 @@ func TestMacOSUpdatesValidate(t *testing.T) {
 	})
 }
 
+func TestMacOSMigrationValidate(t *testing.T) {
+	cases := []struct {
+		desc    string
+		m       MacOSMigration
+		wantErr string
+	}{
+		{"empty", MacOSMigration{}, ""},
+		{"disabled with mode", MacOSMigration{Mode: MacOSMigrationModeForced}, ""},
+		{"enabled voluntary", MacOSMigration{Enable: true, Mode: MacOSMigrationModeVoluntary, WebhookURL: "https://example.com/hook"}, ""},
+		{"enabled without mode", MacOSMigration{Enable: true}, "mode is required"},
+		{"invalid mode", MacOSMigration{Mode: "now"}, `invalid mode "now"`},
+		{"webhook without scheme", MacOSMigration{Mode: MacOSMigrationModeForced, WebhookURL: "example.com"}, "invalid webhook_url"},
+		{"webhook with other scheme", MacOSMigration{Mode: MacOSMigrationModeForced, WebhookURL: "ftp://example.com"}, "invalid webhook_url"},
+		{"message too long", MacOSMigration{EndUserMessage: strings.Repeat("a", MacOSMigrationEndUserMessageMaxLength+1)}, "end_user_message must be at most"},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			err := c.m.Validate()
+			if c.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestMacOSEnrollmentEligibility(t *testing.T) {
+	cases := []struct {
+		desc    string
+		e       MacOSEnrollmentEligibility
+		wantErr string
+	}{
+		{"empty", MacOSEnrollmentEligibility{}, ""},
+		{"valid", MacOSEnrollmentEligibility{MinimumModelYear: 2018, RequiredArchitecture: "arm64", Action: "flag"}, ""},
+		{"year too old", MacOSEnrollmentEligibility{MinimumModelYear: 1999}, "invalid minimum_model_year 1999"},
+		{"year in the future", MacOSEnrollmentEligibility{MinimumModelYear: time.Now().Year() + 1}, "invalid minimum_model_year"},
+		{"invalid architecture", MacOSEnrollmentEligibility{RequiredArchitecture: "ppc"}, `invalid required_architecture "ppc"`},
+		{"invalid action", MacOSEnrollmentEligibility{Action: "wipe"}, `invalid action "wipe"`},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			err := c.e.Validate()
+			if c.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, c.wantErr)
+			}
+		})
+	}
+
+	rules := MacOSEnrollmentEligibility{MinimumModelYear: 2018, RequiredArchitecture: MacOSArchitectureARM64}
+	require.True(t, rules.IsSet())
+	require.Equal(t, MacOSEnrollmentEligibilityActionBlock, rules.EffectiveAction())
+	require.Empty(t, rules.IneligibilityReason(2020, MacOSArchitectureARM64))
+	require.Contains(t, rules.IneligibilityReason(2017, MacOSArchitectureARM64), "model year 2017 is older")
+	require.Contains(t, rules.IneligibilityReason(2019, MacOSArchitectureX8664), "architecture x86_64")
+	// unknown hardware information isn't checked
+	require.Empty(t, rules.IneligibilityReason(0, ""))
+	require.False(t, MacOSEnrollmentEligibility{Action: MacOSEnrollmentEligibilityActionFlag}.IsSet())
+}
+
+func TestHostExpiryMDMEnrolledValidate(t *testing.T) {
+	for _, m := range []HostExpiryMDMEnrolled{"", HostExpiryMDMEnrolledExpire, HostExpiryMDMEnrolledExempt, HostExpiryMDMEnrolledCheckIn} {
+		invalid := &InvalidArgumentError{}
+		m.Validate(invalid)
+		require.False(t, invalid.HasErrors(), m)
+	}
+
+	invalid := &InvalidArgumentError{}
+	HostExpiryMDMEnrolled("never").Validate(invalid)
+	require.True(t, invalid.HasErrors())
+	require.Contains(t, invalid.Error(), `unsupported value "never"`)
+}
+
+func TestMacOSProfileFailureGracePeriod(t *testing.T) {
+	var zero MacOSProfileFailureGracePeriod
+	require.NoError(t, zero.Validate())
+	require.False(t, zero.IsRetryable([]int{12021}))
+	require.True(t, zero.ShouldFail(1, time.Now(), time.Now()))
+
+	g := MacOSProfileFailureGracePeriod{RetryableErrorCodes: []int{12021, 12078}, MinConsecutiveFailures: 3, MinFailureWindow: Duration{Duration: time.Hour}}
+	require.NoError(t, g.Validate())
+	require.True(t, g.IsRetryable([]int{4, 12078}))
+	require.False(t, g.IsRetryable([]int{4}))
+	require.False(t, g.IsRetryable(nil))
+
+	now := time.Now()
+	require.False(t, g.ShouldFail(2, now.Add(-2*time.Hour), now))
+	require.False(t, g.ShouldFail(3, now.Add(-time.Minute), now))
+	require.True(t, g.ShouldFail(3, now.Add(-time.Hour), now))
+
+	require.ErrorContains(t, MacOSProfileFailureGracePeriod{MinConsecutiveFailures: -1}.Validate(), "min_consecutive_failures")
+	require.ErrorContains(t, MacOSProfileFailureGracePeriod{MinFailureWindow: Duration{Duration: -time.Second}}.Validate(), "min_failure_window")
+
+	var settings MacOSSettings
+	set, err := settings.FromMap(map[string]interface{}{
+		"profile_failure_grace_period": map[string]interface{}{
+			"retryable_error_codes":    []interface{}{12021.0},
+			"min_consecutive_failures": 2.0,
+			"min_failure_window":       "30m",
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, set["profile_failure_grace_period"])
+	require.Equal(t, MacOSProfileFailureGracePeriod{RetryableErrorCodes: []int{12021}, MinConsecutiveFailures: 2, MinFailureWindow: Duration{Duration: 30 * time.Minute}},
+		settings.ProfileFailureGracePeriod)
+
+	_, err = settings.FromMap(map[string]interface{}{"profile_failure_grace_period": map[string]interface{}{"min_failure_window": "soon"}})
+	require.ErrorContains(t, err, "macos_settings.profile_failure_grace_period")
+
+	set, err = settings.FromMap(map[string]interface{}{"profile_failure_grace_period": nil})
+	require.NoError(t, err)
+	require.True(t, set["profile_failure_grace_period"])
+	require.Equal(t, MacOSProfileFailureGracePeriod{}, settings.ProfileFailureGracePeriod)
+}
+
+func TestMacOSProfileRetryPolicy(t *testing.T) {
+	var zero MacOSProfileRetryPolicy
+	require.NoError(t, zero.Validate())
+	require.False(t, zero.Enabled())
+	initial, max := zero.Backoffs()
+	require.Equal(t, DefaultMacOSProfileRetryInitialBackoff, initial)
+	require.Equal(t, DefaultMacOSProfileRetryMaxBackoff, max)
+
+	p := MacOSProfileRetryPolicy{MaxRetries: 3, InitialBackoff: Duration{Duration: time.Minute}, MaxBackoff: Duration{Duration: time.Hour}}
+	require.NoError(t, p.Validate())
+	require.True(t, p.Enabled())
+	initial, max = p.Backoffs()
+	require.Equal(t, time.Minute, initial)
+	require.Equal(t, time.Hour, max)
+
+	require.ErrorContains(t, MacOSProfileRetryPolicy{MaxRetries: -1}.Validate(), "max_retries")
+	require.ErrorContains(t, MacOSProfileRetryPolicy{InitialBackoff: Duration{Duration: -time.Second}}.Validate(), "initial_backoff")
+	require.ErrorContains(t, MacOSProfileRetryPolicy{MaxBackoff: Duration{Duration: -time.Second}}.Validate(), "max_backoff")
+	require.ErrorContains(t, MacOSProfileRetryPolicy{InitialBackoff: Duration{Duration: 48 * time.Hour}}.Validate(), "must not be greater than max_backoff")
+
+	var settings MacOSSettings
+	set, err := settings.FromMap(map[string]interface{}{
+		"profile_retry_policy": map[string]interface{}{
+			"max_retries":     5.0,
+			"initial_backoff": "10m",
+			"max_backoff":     "6h",
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, set["profile_retry_policy"])
+	require.Equal(t, MacOSProfileRetryPolicy{MaxRetries: 5, InitialBackoff: Duration{Duration: 10 * time.Minute}, MaxBackoff: Duration{Duration: 6 * time.Hour}},
+		settings.ProfileRetryPolicy)
+
+	_, err = settings.FromMap(map[string]interface{}{"profile_retry_policy": map[string]interface{}{"max_backoff": "later"}})
+	require.ErrorContains(t, err, "macos_settings.profile_retry_policy")
+
+	set, err = settings.FromMap(map[string]interface{}{"profile_retry_policy": nil})
+	require.NoError(t, err)
+	require.True(t, set["profile_retry_policy"])
+	require.Equal(t, MacOSProfileRetryPolicy{}, settings.ProfileRetryPolicy)
+}
+
+func TestMacOSSettingsCustomSettingsExclusions(t *testing.T) {
+	settings := MacOSSettings{CustomSettings: []string{"a.mobileconfig", "b.mobileconfig"}}
+	set, err := settings.FromMap(map[string]interface{}{
+		"custom_settings_exclusions": []interface{}{
+			map[string]interface{}{"profile": "a.mobileconfig", "labels": []interface{}{"Kiosks"}},
+			map[string]interface{}{"profile": "b.mobileconfig", "hosts": []interface{}{"ABC123"}},
+			map[string]interface{}{"profile": "b.mobileconfig", "idp_groups": []interface{}{"Contractors"}},
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, set["custom_settings_exclusions"])
+	require.Equal(t, []MacOSCustomSettingsExclusion{
+		{Profile: "a.mobileconfig", Labels: []string{"Kiosks"}},
+		{Profile: "b.mobileconfig", Hosts: []string{"ABC123"}},
+		{Profile: "b.mobileconfig", IdPGroups: []string{"Contractors"}},
+	}, settings.CustomSettingsExclusions)
+	require.NoError(t, settings.ValidateCustomSettingsExclusions())
+
+	_, err = settings.FromMap(map[string]interface{}{"custom_settings_exclusions": "a.mobileconfig"})
+	require.ErrorContains(t, err, "macos_settings.custom_settings_exclusions")
+
+	settings.CustomSettingsExclusions = []MacOSCustomSettingsExclusion{{Profile: "c.mobileconfig", Labels: []string{"Kiosks"}}}
+	require.ErrorContains(t, settings.ValidateCustomSettingsExclusions(), `excluded profile "c.mobileconfig" is not in custom_settings`)
+	settings.CustomSettingsExclusions = []MacOSCustomSettingsExclusion{{Profile: "a.mobileconfig"}}
+	require.ErrorContains(t, settings.ValidateCustomSettingsExclusions(), "must specify labels, hosts or idp_groups")
+
+	set, err = settings.FromMap(map[string]interface{}{"custom_settings_exclusions": nil})
+	require.NoError(t, err)
+	require.True(t, set["custom_settings_exclusions"])
+	require.Nil(t, settings.CustomSettingsExclusions)
+}
+
 func TestSSOSettingsIsEmpty(t *testing.T) {
 	require.True(t, (SSOProviderSettings{}).IsEmpty())
 	require.False(t, (SSOProviderSettings{EntityID: "fleet"}).IsEmpty())
 }
+
+func TestMDMSSOTeamRules(t *testing.T) {
+	rules := MDMSSOTeamRules{
+		{Group: "engineering", Team: "Engineering"},
+		{Group: "everyone", Team: "Workstations"},
+	}
+	require.Equal(t, "", rules.TeamForGroups(nil))
+	require.Equal(t, "", rules.TeamForGroups([]string{"sales"}))
+	require.Equal(t, "Workstations", rules.TeamForGroups([]string{"everyone"}))
+	// the first matching rule wins, regardless of the order of the groups
+	require.Equal(t, "Engineering", rules.TeamForGroups([]string{"everyone", "engineering"}))
+
+	require.True(t, rules.Equal(MDMSSOTeamRules{{Group: "engineering", Team: "Engineering"}, {Group: "everyone", Team: "Workstations"}}))
+	require.False(t, rules.Equal(MDMSSOTeamRules{{Group: "everyone", Team: "Workstations"}, {Group: "engineering", Team: "Engineering"}}))
+	require.False(t, rules.Equal(nil))
+	require.True(t, MDMSSOTeamRules(nil).Equal(MDMSSOTeamRules{}))
+}
@@ -2,9 +2,14 @@ package fleet
 
 import (
 	"context"
+	"crypto/md5" //nolint:gosec // used only to detect changes of the profiles
+	"crypto/sha256"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"math"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,12 +17,45 @@ import (
 	"github.com/micromdm/nanodep/godep"
 )
 
+// MDMProfileChecksumAlgorithm is the hash algorithm of the checksums used to
+// detect changes of the configuration profiles.
+type MDMProfileChecksumAlgorithm string
+
+const (
+	// MDMProfileChecksumMD5 is the default algorithm, it is also used if the
+	// algorithm is empty.
+	MDMProfileChecksumMD5 MDMProfileChecksumAlgorithm = "md5"
+	// MDMProfileChecksumSHA256 is the FIPS-approved algorithm. The hash is
+	// truncated to the 16 bytes of the checksum columns, as allowed by NIST
+	// SP 800-107.
+	MDMProfileChecksumSHA256 MDMProfileChecksumAlgorithm = "sha256"
+)
+
+// Sum returns the checksum of the profile. It is computed on the canonical
+// form of the profile, so that cosmetic changes (whitespace, key order) don't
+// change the checksum and trigger a reinstall on the hosts. The profiles that
+// can't be parsed are hashed as-is.
+func (a MDMProfileChecksumAlgorithm) Sum(mc []byte) []byte {
+	if canonical, err := mobileconfig.Mobileconfig(mc).Canonical(); err == nil {
+		mc = canonical
+	}
+	if a == MDMProfileChecksumSHA256 {
+		sum := sha256.Sum256(mc)
+		return sum[:md5.Size]
+	}
+	sum := md5.Sum(mc) //nolint:gosec
+	return sum[:]
+}
+
 type MDMAppleCommandIssuer interface {
 	InstallProfile(ctx context.Context, hostUUIDs []string, profile mobileconfig.Mobileconfig, uuid string) error
 	RemoveProfile(ctx context.Context, hostUUIDs []string, identifier string, uuid string) error
-	DeviceLock(ctx context.Context, hostUUIDs []string, uuid string) error
-	EraseDevice(ctx context.Context, hostUUIDs []string, uuid string) error
+	DeviceLock(ctx context.Context, hostUUIDs []string, uuid string) (string, error)
+	EraseDevice(ctx context.Context, hostUUIDs []string, uuid string) (string, error)
 	InstallEnterpriseApplication(ctx context.Context, hostUUIDs []string, uuid string, manifestURL string) error
+	ActivationLockBypassCode(ctx context.Context, hostUUIDs []string, uuid string) error
+	EnqueueCommand(ctx context.Context, hostUUIDs []string, rawCommand string) error
+	EnqueueCommandWithPriority(ctx context.Context, hostUUIDs []string, rawCommand string, priority MDMAppleCommandPriority) error
 }
 
 // MDMAppleEnrollmentType is the type for Apple MDM enrollments.
@@ -39,6 +77,10 @@ const (
 	MDMAppleStatusNotNow             = "NotNow"
 )
 
+// MDMAppleRedactedValue replaces the secrets removed from the MDM commands and
+// their results stored in the database.
+const MDMAppleRedactedValue = "REDACTED"
+
 // MDMAppleDeliveryStatus is the status of an MDM command to apply a profile
 // to a device (whether it is installing or removing).
 type MDMAppleDeliveryStatus string
@@ -48,16 +90,13 @@ type MDMAppleDeliveryStatus string
 // as follows:
 //
 //   - failed: the MDM command failed to apply, and it won't retry. This is
-//     currently a terminal state. TODO(mna): currently we only retry if the
-//     command failed to enqueue in ReconcileProfile (it resets the status to
-//     NULL). A failure in the asynchronous actual response of the MDM command
-//     (via MDMAppleCheckinAndCommandService.CommandAndReportResults) results in
-//     the failed state being applied and no retry. We should probably support
-//     some retries for such failures, and determine a maximum number of retries
-//     before giving up (either as a count of attempts - which would require
-//     storing somewhere - or as a time period, which we could determine based on
-//     the timestamps, e.g. time since created_at, if we added them to
-//     host_mdm_apple_profiles).
+//     currently a terminal state. We retry if the command failed to enqueue in
+//     ReconcileProfile (it resets the status to NULL), and if the asynchronous
+//     response of the MDM command (via
+//     MDMAppleCheckinAndCommandService.CommandAndReportResults) failed with an
+//     error code that is retryable according to the team's
+//     MacOSProfileFailureGracePeriod, until that grace period is over. Any
+//     other failure results in the failed state being applied and no retry.
 //
 //   - verifying: the MDM command was successfully applied, but Fleet has not
 //     independently verified the status. This is an intermediate state,
@@ -84,6 +123,11 @@ var (
 	MDMAppleDeliveryFailed    MDMAppleDeliveryStatus = "failed"
 	MDMAppleDeliveryVerifying MDMAppleDeliveryStatus = "verifying"
 	MDMAppleDeliveryPending   MDMAppleDeliveryStatus = "pending"
+
+	// MDMAppleDeliveryNotApplicable is reported for the profiles of the
+	// host's team that are not installed because the host's macOS version is
+	// outside of the profile's version range. It is never stored.
+	MDMAppleDeliveryNotApplicable MDMAppleDeliveryStatus = "not_applicable"
 )
 
 func MDMAppleDeliveryStatusFromCommandStatus(cmdStatus string) *MDMAppleDeliveryStatus {
@@ -120,6 +164,81 @@ type MDMAppleEnrollmentProfilePayload struct {
 	Token string `json:"-"`
 }
 
+// MDMAppleEnrollmentLink is a short-lived link that serves the enrollment
+// profile of a team, e.g. to enroll iOS and iPadOS devices over-the-air by
+// scanning a QR code. A link can be used to enroll up to MaxUses hosts before
+// it expires.
+type MDMAppleEnrollmentLink struct {
+	ID uint `json:"id" db:"id"`
+	// Token is the random secret that authenticates the link.
+	Token string `json:"-" db:"token"`
+	// TeamID is the team the enrolled hosts are assigned to, nil for no team.
+	TeamID    *uint     `json:"team_id" db:"team_id"`
+	MaxUses   uint      `json:"max_uses" db:"max_uses"`
+	UsesCount uint      `json:"uses_count" db:"uses_count"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// URL is the enrollment URL served by the link, it is only returned when
+	// the link is created.
+	URL string `json:"url,omitempty" db:"-"`
+	// QRCodePayload is the payload of the QR code to scan to open the URL on
+	// a device, it is only returned when the link is created.
+	QRCodePayload string `json:"qr_code_payload,omitempty" db:"-"`
+	// Uses are the hosts that enrolled with the link.
+	Uses []MDMAppleEnrollmentLinkUse `json:"uses" db:"-"`
+}
+
+// MDMAppleEnrollmentLinkUse records a host that enrolled with an enrollment
+// link.
+type MDMAppleEnrollmentLinkUse struct {
+	HostUUID string `json:"host_uuid" db:"host_uuid"`
+	// HostID is nil if the host was deleted after it enrolled.
+	HostID    *uint     `json:"host_id" db:"host_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// MDMAppleTeamEnrollmentToken is the token embedded in the reusable
+// enrollment profile of a team, e.g. to bake the profile into golden images.
+// Hosts that enroll with the profile are assigned to the team, until the token
+// is rotated.
+type MDMAppleTeamEnrollmentToken struct {
+	// TeamID is the team of the token, nil for no team.
+	TeamID    *uint     `json:"team_id" db:"team_id"`
+	Token     string    `json:"-" db:"token"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// MDMAppleSSOEnrollmentToken is the short-lived, single-use token returned to
+// an end user after authenticating with the IdP during automatic enrollment,
+// which authorizes the download of the enrollment profile. It is bound to the
+// MDM IdP account created for the SSO session.
+type MDMAppleSSOEnrollmentToken struct {
+	ID             uint      `json:"id" db:"id"`
+	Token          string    `json:"-" db:"token"`
+	IdPAccountUUID string    `json:"idp_account_uuid" db:"idp_account_uuid"`
+	IdPUsername    string    `json:"idp_username" db:"idp_username"`
+	IdPFullName    string    `json:"idp_full_name" db:"idp_full_name"`
+	ExpiresAt      time.Time `json:"expires_at" db:"expires_at"`
+	// ConsumedAt is the time the enrollment profile was downloaded with the
+	// token, nil if it wasn't used.
+	ConsumedAt *time.Time `json:"consumed_at" db:"consumed_at"`
+	RevokedAt  *time.Time `json:"revoked_at" db:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// MDMApplePushResult is the result of a push notification sent to an MDM
+// push token.
+type MDMApplePushResult struct {
+	// TokenHex is the hex-encoded push token.
+	TokenHex string
+	// Err is the error of the push, empty if it succeeded.
+	Err string
+	// TokenInvalid is true if APNs reported that the token is not valid
+	// anymore, e.g. because the device was wiped.
+	TokenInvalid bool
+}
+
 // MDMAppleEnrollmentProfile represents an Apple MDM enrollment profile in Fleet.
 // Such enrollment profiles are used to enroll Apple devices to Fleet.
 type MDMAppleEnrollmentProfile struct {
@@ -172,6 +291,9 @@ type MDMAppleCommandResult struct {
 	// Hostname is not filled by the query, it is filled in the service layer
 	// afterwards. To make that explicit, the db field tag is explicitly ignored.
 	Hostname string `json:"hostname" db:"-"`
+	// TraceID is the trace ID of the MDM request in which the device reported
+	// the result, it can be used to find the related entries in the logs.
+	TraceID string `json:"trace_id,omitempty" db:"trace_id"`
 }
 
 // MDMAppleInstaller holds installer packages for Apple devices.
@@ -226,6 +348,27 @@ func (m MDMAppleDEPDevice) AuthzType() string {
 	return "mdm_apple_dep_device"
 }
 
+// MDMAppleDEPTeamAssignment is the team that a device is assigned to when it
+// is first synced from Apple Business Manager, instead of the default team
+// (AppConfig.MDM.AppleBMDefaultTeam).
+type MDMAppleDEPTeamAssignment struct {
+	SerialNumber string `json:"serial_number" db:"serial_number"`
+	// TeamID is the ID of the team of the device. When setting the
+	// assignments, a nil TeamID removes the assignment of the device.
+	TeamID    *uint     `json:"team_id" db:"team_id"`
+	TeamName  string    `json:"team_name" db:"team_name"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// MDMAppleDEPTeamAssignmentsResult is the result of the upload of DEP team
+// assignments.
+type MDMAppleDEPTeamAssignmentsResult struct {
+	// Assigned is the number of serial numbers assigned to a team.
+	Assigned int `json:"assigned"`
+	// Removed is the number of serial numbers whose assignment was removed.
+	Removed int `json:"removed"`
+}
+
 // These following types are copied from nanomdm.
 
 // EnrolledAPIResult is a per-enrollment API result.
@@ -246,12 +389,91 @@ type CommandEnqueueResult struct {
 	RequestType string `json:"request_type,omitempty"`
 	// FailedUUIDs is the list of host UUIDs that failed to receive the command.
 	FailedUUIDs []string `json:"failed_uuids,omitempty"`
+	// Hosts is the per-host result of the command, it is only set when the
+	// hosts are resolved by the server (i.e. the command targets teams or a
+	// label).
+	Hosts []*CommandEnqueueHostResult `json:"hosts,omitempty"`
+}
+
+// CommandEnqueueHostStatus is the status of a command enqueued for a host.
+type CommandEnqueueHostStatus string
+
+const (
+	// CommandEnqueueHostStatusEnqueued is the status of a host for which the
+	// command was enqueued and the push notification was sent.
+	CommandEnqueueHostStatusEnqueued CommandEnqueueHostStatus = "enqueued"
+	// CommandEnqueueHostStatusPushFailed is the status of a host for which the
+	// command was enqueued but the push notification failed, the host will
+	// get the command on its next check-in.
+	CommandEnqueueHostStatusPushFailed CommandEnqueueHostStatus = "push_failed"
+)
+
+// CommandEnqueueHostResult is the result of a command enqueued for a host.
+type CommandEnqueueHostResult struct {
+	HostID   uint                     `json:"host_id"`
+	HostUUID string                   `json:"host_uuid"`
+	Status   CommandEnqueueHostStatus `json:"status"`
+}
+
+// MDMAppleHostsPushResult is the result of sending push notifications to wake
+// up the macOS hosts.
+type MDMAppleHostsPushResult struct {
+	// HostsCount is the number of macOS hosts that matched the filters, the
+	// hosts not enrolled in Fleet's MDM are not sent a push notification.
+	HostsCount int `json:"hosts_count"`
+	// PushesSent is the number of push notifications accepted by APNs.
+	PushesSent int `json:"pushes_sent"`
+	// FailedUUIDs is the list of host UUIDs whose push notification failed.
+	FailedUUIDs []string `json:"failed_uuids,omitempty"`
+}
+
+// MDMAppleCommandPriority is the priority of an MDM command in the queue of
+// the hosts. Hosts receive their queued commands by decreasing priority, and
+// in the order they were enqueued for the same priority.
+type MDMAppleCommandPriority string
+
+// List of MDM command priorities.
+const (
+	// MDMAppleCommandPriorityUrgent is used for security-critical commands
+	// (e.g. lock, wipe), they are delivered before any other queued command.
+	MDMAppleCommandPriorityUrgent MDMAppleCommandPriority = "urgent"
+	// MDMAppleCommandPriorityNormal is the default priority.
+	MDMAppleCommandPriorityNormal MDMAppleCommandPriority = "normal"
+	// MDMAppleCommandPriorityLow is used for commands that can wait for the
+	// rest of the queue to be delivered.
+	MDMAppleCommandPriorityLow MDMAppleCommandPriority = "low"
+)
+
+// IsValid returns true if p is a known priority. The empty priority is valid
+// and means normal.
+func (p MDMAppleCommandPriority) IsValid() bool {
+	switch p {
+	case "", MDMAppleCommandPriorityUrgent, MDMAppleCommandPriorityNormal, MDMAppleCommandPriorityLow:
+		return true
+	}
+	return false
+}
+
+// QueuePriority returns the value of the priority stored with the queued
+// command, higher values are delivered first.
+func (p MDMAppleCommandPriority) QueuePriority() int {
+	switch p {
+	case MDMAppleCommandPriorityUrgent:
+		return 10
+	case MDMAppleCommandPriorityLow:
+		return -10
+	default:
+		return 0
+	}
 }
 
 // MDMAppleCommandAuthz is used to check user authorization to read/write an
 // Apple MDM command.
 type MDMAppleCommandAuthz struct {
 	TeamID *uint `json:"team_id"` // required for authorization by team
+	// GitOpsAllowed is true if the request type of the command is allowed for
+	// users with the GitOps role.
+	GitOpsAllowed bool `json:"gitops_allowed"`
 }
 
 // AuthzType implements authz.AuthzTyper.
@@ -259,6 +481,243 @@ func (m MDMAppleCommandAuthz) AuthzType() string {
 	return "mdm_apple_command"
 }
 
+// MDMAppleActivationLockBypassCodeAuthz is used to check user authorization
+// to read the Activation Lock bypass code of a host.
+type MDMAppleActivationLockBypassCodeAuthz struct {
+	TeamID *uint `json:"team_id"` // required for authorization by team
+}
+
+// AuthzType implements authz.AuthzTyper.
+func (m MDMAppleActivationLockBypassCodeAuthz) AuthzType() string {
+	return "mdm_apple_activation_lock_bypass_code"
+}
+
+// MDMAppleHostDataAuthz is used to check user authorization to purge the MDM
+// data of a host.
+type MDMAppleHostDataAuthz struct {
+	TeamID *uint `json:"team_id"` // required for authorization by team
+}
+
+// AuthzType implements authz.AuthzTyper.
+func (m MDMAppleHostDataAuthz) AuthzType() string {
+	return "mdm_apple_host_data"
+}
+
+// HostMDMActivationLockBypassCode is the Activation Lock bypass code escrowed
+// for a supervised host, it allows to activate the host after it is wiped
+// even if Activation Lock is enabled.
+type HostMDMActivationLockBypassCode struct {
+	HostUUID string `json:"-" db:"host_uuid"`
+	// BypassCode is the decrypted bypass code, it is only stored in plain text
+	// for the codes escrowed before the codes were encrypted at rest.
+	BypassCode string `json:"bypass_code" db:"bypass_code"`
+	// Base64Encrypted is the bypass code encrypted with the SCEP CA.
+	Base64Encrypted string    `json:"-" db:"base64_encrypted"`
+	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// HostMDMAppleLockPIN is the PIN of the latest DeviceLock or EraseDevice
+// command sent to a host. It unlocks the host after it is locked, or after it
+// is wiped if Find My locks it.
+type HostMDMAppleLockPIN struct {
+	HostUUID    string    `json:"-" db:"host_uuid"`
+	CommandUUID string    `json:"command_uuid" db:"command_uuid"`
+	RequestType string    `json:"request_type" db:"request_type"`
+	PIN         string    `json:"pin" db:"pin"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// HostMDMCertificateOrigin indicates whether a certificate installed on a host
+// was installed by an MDM payload.
+type HostMDMCertificateOrigin string
+
+// List of valid values for HostMDMCertificateOrigin.
+const (
+	// HostMDMCertificateOriginMDM is the origin of the certificates installed
+	// by an MDM payload (e.g. a Wi-Fi or VPN configuration profile).
+	HostMDMCertificateOriginMDM HostMDMCertificateOrigin = "mdm"
+	// HostMDMCertificateOriginOther is the origin of the certificates installed
+	// by other means (e.g. by the user or the system).
+	HostMDMCertificateOriginOther HostMDMCertificateOrigin = "other"
+)
+
+// Prefixes of the command UUIDs of the CertificateList commands sent to the
+// hosts, used to tell apart the results of the list of all certificates from
+// those of the list of certificates installed by MDM payloads.
+const (
+	MDMAppleCertificateListAllCommandPrefix     = "CERTLIST-ALL-"
+	MDMAppleCertificateListManagedCommandPrefix = "CERTLIST-MANAGED-"
+)
+
+// HostMDMCertificate is the metadata of a certificate installed on a host, as
+// reported by the CertificateList MDM command.
+type HostMDMCertificate struct {
+	ID       uint   `json:"id" db:"id"`
+	HostUUID string `json:"-" db:"host_uuid"`
+	// SHA1Sum is the hex-encoded SHA-1 fingerprint of the certificate.
+	SHA1Sum        string                   `json:"sha1_sum" db:"sha1_sum"`
+	CommonName     string                   `json:"common_name" db:"common_name"`
+	Issuer         string                   `json:"issuer" db:"issuer"`
+	SerialNumber   string                   `json:"serial_number" db:"serial_number"`
+	NotValidBefore *time.Time               `json:"not_valid_before" db:"not_valid_before"`
+	NotValidAfter  *time.Time               `json:"not_valid_after" db:"not_valid_after"`
+	IsIdentity     bool                     `json:"is_identity" db:"is_identity"`
+	Origin         HostMDMCertificateOrigin `json:"origin" db:"origin"`
+	UpdatedAt      time.Time                `json:"updated_at" db:"updated_at"`
+}
+
+// MDMAppleProfileListCommandPrefix is the prefix of the command UUIDs of the
+// ProfileList commands sent to the hosts to verify the installed profiles.
+const MDMAppleProfileListCommandPrefix = "PROFLIST-"
+
+// HostMDMAppleProfileInstall is a profile that Fleet installs on a host, used
+// to compare the PayloadUUID reported installed by the host with the
+// PayloadUUID of the profile's current version.
+type HostMDMAppleProfileInstall struct {
+	ProfileID         uint                      `db:"profile_id"`
+	ProfileIdentifier string                    `db:"profile_identifier"`
+	Status            *MDMAppleDeliveryStatus   `db:"status"`
+	Mobileconfig      mobileconfig.Mobileconfig `db:"mobileconfig"`
+	// InstalledPayloadUUID is the PayloadUUID of the profile last reported
+	// installed by the host, nil if it was never reported.
+	InstalledPayloadUUID *string `db:"installed_payload_uuid"`
+}
+
+// MDMAppleSCEPCertificate is the record of a certificate issued by Fleet's
+// SCEP server, e.g. the identity certificate of a host enrolled in Fleet's MDM.
+type MDMAppleSCEPCertificate struct {
+	Serial     int64  `json:"serial" db:"serial"`
+	CommonName string `json:"common_name" db:"name"`
+	// SHA256Sum is the hex-encoded SHA-256 fingerprint of the certificate. It
+	// is nil for certificates issued before it was recorded.
+	SHA256Sum      *string   `json:"sha256_sum" db:"sha256"`
+	NotValidBefore time.Time `json:"not_valid_before" db:"not_valid_before"`
+	NotValidAfter  time.Time `json:"not_valid_after" db:"not_valid_after"`
+	Revoked        bool      `json:"revoked" db:"revoked"`
+	// HostUUID is the UUID of the host that uses the certificate, it is nil
+	// until the host authenticates with it. HostID and Hostname are nil if that
+	// host does not exist in Fleet.
+	HostUUID *string `json:"host_uuid" db:"host_uuid"`
+	HostID   *uint   `json:"host_id" db:"host_id"`
+	Hostname *string `json:"hostname" db:"hostname"`
+	// RenewedFromSerial is the serial of the certificate of the host that this
+	// certificate renews, and RenewedBySerial the serial of the certificate that
+	// renews it.
+	RenewedFromSerial *int64    `json:"renewed_from_serial" db:"renewed_from_serial"`
+	RenewedBySerial   *int64    `json:"renewed_by_serial" db:"renewed_by_serial"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+}
+
+// MDMAppleSCEPCertificateListOptions defines the options to filter the list of
+// certificates issued by Fleet's SCEP server.
+type MDMAppleSCEPCertificateListOptions struct {
+	ListOptions
+
+	// HostID filters the certificates used by that host.
+	HostID *uint
+	// ExpiresWithinDays filters the certificates that are not expired yet and
+	// expire in that number of days.
+	ExpiresWithinDays *uint
+	// ExcludeRenewed filters out the certificates that were renewed.
+	ExcludeRenewed bool
+}
+
+// MDMAppleSCEPIdentityCertificate is the identity certificate currently used
+// by a host enrolled in Fleet's MDM.
+type MDMAppleSCEPIdentityCertificate struct {
+	Serial         int64  `db:"serial"`
+	HostUUID       string `db:"host_uuid"`
+	CertificatePEM []byte `db:"certificate_pem"`
+}
+
+// MDMAppleSCEPCA describes a CA certificate of Fleet's SCEP server.
+type MDMAppleSCEPCA struct {
+	Subject string `json:"subject"`
+	// SHA256Sum is the hex-encoded SHA-256 fingerprint of the certificate.
+	SHA256Sum     string    `json:"sha256_sum"`
+	NotValidAfter time.Time `json:"not_valid_after"`
+}
+
+// MDMConfigAssetName is the name of an MDM certificate, key or token stored
+// in the database.
+type MDMConfigAssetName string
+
+// List of the MDM assets that can be stored in the database.
+const (
+	MDMConfigAssetAPNsCert MDMConfigAssetName = "apns_cert"
+	MDMConfigAssetAPNsKey  MDMConfigAssetName = "apns_key"
+	MDMConfigAssetSCEPCert MDMConfigAssetName = "scep_cert"
+	MDMConfigAssetSCEPKey  MDMConfigAssetName = "scep_key"
+	MDMConfigAssetABMCert  MDMConfigAssetName = "abm_cert"
+	MDMConfigAssetABMKey   MDMConfigAssetName = "abm_key"
+	MDMConfigAssetABMToken MDMConfigAssetName = "abm_token"
+)
+
+// MDMConfigAsset is an MDM certificate, key or token stored in the database.
+// The value is only encrypted at rest, it is always returned decrypted.
+type MDMConfigAsset struct {
+	Name  MDMConfigAssetName
+	Value []byte
+}
+
+// MDMAppleSetupPayload contains the PEM-encoded assets required to enable
+// Apple MDM. The Apple Business Manager assets are optional, but must be
+// provided together.
+type MDMAppleSetupPayload struct {
+	APNsCert string `json:"apns_cert"`
+	APNsKey  string `json:"apns_key"`
+	SCEPCert string `json:"scep_cert"`
+	SCEPKey  string `json:"scep_key"`
+	ABMCert  string `json:"abm_cert"`
+	ABMKey   string `json:"abm_key"`
+	ABMToken string `json:"abm_token"`
+}
+
+// MDMAppleSCEPCARotation is the status of the rotation of the CA of Fleet's
+// SCEP server. A rotation is in progress while the previous CA is configured
+// alongside the current one, the hosts that still use an identity issued by
+// the previous CA move to the current one when they renew it or re-enroll.
+type MDMAppleSCEPCARotation struct {
+	InProgress bool            `json:"in_progress"`
+	CurrentCA  MDMAppleSCEPCA  `json:"current_ca"`
+	PreviousCA *MDMAppleSCEPCA `json:"previous_ca"`
+	// HostsOnCurrentCA and HostsOnPreviousCA are the number of enrolled hosts
+	// whose identity certificate was issued by the current and previous CA,
+	// and HostsOnUnknownCA the number of those issued by neither of them.
+	HostsOnCurrentCA  uint `json:"hosts_on_current_ca"`
+	HostsOnPreviousCA uint `json:"hosts_on_previous_ca"`
+	HostsOnUnknownCA  uint `json:"hosts_on_unknown_ca"`
+}
+
+// MDMAppleNanoEnrollment is an enrollment stored in the MDM server's storage
+// (the nano tables), along with the Fleet host it is linked to, if any.
+type MDMAppleNanoEnrollment struct {
+	// ID is the enrollment id, the device UDID for device enrollments.
+	ID       string  `json:"id" db:"id"`
+	DeviceID string  `json:"device_id" db:"device_id"`
+	UserID   *string `json:"user_id" db:"user_id"`
+	Type     string  `json:"type" db:"type"`
+	Enabled  bool    `json:"enabled" db:"enabled"`
+	// SerialNumber is the serial number reported by the device, it is nil if
+	// the device never reported it.
+	SerialNumber *string   `json:"serial_number" db:"serial_number"`
+	LastSeenAt   time.Time `json:"last_seen_at" db:"last_seen_at"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	// HostID is the id of the Fleet host linked to the enrollment's device, it
+	// is nil for orphaned enrollments, i.e. enrollments without a matching
+	// Fleet host.
+	HostID *uint `json:"host_id" db:"host_id"`
+}
+
+// MDMAppleNanoEnrollmentListOptions defines the options to filter the list of
+// enrollments stored in the MDM server's storage.
+type MDMAppleNanoEnrollmentListOptions struct {
+	ListOptions
+
+	// OrphanedOnly filters the enrollments without a matching Fleet host.
+	OrphanedOnly bool
+}
+
 // MDMAppleHostDetails represents the device identifiers used to ingest an MDM device as a Fleet
 // host pending enrollment.
 // See also https://developer.apple.com/documentation/devicemanagement/authenticaterequest.
@@ -268,16 +727,139 @@ type MDMAppleHostDetails struct {
 	Model        string
 }
 
-type MDMAppleCommandTimeoutError struct{}
+// MDMAppleUnenrollStatus is the state of a host after a request to turn off
+// MDM for it.
+type MDMAppleUnenrollStatus string
 
-func (e MDMAppleCommandTimeoutError) Error() string {
-	return "Timeout waiting for MDM device to acknowledge command"
+const (
+	// MDMAppleUnenrollStatusUnenrolled means that the host checked out from
+	// Fleet's MDM while the request was being served.
+	MDMAppleUnenrollStatusUnenrolled MDMAppleUnenrollStatus = "unenrolled"
+	// MDMAppleUnenrollStatusPending means that the command to remove the
+	// enrollment profile is queued and the host will be unenrolled the next time
+	// it checks in.
+	MDMAppleUnenrollStatusPending MDMAppleUnenrollStatus = "pending"
+)
+
+// MDMAppleUnenrollResult is the result of a request to turn off MDM for a
+// host.
+type MDMAppleUnenrollResult struct {
+	HostID      uint                   `json:"host_id"`
+	CommandUUID string                 `json:"command_uuid"`
+	Status      MDMAppleUnenrollStatus `json:"status"`
+	// PushFailed is true if the push notification asking the host to check in
+	// could not be delivered. The command is still queued.
+	PushFailed bool `json:"push_failed"`
 }
 
-func (e MDMAppleCommandTimeoutError) StatusCode() int {
-	return http.StatusGatewayTimeout
+// MDMAppleEnrollmentApprovalStatus is the approval status of a manual
+// enrollment in Fleet's MDM.
+type MDMAppleEnrollmentApprovalStatus string
+
+const (
+	// MDMAppleEnrollmentApprovalPending means that the host enrolled manually
+	// and waits to be approved, it receives no profile and no command.
+	MDMAppleEnrollmentApprovalPending MDMAppleEnrollmentApprovalStatus = "pending"
+	// MDMAppleEnrollmentApprovalApproved means that the enrollment of the host
+	// was approved and the host is managed as usual.
+	MDMAppleEnrollmentApprovalApproved MDMAppleEnrollmentApprovalStatus = "approved"
+)
+
+// MDMApplePendingEnrollment is a host that enrolled manually in Fleet's MDM
+// and waits for its enrollment to be approved.
+type MDMApplePendingEnrollment struct {
+	HostID          uint      `json:"host_id" db:"host_id"`
+	HostUUID        string    `json:"host_uuid" db:"host_uuid"`
+	HardwareSerial  string    `json:"hardware_serial" db:"hardware_serial"`
+	HostDisplayName string    `json:"host_display_name" db:"host_display_name"`
+	TeamID          *uint     `json:"team_id" db:"team_id"`
+	EnrolledAt      time.Time `json:"enrolled_at" db:"enrolled_at"`
 }
 
+// Sources of the blocked enrollments, i.e. when the enrollment eligibility
+// of the device was checked.
+const (
+	// MDMAppleBlockedEnrollmentSourceDEP is when the device is synced from
+	// Apple Business Manager, before it is assigned the enrollment profile.
+	MDMAppleBlockedEnrollmentSourceDEP = "dep"
+	// MDMAppleBlockedEnrollmentSourceEnrollment is when the device enrolls in
+	// Fleet's MDM, be it manually or automatically.
+	MDMAppleBlockedEnrollmentSourceEnrollment = "enrollment"
+)
+
+// MDMAppleBlockedEnrollment is a macOS device whose hardware doesn't meet the
+// enrollment eligibility rules of its team.
+type MDMAppleBlockedEnrollment struct {
+	SerialNumber string `json:"serial_number" db:"serial_number"`
+	// HostUUID is empty if the device was blocked before it enrolled.
+	HostUUID      string `json:"host_uuid" db:"host_uuid"`
+	TeamID        *uint  `json:"team_id" db:"team_id"`
+	HardwareModel string `json:"hardware_model" db:"hardware_model"`
+	// Source is when the eligibility was checked, "dep" or "enrollment".
+	Source string `json:"source" db:"source"`
+	// Action is what happened to the device, "block" or "flag".
+	Action    MacOSEnrollmentEligibilityAction `json:"action" db:"action"`
+	Reason    string                           `json:"reason" db:"reason"`
+	UpdatedAt time.Time                        `json:"updated_at" db:"updated_at"`
+}
+
+// MDMAppleProfileChangeStatus is the approval status of a profile change
+// computed by the reconciler.
+type MDMAppleProfileChangeStatus string
+
+const (
+	// MDMAppleProfileChangePending means that the change waits to be
+	// approved, its commands are not sent.
+	MDMAppleProfileChangePending MDMAppleProfileChangeStatus = "pending"
+	// MDMAppleProfileChangeApproved means that the change was approved and
+	// its commands are sent as usual.
+	MDMAppleProfileChangeApproved MDMAppleProfileChangeStatus = "approved"
+	// MDMAppleProfileChangeRejected means that the change was rejected, its
+	// commands are not sent until the profile is modified.
+	MDMAppleProfileChangeRejected MDMAppleProfileChangeStatus = "rejected"
+	// MDMAppleProfileChangeExpired means that the change was not reviewed
+	// before it expired. A new change is requested if the profile still
+	// needs to be installed or removed.
+	MDMAppleProfileChangeExpired MDMAppleProfileChangeStatus = "expired"
+)
+
+// IsValid returns true if s is a known profile change status.
+func (s MDMAppleProfileChangeStatus) IsValid() bool {
+	switch s {
+	case MDMAppleProfileChangePending, MDMAppleProfileChangeApproved,
+		MDMAppleProfileChangeRejected, MDMAppleProfileChangeExpired:
+		return true
+	}
+	return false
+}
+
+// MDMAppleProfileChange is the installation or removal of a version (as
+// identified by its checksum) of a profile that affects more hosts than
+// allowed without approval (see MDMProfileChangeApproval).
+type MDMAppleProfileChange struct {
+	ID                uint                        `json:"id" db:"id"`
+	ProfileID         uint                        `json:"profile_id" db:"profile_id"`
+	ProfileIdentifier string                      `json:"profile_identifier" db:"profile_identifier"`
+	ProfileName       string                      `json:"profile_name" db:"profile_name"`
+	Checksum          []byte                      `json:"-" db:"checksum"`
+	OperationType     MDMAppleOperationType       `json:"operation_type" db:"operation_type"`
+	HostCount         int                         `json:"host_count" db:"host_count"`
+	Status            MDMAppleProfileChangeStatus `json:"status" db:"status"`
+	CreatedAt         time.Time                   `json:"created_at" db:"created_at"`
+	ExpiresAt         time.Time                   `json:"expires_at" db:"expires_at"`
+	ReviewedAt        *time.Time                  `json:"reviewed_at" db:"reviewed_at"`
+}
+
+// MDMAppleAllTeamsProfilesTeamID is the team ID under which the
+// configuration profiles that apply to all teams are stored. It is the
+// largest team ID that can be stored, so it is never the ID of an actual team.
+const MDMAppleAllTeamsProfilesTeamID uint = math.MaxUint32
+
+// MDMAppleHostProfilesTeamID is the team ID under which the ad-hoc
+// configuration profiles installed on a single host are stored, so that they
+// are never part of a team's profiles.
+const MDMAppleHostProfilesTeamID uint = math.MaxUint32 - 1
+
 // MDMAppleConfigProfile represents an Apple MDM configuration profile in Fleet.
 // Configuration profiles are used to configure Apple devices .
 // See also https://developer.apple.com/documentation/devicemanagement/configuring_multiple_devices_using_profiles.
@@ -287,6 +869,10 @@ type MDMAppleConfigProfile struct {
 	// TeamID is the id of the team with which the configuration is associated. A nil team id
 	// represents a configuration profile that is not associated with any team.
 	TeamID *uint `db:"team_id" json:"team_id"`
+	// HostID is the id of the host on which an ad-hoc profile is installed,
+	// in which case TeamID is MDMAppleHostProfilesTeamID. It is zero for the
+	// team profiles.
+	HostID uint `db:"host_id" json:"host_id,omitempty"`
 	// Identifier corresponds to the payload identifier of the associated mobileconfig payload.
 	// Fleet requires that Identifier must be unique in combination with the Name and TeamID.
 	Identifier string `db:"identifier" json:"identifier"`
@@ -296,10 +882,56 @@ type MDMAppleConfigProfile struct {
 	// Mobileconfig is the byte slice corresponding to the XML property list (i.e. plist)
 	// representation of the configuration profile. It must be XML or PKCS7 parseable.
 	Mobileconfig mobileconfig.Mobileconfig `db:"mobileconfig" json:"-"`
-	// Checksum is an MD5 hash of the Mobileconfig bytes
+	// Checksum is a hash of the Mobileconfig bytes, see
+	// MDMProfileChecksumAlgorithm.
 	Checksum  []byte    `db:"checksum" json:"-"`
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+	// ReservedPayloadTypes are the PayloadTypes reserved by Fleet that the
+	// profile contains. Those are only allowed if the team permits reserved
+	// payloads and the upload explicitly acknowledged them.
+	ReservedPayloadTypes []string `db:"-" json:"-"`
+	// TargetID is the id of the MDMHostTarget that restricts the hosts of the
+	// team (or no team) on which the profile is installed, nil if it is
+	// installed on all of them.
+	TargetID *uint `db:"target_id" json:"target_id"`
+	// MinMacOSVersion and MaxMacOSVersion restrict the profile to the hosts
+	// running a macOS version in that range, if set. Both are inclusive, the
+	// max version covers all the versions it is a prefix of (e.g. "13" covers
+	// 13.x).
+	MinMacOSVersion string `db:"min_macos_version" json:"min_macos_version"`
+	MaxMacOSVersion string `db:"max_macos_version" json:"max_macos_version"`
+}
+
+// ValidateMDMAppleProfileMacOSVersions validates the macOS version range of a
+// configuration profile, any of the versions may be empty.
+func ValidateMDMAppleProfileMacOSVersions(min, max string) error {
+	if min != "" && !versionStringRegex.MatchString(min) {
+		return NewInvalidArgumentError("min_macos_version", `accepts version numbers only. (E.g., "13.0.1.")`)
+	}
+	if max != "" && !versionStringRegex.MatchString(max) {
+		return NewInvalidArgumentError("max_macos_version", `accepts version numbers only. (E.g., "14.")`)
+	}
+	if min == "" || max == "" {
+		return nil
+	}
+
+	// compare the versions up to the precision of the max version.
+	minParts, maxParts := strings.Split(min, "."), strings.Split(max, ".")
+	for i, maxPart := range maxParts {
+		minNum := 0
+		if i < len(minParts) {
+			minNum, _ = strconv.Atoi(minParts[i])
+		}
+		maxNum, _ := strconv.Atoi(maxPart)
+		if minNum < maxNum {
+			return nil
+		}
+		if minNum > maxNum {
+			return NewInvalidArgumentError("max_macos_version", "must not be older than min_macos_version")
+		}
+	}
+	return nil
 }
 
 func NewMDMAppleConfigProfile(raw []byte, teamID *uint) (*MDMAppleConfigProfile, error) {
@@ -329,6 +961,22 @@ func (cp MDMAppleConfigProfile) ValidateUserProvided() error {
 	return cp.Mobileconfig.ScreenPayloads()
 }
 
+// ValidateUserProvidedAllowReservedTypes validates the profile like
+// ValidateUserProvided, except that payloads with a PayloadType reserved by
+// Fleet are not rejected but recorded in ReservedPayloadTypes.
+func (cp *MDMAppleConfigProfile) ValidateUserProvidedAllowReservedTypes() error {
+	if _, ok := mobileconfig.FleetPayloadIdentifiers()[cp.Identifier]; ok {
+		return fmt.Errorf("payload identifier %s is not allowed", cp.Identifier)
+	}
+
+	types, err := cp.Mobileconfig.ScreenPayloadsAllowReservedTypes()
+	if err != nil {
+		return err
+	}
+	cp.ReservedPayloadTypes = types
+	return nil
+}
+
 // HostMDMAppleProfile represents the status of an Apple MDM profile in a host.
 type HostMDMAppleProfile struct {
 	HostUUID      string                  `db:"host_uuid" json:"-"`
@@ -339,8 +987,33 @@ type HostMDMAppleProfile struct {
 	Status        *MDMAppleDeliveryStatus `db:"status" json:"status"`
 	OperationType MDMAppleOperationType   `db:"operation_type" json:"operation_type"`
 	Detail        string                  `db:"detail" json:"detail"`
+	// AdHoc is true for the profiles installed only on this host, outside of
+	// the profiles of its team.
+	AdHoc bool `db:"ad_hoc" json:"ad_hoc"`
+	// Origin indicates who manages the profile. It is empty for the profiles
+	// that were deleted and are being removed from the host.
+	Origin MDMAppleProfileOrigin `db:"origin" json:"origin"`
 }
 
+// MDMAppleProfileOrigin indicates who manages a configuration profile
+// installed on a host.
+type MDMAppleProfileOrigin string
+
+const (
+	// MDMAppleProfileOriginFleet is the origin of the profiles managed by
+	// Fleet itself, e.g. to enforce disk encryption or configure fleetd.
+	MDMAppleProfileOriginFleet MDMAppleProfileOrigin = "fleet"
+	// MDMAppleProfileOriginTeam is the origin of the custom profiles of the
+	// host's team.
+	MDMAppleProfileOriginTeam MDMAppleProfileOrigin = "team"
+	// MDMAppleProfileOriginGlobal is the origin of the custom profiles of "no
+	// team" and of the profiles that apply to all teams.
+	MDMAppleProfileOriginGlobal MDMAppleProfileOrigin = "global"
+	// MDMAppleProfileOriginAdHoc is the origin of the custom profiles
+	// installed only on the host.
+	MDMAppleProfileOriginAdHoc MDMAppleProfileOrigin = "ad_hoc"
+)
+
 func (p HostMDMAppleProfile) IgnoreMDMClientError() bool {
 	switch p.OperationType {
 	case MDMAppleOperationTypeRemove:
@@ -358,6 +1031,7 @@ type MDMAppleProfilePayload struct {
 	ProfileName       string `db:"profile_name"`
 	HostUUID          string `db:"host_uuid"`
 	Checksum          []byte `db:"checksum"`
+	CommandUUID       string `db:"command_uuid"`
 }
 
 type MDMAppleBulkUpsertHostProfilePayload struct {
@@ -369,6 +1043,8 @@ type MDMAppleBulkUpsertHostProfilePayload struct {
 	OperationType     MDMAppleOperationType
 	Status            *MDMAppleDeliveryStatus
 	Checksum          []byte
+	// Detail replaces the detail of the host profile if not nil.
+	Detail *string
 }
 
 // MDMAppleConfigProfilesSummary reports the number of hosts being managed with MDM configuration
@@ -385,6 +1061,224 @@ type MDMAppleConfigProfilesSummary struct {
 	// Failed includes each host that has failed to apply one or more of the profiles currently
 	// applicable to the host.
 	Failed uint `json:"failed" db:"failed"`
+	// ReservedPayloadConflicts lists the profiles of the team that contain
+	// payloads with a PayloadType reserved by Fleet, which may conflict with
+	// the profiles delivered by Fleet (e.g. for disk encryption).
+	ReservedPayloadConflicts []MDMAppleReservedPayloadConflict `json:"reserved_payload_conflicts" db:"-"`
+}
+
+// MDMAppleConfigProfilesTeamSummary is the summary of the state of the MDM
+// configuration profiles on the hosts of a team. TeamID is nil for the hosts
+// with no team.
+type MDMAppleConfigProfilesTeamSummary struct {
+	TeamID    *uint  `json:"team_id" db:"team_id"`
+	TeamName  string `json:"team_name" db:"team_name"`
+	Verifying uint   `json:"verifying" db:"verifying"`
+	Pending   uint   `json:"pending" db:"pending"`
+	Failed    uint   `json:"failed" db:"failed"`
+}
+
+// MDMAppleReservedPayloadConflict is a custom profile that was uploaded with
+// payloads of PayloadTypes reserved by Fleet.
+type MDMAppleReservedPayloadConflict struct {
+	ProfileID    uint     `json:"profile_id"`
+	Name         string   `json:"name"`
+	Identifier   string   `json:"identifier"`
+	PayloadTypes []string `json:"payload_types"`
+}
+
+// MDMAppleProfilesPreview reports the number of hosts that would be affected
+// by a batch change of the custom profiles of a team (or no team). Only the
+// hosts enrolled in Fleet's MDM are counted.
+type MDMAppleProfilesPreview struct {
+	TeamID *uint `json:"team_id"`
+	// EnrolledHosts is the number of hosts of the team enrolled in Fleet's MDM.
+	EnrolledHosts uint `json:"enrolled_hosts"`
+	// InstallHosts is the number of enrolled hosts that would receive at least
+	// one new or changed profile.
+	InstallHosts uint `json:"install_hosts"`
+	// RemoveHosts is the number of enrolled hosts that would have at least one
+	// profile removed.
+	RemoveHosts uint `json:"remove_hosts"`
+}
+
+// MDMAppleProfileExclusionSpec is the exclusion of some hosts from a custom
+// profile as provided in a batch change of the custom profiles of a team (or
+// no team). The hosts are excluded if they are a member of any of the labels,
+// if they match any of the host identifiers (hostname, UUID or serial number)
+// or if their assigned user is a member of any of the IdP (SCIM) groups.
+type MDMAppleProfileExclusionSpec struct {
+	ProfileIdentifier string   `json:"profile_identifier"`
+	Labels            []string `json:"labels"`
+	Hosts             []string `json:"hosts"`
+	IdPGroups         []string `json:"idp_groups"`
+}
+
+// MDMAppleTeamProfilesSpec is the set of custom profiles of a team (or no
+// team) as provided in a batch change of the custom profiles of multiple
+// teams.
+type MDMAppleTeamProfilesSpec struct {
+	Profiles   [][]byte                       `json:"profiles"`
+	Exclusions []MDMAppleProfileExclusionSpec `json:"exclusions"`
+}
+
+// MDMAppleTeamProfiles is the validated set of custom profiles and exclusions
+// of a team (or no team, if TeamID is nil) to apply in a batch change of the
+// custom profiles of multiple teams.
+type MDMAppleTeamProfiles struct {
+	TeamID     *uint
+	Profiles   []*MDMAppleConfigProfile
+	Exclusions []*MDMAppleProfileExclusion
+}
+
+// MDMAppleProfileExclusion is the exclusion of a label, a single host or the
+// hosts assigned to the members of an IdP group from a custom profile of a
+// team (or no team). Only one of LabelID, HostID and IdPGroup is set.
+type MDMAppleProfileExclusion struct {
+	// TeamID is the id of the team of the profile, 0 for no team.
+	TeamID            uint   `db:"team_id"`
+	ProfileIdentifier string `db:"profile_identifier"`
+	LabelID           *uint  `db:"label_id"`
+	HostID            *uint  `db:"host_id"`
+	// IdPGroup is the display name of the SCIM group, the group does not need
+	// to exist when the exclusion is created.
+	IdPGroup *string `db:"idp_group"`
+}
+
+// MDMHostTarget is a named set of hosts defined by filters, e.g. "all Apple
+// silicon Macs on macOS < 14 in teams A and B". The filters are evaluated each
+// time the target is used, so that an MDM operation that references it always
+// applies to the macOS hosts enrolled in Fleet's MDM that currently match all
+// of them.
+type MDMHostTarget struct {
+	ID          uint   `json:"id" db:"id"`
+	Name        string `json:"name" db:"name"`
+	Description string `json:"description" db:"description"`
+	// MDMHostTargetFilters are stored in their own columns, but rendered as a
+	// nested "filters" object.
+	MDMHostTargetFilters `json:"filters"`
+	CreatedAt            time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// AuthzType implements authz.AuthzTyper.
+func (t MDMHostTarget) AuthzType() string {
+	return "mdm_host_target"
+}
+
+// MDMHostTargetFilters are the filters of an MDMHostTarget, a host must match
+// all the filters that are set.
+type MDMHostTargetFilters struct {
+	// TeamIDs are the teams of the hosts, 0 being no team. Empty matches the
+	// hosts of any team.
+	TeamIDs MDMHostTargetTeamIDs `json:"team_ids" db:"team_ids"`
+	// Architecture is the chip architecture of the hosts, either
+	// MacOSArchitectureARM64 or MacOSArchitectureX8664.
+	Architecture string `json:"architecture" db:"architecture"`
+	// OSVersionAtLeast is the oldest macOS version of the hosts, inclusive.
+	OSVersionAtLeast string `json:"os_version_at_least" db:"os_version_at_least"`
+	// OSVersionBelow is the macOS version the hosts must be older than.
+	OSVersionBelow string `json:"os_version_below" db:"os_version_below"`
+}
+
+func (f MDMHostTargetFilters) Validate() error {
+	switch f.Architecture {
+	case "", MacOSArchitectureARM64, MacOSArchitectureX8664:
+	default:
+		return fmt.Errorf(`invalid architecture %q, must be "arm64" or "x86_64"`, f.Architecture)
+	}
+	if f.OSVersionAtLeast != "" && !versionStringRegex.MatchString(f.OSVersionAtLeast) {
+		return errors.New(`os_version_at_least accepts version numbers only. (E.g., "13.0.1.")`)
+	}
+	if f.OSVersionBelow != "" && !versionStringRegex.MatchString(f.OSVersionBelow) {
+		return errors.New(`os_version_below accepts version numbers only. (E.g., "14.")`)
+	}
+	return nil
+}
+
+// MDMHostTargetTeamIDs are the team ids of an MDMHostTarget, stored as a JSON
+// array.
+type MDMHostTargetTeamIDs []uint
+
+// Scan implements the sql.Scanner interface
+func (ids *MDMHostTargetTeamIDs) Scan(val interface{}) error {
+	switch v := val.(type) {
+	case []byte:
+		return json.Unmarshal(v, ids)
+	case string:
+		return json.Unmarshal([]byte(v), ids)
+	case nil: // sql NULL
+		return nil
+	default:
+		return fmt.Errorf("unsupported type: %T", v)
+	}
+}
+
+// Value implements the sql.Valuer interface
+func (ids MDMHostTargetTeamIDs) Value() (driver.Value, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(ids)
+}
+
+// MDMHostTargetHost is a host that matches an MDMHostTarget.
+type MDMHostTargetHost struct {
+	ID     uint   `json:"id" db:"id"`
+	UUID   string `json:"uuid" db:"uuid"`
+	TeamID *uint  `json:"team_id" db:"team_id"`
+	// DisplayName is the host's computer name, or its hostname if it has no
+	// computer name.
+	DisplayName    string `json:"display_name" db:"display_name"`
+	HardwareSerial string `json:"hardware_serial" db:"hardware_serial"`
+}
+
+// MDMApplePolicyAction is the MDM action that runs on the macOS hosts enrolled
+// in Fleet's MDM when they start failing a policy. It runs in addition to the
+// failing policies automation (webhook or ticket) of the policy, if any.
+type MDMApplePolicyAction struct {
+	PolicyID uint `json:"policy_id" db:"policy_id"`
+	// RemediationProfile is the configuration profile installed on the failing
+	// hosts, empty if none.
+	RemediationProfile []byte `json:"remediation_profile,omitempty" db:"remediation_profile"`
+	// CommandTemplate is the raw MDM command (a plist) enqueued for each
+	// failing host after expansion of its variables, empty if none. Its
+	// CommandUUID is generated by Fleet.
+	CommandTemplate string `json:"command_template,omitempty" db:"command_template"`
+	// TeamIDs restricts the hosts of a global policy on which the action runs
+	// to those in the listed teams (0 is for hosts in no team). If empty, the
+	// action runs on all failing hosts. It is always empty for team policies.
+	TeamIDs   []uint    `json:"team_ids" db:"-"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// AppliesToTeam returns true if the action runs on the hosts of the provided
+// team, nil being no team.
+func (a *MDMApplePolicyAction) AppliesToTeam(teamID *uint) bool {
+	if len(a.TeamIDs) == 0 {
+		return true
+	}
+	var tmID uint
+	if teamID != nil {
+		tmID = *teamID
+	}
+	for _, id := range a.TeamIDs {
+		if id == tmID {
+			return true
+		}
+	}
+	return false
+}
+
+// MDMApplePolicyActionHost is a host enrolled in Fleet's MDM on which a policy
+// MDM action may run.
+type MDMApplePolicyActionHost struct {
+	ID             uint   `db:"id"`
+	UUID           string `db:"uuid"`
+	HardwareSerial string `db:"hardware_serial"`
+	DisplayName    string `db:"display_name"`
+	TeamID         *uint  `db:"team_id"`
 }
 
 // MDMAppleFileVaultSummary reports the number of macOS hosts being managed with Apples disk
@@ -396,6 +1290,126 @@ type MDMAppleFileVaultSummary struct {
 	Enforcing           uint `json:"enforcing" db:"enforcing"`
 	Failed              uint `json:"failed" db:"failed"`
 	RemovingEnforcement uint `json:"removing_enforcement" db:"removing_enforcement"`
+	// KeyRotation is the progress of the FileVault key rotations requested
+	// for the hosts.
+	KeyRotation MDMAppleFileVaultKeyRotationSummary `json:"key_rotation" db:"-"`
+}
+
+// MDMAppleFileVaultKeyRotationSummary reports the progress of the FileVault
+// key rotations requested for a team's hosts.
+type MDMAppleFileVaultKeyRotationSummary struct {
+	// Pending is the number of hosts waiting to be notified to rotate their
+	// key.
+	Pending uint `json:"pending" db:"pending"`
+	// Notified is the number of hosts that were notified but didn't escrow a
+	// new key yet.
+	Notified uint `json:"notified" db:"notified"`
+	// Completed is the number of hosts that escrowed a new key since they were
+	// notified.
+	Completed uint `json:"completed" db:"completed"`
+}
+
+// OSUpdateStatus is the compliance status of a macOS host with regards to the
+// macOS updates settings (minimum version and deadline) of its team.
+type OSUpdateStatus string
+
+const (
+	// OSUpdateCompliant means that the host runs the minimum version or later.
+	OSUpdateCompliant OSUpdateStatus = "compliant"
+	// OSUpdateDeferred means that the host runs an older version than the
+	// minimum, but the deadline to update has not passed yet.
+	OSUpdateDeferred OSUpdateStatus = "deferred"
+	// OSUpdateBehind means that the host runs an older version than the minimum
+	// and the deadline to update has passed.
+	OSUpdateBehind OSUpdateStatus = "behind"
+)
+
+func (s OSUpdateStatus) IsValid() bool {
+	switch s {
+	case OSUpdateCompliant, OSUpdateDeferred, OSUpdateBehind:
+		return true
+	default:
+		return false
+	}
+}
+
+// MDMAppleOSUpdatesSummary reports the number of macOS hosts of a team by
+// compliance with the macOS updates settings of the team. Each host may be
+// counted in only one of three mutually-exclusive categories: Compliant,
+// Deferred or Behind. Hosts that did not report their OS version yet, or that
+// belong to a team without macOS updates settings, are not counted.
+type MDMAppleOSUpdatesSummary struct {
+	Compliant uint `json:"compliant" db:"compliant"`
+	Deferred  uint `json:"deferred" db:"deferred"`
+	Behind    uint `json:"behind" db:"behind"`
+	// UpdatesAvailable is the number of hosts counted in one of the categories
+	// above that reported at least one pending software update.
+	UpdatesAvailable uint `json:"updates_available" db:"updates_available"`
+}
+
+// MDMAppleReconciliationReason explains why the profiles reconciliation would
+// install or remove a profile on a host.
+type MDMAppleReconciliationReason string
+
+const (
+	// MDMAppleReconciliationReasonNewProfile is a profile that was never
+	// delivered to the host, e.g. a new profile or a newly enrolled host.
+	MDMAppleReconciliationReasonNewProfile MDMAppleReconciliationReason = "new_profile"
+	// MDMAppleReconciliationReasonTeamChange is a profile of the host's new
+	// team to install, or a profile of its previous team to remove.
+	MDMAppleReconciliationReasonTeamChange MDMAppleReconciliationReason = "team_change"
+	// MDMAppleReconciliationReasonProfileUpdated is a profile whose contents
+	// changed since it was delivered to the host.
+	MDMAppleReconciliationReasonProfileUpdated MDMAppleReconciliationReason = "profile_updated"
+	// MDMAppleReconciliationReasonFailedRetry is a profile that failed to
+	// install on the host and is retried.
+	MDMAppleReconciliationReasonFailedRetry MDMAppleReconciliationReason = "failed_retry"
+	// MDMAppleReconciliationReasonReinstall is a profile that was being removed
+	// from the host but is in its scope again.
+	MDMAppleReconciliationReasonReinstall MDMAppleReconciliationReason = "reinstall"
+	// MDMAppleReconciliationReasonResend is a profile that was marked to be
+	// sent again to the host, e.g. after a resend request.
+	MDMAppleReconciliationReasonResend MDMAppleReconciliationReason = "resend"
+	// MDMAppleReconciliationReasonProfileDeleted is a deleted profile to remove
+	// from the host.
+	MDMAppleReconciliationReasonProfileDeleted MDMAppleReconciliationReason = "profile_deleted"
+	// MDMAppleReconciliationReasonOutOfScope is a profile that is not in the
+	// host's scope anymore, e.g. because of an exclusion, its target or its
+	// macOS versions range.
+	MDMAppleReconciliationReasonOutOfScope MDMAppleReconciliationReason = "out_of_scope"
+)
+
+// MDMAppleReconciliationPlanItem is a profile that the next profiles
+// reconciliation would install on or remove from a host, with the reason of
+// the operation.
+type MDMAppleReconciliationPlanItem struct {
+	HostID            uint                         `json:"host_id" db:"host_id"`
+	HostUUID          string                       `json:"host_uuid" db:"host_uuid"`
+	Hostname          string                       `json:"hostname" db:"hostname"`
+	ProfileID         uint                         `json:"profile_id" db:"profile_id"`
+	ProfileIdentifier string                       `json:"profile_identifier" db:"profile_identifier"`
+	ProfileName       string                       `json:"profile_name" db:"profile_name"`
+	OperationType     MDMAppleOperationType        `json:"operation_type" db:"operation_type"`
+	Reason            MDMAppleReconciliationReason `json:"reason" db:"reason"`
+	Checksum          []byte                       `json:"-" db:"checksum"`
+
+	// HeldForApproval is true if the operation is part of a profile change
+	// that affects more hosts than allowed without approval, and is not
+	// approved yet.
+	HeldForApproval bool `json:"held_for_approval" db:"-"`
+	// QueuedCommandUUID is the identical command that is still queued for the
+	// host, if any, in which case no new command is sent.
+	QueuedCommandUUID string `json:"queued_command_uuid,omitempty" db:"-"`
+}
+
+// MDMAppleProfileIdentifierConflict reports the number of hosts of a team
+// that have a profile with the given identifier (PayloadIdentifier) installed,
+// or being installed or removed, that was not delivered by one of the team's
+// profiles. Installing a team profile with the same identifier on those hosts
+// would overwrite it.
+type MDMAppleProfileIdentifierConflict struct {
+	Identifier string `json:"identifier" db:"identifier"`
+	HostsCount uint   `json:"hosts_count" db:"hosts_count"`
 }
 
 // MDMAppleBootstrapPackageSummary reports the number of hosts that are targeted to install the
@@ -423,8 +1437,9 @@ type MDMAppleFleetdConfig struct {
 // MDMAppleSettingsPayload describes the payload accepted by the endpoint to
 // update specific MDM macos settings for a team (or no team).
 type MDMAppleSettingsPayload struct {
-	TeamID               *uint `json:"team_id"`
-	EnableDiskEncryption *bool `json:"enable_disk_encryption"`
+	TeamID                *uint `json:"team_id"`
+	EnableDiskEncryption  *bool `json:"enable_disk_encryption"`
+	AllowReservedPayloads *bool `json:"allow_reserved_payloads"`
 }
 
 // AuthzType implements authz.AuthzTyper.
@@ -445,12 +1460,25 @@ type NanoEnrollment struct {
 }
 
 // MDMAppleCommandListOptions defines the options to control the list of MDM
-// Apple Commands to return. Although it only supports the standard list
-// options for now, in the future we expect to add filtering options.
+// Apple Commands to return.
 //
 // https://github.com/fleetdm/fleet/issues/11008#issuecomment-1503466119
 type MDMAppleCommandListOptions struct {
 	ListOptions
+
+	// CommandUUID filters the list to the hosts targeted by that command.
+	CommandUUID string
+}
+
+// MDMAppleCommandStatusIsFinal returns true if the command status is final,
+// i.e. the host will not process the command anymore.
+func MDMAppleCommandStatusIsFinal(status string) bool {
+	switch status {
+	case MDMAppleStatusAcknowledged, MDMAppleStatusError, MDMAppleStatusCommandFormatError:
+		return true
+	default:
+		return false
+	}
 }
 
 // MDMAppleCommand represents an MDM Apple command that has been enqueued for
@@ -483,6 +1511,40 @@ type MDMAppleCommand struct {
 	TeamID *uint `json:"-" db:"team_id"`
 }
 
+// MDMAppleHostQueuedCommand is an MDM command queued for a host that was not
+// delivered yet, or that the host reported it couldn't process yet (NotNow).
+type MDMAppleHostQueuedCommand struct {
+	CommandUUID string `json:"command_uuid" db:"command_uuid"`
+	RequestType string `json:"request_type" db:"request_type"`
+	// Status is Pending if the command was not delivered yet, or NotNow.
+	Status   string `json:"status" db:"status"`
+	Priority int    `json:"priority" db:"priority"`
+	// ProfileIdentifier is the identifier of the configuration profile
+	// installed or removed by the command, nil if the command is not managed
+	// by Fleet's profiles.
+	ProfileIdentifier *string   `json:"profile_identifier" db:"profile_identifier"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+}
+
+// HostMDMAppleCommandHistoryRetention is how long the history of the MDM
+// commands sent to a host is kept.
+const HostMDMAppleCommandHistoryRetention = 90 * 24 * time.Hour
+
+// MDMAppleHostCommand is an entry of the history of the MDM commands sent to
+// a host. The history is kept by Fleet independently of the MDM queue of the
+// host, so it is not lost when the queue is cleared or purged.
+type MDMAppleHostCommand struct {
+	CommandUUID string `json:"command_uuid" db:"command_uuid"`
+	RequestType string `json:"request_type" db:"request_type"`
+	// Status is Pending until the host reports the result of the command, then
+	// the last status reported (Acknowledged, Error, CommandFormatError or
+	// NotNow). It is Canceled if the command was removed from the queue before
+	// it was delivered.
+	Status    string    `json:"status" db:"status"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
 // MDMAppleSetupAssistant represents the setup assistant set for a given team
 // or no team.
 type MDMAppleSetupAssistant struct {
@@ -498,3 +1560,140 @@ type MDMAppleSetupAssistant struct {
 func (a MDMAppleSetupAssistant) AuthzType() string {
 	return "mdm_apple_setup_assistant"
 }
+
+// MDMAppleServerURLMigrationCommandPrefix is the prefix of the command UUIDs
+// of the InstallProfile commands that redeliver the enrollment profile to the
+// hosts after a change of the server URL.
+const MDMAppleServerURLMigrationCommandPrefix = "SRVURL-"
+
+// MDMAppleProfileSecretsCommandPrefix is the prefix of the command UUIDs of
+// the InstallProfile commands that install profiles with the values of the
+// secrets they reference, those commands are redacted once processed.
+const MDMAppleProfileSecretsCommandPrefix = "SECRETS-"
+
+// MDMAppleServerURLMigrationHostStatus is the status of the redelivery of the
+// enrollment profile to a host during a server URL migration.
+type MDMAppleServerURLMigrationHostStatus string
+
+const (
+	MDMAppleServerURLMigrationHostPending      MDMAppleServerURLMigrationHostStatus = "pending"
+	MDMAppleServerURLMigrationHostSent         MDMAppleServerURLMigrationHostStatus = "sent"
+	MDMAppleServerURLMigrationHostAcknowledged MDMAppleServerURLMigrationHostStatus = "acknowledged"
+	MDMAppleServerURLMigrationHostFailed       MDMAppleServerURLMigrationHostStatus = "failed"
+)
+
+// MDMAppleServerURLMigration is a change of the server URL for which the
+// enrollment profile with the new URL is redelivered to the hosts that were
+// enrolled at the time of the change, along with the progress of the
+// redelivery.
+type MDMAppleServerURLMigration struct {
+	ID           uint      `json:"id" db:"id"`
+	OldServerURL string    `json:"old_server_url" db:"old_server_url"`
+	NewServerURL string    `json:"new_server_url" db:"new_server_url"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+
+	// Hosts is the number of hosts the enrollment profile is redelivered to,
+	// the other counts are the number of those hosts in each status.
+	Hosts        uint `json:"hosts" db:"hosts"`
+	Pending      uint `json:"pending" db:"pending"`
+	Sent         uint `json:"sent" db:"sent"`
+	Acknowledged uint `json:"acknowledged" db:"acknowledged"`
+	Failed       uint `json:"failed" db:"failed"`
+}
+
+// InProgress returns true if the enrollment profile still has to be sent to
+// some hosts.
+func (m MDMAppleServerURLMigration) InProgress() bool {
+	return m.Pending > 0
+}
+
+// MDMAppleServerURLMigrationCheck is the result of a pre-flight check of a
+// server URL migration.
+type MDMAppleServerURLMigrationCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// MDMWebhookEventType is the type of an event delivered by the MDM events
+// webhook.
+type MDMWebhookEventType string
+
+// List of the events delivered by the MDM events webhook.
+const (
+	MDMWebhookEventEnrolled      MDMWebhookEventType = "mdm_enrolled"
+	MDMWebhookEventCommandResult MDMWebhookEventType = "mdm_command_result"
+	MDMWebhookEventKeyEscrowed   MDMWebhookEventType = "mdm_key_escrowed"
+	// MDMWebhookEventCronJobFailing and MDMWebhookEventCronJobRecovered are
+	// not associated with a host, they are delivered when an MDM cron job
+	// reaches the failure threshold and when it succeeds again.
+	MDMWebhookEventCronJobFailing   MDMWebhookEventType = "mdm_cron_job_failing"
+	MDMWebhookEventCronJobRecovered MDMWebhookEventType = "mdm_cron_job_recovered"
+)
+
+// MDMWebhookEvent is the payload of a delivery of the MDM events webhook.
+type MDMWebhookEvent struct {
+	// ID uniquely identifies the event, it is the same for all delivery
+	// attempts of that event so that receivers can deduplicate them.
+	ID        string              `json:"id"`
+	Type      MDMWebhookEventType `json:"type"`
+	Timestamp time.Time           `json:"timestamp"`
+	HostUUID  string              `json:"host_uuid"`
+	// Details holds the event-specific data, its structure depends on the
+	// type of the event.
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+// MDMWebhookDeadLetter is an MDM webhook event that could not be delivered
+// after all attempts.
+type MDMWebhookDeadLetter struct {
+	JobID         uint            `json:"job_id"`
+	Event         MDMWebhookEvent `json:"event"`
+	Attempts      int             `json:"attempts"`
+	Error         string          `json:"error"`
+	CreatedAt     time.Time       `json:"created_at"`
+	LastAttemptAt *time.Time      `json:"last_attempt_at"`
+}
+
+// HostMDMChangeType is the type of a change of the MDM state of a host, as
+// returned by the MDM changes API.
+type HostMDMChangeType string
+
+// List of the changes of the MDM state of hosts that are recorded.
+const (
+	HostMDMChangeEnrolled      HostMDMChangeType = "enrolled"
+	HostMDMChangeUnenrolled    HostMDMChangeType = "unenrolled"
+	HostMDMChangeProfileStatus HostMDMChangeType = "profile_status"
+	HostMDMChangeKeyEscrowed   HostMDMChangeType = "key_escrowed"
+)
+
+// HostMDMChangesRetention is how long the changes of the MDM state of hosts
+// are kept. Consumers of the MDM changes API must sync more often than that
+// to not miss changes.
+const HostMDMChangesRetention = 30 * 24 * time.Hour
+
+// HostMDMChange is a change of the MDM state of a host. The changes are
+// ordered by ID, which is used as the cursor of the MDM changes API.
+type HostMDMChange struct {
+	ID       uint64 `json:"id" db:"id"`
+	HostUUID string `json:"host_uuid" db:"host_uuid"`
+	// HostID and HardwareSerial are nil if the host was deleted since the
+	// change.
+	HostID         *uint             `json:"host_id" db:"host_id"`
+	HardwareSerial *string           `json:"hardware_serial" db:"hardware_serial"`
+	Type           HostMDMChangeType `json:"type" db:"change_type"`
+	// Details holds the change-specific data, its structure depends on the
+	// type of the change.
+	Details   *json.RawMessage `json:"details" db:"details"`
+	CreatedAt time.Time        `json:"created_at" db:"created_at"`
+}
+
+// HostMDMProfileStatusChange is the details of a HostMDMChangeProfileStatus
+// change.
+type HostMDMProfileStatusChange struct {
+	ProfileIdentifier string                  `json:"profile_identifier"`
+	ProfileName       string                  `json:"profile_name"`
+	OperationType     MDMAppleOperationType   `json:"operation_type"`
+	Status            *MDMAppleDeliveryStatus `json:"status"`
+	Detail            string                  `json:"detail"`
+}
@@ -1,9 +1,12 @@
 package fleet
 
 import (
+	"crypto/md5" //nolint:gosec
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -94,6 +97,75 @@ func TestMDMAppleConfigProfile(t *testing.T) {
 	}
 }
 
+func TestMDMAppleConfigProfileFIPSMode(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	crtBytes, err := depot.NewCACert().SelfSign(rand.Reader, key.Public(), key)
+	require.NoError(t, err)
+	crt, err := x509.ParseCertificate(crtBytes)
+	require.NoError(t, err)
+
+	sign := func(digest asn1.ObjectIdentifier) []byte {
+		signedData, err := pkcs7.NewSignedData(mobileconfigForTest("ValidName", "ValidIdentifier", uuid.NewString(), ""))
+		require.NoError(t, err)
+		signedData.SetDigestAlgorithm(digest)
+		require.NoError(t, signedData.AddSigner(crt, key, pkcs7.SignerInfoConfig{}))
+		b, err := signedData.Finish()
+		require.NoError(t, err)
+		return b
+	}
+	sha1Signed, sha256Signed := sign(pkcs7.OIDDigestAlgorithmSHA1), sign(pkcs7.OIDDigestAlgorithmSHA256)
+
+	_, err = NewMDMAppleConfigProfile(sha1Signed, nil)
+	require.NoError(t, err)
+
+	mobileconfig.SetFIPSMode(true)
+	t.Cleanup(func() { mobileconfig.SetFIPSMode(false) })
+
+	_, err = NewMDMAppleConfigProfile(sha1Signed, nil)
+	require.ErrorContains(t, err, "only SHA-256, SHA-384 and SHA-512 are allowed in FIPS mode")
+	_, err = NewMDMAppleConfigProfile(sha256Signed, nil)
+	require.NoError(t, err)
+}
+
+func TestMDMProfileChecksumAlgorithm(t *testing.T) {
+	mc := mobileconfigForTest("N", "I", "uuid", "")
+	canonical, err := mc.Canonical()
+	require.NoError(t, err)
+	md5Sum := md5.Sum(canonical) //nolint:gosec
+	sha256Sum := sha256.Sum256(canonical)
+
+	require.Equal(t, md5Sum[:], MDMProfileChecksumAlgorithm("").Sum(mc))
+	require.Equal(t, md5Sum[:], MDMProfileChecksumMD5.Sum(mc))
+	require.Equal(t, sha256Sum[:16], MDMProfileChecksumSHA256.Sum(mc))
+
+	// the profiles that can't be parsed are hashed as-is
+	invalid := []byte("<?xml not a plist")
+	md5Sum = md5.Sum(invalid) //nolint:gosec
+	require.Equal(t, md5Sum[:], MDMProfileChecksumMD5.Sum(invalid))
+}
+
+func TestMDMProfileChecksumIgnoresFormatting(t *testing.T) {
+	mc := mobileconfigForTest("N", "I", "uuid", "")
+
+	// same profile with other whitespace and key order
+	reformatted := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0"><dict>
+    <key>PayloadVersion</key><integer>1</integer>
+    <key>PayloadUUID</key><string>uuid</string>
+    <key>PayloadType</key><string>Configuration</string>
+    <key>PayloadIdentifier</key><string>I</string>
+    <key>PayloadDisplayName</key><string>N</string>
+    <key>PayloadContent</key><array></array>
+</dict></plist>`)
+
+	for _, alg := range []MDMProfileChecksumAlgorithm{MDMProfileChecksumMD5, MDMProfileChecksumSHA256} {
+		require.Equal(t, alg.Sum(mc), alg.Sum(reformatted), alg)
+		require.NotEqual(t, alg.Sum(mc), alg.Sum(mobileconfigForTest("N2", "I", "uuid", "")), alg)
+	}
+}
+
 func TestMDMAppleConfigProfileScreenPayloadContent(t *testing.T) {
 	cases := []struct {
 		testName     string
@@ -150,6 +222,11 @@ func TestMDMAppleConfigProfileScreenPayloadContent(t *testing.T) {
 				require.Error(t, err)
 				require.ErrorContains(t, err, pt)
 			}
+
+			// the reserved payload types are recorded instead if allowed
+			err = parsed.ValidateUserProvidedAllowReservedTypes()
+			require.NoError(t, err)
+			require.Equal(t, c.shouldFail, parsed.ReservedPayloadTypes)
 		})
 	}
 }
@@ -205,6 +282,13 @@ func TestMDMAppleConfigProfileScreenPayloadIdentifiers(t *testing.T) {
 				require.Error(t, err)
 				require.ErrorContains(t, err, pt)
 			}
+
+			// the reserved payload identifiers are never allowed
+			err = parsed.ValidateUserProvidedAllowReservedTypes()
+			for _, pt := range c.shouldFail {
+				require.Error(t, err)
+				require.ErrorContains(t, err, pt)
+			}
 		})
 	}
 }
@@ -279,3 +363,31 @@ func TestHostMDMAppleProfileIgnoreClientError(t *testing.T) {
 		OperationType: MDMAppleOperationTypeRemove,
 	}.IgnoreMDMClientError())
 }
+
+func TestValidateMDMAppleProfileMacOSVersions(t *testing.T) {
+	cases := []struct {
+		min, max string
+		wantErr  string
+	}{
+		{"", "", ""},
+		{"13", "", ""},
+		{"", "14.1", ""},
+		{"13.4.1", "13.4.1", ""},
+		{"13.4.1", "13", ""},
+		{"12.6", "13", ""},
+		{"13.1", "13.0.5", "must not be older than min_macos_version"},
+		{"14", "13.9", "must not be older than min_macos_version"},
+		{"thirteen", "", "min_macos_version"},
+		{"", "14.x", "max_macos_version"},
+	}
+	for _, c := range cases {
+		t.Run(c.min+"-"+c.max, func(t *testing.T) {
+			err := ValidateMDMAppleProfileMacOSVersions(c.min, c.max)
+			if c.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, c.wantErr)
+		})
+	}
+}
@@ -52,14 +52,18 @@ const (
 	// CapabilityTokenRotation denotes the ability of the server to support
 	// periodic rotation of device tokens
 	CapabilityTokenRotation Capability = "token_rotation"
+	// CapabilityMDMEnrollmentStatus denotes the ability of the server to ingest
+	// the MDM enrollment status read locally by Orbit.
+	CapabilityMDMEnrollmentStatus Capability = "mdm_enrollment_status"
 )
 
 // ServerOrbitCapabilities is a set of capabilities that server-side,
 // Orbit-related endpoint supports.
 // **it shouldn't be modified at runtime**
 var ServerOrbitCapabilities = CapabilityMap{
-	CapabilityOrbitEndpoints: {},
-	CapabilityTokenRotation:  {},
+	CapabilityOrbitEndpoints:      {},
+	CapabilityTokenRotation:       {},
+	CapabilityMDMEnrollmentStatus: {},
 }
 
 // ServerDeviceCapabilities is a set of capabilities that server-side,
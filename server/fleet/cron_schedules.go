@@ -1,6 +1,8 @@
 package fleet
 
 import (
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"sort"
@@ -19,12 +21,16 @@ const (
 	CronAutomations                CronScheduleName = "automations"
 	CronWorkerIntegrations         CronScheduleName = "integrations"
 	CronActivitiesStreaming        CronScheduleName = "activities_streaming"
+	CronSecurityAuditLogStreaming  CronScheduleName = "security_audit_log_streaming"
 	CronMDMAppleProfileManager     CronScheduleName = "mdm_apple_profile_manager"
 )
 
 type CronSchedulesService interface {
 	// TriggerCronSchedule attempts to trigger an ad-hoc run of the named cron schedule.
 	TriggerCronSchedule(name string) error
+	// CronScheduleInterval returns the current interval of the named cron schedule
+	// and whether it is registered with the service.
+	CronScheduleInterval(name string) (time.Duration, bool)
 }
 
 func NewCronSchedules() *CronSchedules {
@@ -35,6 +41,7 @@ type CronSchedule interface {
 	Trigger() (*CronStats, error)
 	Name() string
 	Start()
+	Interval() time.Duration
 }
 
 type CronSchedules struct {
@@ -76,6 +83,16 @@ func (cs *CronSchedules) TriggerCronSchedule(name string) error {
 	}
 }
 
+// CronScheduleInterval returns the current interval of the named cron schedule and whether it is
+// registered with the service.
+func (cs *CronSchedules) CronScheduleInterval(name string) (time.Duration, bool) {
+	sched, ok := cs.Schedules[name]
+	if !ok {
+		return 0, false
+	}
+	return sched.Interval(), true
+}
+
 // ScheduleNames returns a list of the names of all cron schedules registered with the service.
 func (cs *CronSchedules) ScheduleNames() []string {
 	var res []string
@@ -160,6 +177,130 @@ type CronStats struct {
 	// Status is the current status of the run. Recognized statuses are "pending", "completed", and
 	// "expired".
 	Status CronStatsStatus `db:"status"`
+	// Errors are the errors returned by the jobs of a completed run, keyed by job ID.
+	Errors CronScheduleErrors `db:"errors"`
+}
+
+// CronScheduleErrors maps the ID of the jobs that failed during a run of a cron schedule to
+// their error message.
+type CronScheduleErrors map[string]string
+
+// Scan implements the sql.Scanner interface.
+func (e *CronScheduleErrors) Scan(val interface{}) error {
+	switch v := val.(type) {
+	case []byte:
+		return json.Unmarshal(v, e)
+	case string:
+		return json.Unmarshal([]byte(v), e)
+	case nil:
+		*e = nil
+		return nil
+	default:
+		return fmt.Errorf("unsupported type: %T", v)
+	}
+}
+
+// Value implements the sql.Valuer interface. Empty errors are stored as NULL.
+func (e CronScheduleErrors) Value() (driver.Value, error) {
+	if len(e) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(e)
+}
+
+// CronScheduleStatus is the health status of a cron schedule, as reported by
+// the cron schedules status endpoints.
+type CronScheduleStatus struct {
+	Name string `json:"name"`
+	// Enabled is true if the schedule is registered with this Fleet instance
+	// (e.g. MDM schedules are only registered when MDM is configured).
+	Enabled bool `json:"enabled"`
+	// Running is true if a run of the schedule is currently pending.
+	Running bool `json:"running"`
+	// LastRun is the most recently completed run of the schedule, if any.
+	LastRun *CronScheduleRun `json:"last_run"`
+	// NextRunAt is the expected start time of the next scheduled run, if the
+	// schedule is enabled.
+	NextRunAt *time.Time `json:"next_run_at"`
+}
+
+// CronScheduleRun is a completed run of a cron schedule.
+type CronScheduleRun struct {
+	Type            CronStatsType      `json:"type"`
+	Instance        string             `json:"instance"`
+	StartedAt       time.Time          `json:"started_at"`
+	CompletedAt     time.Time          `json:"completed_at"`
+	DurationSeconds int64              `json:"duration_seconds"`
+	Errors          CronScheduleErrors `json:"errors"`
+}
+
+// MDMCronScheduleNames are the names of the cron schedules that can be
+// inspected and triggered via the MDM schedules endpoints.
+var MDMCronScheduleNames = []CronScheduleName{
+	CronMDMAppleProfileManager,
+	CronAppleMDMDEPProfileAssigner,
+}
+
+// IsMDMCronSchedule returns true if name is one of the MDM cron schedules.
+func IsMDMCronSchedule(name string) bool {
+	for _, n := range MDMCronScheduleNames {
+		if string(n) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MDMMonitoredCronJob is a job of an MDM cron schedule whose repeated failures
+// are notified and reported in the MDM cron health.
+type MDMMonitoredCronJob struct {
+	Schedule CronScheduleName
+	Job      string
+}
+
+// MDMMonitoredCronJobs are the MDM cron jobs that must not fail repeatedly,
+// as MDM stops working for the hosts when they do (e.g. with an expired ABM
+// token, the new devices are not assigned a DEP profile).
+var MDMMonitoredCronJobs = []MDMMonitoredCronJob{
+	{Schedule: CronAppleMDMDEPProfileAssigner, Job: "dep_syncer"},
+	{Schedule: CronMDMAppleProfileManager, Job: "manage_profiles"},
+}
+
+// MDMCronJobHealth is the health of an MDM cron job, as reported in the app
+// config.
+type MDMCronJobHealth struct {
+	Schedule string `json:"schedule"`
+	Job      string `json:"job"`
+	// Healthy is false if the job failed in at least the failure threshold
+	// consecutive runs.
+	Healthy bool `json:"healthy"`
+	// ConsecutiveFailures is the number of the most recent runs in which the
+	// job failed.
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// LastError and LastFailedAt are the error and the completion time of the
+	// most recent failed run, they are only set if the job is failing.
+	LastError    string     `json:"last_error,omitempty"`
+	LastFailedAt *time.Time `json:"last_failed_at,omitempty"`
+}
+
+// NewMDMCronJobHealth returns the health of the job from the completed runs of
+// its schedule, ordered from the most recent.
+func NewMDMCronJobHealth(job MDMMonitoredCronJob, runs []CronStats, threshold int) *MDMCronJobHealth {
+	health := &MDMCronJobHealth{Schedule: string(job.Schedule), Job: job.Job}
+	for _, run := range runs {
+		jobErr, failed := run.Errors[job.Job]
+		if !failed {
+			break
+		}
+		if health.ConsecutiveFailures == 0 {
+			completedAt := run.UpdatedAt
+			health.LastError = jobErr
+			health.LastFailedAt = &completedAt
+		}
+		health.ConsecutiveFailures++
+	}
+	health.Healthy = health.ConsecutiveFailures < threshold
+	return health
 }
 
 // CronStatsType is one of two recognized types of cron stats (i.e. "scheduled" or "triggered")
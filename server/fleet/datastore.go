@@ -35,6 +35,21 @@ type InstallerStore interface {
 	Exists(ctx context.Context, installer Installer) (bool, error)
 }
 
+// MDMAssetStore is used to store the contents of MDM assets (bootstrap
+// packages and EULAs) in a blob storage instead of the database. Assets are
+// identified by their kind and token.
+type MDMAssetStore interface {
+	Get(ctx context.Context, kind MDMAssetKind, token string) (io.ReadCloser, int64, error)
+	Put(ctx context.Context, kind MDMAssetKind, token string, content io.Reader) error
+	Delete(ctx context.Context, kind MDMAssetKind, token string) error
+	// Path returns the path of the asset in the store, which is also its path
+	// on a CDN that has the store as origin.
+	Path(kind MDMAssetKind, token string) string
+	// Cleanup deletes the assets whose token is not in keep and that were
+	// stored before olderThan, and returns the number of deleted assets.
+	Cleanup(ctx context.Context, keep []string, olderThan time.Time) (int, error)
+}
+
 // Datastore combines all the interfaces in the Fleet DAL
 type Datastore interface {
 	health.Checker
@@ -212,12 +227,19 @@ type Datastore interface {
 	CleanupIncomingHosts(ctx context.Context, now time.Time) ([]uint, error)
 	// GenerateHostStatusStatistics retrieves the count of online, offline, MIA and new hosts.
 	GenerateHostStatusStatistics(ctx context.Context, filter TeamFilter, now time.Time, platform *string, lowDiskSpace *int) (*HostSummary, error)
+	// GenerateHostMDMStatistics retrieves the count of hosts by MDM enrollment
+	// status, with failed profiles and with disk encryption enabled, in a single
+	// query.
+	GenerateHostMDMStatistics(ctx context.Context, filter TeamFilter, platform *string) (*HostSummaryMDM, error)
 	// HostIDsByName Retrieve the IDs associated with the given hostnames
 	HostIDsByName(ctx context.Context, filter TeamFilter, hostnames []string) ([]uint, error)
 
 	// HostIDsByOSID retrieves the IDs of all host for the given OS ID
 	HostIDsByOSID(ctx context.Context, osID uint, offset int, limit int) ([]uint, error)
 
+	// HostIDsByTeam retrieves the IDs of up to limit hosts of the team.
+	HostIDsByTeam(ctx context.Context, teamID uint, limit int) ([]uint, error)
+
 	// TODO JUAN: Refactor this to use the Operating System type instead.
 	// HostIDsByOSVersion retrieves the IDs of all host matching osVersion
 	HostIDsByOSVersion(ctx context.Context, osVersion OSVersion, offset int, limit int) ([]uint, error)
@@ -241,12 +263,68 @@ type Datastore interface {
 	// ListHostBatteries returns the list of batteries for the given host ID.
 	ListHostBatteries(ctx context.Context, id uint) ([]*HostBattery, error)
 
+	// ListHostCustomAttributes returns the custom attributes of the given host
+	// ID, ordered by name.
+	ListHostCustomAttributes(ctx context.Context, hostID uint) ([]*HostCustomAttribute, error)
+	// ListHostCustomAttributesByHostUUIDs returns the custom attributes of the
+	// hosts identified by their UUIDs, as a map of host UUID to attribute name
+	// to value. Hosts without custom attributes are not part of the map.
+	ListHostCustomAttributesByHostUUIDs(ctx context.Context, hostUUIDs []string) (map[string]map[string]string, error)
+	// SetHostCustomAttributes creates, updates or deletes the custom
+	// attributes of the given host ID. The attrs map is keyed by attribute
+	// name, a nil value deletes the attribute. The source is recorded for the
+	// created and updated attributes. The installed configuration profiles of
+	// the host that reference the custom attributes are marked for reinstall if
+	// any value changed.
+	SetHostCustomAttributes(ctx context.Context, hostID uint, attrs map[string]*string, source string) error
+
+	// GetHostAssignedUser returns the user the given host ID is assigned to.
+	// It returns a NotFound error if the host has no assigned user.
+	GetHostAssignedUser(ctx context.Context, hostID uint) (*HostAssignedUser, error)
+	// ListHostAssignedUsersByHostUUIDs returns the assigned users of the hosts
+	// identified by their UUIDs, keyed by host UUID. Hosts without an assigned
+	// user are not part of the map.
+	ListHostAssignedUsersByHostUUIDs(ctx context.Context, hostUUIDs []string) (map[string]*HostAssignedUser, error)
+	// SetHostAssignedUser assigns the given host ID to the user, replacing the
+	// current assignment. A nil user removes the assignment. The installed
+	// configuration profiles of the host that reference the assigned user are
+	// marked for reinstall if the user changed.
+	SetHostAssignedUser(ctx context.Context, hostID uint, user *HostAssignedUser) error
+
+	// GetHostMDMAppleDEPProfileOverride returns the DEP profile override of the
+	// host. It returns a NotFound error if the host has no override, or if it
+	// was cleared.
+	GetHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint) (*HostMDMAppleDEPProfileOverride, error)
+	// SetHostMDMAppleDEPProfileOverride sets the DEP profile override of the
+	// host and marks it as pending assignment. A nil profile clears the
+	// override, so that the default profile is assigned back to the host.
+	SetHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint, profile *json.RawMessage) error
+	// ListHostMDMAppleDEPProfileOverridesBySerials returns the DEP profile
+	// overrides of the hosts with the given serial numbers, keyed by serial
+	// number. Cleared overrides are not part of the map.
+	ListHostMDMAppleDEPProfileOverridesBySerials(ctx context.Context, serials []string) (map[string]*HostMDMAppleDEPProfileOverride, error)
+	// ListPendingHostMDMAppleDEPProfileOverrides returns the DEP profile
+	// overrides, including the cleared ones, that are pending assignment.
+	ListPendingHostMDMAppleDEPProfileOverrides(ctx context.Context) ([]*HostMDMAppleDEPProfileOverride, error)
+	// SetHostMDMAppleDEPProfileOverridesPending marks the DEP profile overrides
+	// of the hosts as pending assignment.
+	SetHostMDMAppleDEPProfileOverridesPending(ctx context.Context, hostIDs []uint) error
+	// SetHostMDMAppleDEPProfileOverrideAssigned records that the DEP profile
+	// override of the host was assigned with the given profile UUID, or
+	// deletes it if it was cleared.
+	SetHostMDMAppleDEPProfileOverrideAssigned(ctx context.Context, hostID uint, profileUUID string) error
+
 	// LoadHostByDeviceAuthToken loads the host identified by the device auth token.
 	// If the token is invalid or expired it returns a NotFoundError.
 	LoadHostByDeviceAuthToken(ctx context.Context, authToken string, tokenTTL time.Duration) (*Host, error)
 	// SetOrUpdateDeviceAuthToken inserts or updates the auth token for a host.
 	SetOrUpdateDeviceAuthToken(ctx context.Context, hostID uint, authToken string) error
 
+	// NewHostQuarantine records the quarantine of a host.
+	NewHostQuarantine(ctx context.Context, q *HostQuarantine) (*HostQuarantine, error)
+	// DeleteHostQuarantine deletes the record of a host quarantine.
+	DeleteHostQuarantine(ctx context.Context, id uint) error
+
 	// FailingPoliciesCount returns the number of failling policies for 'host'
 	FailingPoliciesCount(ctx context.Context, host *Host) (uint, error)
 
@@ -257,6 +335,9 @@ type Datastore interface {
 	GetHostMunkiIssues(ctx context.Context, hostID uint) ([]*HostMunkiIssue, error)
 	GetHostMDM(ctx context.Context, hostID uint) (*HostMDM, error)
 	GetHostMDMCheckinInfo(ctx context.Context, hostUUID string) (*HostMDMCheckinInfo, error)
+	// MarkHostMDMCheckedIn records t as the last time the host with the
+	// provided UUID checked in via MDM.
+	MarkHostMDMCheckedIn(ctx context.Context, hostUUID string, t time.Time) error
 
 	AggregatedMunkiVersion(ctx context.Context, teamID *uint) ([]AggregatedMunkiVersion, time.Time, error)
 	AggregatedMunkiIssues(ctx context.Context, teamID *uint) ([]AggregatedMunkiIssue, time.Time, error)
@@ -314,6 +395,10 @@ type Datastore interface {
 	// MarkSessionAccessed marks the currently tracked session as access to extend expiration
 	MarkSessionAccessed(ctx context.Context, session *Session) error
 
+	// MarkSessionReauthenticated records that the user confirmed their
+	// password in the session, which puts the session in sudo mode.
+	MarkSessionReauthenticated(ctx context.Context, session *Session) error
+
 	///////////////////////////////////////////////////////////////////////////////
 	// AppConfigStore contains method for saving and retrieving application configuration
 
@@ -384,6 +469,9 @@ type Datastore interface {
 	Team(ctx context.Context, tid uint) (*Team, error)
 	// Team deletes the Team by ID.
 	DeleteTeam(ctx context.Context, tid uint) error
+	// TeamDeletionReport returns what deleting the team would affect, in
+	// particular the MDM profiles that would be removed from its hosts.
+	TeamDeletionReport(ctx context.Context, tid uint) (*TeamDeletionReport, error)
 	// TeamByName retrieves the Team by Name.
 	TeamByName(ctx context.Context, name string) (*Team, error)
 	// ListTeams lists teams with the ordering and filters in the provided options.
@@ -464,6 +552,22 @@ type Datastore interface {
 	ListActivities(ctx context.Context, opt ListActivitiesOptions) ([]*Activity, *PaginationMetadata, error)
 	MarkActivitiesAsStreamed(ctx context.Context, activityIDs []uint) error
 
+	// NewSecurityAuditLogEntry appends the entry to the security audit log,
+	// chaining it to the last entry. It sets the ID, CreatedAt, PrevHash and
+	// Hash of the entry.
+	NewSecurityAuditLogEntry(ctx context.Context, entry *SecurityAuditLogEntry) error
+	// ListSecurityAuditLog returns the entries of the security audit log,
+	// most recent first by default.
+	ListSecurityAuditLog(ctx context.Context, opt ListOptions) ([]*SecurityAuditLogEntry, *PaginationMetadata, error)
+	// ListSecurityAuditLogToStream returns up to limit entries of the
+	// security audit log that weren't streamed yet, oldest first.
+	ListSecurityAuditLogToStream(ctx context.Context, limit uint) ([]*SecurityAuditLogEntry, error)
+	// MarkSecurityAuditLogAsStreamed marks the entries as streamed.
+	MarkSecurityAuditLogAsStreamed(ctx context.Context, ids []uint) error
+	// VerifySecurityAuditLog verifies that the entries of the security audit
+	// log match their hash and the hash of their previous entry.
+	VerifySecurityAuditLog(ctx context.Context) (*SecurityAuditLogVerification, error)
+
 	///////////////////////////////////////////////////////////////////////////////
 	// StatisticsStore
 
@@ -493,6 +597,10 @@ type Datastore interface {
 
 	PolicyQueriesForHost(ctx context.Context, host *Host) (map[string]string, error)
 
+	// PolicyMDMConditions returns the MDM conditions of the provided policies,
+	// indexed by policy id. The policies without MDM conditions are omitted.
+	PolicyMDMConditions(ctx context.Context, policyIDs []uint) (map[uint]PolicyMDMConditions, error)
+
 	// Methods used for async processing of host policy query results.
 	AsyncBatchInsertPolicyMembership(ctx context.Context, batch []PolicyMembershipResult) error
 	AsyncBatchUpdatePolicyTimestamp(ctx context.Context, ids []uint, ts time.Time) error
@@ -556,8 +664,14 @@ type Datastore interface {
 	GetLatestCronStats(ctx context.Context, name string) ([]CronStats, error)
 	// InsertCronStats inserts cron stats for the named cron schedule.
 	InsertCronStats(ctx context.Context, statsType CronStatsType, name string, instance string, status CronStatsStatus) (int, error)
-	// UpdateCronStats updates the status of the identified cron stats record.
-	UpdateCronStats(ctx context.Context, id int, status CronStatsStatus) error
+	// UpdateCronStats updates the status and the job errors of the identified cron stats record.
+	UpdateCronStats(ctx context.Context, id int, status CronStatsStatus, cronErrors CronScheduleErrors) error
+	// GetLatestCompletedCronStats returns the most recently completed run (scheduled or triggered)
+	// of the named cron schedule, including the errors of the jobs that failed during that run.
+	GetLatestCompletedCronStats(ctx context.Context, name string) (*CronStats, error)
+	// ListLatestCompletedCronStats returns up to limit of the most recently completed runs
+	// (scheduled or triggered) of the named cron schedule, from the most recent.
+	ListLatestCompletedCronStats(ctx context.Context, name string, limit int) ([]CronStats, error)
 	// UpdateAllCronStatsForInstance updates all records for the identified instance with the
 	// specified statuses
 	UpdateAllCronStatsForInstance(ctx context.Context, instance string, fromStatus CronStatsStatus, toStatus CronStatsStatus) error
@@ -668,11 +782,37 @@ type Datastore interface {
 	SetHostsDiskEncryptionKeyStatus(ctx context.Context, hostIDs []uint, encryptable bool, threshold time.Time) error
 	// GetHostDiskEncryptionKey returns the encryption key information for a given host
 	GetHostDiskEncryptionKey(ctx context.Context, hostID uint) (*HostDiskEncryptionKey, error)
+	// NewHostDiskEncryptionKeyAccess records an access to the disk encryption
+	// key of a host.
+	NewHostDiskEncryptionKeyAccess(ctx context.Context, access *HostDiskEncryptionKeyAccess) error
+	// ListHostDiskEncryptionKeyAccesses returns the recorded accesses to the
+	// disk encryption key of a host, most recent first by default.
+	ListHostDiskEncryptionKeyAccesses(ctx context.Context, hostID uint, opt ListOptions) ([]*HostDiskEncryptionKeyAccess, *PaginationMetadata, error)
 
 	SetDiskEncryptionResetStatus(ctx context.Context, hostID uint, status bool) error
+
+	// RequestMDMAppleFileVaultKeyRotation flags the macOS hosts of the team
+	// (or no team if teamID is nil) that have an escrowed disk encryption key
+	// for the rotation of their key. It returns the number of hosts flagged.
+	RequestMDMAppleFileVaultKeyRotation(ctx context.Context, teamID *uint) (int, error)
+
+	// RequestMDMAppleFileVaultKeyRotationForTarget is like
+	// RequestMDMAppleFileVaultKeyRotation, for the hosts that match the MDM
+	// host target instead of the hosts of a team.
+	RequestMDMAppleFileVaultKeyRotationForTarget(ctx context.Context, targetID uint) (int, error)
+
+	// NotifyMDMAppleFileVaultKeyRotations requests the disk encryption key
+	// reset of up to limit hosts flagged for rotation and not notified yet,
+	// oldest request first, so that fleetd prompts the end users to rotate
+	// their key. It returns the number of hosts notified.
+	NotifyMDMAppleFileVaultKeyRotations(ctx context.Context, limit int) (int, error)
 	// SetOrUpdateHostOrbitInfo inserts of updates the orbit info for a host
 	SetOrUpdateHostOrbitInfo(ctx context.Context, hostID uint, version string) error
 
+	// SetOrUpdateHostOSUpdates inserts or updates the OS version and the number
+	// of pending software updates reported by a macOS host.
+	SetOrUpdateHostOSUpdates(ctx context.Context, hostID uint, osVersion string, pendingUpdates uint) error
+
 	ReplaceHostDeviceMapping(ctx context.Context, id uint, mappings []*HostDeviceMapping) error
 
 	// ReplaceHostBatteries creates or updates the battery mappings of a host.
@@ -703,9 +843,20 @@ type Datastore interface {
 	// GetQueuedJobs gets queued jobs from the jobs table (queue).
 	GetQueuedJobs(ctx context.Context, maxNumJobs int) ([]*Job, error)
 
+	// GetJob returns the job with the provided id.
+	GetJob(ctx context.Context, id uint) (*Job, error)
+
+	// ListJobs returns the jobs with the provided name and state, most recently
+	// updated first.
+	ListJobs(ctx context.Context, name string, state JobState, opt ListOptions) ([]*Job, error)
+
 	// UpdateJobs updates an existing job. Call this after processing a job.
 	UpdateJob(ctx context.Context, id uint, job *Job) (*Job, error)
 
+	// UpdateJobProgress records the number of hosts processed so far by the
+	// job, out of the hosts it processes.
+	UpdateJobProgress(ctx context.Context, id uint, hostsDone, hostsCount int) error
+
 	///////////////////////////////////////////////////////////////////////////////
 	// Debug
 
@@ -722,6 +873,46 @@ type Datastore interface {
 	InsertOSVulnerabilities(ctx context.Context, vulnerabilities []OSVulnerability, source VulnerabilitySource) (int64, error)
 	DeleteOSVulnerabilities(ctx context.Context, vulnerabilities []OSVulnerability) error
 
+	///////////////////////////////////////////////////////////////////////////////
+	// SCIM
+
+	// CreateScimUser creates the SCIM user. It returns an AlreadyExists error
+	// if a user with the same user name exists.
+	CreateScimUser(ctx context.Context, user *ScimUser) (*ScimUser, error)
+	// ScimUserByID returns the SCIM user with the groups it is a member of.
+	ScimUserByID(ctx context.Context, id uint) (*ScimUser, error)
+	// ListScimUsers returns a page of the SCIM users, ordered by id, with the
+	// groups they are a member of, and the total number of users that match
+	// the filters.
+	ListScimUsers(ctx context.Context, opts ScimListOptions) ([]*ScimUser, int, error)
+	// ReplaceScimUser replaces the attributes of the SCIM user. It returns an
+	// AlreadyExists error if another user has the same user name.
+	ReplaceScimUser(ctx context.Context, user *ScimUser) error
+	// DeleteScimUser deletes the SCIM user and its group memberships.
+	DeleteScimUser(ctx context.Context, id uint) error
+	// CreateScimGroup creates the SCIM group with its members. It returns an
+	// AlreadyExists error if a group with the same display name exists.
+	CreateScimGroup(ctx context.Context, group *ScimGroup) (*ScimGroup, error)
+	// ScimGroupByID returns the SCIM group with its members.
+	ScimGroupByID(ctx context.Context, id uint) (*ScimGroup, error)
+	// ListScimGroups returns a page of the SCIM groups, ordered by id, with
+	// their members, and the total number of groups that match the filters.
+	ListScimGroups(ctx context.Context, opts ScimListOptions) ([]*ScimGroup, int, error)
+	// ReplaceScimGroup replaces the display name, external id and members of
+	// the SCIM group. It returns an AlreadyExists error if another group has
+	// the same display name.
+	ReplaceScimGroup(ctx context.Context, group *ScimGroup) error
+	// DeleteScimGroup deletes the SCIM group and its memberships.
+	DeleteScimGroup(ctx context.Context, id uint) error
+	// ScimGroupNamesForUserName returns the display names of the SCIM groups
+	// the active SCIM user with that user name is a member of.
+	ScimGroupNamesForUserName(ctx context.Context, userName string) ([]string, error)
+	// SetScimLastRequest records the outcome of the last SCIM request.
+	SetScimLastRequest(ctx context.Context, req *ScimLastRequest) error
+	// ScimLastRequest returns the outcome of the last SCIM request, or a
+	// NotFound error if no request was recorded.
+	ScimLastRequest(ctx context.Context) (*ScimLastRequest, error)
+
 	///////////////////////////////////////////////////////////////////////////////
 	// Apple MDM
 
@@ -749,6 +940,20 @@ type Datastore interface {
 	// to the specified profile id.
 	DeleteMDMAppleConfigProfile(ctx context.Context, profileID uint) error
 
+	// ListMDMAppleConfigProfilesByIDsOrIdentifiers returns the mdm config
+	// profiles (with their contents) that match any of the profile ids or any
+	// of the identifiers, in any team.
+	ListMDMAppleConfigProfilesByIDsOrIdentifiers(ctx context.Context, profileIDs []uint, identifiers []string) ([]*MDMAppleConfigProfile, error)
+
+	// DeleteMDMAppleConfigProfiles deletes the mdm config profiles
+	// corresponding to the specified profile ids in a single transaction.
+	DeleteMDMAppleConfigProfiles(ctx context.Context, profileIDs []uint) error
+
+	// CopyMDMAppleConfigProfileToTeams creates a copy of the mdm config profile
+	// in each of the teams (0 for no team) in a single transaction, and returns
+	// the created profiles.
+	CopyMDMAppleConfigProfileToTeams(ctx context.Context, cp *MDMAppleConfigProfile, teamIDs []uint) ([]*MDMAppleConfigProfile, error)
+
 	// DeleteMDMAppleConfigProfileByTeamAndIdentifier deletes a configuration
 	// profile using the unique key defined by `team_id` and `identifier`
 	DeleteMDMAppleConfigProfileByTeamAndIdentifier(ctx context.Context, teamID *uint, profileIdentifier string) error
@@ -774,6 +979,16 @@ type Datastore interface {
 	// GetMDMAppleCommandResults returns the execution results of a command identified by a CommandUUID.
 	GetMDMAppleCommandResults(ctx context.Context, commandUUID string) ([]*MDMAppleCommandResult, error)
 
+	// RedactMDMAppleCommandResult replaces the result of the command reported
+	// by the host with the redacted result, to remove the secrets it contains.
+	RedactMDMAppleCommandResult(ctx context.Context, hostUUID, commandUUID string, redactedResult []byte) error
+
+	// RedactMDMAppleCommand replaces the command with the redacted command, to
+	// remove the secrets it contains, once all the hosts it targets have
+	// reported a result for it. It does nothing while the command is still
+	// due to be delivered to a host.
+	RedactMDMAppleCommand(ctx context.Context, commandUUID, redactedCommand string) error
+
 	// ListMDMAppleCommands returns a list of MDM Apple commands that have been
 	// executed, based on the provided options.
 	ListMDMAppleCommands(ctx context.Context, tmFilter TeamFilter, listOpts *MDMAppleCommandListOptions) ([]*MDMAppleCommand, error)
@@ -802,13 +1017,115 @@ type Datastore interface {
 	// no team.
 	BatchSetMDMAppleProfiles(ctx context.Context, tmID *uint, profiles []*MDMAppleConfigProfile) error
 
+	// BatchSetMDMAppleProfileExclusions replaces the exclusions of hosts from
+	// the custom profiles of the given team or no team.
+	BatchSetMDMAppleProfileExclusions(ctx context.Context, tmID *uint, exclusions []*MDMAppleProfileExclusion) error
+
+	// BatchSetMDMAppleTeamsProfiles sets the MDM Apple profiles and exclusions
+	// of multiple teams (or no team) in a single transaction, so that either all
+	// of them or none of them are changed.
+	BatchSetMDMAppleTeamsProfiles(ctx context.Context, teams []*MDMAppleTeamProfiles) error
+
+	// ListMDMAppleProfileExclusions returns the exclusions of hosts from the
+	// custom profiles of the given team or no team.
+	ListMDMAppleProfileExclusions(ctx context.Context, tmID *uint) ([]*MDMAppleProfileExclusion, error)
+
+	// NewMDMHostTarget creates a new MDM host target.
+	NewMDMHostTarget(ctx context.Context, target *MDMHostTarget) (*MDMHostTarget, error)
+
+	// MDMHostTarget returns the MDM host target with the given id.
+	MDMHostTarget(ctx context.Context, id uint) (*MDMHostTarget, error)
+
+	// ListMDMHostTargets returns all the MDM host targets, sorted by name.
+	ListMDMHostTargets(ctx context.Context) ([]*MDMHostTarget, error)
+
+	// DeleteMDMHostTarget deletes the MDM host target with the given id. It
+	// fails with a foreign key error if a profile is restricted to it.
+	DeleteMDMHostTarget(ctx context.Context, id uint) error
+
+	// ListMDMHostTargetHosts returns the macOS hosts enrolled in Fleet's MDM
+	// that currently match the MDM host target with the given id.
+	ListMDMHostTargetHosts(ctx context.Context, id uint) ([]*MDMHostTargetHost, error)
+
+	// SetMDMAppleConfigProfileTarget restricts the profile to the hosts that
+	// match the MDM host target, or to none if targetID is nil.
+	SetMDMAppleConfigProfileTarget(ctx context.Context, profileID uint, targetID *uint) error
+
+	// SetMDMAppleConfigProfileMacOSVersions restricts the profile to the hosts
+	// running a macOS version in the range, empty versions are unbounded.
+	SetMDMAppleConfigProfileMacOSVersions(ctx context.Context, profileID uint, min, max string) error
+
+	// SetMDMApplePolicyAction creates or replaces the MDM action of a policy.
+	SetMDMApplePolicyAction(ctx context.Context, action *MDMApplePolicyAction) error
+
+	// GetMDMApplePolicyAction returns the MDM action of a policy, or a not
+	// found error if it has none.
+	GetMDMApplePolicyAction(ctx context.Context, policyID uint) (*MDMApplePolicyAction, error)
+
+	// DeleteMDMApplePolicyAction deletes the MDM action of a policy, returning
+	// a not found error if it has none.
+	DeleteMDMApplePolicyAction(ctx context.Context, policyID uint) error
+
+	// ListMDMApplePolicyActions returns the MDM actions of all policies.
+	ListMDMApplePolicyActions(ctx context.Context) ([]*MDMApplePolicyAction, error)
+
+	// ListMDMApplePolicyActionPolicyIDs returns the ids of the policies that
+	// have an MDM action.
+	ListMDMApplePolicyActionPolicyIDs(ctx context.Context) ([]uint, error)
+
+	// ListMDMApplePolicyActionHosts returns the hosts among the provided ids
+	// that are macOS hosts enrolled in Fleet's MDM.
+	ListMDMApplePolicyActionHosts(ctx context.Context, hostIDs []uint) ([]*MDMApplePolicyActionHost, error)
+
+	// GetMDMAppleProfilesPreview returns the number of hosts of the team (or no
+	// team) that would be affected if its custom profiles were replaced by the
+	// provided profiles with BatchSetMDMAppleProfiles.
+	GetMDMAppleProfilesPreview(ctx context.Context, tmID *uint, profiles []*MDMAppleConfigProfile) (*MDMAppleProfilesPreview, error)
+
 	// MDMAppleListDevices lists all the MDM enrolled devices.
 	MDMAppleListDevices(ctx context.Context) ([]MDMAppleDevice, error)
 
 	// IngestMDMAppleDevicesFromDEPSync creates new Fleet host records for MDM-enrolled devices that are
-	// not already enrolled in Fleet.
+	// not already enrolled in Fleet. It also stores the Apple Business Manager
+	// device information of all the devices, including the devices modified
+	// in Apple Business Manager that are already hosts. The new hosts are
+	// assigned to the team of their serial number if any (see
+	// SetMDMAppleDEPTeamAssignments), otherwise to the default team.
 	IngestMDMAppleDevicesFromDEPSync(ctx context.Context, devices []godep.Device) (int64, error)
 
+	// SetMDMAppleDEPTeamAssignments creates or updates the team assignments
+	// of the serial numbers, and removes those whose TeamID is nil.
+	SetMDMAppleDEPTeamAssignments(ctx context.Context, assignments []*MDMAppleDEPTeamAssignment) error
+
+	// ListMDMAppleDEPTeamAssignments returns all the team assignments of
+	// serial numbers, ordered by serial number.
+	ListMDMAppleDEPTeamAssignments(ctx context.Context) ([]*MDMAppleDEPTeamAssignment, error)
+
+	// GetMDMAppleDEPTeamAssignments returns the team IDs of the provided
+	// serial numbers that are assigned to a team, indexed by serial number.
+	GetMDMAppleDEPTeamAssignments(ctx context.Context, serials []string) (map[string]uint, error)
+
+	// GetHostMDMAppleDEPDevice returns the Apple Business Manager device
+	// information of the host, or a not found error if there is none.
+	GetHostMDMAppleDEPDevice(ctx context.Context, hostID uint) (*HostMDMAppleDEPDevice, error)
+
+	// SetMDMAppleDEPDevicesOrgName sets the name of the Apple Business Manager
+	// organization in the device information of all the DEP hosts.
+	SetMDMAppleDEPDevicesOrgName(ctx context.Context, orgName string) error
+
+	// ListMDMAppleDEPHostsReport returns the hosts that have Apple Business
+	// Manager device information along with that information, filtered by the
+	// teams the user can see.
+	ListMDMAppleDEPHostsReport(ctx context.Context, filter TeamFilter, opts ListOptions) ([]*MDMAppleDEPHostReportItem, error)
+
+	// ListMDMAppleDEPAssignmentDiscrepancies returns the hosts installed from
+	// DEP that were removed from Fleet's MDM server in Apple Business Manager
+	// and, if defaultFamilies is not empty, the hosts assigned to Fleet's MDM
+	// server whose device family is not one of defaultFamilies. The hosts
+	// unassigned from Fleet's MDM server are only returned if their device
+	// family is one of defaultFamilies, if any.
+	ListMDMAppleDEPAssignmentDiscrepancies(ctx context.Context, filter TeamFilter, defaultFamilies []string) ([]*MDMAppleDEPAssignmentDiscrepancy, error)
+
 	// IngestMDMAppleDeviceFromCheckin creates a new Fleet host record for an MDM-enrolled device that is
 	// not already enrolled in Fleet.
 	IngestMDMAppleDeviceFromCheckin(ctx context.Context, mdmHost MDMAppleHostDetails) error
@@ -832,6 +1149,19 @@ type Datastore interface {
 	// registered in `host_mdm_apple_profiles`
 	ListMDMAppleProfilesToRemove(ctx context.Context) ([]*MDMAppleProfilePayload, error)
 
+	// ListMDMAppleReconciliationPlan returns the profiles that the next
+	// profiles reconciliation would install on or remove from the hosts of the
+	// team (no team if nil or 0), with the reason of each operation. It
+	// doesn't account for the profile changes held for approval nor for the
+	// identical commands still queued.
+	ListMDMAppleReconciliationPlan(ctx context.Context, teamID *uint) ([]*MDMAppleReconciliationPlanItem, error)
+
+	// ListMDMAppleHostProfilesWithQueuedCommand returns the profiles of the
+	// hosts for the given operation type whose command is still queued for the
+	// host, i.e. it wasn't acknowledged nor failed yet. The CommandUUID of the
+	// returned payloads is set to the queued command.
+	ListMDMAppleHostProfilesWithQueuedCommand(ctx context.Context, opType MDMAppleOperationType, hostUUIDs []string) ([]*MDMAppleProfilePayload, error)
+
 	// BulkUpsertMDMAppleHostProfiles bulk-adds/updates records to track the
 	// status of a profile in a host.
 	BulkUpsertMDMAppleHostProfiles(ctx context.Context, payload []*MDMAppleBulkUpsertHostProfilePayload) error
@@ -842,15 +1172,252 @@ type Datastore interface {
 	// (only one of those ID types can be provided).
 	BulkSetPendingMDMAppleHostProfiles(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, hostUUIDs []string) error
 
+	// ListMDMAppleBulkSetPendingHostUUIDs returns the UUIDs of the hosts that
+	// would be affected by BulkSetPendingMDMAppleHostProfiles for the provided
+	// criteria, which may be either a list of hostIDs, teamIDs or profileIDs
+	// (only one of those ID types can be provided).
+	ListMDMAppleBulkSetPendingHostUUIDs(ctx context.Context, hostIDs, teamIDs, profileIDs []uint) ([]string, error)
+
+	// CountMDMAppleBulkSetPendingHosts returns the number of hosts that would
+	// be affected by BulkSetPendingMDMAppleHostProfiles for the provided
+	// criteria, counting at most limit hosts.
+	CountMDMAppleBulkSetPendingHosts(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, limit int) (int, error)
+
 	// GetMDMAppleProfilesContents retrieves the XML contents of the
 	// profiles requested.
 	GetMDMAppleProfilesContents(ctx context.Context, profileIDs []uint) (map[uint]mobileconfig.Mobileconfig, error)
 
+	// ListMDMAppleProfilesReferencingSecrets returns the XML contents of the
+	// profiles that reference secrets of the secrets manager, keyed by profile
+	// ID.
+	ListMDMAppleProfilesReferencingSecrets(ctx context.Context) (map[uint]mobileconfig.Mobileconfig, error)
+
+	// SetMDMAppleProfileSecretsChecksum sets the checksum of the versions of
+	// the secrets referenced by the profile, which is part of the checksum of
+	// the profile delivered to the hosts.
+	SetMDMAppleProfileSecretsChecksum(ctx context.Context, profileID uint, checksum []byte) error
+
 	// UpdateOrDeleteHostMDMAppleProfile updates information about a single
 	// profile status. It deletes the row if the profile operation is "remove"
 	// and the status is "verifying" (i.e. successfully removed).
 	UpdateOrDeleteHostMDMAppleProfile(ctx context.Context, profile *HostMDMAppleProfile) error
 
+	// UpdateHostMDMAppleProfileRetryableFailure records a failure to apply the
+	// profile to the host with an error that is retryable according to the
+	// grace period settings. The profile is queued to be applied again, unless
+	// the grace period is over in which case it is marked as failed and true is
+	// returned.
+	UpdateHostMDMAppleProfileRetryableFailure(ctx context.Context, profile *HostMDMAppleProfile, gracePeriod MacOSProfileFailureGracePeriod) (failed bool, err error)
+
+	// ListTeamsMacOSProfileRetryPolicies returns the profile retry policy of
+	// each team, by team id.
+	ListTeamsMacOSProfileRetryPolicies(ctx context.Context) (map[uint]MacOSProfileRetryPolicy, error)
+
+	// RetryFailedHostMDMAppleProfiles queues the failed profile installations
+	// of the hosts of the team (0 for no team) whose retry is due to be
+	// applied again, and schedules the retry of the other failed profile
+	// installations that were retried less than the maximum of the policy. It
+	// returns the number of host profiles that were queued.
+	RetryFailedHostMDMAppleProfiles(ctx context.Context, teamID uint, policy MacOSProfileRetryPolicy) (int64, error)
+
+	// ResetFailedHostMDMAppleProfile queues the failed installation of the
+	// profile on the host, or on the hosts of the team (0 for no team) if
+	// hostUUID is empty, to be applied again, and resets their retries. It
+	// returns the number of host profiles that were queued.
+	ResetFailedHostMDMAppleProfile(ctx context.Context, profileID uint, hostUUID string, teamID uint) (int64, error)
+
+	// SetMDMAppleEnrollmentPendingApproval records that the manual enrollment
+	// of the host must be approved before the host receives profiles and
+	// commands. It returns true if the host was not already pending or
+	// approved.
+	SetMDMAppleEnrollmentPendingApproval(ctx context.Context, hostUUID string) (bool, error)
+
+	// ApproveMDMAppleEnrollment approves the pending enrollment of the host. It
+	// returns a NotFoundError if the host is not pending approval.
+	ApproveMDMAppleEnrollment(ctx context.Context, hostUUID string) error
+
+	// PurgeHostMDMAppleData deletes the MDM state of the host (delivery status
+	// of its profiles and bootstrap package, certificates, escrowed disk
+	// encryption key, Activation Lock bypass code and queued commands), so that
+	// it is provisioned again from scratch. Its MDM enrollment is kept.
+	PurgeHostMDMAppleData(ctx context.Context, hostID uint, hostUUID string) error
+
+	// ListMDMApplePendingEnrollments returns the hosts visible to the filter
+	// whose enrollment waits to be approved, oldest first.
+	ListMDMApplePendingEnrollments(ctx context.Context, filter TeamFilter) ([]*MDMApplePendingEnrollment, error)
+
+	// FilterMDMAppleHostUUIDsPendingApproval returns the subset of the provided
+	// host UUIDs whose enrollment waits to be approved.
+	FilterMDMAppleHostUUIDsPendingApproval(ctx context.Context, hostUUIDs []string) ([]string, error)
+
+	// RequestMDMAppleProfileChanges records the profile changes that must be
+	// approved before their commands are sent. A change that is already
+	// recorded and not expired is kept, with its host count updated if it is
+	// still pending, otherwise a new pending change that expires at expiresAt
+	// is created. The ID, Status, CreatedAt and ExpiresAt of the changes are
+	// set, and the newly created changes are returned.
+	RequestMDMAppleProfileChanges(ctx context.Context, changes []*MDMAppleProfileChange, expiresAt time.Time) ([]*MDMAppleProfileChange, error)
+
+	// ExpireMDMAppleProfileChanges marks the pending profile changes that
+	// were not reviewed in time as expired.
+	ExpireMDMAppleProfileChanges(ctx context.Context) error
+
+	// ListMDMAppleProfileChanges returns the profile changes with the
+	// provided status, or all of them if status is empty, most recent first.
+	ListMDMAppleProfileChanges(ctx context.Context, status MDMAppleProfileChangeStatus) ([]*MDMAppleProfileChange, error)
+
+	// ReviewMDMAppleProfileChange sets the status of the pending profile
+	// change to approved or rejected, and returns the change. It returns a
+	// NotFoundError if the change is not pending or has expired.
+	ReviewMDMAppleProfileChange(ctx context.Context, id uint, status MDMAppleProfileChangeStatus) (*MDMAppleProfileChange, error)
+
+	// UpsertMDMAppleBlockedEnrollment records that the enrollment of the
+	// device was blocked or flagged, replacing the previous record of the
+	// same serial number.
+	UpsertMDMAppleBlockedEnrollment(ctx context.Context, blocked *MDMAppleBlockedEnrollment) error
+
+	// DeleteMDMAppleBlockedEnrollments deletes the records of the devices
+	// with the provided serial numbers, e.g. because they are now eligible.
+	DeleteMDMAppleBlockedEnrollments(ctx context.Context, serials []string) error
+
+	// ListMDMAppleBlockedEnrollments returns the blocked and flagged devices
+	// visible to the filter, most recent first.
+	ListMDMAppleBlockedEnrollments(ctx context.Context, filter TeamFilter) ([]*MDMAppleBlockedEnrollment, error)
+
+	// ListMDMAppleHostUUIDsToRefreshCertificates returns the UUIDs of up to
+	// limit MDM-enrolled macOS hosts whose list of installed certificates was
+	// not requested in the last interval, least recently requested first.
+	ListMDMAppleHostUUIDsToRefreshCertificates(ctx context.Context, interval time.Duration, limit int) ([]string, error)
+
+	// SetMDMAppleHostCertificatesRefreshRequested records that the list of
+	// installed certificates was just requested for the hosts.
+	SetMDMAppleHostCertificatesRefreshRequested(ctx context.Context, hostUUIDs []string) error
+
+	// ReplaceHostMDMAppleCertificates replaces the certificates installed on
+	// the host with the provided ones, as reported by the host in the result
+	// of a CertificateList command.
+	ReplaceHostMDMAppleCertificates(ctx context.Context, hostUUID string, certs []*HostMDMCertificate) error
+
+	// UpdateHostMDMAppleManagedCertificates marks the provided certificates as
+	// installed by MDM payloads on the host, and all others as installed by
+	// other means.
+	UpdateHostMDMAppleManagedCertificates(ctx context.Context, hostUUID string, certs []*HostMDMCertificate) error
+
+	// ListHostMDMAppleCertificates returns the certificates installed on the
+	// host, by default sorted by expiration date.
+	ListHostMDMAppleCertificates(ctx context.Context, hostUUID string, opt ListOptions) ([]*HostMDMCertificate, *PaginationMetadata, error)
+
+	// ListMDMAppleHostUUIDsToRefreshProfileList returns the UUIDs of up to
+	// limit MDM-enrolled macOS hosts with profiles installed by Fleet whose
+	// list of installed profiles was not requested in the last interval, least
+	// recently requested first.
+	ListMDMAppleHostUUIDsToRefreshProfileList(ctx context.Context, interval time.Duration, limit int) ([]string, error)
+
+	// SetMDMAppleHostProfileListRefreshRequested records that the list of
+	// installed profiles was just requested for the hosts.
+	SetMDMAppleHostProfileListRefreshRequested(ctx context.Context, hostUUIDs []string) error
+
+	// ListHostMDMAppleProfileInstalls returns the profiles that Fleet installs
+	// on the host, along with the PayloadUUID last reported installed by the
+	// host.
+	ListHostMDMAppleProfileInstalls(ctx context.Context, hostUUID string) ([]*HostMDMAppleProfileInstall, error)
+
+	// UpdateHostMDMAppleProfilesInstalledPayloadUUIDs records the PayloadUUIDs
+	// of the profiles reported installed by the host, keyed by profile id. The
+	// installed PayloadUUID of the host's other profiles is cleared.
+	UpdateHostMDMAppleProfilesInstalledPayloadUUIDs(ctx context.Context, hostUUID string, payloadUUIDs map[uint]string) error
+
+	// SetHostMDMAppleProfilesToReinstall marks the provided profiles of the
+	// host as pending installation, so that they are installed again.
+	SetHostMDMAppleProfilesToReinstall(ctx context.Context, hostUUID string, profileIDs []uint) error
+
+	// NewMDMAppleServerURLMigration creates a migration of the server URL from
+	// oldServerURL to newServerURL, for all the macOS hosts currently enrolled
+	// in Fleet's MDM.
+	NewMDMAppleServerURLMigration(ctx context.Context, oldServerURL, newServerURL string) (*MDMAppleServerURLMigration, error)
+
+	// GetLatestMDMAppleServerURLMigration returns the latest migration of the
+	// server URL, along with its progress.
+	GetLatestMDMAppleServerURLMigration(ctx context.Context) (*MDMAppleServerURLMigration, error)
+
+	// ListMDMAppleServerURLMigrationPendingHosts returns the UUIDs of up to
+	// limit hosts of the migration that weren't sent the enrollment profile
+	// yet.
+	ListMDMAppleServerURLMigrationPendingHosts(ctx context.Context, migrationID uint, limit int) ([]string, error)
+
+	// SetMDMAppleServerURLMigrationHostsSent records that the enrollment
+	// profile was sent to the hosts of the migration with the command.
+	SetMDMAppleServerURLMigrationHostsSent(ctx context.Context, migrationID uint, hostUUIDs []string, commandUUID string) error
+
+	// SetMDMAppleServerURLMigrationHostResult records the result of the
+	// command that sent the enrollment profile to the host.
+	SetMDMAppleServerURLMigrationHostResult(ctx context.Context, hostUUID, commandUUID string, status MDMAppleServerURLMigrationHostStatus, detail string) error
+
+	// ListMDMAppleSCEPCertificates returns the certificates issued by Fleet's
+	// SCEP server that match the options. The certificates used by hosts are
+	// limited to the teams of the filter.
+	ListMDMAppleSCEPCertificates(ctx context.Context, filter TeamFilter, opt MDMAppleSCEPCertificateListOptions) ([]*MDMAppleSCEPCertificate, *PaginationMetadata, error)
+
+	// ListMDMAppleSCEPIdentityCertificates returns the identity certificates
+	// currently used by the hosts enrolled in Fleet's MDM, ordered by serial.
+	// It returns at most limit certificates with a serial greater than
+	// afterSerial.
+	ListMDMAppleSCEPIdentityCertificates(ctx context.Context, afterSerial int64, limit uint) ([]*MDMAppleSCEPIdentityCertificate, error)
+
+	// SetupMDMAppleConfigAssets stores the MDM assets, encrypted with the
+	// server private key, and enables MDM in the app config in the same
+	// transaction. Apple Business Manager is enabled too if its token is part
+	// of the assets.
+	SetupMDMAppleConfigAssets(ctx context.Context, assets []MDMConfigAsset) error
+
+	// GetMDMConfigAssetsByName returns the decrypted MDM assets with the given
+	// names. The assets that are not stored are not part of the returned map.
+	GetMDMConfigAssetsByName(ctx context.Context, names []MDMConfigAssetName) (map[MDMConfigAssetName]MDMConfigAsset, error)
+
+	// SetOrUpdateHostOrbitMDMStatus stores the MDM enrollment status reported by
+	// fleetd for the host.
+	SetOrUpdateHostOrbitMDMStatus(ctx context.Context, hostID uint, status *OrbitMDMEnrollmentStatus) error
+
+	// ListMDMAppleEnrollmentMismatches returns the hosts of the team (or with
+	// no team if teamID is nil) for which the MDM enrollment status reported by
+	// fleetd doesn't match the Fleet MDM enrollment status. A host reporting
+	// that it is enrolled is only considered enrolled in Fleet's MDM if it
+	// reports mdmServerURL as its MDM server.
+	ListMDMAppleEnrollmentMismatches(ctx context.Context, teamID *uint, mdmServerURL string) ([]*MDMAppleEnrollmentMismatch, error)
+
+	// ListMDMAppleHostsMissingFleetd returns the hosts of the team (or with no
+	// team if teamID is nil) that are enrolled in Fleet's MDM since before
+	// enrolledBefore but for which fleetd (osquery or orbit) never enrolled.
+	ListMDMAppleHostsMissingFleetd(ctx context.Context, teamID *uint, enrolledBefore time.Time) ([]*MDMAppleHostMissingFleetd, error)
+
+	// ReleaseMDMAppleDEPHost updates the host with the given serial number after
+	// its device was released from Fleet's MDM server in Apple Business
+	// Manager. A host that was waiting to enroll via DEP is deleted, the other
+	// hosts are marked as not enrolled via DEP. It returns the id of the host
+	// (0 if there is no host with that serial number) and whether it was
+	// deleted.
+	ReleaseMDMAppleDEPHost(ctx context.Context, serial string) (hostID uint, deleted bool, err error)
+
+	// ListMDMAppleNanoEnrollments returns the enrollments stored in the MDM
+	// server's storage that match the options, including the ones without a
+	// matching Fleet host.
+	ListMDMAppleNanoEnrollments(ctx context.Context, opt MDMAppleNanoEnrollmentListOptions) ([]*MDMAppleNanoEnrollment, *PaginationMetadata, error)
+
+	// GetMDMAppleNanoEnrollment returns the enrollment with the given id from
+	// the MDM server's storage.
+	GetMDMAppleNanoEnrollment(ctx context.Context, id string) (*MDMAppleNanoEnrollment, error)
+
+	// DeleteMDMAppleNanoEnrollment deletes the enrollment with the given id
+	// from the MDM server's storage, along with its device (and the device's
+	// other enrollments) for device enrollments.
+	DeleteMDMAppleNanoEnrollment(ctx context.Context, id string) error
+
+	// LinkMDMAppleNanoEnrollmentToHost links the device of the enrollment with
+	// the given id to the host, by setting the host's UUID to the device's
+	// UDID. It returns a conflict error if the device is already linked to
+	// another host.
+	LinkMDMAppleNanoEnrollmentToHost(ctx context.Context, id string, hostID uint) error
+
 	// GetMDMAppleCommandRequest type returns the request type for the given command
 	GetMDMAppleCommandRequestType(ctx context.Context, commandUUID string) (string, error)
 
@@ -859,9 +1426,138 @@ type Datastore interface {
 	// to any team).
 	GetMDMAppleHostsProfilesSummary(ctx context.Context, teamID *uint) (*MDMAppleConfigProfilesSummary, error)
 
+	// ListMDMAppleHostsProfilesSummaryByTeam summarizes the current state of
+	// MDM configuration profiles on the hosts of every team that has hosts,
+	// with one row per team (including the hosts with no team).
+	ListMDMAppleHostsProfilesSummaryByTeam(ctx context.Context) ([]*MDMAppleConfigProfilesTeamSummary, error)
+
+	// ListMDMAppleReservedPayloadConflicts returns the custom profiles of the
+	// team (or no team if teamID is nil) that were uploaded with payloads of
+	// PayloadTypes reserved by Fleet.
+	ListMDMAppleReservedPayloadConflicts(ctx context.Context, teamID *uint) ([]MDMAppleReservedPayloadConflict, error)
+
+	// ListMDMAppleProfileIdentifierConflicts returns, for each of the provided
+	// identifiers that conflicts with a profile from another source on hosts
+	// of the team (or no team if teamID is nil), the number of affected hosts.
+	ListMDMAppleProfileIdentifierConflicts(ctx context.Context, teamID *uint, identifiers []string) ([]*MDMAppleProfileIdentifierConflict, error)
+
 	// InsertMDMIdPAccount inserts a new MDM IdP account
 	InsertMDMIdPAccount(ctx context.Context, account *MDMIdPAccount) error
 
+	// GetMDMIdPAccount returns the MDM IdP account with the given uuid.
+	GetMDMIdPAccount(ctx context.Context, uuid string) (*MDMIdPAccount, error)
+
+	// AssociateHostMDMIdPAccount records that the host was enrolled by the end
+	// user of the MDM IdP account, replacing any previous association.
+	AssociateHostMDMIdPAccount(ctx context.Context, hostUUID, accountUUID string) error
+
+	// SetHostMDMActivationLockBypassCode stores the Activation Lock bypass
+	// code of the host encrypted with the SCEP CA, replacing any previous one.
+	SetHostMDMActivationLockBypassCode(ctx context.Context, hostUUID, base64Encrypted string) error
+
+	// GetHostMDMActivationLockBypassCode returns the Activation Lock bypass
+	// code of the host, or a not found error if none was escrowed.
+	GetHostMDMActivationLockBypassCode(ctx context.Context, hostUUID string) (*HostMDMActivationLockBypassCode, error)
+
+	// SetHostMDMAppleLockPIN stores the PIN of the DeviceLock or EraseDevice
+	// command sent to the host, replacing the previous one.
+	SetHostMDMAppleLockPIN(ctx context.Context, pin *HostMDMAppleLockPIN) error
+
+	// GetHostMDMAppleLockPIN returns the PIN of the latest DeviceLock or
+	// EraseDevice command sent to the host.
+	GetHostMDMAppleLockPIN(ctx context.Context, hostUUID string) (*HostMDMAppleLockPIN, error)
+
+	// GetHostMDMIdPAccount returns the MDM IdP account of the end user that
+	// enrolled the host.
+	GetHostMDMIdPAccount(ctx context.Context, hostUUID string) (*MDMIdPAccount, error)
+
+	// ListMDMAppleHostQueuedCommands returns the commands queued for the host
+	// that were not delivered yet or that the host couldn't process yet
+	// (NotNow), in the order they will be delivered.
+	ListMDMAppleHostQueuedCommands(ctx context.Context, hostUUID string) ([]*MDMAppleHostQueuedCommand, error)
+
+	// CancelMDMAppleHostQueuedCommand removes the command from the queue of
+	// the host so that it is never delivered. It returns a not found error if
+	// the command is not queued for the host.
+	CancelMDMAppleHostQueuedCommand(ctx context.Context, hostUUID, commandUUID string) error
+
+	// ListMDMAppleHostCommands returns the history of the MDM commands sent to
+	// the host, most recent first by default. The history is kept even if the
+	// MDM queue of the host is cleared or purged.
+	ListMDMAppleHostCommands(ctx context.Context, hostUUID string, opt ListOptions) ([]*MDMAppleHostCommand, *PaginationMetadata, error)
+
+	// CleanupHostMDMAppleCommandHistory deletes the entries of the history of
+	// the MDM commands sent to the hosts that were created longer than
+	// HostMDMAppleCommandHistoryRetention ago.
+	CleanupHostMDMAppleCommandHistory(ctx context.Context, now time.Time) (int64, error)
+
+	// NewMDMAppleEnrollmentLink creates a new enrollment link.
+	NewMDMAppleEnrollmentLink(ctx context.Context, link *MDMAppleEnrollmentLink) (*MDMAppleEnrollmentLink, error)
+
+	// GetMDMAppleEnrollmentLink returns the enrollment link with the given id,
+	// along with the hosts that enrolled with it.
+	GetMDMAppleEnrollmentLink(ctx context.Context, id uint) (*MDMAppleEnrollmentLink, error)
+
+	// GetMDMAppleEnrollmentLinkByToken returns the enrollment link with the
+	// given token if it has not expired and can still be used, otherwise it
+	// returns a not found error.
+	GetMDMAppleEnrollmentLinkByToken(ctx context.Context, token string) (*MDMAppleEnrollmentLink, error)
+
+	// ConsumeMDMAppleEnrollmentLink records that the host enrolled with the
+	// enrollment link with the given token and returns the link. It returns a
+	// not found error if the link does not exist, has expired or has reached
+	// its maximum number of uses. Consuming the same link again for the same
+	// host is a no-op.
+	ConsumeMDMAppleEnrollmentLink(ctx context.Context, token, hostUUID string) (*MDMAppleEnrollmentLink, error)
+
+	// NewMDMAppleSSOEnrollmentToken creates a new SSO enrollment token.
+	NewMDMAppleSSOEnrollmentToken(ctx context.Context, token *MDMAppleSSOEnrollmentToken) (*MDMAppleSSOEnrollmentToken, error)
+
+	// ConsumeMDMAppleSSOEnrollmentToken marks the SSO enrollment token as
+	// consumed and returns it. It returns a not found error if the token does
+	// not exist, has expired, was revoked or was already consumed.
+	ConsumeMDMAppleSSOEnrollmentToken(ctx context.Context, token string) (*MDMAppleSSOEnrollmentToken, error)
+
+	// RevokeMDMAppleSSOEnrollmentToken revokes the SSO enrollment token with
+	// the given id and returns it. It returns a not found error if the token
+	// does not exist or can't be used anymore.
+	RevokeMDMAppleSSOEnrollmentToken(ctx context.Context, id uint) (*MDMAppleSSOEnrollmentToken, error)
+
+	// ListMDMAppleSSOEnrollmentTokens returns the SSO enrollment tokens along
+	// with the IdP account they were issued to.
+	ListMDMAppleSSOEnrollmentTokens(ctx context.Context, opt ListOptions) ([]*MDMAppleSSOEnrollmentToken, error)
+
+	// EnsureMDMAppleTeamEnrollmentToken sets the enrollment token of the team
+	// (or no team if teamID is nil) to token if it doesn't have one yet, and
+	// returns the current token of the team.
+	EnsureMDMAppleTeamEnrollmentToken(ctx context.Context, teamID *uint, token string) (*MDMAppleTeamEnrollmentToken, error)
+
+	// SetMDMAppleTeamEnrollmentToken sets the enrollment token of the team (or
+	// no team if teamID is nil) to token, replacing any existing one.
+	SetMDMAppleTeamEnrollmentToken(ctx context.Context, teamID *uint, token string) (*MDMAppleTeamEnrollmentToken, error)
+
+	// GetMDMAppleTeamEnrollmentTokenByToken returns the team enrollment token
+	// with the given token, or a not found error if no team has it.
+	GetMDMAppleTeamEnrollmentTokenByToken(ctx context.Context, token string) (*MDMAppleTeamEnrollmentToken, error)
+
+	// RecordMDMApplePushResults records the results of the push notifications
+	// sent to the enrollments with the given push tokens. A failure increments
+	// the failure count of the enrollment, and a success clears it.
+	RecordMDMApplePushResults(ctx context.Context, results []MDMApplePushResult) error
+
+	// ListMDMAppleInvalidPushTokenEnrollmentIDs returns the ids of the provided
+	// enrollments whose current push token was reported as invalid by APNs.
+	ListMDMAppleInvalidPushTokenEnrollmentIDs(ctx context.Context, enrollmentIDs []string) ([]string, error)
+
+	// ClearMDMApplePushFailures clears the push failures of the enrollment,
+	// e.g. after it sent a new push token.
+	ClearMDMApplePushFailures(ctx context.Context, enrollmentID string) error
+
+	// GetHostMDMApplePushFailure returns the push failures of the current
+	// push token of the host's device enrollment, or a not found error if
+	// there are none.
+	GetHostMDMApplePushFailure(ctx context.Context, hostUUID string) (*HostMDMApplePushFailure, error)
+
 	// GetMDMAppleFileVaultSummary summarizes the current state of Apple disk encryption profiles on
 	// each macOS host in the specified team (or, if no team is specified, each host that is not assigned
 	// to any team).
@@ -873,16 +1569,74 @@ type Datastore interface {
 	DeleteMDMAppleBootstrapPackage(ctx context.Context, teamID uint) error
 	// GetMDMAppleBootstrapPackageMeta returns metadata about the bootstrap package for a team
 	GetMDMAppleBootstrapPackageMeta(ctx context.Context, teamID uint) (*MDMAppleBootstrapPackage, error)
+	// ListMDMAppleBootstrapPackages returns metadata about the bootstrap
+	// packages of all teams, including where their contents are stored.
+	ListMDMAppleBootstrapPackages(ctx context.Context) ([]*MDMAppleBootstrapPackage, error)
+	// SetMDMAppleBootstrapPackageSignatureStatus records the result of the
+	// verification of the signing chain of the bootstrap package with the
+	// given token.
+	SetMDMAppleBootstrapPackageSignatureStatus(ctx context.Context, token string, status MDMAppleBootstrapPackageSignatureStatus, detail string, verifiedAt time.Time) error
 	// GetMDMAppleBootstrapPackageBytes returns the bytes of a bootstrap package with the given token
 	GetMDMAppleBootstrapPackageBytes(ctx context.Context, token string) (*MDMAppleBootstrapPackage, error)
 	// GetMDMAppleBootstrapPackageSummary returns an aggregated summary of
 	// the status of the bootstrap package for hosts in a team.
 	GetMDMAppleBootstrapPackageSummary(ctx context.Context, teamID uint) (*MDMAppleBootstrapPackageSummary, error)
 
+	// GetMDMAppleOSUpdatesSummary returns an aggregated summary of the
+	// compliance of the macOS hosts of a team (or no team, if teamID is 0) with
+	// the macOS updates settings that apply to them.
+	GetMDMAppleOSUpdatesSummary(ctx context.Context, teamID uint) (*MDMAppleOSUpdatesSummary, error)
+
 	// RecordHostBootstrapPackage records a command used to install a
 	// bootstrap package in a host.
 	RecordHostBootstrapPackage(ctx context.Context, commandUUID string, hostUUID string) error
 
+	// NewMDMAppleBootstrapPackageUpload creates a new resumable upload session
+	// for a bootstrap package.
+	NewMDMAppleBootstrapPackageUpload(ctx context.Context, upload *MDMAppleBootstrapPackageUpload) (*MDMAppleBootstrapPackageUpload, error)
+	// GetMDMAppleBootstrapPackageUpload returns the bootstrap package upload
+	// session identified by token.
+	GetMDMAppleBootstrapPackageUpload(ctx context.Context, token string) (*MDMAppleBootstrapPackageUpload, error)
+	// AppendMDMAppleBootstrapPackageUploadChunk appends a chunk of bytes to
+	// the upload session identified by token. The offset must match the number
+	// of bytes received so far, otherwise a
+	// MDMAppleBootstrapPackageUploadOffsetError is returned.
+	AppendMDMAppleBootstrapPackageUploadChunk(ctx context.Context, token string, offset int64, chunk []byte) (*MDMAppleBootstrapPackageUpload, error)
+	// GetMDMAppleBootstrapPackageUploadChunk returns the bytes of the chunk at
+	// the given index of an upload session.
+	GetMDMAppleBootstrapPackageUploadChunk(ctx context.Context, uploadID uint, index int) ([]byte, error)
+	// CommitMDMAppleBootstrapPackageUpload creates the bootstrap package of the
+	// upload session's team with the contents of the upload, the package's
+	// token is set to pkgToken.
+	CommitMDMAppleBootstrapPackageUpload(ctx context.Context, token string, pkgToken string) error
+	// CleanupMDMAppleBootstrapPackageUploads deletes the upload sessions that
+	// were not committed and have not been updated in the last 24 hours.
+	CleanupMDMAppleBootstrapPackageUploads(ctx context.Context, now time.Time) (int64, error)
+	// ListMDMAppleAssetTokens returns the tokens of all the bootstrap packages
+	// and EULAs.
+	ListMDMAppleAssetTokens(ctx context.Context) ([]string, error)
+
+	// ListHostMDMChanges returns up to limit changes of the MDM state of hosts
+	// recorded after the change with id since, in order.
+	ListHostMDMChanges(ctx context.Context, since uint64, limit int) ([]*HostMDMChange, error)
+	// CleanupHostMDMChanges deletes the changes of the MDM state of hosts
+	// recorded longer than HostMDMChangesRetention ago.
+	CleanupHostMDMChanges(ctx context.Context, now time.Time) (int64, error)
+
+	// GetMDMPostureScoreHostCounts returns the counts of the macOS hosts of
+	// the team (or no team if teamID is nil or 0) from which the MDM posture
+	// score is measured.
+	GetMDMPostureScoreHostCounts(ctx context.Context, teamID *uint) (*MDMPostureScoreHostCounts, error)
+	// SaveMDMPostureScores creates or replaces the MDM posture scores of the
+	// same team and day.
+	SaveMDMPostureScores(ctx context.Context, scores []*MDMPostureScore) error
+	// ListMDMPostureScores returns the daily MDM posture scores of the team (0
+	// for no team, nil for all hosts) since the day of since, by date.
+	ListMDMPostureScores(ctx context.Context, teamID *uint, since time.Time) ([]*MDMPostureScore, error)
+	// CleanupMDMPostureScores deletes the MDM posture scores older than
+	// MDMPostureScoreHistoryRetention.
+	CleanupMDMPostureScores(ctx context.Context, now time.Time) (int64, error)
+
 	// GetHostMDMMacOSSetup returns the MDM macOS setup information for the specified host id.
 	GetHostMDMMacOSSetup(ctx context.Context, hostID uint) (*HostMDMMacOSSetup, error)
 
@@ -907,6 +1661,25 @@ type Datastore interface {
 	// Set the profile UUID generated by the call to Apple's DefineProfile API of
 	// the setup assistant for a team or no team.
 	SetMDMAppleSetupAssistantProfileUUID(ctx context.Context, teamID *uint, profileUUID string) error
+
+	///////////////////////////////////////////////////////////////////////////////
+	// Microsoft MDM
+
+	// MDMWindowsInsertEnrolledDevice inserts a new Windows device enrolled in
+	// Fleet's MDM, or replaces it if a device with the same MDM device ID is
+	// already enrolled.
+	MDMWindowsInsertEnrolledDevice(ctx context.Context, device *MDMWindowsEnrolledDevice) error
+	// MDMWindowsGetEnrolledDevice returns the Windows device enrolled in
+	// Fleet's MDM with the provided MDM device ID.
+	MDMWindowsGetEnrolledDevice(ctx context.Context, mdmDeviceID string) (*MDMWindowsEnrolledDevice, error)
+	// MDMWindowsDeleteEnrolledDevice deletes the Windows device enrolled in
+	// Fleet's MDM with the provided MDM device ID.
+	MDMWindowsDeleteEnrolledDevice(ctx context.Context, mdmDeviceID string) error
+	// MDMWindowsLinkEnrolledDeviceToHost links the Windows device enrolled in
+	// Fleet's MDM with the provided MDM device ID to the host, if the device
+	// is not linked to a host yet. The host is the one of the osquery agent
+	// that reported the MDM device ID.
+	MDMWindowsLinkEnrolledDeviceToHost(ctx context.Context, mdmDeviceID, hostUUID string) error
 }
 
 const (
@@ -17,6 +17,9 @@ var (
 	ErrPasswordResetRequired = &passwordResetRequiredError{}
 	ErrMissingLicense        = &licenseError{}
 	ErrMDMNotConfigured      = &MDMNotConfiguredError{}
+	ErrSudoModeRequired      = &sudoModeRequiredError{}
+
+	ErrWindowsMDMNotConfigured = &WindowsMDMNotConfiguredError{}
 )
 
 // ErrWithInternal is an interface for errors that include extra "internal"
@@ -290,6 +293,21 @@ func (e passwordResetRequiredError) StatusCode() int {
 	return http.StatusUnauthorized
 }
 
+// sudoModeRequiredError is returned when a security-sensitive action is
+// attempted with a session that isn't in sudo mode, i.e. the user must
+// re-authenticate first.
+type sudoModeRequiredError struct {
+	ErrorWithUUID
+}
+
+func (e sudoModeRequiredError) Error() string {
+	return "re-authentication required"
+}
+
+func (e sudoModeRequiredError) StatusCode() int {
+	return http.StatusForbidden
+}
+
 // MDMNotConfiguredError is used when an MDM endpoint or resource is accessed
 // without having MDM correctly configured.
 type MDMNotConfiguredError struct{}
@@ -304,6 +322,20 @@ func (e *MDMNotConfiguredError) Error() string {
 	return "MDM features aren't turned on in Fleet. For more information about setting up MDM, please visit https://fleetdm.com/docs/using-fleet/mobile-device-management"
 }
 
+// WindowsMDMNotConfiguredError is used when a Windows MDM endpoint or
+// resource is accessed without having Windows MDM correctly configured.
+type WindowsMDMNotConfiguredError struct{}
+
+// Status implements the kithttp.StatusCoder interface so we can customize the
+// HTTP status code of the response returning this error.
+func (e *WindowsMDMNotConfiguredError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+func (e *WindowsMDMNotConfiguredError) Error() string {
+	return "Windows MDM isn't turned on in Fleet. For more information about setting up MDM, please visit https://fleetdm.com/docs/using-fleet/mobile-device-management"
+}
+
 // BadGatewayError is an error type that generates a 502 status code.
 type BadGatewayError struct {
 	Message string
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -144,6 +145,10 @@ type HostListOptions struct {
 	// MDMBootstrapPackageFilter filters the hosts by the status of the MDM bootstrap package.
 	MDMBootstrapPackageFilter *MDMBootstrapPackageStatus
 
+	// OSUpdateStatusFilter filters the hosts by their compliance with the macOS
+	// updates settings of their team.
+	OSUpdateStatusFilter *OSUpdateStatus
+
 	// MDMIDFilter filters the hosts by MDM ID.
 	MDMIDFilter *uint
 	// MDMNameFilter filters the hosts by MDM solution name (e.g. one of the
@@ -159,6 +164,29 @@ type HostListOptions struct {
 	// Premium feature, Fleet Free ignores the setting (it forces it to nil to
 	// disable it).
 	LowDiskSpaceFilter *int
+
+	// CertificateExpiringWithinDaysFilter filters the hosts that have at least
+	// one installed certificate (as reported via MDM) that expires in the next
+	// N days, including already expired certificates.
+	CertificateExpiringWithinDaysFilter *int
+
+	// LastMDMCheckinWithinDaysFilter filters the hosts that checked in via MDM
+	// in the last N days.
+	LastMDMCheckinWithinDaysFilter *int
+
+	// DiskEncryptionKeyEscrowedFilter filters the hosts by whether or not their
+	// disk encryption key is escrowed in Fleet.
+	DiskEncryptionKeyEscrowedFilter *bool
+
+	// KeyEscrowedBeforeFilter filters the hosts that have a disk encryption key
+	// escrowed, and whose key was escrowed before that time.
+	KeyEscrowedBeforeFilter *time.Time
+
+	// CustomAttributeNameFilter filters the hosts that have the custom
+	// attribute with that name. If CustomAttributeValueFilter is also set, only
+	// the hosts where that attribute has that value are returned.
+	CustomAttributeNameFilter  *string
+	CustomAttributeValueFilter *string
 }
 
 // TODO(Sarah): Are we missing any filters here? Should all MDM filters be included?
@@ -178,11 +206,18 @@ func (h HostListOptions) Empty() bool {
 		h.MacOSSettingsFilter == "" &&
 		h.MacOSSettingsDiskEncryptionFilter == "" &&
 		h.MDMBootstrapPackageFilter == nil &&
+		h.OSUpdateStatusFilter == nil &&
 		h.MDMIDFilter == nil &&
 		h.MDMNameFilter == nil &&
 		h.MDMEnrollmentStatusFilter == "" &&
 		h.MunkiIssueIDFilter == nil &&
-		h.LowDiskSpaceFilter == nil
+		h.LowDiskSpaceFilter == nil &&
+		h.CertificateExpiringWithinDaysFilter == nil &&
+		h.LastMDMCheckinWithinDaysFilter == nil &&
+		h.DiskEncryptionKeyEscrowedFilter == nil &&
+		h.KeyEscrowedBeforeFilter == nil &&
+		h.CustomAttributeNameFilter == nil &&
+		h.CustomAttributeValueFilter == nil
 }
 
 type HostUser struct {
@@ -257,6 +292,11 @@ type Host struct {
 	GigsDiskSpaceAvailable    float64 `json:"gigs_disk_space_available" db:"gigs_disk_space_available" csv:"gigs_disk_space_available"`
 	PercentDiskSpaceAvailable float64 `json:"percent_disk_space_available" db:"percent_disk_space_available" csv:"percent_disk_space_available"`
 
+	// LastMDMCheckinAt is the last time the host checked in via MDM, nil if it
+	// never did. Unlike SeenTime, which is only updated by osquery, it is also
+	// updated for MDM-only devices.
+	LastMDMCheckinAt *time.Time `json:"last_mdm_checkin_at" db:"last_mdm_checkin_at" csv:"last_mdm_checkin_at"`
+
 	// DiskEncryptionEnabled is only returned by GET /host/{id} and so is not
 	// exportable as CSV (which is the result of List Hosts endpoint). It is
 	// a *bool because for Linux we set it to NULL and omit it from the JSON
@@ -327,6 +367,143 @@ type MDMHostData struct {
 	//
 	// It is not filled in by all host-returning datastore methods.
 	MacOSSetup *HostMDMMacOSSetup `json:"macos_setup,omitempty" db:"-" csv:"-"`
+
+	// EndUser is the end user that authenticated with the IdP before
+	// enrolling the host, if end user authentication is configured.
+	//
+	// It is not filled in by all host-returning datastore methods.
+	EndUser *HostMDMEndUser `json:"end_user,omitempty" db:"-" csv:"-"`
+
+	// DEPDevice is the device information provided by Apple Business Manager
+	// for hosts assigned to Fleet via DEP, it is available before the host
+	// enrolls.
+	//
+	// It is not filled in by all host-returning datastore methods.
+	DEPDevice *HostMDMAppleDEPDevice `json:"dep_device,omitempty" db:"-" csv:"-"`
+
+	// DEPProfileOverride is the DEP profile assigned to the host instead of
+	// the default one, nil if the host uses the default profile.
+	//
+	// It is not filled in by all host-returning datastore methods.
+	DEPProfileOverride *HostMDMAppleDEPProfileOverride `json:"dep_profile_override,omitempty" db:"-" csv:"-"`
+
+	// PushFailure reports the failures of the push notifications sent to the
+	// current push token of the host, it is nil if the last push succeeded.
+	//
+	// It is not filled in by all host-returning datastore methods.
+	PushFailure *HostMDMApplePushFailure `json:"push_failure,omitempty" db:"-" csv:"-"`
+}
+
+// HostMDMEndUser contains the account information of the end user that
+// enrolled the host, as mapped from the IdP's SAML assertion.
+type HostMDMEndUser struct {
+	Username string   `json:"username"`
+	FullName string   `json:"full_name"`
+	Groups   []string `json:"groups"`
+}
+
+// HostMDMAppleDEPDevice is the device information of a host as provided by
+// Apple Business Manager in the DEP device sync.
+type HostMDMAppleDEPDevice struct {
+	HostID uint `json:"-" db:"host_id"`
+	// OrgName is the name of the Apple Business Manager organization that
+	// owns the device.
+	OrgName            string     `json:"org_name" db:"org_name"`
+	Description        string     `json:"description" db:"description"`
+	Color              string     `json:"color" db:"color"`
+	AssetTag           string     `json:"asset_tag" db:"asset_tag"`
+	DeviceFamily       string     `json:"device_family" db:"device_family"`
+	OS                 string     `json:"os" db:"os"`
+	DeviceAssignedBy   string     `json:"device_assigned_by" db:"device_assigned_by"`
+	DeviceAssignedDate *time.Time `json:"device_assigned_date" db:"device_assigned_date"`
+	// ProfileStatus, ProfileUUID, ProfileAssignTime and ProfilePushTime are
+	// the state of the DEP profile of the device as last reported by Apple
+	// Business Manager.
+	ProfileStatus     string     `json:"profile_status" db:"profile_status"`
+	ProfileUUID       string     `json:"profile_uuid" db:"profile_uuid"`
+	ProfileAssignTime *time.Time `json:"profile_assign_time" db:"profile_assign_time"`
+	ProfilePushTime   *time.Time `json:"profile_push_time" db:"profile_push_time"`
+}
+
+// HostMDMAppleDEPProfileOverride is the DEP profile assigned to a single host
+// instead of the default profile, e.g. for kiosk devices that need different
+// skip_setup_items than the rest of their team. It is defined in Apple
+// Business Manager and assigned to the host's serial number by the DEP
+// assigner.
+type HostMDMAppleDEPProfileOverride struct {
+	HostID uint `json:"host_id" db:"host_id"`
+	// Profile is the JSON DEP profile, it is nil if the override was cleared
+	// and the default profile is not assigned back yet.
+	Profile *json.RawMessage `json:"profile" db:"profile"`
+	// ProfileUUID is the UUID of the profile in Apple Business Manager, empty
+	// until the profile is defined.
+	ProfileUUID string `json:"profile_uuid" db:"profile_uuid"`
+	// AssignedAt is when the profile was assigned to the host's serial number,
+	// nil if the assignment is pending.
+	AssignedAt *time.Time `json:"assigned_at" db:"assigned_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+
+	// HardwareSerial and TeamName are the serial number and team of the host,
+	// only filled in by ListPendingHostMDMAppleDEPProfileOverrides.
+	HardwareSerial string  `json:"-" db:"hardware_serial"`
+	TeamName       *string `json:"-" db:"team_name"`
+}
+
+// MDMAppleDEPHostReportItem is a host with its Apple Business Manager device
+// information, as returned by the DEP hosts report.
+type MDMAppleDEPHostReportItem struct {
+	ID             uint   `json:"host_id" db:"id"`
+	DisplayName    string `json:"display_name" db:"display_name"`
+	HardwareSerial string `json:"hardware_serial" db:"hardware_serial"`
+	HardwareModel  string `json:"hardware_model" db:"hardware_model"`
+	TeamID         *uint  `json:"team_id" db:"team_id"`
+	HostMDMAppleDEPDevice
+}
+
+// MDMAppleDEPAssignmentDiscrepancyReason is the reason why the Apple Business
+// Manager assignment of a device doesn't match the default MDM server
+// assignments expected by Fleet.
+type MDMAppleDEPAssignmentDiscrepancyReason string
+
+const (
+	// MDMAppleDEPAssignmentDiscrepancyUnassigned is the reason of a device
+	// that was removed from Fleet's MDM server in Apple Business Manager
+	// without being released by Fleet, e.g. because it was assigned to another
+	// MDM server.
+	MDMAppleDEPAssignmentDiscrepancyUnassigned MDMAppleDEPAssignmentDiscrepancyReason = "unassigned"
+	// MDMAppleDEPAssignmentDiscrepancyUnexpectedDeviceFamily is the reason of
+	// a device assigned to Fleet's MDM server whose device family is not one
+	// of the families for which Fleet is the default MDM server.
+	MDMAppleDEPAssignmentDiscrepancyUnexpectedDeviceFamily MDMAppleDEPAssignmentDiscrepancyReason = "unexpected_device_family"
+)
+
+// MDMAppleDEPAssignmentDiscrepancy is a host whose Apple Business Manager
+// assignment doesn't match the default MDM server assignments expected by
+// Fleet (see MDM.AppleBMDefaultDeviceFamilies).
+type MDMAppleDEPAssignmentDiscrepancy struct {
+	HostID         uint                                   `json:"host_id" db:"host_id"`
+	DisplayName    string                                 `json:"display_name" db:"display_name"`
+	HardwareSerial string                                 `json:"hardware_serial" db:"hardware_serial"`
+	HardwareModel  string                                 `json:"hardware_model" db:"hardware_model"`
+	TeamID         *uint                                  `json:"team_id" db:"team_id"`
+	DeviceFamily   string                                 `json:"device_family" db:"device_family"`
+	Reason         MDMAppleDEPAssignmentDiscrepancyReason `json:"reason" db:"reason"`
+	// UnassignedAt is when the DEP sync reported that the device was removed
+	// from Fleet's MDM server, nil unless the reason is unassigned.
+	UnassignedAt *time.Time `json:"unassigned_at" db:"unassigned_at"`
+}
+
+// HostMDMApplePushFailure reports the failures of the push notifications sent
+// to the current push token of a host's MDM enrollment.
+type HostMDMApplePushFailure struct {
+	// FailureCount is the number of consecutive failed pushes.
+	FailureCount uint   `json:"failure_count" db:"failure_count"`
+	LastError    string `json:"last_error" db:"last_error"`
+	// TokenInvalid is true if APNs reported that the push token is not valid
+	// anymore, in which case no pushes are sent to the host until it sends a
+	// new token.
+	TokenInvalid bool      `json:"token_invalid" db:"token_invalid"`
+	LastFailedAt time.Time `json:"last_failed_at" db:"last_failed_at"`
 }
 
 type DiskEncryptionStatus string
@@ -538,6 +715,8 @@ type HostDetail struct {
 	// but when unset, it doesn't get marshaled (e.g. we don't return that
 	// information for the List Hosts endpoint).
 	Batteries *[]*HostBattery `json:"batteries,omitempty"`
+	// AssignedUser is the user the host is assigned to, if any.
+	AssignedUser *HostAssignedUser `json:"assigned_user"`
 }
 
 const (
@@ -559,6 +738,28 @@ type HostSummary struct {
 	LowDiskSpaceCount  *uint                  `json:"low_disk_space_count,omitempty" db:"low_disk_space"`
 	BuiltinLabels      []*LabelSummary        `json:"builtin_labels" db:"-"`
 	Platforms          []*HostSummaryPlatform `json:"platforms" db:"-"`
+	MDM                *HostSummaryMDM        `json:"mdm,omitempty" db:"-"`
+}
+
+// HostSummaryMDM represents the MDM statistics of the hosts, as returned
+// inside the HostSummary struct by the GetHostSummary service.
+type HostSummaryMDM struct {
+	EnrolledCount          uint `json:"enrolled_hosts_count" db:"enrolled"`
+	EnrolledManualCount    uint `json:"enrolled_manual_hosts_count" db:"enrolled_manual"`
+	EnrolledAutomatedCount uint `json:"enrolled_automated_hosts_count" db:"enrolled_automated"`
+	// PendingCount is the number of hosts assigned to Fleet in Apple Business
+	// Manager that didn't enroll yet.
+	PendingCount    uint `json:"pending_hosts_count" db:"pending"`
+	UnenrolledCount uint `json:"unenrolled_hosts_count" db:"unenrolled"`
+	// ProfilesFailedCount is the number of hosts with at least one
+	// configuration profile that failed to install or to be removed.
+	ProfilesFailedCount uint `json:"profiles_failed_hosts_count" db:"profiles_failed"`
+	// DiskEncryptionEnabledCount is the number of macOS hosts that report
+	// FileVault as enabled.
+	DiskEncryptionEnabledCount uint `json:"disk_encryption_enabled_hosts_count" db:"disk_encryption_enabled"`
+	// DiskEncryptionKeyEscrowedCount is the number of hosts with a
+	// disk encryption key escrowed in Fleet that could be decrypted.
+	DiskEncryptionKeyEscrowedCount uint `json:"disk_encryption_key_escrowed_hosts_count" db:"disk_encryption_key_escrowed"`
 }
 
 // HostSummaryPlatform represents the hosts statistics for a given platform,
@@ -795,6 +996,114 @@ type HostBattery struct {
 	Health       string `json:"health" db:"health"`
 }
 
+// Sources of the values of the host custom attributes.
+const (
+	HostCustomAttributeSourceAPI = "api"
+	HostCustomAttributeSourceIdP = "idp"
+	HostCustomAttributeSourceDEP = "dep"
+)
+
+// Names of the host custom attributes set by the MDM workflows.
+const (
+	// HostCustomAttributeIdPUsername is set to the username of the end user
+	// that authenticated with the IdP during the MDM enrollment.
+	HostCustomAttributeIdPUsername = "idp_username"
+	// HostCustomAttributeIdPFullName is set to the full name of the end user
+	// that authenticated with the IdP during the MDM enrollment.
+	HostCustomAttributeIdPFullName = "idp_full_name"
+	// HostCustomAttributeDEPAssignedBy is set to the Apple Business Manager
+	// user that assigned the device to Fleet.
+	HostCustomAttributeDEPAssignedBy = "dep_assigned_by"
+	// HostCustomAttributeDEPAssetTag is set to the asset tag of the device in
+	// Apple Business Manager.
+	HostCustomAttributeDEPAssetTag = "dep_asset_tag"
+)
+
+// HostCustomAttributeMaxValueLength is the maximum length of the value of a
+// host custom attribute.
+const HostCustomAttributeMaxValueLength = 1024
+
+var hostCustomAttributeNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// HostCustomAttribute is a key/value attribute of a host, set via the API or
+// by the MDM workflows (e.g. during the end user authentication or the DEP
+// sync), that can be used to filter the hosts and in the configuration
+// profiles.
+type HostCustomAttribute struct {
+	Name      string    `json:"name" db:"name"`
+	Value     string    `json:"value" db:"value"`
+	Source    string    `json:"source" db:"source"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// ValidateHostCustomAttribute returns an error if the name or the value of
+// the host custom attribute is invalid. Names must start with a lowercase
+// letter and contain only lowercase letters, digits and underscores.
+func ValidateHostCustomAttribute(name, value string) error {
+	if !hostCustomAttributeNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid custom attribute name %q: must start with a lowercase letter and contain only lowercase letters, digits and underscores (max 64 characters)", name)
+	}
+	if len(value) > HostCustomAttributeMaxValueLength {
+		return fmt.Errorf("invalid value for custom attribute %q: must be at most %d characters", name, HostCustomAttributeMaxValueLength)
+	}
+	return nil
+}
+
+// Sources of the host assigned user.
+const (
+	// HostAssignedUserSourceSSO is used when the user authenticated with the
+	// IdP during the MDM enrollment of the host.
+	HostAssignedUserSourceSSO = "sso"
+	// HostAssignedUserSourceAPI is used when the user was assigned via the
+	// REST API.
+	HostAssignedUserSourceAPI = "api"
+	// HostAssignedUserSourceIdPSync is used when the user was synced from the
+	// IdP (e.g. via a SCIM integration calling the batch API).
+	HostAssignedUserSourceIdPSync = "idp_sync"
+)
+
+// HostAssignedUserMaxFullNameLength is the maximum length of the full name of
+// a host assigned user.
+const HostAssignedUserMaxFullNameLength = 255
+
+// HostAssignedUser is the end user (owner) a host is assigned to. A host has
+// at most one assigned user, it is displayed in the host details, can be
+// used to search hosts and in the configuration profiles.
+type HostAssignedUser struct {
+	HostID    uint      `json:"-" db:"host_id"`
+	Email     string    `json:"email" db:"email"`
+	FullName  string    `json:"full_name" db:"full_name"`
+	Source    string    `json:"source" db:"source"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// HostAssignedUserSync is an entry of the batch of host assigned users synced
+// from the IdP. The host is identified by its UUID, osquery host ID, node key
+// or hostname.
+type HostAssignedUserSync struct {
+	HostIdentifier string `json:"host_identifier"`
+	Email          string `json:"email"`
+	FullName       string `json:"full_name"`
+}
+
+// ValidateHostAssignedUser returns an error if the email or the full name of
+// the host assigned user is invalid.
+func ValidateHostAssignedUser(email, fullName string) error {
+	if email == "" {
+		return errors.New("email is required")
+	}
+	if err := ValidateEmail(email); err != nil {
+		return fmt.Errorf("invalid email %q", email)
+	}
+	if len(email) > 255 {
+		return errors.New("email must be at most 255 characters")
+	}
+	if len(fullName) > HostAssignedUserMaxFullNameLength {
+		return fmt.Errorf("full name must be at most %d characters", HostAssignedUserMaxFullNameLength)
+	}
+	return nil
+}
+
 type MacadminsData struct {
 	Munki       *HostMunkiInfo    `json:"munki"`
 	MDM         *HostMDM          `json:"mobile_device_management"`
@@ -909,4 +1218,41 @@ type HostDiskEncryptionKey struct {
 	Decryptable     *bool     `json:"-" db:"decryptable"`
 	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
 	DecryptedValue  string    `json:"key" db:"-"`
+	// ExpiresAt is the time after which the decrypted key must not be
+	// displayed anymore. It is only set when the key is decrypted.
+	ExpiresAt *time.Time `json:"expires_at,omitempty" db:"-"`
+}
+
+// HostDiskEncryptionKeyAccess is a record of a user reading the disk
+// encryption key of a host. Those records are kept independently of the
+// activities, as evidence for compliance purposes.
+type HostDiskEncryptionKeyAccess struct {
+	ID     uint `json:"id" db:"id"`
+	HostID uint `json:"host_id" db:"host_id"`
+	// UserID is the id of the user who read the key, it is nil if the user
+	// was deleted.
+	UserID    *uint  `json:"user_id" db:"user_id"`
+	UserName  string `json:"user_name" db:"user_name"`
+	UserEmail string `json:"user_email" db:"user_email"`
+	// IPAddress is the public IP address from which the key was read.
+	IPAddress string `json:"ip_address" db:"ip_address"`
+	// Justification is the reason provided by the user when reading the key.
+	Justification string    `json:"justification" db:"justification"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+// HostQuarantine is the record of a host being quarantined, i.e. moved to a
+// quarantine team that restricts its network access, and optionally locked.
+type HostQuarantine struct {
+	ID     uint   `json:"id" db:"id"`
+	HostID uint   `json:"host_id" db:"host_id"`
+	CaseID string `json:"case_id" db:"case_id"`
+	// TeamID is the ID of the quarantine team the host was moved to.
+	TeamID uint `json:"team_id" db:"team_id"`
+	// PreviousTeamID is the ID of the team of the host before it was
+	// quarantined, nil if it had no team.
+	PreviousTeamID *uint `json:"previous_team_id" db:"previous_team_id"`
+	// Locked is true if the host was locked as part of the quarantine.
+	Locked    bool      `json:"locked" db:"locked"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
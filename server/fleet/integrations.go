@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -359,6 +360,21 @@ func ValidateEnabledHostStatusIntegrations(webhook HostStatusWebhookSettings, in
 	}
 }
 
+// ValidateEnabledMDMEventsIntegrations checks that the MDM events webhook has
+// a valid destination URL if it is enabled. It adds any error it finds to the
+// invalid argument error.
+func ValidateEnabledMDMEventsIntegrations(webhook MDMEventsWebhookSettings, invalid *InvalidArgumentError) {
+	if webhook.Enable {
+		if webhook.DestinationURL == "" {
+			invalid.Append("destination_url", "destination_url is required to enable the MDM events webhook")
+			return
+		}
+		if u, err := url.ParseRequestURI(webhook.DestinationURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid.Append("destination_url", "destination_url must be a valid http or https URL")
+		}
+	}
+}
+
 // ValidateEnabledVulnerabilitiesIntegrations checks that a single integration
 // is enabled for vulnerabilities. It adds any error it finds to the invalid
 // argument error, that can then be checked after the call for errors using
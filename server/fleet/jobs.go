@@ -30,4 +30,10 @@ type Job struct {
 	Retries   int              `json:"retries" db:"retries"`
 	Error     string           `json:"error" db:"error"`
 	NotBefore time.Time        `json:"not_before" db:"not_before"`
+
+	// HostsDone and HostsCount are the progress of the jobs that process
+	// hosts in batches, they are nil for the other jobs and until the hosts
+	// to process are known.
+	HostsDone  *int `json:"hosts_done,omitempty" db:"hosts_done"`
+	HostsCount *int `json:"hosts_count,omitempty" db:"hosts_count"`
 }
@@ -2,11 +2,25 @@ package fleet
 
 import (
 	"context"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// List of the MDM protocols supported by Fleet, as reported in the details
+// of the MDM activities.
+const (
+	MDMPlatformApple     = "apple"
+	MDMPlatformMicrosoft = "microsoft"
+)
+
 type AppleMDM struct {
 	CommonName   string    `json:"common_name"`
 	SerialNumber string    `json:"serial_number"`
@@ -63,6 +77,8 @@ type MDMIdPAccount struct {
 	SaltedSHA512PBKDF2Dictionary
 	UUID     string
 	Username string
+	FullName string
+	Groups   []string
 }
 
 type MDMAppleBootstrapPackage struct {
@@ -73,8 +89,69 @@ type MDMAppleBootstrapPackage struct {
 	Token     string    `json:"token"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"-" db:"updated_at"`
+	// UploadID is set for packages uploaded via a resumable upload session, in
+	// which case Bytes is empty and the package contents are stored in the
+	// chunks of that upload.
+	UploadID *uint `json:"-" db:"upload_id"`
+	// Size is the size in bytes of the package contents stored in the chunks
+	// of the upload, only set when UploadID is set.
+	Size int64 `json:"-" db:"size"`
+	// Content streams the package contents when they are not loaded in Bytes.
+	// It must be closed by the caller.
+	Content io.ReadCloser `json:"-" db:"-"`
+	// CDNURL is the signed URL of the package on the MDM assets CDN, set
+	// instead of Content when the CDN is enabled.
+	CDNURL string `json:"-" db:"-"`
+	// SignatureStatus is the result of the last verification of the
+	// package's signing certificate chain, empty if it wasn't verified yet.
+	SignatureStatus MDMAppleBootstrapPackageSignatureStatus `json:"signature_status" db:"signature_status"`
+	// SignatureDetail explains why the signing chain was found invalid, or
+	// why its revocation status could not be checked.
+	SignatureDetail *string `json:"signature_detail" db:"signature_detail"`
+	// SignatureVerifiedAt is the time of the last verification of the
+	// package's signing certificate chain.
+	SignatureVerifiedAt *time.Time `json:"signature_verified_at" db:"signature_verified_at"`
+}
+
+// MDMAppleBootstrapPackageSignatureStatus is the status of the signing
+// certificate chain of a bootstrap package.
+type MDMAppleBootstrapPackageSignatureStatus string
+
+const (
+	// MDMAppleBootstrapPackageSignatureUnverified is the status of a package
+	// whose signing chain wasn't verified yet.
+	MDMAppleBootstrapPackageSignatureUnverified MDMAppleBootstrapPackageSignatureStatus = ""
+	// MDMAppleBootstrapPackageSignatureValid is the status of a package whose
+	// signing chain was valid and not revoked when last verified.
+	MDMAppleBootstrapPackageSignatureValid MDMAppleBootstrapPackageSignatureStatus = "valid"
+	// MDMAppleBootstrapPackageSignatureInvalid is the status of a package that
+	// isn't signed or whose signing chain has expired or was revoked.
+	MDMAppleBootstrapPackageSignatureInvalid MDMAppleBootstrapPackageSignatureStatus = "invalid"
+)
+
+// MDMAppleBootstrapPackageSignaturesSummary summarizes the status of the
+// signing chains of the bootstrap packages of all teams.
+type MDMAppleBootstrapPackageSignaturesSummary struct {
+	Valid      uint `json:"valid"`
+	Invalid    uint `json:"invalid"`
+	Unverified uint `json:"unverified"`
+	// Packages are the metadata of the bootstrap packages of all teams.
+	Packages []*MDMAppleBootstrapPackage `json:"packages"`
 }
 
+// MDMAssetKind identifies the kind of an MDM asset stored in an
+// MDMAssetStore.
+type MDMAssetKind string
+
+const (
+	MDMAssetBootstrapPackage MDMAssetKind = "bootstrap_packages"
+	MDMAssetEULA             MDMAssetKind = "eulas"
+	// MDMAssetEnrollmentProfile is the manual enrollment profile, only stored
+	// to be served by the MDM assets CDN. Its token is the hex-encoded SHA-256
+	// of its contents.
+	MDMAssetEnrollmentProfile MDMAssetKind = "enrollment_profiles"
+)
+
 func (bp MDMAppleBootstrapPackage) AuthzType() string {
 	return "mdm_apple_bootstrap_package"
 }
@@ -89,14 +166,273 @@ func (bp *MDMAppleBootstrapPackage) URL(host string) (string, error) {
 	return pkgURL.String(), nil
 }
 
+// MDMAppleBootstrapPackageUpload represents a resumable upload session for a
+// bootstrap package. Packages can be several GBs in size, so instead of
+// sending them in a single multipart request, clients can initiate an upload
+// session and send the package in chunks, resuming from the last received
+// offset if a request fails.
+type MDMAppleBootstrapPackageUpload struct {
+	ID     uint   `json:"-" db:"id"`
+	Token  string `json:"token" db:"token"`
+	TeamID uint   `json:"team_id" db:"team_id"`
+	Name   string `json:"name" db:"name"`
+	// Size is the total size in bytes of the package, as announced by the
+	// client when the session was initiated.
+	Size int64 `json:"size" db:"size"`
+	// Sha256 is the expected checksum of the package, as announced by the
+	// client when the session was initiated. It is verified when the upload
+	// is committed.
+	Sha256 []byte `json:"-" db:"sha256"`
+	// Offset is the number of bytes received so far, it is the offset at
+	// which the next chunk must be appended.
+	Offset int64 `json:"offset" db:"received_bytes"`
+	// Chunks is the number of chunks received so far.
+	Chunks    int       `json:"-" db:"chunks"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// MDMAppleBootstrapPackageUploadOffsetError is returned when a chunk is
+// appended to a bootstrap package upload session at an offset that does not
+// match the number of bytes already received.
+type MDMAppleBootstrapPackageUploadOffsetError struct {
+	Expected int64
+	Got      int64
+}
+
+func (e MDMAppleBootstrapPackageUploadOffsetError) Error() string {
+	return fmt.Sprintf("invalid upload offset %d, expected %d", e.Got, e.Expected)
+}
+
+// IsConflict implements the conflict error interface so that the error is
+// returned as a 409 to the client, which can then resume the upload at the
+// expected offset.
+func (e MDMAppleBootstrapPackageUploadOffsetError) IsConflict() bool {
+	return true
+}
+
 // MDMAppleEULA represents an EULA (End User License Agreement) file.
 type MDMAppleEULA struct {
 	Name      string    `json:"name"`
 	Bytes     []byte    `json:"bytes"`
 	Token     string    `json:"token"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// Sha256 is the checksum of the EULA contents, it is nil for EULAs
+	// uploaded before it was recorded.
+	Sha256 []byte `json:"sha256,omitempty" db:"sha256"`
+	// Size is the size in bytes of the EULA, only set along with Content.
+	Size int64 `json:"-" db:"-"`
+	// Content streams the EULA contents when they are not loaded in Bytes.
+	// It must be closed by the caller.
+	Content io.ReadCloser `json:"-" db:"-"`
+	// CDNURL is the signed URL of the EULA on the MDM assets CDN, set instead
+	// of Content when the CDN is enabled.
+	CDNURL string `json:"-" db:"-"`
 }
 
 func (e MDMAppleEULA) AuthzType() string {
 	return "mdm_apple"
 }
+
+// MDMPostureScoreHistoryRetention is how long the daily MDM posture scores
+// are kept.
+const MDMPostureScoreHistoryRetention = 365 * 24 * time.Hour
+
+// MDMPostureScoreComponentName identifies a component of the MDM posture
+// score.
+type MDMPostureScoreComponentName string
+
+const (
+	// MDMPostureScoreDiskEncryption measures the hosts with a verified
+	// disk encryption key among those on which disk encryption is enforced.
+	MDMPostureScoreDiskEncryption MDMPostureScoreComponentName = "disk_encryption"
+	// MDMPostureScoreProfiles measures the hosts that didn't fail to install
+	// a configuration profile among those with configuration profiles.
+	MDMPostureScoreProfiles MDMPostureScoreComponentName = "profiles"
+	// MDMPostureScoreEnrollment measures the macOS hosts enrolled in Fleet's
+	// MDM.
+	MDMPostureScoreEnrollment MDMPostureScoreComponentName = "enrollment"
+	// MDMPostureScoreOSCurrency measures the macOS hosts compliant with the
+	// macOS updates settings. It is not measured if no minimum version and
+	// deadline are set.
+	MDMPostureScoreOSCurrency MDMPostureScoreComponentName = "os_currency"
+)
+
+// MDMPostureScoreComponent is the measure of a component of the MDM posture
+// score.
+type MDMPostureScoreComponent struct {
+	Name   MDMPostureScoreComponentName `json:"name"`
+	Weight int                          `json:"weight"`
+	// Hosts is the number of hosts measured by the component.
+	Hosts uint `json:"hosts"`
+	// CompliantHosts is the number of measured hosts that comply.
+	CompliantHosts uint `json:"compliant_hosts"`
+	// Score is the percentage of compliant hosts, nil if no host was measured.
+	Score *float64 `json:"score"`
+}
+
+// MDMPostureScoreComponents are the components of an MDM posture score, it
+// is stored as JSON.
+type MDMPostureScoreComponents []MDMPostureScoreComponent
+
+// Score sets the score of each component from its host counts, and returns
+// the average of the scores of the measured components weighted by their
+// weight. It returns nil if no component with a weight was measured.
+func (cs MDMPostureScoreComponents) Score() *float64 {
+	var sum float64
+	var weights int
+	for i := range cs {
+		c := &cs[i]
+		c.Score = nil
+		if c.Hosts == 0 {
+			continue
+		}
+		score := roundMDMPostureScore(100 * float64(c.CompliantHosts) / float64(c.Hosts))
+		c.Score = &score
+		if c.Weight > 0 {
+			sum += score * float64(c.Weight)
+			weights += c.Weight
+		}
+	}
+	if weights == 0 {
+		return nil
+	}
+	score := roundMDMPostureScore(sum / float64(weights))
+	return &score
+}
+
+// roundMDMPostureScore rounds the score to one decimal.
+func roundMDMPostureScore(score float64) float64 {
+	return math.Round(score*10) / 10
+}
+
+// Scan implements the sql.Scanner interface
+func (cs *MDMPostureScoreComponents) Scan(val interface{}) error {
+	switch v := val.(type) {
+	case []byte:
+		return json.Unmarshal(v, cs)
+	case string:
+		return json.Unmarshal([]byte(v), cs)
+	case nil: // sql NULL
+		return nil
+	default:
+		return fmt.Errorf("unsupported type: %T", v)
+	}
+}
+
+// Value implements the sql.Valuer interface
+func (cs MDMPostureScoreComponents) Value() (driver.Value, error) {
+	if len(cs) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(cs)
+}
+
+// MDMPostureScore is the MDM posture score of a team on a given day, it is
+// updated during the day as the hosts change.
+type MDMPostureScore struct {
+	// TeamID is the team of the scored hosts, 0 for the hosts in no team. It
+	// is nil for the score of all hosts.
+	TeamID *uint `json:"team_id" db:"team_id"`
+	// Date is the day of the score, in UTC.
+	Date time.Time `json:"date" db:"score_date"`
+	// Score is between 0 and 100, nil if no component was measured.
+	Score      *float64                  `json:"score" db:"score"`
+	Components MDMPostureScoreComponents `json:"components" db:"components"`
+	UpdatedAt  time.Time                 `json:"updated_at" db:"updated_at"`
+}
+
+// MDMPostureScoreHostCounts are the counts of the macOS hosts of a team from
+// which the enrollment component of its MDM posture score is measured.
+type MDMPostureScoreHostCounts struct {
+	// MacOSHosts is the number of macOS hosts.
+	MacOSHosts uint `db:"macos_hosts"`
+	// EnrolledHosts is the number of macOS hosts enrolled in Fleet's MDM.
+	EnrolledHosts uint `db:"enrolled_hosts"`
+}
+
+// DefaultMDMSSOErrorMessage is the message of the page displayed when the end
+// user fails to authenticate before enrolling, if it isn't translated.
+const DefaultMDMSSOErrorMessage = "We couldn't verify your identity. Please contact your IT admin."
+
+// LocalizeMDMEndUserStrings returns the strings to render to an end user that
+// accepts the languages of acceptLanguage, the value of an Accept-Language
+// header. The localizations are searched in order (e.g. the team's then the
+// global one), first for each accepted language and its base language, then
+// for their default language. Each string falls back separately, so that a
+// partial translation is completed by the next language. The strings that
+// aren't translated in any of those languages are empty, the caller renders
+// its English default.
+func LocalizeMDMEndUserStrings(acceptLanguage string, localizations ...MDMLocalization) MDMEndUserStrings {
+	var languages []string
+	for _, lang := range parseAcceptLanguage(acceptLanguage) {
+		languages = append(languages, lang)
+		if base, _, ok := strings.Cut(lang, "-"); ok {
+			languages = append(languages, base)
+		}
+	}
+	for _, l := range localizations {
+		if l.DefaultLanguage != "" {
+			languages = append(languages, l.DefaultLanguage)
+		}
+	}
+
+	fill := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	var res MDMEndUserStrings
+	for _, lang := range languages {
+		for _, l := range localizations {
+			for _, t := range l.Translations {
+				if !strings.EqualFold(t.Language, lang) {
+					continue
+				}
+				fill(&res.Language, t.Language)
+				fill(&res.EnrollmentProfileDisplayName, t.EnrollmentProfileDisplayName)
+				fill(&res.EnrollmentProfileDescription, t.EnrollmentProfileDescription)
+				fill(&res.SSOErrorMessage, t.SSOErrorMessage)
+				fill(&res.MigrationMessage, t.MigrationMessage)
+			}
+		}
+	}
+	return res
+}
+
+// parseAcceptLanguage returns the language tags of an Accept-Language header,
+// by decreasing quality. The wildcard and the languages with a zero quality
+// are ignored.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		lang    string
+		quality float64
+	}
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		lang, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang = strings.TrimSpace(lang)
+		if lang == "" || lang == "*" {
+			continue
+		}
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			v, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = v
+		}
+		if quality <= 0 {
+			continue
+		}
+		langs = append(langs, weighted{lang: lang, quality: quality})
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].quality > langs[j].quality })
+
+	res := make([]string, 0, len(langs))
+	for _, l := range langs {
+		res = append(res, l.lang)
+	}
+	return res
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
@@ -179,3 +180,84 @@ func TestMDMAppleBootstrapPackage(t *testing.T) {
 	require.Empty(t, url)
 	require.Error(t, err)
 }
+
+func TestMDMLocalizationValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		loc     fleet.MDMLocalization
+		wantErr string
+	}{
+		{"empty", fleet.MDMLocalization{}, ""},
+		{"valid", fleet.MDMLocalization{DefaultLanguage: "pt-BR", Translations: []fleet.MDMEndUserStrings{{Language: "pt-BR"}, {Language: "fr"}}}, ""},
+		{"invalid default language", fleet.MDMLocalization{DefaultLanguage: "portuguese"}, "invalid default_language"},
+		{"missing language", fleet.MDMLocalization{Translations: []fleet.MDMEndUserStrings{{SSOErrorMessage: "x"}}}, "invalid language"},
+		{"duplicate language", fleet.MDMLocalization{Translations: []fleet.MDMEndUserStrings{{Language: "fr"}, {Language: "FR"}}}, "duplicate translations"},
+		{"too long", fleet.MDMLocalization{Translations: []fleet.MDMEndUserStrings{{Language: "fr", MigrationMessage: strings.Repeat("a", fleet.MDMEndUserStringMaxLength+1)}}}, "at most"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.loc.Validate()
+			if c.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestLocalizeMDMEndUserStrings(t *testing.T) {
+	team := fleet.MDMLocalization{
+		Translations: []fleet.MDMEndUserStrings{
+			{Language: "fr", EnrollmentProfileDisplayName: "Inscription de l'équipe"},
+		},
+	}
+	global := fleet.MDMLocalization{
+		DefaultLanguage: "es",
+		Translations: []fleet.MDMEndUserStrings{
+			{Language: "fr", EnrollmentProfileDisplayName: "Inscription", SSOErrorMessage: "Erreur"},
+			{Language: "fr-CA", SSOErrorMessage: "Erreur (Canada)"},
+			{Language: "es", EnrollmentProfileDisplayName: "Inscripción", MigrationMessage: "Migrar"},
+		},
+	}
+
+	cases := []struct {
+		name           string
+		acceptLanguage string
+		want           fleet.MDMEndUserStrings
+	}{
+		{
+			name:           "no accepted language uses the default language",
+			acceptLanguage: "",
+			want:           fleet.MDMEndUserStrings{Language: "es", EnrollmentProfileDisplayName: "Inscripción", MigrationMessage: "Migrar"},
+		},
+		{
+			name:           "team translation takes precedence, missing strings fall back",
+			acceptLanguage: "fr",
+			want:           fleet.MDMEndUserStrings{Language: "fr", EnrollmentProfileDisplayName: "Inscription de l'équipe", SSOErrorMessage: "Erreur", MigrationMessage: "Migrar"},
+		},
+		{
+			name:           "region falls back to the base language",
+			acceptLanguage: "fr-CA,en;q=0.5",
+			want:           fleet.MDMEndUserStrings{Language: "fr-CA", EnrollmentProfileDisplayName: "Inscription de l'équipe", SSOErrorMessage: "Erreur (Canada)", MigrationMessage: "Migrar"},
+		},
+		{
+			name:           "languages are sorted by quality",
+			acceptLanguage: "de, fr;q=0.2, es;q=0.8",
+			want:           fleet.MDMEndUserStrings{Language: "es", EnrollmentProfileDisplayName: "Inscripción", SSOErrorMessage: "Erreur", MigrationMessage: "Migrar"},
+		},
+		{
+			name:           "zero quality is ignored",
+			acceptLanguage: "fr;q=0, *",
+			want:           fleet.MDMEndUserStrings{Language: "es", EnrollmentProfileDisplayName: "Inscripción", MigrationMessage: "Migrar"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.want, fleet.LocalizeMDMEndUserStrings(c.acceptLanguage, team, global))
+		})
+	}
+
+	// without any translation, the strings are empty
+	require.Equal(t, fleet.MDMEndUserStrings{}, fleet.LocalizeMDMEndUserStrings("fr"))
+}
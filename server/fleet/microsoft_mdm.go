@@ -0,0 +1,402 @@
+package fleet
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// MDMWindowsEnrolledDevice is a Windows device enrolled in Fleet's MDM via the
+// MS-MDE2 enrollment protocol.
+type MDMWindowsEnrolledDevice struct {
+	ID uint `db:"id"`
+	// MDMDeviceID is the identifier of the device in the MDM protocols (the
+	// DeviceID context item of the enrollment request), it is the subject of
+	// its identity certificate and the source of its management sessions.
+	MDMDeviceID string `db:"mdm_device_id"`
+	// MDMHardwareID is the hardware identifier of the device (the HWDevID
+	// context item of the enrollment request).
+	MDMHardwareID string `db:"mdm_hardware_id"`
+	// MDMDeviceState is the enrollment state of the device, one of the
+	// MDMDeviceState* constants.
+	MDMDeviceState         string `db:"device_state"`
+	MDMDeviceType          string `db:"device_type"`
+	MDMDeviceName          string `db:"device_name"`
+	MDMEnrollType          string `db:"enroll_type"`
+	MDMEnrollUserID        string `db:"enroll_user_id"`
+	MDMEnrollProtoVersion  string `db:"enroll_proto_version"`
+	MDMEnrollClientVersion string `db:"enroll_client_version"`
+	MDMNotInOOBE           bool   `db:"not_in_oobe"`
+	// MDMIdentityCertSerial is the serial number, in hexadecimal, of the
+	// identity certificate issued to the device.
+	MDMIdentityCertSerial string `db:"identity_cert_serial"`
+	// TeamID is the team of the enroll secret used to enroll the device, nil
+	// for a global enroll secret.
+	TeamID *uint `db:"team_id"`
+	// HostUUID is the UUID of the host linked to the device, empty until the
+	// osquery agent of the host reports the MDM device ID.
+	HostUUID  string    `db:"host_uuid"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// List of the enrollment states of a Windows device.
+const (
+	MDMDeviceStateEnrolled = "MDMDeviceEnrolled"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// MS-MDE2 messages (discovery, policy and enrollment)
+//
+// The request types are used to decode the messages sent by the devices, so
+// their XML names don't include the namespaces. The response types are
+// encoded with explicit namespace prefixes as documented in the MS-MDE2
+// specification, as the Windows enrollment client is strict about them.
+
+// SoapRequest is a SOAP 1.2 request sent by a Windows device during the
+// enrollment.
+type SoapRequest struct {
+	XMLName xml.Name          `xml:"Envelope"`
+	Header  SoapRequestHeader `xml:"Header"`
+	Body    SoapRequestBody   `xml:"Body"`
+}
+
+// SoapRequestHeader is the header of a SOAP request.
+type SoapRequestHeader struct {
+	Action    string      `xml:"Action"`
+	MessageID string      `xml:"MessageID"`
+	To        string      `xml:"To"`
+	Security  *WSSecurity `xml:"Security"`
+}
+
+// WSSecurity holds the credentials of the user that enrolls the device with
+// the OnPremise authentication policy.
+type WSSecurity struct {
+	Username string `xml:"UsernameToken>Username"`
+	Password string `xml:"UsernameToken>Password"`
+}
+
+// SoapRequestBody is the body of a SOAP request, only one of the messages
+// is set.
+type SoapRequestBody struct {
+	Discover             *Discover             `xml:"Discover"`
+	GetPolicies          *GetPolicies          `xml:"GetPolicies"`
+	RequestSecurityToken *RequestSecurityToken `xml:"RequestSecurityToken"`
+}
+
+// Discover is the message sent to the discovery service.
+type Discover struct {
+	EmailAddress       string   `xml:"request>EmailAddress"`
+	RequestVersion     string   `xml:"request>RequestVersion"`
+	DeviceType         string   `xml:"request>DeviceType"`
+	ApplicationVersion string   `xml:"request>ApplicationVersion"`
+	OSEdition          string   `xml:"request>OSEdition"`
+	AuthPolicies       []string `xml:"request>AuthPolicies>AuthPolicy"`
+}
+
+// GetPolicies is the message sent to the enrollment policy service (MS-XCEP).
+type GetPolicies struct{}
+
+// RequestSecurityToken is the message sent to the enrollment service
+// (MS-WSTEP), it holds the certificate signing request of the device.
+type RequestSecurityToken struct {
+	TokenType           string              `xml:"TokenType"`
+	RequestType         string              `xml:"RequestType"`
+	BinarySecurityToken BinarySecurityToken `xml:"BinarySecurityToken"`
+	ContextItems        []ContextItem       `xml:"AdditionalContext>ContextItem"`
+}
+
+// ContextItem returns the value of the context item with the given name, or
+// an empty string if the request doesn't have it.
+func (r *RequestSecurityToken) ContextItem(name string) string {
+	for _, item := range r.ContextItems {
+		if item.Name == name {
+			return item.Value
+		}
+	}
+	return ""
+}
+
+// BinarySecurityToken is a base64-encoded security token, the certificate
+// signing request of the device in a request, the provisioning document in a
+// response.
+type BinarySecurityToken struct {
+	XMLNS        string `xml:"xmlns,attr,omitempty"`
+	ValueType    string `xml:"ValueType,attr"`
+	EncodingType string `xml:"EncodingType,attr"`
+	Value        string `xml:",chardata"`
+}
+
+// ContextItem is an item of the additional context of an enrollment request.
+type ContextItem struct {
+	Name  string `xml:"Name,attr"`
+	Value string `xml:"Value"`
+}
+
+// SoapResponse is a SOAP 1.2 response sent to a Windows device during the
+// enrollment.
+type SoapResponse struct {
+	XMLName xml.Name           `xml:"s:Envelope"`
+	XMLNSS  string             `xml:"xmlns:s,attr"`
+	XMLNSA  string             `xml:"xmlns:a,attr"`
+	XMLNSU  string             `xml:"xmlns:u,attr,omitempty"`
+	Header  SoapResponseHeader `xml:"s:Header"`
+	Body    SoapResponseBody   `xml:"s:Body"`
+}
+
+// SoapResponseHeader is the header of a SOAP response.
+type SoapResponseHeader struct {
+	Action    SoapAction             `xml:"a:Action"`
+	RelatesTo string                 `xml:"a:RelatesTo,omitempty"`
+	Security  *WSSecurityTimestamped `xml:"o:Security,omitempty"`
+}
+
+// SoapAction is the action of a SOAP response.
+type SoapAction struct {
+	MustUnderstand string `xml:"s:mustUnderstand,attr"`
+	Value          string `xml:",chardata"`
+}
+
+// WSSecurityTimestamped is the security header of an enrollment response.
+type WSSecurityTimestamped struct {
+	XMLNSO         string `xml:"xmlns:o,attr"`
+	MustUnderstand string `xml:"s:mustUnderstand,attr"`
+	Timestamp      struct {
+		ID      string `xml:"u:Id,attr"`
+		Created string `xml:"u:Created"`
+		Expires string `xml:"u:Expires"`
+	} `xml:"u:Timestamp"`
+}
+
+// SoapResponseBody is the body of a SOAP response, only one of the messages
+// is set.
+type SoapResponseBody struct {
+	XMLNSXSI                               string                                  `xml:"xmlns:xsi,attr,omitempty"`
+	XMLNSXSD                               string                                  `xml:"xmlns:xsd,attr,omitempty"`
+	DiscoverResponse                       *DiscoverResponse                       `xml:"DiscoverResponse,omitempty"`
+	GetPoliciesResponse                    *GetPoliciesResponse                    `xml:"GetPoliciesResponse,omitempty"`
+	RequestSecurityTokenResponseCollection *RequestSecurityTokenResponseCollection `xml:"RequestSecurityTokenResponseCollection,omitempty"`
+	Fault                                  *SoapFault                              `xml:"s:Fault,omitempty"`
+}
+
+// DiscoverResponse is the response of the discovery service, it tells the
+// device how to authenticate and where the policy and enrollment services
+// are.
+type DiscoverResponse struct {
+	XMLNS                      string `xml:"xmlns,attr"`
+	AuthPolicy                 string `xml:"DiscoverResult>AuthPolicy"`
+	EnrollmentVersion          string `xml:"DiscoverResult>EnrollmentVersion"`
+	EnrollmentPolicyServiceURL string `xml:"DiscoverResult>EnrollmentPolicyServiceUrl"`
+	EnrollmentServiceURL       string `xml:"DiscoverResult>EnrollmentServiceUrl"`
+}
+
+// NilElement is an XML element whose value is explicitly nil.
+type NilElement struct {
+	Nil string `xml:"xsi:nil,attr"`
+}
+
+// NewNilElement returns a new nil XML element.
+func NewNilElement() *NilElement {
+	return &NilElement{Nil: "true"}
+}
+
+// GetPoliciesResponse is the response of the enrollment policy service, it
+// tells the device the requirements of the certificate signing request.
+type GetPoliciesResponse struct {
+	XMLNS    string      `xml:"xmlns,attr"`
+	Response Response    `xml:"response"`
+	CAs      *NilElement `xml:"cAs"`
+	OIDs     OIDs        `xml:"oIDs"`
+}
+
+// Response is the response of GetPoliciesResponse.
+type Response struct {
+	PolicyID           string             `xml:"policyID"`
+	PolicyFriendlyName *NilElement        `xml:"policyFriendlyName"`
+	NextUpdateHours    *NilElement        `xml:"nextUpdateHours"`
+	PoliciesNotChanged *NilElement        `xml:"policiesNotChanged"`
+	Policies           []EnrollmentPolicy `xml:"policies>policy"`
+}
+
+// EnrollmentPolicy is a certificate enrollment policy.
+type EnrollmentPolicy struct {
+	PolicyOIDReference int              `xml:"policyOIDReference"`
+	CAs                *NilElement      `xml:"cAs"`
+	Attributes         PolicyAttributes `xml:"attributes"`
+}
+
+// PolicyAttributes are the attributes of a certificate enrollment policy.
+type PolicyAttributes struct {
+	CommonName                string      `xml:"commonName"`
+	PolicySchema              int         `xml:"policySchema"`
+	ValidityPeriodSeconds     int         `xml:"certificateValidity>validityPeriodSeconds"`
+	RenewalPeriodSeconds      int         `xml:"certificateValidity>renewalPeriodSeconds"`
+	Enroll                    bool        `xml:"permission>enroll"`
+	AutoEnroll                bool        `xml:"permission>autoEnroll"`
+	MinimalKeyLength          int         `xml:"privateKeyAttributes>minimalKeyLength"`
+	KeySpec                   *NilElement `xml:"privateKeyAttributes>keySpec"`
+	KeyUsageProperty          *NilElement `xml:"privateKeyAttributes>keyUsageProperty"`
+	Permissions               *NilElement `xml:"privateKeyAttributes>permissions"`
+	AlgorithmOIDReference     *NilElement `xml:"privateKeyAttributes>algorithmOIDReference"`
+	CryptoProviders           *NilElement `xml:"privateKeyAttributes>cryptoProviders"`
+	MajorRevision             int         `xml:"revision>majorRevision"`
+	MinorRevision             int         `xml:"revision>minorRevision"`
+	SupersededPolicies        *NilElement `xml:"supersededPolicies"`
+	PrivateKeyFlags           *NilElement `xml:"privateKeyFlags"`
+	SubjectNameFlags          *NilElement `xml:"subjectNameFlags"`
+	EnrollmentFlags           *NilElement `xml:"enrollmentFlags"`
+	GeneralFlags              *NilElement `xml:"generalFlags"`
+	HashAlgorithmOIDReference int         `xml:"hashAlgorithmOIDReference"`
+	RARequirements            *NilElement `xml:"rARequirements"`
+	KeyArchivalAttributes     *NilElement `xml:"keyArchivalAttributes"`
+	Extensions                *NilElement `xml:"extensions"`
+}
+
+// OIDs are the object identifiers referenced by the policies.
+type OIDs struct {
+	OID []OID `xml:"oID"`
+}
+
+// OID is an object identifier referenced by the policies.
+type OID struct {
+	Value          string `xml:"value"`
+	Group          int    `xml:"group"`
+	OIDReferenceID int    `xml:"oIDReferenceID"`
+	DefaultName    string `xml:"defaultName"`
+}
+
+// RequestSecurityTokenResponseCollection is the response of the enrollment
+// service, it holds the provisioning document of the device.
+type RequestSecurityTokenResponseCollection struct {
+	XMLNS                        string                       `xml:"xmlns,attr"`
+	RequestSecurityTokenResponse RequestSecurityTokenResponse `xml:"RequestSecurityTokenResponse"`
+}
+
+// RequestSecurityTokenResponse is the response to a RequestSecurityToken.
+type RequestSecurityTokenResponse struct {
+	TokenType              string              `xml:"TokenType"`
+	DispositionMessage     XMLNSValue          `xml:"DispositionMessage"`
+	RequestedSecurityToken BinarySecurityToken `xml:"RequestedSecurityToken>BinarySecurityToken"`
+	RequestID              XMLNSValue          `xml:"RequestID"`
+}
+
+// XMLNSValue is an XML element with a value and a default namespace.
+type XMLNSValue struct {
+	XMLNS string `xml:"xmlns,attr"`
+	Value string `xml:",chardata"`
+}
+
+// SoapFault is the fault returned to the device when its request failed.
+type SoapFault struct {
+	Code    string           `xml:"s:Code>s:Value"`
+	Subcode SoapFaultCode    `xml:"s:Code>s:Subcode>s:Value"`
+	Reason  SoapFaultText    `xml:"s:Reason>s:Text"`
+	Detail  *SoapFaultDetail `xml:"s:Detail,omitempty"`
+}
+
+// SoapFaultCode is the subcode of a fault.
+type SoapFaultCode struct {
+	XMLNSA string `xml:"xmlns:a,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// SoapFaultText is the reason of a fault.
+type SoapFaultText struct {
+	Lang  string `xml:"xml:lang,attr"`
+	Value string `xml:",chardata"`
+}
+
+// SoapFaultDetail is the detail of an enrollment fault.
+type SoapFaultDetail struct {
+	Error struct {
+		XMLNS     string `xml:"xmlns,attr"`
+		ErrorType string `xml:"ErrorType"`
+		Message   string `xml:"Message"`
+		TraceID   string `xml:"TraceId"`
+	} `xml:"DeviceEnrollmentServiceError"`
+}
+
+// MicrosoftMDMFault is an error of the Windows MDM enrollment, it is returned
+// to the device as a SOAP fault.
+type MicrosoftMDMFault struct {
+	// Type is the type of the error, one of the MS-MDE2 error types (e.g.
+	// "MessageFormat", "Authentication").
+	Type string
+	// Sender is true if the request of the device is invalid, false if the
+	// server failed to process it.
+	Sender  bool
+	Message string
+}
+
+// NewMicrosoftMDMFault returns a new Windows MDM enrollment error.
+func NewMicrosoftMDMFault(typ string, sender bool, msg string) *MicrosoftMDMFault {
+	return &MicrosoftMDMFault{Type: typ, Sender: sender, Message: msg}
+}
+
+func (e *MicrosoftMDMFault) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
+
+// StatusCode implements the kithttp.StatusCoder interface.
+func (e *MicrosoftMDMFault) StatusCode() int {
+	if e.Sender {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// MS-MDM messages (management sessions)
+
+// SyncMLMessage is an OMA-DM SyncML message, sent by the devices during the
+// management sessions and returned to them.
+type SyncMLMessage struct {
+	XMLName xml.Name     `xml:"SyncML"`
+	XMLNS   string       `xml:"xmlns,attr,omitempty"`
+	Header  SyncMLHeader `xml:"SyncHdr"`
+	Body    SyncMLBody   `xml:"SyncBody"`
+}
+
+// SyncMLHeader is the header of a SyncML message.
+type SyncMLHeader struct {
+	VerDTD    string `xml:"VerDTD"`
+	VerProto  string `xml:"VerProto"`
+	SessionID string `xml:"SessionID"`
+	MsgID     string `xml:"MsgID"`
+	Target    string `xml:"Target>LocURI"`
+	Source    string `xml:"Source>LocURI"`
+}
+
+// SyncMLBody is the body of a SyncML message.
+type SyncMLBody struct {
+	Status  []SyncMLStatus `xml:"Status"`
+	Alert   []SyncMLCmd    `xml:"Alert"`
+	Replace []SyncMLCmd    `xml:"Replace"`
+	Results []SyncMLCmd    `xml:"Results"`
+	Final   *struct{}      `xml:"Final"`
+}
+
+// SyncMLCmd is a command of a SyncML message.
+type SyncMLCmd struct {
+	CmdID string       `xml:"CmdID"`
+	Data  string       `xml:"Data,omitempty"`
+	Items []SyncMLItem `xml:"Item"`
+}
+
+// SyncMLItem is an item of a SyncML command.
+type SyncMLItem struct {
+	Source   string `xml:"Source>LocURI,omitempty"`
+	Target   string `xml:"Target>LocURI,omitempty"`
+	MetaType string `xml:"Meta>Type,omitempty"`
+	Data     string `xml:"Data,omitempty"`
+}
+
+// SyncMLStatus is the status of a command of a SyncML message.
+type SyncMLStatus struct {
+	CmdID  string `xml:"CmdID"`
+	MsgRef string `xml:"MsgRef"`
+	CmdRef string `xml:"CmdRef"`
+	Cmd    string `xml:"Cmd"`
+	Data   string `xml:"Data"`
+}
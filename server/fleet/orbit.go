@@ -1,6 +1,9 @@
 package fleet
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 // OrbitConfigNotifications are notifications that the fleet server sends to
 // fleetd (orbit) so that it can run commands or more generally react to this
@@ -29,3 +32,49 @@ type OrbitHostInfo struct {
 	// Platform is the device's platform as defined by osquery.
 	Platform string
 }
+
+// OrbitMDMEnrollmentStatus is the MDM enrollment status of a device as read
+// locally by fleetd (orbit), e.g. via the `profiles` command on macOS.
+type OrbitMDMEnrollmentStatus struct {
+	// Enrolled is true if the device reports being enrolled in an MDM server.
+	Enrolled bool `json:"enrolled" db:"enrolled"`
+	// InstalledFromDEP is true if the device reports being enrolled via DEP.
+	InstalledFromDEP bool `json:"installed_from_dep" db:"installed_from_dep"`
+	// ServerURL is the URL of the MDM server the device is enrolled in, if any.
+	ServerURL string `json:"server_url" db:"server_url"`
+	// Profiles are the identifiers of the device-level configuration profiles
+	// installed on the device.
+	Profiles []string `json:"profiles" db:"-"`
+}
+
+// MDMAppleHostMissingFleetd is a host enrolled in Fleet's MDM for which fleetd
+// never enrolled, most likely because the command to install it failed.
+type MDMAppleHostMissingFleetd struct {
+	HostID         uint   `json:"host_id" db:"host_id"`
+	DisplayName    string `json:"display_name" db:"display_name"`
+	HardwareSerial string `json:"hardware_serial" db:"hardware_serial"`
+	UUID           string `json:"uuid" db:"uuid"`
+	// InstalledFromDEP is true if the host enrolled via Apple Business
+	// Manager, in which case Fleet installs fleetd automatically.
+	InstalledFromDEP bool `json:"installed_from_dep" db:"installed_from_dep"`
+	// MDMEnrolledAt is the time the host first enrolled in Fleet's MDM.
+	MDMEnrolledAt time.Time `json:"mdm_enrolled_at" db:"mdm_enrolled_at"`
+}
+
+// MDMAppleEnrollmentMismatch is a host for which the MDM enrollment status
+// reported by fleetd doesn't match the status known by the Fleet MDM server.
+type MDMAppleEnrollmentMismatch struct {
+	HostID         uint   `json:"host_id" db:"host_id"`
+	DisplayName    string `json:"display_name" db:"display_name"`
+	HardwareSerial string `json:"hardware_serial" db:"hardware_serial"`
+	// ServerEnrolled is true if the host is enrolled in Fleet's MDM according to
+	// the server.
+	ServerEnrolled bool `json:"server_enrolled" db:"server_enrolled"`
+	// DeviceEnrolled is true if the host reports being enrolled in an MDM
+	// server.
+	DeviceEnrolled bool `json:"device_enrolled" db:"device_enrolled"`
+	// DeviceServerURL is the URL of the MDM server reported by the host.
+	DeviceServerURL string `json:"device_server_url" db:"device_server_url"`
+	// ReportedAt is the last time the host reported its MDM enrollment status.
+	ReportedAt time.Time `json:"reported_at" db:"reported_at"`
+}
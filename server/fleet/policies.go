@@ -1,7 +1,10 @@
 package fleet
 
 import (
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
 )
 
@@ -29,6 +32,9 @@ type PolicyPayload struct {
 	//
 	// Empty string targets all platforms.
 	Platform string
+	// MDMConditions are the conditions on the host's MDM state evaluated by
+	// Fleet, in addition to the policy query.
+	MDMConditions PolicyMDMConditions
 }
 
 var (
@@ -38,6 +44,135 @@ var (
 	errPolicyInvalidPlatform = errors.New("invalid policy platform")
 )
 
+// PolicyMDMConditionKind is the MDM state of a host checked by a policy MDM
+// condition.
+type PolicyMDMConditionKind string
+
+const (
+	// PolicyMDMConditionProfile checks the status of a configuration profile
+	// of the host, identified by its name.
+	PolicyMDMConditionProfile PolicyMDMConditionKind = "profile"
+	// PolicyMDMConditionDiskEncryption checks the status of the disk
+	// encryption of the host, i.e. of the FileVault profile and of the escrow
+	// of its disk encryption key.
+	PolicyMDMConditionDiskEncryption PolicyMDMConditionKind = "disk_encryption"
+)
+
+// PolicyMDMCondition is a condition on the MDM state of a host. Unlike the
+// policy query, it is evaluated by Fleet with the MDM data it has about the
+// host.
+type PolicyMDMCondition struct {
+	// Kind is the MDM state checked by the condition.
+	Kind PolicyMDMConditionKind `json:"kind"`
+	// ProfileName is the name of the configuration profile checked by a
+	// "profile" condition.
+	ProfileName string `json:"profile_name,omitempty"`
+	// Status is the status the host must be in to meet the condition.
+	Status MDMAppleDeliveryStatus `json:"status"`
+}
+
+// PolicyMDMConditions are the MDM conditions of a policy, stored as a JSON
+// array. The policy passes only if its query passes and the host meets all
+// of them.
+type PolicyMDMConditions []PolicyMDMCondition
+
+// Verify verifies the conditions are valid.
+func (cs PolicyMDMConditions) Verify() error {
+	for _, c := range cs {
+		switch c.Kind {
+		case PolicyMDMConditionProfile:
+			if emptyString(c.ProfileName) {
+				return errors.New("mdm condition profile_name cannot be empty")
+			}
+		case PolicyMDMConditionDiskEncryption:
+			if c.ProfileName != "" {
+				return errors.New("mdm condition profile_name is only supported for the profile kind")
+			}
+		default:
+			return fmt.Errorf("invalid mdm condition kind %q", c.Kind)
+		}
+		switch c.Status {
+		case MDMAppleDeliveryVerifying, MDMAppleDeliveryPending, MDMAppleDeliveryFailed:
+			// OK
+		default:
+			return fmt.Errorf("invalid mdm condition status %q", c.Status)
+		}
+	}
+	return nil
+}
+
+// Scan implements the sql.Scanner interface
+func (cs *PolicyMDMConditions) Scan(val interface{}) error {
+	switch v := val.(type) {
+	case []byte:
+		return json.Unmarshal(v, cs)
+	case string:
+		return json.Unmarshal([]byte(v), cs)
+	case nil: // sql NULL
+		return nil
+	default:
+		return fmt.Errorf("unsupported type: %T", v)
+	}
+}
+
+// Value implements the sql.Valuer interface
+func (cs PolicyMDMConditions) Value() (driver.Value, error) {
+	if len(cs) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(cs)
+}
+
+// HostPolicyMDMState is the MDM state of a host against which the MDM
+// conditions of the policies are evaluated.
+type HostPolicyMDMState struct {
+	// Profiles are the configuration profiles of the host.
+	Profiles []HostMDMAppleProfile
+	// DiskEncryptionKeyDecryptable is whether the escrowed disk encryption key
+	// of the host could be decrypted, nil if no key was escrowed or it was not
+	// verified yet.
+	DiskEncryptionKeyDecryptable *bool
+}
+
+// Meets returns true if the host meets all the conditions. The FileVault
+// profile identifier is received as argument to avoid a circular
+// dependency.
+func (s HostPolicyMDMState) Meets(conds PolicyMDMConditions, fileVaultIdentifier string) bool {
+	for _, c := range conds {
+		var status *MDMAppleDeliveryStatus
+		switch c.Kind {
+		case PolicyMDMConditionProfile:
+			for _, p := range s.Profiles {
+				if p.Name == c.ProfileName && p.OperationType == MDMAppleOperationTypeInstall {
+					// a NULL status is equivalent to pending
+					status = &MDMAppleDeliveryPending
+					if p.Status != nil {
+						status = p.Status
+					}
+					break
+				}
+			}
+
+		case PolicyMDMConditionDiskEncryption:
+			var d MDMHostData
+			if s.DiskEncryptionKeyDecryptable != nil {
+				raw := 0
+				if *s.DiskEncryptionKeyDecryptable {
+					raw = 1
+				}
+				d.rawDecryptable = &raw
+			}
+			d.DetermineDiskEncryptionStatus(s.Profiles, fileVaultIdentifier)
+			status = d.ProfileStatusFromDiskEncryptionState(nil)
+		}
+
+		if status == nil || *status != c.Status {
+			return false
+		}
+	}
+	return true
+}
+
 // Verify verifies the policy payload is valid.
 func (p PolicyPayload) Verify() error {
 	if p.QueryID != nil {
@@ -48,13 +183,19 @@ func (p PolicyPayload) Verify() error {
 		if err := verifyPolicyName(p.Name); err != nil {
 			return err
 		}
-		if err := verifyPolicyQuery(p.Query); err != nil {
-			return err
+		// a policy with MDM conditions doesn't need a query
+		if len(p.MDMConditions) == 0 {
+			if err := verifyPolicyQuery(p.Query); err != nil {
+				return err
+			}
 		}
 	}
 	if err := verifyPolicyPlatforms(p.Platform); err != nil {
 		return err
 	}
+	if err := p.MDMConditions.Verify(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -106,6 +247,9 @@ type ModifyPolicyPayload struct {
 	Platform *string `json:"platform"`
 	// Critical marks the policy as high impact.
 	Critical *bool `json:"critical" premium:"true"`
+	// MDMConditions are the conditions on the host's MDM state evaluated by
+	// Fleet. If non-nil, an empty list removes the conditions.
+	MDMConditions *PolicyMDMConditions `json:"mdm_conditions"`
 }
 
 // Verify verifies the policy payload is valid.
@@ -115,16 +259,18 @@ func (p ModifyPolicyPayload) Verify() error {
 			return err
 		}
 	}
-	if p.Query != nil {
-		if err := verifyPolicyQuery(*p.Query); err != nil {
+	if p.Platform != nil {
+		if err := verifyPolicyPlatforms(*p.Platform); err != nil {
 			return err
 		}
 	}
-	if p.Platform != nil {
-		if err := verifyPolicyPlatforms(*p.Platform); err != nil {
+	if p.MDMConditions != nil {
+		if err := p.MDMConditions.Verify(); err != nil {
 			return err
 		}
 	}
+	// the query is verified against the resulting policy, as it can be empty
+	// if the policy has MDM conditions.
 	return nil
 }
 
@@ -157,10 +303,22 @@ type PolicyData struct {
 	//
 	// Empty string targets all platforms.
 	Platform string `json:"platform" db:"platforms"`
+	// MDMConditions are the conditions on the host's MDM state evaluated by
+	// Fleet, in addition to the query.
+	MDMConditions PolicyMDMConditions `json:"mdm_conditions,omitempty" db:"mdm_conditions"`
 
 	UpdateCreateTimestamps
 }
 
+// VerifyQuery verifies the policy has a query, which is optional if it has
+// MDM conditions.
+func (p PolicyData) VerifyQuery() error {
+	if len(p.MDMConditions) > 0 {
+		return nil
+	}
+	return verifyPolicyQuery(p.Query)
+}
+
 // Policy is a fleet's policy query.
 type Policy struct {
 	PolicyData
@@ -210,6 +368,9 @@ type PolicySpec struct {
 	//
 	// Empty string targets all platforms.
 	Platform string `json:"platform,omitempty"`
+	// MDMConditions are the conditions on the host's MDM state evaluated by
+	// Fleet, in addition to the query.
+	MDMConditions PolicyMDMConditions `json:"mdm_conditions,omitempty"`
 }
 
 // Verify verifies the policy data is valid.
@@ -217,12 +378,18 @@ func (p PolicySpec) Verify() error {
 	if err := verifyPolicyName(p.Name); err != nil {
 		return err
 	}
-	if err := verifyPolicyQuery(p.Query); err != nil {
-		return err
+	// a policy with MDM conditions doesn't need a query
+	if len(p.MDMConditions) == 0 {
+		if err := verifyPolicyQuery(p.Query); err != nil {
+			return err
+		}
 	}
 	if err := verifyPolicyPlatforms(p.Platform); err != nil {
 		return err
 	}
+	if err := p.MDMConditions.Verify(); err != nil {
+		return err
+	}
 	return nil
 }
 
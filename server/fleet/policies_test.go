@@ -0,0 +1,39 @@
+package fleet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyMDMConditionsVerify(t *testing.T) {
+	cases := []struct {
+		conds   PolicyMDMConditions
+		wantErr string
+	}{
+		{nil, ""},
+		{PolicyMDMConditions{{Kind: PolicyMDMConditionDiskEncryption, Status: MDMAppleDeliveryVerifying}}, ""},
+		{PolicyMDMConditions{{Kind: PolicyMDMConditionProfile, ProfileName: "Kiosk", Status: MDMAppleDeliveryFailed}}, ""},
+		{PolicyMDMConditions{{Kind: "nope", Status: MDMAppleDeliveryVerifying}}, `invalid mdm condition kind "nope"`},
+		{PolicyMDMConditions{{Kind: PolicyMDMConditionProfile, Status: MDMAppleDeliveryVerifying}}, "profile_name cannot be empty"},
+		{PolicyMDMConditions{{Kind: PolicyMDMConditionDiskEncryption, ProfileName: "x", Status: MDMAppleDeliveryVerifying}}, "profile_name is only supported"},
+		{PolicyMDMConditions{{Kind: PolicyMDMConditionDiskEncryption, Status: MDMAppleDeliveryNotApplicable}}, `invalid mdm condition status "not_applicable"`},
+		{PolicyMDMConditions{{Kind: PolicyMDMConditionDiskEncryption}}, `invalid mdm condition status ""`},
+	}
+	for _, c := range cases {
+		err := c.conds.Verify()
+		if c.wantErr == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.wantErr)
+		}
+	}
+
+	// the query is optional for the policies with MDM conditions
+	require.ErrorIs(t, PolicySpec{Name: "p"}.Verify(), errPolicyEmptyQuery)
+	require.NoError(t, PolicySpec{Name: "p", MDMConditions: cases[1].conds}.Verify())
+	require.ErrorIs(t, PolicyPayload{Name: "p"}.Verify(), errPolicyEmptyQuery)
+	require.NoError(t, PolicyPayload{Name: "p", MDMConditions: cases[1].conds}.Verify())
+	require.ErrorIs(t, PolicyData{}.VerifyQuery(), errPolicyEmptyQuery)
+	require.NoError(t, PolicyData{MDMConditions: cases[1].conds}.VerifyQuery())
+}
@@ -0,0 +1,101 @@
+package fleet
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ScimUser is a user provisioned by the identity provider (IdP) via the SCIM
+// API. The SCIM id of the user is its ID, the ExternalID is the identifier
+// of the user in the IdP.
+type ScimUser struct {
+	ID         uint      `db:"id"`
+	ExternalID *string   `db:"external_id"`
+	UserName   string    `db:"user_name"`
+	GivenName  string    `db:"given_name"`
+	FamilyName string    `db:"family_name"`
+	Email      string    `db:"email"`
+	Active     bool      `db:"active"`
+	CreatedAt  time.Time `db:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+
+	// Groups are the groups the user is a member of, they are set by the
+	// groups and are read-only on the user.
+	Groups []ScimUserGroup `db:"-"`
+}
+
+// ScimUserGroup is a group the SCIM user is a member of.
+type ScimUserGroup struct {
+	ID          uint   `db:"id"`
+	DisplayName string `db:"display_name"`
+}
+
+// ScimGroup is a group provisioned by the identity provider (IdP) via the
+// SCIM API, with the SCIM users that are its members.
+type ScimGroup struct {
+	ID          uint      `db:"id"`
+	ExternalID  *string   `db:"external_id"`
+	DisplayName string    `db:"display_name"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+
+	// MemberIDs are the ids of the SCIM users that are members of the group.
+	MemberIDs []uint `db:"-"`
+}
+
+// ScimListOptions are the options to list the SCIM users or groups. The
+// SCIM API paginates with a 1-based StartIndex and a Count of resources.
+type ScimListOptions struct {
+	StartIndex uint
+	Count      uint
+
+	// Filter is the SCIM filter expression of the request, the service parses
+	// it into the name filters below.
+	Filter string
+
+	// UserNameFilter filters the users by exact (case-insensitive) user name.
+	UserNameFilter *string
+	// DisplayNameFilter filters the groups by exact (case-insensitive) display
+	// name.
+	DisplayNameFilter *string
+}
+
+// Statuses of the last SCIM request.
+const (
+	ScimRequestStatusSuccess = "success"
+	ScimRequestStatusError   = "error"
+)
+
+// ScimLastRequest is the outcome of the last SCIM request that changed the
+// users or groups.
+type ScimLastRequest struct {
+	Status      string    `json:"status" db:"status"`
+	Details     string    `json:"details" db:"details"`
+	RequestedAt time.Time `json:"requested_at" db:"updated_at"`
+}
+
+// ScimDetails is the status of the SCIM integration, as returned by the SCIM
+// details endpoint.
+type ScimDetails struct {
+	// LastRequest is nil if the IdP never sent a request.
+	LastRequest *ScimLastRequest `json:"last_request"`
+	UsersCount  int              `json:"users_count"`
+	GroupsCount int              `json:"groups_count"`
+}
+
+// ScimAuthz is used to check user authorization to read and write the SCIM
+// users and groups.
+type ScimAuthz struct{}
+
+// AuthzType implements authz.AuthzTyper.
+func (ScimAuthz) AuthzType() string {
+	return "scim"
+}
+
+// ScimPatchOperation is an operation of a SCIM PATCH request. The Path is
+// empty when the Value holds the attributes to change.
+type ScimPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
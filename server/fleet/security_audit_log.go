@@ -0,0 +1,104 @@
+package fleet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Actions recorded in the security audit log. They match the names of the
+// corresponding activities.
+const (
+	SecurityAuditActionReadHostDiskEncryptionKey        = "read_host_disk_encryption_key"
+	SecurityAuditActionReadHostActivationLockBypassCode = "read_host_activation_lock_bypass_code"
+	SecurityAuditActionReadHostUnlockPIN                = "read_host_unlock_pin"
+)
+
+// SecurityAuditLogEntry is an entry of the security audit log, the stream
+// that records the reads of security-sensitive MDM data (disk encryption keys,
+// unlock PINs and Activation Lock bypass codes).
+//
+// Unlike the activities, the entries are never updated nor deleted, and each
+// entry includes the hash of the previous one, so that any change to the
+// stored entries can be detected (see SecurityAuditLogVerification).
+type SecurityAuditLogEntry struct {
+	ID        uint      `json:"id" db:"id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	Action    string    `json:"action" db:"action"`
+	// UserID is the id of the user who read the data, it is nil if the user
+	// was deleted.
+	UserID          *uint  `json:"user_id" db:"user_id"`
+	UserName        string `json:"user_name" db:"user_name"`
+	UserEmail       string `json:"user_email" db:"user_email"`
+	IPAddress       string `json:"ip_address" db:"ip_address"`
+	HostID          uint   `json:"host_id" db:"host_id"`
+	HostDisplayName string `json:"host_display_name" db:"host_display_name"`
+	// PrevHash is the Hash of the previous entry, empty for the first entry.
+	PrevHash string `json:"prev_hash" db:"prev_hash"`
+	// Hash is the hex-encoded SHA-256 of the entry, see ComputeHash.
+	Hash string `json:"hash" db:"hash"`
+}
+
+// NewSecurityAuditLogEntry returns the entry that records that user read the
+// security-sensitive data of host described by action, from ipAddress.
+func NewSecurityAuditLogEntry(action string, user *User, ipAddress string, host *Host) *SecurityAuditLogEntry {
+	entry := &SecurityAuditLogEntry{
+		Action:          action,
+		IPAddress:       ipAddress,
+		HostID:          host.ID,
+		HostDisplayName: host.DisplayName(),
+	}
+	if user != nil {
+		entry.UserID = &user.ID
+		entry.UserName = user.Name
+		entry.UserEmail = user.Email
+	}
+	return entry
+}
+
+// AuthzType implements authz.AuthzTyper.
+func (e *SecurityAuditLogEntry) AuthzType() string {
+	return "security_audit_log"
+}
+
+// ComputeHash returns the hash of the entry, which covers all its fields but
+// the ID (which is assigned by the datastore) and the Hash itself.
+func (e *SecurityAuditLogEntry) ComputeHash() string {
+	b, _ := json.Marshal(struct {
+		PrevHash        string `json:"prev_hash"`
+		CreatedAt       string `json:"created_at"`
+		Action          string `json:"action"`
+		UserID          *uint  `json:"user_id"`
+		UserName        string `json:"user_name"`
+		UserEmail       string `json:"user_email"`
+		IPAddress       string `json:"ip_address"`
+		HostID          uint   `json:"host_id"`
+		HostDisplayName string `json:"host_display_name"`
+	}{
+		PrevHash:        e.PrevHash,
+		CreatedAt:       e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		Action:          e.Action,
+		UserID:          e.UserID,
+		UserName:        e.UserName,
+		UserEmail:       e.UserEmail,
+		IPAddress:       e.IPAddress,
+		HostID:          e.HostID,
+		HostDisplayName: e.HostDisplayName,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// SecurityAuditLogVerification is the result of the verification of the hash
+// chain of the security audit log.
+type SecurityAuditLogVerification struct {
+	// Valid is true if all the entries match their hash and the hash of their
+	// previous entry.
+	Valid bool `json:"valid"`
+	// EntryCount is the number of entries that were verified.
+	EntryCount int `json:"entry_count"`
+	// FirstInvalidID is the id of the first entry that doesn't match its hash
+	// or the hash of its previous entry, if any.
+	FirstInvalidID *uint `json:"first_invalid_id"`
+}
@@ -2,6 +2,7 @@ package fleet
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/json"
 	"io"
 	"time"
@@ -31,6 +32,7 @@ type EnterpriseOverrides struct {
 	DeleteMDMAppleSetupAssistant      func(ctx context.Context, teamID *uint) error
 	MDMAppleSyncDEPProfile            func(ctx context.Context) error
 	DeleteMDMAppleBootstrapPackage    func(ctx context.Context, teamID *uint) error
+	DeleteMDMAppleEULA                func(ctx context.Context) error
 }
 
 type OsqueryService interface {
@@ -82,6 +84,10 @@ type Service interface {
 	// SetOrUpdateDeviceAuthToken creates or updates a device auth token for the given host.
 	SetOrUpdateDeviceAuthToken(ctx context.Context, authToken string) error
 
+	// SetOrbitMDMEnrollmentStatus stores the MDM enrollment status read locally
+	// by orbit for the host.
+	SetOrbitMDMEnrollmentStatus(ctx context.Context, status OrbitMDMEnrollmentStatus) error
+
 	// SetEnterpriseOverrides allows the enterprise service to override specific methods
 	// that can't be easily overridden via embedding.
 	//
@@ -170,6 +176,11 @@ type Service interface {
 	// are valid, then responds with an enrollment profile.
 	InitiateMDMAppleSSOCallback(ctx context.Context, auth Auth) (string, error)
 
+	// GetMDMAppleSSOEndUserStrings returns the strings rendered to the end
+	// user when the SSO callback fails, localized for the team the end user's
+	// hosts enroll in.
+	GetMDMAppleSSOEndUserStrings(ctx context.Context, auth Auth) (*MDMEndUserStrings, error)
+
 	// GetSSOUser handles retrieval of an user that is trying to authenticate
 	// via SSO
 	GetSSOUser(ctx context.Context, auth Auth) (*User, error)
@@ -186,6 +197,9 @@ type Service interface {
 	GetInfoAboutSession(ctx context.Context, id uint) (session *Session, err error)
 	GetSessionByKey(ctx context.Context, key string) (session *Session, err error)
 	DeleteSession(ctx context.Context, id uint) (err error)
+	// ReauthenticateSession confirms the password of the current user, which
+	// puts their session in sudo mode for SessionSudoModeDuration.
+	ReauthenticateSession(ctx context.Context, password string) (*Session, error)
 
 	// /////////////////////////////////////////////////////////////////////////////
 	// PackService is the service interface for managing query packs.
@@ -336,6 +350,22 @@ type Service interface {
 	// ListHostDeviceMapping returns the list of device-mapping of user's email address
 	// for the host.
 	ListHostDeviceMapping(ctx context.Context, id uint) ([]*HostDeviceMapping, error)
+	// ListHostCustomAttributes returns the custom attributes of the host.
+	ListHostCustomAttributes(ctx context.Context, id uint) ([]*HostCustomAttribute, error)
+	// SetHostCustomAttributes creates, updates or deletes (if the value is nil)
+	// the custom attributes of the host, keyed by name.
+	SetHostCustomAttributes(ctx context.Context, id uint, attrs map[string]*string) error
+	// GetHostAssignedUser returns the user the host is assigned to, or nil if
+	// it is not assigned.
+	GetHostAssignedUser(ctx context.Context, id uint) (*HostAssignedUser, error)
+	// SetHostAssignedUser assigns the host to the user identified by its email.
+	SetHostAssignedUser(ctx context.Context, id uint, email, fullName string) error
+	// DeleteHostAssignedUser removes the assigned user of the host.
+	DeleteHostAssignedUser(ctx context.Context, id uint) error
+	// SyncHostAssignedUsers assigns the hosts to the users synced from the
+	// IdP. It returns the number of hosts updated and the identifiers of the
+	// hosts that were not found.
+	SyncHostAssignedUsers(ctx context.Context, users []HostAssignedUserSync) (int, []string, error)
 
 	// FailingPoliciesCount returns the number of failling policies for 'host'
 	FailingPoliciesCount(ctx context.Context, host *Host) (uint, error)
@@ -354,7 +384,21 @@ type Service interface {
 	GetMDMSolution(ctx context.Context, mdmID uint) (*MDMSolution, error)
 	GetMunkiIssue(ctx context.Context, munkiIssueID uint) (*MunkiIssue, error)
 
-	HostEncryptionKey(ctx context.Context, id uint) (*HostDiskEncryptionKey, error)
+	// HostEncryptionKey returns the decrypted disk encryption key of the host
+	// and records the access with the provided justification.
+	HostEncryptionKey(ctx context.Context, id uint, justification string) (*HostDiskEncryptionKey, error)
+
+	// ListHostEncryptionKeyAccesses returns the recorded accesses to the disk
+	// encryption key of the host.
+	ListHostEncryptionKeyAccesses(ctx context.Context, id uint, opt ListOptions) ([]*HostDiskEncryptionKeyAccess, *PaginationMetadata, error)
+
+	// HostActivationLockBypassCode returns the Activation Lock bypass code
+	// escrowed for the host.
+	HostActivationLockBypassCode(ctx context.Context, id uint) (*HostMDMActivationLockBypassCode, error)
+
+	// ListHostCertificates returns the certificates installed on the host, as
+	// reported by the host via MDM.
+	ListHostCertificates(ctx context.Context, id uint, opt ListOptions) ([]*HostMDMCertificate, *PaginationMetadata, error)
 
 	// OSVersions returns a list of operating systems and associated host counts, which may be
 	// filtered using the following optional criteria: team id, platform, or name and version.
@@ -375,6 +419,12 @@ type Service interface {
 	// GetEnrollSecretSpec gets the spec for the current enroll secrets.
 	GetEnrollSecretSpec(ctx context.Context) (*EnrollSecretSpec, error)
 
+	// GetFleetdProfile returns the fleetd configuration profile of the team, or
+	// of no team if teamID is nil. The profile embeds an enroll secret, but
+	// users only need to be able to download the profile, not to read the
+	// enroll secrets.
+	GetFleetdProfile(ctx context.Context, teamID *uint) ([]byte, error)
+
 	// CertificateChain returns the PEM encoded certificate chain for osqueryd TLS termination. For cases where the
 	// connection is self-signed, the server will attempt to connect using the InsecureSkipVerify option in tls.Config.
 	CertificateChain(ctx context.Context) (cert []byte, err error)
@@ -402,6 +452,10 @@ type Service interface {
 	// the fleet instance.
 	VulnerabilitiesConfig(ctx context.Context) (*VulnerabilitiesConfig, error)
 
+	// MDMCronHealth returns the health of the MDM cron jobs whose repeated
+	// failures are notified. It is empty if MDM is not configured.
+	MDMCronHealth(ctx context.Context) ([]*MDMCronJobHealth, error)
+
 	// /////////////////////////////////////////////////////////////////////////////
 	// InviteService contains methods for a service which deals with user invites.
 
@@ -478,8 +532,18 @@ type Service interface {
 	AddTeamUsers(ctx context.Context, teamID uint, users []TeamUser) (*Team, error)
 	// DeleteTeamUsers deletes users from an existing team.
 	DeleteTeamUsers(ctx context.Context, teamID uint, users []TeamUser) (*Team, error)
-	// DeleteTeam deletes an existing team.
-	DeleteTeam(ctx context.Context, id uint) error
+	// DeleteTeam deletes an existing team. If the MDM profiles of the team's
+	// hosts are updated asynchronously, the corresponding job is returned.
+	DeleteTeam(ctx context.Context, id uint) (*Job, error)
+	// GetTeamDeletionReport returns what deleting the team would affect.
+	GetTeamDeletionReport(ctx context.Context, id uint) (*TeamDeletionReport, error)
+	// StageTeamDeletion queues a job that moves the hosts of the team to the
+	// destination team (0 for no team) in batches, and deletes the team once it
+	// has no hosts left.
+	StageTeamDeletion(ctx context.Context, id uint, destinationTeamID uint) (*Job, error)
+	// GetTeamDeletionProgress returns the progress of the staged team deletion
+	// of the job.
+	GetTeamDeletionProgress(ctx context.Context, jobID uint) (*TeamDeletionProgress, error)
 	// ListTeams lists teams with the ordering and filters in the provided options.
 	ListTeams(ctx context.Context, opt ListOptions) ([]*Team, error)
 	// ListTeamUsers lists users on the team with the provided list options.
@@ -507,6 +571,12 @@ type Service interface {
 	// logins, running a live query, etc.
 	ListActivities(ctx context.Context, opt ListActivitiesOptions) ([]*Activity, *PaginationMetadata, error)
 
+	// ListSecurityAuditLog lists the entries of the security audit log, which
+	// records the reads of security-sensitive MDM data.
+	ListSecurityAuditLog(ctx context.Context, opt ListOptions) ([]*SecurityAuditLogEntry, *PaginationMetadata, error)
+	// VerifySecurityAuditLog verifies the hash chain of the security audit log.
+	VerifySecurityAuditLog(ctx context.Context) (*SecurityAuditLogVerification, error)
+
 	// /////////////////////////////////////////////////////////////////////////////
 	// UserRolesService
 
@@ -573,6 +643,38 @@ type Service interface {
 	GetInstaller(ctx context.Context, installer Installer) (io.ReadCloser, int64, error)
 	CheckInstallerExistence(ctx context.Context, installer Installer) error
 
+	// /////////////////////////////////////////////////////////////////////////////
+	// SCIM
+
+	// CreateScimUser creates the user pushed by the IdP.
+	CreateScimUser(ctx context.Context, user *ScimUser) (*ScimUser, error)
+	// GetScimUser returns the SCIM user with the groups it is a member of.
+	GetScimUser(ctx context.Context, id uint) (*ScimUser, error)
+	// ListScimUsers returns a page of the SCIM users and the total number of
+	// users that match the filters.
+	ListScimUsers(ctx context.Context, opts ScimListOptions) ([]*ScimUser, int, error)
+	// ReplaceScimUser replaces the attributes of the SCIM user.
+	ReplaceScimUser(ctx context.Context, user *ScimUser) (*ScimUser, error)
+	// PatchScimUser applies the PATCH operations to the SCIM user.
+	PatchScimUser(ctx context.Context, id uint, ops []ScimPatchOperation) (*ScimUser, error)
+	// DeleteScimUser deletes the SCIM user.
+	DeleteScimUser(ctx context.Context, id uint) error
+	// CreateScimGroup creates the group pushed by the IdP.
+	CreateScimGroup(ctx context.Context, group *ScimGroup) (*ScimGroup, error)
+	// GetScimGroup returns the SCIM group with its members.
+	GetScimGroup(ctx context.Context, id uint) (*ScimGroup, error)
+	// ListScimGroups returns a page of the SCIM groups and the total number of
+	// groups that match the filters.
+	ListScimGroups(ctx context.Context, opts ScimListOptions) ([]*ScimGroup, int, error)
+	// ReplaceScimGroup replaces the display name and members of the SCIM group.
+	ReplaceScimGroup(ctx context.Context, group *ScimGroup) (*ScimGroup, error)
+	// PatchScimGroup applies the PATCH operations to the SCIM group.
+	PatchScimGroup(ctx context.Context, id uint, ops []ScimPatchOperation) (*ScimGroup, error)
+	// DeleteScimGroup deletes the SCIM group.
+	DeleteScimGroup(ctx context.Context, id uint) error
+	// GetScimDetails returns the status of the SCIM integration.
+	GetScimDetails(ctx context.Context) (*ScimDetails, error)
+
 	// /////////////////////////////////////////////////////////////////////////////
 	// Apple MDM
 
@@ -581,11 +683,48 @@ type Service interface {
 	RequestMDMAppleCSR(ctx context.Context, email, org string) (*AppleCSR, error)
 
 	// NewMDMAppleConfigProfile creates a new configuration profile for the specified team.
-	NewMDMAppleConfigProfile(ctx context.Context, teamID uint, r io.Reader, size int64) (*MDMAppleConfigProfile, error)
+	// Unless force is true, it fails if the profile's identifier conflicts with
+	// a profile from another source installed on hosts of the team. Payloads
+	// with a PayloadType reserved by Fleet are rejected unless the team allows
+	// them and acknowledgeReserved is true.
+	NewMDMAppleConfigProfile(ctx context.Context, teamID uint, r io.Reader, size int64, force, acknowledgeReserved bool) (*MDMAppleConfigProfile, error)
+
+	// ListMDMAppleProfileIdentifierConflicts returns the number of hosts of the
+	// team (or no team) on which a profile with the provided identifier would
+	// overwrite a profile from another source.
+	ListMDMAppleProfileIdentifierConflicts(ctx context.Context, teamID *uint, identifier string) ([]*MDMAppleProfileIdentifierConflict, error)
+	// GetMDMAppleReconciliationPlan returns the profile commands that the next
+	// reconciliation would send to the hosts of the team (or no team), with
+	// the reason of each. Nothing is sent nor recorded.
+	GetMDMAppleReconciliationPlan(ctx context.Context, teamID *uint) ([]*MDMAppleReconciliationPlanItem, error)
 	// GetMDMAppleConfigProfile retrieves the specified configuration profile.
 	GetMDMAppleConfigProfile(ctx context.Context, profileID uint) (*MDMAppleConfigProfile, error)
 	// DeleteMDMAppleConfigProfile deletes the specified configuration profile.
 	DeleteMDMAppleConfigProfile(ctx context.Context, profileID uint) error
+	// DeleteMDMAppleConfigProfiles deletes, in a single operation, the
+	// configuration profiles with the specified ids and those with the
+	// specified identifiers in any of the teams (0 for no team), or in any
+	// team if no team is specified. It returns the ids of the deleted profiles.
+	DeleteMDMAppleConfigProfiles(ctx context.Context, profileIDs []uint, identifiers []string, teamIDs []uint) ([]uint, error)
+	// CopyMDMAppleConfigProfile copies the specified configuration profile to
+	// each of the teams (0 for no team). Unless force is true, it fails if the
+	// profile's identifier conflicts with a profile from another source
+	// installed on hosts of a team.
+	CopyMDMAppleConfigProfile(ctx context.Context, profileID uint, teamIDs []uint, force bool) ([]*MDMAppleConfigProfile, error)
+	// RetryMDMAppleConfigProfile queues the failed installation of the
+	// configuration profile on the host, or on the hosts of the team (0 for no
+	// team), to be applied again and resets their automatic retries. Exactly
+	// one of hostID or teamID must be provided. It returns the number of hosts
+	// whose installation was queued.
+	RetryMDMAppleConfigProfile(ctx context.Context, profileID uint, hostID, teamID *uint) (int64, error)
+	// InstallMDMAppleHostProfile installs the provided configuration profile
+	// on a single host, without adding it to the profiles of the host's team.
+	// Unless force is true, it fails if the profile's identifier is already
+	// used by a profile installed on the host.
+	InstallMDMAppleHostProfile(ctx context.Context, hostID uint, r io.Reader, size int64, force bool) (*MDMAppleConfigProfile, error)
+	// DeleteMDMAppleHostProfile deletes an ad-hoc profile installed on a
+	// single host, which removes it from the host.
+	DeleteMDMAppleHostProfile(ctx context.Context, hostID, profileID uint) error
 	// ListMDMAppleConfigProfiles returns the list of all the configuration profiles for the
 	// specified team.
 	ListMDMAppleConfigProfiles(ctx context.Context, teamID uint) ([]*MDMAppleConfigProfile, error)
@@ -595,11 +734,83 @@ type Service interface {
 	// to any team).
 	GetMDMAppleProfilesSummary(ctx context.Context, teamID *uint) (*MDMAppleConfigProfilesSummary, error)
 
+	// ListMDMAppleProfilesSummaryByTeam summarizes the current state of MDM
+	// configuration profiles on the hosts of all teams, with one row per team.
+	ListMDMAppleProfilesSummaryByTeam(ctx context.Context) ([]*MDMAppleConfigProfilesTeamSummary, error)
+
+	// SetupMDMApple validates the Apple MDM assets, stores them encrypted in
+	// the database and enables Apple MDM. The Fleet server instances load the
+	// stored assets when they start.
+	SetupMDMApple(ctx context.Context, payload MDMAppleSetupPayload) error
+
 	// GetMDMAppleFileVaultSummary summarizes the current state of Apple disk encryption profiles on
 	// each macOS host in the specified team (or, if no team is specified, each host that is not assigned
 	// to any team).
 	GetMDMAppleFileVaultSummary(ctx context.Context, teamID *uint) (*MDMAppleFileVaultSummary, error)
 
+	// ListMDMAppleEnrollmentMismatches returns the hosts in the specified team
+	// (or, if no team is specified, the hosts that are not assigned to any team)
+	// for which the MDM enrollment status reported by orbit doesn't match the
+	// status known by the Fleet MDM server.
+	ListMDMAppleEnrollmentMismatches(ctx context.Context, teamID *uint) ([]*MDMAppleEnrollmentMismatch, error)
+
+	// ListMDMAppleHostsMissingFleetd returns the hosts in the specified team
+	// (or, if no team is specified, the hosts that are not assigned to any
+	// team) that have been enrolled in Fleet's MDM for at least minHours hours
+	// but for which fleetd never enrolled.
+	ListMDMAppleHostsMissingFleetd(ctx context.Context, teamID *uint, minHours *uint) ([]*MDMAppleHostMissingFleetd, error)
+
+	// InstallMDMAppleFleetd enqueues the command to install fleetd on the host,
+	// which must be enrolled in Fleet's MDM. It returns the UUID of the
+	// command.
+	InstallMDMAppleFleetd(ctx context.Context, hostID uint) (string, error)
+
+	// ListMDMAppleHostQueuedCommands returns the MDM commands queued for the
+	// host that were not delivered yet.
+	ListMDMAppleHostQueuedCommands(ctx context.Context, hostID uint) ([]*MDMAppleHostQueuedCommand, error)
+
+	// CancelMDMAppleHostQueuedCommand removes a command that was not delivered
+	// yet from the queue of the host.
+	CancelMDMAppleHostQueuedCommand(ctx context.Context, hostID uint, commandUUID string) error
+
+	// ListMDMAppleHostCommands returns the history of the MDM commands sent to
+	// the host.
+	ListMDMAppleHostCommands(ctx context.Context, hostID uint, opt ListOptions) ([]*MDMAppleHostCommand, *PaginationMetadata, error)
+
+	// ListMDMWebhookDeadLetters returns the MDM events that could not be
+	// delivered to the MDM events webhook after all retries.
+	ListMDMWebhookDeadLetters(ctx context.Context, opt ListOptions) ([]*MDMWebhookDeadLetter, error)
+
+	// ListMDMHostChanges returns up to perPage changes of the MDM state of hosts
+	// recorded after the cursor since (all the retained changes if since is
+	// empty), and whether more changes are available.
+	ListMDMHostChanges(ctx context.Context, since string, perPage uint) (changes []*HostMDMChange, hasMore bool, err error)
+
+	// ReleaseMDMAppleDEPDevice releases the device with the given serial number
+	// from Fleet's MDM server in Apple Business Manager and updates its host.
+	ReleaseMDMAppleDEPDevice(ctx context.Context, serial string) error
+
+	// UploadMDMAppleDEPTeamAssignments sets the teams that devices are assigned
+	// to when they are first synced from Apple Business Manager, from a CSV
+	// file of "serial_number,team" records.
+	UploadMDMAppleDEPTeamAssignments(ctx context.Context, r io.Reader) (*MDMAppleDEPTeamAssignmentsResult, error)
+
+	// ListMDMAppleDEPTeamAssignments returns the uploaded team assignments of
+	// devices synced from Apple Business Manager.
+	ListMDMAppleDEPTeamAssignments(ctx context.Context) ([]*MDMAppleDEPTeamAssignment, error)
+
+	// ListMDMAppleNanoEnrollments returns the enrollments stored by the MDM
+	// server, including the ones without a matching Fleet host.
+	ListMDMAppleNanoEnrollments(ctx context.Context, opt MDMAppleNanoEnrollmentListOptions) ([]*MDMAppleNanoEnrollment, *PaginationMetadata, error)
+
+	// DeleteMDMAppleNanoEnrollment deletes an enrollment without a matching
+	// Fleet host from the MDM server's storage.
+	DeleteMDMAppleNanoEnrollment(ctx context.Context, id string) error
+
+	// LinkMDMAppleNanoEnrollmentToHost links an enrollment without a matching
+	// Fleet host to the host with the given id.
+	LinkMDMAppleNanoEnrollmentToHost(ctx context.Context, id string, hostID uint) error
+
 	// NewMDMAppleEnrollmentProfile creates and returns new enrollment profile.
 	// Such enrollment profiles allow devices to enroll to Fleet MDM.
 	NewMDMAppleEnrollmentProfile(ctx context.Context, enrollmentPayload MDMAppleEnrollmentProfilePayload) (enrollmentProfile *MDMAppleEnrollmentProfile, err error)
@@ -611,7 +822,49 @@ type Service interface {
 	// TODO(mna): this may have to be removed if we don't end up supporting
 	// manual enrollment via a token (currently we only support it via Fleet
 	// Desktop, in the My Device page). See #8701.
-	GetMDMAppleEnrollmentProfileByToken(ctx context.Context, enrollmentToken string) (profile []byte, err error)
+	//
+	// The optional enrollmentRef references the MDM IdP account of the end
+	// user that authenticated before enrolling, it is recorded for the host
+	// when it checks in.
+	GetMDMAppleEnrollmentProfileByToken(ctx context.Context, enrollmentToken string, enrollmentRef string) (profile []byte, err error)
+
+	// ListMDMAppleSSOEnrollmentTokens returns the enrollment tokens issued to
+	// end users that authenticated with the IdP during automatic enrollment.
+	ListMDMAppleSSOEnrollmentTokens(ctx context.Context, opt ListOptions) ([]*MDMAppleSSOEnrollmentToken, error)
+
+	// RevokeMDMAppleSSOEnrollmentToken revokes an SSO enrollment token that was
+	// not used yet.
+	RevokeMDMAppleSSOEnrollmentToken(ctx context.Context, id uint) error
+
+	// CreateMDMAppleEnrollmentLink creates a short-lived link that serves the
+	// enrollment profile to devices and assigns the hosts that enroll with it
+	// to the team (or no team if teamID is nil). The link can be used by up to
+	// maxUses hosts before it expires after expiresIn.
+	CreateMDMAppleEnrollmentLink(ctx context.Context, teamID *uint, maxUses uint, expiresIn time.Duration) (*MDMAppleEnrollmentLink, error)
+
+	// GetMDMAppleEnrollmentLink returns the enrollment link along with the
+	// hosts that enrolled with it.
+	GetMDMAppleEnrollmentLink(ctx context.Context, id uint) (*MDMAppleEnrollmentLink, error)
+
+	// GetMDMAppleEnrollmentProfileByLinkToken returns the enrollment profile
+	// served by the enrollment link with the given token.
+	GetMDMAppleEnrollmentProfileByLinkToken(ctx context.Context, token string) (profile []byte, err error)
+
+	// GetMDMAppleTeamEnrollmentProfile returns the reusable enrollment profile
+	// of the team (or no team if teamID is nil). The hosts that enroll with it
+	// are assigned to the team until its token is rotated.
+	GetMDMAppleTeamEnrollmentProfile(ctx context.Context, teamID *uint) (profile []byte, err error)
+
+	// MDMAppleEnrollmentProfileCDNURL stores the manual enrollment profile in
+	// the MDM assets store and returns its signed URL on the MDM assets CDN,
+	// or an empty string if the CDN is not enabled. It must only be called
+	// after the download of the profile was authorized.
+	MDMAppleEnrollmentProfileCDNURL(ctx context.Context, profile []byte) (string, error)
+
+	// RotateMDMAppleTeamEnrollmentToken replaces the token embedded in the
+	// reusable enrollment profile of the team (or no team if teamID is nil),
+	// so that the previously downloaded profiles no longer assign hosts to it.
+	RotateMDMAppleTeamEnrollmentToken(ctx context.Context, teamID *uint) error
 
 	// GetDeviceMDMAppleEnrollmentProfile loads the raw (PList-format) enrollment
 	// profile for the currently authenticated device.
@@ -624,6 +877,11 @@ type Service interface {
 	// the specified options.
 	ListMDMAppleCommands(ctx context.Context, opts *MDMAppleCommandListOptions) ([]*MDMAppleCommand, error)
 
+	// ListMDMAppleSCEPCertificates returns the certificates issued by Fleet's
+	// SCEP server that match the options, limited to the hosts the user can
+	// access.
+	ListMDMAppleSCEPCertificates(ctx context.Context, opt MDMAppleSCEPCertificateListOptions) ([]*MDMAppleSCEPCertificate, *PaginationMetadata, error)
+
 	// UploadMDMAppleInstaller uploads an Apple installer to Fleet.
 	UploadMDMAppleInstaller(ctx context.Context, name string, size int64, installer io.Reader) (*MDMAppleInstaller, error)
 
@@ -650,26 +908,161 @@ type Service interface {
 	// ListMDMAppleDEPDevices lists all the devices added to this MDM server in Apple Business Manager (ABM).
 	ListMDMAppleDEPDevices(ctx context.Context) ([]MDMAppleDEPDevice, error)
 
+	// ListMDMAppleDEPHostsReport lists the hosts with their device information
+	// from Apple Business Manager (ABM), as stored by the DEP sync.
+	ListMDMAppleDEPHostsReport(ctx context.Context, teamID *uint, opts ListOptions) ([]*MDMAppleDEPHostReportItem, error)
+
+	// ListMDMAppleDEPAssignmentDiscrepancies returns the device families that
+	// are expected to be assigned to Fleet's MDM server by default in Apple
+	// Business Manager (ABM), along with the hosts that don't match that rule.
+	ListMDMAppleDEPAssignmentDiscrepancies(ctx context.Context, teamID *uint) ([]string, []*MDMAppleDEPAssignmentDiscrepancy, error)
+
 	// NewMDMAppleDEPKeyPair creates a public private key pair for use with the Apple MDM DEP token.
 	NewMDMAppleDEPKeyPair(ctx context.Context) (*MDMAppleDEPKeyPair, error)
 
+	// GetMDMEndUserStrings returns the strings rendered to the end users of
+	// the team (nil for no team), localized in the languages accepted by the
+	// current request.
+	GetMDMEndUserStrings(ctx context.Context, teamID *uint) (*MDMEndUserStrings, error)
+
 	// EnqueueMDMAppleCommand enqueues a command for execution on the given
-	// devices. Note that a deviceID is the same as a host's UUID.
-	EnqueueMDMAppleCommand(ctx context.Context, rawBase64Cmd string, deviceIDs []string, noPush bool) (status int, result *CommandEnqueueResult, err error)
+	// devices, or on the hosts that match the MDM host target if targetID is
+	// set, or on the hosts enrolled in Fleet's MDM of the teams (0 for no team)
+	// or of the label if teamIDs or labelID are set, with the given priority
+	// (normal if empty). Note that a deviceID is the same as a host's UUID.
+	EnqueueMDMAppleCommand(ctx context.Context, rawBase64Cmd string, deviceIDs []string, targetID *uint, teamIDs []uint, labelID *uint, priority MDMAppleCommandPriority, noPush bool) (status int, result *CommandEnqueueResult, err error)
+
+	// PushMDMAppleHosts sends a push notification, without any command, to the
+	// macOS hosts that match the filters so that they check in for their queued
+	// commands.
+	PushMDMAppleHosts(ctx context.Context, opts HostListOptions, labelID *uint) (*MDMAppleHostsPushResult, error)
 
 	// EnqueueMDMAppleCommandRemoveEnrollmentProfile enqueues a command to remove the
-	// profile used for Fleet MDM enrollment from the specified device.
-	EnqueueMDMAppleCommandRemoveEnrollmentProfile(ctx context.Context, hostID uint) error
+	// profile used for Fleet MDM enrollment from the specified device. The
+	// command stays queued until the device checks in, the result reports
+	// whether the device was already unenrolled.
+	EnqueueMDMAppleCommandRemoveEnrollmentProfile(ctx context.Context, hostID uint) (*MDMAppleUnenrollResult, error)
 
 	// BatchSetMDMAppleProfiles replaces the custom macOS profiles for a specified
-	// team or for hosts with no team.
-	BatchSetMDMAppleProfiles(ctx context.Context, teamID *uint, teamName *string, profiles [][]byte, dryRun bool) error
-
-	// MDMAppleDeviceLock remote locks a host
-	MDMAppleDeviceLock(ctx context.Context, hostID uint) error
-
-	// MMDAppleEraseDevice erases a host
-	MDMAppleEraseDevice(ctx context.Context, hostID uint) error
+	// team or for hosts with no team, along with the exclusions of hosts from
+	// those profiles. If the profiles of the affected hosts are updated
+	// asynchronously, the corresponding job is returned. Payloads with a
+	// PayloadType reserved by Fleet are rejected unless the team allows them
+	// and acknowledgeReserved is true.
+	BatchSetMDMAppleProfiles(ctx context.Context, teamID *uint, teamName *string, profiles [][]byte, exclusions []MDMAppleProfileExclusionSpec, dryRun, force, acknowledgeReserved bool) (*Job, error)
+
+	// RotateMDMAppleFileVaultKeys flags the macOS hosts of the team (or no
+	// team), or the hosts that match the MDM host target if targetID is set,
+	// that have an escrowed FileVault key for the rotation of their key. The
+	// hosts are notified in batches. It returns the number of hosts flagged.
+	RotateMDMAppleFileVaultKeys(ctx context.Context, teamID, targetID *uint) (int, error)
+
+	// NewMDMHostTarget creates a named MDM host target with the provided
+	// filters.
+	NewMDMHostTarget(ctx context.Context, name, description string, filters MDMHostTargetFilters) (*MDMHostTarget, error)
+
+	// ListMDMHostTargets lists the MDM host targets.
+	ListMDMHostTargets(ctx context.Context) ([]*MDMHostTarget, error)
+
+	// ListMDMHostTargetHosts lists the hosts visible to the user that currently
+	// match the MDM host target.
+	ListMDMHostTargetHosts(ctx context.Context, id uint) ([]*MDMHostTargetHost, error)
+
+	// DeleteMDMHostTarget deletes the MDM host target, which must not be used
+	// by a profile.
+	DeleteMDMHostTarget(ctx context.Context, id uint) error
+
+	// SetMDMAppleConfigProfileTarget restricts the configuration profile to
+	// the hosts of its team that match the MDM host target, or removes the
+	// restriction if targetID is nil.
+	SetMDMAppleConfigProfileTarget(ctx context.Context, profileID uint, targetID *uint) error
+
+	// SetMDMAppleConfigProfileMacOSVersions restricts the configuration
+	// profile to the hosts running a macOS version between min and max
+	// (inclusive), an empty version leaves that end of the range unbounded.
+	SetMDMAppleConfigProfileMacOSVersions(ctx context.Context, profileID uint, min, max string) error
+
+	// ListMDMAppleBlockedEnrollments lists the devices visible to the user
+	// whose enrollment was blocked or flagged because their hardware doesn't
+	// meet the enrollment eligibility rules of their team.
+	ListMDMAppleBlockedEnrollments(ctx context.Context) ([]*MDMAppleBlockedEnrollment, error)
+
+	// ListMDMApplePendingEnrollments lists the hosts visible to the user whose
+	// manual enrollment waits to be approved.
+	ListMDMApplePendingEnrollments(ctx context.Context) ([]*MDMApplePendingEnrollment, error)
+
+	// ApproveMDMAppleEnrollment approves the pending manual enrollment of the
+	// host, which then receives its profiles and commands as usual.
+	ApproveMDMAppleEnrollment(ctx context.Context, hostID uint) error
+
+	// ListMDMAppleProfileChanges lists the profile changes that required
+	// approval with the provided status, or all of them if status is empty.
+	ListMDMAppleProfileChanges(ctx context.Context, status MDMAppleProfileChangeStatus) ([]*MDMAppleProfileChange, error)
+
+	// ReviewMDMAppleProfileChange approves or rejects the pending profile
+	// change. The commands of an approved change are sent by the next run of
+	// the profiles reconciler.
+	ReviewMDMAppleProfileChange(ctx context.Context, id uint, approve bool) error
+
+	// PurgeHostMDMAppleData deletes the stale MDM state of the host, e.g. after
+	// it was wiped and re-imaged, so that its profiles and bootstrap package
+	// are delivered again.
+	PurgeHostMDMAppleData(ctx context.Context, hostID uint) error
+
+	// BatchSetMDMAppleAllTeamsProfiles replaces the custom macOS profiles that
+	// apply to the hosts of all teams (and no team), along with their
+	// exclusions.
+	BatchSetMDMAppleAllTeamsProfiles(ctx context.Context, profiles [][]byte, exclusions []MDMAppleProfileExclusionSpec, dryRun, acknowledgeReserved bool) (*Job, error)
+
+	// BatchSetMDMAppleTeamsProfiles replaces the custom macOS profiles of
+	// multiple teams at once. The teams are keyed by name, the empty name
+	// being no team. The changes are applied in a single transaction (all or
+	// nothing) and the profiles of the affected hosts are updated in a single
+	// pass.
+	BatchSetMDMAppleTeamsProfiles(ctx context.Context, teams map[string]MDMAppleTeamProfilesSpec, dryRun, force, acknowledgeReserved bool) (*Job, error)
+
+	// StartMDMAppleServerURLMigration runs the pre-flight checks of a change of
+	// the server URL and, unless dryRun is set, changes the server URL,
+	// registers the DEP profile with the new URL and starts the staged
+	// redelivery of the enrollment profile to the enrolled hosts.
+	StartMDMAppleServerURLMigration(ctx context.Context, serverURL string, dryRun bool) ([]MDMAppleServerURLMigrationCheck, *MDMAppleServerURLMigration, error)
+
+	// GetMDMAppleServerURLMigration returns the latest server URL migration
+	// along with the progress of the redelivery of the enrollment profile.
+	GetMDMAppleServerURLMigration(ctx context.Context) (*MDMAppleServerURLMigration, error)
+
+	// GetMDMAppleSCEPCARotation returns the status of the rotation of the CA
+	// of Fleet's SCEP server, including the number of enrolled hosts that
+	// still use an identity issued by the previous CA.
+	GetMDMAppleSCEPCARotation(ctx context.Context) (*MDMAppleSCEPCARotation, error)
+
+	// PreviewBatchSetMDMAppleProfiles validates the profiles and exclusions
+	// like BatchSetMDMAppleProfiles but does not save them, instead it returns
+	// the number of enrolled hosts that would install or remove profiles.
+	PreviewBatchSetMDMAppleProfiles(ctx context.Context, teamID *uint, teamName *string, profiles [][]byte, exclusions []MDMAppleProfileExclusionSpec, force, acknowledgeReserved bool) (*MDMAppleProfilesPreview, error)
+
+	// GetMDMAppleProfilesJob returns the job that updates the macOS profiles of
+	// the hosts affected by a change of profiles, e.g. as returned by
+	// BatchSetMDMAppleProfiles.
+	GetMDMAppleProfilesJob(ctx context.Context, jobID uint) (*Job, error)
+
+	// MDMAppleDeviceLock remote locks a host and returns the PIN that unlocks
+	// it.
+	MDMAppleDeviceLock(ctx context.Context, hostID uint) (*HostMDMAppleLockPIN, error)
+
+	// MDMAppleQuarantineHost quarantines the host: it moves it to the
+	// quarantine team after making sure the team applies Fleet's network
+	// restrictions profile, records the case ID and, if lock is true, locks the
+	// host. The changes are rolled back if any of the steps fails.
+	MDMAppleQuarantineHost(ctx context.Context, hostID, teamID uint, caseID string, lock bool) (*HostQuarantine, error)
+
+	// MMDAppleEraseDevice erases a host and returns the PIN that unlocks it if
+	// Find My locks it after it is wiped.
+	MDMAppleEraseDevice(ctx context.Context, hostID uint) (*HostMDMAppleLockPIN, error)
+
+	// HostMDMAppleLockPIN returns the PIN of the latest lock or wipe of a
+	// host, needed to unlock it.
+	HostMDMAppleLockPIN(ctx context.Context, hostID uint) (*HostMDMAppleLockPIN, error)
 
 	// MDMAppleEnableFileVaultAndEscrow adds a configuration profile for the
 	// given team that enables FileVault with a config that allows Fleet to
@@ -689,6 +1082,11 @@ type Service interface {
 	// error can be raised to the user.
 	VerifyMDMAppleConfigured(ctx context.Context) error
 
+	// VerifyMDMWindowsConfigured verifies that the server is configured for
+	// Windows MDM. If an error is returned, authorization is skipped so the
+	// error can be raised to the user.
+	VerifyMDMWindowsConfigured(ctx context.Context) error
+
 	MDMAppleUploadBootstrapPackage(ctx context.Context, name string, pkg io.Reader, teamID uint) error
 
 	GetMDMAppleBootstrapPackageBytes(ctx context.Context, token string) (*MDMAppleBootstrapPackage, error)
@@ -699,6 +1097,28 @@ type Service interface {
 
 	GetMDMAppleBootstrapPackageSummary(ctx context.Context, teamID *uint) (*MDMAppleBootstrapPackageSummary, error)
 
+	// GetMDMAppleBootstrapPackageSignatures returns the status of the signing
+	// chains of the bootstrap packages of all teams.
+	GetMDMAppleBootstrapPackageSignatures(ctx context.Context) (*MDMAppleBootstrapPackageSignaturesSummary, error)
+
+	// GetMDMAppleOSUpdatesSummary returns the number of macOS hosts of the team
+	// (or no team) by compliance with its macOS updates settings.
+	GetMDMAppleOSUpdatesSummary(ctx context.Context, teamID *uint) (*MDMAppleOSUpdatesSummary, error)
+
+	// InitiateMDMAppleBootstrapPackageUpload creates a resumable upload
+	// session for a bootstrap package of the given size and sha256 checksum.
+	InitiateMDMAppleBootstrapPackageUpload(ctx context.Context, teamID uint, name string, size int64, sha256 []byte) (*MDMAppleBootstrapPackageUpload, error)
+	// GetMDMAppleBootstrapPackageUpload returns the state of an upload
+	// session, so that clients know the offset at which to resume the upload.
+	GetMDMAppleBootstrapPackageUpload(ctx context.Context, token string) (*MDMAppleBootstrapPackageUpload, error)
+	// AppendMDMAppleBootstrapPackageUpload appends a chunk of the package at
+	// the given offset of the upload session.
+	AppendMDMAppleBootstrapPackageUpload(ctx context.Context, token string, offset int64, chunk []byte) (*MDMAppleBootstrapPackageUpload, error)
+	// CommitMDMAppleBootstrapPackageUpload verifies the checksum and signature
+	// of a completed upload session and sets it as the team's bootstrap
+	// package.
+	CommitMDMAppleBootstrapPackageUpload(ctx context.Context, token string) error
+
 	// MDMAppleGetEULABytes returns the contents of the EULA that matches
 	// the given token.
 	//
@@ -713,6 +1133,10 @@ type Service interface {
 	// MDMAppleDelete EULA removes an EULA entry.
 	MDMAppleDeleteEULA(ctx context.Context, token string) error
 
+	// ListMDMPostureScores returns the daily MDM posture scores of the last
+	// days for the team (0 for no team, nil for all hosts), by date.
+	ListMDMPostureScores(ctx context.Context, teamID *uint, days int) ([]*MDMPostureScore, error)
+
 	// Create or update the MDM Apple Setup Assistant for a team or no team.
 	SetOrUpdateMDMAppleSetupAssistant(ctx context.Context, asst *MDMAppleSetupAssistant) (*MDMAppleSetupAssistant, error)
 	// Get the MDM Apple Setup Assistant for the provided team or no team.
@@ -720,11 +1144,55 @@ type Service interface {
 	// Delete the MDM Apple Setup Assistant for the provided team or no team.
 	DeleteMDMAppleSetupAssistant(ctx context.Context, teamID *uint) error
 
+	// GetHostMDMAppleDEPProfileOverride returns the DEP profile assigned to the
+	// host instead of the default one, or nil if it has no override.
+	GetHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint) (*HostMDMAppleDEPProfileOverride, error)
+	// SetHostMDMAppleDEPProfileOverride sets the DEP profile assigned to the
+	// host instead of the default one. It is assigned by the DEP assigner.
+	SetHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint, profile json.RawMessage) (*HostMDMAppleDEPProfileOverride, error)
+	// DeleteHostMDMAppleDEPProfileOverride clears the DEP profile override of
+	// the host, the default profile is assigned back by the DEP assigner.
+	DeleteHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint) error
+
+	// SetMDMApplePolicyAction creates or replaces the MDM action that runs on
+	// the hosts that start failing the policy.
+	SetMDMApplePolicyAction(ctx context.Context, policyID uint, remediationProfile []byte, commandTemplate string, teamIDs []uint) (*MDMApplePolicyAction, error)
+	// GetMDMApplePolicyAction returns the MDM action of the policy.
+	GetMDMApplePolicyAction(ctx context.Context, policyID uint) (*MDMApplePolicyAction, error)
+	// DeleteMDMApplePolicyAction deletes the MDM action of the policy.
+	DeleteMDMApplePolicyAction(ctx context.Context, policyID uint) error
+
+	///////////////////////////////////////////////////////////////////////////////
+	// Microsoft MDM
+
+	// VerifyMDMMicrosoftDiscovery is called when a Windows device verifies
+	// that the discovery service is reachable.
+	VerifyMDMMicrosoftDiscovery(ctx context.Context) error
+	// GetMDMMicrosoftDiscoveryResponse returns the response to the discovery
+	// request of a Windows device.
+	GetMDMMicrosoftDiscoveryResponse(ctx context.Context, req *SoapRequest) (*SoapResponse, error)
+	// GetMDMMicrosoftPolicyResponse returns the response to the enrollment
+	// policy request of a Windows device.
+	GetMDMMicrosoftPolicyResponse(ctx context.Context, req *SoapRequest) (*SoapResponse, error)
+	// GetMDMMicrosoftEnrollResponse enrolls a Windows device in Fleet's MDM and
+	// returns the response to its enrollment request, with its provisioning
+	// document.
+	GetMDMMicrosoftEnrollResponse(ctx context.Context, req *SoapRequest) (*SoapResponse, error)
+	// GetMDMMicrosoftManagementResponse processes the SyncML message of a
+	// management session of a Windows device and returns the response. The
+	// device is authenticated by its TLS client certificates.
+	GetMDMMicrosoftManagementResponse(ctx context.Context, msg *SyncMLMessage, certs []*x509.Certificate) (*SyncMLMessage, error)
+
 	///////////////////////////////////////////////////////////////////////////////
 	// CronSchedulesService
 
 	// TriggerCronSchedule attempts to trigger an ad-hoc run of the named cron schedule.
 	TriggerCronSchedule(ctx context.Context, name string) error
+	// ListMDMCronSchedules returns the health status of the MDM cron schedules (the profile manager
+	// and the DEP profile assigner).
+	ListMDMCronSchedules(ctx context.Context) ([]*CronScheduleStatus, error)
+	// TriggerMDMCronSchedule attempts to trigger an ad-hoc run of the named MDM cron schedule.
+	TriggerMDMCronSchedule(ctx context.Context, name string) error
 
 	// ResetAutomation sets the policies and all policies of the listed teams to fire again
 	// for all hosts that are already marked as failing.
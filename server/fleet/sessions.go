@@ -66,6 +66,24 @@ type Session struct {
 	UserID     uint      `json:"user_id" db:"user_id"`
 	Key        string
 	APIOnly    *bool `json:"-" db:"api_only"`
+	// ReauthenticatedAt is the last time the user confirmed their password
+	// in this session, nil if they never did.
+	ReauthenticatedAt *time.Time `json:"-" db:"reauthenticated_at"`
+}
+
+// SessionSudoModeDuration is how long a session stays in sudo mode after the
+// user logged in or re-authenticated. Security-sensitive actions, such as
+// reading a disk encryption key, require the session to be in sudo mode.
+const SessionSudoModeDuration = 5 * time.Minute
+
+// InSudoMode returns true if the user logged in or re-authenticated less than
+// SessionSudoModeDuration ago.
+func (s Session) InSudoMode(now time.Time) bool {
+	since := s.CreatedAt
+	if s.ReauthenticatedAt != nil && s.ReauthenticatedAt.After(since) {
+		since = *s.ReauthenticatedAt
+	}
+	return now.Sub(since) < SessionSudoModeDuration
 }
 
 func (s Session) AuthzType() string {
@@ -29,9 +29,11 @@ type TeamPayload struct {
 // need to be able which part of the MDM config was provided in the request,
 // so the fields are pointers to structs.
 type TeamPayloadMDM struct {
-	MacOSUpdates  *MacOSUpdates  `json:"macos_updates"`
-	MacOSSettings *MacOSSettings `json:"macos_settings"`
-	MacOSSetup    *MacOSSetup    `json:"macos_setup"`
+	MacOSUpdates  *MacOSUpdates    `json:"macos_updates"`
+	MacOSSettings *MacOSSettings   `json:"macos_settings"`
+	MacOSSetup    *MacOSSetup      `json:"macos_setup"`
+	Timezone      *string          `json:"timezone"`
+	Localization  *MDMLocalization `json:"localization"`
 }
 
 // Team is the data representation for the "Team" concept (group of hosts and
@@ -146,6 +148,18 @@ type TeamMDM struct {
 	MacOSUpdates  MacOSUpdates  `json:"macos_updates"`
 	MacOSSettings MacOSSettings `json:"macos_settings"`
 	MacOSSetup    MacOSSetup    `json:"macos_setup"`
+	// MacOSMigration configures the migration of the team's macOS hosts from
+	// another MDM solution.
+	MacOSMigration MacOSMigration `json:"macos_migration"`
+	// MacOSEnrollmentEligibility configures the hardware rules the team's
+	// macOS hosts must meet to enroll.
+	MacOSEnrollmentEligibility MacOSEnrollmentEligibility `json:"macos_enrollment_eligibility"`
+	// Timezone is the IANA time zone name (e.g. "America/New_York") in which
+	// the team's MDM jobs are scheduled. Empty means UTC.
+	Timezone string `json:"timezone"`
+	// Localization configures the translations of the strings rendered to
+	// the team's end users, it takes precedence over the global one.
+	Localization MDMLocalization `json:"localization"`
 	// NOTE: TeamSpecMDM must be kept in sync with TeamMDM.
 }
 
@@ -160,6 +174,11 @@ type TeamSpecMDM struct {
 	MacOSSettings map[string]interface{} `json:"macos_settings"`
 	MacOSSetup    MacOSSetup             `json:"macos_setup"`
 
+	MacOSMigration             MacOSMigration             `json:"macos_migration"`
+	MacOSEnrollmentEligibility MacOSEnrollmentEligibility `json:"macos_enrollment_eligibility"`
+	Timezone                   string                     `json:"timezone"`
+	Localization               MDMLocalization            `json:"localization"`
+
 	// NOTE: TeamMDM must be kept in sync with TeamSpecMDM.
 }
 
@@ -192,6 +211,41 @@ func (t Team) AuthzType() string {
 	return "team"
 }
 
+// TeamDeletionReport reports what deleting a team affects. When a team is
+// deleted, its hosts are moved to no team and the profiles of the team are
+// removed from them.
+type TeamDeletionReport struct {
+	TeamID   uint   `json:"team_id" db:"-"`
+	TeamName string `json:"team_name" db:"-"`
+	// HostsCount is the number of hosts of the team.
+	HostsCount uint `json:"hosts_count" db:"hosts_count"`
+	// MDMEnrolledHostsCount is the number of hosts of the team enrolled in
+	// Fleet's MDM, the profiles of which are updated.
+	MDMEnrolledHostsCount uint `json:"mdm_enrolled_hosts_count" db:"mdm_enrolled_hosts_count"`
+	// ProfilesCount is the number of custom configuration profiles of the team.
+	ProfilesCount uint `json:"profiles_count" db:"profiles_count"`
+	// HostProfilesToRemoveCount is the number of profiles of the team
+	// installed (or being installed) on its hosts, that are set to pending
+	// removal.
+	HostProfilesToRemoveCount uint `json:"host_profiles_to_remove_count" db:"host_profiles_to_remove_count"`
+}
+
+// TeamDeletionProgress reports the progress of a staged team deletion, which
+// moves the hosts of the team to a destination team in batches before
+// deleting it.
+type TeamDeletionProgress struct {
+	TeamID   uint   `json:"team_id"`
+	TeamName string `json:"team_name"`
+	// DestinationTeamID is the team the hosts are moved to, 0 for no team.
+	DestinationTeamID uint `json:"destination_team_id"`
+	// HostsCount is the number of hosts of the team when the deletion was
+	// staged, and HostsMoved the number of those already moved.
+	HostsCount  uint `json:"hosts_count"`
+	HostsMoved  uint `json:"hosts_moved"`
+	TeamDeleted bool `json:"team_deleted"`
+	Job         *Job `json:"job"`
+}
+
 // TeamUser is a user mapped to a team with a role.
 type TeamUser struct {
 	// User is the user object. At least ID must be specified for most uses.
@@ -314,6 +368,9 @@ type TeamFilter struct {
 	User *User
 	// IncludeObserver determines whether to include teams the user is an observer on.
 	IncludeObserver bool
+	// IncludeGitOps determines whether to include teams the user has the
+	// GitOps role on.
+	IncludeGitOps bool
 	// TeamID is the specific team id to filter by. If other criteria are
 	// specified, they must met too (e.g. if a User is provided, that team ID
 	// must be part of their teams).
@@ -365,6 +422,10 @@ func TeamSpecFromTeam(t *Team) (*TeamSpec, error) {
 	mdmSpec.MacOSUpdates = t.Config.MDM.MacOSUpdates
 	mdmSpec.MacOSSettings = t.Config.MDM.MacOSSettings.ToMap()
 	mdmSpec.MacOSSetup = t.Config.MDM.MacOSSetup
+	mdmSpec.MacOSMigration = t.Config.MDM.MacOSMigration
+	mdmSpec.MacOSEnrollmentEligibility = t.Config.MDM.MacOSEnrollmentEligibility
+	mdmSpec.Timezone = t.Config.MDM.Timezone
+	mdmSpec.Localization = t.Config.MDM.Localization
 	return &TeamSpec{
 		Name:         t.Name,
 		AgentOptions: agentOptions,
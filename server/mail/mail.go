@@ -292,3 +292,29 @@ func getTemplate(templatePath string) (*template.Template, error) {
 
 	return t, nil
 }
+
+// MDMCronJobFailureMailer is used to build the email sent to the admins when
+// an MDM cron job reaches the failure threshold, or recovers.
+type MDMCronJobFailureMailer struct {
+	BaseURL             template.URL
+	AssetURL            template.URL
+	Schedule            string
+	Job                 string
+	ConsecutiveFailures int
+	Error               string
+	Recovered           bool
+}
+
+func (m *MDMCronJobFailureMailer) Message() ([]byte, error) {
+	t, err := getTemplate("server/mail/templates/mdm_cron_job_failure.html")
+	if err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	if err = t.Execute(&msg, m); err != nil {
+		return nil, err
+	}
+
+	return msg.Bytes(), nil
+}
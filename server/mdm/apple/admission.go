@@ -0,0 +1,337 @@
+package apple_mdm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Results of the admission of an enrollment request, used as label of the
+// admission metrics.
+const (
+	admissionAdmitted         = "admitted"
+	admissionRejectedFull     = "rejected_queue_full"
+	admissionRejectedTimeout  = "rejected_timeout"
+	admissionRejectedCanceled = "canceled"
+
+	// minRetryAfter and maxRetryAfter bound the delay suggested to the
+	// rejected devices.
+	minRetryAfter = time.Second
+	maxRetryAfter = 5 * time.Minute
+)
+
+var (
+	enrollmentAdmissionRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "mdm_apple",
+			Name:      "enrollment_admission_requests_total",
+			Help:      "Total number of enrollment requests (SCEP and check-ins) subject to admission control, by result.",
+		},
+		[]string{"result"},
+	)
+	enrollmentAdmissionInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "mdm_apple",
+			Name:      "enrollment_admission_in_flight",
+			Help:      "Number of enrollment requests currently being processed.",
+		},
+	)
+	enrollmentAdmissionQueued = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "mdm_apple",
+			Name:      "enrollment_admission_queued",
+			Help:      "Number of enrollment requests waiting to be processed.",
+		},
+	)
+	enrollmentAdmissionWait = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Subsystem: "mdm_apple",
+			Name:      "enrollment_admission_wait_seconds",
+			Help:      "Time spent by the admitted enrollment requests waiting in the queue.",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(enrollmentAdmissionRequests, enrollmentAdmissionInFlight,
+		enrollmentAdmissionQueued, enrollmentAdmissionWait)
+}
+
+// ErrEnrollmentNotAdmitted is returned by EnrollmentAdmission.Acquire when
+// the request was not admitted, because the queue is full or the request
+// waited for too long.
+var ErrEnrollmentNotAdmitted = errors.New("enrollment request not admitted")
+
+// EnrollmentAdmission limits the number of enrollment requests (SCEP and
+// check-ins) processed concurrently, to protect the database from the bursts
+// of requests of mass (re-)enrollments. The requests above the limit wait in
+// a queue per fairness key (the team of the enrollment when it is known), and
+// the queues are served in turn so that a team's burst doesn't starve the
+// others. The requests that can't be queued or that wait for too long are
+// rejected with a Retry-After estimated from the current backlog.
+//
+// A nil *EnrollmentAdmission admits all requests.
+type EnrollmentAdmission struct {
+	maxConcurrent int
+	maxQueued     int
+	queueTimeout  time.Duration
+
+	mu      sync.Mutex
+	running int
+	queued  int
+	queues  map[string][]*admissionWaiter
+	// keys is the round-robin order of the fairness keys with queued
+	// requests, next is the index of the key to serve next.
+	keys []string
+	next int
+	// avgDuration is a moving average of the processing time of the requests,
+	// used to estimate the Retry-After delay.
+	avgDuration time.Duration
+}
+
+type admissionWaiter struct {
+	ready chan struct{}
+	// granted is set (with the admission's mutex held) when the waiter is
+	// given a slot.
+	granted bool
+}
+
+// NewEnrollmentAdmission returns an EnrollmentAdmission that processes at
+// most maxConcurrent requests at once and queues at most maxQueued others for
+// up to queueTimeout. It returns nil (i.e. no admission control) if
+// maxConcurrent is 0.
+func NewEnrollmentAdmission(maxConcurrent, maxQueued int, queueTimeout time.Duration) *EnrollmentAdmission {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	return &EnrollmentAdmission{
+		maxConcurrent: maxConcurrent,
+		maxQueued:     maxQueued,
+		queueTimeout:  queueTimeout,
+		queues:        make(map[string][]*admissionWaiter),
+		avgDuration:   time.Second,
+	}
+}
+
+// Acquire waits until the request identified by the fairness key can be
+// processed. On success it returns the function to call once the request is
+// processed. Otherwise it returns ErrEnrollmentNotAdmitted (or the error of
+// the context) and the delay after which the request should be retried.
+func (a *EnrollmentAdmission) Acquire(ctx context.Context, key string) (release func(), retryAfter time.Duration, err error) {
+	if a == nil {
+		return func() {}, 0, nil
+	}
+
+	a.mu.Lock()
+	if a.running < a.maxConcurrent && a.queued == 0 {
+		a.running++
+		a.mu.Unlock()
+		enrollmentAdmissionInFlight.Inc()
+		enrollmentAdmissionRequests.WithLabelValues(admissionAdmitted).Inc()
+		enrollmentAdmissionWait.Observe(0)
+		return a.releaseFunc(time.Now()), 0, nil
+	}
+	if a.queued >= a.maxQueued {
+		retryAfter := a.retryAfterLocked()
+		a.mu.Unlock()
+		enrollmentAdmissionRequests.WithLabelValues(admissionRejectedFull).Inc()
+		return nil, retryAfter, ErrEnrollmentNotAdmitted
+	}
+	w := &admissionWaiter{ready: make(chan struct{})}
+	if len(a.queues[key]) == 0 {
+		a.keys = append(a.keys, key)
+	}
+	a.queues[key] = append(a.queues[key], w)
+	a.queued++
+	a.mu.Unlock()
+	enrollmentAdmissionQueued.Inc()
+
+	start := time.Now()
+	timer := time.NewTimer(a.queueTimeout)
+	defer timer.Stop()
+
+	var result string
+	select {
+	case <-w.ready:
+		enrollmentAdmissionWait.Observe(time.Since(start).Seconds())
+		enrollmentAdmissionRequests.WithLabelValues(admissionAdmitted).Inc()
+		return a.releaseFunc(time.Now()), 0, nil
+	case <-timer.C:
+		err, result = ErrEnrollmentNotAdmitted, admissionRejectedTimeout
+	case <-ctx.Done():
+		err, result = ctx.Err(), admissionRejectedCanceled
+	}
+
+	a.mu.Lock()
+	if w.granted {
+		// the slot was given to the request at the same time, pass it on.
+		a.releaseSlotLocked()
+	} else {
+		a.removeWaiterLocked(key, w)
+	}
+	retryAfter = a.retryAfterLocked()
+	a.mu.Unlock()
+	enrollmentAdmissionRequests.WithLabelValues(result).Inc()
+	return nil, retryAfter, err
+}
+
+func (a *EnrollmentAdmission) releaseFunc(start time.Time) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+
+			// exponential moving average, giving a weight of 1/8 to the new value.
+			a.avgDuration += (time.Since(start) - a.avgDuration) / 8
+			a.releaseSlotLocked()
+		})
+	}
+}
+
+// releaseSlotLocked hands over the processing slot of a request that is done
+// to the next waiting request, or frees it if no request is waiting.
+func (a *EnrollmentAdmission) releaseSlotLocked() {
+	if w := a.dequeueLocked(); w != nil {
+		// running is unchanged, the slot is taken over by the waiting request.
+		w.granted = true
+		close(w.ready)
+		return
+	}
+	a.running--
+	enrollmentAdmissionInFlight.Dec()
+}
+
+// dequeueLocked removes and returns the next waiting request, taking the
+// fairness keys in turn. It returns nil if no request is waiting.
+func (a *EnrollmentAdmission) dequeueLocked() *admissionWaiter {
+	if len(a.keys) == 0 {
+		return nil
+	}
+	if a.next >= len(a.keys) {
+		a.next = 0
+	}
+	key := a.keys[a.next]
+	q := a.queues[key]
+	w := q[0]
+	if len(q) == 1 {
+		delete(a.queues, key)
+		a.keys = append(a.keys[:a.next], a.keys[a.next+1:]...)
+		// a.next now points to the key following the removed one.
+	} else {
+		a.queues[key] = q[1:]
+		a.next++
+	}
+	a.queued--
+	enrollmentAdmissionQueued.Dec()
+	return w
+}
+
+func (a *EnrollmentAdmission) removeWaiterLocked(key string, w *admissionWaiter) {
+	q := a.queues[key]
+	for i, qw := range q {
+		if qw == w {
+			q = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+	a.queued--
+	enrollmentAdmissionQueued.Dec()
+	if len(q) > 0 {
+		a.queues[key] = q
+		return
+	}
+	delete(a.queues, key)
+	for i, k := range a.keys {
+		if k == key {
+			a.keys = append(a.keys[:i], a.keys[i+1:]...)
+			if i < a.next {
+				a.next--
+			}
+			break
+		}
+	}
+}
+
+// retryAfterLocked estimates the time needed to process the current backlog.
+func (a *EnrollmentAdmission) retryAfterLocked() time.Duration {
+	batches := math.Ceil(float64(a.queued+1) / float64(a.maxConcurrent))
+	d := time.Duration(batches) * a.avgDuration
+	if d < minRetryAfter {
+		d = minRetryAfter
+	}
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d
+}
+
+// Middleware returns a handler that subjects the requests for which classify
+// returns true to the admission control, using the fairness key it returns.
+// The rejected requests get a 503 Service Unavailable response with a
+// Retry-After header.
+func (a *EnrollmentAdmission) Middleware(next http.Handler, classify func(r *http.Request) (key string, ok bool)) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := classify(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		release, retryAfter, err := a.Acquire(r.Context(), key)
+		if err != nil {
+			secs := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			http.Error(w, "Too many enrollment requests, retry in "+strconv.Itoa(secs)+" seconds.", http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClassifySCEPEnrollment classifies the SCEP requests that issue or renew a
+// certificate (the PKIOperation). SCEP requests don't carry the team of the
+// enrollment, so they share the same fairness key.
+func ClassifySCEPEnrollment(r *http.Request) (string, bool) {
+	return "", r.URL.Query().Get("operation") == "PKIOperation"
+}
+
+// ClassifyMDMEnrollment classifies the MDM check-in requests that are part of
+// an enrollment (Authenticate and TokenUpdate). The fairness key is the team
+// enrollment token of the request, if any.
+func ClassifyMDMEnrollment(r *http.Request) (string, bool) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-apple-aspen-mdm-checkin") || r.Body == nil {
+		return "", false
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		// let the MDM handler deal with it.
+		return "", false
+	}
+	var msg struct {
+		MessageType string
+	}
+	if err := plist.Unmarshal(body, &msg); err != nil {
+		return "", false
+	}
+	if msg.MessageType != "Authenticate" && msg.MessageType != "TokenUpdate" {
+		return "", false
+	}
+	return r.URL.Query().Get(EnrollTeamTokenKey), true
+}
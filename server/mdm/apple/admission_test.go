@@ -0,0 +1,202 @@
+package apple_mdm
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnrollmentAdmissionDisabled(t *testing.T) {
+	a := NewEnrollmentAdmission(0, 10, time.Second)
+	require.Nil(t, a)
+
+	release, _, err := a.Acquire(context.Background(), "")
+	require.NoError(t, err)
+	release()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := a.Middleware(next, ClassifySCEPEnrollment)
+	require.NotNil(t, h)
+}
+
+func TestEnrollmentAdmissionFairness(t *testing.T) {
+	ctx := context.Background()
+	a := NewEnrollmentAdmission(1, 10, time.Minute)
+
+	release, _, err := a.Acquire(ctx, "")
+	require.NoError(t, err)
+
+	// queue 3 requests of team a, then 1 of team b, they are admitted in turn
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+	enqueue := func(key, name string) {
+		a.mu.Lock()
+		want := a.queued + 1
+		a.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rel, _, err := a.Acquire(ctx, key)
+			require.NoError(t, err)
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			rel()
+		}()
+		// wait for the request to be queued to guarantee the order
+		require.Eventually(t, func() bool {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			return a.queued == want
+		}, time.Second, time.Millisecond)
+	}
+	enqueue("a", "a1")
+	enqueue("a", "a2")
+	enqueue("a", "a3")
+	enqueue("b", "b1")
+
+	release()
+	wg.Wait()
+	require.Equal(t, []string{"a1", "b1", "a2", "a3"}, order)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	require.Zero(t, a.running)
+	require.Zero(t, a.queued)
+	require.Empty(t, a.keys)
+}
+
+func TestEnrollmentAdmissionRejections(t *testing.T) {
+	ctx := context.Background()
+	a := NewEnrollmentAdmission(1, 1, 50*time.Millisecond)
+
+	release, _, err := a.Acquire(ctx, "")
+	require.NoError(t, err)
+
+	// the request waits for too long
+	_, retryAfter, err := a.Acquire(ctx, "")
+	require.ErrorIs(t, err, ErrEnrollmentNotAdmitted)
+	require.GreaterOrEqual(t, retryAfter, minRetryAfter)
+
+	// the queue is full
+	done := make(chan error)
+	go func() {
+		_, _, err := a.Acquire(ctx, "a")
+		done <- err
+	}()
+	require.Eventually(t, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return a.queued == 1
+	}, time.Second, time.Millisecond)
+	_, retryAfter, err = a.Acquire(ctx, "b")
+	require.ErrorIs(t, err, ErrEnrollmentNotAdmitted)
+	require.GreaterOrEqual(t, retryAfter, minRetryAfter)
+	require.LessOrEqual(t, retryAfter, maxRetryAfter)
+	require.ErrorIs(t, <-done, ErrEnrollmentNotAdmitted)
+
+	// a canceled request is removed from the queue
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _, err = a.Acquire(cctx, "")
+	require.ErrorIs(t, err, context.Canceled)
+
+	release()
+	a.mu.Lock()
+	require.Zero(t, a.running)
+	require.Zero(t, a.queued)
+	a.mu.Unlock()
+
+	// the slot is available again
+	release, _, err = a.Acquire(ctx, "")
+	require.NoError(t, err)
+	release()
+}
+
+func TestEnrollmentAdmissionMiddleware(t *testing.T) {
+	a := NewEnrollmentAdmission(1, 0, time.Millisecond)
+
+	block, entered := make(chan struct{}), make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("block") != "" {
+			close(entered)
+			<-block
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := httptest.NewServer(a.Middleware(next, ClassifySCEPEnrollment))
+	defer srv.Close()
+
+	go func() {
+		resp, err := http.Get(srv.URL + "?operation=PKIOperation&block=1")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-entered
+
+	// an enrollment request is rejected while the slot is taken
+	resp, err := http.Get(srv.URL + "?operation=PKIOperation")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	// other requests are not subject to the admission control
+	resp, err = http.Get(srv.URL + "?operation=GetCACert")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	close(block)
+}
+
+func TestClassifyMDMEnrollment(t *testing.T) {
+	checkin := func(msgType, query string) *http.Request {
+		body := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>` + msgType + `</string>
+	<key>UDID</key>
+	<string>ABC</string>
+</dict>
+</plist>`
+		r := httptest.NewRequest(http.MethodPut, "/mdm/apple/mdm"+query, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-apple-aspen-mdm-checkin")
+		return r
+	}
+
+	r := checkin("Authenticate", "?"+EnrollTeamTokenKey+"=tok")
+	key, ok := ClassifyMDMEnrollment(r)
+	require.True(t, ok)
+	require.Equal(t, "tok", key)
+	// the body can still be read by the MDM handler
+	b, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(b), "Authenticate")
+
+	key, ok = ClassifyMDMEnrollment(checkin("TokenUpdate", ""))
+	require.True(t, ok)
+	require.Empty(t, key)
+
+	_, ok = ClassifyMDMEnrollment(checkin("CheckOut", ""))
+	require.False(t, ok)
+
+	// command requests are not enrollments
+	r = httptest.NewRequest(http.MethodPut, "/mdm/apple/mdm", strings.NewReader("<plist></plist>"))
+	_, ok = ClassifyMDMEnrollment(r)
+	require.False(t, ok)
+}
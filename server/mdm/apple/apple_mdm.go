@@ -3,9 +3,12 @@ package apple_mdm
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"path"
 	"strings"
@@ -13,6 +16,7 @@ import (
 	"time"
 
 	"github.com/fleetdm/fleet/v4/pkg/fleethttp"
+	"github.com/fleetdm/fleet/v4/server/config"
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/logging"
@@ -23,6 +27,7 @@ import (
 	"github.com/micromdm/nanodep/godep"
 
 	kitlog "github.com/go-kit/kit/log"
+	depclient "github.com/micromdm/nanodep/client"
 	nanodep_storage "github.com/micromdm/nanodep/storage"
 	depsync "github.com/micromdm/nanodep/sync"
 )
@@ -41,6 +46,9 @@ const (
 
 	// EnrollPath is the HTTP path that serves the mobile profile to devices when enrolling.
 	EnrollPath = "/api/mdm/apple/enroll"
+	// EnrollLinkPath is the HTTP path that serves the mobile profile to
+	// devices enrolling with an enrollment link.
+	EnrollLinkPath = "/api/mdm/apple/enroll_link"
 	// InstallerPath is the HTTP path that serves installers to Apple devices.
 	InstallerPath = "/api/mdm/apple/installer"
 
@@ -51,6 +59,23 @@ const (
 	// FleetdPublicManifestURL contains a valid manifest that can be used
 	// by InstallEnterpriseApplication to install `fleetd` in a host.
 	FleetdPublicManifestURL = "https://download.fleetdm.com/fleetd-base-manifest.plist"
+
+	// EnrollReferenceKey is the query parameter that holds the reference to
+	// the MDM IdP account of the end user that is enrolling the device. It is
+	// set on the enroll URL and, via the enrollment profile, on the URL of
+	// the MDM check-in requests.
+	EnrollReferenceKey = "enrollment_reference"
+
+	// EnrollLinkKey is the query parameter that holds the token of the
+	// enrollment link used to enroll the device. It is set on the enroll link
+	// URL and, via the enrollment profile, on the URL of the MDM check-in
+	// requests.
+	EnrollLinkKey = "enrollment_link"
+
+	// EnrollTeamTokenKey is the query parameter that holds the enrollment
+	// token of the team of a reusable enrollment profile. It is set, via the
+	// enrollment profile, on the URL of the MDM check-in requests.
+	EnrollTeamTokenKey = "enrollment_team_token"
 )
 
 func ResolveAppleMDMURL(serverURL string) (string, error) {
@@ -65,6 +90,57 @@ func ResolveAppleSCEPURL(serverURL string) (string, error) {
 	return resolveURL(serverURL, SCEPPath)
 }
 
+// ResolveAppleEnrollLinkURL returns the URL of the enrollment link with the
+// given token.
+func ResolveAppleEnrollLinkURL(serverURL, token string) (string, error) {
+	u, err := resolveURL(serverURL, EnrollLinkPath)
+	if err != nil {
+		return "", err
+	}
+	return addQueryToURL(u, EnrollLinkKey, token)
+}
+
+// AddEnrollmentRefToFleetURL adds the enrollment reference as a query
+// parameter to the Fleet server URL, so that the URLs resolved from it carry
+// the reference.
+func AddEnrollmentRefToFleetURL(fleetURL, reference string) (string, error) {
+	if reference == "" {
+		return fleetURL, nil
+	}
+	return addQueryToURL(fleetURL, EnrollReferenceKey, reference)
+}
+
+// AddEnrollmentLinkToFleetURL adds the token of the enrollment link as a
+// query parameter to the Fleet server URL, so that the URLs resolved from it
+// carry the token.
+func AddEnrollmentLinkToFleetURL(fleetURL, token string) (string, error) {
+	if token == "" {
+		return fleetURL, nil
+	}
+	return addQueryToURL(fleetURL, EnrollLinkKey, token)
+}
+
+// AddEnrollmentTeamTokenToFleetURL adds the enrollment token of a team as a
+// query parameter to the Fleet server URL, so that the URLs resolved from it
+// carry the token.
+func AddEnrollmentTeamTokenToFleetURL(fleetURL, token string) (string, error) {
+	if token == "" {
+		return fleetURL, nil
+	}
+	return addQueryToURL(fleetURL, EnrollTeamTokenKey, token)
+}
+
+func addQueryToURL(rawURL, key, value string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing configured server URL: %w", err)
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
 func resolveURL(serverURL, relPath string) (string, error) {
 	u, err := url.Parse(serverURL)
 	if err != nil {
@@ -83,10 +159,21 @@ func resolveURL(serverURL, relPath string) (string, error) {
 type DEPService struct {
 	ds         fleet.Datastore
 	depStorage nanodep_storage.AllStorage
-	syncer     *depsync.Syncer
+	syncer     *depSyncer
 	logger     kitlog.Logger
 }
 
+// DEPServiceOption configures a DEPService.
+type DEPServiceOption func(*DEPService)
+
+// WithDEPSyncWindows restricts the DEP devices sync to the provided daily
+// windows of time.
+func WithDEPSyncWindows(windows []config.DailyWindow) DEPServiceOption {
+	return func(d *DEPService) {
+		d.syncer.windows = windows
+	}
+}
+
 // GetDefaultProfile returns a godep.Profile with default values set.
 func (d *DEPService) GetDefaultProfile() *godep.Profile {
 	return &godep.Profile{
@@ -169,12 +256,45 @@ func (d *DEPService) createProfile(ctx context.Context, depProfile *godep.Profil
 
 // RegisterProfileWithAppleDEPServer registers the enrollment profile in
 // Apple's servers via the DEP API, so it can be used for assignment.
+//
+// The variables referenced in the string values of the profile are resolved
+// at this time, with the team of the profile being the team assigned to the
+// hosts enrolled via ABM (see ExpandSetupAssistantVariables).
 func (d *DEPService) RegisterProfileWithAppleDEPServer(ctx context.Context, depProfile *godep.Profile, enrollURL string) error {
 	appConfig, err := d.ds.AppConfig(ctx)
 	if err != nil {
 		return fmt.Errorf("get app config: %w", err)
 	}
 
+	profileUUID, err := d.defineProfile(ctx, appConfig, depProfile, enrollURL, appConfig.MDM.AppleBMDefaultTeam)
+	if err != nil {
+		return err
+	}
+
+	if err := d.depStorage.StoreAssignerProfile(ctx, DEPName, profileUUID); err != nil {
+		return ctxerr.Wrap(ctx, err, "set profile UUID")
+	}
+
+	return nil
+}
+
+// defineProfile defines the enrollment profile in Apple's servers via the DEP
+// API, with its variables resolved for the provided team name, and returns the
+// UUID of the profile.
+func (d *DEPService) defineProfile(ctx context.Context, appConfig *fleet.AppConfig, depProfile *godep.Profile, enrollURL, teamName string) (string, error) {
+	rawProfile, err := json.Marshal(depProfile)
+	if err != nil {
+		return "", ctxerr.Wrap(ctx, err, "marshal profile")
+	}
+	rawProfile, err = ExpandSetupAssistantVariables(rawProfile, NewSetupAssistantVariables(appConfig, teamName))
+	if err != nil {
+		return "", ctxerr.Wrap(ctx, err, "expand profile variables")
+	}
+	depProfile = new(godep.Profile)
+	if err := json.Unmarshal(rawProfile, depProfile); err != nil {
+		return "", ctxerr.Wrap(ctx, err, "unmarshal expanded profile")
+	}
+
 	depProfile.URL = enrollURL
 
 	// If SSO is configured, use the `/mdm/sso` page which starts the SSO
@@ -193,14 +313,9 @@ func (d *DEPService) RegisterProfileWithAppleDEPServer(ctx context.Context, depP
 	depClient := NewDEPClient(d.depStorage, d.ds, d.logger)
 	res, err := depClient.DefineProfile(ctx, DEPName, depProfile)
 	if err != nil {
-		return ctxerr.Wrap(ctx, err, "apple POST /profile request failed")
+		return "", ctxerr.Wrap(ctx, err, "apple POST /profile request failed")
 	}
-
-	if err := d.depStorage.StoreAssignerProfile(ctx, DEPName, res.ProfileUUID); err != nil {
-		return ctxerr.Wrap(ctx, err, "set profile UUID")
-	}
-
-	return nil
+	return res.ProfileUUID, nil
 }
 
 // EnrollURL returns an URL that can be used to obtain an MDM enrollment
@@ -215,6 +330,11 @@ func (d *DEPService) EnrollURL(token string) (string, error) {
 }
 
 func (d *DEPService) RunAssigner(ctx context.Context) error {
+	if !d.syncer.inWindow() {
+		level.Debug(d.logger).Log("msg", "outside of the DEP sync windows, skipping")
+		return nil
+	}
+
 	profileUUID, profileModTime, err := d.depStorage.RetrieveAssignerProfile(ctx, DEPName)
 	if err != nil {
 		return err
@@ -244,11 +364,123 @@ func (d *DEPService) RunAssigner(ctx context.Context) error {
 	// the cursor and perform a full sync of all devices and profile assigning.
 	if cursor != "" && profileModTime.After(cursorModTime) {
 		d.logger.Log("msg", "clearing device syncer cursor")
-		if err := d.depStorage.StoreCursor(ctx, DEPName, ""); err != nil {
+		// if another runner changed the cursor in the meantime, it already
+		// cleared it or synced after the profile change.
+		if err := swapDEPCursor(ctx, d.depStorage, cursor, ""); err != nil && !errors.Is(err, ErrDEPCursorConflict) {
 			return err
 		}
 	}
-	return d.syncer.Run(ctx)
+	if err := d.syncer.Run(ctx); err != nil {
+		return err
+	}
+	if err := d.assignDEPProfileOverrides(ctx); err != nil {
+		return err
+	}
+	d.syncOrgName(ctx)
+	return nil
+}
+
+// assignDEPProfileOverrides defines and assigns the DEP profile overrides
+// that are pending assignment, and assigns the default profile back to the
+// hosts whose override was cleared. A failed assignment is only logged, it is
+// retried on the next run.
+func (d *DEPService) assignDEPProfileOverrides(ctx context.Context) error {
+	pending, err := d.ds.ListPendingHostMDMAppleDEPProfileOverrides(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list pending dep profile overrides")
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	appConfig, err := d.ds.AppConfig(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get app config")
+	}
+	defaultProfileUUID, _, err := d.depStorage.RetrieveAssignerProfile(ctx, DEPName)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "retrieve assigner profile")
+	}
+	var enrollURL string
+
+	for _, override := range pending {
+		logger := kitlog.With(d.logger, "host_id", override.HostID, "serial", override.HardwareSerial)
+
+		profileUUID := override.ProfileUUID
+		switch {
+		case override.Profile == nil:
+			profileUUID = defaultProfileUUID
+		case profileUUID == "":
+			if enrollURL == "" {
+				enrollURL, err = d.automaticEnrollURL(ctx, appConfig)
+				if err != nil {
+					return err
+				}
+			}
+			var depProfile godep.Profile
+			if err := json.Unmarshal(*override.Profile, &depProfile); err != nil {
+				level.Error(logger).Log("msg", "unmarshal dep profile override", "err", err)
+				continue
+			}
+			var teamName string
+			if override.TeamName != nil {
+				teamName = *override.TeamName
+			}
+			profileUUID, err = d.defineProfile(ctx, appConfig, &depProfile, enrollURL, teamName)
+			if err != nil {
+				level.Error(logger).Log("msg", "define dep profile override", "err", err)
+				continue
+			}
+		}
+		if profileUUID == "" {
+			// no default profile to assign back yet
+			continue
+		}
+
+		res, err := d.syncer.client.AssignProfile(ctx, DEPName, profileUUID, override.HardwareSerial)
+		if err != nil {
+			level.Error(logger).Log("msg", "assign dep profile override", "err", err)
+			continue
+		}
+		if status := res.Devices[override.HardwareSerial]; status != "SUCCESS" {
+			level.Info(logger).Log("msg", "dep profile override not assigned", "status", status)
+			continue
+		}
+		if err := d.ds.SetHostMDMAppleDEPProfileOverrideAssigned(ctx, override.HostID, profileUUID); err != nil {
+			return ctxerr.Wrap(ctx, err, "set dep profile override assigned")
+		}
+	}
+	return nil
+}
+
+// automaticEnrollURL returns the enroll URL of the automatic enrollment
+// profile.
+func (d *DEPService) automaticEnrollURL(ctx context.Context, appConfig *fleet.AppConfig) (string, error) {
+	profiles, err := d.ds.ListMDMAppleEnrollmentProfiles(ctx)
+	if err != nil {
+		return "", ctxerr.Wrap(ctx, err, "list enrollment profiles")
+	}
+	for _, prof := range profiles {
+		if prof.Type == "automatic" {
+			return EnrollURL(prof.Token, appConfig)
+		}
+	}
+	return "", ctxerr.New(ctx, "automatic enrollment profile not found")
+}
+
+// syncOrgName stores the name of the Apple Business Manager organization in
+// the device information of the DEP hosts, as it is not part of the devices
+// returned by the sync. Failures are only logged, the org name is synced again
+// on the next run.
+func (d *DEPService) syncOrgName(ctx context.Context) {
+	detail, err := d.syncer.client.AccountDetail(ctx, DEPName)
+	if err != nil {
+		level.Error(d.logger).Log("msg", "get DEP account detail", "err", err)
+		return
+	}
+	if err := d.ds.SetMDMAppleDEPDevicesOrgName(ctx, detail.OrgName); err != nil {
+		level.Error(d.logger).Log("msg", "set DEP devices org name", "err", err)
+	}
 }
 
 func NewDEPService(
@@ -256,6 +488,7 @@ func NewDEPService(
 	depStorage nanodep_storage.AllStorage,
 	logger kitlog.Logger,
 	loggingDebug bool,
+	opts ...DEPServiceOption,
 ) *DEPService {
 	depClient := NewDEPClient(depStorage, ds, logger)
 	assignerOpts := []depsync.AssignerOption{
@@ -271,17 +504,19 @@ func NewDEPService(
 		assignerOpts...,
 	)
 
-	syncer := depsync.NewSyncer(
-		depClient,
-		DEPName,
-		depStorage,
-		depsync.WithLogger(logging.NewNanoDEPLogger(kitlog.With(logger, "component", "nanodep-syncer"))),
-		depsync.WithCallback(func(ctx context.Context, isFetch bool, resp *godep.DeviceResponse) error {
+	syncer := &depSyncer{
+		client: depClient,
+		store:  depStorage,
+		logger: kitlog.With(logger, "component", "nanodep-syncer"),
+		now:    time.Now,
+		callback: func(ctx context.Context, isFetch bool, resp *godep.DeviceResponse) error {
 			n, err := ds.IngestMDMAppleDevicesFromDEPSync(ctx, resp.Devices)
 			switch {
 			case err != nil:
 				level.Error(kitlog.With(logger)).Log("err", err)
 				sentry.CaptureException(err)
+				// the page of devices is processed again on the next run
+				return err
 			case n > 0:
 				level.Info(kitlog.With(logger)).Log("msg", fmt.Sprintf("added %d new mdm device(s) to pending hosts", n))
 			case n == 0:
@@ -290,23 +525,189 @@ func NewDEPService(
 
 			// TODO(mna): at this point, the hosts rows are created for the devices, with the
 			// correct team_id, so we know what team-specific profile needs to be applied.
+			devices, err := filterIneligibleDEPDevices(ctx, ds, logger, resp.Devices)
+			if err != nil {
+				level.Error(kitlog.With(logger)).Log("err", err)
+				sentry.CaptureException(err)
+				return err
+			}
+			devices, err = skipDEPProfileOverrides(ctx, ds, devices)
+			if err != nil {
+				level.Error(kitlog.With(logger)).Log("err", err)
+				sentry.CaptureException(err)
+				return err
+			}
+			resp.Devices = devices
 			return assigner.ProcessDeviceResponse(ctx, resp)
-		}),
-	)
+		},
+	}
 
-	return &DEPService{
+	d := &DEPService{
 		syncer:     syncer,
 		depStorage: depStorage,
 		logger:     logger,
 		ds:         ds,
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// filterIneligibleDEPDevices checks the devices synced from Apple Business
+// Manager against the enrollment eligibility rules of the team they are
+// assigned to, the team of their serial number if any, otherwise the default
+// team. The ineligible devices are recorded as blocked enrollments, and those
+// blocked (as opposed to flagged) are removed from the returned devices so
+// that they aren't assigned the enrollment profile.
+//
+// Apple Business Manager doesn't report the model year of the devices, it is
+// checked when they enroll.
+func filterIneligibleDEPDevices(ctx context.Context, ds fleet.Datastore, logger kitlog.Logger, devices []godep.Device) ([]godep.Device, error) {
+	if len(devices) == 0 {
+		return devices, nil
+	}
+	appCfg, err := ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get app config")
+	}
+
+	var defaultTeam *fleet.Team
+	if appCfg.MDM.AppleBMDefaultTeam != "" {
+		defaultTeam, err = ds.TeamByName(ctx, appCfg.MDM.AppleBMDefaultTeam)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				return nil, ctxerr.Wrap(ctx, err, "get default team")
+			}
+			// the devices are ingested without a team, see
+			// IngestMDMAppleDevicesFromDEPSync.
+			defaultTeam = nil
+		}
+	}
+
+	serials := make([]string, 0, len(devices))
+	for _, d := range devices {
+		serials = append(serials, d.SerialNumber)
+	}
+	assignedTeamIDs, err := ds.GetMDMAppleDEPTeamAssignments(ctx, serials)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get dep team assignments")
+	}
+	if defaultTeam == nil && len(assignedTeamIDs) == 0 {
+		return devices, nil
+	}
+	assignedTeams := make(map[uint]*fleet.Team)
+	teamOf := func(serial string) (*fleet.Team, error) {
+		teamID, ok := assignedTeamIDs[serial]
+		if !ok {
+			return defaultTeam, nil
+		}
+		if tm, ok := assignedTeams[teamID]; ok {
+			return tm, nil
+		}
+		tm, err := ds.Team(ctx, teamID)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "get assigned team")
+		}
+		assignedTeams[teamID] = tm
+		return tm, nil
+	}
+
+	eligible := make([]godep.Device, 0, len(devices))
+	var eligibleSerials []string
+	for _, d := range devices {
+		if strings.ToLower(d.OpType) == "deleted" {
+			eligible = append(eligible, d)
+			continue
+		}
+
+		tm, err := teamOf(d.SerialNumber)
+		if err != nil {
+			return nil, err
+		}
+		if tm == nil || !tm.Config.MDM.MacOSEnrollmentEligibility.IsSet() {
+			eligible = append(eligible, d)
+			continue
+		}
+		rules := tm.Config.MDM.MacOSEnrollmentEligibility
+
+		hw := MacHardwareFromDEPDescription(d.Description)
+		reason := rules.IneligibilityReason(hw.ModelYear, hw.Architecture)
+		if reason == "" {
+			eligible = append(eligible, d)
+			eligibleSerials = append(eligibleSerials, d.SerialNumber)
+			continue
+		}
+
+		action := rules.EffectiveAction()
+		if err := ds.UpsertMDMAppleBlockedEnrollment(ctx, &fleet.MDMAppleBlockedEnrollment{
+			SerialNumber:  d.SerialNumber,
+			TeamID:        &tm.ID,
+			HardwareModel: d.Model,
+			Source:        fleet.MDMAppleBlockedEnrollmentSourceDEP,
+			Action:        action,
+			Reason:        reason,
+		}); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "record blocked enrollment")
+		}
+		level.Info(logger).Log("msg", "ineligible DEP device", "serial", d.SerialNumber,
+			"description", d.Description, "action", action, "reason", reason)
+		if action == fleet.MacOSEnrollmentEligibilityActionFlag {
+			eligible = append(eligible, d)
+		}
+	}
+
+	if len(eligibleSerials) > 0 {
+		if err := ds.DeleteMDMAppleBlockedEnrollments(ctx, eligibleSerials); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "delete blocked enrollments of eligible devices")
+		}
+	}
+	return eligible, nil
+}
+
+// skipDEPProfileOverrides removes the devices whose host has a DEP profile
+// override from the devices to assign the default profile to, and marks their
+// override as pending so that it is assigned again after the sync, as Apple
+// Business Manager may have reset the profile of a modified device.
+func skipDEPProfileOverrides(ctx context.Context, ds fleet.Datastore, devices []godep.Device) ([]godep.Device, error) {
+	if len(devices) == 0 {
+		return devices, nil
+	}
+	serials := make([]string, 0, len(devices))
+	for _, d := range devices {
+		serials = append(serials, d.SerialNumber)
+	}
+	overrides, err := ds.ListHostMDMAppleDEPProfileOverridesBySerials(ctx, serials)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list dep profile overrides")
+	}
+	if len(overrides) == 0 {
+		return devices, nil
+	}
+
+	remaining := make([]godep.Device, 0, len(devices))
+	var pendingHostIDs []uint
+	for _, d := range devices {
+		override, ok := overrides[d.SerialNumber]
+		if !ok || strings.ToLower(d.OpType) == "deleted" {
+			remaining = append(remaining, d)
+			continue
+		}
+		pendingHostIDs = append(pendingHostIDs, override.HostID)
+	}
+	if err := ds.SetHostMDMAppleDEPProfileOverridesPending(ctx, pendingHostIDs); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "set dep profile overrides pending")
+	}
+	return remaining, nil
 }
 
 // NewDEPClient creates an Apple DEP API HTTP client based on the provided
 // storage that will flag the AppConfig's AppleBMTermsExpired field
 // whenever the status of the terms changes.
 func NewDEPClient(storage godep.ClientStorage, appCfgUpdater fleet.AppConfigUpdater, logger kitlog.Logger) *godep.Client {
-	return godep.NewClient(storage, fleethttp.NewClient(), godep.WithAfterHook(func(ctx context.Context, reqErr error) error {
+	httpClient := fleethttp.NewClient()
+	httpClient.Transport = newDEPRetryTransport(httpClient.Transport, logger)
+	return godep.NewClient(storage, httpClient, godep.WithAfterHook(func(ctx context.Context, reqErr error) error {
 		// if the request failed due to terms not signed, or if it succeeded,
 		// update the app config flag accordingly. If it failed for any other
 		// reason, do not update the flag.
@@ -341,6 +742,57 @@ func NewDEPClient(storage godep.ClientStorage, appCfgUpdater fleet.AppConfigUpda
 	}))
 }
 
+// DEPDisownStatusSuccess is the status reported by the DEP API for a device
+// that was released from the MDM server.
+const DEPDisownStatusSuccess = "SUCCESS"
+
+// DisownDEPDevices releases the devices with the given serial numbers from
+// Fleet's MDM server in Apple Business Manager, which removes them from the
+// organization. The godep client doesn't support the disown endpoint, so the
+// request is made with the same authenticated (and retrying) transport.
+//
+// It returns the status reported by Apple for each serial number, e.g.
+// "SUCCESS", "NOT_ACCESSIBLE" or "FAILED".
+//
+// See https://developer.apple.com/documentation/devicemanagement/disown_devices
+func DisownDEPDevices(ctx context.Context, storage godep.ClientStorage, logger kitlog.Logger, serials ...string) (map[string]string, error) {
+	httpClient := fleethttp.NewClient()
+	httpClient.Transport = newDEPRetryTransport(httpClient.Transport, logger)
+	client := depclient.NewClient(httpClient, depclient.NewTransport(httpClient.Transport, httpClient, storage, nil))
+
+	body, err := json.Marshal(map[string][]string{"devices": serials})
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "marshal disown devices request")
+	}
+	req, err := depclient.NewRequestWithContext(ctx, DEPName, storage, "POST", "/devices/disown", bytes.NewReader(body))
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "create disown devices request")
+	}
+	req.Header.Set("Content-Type", "application/json;charset=UTF8")
+	req.Header.Set("Accept", "application/json;charset=UTF8")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "disown devices request")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, ctxerr.Wrap(ctx, depclient.NewAuthError(resp), "disown devices")
+	case resp.StatusCode != http.StatusOK:
+		return nil, ctxerr.Wrap(ctx, godep.NewHTTPError(resp), "disown devices")
+	}
+
+	var res struct {
+		Devices map[string]string `json:"devices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "decode disown devices response")
+	}
+	return res.Devices, nil
+}
+
 // enrollmentProfileMobileconfigTemplate is the template Fleet uses to assemble a .mobileconfig enrollment profile to serve to devices.
 //
 // During a profile replacement, the system updates payloads with the same PayloadIdentifier and
@@ -408,8 +860,12 @@ var enrollmentProfileMobileconfigTemplate = template.Must(template.New("").Parse
 			<string>{{ .Topic }}</string>
 		</dict>
 	</array>
+{{- if .Description }}
+	<key>PayloadDescription</key>
+	<string>{{ .Description }}</string>
+{{- end }}
 	<key>PayloadDisplayName</key>
-	<string>{{ .Organization }} enrollment</string>
+	<string>{{ if .DisplayName }}{{ .DisplayName }}{{ else }}{{ .Organization }} enrollment{{ end }}</string>
 	<key>PayloadIdentifier</key>
 	<string>` + FleetPayloadIdentifier + `</string>
 	<key>PayloadOrganization</key>
@@ -425,7 +881,10 @@ var enrollmentProfileMobileconfigTemplate = template.Must(template.New("").Parse
 </dict>
 </plist>`))
 
-func GenerateEnrollmentProfileMobileconfig(orgName, fleetURL, scepChallenge, topic string) ([]byte, error) {
+// GenerateEnrollmentProfileMobileconfig returns the enrollment profile served
+// to the devices, its display name and description are the localized strings
+// if set.
+func GenerateEnrollmentProfileMobileconfig(orgName, fleetURL, scepChallenge, topic string, strs fleet.MDMEndUserStrings) ([]byte, error) {
 	scepURL, err := ResolveAppleSCEPURL(fleetURL)
 	if err != nil {
 		return nil, fmt.Errorf("resolve Apple SCEP url: %w", err)
@@ -439,6 +898,13 @@ func GenerateEnrollmentProfileMobileconfig(orgName, fleetURL, scepChallenge, top
 	if err := xml.EscapeText(&escaped, []byte(scepChallenge)); err != nil {
 		return nil, fmt.Errorf("escape SCEP challenge for XML: %w", err)
 	}
+	var displayName, description strings.Builder
+	if err := xml.EscapeText(&displayName, []byte(strs.EnrollmentProfileDisplayName)); err != nil {
+		return nil, fmt.Errorf("escape display name for XML: %w", err)
+	}
+	if err := xml.EscapeText(&description, []byte(strs.EnrollmentProfileDescription)); err != nil {
+		return nil, fmt.Errorf("escape description for XML: %w", err)
+	}
 
 	var buf bytes.Buffer
 	if err := enrollmentProfileMobileconfigTemplate.Execute(&buf, struct {
@@ -447,14 +913,69 @@ func GenerateEnrollmentProfileMobileconfig(orgName, fleetURL, scepChallenge, top
 		SCEPChallenge string
 		Topic         string
 		ServerURL     string
+		DisplayName   string
+		Description   string
 	}{
 		Organization:  orgName,
 		SCEPURL:       scepURL,
 		SCEPChallenge: escaped.String(),
 		Topic:         topic,
 		ServerURL:     serverURL,
+		DisplayName:   displayName.String(),
+		Description:   description.String(),
 	}); err != nil {
 		return nil, fmt.Errorf("execute template: %w", err)
 	}
 	return buf.Bytes(), nil
 }
+
+// EndUserTeam returns the team of the first IdP team rule that matches the
+// groups of the end user, completed with the groups synced via SCIM. It
+// returns nil if no rule matches or if the team of the rule doesn't exist
+// anymore.
+func EndUserTeam(ctx context.Context, ds fleet.Datastore, appCfg *fleet.AppConfig, username string, groups []string) (*fleet.Team, error) {
+	// the IdP may not send the groups with the SAML assertion.
+	scimGroups, err := ds.ScimGroupNamesForUserName(ctx, username)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get SCIM groups of end user")
+	}
+	groups = append(append([]string{}, groups...), scimGroups...)
+	return teamByName(ctx, ds, appCfg.MDM.EndUserAuthentication.TeamRules.TeamForGroups(groups))
+}
+
+// EnrollmentTeamID returns the ID of the team of the hosts that the end user
+// enrolls with the automatic enrollment profile: the team of the matching IdP
+// team rule if the end user authenticated (username is not empty), or else
+// the default team of Apple Business Manager. It returns nil for no team.
+func EnrollmentTeamID(ctx context.Context, ds fleet.Datastore, appCfg *fleet.AppConfig, username string, groups []string) (*uint, error) {
+	if username != "" {
+		tm, err := EndUserTeam(ctx, ds, appCfg, username, groups)
+		if err != nil {
+			return nil, err
+		}
+		if tm != nil {
+			return &tm.ID, nil
+		}
+	}
+	tm, err := teamByName(ctx, ds, appCfg.MDM.AppleBMDefaultTeam)
+	if err != nil || tm == nil {
+		return nil, err
+	}
+	return &tm.ID, nil
+}
+
+// teamByName returns the team with the name, nil if the name is empty or the
+// team doesn't exist.
+func teamByName(ctx context.Context, ds fleet.Datastore, name string) (*fleet.Team, error) {
+	if name == "" {
+		return nil, nil
+	}
+	tm, err := ds.TeamByName(ctx, name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || fleet.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get team by name")
+	}
+	return tm, nil
+}
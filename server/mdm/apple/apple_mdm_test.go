@@ -8,10 +8,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/mock"
 	nanodep_mock "github.com/fleetdm/fleet/v4/server/mock/nanodep"
+	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/go-kit/log"
 	"github.com/micromdm/nanodep/client"
 	"github.com/micromdm/nanodep/godep"
@@ -88,6 +90,147 @@ func TestDEPService(t *testing.T) {
 		require.True(t, depStorage.StoreAssignerProfileFuncInvoked)
 	})
 
+	t.Run("RegisterProfileWithAppleDEPServer expands variables", func(t *testing.T) {
+		ds := new(mock.Store)
+		ctx := context.Background()
+		depStorage := new(nanodep_mock.Storage)
+		depSvc := NewDEPService(ds, depStorage, log.NewNopLogger(), true)
+
+		var got godep.Profile
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			switch r.URL.Path {
+			case "/session":
+				_, _ = w.Write([]byte(`{"auth_session_token": "xyz"}`))
+			case "/profile":
+				_, _ = w.Write([]byte(`{"profile_uuid": "xyz"}`))
+				body, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				require.NoError(t, json.Unmarshal(body, &got))
+			}
+		}))
+		t.Cleanup(srv.Close)
+
+		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+			appCfg := &fleet.AppConfig{}
+			appCfg.OrgInfo.OrgName = "Acme"
+			appCfg.ServerSettings.ServerURL = "https://example.com"
+			appCfg.MDM.AppleBMDefaultTeam = "Workstations"
+			return appCfg, nil
+		}
+		depStorage.RetrieveConfigFunc = func(ctx context.Context, name string) (*client.Config, error) {
+			return &client.Config{BaseURL: srv.URL}, nil
+		}
+		depStorage.RetrieveAuthTokensFunc = func(ctx context.Context, name string) (*client.OAuth1Tokens, error) {
+			return &client.OAuth1Tokens{}, nil
+		}
+		depStorage.StoreAssignerProfileFunc = func(ctx context.Context, name string, profileUUID string) error {
+			return nil
+		}
+
+		prof := &godep.Profile{
+			ProfileName:         "$FLEET_VAR_ORG_NAME ${FLEET_VAR_TEAM_NAME}",
+			Department:          "$FLEET_VAR_TEAM_NAME",
+			SupportEmailAddress: "it@example.com",
+		}
+		err := depSvc.RegisterProfileWithAppleDEPServer(ctx, prof, "https://example.com/api/mdm/apple/enroll?token=tok")
+		require.NoError(t, err)
+		require.Equal(t, "Acme Workstations", got.ProfileName)
+		require.Equal(t, "Workstations", got.Department)
+		require.Equal(t, "it@example.com", got.SupportEmailAddress)
+		require.Equal(t, "https://example.com/api/mdm/apple/enroll?token=tok", got.URL)
+		// the provided profile is not modified
+		require.Equal(t, "$FLEET_VAR_TEAM_NAME", prof.Department)
+	})
+
+	t.Run("assignDEPProfileOverrides", func(t *testing.T) {
+		ds := new(mock.Store)
+		ctx := context.Background()
+		depStorage := new(nanodep_mock.Storage)
+		depSvc := NewDEPService(ds, depStorage, log.NewNopLogger(), true)
+
+		var defined []godep.Profile
+		assigned := map[string]string{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			switch r.URL.Path {
+			case "/session":
+				_, _ = w.Write([]byte(`{"auth_session_token": "xyz"}`))
+			case "/profile":
+				var got godep.Profile
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+				defined = append(defined, got)
+				_, _ = w.Write([]byte(`{"profile_uuid": "override-uuid"}`))
+			case "/profile/devices":
+				var req struct {
+					ProfileUUID string   `json:"profile_uuid"`
+					Devices     []string `json:"devices"`
+				}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+				require.Len(t, req.Devices, 1)
+				assigned[req.Devices[0]] = req.ProfileUUID
+				status := "SUCCESS"
+				if req.Devices[0] == "not-accessible" {
+					status = "NOT_ACCESSIBLE"
+				}
+				_, _ = w.Write([]byte(`{"devices": {"` + req.Devices[0] + `": "` + status + `"}}`))
+			}
+		}))
+		t.Cleanup(srv.Close)
+
+		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+			appCfg := &fleet.AppConfig{}
+			appCfg.OrgInfo.OrgName = "Acme"
+			appCfg.ServerSettings.ServerURL = "https://example.com"
+			return appCfg, nil
+		}
+		ds.ListMDMAppleEnrollmentProfilesFunc = func(ctx context.Context) ([]*fleet.MDMAppleEnrollmentProfile, error) {
+			return []*fleet.MDMAppleEnrollmentProfile{{Token: "tok", Type: "automatic"}}, nil
+		}
+		depStorage.RetrieveConfigFunc = func(ctx context.Context, name string) (*client.Config, error) {
+			return &client.Config{BaseURL: srv.URL}, nil
+		}
+		depStorage.RetrieveAuthTokensFunc = func(ctx context.Context, name string) (*client.OAuth1Tokens, error) {
+			return &client.OAuth1Tokens{}, nil
+		}
+		depStorage.RetrieveAssignerProfileFunc = func(ctx context.Context, name string) (string, time.Time, error) {
+			return "default-uuid", time.Now(), nil
+		}
+
+		kiosk := json.RawMessage(`{"profile_name": "$FLEET_VAR_TEAM_NAME kiosk", "skip_setup_items": ["Siri"]}`)
+		ds.ListPendingHostMDMAppleDEPProfileOverridesFunc = func(ctx context.Context) ([]*fleet.HostMDMAppleDEPProfileOverride, error) {
+			return []*fleet.HostMDMAppleDEPProfileOverride{
+				{HostID: 1, Profile: &kiosk, HardwareSerial: "new", TeamName: ptr.String("Kiosks")},
+				{HostID: 2, Profile: &kiosk, ProfileUUID: "synced-uuid", HardwareSerial: "synced"},
+				{HostID: 3, HardwareSerial: "cleared"},
+				{HostID: 4, Profile: &kiosk, ProfileUUID: "synced-uuid", HardwareSerial: "not-accessible"},
+			}, nil
+		}
+		assignedHosts := map[uint]string{}
+		ds.SetHostMDMAppleDEPProfileOverrideAssignedFunc = func(ctx context.Context, hostID uint, profileUUID string) error {
+			assignedHosts[hostID] = profileUUID
+			return nil
+		}
+
+		err := depSvc.assignDEPProfileOverrides(ctx)
+		require.NoError(t, err)
+
+		// only the override without a UUID is defined, with its team's variables
+		require.Len(t, defined, 1)
+		require.Equal(t, "Kiosks kiosk", defined[0].ProfileName)
+		require.Equal(t, []string{"Siri"}, defined[0].SkipSetupItems)
+		require.Equal(t, "https://example.com/api/mdm/apple/enroll?token=tok", defined[0].URL)
+
+		require.Equal(t, map[string]string{
+			"new":            "override-uuid",
+			"synced":         "synced-uuid",
+			"cleared":        "default-uuid",
+			"not-accessible": "synced-uuid",
+		}, assigned)
+		// the failed assignment stays pending
+		require.Equal(t, map[uint]string{1: "override-uuid", 2: "synced-uuid", 3: "default-uuid"}, assignedHosts)
+	})
+
 	t.Run("EnrollURL", func(t *testing.T) {
 		ds := new(mock.Store)
 		logger := log.NewNopLogger()
@@ -131,3 +274,193 @@ func TestDEPService(t *testing.T) {
 
 	})
 }
+
+func TestAddEnrollmentRefToFleetURL(t *testing.T) {
+	got, err := AddEnrollmentRefToFleetURL("https://example.com", "")
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com", got)
+
+	got, err = AddEnrollmentRefToFleetURL("https://example.com/fleet", "abc-123")
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/fleet?enrollment_reference=abc-123", got)
+
+	mdmURL, err := ResolveAppleMDMURL(got)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/fleet/mdm/apple/mdm?enrollment_reference=abc-123", mdmURL)
+}
+
+func TestEnrollmentLinkURLs(t *testing.T) {
+	got, err := ResolveAppleEnrollLinkURL("https://example.com/fleet", "a+b/c=")
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/fleet/api/mdm/apple/enroll_link?enrollment_link=a%2Bb%2Fc%3D", got)
+
+	got, err = AddEnrollmentLinkToFleetURL("https://example.com", "")
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com", got)
+
+	got, err = AddEnrollmentLinkToFleetURL("https://example.com/fleet", "abc")
+	require.NoError(t, err)
+	mdmURL, err := ResolveAppleMDMURL(got)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/fleet/mdm/apple/mdm?enrollment_link=abc", mdmURL)
+}
+
+func TestAddEnrollmentTeamTokenToFleetURL(t *testing.T) {
+	got, err := AddEnrollmentTeamTokenToFleetURL("https://example.com", "")
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com", got)
+
+	got, err = AddEnrollmentTeamTokenToFleetURL("https://example.com/fleet", "a+b/c=")
+	require.NoError(t, err)
+	mdmURL, err := ResolveAppleMDMURL(got)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/fleet/mdm/apple/mdm?enrollment_team_token=a%2Bb%2Fc%3D", mdmURL)
+}
+
+func TestFilterIneligibleDEPDevices(t *testing.T) {
+	ctx := context.Background()
+	ds := new(mock.Store)
+	logger := log.NewNopLogger()
+
+	var defaultTeam string
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		appCfg := &fleet.AppConfig{}
+		appCfg.MDM.AppleBMDefaultTeam = defaultTeam
+		return appCfg, nil
+	}
+	team := &fleet.Team{ID: 1, Name: "team"}
+	ds.TeamByNameFunc = func(ctx context.Context, name string) (*fleet.Team, error) {
+		require.Equal(t, "team", name)
+		return team, nil
+	}
+	assignedTeam := &fleet.Team{ID: 2, Name: "assigned"}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		require.Equal(t, assignedTeam.ID, tid)
+		return assignedTeam, nil
+	}
+	assignments := map[string]uint{}
+	ds.GetMDMAppleDEPTeamAssignmentsFunc = func(ctx context.Context, serials []string) (map[string]uint, error) {
+		return assignments, nil
+	}
+	var upserted []*fleet.MDMAppleBlockedEnrollment
+	ds.UpsertMDMAppleBlockedEnrollmentFunc = func(ctx context.Context, blocked *fleet.MDMAppleBlockedEnrollment) error {
+		upserted = append(upserted, blocked)
+		return nil
+	}
+	var deleted []string
+	ds.DeleteMDMAppleBlockedEnrollmentsFunc = func(ctx context.Context, serials []string) error {
+		deleted = append(deleted, serials...)
+		return nil
+	}
+
+	devices := []godep.Device{
+		{SerialNumber: "arm", Model: "MacBook Pro 14\"", Description: "MBP 14.2 SPG/M1 PRO", OpType: "added"},
+		{SerialNumber: "intel", Model: "iMac 27\"", Description: "IMAC 27/3.6GHZ/8GB", OpType: "added"},
+		{SerialNumber: "unknown", Model: "Mac mini", Description: "MAC MINI", OpType: "modified"},
+		{SerialNumber: "deleted", Model: "iMac 27\"", Description: "IMAC 27/3.6GHZ/8GB", OpType: "deleted"},
+	}
+	serials := func(devices []godep.Device) []string {
+		var s []string
+		for _, d := range devices {
+			s = append(s, d.SerialNumber)
+		}
+		return s
+	}
+
+	// no default team, all devices are kept
+	got, err := filterIneligibleDEPDevices(ctx, ds, logger, devices)
+	require.NoError(t, err)
+	require.Equal(t, devices, got)
+	require.False(t, ds.TeamByNameFuncInvoked)
+
+	// default team without rules
+	defaultTeam = "team"
+	got, err = filterIneligibleDEPDevices(ctx, ds, logger, devices)
+	require.NoError(t, err)
+	require.Equal(t, devices, got)
+	require.False(t, ds.UpsertMDMAppleBlockedEnrollmentFuncInvoked)
+
+	// the Intel device is blocked
+	team.Config.MDM.MacOSEnrollmentEligibility = fleet.MacOSEnrollmentEligibility{
+		MinimumModelYear:     2020,
+		RequiredArchitecture: fleet.MacOSArchitectureARM64,
+	}
+	got, err = filterIneligibleDEPDevices(ctx, ds, logger, devices)
+	require.NoError(t, err)
+	require.Equal(t, []string{"arm", "unknown", "deleted"}, serials(got))
+	require.Len(t, upserted, 1)
+	require.Equal(t, "intel", upserted[0].SerialNumber)
+	require.Equal(t, "iMac 27\"", upserted[0].HardwareModel)
+	require.Equal(t, fleet.MDMAppleBlockedEnrollmentSourceDEP, upserted[0].Source)
+	require.Equal(t, fleet.MacOSEnrollmentEligibilityActionBlock, upserted[0].Action)
+	require.Equal(t, &team.ID, upserted[0].TeamID)
+	require.Equal(t, []string{"arm", "unknown"}, deleted)
+
+	// the Intel device is flagged but still assigned the enrollment profile
+	upserted, deleted = nil, nil
+	team.Config.MDM.MacOSEnrollmentEligibility.Action = fleet.MacOSEnrollmentEligibilityActionFlag
+	got, err = filterIneligibleDEPDevices(ctx, ds, logger, devices)
+	require.NoError(t, err)
+	require.Equal(t, devices, got)
+	require.Len(t, upserted, 1)
+	require.Equal(t, fleet.MacOSEnrollmentEligibilityActionFlag, upserted[0].Action)
+
+	// the Intel device is pre-assigned to a team without rules, it is kept
+	upserted = nil
+	team.Config.MDM.MacOSEnrollmentEligibility.Action = fleet.MacOSEnrollmentEligibilityActionBlock
+	assignments["intel"] = assignedTeam.ID
+	got, err = filterIneligibleDEPDevices(ctx, ds, logger, devices)
+	require.NoError(t, err)
+	require.Equal(t, devices, got)
+	require.Empty(t, upserted)
+
+	// the rules of the pre-assigned team apply to its devices
+	assignedTeam.Config.MDM.MacOSEnrollmentEligibility = fleet.MacOSEnrollmentEligibility{
+		RequiredArchitecture: fleet.MacOSArchitectureARM64,
+	}
+	got, err = filterIneligibleDEPDevices(ctx, ds, logger, devices)
+	require.NoError(t, err)
+	require.Equal(t, []string{"arm", "unknown", "deleted"}, serials(got))
+	require.Len(t, upserted, 1)
+	require.Equal(t, &assignedTeam.ID, upserted[0].TeamID)
+
+	// errors are returned so that the page of devices is processed again
+	ds.UpsertMDMAppleBlockedEnrollmentFunc = func(ctx context.Context, blocked *fleet.MDMAppleBlockedEnrollment) error {
+		return errors.New("db error")
+	}
+	_, err = filterIneligibleDEPDevices(ctx, ds, logger, devices)
+	require.ErrorContains(t, err, "db error")
+}
+
+func TestSkipDEPProfileOverrides(t *testing.T) {
+	ctx := context.Background()
+	ds := new(mock.Store)
+
+	kiosk := json.RawMessage(`{"profile_name": "kiosk"}`)
+	ds.ListHostMDMAppleDEPProfileOverridesBySerialsFunc = func(ctx context.Context, serials []string) (map[string]*fleet.HostMDMAppleDEPProfileOverride, error) {
+		return map[string]*fleet.HostMDMAppleDEPProfileOverride{
+			"kiosk":         {HostID: 1, Profile: &kiosk},
+			"deleted-kiosk": {HostID: 2, Profile: &kiosk},
+		}, nil
+	}
+	var pending []uint
+	ds.SetHostMDMAppleDEPProfileOverridesPendingFunc = func(ctx context.Context, hostIDs []uint) error {
+		pending = append(pending, hostIDs...)
+		return nil
+	}
+
+	got, err := skipDEPProfileOverrides(ctx, ds, nil)
+	require.NoError(t, err)
+	require.Empty(t, got)
+	require.False(t, ds.ListHostMDMAppleDEPProfileOverridesBySerialsFuncInvoked)
+
+	devices := []godep.Device{
+		{SerialNumber: "laptop", OpType: "added"},
+		{SerialNumber: "kiosk", OpType: "modified"},
+		{SerialNumber: "deleted-kiosk", OpType: "deleted"},
+	}
+	got, err = skipDEPProfileOverrides(ctx, ds, devices)
+	require.NoError(t, err)
+	require.Equal(t, []godep.Device{devices[0], devices[2]}, got)
+	require.Equal(t, []uint{1}, pending)
+}
@@ -0,0 +1,62 @@
+package apple_mdm
+
+import (
+	"bytes"
+	"encoding/xml"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+)
+
+// Names of the variables (without the FLEET_VAR_ prefix) that reference the
+// user the host is assigned to in the configuration profiles.
+const (
+	HostAssignedUserVarEmail    = "HOST_ASSIGNED_USER_EMAIL"
+	HostAssignedUserVarFullName = "HOST_ASSIGNED_USER_FULL_NAME"
+)
+
+// hostAssignedUserVarValue returns the value of the assigned user variable
+// for the user, or false if the variable does not reference the assigned
+// user. A nil user results in empty values.
+func hostAssignedUserVarValue(varName string, user *fleet.HostAssignedUser) (string, bool) {
+	switch varName {
+	case HostAssignedUserVarEmail:
+		if user == nil {
+			return "", true
+		}
+		return user.Email, true
+	case HostAssignedUserVarFullName:
+		if user == nil {
+			return "", true
+		}
+		return user.FullName, true
+	}
+	return "", false
+}
+
+// HasHostAssignedUserVars returns true if the configuration profile
+// references at least one host assigned user variable.
+func HasHostAssignedUserVars(b []byte) bool {
+	for _, m := range fleetVarRegexp.FindAllSubmatch(b, -1) {
+		if _, ok := hostAssignedUserVarValue(string(m[1])+string(m[2]), nil); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandProfileHostAssignedUserVars returns the configuration profile with
+// the host assigned user variables replaced by the XML-escaped values of the
+// user. If the host has no assigned user (nil user), the variables are
+// replaced by an empty string.
+func ExpandProfileHostAssignedUserVars(profile []byte, user *fleet.HostAssignedUser) []byte {
+	return fleetVarRegexp.ReplaceAllFunc(profile, func(v []byte) []byte {
+		m := fleetVarRegexp.FindSubmatch(v)
+		value, ok := hostAssignedUserVarValue(string(m[1])+string(m[2]), user)
+		if !ok {
+			return v
+		}
+		var buf bytes.Buffer
+		_ = xml.EscapeText(&buf, []byte(value))
+		return buf.Bytes()
+	})
+}
@@ -0,0 +1,38 @@
+package apple_mdm
+
+import (
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostAssignedUserVars(t *testing.T) {
+	profile := []byte(`<plist version="1.0">
+<dict>
+	<key>Email</key>
+	<string>$FLEET_VAR_HOST_ASSIGNED_USER_EMAIL</string>
+	<key>FullName</key>
+	<string>${FLEET_VAR_HOST_ASSIGNED_USER_FULL_NAME}</string>
+	<key>Other</key>
+	<string>$FLEET_VAR_HOST_UUID $FLEET_VAR_HOST_ASSIGNED_USER_UNKNOWN</string>
+</dict>
+</plist>`)
+
+	require.True(t, HasHostAssignedUserVars(profile))
+	require.False(t, HasHostAssignedUserVars([]byte(`<string>$FLEET_VAR_HOST_UUID $FLEET_VAR_HOST_ASSIGNED_USER_UNKNOWN</string>`)))
+	require.False(t, HasHostAssignedUserVars([]byte(`<string>no vars</string>`)))
+
+	got := ExpandProfileHostAssignedUserVars(profile, &fleet.HostAssignedUser{
+		Email:    "jane@example.com",
+		FullName: "Jane <Doe> & co",
+	})
+	require.Contains(t, string(got), "<string>jane@example.com</string>")
+	require.Contains(t, string(got), "<string>Jane &lt;Doe&gt; &amp; co</string>")
+	require.Contains(t, string(got), "<string>$FLEET_VAR_HOST_UUID $FLEET_VAR_HOST_ASSIGNED_USER_UNKNOWN</string>")
+
+	// no assigned user
+	got = ExpandProfileHostAssignedUserVars(profile, nil)
+	require.Contains(t, string(got), "<key>Email</key>\n\t<string></string>")
+	require.Contains(t, string(got), "<key>FullName</key>\n\t<string></string>")
+}
@@ -0,0 +1,219 @@
+package apple_mdm
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/pkg/file"
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/log/level"
+	"golang.org/x/crypto/ocsp"
+)
+
+// BootstrapPackageVerificationInterval is the minimum interval between two
+// verifications of the signing chain of a bootstrap package.
+const BootstrapPackageVerificationInterval = 24 * time.Hour
+
+// BootstrapPackageChunkReader reads the contents of a bootstrap package
+// stored in the chunks of an upload session, loading one chunk at a time.
+type BootstrapPackageChunkReader struct {
+	ctx      context.Context
+	ds       fleet.Datastore
+	uploadID uint
+	size     int64
+
+	read  int64
+	next  int
+	chunk *bytes.Reader
+}
+
+// NewBootstrapPackageChunkReader returns a reader of the contents of size
+// bytes stored in the chunks of the upload session with the given id.
+func NewBootstrapPackageChunkReader(ctx context.Context, ds fleet.Datastore, uploadID uint, size int64) *BootstrapPackageChunkReader {
+	return &BootstrapPackageChunkReader{
+		ctx:      ctx,
+		ds:       ds,
+		uploadID: uploadID,
+		size:     size,
+		chunk:    bytes.NewReader(nil),
+	}
+}
+
+func (r *BootstrapPackageChunkReader) Read(p []byte) (int, error) {
+	for r.chunk.Len() == 0 {
+		if r.read >= r.size {
+			return 0, io.EOF
+		}
+		b, err := r.ds.GetMDMAppleBootstrapPackageUploadChunk(r.ctx, r.uploadID, r.next)
+		if err != nil {
+			return 0, ctxerr.Wrap(r.ctx, err, "reading bootstrap package chunk")
+		}
+		r.chunk = bytes.NewReader(b)
+		r.next++
+	}
+
+	n, err := r.chunk.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+func (r *BootstrapPackageChunkReader) Close() error {
+	return nil
+}
+
+// VerifyBootstrapPackageSignatures verifies the signing certificate chain of
+// the bootstrap packages that weren't verified in the last
+// BootstrapPackageVerificationInterval, and records the result in the
+// datastore.
+//
+// A package is flagged as invalid if it isn't signed, if a certificate of its
+// chain has expired or if it was revoked. The revocation status is checked
+// via OCSP with the provided client; OCSP failures don't invalidate the
+// package, they are recorded in the signature detail.
+func VerifyBootstrapPackageSignatures(
+	ctx context.Context,
+	ds fleet.Datastore,
+	assetStore fleet.MDMAssetStore,
+	ocspClient *http.Client,
+	logger kitlog.Logger,
+	now time.Time,
+) error {
+	pkgs, err := ds.ListMDMAppleBootstrapPackages(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list bootstrap packages")
+	}
+
+	for _, pkg := range pkgs {
+		if pkg.SignatureVerifiedAt != nil && now.Sub(*pkg.SignatureVerifiedAt) < BootstrapPackageVerificationInterval {
+			continue
+		}
+
+		status, detail, err := verifyBootstrapPackage(ctx, ds, assetStore, ocspClient, pkg, now)
+		if err != nil {
+			// don't flag the package if it couldn't be read, it will be
+			// verified again on the next run.
+			level.Error(logger).Log("msg", "verify bootstrap package signature", "team_id", pkg.TeamID, "token", pkg.Token, "err", err)
+			continue
+		}
+		if status == fleet.MDMAppleBootstrapPackageSignatureInvalid {
+			level.Info(logger).Log("msg", "bootstrap package signature is invalid", "team_id", pkg.TeamID, "token", pkg.Token, "detail", detail)
+		}
+		if err := ds.SetMDMAppleBootstrapPackageSignatureStatus(ctx, pkg.Token, status, detail, now); err != nil {
+			return ctxerr.Wrap(ctx, err, "set bootstrap package signature status")
+		}
+	}
+	return nil
+}
+
+func verifyBootstrapPackage(
+	ctx context.Context,
+	ds fleet.Datastore,
+	assetStore fleet.MDMAssetStore,
+	ocspClient *http.Client,
+	pkg *fleet.MDMAppleBootstrapPackage,
+	now time.Time,
+) (fleet.MDMAppleBootstrapPackageSignatureStatus, string, error) {
+	content, err := openBootstrapPackage(ctx, ds, assetStore, pkg)
+	if err != nil {
+		return "", "", err
+	}
+	defer content.Close()
+
+	certs, err := file.VerifyPKGSigningChain(content, now)
+	if err != nil {
+		var chainErr *file.SigningChainError
+		if errors.As(err, &chainErr) || errors.Is(err, file.ErrNotSigned) || errors.Is(err, file.ErrInvalidType) {
+			return fleet.MDMAppleBootstrapPackageSignatureInvalid, err.Error(), nil
+		}
+		return "", "", ctxerr.Wrap(ctx, err, "reading bootstrap package signing chain")
+	}
+
+	// the last certificate of the chain is the root, which can't be checked
+	// via OCSP.
+	for i := 0; i+1 < len(certs); i++ {
+		cert, issuer := certs[i], certs[i+1]
+		revokedAt, err := checkOCSP(ctx, ocspClient, cert, issuer)
+		if err != nil {
+			return fleet.MDMAppleBootstrapPackageSignatureValid,
+				fmt.Sprintf("revocation status of certificate %q could not be checked: %v", cert.Subject.CommonName, err), nil
+		}
+		if revokedAt != nil {
+			return fleet.MDMAppleBootstrapPackageSignatureInvalid,
+				fmt.Sprintf("certificate %q was revoked on %s", cert.Subject.CommonName, revokedAt.UTC().Format(time.RFC3339)), nil
+		}
+	}
+	return fleet.MDMAppleBootstrapPackageSignatureValid, "", nil
+}
+
+// openBootstrapPackage returns a reader of the contents of the bootstrap
+// package, wherever they are stored.
+func openBootstrapPackage(ctx context.Context, ds fleet.Datastore, assetStore fleet.MDMAssetStore, pkg *fleet.MDMAppleBootstrapPackage) (io.ReadCloser, error) {
+	if pkg.UploadID != nil {
+		return NewBootstrapPackageChunkReader(ctx, ds, *pkg.UploadID, pkg.Size), nil
+	}
+
+	stored, err := ds.GetMDMAppleBootstrapPackageBytes(ctx, pkg.Token)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get bootstrap package bytes")
+	}
+	if stored.Bytes == nil && assetStore != nil {
+		content, _, err := assetStore.Get(ctx, fleet.MDMAssetBootstrapPackage, pkg.Token)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "retrieving bootstrap package from store")
+		}
+		return content, nil
+	}
+	return io.NopCloser(bytes.NewReader(stored.Bytes)), nil
+}
+
+// checkOCSP queries the OCSP responder of the certificate, if any, and
+// returns the time it was revoked at, or nil if it isn't revoked.
+func checkOCSP(ctx context.Context, client *http.Client, cert, issuer *x509.Certificate) (*time.Time, error) {
+	if len(cert.OCSPServer) == 0 {
+		return nil, nil
+	}
+
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating OCSP request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, cert.OCSPServer[0], bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("creating OCSP request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("sending OCSP request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("reading OCSP response: %w", err)
+	}
+
+	ocspResp, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("parsing OCSP response: %w", err)
+	}
+	switch ocspResp.Status {
+	case ocsp.Revoked:
+		return &ocspResp.RevokedAt, nil
+	case ocsp.Good:
+		return nil, nil
+	default:
+		return nil, errors.New("OCSP responder doesn't know the certificate")
+	}
+}
@@ -0,0 +1,178 @@
+package apple_mdm
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/mock"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestVerifyBootstrapPackageSignatures(t *testing.T) {
+	ctx := context.Background()
+	ds := new(mock.Store)
+
+	signed, err := os.ReadFile("../../../pkg/file/testdata/signed.pkg")
+	require.NoError(t, err)
+	unsigned, err := os.ReadFile("../../../pkg/file/testdata/unsigned.pkg")
+	require.NoError(t, err)
+
+	var ocspRequests int
+	ocspClient := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		ocspRequests++
+		require.Equal(t, "application/ocsp-request", r.Header.Get("Content-Type"))
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+	})}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ds.ListMDMAppleBootstrapPackagesFunc = func(ctx context.Context) ([]*fleet.MDMAppleBootstrapPackage, error) {
+		return []*fleet.MDMAppleBootstrapPackage{
+			{TeamID: 0, Token: "signed"},
+			{TeamID: 1, Token: "unsigned"},
+			{TeamID: 2, Token: "recent", SignatureVerifiedAt: ptr.Time(now.Add(-time.Hour))},
+			{TeamID: 3, Token: "stored"},
+		}, nil
+	}
+	ds.GetMDMAppleBootstrapPackageBytesFunc = func(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackage, error) {
+		switch token {
+		case "signed":
+			return &fleet.MDMAppleBootstrapPackage{Token: token, Bytes: signed}, nil
+		case "unsigned":
+			return &fleet.MDMAppleBootstrapPackage{Token: token, Bytes: unsigned}, nil
+		}
+		return &fleet.MDMAppleBootstrapPackage{Token: token}, nil
+	}
+
+	type result struct {
+		status fleet.MDMAppleBootstrapPackageSignatureStatus
+		detail string
+	}
+	results := make(map[string]result)
+	ds.SetMDMAppleBootstrapPackageSignatureStatusFunc = func(ctx context.Context, token string, status fleet.MDMAppleBootstrapPackageSignatureStatus, detail string, verifiedAt time.Time) error {
+		require.Equal(t, now, verifiedAt)
+		results[token] = result{status, detail}
+		return nil
+	}
+
+	store := &mdmAssetStore{assets: map[string][]byte{"stored": signed}}
+	err = VerifyBootstrapPackageSignatures(ctx, ds, store, ocspClient, kitlog.NewNopLogger(), now)
+	require.NoError(t, err)
+
+	require.Len(t, results, 3)
+	require.Equal(t, fleet.MDMAppleBootstrapPackageSignatureValid, results["signed"].status)
+	require.Contains(t, results["signed"].detail, "revocation status of certificate \"Developer ID Installer")
+	require.Equal(t, fleet.MDMAppleBootstrapPackageSignatureValid, results["stored"].status)
+	require.Equal(t, result{fleet.MDMAppleBootstrapPackageSignatureInvalid, "file is not signed"}, results["unsigned"])
+	require.NotContains(t, results, "recent")
+	// the OCSP check stops at the first failure
+	require.Equal(t, 2, ocspRequests)
+
+	// once the signing certificate expired, the package is invalid
+	results = make(map[string]result)
+	now = time.Date(2027, 3, 1, 0, 0, 0, 0, time.UTC)
+	err = VerifyBootstrapPackageSignatures(ctx, ds, store, ocspClient, kitlog.NewNopLogger(), now)
+	require.NoError(t, err)
+	require.Equal(t, fleet.MDMAppleBootstrapPackageSignatureInvalid, results["signed"].status)
+	require.Regexp(t, `^invalid signing certificate chain: certificate "Developer ID Installer.*" expired on 2027-02-01`, results["signed"].detail)
+}
+
+type mdmAssetStore struct {
+	fleet.MDMAssetStore
+	assets map[string][]byte
+}
+
+func (s *mdmAssetStore) Get(ctx context.Context, kind fleet.MDMAssetKind, token string) (io.ReadCloser, int64, error) {
+	b := s.assets[token]
+	return io.NopCloser(bytes.NewReader(b)), int64(len(b)), nil
+}
+
+func TestCheckOCSP(t *testing.T) {
+	ctx := context.Background()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	revokedAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	status := ocsp.Good
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		req, err := ocsp.ParseRequest(b)
+		require.NoError(t, err)
+		resp, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now(),
+			RevokedAt:    revokedAt,
+		}, caKey)
+		require.NoError(t, err)
+		_, _ = w.Write(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test Leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{srv.URL},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, ca, &leafKey.PublicKey, caKey)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(leafDER)
+	require.NoError(t, err)
+
+	got, err := checkOCSP(ctx, srv.Client(), leaf, ca)
+	require.NoError(t, err)
+	require.Nil(t, got)
+
+	status = ocsp.Revoked
+	got, err = checkOCSP(ctx, srv.Client(), leaf, ca)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	require.True(t, revokedAt.Equal(*got))
+
+	status = ocsp.Unknown
+	_, err = checkOCSP(ctx, srv.Client(), leaf, ca)
+	require.ErrorContains(t, err, "doesn't know the certificate")
+
+	// certificates without an OCSP responder are not checked
+	got, err = checkOCSP(ctx, srv.Client(), ca, ca)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
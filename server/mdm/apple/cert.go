@@ -3,12 +3,18 @@ package apple_mdm
 import (
 	"bytes"
 	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -174,3 +180,118 @@ func DecryptBase64CMS(p7Base64 string, cert *x509.Certificate, key crypto.Privat
 
 	return p7.Decrypt(cert, key)
 }
+
+// DecryptBase64CMSWithCertificates is like DecryptBase64CMS, but tries each
+// of the certificates in order until one of them is a recipient of the
+// message. It is used during a SCEP CA rotation, when the message may have
+// been encrypted with the current or the previous CA.
+func DecryptBase64CMSWithCertificates(p7Base64 string, certs []*tls.Certificate) ([]byte, error) {
+	p7Bytes, err := base64.StdEncoding.DecodeString(p7Base64)
+	if err != nil {
+		return nil, err
+	}
+
+	p7, err := pkcs7.Parse(p7Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = errors.New("no certificate to decrypt the message")
+	for _, cert := range certs {
+		var b []byte
+		if b, err = p7.Decrypt(cert.Leaf, cert.PrivateKey); err == nil {
+			return b, nil
+		}
+	}
+	return nil, err
+}
+
+// EncryptBase64WithCertificate encrypts data so that only the owner of the
+// private key of cert (e.g. the SCEP CA) can decrypt it, and returns the
+// base64-encoded message. It is used to store the secrets reported by the
+// hosts encrypted at rest, like the disk encryption keys encrypted by the
+// hosts themselves. The data is encrypted with a random AES-256-GCM key, which
+// is encrypted with the RSA public key of cert using OAEP.
+func EncryptBase64WithCertificate(data []byte, cert *x509.Certificate) (string, error) {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return "", errors.New("the certificate must have an RSA public key")
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("generate key: %w", err)
+	}
+	encKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return "", fmt.Errorf("encrypt key: %w", err)
+	}
+	aesGCM, err := newAESGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aesGCM.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+
+	// the message is the length of the encrypted key, the encrypted key, the
+	// nonce and the encrypted data.
+	msg := binary.BigEndian.AppendUint16(nil, uint16(len(encKey)))
+	msg = append(msg, encKey...)
+	msg = append(msg, nonce...)
+	msg = aesGCM.Seal(msg, nonce, data, nil)
+	return base64.StdEncoding.EncodeToString(msg), nil
+}
+
+// DecryptBase64WithCertificates decrypts a message returned by
+// EncryptBase64WithCertificate. It tries each of the certificates in order
+// until one of them can decrypt the message, as during a SCEP CA rotation the
+// message may have been encrypted with the current or the previous CA.
+func DecryptBase64WithCertificates(msgBase64 string, certs []*tls.Certificate) ([]byte, error) {
+	msg, err := base64.StdEncoding.DecodeString(msgBase64)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) < 2 {
+		return nil, errors.New("message is too short")
+	}
+	keyLen := int(binary.BigEndian.Uint16(msg))
+	if len(msg) < 2+keyLen {
+		return nil, errors.New("message is too short")
+	}
+	encKey, rest := msg[2:2+keyLen], msg[2+keyLen:]
+
+	err = errors.New("no certificate to decrypt the message")
+	for _, cert := range certs {
+		priv, ok := cert.PrivateKey.(*rsa.PrivateKey)
+		if !ok {
+			continue
+		}
+		var key []byte
+		if key, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, encKey, nil); err != nil {
+			continue
+		}
+		aesGCM, err := newAESGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) < aesGCM.NonceSize() {
+			return nil, errors.New("message is too short")
+		}
+		return aesGCM.Open(nil, rest[:aesGCM.NonceSize()], rest[aesGCM.NonceSize():], nil)
+	}
+	return nil, err
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create GCM cipher: %w", err)
+	}
+	return aesGCM, nil
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/micromdm/nanodep/tokenpki"
 	"github.com/stretchr/testify/require"
 )
 
@@ -58,6 +59,64 @@ func TestDecodeCMS(t *testing.T) {
 	}
 }
 
+func TestDecodeCMSWithCertificates(t *testing.T) {
+	// the first valid message of TestDecodeCMS
+	msg := "MIAGCSqGSIb3DQEHA6CAMIACAQAxggFQMIIBTAIBADA0MC8xCTAHBgNVBAYTADEQMA4GA1UEChMHc2NlcC1jYTEQMA4GA1UECxMHU0NFUCBDQQIBATANBgkqhkiG9w0BAQEFAASCAQBusyskXHF7kvWwwBs44zMvQfbtoBOMtESu7Wb2kFlSLOWFJjeb5wMUtmP6OUEYiNC5qP5Ig7m2KHRBtmdG/bUNH+UZQrn84PCIImwNeXl7o0NE5qxQda+R/n4gxCAc5XzjYPCfYVQsFiC2qVps5QPyNlXLoR7hnEm0nSgVeDYW6qg0vg5mZYRZZ01RE2T8HYEUWgjIRiVPbDkj/sGRASsFGuRlCiMx0fdkfH+fB4ir9VvUpW38GjFVkvnGoIflperEaKk6uOfQIzwFpV9kn+xVFxWSq6jNp99ASne40mHIvUH8D/7a7qpfCFWF9RXkY4A/vUU8cQtqUryvqReaWKHHMIAGCSqGSIb3DQEHATAUBggqhkiG9w0DBwQIjCknWIVENfWggAQYGVn0ydCHbO/umUeDFBbz620zBAHJ3yUcBAhglCmprZMW8gAAAAAAAAAAAAA="
+
+	cert, err := tls.X509KeyPair(testSCEPCert, testSCEPKey)
+	require.NoError(t, err)
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	otherKey, otherCert, err := tokenpki.SelfSignedRSAKeypair("other", 1)
+	require.NoError(t, err)
+	other := tls.Certificate{Certificate: [][]byte{otherCert.Raw}, PrivateKey: otherKey, Leaf: otherCert}
+
+	_, err = DecryptBase64CMSWithCertificates(msg, nil)
+	require.Error(t, err)
+	_, err = DecryptBase64CMSWithCertificates(msg, []*tls.Certificate{&other})
+	require.EqualError(t, err, "pkcs7: no enveloped recipient for provided certificate")
+
+	for _, certs := range [][]*tls.Certificate{{&cert}, {&other, &cert}, {&cert, &other}} {
+		key, err := DecryptBase64CMSWithCertificates(msg, certs)
+		require.NoError(t, err)
+		require.Equal(t, []byte("5ADZ-HTZ8-LJJ4-B2F8-JWH3-YPBT"), key)
+	}
+}
+
+func TestEncryptDecryptBase64WithCertificates(t *testing.T) {
+	cert, err := tls.X509KeyPair(testSCEPCert, testSCEPKey)
+	require.NoError(t, err)
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	otherKey, otherCert, err := tokenpki.SelfSignedRSAKeypair("other", 1)
+	require.NoError(t, err)
+	other := tls.Certificate{Certificate: [][]byte{otherCert.Raw}, PrivateKey: otherKey, Leaf: otherCert}
+
+	msg, err := EncryptBase64WithCertificate([]byte("AAAA-BBBB-CCCC"), cert.Leaf)
+	require.NoError(t, err)
+	require.NotContains(t, msg, "AAAA")
+
+	// each message is encrypted with a different key
+	msg2, err := EncryptBase64WithCertificate([]byte("AAAA-BBBB-CCCC"), cert.Leaf)
+	require.NoError(t, err)
+	require.NotEqual(t, msg, msg2)
+
+	_, err = DecryptBase64WithCertificates(msg, nil)
+	require.Error(t, err)
+	_, err = DecryptBase64WithCertificates(msg, []*tls.Certificate{&other})
+	require.Error(t, err)
+	_, err = DecryptBase64WithCertificates("invalid", []*tls.Certificate{&cert})
+	require.Error(t, err)
+
+	for _, certs := range [][]*tls.Certificate{{&cert}, {&other, &cert}, {&cert, &other}} {
+		data, err := DecryptBase64WithCertificates(msg, certs)
+		require.NoError(t, err)
+		require.Equal(t, []byte("AAAA-BBBB-CCCC"), data)
+	}
+}
+
 var (
 	testSCEPCert = []byte(`-----BEGIN CERTIFICATE-----
 MIIDGzCCAgOgAwIBAgIBATANBgkqhkiG9w0BAQsFADAvMQkwBwYD
@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/mdm/apple/appmanifest"
 	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
 	"github.com/groob/plist"
@@ -43,8 +44,21 @@ func NewMDMAppleCommander(mdmStorage nanomdm_storage.AllStorage, mdmPushService
 // InstallProfile sends the homonymous MDM command to the given hosts, it also
 // takes care of the base64 encoding of the provided profile bytes.
 func (svc *MDMAppleCommander) InstallProfile(ctx context.Context, hostUUIDs []string, profile mobileconfig.Mobileconfig, uuid string) error {
+	raw := installProfileCommand(uuid, profile)
+	err := svc.EnqueueCommand(ctx, hostUUIDs, raw)
+	return ctxerr.Wrap(ctx, err, "commander install profile")
+}
+
+// RedactedInstallProfileCommand returns the InstallProfile command with the
+// given uuid where the profile is replaced by the redacted value, to replace
+// a command that installed a profile holding secrets once it is processed.
+func RedactedInstallProfileCommand(uuid string) string {
+	return installProfileCommand(uuid, []byte(fleet.MDMAppleRedactedValue))
+}
+
+func installProfileCommand(uuid string, profile []byte) string {
 	base64Profile := base64.StdEncoding.EncodeToString(profile)
-	raw := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
 <!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
 <plist version="1.0">
 <dict>
@@ -59,8 +73,6 @@ func (svc *MDMAppleCommander) InstallProfile(ctx context.Context, hostUUIDs []st
 	</dict>
 </dict>
 </plist>`, uuid, base64Profile)
-	err := svc.EnqueueCommand(ctx, hostUUIDs, raw)
-	return ctxerr.Wrap(ctx, err, "commander install profile")
 }
 
 // InstallProfile sends the homonymous MDM command to the given hosts.
@@ -84,7 +96,9 @@ func (svc *MDMAppleCommander) RemoveProfile(ctx context.Context, hostUUIDs []str
 	return ctxerr.Wrap(ctx, err, "commander remove profile")
 }
 
-func (svc *MDMAppleCommander) DeviceLock(ctx context.Context, hostUUIDs []string, uuid string) error {
+// DeviceLock locks the hosts, the command is enqueued with the urgent priority.
+// It returns the PIN that unlocks the hosts.
+func (svc *MDMAppleCommander) DeviceLock(ctx context.Context, hostUUIDs []string, uuid string) (string, error) {
 	pin := GenerateRandomPin(6)
 	raw := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
 <!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
@@ -101,10 +115,16 @@ func (svc *MDMAppleCommander) DeviceLock(ctx context.Context, hostUUIDs []string
     </dict>
   </dict>
 </plist>`, uuid, pin)
-	return svc.EnqueueCommand(ctx, hostUUIDs, raw)
+	if err := svc.EnqueueCommandWithPriority(ctx, hostUUIDs, raw, fleet.MDMAppleCommandPriorityUrgent); err != nil {
+		return "", err
+	}
+	return pin, nil
 }
 
-func (svc *MDMAppleCommander) EraseDevice(ctx context.Context, hostUUIDs []string, uuid string) error {
+// EraseDevice wipes the hosts, the command is enqueued with the urgent priority.
+// It returns the PIN that unlocks the hosts if they are locked by Find My
+// after they are wiped.
+func (svc *MDMAppleCommander) EraseDevice(ctx context.Context, hostUUIDs []string, uuid string) (string, error) {
 	pin := GenerateRandomPin(6)
 	raw := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
 <!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
@@ -121,7 +141,10 @@ func (svc *MDMAppleCommander) EraseDevice(ctx context.Context, hostUUIDs []strin
     </dict>
   </dict>
 </plist>`, uuid, pin)
-	return svc.EnqueueCommand(ctx, hostUUIDs, raw)
+	if err := svc.EnqueueCommandWithPriority(ctx, hostUUIDs, raw, fleet.MDMAppleCommandPriorityUrgent); err != nil {
+		return "", err
+	}
+	return pin, nil
 }
 
 func (svc *MDMAppleCommander) InstallEnterpriseApplication(ctx context.Context, hostUUIDs []string, uuid string, manifestURL string) error {
@@ -144,6 +167,71 @@ func (svc *MDMAppleCommander) InstallEnterpriseApplication(ctx context.Context,
 	return svc.EnqueueCommand(ctx, hostUUIDs, raw)
 }
 
+// ActivationLockBypassCode requests the Activation Lock bypass code of the
+// hosts, which must be supervised. The code is returned in the result of the
+// command.
+func (svc *MDMAppleCommander) ActivationLockBypassCode(ctx context.Context, hostUUIDs []string, uuid string) error {
+	raw := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+  <dict>
+    <key>CommandUUID</key>
+    <string>%s</string>
+    <key>Command</key>
+    <dict>
+      <key>RequestType</key>
+      <string>ActivationLockBypassCode</string>
+    </dict>
+  </dict>
+</plist>`, uuid)
+	return svc.EnqueueCommand(ctx, hostUUIDs, raw)
+}
+
+// CertificateList requests the list of certificates installed on the hosts.
+// If managedOnly is true, only the certificates installed by MDM payloads are
+// listed. The command is enqueued with the low priority, as it is used for
+// periodic refreshes.
+func (svc *MDMAppleCommander) CertificateList(ctx context.Context, hostUUIDs []string, uuid string, managedOnly bool) error {
+	raw := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+  <dict>
+    <key>CommandUUID</key>
+    <string>%s</string>
+    <key>Command</key>
+    <dict>
+      <key>RequestType</key>
+      <string>CertificateList</string>
+      <key>ManagedOnly</key>
+      <%t/>
+    </dict>
+  </dict>
+</plist>`, uuid, managedOnly)
+	return svc.EnqueueCommandWithPriority(ctx, hostUUIDs, raw, fleet.MDMAppleCommandPriorityLow)
+}
+
+// ProfileList requests the list of profiles installed by MDM on the hosts.
+// The command is enqueued with the low priority, as it is used for periodic
+// refreshes.
+func (svc *MDMAppleCommander) ProfileList(ctx context.Context, hostUUIDs []string, uuid string) error {
+	raw := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+  <dict>
+    <key>CommandUUID</key>
+    <string>%s</string>
+    <key>Command</key>
+    <dict>
+      <key>RequestType</key>
+      <string>ProfileList</string>
+      <key>ManagedOnly</key>
+      <true/>
+    </dict>
+  </dict>
+</plist>`, uuid)
+	return svc.EnqueueCommandWithPriority(ctx, hostUUIDs, raw, fleet.MDMAppleCommandPriorityLow)
+}
+
 type installEnterpriseApplicationPayload struct {
 	Manifest    *appmanifest.Manifest
 	RequestType string
@@ -178,32 +266,82 @@ func (svc *MDMAppleCommander) InstallEnterpriseApplicationWithEmbeddedManifest(
 // internally, leaving making pushes optional as an optimization to be tackled
 // later.
 func (svc *MDMAppleCommander) EnqueueCommand(ctx context.Context, hostUUIDs []string, rawCommand string) error {
+	return svc.EnqueueCommandWithPriority(ctx, hostUUIDs, rawCommand, fleet.MDMAppleCommandPriorityNormal)
+}
+
+// priorityEnqueuer is implemented by the MDM storages that store the priority
+// of the enqueued commands.
+type priorityEnqueuer interface {
+	EnqueueCommandWithPriority(ctx context.Context, ids []string, cmd *mdm.Command, priority int) error
+}
+
+// EnqueueCommandWithPriority is like EnqueueCommand, but the command is
+// delivered to the devices before (or after) the commands already queued with
+// a lower (or higher) priority. The push notifications are always sent right
+// away, so urgent commands are never delayed by bulk operations. If the
+// storage doesn't support priorities, the command is enqueued with the normal
+// priority.
+func (svc *MDMAppleCommander) EnqueueCommandWithPriority(ctx context.Context, hostUUIDs []string, rawCommand string, priority fleet.MDMAppleCommandPriority) error {
+	// the trace ID is included in the logs of the storage and push service.
+	ctx = ensureTraceID(ctx)
+
 	cmd, err := mdm.DecodeCommand([]byte(rawCommand))
 	if err != nil {
 		return ctxerr.Wrap(ctx, err, "commander enqueue")
 	}
 
-	// MySQL implementation always returns nil for the first parameter
-	_, err = svc.storage.EnqueueCommand(ctx, hostUUIDs, cmd)
+	if pe, ok := svc.storage.(priorityEnqueuer); ok && priority.QueuePriority() != 0 {
+		err = pe.EnqueueCommandWithPriority(ctx, hostUUIDs, cmd, priority.QueuePriority())
+	} else {
+		// MySQL implementation always returns nil for the first parameter
+		_, err = svc.storage.EnqueueCommand(ctx, hostUUIDs, cmd)
+	}
 	if err != nil {
 		return ctxerr.Wrap(ctx, err, "commander enqueue")
 	}
 
-	apnsResponses, err := svc.pusher.Push(ctx, hostUUIDs)
-	if err != nil {
-		return ctxerr.Wrap(ctx, err, "commander push")
-	}
+	return svc.pushInBatches(ctx, hostUUIDs)
+}
+
+// commanderPushBatchSize is the maximum number of devices notified by a
+// single call to the push service.
+const commanderPushBatchSize = 1000
 
-	// Even if we didn't get an error, some of the APNs
-	// responses might have failed, signal that to the caller.
+// pushInBatches sends the push notifications to the devices, in batches of
+// commanderPushBatchSize so that commands enqueued for a large number of
+// devices (e.g. a whole team) don't result in a single huge APNs request. The
+// failures of all batches are aggregated in a single APNSDeliveryError.
+func (svc *MDMAppleCommander) pushInBatches(ctx context.Context, hostUUIDs []string) error {
 	var failed []string
-	for uuid, response := range apnsResponses {
-		if response.Err != nil {
-			failed = append(failed, uuid)
+	var pushErr error
+	for len(hostUUIDs) > 0 {
+		batch := hostUUIDs
+		if len(batch) > commanderPushBatchSize {
+			batch = batch[:commanderPushBatchSize]
+		}
+		hostUUIDs = hostUUIDs[len(batch):]
+
+		apnsResponses, err := svc.pusher.Push(ctx, batch)
+		if err != nil {
+			// the command is already enqueued, so signal the push failure for all
+			// hosts of the batch, they'll get the command on their next check-in.
+			failed = append(failed, batch...)
+			if pushErr == nil {
+				pushErr = ctxerr.Wrap(ctx, err, "commander push")
+			}
+			continue
+		}
+
+		// Even if we didn't get an error, some of the APNs
+		// responses might have failed, signal that to the caller.
+		for uuid, response := range apnsResponses {
+			if response.Err != nil {
+				failed = append(failed, uuid)
+			}
 		}
 	}
 	if len(failed) > 0 {
-		return &APNSDeliveryError{FailedUUIDs: failed, Err: err}
+		return &APNSDeliveryError{FailedUUIDs: failed, Err: pushErr}
 	}
 
 	return nil
@@ -0,0 +1,83 @@
+package apple_mdm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	nanomdm_mock "github.com/fleetdm/fleet/v4/server/mock/nanomdm"
+	"github.com/micromdm/nanomdm/mdm"
+	nanomdm_push "github.com/micromdm/nanomdm/push"
+	"github.com/stretchr/testify/require"
+)
+
+type batchRecordingPusher struct {
+	batches [][]string
+	failing map[int]bool // index of the batches that fail to be pushed
+}
+
+func (p *batchRecordingPusher) Push(ctx context.Context, ids []string) (map[string]*nanomdm_push.Response, error) {
+	p.batches = append(p.batches, ids)
+	if p.failing[len(p.batches)-1] {
+		return nil, errors.New("push failed")
+	}
+	res := make(map[string]*nanomdm_push.Response, len(ids))
+	for _, id := range ids {
+		res[id] = &nanomdm_push.Response{Id: "push-" + id}
+	}
+	return res, nil
+}
+
+func TestCommanderEnqueueCommandPushesInBatches(t *testing.T) {
+	ctx := context.Background()
+	rawCmd := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>ProfileList</string>
+	</dict>
+	<key>CommandUUID</key>
+	<string>uuid-1</string>
+</dict>
+</plist>`
+
+	uuids := make([]string, commanderPushBatchSize*2+1)
+	for i := range uuids {
+		uuids[i] = fmt.Sprintf("host-%d", i)
+	}
+
+	storage := &nanomdm_mock.Storage{}
+	storage.EnqueueCommandFunc = func(ctx context.Context, ids []string, cmd *mdm.Command) (map[string]error, error) {
+		require.Len(t, ids, len(uuids))
+		return nil, nil
+	}
+
+	t.Run("all batches succeed", func(t *testing.T) {
+		pusher := &batchRecordingPusher{}
+		cmdr := NewMDMAppleCommander(storage, pusher)
+		err := cmdr.EnqueueCommandWithPriority(ctx, uuids, rawCmd, fleet.MDMAppleCommandPriorityNormal)
+		require.NoError(t, err)
+		require.Len(t, pusher.batches, 3)
+		require.Len(t, pusher.batches[0], commanderPushBatchSize)
+		require.Len(t, pusher.batches[1], commanderPushBatchSize)
+		require.Equal(t, []string{uuids[len(uuids)-1]}, pusher.batches[2])
+	})
+
+	t.Run("one batch fails", func(t *testing.T) {
+		pusher := &batchRecordingPusher{failing: map[int]bool{2: true}}
+		cmdr := NewMDMAppleCommander(storage, pusher)
+		err := cmdr.EnqueueCommandWithPriority(ctx, uuids, rawCmd, fleet.MDMAppleCommandPriorityNormal)
+		var apnsErr *APNSDeliveryError
+		require.ErrorAs(t, err, &apnsErr)
+		require.Len(t, pusher.batches, 3)
+		sort.Strings(apnsErr.FailedUUIDs)
+		require.Equal(t, []string{uuids[len(uuids)-1]}, apnsErr.FailedUUIDs)
+		require.ErrorContains(t, apnsErr.Err, "push failed")
+	})
+}
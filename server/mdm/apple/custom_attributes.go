@@ -0,0 +1,52 @@
+package apple_mdm
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+)
+
+// HostCustomAttributeVarPrefix is the prefix of the variables that reference
+// the host custom attributes in the configuration profiles and in the policy
+// command templates, e.g. $FLEET_VAR_HOST_CUSTOM_ATTRIBUTE_COST_CENTER for
+// the "cost_center" custom attribute.
+const HostCustomAttributeVarPrefix = "HOST_CUSTOM_ATTRIBUTE_"
+
+// hostCustomAttributeVarName returns the name of the custom attribute
+// referenced by the variable name (without the FLEET_VAR_ prefix), or false if
+// the variable does not reference a custom attribute.
+func hostCustomAttributeVarName(varName string) (string, bool) {
+	if !strings.HasPrefix(varName, HostCustomAttributeVarPrefix) || len(varName) == len(HostCustomAttributeVarPrefix) {
+		return "", false
+	}
+	return strings.ToLower(strings.TrimPrefix(varName, HostCustomAttributeVarPrefix)), true
+}
+
+// HasHostCustomAttributeVars returns true if the configuration profile or
+// policy command template references at least one host custom attribute
+// variable.
+func HasHostCustomAttributeVars(b []byte) bool {
+	for _, m := range fleetVarRegexp.FindAllSubmatch(b, -1) {
+		if _, ok := hostCustomAttributeVarName(string(m[1]) + string(m[2])); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandProfileHostCustomAttributeVars returns the configuration profile with
+// the host custom attribute variables replaced by the XML-escaped values of
+// the attributes in attrs, keyed by attribute name. The variables of
+// attributes that the host does not have are replaced by an empty string.
+func ExpandProfileHostCustomAttributeVars(profile []byte, attrs map[string]string) []byte {
+	return fleetVarRegexp.ReplaceAllFunc(profile, func(v []byte) []byte {
+		m := fleetVarRegexp.FindSubmatch(v)
+		name, ok := hostCustomAttributeVarName(string(m[1]) + string(m[2]))
+		if !ok {
+			return v
+		}
+		var buf bytes.Buffer
+		_ = xml.EscapeText(&buf, []byte(attrs[name]))
+		return buf.Bytes()
+	})
+}
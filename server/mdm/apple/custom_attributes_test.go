@@ -0,0 +1,53 @@
+package apple_mdm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostCustomAttributeVars(t *testing.T) {
+	profile := []byte(`<plist version="1.0">
+<dict>
+	<key>PayloadDisplayName</key>
+	<string>$FLEET_VAR_HOST_CUSTOM_ATTRIBUTE_COST_CENTER</string>
+	<key>AssignedUser</key>
+	<string>${FLEET_VAR_HOST_CUSTOM_ATTRIBUTE_IDP_FULL_NAME} ($FLEET_VAR_HOST_CUSTOM_ATTRIBUTE_MISSING)</string>
+	<key>Other</key>
+	<string>$FLEET_VAR_HOST_UUID $FLEET_VAR_HOST_CUSTOM_ATTRIBUTE_</string>
+</dict>
+</plist>`)
+
+	require.True(t, HasHostCustomAttributeVars(profile))
+	require.False(t, HasHostCustomAttributeVars([]byte(`<string>$FLEET_VAR_HOST_UUID $FLEET_VAR_HOST_CUSTOM_ATTRIBUTE_</string>`)))
+	require.False(t, HasHostCustomAttributeVars([]byte(`<string>no vars</string>`)))
+
+	got := ExpandProfileHostCustomAttributeVars(profile, map[string]string{
+		"cost_center":   "CC-123",
+		"idp_full_name": "Jane <Doe> & co",
+	})
+	require.Contains(t, string(got), "<string>CC-123</string>")
+	require.Contains(t, string(got), "<string>Jane &lt;Doe&gt; &amp; co ()</string>")
+	require.Contains(t, string(got), "<string>$FLEET_VAR_HOST_UUID $FLEET_VAR_HOST_CUSTOM_ATTRIBUTE_</string>")
+}
+
+func TestPolicyCommandTemplateHostCustomAttributes(t *testing.T) {
+	template := `<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>DeviceLock</string>
+		<key>Message</key>
+		<string>Contact $FLEET_VAR_HOST_CUSTOM_ATTRIBUTE_IDP_FULL_NAME$FLEET_VAR_HOST_CUSTOM_ATTRIBUTE_MISSING</string>
+	</dict>
+</dict>
+</plist>`
+	require.NoError(t, ValidatePolicyCommandTemplate(template))
+
+	vars := PolicyCommandVariables{PolicyCommandVarHostUUID: "host-uuid"}
+	vars.SetHostCustomAttributes(map[string]string{"idp_full_name": "Jane Doe"})
+	raw, err := ExpandPolicyCommandTemplate(template, vars, "cmd-uuid")
+	require.NoError(t, err)
+	require.Contains(t, raw, "<string>Contact Jane Doe</string>")
+}
@@ -0,0 +1,322 @@
+package apple_mdm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/config"
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/micromdm/nanodep/godep"
+	depsync "github.com/micromdm/nanodep/sync"
+)
+
+// Default settings of the backoff applied to the requests to the Apple DEP
+// API that are rate-limited or fail with a server error.
+const (
+	depRetryMaxAttempts = 5
+	depRetryMinBackoff  = 1 * time.Second
+	depRetryMaxBackoff  = 1 * time.Minute
+)
+
+// depRetryTransport is an http.RoundTripper that retries the requests to the
+// Apple DEP API that fail with a 429 Too Many Requests or a 5xx status code
+// (e.g. during Apple's maintenance windows).
+//
+// The backoff is adaptive: the delay doubles on every such failure (or is set
+// to the delay requested by the Retry-After header, if longer) and halves on
+// every success, and it is applied before every request, so that the requests
+// that follow a rate-limited one are spaced out too.
+type depRetryTransport struct {
+	next   http.RoundTripper
+	logger kitlog.Logger
+
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	// sleep waits for the duration or until the context is done, it is a
+	// field so that tests can override it.
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu    sync.Mutex
+	delay time.Duration
+}
+
+func newDEPRetryTransport(next http.RoundTripper, logger kitlog.Logger) *depRetryTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &depRetryTransport{
+		next:        next,
+		logger:      logger,
+		maxAttempts: depRetryMaxAttempts,
+		minBackoff:  depRetryMinBackoff,
+		maxBackoff:  depRetryMaxBackoff,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+				return nil
+			}
+		},
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *depRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		if d := t.currentDelay(); d > 0 {
+			if err := t.sleep(req.Context(), d); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		if !isRetryableDEPStatus(resp.StatusCode) {
+			t.decreaseDelay()
+			return resp, nil
+		}
+
+		delay := t.increaseDelay(parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()))
+		canRetry := req.Body == nil || req.GetBody != nil
+		if attempt >= t.maxAttempts || !canRetry {
+			return resp, nil
+		}
+		level.Info(t.logger).Log("msg", "Apple DEP request failed, retrying", "path", req.URL.Path,
+			"status", resp.StatusCode, "attempt", attempt, "backoff", delay)
+
+		// drain the body so that the connection can be reused
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+func (t *depRetryTransport) currentDelay() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.delay
+}
+
+func (t *depRetryTransport) increaseDelay(retryAfter time.Duration) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d := t.delay * 2
+	if d < t.minBackoff {
+		d = t.minBackoff
+	}
+	if retryAfter > d {
+		d = retryAfter
+	}
+	if d > t.maxBackoff {
+		d = t.maxBackoff
+	}
+	t.delay = d
+	return d
+}
+
+func (t *depRetryTransport) decreaseDelay() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.delay /= 2
+	if t.delay < t.minBackoff {
+		t.delay = 0
+	}
+}
+
+func isRetryableDEPStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter returns the delay requested by the value of a Retry-After
+// header, which is either a number of seconds or an HTTP date. It returns 0 if
+// the value is empty or invalid.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// ErrDEPCursorConflict is returned by the DEP cursor storages that support
+// swapping the cursor when the stored cursor was changed by another runner
+// (e.g. another Fleet instance) since it was retrieved.
+var ErrDEPCursorConflict = errors.New("DEP cursor was changed by another runner")
+
+// depCursorSwapper is implemented by the DEP cursor storages that can store the
+// cursor only if it was not changed since it was retrieved, which prevents
+// concurrent runners from overwriting each other's cursor.
+type depCursorSwapper interface {
+	SwapCursor(ctx context.Context, name, oldCursor, newCursor string) error
+}
+
+// swapDEPCursor stores newCursor if the stored cursor is still oldCursor. If
+// the storage does not support swapping the cursor, it is stored
+// unconditionally.
+func swapDEPCursor(ctx context.Context, store depsync.CursorStorage, oldCursor, newCursor string) error {
+	if sw, ok := store.(depCursorSwapper); ok {
+		return sw.SwapCursor(ctx, DEPName, oldCursor, newCursor)
+	}
+	return store.StoreCursor(ctx, DEPName, newCursor)
+}
+
+// depSyncer fetches and syncs the DEP devices from Apple Business Manager and
+// passes them to the callback, one page at a time.
+//
+// Unlike the nanodep syncer, the cursor is only advanced once the devices of a
+// page have been processed by the callback and an error is returned if a
+// request or the callback fails, so that an interrupted sync resumes from the
+// last processed page on the next run. It also stops at the end of the sync
+// windows, if any.
+//
+// If the storage supports it, the cursor is swapped instead of overwritten,
+// and the syncer stops when another runner processed the current page
+// concurrently, so that the devices are not assigned twice and the cursor does
+// not go back.
+type depSyncer struct {
+	client   *godep.Client
+	store    depsync.CursorStorage
+	callback depsync.DeviceResponseCallback
+	windows  []config.DailyWindow
+	logger   kitlog.Logger
+	now      func() time.Time
+}
+
+// inWindow returns true if the devices can be synced now.
+func (s *depSyncer) inWindow() bool {
+	if len(s.windows) == 0 {
+		return true
+	}
+	now := s.now()
+	for _, w := range s.windows {
+		if w.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run fetches all the devices if the cursor is empty or if a previous fetch
+// was interrupted, and then syncs the devices changed since the cursor.
+func (s *depSyncer) Run(ctx context.Context) error {
+	stored, _, err := s.store.RetrieveCursor(ctx, DEPName)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "retrieve cursor")
+	}
+	// stored is the last cursor known to be stored, it differs from cursor
+	// when the stored cursor is expired or invalid.
+	cursor := stored
+
+	doFetch := true
+	for {
+		phase := "sync"
+		if doFetch {
+			phase = "fetch"
+		}
+
+		if !s.inWindow() {
+			level.Info(s.logger).Log("msg", "DEP sync window closed, sync will resume in the next window", "phase", phase, "cursor", cursor)
+			return nil
+		}
+
+		var resp *godep.DeviceResponse
+		if doFetch {
+			resp, err = s.client.FetchDevices(ctx, DEPName, godep.WithCursor(cursor))
+			if err != nil && godep.IsCursorExhausted(err) {
+				level.Debug(s.logger).Log("msg", "cursor returned all devices previously", "phase", phase, "cursor", cursor)
+				doFetch = false
+				continue
+			}
+		} else {
+			resp, err = s.client.SyncDevices(ctx, DEPName, godep.WithCursor(cursor))
+		}
+		if err != nil {
+			if godep.IsCursorExpired(err) || godep.IsCursorInvalid(err) {
+				level.Info(s.logger).Log("msg", "cursor error, retrying with empty cursor", "phase", phase, "cursor", cursor, "err", err)
+				cursor = ""
+				doFetch = true
+				continue
+			}
+			// the cursor of the last processed page is kept, the next run
+			// resumes from there.
+			return ctxerr.Wrapf(ctx, err, "%s devices", phase)
+		}
+
+		level.Info(s.logger).Log("msg", "device sync", "phase", phase, "more", resp.MoreToFollow,
+			"cursor", resp.Cursor, "devices", len(resp.Devices))
+
+		if _, ok := s.store.(depCursorSwapper); ok {
+			current, _, err := s.store.RetrieveCursor(ctx, DEPName)
+			if err != nil {
+				return ctxerr.Wrap(ctx, err, "retrieve cursor")
+			}
+			if current != stored {
+				level.Info(s.logger).Log("msg", "cursor changed by another runner, stopping", "phase", phase, "cursor", stored, "stored_cursor", current)
+				return nil
+			}
+		}
+
+		if s.callback != nil {
+			if err := s.callback(ctx, doFetch, resp); err != nil {
+				return ctxerr.Wrapf(ctx, err, "process %s devices", phase)
+			}
+		}
+
+		if stored != resp.Cursor {
+			if err := swapDEPCursor(ctx, s.store, stored, resp.Cursor); err != nil {
+				if errors.Is(err, ErrDEPCursorConflict) {
+					level.Info(s.logger).Log("msg", "cursor changed by another runner, stopping", "phase", phase, "cursor", stored)
+					return nil
+				}
+				return ctxerr.Wrap(ctx, err, "store cursor")
+			}
+			stored = resp.Cursor
+		}
+		cursor = resp.Cursor
+
+		if resp.MoreToFollow {
+			continue
+		}
+		if doFetch {
+			doFetch = false
+			continue
+		}
+		return nil
+	}
+}
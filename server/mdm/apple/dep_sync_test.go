@@ -0,0 +1,449 @@
+package apple_mdm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/config"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/mock"
+	nanodep_mock "github.com/fleetdm/fleet/v4/server/mock/nanodep"
+	"github.com/go-kit/log"
+	"github.com/micromdm/nanodep/client"
+	"github.com/micromdm/nanodep/godep"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDEPRetryTransport(t *testing.T) {
+	var statuses []int
+	var bodies []string
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(b))
+
+		i := atomic.AddInt32(&calls, 1) - 1
+		status := http.StatusOK
+		if int(i) < len(statuses) {
+			status = statuses[i]
+		}
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "3")
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	var slept []time.Duration
+	newTransport := func() *depRetryTransport {
+		slept = nil
+		tr := newDEPRetryTransport(nil, log.NewNopLogger())
+		tr.sleep = func(ctx context.Context, d time.Duration) error {
+			slept = append(slept, d)
+			return nil
+		}
+		return tr
+	}
+	reset := func(sts ...int) {
+		atomic.StoreInt32(&calls, 0)
+		statuses = sts
+		bodies = nil
+	}
+	post := func(tr *depRetryTransport) int {
+		req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+		require.NoError(t, err)
+		resp, err := tr.RoundTrip(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("success", func(t *testing.T) {
+		tr := newTransport()
+		reset()
+		require.Equal(t, http.StatusOK, post(tr))
+		require.EqualValues(t, 1, calls)
+		require.Empty(t, slept)
+	})
+
+	t.Run("retries rate-limited and server errors", func(t *testing.T) {
+		tr := newTransport()
+		reset(http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+		require.Equal(t, http.StatusOK, post(tr))
+		require.EqualValues(t, 3, calls)
+		require.Equal(t, []string{"payload", "payload", "payload"}, bodies)
+		// 1s after the 503, then 3s as requested by the Retry-After of the 429
+		require.Equal(t, []time.Duration{time.Second, 3 * time.Second}, slept)
+
+		// the delay is halved after the success and applied to the next request
+		reset()
+		require.Equal(t, http.StatusOK, post(tr))
+		require.Equal(t, []time.Duration{time.Second, 3 * time.Second, 1500 * time.Millisecond}, slept)
+
+		// and eventually reset
+		reset()
+		require.Equal(t, http.StatusOK, post(tr))
+		require.Len(t, slept, 3)
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		tr := newTransport()
+		reset(http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway,
+			http.StatusBadGateway, http.StatusBadGateway)
+		require.Equal(t, http.StatusBadGateway, post(tr))
+		require.EqualValues(t, depRetryMaxAttempts, calls)
+		require.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}, slept)
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		tr := newTransport()
+		reset(http.StatusBadRequest)
+		require.Equal(t, http.StatusBadRequest, post(tr))
+		require.EqualValues(t, 1, calls)
+		require.Empty(t, slept)
+	})
+
+	t.Run("context canceled while waiting", func(t *testing.T) {
+		tr := newDEPRetryTransport(nil, log.NewNopLogger())
+		reset(http.StatusTooManyRequests)
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		cancel()
+		_, err = tr.RoundTrip(req)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 6, 7, 10, 0, 0, 0, time.UTC)
+	cases := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"abc", 0},
+		{"-1", 0},
+		{"0", 0},
+		{"120", 2 * time.Minute},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second},
+		{now.Add(-30 * time.Second).Format(http.TimeFormat), 0},
+	}
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			require.Equal(t, c.want, parseRetryAfter(c.in, now))
+		})
+	}
+}
+
+func TestDEPSyncer(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+
+	// the fetch returns 3 pages of devices, then the sync returns no devices
+	pages := map[string]godep.DeviceResponse{
+		"":   {Cursor: "c1", MoreToFollow: true, Devices: []godep.Device{{SerialNumber: "s1"}}},
+		"c1": {Cursor: "c2", MoreToFollow: true, Devices: []godep.Device{{SerialNumber: "s2"}}},
+		"c2": {Cursor: "c3", MoreToFollow: false, Devices: []godep.Device{{SerialNumber: "s3"}}},
+	}
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/session" {
+			_, _ = w.Write([]byte(`{"auth_session_token": "xyz"}`))
+			return
+		}
+
+		var req struct {
+			Cursor string `json:"cursor"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests = append(requests, r.URL.Path+"@"+req.Cursor)
+
+		switch r.URL.Path {
+		case "/server/devices":
+			page, ok := pages[req.Cursor]
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`"EXHAUSTED_CURSOR"`))
+				return
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(page))
+		case "/devices/sync":
+			require.NoError(t, json.NewEncoder(w).Encode(godep.DeviceResponse{Cursor: "c4"}))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	ds := new(mock.Store)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+	ds.SaveAppConfigFunc = func(ctx context.Context, info *fleet.AppConfig) error {
+		return nil
+	}
+
+	var storedCursor string
+	depStorage := new(nanodep_mock.Storage)
+	depStorage.RetrieveConfigFunc = func(ctx context.Context, name string) (*client.Config, error) {
+		return &client.Config{BaseURL: srv.URL}, nil
+	}
+	depStorage.RetrieveAuthTokensFunc = func(ctx context.Context, name string) (*client.OAuth1Tokens, error) {
+		return &client.OAuth1Tokens{}, nil
+	}
+	depStorage.RetrieveCursorFunc = func(ctx context.Context, name string) (string, time.Time, error) {
+		return storedCursor, time.Time{}, nil
+	}
+	depStorage.StoreCursorFunc = func(ctx context.Context, name string, cursor string) error {
+		storedCursor = cursor
+		return nil
+	}
+
+	var processed []string
+	failOn := "s2"
+	syncer := &depSyncer{
+		client: NewDEPClient(depStorage, ds, logger),
+		store:  depStorage,
+		logger: logger,
+		now:    time.Now,
+		callback: func(ctx context.Context, isFetch bool, resp *godep.DeviceResponse) error {
+			for _, d := range resp.Devices {
+				if d.SerialNumber == failOn {
+					return errors.New("process failed")
+				}
+				processed = append(processed, d.SerialNumber)
+			}
+			return nil
+		},
+	}
+
+	// the second page fails, the cursor of the first page is kept
+	err := syncer.Run(ctx)
+	require.ErrorContains(t, err, "process failed")
+	require.Equal(t, "c1", storedCursor)
+	require.Equal(t, []string{"s1"}, processed)
+	require.Equal(t, []string{"/server/devices@", "/server/devices@c1"}, requests)
+
+	// the next run resumes from the second page
+	failOn = ""
+	requests = nil
+	err = syncer.Run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "c4", storedCursor)
+	require.Equal(t, []string{"s1", "s2", "s3"}, processed)
+	require.Equal(t, []string{"/server/devices@c1", "/server/devices@c2", "/devices/sync@c3"}, requests)
+
+	// a run after a complete fetch only syncs
+	requests = nil
+	err = syncer.Run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/server/devices@c4", "/devices/sync@c4"}, requests)
+
+	// outside of the sync windows, no request is made
+	requests = nil
+	now := time.Date(2023, 6, 7, 12, 0, 0, 0, time.UTC)
+	syncer.now = func() time.Time { return now }
+	syncer.windows = []config.DailyWindow{{Start: 22 * time.Hour, End: 6 * time.Hour}}
+	err = syncer.Run(ctx)
+	require.NoError(t, err)
+	require.Empty(t, requests)
+
+	now = time.Date(2023, 6, 7, 23, 0, 0, 0, time.UTC)
+	err = syncer.Run(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, requests)
+}
+
+// swappingDEPStorage is a DEP storage that supports swapping the cursor, like
+// the MySQL storage.
+type swappingDEPStorage struct {
+	*nanodep_mock.Storage
+
+	mu     sync.Mutex
+	cursor string
+}
+
+func (s *swappingDEPStorage) RetrieveCursor(ctx context.Context, name string) (string, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cursor, time.Time{}, nil
+}
+
+func (s *swappingDEPStorage) StoreCursor(ctx context.Context, name string, cursor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cursor = cursor
+	return nil
+}
+
+func (s *swappingDEPStorage) SwapCursor(ctx context.Context, name, oldCursor, newCursor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.cursor {
+	case newCursor:
+		return nil
+	case oldCursor:
+		s.cursor = newCursor
+		return nil
+	default:
+		return ErrDEPCursorConflict
+	}
+}
+
+func TestDEPSyncerConcurrentRunners(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+
+	pages := map[string]godep.DeviceResponse{
+		"":   {Cursor: "c1", MoreToFollow: true, Devices: []godep.Device{{SerialNumber: "s1"}}},
+		"c1": {Cursor: "c2", MoreToFollow: true, Devices: []godep.Device{{SerialNumber: "s2"}}},
+		"c2": {Cursor: "c3", MoreToFollow: false, Devices: []godep.Device{{SerialNumber: "s3"}}},
+	}
+	// onRequest simulates another runner storing a cursor while a request is
+	// in flight.
+	var onRequest func(cursor string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/session" {
+			_, _ = w.Write([]byte(`{"auth_session_token": "xyz"}`))
+			return
+		}
+
+		var req struct {
+			Cursor string `json:"cursor"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if onRequest != nil {
+			onRequest(req.Cursor)
+		}
+
+		switch r.URL.Path {
+		case "/server/devices":
+			page, ok := pages[req.Cursor]
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`"EXHAUSTED_CURSOR"`))
+				return
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(page))
+		case "/devices/sync":
+			require.NoError(t, json.NewEncoder(w).Encode(godep.DeviceResponse{Cursor: "c4"}))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	ds := new(mock.Store)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+	ds.SaveAppConfigFunc = func(ctx context.Context, info *fleet.AppConfig) error {
+		return nil
+	}
+
+	depStorage := &swappingDEPStorage{Storage: new(nanodep_mock.Storage)}
+	depStorage.RetrieveConfigFunc = func(ctx context.Context, name string) (*client.Config, error) {
+		return &client.Config{BaseURL: srv.URL}, nil
+	}
+	depStorage.RetrieveAuthTokensFunc = func(ctx context.Context, name string) (*client.OAuth1Tokens, error) {
+		return &client.OAuth1Tokens{}, nil
+	}
+
+	var processed []string
+	var onProcess func(serial string)
+	syncer := &depSyncer{
+		client: NewDEPClient(depStorage, ds, logger),
+		store:  depStorage,
+		logger: logger,
+		now:    time.Now,
+		callback: func(ctx context.Context, isFetch bool, resp *godep.DeviceResponse) error {
+			for _, d := range resp.Devices {
+				processed = append(processed, d.SerialNumber)
+				if onProcess != nil {
+					onProcess(d.SerialNumber)
+				}
+			}
+			return nil
+		},
+	}
+	reset := func() {
+		depStorage.cursor = ""
+		processed = nil
+		onRequest = nil
+		onProcess = nil
+	}
+
+	t.Run("page processed by another runner during the request", func(t *testing.T) {
+		reset()
+		onRequest = func(cursor string) {
+			if cursor == "" {
+				depStorage.cursor = "c1"
+			}
+		}
+		require.NoError(t, syncer.Run(ctx))
+		require.Empty(t, processed)
+		require.Equal(t, "c1", depStorage.cursor)
+	})
+
+	t.Run("cursor advanced by another runner during the processing", func(t *testing.T) {
+		reset()
+		onProcess = func(serial string) {
+			if serial == "s1" {
+				depStorage.cursor = "c2"
+			}
+		}
+		require.NoError(t, syncer.Run(ctx))
+		require.Equal(t, []string{"s1"}, processed)
+		require.Equal(t, "c2", depStorage.cursor)
+	})
+
+	t.Run("same cursor stored by another runner", func(t *testing.T) {
+		reset()
+		onProcess = func(serial string) {
+			if serial == "s1" {
+				depStorage.cursor = "c1"
+			}
+		}
+		require.NoError(t, syncer.Run(ctx))
+		require.Equal(t, []string{"s1", "s2", "s3"}, processed)
+		require.Equal(t, "c4", depStorage.cursor)
+	})
+
+	t.Run("concurrent runners", func(t *testing.T) {
+		reset()
+		var mu sync.Mutex
+		var count int
+		runners := make([]*depSyncer, 5)
+		for i := range runners {
+			r := *syncer
+			r.callback = func(ctx context.Context, isFetch bool, resp *godep.DeviceResponse) error {
+				mu.Lock()
+				count += len(resp.Devices)
+				mu.Unlock()
+				return nil
+			}
+			runners[i] = &r
+		}
+
+		var wg sync.WaitGroup
+		for _, r := range runners {
+			wg.Add(1)
+			go func(r *depSyncer) {
+				defer wg.Done()
+				require.NoError(t, r.Run(ctx))
+			}(r)
+		}
+		wg.Wait()
+
+		// the cursor never goes back, whatever the order of the runners
+		require.Equal(t, "c4", depStorage.cursor)
+		require.GreaterOrEqual(t, count, 3)
+	})
+}
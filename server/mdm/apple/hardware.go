@@ -0,0 +1,142 @@
+package apple_mdm
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+)
+
+// MacHardware is the hardware information of a Mac used to check its
+// enrollment eligibility. A zero ModelYear or an empty Architecture means
+// that it is unknown.
+type MacHardware struct {
+	ModelYear    int
+	Architecture string
+}
+
+// macModelFamily describes the model identifiers of a family of Macs, e.g.
+// "MacBookPro" for "MacBookPro16,1".
+type macModelFamily struct {
+	// years maps the major version of the model identifier to the year the
+	// models were first released.
+	years map[int]int
+	// firstARM64 is the first major version of Apple silicon models, 0 if
+	// the family only has Intel models.
+	firstARM64 int
+}
+
+// macModelFamilies is the release year and architecture of the Mac model
+// identifiers since the transition to Intel.
+var macModelFamilies = map[string]macModelFamily{
+	"MacBook": {
+		years: map[int]int{1: 2006, 2: 2006, 3: 2007, 4: 2008, 5: 2008, 6: 2009, 7: 2010, 8: 2015, 9: 2016, 10: 2017},
+	},
+	"MacBookAir": {
+		years:      map[int]int{1: 2008, 2: 2008, 3: 2010, 4: 2011, 5: 2012, 6: 2013, 7: 2015, 8: 2018, 9: 2020, 10: 2020},
+		firstARM64: 10,
+	},
+	"MacBookPro": {
+		years: map[int]int{
+			1: 2006, 2: 2006, 3: 2007, 4: 2008, 5: 2008, 6: 2010, 7: 2010, 8: 2011, 9: 2012,
+			10: 2012, 11: 2013, 12: 2015, 13: 2016, 14: 2017, 15: 2018, 16: 2019, 17: 2020, 18: 2021,
+		},
+		firstARM64: 17,
+	},
+	"Macmini": {
+		years:      map[int]int{1: 2006, 2: 2007, 3: 2009, 4: 2010, 5: 2011, 6: 2012, 7: 2014, 8: 2018, 9: 2020},
+		firstARM64: 9,
+	},
+	"iMac": {
+		years: map[int]int{
+			4: 2006, 5: 2006, 6: 2006, 7: 2007, 8: 2008, 9: 2009, 10: 2009, 11: 2010, 12: 2011,
+			13: 2012, 14: 2013, 15: 2014, 16: 2015, 17: 2015, 18: 2017, 19: 2019, 20: 2020, 21: 2021,
+		},
+		firstARM64: 21,
+	},
+	"iMacPro": {
+		years: map[int]int{1: 2017},
+	},
+	"MacPro": {
+		years: map[int]int{1: 2006, 2: 2007, 3: 2008, 4: 2009, 5: 2010, 6: 2013, 7: 2019},
+	},
+	// the "Mac" family is used for all Apple silicon models since 2022.
+	"Mac": {
+		years:      map[int]int{13: 2022, 14: 2023, 15: 2023, 16: 2024},
+		firstARM64: 13,
+	},
+}
+
+// macModelYearOverrides is the release year of the model identifiers that
+// were released later than the other models of their major version.
+var macModelYearOverrides = map[string]int{
+	"MacBookPro11,4": 2015,
+	"MacBookPro11,5": 2015,
+	"MacBookPro15,3": 2019,
+	"MacBookPro15,4": 2019,
+	"MacBookPro16,2": 2020,
+	"MacBookPro16,3": 2020,
+	"MacBookAir8,2":  2019,
+	"iMac14,4":       2014,
+	"Mac14,2":        2022,
+	"Mac14,7":        2022,
+	"Mac15,12":       2024,
+	"Mac15,13":       2024,
+}
+
+var macModelIdentifierRegexp = regexp.MustCompile(`^([A-Za-z]+)(\d+),(\d+)$`)
+
+// MacHardwareFromModelIdentifier returns the hardware information of the Mac
+// with the provided model identifier, e.g. "MacBookPro16,1", as reported in
+// the MDM Authenticate check-in message. It returns false if the model
+// identifier is unknown.
+func MacHardwareFromModelIdentifier(identifier string) (MacHardware, bool) {
+	matches := macModelIdentifierRegexp.FindStringSubmatch(strings.TrimSpace(identifier))
+	if matches == nil {
+		return MacHardware{}, false
+	}
+	family, ok := macModelFamilies[matches[1]]
+	if !ok {
+		return MacHardware{}, false
+	}
+	major, err := strconv.Atoi(matches[2])
+	if err != nil {
+		return MacHardware{}, false
+	}
+	year, ok := family.years[major]
+	if !ok {
+		return MacHardware{}, false
+	}
+	if override, ok := macModelYearOverrides[matches[0]]; ok {
+		year = override
+	}
+
+	arch := fleet.MacOSArchitectureX8664
+	if family.firstARM64 != 0 && major >= family.firstARM64 {
+		arch = fleet.MacOSArchitectureARM64
+	}
+	return MacHardware{ModelYear: year, Architecture: arch}, true
+}
+
+var (
+	depAppleSiliconRegexp = regexp.MustCompile(`\bM[1-9]\b`)
+	depIntelRegexp        = regexp.MustCompile(`\bINTEL\b|\bI[3579]\b|\d(\.\d+)?GHZ\b`)
+)
+
+// MacHardwareFromDEPDescription returns the hardware information that can be
+// inferred from the description of a device reported by Apple Business
+// Manager, e.g. "MBP 14.2 SPG/10C CPU/16C GPU/M1 PRO" or
+// "IMAC 27/3.6GHZ/8GB/2TB FD". The model year is never reported, and the
+// architecture is only known if the description mentions the chip.
+func MacHardwareFromDEPDescription(description string) MacHardware {
+	var hw MacHardware
+	desc := strings.ToUpper(description)
+	switch {
+	case depAppleSiliconRegexp.MatchString(desc):
+		hw.Architecture = fleet.MacOSArchitectureARM64
+	case depIntelRegexp.MatchString(desc):
+		hw.Architecture = fleet.MacOSArchitectureX8664
+	}
+	return hw
+}
@@ -0,0 +1,62 @@
+package apple_mdm
+
+import (
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMacHardwareFromModelIdentifier(t *testing.T) {
+	cases := []struct {
+		identifier string
+		want       MacHardware
+		ok         bool
+	}{
+		{"MacBookPro16,1", MacHardware{2019, fleet.MacOSArchitectureX8664}, true},
+		{"MacBookPro16,2", MacHardware{2020, fleet.MacOSArchitectureX8664}, true},
+		{"MacBookPro17,1", MacHardware{2020, fleet.MacOSArchitectureARM64}, true},
+		{"MacBookAir8,2", MacHardware{2019, fleet.MacOSArchitectureX8664}, true},
+		{"MacBookAir10,1", MacHardware{2020, fleet.MacOSArchitectureARM64}, true},
+		{"iMac14,4", MacHardware{2014, fleet.MacOSArchitectureX8664}, true},
+		{"iMacPro1,1", MacHardware{2017, fleet.MacOSArchitectureX8664}, true},
+		{"Macmini9,1", MacHardware{2020, fleet.MacOSArchitectureARM64}, true},
+		{"MacPro7,1", MacHardware{2019, fleet.MacOSArchitectureX8664}, true},
+		{"Mac14,2", MacHardware{2022, fleet.MacOSArchitectureARM64}, true},
+		{"Mac14,3", MacHardware{2023, fleet.MacOSArchitectureARM64}, true},
+		{" Mac15,13 ", MacHardware{2024, fleet.MacOSArchitectureARM64}, true},
+		{"MacBookPro99,1", MacHardware{}, false},
+		{"iPhone14,2", MacHardware{}, false},
+		{"MacBook Pro", MacHardware{}, false},
+		{"", MacHardware{}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.identifier, func(t *testing.T) {
+			got, ok := MacHardwareFromModelIdentifier(c.identifier)
+			require.Equal(t, c.ok, ok)
+			require.Equal(t, c.want, got)
+		})
+	}
+}
+
+func TestMacHardwareFromDEPDescription(t *testing.T) {
+	cases := []struct {
+		description string
+		want        string
+	}{
+		{"MBP 14.2 SPG/10C CPU/16C GPU/M1 PRO", fleet.MacOSArchitectureARM64},
+		{"MacBook Air 13 M2/8GB/256GB", fleet.MacOSArchitectureARM64},
+		{"IMAC 27/3.6GHZ/8GB/2TB FD", fleet.MacOSArchitectureX8664},
+		{"MBP 16.0 SG/2.6GHZ I7/16GB/512GB", fleet.MacOSArchitectureX8664},
+		{"MAC MINI INTEL CORE", fleet.MacOSArchitectureX8664},
+		{"MBP 13 SPG/MM10", ""},
+		{"", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			got := MacHardwareFromDEPDescription(c.description)
+			require.Zero(t, got.ModelYear)
+			require.Equal(t, c.want, got.Architecture)
+		})
+	}
+}
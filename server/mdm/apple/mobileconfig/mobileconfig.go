@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 
 	"go.mozilla.org/pkcs7"
 	"howett.net/plist"
@@ -18,6 +19,10 @@ const (
 	// FleetdConfigPayloadIdentifier is the value for the PayloadIdentifier used
 	// by fleetd to read configuration values from the system.
 	FleetdConfigPayloadIdentifier = "com.fleetdm.fleetd.config"
+
+	// FleetQuarantinePayloadIdentifier is the value for the PayloadIdentifier
+	// used by Fleet to restrict the network access of quarantined hosts.
+	FleetQuarantinePayloadIdentifier = "com.fleetdm.fleet.mdm.quarantine"
 )
 
 // FleetPayloadIdentifiers returns a map of PayloadIdentifier strings
@@ -28,8 +33,9 @@ const (
 // files around due to import cycles.
 func FleetPayloadIdentifiers() map[string]struct{} {
 	return map[string]struct{}{
-		FleetFileVaultPayloadIdentifier: {},
-		FleetdConfigPayloadIdentifier:   {},
+		FleetFileVaultPayloadIdentifier:  {},
+		FleetdConfigPayloadIdentifier:    {},
+		FleetQuarantinePayloadIdentifier: {},
 	}
 }
 
@@ -54,10 +60,58 @@ func FleetPayloadTypes() map[string]struct{} {
 // https://developer.apple.com/documentation/devicemanagement/configuring_multiple_devices_using_profiles.
 type Mobileconfig []byte
 
+// fipsMode is true if the Fleet server runs in FIPS mode, the signed profiles
+// must then use a FIPS-approved digest algorithm.
+var fipsMode atomic.Bool
+
+// SetFIPSMode enables or disables the FIPS mode checks of the signed
+// profiles.
+func SetFIPSMode(enabled bool) {
+	fipsMode.Store(enabled)
+}
+
+// verifySigned verifies the signature of a PKCS7 signed profile and returns
+// its content.
+func verifySigned(mcBytes []byte) ([]byte, error) {
+	p7, err := pkcs7.Parse(mcBytes)
+	if err != nil {
+		return nil, fmt.Errorf("mobileconfig is not XML nor PKCS7 parseable: %w", err)
+	}
+	if err := p7.Verify(); err != nil {
+		return nil, err
+	}
+	if fipsMode.Load() {
+		for _, signer := range p7.Signers {
+			alg := signer.DigestAlgorithm.Algorithm
+			if !alg.Equal(pkcs7.OIDDigestAlgorithmSHA256) && !alg.Equal(pkcs7.OIDDigestAlgorithmSHA384) && !alg.Equal(pkcs7.OIDDigestAlgorithmSHA512) {
+				return nil, fmt.Errorf("mobileconfig is signed with digest algorithm %s, only SHA-256, SHA-384 and SHA-512 are allowed in FIPS mode", alg)
+			}
+		}
+	}
+	return p7.Content, nil
+}
+
+// Canonical returns the canonical form of the profile: the property list is
+// re-encoded with sorted dictionary keys and a fixed indentation, so that
+// profiles that only differ by whitespace or key order (e.g. re-exported from
+// different tools) have the same canonical form. Signed profiles are returned
+// unchanged, as their content is covered by the signature.
+func (mc Mobileconfig) Canonical() ([]byte, error) {
+	if !bytes.HasPrefix(mc, []byte("<?xml")) {
+		return mc, nil
+	}
+	var v any
+	if _, err := plist.Unmarshal(mc, &v); err != nil {
+		return nil, err
+	}
+	return plist.MarshalIndent(v, plist.XMLFormat, "\t")
+}
+
 type Parsed struct {
 	PayloadIdentifier  string
 	PayloadDisplayName string
 	PayloadType        string
+	PayloadUUID        string
 }
 
 // ParseConfigProfile attempts to parse the Mobileconfig byte slice as a Fleet MDMAppleConfigProfile.
@@ -69,15 +123,11 @@ type Parsed struct {
 func (mc Mobileconfig) ParseConfigProfile() (*Parsed, error) {
 	mcBytes := mc
 	if !bytes.HasPrefix(mcBytes, []byte("<?xml")) {
-		p7, err := pkcs7.Parse(mcBytes)
-		if err != nil {
-			return nil, fmt.Errorf("mobileconfig is not XML nor PKCS7 parseable: %w", err)
-		}
-		err = p7.Verify()
+		content, err := verifySigned(mcBytes)
 		if err != nil {
 			return nil, err
 		}
-		mcBytes = Mobileconfig(p7.Content)
+		mcBytes = Mobileconfig(content)
 	}
 	var p Parsed
 	if _, err := plist.Unmarshal(mcBytes, &p); err != nil {
@@ -108,15 +158,11 @@ type payloadSummary struct {
 func (mc Mobileconfig) payloadSummary() ([]payloadSummary, error) {
 	mcBytes := mc
 	if !bytes.HasPrefix(mcBytes, []byte("<?xml")) {
-		p7, err := pkcs7.Parse(mcBytes)
-		if err != nil {
-			return nil, fmt.Errorf("mobileconfig is not XML nor PKCS7 parseable: %w", err)
-		}
-		err = p7.Verify()
+		content, err := verifySigned(mcBytes)
 		if err != nil {
 			return nil, err
 		}
-		mcBytes = Mobileconfig(p7.Content)
+		mcBytes = Mobileconfig(content)
 	}
 
 	// unmarshal the values we need from the top-level object
@@ -171,36 +217,60 @@ func (mc Mobileconfig) payloadSummary() ([]payloadSummary, error) {
 }
 
 func (mc *Mobileconfig) ScreenPayloads() error {
+	screenedTypes, screenedIdentifiers, err := mc.screenPayloads()
+	if err != nil {
+		return err
+	}
+
+	if len(screenedTypes) > 0 {
+		return fmt.Errorf("unsupported PayloadType(s): %s", strings.Join(screenedTypes, ", "))
+	}
+
+	if len(screenedIdentifiers) > 0 {
+		return fmt.Errorf("unsupported PayloadIdentifier(s): %s", strings.Join(screenedIdentifiers, ", "))
+	}
+
+	return nil
+}
+
+// ScreenPayloadsAllowReservedTypes screens the payloads like ScreenPayloads,
+// except that payloads with a PayloadType reserved by Fleet are not rejected.
+// Those PayloadTypes are returned instead so that the caller can record them.
+func (mc *Mobileconfig) ScreenPayloadsAllowReservedTypes() ([]string, error) {
+	screenedTypes, screenedIdentifiers, err := mc.screenPayloads()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(screenedIdentifiers) > 0 {
+		return nil, fmt.Errorf("unsupported PayloadIdentifier(s): %s", strings.Join(screenedIdentifiers, ", "))
+	}
+
+	return screenedTypes, nil
+}
+
+// screenPayloads returns the PayloadTypes and PayloadIdentifiers of the
+// payloads that are reserved by Fleet.
+func (mc *Mobileconfig) screenPayloads() (types []string, identifiers []string, err error) {
 	pct, err := mc.payloadSummary()
 	if err != nil {
 		// don't error if there's nothing for us to screen.
 		if !errors.Is(err, ErrEmptyPayloadContent) && !errors.Is(err, ErrEncryptedPayloadContent) {
-			return err
+			return nil, nil, err
 		}
 	}
 
 	fleetIdentifiers := FleetPayloadIdentifiers()
 	fleetTypes := FleetPayloadTypes()
-	screenedTypes := []string{}
-	screenedIdentifiers := []string{}
 	for _, t := range pct {
 		if _, ok := fleetTypes[t.Type]; ok {
-			screenedTypes = append(screenedTypes, t.Type)
+			types = append(types, t.Type)
 		}
 		if _, ok := fleetIdentifiers[t.Identifier]; ok {
-			screenedIdentifiers = append(screenedIdentifiers, t.Identifier)
+			identifiers = append(identifiers, t.Identifier)
 		}
 	}
-
-	if len(screenedTypes) > 0 {
-		return fmt.Errorf("unsupported PayloadType(s): %s", strings.Join(screenedTypes, ", "))
-	}
-
-	if len(screenedIdentifiers) > 0 {
-		return fmt.Errorf("unsupported PayloadIdentifier(s): %s", strings.Join(screenedIdentifiers, ", "))
-	}
-
-	return nil
+	return types, identifiers, nil
 }
 
 type ErrInvalidPayloadType struct {
@@ -0,0 +1,139 @@
+package apple_mdm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/groob/plist"
+)
+
+// Names of the variables supported in the command templates of the policy MDM
+// actions.
+const (
+	PolicyCommandVarHostUUID         = "HOST_UUID"
+	PolicyCommandVarHostSerialNumber = "HOST_SERIAL_NUMBER"
+	PolicyCommandVarHostDisplayName  = "HOST_DISPLAY_NAME"
+	PolicyCommandVarPolicyName       = "POLICY_NAME"
+)
+
+var supportedPolicyCommandVars = map[string]bool{
+	PolicyCommandVarHostUUID:         true,
+	PolicyCommandVarHostSerialNumber: true,
+	PolicyCommandVarHostDisplayName:  true,
+	PolicyCommandVarPolicyName:       true,
+}
+
+// PolicyCommandVariables holds the values of the variables of a policy command
+// template for a host, keyed by variable name (without the FLEET_VAR_ prefix).
+type PolicyCommandVariables map[string]string
+
+// SetHostCustomAttributes sets the values of the host custom attribute
+// variables from attrs, keyed by attribute name.
+func (v PolicyCommandVariables) SetHostCustomAttributes(attrs map[string]string) {
+	for name, value := range attrs {
+		v[HostCustomAttributeVarPrefix+strings.ToUpper(name)] = value
+	}
+}
+
+// ValidatePolicyCommandTemplate returns an error if the command template is
+// not a valid MDM command or if it references a variable that is not
+// supported.
+func ValidatePolicyCommandTemplate(template string) error {
+	cmd, err := decodePolicyCommandTemplate(template)
+	if err != nil {
+		return err
+	}
+
+	var unknown []string
+	seen := make(map[string]bool)
+	mapPlistStrings(cmd, func(s string) string {
+		for _, m := range fleetVarRegexp.FindAllStringSubmatch(s, -1) {
+			name := m[1] + m[2]
+			if _, ok := hostCustomAttributeVarName(name); ok {
+				continue
+			}
+			if !supportedPolicyCommandVars[name] && !seen[name] {
+				seen[name] = true
+				unknown = append(unknown, "FLEET_VAR_"+name)
+			}
+		}
+		return s
+	})
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unsupported variable(s): %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// ExpandPolicyCommandTemplate returns the raw MDM command built from the
+// template, with the variables referenced in its string values replaced by
+// their values and its CommandUUID set to commandUUID. The host custom
+// attribute variables that have no value are replaced by an empty string.
+func ExpandPolicyCommandTemplate(template string, vars PolicyCommandVariables, commandUUID string) (string, error) {
+	cmd, err := decodePolicyCommandTemplate(template)
+	if err != nil {
+		return "", err
+	}
+
+	mapPlistStrings(cmd, func(s string) string {
+		return fleetVarRegexp.ReplaceAllStringFunc(s, func(v string) string {
+			m := fleetVarRegexp.FindStringSubmatch(v)
+			if val, ok := vars[m[1]+m[2]]; ok {
+				return val
+			}
+			if _, ok := hostCustomAttributeVarName(m[1] + m[2]); ok {
+				return ""
+			}
+			return v
+		})
+	})
+	cmd["CommandUUID"] = commandUUID
+
+	b, err := plist.MarshalIndent(cmd, "\t")
+	if err != nil {
+		return "", fmt.Errorf("marshal command: %w", err)
+	}
+	return string(b), nil
+}
+
+// decodePolicyCommandTemplate decodes the command template and checks that it
+// has the structure of an MDM command.
+func decodePolicyCommandTemplate(template string) (map[string]any, error) {
+	var cmd map[string]any
+	if err := plist.Unmarshal([]byte(template), &cmd); err != nil {
+		return nil, fmt.Errorf("decode command: %w", err)
+	}
+	payload, ok := cmd["Command"].(map[string]any)
+	if !ok {
+		return nil, errors.New("command must have a Command dictionary")
+	}
+	if rt, _ := payload["RequestType"].(string); rt == "" {
+		return nil, errors.New("command must have a RequestType")
+	}
+	return cmd, nil
+}
+
+// mapPlistStrings replaces in place all the string values of the decoded plist
+// by the result of fn.
+func mapPlistStrings(v map[string]any, fn func(string) string) {
+	var walk func(v any) any
+	walk = func(v any) any {
+		switch v := v.(type) {
+		case string:
+			return fn(v)
+		case []any:
+			for i, e := range v {
+				v[i] = walk(e)
+			}
+		case map[string]any:
+			for k, e := range v {
+				v[k] = walk(e)
+			}
+		}
+		return v
+	}
+	walk(v)
+}
@@ -0,0 +1,57 @@
+package apple_mdm
+
+import (
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyCommandTemplate(t *testing.T) {
+	template := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>DeviceLock</string>
+		<key>Message</key>
+		<string>$FLEET_VAR_HOST_DISPLAY_NAME fails ${FLEET_VAR_POLICY_NAME} ($FLEET_VAR_HOST_SERIAL_NUMBER)</string>
+		<key>PhoneNumber</key>
+		<string>$FLEET_VAR_NOT_EXPANDED</string>
+	</dict>
+</dict>
+</plist>`
+
+	err := ValidatePolicyCommandTemplate(template)
+	require.Error(t, err)
+	require.Equal(t, "unsupported variable(s): FLEET_VAR_NOT_EXPANDED", err.Error())
+
+	raw, err := ExpandPolicyCommandTemplate(template, PolicyCommandVariables{
+		PolicyCommandVarHostUUID:         "host-uuid",
+		PolicyCommandVarHostSerialNumber: "ABC123",
+		PolicyCommandVarHostDisplayName:  "Jane's <Mac>",
+		PolicyCommandVarPolicyName:       "FileVault & firewall",
+	}, "cmd-uuid")
+	require.NoError(t, err)
+
+	cmd, err := mdm.DecodeCommand([]byte(raw))
+	require.NoError(t, err)
+	require.Equal(t, "cmd-uuid", cmd.CommandUUID)
+	require.Equal(t, "DeviceLock", cmd.Command.RequestType)
+	require.Contains(t, raw, "Jane&#39;s &lt;Mac&gt; fails FileVault &amp; firewall (ABC123)")
+	require.Contains(t, raw, "$FLEET_VAR_NOT_EXPANDED")
+
+	for _, c := range []struct {
+		template string
+		wantErr  string
+	}{
+		{"not a plist", "decode command"},
+		{`<plist version="1.0"><dict><key>CommandUUID</key><string>a</string></dict></plist>`, "command must have a Command dictionary"},
+		{`<plist version="1.0"><dict><key>Command</key><dict><key>Foo</key><string>a</string></dict></dict></plist>`, "command must have a RequestType"},
+	} {
+		err := ValidatePolicyCommandTemplate(c.template)
+		require.ErrorContains(t, err, c.wantErr)
+	}
+}
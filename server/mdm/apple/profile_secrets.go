@@ -0,0 +1,43 @@
+package apple_mdm
+
+import (
+	"bytes"
+	"encoding/xml"
+	"regexp"
+)
+
+// fleetSecretRegexp matches the variables that reference secrets stored in
+// the external secrets manager, e.g. $FLEET_SECRET_WIFI_PSK or
+// ${FLEET_SECRET_WIFI_PSK} for the "WIFI_PSK" secret.
+var fleetSecretRegexp = regexp.MustCompile(`\$(?:FLEET_SECRET_([A-Z0-9_]+)|\{FLEET_SECRET_([A-Z0-9_]+)\})`)
+
+// ProfileSecretNames returns the deduplicated names of the secrets referenced
+// by the configuration profile, in order of first reference.
+func ProfileSecretNames(profile []byte) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range fleetSecretRegexp.FindAllSubmatch(profile, -1) {
+		name := string(m[1]) + string(m[2])
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ExpandProfileSecrets returns the configuration profile with the secret
+// variables replaced by the XML-escaped values of the secrets, keyed by
+// secret name. The variables of secrets missing from secrets are left as-is.
+func ExpandProfileSecrets(profile []byte, secrets map[string]string) []byte {
+	return fleetSecretRegexp.ReplaceAllFunc(profile, func(v []byte) []byte {
+		m := fleetSecretRegexp.FindSubmatch(v)
+		value, ok := secrets[string(m[1])+string(m[2])]
+		if !ok {
+			return v
+		}
+		var buf bytes.Buffer
+		_ = xml.EscapeText(&buf, []byte(value))
+		return buf.Bytes()
+	})
+}
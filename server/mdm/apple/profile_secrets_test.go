@@ -0,0 +1,31 @@
+package apple_mdm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileSecrets(t *testing.T) {
+	profile := []byte(`<plist version="1.0">
+<dict>
+	<key>Password</key>
+	<string>$FLEET_SECRET_WIFI_PSK</string>
+	<key>Other</key>
+	<string>${FLEET_SECRET_CERT_PASSWORD}-${FLEET_SECRET_WIFI_PSK}-$FLEET_SECRET_MISSING</string>
+	<key>NotASecret</key>
+	<string>$FLEET_VAR_HOST_UUID $FLEET_SECRET_lower</string>
+</dict>
+</plist>`)
+
+	require.Equal(t, []string{"WIFI_PSK", "CERT_PASSWORD", "MISSING"}, ProfileSecretNames(profile))
+	require.Empty(t, ProfileSecretNames([]byte(`<string>no secrets</string>`)))
+
+	got := ExpandProfileSecrets(profile, map[string]string{
+		"WIFI_PSK":      "p<a>ss&word",
+		"CERT_PASSWORD": "cert",
+	})
+	require.Contains(t, string(got), "<string>p&lt;a&gt;ss&amp;word</string>")
+	require.Contains(t, string(got), "<string>cert-p&lt;a&gt;ss&amp;word-$FLEET_SECRET_MISSING</string>")
+	require.Contains(t, string(got), "<string>$FLEET_VAR_HOST_UUID $FLEET_SECRET_lower</string>")
+}
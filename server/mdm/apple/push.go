@@ -0,0 +1,285 @@
+package apple_mdm
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	buford_push "github.com/RobotsAndPencils/buford/push"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	nanomdm_log "github.com/micromdm/nanomdm/log"
+	"github.com/micromdm/nanomdm/mdm"
+	nanomdm_push "github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/push/buford"
+	nanomdm_pushsvc "github.com/micromdm/nanomdm/push/service"
+	nanomdm_storage "github.com/micromdm/nanomdm/storage"
+	"golang.org/x/net/http2"
+)
+
+// APNsHost is the host of the production Apple Push Notification service.
+const APNsHost = "api.push.apple.com:443"
+
+// apnsProxyCheckTimeout is the maximum time the proxy health check waits for
+// the proxy to open a tunnel to APNs.
+const apnsProxyCheckTimeout = 10 * time.Second
+
+// ErrPushTokenInvalid is the error of the pushes that are not sent because
+// APNs reported that the push token of the enrollment is not valid anymore.
+var ErrPushTokenInvalid = errors.New("push token reported as invalid by APNs, waiting for a new token from the device")
+
+// PushResultStore records the results of the push notifications, so that no
+// pushes are sent to the tokens that APNs reported as invalid.
+type PushResultStore interface {
+	RecordMDMApplePushResults(ctx context.Context, results []fleet.MDMApplePushResult) error
+	ListMDMAppleInvalidPushTokenEnrollmentIDs(ctx context.Context, enrollmentIDs []string) ([]string, error)
+}
+
+// PushService sends MDM push notifications to APNs, optionally through an HTTP
+// proxy.
+type PushService struct {
+	results PushResultStore
+	pusher  nanomdm_push.Pusher
+}
+
+var _ nanomdm_push.Pusher = (*PushService)(nil)
+
+// NewPushService creates a new PushService. If proxyURL is nil, the push
+// notifications are sent directly to APNs. If results is not nil, the results
+// of the pushes are recorded in it.
+func NewPushService(
+	store nanomdm_storage.PushStore,
+	certStore nanomdm_storage.PushCertStore,
+	results PushResultStore,
+	proxyURL *url.URL,
+	logger nanomdm_log.Logger,
+) *PushService {
+	return NewPushServiceWithFactory(store, certStore, results, NewPushProviderFactory(proxyURL), logger)
+}
+
+// NewPushServiceWithFactory creates a new PushService that sends the push
+// notifications with the providers created by factory, e.g. a
+// LogPushProviderFactory or a WebhookPushProviderFactory. If results is not
+// nil, the results of the pushes are recorded in it.
+func NewPushServiceWithFactory(
+	store nanomdm_storage.PushStore,
+	certStore nanomdm_storage.PushCertStore,
+	results PushResultStore,
+	factory nanomdm_push.PushProviderFactory,
+	logger nanomdm_log.Logger,
+) *PushService {
+	return &PushService{
+		results: results,
+		pusher:  nanomdm_pushsvc.New(store, certStore, newRecordingPushProviderFactory(factory, results, logger), logger),
+	}
+}
+
+// Push sends a push notification to each of the provided enrollment ids. No
+// push is sent to the enrollments whose push token was reported as invalid by
+// APNs, their response has the ErrPushTokenInvalid error instead.
+func (s *PushService) Push(ctx context.Context, ids []string) (map[string]*nanomdm_push.Response, error) {
+	if s.results == nil {
+		return s.pusher.Push(ctx, ids)
+	}
+
+	invalidIDs, err := s.results.ListMDMAppleInvalidPushTokenEnrollmentIDs(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("listing invalid push tokens: %w", err)
+	}
+	if len(invalidIDs) == 0 {
+		return s.pusher.Push(ctx, ids)
+	}
+
+	invalid := make(map[string]bool, len(invalidIDs))
+	for _, id := range invalidIDs {
+		invalid[id] = true
+	}
+	validIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !invalid[id] {
+			validIDs = append(validIDs, id)
+		}
+	}
+
+	res := make(map[string]*nanomdm_push.Response, len(ids))
+	if len(validIDs) > 0 {
+		pushed, err := s.pusher.Push(ctx, validIDs)
+		if err != nil {
+			return nil, err
+		}
+		for id, resp := range pushed {
+			res[id] = resp
+		}
+	}
+	for id := range invalid {
+		res[id] = &nanomdm_push.Response{Err: ErrPushTokenInvalid}
+	}
+	return res, nil
+}
+
+// recordingPushProviderFactory creates push providers that record the results
+// of the pushes sent by the providers created by factory.
+type recordingPushProviderFactory struct {
+	factory nanomdm_push.PushProviderFactory
+	results PushResultStore
+	logger  nanomdm_log.Logger
+}
+
+// newRecordingPushProviderFactory wraps factory so that the results of the
+// pushes are recorded in results. It returns factory as-is if results is nil.
+func newRecordingPushProviderFactory(factory nanomdm_push.PushProviderFactory, results PushResultStore, logger nanomdm_log.Logger) nanomdm_push.PushProviderFactory {
+	if results == nil {
+		return factory
+	}
+	return recordingPushProviderFactory{factory: factory, results: results, logger: logger}
+}
+
+func (f recordingPushProviderFactory) NewPushProvider(cert *tls.Certificate) (nanomdm_push.PushProvider, error) {
+	prov, err := f.factory.NewPushProvider(cert)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingPushProvider{provider: prov, results: f.results, logger: f.logger}, nil
+}
+
+type recordingPushProvider struct {
+	provider nanomdm_push.PushProvider
+	results  PushResultStore
+	logger   nanomdm_log.Logger
+}
+
+func (p *recordingPushProvider) Push(pushes []*mdm.Push) (map[string]*nanomdm_push.Response, error) {
+	res, err := p.provider.Push(pushes)
+	if err != nil {
+		// the pushes were not sent (e.g. APNs is unreachable), this says
+		// nothing about the push tokens.
+		return res, err
+	}
+
+	results := make([]fleet.MDMApplePushResult, 0, len(res))
+	for token, resp := range res {
+		result := fleet.MDMApplePushResult{TokenHex: token}
+		if resp.Err != nil {
+			result.Err = resp.Err.Error()
+			result.TokenInvalid = isInvalidPushTokenError(resp.Err)
+		}
+		results = append(results, result)
+	}
+	// the providers are not passed a context, and failing to record the
+	// results must not fail the pushes.
+	if err := p.results.RecordMDMApplePushResults(context.Background(), results); err != nil {
+		p.logger.Info("msg", "record push results", "err", err)
+	}
+	return res, nil
+}
+
+// isInvalidPushTokenError returns true if the error returned by APNs means
+// that the push token is not valid anymore, e.g. because the device was wiped
+// or the MDM profile removed.
+func isInvalidPushTokenError(err error) bool {
+	var apnsErr *buford_push.Error
+	if !errors.As(err, &apnsErr) {
+		return false
+	}
+	switch apnsErr.Reason {
+	case buford_push.ErrUnregistered, buford_push.ErrBadDeviceToken, buford_push.ErrDeviceTokenNotForTopic:
+		return true
+	}
+	return apnsErr.Status == http.StatusGone
+}
+
+// NewPushProviderFactory returns a push provider factory whose providers
+// connect to APNs through proxyURL. If proxyURL is nil, they connect directly.
+func NewPushProviderFactory(proxyURL *url.URL) nanomdm_push.PushProviderFactory {
+	if proxyURL == nil {
+		return buford.NewPushProviderFactory()
+	}
+	return buford.NewPushProviderFactory(buford.WithNewClient(func(cert *tls.Certificate) (*http.Client, error) {
+		transport := &http.Transport{
+			TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{*cert}},
+			Proxy:           http.ProxyURL(proxyURL),
+		}
+		if err := http2.ConfigureTransport(transport); err != nil {
+			return nil, fmt.Errorf("configuring http2 transport: %w", err)
+		}
+		return &http.Client{Transport: transport}, nil
+	}))
+}
+
+// APNsProxyChecker checks that the HTTP proxy used to reach APNs is able to
+// open a tunnel to it. It implements health.Checker.
+type APNsProxyChecker struct {
+	ProxyURL *url.URL
+	// Target is the host:port the proxy is asked to connect to, defaults to
+	// APNsHost.
+	Target string
+}
+
+// HealthCheck sends a CONNECT request to the proxy and returns an error if it
+// fails or if the proxy doesn't respond with a 200 status.
+func (c APNsProxyChecker) HealthCheck() error {
+	ctx, cancel := context.WithTimeout(context.Background(), apnsProxyCheckTimeout)
+	defer cancel()
+
+	target := c.Target
+	if target == "" {
+		target = APNsHost
+	}
+
+	proxyHost := c.ProxyURL.Host
+	if c.ProxyURL.Port() == "" {
+		port := "80"
+		if c.ProxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyHost = net.JoinHostPort(c.ProxyURL.Hostname(), port)
+	}
+
+	var conn net.Conn
+	var err error
+	if c.ProxyURL.Scheme == "https" {
+		conn, err = (&tls.Dialer{}).DialContext(ctx, "tcp", proxyHost)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", proxyHost)
+	}
+	if err != nil {
+		return fmt.Errorf("connecting to APNs proxy: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return fmt.Errorf("setting APNs proxy connection deadline: %w", err)
+		}
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if u := c.ProxyURL.User; u != nil {
+		password, _ := u.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("sending CONNECT request to APNs proxy: %w", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return fmt.Errorf("reading APNs proxy response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("APNs proxy responded with status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package apple_mdm
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/fleetdm/fleet/v4/pkg/fleethttp"
+	"github.com/google/uuid"
+	nanomdm_log "github.com/micromdm/nanomdm/log"
+	"github.com/micromdm/nanomdm/mdm"
+	nanomdm_push "github.com/micromdm/nanomdm/push"
+)
+
+// LogPushProviderFactory creates push providers that only log the push
+// notifications instead of sending them to APNs, for test or air-gapped
+// environments.
+type LogPushProviderFactory struct {
+	Logger nanomdm_log.Logger
+}
+
+// NewPushProvider returns a push provider that logs the push notifications.
+func (f LogPushProviderFactory) NewPushProvider(*tls.Certificate) (nanomdm_push.PushProvider, error) {
+	return &logPushProvider{logger: f.Logger}, nil
+}
+
+type logPushProvider struct {
+	logger nanomdm_log.Logger
+}
+
+func (p *logPushProvider) Push(pushes []*mdm.Push) (map[string]*nanomdm_push.Response, error) {
+	res := make(map[string]*nanomdm_push.Response, len(pushes))
+	for _, push := range pushes {
+		id := uuid.New().String()
+		p.logger.Info("msg", "push notification not sent, log-only push provider", "id", id, "topic", push.Topic, "token", push.Token.String())
+		res[push.Token.String()] = &nanomdm_push.Response{Id: id}
+	}
+	return res, nil
+}
+
+// webhookPush is a push notification as sent to the webhook.
+type webhookPush struct {
+	ID        string `json:"id"`
+	Topic     string `json:"topic"`
+	Token     string `json:"token"`
+	PushMagic string `json:"push_magic"`
+}
+
+// WebhookPushProviderFactory creates push providers that POST the push
+// notifications to a webhook (e.g. an internal relay) instead of sending them
+// to APNs, for test or air-gapped environments.
+type WebhookPushProviderFactory struct {
+	URL *url.URL
+	// Client is the HTTP client used to call the webhook, defaults to a
+	// fleethttp client.
+	Client *http.Client
+}
+
+// NewPushProvider returns a push provider that sends the push notifications
+// to the webhook.
+func (f WebhookPushProviderFactory) NewPushProvider(*tls.Certificate) (nanomdm_push.PushProvider, error) {
+	client := f.Client
+	if client == nil {
+		client = fleethttp.NewClient()
+	}
+	return &webhookPushProvider{url: f.URL.String(), client: client}, nil
+}
+
+type webhookPushProvider struct {
+	url    string
+	client *http.Client
+}
+
+// Push sends the push notifications to the webhook in a single request, as a
+// JSON object with a "pushes" array. Any 2xx response status means that all
+// of them were accepted.
+func (p *webhookPushProvider) Push(pushes []*mdm.Push) (map[string]*nanomdm_push.Response, error) {
+	payload := struct {
+		Pushes []webhookPush `json:"pushes"`
+	}{Pushes: make([]webhookPush, 0, len(pushes))}
+	res := make(map[string]*nanomdm_push.Response, len(pushes))
+	for _, push := range pushes {
+		id := uuid.New().String()
+		payload.Pushes = append(payload.Pushes, webhookPush{
+			ID:        id,
+			Topic:     push.Topic,
+			Token:     push.Token.String(),
+			PushMagic: push.PushMagic,
+		})
+		res[push.Token.String()] = &nanomdm_push.Response{Id: id}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal push webhook payload: %w", err)
+	}
+	resp, err := p.client.Post(p.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("send push webhook request: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("push webhook responded with status %s", resp.Status)
+	}
+	return res, nil
+}
@@ -0,0 +1,83 @@
+package apple_mdm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	nanomdm_log "github.com/micromdm/nanomdm/log"
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/stretchr/testify/require"
+)
+
+type recordLogger struct {
+	infos [][]interface{}
+}
+
+func (l *recordLogger) Info(kv ...interface{})                 { l.infos = append(l.infos, kv) }
+func (l *recordLogger) Debug(...interface{})                   {}
+func (l *recordLogger) With(...interface{}) nanomdm_log.Logger { return l }
+
+func newTestPush(t *testing.T, token string) *mdm.Push {
+	p := &mdm.Push{PushMagic: "magic-" + token, Topic: "com.apple.mgmt.test"}
+	require.NoError(t, p.SetTokenString(token))
+	return p
+}
+
+func TestLogPushProvider(t *testing.T) {
+	logger := &recordLogger{}
+	prov, err := LogPushProviderFactory{Logger: logger}.NewPushProvider(nil)
+	require.NoError(t, err)
+
+	res, err := prov.Push([]*mdm.Push{newTestPush(t, "aa01"), newTestPush(t, "bb02")})
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	for _, tok := range []string{"aa01", "bb02"} {
+		require.Contains(t, res, tok)
+		require.NotEmpty(t, res[tok].Id)
+		require.NoError(t, res[tok].Err)
+	}
+	require.Len(t, logger.infos, 2)
+}
+
+func TestWebhookPushProvider(t *testing.T) {
+	var got struct {
+		Pushes []webhookPush `json:"pushes"`
+	}
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	prov, err := WebhookPushProviderFactory{URL: u}.NewPushProvider(nil)
+	require.NoError(t, err)
+
+	res, err := prov.Push([]*mdm.Push{newTestPush(t, "aa01"), newTestPush(t, "bb02")})
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	require.Len(t, got.Pushes, 2)
+	for _, p := range got.Pushes {
+		require.Contains(t, res, p.Token)
+		require.Equal(t, res[p.Token].Id, p.ID)
+		require.Equal(t, "com.apple.mgmt.test", p.Topic)
+		require.Equal(t, "magic-"+p.Token, p.PushMagic)
+	}
+
+	// the relay fails
+	status = http.StatusBadGateway
+	_, err = prov.Push([]*mdm.Push{newTestPush(t, "aa01")})
+	require.ErrorContains(t, err, "502 Bad Gateway")
+
+	// the relay is not reachable
+	srv.Close()
+	_, err = prov.Push([]*mdm.Push{newTestPush(t, "aa01")})
+	require.ErrorContains(t, err, "send push webhook request")
+}
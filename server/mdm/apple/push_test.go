@@ -0,0 +1,147 @@
+package apple_mdm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+
+	buford_push "github.com/RobotsAndPencils/buford/push"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	nanomdm_log "github.com/micromdm/nanomdm/log"
+	"github.com/micromdm/nanomdm/mdm"
+	nanomdm_push "github.com/micromdm/nanomdm/push"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPNsProxyChecker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Host != APNsHost {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	proxyURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	// missing credentials
+	err = APNsProxyChecker{ProxyURL: proxyURL}.HealthCheck()
+	require.ErrorContains(t, err, "407 Proxy Authentication Required")
+
+	// valid credentials
+	proxyURL.User = url.UserPassword("user", "pass")
+	require.NoError(t, APNsProxyChecker{ProxyURL: proxyURL}.HealthCheck())
+
+	// target not allowed by the proxy
+	err = APNsProxyChecker{ProxyURL: proxyURL, Target: "example.com:443"}.HealthCheck()
+	require.ErrorContains(t, err, "403 Forbidden")
+
+	// proxy not reachable
+	srv.Close()
+	err = APNsProxyChecker{ProxyURL: proxyURL}.HealthCheck()
+	require.ErrorContains(t, err, "connecting to APNs proxy")
+}
+
+type fakePushResultStore struct {
+	invalidIDs []string
+	recorded   []fleet.MDMApplePushResult
+}
+
+func (s *fakePushResultStore) RecordMDMApplePushResults(ctx context.Context, results []fleet.MDMApplePushResult) error {
+	s.recorded = append(s.recorded, results...)
+	return nil
+}
+
+func (s *fakePushResultStore) ListMDMAppleInvalidPushTokenEnrollmentIDs(ctx context.Context, ids []string) ([]string, error) {
+	return s.invalidIDs, nil
+}
+
+type fakePusher struct {
+	pushed []string
+}
+
+func (p *fakePusher) Push(ctx context.Context, ids []string) (map[string]*nanomdm_push.Response, error) {
+	p.pushed = append(p.pushed, ids...)
+	res := make(map[string]*nanomdm_push.Response, len(ids))
+	for _, id := range ids {
+		res[id] = &nanomdm_push.Response{Id: "push-" + id}
+	}
+	return res, nil
+}
+
+func TestPushServiceSkipsInvalidTokens(t *testing.T) {
+	store := &fakePushResultStore{invalidIDs: []string{"b"}}
+	pusher := &fakePusher{}
+	svc := NewPushService(nil, nil, store, nil, nanomdm_log.NopLogger)
+	svc.pusher = pusher
+
+	res, err := svc.Push(context.Background(), []string{"a", "b", "c"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "c"}, pusher.pushed)
+	require.Len(t, res, 3)
+	require.NoError(t, res["a"].Err)
+	require.ErrorIs(t, res["b"].Err, ErrPushTokenInvalid)
+	require.NoError(t, res["c"].Err)
+
+	// no push is sent if all the tokens are invalid
+	pusher.pushed = nil
+	res, err = svc.Push(context.Background(), []string{"b"})
+	require.NoError(t, err)
+	require.Empty(t, pusher.pushed)
+	require.ErrorIs(t, res["b"].Err, ErrPushTokenInvalid)
+}
+
+type fakeResponsesProvider map[string]error
+
+func (p fakeResponsesProvider) Push(pushes []*mdm.Push) (map[string]*nanomdm_push.Response, error) {
+	res := make(map[string]*nanomdm_push.Response, len(pushes))
+	for _, push := range pushes {
+		tok := push.Token.String()
+		res[tok] = &nanomdm_push.Response{Id: "id-" + tok, Err: p[tok]}
+	}
+	return res, nil
+}
+
+func TestRecordingPushProvider(t *testing.T) {
+	store := &fakePushResultStore{}
+	prov := &recordingPushProvider{
+		provider: fakeResponsesProvider{
+			"bb02": &buford_push.Error{Reason: buford_push.ErrUnregistered, Status: http.StatusGone},
+			"cc03": &buford_push.Error{Reason: buford_push.ErrTooManyRequests, Status: http.StatusTooManyRequests},
+			"dd04": errors.New("connection reset"),
+		},
+		results: store,
+		logger:  nanomdm_log.NopLogger,
+	}
+
+	res, err := prov.Push([]*mdm.Push{
+		newTestPush(t, "aa01"), newTestPush(t, "bb02"), newTestPush(t, "cc03"), newTestPush(t, "dd04"),
+	})
+	require.NoError(t, err)
+	require.Len(t, res, 4)
+
+	sort.Slice(store.recorded, func(i, j int) bool { return store.recorded[i].TokenHex < store.recorded[j].TokenHex })
+	require.Len(t, store.recorded, 4)
+	require.Equal(t, fleet.MDMApplePushResult{TokenHex: "aa01"}, store.recorded[0])
+	require.Equal(t, "bb02", store.recorded[1].TokenHex)
+	require.NotEmpty(t, store.recorded[1].Err)
+	require.True(t, store.recorded[1].TokenInvalid)
+	require.Equal(t, "cc03", store.recorded[2].TokenHex)
+	require.NotEmpty(t, store.recorded[2].Err)
+	require.False(t, store.recorded[2].TokenInvalid)
+	require.Equal(t, fleet.MDMApplePushResult{TokenHex: "dd04", Err: "connection reset"}, store.recorded[3])
+}
@@ -0,0 +1,121 @@
+package apple_mdm
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+)
+
+// fleetVarRegexp matches the variables that can be referenced in the
+// string values of a setup assistant or of a policy command template, either
+// as $FLEET_VAR_NAME or as ${FLEET_VAR_NAME}.
+var fleetVarRegexp = regexp.MustCompile(`\$(?:FLEET_VAR_([A-Z0-9_]+)|\{FLEET_VAR_([A-Z0-9_]+)\})`)
+
+// Names of the variables supported in the setup assistants.
+const (
+	SetupAssistantVarOrgName    = "ORG_NAME"
+	SetupAssistantVarOrgLogoURL = "ORG_LOGO_URL"
+	SetupAssistantVarServerURL  = "SERVER_URL"
+	SetupAssistantVarTeamName   = "TEAM_NAME"
+)
+
+var supportedSetupAssistantVars = map[string]bool{
+	SetupAssistantVarOrgName:    true,
+	SetupAssistantVarOrgLogoURL: true,
+	SetupAssistantVarServerURL:  true,
+	SetupAssistantVarTeamName:   true,
+}
+
+// SetupAssistantVariables holds the values of the variables of a setup
+// assistant, keyed by variable name (without the FLEET_VAR_ prefix).
+type SetupAssistantVariables map[string]string
+
+// NewSetupAssistantVariables returns the values of the variables of a setup
+// assistant for the provided team name, or for no team if teamName is empty.
+func NewSetupAssistantVariables(appCfg *fleet.AppConfig, teamName string) SetupAssistantVariables {
+	if teamName == "" {
+		teamName = "No team"
+	}
+	return SetupAssistantVariables{
+		SetupAssistantVarOrgName:    appCfg.OrgInfo.OrgName,
+		SetupAssistantVarOrgLogoURL: appCfg.OrgInfo.OrgLogoURL,
+		SetupAssistantVarServerURL:  appCfg.ServerSettings.ServerURL,
+		SetupAssistantVarTeamName:   teamName,
+	}
+}
+
+// ValidateSetupAssistantVariables returns an error if the setup assistant
+// references a variable that is not supported.
+func ValidateSetupAssistantVariables(profile json.RawMessage) error {
+	var unknown []string
+	seen := make(map[string]bool)
+	_, err := mapSetupAssistantStrings(profile, func(s string) string {
+		for _, m := range fleetVarRegexp.FindAllStringSubmatch(s, -1) {
+			name := m[1] + m[2]
+			if !supportedSetupAssistantVars[name] && !seen[name] {
+				seen[name] = true
+				unknown = append(unknown, "FLEET_VAR_"+name)
+			}
+		}
+		return s
+	})
+	if err != nil {
+		return err
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unsupported variable(s): %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// ExpandSetupAssistantVariables returns the setup assistant with the variables
+// referenced in its string values replaced by their values. Unsupported
+// variables are left as-is.
+func ExpandSetupAssistantVariables(profile json.RawMessage, vars SetupAssistantVariables) (json.RawMessage, error) {
+	return mapSetupAssistantStrings(profile, func(s string) string {
+		return fleetVarRegexp.ReplaceAllStringFunc(s, func(v string) string {
+			m := fleetVarRegexp.FindStringSubmatch(v)
+			if val, ok := vars[m[1]+m[2]]; ok {
+				return val
+			}
+			return v
+		})
+	})
+}
+
+// mapSetupAssistantStrings applies fn to all the string values of the JSON
+// document and returns the resulting document.
+func mapSetupAssistantStrings(profile json.RawMessage, fn func(string) string) (json.RawMessage, error) {
+	var v any
+	if err := json.Unmarshal(profile, &v); err != nil {
+		return nil, fmt.Errorf("unmarshal setup assistant: %w", err)
+	}
+
+	var walk func(v any) any
+	walk = func(v any) any {
+		switch v := v.(type) {
+		case string:
+			return fn(v)
+		case []any:
+			for i, e := range v {
+				v[i] = walk(e)
+			}
+		case map[string]any:
+			for k, e := range v {
+				v[k] = walk(e)
+			}
+		}
+		return v
+	}
+
+	b, err := json.Marshal(walk(v))
+	if err != nil {
+		return nil, fmt.Errorf("marshal setup assistant: %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,53 @@
+package apple_mdm
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupAssistantVariables(t *testing.T) {
+	appCfg := &fleet.AppConfig{
+		OrgInfo:        fleet.OrgInfo{OrgName: `Acme "Inc"`, OrgLogoURL: "https://acme.example.com/logo.png"},
+		ServerSettings: fleet.ServerSettings{ServerURL: "https://fleet.example.com"},
+	}
+
+	profile := json.RawMessage(`{
+		"profile_name": "$FLEET_VAR_ORG_NAME - ${FLEET_VAR_TEAM_NAME}",
+		"department": "${FLEET_VAR_TEAM_NAME}",
+		"support_email_address": "help@example.com",
+		"skip_setup_items": ["Location", "$FLEET_VAR_NOT_EXPANDED"],
+		"is_supervised": true,
+		"nested": {"url": "$FLEET_VAR_SERVER_URL/support", "price": "$5"}
+	}`)
+
+	err := ValidateSetupAssistantVariables(profile)
+	require.Error(t, err)
+	require.Equal(t, "unsupported variable(s): FLEET_VAR_NOT_EXPANDED", err.Error())
+
+	got, err := ExpandSetupAssistantVariables(profile, NewSetupAssistantVariables(appCfg, "Workstations"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"profile_name": "Acme \"Inc\" - Workstations",
+		"department": "Workstations",
+		"support_email_address": "help@example.com",
+		"skip_setup_items": ["Location", "$FLEET_VAR_NOT_EXPANDED"],
+		"is_supervised": true,
+		"nested": {"url": "https://fleet.example.com/support", "price": "$5"}
+	}`, string(got))
+
+	// no team
+	got, err = ExpandSetupAssistantVariables(json.RawMessage(`{"department": "$FLEET_VAR_TEAM_NAME"}`), NewSetupAssistantVariables(appCfg, ""))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"department": "No team"}`, string(got))
+
+	// supported variables only
+	require.NoError(t, ValidateSetupAssistantVariables(json.RawMessage(`{"a": "$FLEET_VAR_ORG_NAME ${FLEET_VAR_ORG_LOGO_URL} $FLEET_VAR_SERVER_URL $FLEET_VAR_TEAM_NAME"}`)))
+
+	// invalid JSON
+	_, err = ExpandSetupAssistantVariables(json.RawMessage(`{`), nil)
+	require.Error(t, err)
+	require.Error(t, ValidateSetupAssistantVariables(json.RawMessage(`{`)))
+}
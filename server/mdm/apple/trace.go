@@ -0,0 +1,146 @@
+package apple_mdm
+
+import (
+	"context"
+	"net/http"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/micromdm/nanomdm/log/ctxlog"
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+)
+
+const (
+	// TraceIDHeader is the HTTP response header in which the trace ID of a
+	// request to the MDM endpoint is returned.
+	TraceIDHeader = "X-Fleet-MDM-Trace-Id"
+	// TraceIDParam is the request parameter in which the trace ID of a request
+	// to the MDM endpoint is stored, so that it survives the services that
+	// replace the context of the MDM request (e.g. nanomdm's multi service).
+	TraceIDParam = "fleet_trace_id"
+)
+
+type traceIDKey struct{}
+
+// NewContextWithTraceID returns a context carrying the trace ID. The trace ID
+// is added to the logs of nanomdm components that log with the context (e.g.
+// the MDM handlers, service and push service).
+func NewContextWithTraceID(ctx context.Context, traceID string) context.Context {
+	ctx = context.WithValue(ctx, traceIDKey{}, traceID)
+	return ctxlog.AddFunc(ctx, ctxlog.SimpleStringFunc("trace_id", traceIDKey{}))
+}
+
+// TraceIDFromContext returns the trace ID stored in the context, or an empty
+// string if there's none.
+func TraceIDFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(traceIDKey{}).(string)
+	return v
+}
+
+// TraceIDFromRequest returns the trace ID of the MDM request, taken from its
+// context or, failing that, from its parameters.
+func TraceIDFromRequest(r *mdm.Request) string {
+	if r.Context != nil {
+		if traceID := TraceIDFromContext(r.Context); traceID != "" {
+			return traceID
+		}
+	}
+	return r.Params[TraceIDParam]
+}
+
+// ensureTraceID returns a context carrying a new trace ID if ctx doesn't
+// already carry one.
+func ensureTraceID(ctx context.Context) context.Context {
+	if TraceIDFromContext(ctx) != "" {
+		return ctx
+	}
+	return NewContextWithTraceID(ctx, uuid.New().String())
+}
+
+// LoggerWithTraceID returns logger with the trace ID stored in the context, if
+// any.
+func LoggerWithTraceID(ctx context.Context, logger kitlog.Logger) kitlog.Logger {
+	if traceID := TraceIDFromContext(ctx); traceID != "" {
+		return kitlog.With(logger, "trace_id", traceID)
+	}
+	return logger
+}
+
+// TraceIDMiddleware sets a new trace ID in the context and parameters of each
+// request to the MDM endpoint (i.e. for each check-in of a device) and returns
+// it in the TraceIDHeader response header, so that the flow of a device can be
+// followed across components. Any trace ID sent by the device is ignored.
+func TraceIDMiddleware(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		traceID := uuid.New().String()
+		w.Header().Set(TraceIDHeader, traceID)
+
+		r = r.WithContext(NewContextWithTraceID(r.Context(), traceID))
+		q := r.URL.Query()
+		q.Set(TraceIDParam, traceID)
+		r.URL.RawQuery = q.Encode()
+		next.ServeHTTP(w, r)
+	}
+}
+
+// TraceIDService wraps a check-in and command service to restore the trace ID
+// of the request in its context. It is meant to wrap the services run by
+// nanomdm's multi service, which get a request with a new context.
+type TraceIDService struct {
+	next service.CheckinAndCommandService
+}
+
+// NewTraceIDService returns a TraceIDService wrapping next.
+func NewTraceIDService(next service.CheckinAndCommandService) *TraceIDService {
+	return &TraceIDService{next: next}
+}
+
+func (s *TraceIDService) withTraceID(r *mdm.Request) *mdm.Request {
+	traceID := TraceIDFromRequest(r)
+	if traceID == "" {
+		return r
+	}
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if TraceIDFromContext(ctx) == traceID {
+		return r
+	}
+	r2 := r.Clone()
+	r2.Context = NewContextWithTraceID(ctx, traceID)
+	return r2
+}
+
+func (s *TraceIDService) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return s.next.Authenticate(s.withTraceID(r), m)
+}
+
+func (s *TraceIDService) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return s.next.TokenUpdate(s.withTraceID(r), m)
+}
+
+func (s *TraceIDService) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return s.next.CheckOut(s.withTraceID(r), m)
+}
+
+func (s *TraceIDService) SetBootstrapToken(r *mdm.Request, m *mdm.SetBootstrapToken) error {
+	return s.next.SetBootstrapToken(s.withTraceID(r), m)
+}
+
+func (s *TraceIDService) GetBootstrapToken(r *mdm.Request, m *mdm.GetBootstrapToken) (*mdm.BootstrapToken, error) {
+	return s.next.GetBootstrapToken(s.withTraceID(r), m)
+}
+
+func (s *TraceIDService) UserAuthenticate(r *mdm.Request, m *mdm.UserAuthenticate) ([]byte, error) {
+	return s.next.UserAuthenticate(s.withTraceID(r), m)
+}
+
+func (s *TraceIDService) DeclarativeManagement(r *mdm.Request, m *mdm.DeclarativeManagement) ([]byte, error) {
+	return s.next.DeclarativeManagement(s.withTraceID(r), m)
+}
+
+func (s *TraceIDService) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	return s.next.CommandAndReportResults(s.withTraceID(r), results)
+}
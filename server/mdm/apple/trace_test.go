@@ -0,0 +1,87 @@
+package apple_mdm
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceIDMiddleware(t *testing.T) {
+	var gotCtx, gotParam string
+	handler := TraceIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotCtx = TraceIDFromContext(r.Context())
+		gotParam = r.URL.Query().Get(TraceIDParam)
+	}))
+
+	// a trace ID sent by the device is ignored
+	req := httptest.NewRequest(http.MethodPut, MDMPath+"?"+TraceIDParam+"=spoofed&foo=bar", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	traceID := rec.Header().Get(TraceIDHeader)
+	require.NotEmpty(t, traceID)
+	require.NotEqual(t, "spoofed", traceID)
+	require.Equal(t, traceID, gotCtx)
+	require.Equal(t, traceID, gotParam)
+
+	// each request gets a new trace ID
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, MDMPath, nil))
+	require.NotEmpty(t, rec.Header().Get(TraceIDHeader))
+	require.NotEqual(t, traceID, rec.Header().Get(TraceIDHeader))
+}
+
+func TestEnsureTraceID(t *testing.T) {
+	ctx := ensureTraceID(context.Background())
+	traceID := TraceIDFromContext(ctx)
+	require.NotEmpty(t, traceID)
+	require.Equal(t, traceID, TraceIDFromContext(ensureTraceID(ctx)))
+}
+
+func TestLoggerWithTraceID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := kitlog.NewLogfmtLogger(&buf)
+
+	require.NoError(t, LoggerWithTraceID(context.Background(), logger).Log("msg", "no trace"))
+	require.NotContains(t, buf.String(), "trace_id")
+
+	buf.Reset()
+	ctx := NewContextWithTraceID(context.Background(), "abc")
+	require.NoError(t, LoggerWithTraceID(ctx, logger).Log("msg", "trace"))
+	require.Contains(t, buf.String(), "trace_id=abc")
+}
+
+type traceRecordingService struct {
+	service.CheckinAndCommandService
+	traceID string
+}
+
+func (s *traceRecordingService) CommandAndReportResults(r *mdm.Request, _ *mdm.CommandResults) (*mdm.Command, error) {
+	s.traceID = TraceIDFromContext(r.Context)
+	return nil, nil
+}
+
+func TestTraceIDService(t *testing.T) {
+	next := &traceRecordingService{}
+	svc := NewTraceIDService(next)
+
+	// the request context was replaced, the trace ID is restored from the params
+	r := &mdm.Request{Context: context.Background(), Params: map[string]string{TraceIDParam: "abc"}}
+	_, err := svc.CommandAndReportResults(r, &mdm.CommandResults{})
+	require.NoError(t, err)
+	require.Equal(t, "abc", next.traceID)
+	require.Empty(t, TraceIDFromContext(r.Context))
+
+	// no trace ID
+	r = &mdm.Request{Context: context.Background()}
+	_, err = svc.CommandAndReportResults(r, &mdm.CommandResults{})
+	require.NoError(t, err)
+	require.Empty(t, next.traceID)
+}
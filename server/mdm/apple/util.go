@@ -3,10 +3,12 @@ package apple_mdm
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // used for certificate fingerprints, not for security
 	"crypto/sha256"
 	"crypto/sha512"
 	"crypto/x509"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -18,6 +20,7 @@ import (
 	"time"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/groob/plist"
 	"github.com/micromdm/nanomdm/mdm"
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -158,6 +161,65 @@ func FmtErrorChain(chain []mdm.ErrorChain) string {
 	return sb.String()
 }
 
+// ParseProfileListResult parses the raw result of a ProfileList command and
+// returns the PayloadUUID of the listed profiles, keyed by PayloadIdentifier.
+func ParseProfileListResult(raw []byte) (map[string]string, error) {
+	var payload struct {
+		ProfileList []struct {
+			PayloadIdentifier string
+			PayloadUUID       string
+		}
+	}
+	if err := plist.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("unmarshal profile list: %w", err)
+	}
+
+	profiles := make(map[string]string, len(payload.ProfileList))
+	for _, item := range payload.ProfileList {
+		profiles[item.PayloadIdentifier] = item.PayloadUUID
+	}
+	return profiles, nil
+}
+
+// ParseCertificateListResult parses the raw result of a CertificateList
+// command and returns the metadata of the listed certificates. Certificates
+// that cannot be parsed are still returned with the common name reported by
+// the host.
+func ParseCertificateListResult(raw []byte) ([]*fleet.HostMDMCertificate, error) {
+	var payload struct {
+		CertificateList []struct {
+			CommonName string
+			Data       []byte
+			IsIdentity bool
+		}
+	}
+	if err := plist.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("unmarshal certificate list: %w", err)
+	}
+
+	certs := make([]*fleet.HostMDMCertificate, 0, len(payload.CertificateList))
+	for _, item := range payload.CertificateList {
+		sum := sha1.Sum(item.Data) //nolint:gosec
+		cert := &fleet.HostMDMCertificate{
+			SHA1Sum:    hex.EncodeToString(sum[:]),
+			CommonName: item.CommonName,
+			IsIdentity: item.IsIdentity,
+		}
+		if parsed, err := x509.ParseCertificate(item.Data); err == nil {
+			notBefore, notAfter := parsed.NotBefore.UTC(), parsed.NotAfter.UTC()
+			cert.Issuer = parsed.Issuer.CommonName
+			cert.SerialNumber = parsed.SerialNumber.String()
+			cert.NotValidBefore = &notBefore
+			cert.NotValidAfter = &notAfter
+			if cert.CommonName == "" {
+				cert.CommonName = parsed.Subject.CommonName
+			}
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
 func EnrollURL(token string, appConfig *fleet.AppConfig) (string, error) {
 	enrollURL, err := url.Parse(appConfig.ServerSettings.ServerURL)
 	if err != nil {
@@ -1,9 +1,15 @@
 package apple_mdm
 
 import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
 	"testing"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/groob/plist"
 	"github.com/stretchr/testify/require"
 )
 
@@ -36,3 +42,92 @@ func TestMDMAppleEnrollURL(t *testing.T) {
 		require.Equal(t, tt.expectedURL, enrollURL)
 	}
 }
+
+func TestParseCertificateListResult(t *testing.T) {
+	key, err := newPrivateKey()
+	require.NoError(t, err)
+	notBefore := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2043, 1, 1, 0, 0, 0, 0, time.UTC)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1234),
+		Subject:      pkix.Name{CommonName: "wifi.example.com"},
+		Issuer:       pkix.Name{CommonName: "wifi.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	type item struct {
+		CommonName string
+		Data       []byte
+		IsIdentity bool
+	}
+	raw, err := plist.Marshal(map[string]interface{}{
+		"CommandUUID": "CERTLIST-ALL-uuid",
+		"Status":      "Acknowledged",
+		"CertificateList": []item{
+			{CommonName: "Wi-Fi", Data: der, IsIdentity: true},
+			{CommonName: "", Data: der},
+			{CommonName: "invalid", Data: []byte("not a certificate")},
+		},
+	})
+	require.NoError(t, err)
+
+	certs, err := ParseCertificateListResult(raw)
+	require.NoError(t, err)
+	require.Len(t, certs, 3)
+
+	require.Equal(t, "Wi-Fi", certs[0].CommonName)
+	require.True(t, certs[0].IsIdentity)
+	require.Len(t, certs[0].SHA1Sum, 40)
+	require.Equal(t, "wifi.example.com", certs[0].Issuer)
+	require.Equal(t, "1234", certs[0].SerialNumber)
+	require.Equal(t, notBefore, *certs[0].NotValidBefore)
+	require.Equal(t, notAfter, *certs[0].NotValidAfter)
+
+	// the common name defaults to the subject of the certificate
+	require.Equal(t, "wifi.example.com", certs[1].CommonName)
+	require.False(t, certs[1].IsIdentity)
+	require.Equal(t, certs[0].SHA1Sum, certs[1].SHA1Sum)
+
+	// invalid certificates are still reported
+	require.Equal(t, "invalid", certs[2].CommonName)
+	require.Len(t, certs[2].SHA1Sum, 40)
+	require.Empty(t, certs[2].Issuer)
+	require.Nil(t, certs[2].NotValidAfter)
+
+	_, err = ParseCertificateListResult([]byte("not a plist"))
+	require.Error(t, err)
+}
+
+func TestParseProfileListResult(t *testing.T) {
+	type item struct {
+		PayloadIdentifier  string
+		PayloadUUID        string
+		PayloadDisplayName string
+	}
+	raw, err := plist.Marshal(map[string]interface{}{
+		"CommandUUID": "PROFLIST-uuid",
+		"Status":      "Acknowledged",
+		"ProfileList": []item{
+			{PayloadIdentifier: "com.example.wifi", PayloadUUID: "uuid-1", PayloadDisplayName: "Wi-Fi"},
+			{PayloadIdentifier: "com.example.vpn", PayloadUUID: "uuid-2", PayloadDisplayName: "VPN"},
+		},
+	})
+	require.NoError(t, err)
+
+	profiles, err := ParseProfileListResult(raw)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"com.example.wifi": "uuid-1", "com.example.vpn": "uuid-2"}, profiles)
+
+	// no profile installed
+	raw, err = plist.Marshal(map[string]interface{}{"CommandUUID": "PROFLIST-uuid", "Status": "Acknowledged"})
+	require.NoError(t, err)
+	profiles, err = ParseProfileListResult(raw)
+	require.NoError(t, err)
+	require.Empty(t, profiles)
+
+	_, err = ParseProfileListResult([]byte("not a plist"))
+	require.Error(t, err)
+}
@@ -0,0 +1,110 @@
+// Package assetcdn signs the URLs of the MDM assets (EULAs, bootstrap
+// packages and manual enrollment profiles) served by a CDN whose origin is the
+// MDM assets S3 bucket, so that the downloads don't go through the Fleet
+// server.
+package assetcdn
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudfront/sign"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+)
+
+// Signer returns signed URLs of the assets served by the CDN.
+type Signer interface {
+	// SignURL returns the URL of the asset at assetPath on the CDN, signed to
+	// be valid until the configured expiry after now.
+	SignURL(assetPath string, now time.Time) (string, error)
+}
+
+// New returns the Signer of the CDN configured in cfg, or nil if the CDN is
+// not enabled. It returns an error if the configuration is invalid.
+func New(cfg fleet.MDMAssetCDN) (Signer, error) {
+	if !cfg.Enable {
+		return nil, nil
+	}
+
+	base, err := url.Parse(cfg.URL)
+	if err != nil || (base.Scheme != "https" && base.Scheme != "http") || base.Host == "" {
+		return nil, errors.New("url must be a valid http or https URL")
+	}
+	if cfg.PrivateKey == "" {
+		return nil, errors.New("private_key is required")
+	}
+	if cfg.URLExpiry.Duration < 0 {
+		return nil, errors.New("url_expiry must not be negative")
+	}
+	expiry := cfg.URLExpiry.ValueOr(fleet.DefaultMDMAssetCDNURLExpiry)
+
+	switch cfg.Provider {
+	case fleet.MDMAssetCDNProviderCloudFront:
+		if cfg.KeyID == "" {
+			return nil, errors.New("key_id is required for cloudfront")
+		}
+		key, err := sign.LoadPEMPrivKey(strings.NewReader(cfg.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("parsing cloudfront private key: %w", err)
+		}
+		return &cloudFrontSigner{base: base, expiry: expiry, signer: sign.NewURLSigner(cfg.KeyID, key)}, nil
+
+	case fleet.MDMAssetCDNProviderFastly:
+		secret, err := base64.StdEncoding.DecodeString(cfg.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("decoding fastly secret: %w", err)
+		}
+		return &fastlySigner{base: base, expiry: expiry, secret: secret}, nil
+
+	default:
+		return nil, fmt.Errorf("invalid provider %q", cfg.Provider)
+	}
+}
+
+// assetURL returns the URL of the asset at assetPath under base.
+func assetURL(base *url.URL, assetPath string) *url.URL {
+	u := *base
+	u.Path = path.Join("/", base.Path, assetPath)
+	u.RawQuery = ""
+	return &u
+}
+
+// cloudFrontSigner signs the URLs with a CloudFront canned policy.
+type cloudFrontSigner struct {
+	base   *url.URL
+	expiry time.Duration
+	signer *sign.URLSigner
+}
+
+func (s *cloudFrontSigner) SignURL(assetPath string, now time.Time) (string, error) {
+	return s.signer.Sign(assetURL(s.base, assetPath).String(), now.Add(s.expiry))
+}
+
+// fastlySigner signs the URLs with a Fastly token, made of the expiration
+// time and the hex-encoded HMAC-SHA256 of the URL path followed by the
+// expiration time, as validated by Fastly's token authentication VCL.
+type fastlySigner struct {
+	base   *url.URL
+	expiry time.Duration
+	secret []byte
+}
+
+func (s *fastlySigner) SignURL(assetPath string, now time.Time) (string, error) {
+	u := assetURL(s.base, assetPath)
+	expiration := strconv.FormatInt(now.Add(s.expiry).Unix(), 10)
+
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(u.Path + expiration))
+
+	u.RawQuery = url.Values{"token": {expiration + "_" + hex.EncodeToString(mac.Sum(nil))}}.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,114 @@
+package assetcdn
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // CloudFront signatures use SHA-1
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	s, err := New(fleet.MDMAssetCDN{})
+	require.NoError(t, err)
+	require.Nil(t, s)
+
+	_, err = New(fleet.MDMAssetCDN{Enable: true, Provider: "nope", URL: "https://cdn.example.com", PrivateKey: "k"})
+	require.ErrorContains(t, err, `invalid provider "nope"`)
+
+	_, err = New(fleet.MDMAssetCDN{Enable: true, Provider: fleet.MDMAssetCDNProviderFastly, URL: "cdn.example.com", PrivateKey: "k"})
+	require.ErrorContains(t, err, "url must be a valid http or https URL")
+
+	_, err = New(fleet.MDMAssetCDN{Enable: true, Provider: fleet.MDMAssetCDNProviderFastly, URL: "https://cdn.example.com"})
+	require.ErrorContains(t, err, "private_key is required")
+
+	_, err = New(fleet.MDMAssetCDN{Enable: true, Provider: fleet.MDMAssetCDNProviderFastly, URL: "https://cdn.example.com", PrivateKey: "!!"})
+	require.ErrorContains(t, err, "decoding fastly secret")
+
+	_, err = New(fleet.MDMAssetCDN{Enable: true, Provider: fleet.MDMAssetCDNProviderCloudFront, URL: "https://cdn.example.com", PrivateKey: "k"})
+	require.ErrorContains(t, err, "key_id is required")
+
+	_, err = New(fleet.MDMAssetCDN{Enable: true, Provider: fleet.MDMAssetCDNProviderCloudFront, URL: "https://cdn.example.com", KeyID: "K", PrivateKey: "k"})
+	require.ErrorContains(t, err, "parsing cloudfront private key")
+
+	_, err = New(fleet.MDMAssetCDN{
+		Enable: true, Provider: fleet.MDMAssetCDNProviderFastly, URL: "https://cdn.example.com", PrivateKey: "a2V5",
+		URLExpiry: fleet.Duration{Duration: -time.Minute},
+	})
+	require.ErrorContains(t, err, "url_expiry must not be negative")
+}
+
+func TestFastlySigner(t *testing.T) {
+	secret := []byte("secret")
+	s, err := New(fleet.MDMAssetCDN{
+		Enable:     true,
+		Provider:   fleet.MDMAssetCDNProviderFastly,
+		URL:        "https://cdn.example.com/fleet/",
+		PrivateKey: base64.StdEncoding.EncodeToString(secret),
+	})
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	signed, err := s.SignURL("mdm/eulas/abc", now)
+	require.NoError(t, err)
+
+	u, err := url.Parse(signed)
+	require.NoError(t, err)
+	require.Equal(t, "cdn.example.com", u.Host)
+	require.Equal(t, "/fleet/mdm/eulas/abc", u.Path)
+
+	expiration := fmt.Sprint(now.Add(fleet.DefaultMDMAssetCDNURLExpiry).Unix())
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(u.Path + expiration))
+	require.Equal(t, expiration+"_"+hex.EncodeToString(mac.Sum(nil)), u.Query().Get("token"))
+}
+
+func TestCloudFrontSigner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	s, err := New(fleet.MDMAssetCDN{
+		Enable:     true,
+		Provider:   fleet.MDMAssetCDNProviderCloudFront,
+		URL:        "https://d111111abcdef8.cloudfront.net",
+		KeyID:      "K2JCJMDEHXQW5F",
+		PrivateKey: string(keyPEM),
+		URLExpiry:  fleet.Duration{Duration: time.Hour},
+	})
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	signed, err := s.SignURL("bootstrap_packages/abc", now)
+	require.NoError(t, err)
+
+	u, err := url.Parse(signed)
+	require.NoError(t, err)
+	require.Equal(t, "/bootstrap_packages/abc", u.Path)
+	q := u.Query()
+	require.Equal(t, "K2JCJMDEHXQW5F", q.Get("Key-Pair-Id"))
+	expires := fmt.Sprint(now.Add(time.Hour).Unix())
+	require.Equal(t, expires, q.Get("Expires"))
+
+	// the signature is the CloudFront-safe base64 of the RSA-SHA1 signature of
+	// the canned policy.
+	u.RawQuery = ""
+	policy := fmt.Sprintf(`{"Statement":[{"Resource":%q,"Condition":{"DateLessThan":{"AWS:EpochTime":%s}}}]}`, u.String(), expires)
+	sig, err := base64.StdEncoding.DecodeString(strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(q.Get("Signature")))
+	require.NoError(t, err)
+	digest := sha1.Sum([]byte(policy)) //nolint:gosec
+	require.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA1, digest[:], sig))
+}
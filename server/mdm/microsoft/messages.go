@@ -0,0 +1,208 @@
+package microsoft_mdm
+
+import (
+	"encoding/base64"
+	"strconv"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+)
+
+func newSoapResponse(action, relatesTo string, body fleet.SoapResponseBody) *fleet.SoapResponse {
+	return &fleet.SoapResponse{
+		XMLNSS: SoapEnvelopeNamespace,
+		XMLNSA: AddressingNamespace,
+		Header: fleet.SoapResponseHeader{
+			Action:    fleet.SoapAction{MustUnderstand: "1", Value: action},
+			RelatesTo: relatesTo,
+		},
+		Body: body,
+	}
+}
+
+// NewDiscoverResponse returns the response to a discovery request, which
+// points the device to the policy and enrollment services of Fleet.
+func NewDiscoverResponse(relatesTo, enrollmentVersion, policyURL, enrollURL string) *fleet.SoapResponse {
+	return newSoapResponse(ActionDiscoverResponse, relatesTo, fleet.SoapResponseBody{
+		XMLNSXSI: XSINamespace,
+		XMLNSXSD: XSDNamespace,
+		DiscoverResponse: &fleet.DiscoverResponse{
+			XMLNS:                      DiscoveryNamespace,
+			AuthPolicy:                 AuthPolicyOnPremise,
+			EnrollmentVersion:          enrollmentVersion,
+			EnrollmentPolicyServiceURL: policyURL,
+			EnrollmentServiceURL:       enrollURL,
+		},
+	})
+}
+
+// NegotiateEnrollmentVersion returns the version of the enrollment protocol
+// to use with a device that supports up to requestVersion.
+func NegotiateEnrollmentVersion(requestVersion string) string {
+	v, err := strconv.ParseFloat(requestVersion, 64)
+	if err != nil || v >= 4 {
+		return MaxEnrollmentVersion
+	}
+	return requestVersion
+}
+
+// NewGetPoliciesResponse returns the response to a policy request, with the
+// requirements of the certificate signing request of the device.
+func NewGetPoliciesResponse(relatesTo string) *fleet.SoapResponse {
+	nilElem := fleet.NewNilElement
+	return newSoapResponse(ActionGetPoliciesResponse, relatesTo, fleet.SoapResponseBody{
+		XMLNSXSI: XSINamespace,
+		XMLNSXSD: XSDNamespace,
+		GetPoliciesResponse: &fleet.GetPoliciesResponse{
+			XMLNS: EnrollmentPolicyNamespace,
+			Response: fleet.Response{
+				PolicyFriendlyName: nilElem(),
+				NextUpdateHours:    nilElem(),
+				PoliciesNotChanged: nilElem(),
+				Policies: []fleet.EnrollmentPolicy{{
+					PolicyOIDReference: 0,
+					CAs:                nilElem(),
+					Attributes: fleet.PolicyAttributes{
+						CommonName:                "FleetDeviceEnrollment",
+						PolicySchema:              3,
+						ValidityPeriodSeconds:     int(wstepCertValidity.Seconds()),
+						RenewalPeriodSeconds:      int(wstepCertRenewalPeriod.Seconds()),
+						Enroll:                    true,
+						AutoEnroll:                false,
+						MinimalKeyLength:          wstepMinimalKeyLength,
+						KeySpec:                   nilElem(),
+						KeyUsageProperty:          nilElem(),
+						Permissions:               nilElem(),
+						AlgorithmOIDReference:     nilElem(),
+						CryptoProviders:           nilElem(),
+						MajorRevision:             101,
+						MinorRevision:             0,
+						SupersededPolicies:        nilElem(),
+						PrivateKeyFlags:           nilElem(),
+						SubjectNameFlags:          nilElem(),
+						EnrollmentFlags:           nilElem(),
+						GeneralFlags:              nilElem(),
+						HashAlgorithmOIDReference: 0,
+						RARequirements:            nilElem(),
+						KeyArchivalAttributes:     nilElem(),
+						Extensions:                nilElem(),
+					},
+				}},
+			},
+			CAs: nilElem(),
+			OIDs: fleet.OIDs{OID: []fleet.OID{{
+				// SHA-256, referenced by HashAlgorithmOIDReference
+				Value:          "2.16.840.1.101.3.4.2.1",
+				Group:          1,
+				OIDReferenceID: 0,
+				DefaultName:    "szOID_NIST_sha256",
+			}}},
+		},
+	})
+}
+
+// NewRequestSecurityTokenResponse returns the response to an enrollment
+// request, with the provisioning document of the device.
+func NewRequestSecurityTokenResponse(relatesTo string, provisioningDoc []byte, now time.Time) *fleet.SoapResponse {
+	resp := newSoapResponse(ActionRequestSecurityTokenResponse, relatesTo, fleet.SoapResponseBody{
+		RequestSecurityTokenResponseCollection: &fleet.RequestSecurityTokenResponseCollection{
+			XMLNS: WSTrustNamespace,
+			RequestSecurityTokenResponse: fleet.RequestSecurityTokenResponse{
+				TokenType:          TokenTypeDeviceEnrollment,
+				DispositionMessage: fleet.XMLNSValue{XMLNS: EnrollmentNamespace},
+				RequestedSecurityToken: fleet.BinarySecurityToken{
+					XMLNS:        WSSecuritySecextNamespace,
+					ValueType:    ValueTypeProvisioningDoc,
+					EncodingType: EncodingTypeBase64,
+					Value:        base64.StdEncoding.EncodeToString(provisioningDoc),
+				},
+				RequestID: fleet.XMLNSValue{XMLNS: EnrollmentNamespace, Value: "0"},
+			},
+		},
+	})
+	resp.XMLNSU = WSSecurityUtilityNamespace
+	sec := &fleet.WSSecurityTimestamped{XMLNSO: WSSecuritySecextNamespace, MustUnderstand: "1"}
+	sec.Timestamp.ID = "_0"
+	sec.Timestamp.Created = now.UTC().Format(time.RFC3339)
+	sec.Timestamp.Expires = now.Add(5 * time.Minute).UTC().Format(time.RFC3339)
+	resp.Header.Security = sec
+	return resp
+}
+
+// NewSoapFault returns the SOAP fault of a failed enrollment request.
+func NewSoapFault(relatesTo string, fault *fleet.MicrosoftMDMFault, traceID string) *fleet.SoapResponse {
+	code := "s:Receiver"
+	if fault.Sender {
+		code = "s:Sender"
+	}
+	detail := &fleet.SoapFaultDetail{}
+	detail.Error.XMLNS = EnrollmentNamespace
+	detail.Error.ErrorType = fault.Type
+	detail.Error.Message = fault.Message
+	detail.Error.TraceID = traceID
+
+	return newSoapResponse(ActionFault, relatesTo, fleet.SoapResponseBody{
+		Fault: &fleet.SoapFault{
+			Code:    code,
+			Subcode: fleet.SoapFaultCode{XMLNSA: EnrollmentNamespace, Value: "a:" + fault.Type},
+			Reason:  fleet.SoapFaultText{Lang: "en-US", Value: fault.Message},
+			Detail:  detail,
+		},
+	})
+}
+
+// NewSyncMLResponse returns the response to a SyncML message of a device,
+// which acknowledges the header and every command of the message.
+func NewSyncMLResponse(req *fleet.SyncMLMessage, managementURL string) *fleet.SyncMLMessage {
+	resp := &fleet.SyncMLMessage{
+		XMLNS: SyncMLNamespace,
+		Header: fleet.SyncMLHeader{
+			VerDTD:    SyncMLVerDTD,
+			VerProto:  SyncMLVerProto,
+			SessionID: req.Header.SessionID,
+			MsgID:     req.Header.MsgID,
+			Target:    req.Header.Source,
+			Source:    managementURL,
+		},
+		Body: fleet.SyncMLBody{Final: &struct{}{}},
+	}
+
+	cmdID := 1
+	addStatus := func(cmd, cmdRef string) {
+		resp.Body.Status = append(resp.Body.Status, fleet.SyncMLStatus{
+			CmdID:  strconv.Itoa(cmdID),
+			MsgRef: req.Header.MsgID,
+			CmdRef: cmdRef,
+			Cmd:    cmd,
+			Data:   SyncMLStatusOK,
+		})
+		cmdID++
+	}
+	addStatus("SyncHdr", "0")
+	for _, c := range req.Body.Alert {
+		addStatus("Alert", c.CmdID)
+	}
+	for _, c := range req.Body.Replace {
+		addStatus("Replace", c.CmdID)
+	}
+	for _, c := range req.Body.Results {
+		addStatus("Results", c.CmdID)
+	}
+	return resp
+}
+
+// IsUnenrollmentRequest returns true if the SyncML message of a device
+// notifies that the user unenrolled it.
+func IsUnenrollmentRequest(msg *fleet.SyncMLMessage) bool {
+	for _, alert := range msg.Body.Alert {
+		if alert.Data != AlertCodeGeneric {
+			continue
+		}
+		for _, item := range alert.Items {
+			if item.MetaType == AlertTypeUserUnenrollment {
+				return true
+			}
+		}
+	}
+	return false
+}
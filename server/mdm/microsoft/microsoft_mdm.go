@@ -0,0 +1,132 @@
+// Package microsoft_mdm implements the Windows MDM enrollment (MS-MDE2) and
+// management (MS-MDM) protocols.
+package microsoft_mdm
+
+import (
+	"net/url"
+	"path"
+)
+
+const (
+	// MDE2DiscoveryPath is Fleet's HTTP path for the Windows MDM discovery
+	// service.
+	MDE2DiscoveryPath = "/api/mdm/microsoft/discovery"
+	// MDE2PolicyPath is Fleet's HTTP path for the Windows MDM enrollment
+	// policy service (MS-XCEP).
+	MDE2PolicyPath = "/api/mdm/microsoft/policy"
+	// MDE2EnrollPath is Fleet's HTTP path for the Windows MDM enrollment
+	// service (MS-WSTEP).
+	MDE2EnrollPath = "/api/mdm/microsoft/enroll"
+	// MDE2ManagementPath is Fleet's HTTP path for the Windows MDM management
+	// service (OMA-DM).
+	MDE2ManagementPath = "/api/mdm/microsoft/management"
+)
+
+// XML namespaces of the enrollment and management messages.
+const (
+	SoapEnvelopeNamespace      = "http://www.w3.org/2003/05/soap-envelope"
+	AddressingNamespace        = "http://www.w3.org/2005/08/addressing"
+	WSSecurityUtilityNamespace = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd"
+	WSSecuritySecextNamespace  = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd"
+	WSTrustNamespace           = "http://docs.oasis-open.org/ws-sx/ws-trust/200512"
+	XSINamespace               = "http://www.w3.org/2001/XMLSchema-instance"
+	XSDNamespace               = "http://www.w3.org/2001/XMLSchema"
+	DiscoveryNamespace         = "http://schemas.microsoft.com/windows/management/2012/01/enrollment"
+	EnrollmentPolicyNamespace  = "http://schemas.microsoft.com/windows/pki/2009/01/enrollmentpolicy"
+	EnrollmentNamespace        = "http://schemas.microsoft.com/windows/pki/2009/01/enrollment"
+	SyncMLNamespace            = "SYNCML:SYNCML1.2"
+)
+
+// SOAP actions of the enrollment messages.
+const (
+	ActionDiscover                     = DiscoveryNamespace + "/IDiscoveryService/Discover"
+	ActionDiscoverResponse             = DiscoveryNamespace + "/IDiscoveryService/DiscoverResponse"
+	ActionGetPolicies                  = EnrollmentPolicyNamespace + "/IPolicy/GetPolicies"
+	ActionGetPoliciesResponse          = EnrollmentPolicyNamespace + "/IPolicy/GetPoliciesResponse"
+	ActionRequestSecurityToken         = EnrollmentNamespace + "/RST/wstep"
+	ActionRequestSecurityTokenResponse = EnrollmentNamespace + "/RSTRC/wstep"
+	ActionFault                        = AddressingNamespace + "/soap/fault"
+)
+
+const (
+	// AuthPolicyOnPremise is the authentication policy of Fleet's enrollment,
+	// the user provides a username and password, the password being an enroll
+	// secret of Fleet.
+	AuthPolicyOnPremise = "OnPremise"
+	// MaxEnrollmentVersion is the most recent version of the enrollment
+	// protocol supported by Fleet.
+	MaxEnrollmentVersion = "4.0"
+
+	TokenTypeDeviceEnrollment = "http://schemas.microsoft.com/5.0.0.0/ConfigurationManager/Enrollment/DeviceEnrollmentToken"
+	ValueTypePKCS10           = EnrollmentNamespace + "#PKCS10"
+	ValueTypeProvisioningDoc  = "http://schemas.microsoft.com/5.0.0.0/ConfigurationManager/Enrollment/DeviceEnrollmentProvisionDoc"
+	EncodingTypeBase64        = WSSecuritySecextNamespace + "#base64binary"
+)
+
+// Names of the context items of the enrollment requests.
+const (
+	ContextItemDeviceID           = "DeviceID"
+	ContextItemHWDevID            = "HWDevID"
+	ContextItemEnrollmentType     = "EnrollmentType"
+	ContextItemDeviceType         = "DeviceType"
+	ContextItemDeviceName         = "DeviceName"
+	ContextItemApplicationVersion = "ApplicationVersion"
+	ContextItemNotInOobe          = "NotInOobe"
+	ContextItemRequestVersion     = "RequestVersion"
+)
+
+// Enrollment types of the devices. A "Full" enrollment is made by a user from
+// the Settings app, a "Device" enrollment applies to the whole device.
+const (
+	EnrollmentTypeFull   = "Full"
+	EnrollmentTypeDevice = "Device"
+)
+
+// Error types of the enrollment faults, as documented in MS-MDE2.
+const (
+	ErrorTypeMessageFormat        = "MessageFormat"
+	ErrorTypeAuthentication       = "Authentication"
+	ErrorTypeCertificateRequest   = "CertificateRequest"
+	ErrorTypeInternalServiceFault = "InternalServiceFault"
+)
+
+// SyncML protocol values of the management sessions.
+const (
+	SyncMLVerDTD   = "1.2"
+	SyncMLVerProto = "DM/1.2"
+	SyncMLStatusOK = "200"
+
+	// AlertCodeGeneric is the code of the generic alerts, whose type is in
+	// the meta of their item.
+	AlertCodeGeneric = "1226"
+	// AlertTypeUserUnenrollment is the type of the generic alert sent by a
+	// device when the user unenrolls it from the Settings app.
+	AlertTypeUserUnenrollment = "com.microsoft:mdm.unenrollment.userrequest"
+)
+
+// Content types of the enrollment and management messages.
+const (
+	SoapContentType   = "application/soap+xml; charset=utf-8"
+	SyncMLContentType = "application/vnd.syncml.dm+xml"
+)
+
+func ResolveMicrosoftMDMPolicyURL(serverURL string) (string, error) {
+	return resolveURL(serverURL, MDE2PolicyPath)
+}
+
+func ResolveMicrosoftMDMEnrollURL(serverURL string) (string, error) {
+	return resolveURL(serverURL, MDE2EnrollPath)
+}
+
+func ResolveMicrosoftMDMManagementURL(serverURL string) (string, error) {
+	return resolveURL(serverURL, MDE2ManagementPath)
+}
+
+func resolveURL(serverURL, relPath string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, relPath)
+	return u.String(), nil
+}
@@ -0,0 +1,258 @@
+package microsoft_mdm
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCertManager(t *testing.T) *CertManager {
+	caCert, caKey, err := NewWSTEPCACertKey()
+	require.NoError(t, err)
+	m, err := NewCertManager(&tls.Certificate{
+		Certificate: [][]byte{caCert.Raw},
+		PrivateKey:  caKey,
+		Leaf:        caCert,
+	})
+	require.NoError(t, err)
+	return m
+}
+
+func newTestCSR(t *testing.T, bits int) *x509.CertificateRequest {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "ignored"},
+	}, key)
+	require.NoError(t, err)
+	csr, err := x509.ParseCertificateRequest(der)
+	require.NoError(t, err)
+	return csr
+}
+
+func TestCertManager(t *testing.T) {
+	_, err := NewCertManager(nil)
+	require.Error(t, err)
+	_, err = NewCertManager(&tls.Certificate{})
+	require.Error(t, err)
+
+	m := newTestCertManager(t)
+	now := time.Now()
+
+	cert, err := m.SignClientCSR(newTestCSR(t, 2048), "DEVICE-1", now)
+	require.NoError(t, err)
+	require.Equal(t, "DEVICE-1", cert.Subject.CommonName)
+	require.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, cert.ExtKeyUsage)
+	require.True(t, cert.NotAfter.After(now.Add(364*24*time.Hour)))
+	require.NoError(t, cert.CheckSignatureFrom(m.IdentityCert()))
+
+	// the key of the CSR is too short
+	_, err = m.SignClientCSR(newTestCSR(t, 1024), "DEVICE-1", now)
+	require.ErrorContains(t, err, "CSR key is too short")
+
+	// the CSR was tampered with
+	csr := newTestCSR(t, 2048)
+	csr.Signature[0] ^= 0xff
+	_, err = m.SignClientCSR(csr, "DEVICE-1", now)
+	require.ErrorContains(t, err, "invalid CSR signature")
+
+	// the certificate is a valid client certificate of the CA, but not of
+	// another one
+	require.NoError(t, m.VerifyClientCert(cert, now))
+	require.ErrorContains(t, m.VerifyClientCert(cert, now.Add(366*24*time.Hour)), "verify client certificate")
+	require.ErrorContains(t, newTestCertManager(t).VerifyClientCert(cert, now), "verify client certificate")
+
+	thumbprint := CertThumbprint(cert)
+	require.Len(t, thumbprint, 40)
+	require.Equal(t, strings.ToUpper(thumbprint), thumbprint)
+}
+
+func TestNewProvisioningDoc(t *testing.T) {
+	m := newTestCertManager(t)
+	cert, err := m.SignClientCSR(newTestCSR(t, 2048), "DEVICE-1", time.Now())
+	require.NoError(t, err)
+	auth, err := NewAppAuth("DEVICE-1")
+	require.NoError(t, err)
+	require.Equal(t, "DEVICE-1", auth.ClientName)
+	require.NotEmpty(t, auth.ClientSecret)
+	require.NotEqual(t, auth.ClientSecret, auth.ServerSecret)
+
+	for _, c := range []struct {
+		enrollmentType string
+		store          string
+	}{
+		{EnrollmentTypeFull, "User"},
+		{EnrollmentTypeDevice, "System"},
+	} {
+		t.Run(c.enrollmentType, func(t *testing.T) {
+			b, err := NewProvisioningDoc(m.IdentityCert(), cert, "DEVICE-1", c.enrollmentType, "https://example.com/api/mdm/microsoft/management", auth)
+			require.NoError(t, err)
+
+			var doc wapProvisioningDoc
+			require.NoError(t, xml.Unmarshal(b, &doc))
+			require.Len(t, doc.Characteristics, 3)
+
+			store := doc.Characteristics[0]
+			require.Equal(t, "CertificateStore", store.Type)
+			root := store.Characteristics[0].Characteristics[0]
+			require.Equal(t, "System", root.Type)
+			require.Equal(t, CertThumbprint(m.IdentityCert()), root.Characteristics[0].Type)
+			require.Equal(t, base64.StdEncoding.EncodeToString(m.IdentityCert().Raw), root.Characteristics[0].Params[0].Value)
+			my := store.Characteristics[1].Characteristics[0]
+			require.Equal(t, c.store, my.Type)
+			require.Equal(t, CertThumbprint(cert), my.Characteristics[0].Type)
+
+			app := doc.Characteristics[1]
+			require.Equal(t, "APPLICATION", app.Type)
+			params := make(map[string]string)
+			for _, p := range app.Params {
+				params[p.Name] = p.Value
+			}
+			require.Equal(t, "https://example.com/api/mdm/microsoft/management", params["ADDR"])
+			require.Equal(t, ProviderID, params["PROVIDER-ID"])
+			require.Equal(t, "Subject=CN%3DDEVICE-1&Stores=MY%5C"+c.store, params["SSLCLIENTCERTSEARCHCRITERIA"])
+
+			appAuths := make(map[string]map[string]string)
+			for _, ch := range app.Characteristics {
+				require.Equal(t, "APPAUTH", ch.Type)
+				params := make(map[string]string)
+				for _, p := range ch.Params {
+					params[p.Name] = p.Value
+				}
+				appAuths[params["AAUTHLEVEL"]] = params
+			}
+			require.Equal(t, auth.ServerSecret, appAuths["CLIENT"]["AAUTHSECRET"])
+			require.Equal(t, auth.ServerNonce, appAuths["CLIENT"]["AAUTHDATA"])
+			require.Equal(t, auth.ClientName, appAuths["APPSRV"]["AAUTHNAME"])
+			require.Equal(t, auth.ClientSecret, appAuths["APPSRV"]["AAUTHSECRET"])
+			require.Equal(t, auth.ClientNonce, appAuths["APPSRV"]["AAUTHDATA"])
+		})
+	}
+}
+
+func TestNegotiateEnrollmentVersion(t *testing.T) {
+	require.Equal(t, "4.0", NegotiateEnrollmentVersion("4.0"))
+	require.Equal(t, "4.0", NegotiateEnrollmentVersion("5.0"))
+	require.Equal(t, "3.0", NegotiateEnrollmentVersion("3.0"))
+	require.Equal(t, "4.0", NegotiateEnrollmentVersion(""))
+}
+
+func TestSoapMessages(t *testing.T) {
+	const discoverRequest = `<s:Envelope xmlns:a="http://www.w3.org/2005/08/addressing" xmlns:s="http://www.w3.org/2003/05/soap-envelope">
+  <s:Header>
+    <a:Action s:mustUnderstand="1">http://schemas.microsoft.com/windows/management/2012/01/enrollment/IDiscoveryService/Discover</a:Action>
+    <a:MessageID>urn:uuid:748132ec-a575-4329-b01b-6171a9cf8478</a:MessageID>
+    <a:ReplyTo><a:Address>http://www.w3.org/2005/08/addressing/anonymous</a:Address></a:ReplyTo>
+    <a:To s:mustUnderstand="1">https://example.com/api/mdm/microsoft/discovery</a:To>
+  </s:Header>
+  <s:Body>
+    <Discover xmlns="http://schemas.microsoft.com/windows/management/2012/01/enrollment">
+      <request xmlns:i="http://www.w3.org/2001/XMLSchema-instance">
+        <EmailAddress>user@example.com</EmailAddress>
+        <RequestVersion>4.0</RequestVersion>
+        <DeviceType>CIMClient_Windows</DeviceType>
+        <ApplicationVersion>10.0.19045.2965</ApplicationVersion>
+        <OSEdition>72</OSEdition>
+        <AuthPolicies>
+          <AuthPolicy>OnPremise</AuthPolicy>
+          <AuthPolicy>Federated</AuthPolicy>
+        </AuthPolicies>
+      </request>
+    </Discover>
+  </s:Body>
+</s:Envelope>`
+
+	var req fleet.SoapRequest
+	require.NoError(t, xml.Unmarshal([]byte(discoverRequest), &req))
+	require.Equal(t, ActionDiscover, req.Header.Action)
+	require.Equal(t, "urn:uuid:748132ec-a575-4329-b01b-6171a9cf8478", req.Header.MessageID)
+	require.NotNil(t, req.Body.Discover)
+	require.Equal(t, "user@example.com", req.Body.Discover.EmailAddress)
+	require.Equal(t, []string{"OnPremise", "Federated"}, req.Body.Discover.AuthPolicies)
+
+	resp := NewDiscoverResponse(req.Header.MessageID, "4.0", "https://example.com/policy", "https://example.com/enroll")
+	b, err := xml.Marshal(resp)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://www.w3.org/2005/08/addressing">`)
+	require.Contains(t, string(b), `<a:Action s:mustUnderstand="1">`+ActionDiscoverResponse+`</a:Action>`)
+	require.Contains(t, string(b), `<a:RelatesTo>urn:uuid:748132ec-a575-4329-b01b-6171a9cf8478</a:RelatesTo>`)
+	require.Contains(t, string(b), `<DiscoverResponse xmlns="`+DiscoveryNamespace+`"><DiscoverResult><AuthPolicy>OnPremise</AuthPolicy><EnrollmentVersion>4.0</EnrollmentVersion>`)
+
+	b, err = xml.Marshal(NewGetPoliciesResponse("urn:uuid:1"))
+	require.NoError(t, err)
+	require.Contains(t, string(b), `<minimalKeyLength>2048</minimalKeyLength><keySpec xsi:nil="true"></keySpec>`)
+	require.Contains(t, string(b), `<oIDs><oID><value>2.16.840.1.101.3.4.2.1</value>`)
+
+	b, err = xml.Marshal(NewRequestSecurityTokenResponse("urn:uuid:2", []byte("<doc/>"), time.Date(2023, 7, 11, 10, 0, 0, 0, time.UTC)))
+	require.NoError(t, err)
+	require.Contains(t, string(b), `<u:Timestamp u:Id="_0"><u:Created>2023-07-11T10:00:00Z</u:Created><u:Expires>2023-07-11T10:05:00Z</u:Expires></u:Timestamp>`)
+	require.Contains(t, string(b), `ValueType="`+ValueTypeProvisioningDoc+`"`)
+	require.Contains(t, string(b), base64.StdEncoding.EncodeToString([]byte("<doc/>")))
+
+	fault := NewSoapFault("urn:uuid:3", fleet.NewMicrosoftMDMFault(ErrorTypeAuthentication, true, "invalid credentials"), "trace")
+	b, err = xml.Marshal(fault)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `<s:Fault><s:Code><s:Value>s:Sender</s:Value><s:Subcode><s:Value xmlns:a="`+EnrollmentNamespace+`">a:Authentication</s:Value></s:Subcode></s:Code>`)
+	require.Contains(t, string(b), `<ErrorType>Authentication</ErrorType><Message>invalid credentials</Message><TraceId>trace</TraceId>`)
+}
+
+func TestSyncMLMessages(t *testing.T) {
+	const unenrollRequest = `<SyncML xmlns="SYNCML:SYNCML1.2">
+  <SyncHdr>
+    <VerDTD>1.2</VerDTD>
+    <VerProto>DM/1.2</VerProto>
+    <SessionID>3</SessionID>
+    <MsgID>1</MsgID>
+    <Target><LocURI>https://example.com/api/mdm/microsoft/management</LocURI></Target>
+    <Source><LocURI>DEVICE-1</LocURI></Source>
+  </SyncHdr>
+  <SyncBody>
+    <Alert><CmdID>2</CmdID><Data>1201</Data></Alert>
+    <Alert>
+      <CmdID>3</CmdID>
+      <Data>1226</Data>
+      <Item>
+        <Meta><Type xmlns="syncml:metinf">com.microsoft:mdm.unenrollment.userrequest</Type><Format xmlns="syncml:metinf">int</Format></Meta>
+        <Data>1</Data>
+      </Item>
+    </Alert>
+    <Replace><CmdID>4</CmdID><Item><Source><LocURI>./DevInfo/DevId</LocURI></Source><Data>DEVICE-1</Data></Item></Replace>
+    <Final/>
+  </SyncBody>
+</SyncML>`
+
+	var req fleet.SyncMLMessage
+	require.NoError(t, xml.Unmarshal([]byte(unenrollRequest), &req))
+	require.Equal(t, "DEVICE-1", req.Header.Source)
+	require.Len(t, req.Body.Alert, 2)
+	require.Len(t, req.Body.Replace, 1)
+	require.NotNil(t, req.Body.Final)
+	require.True(t, IsUnenrollmentRequest(&req))
+
+	resp := NewSyncMLResponse(&req, "https://example.com/api/mdm/microsoft/management")
+	require.Equal(t, "3", resp.Header.SessionID)
+	require.Equal(t, "DEVICE-1", resp.Header.Target)
+	require.Equal(t, []fleet.SyncMLStatus{
+		{CmdID: "1", MsgRef: "1", CmdRef: "0", Cmd: "SyncHdr", Data: "200"},
+		{CmdID: "2", MsgRef: "1", CmdRef: "2", Cmd: "Alert", Data: "200"},
+		{CmdID: "3", MsgRef: "1", CmdRef: "3", Cmd: "Alert", Data: "200"},
+		{CmdID: "4", MsgRef: "1", CmdRef: "4", Cmd: "Replace", Data: "200"},
+	}, resp.Body.Status)
+
+	b, err := xml.Marshal(resp)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `<SyncML xmlns="SYNCML:SYNCML1.2"><SyncHdr><VerDTD>1.2</VerDTD>`)
+
+	req.Body.Alert = req.Body.Alert[:1]
+	require.False(t, IsUnenrollmentRequest(&req))
+}
@@ -0,0 +1,182 @@
+package microsoft_mdm
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+)
+
+// ProviderID is the identifier of Fleet as the MDM provider of the devices.
+const ProviderID = "Fleet"
+
+// AppAuth holds the credentials of the DM protocol digest authentication of a
+// device, configured by the APPAUTH characteristics of its provisioning
+// document.
+type AppAuth struct {
+	// ClientName and ClientSecret authenticate the device to Fleet.
+	ClientName   string
+	ClientSecret string
+	// ServerSecret authenticates Fleet to the device.
+	ServerSecret string
+	// ClientNonce and ServerNonce are the initial nonces of the digests.
+	ClientNonce string
+	ServerNonce string
+}
+
+// NewAppAuth generates random credentials for the device. They are not kept
+// by Fleet, which authenticates the management sessions with the identity
+// certificate of the device, but the provisioning document requires them.
+func NewAppAuth(deviceID string) (AppAuth, error) {
+	auth := AppAuth{ClientName: deviceID}
+	for _, v := range []*string{&auth.ClientSecret, &auth.ServerSecret, &auth.ClientNonce, &auth.ServerNonce} {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return AppAuth{}, fmt.Errorf("generate app auth credentials: %w", err)
+		}
+		*v = base64.StdEncoding.EncodeToString(b)
+	}
+	return auth, nil
+}
+
+// wapProvisioningDoc is the provisioning document returned to a device when
+// it enrolls, it configures the certificates and the MDM client of the device.
+type wapProvisioningDoc struct {
+	XMLName         xml.Name            `xml:"wap-provisioningdoc"`
+	Version         string              `xml:"version,attr"`
+	Characteristics []wapCharacteristic `xml:"characteristic"`
+}
+
+type wapCharacteristic struct {
+	Type            string              `xml:"type,attr"`
+	Params          []wapParam          `xml:"parm"`
+	Characteristics []wapCharacteristic `xml:"characteristic"`
+}
+
+type wapParam struct {
+	Name     string `xml:"name,attr"`
+	Value    string `xml:"value,attr,omitempty"`
+	Datatype string `xml:"datatype,attr,omitempty"`
+}
+
+// NewProvisioningDoc returns the provisioning document of a device, which
+// installs the certificate authority and the identity certificate of the
+// device, and configures its MDM client to connect to the management URL.
+//
+// The identity certificate is installed in the store of the user for a "Full"
+// enrollment, in the store of the device otherwise.
+func NewProvisioningDoc(identityCert, deviceCert *x509.Certificate, deviceID, enrollmentType, managementURL string, auth AppAuth) ([]byte, error) {
+	store := "System"
+	if enrollmentType == EnrollmentTypeFull {
+		store = "User"
+	}
+	searchCriteria := fmt.Sprintf("Subject=%s&Stores=%s",
+		url.QueryEscape("CN="+deviceID), url.QueryEscape(`MY\`+store))
+
+	doc := wapProvisioningDoc{
+		Version: "1.1",
+		Characteristics: []wapCharacteristic{
+			{
+				Type: "CertificateStore",
+				Characteristics: []wapCharacteristic{
+					{
+						Type: "Root",
+						Characteristics: []wapCharacteristic{{
+							Type: "System",
+							Characteristics: []wapCharacteristic{
+								encodedCertCharacteristic(identityCert),
+							},
+						}},
+					},
+					{
+						Type: "My",
+						Characteristics: []wapCharacteristic{{
+							Type: store,
+							Characteristics: []wapCharacteristic{
+								encodedCertCharacteristic(deviceCert),
+								{Type: "PrivateKeyContainer"},
+							},
+						}},
+					},
+				},
+			},
+			{
+				Type: "APPLICATION",
+				Params: []wapParam{
+					{Name: "APPID", Value: "w7"},
+					{Name: "PROVIDER-ID", Value: ProviderID},
+					{Name: "NAME", Value: ProviderID},
+					{Name: "ADDR", Value: managementURL},
+					{Name: "CONNRETRYFREQ", Value: "6"},
+					{Name: "INITIALBACKOFFTIME", Value: "30000"},
+					{Name: "MAXBACKOFFTIME", Value: "120000"},
+					{Name: "BACKCOMPATRETRYDISABLED"},
+					{Name: "DEFAULTENCODING", Value: SyncMLContentType},
+					{Name: "SSLCLIENTCERTSEARCHCRITERIA", Value: searchCriteria},
+				},
+				Characteristics: []wapCharacteristic{
+					{
+						Type: "APPAUTH",
+						Params: []wapParam{
+							{Name: "AAUTHLEVEL", Value: "CLIENT"},
+							{Name: "AAUTHTYPE", Value: "DIGEST"},
+							{Name: "AAUTHSECRET", Value: auth.ServerSecret},
+							{Name: "AAUTHDATA", Value: auth.ServerNonce},
+						},
+					},
+					{
+						Type: "APPAUTH",
+						Params: []wapParam{
+							{Name: "AAUTHLEVEL", Value: "APPSRV"},
+							{Name: "AAUTHTYPE", Value: "DIGEST"},
+							{Name: "AAUTHNAME", Value: auth.ClientName},
+							{Name: "AAUTHSECRET", Value: auth.ClientSecret},
+							{Name: "AAUTHDATA", Value: auth.ClientNonce},
+						},
+					},
+				},
+			},
+			{
+				Type: "DMClient",
+				Characteristics: []wapCharacteristic{{
+					Type: "Provider",
+					Characteristics: []wapCharacteristic{{
+						Type: ProviderID,
+						Params: []wapParam{
+							{Name: "EntDMID", Value: deviceID, Datatype: "string"},
+						},
+						Characteristics: []wapCharacteristic{{
+							Type: "Poll",
+							Params: []wapParam{
+								{Name: "NumberOfFirstRetries", Value: "8", Datatype: "integer"},
+								{Name: "IntervalForFirstSetOfRetries", Value: "15", Datatype: "integer"},
+								{Name: "NumberOfSecondRetries", Value: "5", Datatype: "integer"},
+								{Name: "IntervalForSecondSetOfRetries", Value: "3", Datatype: "integer"},
+								{Name: "NumberOfRemainingScheduledRetries", Value: "0", Datatype: "integer"},
+								{Name: "IntervalForRemainingScheduledRetries", Value: "1560", Datatype: "integer"},
+								{Name: "PollOnLogin", Value: "true", Datatype: "boolean"},
+							},
+						}},
+					}},
+				}},
+			},
+		},
+	}
+
+	b, err := xml.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("marshal provisioning document: %w", err)
+	}
+	return b, nil
+}
+
+func encodedCertCharacteristic(cert *x509.Certificate) wapCharacteristic {
+	return wapCharacteristic{
+		Type: CertThumbprint(cert),
+		Params: []wapParam{
+			{Name: "EncodedCertificate", Value: base64.StdEncoding.EncodeToString(cert.Raw)},
+		},
+	}
+}
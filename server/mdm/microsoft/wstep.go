@@ -0,0 +1,140 @@
+package microsoft_mdm
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // the certificate thumbprints of Windows are SHA-1 hashes
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// wstepCertValidity is the validity of the identity certificates issued
+	// to the devices.
+	wstepCertValidity = 365 * 24 * time.Hour
+	// wstepCertRenewalPeriod is the period before the expiration of an
+	// identity certificate during which the device renews it.
+	wstepCertRenewalPeriod = 14 * 24 * time.Hour
+	// wstepMinimalKeyLength is the minimal length of the keys of the
+	// certificate signing requests.
+	wstepMinimalKeyLength = 2048
+)
+
+// CertManager issues the identity certificates of the Windows devices that
+// enroll in Fleet's MDM, signed by the WSTEP identity certificate authority.
+type CertManager struct {
+	identityCert *x509.Certificate
+	identityKey  crypto.Signer
+}
+
+// NewCertManager returns a CertManager that signs the identity certificates
+// with the provided certificate authority, whose leaf must be parsed.
+func NewCertManager(cert *tls.Certificate) (*CertManager, error) {
+	if cert == nil || cert.Leaf == nil {
+		return nil, errors.New("missing WSTEP identity certificate")
+	}
+	key, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("WSTEP identity private key can't sign certificates")
+	}
+	return &CertManager{identityCert: cert.Leaf, identityKey: key}, nil
+}
+
+// IdentityCert returns the certificate of the certificate authority.
+func (m *CertManager) IdentityCert() *x509.Certificate {
+	return m.identityCert
+}
+
+// SignClientCSR verifies the certificate signing request of a device and
+// issues its identity certificate, whose subject common name is the device ID.
+func (m *CertManager) SignClientCSR(csr *x509.CertificateRequest, deviceID string, now time.Time) (*x509.Certificate, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %w", err)
+	}
+	if key, ok := csr.PublicKey.(*rsa.PublicKey); ok && key.N.BitLen() < wstepMinimalKeyLength {
+		return nil, fmt.Errorf("CSR key is too short: %d bits", key.N.BitLen())
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generate serial number: %w", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: deviceID},
+		// leave some room for the clock skew of the devices
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(wstepCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, m.identityCert, csr.PublicKey, m.identityKey)
+	if err != nil {
+		return nil, fmt.Errorf("sign certificate: %w", err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+// VerifyClientCert verifies that the TLS client certificate of a device was
+// issued by the certificate authority, is valid at the given time and can be
+// used for client authentication.
+func (m *CertManager) VerifyClientCert(cert *x509.Certificate, now time.Time) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(m.identityCert)
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return fmt.Errorf("verify client certificate: %w", err)
+	}
+	return nil
+}
+
+// NewWSTEPCACertKey generates a new self-signed certificate authority for the
+// WSTEP identity certificates.
+func NewWSTEPCACertKey() (*x509.Certificate, *rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "FleetDM Identity CA", Organization: []string{"fleet-ca"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// CertThumbprint returns the thumbprint of the certificate as used by
+// Windows, the uppercase hexadecimal SHA-1 hash of its DER encoding.
+func CertThumbprint(cert *x509.Certificate) string {
+	sum := sha1.Sum(cert.Raw) //nolint:gosec
+	return strings.ToUpper(fmt.Sprintf("%x", sum))
+}
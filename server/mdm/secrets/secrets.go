@@ -0,0 +1,188 @@
+// Package secrets resolves the secrets referenced by the MDM configuration
+// profiles from an external secrets manager, so that the profiles stored in
+// Fleet's database only hold references to them. The values of the secrets
+// are sent to the hosts in the InstallProfile commands, which are redacted
+// once all the hosts they target have reported their result; only the
+// versions of the secrets are stored, to detect their rotation.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/fleetdm/fleet/v4/pkg/fleethttp"
+	"github.com/fleetdm/fleet/v4/server/config"
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+)
+
+// Names of the supported secrets managers.
+const (
+	ProviderVault = "vault"
+	ProviderAWS   = "aws"
+)
+
+// Secret is the current value of a secret and the version of the secrets
+// manager that holds it, the version changes when the secret is rotated.
+type Secret struct {
+	Value   string
+	Version string
+}
+
+// Store retrieves secrets by name.
+type Store interface {
+	// GetSecret returns the current version of the secret with the given name.
+	GetSecret(ctx context.Context, name string) (*Secret, error)
+}
+
+// New returns the Store of the secrets manager configured in cfg, or nil if
+// no secrets manager is configured.
+func New(cfg config.MDMSecretsConfig) (Store, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case ProviderVault:
+		return newVaultStore(cfg)
+	case ProviderAWS:
+		return newAWSStore(cfg)
+	default:
+		return nil, fmt.Errorf("invalid secrets provider %q, must be %q or %q", cfg.Provider, ProviderVault, ProviderAWS)
+	}
+}
+
+// vaultStore reads the secrets from a HashiCorp Vault KV v2 secrets engine.
+// The value of a secret is the "value" key of the Vault secret at the path
+// made of the prefix and the secret's name.
+type vaultStore struct {
+	client  *http.Client
+	baseURL *url.URL
+	token   string
+	mount   string
+	prefix  string
+}
+
+func newVaultStore(cfg config.MDMSecretsConfig) (*vaultStore, error) {
+	if cfg.VaultAddress == "" || cfg.VaultToken == "" {
+		return nil, errors.New("vault secrets provider requires an address and a token")
+	}
+	baseURL, err := url.Parse(cfg.VaultAddress)
+	if err != nil {
+		return nil, fmt.Errorf("parse vault address: %w", err)
+	}
+	mount := cfg.VaultMount
+	if mount == "" {
+		mount = "secret"
+	}
+	return &vaultStore{
+		client:  fleethttp.NewClient(),
+		baseURL: baseURL,
+		token:   cfg.VaultToken,
+		mount:   strings.Trim(mount, "/"),
+		prefix:  cfg.Prefix,
+	}, nil
+}
+
+func (s *vaultStore) GetSecret(ctx context.Context, name string) (*Secret, error) {
+	u := *s.baseURL
+	u.Path = path.Join(u.Path, "v1", s.mount, "data", s.prefix+name)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "create vault request")
+	}
+	req.Header.Set("X-Vault-Token", s.token)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "vault request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ctxerr.Errorf(ctx, "vault secret %s: unexpected status %s", name, resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data     map[string]string `json:"data"`
+			Metadata struct {
+				Version int `json:"version"`
+			} `json:"metadata"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "decode vault response")
+	}
+	value, ok := body.Data.Data["value"]
+	if !ok {
+		return nil, ctxerr.Errorf(ctx, "vault secret %s has no value key", name)
+	}
+	return &Secret{Value: value, Version: strconv.Itoa(body.Data.Metadata.Version)}, nil
+}
+
+// awsStore reads the secrets from AWS Secrets Manager. The value of a secret
+// is the string value of the AWS secret whose id is made of the prefix and
+// the secret's name.
+type awsStore struct {
+	client secretsmanageriface.SecretsManagerAPI
+	prefix string
+}
+
+func newAWSStore(cfg config.MDMSecretsConfig) (*awsStore, error) {
+	conf := &aws.Config{}
+
+	// Use default auth provider if no static credentials were provided
+	if cfg.AWSAccessKeyID != "" && cfg.AWSSecretAccessKey != "" {
+		conf.Credentials = credentials.NewStaticCredentials(cfg.AWSAccessKeyID, cfg.AWSSecretAccessKey, "")
+	}
+	if cfg.AWSEndpointURL != "" {
+		conf.Endpoint = &cfg.AWSEndpointURL
+	}
+	if cfg.AWSRegion != "" {
+		conf.Region = &cfg.AWSRegion
+	}
+
+	sess, err := session.NewSession(conf)
+	if err != nil {
+		return nil, fmt.Errorf("create AWS Secrets Manager client: %w", err)
+	}
+
+	// Assume role if configured
+	if cfg.AWSStsAssumeRoleArn != "" {
+		conf.Credentials = stscreds.NewCredentials(sess, cfg.AWSStsAssumeRoleArn)
+		sess, err = session.NewSession(conf)
+		if err != nil {
+			return nil, fmt.Errorf("create AWS Secrets Manager client: %w", err)
+		}
+	}
+
+	return &awsStore{
+		client: secretsmanager.New(sess),
+		prefix: cfg.Prefix,
+	}, nil
+}
+
+func (s *awsStore) GetSecret(ctx context.Context, name string) (*Secret, error) {
+	out, err := s.client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(s.prefix + name),
+	})
+	if err != nil {
+		return nil, ctxerr.Wrapf(ctx, err, "get aws secret %s", name)
+	}
+	if out.SecretString == nil {
+		return nil, ctxerr.Errorf(ctx, "aws secret %s has no string value", name)
+	}
+	return &Secret{Value: *out.SecretString, Version: aws.StringValue(out.VersionId)}, nil
+}
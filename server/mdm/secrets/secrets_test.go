@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/fleetdm/fleet/v4/server/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	s, err := New(config.MDMSecretsConfig{})
+	require.NoError(t, err)
+	require.Nil(t, s)
+
+	_, err = New(config.MDMSecretsConfig{Provider: "nope"})
+	require.ErrorContains(t, err, `invalid secrets provider "nope"`)
+
+	_, err = New(config.MDMSecretsConfig{Provider: ProviderVault})
+	require.ErrorContains(t, err, "requires an address and a token")
+
+	s, err = New(config.MDMSecretsConfig{Provider: ProviderVault, VaultAddress: "https://vault.example.com", VaultToken: "tok"})
+	require.NoError(t, err)
+	require.IsType(t, &vaultStore{}, s)
+}
+
+func TestVaultStore(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "tok" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/data/fleet/WIFI_PSK":
+			_, _ = w.Write([]byte(`{"data": {"data": {"value": "s3cr3t"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/data/fleet/NO_VALUE":
+			_, _ = w.Write([]byte(`{"data": {"data": {"other": "x"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := New(config.MDMSecretsConfig{
+		Provider:     ProviderVault,
+		Prefix:       "fleet/",
+		VaultAddress: srv.URL,
+		VaultToken:   "tok",
+		VaultMount:   "/kv/",
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	v, err := s.GetSecret(ctx, "WIFI_PSK")
+	require.NoError(t, err)
+	require.Equal(t, &Secret{Value: "s3cr3t", Version: "3"}, v)
+
+	_, err = s.GetSecret(ctx, "NO_VALUE")
+	require.ErrorContains(t, err, "has no value key")
+
+	_, err = s.GetSecret(ctx, "MISSING")
+	require.ErrorContains(t, err, "404")
+}
+
+type mockSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	secrets map[string]*string
+}
+
+func (m *mockSecretsManager) GetSecretValueWithContext(ctx aws.Context, in *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	v, ok := m.secrets[*in.SecretId]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: v, VersionId: aws.String("v1")}, nil
+}
+
+func TestAWSStore(t *testing.T) {
+	s := &awsStore{
+		client: &mockSecretsManager{secrets: map[string]*string{
+			"fleet/WIFI_PSK": aws.String("s3cr3t"),
+			"fleet/BINARY":   nil,
+		}},
+		prefix: "fleet/",
+	}
+
+	ctx := context.Background()
+	v, err := s.GetSecret(ctx, "WIFI_PSK")
+	require.NoError(t, err)
+	require.Equal(t, &Secret{Value: "s3cr3t", Version: "v1"}, v)
+
+	_, err = s.GetSecret(ctx, "BINARY")
+	require.ErrorContains(t, err, "has no string value")
+
+	_, err = s.GetSecret(ctx, "MISSING")
+	require.ErrorContains(t, err, "not found")
+}
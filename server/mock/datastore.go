@@ -8,6 +8,7 @@ import (
 
 //go:generate mockimpl -o datastore_mock.go "s *DataStore" "fleet.Datastore"
 //go:generate mockimpl -o datastore_installers.go "s *InstallerStore" "fleet.InstallerStore"
+//go:generate mockimpl -o mdmassets/store.go "s *MDMAssetStore" "fleet.MDMAssetStore"
 //go:generate mockimpl -o nanomdm/storage.go "s *Storage" "github.com/micromdm/nanomdm/storage.AllStorage"
 //go:generate mockimpl -o nanodep/storage.go "s *Storage" "github.com/micromdm/nanodep/storage.AllStorage"
 //go:generate mockimpl -o scep/depot.go "d *Depot" "depot.Depot"
@@ -168,10 +168,14 @@ type CleanupIncomingHostsFunc func(ctx context.Context, now time.Time) ([]uint,
 
 type GenerateHostStatusStatisticsFunc func(ctx context.Context, filter fleet.TeamFilter, now time.Time, platform *string, lowDiskSpace *int) (*fleet.HostSummary, error)
 
+type GenerateHostMDMStatisticsFunc func(ctx context.Context, filter fleet.TeamFilter, platform *string) (*fleet.HostSummaryMDM, error)
+
 type HostIDsByNameFunc func(ctx context.Context, filter fleet.TeamFilter, hostnames []string) ([]uint, error)
 
 type HostIDsByOSIDFunc func(ctx context.Context, osID uint, offset int, limit int) ([]uint, error)
 
+type HostIDsByTeamFunc func(ctx context.Context, teamID uint, limit int) ([]uint, error)
+
 type HostIDsByOSVersionFunc func(ctx context.Context, osVersion fleet.OSVersion, offset int, limit int) ([]uint, error)
 
 type HostByIdentifierFunc func(ctx context.Context, identifier string) (*fleet.Host, error)
@@ -190,10 +194,38 @@ type ListHostDeviceMappingFunc func(ctx context.Context, id uint) ([]*fleet.Host
 
 type ListHostBatteriesFunc func(ctx context.Context, id uint) ([]*fleet.HostBattery, error)
 
+type ListHostCustomAttributesFunc func(ctx context.Context, hostID uint) ([]*fleet.HostCustomAttribute, error)
+
+type ListHostCustomAttributesByHostUUIDsFunc func(ctx context.Context, hostUUIDs []string) (map[string]map[string]string, error)
+
+type SetHostCustomAttributesFunc func(ctx context.Context, hostID uint, attrs map[string]*string, source string) error
+
+type GetHostAssignedUserFunc func(ctx context.Context, hostID uint) (*fleet.HostAssignedUser, error)
+
+type ListHostAssignedUsersByHostUUIDsFunc func(ctx context.Context, hostUUIDs []string) (map[string]*fleet.HostAssignedUser, error)
+
+type SetHostAssignedUserFunc func(ctx context.Context, hostID uint, user *fleet.HostAssignedUser) error
+
+type GetHostMDMAppleDEPProfileOverrideFunc func(ctx context.Context, hostID uint) (*fleet.HostMDMAppleDEPProfileOverride, error)
+
+type SetHostMDMAppleDEPProfileOverrideFunc func(ctx context.Context, hostID uint, profile *json.RawMessage) error
+
+type ListHostMDMAppleDEPProfileOverridesBySerialsFunc func(ctx context.Context, serials []string) (map[string]*fleet.HostMDMAppleDEPProfileOverride, error)
+
+type ListPendingHostMDMAppleDEPProfileOverridesFunc func(ctx context.Context) ([]*fleet.HostMDMAppleDEPProfileOverride, error)
+
+type SetHostMDMAppleDEPProfileOverridesPendingFunc func(ctx context.Context, hostIDs []uint) error
+
+type SetHostMDMAppleDEPProfileOverrideAssignedFunc func(ctx context.Context, hostID uint, profileUUID string) error
+
 type LoadHostByDeviceAuthTokenFunc func(ctx context.Context, authToken string, tokenTTL time.Duration) (*fleet.Host, error)
 
 type SetOrUpdateDeviceAuthTokenFunc func(ctx context.Context, hostID uint, authToken string) error
 
+type NewHostQuarantineFunc func(ctx context.Context, q *fleet.HostQuarantine) (*fleet.HostQuarantine, error)
+
+type DeleteHostQuarantineFunc func(ctx context.Context, id uint) error
+
 type FailingPoliciesCountFunc func(ctx context.Context, host *fleet.Host) (uint, error)
 
 type ListPoliciesForHostFunc func(ctx context.Context, host *fleet.Host) ([]*fleet.HostPolicy, error)
@@ -206,6 +238,8 @@ type GetHostMDMFunc func(ctx context.Context, hostID uint) (*fleet.HostMDM, erro
 
 type GetHostMDMCheckinInfoFunc func(ctx context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error)
 
+type MarkHostMDMCheckedInFunc func(ctx context.Context, hostUUID string, t time.Time) error
+
 type AggregatedMunkiVersionFunc func(ctx context.Context, teamID *uint) ([]fleet.AggregatedMunkiVersion, time.Time, error)
 
 type AggregatedMunkiIssuesFunc func(ctx context.Context, teamID *uint) ([]fleet.AggregatedMunkiIssue, time.Time, error)
@@ -250,6 +284,8 @@ type DestroyAllSessionsForUserFunc func(ctx context.Context, id uint) error
 
 type MarkSessionAccessedFunc func(ctx context.Context, session *fleet.Session) error
 
+type MarkSessionReauthenticatedFunc func(ctx context.Context, session *fleet.Session) error
+
 type NewAppConfigFunc func(ctx context.Context, info *fleet.AppConfig) (*fleet.AppConfig, error)
 
 type AppConfigFunc func(ctx context.Context) (*fleet.AppConfig, error)
@@ -298,6 +334,8 @@ type TeamFunc func(ctx context.Context, tid uint) (*fleet.Team, error)
 
 type DeleteTeamFunc func(ctx context.Context, tid uint) error
 
+type TeamDeletionReportFunc func(ctx context.Context, tid uint) (*fleet.TeamDeletionReport, error)
+
 type TeamByNameFunc func(ctx context.Context, name string) (*fleet.Team, error)
 
 type ListTeamsFunc func(ctx context.Context, filter fleet.TeamFilter, opt fleet.ListOptions) ([]*fleet.Team, error)
@@ -354,6 +392,16 @@ type ListActivitiesFunc func(ctx context.Context, opt fleet.ListActivitiesOption
 
 type MarkActivitiesAsStreamedFunc func(ctx context.Context, activityIDs []uint) error
 
+type NewSecurityAuditLogEntryFunc func(ctx context.Context, entry *fleet.SecurityAuditLogEntry) error
+
+type ListSecurityAuditLogFunc func(ctx context.Context, opt fleet.ListOptions) ([]*fleet.SecurityAuditLogEntry, *fleet.PaginationMetadata, error)
+
+type ListSecurityAuditLogToStreamFunc func(ctx context.Context, limit uint) ([]*fleet.SecurityAuditLogEntry, error)
+
+type MarkSecurityAuditLogAsStreamedFunc func(ctx context.Context, ids []uint) error
+
+type VerifySecurityAuditLogFunc func(ctx context.Context) (*fleet.SecurityAuditLogVerification, error)
+
 type ShouldSendStatisticsFunc func(ctx context.Context, frequency time.Duration, config config.FleetConfig) (fleet.StatisticsPayload, bool, error)
 
 type RecordStatisticsSentFunc func(ctx context.Context) error
@@ -376,6 +424,8 @@ type DeleteGlobalPoliciesFunc func(ctx context.Context, ids []uint) ([]uint, err
 
 type PolicyQueriesForHostFunc func(ctx context.Context, host *fleet.Host) (map[string]string, error)
 
+type PolicyMDMConditionsFunc func(ctx context.Context, policyIDs []uint) (map[uint]fleet.PolicyMDMConditions, error)
+
 type AsyncBatchInsertPolicyMembershipFunc func(ctx context.Context, batch []fleet.PolicyMembershipResult) error
 
 type AsyncBatchUpdatePolicyTimestampFunc func(ctx context.Context, ids []uint, ts time.Time) error
@@ -418,7 +468,11 @@ type GetLatestCronStatsFunc func(ctx context.Context, name string) ([]fleet.Cron
 
 type InsertCronStatsFunc func(ctx context.Context, statsType fleet.CronStatsType, name string, instance string, status fleet.CronStatsStatus) (int, error)
 
-type UpdateCronStatsFunc func(ctx context.Context, id int, status fleet.CronStatsStatus) error
+type UpdateCronStatsFunc func(ctx context.Context, id int, status fleet.CronStatsStatus, cronErrors fleet.CronScheduleErrors) error
+
+type GetLatestCompletedCronStatsFunc func(ctx context.Context, name string) (*fleet.CronStats, error)
+
+type ListLatestCompletedCronStatsFunc func(ctx context.Context, name string, limit int) ([]fleet.CronStats, error)
 
 type UpdateAllCronStatsForInstanceFunc func(ctx context.Context, instance string, fromStatus fleet.CronStatsStatus, toStatus fleet.CronStatsStatus) error
 
@@ -480,10 +534,22 @@ type SetHostsDiskEncryptionKeyStatusFunc func(ctx context.Context, hostIDs []uin
 
 type GetHostDiskEncryptionKeyFunc func(ctx context.Context, hostID uint) (*fleet.HostDiskEncryptionKey, error)
 
+type NewHostDiskEncryptionKeyAccessFunc func(ctx context.Context, access *fleet.HostDiskEncryptionKeyAccess) error
+
+type ListHostDiskEncryptionKeyAccessesFunc func(ctx context.Context, hostID uint, opt fleet.ListOptions) ([]*fleet.HostDiskEncryptionKeyAccess, *fleet.PaginationMetadata, error)
+
 type SetDiskEncryptionResetStatusFunc func(ctx context.Context, hostID uint, status bool) error
 
+type RequestMDMAppleFileVaultKeyRotationFunc func(ctx context.Context, teamID *uint) (int, error)
+
+type RequestMDMAppleFileVaultKeyRotationForTargetFunc func(ctx context.Context, targetID uint) (int, error)
+
+type NotifyMDMAppleFileVaultKeyRotationsFunc func(ctx context.Context, limit int) (int, error)
+
 type SetOrUpdateHostOrbitInfoFunc func(ctx context.Context, hostID uint, version string) error
 
+type SetOrUpdateHostOSUpdatesFunc func(ctx context.Context, hostID uint, osVersion string, pendingUpdates uint) error
+
 type ReplaceHostDeviceMappingFunc func(ctx context.Context, id uint, mappings []*fleet.HostDeviceMapping) error
 
 type ReplaceHostBatteriesFunc func(ctx context.Context, id uint, mappings []*fleet.HostBattery) error
@@ -500,8 +566,14 @@ type NewJobFunc func(ctx context.Context, job *fleet.Job) (*fleet.Job, error)
 
 type GetQueuedJobsFunc func(ctx context.Context, maxNumJobs int) ([]*fleet.Job, error)
 
+type GetJobFunc func(ctx context.Context, id uint) (*fleet.Job, error)
+
+type ListJobsFunc func(ctx context.Context, name string, state fleet.JobState, opt fleet.ListOptions) ([]*fleet.Job, error)
+
 type UpdateJobFunc func(ctx context.Context, id uint, job *fleet.Job) (*fleet.Job, error)
 
+type UpdateJobProgressFunc func(ctx context.Context, id uint, hostsDone int, hostsCount int) error
+
 type InnoDBStatusFunc func(ctx context.Context) (string, error)
 
 type ProcessListFunc func(ctx context.Context) ([]fleet.MySQLProcess, error)
@@ -516,6 +588,32 @@ type InsertOSVulnerabilitiesFunc func(ctx context.Context, vulnerabilities []fle
 
 type DeleteOSVulnerabilitiesFunc func(ctx context.Context, vulnerabilities []fleet.OSVulnerability) error
 
+type CreateScimUserFunc func(ctx context.Context, user *fleet.ScimUser) (*fleet.ScimUser, error)
+
+type ScimUserByIDFunc func(ctx context.Context, id uint) (*fleet.ScimUser, error)
+
+type ListScimUsersFunc func(ctx context.Context, opts fleet.ScimListOptions) ([]*fleet.ScimUser, int, error)
+
+type ReplaceScimUserFunc func(ctx context.Context, user *fleet.ScimUser) error
+
+type DeleteScimUserFunc func(ctx context.Context, id uint) error
+
+type CreateScimGroupFunc func(ctx context.Context, group *fleet.ScimGroup) (*fleet.ScimGroup, error)
+
+type ScimGroupByIDFunc func(ctx context.Context, id uint) (*fleet.ScimGroup, error)
+
+type ListScimGroupsFunc func(ctx context.Context, opts fleet.ScimListOptions) ([]*fleet.ScimGroup, int, error)
+
+type ReplaceScimGroupFunc func(ctx context.Context, group *fleet.ScimGroup) error
+
+type DeleteScimGroupFunc func(ctx context.Context, id uint) error
+
+type ScimGroupNamesForUserNameFunc func(ctx context.Context, userName string) ([]string, error)
+
+type SetScimLastRequestFunc func(ctx context.Context, req *fleet.ScimLastRequest) error
+
+type ScimLastRequestFunc func(ctx context.Context) (*fleet.ScimLastRequest, error)
+
 type NewMDMAppleConfigProfileFunc func(ctx context.Context, p fleet.MDMAppleConfigProfile) (*fleet.MDMAppleConfigProfile, error)
 
 type BulkUpsertMDMAppleConfigProfilesFunc func(ctx context.Context, payload []*fleet.MDMAppleConfigProfile) error
@@ -526,6 +624,12 @@ type ListMDMAppleConfigProfilesFunc func(ctx context.Context, teamID *uint) ([]*
 
 type DeleteMDMAppleConfigProfileFunc func(ctx context.Context, profileID uint) error
 
+type ListMDMAppleConfigProfilesByIDsOrIdentifiersFunc func(ctx context.Context, profileIDs []uint, identifiers []string) ([]*fleet.MDMAppleConfigProfile, error)
+
+type DeleteMDMAppleConfigProfilesFunc func(ctx context.Context, profileIDs []uint) error
+
+type CopyMDMAppleConfigProfileToTeamsFunc func(ctx context.Context, cp *fleet.MDMAppleConfigProfile, teamIDs []uint) ([]*fleet.MDMAppleConfigProfile, error)
+
 type DeleteMDMAppleConfigProfileByTeamAndIdentifierFunc func(ctx context.Context, teamID *uint, profileIdentifier string) error
 
 type GetHostMDMProfilesFunc func(ctx context.Context, hostUUID string) ([]fleet.HostMDMAppleProfile, error)
@@ -540,6 +644,10 @@ type ListMDMAppleEnrollmentProfilesFunc func(ctx context.Context) ([]*fleet.MDMA
 
 type GetMDMAppleCommandResultsFunc func(ctx context.Context, commandUUID string) ([]*fleet.MDMAppleCommandResult, error)
 
+type RedactMDMAppleCommandResultFunc func(ctx context.Context, hostUUID string, commandUUID string, redactedResult []byte) error
+
+type RedactMDMAppleCommandFunc func(ctx context.Context, commandUUID string, redactedCommand string) error
+
 type ListMDMAppleCommandsFunc func(ctx context.Context, tmFilter fleet.TeamFilter, listOpts *fleet.MDMAppleCommandListOptions) ([]*fleet.MDMAppleCommand, error)
 
 type NewMDMAppleInstallerFunc func(ctx context.Context, name string, size int64, manifest string, installer []byte, urlToken string) (*fleet.MDMAppleInstaller, error)
@@ -556,10 +664,58 @@ type ListMDMAppleInstallersFunc func(ctx context.Context) ([]fleet.MDMAppleInsta
 
 type BatchSetMDMAppleProfilesFunc func(ctx context.Context, tmID *uint, profiles []*fleet.MDMAppleConfigProfile) error
 
+type BatchSetMDMAppleProfileExclusionsFunc func(ctx context.Context, tmID *uint, exclusions []*fleet.MDMAppleProfileExclusion) error
+
+type BatchSetMDMAppleTeamsProfilesFunc func(ctx context.Context, teams []*fleet.MDMAppleTeamProfiles) error
+
+type ListMDMAppleProfileExclusionsFunc func(ctx context.Context, tmID *uint) ([]*fleet.MDMAppleProfileExclusion, error)
+
+type NewMDMHostTargetFunc func(ctx context.Context, target *fleet.MDMHostTarget) (*fleet.MDMHostTarget, error)
+
+type MDMHostTargetFunc func(ctx context.Context, id uint) (*fleet.MDMHostTarget, error)
+
+type ListMDMHostTargetsFunc func(ctx context.Context) ([]*fleet.MDMHostTarget, error)
+
+type DeleteMDMHostTargetFunc func(ctx context.Context, id uint) error
+
+type ListMDMHostTargetHostsFunc func(ctx context.Context, id uint) ([]*fleet.MDMHostTargetHost, error)
+
+type SetMDMAppleConfigProfileTargetFunc func(ctx context.Context, profileID uint, targetID *uint) error
+
+type SetMDMAppleConfigProfileMacOSVersionsFunc func(ctx context.Context, profileID uint, min string, max string) error
+
+type SetMDMApplePolicyActionFunc func(ctx context.Context, action *fleet.MDMApplePolicyAction) error
+
+type GetMDMApplePolicyActionFunc func(ctx context.Context, policyID uint) (*fleet.MDMApplePolicyAction, error)
+
+type DeleteMDMApplePolicyActionFunc func(ctx context.Context, policyID uint) error
+
+type ListMDMApplePolicyActionsFunc func(ctx context.Context) ([]*fleet.MDMApplePolicyAction, error)
+
+type ListMDMApplePolicyActionPolicyIDsFunc func(ctx context.Context) ([]uint, error)
+
+type ListMDMApplePolicyActionHostsFunc func(ctx context.Context, hostIDs []uint) ([]*fleet.MDMApplePolicyActionHost, error)
+
+type GetMDMAppleProfilesPreviewFunc func(ctx context.Context, tmID *uint, profiles []*fleet.MDMAppleConfigProfile) (*fleet.MDMAppleProfilesPreview, error)
+
 type MDMAppleListDevicesFunc func(ctx context.Context) ([]fleet.MDMAppleDevice, error)
 
 type IngestMDMAppleDevicesFromDEPSyncFunc func(ctx context.Context, devices []godep.Device) (int64, error)
 
+type SetMDMAppleDEPTeamAssignmentsFunc func(ctx context.Context, assignments []*fleet.MDMAppleDEPTeamAssignment) error
+
+type ListMDMAppleDEPTeamAssignmentsFunc func(ctx context.Context) ([]*fleet.MDMAppleDEPTeamAssignment, error)
+
+type GetMDMAppleDEPTeamAssignmentsFunc func(ctx context.Context, serials []string) (map[string]uint, error)
+
+type GetHostMDMAppleDEPDeviceFunc func(ctx context.Context, hostID uint) (*fleet.HostMDMAppleDEPDevice, error)
+
+type SetMDMAppleDEPDevicesOrgNameFunc func(ctx context.Context, orgName string) error
+
+type ListMDMAppleDEPHostsReportFunc func(ctx context.Context, filter fleet.TeamFilter, opts fleet.ListOptions) ([]*fleet.MDMAppleDEPHostReportItem, error)
+
+type ListMDMAppleDEPAssignmentDiscrepanciesFunc func(ctx context.Context, filter fleet.TeamFilter, defaultFamilies []string) ([]*fleet.MDMAppleDEPAssignmentDiscrepancy, error)
+
 type IngestMDMAppleDeviceFromCheckinFunc func(ctx context.Context, mdmHost fleet.MDMAppleHostDetails) error
 
 type GetNanoMDMEnrollmentFunc func(ctx context.Context, id string) (*fleet.NanoEnrollment, error)
@@ -572,20 +728,176 @@ type ListMDMAppleProfilesToInstallFunc func(ctx context.Context) ([]*fleet.MDMAp
 
 type ListMDMAppleProfilesToRemoveFunc func(ctx context.Context) ([]*fleet.MDMAppleProfilePayload, error)
 
+type ListMDMAppleReconciliationPlanFunc func(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleReconciliationPlanItem, error)
+
+type ListMDMAppleHostProfilesWithQueuedCommandFunc func(ctx context.Context, opType fleet.MDMAppleOperationType, hostUUIDs []string) ([]*fleet.MDMAppleProfilePayload, error)
+
 type BulkUpsertMDMAppleHostProfilesFunc func(ctx context.Context, payload []*fleet.MDMAppleBulkUpsertHostProfilePayload) error
 
 type BulkSetPendingMDMAppleHostProfilesFunc func(ctx context.Context, hostIDs []uint, teamIDs []uint, profileIDs []uint, hostUUIDs []string) error
 
+type ListMDMAppleBulkSetPendingHostUUIDsFunc func(ctx context.Context, hostIDs []uint, teamIDs []uint, profileIDs []uint) ([]string, error)
+
+type CountMDMAppleBulkSetPendingHostsFunc func(ctx context.Context, hostIDs []uint, teamIDs []uint, profileIDs []uint, limit int) (int, error)
+
 type GetMDMAppleProfilesContentsFunc func(ctx context.Context, profileIDs []uint) (map[uint]mobileconfig.Mobileconfig, error)
 
+type ListMDMAppleProfilesReferencingSecretsFunc func(ctx context.Context) (map[uint]mobileconfig.Mobileconfig, error)
+
+type SetMDMAppleProfileSecretsChecksumFunc func(ctx context.Context, profileID uint, checksum []byte) error
+
 type UpdateOrDeleteHostMDMAppleProfileFunc func(ctx context.Context, profile *fleet.HostMDMAppleProfile) error
 
+type UpdateHostMDMAppleProfileRetryableFailureFunc func(ctx context.Context, profile *fleet.HostMDMAppleProfile, gracePeriod fleet.MacOSProfileFailureGracePeriod) (failed bool, err error)
+
+type ListTeamsMacOSProfileRetryPoliciesFunc func(ctx context.Context) (map[uint]fleet.MacOSProfileRetryPolicy, error)
+
+type RetryFailedHostMDMAppleProfilesFunc func(ctx context.Context, teamID uint, policy fleet.MacOSProfileRetryPolicy) (int64, error)
+
+type ResetFailedHostMDMAppleProfileFunc func(ctx context.Context, profileID uint, hostUUID string, teamID uint) (int64, error)
+
+type SetMDMAppleEnrollmentPendingApprovalFunc func(ctx context.Context, hostUUID string) (bool, error)
+
+type ApproveMDMAppleEnrollmentFunc func(ctx context.Context, hostUUID string) error
+
+type PurgeHostMDMAppleDataFunc func(ctx context.Context, hostID uint, hostUUID string) error
+
+type ListMDMApplePendingEnrollmentsFunc func(ctx context.Context, filter fleet.TeamFilter) ([]*fleet.MDMApplePendingEnrollment, error)
+
+type FilterMDMAppleHostUUIDsPendingApprovalFunc func(ctx context.Context, hostUUIDs []string) ([]string, error)
+
+type RequestMDMAppleProfileChangesFunc func(ctx context.Context, changes []*fleet.MDMAppleProfileChange, expiresAt time.Time) ([]*fleet.MDMAppleProfileChange, error)
+
+type ExpireMDMAppleProfileChangesFunc func(ctx context.Context) error
+
+type ListMDMAppleProfileChangesFunc func(ctx context.Context, status fleet.MDMAppleProfileChangeStatus) ([]*fleet.MDMAppleProfileChange, error)
+
+type ReviewMDMAppleProfileChangeFunc func(ctx context.Context, id uint, status fleet.MDMAppleProfileChangeStatus) (*fleet.MDMAppleProfileChange, error)
+
+type UpsertMDMAppleBlockedEnrollmentFunc func(ctx context.Context, blocked *fleet.MDMAppleBlockedEnrollment) error
+
+type DeleteMDMAppleBlockedEnrollmentsFunc func(ctx context.Context, serials []string) error
+
+type ListMDMAppleBlockedEnrollmentsFunc func(ctx context.Context, filter fleet.TeamFilter) ([]*fleet.MDMAppleBlockedEnrollment, error)
+
+type ListMDMAppleHostUUIDsToRefreshCertificatesFunc func(ctx context.Context, interval time.Duration, limit int) ([]string, error)
+
+type SetMDMAppleHostCertificatesRefreshRequestedFunc func(ctx context.Context, hostUUIDs []string) error
+
+type ReplaceHostMDMAppleCertificatesFunc func(ctx context.Context, hostUUID string, certs []*fleet.HostMDMCertificate) error
+
+type UpdateHostMDMAppleManagedCertificatesFunc func(ctx context.Context, hostUUID string, certs []*fleet.HostMDMCertificate) error
+
+type ListHostMDMAppleCertificatesFunc func(ctx context.Context, hostUUID string, opt fleet.ListOptions) ([]*fleet.HostMDMCertificate, *fleet.PaginationMetadata, error)
+
+type ListMDMAppleHostUUIDsToRefreshProfileListFunc func(ctx context.Context, interval time.Duration, limit int) ([]string, error)
+
+type SetMDMAppleHostProfileListRefreshRequestedFunc func(ctx context.Context, hostUUIDs []string) error
+
+type ListHostMDMAppleProfileInstallsFunc func(ctx context.Context, hostUUID string) ([]*fleet.HostMDMAppleProfileInstall, error)
+
+type UpdateHostMDMAppleProfilesInstalledPayloadUUIDsFunc func(ctx context.Context, hostUUID string, payloadUUIDs map[uint]string) error
+
+type SetHostMDMAppleProfilesToReinstallFunc func(ctx context.Context, hostUUID string, profileIDs []uint) error
+
+type NewMDMAppleServerURLMigrationFunc func(ctx context.Context, oldServerURL string, newServerURL string) (*fleet.MDMAppleServerURLMigration, error)
+
+type GetLatestMDMAppleServerURLMigrationFunc func(ctx context.Context) (*fleet.MDMAppleServerURLMigration, error)
+
+type ListMDMAppleServerURLMigrationPendingHostsFunc func(ctx context.Context, migrationID uint, limit int) ([]string, error)
+
+type SetMDMAppleServerURLMigrationHostsSentFunc func(ctx context.Context, migrationID uint, hostUUIDs []string, commandUUID string) error
+
+type SetMDMAppleServerURLMigrationHostResultFunc func(ctx context.Context, hostUUID string, commandUUID string, status fleet.MDMAppleServerURLMigrationHostStatus, detail string) error
+
+type ListMDMAppleSCEPCertificatesFunc func(ctx context.Context, filter fleet.TeamFilter, opt fleet.MDMAppleSCEPCertificateListOptions) ([]*fleet.MDMAppleSCEPCertificate, *fleet.PaginationMetadata, error)
+
+type ListMDMAppleSCEPIdentityCertificatesFunc func(ctx context.Context, afterSerial int64, limit uint) ([]*fleet.MDMAppleSCEPIdentityCertificate, error)
+
+type SetupMDMAppleConfigAssetsFunc func(ctx context.Context, assets []fleet.MDMConfigAsset) error
+
+type GetMDMConfigAssetsByNameFunc func(ctx context.Context, names []fleet.MDMConfigAssetName) (map[fleet.MDMConfigAssetName]fleet.MDMConfigAsset, error)
+
+type SetOrUpdateHostOrbitMDMStatusFunc func(ctx context.Context, hostID uint, status *fleet.OrbitMDMEnrollmentStatus) error
+
+type ListMDMAppleEnrollmentMismatchesFunc func(ctx context.Context, teamID *uint, mdmServerURL string) ([]*fleet.MDMAppleEnrollmentMismatch, error)
+
+type ListMDMAppleHostsMissingFleetdFunc func(ctx context.Context, teamID *uint, enrolledBefore time.Time) ([]*fleet.MDMAppleHostMissingFleetd, error)
+
+type ReleaseMDMAppleDEPHostFunc func(ctx context.Context, serial string) (hostID uint, deleted bool, err error)
+
+type ListMDMAppleNanoEnrollmentsFunc func(ctx context.Context, opt fleet.MDMAppleNanoEnrollmentListOptions) ([]*fleet.MDMAppleNanoEnrollment, *fleet.PaginationMetadata, error)
+
+type GetMDMAppleNanoEnrollmentFunc func(ctx context.Context, id string) (*fleet.MDMAppleNanoEnrollment, error)
+
+type DeleteMDMAppleNanoEnrollmentFunc func(ctx context.Context, id string) error
+
+type LinkMDMAppleNanoEnrollmentToHostFunc func(ctx context.Context, id string, hostID uint) error
+
 type GetMDMAppleCommandRequestTypeFunc func(ctx context.Context, commandUUID string) (string, error)
 
 type GetMDMAppleHostsProfilesSummaryFunc func(ctx context.Context, teamID *uint) (*fleet.MDMAppleConfigProfilesSummary, error)
 
+type ListMDMAppleHostsProfilesSummaryByTeamFunc func(ctx context.Context) ([]*fleet.MDMAppleConfigProfilesTeamSummary, error)
+
+type ListMDMAppleReservedPayloadConflictsFunc func(ctx context.Context, teamID *uint) ([]fleet.MDMAppleReservedPayloadConflict, error)
+
+type ListMDMAppleProfileIdentifierConflictsFunc func(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error)
+
 type InsertMDMIdPAccountFunc func(ctx context.Context, account *fleet.MDMIdPAccount) error
 
+type GetMDMIdPAccountFunc func(ctx context.Context, uuid string) (*fleet.MDMIdPAccount, error)
+
+type AssociateHostMDMIdPAccountFunc func(ctx context.Context, hostUUID string, accountUUID string) error
+
+type SetHostMDMActivationLockBypassCodeFunc func(ctx context.Context, hostUUID string, base64Encrypted string) error
+
+type GetHostMDMActivationLockBypassCodeFunc func(ctx context.Context, hostUUID string) (*fleet.HostMDMActivationLockBypassCode, error)
+
+type SetHostMDMAppleLockPINFunc func(ctx context.Context, pin *fleet.HostMDMAppleLockPIN) error
+
+type GetHostMDMAppleLockPINFunc func(ctx context.Context, hostUUID string) (*fleet.HostMDMAppleLockPIN, error)
+
+type GetHostMDMIdPAccountFunc func(ctx context.Context, hostUUID string) (*fleet.MDMIdPAccount, error)
+
+type ListMDMAppleHostQueuedCommandsFunc func(ctx context.Context, hostUUID string) ([]*fleet.MDMAppleHostQueuedCommand, error)
+
+type CancelMDMAppleHostQueuedCommandFunc func(ctx context.Context, hostUUID string, commandUUID string) error
+
+type ListMDMAppleHostCommandsFunc func(ctx context.Context, hostUUID string, opt fleet.ListOptions) ([]*fleet.MDMAppleHostCommand, *fleet.PaginationMetadata, error)
+
+type CleanupHostMDMAppleCommandHistoryFunc func(ctx context.Context, now time.Time) (int64, error)
+
+type NewMDMAppleEnrollmentLinkFunc func(ctx context.Context, link *fleet.MDMAppleEnrollmentLink) (*fleet.MDMAppleEnrollmentLink, error)
+
+type GetMDMAppleEnrollmentLinkFunc func(ctx context.Context, id uint) (*fleet.MDMAppleEnrollmentLink, error)
+
+type GetMDMAppleEnrollmentLinkByTokenFunc func(ctx context.Context, token string) (*fleet.MDMAppleEnrollmentLink, error)
+
+type ConsumeMDMAppleEnrollmentLinkFunc func(ctx context.Context, token string, hostUUID string) (*fleet.MDMAppleEnrollmentLink, error)
+
+type NewMDMAppleSSOEnrollmentTokenFunc func(ctx context.Context, token *fleet.MDMAppleSSOEnrollmentToken) (*fleet.MDMAppleSSOEnrollmentToken, error)
+
+type ConsumeMDMAppleSSOEnrollmentTokenFunc func(ctx context.Context, token string) (*fleet.MDMAppleSSOEnrollmentToken, error)
+
+type RevokeMDMAppleSSOEnrollmentTokenFunc func(ctx context.Context, id uint) (*fleet.MDMAppleSSOEnrollmentToken, error)
+
+type ListMDMAppleSSOEnrollmentTokensFunc func(ctx context.Context, opt fleet.ListOptions) ([]*fleet.MDMAppleSSOEnrollmentToken, error)
+
+type EnsureMDMAppleTeamEnrollmentTokenFunc func(ctx context.Context, teamID *uint, token string) (*fleet.MDMAppleTeamEnrollmentToken, error)
+
+type SetMDMAppleTeamEnrollmentTokenFunc func(ctx context.Context, teamID *uint, token string) (*fleet.MDMAppleTeamEnrollmentToken, error)
+
+type GetMDMAppleTeamEnrollmentTokenByTokenFunc func(ctx context.Context, token string) (*fleet.MDMAppleTeamEnrollmentToken, error)
+
+type RecordMDMApplePushResultsFunc func(ctx context.Context, results []fleet.MDMApplePushResult) error
+
+type ListMDMAppleInvalidPushTokenEnrollmentIDsFunc func(ctx context.Context, enrollmentIDs []string) ([]string, error)
+
+type ClearMDMApplePushFailuresFunc func(ctx context.Context, enrollmentID string) error
+
+type GetHostMDMApplePushFailureFunc func(ctx context.Context, hostUUID string) (*fleet.HostMDMApplePushFailure, error)
+
 type GetMDMAppleFileVaultSummaryFunc func(ctx context.Context, teamID *uint) (*fleet.MDMAppleFileVaultSummary, error)
 
 type InsertMDMAppleBootstrapPackageFunc func(ctx context.Context, bp *fleet.MDMAppleBootstrapPackage) error
@@ -594,12 +906,44 @@ type DeleteMDMAppleBootstrapPackageFunc func(ctx context.Context, teamID uint) e
 
 type GetMDMAppleBootstrapPackageMetaFunc func(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackage, error)
 
+type ListMDMAppleBootstrapPackagesFunc func(ctx context.Context) ([]*fleet.MDMAppleBootstrapPackage, error)
+
+type SetMDMAppleBootstrapPackageSignatureStatusFunc func(ctx context.Context, token string, status fleet.MDMAppleBootstrapPackageSignatureStatus, detail string, verifiedAt time.Time) error
+
 type GetMDMAppleBootstrapPackageBytesFunc func(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackage, error)
 
 type GetMDMAppleBootstrapPackageSummaryFunc func(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackageSummary, error)
 
+type GetMDMAppleOSUpdatesSummaryFunc func(ctx context.Context, teamID uint) (*fleet.MDMAppleOSUpdatesSummary, error)
+
 type RecordHostBootstrapPackageFunc func(ctx context.Context, commandUUID string, hostUUID string) error
 
+type NewMDMAppleBootstrapPackageUploadFunc func(ctx context.Context, upload *fleet.MDMAppleBootstrapPackageUpload) (*fleet.MDMAppleBootstrapPackageUpload, error)
+
+type GetMDMAppleBootstrapPackageUploadFunc func(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackageUpload, error)
+
+type AppendMDMAppleBootstrapPackageUploadChunkFunc func(ctx context.Context, token string, offset int64, chunk []byte) (*fleet.MDMAppleBootstrapPackageUpload, error)
+
+type GetMDMAppleBootstrapPackageUploadChunkFunc func(ctx context.Context, uploadID uint, index int) ([]byte, error)
+
+type CommitMDMAppleBootstrapPackageUploadFunc func(ctx context.Context, token string, pkgToken string) error
+
+type CleanupMDMAppleBootstrapPackageUploadsFunc func(ctx context.Context, now time.Time) (int64, error)
+
+type ListMDMAppleAssetTokensFunc func(ctx context.Context) ([]string, error)
+
+type ListHostMDMChangesFunc func(ctx context.Context, since uint64, limit int) ([]*fleet.HostMDMChange, error)
+
+type CleanupHostMDMChangesFunc func(ctx context.Context, now time.Time) (int64, error)
+
+type GetMDMPostureScoreHostCountsFunc func(ctx context.Context, teamID *uint) (*fleet.MDMPostureScoreHostCounts, error)
+
+type SaveMDMPostureScoresFunc func(ctx context.Context, scores []*fleet.MDMPostureScore) error
+
+type ListMDMPostureScoresFunc func(ctx context.Context, teamID *uint, since time.Time) ([]*fleet.MDMPostureScore, error)
+
+type CleanupMDMPostureScoresFunc func(ctx context.Context, now time.Time) (int64, error)
+
 type GetHostMDMMacOSSetupFunc func(ctx context.Context, hostID uint) (*fleet.HostMDMMacOSSetup, error)
 
 type MDMAppleGetEULAMetadataFunc func(ctx context.Context) (*fleet.MDMAppleEULA, error)
@@ -618,6 +962,14 @@ type DeleteMDMAppleSetupAssistantFunc func(ctx context.Context, teamID *uint) er
 
 type SetMDMAppleSetupAssistantProfileUUIDFunc func(ctx context.Context, teamID *uint, profileUUID string) error
 
+type MDMWindowsInsertEnrolledDeviceFunc func(ctx context.Context, device *fleet.MDMWindowsEnrolledDevice) error
+
+type MDMWindowsGetEnrolledDeviceFunc func(ctx context.Context, mdmDeviceID string) (*fleet.MDMWindowsEnrolledDevice, error)
+
+type MDMWindowsDeleteEnrolledDeviceFunc func(ctx context.Context, mdmDeviceID string) error
+
+type MDMWindowsLinkEnrolledDeviceToHostFunc func(ctx context.Context, mdmDeviceID string, hostUUID string) error
+
 type DataStore struct {
 	HealthCheckFunc        HealthCheckFunc
 	HealthCheckFuncInvoked bool
@@ -847,12 +1199,18 @@ type DataStore struct {
 	GenerateHostStatusStatisticsFunc        GenerateHostStatusStatisticsFunc
 	GenerateHostStatusStatisticsFuncInvoked bool
 
+	GenerateHostMDMStatisticsFunc        GenerateHostMDMStatisticsFunc
+	GenerateHostMDMStatisticsFuncInvoked bool
+
 	HostIDsByNameFunc        HostIDsByNameFunc
 	HostIDsByNameFuncInvoked bool
 
 	HostIDsByOSIDFunc        HostIDsByOSIDFunc
 	HostIDsByOSIDFuncInvoked bool
 
+	HostIDsByTeamFunc        HostIDsByTeamFunc
+	HostIDsByTeamFuncInvoked bool
+
 	HostIDsByOSVersionFunc        HostIDsByOSVersionFunc
 	HostIDsByOSVersionFuncInvoked bool
 
@@ -880,12 +1238,54 @@ type DataStore struct {
 	ListHostBatteriesFunc        ListHostBatteriesFunc
 	ListHostBatteriesFuncInvoked bool
 
+	ListHostCustomAttributesFunc        ListHostCustomAttributesFunc
+	ListHostCustomAttributesFuncInvoked bool
+
+	ListHostCustomAttributesByHostUUIDsFunc        ListHostCustomAttributesByHostUUIDsFunc
+	ListHostCustomAttributesByHostUUIDsFuncInvoked bool
+
+	SetHostCustomAttributesFunc        SetHostCustomAttributesFunc
+	SetHostCustomAttributesFuncInvoked bool
+
+	GetHostAssignedUserFunc        GetHostAssignedUserFunc
+	GetHostAssignedUserFuncInvoked bool
+
+	ListHostAssignedUsersByHostUUIDsFunc        ListHostAssignedUsersByHostUUIDsFunc
+	ListHostAssignedUsersByHostUUIDsFuncInvoked bool
+
+	SetHostAssignedUserFunc        SetHostAssignedUserFunc
+	SetHostAssignedUserFuncInvoked bool
+
+	GetHostMDMAppleDEPProfileOverrideFunc        GetHostMDMAppleDEPProfileOverrideFunc
+	GetHostMDMAppleDEPProfileOverrideFuncInvoked bool
+
+	SetHostMDMAppleDEPProfileOverrideFunc        SetHostMDMAppleDEPProfileOverrideFunc
+	SetHostMDMAppleDEPProfileOverrideFuncInvoked bool
+
+	ListHostMDMAppleDEPProfileOverridesBySerialsFunc        ListHostMDMAppleDEPProfileOverridesBySerialsFunc
+	ListHostMDMAppleDEPProfileOverridesBySerialsFuncInvoked bool
+
+	ListPendingHostMDMAppleDEPProfileOverridesFunc        ListPendingHostMDMAppleDEPProfileOverridesFunc
+	ListPendingHostMDMAppleDEPProfileOverridesFuncInvoked bool
+
+	SetHostMDMAppleDEPProfileOverridesPendingFunc        SetHostMDMAppleDEPProfileOverridesPendingFunc
+	SetHostMDMAppleDEPProfileOverridesPendingFuncInvoked bool
+
+	SetHostMDMAppleDEPProfileOverrideAssignedFunc        SetHostMDMAppleDEPProfileOverrideAssignedFunc
+	SetHostMDMAppleDEPProfileOverrideAssignedFuncInvoked bool
+
 	LoadHostByDeviceAuthTokenFunc        LoadHostByDeviceAuthTokenFunc
 	LoadHostByDeviceAuthTokenFuncInvoked bool
 
 	SetOrUpdateDeviceAuthTokenFunc        SetOrUpdateDeviceAuthTokenFunc
 	SetOrUpdateDeviceAuthTokenFuncInvoked bool
 
+	NewHostQuarantineFunc        NewHostQuarantineFunc
+	NewHostQuarantineFuncInvoked bool
+
+	DeleteHostQuarantineFunc        DeleteHostQuarantineFunc
+	DeleteHostQuarantineFuncInvoked bool
+
 	FailingPoliciesCountFunc        FailingPoliciesCountFunc
 	FailingPoliciesCountFuncInvoked bool
 
@@ -904,6 +1304,9 @@ type DataStore struct {
 	GetHostMDMCheckinInfoFunc        GetHostMDMCheckinInfoFunc
 	GetHostMDMCheckinInfoFuncInvoked bool
 
+	MarkHostMDMCheckedInFunc        MarkHostMDMCheckedInFunc
+	MarkHostMDMCheckedInFuncInvoked bool
+
 	AggregatedMunkiVersionFunc        AggregatedMunkiVersionFunc
 	AggregatedMunkiVersionFuncInvoked bool
 
@@ -970,6 +1373,9 @@ type DataStore struct {
 	MarkSessionAccessedFunc        MarkSessionAccessedFunc
 	MarkSessionAccessedFuncInvoked bool
 
+	MarkSessionReauthenticatedFunc        MarkSessionReauthenticatedFunc
+	MarkSessionReauthenticatedFuncInvoked bool
+
 	NewAppConfigFunc        NewAppConfigFunc
 	NewAppConfigFuncInvoked bool
 
@@ -1042,6 +1448,9 @@ type DataStore struct {
 	DeleteTeamFunc        DeleteTeamFunc
 	DeleteTeamFuncInvoked bool
 
+	TeamDeletionReportFunc        TeamDeletionReportFunc
+	TeamDeletionReportFuncInvoked bool
+
 	TeamByNameFunc        TeamByNameFunc
 	TeamByNameFuncInvoked bool
 
@@ -1126,6 +1535,21 @@ type DataStore struct {
 	MarkActivitiesAsStreamedFunc        MarkActivitiesAsStreamedFunc
 	MarkActivitiesAsStreamedFuncInvoked bool
 
+	NewSecurityAuditLogEntryFunc        NewSecurityAuditLogEntryFunc
+	NewSecurityAuditLogEntryFuncInvoked bool
+
+	ListSecurityAuditLogFunc        ListSecurityAuditLogFunc
+	ListSecurityAuditLogFuncInvoked bool
+
+	ListSecurityAuditLogToStreamFunc        ListSecurityAuditLogToStreamFunc
+	ListSecurityAuditLogToStreamFuncInvoked bool
+
+	MarkSecurityAuditLogAsStreamedFunc        MarkSecurityAuditLogAsStreamedFunc
+	MarkSecurityAuditLogAsStreamedFuncInvoked bool
+
+	VerifySecurityAuditLogFunc        VerifySecurityAuditLogFunc
+	VerifySecurityAuditLogFuncInvoked bool
+
 	ShouldSendStatisticsFunc        ShouldSendStatisticsFunc
 	ShouldSendStatisticsFuncInvoked bool
 
@@ -1159,6 +1583,9 @@ type DataStore struct {
 	PolicyQueriesForHostFunc        PolicyQueriesForHostFunc
 	PolicyQueriesForHostFuncInvoked bool
 
+	PolicyMDMConditionsFunc        PolicyMDMConditionsFunc
+	PolicyMDMConditionsFuncInvoked bool
+
 	AsyncBatchInsertPolicyMembershipFunc        AsyncBatchInsertPolicyMembershipFunc
 	AsyncBatchInsertPolicyMembershipFuncInvoked bool
 
@@ -1225,6 +1652,12 @@ type DataStore struct {
 	UpdateCronStatsFunc        UpdateCronStatsFunc
 	UpdateCronStatsFuncInvoked bool
 
+	GetLatestCompletedCronStatsFunc        GetLatestCompletedCronStatsFunc
+	GetLatestCompletedCronStatsFuncInvoked bool
+
+	ListLatestCompletedCronStatsFunc        ListLatestCompletedCronStatsFunc
+	ListLatestCompletedCronStatsFuncInvoked bool
+
 	UpdateAllCronStatsForInstanceFunc        UpdateAllCronStatsForInstanceFunc
 	UpdateAllCronStatsForInstanceFuncInvoked bool
 
@@ -1315,12 +1748,30 @@ type DataStore struct {
 	GetHostDiskEncryptionKeyFunc        GetHostDiskEncryptionKeyFunc
 	GetHostDiskEncryptionKeyFuncInvoked bool
 
+	NewHostDiskEncryptionKeyAccessFunc        NewHostDiskEncryptionKeyAccessFunc
+	NewHostDiskEncryptionKeyAccessFuncInvoked bool
+
+	ListHostDiskEncryptionKeyAccessesFunc        ListHostDiskEncryptionKeyAccessesFunc
+	ListHostDiskEncryptionKeyAccessesFuncInvoked bool
+
 	SetDiskEncryptionResetStatusFunc        SetDiskEncryptionResetStatusFunc
 	SetDiskEncryptionResetStatusFuncInvoked bool
 
+	RequestMDMAppleFileVaultKeyRotationFunc        RequestMDMAppleFileVaultKeyRotationFunc
+	RequestMDMAppleFileVaultKeyRotationFuncInvoked bool
+
+	RequestMDMAppleFileVaultKeyRotationForTargetFunc        RequestMDMAppleFileVaultKeyRotationForTargetFunc
+	RequestMDMAppleFileVaultKeyRotationForTargetFuncInvoked bool
+
+	NotifyMDMAppleFileVaultKeyRotationsFunc        NotifyMDMAppleFileVaultKeyRotationsFunc
+	NotifyMDMAppleFileVaultKeyRotationsFuncInvoked bool
+
 	SetOrUpdateHostOrbitInfoFunc        SetOrUpdateHostOrbitInfoFunc
 	SetOrUpdateHostOrbitInfoFuncInvoked bool
 
+	SetOrUpdateHostOSUpdatesFunc        SetOrUpdateHostOSUpdatesFunc
+	SetOrUpdateHostOSUpdatesFuncInvoked bool
+
 	ReplaceHostDeviceMappingFunc        ReplaceHostDeviceMappingFunc
 	ReplaceHostDeviceMappingFuncInvoked bool
 
@@ -1345,9 +1796,18 @@ type DataStore struct {
 	GetQueuedJobsFunc        GetQueuedJobsFunc
 	GetQueuedJobsFuncInvoked bool
 
+	GetJobFunc        GetJobFunc
+	GetJobFuncInvoked bool
+
+	ListJobsFunc        ListJobsFunc
+	ListJobsFuncInvoked bool
+
 	UpdateJobFunc        UpdateJobFunc
 	UpdateJobFuncInvoked bool
 
+	UpdateJobProgressFunc        UpdateJobProgressFunc
+	UpdateJobProgressFuncInvoked bool
+
 	InnoDBStatusFunc        InnoDBStatusFunc
 	InnoDBStatusFuncInvoked bool
 
@@ -1369,6 +1829,45 @@ type DataStore struct {
 	DeleteOSVulnerabilitiesFunc        DeleteOSVulnerabilitiesFunc
 	DeleteOSVulnerabilitiesFuncInvoked bool
 
+	CreateScimUserFunc        CreateScimUserFunc
+	CreateScimUserFuncInvoked bool
+
+	ScimUserByIDFunc        ScimUserByIDFunc
+	ScimUserByIDFuncInvoked bool
+
+	ListScimUsersFunc        ListScimUsersFunc
+	ListScimUsersFuncInvoked bool
+
+	ReplaceScimUserFunc        ReplaceScimUserFunc
+	ReplaceScimUserFuncInvoked bool
+
+	DeleteScimUserFunc        DeleteScimUserFunc
+	DeleteScimUserFuncInvoked bool
+
+	CreateScimGroupFunc        CreateScimGroupFunc
+	CreateScimGroupFuncInvoked bool
+
+	ScimGroupByIDFunc        ScimGroupByIDFunc
+	ScimGroupByIDFuncInvoked bool
+
+	ListScimGroupsFunc        ListScimGroupsFunc
+	ListScimGroupsFuncInvoked bool
+
+	ReplaceScimGroupFunc        ReplaceScimGroupFunc
+	ReplaceScimGroupFuncInvoked bool
+
+	DeleteScimGroupFunc        DeleteScimGroupFunc
+	DeleteScimGroupFuncInvoked bool
+
+	ScimGroupNamesForUserNameFunc        ScimGroupNamesForUserNameFunc
+	ScimGroupNamesForUserNameFuncInvoked bool
+
+	SetScimLastRequestFunc        SetScimLastRequestFunc
+	SetScimLastRequestFuncInvoked bool
+
+	ScimLastRequestFunc        ScimLastRequestFunc
+	ScimLastRequestFuncInvoked bool
+
 	NewMDMAppleConfigProfileFunc        NewMDMAppleConfigProfileFunc
 	NewMDMAppleConfigProfileFuncInvoked bool
 
@@ -1384,6 +1883,15 @@ type DataStore struct {
 	DeleteMDMAppleConfigProfileFunc        DeleteMDMAppleConfigProfileFunc
 	DeleteMDMAppleConfigProfileFuncInvoked bool
 
+	ListMDMAppleConfigProfilesByIDsOrIdentifiersFunc        ListMDMAppleConfigProfilesByIDsOrIdentifiersFunc
+	ListMDMAppleConfigProfilesByIDsOrIdentifiersFuncInvoked bool
+
+	DeleteMDMAppleConfigProfilesFunc        DeleteMDMAppleConfigProfilesFunc
+	DeleteMDMAppleConfigProfilesFuncInvoked bool
+
+	CopyMDMAppleConfigProfileToTeamsFunc        CopyMDMAppleConfigProfileToTeamsFunc
+	CopyMDMAppleConfigProfileToTeamsFuncInvoked bool
+
 	DeleteMDMAppleConfigProfileByTeamAndIdentifierFunc        DeleteMDMAppleConfigProfileByTeamAndIdentifierFunc
 	DeleteMDMAppleConfigProfileByTeamAndIdentifierFuncInvoked bool
 
@@ -1405,6 +1913,12 @@ type DataStore struct {
 	GetMDMAppleCommandResultsFunc        GetMDMAppleCommandResultsFunc
 	GetMDMAppleCommandResultsFuncInvoked bool
 
+	RedactMDMAppleCommandResultFunc        RedactMDMAppleCommandResultFunc
+	RedactMDMAppleCommandResultFuncInvoked bool
+
+	RedactMDMAppleCommandFunc        RedactMDMAppleCommandFunc
+	RedactMDMAppleCommandFuncInvoked bool
+
 	ListMDMAppleCommandsFunc        ListMDMAppleCommandsFunc
 	ListMDMAppleCommandsFuncInvoked bool
 
@@ -1429,12 +1943,84 @@ type DataStore struct {
 	BatchSetMDMAppleProfilesFunc        BatchSetMDMAppleProfilesFunc
 	BatchSetMDMAppleProfilesFuncInvoked bool
 
+	BatchSetMDMAppleProfileExclusionsFunc        BatchSetMDMAppleProfileExclusionsFunc
+	BatchSetMDMAppleProfileExclusionsFuncInvoked bool
+
+	BatchSetMDMAppleTeamsProfilesFunc        BatchSetMDMAppleTeamsProfilesFunc
+	BatchSetMDMAppleTeamsProfilesFuncInvoked bool
+
+	ListMDMAppleProfileExclusionsFunc        ListMDMAppleProfileExclusionsFunc
+	ListMDMAppleProfileExclusionsFuncInvoked bool
+
+	NewMDMHostTargetFunc        NewMDMHostTargetFunc
+	NewMDMHostTargetFuncInvoked bool
+
+	MDMHostTargetFunc        MDMHostTargetFunc
+	MDMHostTargetFuncInvoked bool
+
+	ListMDMHostTargetsFunc        ListMDMHostTargetsFunc
+	ListMDMHostTargetsFuncInvoked bool
+
+	DeleteMDMHostTargetFunc        DeleteMDMHostTargetFunc
+	DeleteMDMHostTargetFuncInvoked bool
+
+	ListMDMHostTargetHostsFunc        ListMDMHostTargetHostsFunc
+	ListMDMHostTargetHostsFuncInvoked bool
+
+	SetMDMAppleConfigProfileTargetFunc        SetMDMAppleConfigProfileTargetFunc
+	SetMDMAppleConfigProfileTargetFuncInvoked bool
+
+	SetMDMAppleConfigProfileMacOSVersionsFunc        SetMDMAppleConfigProfileMacOSVersionsFunc
+	SetMDMAppleConfigProfileMacOSVersionsFuncInvoked bool
+
+	SetMDMApplePolicyActionFunc        SetMDMApplePolicyActionFunc
+	SetMDMApplePolicyActionFuncInvoked bool
+
+	GetMDMApplePolicyActionFunc        GetMDMApplePolicyActionFunc
+	GetMDMApplePolicyActionFuncInvoked bool
+
+	DeleteMDMApplePolicyActionFunc        DeleteMDMApplePolicyActionFunc
+	DeleteMDMApplePolicyActionFuncInvoked bool
+
+	ListMDMApplePolicyActionsFunc        ListMDMApplePolicyActionsFunc
+	ListMDMApplePolicyActionsFuncInvoked bool
+
+	ListMDMApplePolicyActionPolicyIDsFunc        ListMDMApplePolicyActionPolicyIDsFunc
+	ListMDMApplePolicyActionPolicyIDsFuncInvoked bool
+
+	ListMDMApplePolicyActionHostsFunc        ListMDMApplePolicyActionHostsFunc
+	ListMDMApplePolicyActionHostsFuncInvoked bool
+
+	GetMDMAppleProfilesPreviewFunc        GetMDMAppleProfilesPreviewFunc
+	GetMDMAppleProfilesPreviewFuncInvoked bool
+
 	MDMAppleListDevicesFunc        MDMAppleListDevicesFunc
 	MDMAppleListDevicesFuncInvoked bool
 
 	IngestMDMAppleDevicesFromDEPSyncFunc        IngestMDMAppleDevicesFromDEPSyncFunc
 	IngestMDMAppleDevicesFromDEPSyncFuncInvoked bool
 
+	SetMDMAppleDEPTeamAssignmentsFunc        SetMDMAppleDEPTeamAssignmentsFunc
+	SetMDMAppleDEPTeamAssignmentsFuncInvoked bool
+
+	ListMDMAppleDEPTeamAssignmentsFunc        ListMDMAppleDEPTeamAssignmentsFunc
+	ListMDMAppleDEPTeamAssignmentsFuncInvoked bool
+
+	GetMDMAppleDEPTeamAssignmentsFunc        GetMDMAppleDEPTeamAssignmentsFunc
+	GetMDMAppleDEPTeamAssignmentsFuncInvoked bool
+
+	GetHostMDMAppleDEPDeviceFunc        GetHostMDMAppleDEPDeviceFunc
+	GetHostMDMAppleDEPDeviceFuncInvoked bool
+
+	SetMDMAppleDEPDevicesOrgNameFunc        SetMDMAppleDEPDevicesOrgNameFunc
+	SetMDMAppleDEPDevicesOrgNameFuncInvoked bool
+
+	ListMDMAppleDEPHostsReportFunc        ListMDMAppleDEPHostsReportFunc
+	ListMDMAppleDEPHostsReportFuncInvoked bool
+
+	ListMDMAppleDEPAssignmentDiscrepanciesFunc        ListMDMAppleDEPAssignmentDiscrepanciesFunc
+	ListMDMAppleDEPAssignmentDiscrepanciesFuncInvoked bool
+
 	IngestMDMAppleDeviceFromCheckinFunc        IngestMDMAppleDeviceFromCheckinFunc
 	IngestMDMAppleDeviceFromCheckinFuncInvoked bool
 
@@ -1453,27 +2039,261 @@ type DataStore struct {
 	ListMDMAppleProfilesToRemoveFunc        ListMDMAppleProfilesToRemoveFunc
 	ListMDMAppleProfilesToRemoveFuncInvoked bool
 
+	ListMDMAppleReconciliationPlanFunc        ListMDMAppleReconciliationPlanFunc
+	ListMDMAppleReconciliationPlanFuncInvoked bool
+
+	ListMDMAppleHostProfilesWithQueuedCommandFunc        ListMDMAppleHostProfilesWithQueuedCommandFunc
+	ListMDMAppleHostProfilesWithQueuedCommandFuncInvoked bool
+
 	BulkUpsertMDMAppleHostProfilesFunc        BulkUpsertMDMAppleHostProfilesFunc
 	BulkUpsertMDMAppleHostProfilesFuncInvoked bool
 
 	BulkSetPendingMDMAppleHostProfilesFunc        BulkSetPendingMDMAppleHostProfilesFunc
 	BulkSetPendingMDMAppleHostProfilesFuncInvoked bool
 
+	ListMDMAppleBulkSetPendingHostUUIDsFunc        ListMDMAppleBulkSetPendingHostUUIDsFunc
+	ListMDMAppleBulkSetPendingHostUUIDsFuncInvoked bool
+
+	CountMDMAppleBulkSetPendingHostsFunc        CountMDMAppleBulkSetPendingHostsFunc
+	CountMDMAppleBulkSetPendingHostsFuncInvoked bool
+
 	GetMDMAppleProfilesContentsFunc        GetMDMAppleProfilesContentsFunc
 	GetMDMAppleProfilesContentsFuncInvoked bool
 
+	ListMDMAppleProfilesReferencingSecretsFunc        ListMDMAppleProfilesReferencingSecretsFunc
+	ListMDMAppleProfilesReferencingSecretsFuncInvoked bool
+
+	SetMDMAppleProfileSecretsChecksumFunc        SetMDMAppleProfileSecretsChecksumFunc
+	SetMDMAppleProfileSecretsChecksumFuncInvoked bool
+
 	UpdateOrDeleteHostMDMAppleProfileFunc        UpdateOrDeleteHostMDMAppleProfileFunc
 	UpdateOrDeleteHostMDMAppleProfileFuncInvoked bool
 
+	UpdateHostMDMAppleProfileRetryableFailureFunc        UpdateHostMDMAppleProfileRetryableFailureFunc
+	UpdateHostMDMAppleProfileRetryableFailureFuncInvoked bool
+
+	ListTeamsMacOSProfileRetryPoliciesFunc        ListTeamsMacOSProfileRetryPoliciesFunc
+	ListTeamsMacOSProfileRetryPoliciesFuncInvoked bool
+
+	RetryFailedHostMDMAppleProfilesFunc        RetryFailedHostMDMAppleProfilesFunc
+	RetryFailedHostMDMAppleProfilesFuncInvoked bool
+
+	ResetFailedHostMDMAppleProfileFunc        ResetFailedHostMDMAppleProfileFunc
+	ResetFailedHostMDMAppleProfileFuncInvoked bool
+
+	SetMDMAppleEnrollmentPendingApprovalFunc        SetMDMAppleEnrollmentPendingApprovalFunc
+	SetMDMAppleEnrollmentPendingApprovalFuncInvoked bool
+
+	ApproveMDMAppleEnrollmentFunc        ApproveMDMAppleEnrollmentFunc
+	ApproveMDMAppleEnrollmentFuncInvoked bool
+
+	PurgeHostMDMAppleDataFunc        PurgeHostMDMAppleDataFunc
+	PurgeHostMDMAppleDataFuncInvoked bool
+
+	ListMDMApplePendingEnrollmentsFunc        ListMDMApplePendingEnrollmentsFunc
+	ListMDMApplePendingEnrollmentsFuncInvoked bool
+
+	FilterMDMAppleHostUUIDsPendingApprovalFunc        FilterMDMAppleHostUUIDsPendingApprovalFunc
+	FilterMDMAppleHostUUIDsPendingApprovalFuncInvoked bool
+
+	RequestMDMAppleProfileChangesFunc        RequestMDMAppleProfileChangesFunc
+	RequestMDMAppleProfileChangesFuncInvoked bool
+
+	ExpireMDMAppleProfileChangesFunc        ExpireMDMAppleProfileChangesFunc
+	ExpireMDMAppleProfileChangesFuncInvoked bool
+
+	ListMDMAppleProfileChangesFunc        ListMDMAppleProfileChangesFunc
+	ListMDMAppleProfileChangesFuncInvoked bool
+
+	ReviewMDMAppleProfileChangeFunc        ReviewMDMAppleProfileChangeFunc
+	ReviewMDMAppleProfileChangeFuncInvoked bool
+
+	UpsertMDMAppleBlockedEnrollmentFunc        UpsertMDMAppleBlockedEnrollmentFunc
+	UpsertMDMAppleBlockedEnrollmentFuncInvoked bool
+
+	DeleteMDMAppleBlockedEnrollmentsFunc        DeleteMDMAppleBlockedEnrollmentsFunc
+	DeleteMDMAppleBlockedEnrollmentsFuncInvoked bool
+
+	ListMDMAppleBlockedEnrollmentsFunc        ListMDMAppleBlockedEnrollmentsFunc
+	ListMDMAppleBlockedEnrollmentsFuncInvoked bool
+
+	ListMDMAppleHostUUIDsToRefreshCertificatesFunc        ListMDMAppleHostUUIDsToRefreshCertificatesFunc
+	ListMDMAppleHostUUIDsToRefreshCertificatesFuncInvoked bool
+
+	SetMDMAppleHostCertificatesRefreshRequestedFunc        SetMDMAppleHostCertificatesRefreshRequestedFunc
+	SetMDMAppleHostCertificatesRefreshRequestedFuncInvoked bool
+
+	ReplaceHostMDMAppleCertificatesFunc        ReplaceHostMDMAppleCertificatesFunc
+	ReplaceHostMDMAppleCertificatesFuncInvoked bool
+
+	UpdateHostMDMAppleManagedCertificatesFunc        UpdateHostMDMAppleManagedCertificatesFunc
+	UpdateHostMDMAppleManagedCertificatesFuncInvoked bool
+
+	ListHostMDMAppleCertificatesFunc        ListHostMDMAppleCertificatesFunc
+	ListHostMDMAppleCertificatesFuncInvoked bool
+
+	ListMDMAppleHostUUIDsToRefreshProfileListFunc        ListMDMAppleHostUUIDsToRefreshProfileListFunc
+	ListMDMAppleHostUUIDsToRefreshProfileListFuncInvoked bool
+
+	SetMDMAppleHostProfileListRefreshRequestedFunc        SetMDMAppleHostProfileListRefreshRequestedFunc
+	SetMDMAppleHostProfileListRefreshRequestedFuncInvoked bool
+
+	ListHostMDMAppleProfileInstallsFunc        ListHostMDMAppleProfileInstallsFunc
+	ListHostMDMAppleProfileInstallsFuncInvoked bool
+
+	UpdateHostMDMAppleProfilesInstalledPayloadUUIDsFunc        UpdateHostMDMAppleProfilesInstalledPayloadUUIDsFunc
+	UpdateHostMDMAppleProfilesInstalledPayloadUUIDsFuncInvoked bool
+
+	SetHostMDMAppleProfilesToReinstallFunc        SetHostMDMAppleProfilesToReinstallFunc
+	SetHostMDMAppleProfilesToReinstallFuncInvoked bool
+
+	NewMDMAppleServerURLMigrationFunc        NewMDMAppleServerURLMigrationFunc
+	NewMDMAppleServerURLMigrationFuncInvoked bool
+
+	GetLatestMDMAppleServerURLMigrationFunc        GetLatestMDMAppleServerURLMigrationFunc
+	GetLatestMDMAppleServerURLMigrationFuncInvoked bool
+
+	ListMDMAppleServerURLMigrationPendingHostsFunc        ListMDMAppleServerURLMigrationPendingHostsFunc
+	ListMDMAppleServerURLMigrationPendingHostsFuncInvoked bool
+
+	SetMDMAppleServerURLMigrationHostsSentFunc        SetMDMAppleServerURLMigrationHostsSentFunc
+	SetMDMAppleServerURLMigrationHostsSentFuncInvoked bool
+
+	SetMDMAppleServerURLMigrationHostResultFunc        SetMDMAppleServerURLMigrationHostResultFunc
+	SetMDMAppleServerURLMigrationHostResultFuncInvoked bool
+
+	ListMDMAppleSCEPCertificatesFunc        ListMDMAppleSCEPCertificatesFunc
+	ListMDMAppleSCEPCertificatesFuncInvoked bool
+
+	ListMDMAppleSCEPIdentityCertificatesFunc        ListMDMAppleSCEPIdentityCertificatesFunc
+	ListMDMAppleSCEPIdentityCertificatesFuncInvoked bool
+
+	SetupMDMAppleConfigAssetsFunc        SetupMDMAppleConfigAssetsFunc
+	SetupMDMAppleConfigAssetsFuncInvoked bool
+
+	GetMDMConfigAssetsByNameFunc        GetMDMConfigAssetsByNameFunc
+	GetMDMConfigAssetsByNameFuncInvoked bool
+
+	SetOrUpdateHostOrbitMDMStatusFunc        SetOrUpdateHostOrbitMDMStatusFunc
+	SetOrUpdateHostOrbitMDMStatusFuncInvoked bool
+
+	ListMDMAppleEnrollmentMismatchesFunc        ListMDMAppleEnrollmentMismatchesFunc
+	ListMDMAppleEnrollmentMismatchesFuncInvoked bool
+
+	ListMDMAppleHostsMissingFleetdFunc        ListMDMAppleHostsMissingFleetdFunc
+	ListMDMAppleHostsMissingFleetdFuncInvoked bool
+
+	ReleaseMDMAppleDEPHostFunc        ReleaseMDMAppleDEPHostFunc
+	ReleaseMDMAppleDEPHostFuncInvoked bool
+
+	ListMDMAppleNanoEnrollmentsFunc        ListMDMAppleNanoEnrollmentsFunc
+	ListMDMAppleNanoEnrollmentsFuncInvoked bool
+
+	GetMDMAppleNanoEnrollmentFunc        GetMDMAppleNanoEnrollmentFunc
+	GetMDMAppleNanoEnrollmentFuncInvoked bool
+
+	DeleteMDMAppleNanoEnrollmentFunc        DeleteMDMAppleNanoEnrollmentFunc
+	DeleteMDMAppleNanoEnrollmentFuncInvoked bool
+
+	LinkMDMAppleNanoEnrollmentToHostFunc        LinkMDMAppleNanoEnrollmentToHostFunc
+	LinkMDMAppleNanoEnrollmentToHostFuncInvoked bool
+
 	GetMDMAppleCommandRequestTypeFunc        GetMDMAppleCommandRequestTypeFunc
 	GetMDMAppleCommandRequestTypeFuncInvoked bool
 
 	GetMDMAppleHostsProfilesSummaryFunc        GetMDMAppleHostsProfilesSummaryFunc
 	GetMDMAppleHostsProfilesSummaryFuncInvoked bool
 
+	ListMDMAppleHostsProfilesSummaryByTeamFunc        ListMDMAppleHostsProfilesSummaryByTeamFunc
+	ListMDMAppleHostsProfilesSummaryByTeamFuncInvoked bool
+
+	ListMDMAppleReservedPayloadConflictsFunc        ListMDMAppleReservedPayloadConflictsFunc
+	ListMDMAppleReservedPayloadConflictsFuncInvoked bool
+
+	ListMDMAppleProfileIdentifierConflictsFunc        ListMDMAppleProfileIdentifierConflictsFunc
+	ListMDMAppleProfileIdentifierConflictsFuncInvoked bool
+
 	InsertMDMIdPAccountFunc        InsertMDMIdPAccountFunc
 	InsertMDMIdPAccountFuncInvoked bool
 
+	GetMDMIdPAccountFunc        GetMDMIdPAccountFunc
+	GetMDMIdPAccountFuncInvoked bool
+
+	AssociateHostMDMIdPAccountFunc        AssociateHostMDMIdPAccountFunc
+	AssociateHostMDMIdPAccountFuncInvoked bool
+
+	SetHostMDMActivationLockBypassCodeFunc        SetHostMDMActivationLockBypassCodeFunc
+	SetHostMDMActivationLockBypassCodeFuncInvoked bool
+
+	GetHostMDMActivationLockBypassCodeFunc        GetHostMDMActivationLockBypassCodeFunc
+	GetHostMDMActivationLockBypassCodeFuncInvoked bool
+
+	SetHostMDMAppleLockPINFunc        SetHostMDMAppleLockPINFunc
+	SetHostMDMAppleLockPINFuncInvoked bool
+
+	GetHostMDMAppleLockPINFunc        GetHostMDMAppleLockPINFunc
+	GetHostMDMAppleLockPINFuncInvoked bool
+
+	GetHostMDMIdPAccountFunc        GetHostMDMIdPAccountFunc
+	GetHostMDMIdPAccountFuncInvoked bool
+
+	ListMDMAppleHostQueuedCommandsFunc        ListMDMAppleHostQueuedCommandsFunc
+	ListMDMAppleHostQueuedCommandsFuncInvoked bool
+
+	CancelMDMAppleHostQueuedCommandFunc        CancelMDMAppleHostQueuedCommandFunc
+	CancelMDMAppleHostQueuedCommandFuncInvoked bool
+
+	ListMDMAppleHostCommandsFunc        ListMDMAppleHostCommandsFunc
+	ListMDMAppleHostCommandsFuncInvoked bool
+
+	CleanupHostMDMAppleCommandHistoryFunc        CleanupHostMDMAppleCommandHistoryFunc
+	CleanupHostMDMAppleCommandHistoryFuncInvoked bool
+
+	NewMDMAppleEnrollmentLinkFunc        NewMDMAppleEnrollmentLinkFunc
+	NewMDMAppleEnrollmentLinkFuncInvoked bool
+
+	GetMDMAppleEnrollmentLinkFunc        GetMDMAppleEnrollmentLinkFunc
+	GetMDMAppleEnrollmentLinkFuncInvoked bool
+
+	GetMDMAppleEnrollmentLinkByTokenFunc        GetMDMAppleEnrollmentLinkByTokenFunc
+	GetMDMAppleEnrollmentLinkByTokenFuncInvoked bool
+
+	ConsumeMDMAppleEnrollmentLinkFunc        ConsumeMDMAppleEnrollmentLinkFunc
+	ConsumeMDMAppleEnrollmentLinkFuncInvoked bool
+
+	NewMDMAppleSSOEnrollmentTokenFunc        NewMDMAppleSSOEnrollmentTokenFunc
+	NewMDMAppleSSOEnrollmentTokenFuncInvoked bool
+
+	ConsumeMDMAppleSSOEnrollmentTokenFunc        ConsumeMDMAppleSSOEnrollmentTokenFunc
+	ConsumeMDMAppleSSOEnrollmentTokenFuncInvoked bool
+
+	RevokeMDMAppleSSOEnrollmentTokenFunc        RevokeMDMAppleSSOEnrollmentTokenFunc
+	RevokeMDMAppleSSOEnrollmentTokenFuncInvoked bool
+
+	ListMDMAppleSSOEnrollmentTokensFunc        ListMDMAppleSSOEnrollmentTokensFunc
+	ListMDMAppleSSOEnrollmentTokensFuncInvoked bool
+
+	EnsureMDMAppleTeamEnrollmentTokenFunc        EnsureMDMAppleTeamEnrollmentTokenFunc
+	EnsureMDMAppleTeamEnrollmentTokenFuncInvoked bool
+
+	SetMDMAppleTeamEnrollmentTokenFunc        SetMDMAppleTeamEnrollmentTokenFunc
+	SetMDMAppleTeamEnrollmentTokenFuncInvoked bool
+
+	GetMDMAppleTeamEnrollmentTokenByTokenFunc        GetMDMAppleTeamEnrollmentTokenByTokenFunc
+	GetMDMAppleTeamEnrollmentTokenByTokenFuncInvoked bool
+
+	RecordMDMApplePushResultsFunc        RecordMDMApplePushResultsFunc
+	RecordMDMApplePushResultsFuncInvoked bool
+
+	ListMDMAppleInvalidPushTokenEnrollmentIDsFunc        ListMDMAppleInvalidPushTokenEnrollmentIDsFunc
+	ListMDMAppleInvalidPushTokenEnrollmentIDsFuncInvoked bool
+
+	ClearMDMApplePushFailuresFunc        ClearMDMApplePushFailuresFunc
+	ClearMDMApplePushFailuresFuncInvoked bool
+
+	GetHostMDMApplePushFailureFunc        GetHostMDMApplePushFailureFunc
+	GetHostMDMApplePushFailureFuncInvoked bool
+
 	GetMDMAppleFileVaultSummaryFunc        GetMDMAppleFileVaultSummaryFunc
 	GetMDMAppleFileVaultSummaryFuncInvoked bool
 
@@ -1486,15 +2306,63 @@ type DataStore struct {
 	GetMDMAppleBootstrapPackageMetaFunc        GetMDMAppleBootstrapPackageMetaFunc
 	GetMDMAppleBootstrapPackageMetaFuncInvoked bool
 
+	ListMDMAppleBootstrapPackagesFunc        ListMDMAppleBootstrapPackagesFunc
+	ListMDMAppleBootstrapPackagesFuncInvoked bool
+
+	SetMDMAppleBootstrapPackageSignatureStatusFunc        SetMDMAppleBootstrapPackageSignatureStatusFunc
+	SetMDMAppleBootstrapPackageSignatureStatusFuncInvoked bool
+
 	GetMDMAppleBootstrapPackageBytesFunc        GetMDMAppleBootstrapPackageBytesFunc
 	GetMDMAppleBootstrapPackageBytesFuncInvoked bool
 
 	GetMDMAppleBootstrapPackageSummaryFunc        GetMDMAppleBootstrapPackageSummaryFunc
 	GetMDMAppleBootstrapPackageSummaryFuncInvoked bool
 
+	GetMDMAppleOSUpdatesSummaryFunc        GetMDMAppleOSUpdatesSummaryFunc
+	GetMDMAppleOSUpdatesSummaryFuncInvoked bool
+
 	RecordHostBootstrapPackageFunc        RecordHostBootstrapPackageFunc
 	RecordHostBootstrapPackageFuncInvoked bool
 
+	NewMDMAppleBootstrapPackageUploadFunc        NewMDMAppleBootstrapPackageUploadFunc
+	NewMDMAppleBootstrapPackageUploadFuncInvoked bool
+
+	GetMDMAppleBootstrapPackageUploadFunc        GetMDMAppleBootstrapPackageUploadFunc
+	GetMDMAppleBootstrapPackageUploadFuncInvoked bool
+
+	AppendMDMAppleBootstrapPackageUploadChunkFunc        AppendMDMAppleBootstrapPackageUploadChunkFunc
+	AppendMDMAppleBootstrapPackageUploadChunkFuncInvoked bool
+
+	GetMDMAppleBootstrapPackageUploadChunkFunc        GetMDMAppleBootstrapPackageUploadChunkFunc
+	GetMDMAppleBootstrapPackageUploadChunkFuncInvoked bool
+
+	CommitMDMAppleBootstrapPackageUploadFunc        CommitMDMAppleBootstrapPackageUploadFunc
+	CommitMDMAppleBootstrapPackageUploadFuncInvoked bool
+
+	CleanupMDMAppleBootstrapPackageUploadsFunc        CleanupMDMAppleBootstrapPackageUploadsFunc
+	CleanupMDMAppleBootstrapPackageUploadsFuncInvoked bool
+
+	ListMDMAppleAssetTokensFunc        ListMDMAppleAssetTokensFunc
+	ListMDMAppleAssetTokensFuncInvoked bool
+
+	ListHostMDMChangesFunc        ListHostMDMChangesFunc
+	ListHostMDMChangesFuncInvoked bool
+
+	CleanupHostMDMChangesFunc        CleanupHostMDMChangesFunc
+	CleanupHostMDMChangesFuncInvoked bool
+
+	GetMDMPostureScoreHostCountsFunc        GetMDMPostureScoreHostCountsFunc
+	GetMDMPostureScoreHostCountsFuncInvoked bool
+
+	SaveMDMPostureScoresFunc        SaveMDMPostureScoresFunc
+	SaveMDMPostureScoresFuncInvoked bool
+
+	ListMDMPostureScoresFunc        ListMDMPostureScoresFunc
+	ListMDMPostureScoresFuncInvoked bool
+
+	CleanupMDMPostureScoresFunc        CleanupMDMPostureScoresFunc
+	CleanupMDMPostureScoresFuncInvoked bool
+
 	GetHostMDMMacOSSetupFunc        GetHostMDMMacOSSetupFunc
 	GetHostMDMMacOSSetupFuncInvoked bool
 
@@ -1522,6 +2390,18 @@ type DataStore struct {
 	SetMDMAppleSetupAssistantProfileUUIDFunc        SetMDMAppleSetupAssistantProfileUUIDFunc
 	SetMDMAppleSetupAssistantProfileUUIDFuncInvoked bool
 
+	MDMWindowsInsertEnrolledDeviceFunc        MDMWindowsInsertEnrolledDeviceFunc
+	MDMWindowsInsertEnrolledDeviceFuncInvoked bool
+
+	MDMWindowsGetEnrolledDeviceFunc        MDMWindowsGetEnrolledDeviceFunc
+	MDMWindowsGetEnrolledDeviceFuncInvoked bool
+
+	MDMWindowsDeleteEnrolledDeviceFunc        MDMWindowsDeleteEnrolledDeviceFunc
+	MDMWindowsDeleteEnrolledDeviceFuncInvoked bool
+
+	MDMWindowsLinkEnrolledDeviceToHostFunc        MDMWindowsLinkEnrolledDeviceToHostFunc
+	MDMWindowsLinkEnrolledDeviceToHostFuncInvoked bool
+
 	mu sync.Mutex
 }
 
@@ -2057,6 +2937,13 @@ func (s *DataStore) GenerateHostStatusStatistics(ctx context.Context, filter fle
 	return s.GenerateHostStatusStatisticsFunc(ctx, filter, now, platform, lowDiskSpace)
 }
 
+func (s *DataStore) GenerateHostMDMStatistics(ctx context.Context, filter fleet.TeamFilter, platform *string) (*fleet.HostSummaryMDM, error) {
+	s.mu.Lock()
+	s.GenerateHostMDMStatisticsFuncInvoked = true
+	s.mu.Unlock()
+	return s.GenerateHostMDMStatisticsFunc(ctx, filter, platform)
+}
+
 func (s *DataStore) HostIDsByName(ctx context.Context, filter fleet.TeamFilter, hostnames []string) ([]uint, error) {
 	s.mu.Lock()
 	s.HostIDsByNameFuncInvoked = true
@@ -2071,6 +2958,13 @@ func (s *DataStore) HostIDsByOSID(ctx context.Context, osID uint, offset int, li
 	return s.HostIDsByOSIDFunc(ctx, osID, offset, limit)
 }
 
+func (s *DataStore) HostIDsByTeam(ctx context.Context, teamID uint, limit int) ([]uint, error) {
+	s.mu.Lock()
+	s.HostIDsByTeamFuncInvoked = true
+	s.mu.Unlock()
+	return s.HostIDsByTeamFunc(ctx, teamID, limit)
+}
+
 func (s *DataStore) HostIDsByOSVersion(ctx context.Context, osVersion fleet.OSVersion, offset int, limit int) ([]uint, error) {
 	s.mu.Lock()
 	s.HostIDsByOSVersionFuncInvoked = true
@@ -2134,6 +3028,90 @@ func (s *DataStore) ListHostBatteries(ctx context.Context, id uint) ([]*fleet.Ho
 	return s.ListHostBatteriesFunc(ctx, id)
 }
 
+func (s *DataStore) ListHostCustomAttributes(ctx context.Context, hostID uint) ([]*fleet.HostCustomAttribute, error) {
+	s.mu.Lock()
+	s.ListHostCustomAttributesFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListHostCustomAttributesFunc(ctx, hostID)
+}
+
+func (s *DataStore) ListHostCustomAttributesByHostUUIDs(ctx context.Context, hostUUIDs []string) (map[string]map[string]string, error) {
+	s.mu.Lock()
+	s.ListHostCustomAttributesByHostUUIDsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListHostCustomAttributesByHostUUIDsFunc(ctx, hostUUIDs)
+}
+
+func (s *DataStore) SetHostCustomAttributes(ctx context.Context, hostID uint, attrs map[string]*string, source string) error {
+	s.mu.Lock()
+	s.SetHostCustomAttributesFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetHostCustomAttributesFunc(ctx, hostID, attrs, source)
+}
+
+func (s *DataStore) GetHostAssignedUser(ctx context.Context, hostID uint) (*fleet.HostAssignedUser, error) {
+	s.mu.Lock()
+	s.GetHostAssignedUserFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetHostAssignedUserFunc(ctx, hostID)
+}
+
+func (s *DataStore) ListHostAssignedUsersByHostUUIDs(ctx context.Context, hostUUIDs []string) (map[string]*fleet.HostAssignedUser, error) {
+	s.mu.Lock()
+	s.ListHostAssignedUsersByHostUUIDsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListHostAssignedUsersByHostUUIDsFunc(ctx, hostUUIDs)
+}
+
+func (s *DataStore) SetHostAssignedUser(ctx context.Context, hostID uint, user *fleet.HostAssignedUser) error {
+	s.mu.Lock()
+	s.SetHostAssignedUserFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetHostAssignedUserFunc(ctx, hostID, user)
+}
+
+func (s *DataStore) GetHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint) (*fleet.HostMDMAppleDEPProfileOverride, error) {
+	s.mu.Lock()
+	s.GetHostMDMAppleDEPProfileOverrideFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetHostMDMAppleDEPProfileOverrideFunc(ctx, hostID)
+}
+
+func (s *DataStore) SetHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint, profile *json.RawMessage) error {
+	s.mu.Lock()
+	s.SetHostMDMAppleDEPProfileOverrideFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetHostMDMAppleDEPProfileOverrideFunc(ctx, hostID, profile)
+}
+
+func (s *DataStore) ListHostMDMAppleDEPProfileOverridesBySerials(ctx context.Context, serials []string) (map[string]*fleet.HostMDMAppleDEPProfileOverride, error) {
+	s.mu.Lock()
+	s.ListHostMDMAppleDEPProfileOverridesBySerialsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListHostMDMAppleDEPProfileOverridesBySerialsFunc(ctx, serials)
+}
+
+func (s *DataStore) ListPendingHostMDMAppleDEPProfileOverrides(ctx context.Context) ([]*fleet.HostMDMAppleDEPProfileOverride, error) {
+	s.mu.Lock()
+	s.ListPendingHostMDMAppleDEPProfileOverridesFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListPendingHostMDMAppleDEPProfileOverridesFunc(ctx)
+}
+
+func (s *DataStore) SetHostMDMAppleDEPProfileOverridesPending(ctx context.Context, hostIDs []uint) error {
+	s.mu.Lock()
+	s.SetHostMDMAppleDEPProfileOverridesPendingFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetHostMDMAppleDEPProfileOverridesPendingFunc(ctx, hostIDs)
+}
+
+func (s *DataStore) SetHostMDMAppleDEPProfileOverrideAssigned(ctx context.Context, hostID uint, profileUUID string) error {
+	s.mu.Lock()
+	s.SetHostMDMAppleDEPProfileOverrideAssignedFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetHostMDMAppleDEPProfileOverrideAssignedFunc(ctx, hostID, profileUUID)
+}
+
 func (s *DataStore) LoadHostByDeviceAuthToken(ctx context.Context, authToken string, tokenTTL time.Duration) (*fleet.Host, error) {
 	s.mu.Lock()
 	s.LoadHostByDeviceAuthTokenFuncInvoked = true
@@ -2148,6 +3126,20 @@ func (s *DataStore) SetOrUpdateDeviceAuthToken(ctx context.Context, hostID uint,
 	return s.SetOrUpdateDeviceAuthTokenFunc(ctx, hostID, authToken)
 }
 
+func (s *DataStore) NewHostQuarantine(ctx context.Context, q *fleet.HostQuarantine) (*fleet.HostQuarantine, error) {
+	s.mu.Lock()
+	s.NewHostQuarantineFuncInvoked = true
+	s.mu.Unlock()
+	return s.NewHostQuarantineFunc(ctx, q)
+}
+
+func (s *DataStore) DeleteHostQuarantine(ctx context.Context, id uint) error {
+	s.mu.Lock()
+	s.DeleteHostQuarantineFuncInvoked = true
+	s.mu.Unlock()
+	return s.DeleteHostQuarantineFunc(ctx, id)
+}
+
 func (s *DataStore) FailingPoliciesCount(ctx context.Context, host *fleet.Host) (uint, error) {
 	s.mu.Lock()
 	s.FailingPoliciesCountFuncInvoked = true
@@ -2190,6 +3182,13 @@ func (s *DataStore) GetHostMDMCheckinInfo(ctx context.Context, hostUUID string)
 	return s.GetHostMDMCheckinInfoFunc(ctx, hostUUID)
 }
 
+func (s *DataStore) MarkHostMDMCheckedIn(ctx context.Context, hostUUID string, t time.Time) error {
+	s.mu.Lock()
+	s.MarkHostMDMCheckedInFuncInvoked = true
+	s.mu.Unlock()
+	return s.MarkHostMDMCheckedInFunc(ctx, hostUUID, t)
+}
+
 func (s *DataStore) AggregatedMunkiVersion(ctx context.Context, teamID *uint) ([]fleet.AggregatedMunkiVersion, time.Time, error) {
 	s.mu.Lock()
 	s.AggregatedMunkiVersionFuncInvoked = true
@@ -2344,6 +3343,13 @@ func (s *DataStore) MarkSessionAccessed(ctx context.Context, session *fleet.Sess
 	return s.MarkSessionAccessedFunc(ctx, session)
 }
 
+func (s *DataStore) MarkSessionReauthenticated(ctx context.Context, session *fleet.Session) error {
+	s.mu.Lock()
+	s.MarkSessionReauthenticatedFuncInvoked = true
+	s.mu.Unlock()
+	return s.MarkSessionReauthenticatedFunc(ctx, session)
+}
+
 func (s *DataStore) NewAppConfig(ctx context.Context, info *fleet.AppConfig) (*fleet.AppConfig, error) {
 	s.mu.Lock()
 	s.NewAppConfigFuncInvoked = true
@@ -2512,6 +3518,13 @@ func (s *DataStore) DeleteTeam(ctx context.Context, tid uint) error {
 	return s.DeleteTeamFunc(ctx, tid)
 }
 
+func (s *DataStore) TeamDeletionReport(ctx context.Context, tid uint) (*fleet.TeamDeletionReport, error) {
+	s.mu.Lock()
+	s.TeamDeletionReportFuncInvoked = true
+	s.mu.Unlock()
+	return s.TeamDeletionReportFunc(ctx, tid)
+}
+
 func (s *DataStore) TeamByName(ctx context.Context, name string) (*fleet.Team, error) {
 	s.mu.Lock()
 	s.TeamByNameFuncInvoked = true
@@ -2708,6 +3721,41 @@ func (s *DataStore) MarkActivitiesAsStreamed(ctx context.Context, activityIDs []
 	return s.MarkActivitiesAsStreamedFunc(ctx, activityIDs)
 }
 
+func (s *DataStore) NewSecurityAuditLogEntry(ctx context.Context, entry *fleet.SecurityAuditLogEntry) error {
+	s.mu.Lock()
+	s.NewSecurityAuditLogEntryFuncInvoked = true
+	s.mu.Unlock()
+	return s.NewSecurityAuditLogEntryFunc(ctx, entry)
+}
+
+func (s *DataStore) ListSecurityAuditLog(ctx context.Context, opt fleet.ListOptions) ([]*fleet.SecurityAuditLogEntry, *fleet.PaginationMetadata, error) {
+	s.mu.Lock()
+	s.ListSecurityAuditLogFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListSecurityAuditLogFunc(ctx, opt)
+}
+
+func (s *DataStore) ListSecurityAuditLogToStream(ctx context.Context, limit uint) ([]*fleet.SecurityAuditLogEntry, error) {
+	s.mu.Lock()
+	s.ListSecurityAuditLogToStreamFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListSecurityAuditLogToStreamFunc(ctx, limit)
+}
+
+func (s *DataStore) MarkSecurityAuditLogAsStreamed(ctx context.Context, ids []uint) error {
+	s.mu.Lock()
+	s.MarkSecurityAuditLogAsStreamedFuncInvoked = true
+	s.mu.Unlock()
+	return s.MarkSecurityAuditLogAsStreamedFunc(ctx, ids)
+}
+
+func (s *DataStore) VerifySecurityAuditLog(ctx context.Context) (*fleet.SecurityAuditLogVerification, error) {
+	s.mu.Lock()
+	s.VerifySecurityAuditLogFuncInvoked = true
+	s.mu.Unlock()
+	return s.VerifySecurityAuditLogFunc(ctx)
+}
+
 func (s *DataStore) ShouldSendStatistics(ctx context.Context, frequency time.Duration, config config.FleetConfig) (fleet.StatisticsPayload, bool, error) {
 	s.mu.Lock()
 	s.ShouldSendStatisticsFuncInvoked = true
@@ -2785,6 +3833,13 @@ func (s *DataStore) PolicyQueriesForHost(ctx context.Context, host *fleet.Host)
 	return s.PolicyQueriesForHostFunc(ctx, host)
 }
 
+func (s *DataStore) PolicyMDMConditions(ctx context.Context, policyIDs []uint) (map[uint]fleet.PolicyMDMConditions, error) {
+	s.mu.Lock()
+	s.PolicyMDMConditionsFuncInvoked = true
+	s.mu.Unlock()
+	return s.PolicyMDMConditionsFunc(ctx, policyIDs)
+}
+
 func (s *DataStore) AsyncBatchInsertPolicyMembership(ctx context.Context, batch []fleet.PolicyMembershipResult) error {
 	s.mu.Lock()
 	s.AsyncBatchInsertPolicyMembershipFuncInvoked = true
@@ -2932,11 +3987,25 @@ func (s *DataStore) InsertCronStats(ctx context.Context, statsType fleet.CronSta
 	return s.InsertCronStatsFunc(ctx, statsType, name, instance, status)
 }
 
-func (s *DataStore) UpdateCronStats(ctx context.Context, id int, status fleet.CronStatsStatus) error {
+func (s *DataStore) UpdateCronStats(ctx context.Context, id int, status fleet.CronStatsStatus, cronErrors fleet.CronScheduleErrors) error {
 	s.mu.Lock()
 	s.UpdateCronStatsFuncInvoked = true
 	s.mu.Unlock()
-	return s.UpdateCronStatsFunc(ctx, id, status)
+	return s.UpdateCronStatsFunc(ctx, id, status, cronErrors)
+}
+
+func (s *DataStore) GetLatestCompletedCronStats(ctx context.Context, name string) (*fleet.CronStats, error) {
+	s.mu.Lock()
+	s.GetLatestCompletedCronStatsFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetLatestCompletedCronStatsFunc(ctx, name)
+}
+
+func (s *DataStore) ListLatestCompletedCronStats(ctx context.Context, name string, limit int) ([]fleet.CronStats, error) {
+	s.mu.Lock()
+	s.ListLatestCompletedCronStatsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListLatestCompletedCronStatsFunc(ctx, name, limit)
 }
 
 func (s *DataStore) UpdateAllCronStatsForInstance(ctx context.Context, instance string, fromStatus fleet.CronStatsStatus, toStatus fleet.CronStatsStatus) error {
@@ -3149,6 +4218,20 @@ func (s *DataStore) GetHostDiskEncryptionKey(ctx context.Context, hostID uint) (
 	return s.GetHostDiskEncryptionKeyFunc(ctx, hostID)
 }
 
+func (s *DataStore) NewHostDiskEncryptionKeyAccess(ctx context.Context, access *fleet.HostDiskEncryptionKeyAccess) error {
+	s.mu.Lock()
+	s.NewHostDiskEncryptionKeyAccessFuncInvoked = true
+	s.mu.Unlock()
+	return s.NewHostDiskEncryptionKeyAccessFunc(ctx, access)
+}
+
+func (s *DataStore) ListHostDiskEncryptionKeyAccesses(ctx context.Context, hostID uint, opt fleet.ListOptions) ([]*fleet.HostDiskEncryptionKeyAccess, *fleet.PaginationMetadata, error) {
+	s.mu.Lock()
+	s.ListHostDiskEncryptionKeyAccessesFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListHostDiskEncryptionKeyAccessesFunc(ctx, hostID, opt)
+}
+
 func (s *DataStore) SetDiskEncryptionResetStatus(ctx context.Context, hostID uint, status bool) error {
 	s.mu.Lock()
 	s.SetDiskEncryptionResetStatusFuncInvoked = true
@@ -3156,6 +4239,27 @@ func (s *DataStore) SetDiskEncryptionResetStatus(ctx context.Context, hostID uin
 	return s.SetDiskEncryptionResetStatusFunc(ctx, hostID, status)
 }
 
+func (s *DataStore) RequestMDMAppleFileVaultKeyRotation(ctx context.Context, teamID *uint) (int, error) {
+	s.mu.Lock()
+	s.RequestMDMAppleFileVaultKeyRotationFuncInvoked = true
+	s.mu.Unlock()
+	return s.RequestMDMAppleFileVaultKeyRotationFunc(ctx, teamID)
+}
+
+func (s *DataStore) RequestMDMAppleFileVaultKeyRotationForTarget(ctx context.Context, targetID uint) (int, error) {
+	s.mu.Lock()
+	s.RequestMDMAppleFileVaultKeyRotationForTargetFuncInvoked = true
+	s.mu.Unlock()
+	return s.RequestMDMAppleFileVaultKeyRotationForTargetFunc(ctx, targetID)
+}
+
+func (s *DataStore) NotifyMDMAppleFileVaultKeyRotations(ctx context.Context, limit int) (int, error) {
+	s.mu.Lock()
+	s.NotifyMDMAppleFileVaultKeyRotationsFuncInvoked = true
+	s.mu.Unlock()
+	return s.NotifyMDMAppleFileVaultKeyRotationsFunc(ctx, limit)
+}
+
 func (s *DataStore) SetOrUpdateHostOrbitInfo(ctx context.Context, hostID uint, version string) error {
 	s.mu.Lock()
 	s.SetOrUpdateHostOrbitInfoFuncInvoked = true
@@ -3163,6 +4267,13 @@ func (s *DataStore) SetOrUpdateHostOrbitInfo(ctx context.Context, hostID uint, v
 	return s.SetOrUpdateHostOrbitInfoFunc(ctx, hostID, version)
 }
 
+func (s *DataStore) SetOrUpdateHostOSUpdates(ctx context.Context, hostID uint, osVersion string, pendingUpdates uint) error {
+	s.mu.Lock()
+	s.SetOrUpdateHostOSUpdatesFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetOrUpdateHostOSUpdatesFunc(ctx, hostID, osVersion, pendingUpdates)
+}
+
 func (s *DataStore) ReplaceHostDeviceMapping(ctx context.Context, id uint, mappings []*fleet.HostDeviceMapping) error {
 	s.mu.Lock()
 	s.ReplaceHostDeviceMappingFuncInvoked = true
@@ -3219,6 +4330,20 @@ func (s *DataStore) GetQueuedJobs(ctx context.Context, maxNumJobs int) ([]*fleet
 	return s.GetQueuedJobsFunc(ctx, maxNumJobs)
 }
 
+func (s *DataStore) GetJob(ctx context.Context, id uint) (*fleet.Job, error) {
+	s.mu.Lock()
+	s.GetJobFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetJobFunc(ctx, id)
+}
+
+func (s *DataStore) ListJobs(ctx context.Context, name string, state fleet.JobState, opt fleet.ListOptions) ([]*fleet.Job, error) {
+	s.mu.Lock()
+	s.ListJobsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListJobsFunc(ctx, name, state, opt)
+}
+
 func (s *DataStore) UpdateJob(ctx context.Context, id uint, job *fleet.Job) (*fleet.Job, error) {
 	s.mu.Lock()
 	s.UpdateJobFuncInvoked = true
@@ -3226,6 +4351,13 @@ func (s *DataStore) UpdateJob(ctx context.Context, id uint, job *fleet.Job) (*fl
 	return s.UpdateJobFunc(ctx, id, job)
 }
 
+func (s *DataStore) UpdateJobProgress(ctx context.Context, id uint, hostsDone int, hostsCount int) error {
+	s.mu.Lock()
+	s.UpdateJobProgressFuncInvoked = true
+	s.mu.Unlock()
+	return s.UpdateJobProgressFunc(ctx, id, hostsDone, hostsCount)
+}
+
 func (s *DataStore) InnoDBStatus(ctx context.Context) (string, error) {
 	s.mu.Lock()
 	s.InnoDBStatusFuncInvoked = true
@@ -3275,42 +4407,154 @@ func (s *DataStore) DeleteOSVulnerabilities(ctx context.Context, vulnerabilities
 	return s.DeleteOSVulnerabilitiesFunc(ctx, vulnerabilities)
 }
 
-func (s *DataStore) NewMDMAppleConfigProfile(ctx context.Context, p fleet.MDMAppleConfigProfile) (*fleet.MDMAppleConfigProfile, error) {
+func (s *DataStore) CreateScimUser(ctx context.Context, user *fleet.ScimUser) (*fleet.ScimUser, error) {
 	s.mu.Lock()
-	s.NewMDMAppleConfigProfileFuncInvoked = true
+	s.CreateScimUserFuncInvoked = true
 	s.mu.Unlock()
-	return s.NewMDMAppleConfigProfileFunc(ctx, p)
+	return s.CreateScimUserFunc(ctx, user)
 }
 
-func (s *DataStore) BulkUpsertMDMAppleConfigProfiles(ctx context.Context, payload []*fleet.MDMAppleConfigProfile) error {
+func (s *DataStore) ScimUserByID(ctx context.Context, id uint) (*fleet.ScimUser, error) {
 	s.mu.Lock()
-	s.BulkUpsertMDMAppleConfigProfilesFuncInvoked = true
+	s.ScimUserByIDFuncInvoked = true
 	s.mu.Unlock()
-	return s.BulkUpsertMDMAppleConfigProfilesFunc(ctx, payload)
+	return s.ScimUserByIDFunc(ctx, id)
 }
 
-func (s *DataStore) GetMDMAppleConfigProfile(ctx context.Context, profileID uint) (*fleet.MDMAppleConfigProfile, error) {
+func (s *DataStore) ListScimUsers(ctx context.Context, opts fleet.ScimListOptions) ([]*fleet.ScimUser, int, error) {
 	s.mu.Lock()
-	s.GetMDMAppleConfigProfileFuncInvoked = true
+	s.ListScimUsersFuncInvoked = true
 	s.mu.Unlock()
-	return s.GetMDMAppleConfigProfileFunc(ctx, profileID)
+	return s.ListScimUsersFunc(ctx, opts)
 }
 
-func (s *DataStore) ListMDMAppleConfigProfiles(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleConfigProfile, error) {
+func (s *DataStore) ReplaceScimUser(ctx context.Context, user *fleet.ScimUser) error {
 	s.mu.Lock()
-	s.ListMDMAppleConfigProfilesFuncInvoked = true
+	s.ReplaceScimUserFuncInvoked = true
 	s.mu.Unlock()
-	return s.ListMDMAppleConfigProfilesFunc(ctx, teamID)
+	return s.ReplaceScimUserFunc(ctx, user)
 }
 
-func (s *DataStore) DeleteMDMAppleConfigProfile(ctx context.Context, profileID uint) error {
+func (s *DataStore) DeleteScimUser(ctx context.Context, id uint) error {
 	s.mu.Lock()
-	s.DeleteMDMAppleConfigProfileFuncInvoked = true
+	s.DeleteScimUserFuncInvoked = true
 	s.mu.Unlock()
-	return s.DeleteMDMAppleConfigProfileFunc(ctx, profileID)
+	return s.DeleteScimUserFunc(ctx, id)
 }
 
-func (s *DataStore) DeleteMDMAppleConfigProfileByTeamAndIdentifier(ctx context.Context, teamID *uint, profileIdentifier string) error {
+func (s *DataStore) CreateScimGroup(ctx context.Context, group *fleet.ScimGroup) (*fleet.ScimGroup, error) {
+	s.mu.Lock()
+	s.CreateScimGroupFuncInvoked = true
+	s.mu.Unlock()
+	return s.CreateScimGroupFunc(ctx, group)
+}
+
+func (s *DataStore) ScimGroupByID(ctx context.Context, id uint) (*fleet.ScimGroup, error) {
+	s.mu.Lock()
+	s.ScimGroupByIDFuncInvoked = true
+	s.mu.Unlock()
+	return s.ScimGroupByIDFunc(ctx, id)
+}
+
+func (s *DataStore) ListScimGroups(ctx context.Context, opts fleet.ScimListOptions) ([]*fleet.ScimGroup, int, error) {
+	s.mu.Lock()
+	s.ListScimGroupsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListScimGroupsFunc(ctx, opts)
+}
+
+func (s *DataStore) ReplaceScimGroup(ctx context.Context, group *fleet.ScimGroup) error {
+	s.mu.Lock()
+	s.ReplaceScimGroupFuncInvoked = true
+	s.mu.Unlock()
+	return s.ReplaceScimGroupFunc(ctx, group)
+}
+
+func (s *DataStore) DeleteScimGroup(ctx context.Context, id uint) error {
+	s.mu.Lock()
+	s.DeleteScimGroupFuncInvoked = true
+	s.mu.Unlock()
+	return s.DeleteScimGroupFunc(ctx, id)
+}
+
+func (s *DataStore) ScimGroupNamesForUserName(ctx context.Context, userName string) ([]string, error) {
+	s.mu.Lock()
+	s.ScimGroupNamesForUserNameFuncInvoked = true
+	s.mu.Unlock()
+	return s.ScimGroupNamesForUserNameFunc(ctx, userName)
+}
+
+func (s *DataStore) SetScimLastRequest(ctx context.Context, req *fleet.ScimLastRequest) error {
+	s.mu.Lock()
+	s.SetScimLastRequestFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetScimLastRequestFunc(ctx, req)
+}
+
+func (s *DataStore) ScimLastRequest(ctx context.Context) (*fleet.ScimLastRequest, error) {
+	s.mu.Lock()
+	s.ScimLastRequestFuncInvoked = true
+	s.mu.Unlock()
+	return s.ScimLastRequestFunc(ctx)
+}
+
+func (s *DataStore) NewMDMAppleConfigProfile(ctx context.Context, p fleet.MDMAppleConfigProfile) (*fleet.MDMAppleConfigProfile, error) {
+	s.mu.Lock()
+	s.NewMDMAppleConfigProfileFuncInvoked = true
+	s.mu.Unlock()
+	return s.NewMDMAppleConfigProfileFunc(ctx, p)
+}
+
+func (s *DataStore) BulkUpsertMDMAppleConfigProfiles(ctx context.Context, payload []*fleet.MDMAppleConfigProfile) error {
+	s.mu.Lock()
+	s.BulkUpsertMDMAppleConfigProfilesFuncInvoked = true
+	s.mu.Unlock()
+	return s.BulkUpsertMDMAppleConfigProfilesFunc(ctx, payload)
+}
+
+func (s *DataStore) GetMDMAppleConfigProfile(ctx context.Context, profileID uint) (*fleet.MDMAppleConfigProfile, error) {
+	s.mu.Lock()
+	s.GetMDMAppleConfigProfileFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleConfigProfileFunc(ctx, profileID)
+}
+
+func (s *DataStore) ListMDMAppleConfigProfiles(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleConfigProfile, error) {
+	s.mu.Lock()
+	s.ListMDMAppleConfigProfilesFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleConfigProfilesFunc(ctx, teamID)
+}
+
+func (s *DataStore) DeleteMDMAppleConfigProfile(ctx context.Context, profileID uint) error {
+	s.mu.Lock()
+	s.DeleteMDMAppleConfigProfileFuncInvoked = true
+	s.mu.Unlock()
+	return s.DeleteMDMAppleConfigProfileFunc(ctx, profileID)
+}
+
+func (s *DataStore) ListMDMAppleConfigProfilesByIDsOrIdentifiers(ctx context.Context, profileIDs []uint, identifiers []string) ([]*fleet.MDMAppleConfigProfile, error) {
+	s.mu.Lock()
+	s.ListMDMAppleConfigProfilesByIDsOrIdentifiersFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleConfigProfilesByIDsOrIdentifiersFunc(ctx, profileIDs, identifiers)
+}
+
+func (s *DataStore) DeleteMDMAppleConfigProfiles(ctx context.Context, profileIDs []uint) error {
+	s.mu.Lock()
+	s.DeleteMDMAppleConfigProfilesFuncInvoked = true
+	s.mu.Unlock()
+	return s.DeleteMDMAppleConfigProfilesFunc(ctx, profileIDs)
+}
+
+func (s *DataStore) CopyMDMAppleConfigProfileToTeams(ctx context.Context, cp *fleet.MDMAppleConfigProfile, teamIDs []uint) ([]*fleet.MDMAppleConfigProfile, error) {
+	s.mu.Lock()
+	s.CopyMDMAppleConfigProfileToTeamsFuncInvoked = true
+	s.mu.Unlock()
+	return s.CopyMDMAppleConfigProfileToTeamsFunc(ctx, cp, teamIDs)
+}
+
+func (s *DataStore) DeleteMDMAppleConfigProfileByTeamAndIdentifier(ctx context.Context, teamID *uint, profileIdentifier string) error {
 	s.mu.Lock()
 	s.DeleteMDMAppleConfigProfileByTeamAndIdentifierFuncInvoked = true
 	s.mu.Unlock()
@@ -3359,6 +4603,20 @@ func (s *DataStore) GetMDMAppleCommandResults(ctx context.Context, commandUUID s
 	return s.GetMDMAppleCommandResultsFunc(ctx, commandUUID)
 }
 
+func (s *DataStore) RedactMDMAppleCommandResult(ctx context.Context, hostUUID string, commandUUID string, redactedResult []byte) error {
+	s.mu.Lock()
+	s.RedactMDMAppleCommandResultFuncInvoked = true
+	s.mu.Unlock()
+	return s.RedactMDMAppleCommandResultFunc(ctx, hostUUID, commandUUID, redactedResult)
+}
+
+func (s *DataStore) RedactMDMAppleCommand(ctx context.Context, commandUUID string, redactedCommand string) error {
+	s.mu.Lock()
+	s.RedactMDMAppleCommandFuncInvoked = true
+	s.mu.Unlock()
+	return s.RedactMDMAppleCommandFunc(ctx, commandUUID, redactedCommand)
+}
+
 func (s *DataStore) ListMDMAppleCommands(ctx context.Context, tmFilter fleet.TeamFilter, listOpts *fleet.MDMAppleCommandListOptions) ([]*fleet.MDMAppleCommand, error) {
 	s.mu.Lock()
 	s.ListMDMAppleCommandsFuncInvoked = true
@@ -3415,6 +4673,125 @@ func (s *DataStore) BatchSetMDMAppleProfiles(ctx context.Context, tmID *uint, pr
 	return s.BatchSetMDMAppleProfilesFunc(ctx, tmID, profiles)
 }
 
+func (s *DataStore) BatchSetMDMAppleProfileExclusions(ctx context.Context, tmID *uint, exclusions []*fleet.MDMAppleProfileExclusion) error {
+	s.mu.Lock()
+	s.BatchSetMDMAppleProfileExclusionsFuncInvoked = true
+	s.mu.Unlock()
+	return s.BatchSetMDMAppleProfileExclusionsFunc(ctx, tmID, exclusions)
+}
+
+func (s *DataStore) BatchSetMDMAppleTeamsProfiles(ctx context.Context, teams []*fleet.MDMAppleTeamProfiles) error {
+	s.mu.Lock()
+	s.BatchSetMDMAppleTeamsProfilesFuncInvoked = true
+	s.mu.Unlock()
+	return s.BatchSetMDMAppleTeamsProfilesFunc(ctx, teams)
+}
+
+func (s *DataStore) ListMDMAppleProfileExclusions(ctx context.Context, tmID *uint) ([]*fleet.MDMAppleProfileExclusion, error) {
+	s.mu.Lock()
+	s.ListMDMAppleProfileExclusionsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleProfileExclusionsFunc(ctx, tmID)
+}
+
+func (s *DataStore) NewMDMHostTarget(ctx context.Context, target *fleet.MDMHostTarget) (*fleet.MDMHostTarget, error) {
+	s.mu.Lock()
+	s.NewMDMHostTargetFuncInvoked = true
+	s.mu.Unlock()
+	return s.NewMDMHostTargetFunc(ctx, target)
+}
+
+func (s *DataStore) MDMHostTarget(ctx context.Context, id uint) (*fleet.MDMHostTarget, error) {
+	s.mu.Lock()
+	s.MDMHostTargetFuncInvoked = true
+	s.mu.Unlock()
+	return s.MDMHostTargetFunc(ctx, id)
+}
+
+func (s *DataStore) ListMDMHostTargets(ctx context.Context) ([]*fleet.MDMHostTarget, error) {
+	s.mu.Lock()
+	s.ListMDMHostTargetsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMHostTargetsFunc(ctx)
+}
+
+func (s *DataStore) DeleteMDMHostTarget(ctx context.Context, id uint) error {
+	s.mu.Lock()
+	s.DeleteMDMHostTargetFuncInvoked = true
+	s.mu.Unlock()
+	return s.DeleteMDMHostTargetFunc(ctx, id)
+}
+
+func (s *DataStore) ListMDMHostTargetHosts(ctx context.Context, id uint) ([]*fleet.MDMHostTargetHost, error) {
+	s.mu.Lock()
+	s.ListMDMHostTargetHostsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMHostTargetHostsFunc(ctx, id)
+}
+
+func (s *DataStore) SetMDMAppleConfigProfileTarget(ctx context.Context, profileID uint, targetID *uint) error {
+	s.mu.Lock()
+	s.SetMDMAppleConfigProfileTargetFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetMDMAppleConfigProfileTargetFunc(ctx, profileID, targetID)
+}
+
+func (s *DataStore) SetMDMAppleConfigProfileMacOSVersions(ctx context.Context, profileID uint, min string, max string) error {
+	s.mu.Lock()
+	s.SetMDMAppleConfigProfileMacOSVersionsFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetMDMAppleConfigProfileMacOSVersionsFunc(ctx, profileID, min, max)
+}
+
+func (s *DataStore) SetMDMApplePolicyAction(ctx context.Context, action *fleet.MDMApplePolicyAction) error {
+	s.mu.Lock()
+	s.SetMDMApplePolicyActionFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetMDMApplePolicyActionFunc(ctx, action)
+}
+
+func (s *DataStore) GetMDMApplePolicyAction(ctx context.Context, policyID uint) (*fleet.MDMApplePolicyAction, error) {
+	s.mu.Lock()
+	s.GetMDMApplePolicyActionFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMApplePolicyActionFunc(ctx, policyID)
+}
+
+func (s *DataStore) DeleteMDMApplePolicyAction(ctx context.Context, policyID uint) error {
+	s.mu.Lock()
+	s.DeleteMDMApplePolicyActionFuncInvoked = true
+	s.mu.Unlock()
+	return s.DeleteMDMApplePolicyActionFunc(ctx, policyID)
+}
+
+func (s *DataStore) ListMDMApplePolicyActions(ctx context.Context) ([]*fleet.MDMApplePolicyAction, error) {
+	s.mu.Lock()
+	s.ListMDMApplePolicyActionsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMApplePolicyActionsFunc(ctx)
+}
+
+func (s *DataStore) ListMDMApplePolicyActionPolicyIDs(ctx context.Context) ([]uint, error) {
+	s.mu.Lock()
+	s.ListMDMApplePolicyActionPolicyIDsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMApplePolicyActionPolicyIDsFunc(ctx)
+}
+
+func (s *DataStore) ListMDMApplePolicyActionHosts(ctx context.Context, hostIDs []uint) ([]*fleet.MDMApplePolicyActionHost, error) {
+	s.mu.Lock()
+	s.ListMDMApplePolicyActionHostsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMApplePolicyActionHostsFunc(ctx, hostIDs)
+}
+
+func (s *DataStore) GetMDMAppleProfilesPreview(ctx context.Context, tmID *uint, profiles []*fleet.MDMAppleConfigProfile) (*fleet.MDMAppleProfilesPreview, error) {
+	s.mu.Lock()
+	s.GetMDMAppleProfilesPreviewFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleProfilesPreviewFunc(ctx, tmID, profiles)
+}
+
 func (s *DataStore) MDMAppleListDevices(ctx context.Context) ([]fleet.MDMAppleDevice, error) {
 	s.mu.Lock()
 	s.MDMAppleListDevicesFuncInvoked = true
@@ -3429,6 +4806,55 @@ func (s *DataStore) IngestMDMAppleDevicesFromDEPSync(ctx context.Context, device
 	return s.IngestMDMAppleDevicesFromDEPSyncFunc(ctx, devices)
 }
 
+func (s *DataStore) SetMDMAppleDEPTeamAssignments(ctx context.Context, assignments []*fleet.MDMAppleDEPTeamAssignment) error {
+	s.mu.Lock()
+	s.SetMDMAppleDEPTeamAssignmentsFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetMDMAppleDEPTeamAssignmentsFunc(ctx, assignments)
+}
+
+func (s *DataStore) ListMDMAppleDEPTeamAssignments(ctx context.Context) ([]*fleet.MDMAppleDEPTeamAssignment, error) {
+	s.mu.Lock()
+	s.ListMDMAppleDEPTeamAssignmentsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleDEPTeamAssignmentsFunc(ctx)
+}
+
+func (s *DataStore) GetMDMAppleDEPTeamAssignments(ctx context.Context, serials []string) (map[string]uint, error) {
+	s.mu.Lock()
+	s.GetMDMAppleDEPTeamAssignmentsFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleDEPTeamAssignmentsFunc(ctx, serials)
+}
+
+func (s *DataStore) GetHostMDMAppleDEPDevice(ctx context.Context, hostID uint) (*fleet.HostMDMAppleDEPDevice, error) {
+	s.mu.Lock()
+	s.GetHostMDMAppleDEPDeviceFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetHostMDMAppleDEPDeviceFunc(ctx, hostID)
+}
+
+func (s *DataStore) SetMDMAppleDEPDevicesOrgName(ctx context.Context, orgName string) error {
+	s.mu.Lock()
+	s.SetMDMAppleDEPDevicesOrgNameFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetMDMAppleDEPDevicesOrgNameFunc(ctx, orgName)
+}
+
+func (s *DataStore) ListMDMAppleDEPHostsReport(ctx context.Context, filter fleet.TeamFilter, opts fleet.ListOptions) ([]*fleet.MDMAppleDEPHostReportItem, error) {
+	s.mu.Lock()
+	s.ListMDMAppleDEPHostsReportFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleDEPHostsReportFunc(ctx, filter, opts)
+}
+
+func (s *DataStore) ListMDMAppleDEPAssignmentDiscrepancies(ctx context.Context, filter fleet.TeamFilter, defaultFamilies []string) ([]*fleet.MDMAppleDEPAssignmentDiscrepancy, error) {
+	s.mu.Lock()
+	s.ListMDMAppleDEPAssignmentDiscrepanciesFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleDEPAssignmentDiscrepanciesFunc(ctx, filter, defaultFamilies)
+}
+
 func (s *DataStore) IngestMDMAppleDeviceFromCheckin(ctx context.Context, mdmHost fleet.MDMAppleHostDetails) error {
 	s.mu.Lock()
 	s.IngestMDMAppleDeviceFromCheckinFuncInvoked = true
@@ -3471,6 +4897,20 @@ func (s *DataStore) ListMDMAppleProfilesToRemove(ctx context.Context) ([]*fleet.
 	return s.ListMDMAppleProfilesToRemoveFunc(ctx)
 }
 
+func (s *DataStore) ListMDMAppleReconciliationPlan(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleReconciliationPlanItem, error) {
+	s.mu.Lock()
+	s.ListMDMAppleReconciliationPlanFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleReconciliationPlanFunc(ctx, teamID)
+}
+
+func (s *DataStore) ListMDMAppleHostProfilesWithQueuedCommand(ctx context.Context, opType fleet.MDMAppleOperationType, hostUUIDs []string) ([]*fleet.MDMAppleProfilePayload, error) {
+	s.mu.Lock()
+	s.ListMDMAppleHostProfilesWithQueuedCommandFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleHostProfilesWithQueuedCommandFunc(ctx, opType, hostUUIDs)
+}
+
 func (s *DataStore) BulkUpsertMDMAppleHostProfiles(ctx context.Context, payload []*fleet.MDMAppleBulkUpsertHostProfilePayload) error {
 	s.mu.Lock()
 	s.BulkUpsertMDMAppleHostProfilesFuncInvoked = true
@@ -3485,6 +4925,20 @@ func (s *DataStore) BulkSetPendingMDMAppleHostProfiles(ctx context.Context, host
 	return s.BulkSetPendingMDMAppleHostProfilesFunc(ctx, hostIDs, teamIDs, profileIDs, hostUUIDs)
 }
 
+func (s *DataStore) ListMDMAppleBulkSetPendingHostUUIDs(ctx context.Context, hostIDs []uint, teamIDs []uint, profileIDs []uint) ([]string, error) {
+	s.mu.Lock()
+	s.ListMDMAppleBulkSetPendingHostUUIDsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleBulkSetPendingHostUUIDsFunc(ctx, hostIDs, teamIDs, profileIDs)
+}
+
+func (s *DataStore) CountMDMAppleBulkSetPendingHosts(ctx context.Context, hostIDs []uint, teamIDs []uint, profileIDs []uint, limit int) (int, error) {
+	s.mu.Lock()
+	s.CountMDMAppleBulkSetPendingHostsFuncInvoked = true
+	s.mu.Unlock()
+	return s.CountMDMAppleBulkSetPendingHostsFunc(ctx, hostIDs, teamIDs, profileIDs, limit)
+}
+
 func (s *DataStore) GetMDMAppleProfilesContents(ctx context.Context, profileIDs []uint) (map[uint]mobileconfig.Mobileconfig, error) {
 	s.mu.Lock()
 	s.GetMDMAppleProfilesContentsFuncInvoked = true
@@ -3492,6 +4946,20 @@ func (s *DataStore) GetMDMAppleProfilesContents(ctx context.Context, profileIDs
 	return s.GetMDMAppleProfilesContentsFunc(ctx, profileIDs)
 }
 
+func (s *DataStore) ListMDMAppleProfilesReferencingSecrets(ctx context.Context) (map[uint]mobileconfig.Mobileconfig, error) {
+	s.mu.Lock()
+	s.ListMDMAppleProfilesReferencingSecretsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleProfilesReferencingSecretsFunc(ctx)
+}
+
+func (s *DataStore) SetMDMAppleProfileSecretsChecksum(ctx context.Context, profileID uint, checksum []byte) error {
+	s.mu.Lock()
+	s.SetMDMAppleProfileSecretsChecksumFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetMDMAppleProfileSecretsChecksumFunc(ctx, profileID, checksum)
+}
+
 func (s *DataStore) UpdateOrDeleteHostMDMAppleProfile(ctx context.Context, profile *fleet.HostMDMAppleProfile) error {
 	s.mu.Lock()
 	s.UpdateOrDeleteHostMDMAppleProfileFuncInvoked = true
@@ -3499,116 +4967,732 @@ func (s *DataStore) UpdateOrDeleteHostMDMAppleProfile(ctx context.Context, profi
 	return s.UpdateOrDeleteHostMDMAppleProfileFunc(ctx, profile)
 }
 
-func (s *DataStore) GetMDMAppleCommandRequestType(ctx context.Context, commandUUID string) (string, error) {
+func (s *DataStore) UpdateHostMDMAppleProfileRetryableFailure(ctx context.Context, profile *fleet.HostMDMAppleProfile, gracePeriod fleet.MacOSProfileFailureGracePeriod) (failed bool, err error) {
 	s.mu.Lock()
-	s.GetMDMAppleCommandRequestTypeFuncInvoked = true
+	s.UpdateHostMDMAppleProfileRetryableFailureFuncInvoked = true
 	s.mu.Unlock()
-	return s.GetMDMAppleCommandRequestTypeFunc(ctx, commandUUID)
+	return s.UpdateHostMDMAppleProfileRetryableFailureFunc(ctx, profile, gracePeriod)
 }
 
-func (s *DataStore) GetMDMAppleHostsProfilesSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleConfigProfilesSummary, error) {
+func (s *DataStore) ListTeamsMacOSProfileRetryPolicies(ctx context.Context) (map[uint]fleet.MacOSProfileRetryPolicy, error) {
 	s.mu.Lock()
-	s.GetMDMAppleHostsProfilesSummaryFuncInvoked = true
+	s.ListTeamsMacOSProfileRetryPoliciesFuncInvoked = true
 	s.mu.Unlock()
-	return s.GetMDMAppleHostsProfilesSummaryFunc(ctx, teamID)
+	return s.ListTeamsMacOSProfileRetryPoliciesFunc(ctx)
 }
 
-func (s *DataStore) InsertMDMIdPAccount(ctx context.Context, account *fleet.MDMIdPAccount) error {
+func (s *DataStore) RetryFailedHostMDMAppleProfiles(ctx context.Context, teamID uint, policy fleet.MacOSProfileRetryPolicy) (int64, error) {
 	s.mu.Lock()
-	s.InsertMDMIdPAccountFuncInvoked = true
+	s.RetryFailedHostMDMAppleProfilesFuncInvoked = true
 	s.mu.Unlock()
-	return s.InsertMDMIdPAccountFunc(ctx, account)
+	return s.RetryFailedHostMDMAppleProfilesFunc(ctx, teamID, policy)
 }
 
-func (s *DataStore) GetMDMAppleFileVaultSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleFileVaultSummary, error) {
+func (s *DataStore) ResetFailedHostMDMAppleProfile(ctx context.Context, profileID uint, hostUUID string, teamID uint) (int64, error) {
 	s.mu.Lock()
-	s.GetMDMAppleFileVaultSummaryFuncInvoked = true
+	s.ResetFailedHostMDMAppleProfileFuncInvoked = true
 	s.mu.Unlock()
-	return s.GetMDMAppleFileVaultSummaryFunc(ctx, teamID)
+	return s.ResetFailedHostMDMAppleProfileFunc(ctx, profileID, hostUUID, teamID)
 }
 
-func (s *DataStore) InsertMDMAppleBootstrapPackage(ctx context.Context, bp *fleet.MDMAppleBootstrapPackage) error {
+func (s *DataStore) SetMDMAppleEnrollmentPendingApproval(ctx context.Context, hostUUID string) (bool, error) {
 	s.mu.Lock()
-	s.InsertMDMAppleBootstrapPackageFuncInvoked = true
+	s.SetMDMAppleEnrollmentPendingApprovalFuncInvoked = true
 	s.mu.Unlock()
-	return s.InsertMDMAppleBootstrapPackageFunc(ctx, bp)
+	return s.SetMDMAppleEnrollmentPendingApprovalFunc(ctx, hostUUID)
 }
 
-func (s *DataStore) DeleteMDMAppleBootstrapPackage(ctx context.Context, teamID uint) error {
+func (s *DataStore) ApproveMDMAppleEnrollment(ctx context.Context, hostUUID string) error {
 	s.mu.Lock()
-	s.DeleteMDMAppleBootstrapPackageFuncInvoked = true
+	s.ApproveMDMAppleEnrollmentFuncInvoked = true
 	s.mu.Unlock()
-	return s.DeleteMDMAppleBootstrapPackageFunc(ctx, teamID)
+	return s.ApproveMDMAppleEnrollmentFunc(ctx, hostUUID)
 }
 
-func (s *DataStore) GetMDMAppleBootstrapPackageMeta(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackage, error) {
+func (s *DataStore) PurgeHostMDMAppleData(ctx context.Context, hostID uint, hostUUID string) error {
 	s.mu.Lock()
-	s.GetMDMAppleBootstrapPackageMetaFuncInvoked = true
+	s.PurgeHostMDMAppleDataFuncInvoked = true
 	s.mu.Unlock()
-	return s.GetMDMAppleBootstrapPackageMetaFunc(ctx, teamID)
+	return s.PurgeHostMDMAppleDataFunc(ctx, hostID, hostUUID)
 }
 
-func (s *DataStore) GetMDMAppleBootstrapPackageBytes(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackage, error) {
+func (s *DataStore) ListMDMApplePendingEnrollments(ctx context.Context, filter fleet.TeamFilter) ([]*fleet.MDMApplePendingEnrollment, error) {
 	s.mu.Lock()
-	s.GetMDMAppleBootstrapPackageBytesFuncInvoked = true
+	s.ListMDMApplePendingEnrollmentsFuncInvoked = true
 	s.mu.Unlock()
-	return s.GetMDMAppleBootstrapPackageBytesFunc(ctx, token)
+	return s.ListMDMApplePendingEnrollmentsFunc(ctx, filter)
 }
 
-func (s *DataStore) GetMDMAppleBootstrapPackageSummary(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackageSummary, error) {
+func (s *DataStore) FilterMDMAppleHostUUIDsPendingApproval(ctx context.Context, hostUUIDs []string) ([]string, error) {
 	s.mu.Lock()
-	s.GetMDMAppleBootstrapPackageSummaryFuncInvoked = true
+	s.FilterMDMAppleHostUUIDsPendingApprovalFuncInvoked = true
 	s.mu.Unlock()
-	return s.GetMDMAppleBootstrapPackageSummaryFunc(ctx, teamID)
+	return s.FilterMDMAppleHostUUIDsPendingApprovalFunc(ctx, hostUUIDs)
 }
 
-func (s *DataStore) RecordHostBootstrapPackage(ctx context.Context, commandUUID string, hostUUID string) error {
+func (s *DataStore) RequestMDMAppleProfileChanges(ctx context.Context, changes []*fleet.MDMAppleProfileChange, expiresAt time.Time) ([]*fleet.MDMAppleProfileChange, error) {
 	s.mu.Lock()
-	s.RecordHostBootstrapPackageFuncInvoked = true
+	s.RequestMDMAppleProfileChangesFuncInvoked = true
 	s.mu.Unlock()
-	return s.RecordHostBootstrapPackageFunc(ctx, commandUUID, hostUUID)
+	return s.RequestMDMAppleProfileChangesFunc(ctx, changes, expiresAt)
 }
 
-func (s *DataStore) GetHostMDMMacOSSetup(ctx context.Context, hostID uint) (*fleet.HostMDMMacOSSetup, error) {
+func (s *DataStore) ExpireMDMAppleProfileChanges(ctx context.Context) error {
 	s.mu.Lock()
-	s.GetHostMDMMacOSSetupFuncInvoked = true
+	s.ExpireMDMAppleProfileChangesFuncInvoked = true
 	s.mu.Unlock()
-	return s.GetHostMDMMacOSSetupFunc(ctx, hostID)
+	return s.ExpireMDMAppleProfileChangesFunc(ctx)
 }
 
-func (s *DataStore) MDMAppleGetEULAMetadata(ctx context.Context) (*fleet.MDMAppleEULA, error) {
+func (s *DataStore) ListMDMAppleProfileChanges(ctx context.Context, status fleet.MDMAppleProfileChangeStatus) ([]*fleet.MDMAppleProfileChange, error) {
 	s.mu.Lock()
-	s.MDMAppleGetEULAMetadataFuncInvoked = true
+	s.ListMDMAppleProfileChangesFuncInvoked = true
 	s.mu.Unlock()
-	return s.MDMAppleGetEULAMetadataFunc(ctx)
+	return s.ListMDMAppleProfileChangesFunc(ctx, status)
 }
 
-func (s *DataStore) MDMAppleGetEULABytes(ctx context.Context, token string) (*fleet.MDMAppleEULA, error) {
+func (s *DataStore) ReviewMDMAppleProfileChange(ctx context.Context, id uint, status fleet.MDMAppleProfileChangeStatus) (*fleet.MDMAppleProfileChange, error) {
 	s.mu.Lock()
-	s.MDMAppleGetEULABytesFuncInvoked = true
+	s.ReviewMDMAppleProfileChangeFuncInvoked = true
 	s.mu.Unlock()
-	return s.MDMAppleGetEULABytesFunc(ctx, token)
+	return s.ReviewMDMAppleProfileChangeFunc(ctx, id, status)
 }
 
-func (s *DataStore) MDMAppleInsertEULA(ctx context.Context, eula *fleet.MDMAppleEULA) error {
+func (s *DataStore) UpsertMDMAppleBlockedEnrollment(ctx context.Context, blocked *fleet.MDMAppleBlockedEnrollment) error {
 	s.mu.Lock()
-	s.MDMAppleInsertEULAFuncInvoked = true
+	s.UpsertMDMAppleBlockedEnrollmentFuncInvoked = true
 	s.mu.Unlock()
-	return s.MDMAppleInsertEULAFunc(ctx, eula)
+	return s.UpsertMDMAppleBlockedEnrollmentFunc(ctx, blocked)
 }
 
-func (s *DataStore) MDMAppleDeleteEULA(ctx context.Context, token string) error {
+func (s *DataStore) DeleteMDMAppleBlockedEnrollments(ctx context.Context, serials []string) error {
 	s.mu.Lock()
-	s.MDMAppleDeleteEULAFuncInvoked = true
+	s.DeleteMDMAppleBlockedEnrollmentsFuncInvoked = true
 	s.mu.Unlock()
-	return s.MDMAppleDeleteEULAFunc(ctx, token)
+	return s.DeleteMDMAppleBlockedEnrollmentsFunc(ctx, serials)
 }
 
-func (s *DataStore) SetOrUpdateMDMAppleSetupAssistant(ctx context.Context, asst *fleet.MDMAppleSetupAssistant) (*fleet.MDMAppleSetupAssistant, error) {
+func (s *DataStore) ListMDMAppleBlockedEnrollments(ctx context.Context, filter fleet.TeamFilter) ([]*fleet.MDMAppleBlockedEnrollment, error) {
 	s.mu.Lock()
-	s.SetOrUpdateMDMAppleSetupAssistantFuncInvoked = true
+	s.ListMDMAppleBlockedEnrollmentsFuncInvoked = true
 	s.mu.Unlock()
-	return s.SetOrUpdateMDMAppleSetupAssistantFunc(ctx, asst)
+	return s.ListMDMAppleBlockedEnrollmentsFunc(ctx, filter)
+}
+
+func (s *DataStore) ListMDMAppleHostUUIDsToRefreshCertificates(ctx context.Context, interval time.Duration, limit int) ([]string, error) {
+	s.mu.Lock()
+	s.ListMDMAppleHostUUIDsToRefreshCertificatesFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleHostUUIDsToRefreshCertificatesFunc(ctx, interval, limit)
+}
+
+func (s *DataStore) SetMDMAppleHostCertificatesRefreshRequested(ctx context.Context, hostUUIDs []string) error {
+	s.mu.Lock()
+	s.SetMDMAppleHostCertificatesRefreshRequestedFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetMDMAppleHostCertificatesRefreshRequestedFunc(ctx, hostUUIDs)
+}
+
+func (s *DataStore) ReplaceHostMDMAppleCertificates(ctx context.Context, hostUUID string, certs []*fleet.HostMDMCertificate) error {
+	s.mu.Lock()
+	s.ReplaceHostMDMAppleCertificatesFuncInvoked = true
+	s.mu.Unlock()
+	return s.ReplaceHostMDMAppleCertificatesFunc(ctx, hostUUID, certs)
+}
+
+func (s *DataStore) UpdateHostMDMAppleManagedCertificates(ctx context.Context, hostUUID string, certs []*fleet.HostMDMCertificate) error {
+	s.mu.Lock()
+	s.UpdateHostMDMAppleManagedCertificatesFuncInvoked = true
+	s.mu.Unlock()
+	return s.UpdateHostMDMAppleManagedCertificatesFunc(ctx, hostUUID, certs)
+}
+
+func (s *DataStore) ListHostMDMAppleCertificates(ctx context.Context, hostUUID string, opt fleet.ListOptions) ([]*fleet.HostMDMCertificate, *fleet.PaginationMetadata, error) {
+	s.mu.Lock()
+	s.ListHostMDMAppleCertificatesFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListHostMDMAppleCertificatesFunc(ctx, hostUUID, opt)
+}
+
+func (s *DataStore) ListMDMAppleHostUUIDsToRefreshProfileList(ctx context.Context, interval time.Duration, limit int) ([]string, error) {
+	s.mu.Lock()
+	s.ListMDMAppleHostUUIDsToRefreshProfileListFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleHostUUIDsToRefreshProfileListFunc(ctx, interval, limit)
+}
+
+func (s *DataStore) SetMDMAppleHostProfileListRefreshRequested(ctx context.Context, hostUUIDs []string) error {
+	s.mu.Lock()
+	s.SetMDMAppleHostProfileListRefreshRequestedFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetMDMAppleHostProfileListRefreshRequestedFunc(ctx, hostUUIDs)
+}
+
+func (s *DataStore) ListHostMDMAppleProfileInstalls(ctx context.Context, hostUUID string) ([]*fleet.HostMDMAppleProfileInstall, error) {
+	s.mu.Lock()
+	s.ListHostMDMAppleProfileInstallsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListHostMDMAppleProfileInstallsFunc(ctx, hostUUID)
+}
+
+func (s *DataStore) UpdateHostMDMAppleProfilesInstalledPayloadUUIDs(ctx context.Context, hostUUID string, payloadUUIDs map[uint]string) error {
+	s.mu.Lock()
+	s.UpdateHostMDMAppleProfilesInstalledPayloadUUIDsFuncInvoked = true
+	s.mu.Unlock()
+	return s.UpdateHostMDMAppleProfilesInstalledPayloadUUIDsFunc(ctx, hostUUID, payloadUUIDs)
+}
+
+func (s *DataStore) SetHostMDMAppleProfilesToReinstall(ctx context.Context, hostUUID string, profileIDs []uint) error {
+	s.mu.Lock()
+	s.SetHostMDMAppleProfilesToReinstallFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetHostMDMAppleProfilesToReinstallFunc(ctx, hostUUID, profileIDs)
+}
+
+func (s *DataStore) NewMDMAppleServerURLMigration(ctx context.Context, oldServerURL string, newServerURL string) (*fleet.MDMAppleServerURLMigration, error) {
+	s.mu.Lock()
+	s.NewMDMAppleServerURLMigrationFuncInvoked = true
+	s.mu.Unlock()
+	return s.NewMDMAppleServerURLMigrationFunc(ctx, oldServerURL, newServerURL)
+}
+
+func (s *DataStore) GetLatestMDMAppleServerURLMigration(ctx context.Context) (*fleet.MDMAppleServerURLMigration, error) {
+	s.mu.Lock()
+	s.GetLatestMDMAppleServerURLMigrationFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetLatestMDMAppleServerURLMigrationFunc(ctx)
+}
+
+func (s *DataStore) ListMDMAppleServerURLMigrationPendingHosts(ctx context.Context, migrationID uint, limit int) ([]string, error) {
+	s.mu.Lock()
+	s.ListMDMAppleServerURLMigrationPendingHostsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleServerURLMigrationPendingHostsFunc(ctx, migrationID, limit)
+}
+
+func (s *DataStore) SetMDMAppleServerURLMigrationHostsSent(ctx context.Context, migrationID uint, hostUUIDs []string, commandUUID string) error {
+	s.mu.Lock()
+	s.SetMDMAppleServerURLMigrationHostsSentFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetMDMAppleServerURLMigrationHostsSentFunc(ctx, migrationID, hostUUIDs, commandUUID)
+}
+
+func (s *DataStore) SetMDMAppleServerURLMigrationHostResult(ctx context.Context, hostUUID string, commandUUID string, status fleet.MDMAppleServerURLMigrationHostStatus, detail string) error {
+	s.mu.Lock()
+	s.SetMDMAppleServerURLMigrationHostResultFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetMDMAppleServerURLMigrationHostResultFunc(ctx, hostUUID, commandUUID, status, detail)
+}
+
+func (s *DataStore) ListMDMAppleSCEPCertificates(ctx context.Context, filter fleet.TeamFilter, opt fleet.MDMAppleSCEPCertificateListOptions) ([]*fleet.MDMAppleSCEPCertificate, *fleet.PaginationMetadata, error) {
+	s.mu.Lock()
+	s.ListMDMAppleSCEPCertificatesFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleSCEPCertificatesFunc(ctx, filter, opt)
+}
+
+func (s *DataStore) ListMDMAppleSCEPIdentityCertificates(ctx context.Context, afterSerial int64, limit uint) ([]*fleet.MDMAppleSCEPIdentityCertificate, error) {
+	s.mu.Lock()
+	s.ListMDMAppleSCEPIdentityCertificatesFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleSCEPIdentityCertificatesFunc(ctx, afterSerial, limit)
+}
+
+func (s *DataStore) SetupMDMAppleConfigAssets(ctx context.Context, assets []fleet.MDMConfigAsset) error {
+	s.mu.Lock()
+	s.SetupMDMAppleConfigAssetsFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetupMDMAppleConfigAssetsFunc(ctx, assets)
+}
+
+func (s *DataStore) GetMDMConfigAssetsByName(ctx context.Context, names []fleet.MDMConfigAssetName) (map[fleet.MDMConfigAssetName]fleet.MDMConfigAsset, error) {
+	s.mu.Lock()
+	s.GetMDMConfigAssetsByNameFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMConfigAssetsByNameFunc(ctx, names)
+}
+
+func (s *DataStore) SetOrUpdateHostOrbitMDMStatus(ctx context.Context, hostID uint, status *fleet.OrbitMDMEnrollmentStatus) error {
+	s.mu.Lock()
+	s.SetOrUpdateHostOrbitMDMStatusFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetOrUpdateHostOrbitMDMStatusFunc(ctx, hostID, status)
+}
+
+func (s *DataStore) ListMDMAppleEnrollmentMismatches(ctx context.Context, teamID *uint, mdmServerURL string) ([]*fleet.MDMAppleEnrollmentMismatch, error) {
+	s.mu.Lock()
+	s.ListMDMAppleEnrollmentMismatchesFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleEnrollmentMismatchesFunc(ctx, teamID, mdmServerURL)
+}
+
+func (s *DataStore) ListMDMAppleHostsMissingFleetd(ctx context.Context, teamID *uint, enrolledBefore time.Time) ([]*fleet.MDMAppleHostMissingFleetd, error) {
+	s.mu.Lock()
+	s.ListMDMAppleHostsMissingFleetdFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleHostsMissingFleetdFunc(ctx, teamID, enrolledBefore)
+}
+
+func (s *DataStore) ReleaseMDMAppleDEPHost(ctx context.Context, serial string) (hostID uint, deleted bool, err error) {
+	s.mu.Lock()
+	s.ReleaseMDMAppleDEPHostFuncInvoked = true
+	s.mu.Unlock()
+	return s.ReleaseMDMAppleDEPHostFunc(ctx, serial)
+}
+
+func (s *DataStore) ListMDMAppleNanoEnrollments(ctx context.Context, opt fleet.MDMAppleNanoEnrollmentListOptions) ([]*fleet.MDMAppleNanoEnrollment, *fleet.PaginationMetadata, error) {
+	s.mu.Lock()
+	s.ListMDMAppleNanoEnrollmentsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleNanoEnrollmentsFunc(ctx, opt)
+}
+
+func (s *DataStore) GetMDMAppleNanoEnrollment(ctx context.Context, id string) (*fleet.MDMAppleNanoEnrollment, error) {
+	s.mu.Lock()
+	s.GetMDMAppleNanoEnrollmentFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleNanoEnrollmentFunc(ctx, id)
+}
+
+func (s *DataStore) DeleteMDMAppleNanoEnrollment(ctx context.Context, id string) error {
+	s.mu.Lock()
+	s.DeleteMDMAppleNanoEnrollmentFuncInvoked = true
+	s.mu.Unlock()
+	return s.DeleteMDMAppleNanoEnrollmentFunc(ctx, id)
+}
+
+func (s *DataStore) LinkMDMAppleNanoEnrollmentToHost(ctx context.Context, id string, hostID uint) error {
+	s.mu.Lock()
+	s.LinkMDMAppleNanoEnrollmentToHostFuncInvoked = true
+	s.mu.Unlock()
+	return s.LinkMDMAppleNanoEnrollmentToHostFunc(ctx, id, hostID)
+}
+
+func (s *DataStore) GetMDMAppleCommandRequestType(ctx context.Context, commandUUID string) (string, error) {
+	s.mu.Lock()
+	s.GetMDMAppleCommandRequestTypeFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleCommandRequestTypeFunc(ctx, commandUUID)
+}
+
+func (s *DataStore) GetMDMAppleHostsProfilesSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleConfigProfilesSummary, error) {
+	s.mu.Lock()
+	s.GetMDMAppleHostsProfilesSummaryFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleHostsProfilesSummaryFunc(ctx, teamID)
+}
+
+func (s *DataStore) ListMDMAppleHostsProfilesSummaryByTeam(ctx context.Context) ([]*fleet.MDMAppleConfigProfilesTeamSummary, error) {
+	s.mu.Lock()
+	s.ListMDMAppleHostsProfilesSummaryByTeamFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleHostsProfilesSummaryByTeamFunc(ctx)
+}
+
+func (s *DataStore) ListMDMAppleReservedPayloadConflicts(ctx context.Context, teamID *uint) ([]fleet.MDMAppleReservedPayloadConflict, error) {
+	s.mu.Lock()
+	s.ListMDMAppleReservedPayloadConflictsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleReservedPayloadConflictsFunc(ctx, teamID)
+}
+
+func (s *DataStore) ListMDMAppleProfileIdentifierConflicts(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+	s.mu.Lock()
+	s.ListMDMAppleProfileIdentifierConflictsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleProfileIdentifierConflictsFunc(ctx, teamID, identifiers)
+}
+
+func (s *DataStore) InsertMDMIdPAccount(ctx context.Context, account *fleet.MDMIdPAccount) error {
+	s.mu.Lock()
+	s.InsertMDMIdPAccountFuncInvoked = true
+	s.mu.Unlock()
+	return s.InsertMDMIdPAccountFunc(ctx, account)
+}
+
+func (s *DataStore) GetMDMIdPAccount(ctx context.Context, uuid string) (*fleet.MDMIdPAccount, error) {
+	s.mu.Lock()
+	s.GetMDMIdPAccountFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMIdPAccountFunc(ctx, uuid)
+}
+
+func (s *DataStore) AssociateHostMDMIdPAccount(ctx context.Context, hostUUID string, accountUUID string) error {
+	s.mu.Lock()
+	s.AssociateHostMDMIdPAccountFuncInvoked = true
+	s.mu.Unlock()
+	return s.AssociateHostMDMIdPAccountFunc(ctx, hostUUID, accountUUID)
+}
+
+func (s *DataStore) SetHostMDMActivationLockBypassCode(ctx context.Context, hostUUID string, base64Encrypted string) error {
+	s.mu.Lock()
+	s.SetHostMDMActivationLockBypassCodeFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetHostMDMActivationLockBypassCodeFunc(ctx, hostUUID, base64Encrypted)
+}
+
+func (s *DataStore) GetHostMDMActivationLockBypassCode(ctx context.Context, hostUUID string) (*fleet.HostMDMActivationLockBypassCode, error) {
+	s.mu.Lock()
+	s.GetHostMDMActivationLockBypassCodeFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetHostMDMActivationLockBypassCodeFunc(ctx, hostUUID)
+}
+
+func (s *DataStore) SetHostMDMAppleLockPIN(ctx context.Context, pin *fleet.HostMDMAppleLockPIN) error {
+	s.mu.Lock()
+	s.SetHostMDMAppleLockPINFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetHostMDMAppleLockPINFunc(ctx, pin)
+}
+
+func (s *DataStore) GetHostMDMAppleLockPIN(ctx context.Context, hostUUID string) (*fleet.HostMDMAppleLockPIN, error) {
+	s.mu.Lock()
+	s.GetHostMDMAppleLockPINFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetHostMDMAppleLockPINFunc(ctx, hostUUID)
+}
+
+func (s *DataStore) GetHostMDMIdPAccount(ctx context.Context, hostUUID string) (*fleet.MDMIdPAccount, error) {
+	s.mu.Lock()
+	s.GetHostMDMIdPAccountFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetHostMDMIdPAccountFunc(ctx, hostUUID)
+}
+
+func (s *DataStore) ListMDMAppleHostQueuedCommands(ctx context.Context, hostUUID string) ([]*fleet.MDMAppleHostQueuedCommand, error) {
+	s.mu.Lock()
+	s.ListMDMAppleHostQueuedCommandsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleHostQueuedCommandsFunc(ctx, hostUUID)
+}
+
+func (s *DataStore) CancelMDMAppleHostQueuedCommand(ctx context.Context, hostUUID string, commandUUID string) error {
+	s.mu.Lock()
+	s.CancelMDMAppleHostQueuedCommandFuncInvoked = true
+	s.mu.Unlock()
+	return s.CancelMDMAppleHostQueuedCommandFunc(ctx, hostUUID, commandUUID)
+}
+
+func (s *DataStore) ListMDMAppleHostCommands(ctx context.Context, hostUUID string, opt fleet.ListOptions) ([]*fleet.MDMAppleHostCommand, *fleet.PaginationMetadata, error) {
+	s.mu.Lock()
+	s.ListMDMAppleHostCommandsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleHostCommandsFunc(ctx, hostUUID, opt)
+}
+
+func (s *DataStore) CleanupHostMDMAppleCommandHistory(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	s.CleanupHostMDMAppleCommandHistoryFuncInvoked = true
+	s.mu.Unlock()
+	return s.CleanupHostMDMAppleCommandHistoryFunc(ctx, now)
+}
+
+func (s *DataStore) NewMDMAppleEnrollmentLink(ctx context.Context, link *fleet.MDMAppleEnrollmentLink) (*fleet.MDMAppleEnrollmentLink, error) {
+	s.mu.Lock()
+	s.NewMDMAppleEnrollmentLinkFuncInvoked = true
+	s.mu.Unlock()
+	return s.NewMDMAppleEnrollmentLinkFunc(ctx, link)
+}
+
+func (s *DataStore) GetMDMAppleEnrollmentLink(ctx context.Context, id uint) (*fleet.MDMAppleEnrollmentLink, error) {
+	s.mu.Lock()
+	s.GetMDMAppleEnrollmentLinkFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleEnrollmentLinkFunc(ctx, id)
+}
+
+func (s *DataStore) GetMDMAppleEnrollmentLinkByToken(ctx context.Context, token string) (*fleet.MDMAppleEnrollmentLink, error) {
+	s.mu.Lock()
+	s.GetMDMAppleEnrollmentLinkByTokenFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleEnrollmentLinkByTokenFunc(ctx, token)
+}
+
+func (s *DataStore) ConsumeMDMAppleEnrollmentLink(ctx context.Context, token string, hostUUID string) (*fleet.MDMAppleEnrollmentLink, error) {
+	s.mu.Lock()
+	s.ConsumeMDMAppleEnrollmentLinkFuncInvoked = true
+	s.mu.Unlock()
+	return s.ConsumeMDMAppleEnrollmentLinkFunc(ctx, token, hostUUID)
+}
+
+func (s *DataStore) NewMDMAppleSSOEnrollmentToken(ctx context.Context, token *fleet.MDMAppleSSOEnrollmentToken) (*fleet.MDMAppleSSOEnrollmentToken, error) {
+	s.mu.Lock()
+	s.NewMDMAppleSSOEnrollmentTokenFuncInvoked = true
+	s.mu.Unlock()
+	return s.NewMDMAppleSSOEnrollmentTokenFunc(ctx, token)
+}
+
+func (s *DataStore) ConsumeMDMAppleSSOEnrollmentToken(ctx context.Context, token string) (*fleet.MDMAppleSSOEnrollmentToken, error) {
+	s.mu.Lock()
+	s.ConsumeMDMAppleSSOEnrollmentTokenFuncInvoked = true
+	s.mu.Unlock()
+	return s.ConsumeMDMAppleSSOEnrollmentTokenFunc(ctx, token)
+}
+
+func (s *DataStore) RevokeMDMAppleSSOEnrollmentToken(ctx context.Context, id uint) (*fleet.MDMAppleSSOEnrollmentToken, error) {
+	s.mu.Lock()
+	s.RevokeMDMAppleSSOEnrollmentTokenFuncInvoked = true
+	s.mu.Unlock()
+	return s.RevokeMDMAppleSSOEnrollmentTokenFunc(ctx, id)
+}
+
+func (s *DataStore) ListMDMAppleSSOEnrollmentTokens(ctx context.Context, opt fleet.ListOptions) ([]*fleet.MDMAppleSSOEnrollmentToken, error) {
+	s.mu.Lock()
+	s.ListMDMAppleSSOEnrollmentTokensFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleSSOEnrollmentTokensFunc(ctx, opt)
+}
+
+func (s *DataStore) EnsureMDMAppleTeamEnrollmentToken(ctx context.Context, teamID *uint, token string) (*fleet.MDMAppleTeamEnrollmentToken, error) {
+	s.mu.Lock()
+	s.EnsureMDMAppleTeamEnrollmentTokenFuncInvoked = true
+	s.mu.Unlock()
+	return s.EnsureMDMAppleTeamEnrollmentTokenFunc(ctx, teamID, token)
+}
+
+func (s *DataStore) SetMDMAppleTeamEnrollmentToken(ctx context.Context, teamID *uint, token string) (*fleet.MDMAppleTeamEnrollmentToken, error) {
+	s.mu.Lock()
+	s.SetMDMAppleTeamEnrollmentTokenFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetMDMAppleTeamEnrollmentTokenFunc(ctx, teamID, token)
+}
+
+func (s *DataStore) GetMDMAppleTeamEnrollmentTokenByToken(ctx context.Context, token string) (*fleet.MDMAppleTeamEnrollmentToken, error) {
+	s.mu.Lock()
+	s.GetMDMAppleTeamEnrollmentTokenByTokenFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleTeamEnrollmentTokenByTokenFunc(ctx, token)
+}
+
+func (s *DataStore) RecordMDMApplePushResults(ctx context.Context, results []fleet.MDMApplePushResult) error {
+	s.mu.Lock()
+	s.RecordMDMApplePushResultsFuncInvoked = true
+	s.mu.Unlock()
+	return s.RecordMDMApplePushResultsFunc(ctx, results)
+}
+
+func (s *DataStore) ListMDMAppleInvalidPushTokenEnrollmentIDs(ctx context.Context, enrollmentIDs []string) ([]string, error) {
+	s.mu.Lock()
+	s.ListMDMAppleInvalidPushTokenEnrollmentIDsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleInvalidPushTokenEnrollmentIDsFunc(ctx, enrollmentIDs)
+}
+
+func (s *DataStore) ClearMDMApplePushFailures(ctx context.Context, enrollmentID string) error {
+	s.mu.Lock()
+	s.ClearMDMApplePushFailuresFuncInvoked = true
+	s.mu.Unlock()
+	return s.ClearMDMApplePushFailuresFunc(ctx, enrollmentID)
+}
+
+func (s *DataStore) GetHostMDMApplePushFailure(ctx context.Context, hostUUID string) (*fleet.HostMDMApplePushFailure, error) {
+	s.mu.Lock()
+	s.GetHostMDMApplePushFailureFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetHostMDMApplePushFailureFunc(ctx, hostUUID)
+}
+
+func (s *DataStore) GetMDMAppleFileVaultSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleFileVaultSummary, error) {
+	s.mu.Lock()
+	s.GetMDMAppleFileVaultSummaryFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleFileVaultSummaryFunc(ctx, teamID)
+}
+
+func (s *DataStore) InsertMDMAppleBootstrapPackage(ctx context.Context, bp *fleet.MDMAppleBootstrapPackage) error {
+	s.mu.Lock()
+	s.InsertMDMAppleBootstrapPackageFuncInvoked = true
+	s.mu.Unlock()
+	return s.InsertMDMAppleBootstrapPackageFunc(ctx, bp)
+}
+
+func (s *DataStore) DeleteMDMAppleBootstrapPackage(ctx context.Context, teamID uint) error {
+	s.mu.Lock()
+	s.DeleteMDMAppleBootstrapPackageFuncInvoked = true
+	s.mu.Unlock()
+	return s.DeleteMDMAppleBootstrapPackageFunc(ctx, teamID)
+}
+
+func (s *DataStore) GetMDMAppleBootstrapPackageMeta(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackage, error) {
+	s.mu.Lock()
+	s.GetMDMAppleBootstrapPackageMetaFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleBootstrapPackageMetaFunc(ctx, teamID)
+}
+
+func (s *DataStore) ListMDMAppleBootstrapPackages(ctx context.Context) ([]*fleet.MDMAppleBootstrapPackage, error) {
+	s.mu.Lock()
+	s.ListMDMAppleBootstrapPackagesFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleBootstrapPackagesFunc(ctx)
+}
+
+func (s *DataStore) SetMDMAppleBootstrapPackageSignatureStatus(ctx context.Context, token string, status fleet.MDMAppleBootstrapPackageSignatureStatus, detail string, verifiedAt time.Time) error {
+	s.mu.Lock()
+	s.SetMDMAppleBootstrapPackageSignatureStatusFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetMDMAppleBootstrapPackageSignatureStatusFunc(ctx, token, status, detail, verifiedAt)
+}
+
+func (s *DataStore) GetMDMAppleBootstrapPackageBytes(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackage, error) {
+	s.mu.Lock()
+	s.GetMDMAppleBootstrapPackageBytesFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleBootstrapPackageBytesFunc(ctx, token)
+}
+
+func (s *DataStore) GetMDMAppleBootstrapPackageSummary(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackageSummary, error) {
+	s.mu.Lock()
+	s.GetMDMAppleBootstrapPackageSummaryFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleBootstrapPackageSummaryFunc(ctx, teamID)
+}
+
+func (s *DataStore) GetMDMAppleOSUpdatesSummary(ctx context.Context, teamID uint) (*fleet.MDMAppleOSUpdatesSummary, error) {
+	s.mu.Lock()
+	s.GetMDMAppleOSUpdatesSummaryFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleOSUpdatesSummaryFunc(ctx, teamID)
+}
+
+func (s *DataStore) RecordHostBootstrapPackage(ctx context.Context, commandUUID string, hostUUID string) error {
+	s.mu.Lock()
+	s.RecordHostBootstrapPackageFuncInvoked = true
+	s.mu.Unlock()
+	return s.RecordHostBootstrapPackageFunc(ctx, commandUUID, hostUUID)
+}
+
+func (s *DataStore) NewMDMAppleBootstrapPackageUpload(ctx context.Context, upload *fleet.MDMAppleBootstrapPackageUpload) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+	s.mu.Lock()
+	s.NewMDMAppleBootstrapPackageUploadFuncInvoked = true
+	s.mu.Unlock()
+	return s.NewMDMAppleBootstrapPackageUploadFunc(ctx, upload)
+}
+
+func (s *DataStore) GetMDMAppleBootstrapPackageUpload(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+	s.mu.Lock()
+	s.GetMDMAppleBootstrapPackageUploadFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleBootstrapPackageUploadFunc(ctx, token)
+}
+
+func (s *DataStore) AppendMDMAppleBootstrapPackageUploadChunk(ctx context.Context, token string, offset int64, chunk []byte) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+	s.mu.Lock()
+	s.AppendMDMAppleBootstrapPackageUploadChunkFuncInvoked = true
+	s.mu.Unlock()
+	return s.AppendMDMAppleBootstrapPackageUploadChunkFunc(ctx, token, offset, chunk)
+}
+
+func (s *DataStore) GetMDMAppleBootstrapPackageUploadChunk(ctx context.Context, uploadID uint, index int) ([]byte, error) {
+	s.mu.Lock()
+	s.GetMDMAppleBootstrapPackageUploadChunkFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMAppleBootstrapPackageUploadChunkFunc(ctx, uploadID, index)
+}
+
+func (s *DataStore) CommitMDMAppleBootstrapPackageUpload(ctx context.Context, token string, pkgToken string) error {
+	s.mu.Lock()
+	s.CommitMDMAppleBootstrapPackageUploadFuncInvoked = true
+	s.mu.Unlock()
+	return s.CommitMDMAppleBootstrapPackageUploadFunc(ctx, token, pkgToken)
+}
+
+func (s *DataStore) CleanupMDMAppleBootstrapPackageUploads(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	s.CleanupMDMAppleBootstrapPackageUploadsFuncInvoked = true
+	s.mu.Unlock()
+	return s.CleanupMDMAppleBootstrapPackageUploadsFunc(ctx, now)
+}
+
+func (s *DataStore) ListMDMAppleAssetTokens(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	s.ListMDMAppleAssetTokensFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMAppleAssetTokensFunc(ctx)
+}
+
+func (s *DataStore) ListHostMDMChanges(ctx context.Context, since uint64, limit int) ([]*fleet.HostMDMChange, error) {
+	s.mu.Lock()
+	s.ListHostMDMChangesFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListHostMDMChangesFunc(ctx, since, limit)
+}
+
+func (s *DataStore) CleanupHostMDMChanges(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	s.CleanupHostMDMChangesFuncInvoked = true
+	s.mu.Unlock()
+	return s.CleanupHostMDMChangesFunc(ctx, now)
+}
+
+func (s *DataStore) GetMDMPostureScoreHostCounts(ctx context.Context, teamID *uint) (*fleet.MDMPostureScoreHostCounts, error) {
+	s.mu.Lock()
+	s.GetMDMPostureScoreHostCountsFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetMDMPostureScoreHostCountsFunc(ctx, teamID)
+}
+
+func (s *DataStore) SaveMDMPostureScores(ctx context.Context, scores []*fleet.MDMPostureScore) error {
+	s.mu.Lock()
+	s.SaveMDMPostureScoresFuncInvoked = true
+	s.mu.Unlock()
+	return s.SaveMDMPostureScoresFunc(ctx, scores)
+}
+
+func (s *DataStore) ListMDMPostureScores(ctx context.Context, teamID *uint, since time.Time) ([]*fleet.MDMPostureScore, error) {
+	s.mu.Lock()
+	s.ListMDMPostureScoresFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListMDMPostureScoresFunc(ctx, teamID, since)
+}
+
+func (s *DataStore) CleanupMDMPostureScores(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	s.CleanupMDMPostureScoresFuncInvoked = true
+	s.mu.Unlock()
+	return s.CleanupMDMPostureScoresFunc(ctx, now)
+}
+
+func (s *DataStore) GetHostMDMMacOSSetup(ctx context.Context, hostID uint) (*fleet.HostMDMMacOSSetup, error) {
+	s.mu.Lock()
+	s.GetHostMDMMacOSSetupFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetHostMDMMacOSSetupFunc(ctx, hostID)
+}
+
+func (s *DataStore) MDMAppleGetEULAMetadata(ctx context.Context) (*fleet.MDMAppleEULA, error) {
+	s.mu.Lock()
+	s.MDMAppleGetEULAMetadataFuncInvoked = true
+	s.mu.Unlock()
+	return s.MDMAppleGetEULAMetadataFunc(ctx)
+}
+
+func (s *DataStore) MDMAppleGetEULABytes(ctx context.Context, token string) (*fleet.MDMAppleEULA, error) {
+	s.mu.Lock()
+	s.MDMAppleGetEULABytesFuncInvoked = true
+	s.mu.Unlock()
+	return s.MDMAppleGetEULABytesFunc(ctx, token)
+}
+
+func (s *DataStore) MDMAppleInsertEULA(ctx context.Context, eula *fleet.MDMAppleEULA) error {
+	s.mu.Lock()
+	s.MDMAppleInsertEULAFuncInvoked = true
+	s.mu.Unlock()
+	return s.MDMAppleInsertEULAFunc(ctx, eula)
+}
+
+func (s *DataStore) MDMAppleDeleteEULA(ctx context.Context, token string) error {
+	s.mu.Lock()
+	s.MDMAppleDeleteEULAFuncInvoked = true
+	s.mu.Unlock()
+	return s.MDMAppleDeleteEULAFunc(ctx, token)
+}
+
+func (s *DataStore) SetOrUpdateMDMAppleSetupAssistant(ctx context.Context, asst *fleet.MDMAppleSetupAssistant) (*fleet.MDMAppleSetupAssistant, error) {
+	s.mu.Lock()
+	s.SetOrUpdateMDMAppleSetupAssistantFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetOrUpdateMDMAppleSetupAssistantFunc(ctx, asst)
 }
 
 func (s *DataStore) GetMDMAppleSetupAssistant(ctx context.Context, teamID *uint) (*fleet.MDMAppleSetupAssistant, error) {
@@ -3631,3 +5715,31 @@ func (s *DataStore) SetMDMAppleSetupAssistantProfileUUID(ctx context.Context, te
 	s.mu.Unlock()
 	return s.SetMDMAppleSetupAssistantProfileUUIDFunc(ctx, teamID, profileUUID)
 }
+
+func (s *DataStore) MDMWindowsInsertEnrolledDevice(ctx context.Context, device *fleet.MDMWindowsEnrolledDevice) error {
+	s.mu.Lock()
+	s.MDMWindowsInsertEnrolledDeviceFuncInvoked = true
+	s.mu.Unlock()
+	return s.MDMWindowsInsertEnrolledDeviceFunc(ctx, device)
+}
+
+func (s *DataStore) MDMWindowsGetEnrolledDevice(ctx context.Context, mdmDeviceID string) (*fleet.MDMWindowsEnrolledDevice, error) {
+	s.mu.Lock()
+	s.MDMWindowsGetEnrolledDeviceFuncInvoked = true
+	s.mu.Unlock()
+	return s.MDMWindowsGetEnrolledDeviceFunc(ctx, mdmDeviceID)
+}
+
+func (s *DataStore) MDMWindowsDeleteEnrolledDevice(ctx context.Context, mdmDeviceID string) error {
+	s.mu.Lock()
+	s.MDMWindowsDeleteEnrolledDeviceFuncInvoked = true
+	s.mu.Unlock()
+	return s.MDMWindowsDeleteEnrolledDeviceFunc(ctx, mdmDeviceID)
+}
+
+func (s *DataStore) MDMWindowsLinkEnrolledDeviceToHost(ctx context.Context, mdmDeviceID string, hostUUID string) error {
+	s.mu.Lock()
+	s.MDMWindowsLinkEnrolledDeviceToHostFuncInvoked = true
+	s.mu.Unlock()
+	return s.MDMWindowsLinkEnrolledDeviceToHostFunc(ctx, mdmDeviceID, hostUUID)
+}
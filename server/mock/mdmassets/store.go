@@ -0,0 +1,78 @@
+// Automatically generated by mockimpl. DO NOT EDIT!
+
+package mock
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+)
+
+var _ fleet.MDMAssetStore = (*MDMAssetStore)(nil)
+
+type GetFunc func(ctx context.Context, kind fleet.MDMAssetKind, token string) (io.ReadCloser, int64, error)
+
+type PutFunc func(ctx context.Context, kind fleet.MDMAssetKind, token string, content io.Reader) error
+
+type DeleteFunc func(ctx context.Context, kind fleet.MDMAssetKind, token string) error
+
+type PathFunc func(kind fleet.MDMAssetKind, token string) string
+
+type CleanupFunc func(ctx context.Context, keep []string, olderThan time.Time) (int, error)
+
+type MDMAssetStore struct {
+	GetFunc        GetFunc
+	GetFuncInvoked bool
+
+	PutFunc        PutFunc
+	PutFuncInvoked bool
+
+	DeleteFunc        DeleteFunc
+	DeleteFuncInvoked bool
+
+	PathFunc        PathFunc
+	PathFuncInvoked bool
+
+	CleanupFunc        CleanupFunc
+	CleanupFuncInvoked bool
+
+	mu sync.Mutex
+}
+
+func (s *MDMAssetStore) Get(ctx context.Context, kind fleet.MDMAssetKind, token string) (io.ReadCloser, int64, error) {
+	s.mu.Lock()
+	s.GetFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetFunc(ctx, kind, token)
+}
+
+func (s *MDMAssetStore) Put(ctx context.Context, kind fleet.MDMAssetKind, token string, content io.Reader) error {
+	s.mu.Lock()
+	s.PutFuncInvoked = true
+	s.mu.Unlock()
+	return s.PutFunc(ctx, kind, token, content)
+}
+
+func (s *MDMAssetStore) Delete(ctx context.Context, kind fleet.MDMAssetKind, token string) error {
+	s.mu.Lock()
+	s.DeleteFuncInvoked = true
+	s.mu.Unlock()
+	return s.DeleteFunc(ctx, kind, token)
+}
+
+func (s *MDMAssetStore) Path(kind fleet.MDMAssetKind, token string) string {
+	s.mu.Lock()
+	s.PathFuncInvoked = true
+	s.mu.Unlock()
+	return s.PathFunc(kind, token)
+}
+
+func (s *MDMAssetStore) Cleanup(ctx context.Context, keep []string, olderThan time.Time) (int, error) {
+	s.mu.Lock()
+	s.CleanupFuncInvoked = true
+	s.mu.Unlock()
+	return s.CleanupFunc(ctx, keep, olderThan)
+}
@@ -31,12 +31,17 @@ type FailingPolicyAutomationConfig struct {
 	PolicyIDs      map[uint]bool
 	WebhookURL     *url.URL // for webhook automation type only
 	HostBatchSize  int      // for webhook automation type only
+
+	// MDMAction is the MDM action to run on the failing hosts in addition to
+	// the automation, nil if the policy has none. If it is set, AutomationType
+	// may be empty if the policy has no other automation.
+	MDMAction *fleet.MDMApplePolicyAction
 }
 
 // TriggerFailingPoliciesAutomation triggers an automation for failing
 // policies. It receives a function that takes care of sending the failed
 // policy as argument, that function receives the type of automation that is
-// enabled for that policy.
+// enabled for that policy and its MDM action, if any.
 func TriggerFailingPoliciesAutomation(
 	ctx context.Context,
 	ds fleet.Datastore,
@@ -81,6 +86,18 @@ func TriggerFailingPoliciesAutomation(
 		}
 	}
 
+	// load the MDM actions of the policies, only if Fleet MDM is configured.
+	mdmActions := make(map[uint]*fleet.MDMApplePolicyAction)
+	if appConfig.MDM.EnabledAndConfigured {
+		actions, err := ds.ListMDMApplePolicyActions(ctx)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "list policy mdm actions")
+		}
+		for _, a := range actions {
+			mdmActions[a.PolicyID] = a
+		}
+	}
+
 	// prepare the per-team configuration caches
 	getTeam := makeTeamConfigCache(ds, appConfig.Integrations)
 
@@ -118,17 +135,23 @@ func TriggerFailingPoliciesAutomation(
 				continue
 			}
 
-			if teamCfg.AutomationType == "" {
+			mdmAction := mdmActions[policy.ID]
+			if teamCfg.AutomationType == "" && mdmAction == nil {
 				continue
 			}
 
 			if !teamCfg.PolicyIDs[policy.ID] {
-				level.Debug(logger).Log("msg", "skipping failing policy, not found in team policy IDs", "policyID", policyID)
-				if err := failingPoliciesSet.RemoveSet(policy.ID); err != nil {
-					level.Error(logger).Log("msg", "failed to remove policy from set", "policyID", policyID, "err", err)
+				if mdmAction == nil {
+					level.Debug(logger).Log("msg", "skipping failing policy, not found in team policy IDs", "policyID", policyID)
+					if err := failingPoliciesSet.RemoveSet(policy.ID); err != nil {
+						level.Error(logger).Log("msg", "failed to remove policy from set", "policyID", policyID, "err", err)
+					}
+					continue
 				}
-				continue
+				// only the MDM action runs for that policy
+				teamCfg = FailingPolicyAutomationConfig{}
 			}
+			teamCfg.MDMAction = mdmAction
 
 			if err := sendFunc(policy, teamCfg); err != nil {
 				level.Error(logger).Log("msg", "failed to send failing policies", "policyID", policy.ID, "err", err)
@@ -137,15 +160,22 @@ func TriggerFailingPoliciesAutomation(
 		}
 
 		// handle global policy
-		if !globalCfg.PolicyIDs[policy.ID] {
-			level.Debug(logger).Log("msg", "skipping failing policy, not found in global policy IDs", "policyID", policyID)
-			if err := failingPoliciesSet.RemoveSet(policy.ID); err != nil {
-				level.Error(logger).Log("msg", "failed to remove policy from set", "policyID", policyID, "err", err)
+		cfg := globalCfg
+		mdmAction := mdmActions[policy.ID]
+		if !cfg.PolicyIDs[policy.ID] {
+			if mdmAction == nil {
+				level.Debug(logger).Log("msg", "skipping failing policy, not found in global policy IDs", "policyID", policyID)
+				if err := failingPoliciesSet.RemoveSet(policy.ID); err != nil {
+					level.Error(logger).Log("msg", "failed to remove policy from set", "policyID", policyID, "err", err)
+				}
+				continue
 			}
-			continue
+			// only the MDM action runs for that policy
+			cfg = FailingPolicyAutomationConfig{}
 		}
+		cfg.MDMAction = mdmAction
 
-		if err := sendFunc(policy, globalCfg); err != nil {
+		if err := sendFunc(policy, cfg); err != nil {
 			level.Error(logger).Log("msg", "failed to send failing policies", "policyID", policy.ID, "err", err)
 		}
 	}
@@ -225,3 +225,92 @@ func TestTriggerFailingPolicies(t *testing.T) {
 	require.ElementsMatch(t, wantCalls, triggerCalls)
 	require.Zero(t, countHosts)
 }
+
+func TestTriggerFailingPoliciesMDMActions(t *testing.T) {
+	ds := new(mock.Store)
+
+	// pol-global-{1-3}: global policies, 1 has the webhook automation and an
+	// MDM action, 2 has only an MDM action, 3 has neither.
+	// pol-teamA-{4-5}: team A policies, team A has no automation, 4 has an MDM
+	// action.
+	pols := map[uint]*fleet.PolicyData{
+		1: {ID: 1, Name: "pol-global-1"},
+		2: {ID: 2, Name: "pol-global-2"},
+		3: {ID: 3, Name: "pol-global-3"},
+		4: {ID: 4, Name: "pol-teamA-4", TeamID: ptr.Uint(1)},
+		5: {ID: 5, Name: "pol-teamA-5", TeamID: ptr.Uint(1)},
+	}
+	ds.PolicyFunc = func(ctx context.Context, id uint) (*fleet.Policy, error) {
+		pd, ok := pols[id]
+		if !ok {
+			return nil, ctxerr.Wrap(ctx, sql.ErrNoRows)
+		}
+		return &fleet.Policy{PolicyData: *pd}, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, id uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: id, Name: "teamA"}, nil
+	}
+	ds.ListMDMApplePolicyActionsFunc = func(ctx context.Context) ([]*fleet.MDMApplePolicyAction, error) {
+		return []*fleet.MDMApplePolicyAction{
+			{PolicyID: 1, CommandTemplate: "cmd1"},
+			{PolicyID: 2, CommandTemplate: "cmd2"},
+			{PolicyID: 4, CommandTemplate: "cmd4"},
+		}, nil
+	}
+
+	ac := &fleet.AppConfig{
+		WebhookSettings: fleet.WebhookSettings{
+			FailingPoliciesWebhook: fleet.FailingPoliciesWebhookSettings{
+				Enable:         true,
+				DestinationURL: "https://webhook.example.com",
+				PolicyIDs:      []uint{1},
+			},
+		},
+		MDM: fleet.MDM{EnabledAndConfigured: true},
+	}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return ac, nil
+	}
+
+	type policyAutomation struct {
+		polID      uint
+		automation FailingPolicyAutomationType
+		mdmCommand string
+	}
+	trigger := func() []policyAutomation {
+		failingPolicySet := service.NewMemFailingPolicySet()
+		for polID := range pols {
+			err := failingPolicySet.AddHost(polID, fleet.PolicySetHost{ID: polID})
+			require.NoError(t, err)
+		}
+
+		var calls []policyAutomation
+		err := TriggerFailingPoliciesAutomation(context.Background(), ds, kitlog.NewNopLogger(), failingPolicySet, func(pol *fleet.Policy, cfg FailingPolicyAutomationConfig) error {
+			call := policyAutomation{polID: pol.ID, automation: cfg.AutomationType}
+			if cfg.MDMAction != nil {
+				call.mdmCommand = cfg.MDMAction.CommandTemplate
+			}
+			calls = append(calls, call)
+			return nil
+		})
+		require.NoError(t, err)
+		return calls
+	}
+
+	calls := trigger()
+	require.ElementsMatch(t, []policyAutomation{
+		{1, FailingPolicyWebhook, "cmd1"},
+		{2, "", "cmd2"},
+		{4, "", "cmd4"},
+	}, calls)
+	require.True(t, ds.ListMDMApplePolicyActionsFuncInvoked)
+
+	// the MDM actions are ignored if Fleet MDM is not configured
+	ds.ListMDMApplePolicyActionsFuncInvoked = false
+	ac.MDM.EnabledAndConfigured = false
+	calls = trigger()
+	require.ElementsMatch(t, []policyAutomation{
+		{1, FailingPolicyWebhook, ""},
+	}, calls)
+	require.False(t, ds.ListMDMApplePolicyActionsFuncInvoked)
+}
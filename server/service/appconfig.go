@@ -13,13 +13,17 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/fleetdm/fleet/v4/server/authz"
 	authz_ctx "github.com/fleetdm/fleet/v4/server/contexts/authz"
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/contexts/license"
+	"github.com/fleetdm/fleet/v4/server/contexts/logging"
 	"github.com/fleetdm/fleet/v4/server/contexts/viewer"
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/mdm/assetcdn"
 	"github.com/go-kit/kit/log/level"
 	"github.com/kolide/kit/version"
 )
@@ -45,8 +49,11 @@ type appConfigResponseFields struct {
 	// Email is returned when the email backend is something other than SMTP, for example SES
 	Email *fleet.EmailConfig `json:"email,omitempty"`
 	// SandboxEnabled is true if fleet serve was ran with server.sandbox_enabled=true
-	SandboxEnabled bool  `json:"sandbox_enabled,omitempty"`
-	Err            error `json:"error,omitempty"`
+	SandboxEnabled bool `json:"sandbox_enabled,omitempty"`
+	// MDMCronHealth is the health of the MDM cron jobs, computed from their
+	// latest runs.
+	MDMCronHealth []*fleet.MDMCronJobHealth `json:"mdm_cron_health,omitempty"`
+	Err           error                     `json:"error,omitempty"`
 }
 
 // UnmarshalJSON implements the json.Unmarshaler interface to make sure we serialize
@@ -97,6 +104,10 @@ func getAppConfigEndpoint(ctx context.Context, request interface{}, svc fleet.Se
 	if err != nil {
 		return nil, err
 	}
+	mdmCronHealth, err := svc.MDMCronHealth(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	var smtpSettings fleet.SMTPSettings
 	var ssoSettings fleet.SSOSettings
@@ -143,6 +154,7 @@ func getAppConfigEndpoint(ctx context.Context, request interface{}, svc fleet.Se
 			Logging:         loggingConfig,
 			Email:           emailConfig,
 			SandboxEnabled:  svc.SandboxEnabled(),
+			MDMCronHealth:   mdmCronHealth,
 		},
 	}
 	return response, nil
@@ -232,6 +244,8 @@ func (svc *Service) ModifyAppConfig(ctx context.Context, p []byte, applyOpts fle
 	license, _ := license.FromContext(ctx)
 
 	oldSmtpSettings := appConfig.SMTPSettings
+	oldMDMEventsWebhookSecret := appConfig.WebhookSettings.MDMEventsWebhook.Secret
+	oldMDMAssetCDNPrivateKey := appConfig.MDM.AssetCDN.PrivateKey
 	oldAgentOptions := ""
 	if appConfig.AgentOptions != nil {
 		oldAgentOptions = string(*appConfig.AgentOptions)
@@ -314,18 +328,29 @@ func (svc *Service) ModifyAppConfig(ctx context.Context, p []byte, applyOpts fle
 	fleet.ValidateEnabledVulnerabilitiesIntegrations(appConfig.WebhookSettings.VulnerabilitiesWebhook, appConfig.Integrations, invalid)
 	fleet.ValidateEnabledFailingPoliciesIntegrations(appConfig.WebhookSettings.FailingPoliciesWebhook, appConfig.Integrations, invalid)
 	fleet.ValidateEnabledHostStatusIntegrations(appConfig.WebhookSettings.HostStatusWebhook, invalid)
+	// the secret is obfuscated when the config is read, keep the stored one if
+	// it is provided back as-is.
+	if appConfig.WebhookSettings.MDMEventsWebhook.Secret == fleet.MaskedPassword {
+		appConfig.WebhookSettings.MDMEventsWebhook.Secret = oldMDMEventsWebhookSecret
+	}
+	fleet.ValidateEnabledMDMEventsIntegrations(appConfig.WebhookSettings.MDMEventsWebhook, invalid)
+	if appConfig.MDM.AssetCDN.PrivateKey == fleet.MaskedPassword {
+		appConfig.MDM.AssetCDN.PrivateKey = oldMDMAssetCDNPrivateKey
+	}
+	appConfig.HostExpirySettings.HostExpiryMDMEnrolled.Validate(invalid)
 	svc.validateMDM(ctx, license, &oldAppConfig.MDM, &appConfig.MDM, invalid)
 
 	if invalid.HasErrors() {
 		return nil, ctxerr.Wrap(ctx, invalid)
 	}
 
-	// ignore MDM.EnabledAndConfigured MDM.AppleBMTermsExpired, and MDM.AppleBMEnabledAndConfigured
+	// ignore MDM.EnabledAndConfigured, MDM.WindowsEnabledAndConfigured, MDM.AppleBMTermsExpired, and MDM.AppleBMEnabledAndConfigured
 	// if provided in the modify payload we don't return an error in this case because it would
 	// prevent using the output of fleetctl get config as input to fleetctl apply or this endpoint.
 	appConfig.MDM.AppleBMTermsExpired = oldAppConfig.MDM.AppleBMTermsExpired
 	appConfig.MDM.AppleBMEnabledAndConfigured = oldAppConfig.MDM.AppleBMEnabledAndConfigured
 	appConfig.MDM.EnabledAndConfigured = oldAppConfig.MDM.EnabledAndConfigured
+	appConfig.MDM.WindowsEnabledAndConfigured = oldAppConfig.MDM.WindowsEnabledAndConfigured
 
 	// do not send a test email in dry-run mode, so this is a good place to stop
 	// (we also delete the removed integrations after that, which we don't want
@@ -423,6 +448,15 @@ func (svc *Service) ModifyAppConfig(ctx context.Context, p []byte, applyOpts fle
 		}
 	}
 
+	if oldAppConfig.MDM.MacOSSetup.EULA.Value != appConfig.MDM.MacOSSetup.EULA.Value &&
+		appConfig.MDM.MacOSSetup.EULA.Value == "" {
+		// clear the EULA, same as above we have to go through the Enterprise
+		// extensions.
+		if err := svc.EnterpriseOverrides.DeleteMDMAppleEULA(ctx); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "delete Apple EULA")
+		}
+	}
+
 	// retrieve new app config with obfuscated secrets
 	obfuscatedAppConfig, err := svc.ds.AppConfig(ctx)
 	if err != nil {
@@ -493,12 +527,46 @@ func (svc *Service) validateMDM(
 	if mdm.MacOSSettings.EnableDiskEncryption && !license.IsPremium() {
 		invalid.Append("macos_settings.enable_disk_encryption", ErrMissingLicense.Error())
 	}
+	if err := mdm.MacOSSettings.ProfileFailureGracePeriod.Validate(); err != nil {
+		invalid.Append("macos_settings.profile_failure_grace_period", err.Error())
+	}
+	if err := mdm.MacOSSettings.ProfileRetryPolicy.Validate(); err != nil {
+		invalid.Append("macos_settings.profile_retry_policy", err.Error())
+	}
+	if err := mdm.MacOSSettings.ValidateCustomSettingsExclusions(); err != nil {
+		invalid.Append("macos_settings.custom_settings_exclusions", err.Error())
+	}
+	if ttl := mdm.DiskEncryptionKeyViewTTL.Duration; ttl < 0 || ttl > fleet.MaxDiskEncryptionKeyViewTTL {
+		invalid.Append("disk_encryption_key_view_ttl", fmt.Sprintf("must be between 0 and %s", fleet.MaxDiskEncryptionKeyViewTTL))
+	}
+	if mdm.ProfileChangeApproval.HostThreshold < 0 {
+		invalid.Append("profile_change_approval.host_threshold", "must not be negative")
+	}
+	if mdm.ProfileChangeApproval.Expiry.Duration < 0 {
+		invalid.Append("profile_change_approval.expiry", "must not be negative")
+	}
+	if w := mdm.PostureScore.Weights; w.DiskEncryption < 0 || w.Profiles < 0 || w.Enrollment < 0 || w.OSCurrency < 0 {
+		invalid.Append("posture_score.weights", "must not be negative")
+	}
+	if err := mdm.Localization.Validate(); err != nil {
+		invalid.Append("localization", err.Error())
+	}
+	if mdm.AssetCDN.Enable {
+		if !license.IsPremium() {
+			invalid.Append("asset_cdn.enable", ErrMissingLicense.Error())
+		} else if _, err := assetcdn.New(mdm.AssetCDN); err != nil {
+			invalid.Append("asset_cdn", err.Error())
+		}
+	}
 	if oldMdm.MacOSSetup.MacOSSetupAssistant.Value != mdm.MacOSSetup.MacOSSetupAssistant.Value && !license.IsPremium() {
 		invalid.Append("macos_setup.macos_setup_assistant", ErrMissingLicense.Error())
 	}
 	if oldMdm.MacOSSetup.BootstrapPackage.Value != mdm.MacOSSetup.BootstrapPackage.Value && !license.IsPremium() {
 		invalid.Append("macos_setup.bootstrap_package", ErrMissingLicense.Error())
 	}
+	if oldMdm.MacOSSetup.EULA.Value != mdm.MacOSSetup.EULA.Value && !license.IsPremium() {
+		invalid.Append("macos_setup.eula", ErrMissingLicense.Error())
+	}
 
 	// we want to use `oldMdm` here as this boolean is set by the fleet
 	// server at startup and can't be modified by the user
@@ -522,6 +590,11 @@ func (svc *Service) validateMDM(
 			invalid.Append("macos_setup.bootstrap_package",
 				`Couldn't update macos_setup because MDM features aren't turned on in Fleet. Use fleetctl generate mdm-apple and then fleet serve with mdm configuration to turn on MDM features.`)
 		}
+
+		if oldMdm.MacOSSetup.EULA.Value != mdm.MacOSSetup.EULA.Value {
+			invalid.Append("macos_setup.eula",
+				`Couldn't update macos_setup because MDM features aren't turned on in Fleet. Use fleetctl generate mdm-apple and then fleet serve with mdm configuration to turn on MDM features.`)
+		}
 	}
 
 	if name := mdm.AppleBMDefaultTeam; name != "" && name != oldMdm.AppleBMDefaultTeam {
@@ -534,6 +607,16 @@ func (svc *Service) validateMDM(
 		}
 	}
 
+	for i, family := range mdm.AppleBMDefaultDeviceFamilies {
+		normalized, ok := fleet.NormalizeAppleBMDeviceFamily(family)
+		if !ok {
+			invalid.Append("mdm.apple_bm_default_device_families",
+				fmt.Sprintf("unknown device family %q, must be one of %s", family, strings.Join(fleet.AppleBMDeviceFamilies, ", ")))
+			continue
+		}
+		mdm.AppleBMDefaultDeviceFamilies[i] = normalized
+	}
+
 	// MacOSUpdates
 	updatingVersion := mdm.MacOSUpdates.MinimumVersion != "" &&
 		mdm.MacOSUpdates.MinimumVersion != oldMdm.MacOSUpdates.MinimumVersion
@@ -560,6 +643,29 @@ func (svc *Service) validateMDM(
 
 		validateSSOProviderSettings(mdm.EndUserAuthentication.SSOProviderSettings, oldMdm.EndUserAuthentication.SSOProviderSettings, invalid)
 	}
+
+	if mdm.EndUserAuthentication.AttributeMapping != oldMdm.EndUserAuthentication.AttributeMapping && !license.IsPremium() {
+		invalid.Append("end_user_authentication.attribute_mapping", ErrMissingLicense.Error())
+	}
+
+	if !mdm.EndUserAuthentication.TeamRules.Equal(oldMdm.EndUserAuthentication.TeamRules) {
+		if !license.IsPremium() {
+			invalid.Append("end_user_authentication.team_rules", ErrMissingLicense.Error())
+			return
+		}
+		if len(mdm.EndUserAuthentication.TeamRules) > 0 && mdm.EndUserAuthentication.AttributeMapping.Groups == "" {
+			invalid.Append("end_user_authentication.team_rules", "attribute_mapping.groups is required to use team rules")
+		}
+		for _, rule := range mdm.EndUserAuthentication.TeamRules {
+			if rule.Group == "" {
+				invalid.Append("end_user_authentication.team_rules", "group is required")
+				continue
+			}
+			if _, err := svc.ds.TeamByName(ctx, rule.Team); err != nil {
+				invalid.Append("end_user_authentication.team_rules", fmt.Sprintf("team name not found: %q", rule.Team))
+			}
+		}
+	}
 }
 
 func validateSSOProviderSettings(incoming, existing fleet.SSOProviderSettings, invalid *fleet.InvalidArgumentError) {
@@ -676,6 +782,95 @@ func (svc *Service) GetEnrollSecretSpec(ctx context.Context) (*fleet.EnrollSecre
 	return &fleet.EnrollSecretSpec{Secrets: secrets}, nil
 }
 
+// //////////////////////////////////////////////////////////////////////////////
+// Download fleetd configuration profile
+// //////////////////////////////////////////////////////////////////////////////
+
+type getFleetdProfileRequest struct {
+	TeamID *uint `query:"team_id,optional"`
+}
+
+type getFleetdProfileResponse struct {
+	Err error `json:"error,omitempty"`
+
+	// Profile field is used in hijackRender for the response.
+	Profile []byte
+}
+
+func (r getFleetdProfileResponse) error() error { return r.Err }
+
+func (r getFleetdProfileResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
+	w.Header().Set("Content-Length", strconv.FormatInt(int64(len(r.Profile)), 10))
+	w.Header().Set("Content-Type", "application/x-apple-aspen-config")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", `attachment;filename="fleetd-configuration.mobileconfig"`)
+
+	if n, err := w.Write(r.Profile); err != nil {
+		logging.WithExtras(ctx, "err", err, "written", n)
+	}
+}
+
+func getFleetdProfileEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getFleetdProfileRequest)
+	profile, err := svc.GetFleetdProfile(ctx, req.TeamID)
+	if err != nil {
+		return getFleetdProfileResponse{Err: err}, nil
+	}
+	return getFleetdProfileResponse{Profile: profile}, nil
+}
+
+func (svc *Service) GetFleetdProfile(ctx context.Context, teamID *uint) ([]byte, error) {
+	if teamID != nil && *teamID == 0 {
+		teamID = nil
+	}
+	if err := svc.authz.Authorize(ctx, fleet.FleetdProfileAuthz{TeamID: teamID}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	var secrets []*fleet.EnrollSecret
+	if teamID != nil {
+		if !license.IsPremium(ctx) {
+			return nil, fleet.ErrMissingLicense
+		}
+		if _, err := svc.ds.Team(ctx, *teamID); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "get team")
+		}
+		teamSecrets, err := svc.ds.TeamEnrollSecrets(ctx, *teamID)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "get team enroll secrets")
+		}
+		secrets = teamSecrets
+	}
+	// like the profile delivered by Fleet's MDM, the global enroll secret is
+	// used for the teams that don't have one, but only for the users that can
+	// download the global profile as the secret is embedded in the profile.
+	if len(secrets) == 0 {
+		if teamID != nil {
+			if err := svc.authz.Authorize(ctx, fleet.FleetdProfileAuthz{}, fleet.ActionRead); err != nil {
+				return nil, err
+			}
+		}
+		globalSecrets, err := svc.ds.GetEnrollSecrets(ctx, nil)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "get global enroll secrets")
+		}
+		secrets = globalSecrets
+	}
+	if len(secrets) == 0 {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("team_id", "there's no enroll secret to configure fleetd with"))
+	}
+
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get app config")
+	}
+	profile, err := generateFleetdProfile(appCfg.ServerSettings.ServerURL, secrets[0].Secret)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "generate fleetd profile")
+	}
+	return profile, nil
+}
+
 // //////////////////////////////////////////////////////////////////////////////
 // Version
 // //////////////////////////////////////////////////////////////////////////////
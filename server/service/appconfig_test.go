@@ -11,8 +11,10 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/pkg/optjson"
+	"github.com/fleetdm/fleet/v4/server/authz"
 	"github.com/fleetdm/fleet/v4/server/config"
 	"github.com/fleetdm/fleet/v4/server/contexts/viewer"
 	"github.com/fleetdm/fleet/v4/server/fleet"
@@ -296,6 +298,67 @@ func TestApplyEnrollSecretWithGlobalEnrollConfig(t *testing.T) {
 	require.False(t, ds.ApplyEnrollSecretsFuncInvoked)
 }
 
+func TestGetFleetdProfile(t *testing.T) {
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil, &TestServerOpts{License: &fleet.LicenseInfo{Tier: fleet.TierPremium}})
+
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{ServerSettings: fleet.ServerSettings{ServerURL: "https://fleet.example.com"}}, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		if tid != 1 && tid != 2 {
+			return nil, &notFoundError{}
+		}
+		return &fleet.Team{ID: tid}, nil
+	}
+	ds.TeamEnrollSecretsFunc = func(ctx context.Context, teamID uint) ([]*fleet.EnrollSecret, error) {
+		if teamID == 1 {
+			return []*fleet.EnrollSecret{{Secret: "team-secret", TeamID: ptr.Uint(1)}}, nil
+		}
+		return nil, nil
+	}
+	ds.GetEnrollSecretsFunc = func(ctx context.Context, teamID *uint) ([]*fleet.EnrollSecret, error) {
+		return []*fleet.EnrollSecret{{Secret: "global-secret"}}, nil
+	}
+
+	// observer_plus users can download the profile, but not read the secrets
+	ctx = test.UserContext(ctx, test.UserObserverPlus)
+	_, err := svc.GetEnrollSecretSpec(ctx)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+
+	profile, err := svc.GetFleetdProfile(ctx, nil)
+	require.NoError(t, err)
+	require.Contains(t, string(profile), "<string>global-secret</string>")
+	require.Contains(t, string(profile), "<string>https://fleet.example.com</string>")
+
+	profile, err = svc.GetFleetdProfile(ctx, ptr.Uint(1))
+	require.NoError(t, err)
+	require.Contains(t, string(profile), "<string>team-secret</string>")
+
+	// the global secret is used for the teams without secrets
+	profile, err = svc.GetFleetdProfile(ctx, ptr.Uint(2))
+	require.NoError(t, err)
+	require.Contains(t, string(profile), "<string>global-secret</string>")
+
+	_, err = svc.GetFleetdProfile(ctx, ptr.Uint(3))
+	require.True(t, fleet.IsNotFound(err))
+
+	// team users don't get the global secret
+	teamCtx := test.UserContext(ctx, &fleet.User{ID: 42, Teams: []fleet.UserTeam{
+		{Team: fleet.Team{ID: 1}, Role: fleet.RoleObserverPlus},
+		{Team: fleet.Team{ID: 2}, Role: fleet.RoleObserverPlus},
+	}})
+	profile, err = svc.GetFleetdProfile(teamCtx, ptr.Uint(1))
+	require.NoError(t, err)
+	require.Contains(t, string(profile), "<string>team-secret</string>")
+	_, err = svc.GetFleetdProfile(teamCtx, ptr.Uint(2))
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+
+	// observers can't download it
+	_, err = svc.GetFleetdProfile(test.UserContext(ctx, test.UserObserver), nil)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+}
+
 func TestCertificateChain(t *testing.T) {
 	server, teardown := setupCertificateChain(t)
 	defer teardown()
@@ -768,6 +831,56 @@ func TestTransparencyURLDowngradeLicense(t *testing.T) {
 	require.Equal(t, "", ac.FleetDesktop.TransparencyURL)
 }
 
+func TestModifyAppConfigMDMEventsWebhook(t *testing.T) {
+	ds := new(mock.Store)
+
+	admin := &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}
+
+	svc, ctx := newTestService(t, ds, nil, nil)
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: admin})
+
+	dsAppConfig := &fleet.AppConfig{
+		OrgInfo: fleet.OrgInfo{
+			OrgName: "Test",
+		},
+		ServerSettings: fleet.ServerSettings{
+			ServerURL: "https://example.org",
+		},
+	}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return dsAppConfig.Copy(), nil
+	}
+	ds.SaveAppConfigFunc = func(ctx context.Context, conf *fleet.AppConfig) error {
+		*dsAppConfig = *conf
+		return nil
+	}
+
+	// a destination URL is required to enable the webhook
+	_, err := svc.ModifyAppConfig(ctx, []byte(`{"webhook_settings": {"mdm_events_webhook": {"enable_mdm_events_webhook": true}}}`), fleet.ApplySpecOptions{})
+	require.ErrorContains(t, err, "destination_url is required")
+	_, err = svc.ModifyAppConfig(ctx, []byte(`{"webhook_settings": {"mdm_events_webhook": {"enable_mdm_events_webhook": true, "destination_url": "not a url"}}}`), fleet.ApplySpecOptions{})
+	require.ErrorContains(t, err, "destination_url must be a valid http or https URL")
+
+	_, err = svc.ModifyAppConfig(ctx, []byte(`{"webhook_settings": {"mdm_events_webhook": {
+		"enable_mdm_events_webhook": true, "destination_url": "https://example.com/mdm", "secret": "s3cr3t"
+	}}}`), fleet.ApplySpecOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "s3cr3t", dsAppConfig.WebhookSettings.MDMEventsWebhook.Secret)
+
+	// the secret is obfuscated when read
+	ac, err := svc.AppConfigObfuscated(ctx)
+	require.NoError(t, err)
+	require.Equal(t, fleet.MaskedPassword, ac.WebhookSettings.MDMEventsWebhook.Secret)
+
+	// applying the obfuscated secret back keeps the stored one
+	raw, err := json.Marshal(ac.WebhookSettings)
+	require.NoError(t, err)
+	_, err = svc.ModifyAppConfig(ctx, []byte(`{"webhook_settings": `+string(raw)+`}`), fleet.ApplySpecOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "s3cr3t", dsAppConfig.WebhookSettings.MDMEventsWebhook.Secret)
+	require.Equal(t, "https://example.com/mdm", dsAppConfig.WebhookSettings.MDMEventsWebhook.DestinationURL)
+}
+
 func TestMDMAppleConfig(t *testing.T) {
 	ds := new(mock.Store)
 	depStorage := new(nanodep_mock.Storage)
@@ -800,7 +913,7 @@ func TestMDMAppleConfig(t *testing.T) {
 			name:        "nochange",
 			licenseTier: "free",
 			expectedMDM: fleet.MDM{
-				MacOSSetup: fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}},
+				MacOSSetup: fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}, EULA: optjson.String{Set: true}},
 			},
 		}, {
 			name:          "newDefaultTeamNoLicense",
@@ -825,7 +938,7 @@ func TestMDMAppleConfig(t *testing.T) {
 			newMDM:      fleet.MDM{AppleBMDefaultTeam: "foobar"},
 			expectedMDM: fleet.MDM{
 				AppleBMDefaultTeam: "foobar",
-				MacOSSetup:         fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}},
+				MacOSSetup:         fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}, EULA: optjson.String{Set: true}},
 			},
 		}, {
 			name:        "foundEdit",
@@ -835,7 +948,7 @@ func TestMDMAppleConfig(t *testing.T) {
 			newMDM:      fleet.MDM{AppleBMDefaultTeam: "foobar"},
 			expectedMDM: fleet.MDM{
 				AppleBMDefaultTeam: "foobar",
-				MacOSSetup:         fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}},
+				MacOSSetup:         fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}, EULA: optjson.String{Set: true}},
 			},
 		}, {
 			name:          "ssoFree",
@@ -851,7 +964,7 @@ func TestMDMAppleConfig(t *testing.T) {
 			oldMDM:      fleet.MDM{EndUserAuthentication: fleet.MDMEndUserAuthentication{SSOProviderSettings: fleet.SSOProviderSettings{EntityID: "foo"}}},
 			expectedMDM: fleet.MDM{
 				EndUserAuthentication: fleet.MDMEndUserAuthentication{SSOProviderSettings: fleet.SSOProviderSettings{EntityID: "foo"}},
-				MacOSSetup:            fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}},
+				MacOSSetup:            fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}, EULA: optjson.String{Set: true}},
 			},
 		}, {
 			name:        "ssoAllFields",
@@ -870,7 +983,7 @@ func TestMDMAppleConfig(t *testing.T) {
 					MetadataURL: "http://isser.metadata.com",
 					IDPName:     "onelogin",
 				}},
-				MacOSSetup: fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}},
+				MacOSSetup: fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}, EULA: optjson.String{Set: true}},
 			},
 		}, {
 			name:        "ssoShortEntityID",
@@ -915,6 +1028,95 @@ func TestMDMAppleConfig(t *testing.T) {
 				Metadata:  "not-empty",
 			}}},
 			expectedError: "idp_name required",
+		}, {
+			name:        "diskEncryptionKeyViewTTL",
+			licenseTier: "free",
+			newMDM:      fleet.MDM{DiskEncryptionKeyViewTTL: fleet.Duration{Duration: 30 * time.Second}},
+			expectedMDM: fleet.MDM{
+				DiskEncryptionKeyViewTTL: fleet.Duration{Duration: 30 * time.Second},
+				MacOSSetup:               fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}, EULA: optjson.String{Set: true}},
+			},
+		}, {
+			name:          "diskEncryptionKeyViewTTLTooLong",
+			licenseTier:   "free",
+			newMDM:        fleet.MDM{DiskEncryptionKeyViewTTL: fleet.Duration{Duration: time.Hour}},
+			expectedError: "disk_encryption_key_view_ttl",
+		}, {
+			name:        "profileChangeApproval",
+			licenseTier: "free",
+			newMDM:      fleet.MDM{ProfileChangeApproval: fleet.MDMProfileChangeApproval{Enable: true, HostThreshold: 500, Expiry: fleet.Duration{Duration: 72 * time.Hour}}},
+			expectedMDM: fleet.MDM{
+				ProfileChangeApproval: fleet.MDMProfileChangeApproval{Enable: true, HostThreshold: 500, Expiry: fleet.Duration{Duration: 72 * time.Hour}},
+				MacOSSetup:            fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}, EULA: optjson.String{Set: true}},
+			},
+		}, {
+			name:          "profileChangeApprovalNegativeThreshold",
+			licenseTier:   "free",
+			newMDM:        fleet.MDM{ProfileChangeApproval: fleet.MDMProfileChangeApproval{Enable: true, HostThreshold: -1}},
+			expectedError: "profile_change_approval.host_threshold",
+		}, {
+			name:          "assetCDNFree",
+			licenseTier:   "free",
+			newMDM:        fleet.MDM{AssetCDN: fleet.MDMAssetCDN{Enable: true, Provider: fleet.MDMAssetCDNProviderFastly, URL: "https://cdn.example.com", PrivateKey: "c2VjcmV0"}},
+			expectedError: "asset_cdn.enable",
+		}, {
+			name:        "assetCDN",
+			licenseTier: "premium",
+			newMDM:      fleet.MDM{AssetCDN: fleet.MDMAssetCDN{Enable: true, Provider: fleet.MDMAssetCDNProviderFastly, URL: "https://cdn.example.com", PrivateKey: "c2VjcmV0"}},
+			expectedMDM: fleet.MDM{
+				AssetCDN:   fleet.MDMAssetCDN{Enable: true, Provider: fleet.MDMAssetCDNProviderFastly, URL: "https://cdn.example.com", PrivateKey: fleet.MaskedPassword},
+				MacOSSetup: fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}, EULA: optjson.String{Set: true}},
+			},
+		}, {
+			name:          "assetCDNInvalidProvider",
+			licenseTier:   "premium",
+			newMDM:        fleet.MDM{AssetCDN: fleet.MDMAssetCDN{Enable: true, Provider: "akamai", URL: "https://cdn.example.com", PrivateKey: "c2VjcmV0"}},
+			expectedError: "asset_cdn",
+		}, {
+			name:        "defaultDeviceFamilies",
+			licenseTier: "free",
+			newMDM:      fleet.MDM{AppleBMDefaultDeviceFamilies: []string{"mac", " IPAD "}},
+			expectedMDM: fleet.MDM{
+				AppleBMDefaultDeviceFamilies: []string{"Mac", "iPad"},
+				MacOSSetup:                   fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}, EULA: optjson.String{Set: true}},
+			},
+		}, {
+			name:          "defaultDeviceFamiliesUnknown",
+			licenseTier:   "free",
+			newMDM:        fleet.MDM{AppleBMDefaultDeviceFamilies: []string{"Mac", "Watch"}},
+			expectedError: "apple_bm_default_device_families",
+		}, {
+			name:        "postureScoreWeights",
+			licenseTier: "free",
+			newMDM:      fleet.MDM{PostureScore: fleet.MDMPostureScoreSettings{Weights: fleet.MDMPostureScoreWeights{DiskEncryption: 50, Enrollment: 50}}},
+			expectedMDM: fleet.MDM{
+				PostureScore: fleet.MDMPostureScoreSettings{Weights: fleet.MDMPostureScoreWeights{DiskEncryption: 50, Enrollment: 50}},
+				MacOSSetup:   fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}, EULA: optjson.String{Set: true}},
+			},
+		}, {
+			name:          "postureScoreNegativeWeight",
+			licenseTier:   "free",
+			newMDM:        fleet.MDM{PostureScore: fleet.MDMPostureScoreSettings{Weights: fleet.MDMPostureScoreWeights{Profiles: -1}}},
+			expectedError: "posture_score.weights",
+		}, {
+			name:        "localization",
+			licenseTier: "free",
+			newMDM: fleet.MDM{Localization: fleet.MDMLocalization{
+				DefaultLanguage: "fr",
+				Translations:    []fleet.MDMEndUserStrings{{Language: "fr", SSOErrorMessage: "Contactez votre administrateur."}},
+			}},
+			expectedMDM: fleet.MDM{
+				Localization: fleet.MDMLocalization{
+					DefaultLanguage: "fr",
+					Translations:    []fleet.MDMEndUserStrings{{Language: "fr", SSOErrorMessage: "Contactez votre administrateur."}},
+				},
+				MacOSSetup: fleet.MacOSSetup{BootstrapPackage: optjson.String{Set: true}, MacOSSetupAssistant: optjson.String{Set: true}, EULA: optjson.String{Set: true}},
+			},
+		}, {
+			name:          "localizationInvalidLanguage",
+			licenseTier:   "free",
+			newMDM:        fleet.MDM{Localization: fleet.MDMLocalization{Translations: []fleet.MDMEndUserStrings{{Language: "french!"}}}},
+			expectedError: "localization",
 		},
 	}
 
@@ -3,13 +3,23 @@ package service
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,7 +27,13 @@ import (
 
 	"github.com/VividCortex/mysqlerr"
 	"github.com/docker/go-units"
+	"github.com/fleetdm/fleet/v4/pkg/certificate"
+	"github.com/fleetdm/fleet/v4/pkg/fleethttp"
+	"github.com/fleetdm/fleet/v4/pkg/mdmclient"
+	"github.com/fleetdm/fleet/v4/server"
 	"github.com/fleetdm/fleet/v4/server/authz"
+	"github.com/fleetdm/fleet/v4/server/config"
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxdb"
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/contexts/license"
 	"github.com/fleetdm/fleet/v4/server/contexts/logging"
@@ -26,14 +42,21 @@ import (
 	apple_mdm "github.com/fleetdm/fleet/v4/server/mdm/apple"
 	"github.com/fleetdm/fleet/v4/server/mdm/apple/appmanifest"
 	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
+	"github.com/fleetdm/fleet/v4/server/mdm/secrets"
+	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/sso"
+	"github.com/fleetdm/fleet/v4/server/worker"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	"github.com/groob/plist"
+	nanodep_client "github.com/micromdm/nanodep/client"
 	"github.com/micromdm/nanodep/godep"
+	"github.com/micromdm/nanomdm/cryptoutil"
 	"github.com/micromdm/nanomdm/mdm"
+	nanomdm_pushsvc "github.com/micromdm/nanomdm/push/service"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type createMDMAppleEnrollmentProfileRequest struct {
@@ -149,13 +172,11 @@ func (svc *Service) ListMDMAppleEnrollmentProfiles(ctx context.Context) ([]*flee
 	return enrollments, nil
 }
 
-type getMDMAppleCommandResultsRequest struct {
-	CommandUUID string `query:"command_uuid,optional"`
-}
+type getMDMAppleCommandResultsRequest = mdmclient.GetCommandResultsRequest
 
 type getMDMAppleCommandResultsResponse struct {
-	Results []*fleet.MDMAppleCommandResult `json:"results,omitempty"`
-	Err     error                          `json:"error,omitempty"`
+	mdmclient.GetCommandResultsResponse
+	Err error `json:"error,omitempty"`
 }
 
 func (r getMDMAppleCommandResultsResponse) error() error { return r.Err }
@@ -170,7 +191,7 @@ func getMDMAppleCommandResultsEndpoint(ctx context.Context, request interface{},
 	}
 
 	return getMDMAppleCommandResultsResponse{
-		Results: results,
+		GetCommandResultsResponse: mdmclient.GetCommandResultsResponse{Results: results},
 	}, nil
 }
 
@@ -242,6 +263,26 @@ func (svc *Service) GetMDMAppleCommandResults(ctx context.Context, commandUUID s
 		}
 	}
 
+	// the result of an ActivationLockBypassCode command contains the bypass
+	// code, it is withheld from the users that cannot read the bypass codes of
+	// the host's team.
+	for _, res := range results {
+		if res.RequestType != "ActivationLockBypassCode" {
+			continue
+		}
+		var teamID *uint
+		if h := hostsByUUID[res.DeviceID]; h != nil {
+			teamID = h.TeamID
+		}
+		if err := svc.authz.Authorize(ctx, &fleet.MDMAppleActivationLockBypassCodeAuthz{TeamID: teamID}, fleet.ActionRead); err != nil {
+			var authErr *authz.Forbidden
+			if !errors.As(err, &authErr) {
+				return nil, ctxerr.Wrap(ctx, err)
+			}
+			res.Result = nil
+		}
+	}
+
 	// add the hostnames to the results
 	for _, res := range results {
 		if h := hostsByUUID[res.DeviceID]; h != nil {
@@ -253,11 +294,12 @@ func (svc *Service) GetMDMAppleCommandResults(ctx context.Context, commandUUID s
 
 type listMDMAppleCommandsRequest struct {
 	ListOptions fleet.ListOptions `url:"list_options"`
+	CommandUUID string            `query:"command_uuid,optional"`
 }
 
 type listMDMAppleCommandsResponse struct {
-	Results []*fleet.MDMAppleCommand `json:"results"`
-	Err     error                    `json:"error,omitempty"`
+	mdmclient.ListCommandsResponse
+	Err error `json:"error,omitempty"`
 }
 
 func (r listMDMAppleCommandsResponse) error() error { return r.Err }
@@ -266,6 +308,7 @@ func listMDMAppleCommandsEndpoint(ctx context.Context, request interface{}, svc
 	req := request.(*listMDMAppleCommandsRequest)
 	results, err := svc.ListMDMAppleCommands(ctx, &fleet.MDMAppleCommandListOptions{
 		ListOptions: req.ListOptions,
+		CommandUUID: req.CommandUUID,
 	})
 	if err != nil {
 		return listMDMAppleCommandsResponse{
@@ -274,7 +317,7 @@ func listMDMAppleCommandsEndpoint(ctx context.Context, request interface{}, svc
 	}
 
 	return listMDMAppleCommandsResponse{
-		Results: results,
+		ListCommandsResponse: mdmclient.ListCommandsResponse{Results: results},
 	}, nil
 }
 
@@ -355,13 +398,15 @@ func (svc *Service) ListMDMAppleCommands(ctx context.Context, opts *fleet.MDMApp
 }
 
 type newMDMAppleConfigProfileRequest struct {
-	TeamID  uint
-	Profile *multipart.FileHeader
+	TeamID                      uint
+	Profile                     *multipart.FileHeader
+	Force                       bool
+	AcknowledgeReservedPayloads bool
 }
 
 type newMDMAppleConfigProfileResponse struct {
-	ProfileID uint  `json:"profile_id"`
-	Err       error `json:"error,omitempty"`
+	mdmclient.NewProfileResponse
+	Err error `json:"error,omitempty"`
 }
 
 // TODO(lucas): We parse the whole body before running svc.authz.Authorize.
@@ -389,6 +434,22 @@ func (newMDMAppleConfigProfileRequest) DecodeRequest(ctx context.Context, r *htt
 		decoded.TeamID = uint(teamID)
 	}
 
+	if val := r.MultipartForm.Value["force"]; len(val) > 0 {
+		force, err := strconv.ParseBool(val[0])
+		if err != nil {
+			return nil, &fleet.BadRequestError{Message: fmt.Sprintf("failed to decode force in multipart form: %s", err.Error())}
+		}
+		decoded.Force = force
+	}
+
+	if val := r.MultipartForm.Value["acknowledge_reserved_payloads"]; len(val) > 0 {
+		ack, err := strconv.ParseBool(val[0])
+		if err != nil {
+			return nil, &fleet.BadRequestError{Message: fmt.Sprintf("failed to decode acknowledge_reserved_payloads in multipart form: %s", err.Error())}
+		}
+		decoded.AcknowledgeReservedPayloads = ack
+	}
+
 	fhs, ok := r.MultipartForm.File["profile"]
 	if !ok || len(fhs) < 1 {
 		return nil, &fleet.BadRequestError{Message: "no file headers for profile"}
@@ -408,26 +469,34 @@ func newMDMAppleConfigProfileEndpoint(ctx context.Context, request interface{},
 		return &newMDMAppleConfigProfileResponse{Err: err}, nil
 	}
 	defer ff.Close()
-	cp, err := svc.NewMDMAppleConfigProfile(ctx, req.TeamID, ff, req.Profile.Size)
+	cp, err := svc.NewMDMAppleConfigProfile(ctx, req.TeamID, ff, req.Profile.Size, req.Force, req.AcknowledgeReservedPayloads)
 	if err != nil {
 		return &newMDMAppleConfigProfileResponse{Err: err}, nil
 	}
 	return &newMDMAppleConfigProfileResponse{
-		ProfileID: cp.ProfileID,
+		NewProfileResponse: mdmclient.NewProfileResponse{ProfileID: cp.ProfileID},
 	}, nil
 }
 
-func (svc *Service) NewMDMAppleConfigProfile(ctx context.Context, teamID uint, r io.Reader, size int64) (*fleet.MDMAppleConfigProfile, error) {
+func (svc *Service) NewMDMAppleConfigProfile(ctx context.Context, teamID uint, r io.Reader, size int64, force, acknowledgeReserved bool) (*fleet.MDMAppleConfigProfile, error) {
 	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleConfigProfile{TeamID: &teamID}, fleet.ActionWrite); err != nil {
 		return nil, ctxerr.Wrap(ctx, err)
 	}
 	var teamName string
+	var allowReserved bool
 	if teamID >= 1 {
 		tm, err := svc.EnterpriseOverrides.TeamByIDOrName(ctx, &teamID, nil)
 		if err != nil {
 			return nil, ctxerr.Wrap(ctx, err)
 		}
 		teamName = tm.Name
+		allowReserved = tm.Config.MDM.MacOSSettings.AllowReservedPayloads
+	} else {
+		appCfg, err := svc.ds.AppConfig(ctx)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err)
+		}
+		allowReserved = appCfg.MDM.MacOSSettings.AllowReservedPayloads
 	}
 
 	b := make([]byte, size)
@@ -446,15 +515,28 @@ func (svc *Service) NewMDMAppleConfigProfile(ctx context.Context, teamID uint, r
 		})
 	}
 
-	if err := cp.ValidateUserProvided(); err != nil {
+	if err := validateUserProvidedMDMAppleProfile(cp, allowReserved, acknowledgeReserved); err != nil {
+		return nil, ctxerr.Wrap(ctx, &fleet.BadRequestError{Message: err.Error()})
+	}
+	if err := validateMDMAppleProfileSecrets(cp, svc.config.MDM.Secrets.Provider != ""); err != nil {
 		return nil, ctxerr.Wrap(ctx, &fleet.BadRequestError{Message: err.Error()})
 	}
 
+	if !force {
+		err := svc.checkMDMAppleProfileIdentifierConflicts(ctx, &teamID, []*fleet.MDMAppleConfigProfile{cp}, func(int) string {
+			return "profile"
+		}, "Couldn’t upload.")
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	newCP, err := svc.ds.NewMDMAppleConfigProfile(ctx, *cp)
 	if err != nil {
 		return nil, ctxerr.Wrap(ctx, err)
 	}
-	if err := svc.ds.BulkSetPendingMDMAppleHostProfiles(ctx, nil, nil, []uint{newCP.ProfileID}, nil); err != nil {
+	logReservedPayloadTypes(svc.logger, newCP)
+	if _, err := worker.BulkSetPendingMDMAppleHostProfiles(ctx, svc.ds, svc.logger, nil, nil, []uint{newCP.ProfileID}); err != nil {
 		return nil, ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
 	}
 
@@ -470,13 +552,269 @@ func (svc *Service) NewMDMAppleConfigProfile(ctx context.Context, teamID uint, r
 	return newCP, nil
 }
 
-type listMDMAppleConfigProfilesRequest struct {
-	TeamID uint `query:"team_id,optional"`
+// validateUserProvidedMDMAppleProfile validates a custom profile provided by
+// the user. Payloads with a PayloadType reserved by Fleet are rejected, unless
+// the team allows them (allowReserved) and the request explicitly acknowledged
+// them, in which case they are recorded in the profile's ReservedPayloadTypes.
+func validateUserProvidedMDMAppleProfile(cp *fleet.MDMAppleConfigProfile, allowReserved, acknowledged bool) error {
+	if !allowReserved {
+		return cp.ValidateUserProvided()
+	}
+
+	if err := cp.ValidateUserProvidedAllowReservedTypes(); err != nil {
+		return err
+	}
+	if len(cp.ReservedPayloadTypes) > 0 && !acknowledged {
+		return fmt.Errorf("PayloadType(s) reserved by Fleet: %s. Set acknowledge_reserved_payloads to upload the profile anyway.",
+			strings.Join(cp.ReservedPayloadTypes, ", "))
+	}
+	return nil
+}
+
+// validateMDMAppleProfileSecrets returns an error if the profile references
+// secrets ($FLEET_SECRET_<NAME> variables) while no secrets manager is
+// configured to resolve them when the profile is delivered.
+func validateMDMAppleProfileSecrets(cp *fleet.MDMAppleConfigProfile, secretsConfigured bool) error {
+	names := apple_mdm.ProfileSecretNames(cp.Mobileconfig)
+	if len(names) == 0 || secretsConfigured {
+		return nil
+	}
+	return fmt.Errorf("The profile references secrets (%s) but no secrets manager is configured.", strings.Join(names, ", "))
+}
+
+// logReservedPayloadTypes logs a warning if the profile contains payloads with
+// a PayloadType reserved by Fleet.
+func logReservedPayloadTypes(logger kitlog.Logger, cp *fleet.MDMAppleConfigProfile) {
+	if len(cp.ReservedPayloadTypes) == 0 {
+		return
+	}
+	level.Warn(logger).Log("msg", "profile uploaded with reserved payload types", "identifier", cp.Identifier,
+		"payload_types", strings.Join(cp.ReservedPayloadTypes, ","))
+}
+
+// checkMDMAppleProfileIdentifierConflicts returns an error with a conflict
+// status if the identifier of any of the profiles conflicts with a profile
+// from another source on the hosts of the team, as installing it would
+// overwrite that profile on those hosts. The argName function returns the
+// name of the invalid argument for the profile at index i.
+func (svc *Service) checkMDMAppleProfileIdentifierConflicts(
+	ctx context.Context,
+	teamID *uint,
+	profs []*fleet.MDMAppleConfigProfile,
+	argName func(i int) string,
+	msgPrefix string,
+) error {
+	idents := make([]string, 0, len(profs))
+	for _, p := range profs {
+		idents = append(idents, p.Identifier)
+	}
+	conflicts, err := svc.ds.ListMDMAppleProfileIdentifierConflicts(ctx, teamID, idents)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list profile identifier conflicts")
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	hostsByIdent := make(map[string]uint, len(conflicts))
+	for _, c := range conflicts {
+		hostsByIdent[c.Identifier] = c.HostsCount
+	}
+	invalid := &fleet.InvalidArgumentError{}
+	for i, p := range profs {
+		if count, ok := hostsByIdent[p.Identifier]; ok {
+			invalid.Append(argName(i), fmt.Sprintf(
+				"%s The identifier (PayloadIdentifier) %q is already used by a profile from another source on %d host(s) of this team. It would be overwritten on these hosts. Use the force option to continue anyway.",
+				msgPrefix, p.Identifier, count))
+		}
+	}
+	return ctxerr.Wrap(ctx, invalid.WithStatus(http.StatusConflict), "profile identifier conflicts")
+}
+
+type listMDMAppleProfileIdentifierConflictsRequest struct {
+	TeamID     *uint  `query:"team_id,optional"`
+	Identifier string `query:"identifier"`
+}
+
+type listMDMAppleProfileIdentifierConflictsResponse struct {
+	Conflicts []*fleet.MDMAppleProfileIdentifierConflict `json:"conflicts"`
+	Err       error                                      `json:"error,omitempty"`
+}
+
+func (r listMDMAppleProfileIdentifierConflictsResponse) error() error { return r.Err }
+
+func listMDMAppleProfileIdentifierConflictsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listMDMAppleProfileIdentifierConflictsRequest)
+	conflicts, err := svc.ListMDMAppleProfileIdentifierConflicts(ctx, req.TeamID, req.Identifier)
+	if err != nil {
+		return listMDMAppleProfileIdentifierConflictsResponse{Err: err}, nil
+	}
+	if conflicts == nil {
+		conflicts = []*fleet.MDMAppleProfileIdentifierConflict{}
+	}
+	return listMDMAppleProfileIdentifierConflictsResponse{Conflicts: conflicts}, nil
+}
+
+func (svc *Service) ListMDMAppleProfileIdentifierConflicts(ctx context.Context, teamID *uint, identifier string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleConfigProfile{TeamID: teamID}, fleet.ActionRead); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	if identifier == "" {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("identifier", "identifier is required"))
+	}
+	if teamID != nil && *teamID > 0 {
+		if _, err := svc.EnterpriseOverrides.TeamByIDOrName(ctx, teamID, nil); err != nil {
+			return nil, ctxerr.Wrap(ctx, err)
+		}
+	}
+
+	conflicts, err := svc.ds.ListMDMAppleProfileIdentifierConflicts(ctx, teamID, []string{identifier})
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	return conflicts, nil
+}
+
+type getMDMAppleReconciliationPlanRequest struct {
+	TeamID *uint `query:"team_id,optional"`
+}
+
+type getMDMAppleReconciliationPlanResponse struct {
+	TeamID *uint                                   `json:"team_id"`
+	Items  []*fleet.MDMAppleReconciliationPlanItem `json:"items"`
+	Err    error                                   `json:"error,omitempty"`
+}
+
+func (r getMDMAppleReconciliationPlanResponse) error() error { return r.Err }
+
+func getMDMAppleReconciliationPlanEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getMDMAppleReconciliationPlanRequest)
+	items, err := svc.GetMDMAppleReconciliationPlan(ctx, req.TeamID)
+	if err != nil {
+		return getMDMAppleReconciliationPlanResponse{Err: err}, nil
+	}
+	if items == nil {
+		items = []*fleet.MDMAppleReconciliationPlanItem{}
+	}
+	return getMDMAppleReconciliationPlanResponse{TeamID: req.TeamID, Items: items}, nil
+}
+
+func (svc *Service) GetMDMAppleReconciliationPlan(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleReconciliationPlanItem, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.AppleMDM{}, fleet.ActionRead); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	if teamID != nil && *teamID > 0 {
+		if _, err := svc.EnterpriseOverrides.TeamByIDOrName(ctx, teamID, nil); err != nil {
+			return nil, ctxerr.Wrap(ctx, err)
+		}
+	}
+
+	items, err := svc.ds.ListMDMAppleReconciliationPlan(ctx, teamID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	if len(items) == 0 {
+		return items, nil
+	}
+
+	if err := markHeldMDMAppleReconciliationPlanItems(ctx, svc.ds, items); err != nil {
+		return nil, err
+	}
+	if err := markQueuedMDMAppleReconciliationPlanItems(ctx, svc.ds, items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// markHeldMDMAppleReconciliationPlanItems flags the items that would be held
+// by holdUnapprovedProfileChanges. The number of hosts of a change is counted
+// across all teams, as the reconciliation does, but nothing is requested for
+// approval.
+func markHeldMDMAppleReconciliationPlanItems(ctx context.Context, ds fleet.Datastore, items []*fleet.MDMAppleReconciliationPlanItem) error {
+	appCfg, err := ds.AppConfig(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get app config")
+	}
+	approvalCfg := appCfg.MDM.ProfileChangeApproval
+	if !approvalCfg.Enable {
+		return nil
+	}
+
+	toInstall, err := ds.ListMDMAppleProfilesToInstall(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "getting profiles to install")
+	}
+	toRemove, err := ds.ListMDMAppleProfilesToRemove(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "getting profiles to remove")
+	}
+	approved, err := ds.ListMDMAppleProfileChanges(ctx, fleet.MDMAppleProfileChangeApproved)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list approved profile changes")
+	}
+
+	changeKey := func(op fleet.MDMAppleOperationType, profileID uint, checksum []byte) string {
+		return fmt.Sprintf("%s\x00%d\x00%s", op, profileID, checksum)
+	}
+	hostCounts := make(map[string]int)
+	for _, p := range toInstall {
+		hostCounts[changeKey(fleet.MDMAppleOperationTypeInstall, p.ProfileID, p.Checksum)]++
+	}
+	for _, p := range toRemove {
+		hostCounts[changeKey(fleet.MDMAppleOperationTypeRemove, p.ProfileID, p.Checksum)]++
+	}
+	isApproved := make(map[string]bool, len(approved))
+	for _, c := range approved {
+		isApproved[changeKey(c.OperationType, c.ProfileID, c.Checksum)] = true
+	}
+
+	for _, item := range items {
+		key := changeKey(item.OperationType, item.ProfileID, item.Checksum)
+		item.HeldForApproval = hostCounts[key] > approvalCfg.HostThreshold && !isApproved[key]
+	}
+	return nil
+}
+
+// markQueuedMDMAppleReconciliationPlanItems sets the command still queued for
+// the items that would be suppressed by suppressDuplicateProfileCommands.
+func markQueuedMDMAppleReconciliationPlanItems(ctx context.Context, ds fleet.Datastore, items []*fleet.MDMAppleReconciliationPlanItem) error {
+	for _, op := range []fleet.MDMAppleOperationType{fleet.MDMAppleOperationTypeInstall, fleet.MDMAppleOperationTypeRemove} {
+		var hostUUIDs []string
+		seen := make(map[string]bool)
+		for _, item := range items {
+			if item.OperationType == op && !seen[item.HostUUID] {
+				seen[item.HostUUID] = true
+				hostUUIDs = append(hostUUIDs, item.HostUUID)
+			}
+		}
+		if len(hostUUIDs) == 0 {
+			continue
+		}
+
+		queued, err := ds.ListMDMAppleHostProfilesWithQueuedCommand(ctx, op, hostUUIDs)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "list profiles with queued command")
+		}
+		queuedCmds := make(map[string]string, len(queued))
+		for _, p := range queued {
+			queuedCmds[p.HostUUID+"\x00"+string(p.Checksum)] = p.CommandUUID
+		}
+		for _, item := range items {
+			if item.OperationType == op {
+				item.QueuedCommandUUID = queuedCmds[item.HostUUID+"\x00"+string(item.Checksum)]
+			}
+		}
+	}
+	return nil
 }
 
+type listMDMAppleConfigProfilesRequest = mdmclient.ListProfilesRequest
+
 type listMDMAppleConfigProfilesResponse struct {
-	ConfigProfiles []*fleet.MDMAppleConfigProfile `json:"profiles"`
-	Err            error                          `json:"error,omitempty"`
+	mdmclient.ListProfilesResponse
+	Err error `json:"error,omitempty"`
 }
 
 func (r listMDMAppleConfigProfilesResponse) error() error { return r.Err }
@@ -485,7 +823,17 @@ func listMDMAppleConfigProfilesEndpoint(ctx context.Context, request interface{}
 	req := request.(*listMDMAppleConfigProfilesRequest)
 	res := listMDMAppleConfigProfilesResponse{}
 
-	cps, err := svc.ListMDMAppleConfigProfiles(ctx, req.TeamID)
+	teamID := req.TeamID
+	if req.AllTeams {
+		if teamID != 0 {
+			setAuthCheckedOnPreAuthErr(ctx)
+			res.Err = ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("all_teams", "cannot specify both team_id and all_teams"))
+			return &res, nil
+		}
+		teamID = fleet.MDMAppleAllTeamsProfilesTeamID
+	}
+
+	cps, err := svc.ListMDMAppleConfigProfiles(ctx, teamID)
 	if err != nil {
 		res.Err = err
 		return &res, err
@@ -500,7 +848,7 @@ func (svc *Service) ListMDMAppleConfigProfiles(ctx context.Context, teamID uint)
 		return nil, ctxerr.Wrap(ctx, err)
 	}
 
-	if teamID >= 1 {
+	if teamID >= 1 && teamID != fleet.MDMAppleAllTeamsProfilesTeamID {
 		// confirm that team exists
 		if _, err := svc.ds.Team(ctx, teamID); err != nil {
 			return nil, ctxerr.Wrap(ctx, err)
@@ -637,7 +985,7 @@ func (svc *Service) DeleteMDMAppleConfigProfile(ctx context.Context, profileID u
 		return ctxerr.Wrap(ctx, err)
 	}
 	// cannot use the profile ID as it is now deleted
-	if err := svc.ds.BulkSetPendingMDMAppleHostProfiles(ctx, nil, []uint{teamID}, nil, nil); err != nil {
+	if _, err := worker.BulkSetPendingMDMAppleHostProfiles(ctx, svc.ds, svc.logger, nil, []uint{teamID}, nil); err != nil {
 		return ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
 	}
 
@@ -653,823 +1001,4505 @@ func (svc *Service) DeleteMDMAppleConfigProfile(ctx context.Context, profileID u
 	return nil
 }
 
-type getMDMAppleProfilesSummaryRequest struct {
-	TeamID *uint `query:"team_id,optional"`
+type deleteMDMAppleConfigProfilesRequest struct {
+	ProfileIDs  []uint   `json:"profile_ids"`
+	Identifiers []string `json:"identifiers"`
+	TeamIDs     []uint   `json:"team_ids"`
 }
 
-type getMDMAppleProfilesSummaryResponse struct {
-	fleet.MDMAppleConfigProfilesSummary
-	Err error `json:"error,omitempty"`
+type deleteMDMAppleConfigProfilesResponse struct {
+	ProfileIDs []uint `json:"profile_ids"`
+	Err        error  `json:"error,omitempty"`
 }
 
-func (r getMDMAppleProfilesSummaryResponse) error() error { return r.Err }
+func (r deleteMDMAppleConfigProfilesResponse) error() error { return r.Err }
 
-func getMDMAppleProfilesSummaryEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*getMDMAppleProfilesSummaryRequest)
-	res := getMDMAppleProfilesSummaryResponse{}
+func deleteMDMAppleConfigProfilesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*deleteMDMAppleConfigProfilesRequest)
 
-	ps, err := svc.GetMDMAppleProfilesSummary(ctx, req.TeamID)
+	ids, err := svc.DeleteMDMAppleConfigProfiles(ctx, req.ProfileIDs, req.Identifiers, req.TeamIDs)
 	if err != nil {
-		return &getMDMAppleProfilesSummaryResponse{Err: err}, nil
+		return &deleteMDMAppleConfigProfilesResponse{Err: err}, nil
 	}
-
-	res.Verifying = ps.Verifying
-	res.Failed = ps.Failed
-	res.Pending = ps.Pending
-
-	return &res, nil
+	return &deleteMDMAppleConfigProfilesResponse{ProfileIDs: ids}, nil
 }
 
-func (svc *Service) GetMDMAppleProfilesSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleConfigProfilesSummary, error) {
-	if err := svc.authz.Authorize(ctx, fleet.MDMAppleConfigProfile{TeamID: teamID}, fleet.ActionRead); err != nil {
+func (svc *Service) DeleteMDMAppleConfigProfiles(ctx context.Context, profileIDs []uint, identifiers []string, teamIDs []uint) ([]uint, error) {
+	// first we perform a basic authz check
+	if err := svc.authz.Authorize(ctx, &fleet.Team{}, fleet.ActionRead); err != nil {
 		return nil, ctxerr.Wrap(ctx, err)
 	}
 
-	ps, err := svc.ds.GetMDMAppleHostsProfilesSummary(ctx, teamID)
+	if len(profileIDs) == 0 && len(identifiers) == 0 {
+		return nil, ctxerr.Wrap(ctx, badRequest("at least one of profile_ids or identifiers is required"))
+	}
+
+	profs, err := svc.ds.ListMDMAppleConfigProfilesByIDsOrIdentifiers(ctx, profileIDs, identifiers)
 	if err != nil {
 		return nil, ctxerr.Wrap(ctx, err)
 	}
 
-	return ps, nil
-}
-
-type getMDMAppleFileVaultSummaryRequest struct {
-	TeamID *uint `query:"team_id,optional"`
-}
-
-type getMDMAppleFileVauleSummaryResponse struct {
-	*fleet.MDMAppleFileVaultSummary
-	Err error `json:"error,omitempty"`
-}
-
-func (r getMDMAppleFileVauleSummaryResponse) error() error { return r.Err }
+	// the profiles requested by id are always deleted, the ones matching an
+	// identifier only if they belong to one of the teams, if provided.
+	byID := make(map[uint]bool, len(profileIDs))
+	for _, id := range profileIDs {
+		byID[id] = true
+	}
+	inTeams := make(map[uint]bool, len(teamIDs))
+	for _, id := range teamIDs {
+		inTeams[id] = true
+	}
+	var toDelete []*fleet.MDMAppleConfigProfile
+	found := make(map[uint]bool, len(profileIDs))
+	for _, cp := range profs {
+		if byID[cp.ProfileID] {
+			found[cp.ProfileID] = true
+		} else if len(inTeams) > 0 && !inTeams[*cp.TeamID] {
+			continue
+		}
+		toDelete = append(toDelete, cp)
+	}
 
-func getMdmAppleFileVaultSummaryEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*getMDMAppleFileVaultSummaryRequest)
+	// now we can do a specific authz check based on team id of the profiles
+	// before we delete them
+	for _, cp := range toDelete {
+		if err := svc.authz.Authorize(ctx, cp, fleet.ActionWrite); err != nil {
+			return nil, ctxerr.Wrap(ctx, err)
+		}
+	}
 
-	fvs, err := svc.GetMDMAppleFileVaultSummary(ctx, req.TeamID)
-	if err != nil {
-		return &getMDMAppleFileVauleSummaryResponse{Err: err}, nil
+	for _, id := range profileIDs {
+		if !found[id] {
+			return nil, ctxerr.Wrap(ctx, newNotFoundError(), fmt.Sprintf("profile %d", id))
+		}
+	}
+	if len(toDelete) == 0 {
+		return []uint{}, nil
 	}
 
-	return &getMDMAppleFileVauleSummaryResponse{
-		MDMAppleFileVaultSummary: fvs,
-	}, nil
-}
+	teamNames := make(map[uint]string)
+	ids := make([]uint, 0, len(toDelete))
+	var teamIDsToUpdate []uint
+	for _, cp := range toDelete {
+		// prevent deleting profiles that are managed by Fleet
+		if _, ok := mobileconfig.FleetPayloadIdentifiers()[cp.Identifier]; ok {
+			return nil, &fleet.BadRequestError{
+				Message:     "profiles managed by Fleet can't be deleted using this endpoint.",
+				InternalErr: fmt.Errorf("deleting profile %s for team %d not allowed because it's managed by Fleet", cp.Identifier, *cp.TeamID),
+			}
+		}
+		if _, ok := teamNames[*cp.TeamID]; !ok {
+			name, err := svc.mdmAppleProfileTeamName(ctx, *cp.TeamID)
+			if err != nil {
+				return nil, err
+			}
+			teamNames[*cp.TeamID] = name
+			teamIDsToUpdate = append(teamIDsToUpdate, *cp.TeamID)
+		}
+		ids = append(ids, cp.ProfileID)
+	}
 
-// QUESTION: workflow for developing new APIs? whats your setup quickly test code working?
-func (svc *Service) GetMDMAppleFileVaultSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleFileVaultSummary, error) {
-	if err := svc.authz.Authorize(ctx, fleet.MDMAppleConfigProfile{TeamID: teamID}, fleet.ActionRead); err != nil {
+	if err := svc.ds.DeleteMDMAppleConfigProfiles(ctx, ids); err != nil {
 		return nil, ctxerr.Wrap(ctx, err)
 	}
+	// cannot use the profile IDs as they are now deleted
+	if _, err := worker.BulkSetPendingMDMAppleHostProfiles(ctx, svc.ds, svc.logger, nil, teamIDsToUpdate, nil); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
+	}
 
-	fvs, err := svc.ds.GetMDMAppleFileVaultSummary(ctx, teamID)
-	if err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
+	act := &fleet.ActivityTypeDeletedMultipleMacosProfiles{}
+	for _, cp := range toDelete {
+		if len(act.Profiles) == fleet.MaxActivityMacosProfileChanges {
+			act.ProfilesTruncated = true
+			break
+		}
+		team := activityTeam(*cp.TeamID, teamNames[*cp.TeamID])
+		act.Profiles = append(act.Profiles, fleet.ActivityMacosProfile{
+			ProfileName:       cp.Name,
+			ProfileIdentifier: cp.Identifier,
+			TeamID:            team.TeamID,
+			TeamName:          team.TeamName,
+		})
+	}
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), act); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "logging activity for delete mdm apple config profiles")
 	}
 
-	return fvs, nil
+	return ids, nil
 }
 
-type uploadAppleInstallerRequest struct {
-	Installer *multipart.FileHeader
+type installMDMAppleHostProfileRequest struct {
+	HostID  uint
+	Profile *multipart.FileHeader
+	Force   bool
 }
 
-type uploadAppleInstallerResponse struct {
-	ID  uint  `json:"installer_id"`
+type installMDMAppleHostProfileResponse struct {
+	mdmclient.NewProfileResponse
 	Err error `json:"error,omitempty"`
 }
 
-// TODO(lucas): We parse the whole body before running svc.authz.Authorize.
-// An authenticated but unauthorized user could abuse this.
-func (uploadAppleInstallerRequest) DecodeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
-	err := r.ParseMultipartForm(512 * units.MiB)
+func (installMDMAppleHostProfileRequest) DecodeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	decoded := installMDMAppleHostProfileRequest{}
+
+	hostID, err := uintFromRequest(r, "id")
 	if err != nil {
+		return nil, &fleet.BadRequestError{Message: "failed to decode host id"}
+	}
+	decoded.HostID = uint(hostID)
+
+	if err := r.ParseMultipartForm(512 * units.MiB); err != nil {
 		return nil, &fleet.BadRequestError{
 			Message:     "failed to parse multipart form",
 			InternalErr: err,
 		}
 	}
-	installer := r.MultipartForm.File["installer"][0]
-	return &uploadAppleInstallerRequest{
-		Installer: installer,
-	}, nil
-}
-
-func (r uploadAppleInstallerResponse) error() error { return r.Err }
 
-func uploadAppleInstallerEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*uploadAppleInstallerRequest)
-	ff, err := req.Installer.Open()
-	if err != nil {
-		return uploadAppleInstallerResponse{Err: err}, nil
+	if val := r.MultipartForm.Value["force"]; len(val) > 0 {
+		force, err := strconv.ParseBool(val[0])
+		if err != nil {
+			return nil, &fleet.BadRequestError{Message: fmt.Sprintf("failed to decode force in multipart form: %s", err.Error())}
+		}
+		decoded.Force = force
+	}
+
+	fhs, ok := r.MultipartForm.File["profile"]
+	if !ok || len(fhs) < 1 {
+		return nil, &fleet.BadRequestError{Message: "no file headers for profile"}
+	}
+	decoded.Profile = fhs[0]
+
+	return &decoded, nil
+}
+
+func (r installMDMAppleHostProfileResponse) error() error { return r.Err }
+
+func installMDMAppleHostProfileEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*installMDMAppleHostProfileRequest)
+
+	ff, err := req.Profile.Open()
+	if err != nil {
+		return &installMDMAppleHostProfileResponse{Err: err}, nil
 	}
 	defer ff.Close()
-	installer, err := svc.UploadMDMAppleInstaller(ctx, req.Installer.Filename, req.Installer.Size, ff)
+	cp, err := svc.InstallMDMAppleHostProfile(ctx, req.HostID, ff, req.Profile.Size, req.Force)
 	if err != nil {
-		return uploadAppleInstallerResponse{Err: err}, nil
+		return &installMDMAppleHostProfileResponse{Err: err}, nil
 	}
-	return &uploadAppleInstallerResponse{
-		ID: installer.ID,
+	return &installMDMAppleHostProfileResponse{
+		NewProfileResponse: mdmclient.NewProfileResponse{ProfileID: cp.ProfileID},
 	}, nil
 }
 
-func (svc *Service) UploadMDMAppleInstaller(ctx context.Context, name string, size int64, installer io.Reader) (*fleet.MDMAppleInstaller, error) {
-	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleInstaller{}, fleet.ActionWrite); err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
+// authorizeMDMAppleHostProfile loads the host and checks that the user can
+// manage the profiles of the host's team.
+func (svc *Service) authorizeMDMAppleHostProfile(ctx context.Context, hostID uint) (*fleet.Host, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, err
 	}
 
-	appConfig, err := svc.ds.AppConfig(ctx)
+	host, err := svc.ds.HostLite(ctx, hostID)
 	if err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
+		return nil, ctxerr.Wrap(ctx, err, "get host")
 	}
 
-	token := uuid.New().String()
-	if err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
+	if err := svc.authz.Authorize(ctx, host, fleet.ActionRead); err != nil {
+		return nil, err
 	}
+	var teamID uint
+	if host.TeamID != nil {
+		teamID = *host.TeamID
+	}
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleConfigProfile{TeamID: &teamID}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+	return host, nil
+}
 
-	url := svc.installerURL(token, appConfig)
-
-	var installerBuf bytes.Buffer
-	manifest, err := createManifest(size, io.TeeReader(installer, &installerBuf), url)
+func (svc *Service) InstallMDMAppleHostProfile(ctx context.Context, hostID uint, r io.Reader, size int64, force bool) (*fleet.MDMAppleConfigProfile, error) {
+	host, err := svc.authorizeMDMAppleHostProfile(ctx, hostID)
 	if err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
+		return nil, err
+	}
+	if host.Platform != "darwin" {
+		return nil, ctxerr.Wrap(ctx, &fleet.BadRequestError{Message: "Profiles can only be installed on macOS hosts."})
 	}
 
-	inst, err := svc.ds.NewMDMAppleInstaller(ctx, name, size, manifest, installerBuf.Bytes(), token)
+	b := make([]byte, size)
+	if _, err := r.Read(b); err != nil {
+		return nil, ctxerr.Wrap(ctx, &fleet.BadRequestError{
+			Message:     "failed to read config profile",
+			InternalErr: err,
+		})
+	}
+
+	cp, err := fleet.NewMDMAppleConfigProfile(b, ptr.Uint(fleet.MDMAppleHostProfilesTeamID))
 	if err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
+		return nil, ctxerr.Wrap(ctx, &fleet.BadRequestError{
+			Message: fmt.Sprintf("failed to parse config profile: %s", err.Error()),
+		})
 	}
+	cp.HostID = host.ID
 
-	return inst, nil
-}
+	if err := cp.ValidateUserProvided(); err != nil {
+		return nil, ctxerr.Wrap(ctx, &fleet.BadRequestError{Message: err.Error()})
+	}
+	if err := validateMDMAppleProfileSecrets(cp, svc.config.MDM.Secrets.Provider != ""); err != nil {
+		return nil, ctxerr.Wrap(ctx, &fleet.BadRequestError{Message: err.Error()})
+	}
 
-func (svc *Service) installerURL(token string, appConfig *fleet.AppConfig) string {
-	return fmt.Sprintf("%s%s?token=%s", appConfig.ServerSettings.ServerURL, apple_mdm.InstallerPath, token)
-}
+	if !force {
+		// the profile would overwrite the profile with the same identifier
+		// delivered to the host by another source, e.g. its team.
+		profs, err := svc.ds.GetHostMDMProfiles(ctx, host.UUID)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "get host profiles")
+		}
+		for _, p := range profs {
+			if p.Status != nil && *p.Status == fleet.MDMAppleDeliveryNotApplicable {
+				continue
+			}
+			if p.Identifier == cp.Identifier && p.OperationType != fleet.MDMAppleOperationTypeRemove {
+				return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("profile", fmt.Sprintf(
+					"Couldn’t install. The identifier (PayloadIdentifier) %q is already used by a profile installed on the host. Use the force option to continue anyway.",
+					cp.Identifier)).WithStatus(http.StatusConflict))
+			}
+		}
+	}
 
-func createManifest(size int64, installer io.Reader, url string) (string, error) {
-	manifest, err := appmanifest.New(&readerWithSize{
-		Reader: installer,
-		size:   size,
-	}, url)
+	newCP, err := svc.ds.NewMDMAppleConfigProfile(ctx, *cp)
 	if err != nil {
-		return "", fmt.Errorf("create manifest file: %w", err)
+		return nil, ctxerr.Wrap(ctx, err)
 	}
-	var buf bytes.Buffer
-	enc := plist.NewEncoder(&buf)
-	enc.Indent("  ")
-	if err := enc.Encode(manifest); err != nil {
-		return "", fmt.Errorf("encode manifest: %w", err)
+	if _, err := worker.BulkSetPendingMDMAppleHostProfiles(ctx, svc.ds, svc.logger, []uint{host.ID}, nil, nil); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
 	}
-	return buf.String(), nil
-}
 
-type readerWithSize struct {
-	io.Reader
-	size int64
-}
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), &fleet.ActivityTypeInstalledHostMacosProfile{
+		HostID:            host.ID,
+		HostDisplayName:   host.DisplayName(),
+		ProfileName:       newCP.Name,
+		ProfileIdentifier: newCP.Identifier,
+	}); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "logging activity for install host mdm apple config profile")
+	}
 
-func (r *readerWithSize) Size() int64 {
-	return r.size
+	return newCP, nil
 }
 
-type getAppleInstallerDetailsRequest struct {
-	ID uint `url:"installer_id"`
+type deleteMDMAppleHostProfileRequest struct {
+	HostID    uint `url:"id"`
+	ProfileID uint `url:"profile_id"`
 }
 
-type getAppleInstallerDetailsResponse struct {
-	Installer *fleet.MDMAppleInstaller
-	Err       error `json:"error,omitempty"`
+type deleteMDMAppleHostProfileResponse struct {
+	Err error `json:"error,omitempty"`
 }
 
-func (r getAppleInstallerDetailsResponse) error() error { return r.Err }
+func (r deleteMDMAppleHostProfileResponse) error() error { return r.Err }
 
-func getAppleInstallerEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*getAppleInstallerDetailsRequest)
-	installer, err := svc.GetMDMAppleInstallerByID(ctx, req.ID)
-	if err != nil {
-		return getAppleInstallerDetailsResponse{Err: err}, nil
+func deleteMDMAppleHostProfileEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*deleteMDMAppleHostProfileRequest)
+	if err := svc.DeleteMDMAppleHostProfile(ctx, req.HostID, req.ProfileID); err != nil {
+		return deleteMDMAppleHostProfileResponse{Err: err}, nil
 	}
-	return &getAppleInstallerDetailsResponse{
-		Installer: installer,
-	}, nil
+	return deleteMDMAppleHostProfileResponse{}, nil
 }
 
-func (svc *Service) GetMDMAppleInstallerByID(ctx context.Context, id uint) (*fleet.MDMAppleInstaller, error) {
-	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleInstaller{}, fleet.ActionWrite); err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
+func (svc *Service) DeleteMDMAppleHostProfile(ctx context.Context, hostID, profileID uint) error {
+	host, err := svc.authorizeMDMAppleHostProfile(ctx, hostID)
+	if err != nil {
+		return err
 	}
 
-	inst, err := svc.ds.MDMAppleInstallerDetailsByID(ctx, id)
+	cp, err := svc.ds.GetMDMAppleConfigProfile(ctx, profileID)
 	if err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
+		return ctxerr.Wrap(ctx, err)
 	}
-	return inst, nil
-}
-
-type deleteAppleInstallerDetailsRequest struct {
-	ID uint `url:"installer_id"`
-}
-
-type deleteAppleInstallerDetailsResponse struct {
-	Err error `json:"error,omitempty"`
-}
-
-func (r deleteAppleInstallerDetailsResponse) error() error { return r.Err }
-
-func deleteAppleInstallerEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*deleteAppleInstallerDetailsRequest)
-	if err := svc.DeleteMDMAppleInstaller(ctx, req.ID); err != nil {
-		return deleteAppleInstallerDetailsResponse{Err: err}, nil
+	// only the ad-hoc profiles of the host can be deleted with this endpoint
+	if cp.HostID != host.ID {
+		return ctxerr.Wrap(ctx, newNotFoundError(), fmt.Sprintf("profile %d is not an ad-hoc profile of host %d", profileID, hostID))
 	}
-	return &deleteAppleInstallerDetailsResponse{}, nil
-}
 
-func (svc *Service) DeleteMDMAppleInstaller(ctx context.Context, id uint) error {
-	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleInstaller{}, fleet.ActionWrite); err != nil {
+	if err := svc.ds.DeleteMDMAppleConfigProfile(ctx, profileID); err != nil {
 		return ctxerr.Wrap(ctx, err)
 	}
+	if _, err := worker.BulkSetPendingMDMAppleHostProfiles(ctx, svc.ds, svc.logger, []uint{host.ID}, nil, nil); err != nil {
+		return ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
+	}
 
-	if err := svc.ds.DeleteMDMAppleInstaller(ctx, id); err != nil {
-		return ctxerr.Wrap(ctx, err)
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), &fleet.ActivityTypeDeletedHostMacosProfile{
+		HostID:            host.ID,
+		HostDisplayName:   host.DisplayName(),
+		ProfileName:       cp.Name,
+		ProfileIdentifier: cp.Identifier,
+	}); err != nil {
+		return ctxerr.Wrap(ctx, err, "logging activity for delete host mdm apple config profile")
 	}
+
 	return nil
 }
 
-type listMDMAppleDevicesRequest struct{}
+type copyMDMAppleConfigProfileRequest struct {
+	ProfileID uint   `json:"-" url:"profile_id"`
+	TeamIDs   []uint `json:"team_ids"`
+	Force     bool   `json:"force"`
+}
 
-type listMDMAppleDevicesResponse struct {
-	Devices []fleet.MDMAppleDevice `json:"devices"`
-	Err     error                  `json:"error,omitempty"`
+type copyMDMAppleConfigProfileResponse struct {
+	ProfileIDs []uint `json:"profile_ids"`
+	Err        error  `json:"error,omitempty"`
 }
 
-func (r listMDMAppleDevicesResponse) error() error { return r.Err }
+func (r copyMDMAppleConfigProfileResponse) error() error { return r.Err }
 
-func listMDMAppleDevicesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	devices, err := svc.ListMDMAppleDevices(ctx)
+func copyMDMAppleConfigProfileEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*copyMDMAppleConfigProfileRequest)
+
+	copies, err := svc.CopyMDMAppleConfigProfile(ctx, req.ProfileID, req.TeamIDs, req.Force)
 	if err != nil {
-		return listMDMAppleDevicesResponse{Err: err}, nil
+		return &copyMDMAppleConfigProfileResponse{Err: err}, nil
 	}
-	return &listMDMAppleDevicesResponse{
-		Devices: devices,
-	}, nil
+	ids := make([]uint, 0, len(copies))
+	for _, cp := range copies {
+		ids = append(ids, cp.ProfileID)
+	}
+	return &copyMDMAppleConfigProfileResponse{ProfileIDs: ids}, nil
 }
 
-func (svc *Service) ListMDMAppleDevices(ctx context.Context) ([]fleet.MDMAppleDevice, error) {
-	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleDevice{}, fleet.ActionWrite); err != nil {
+func (svc *Service) CopyMDMAppleConfigProfile(ctx context.Context, profileID uint, teamIDs []uint, force bool) ([]*fleet.MDMAppleConfigProfile, error) {
+	// first we perform a basic authz check
+	if err := svc.authz.Authorize(ctx, &fleet.Team{}, fleet.ActionRead); err != nil {
 		return nil, ctxerr.Wrap(ctx, err)
 	}
 
-	return svc.ds.MDMAppleListDevices(ctx)
-}
-
-type listMDMAppleDEPDevicesRequest struct{}
+	if len(teamIDs) == 0 {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("team_ids", "at least one team is required"))
+	}
 
-type listMDMAppleDEPDevicesResponse struct {
-	Devices []fleet.MDMAppleDEPDevice `json:"devices"`
-	Err     error                     `json:"error,omitempty"`
-}
+	cp, err := svc.ds.GetMDMAppleConfigProfile(ctx, profileID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	if err := svc.authz.Authorize(ctx, cp, fleet.ActionRead); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	for _, teamID := range teamIDs {
+		teamID := teamID
+		if err := svc.authz.Authorize(ctx, &fleet.MDMAppleConfigProfile{TeamID: &teamID}, fleet.ActionWrite); err != nil {
+			return nil, ctxerr.Wrap(ctx, err)
+		}
+	}
 
-func (r listMDMAppleDEPDevicesResponse) error() error { return r.Err }
+	// prevent copying profiles that are managed by Fleet, each team has its own
+	if _, ok := mobileconfig.FleetPayloadIdentifiers()[cp.Identifier]; ok {
+		return nil, &fleet.BadRequestError{
+			Message:     "profiles managed by Fleet can't be copied using this endpoint.",
+			InternalErr: fmt.Errorf("copying profile %s not allowed because it's managed by Fleet", cp.Identifier),
+		}
+	}
 
-func listMDMAppleDEPDevicesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	devices, err := svc.ListMDMAppleDEPDevices(ctx)
+	// the profile is validated again for each target team, as the teams may
+	// not allow the same reserved payloads. The original upload already
+	// acknowledged them.
+	parsed, err := fleet.NewMDMAppleConfigProfile(cp.Mobileconfig, cp.TeamID)
 	if err != nil {
-		return listMDMAppleDEPDevicesResponse{Err: err}, nil
+		return nil, ctxerr.Wrap(ctx, err, "parse config profile")
 	}
-	return &listMDMAppleDEPDevicesResponse{
-		Devices: devices,
-	}, nil
-}
-
-func (svc *Service) ListMDMAppleDEPDevices(ctx context.Context) ([]fleet.MDMAppleDEPDevice, error) {
-	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleDEPDevice{}, fleet.ActionWrite); err != nil {
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
 		return nil, ctxerr.Wrap(ctx, err)
 	}
-	depClient := apple_mdm.NewDEPClient(svc.depStorage, svc.ds, svc.logger)
+	targets := make([]fleet.ActivityTeam, 0, len(teamIDs))
+	for _, teamID := range teamIDs {
+		teamID := teamID
+		var teamName string
+		allowReserved := appCfg.MDM.MacOSSettings.AllowReservedPayloads
+		if teamID >= 1 {
+			tm, err := svc.EnterpriseOverrides.TeamByIDOrName(ctx, &teamID, nil)
+			if err != nil {
+				return nil, ctxerr.Wrap(ctx, err)
+			}
+			teamName = tm.Name
+			allowReserved = tm.Config.MDM.MacOSSettings.AllowReservedPayloads
+		}
+		if err := validateUserProvidedMDMAppleProfile(parsed, allowReserved, true); err != nil {
+			return nil, ctxerr.Wrap(ctx, &fleet.BadRequestError{Message: fmt.Sprintf("team %d: %s", teamID, err.Error())})
+		}
 
-	// TODO(lucas): Use cursors and limit to fetch in multiple requests.
-	// This single-request version supports up to 1000 devices (max to return in one call).
-	fetchDevicesResponse, err := depClient.FetchDevices(ctx, apple_mdm.DEPName, godep.WithLimit(1000))
+		if !force {
+			err := svc.checkMDMAppleProfileIdentifierConflicts(ctx, &teamID, []*fleet.MDMAppleConfigProfile{parsed}, func(int) string {
+				return "team_ids"
+			}, "Couldn’t copy.")
+			if err != nil {
+				return nil, err
+			}
+		}
+		targets = append(targets, activityTeam(teamID, teamName))
+	}
+
+	copies, err := svc.ds.CopyMDMAppleConfigProfileToTeams(ctx, parsed, teamIDs)
 	if err != nil {
 		return nil, ctxerr.Wrap(ctx, err)
 	}
+	logReservedPayloadTypes(svc.logger, parsed)
+	copyIDs := make([]uint, 0, len(copies))
+	for _, c := range copies {
+		copyIDs = append(copyIDs, c.ProfileID)
+	}
+	if _, err := worker.BulkSetPendingMDMAppleHostProfiles(ctx, svc.ds, svc.logger, nil, nil, copyIDs); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
+	}
 
-	devices := make([]fleet.MDMAppleDEPDevice, len(fetchDevicesResponse.Devices))
-	for i := range fetchDevicesResponse.Devices {
-		devices[i] = fleet.MDMAppleDEPDevice{Device: fetchDevicesResponse.Devices[i]}
+	sourceTeamName, err := svc.mdmAppleProfileTeamName(ctx, *cp.TeamID)
+	if err != nil {
+		return nil, err
 	}
-	return devices, nil
+	source := activityTeam(*cp.TeamID, sourceTeamName)
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), &fleet.ActivityTypeCopiedMacosProfile{
+		ProfileName:       cp.Name,
+		ProfileIdentifier: cp.Identifier,
+		TeamID:            source.TeamID,
+		TeamName:          source.TeamName,
+		TargetTeams:       targets,
+	}); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "logging activity for copy mdm apple config profile")
+	}
+
+	return copies, nil
 }
 
-type newMDMAppleDEPKeyPairResponse struct {
-	PublicKey  []byte `json:"public_key,omitempty"`
-	PrivateKey []byte `json:"private_key,omitempty"`
-	Err        error  `json:"error,omitempty"`
+type retryMDMAppleConfigProfileRequest struct {
+	ProfileID uint  `json:"-" url:"profile_id"`
+	HostID    *uint `json:"host_id"`
+	TeamID    *uint `json:"team_id"`
 }
 
-func (r newMDMAppleDEPKeyPairResponse) error() error { return r.Err }
+type retryMDMAppleConfigProfileResponse struct {
+	HostCount int64 `json:"host_count"`
+	Err       error `json:"error,omitempty"`
+}
 
-func newMDMAppleDEPKeyPairEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	keyPair, err := svc.NewMDMAppleDEPKeyPair(ctx)
+func (r retryMDMAppleConfigProfileResponse) error() error { return r.Err }
+
+func retryMDMAppleConfigProfileEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*retryMDMAppleConfigProfileRequest)
+
+	count, err := svc.RetryMDMAppleConfigProfile(ctx, req.ProfileID, req.HostID, req.TeamID)
 	if err != nil {
-		return newMDMAppleDEPKeyPairResponse{
-			Err: err,
-		}, nil
+		return retryMDMAppleConfigProfileResponse{Err: err}, nil
 	}
-
-	return newMDMAppleDEPKeyPairResponse{
-		PublicKey:  keyPair.PublicKey,
-		PrivateKey: keyPair.PrivateKey,
-	}, nil
+	return retryMDMAppleConfigProfileResponse{HostCount: count}, nil
 }
 
-func (svc *Service) NewMDMAppleDEPKeyPair(ctx context.Context) (*fleet.MDMAppleDEPKeyPair, error) {
-	// skipauth: Generating a new key pair does not actually make any changes to fleet, or expose any
-	// information. The user must configure fleet with the new key pair and restart the server.
-	svc.authz.SkipAuthorization(ctx)
+func (svc *Service) RetryMDMAppleConfigProfile(ctx context.Context, profileID uint, hostID, teamID *uint) (int64, error) {
+	// first we perform a basic authz check
+	if err := svc.authz.Authorize(ctx, &fleet.Team{}, fleet.ActionRead); err != nil {
+		return 0, ctxerr.Wrap(ctx, err)
+	}
 
-	publicKeyPEM, privateKeyPEM, err := apple_mdm.NewDEPKeyPairPEM()
+	if (hostID == nil) == (teamID == nil) {
+		return 0, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("host_id", "exactly one of host_id or team_id must be provided"))
+	}
+
+	cp, err := svc.ds.GetMDMAppleConfigProfile(ctx, profileID)
 	if err != nil {
-		return nil, fmt.Errorf("generate key pair: %w", err)
+		return 0, ctxerr.Wrap(ctx, err)
+	}
+	if err := svc.authz.Authorize(ctx, cp, fleet.ActionRead); err != nil {
+		return 0, ctxerr.Wrap(ctx, err)
 	}
 
-	return &fleet.MDMAppleDEPKeyPair{
-		PublicKey:  publicKeyPEM,
-		PrivateKey: privateKeyPEM,
-	}, nil
+	var hostUUID string
+	var tmID uint
+	if hostID != nil {
+		host, err := svc.authorizeMDMAppleHostProfile(ctx, *hostID)
+		if err != nil {
+			return 0, err
+		}
+		hostUUID = host.UUID
+	} else {
+		tmID = *teamID
+		if err := svc.authz.Authorize(ctx, &fleet.MDMAppleConfigProfile{TeamID: &tmID}, fleet.ActionWrite); err != nil {
+			return 0, ctxerr.Wrap(ctx, err)
+		}
+		if tmID >= 1 {
+			// confirm that team exists
+			if _, err := svc.ds.Team(ctx, tmID); err != nil {
+				return 0, ctxerr.Wrap(ctx, err)
+			}
+		}
+	}
+
+	// the profile is installed again on the hosts by the profiles
+	// reconciliation, and the retries of the retry policy start over.
+	count, err := svc.ds.ResetFailedHostMDMAppleProfile(ctx, profileID, hostUUID, tmID)
+	if err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "reset failed host profiles")
+	}
+	return count, nil
 }
 
-type enqueueMDMAppleCommandRequest struct {
-	Command   string   `json:"command"`
-	DeviceIDs []string `json:"device_ids"`
+// mdmAppleProfileTeamName returns the name of the team of a profile, or an
+// empty string for no team.
+func (svc *Service) mdmAppleProfileTeamName(ctx context.Context, teamID uint) (string, error) {
+	if teamID == 0 {
+		return "", nil
+	}
+	tm, err := svc.EnterpriseOverrides.TeamByIDOrName(ctx, &teamID, nil)
+	if err != nil {
+		return "", ctxerr.Wrap(ctx, err)
+	}
+	return tm.Name, nil
 }
 
-type enqueueMDMAppleCommandResponse struct {
-	*fleet.CommandEnqueueResult
-	status int   `json:"-"`
-	Err    error `json:"error,omitempty"`
+// activityTeam returns the team as recorded in an activity, with a nil id and
+// name for no team.
+func activityTeam(teamID uint, teamName string) fleet.ActivityTeam {
+	if teamID == 0 {
+		return fleet.ActivityTeam{}
+	}
+	return fleet.ActivityTeam{TeamID: &teamID, TeamName: &teamName}
 }
 
-func (r enqueueMDMAppleCommandResponse) error() error { return r.Err }
-func (r enqueueMDMAppleCommandResponse) Status() int  { return r.status }
+type getMDMAppleProfilesSummaryRequest = mdmclient.GetProfilesSummaryRequest
 
-func enqueueMDMAppleCommandEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*enqueueMDMAppleCommandRequest)
-	status, result, err := svc.EnqueueMDMAppleCommand(ctx, req.Command, req.DeviceIDs, false)
+type getMDMAppleProfilesSummaryResponse struct {
+	mdmclient.GetProfilesSummaryResponse
+	Err error `json:"error,omitempty"`
+}
+
+func (r getMDMAppleProfilesSummaryResponse) error() error { return r.Err }
+
+func getMDMAppleProfilesSummaryEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getMDMAppleProfilesSummaryRequest)
+	res := getMDMAppleProfilesSummaryResponse{}
+
+	ps, err := svc.GetMDMAppleProfilesSummary(ctx, req.TeamID)
 	if err != nil {
-		return enqueueMDMAppleCommandResponse{Err: err}, nil
+		return &getMDMAppleProfilesSummaryResponse{Err: err}, nil
 	}
-	return enqueueMDMAppleCommandResponse{
-		status:               status,
-		CommandEnqueueResult: result,
-	}, nil
+
+	res.Verifying = ps.Verifying
+	res.Failed = ps.Failed
+	res.Pending = ps.Pending
+	res.ReservedPayloadConflicts = ps.ReservedPayloadConflicts
+
+	return &res, nil
 }
 
-func (svc *Service) EnqueueMDMAppleCommand(
-	ctx context.Context,
-	rawBase64Cmd string,
-	deviceIDs []string,
-	noPush bool,
-) (status int, result *fleet.CommandEnqueueResult, err error) {
-	premiumCommands := map[string]bool{
-		"EraseDevice": true,
-		"DeviceLock":  true,
+func (svc *Service) GetMDMAppleProfilesSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleConfigProfilesSummary, error) {
+	if err := svc.authz.Authorize(ctx, fleet.MDMAppleConfigProfile{TeamID: teamID}, fleet.ActionRead); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
 	}
 
-	// load hosts (lite) by uuids, check that the user has the rights to run
-	// commands for every affected team.
-	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
-		return 0, nil, ctxerr.Wrap(ctx, err)
+	ps, err := svc.ds.GetMDMAppleHostsProfilesSummary(ctx, teamID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
 	}
 
-	vc, ok := viewer.FromContext(ctx)
-	if !ok {
-		return 0, nil, fleet.ErrNoContext
-	}
-	// for the team filter, we don't include observers as we require maintainer
-	// and up to run commands.
-	filter := fleet.TeamFilter{User: vc.User, IncludeObserver: false}
-	hosts, err := svc.ds.ListHostsLiteByUUIDs(ctx, filter, deviceIDs)
+	conflicts, err := svc.ds.ListMDMAppleReservedPayloadConflicts(ctx, teamID)
 	if err != nil {
-		return 0, nil, err
-	}
-	if len(hosts) == 0 {
-		return 0, nil, newNotFoundError()
+		return nil, ctxerr.Wrap(ctx, err, "list reserved payload conflicts")
 	}
+	ps.ReservedPayloadConflicts = conflicts
 
-	// collect the team IDs and verify that the user has access to run commands
-	// on all affected teams.
-	teamIDs := make(map[uint]bool)
-	for _, h := range hosts {
-		var id uint
-		if h.TeamID != nil {
-			id = *h.TeamID
-		}
-		teamIDs[id] = true
-	}
+	return ps, nil
+}
 
-	var commandAuthz fleet.MDMAppleCommandAuthz
-	for tmID := range teamIDs {
-		commandAuthz.TeamID = &tmID
-		if tmID == 0 {
-			commandAuthz.TeamID = nil
-		}
+type listMDMAppleProfilesSummaryByTeamRequest struct{}
 
-		if err := svc.authz.Authorize(ctx, commandAuthz, fleet.ActionWrite); err != nil {
-			return 0, nil, ctxerr.Wrap(ctx, err)
-		}
-	}
+type listMDMAppleProfilesSummaryByTeamResponse struct {
+	Teams []*fleet.MDMAppleConfigProfilesTeamSummary `json:"teams"`
+	Err   error                                      `json:"error,omitempty"`
+}
 
-	rawXMLCmd, err := base64.RawStdEncoding.DecodeString(rawBase64Cmd)
+func (r listMDMAppleProfilesSummaryByTeamResponse) error() error { return r.Err }
+
+func listMDMAppleProfilesSummaryByTeamEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	teams, err := svc.ListMDMAppleProfilesSummaryByTeam(ctx)
 	if err != nil {
-		err = fleet.NewInvalidArgumentError("command", "unable to decode base64 command").WithStatus(http.StatusBadRequest)
+		return &listMDMAppleProfilesSummaryByTeamResponse{Err: err}, nil
+	}
+	return &listMDMAppleProfilesSummaryByTeamResponse{Teams: teams}, nil
+}
 
-		return 0, nil, ctxerr.Wrap(ctx, err, "decode base64 command")
+func (svc *Service) ListMDMAppleProfilesSummaryByTeam(ctx context.Context) ([]*fleet.MDMAppleConfigProfilesTeamSummary, error) {
+	// the summary spans all teams, so it is restricted to global admins.
+	if err := svc.authz.Authorize(ctx, &fleet.AppleMDM{}, fleet.ActionRead); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
 	}
-	cmd, err := mdm.DecodeCommand(rawXMLCmd)
+
+	teams, err := svc.ds.ListMDMAppleHostsProfilesSummaryByTeam(ctx)
 	if err != nil {
-		err = fleet.NewInvalidArgumentError("command", "unable to decode plist command").WithStatus(http.StatusUnsupportedMediaType)
-		return 0, nil, ctxerr.Wrap(ctx, err, "decode plist command")
+		return nil, ctxerr.Wrap(ctx, err)
 	}
+	return teams, nil
+}
 
-	if premiumCommands[strings.TrimSpace(cmd.Command.RequestType)] {
-		lic, err := svc.License(ctx)
-		if err != nil {
-			return 0, nil, ctxerr.Wrap(ctx, err, "get license")
-		}
-		if !lic.IsPremium() {
-			return 0, nil, fleet.ErrMissingLicense
-		}
-	}
+type getMDMAppleFileVaultSummaryRequest = mdmclient.GetFileVaultSummaryRequest
 
-	if err := svc.mdmAppleCommander.EnqueueCommand(ctx, deviceIDs, string(rawXMLCmd)); err != nil {
-		// if at least one UUID enqueued properly, return success, otherwise return
-		// error
-		var apnsErr *apple_mdm.APNSDeliveryError
-		var mysqlErr *mysql.MySQLError
-		if errors.As(err, &apnsErr) {
-			if len(apnsErr.FailedUUIDs) < len(deviceIDs) {
-				// some hosts properly received the command, so return success, with the list
-				// of failed uuids.
-				return http.StatusOK, &fleet.CommandEnqueueResult{
-					CommandUUID: cmd.CommandUUID,
-					RequestType: cmd.Command.RequestType,
-					FailedUUIDs: apnsErr.FailedUUIDs,
-				}, nil
-			}
-			// push failed for all hosts
-			err := fleet.NewBadGatewayError("Apple push notificiation service", err)
-			return http.StatusBadGateway, nil, ctxerr.Wrap(ctx, err, "enqueue command")
+type getMDMAppleFileVauleSummaryResponse struct {
+	mdmclient.GetFileVaultSummaryResponse
+	Err error `json:"error,omitempty"`
+}
 
-		} else if errors.As(err, &mysqlErr) {
-			// enqueue may fail with a foreign key constraint error 1452 when one of
-			// the hosts provided is not enrolled in nano_enrollments. Detect when
-			// that's the case and add information to the error.
-			if mysqlErr.Number == mysqlerr.ER_NO_REFERENCED_ROW_2 {
-				err := fleet.NewInvalidArgumentError(
-					"device_ids",
-					fmt.Sprintf("at least one of the hosts is not enrolled in MDM: %v", err),
-				).WithStatus(http.StatusConflict)
-				return http.StatusConflict, nil, ctxerr.Wrap(ctx, err, "enqueue command")
-			}
-		}
+func (r getMDMAppleFileVauleSummaryResponse) error() error { return r.Err }
 
-		return http.StatusInternalServerError, nil, ctxerr.Wrap(ctx, err, "enqueue command")
+func getMdmAppleFileVaultSummaryEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getMDMAppleFileVaultSummaryRequest)
+
+	fvs, err := svc.GetMDMAppleFileVaultSummary(ctx, req.TeamID)
+	if err != nil {
+		return &getMDMAppleFileVauleSummaryResponse{Err: err}, nil
 	}
-	return http.StatusOK, &fleet.CommandEnqueueResult{
-		CommandUUID: cmd.CommandUUID,
-		RequestType: cmd.Command.RequestType,
-	}, nil
-}
 
-type mdmAppleEnrollRequest struct {
-	Token string `query:"token"`
+	return &getMDMAppleFileVauleSummaryResponse{
+		GetFileVaultSummaryResponse: mdmclient.GetFileVaultSummaryResponse{MDMAppleFileVaultSummary: fvs},
+	}, nil
 }
 
-func (r mdmAppleEnrollResponse) error() error { return r.Err }
+// QUESTION: workflow for developing new APIs? whats your setup quickly test code working?
+func (svc *Service) GetMDMAppleFileVaultSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleFileVaultSummary, error) {
+	if err := svc.authz.Authorize(ctx, fleet.MDMAppleConfigProfile{TeamID: teamID}, fleet.ActionRead); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
 
-type mdmAppleEnrollResponse struct {
-	Err error `json:"error,omitempty"`
+	fvs, err := svc.ds.GetMDMAppleFileVaultSummary(ctx, teamID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
 
-	// Profile field is used in hijackRender for the response.
-	Profile []byte
+	return fvs, nil
 }
 
-func (r mdmAppleEnrollResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
-	w.Header().Set("Content-Length", strconv.FormatInt(int64(len(r.Profile)), 10))
-	w.Header().Set("Content-Type", "application/x-apple-aspen-config")
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.Header().Set("Content-Disposition", "attachment;fleet-enrollment-profile.mobileconfig")
+type rotateMDMAppleFileVaultKeysRequest struct {
+	TeamID   *uint `query:"team_id,optional"`
+	TargetID *uint `query:"target_id,optional"`
+}
 
-	// OK to just log the error here as writing anything on
-	// `http.ResponseWriter` sets the status code to 200 (and it can't be
-	// changed.) Clients should rely on matching content-length with the
-	// header provided.
-	if n, err := w.Write(r.Profile); err != nil {
-		logging.WithExtras(ctx, "err", err, "written", n)
-	}
+type rotateMDMAppleFileVaultKeysResponse struct {
+	HostCount int   `json:"host_count"`
+	Err       error `json:"error,omitempty"`
 }
 
-func mdmAppleEnrollEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*mdmAppleEnrollRequest)
+func (r rotateMDMAppleFileVaultKeysResponse) error() error { return r.Err }
 
-	profile, err := svc.GetMDMAppleEnrollmentProfileByToken(ctx, req.Token)
+func rotateMDMAppleFileVaultKeysEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*rotateMDMAppleFileVaultKeysRequest)
+	n, err := svc.RotateMDMAppleFileVaultKeys(ctx, req.TeamID, req.TargetID)
 	if err != nil {
-		return mdmAppleEnrollResponse{Err: err}, nil
+		return rotateMDMAppleFileVaultKeysResponse{Err: err}, nil
 	}
-	return mdmAppleEnrollResponse{
-		Profile: profile,
-	}, nil
+	return rotateMDMAppleFileVaultKeysResponse{HostCount: n}, nil
 }
 
-func (svc *Service) GetMDMAppleEnrollmentProfileByToken(ctx context.Context, token string) (profile []byte, err error) {
-	// skipauth: The enroll profile endpoint is unauthenticated.
+func (svc *Service) RotateMDMAppleFileVaultKeys(ctx context.Context, teamID, targetID *uint) (int, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
 	svc.authz.SkipAuthorization(ctx)
 
-	_, err = svc.ds.GetMDMAppleEnrollmentProfileByToken(ctx, token)
-	if err != nil {
-		if fleet.IsNotFound(err) {
-			return nil, fleet.NewAuthFailedError("enrollment profile not found")
-		}
-		return nil, ctxerr.Wrap(ctx, err, "get enrollment profile")
-	}
+	return 0, fleet.ErrMissingLicense
+}
 
-	appConfig, err := svc.ds.AppConfig(ctx)
-	if err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
-	}
+// mdmAppleFileVaultKeyRotationBatchSize is the maximum number of hosts
+// notified to rotate their FileVault key in a single run, so that the end
+// users aren't all prompted at once.
+const mdmAppleFileVaultKeyRotationBatchSize = 50
 
-	// TODO(lucas): Actually use enrollment (when we define which configuration we want to define
-	// on enrollments).
-	mobileconfig, err := apple_mdm.GenerateEnrollmentProfileMobileconfig(
-		appConfig.OrgInfo.OrgName,
-		appConfig.ServerSettings.ServerURL,
-		svc.config.MDM.AppleSCEPChallenge,
-		svc.mdmPushCertTopic,
-	)
+// NotifyMDMAppleFileVaultKeyRotations notifies the next batch of hosts flagged
+// for the rotation of their FileVault key. fleetd prompts the end user to
+// rotate the key on its next config fetch.
+func NotifyMDMAppleFileVaultKeyRotations(ctx context.Context, ds fleet.Datastore, logger kitlog.Logger) error {
+	n, err := ds.NotifyMDMAppleFileVaultKeyRotations(ctx, mdmAppleFileVaultKeyRotationBatchSize)
 	if err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
+		return ctxerr.Wrap(ctx, err, "notify FileVault key rotations")
 	}
-	return mobileconfig, nil
+	if n > 0 {
+		level.Info(logger).Log("msg", "notified hosts to rotate their FileVault key", "count", n)
+	}
+	return nil
 }
 
-type mdmAppleCommandRemoveEnrollmentProfileRequest struct {
-	HostID uint `url:"id"`
+type listMDMAppleEnrollmentMismatchesRequest struct {
+	TeamID *uint `query:"team_id,optional"`
 }
 
-type mdmAppleCommandRemoveEnrollmentProfileResponse struct {
-	Err error `json:"error,omitempty"`
+type listMDMAppleEnrollmentMismatchesResponse struct {
+	Mismatches []*fleet.MDMAppleEnrollmentMismatch `json:"mismatches"`
+	Err        error                               `json:"error,omitempty"`
 }
 
-func (r mdmAppleCommandRemoveEnrollmentProfileResponse) error() error { return r.Err }
+func (r listMDMAppleEnrollmentMismatchesResponse) error() error { return r.Err }
 
-func mdmAppleCommandRemoveEnrollmentProfileEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*mdmAppleCommandRemoveEnrollmentProfileRequest)
-	err := svc.EnqueueMDMAppleCommandRemoveEnrollmentProfile(ctx, req.HostID)
+func listMDMAppleEnrollmentMismatchesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listMDMAppleEnrollmentMismatchesRequest)
+
+	mismatches, err := svc.ListMDMAppleEnrollmentMismatches(ctx, req.TeamID)
 	if err != nil {
-		return mdmAppleCommandRemoveEnrollmentProfileResponse{Err: err}, nil
+		return &listMDMAppleEnrollmentMismatchesResponse{Err: err}, nil
 	}
-	return mdmAppleCommandRemoveEnrollmentProfileResponse{}, nil
+	return &listMDMAppleEnrollmentMismatchesResponse{Mismatches: mismatches}, nil
 }
 
-func (svc *Service) EnqueueMDMAppleCommandRemoveEnrollmentProfile(ctx context.Context, hostID uint) error {
-	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
-		return err
+func (svc *Service) ListMDMAppleEnrollmentMismatches(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleEnrollmentMismatch, error) {
+	if err := svc.authz.Authorize(ctx, fleet.MDMAppleConfigProfile{TeamID: teamID}, fleet.ActionRead); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
 	}
 
-	h, err := svc.ds.HostLite(ctx, hostID)
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	mdmServerURL, err := apple_mdm.ResolveAppleMDMURL(appCfg.ServerSettings.ServerURL)
 	if err != nil {
-		return ctxerr.Wrap(ctx, err, "getting host info for mdm apple remove profile command")
+		return nil, ctxerr.Wrap(ctx, err, "resolve mdm server url")
 	}
 
-	info, err := svc.ds.GetHostMDMCheckinInfo(ctx, h.UUID)
+	mismatches, err := svc.ds.ListMDMAppleEnrollmentMismatches(ctx, teamID, mdmServerURL)
 	if err != nil {
-		return ctxerr.Wrap(ctx, err, "getting mdm checkin info for mdm apple remove profile command")
+		return nil, ctxerr.Wrap(ctx, err)
 	}
+	return mismatches, nil
+}
 
-	// Check authorization again based on host info for team-based permissions.
+// defaultMDMAppleMissingFleetdMinHours is the default number of hours after
+// the MDM enrollment of a host after which fleetd is considered missing if it
+// didn't enroll.
+const defaultMDMAppleMissingFleetdMinHours = 4
+
+type listMDMAppleHostsMissingFleetdRequest struct {
+	TeamID   *uint `query:"team_id,optional"`
+	MinHours *uint `query:"min_hours,optional"`
+}
+
+type listMDMAppleHostsMissingFleetdResponse struct {
+	Hosts []*fleet.MDMAppleHostMissingFleetd `json:"hosts"`
+	Err   error                              `json:"error,omitempty"`
+}
+
+func (r listMDMAppleHostsMissingFleetdResponse) error() error { return r.Err }
+
+func listMDMAppleHostsMissingFleetdEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listMDMAppleHostsMissingFleetdRequest)
+
+	hosts, err := svc.ListMDMAppleHostsMissingFleetd(ctx, req.TeamID, req.MinHours)
+	if err != nil {
+		return &listMDMAppleHostsMissingFleetdResponse{Err: err}, nil
+	}
+	return &listMDMAppleHostsMissingFleetdResponse{Hosts: hosts}, nil
+}
+
+func (svc *Service) ListMDMAppleHostsMissingFleetd(ctx context.Context, teamID *uint, minHours *uint) ([]*fleet.MDMAppleHostMissingFleetd, error) {
+	if err := svc.authz.Authorize(ctx, fleet.MDMAppleConfigProfile{TeamID: teamID}, fleet.ActionRead); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	hours := uint(defaultMDMAppleMissingFleetdMinHours)
+	if minHours != nil {
+		hours = *minHours
+	}
+	enrolledBefore := svc.clock.Now().Add(-time.Duration(hours) * time.Hour)
+
+	hosts, err := svc.ds.ListMDMAppleHostsMissingFleetd(ctx, teamID, enrolledBefore)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	return hosts, nil
+}
+
+type installMDMAppleFleetdRequest struct {
+	HostID uint `url:"id"`
+}
+
+type installMDMAppleFleetdResponse struct {
+	CommandUUID string `json:"command_uuid"`
+	Err         error  `json:"error,omitempty"`
+}
+
+func (r installMDMAppleFleetdResponse) error() error { return r.Err }
+
+func installMDMAppleFleetdEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*installMDMAppleFleetdRequest)
+	cmdUUID, err := svc.InstallMDMAppleFleetd(ctx, req.HostID)
+	if err != nil {
+		return installMDMAppleFleetdResponse{Err: err}, nil
+	}
+	return installMDMAppleFleetdResponse{CommandUUID: cmdUUID}, nil
+}
+
+func (svc *Service) InstallMDMAppleFleetd(ctx context.Context, hostID uint) (string, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return "", err
+	}
+
+	h, err := svc.ds.HostLite(ctx, hostID)
+	if err != nil {
+		return "", ctxerr.Wrap(ctx, err, "getting host to install fleetd")
+	}
+
+	// installing fleetd runs a command on the host, it requires the same
+	// permissions as running commands.
 	if err := svc.authz.Authorize(ctx, fleet.MDMAppleCommandAuthz{
 		TeamID: h.TeamID,
 	}, fleet.ActionWrite); err != nil {
-		return err
+		return "", err
 	}
 
-	nanoEnroll, err := svc.ds.GetNanoMDMEnrollment(ctx, h.UUID)
+	if h.NodeKey != nil {
+		return "", ctxerr.Wrap(ctx, &fleet.BadRequestError{Message: "fleetd is already enrolled on the host."})
+	}
+	enrollment, err := svc.ds.GetNanoMDMEnrollment(ctx, h.UUID)
 	if err != nil {
-		return ctxerr.Wrap(ctx, err, "getting mdm enrollment status for mdm apple remove profile command")
+		return "", ctxerr.Wrap(ctx, err, "getting mdm enrollment of host")
 	}
-	if nanoEnroll == nil || !nanoEnroll.Enabled {
-		return fleet.NewUserMessageError(ctxerr.New(ctx, fmt.Sprintf("mdm is not enabled for host %d", hostID)), http.StatusConflict)
+	if enrollment == nil || !enrollment.Enabled || enrollment.Type != "Device" {
+		return "", ctxerr.Wrap(ctx, &fleet.BadRequestError{Message: "The host is not enrolled in Fleet's MDM."})
 	}
 
 	cmdUUID := uuid.New().String()
-	err = svc.mdmAppleCommander.RemoveProfile(ctx, []string{h.UUID}, apple_mdm.FleetPayloadIdentifier, cmdUUID)
+	if err := svc.mdmAppleCommander.InstallEnterpriseApplication(ctx, []string{h.UUID}, cmdUUID, apple_mdm.FleetdPublicManifestURL); err != nil {
+		return "", ctxerr.Wrap(ctx, err, "enqueue fleetd install command")
+	}
+
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), fleet.ActivityTypeRequestedFleetdInstall{
+		HostID:          h.ID,
+		HostDisplayName: h.DisplayName(),
+		CommandUUID:     cmdUUID,
+	}); err != nil {
+		return "", ctxerr.Wrap(ctx, err, "create activity for fleetd install")
+	}
+	return cmdUUID, nil
+}
+
+type listMDMAppleHostQueuedCommandsRequest struct {
+	HostID uint `url:"id"`
+}
+
+type listMDMAppleHostQueuedCommandsResponse struct {
+	QueuedCommands []*fleet.MDMAppleHostQueuedCommand `json:"queued_commands"`
+	Err            error                              `json:"error,omitempty"`
+}
+
+func (r listMDMAppleHostQueuedCommandsResponse) error() error { return r.Err }
+
+func listMDMAppleHostQueuedCommandsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listMDMAppleHostQueuedCommandsRequest)
+	cmds, err := svc.ListMDMAppleHostQueuedCommands(ctx, req.HostID)
 	if err != nil {
-		return ctxerr.Wrap(ctx, err, "enqueuing mdm apple remove profile command")
+		return listMDMAppleHostQueuedCommandsResponse{Err: err}, nil
 	}
+	if cmds == nil {
+		cmds = []*fleet.MDMAppleHostQueuedCommand{}
+	}
+	return listMDMAppleHostQueuedCommandsResponse{QueuedCommands: cmds}, nil
+}
 
-	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), &fleet.ActivityTypeMDMUnenrolled{
-		HostSerial:       h.HardwareSerial,
-		HostDisplayName:  h.DisplayName(),
-		InstalledFromDEP: info.InstalledFromDEP,
+func (svc *Service) ListMDMAppleHostQueuedCommands(ctx context.Context, hostID uint) ([]*fleet.MDMAppleHostQueuedCommand, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, err
+	}
+
+	h, err := svc.ds.HostLite(ctx, hostID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "getting host to list queued commands")
+	}
+	if err := svc.authz.Authorize(ctx, fleet.MDMAppleCommandAuthz{
+		TeamID: h.TeamID,
+	}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	cmds, err := svc.ds.ListMDMAppleHostQueuedCommands(ctx, h.UUID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list host queued commands")
+	}
+	return cmds, nil
+}
+
+type cancelMDMAppleHostQueuedCommandRequest struct {
+	HostID      uint   `url:"id"`
+	CommandUUID string `url:"command_uuid"`
+}
+
+type cancelMDMAppleHostQueuedCommandResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r cancelMDMAppleHostQueuedCommandResponse) error() error { return r.Err }
+
+func (r cancelMDMAppleHostQueuedCommandResponse) Status() int { return http.StatusNoContent }
+
+func cancelMDMAppleHostQueuedCommandEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*cancelMDMAppleHostQueuedCommandRequest)
+	if err := svc.CancelMDMAppleHostQueuedCommand(ctx, req.HostID, req.CommandUUID); err != nil {
+		return cancelMDMAppleHostQueuedCommandResponse{Err: err}, nil
+	}
+	return cancelMDMAppleHostQueuedCommandResponse{}, nil
+}
+
+func (svc *Service) CancelMDMAppleHostQueuedCommand(ctx context.Context, hostID uint, commandUUID string) error {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return err
+	}
+
+	h, err := svc.ds.HostLite(ctx, hostID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "getting host to cancel queued command")
+	}
+	// canceling a command requires the same permissions as running it.
+	if err := svc.authz.Authorize(ctx, fleet.MDMAppleCommandAuthz{
+		TeamID: h.TeamID,
+	}, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	cmds, err := svc.ds.ListMDMAppleHostQueuedCommands(ctx, h.UUID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list host queued commands")
+	}
+	var cmd *fleet.MDMAppleHostQueuedCommand
+	for _, c := range cmds {
+		if c.CommandUUID == commandUUID {
+			cmd = c
+			break
+		}
+	}
+	if cmd == nil {
+		return ctxerr.Wrap(ctx, newNotFoundError(), fmt.Sprintf("command %s is not queued for host %d", commandUUID, hostID))
+	}
+	// the commands of the profiles are managed by Fleet, canceling them would
+	// leave the profile pending forever.
+	if cmd.ProfileIdentifier != nil {
+		return ctxerr.Wrap(ctx, &fleet.BadRequestError{
+			Message: fmt.Sprintf("The command installs or removes the configuration profile %s and can't be canceled. Update the profiles of the host instead.", *cmd.ProfileIdentifier),
+		})
+	}
+
+	if err := svc.ds.CancelMDMAppleHostQueuedCommand(ctx, h.UUID, commandUUID); err != nil {
+		return ctxerr.Wrap(ctx, err, "cancel host queued command")
+	}
+
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), &fleet.ActivityTypeCanceledMDMAppleCommand{
+		HostID:          h.ID,
+		HostDisplayName: h.DisplayName(),
+		CommandUUID:     commandUUID,
+		RequestType:     cmd.RequestType,
 	}); err != nil {
-		return ctxerr.Wrap(ctx, err, "logging activity for mdm apple remove profile command")
+		return ctxerr.Wrap(ctx, err, "create activity for canceled command")
 	}
+	return nil
+}
 
-	return svc.pollResultMDMAppleCommandRemoveEnrollmentProfile(ctx, cmdUUID, h.UUID)
+type listMDMAppleHostCommandsRequest struct {
+	HostID      uint              `url:"id"`
+	ListOptions fleet.ListOptions `url:"list_options"`
 }
 
-func (svc *Service) pollResultMDMAppleCommandRemoveEnrollmentProfile(ctx context.Context, cmdUUID string, deviceID string) error {
-	ctx, cancelFn := context.WithDeadline(ctx, time.Now().Add(5*time.Second))
-	ticker := time.NewTicker(300 * time.Millisecond)
-	defer func() {
-		ticker.Stop()
-		cancelFn()
-	}()
+type listMDMAppleHostCommandsResponse struct {
+	Meta     *fleet.PaginationMetadata    `json:"meta"`
+	Commands []*fleet.MDMAppleHostCommand `json:"commands"`
+	Err      error                        `json:"error,omitempty"`
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			// time out after 5 seconds
-			return fleet.MDMAppleCommandTimeoutError{}
-		case <-ticker.C:
-			nanoEnroll, err := svc.ds.GetNanoMDMEnrollment(ctx, deviceID)
-			if err != nil {
-				level.Error(svc.logger).Log("err", "get nanomdm enrollment status", "details", err, "id", deviceID, "command_uuid", cmdUUID)
-				return err
-			}
-			if nanoEnroll != nil && nanoEnroll.Enabled {
-				// check again on the next tick
-				continue
-			}
-			// success, mdm enrollment is no longer enabled for the device
-			level.Info(svc.logger).Log("msg", "mdm disabled for device", "id", deviceID, "command_uuid", cmdUUID)
-			return nil
+func (r listMDMAppleHostCommandsResponse) error() error { return r.Err }
+
+func listMDMAppleHostCommandsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listMDMAppleHostCommandsRequest)
+	cmds, meta, err := svc.ListMDMAppleHostCommands(ctx, req.HostID, req.ListOptions)
+	if err != nil {
+		return listMDMAppleHostCommandsResponse{Err: err}, nil
+	}
+	return listMDMAppleHostCommandsResponse{Meta: meta, Commands: cmds}, nil
+}
+
+func (svc *Service) ListMDMAppleHostCommands(ctx context.Context, hostID uint, opt fleet.ListOptions) ([]*fleet.MDMAppleHostCommand, *fleet.PaginationMetadata, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, nil, err
+	}
+
+	h, err := svc.ds.HostLite(ctx, hostID)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "getting host to list commands history")
+	}
+	if err := svc.authz.Authorize(ctx, fleet.MDMAppleCommandAuthz{
+		TeamID: h.TeamID,
+	}, fleet.ActionRead); err != nil {
+		return nil, nil, err
+	}
+
+	cmds, meta, err := svc.ds.ListMDMAppleHostCommands(ctx, h.UUID, opt)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list host commands history")
+	}
+	return cmds, meta, nil
+}
+
+type listMDMWebhookDeadLettersRequest struct {
+	ListOptions fleet.ListOptions `url:"list_options"`
+}
+
+type listMDMWebhookDeadLettersResponse struct {
+	DeadLetters []*fleet.MDMWebhookDeadLetter `json:"dead_letters"`
+	Err         error                         `json:"error,omitempty"`
+}
+
+func (r listMDMWebhookDeadLettersResponse) error() error { return r.Err }
+
+func listMDMWebhookDeadLettersEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listMDMWebhookDeadLettersRequest)
+	letters, err := svc.ListMDMWebhookDeadLetters(ctx, req.ListOptions)
+	if err != nil {
+		return listMDMWebhookDeadLettersResponse{Err: err}, nil
+	}
+	if letters == nil {
+		letters = []*fleet.MDMWebhookDeadLetter{}
+	}
+	return listMDMWebhookDeadLettersResponse{DeadLetters: letters}, nil
+}
+
+func (svc *Service) ListMDMWebhookDeadLetters(ctx context.Context, opt fleet.ListOptions) ([]*fleet.MDMWebhookDeadLetter, error) {
+	// the dead letters are tied to the MDM events webhook, which only global
+	// admins can configure.
+	if err := svc.authz.Authorize(ctx, &fleet.AppConfig{}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	// the undeliverable events are the webhook jobs that exhausted their
+	// retries.
+	jobs, err := svc.ds.ListJobs(ctx, worker.MDMWebhookName, fleet.JobStateFailure, opt)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list failed MDM webhook jobs")
+	}
+	letters := make([]*fleet.MDMWebhookDeadLetter, 0, len(jobs))
+	for _, job := range jobs {
+		letter, err := worker.MDMWebhookDeadLetterFromJob(job)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "decode MDM webhook job")
 		}
+		letters = append(letters, letter)
 	}
+	return letters, nil
 }
 
-type mdmAppleGetInstallerRequest struct {
-	Token string `query:"token"`
+type listMDMHostChangesRequest struct {
+	Since   string `query:"since,optional"`
+	PerPage uint   `query:"per_page,optional"`
 }
 
-func (r mdmAppleGetInstallerResponse) error() error { return r.Err }
+type listMDMHostChangesResponse struct {
+	Changes []*fleet.HostMDMChange `json:"changes"`
+	// NextCursor is the cursor to pass as since to get the following changes.
+	NextCursor string `json:"next_cursor"`
+	HasMore    bool   `json:"has_more"`
+	Err        error  `json:"error,omitempty"`
+}
 
-type mdmAppleGetInstallerResponse struct {
-	Err error `json:"error,omitempty"`
+func (r listMDMHostChangesResponse) error() error { return r.Err }
 
-	// head is used by hijackRender for the response.
-	head bool
-	// Name field is used in hijackRender for the response.
-	name string
-	// Size field is used in hijackRender for the response.
-	size int64
-	// Installer field is used in hijackRender for the response.
-	installer []byte
+func listMDMHostChangesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listMDMHostChangesRequest)
+	changes, hasMore, err := svc.ListMDMHostChanges(ctx, req.Since, req.PerPage)
+	if err != nil {
+		return listMDMHostChangesResponse{Err: err}, nil
+	}
+
+	// without new changes, the cursor stays the same.
+	next := req.Since
+	if len(changes) > 0 {
+		next = strconv.FormatUint(changes[len(changes)-1].ID, 10)
+	} else {
+		changes = []*fleet.HostMDMChange{}
+	}
+	return listMDMHostChangesResponse{Changes: changes, NextCursor: next, HasMore: hasMore}, nil
 }
 
-func (r mdmAppleGetInstallerResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
-	w.Header().Set("Content-Length", strconv.FormatInt(r.size, 10))
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment;filename="%s"`, r.name))
+const (
+	defaultMDMHostChangesPerPage = 1000
+	maxMDMHostChangesPerPage     = 5000
+)
 
-	if r.head {
-		w.WriteHeader(http.StatusOK)
-		return
+func (svc *Service) ListMDMHostChanges(ctx context.Context, since string, perPage uint) ([]*fleet.HostMDMChange, bool, error) {
+	// the changes span all teams, so they are restricted to global admins.
+	if err := svc.authz.Authorize(ctx, &fleet.AppleMDM{}, fleet.ActionRead); err != nil {
+		return nil, false, ctxerr.Wrap(ctx, err)
 	}
 
-	// OK to just log the error here as writing anything on
-	// `http.ResponseWriter` sets the status code to 200 (and it can't be
-	// changed.) Clients should rely on matching content-length with the
-	// header provided
-	if n, err := w.Write(r.installer); err != nil {
-		logging.WithExtras(ctx, "err", err, "bytes_copied", n)
+	var cursor uint64
+	if since != "" {
+		c, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			return nil, false, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("since", "invalid cursor"))
+		}
+		cursor = c
+	}
+	switch {
+	case perPage == 0:
+		perPage = defaultMDMHostChangesPerPage
+	case perPage > maxMDMHostChangesPerPage:
+		return nil, false, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("per_page", fmt.Sprintf("must be at most %d", maxMDMHostChangesPerPage)))
+	}
+
+	// get one more change to know if there are more.
+	changes, err := svc.ds.ListHostMDMChanges(ctx, cursor, int(perPage)+1)
+	if err != nil {
+		return nil, false, ctxerr.Wrap(ctx, err, "list host MDM changes")
+	}
+	hasMore := len(changes) > int(perPage)
+	if hasMore {
+		changes = changes[:perPage]
 	}
+	return changes, hasMore, nil
 }
 
-func mdmAppleGetInstallerEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*mdmAppleGetInstallerRequest)
-	installer, err := svc.GetMDMAppleInstallerByToken(ctx, req.Token)
+type listMDMAppleNanoEnrollmentsRequest struct {
+	ListOptions  fleet.ListOptions `url:"list_options"`
+	OrphanedOnly bool              `query:"orphaned,optional"`
+}
+
+type listMDMAppleNanoEnrollmentsResponse struct {
+	Meta        *fleet.PaginationMetadata       `json:"meta"`
+	Enrollments []*fleet.MDMAppleNanoEnrollment `json:"enrollments"`
+	Err         error                           `json:"error,omitempty"`
+}
+
+func (r listMDMAppleNanoEnrollmentsResponse) error() error { return r.Err }
+
+func listMDMAppleNanoEnrollmentsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listMDMAppleNanoEnrollmentsRequest)
+	enrollments, meta, err := svc.ListMDMAppleNanoEnrollments(ctx, fleet.MDMAppleNanoEnrollmentListOptions{
+		ListOptions:  req.ListOptions,
+		OrphanedOnly: req.OrphanedOnly,
+	})
 	if err != nil {
-		return mdmAppleGetInstallerResponse{Err: err}, nil
+		return listMDMAppleNanoEnrollmentsResponse{Err: err}, nil
 	}
-	return mdmAppleGetInstallerResponse{
-		head:      false,
-		name:      installer.Name,
-		size:      installer.Size,
-		installer: installer.Installer,
-	}, nil
+	return listMDMAppleNanoEnrollmentsResponse{Meta: meta, Enrollments: enrollments}, nil
 }
 
-func (svc *Service) GetMDMAppleInstallerByToken(ctx context.Context, token string) (*fleet.MDMAppleInstaller, error) {
-	// skipauth: The installer endpoint uses token authentication.
-	svc.authz.SkipAuthorization(ctx)
+func (svc *Service) ListMDMAppleNanoEnrollments(ctx context.Context, opt fleet.MDMAppleNanoEnrollmentListOptions) ([]*fleet.MDMAppleNanoEnrollment, *fleet.PaginationMetadata, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleDevice{}, fleet.ActionRead); err != nil {
+		return nil, nil, err
+	}
 
-	installer, err := svc.ds.MDMAppleInstaller(ctx, token)
+	enrollments, meta, err := svc.ds.ListMDMAppleNanoEnrollments(ctx, opt)
 	if err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
+		return nil, nil, ctxerr.Wrap(ctx, err, "list nano enrollments")
 	}
-	return installer, nil
+	return enrollments, meta, nil
 }
 
-type mdmAppleHeadInstallerRequest struct {
-	Token string `query:"token"`
+type deleteMDMAppleNanoEnrollmentRequest struct {
+	ID string `url:"id"`
+}
+
+type deleteMDMAppleNanoEnrollmentResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r deleteMDMAppleNanoEnrollmentResponse) error() error { return r.Err }
+
+func (r deleteMDMAppleNanoEnrollmentResponse) Status() int { return http.StatusNoContent }
+
+func deleteMDMAppleNanoEnrollmentEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*deleteMDMAppleNanoEnrollmentRequest)
+	if err := svc.DeleteMDMAppleNanoEnrollment(ctx, req.ID); err != nil {
+		return deleteMDMAppleNanoEnrollmentResponse{Err: err}, nil
+	}
+	return deleteMDMAppleNanoEnrollmentResponse{}, nil
+}
+
+func (svc *Service) DeleteMDMAppleNanoEnrollment(ctx context.Context, id string) error {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleDevice{}, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	enrollment, err := svc.ds.GetMDMAppleNanoEnrollment(ctx, id)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get nano enrollment")
+	}
+	// enrollments of Fleet hosts are removed by turning MDM off for the host.
+	if enrollment.HostID != nil {
+		return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("id", fmt.Sprintf("enrollment is linked to host %d", *enrollment.HostID)))
+	}
+
+	if err := svc.ds.DeleteMDMAppleNanoEnrollment(ctx, id); err != nil {
+		return ctxerr.Wrap(ctx, err, "delete nano enrollment")
+	}
+	return nil
+}
+
+type linkMDMAppleNanoEnrollmentRequest struct {
+	ID     string `url:"id"`
+	HostID uint   `json:"host_id"`
+}
+
+type linkMDMAppleNanoEnrollmentResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r linkMDMAppleNanoEnrollmentResponse) error() error { return r.Err }
+
+func (r linkMDMAppleNanoEnrollmentResponse) Status() int { return http.StatusNoContent }
+
+func linkMDMAppleNanoEnrollmentEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*linkMDMAppleNanoEnrollmentRequest)
+	if err := svc.LinkMDMAppleNanoEnrollmentToHost(ctx, req.ID, req.HostID); err != nil {
+		return linkMDMAppleNanoEnrollmentResponse{Err: err}, nil
+	}
+	return linkMDMAppleNanoEnrollmentResponse{}, nil
+}
+
+func (svc *Service) LinkMDMAppleNanoEnrollmentToHost(ctx context.Context, id string, hostID uint) error {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleDevice{}, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	enrollment, err := svc.ds.GetMDMAppleNanoEnrollment(ctx, id)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get nano enrollment")
+	}
+	if enrollment.HostID != nil {
+		return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("id", fmt.Sprintf("enrollment is already linked to host %d", *enrollment.HostID)))
+	}
+
+	h, err := svc.ds.HostLite(ctx, hostID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get host to link")
+	}
+	if h.Platform != "darwin" {
+		return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("host_id", "host is not a macOS host"))
+	}
+	// a host matches an enrollment by its serial number when the device
+	// reported one, this prevents linking the enrollment to the wrong host.
+	if enrollment.SerialNumber != nil && *enrollment.SerialNumber != "" && h.HardwareSerial != *enrollment.SerialNumber {
+		return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("host_id", "host serial number does not match the enrolled device's serial number"))
+	}
+
+	if err := svc.ds.LinkMDMAppleNanoEnrollmentToHost(ctx, id, hostID); err != nil {
+		return ctxerr.Wrap(ctx, err, "link nano enrollment to host")
+	}
+	return nil
+}
+
+type listMDMAppleSCEPCertificatesRequest struct {
+	ListOptions       fleet.ListOptions `url:"list_options"`
+	HostID            *uint             `query:"host_id,optional"`
+	ExpiresWithinDays *uint             `query:"expires_within_days,optional"`
+	ExcludeRenewed    bool              `query:"exclude_renewed,optional"`
+}
+
+type listMDMAppleSCEPCertificatesResponse struct {
+	Meta         *fleet.PaginationMetadata        `json:"meta"`
+	Certificates []*fleet.MDMAppleSCEPCertificate `json:"certificates"`
+	Err          error                            `json:"error,omitempty"`
+}
+
+func (r listMDMAppleSCEPCertificatesResponse) error() error { return r.Err }
+
+func listMDMAppleSCEPCertificatesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listMDMAppleSCEPCertificatesRequest)
+	certs, meta, err := svc.ListMDMAppleSCEPCertificates(ctx, fleet.MDMAppleSCEPCertificateListOptions{
+		ListOptions:       req.ListOptions,
+		HostID:            req.HostID,
+		ExpiresWithinDays: req.ExpiresWithinDays,
+		ExcludeRenewed:    req.ExcludeRenewed,
+	})
+	if err != nil {
+		return listMDMAppleSCEPCertificatesResponse{Err: err}, nil
+	}
+	return listMDMAppleSCEPCertificatesResponse{Meta: meta, Certificates: certs}, nil
+}
+
+func (svc *Service) ListMDMAppleSCEPCertificates(ctx context.Context, opt fleet.MDMAppleSCEPCertificateListOptions) ([]*fleet.MDMAppleSCEPCertificate, *fleet.PaginationMetadata, error) {
+	// the certificates are listed like the hosts that use them
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, nil, err
+	}
+
+	vc, ok := viewer.FromContext(ctx)
+	if !ok {
+		return nil, nil, fleet.ErrNoContext
+	}
+
+	if opt.HostID != nil {
+		host, err := svc.ds.HostLite(ctx, *opt.HostID)
+		if err != nil {
+			return nil, nil, ctxerr.Wrap(ctx, err, "get host")
+		}
+		if err := svc.authz.Authorize(ctx, host, fleet.ActionRead); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	certs, meta, err := svc.ds.ListMDMAppleSCEPCertificates(ctx, fleet.TeamFilter{
+		User:            vc.User,
+		IncludeObserver: true,
+	}, opt)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list scep certificates")
+	}
+	return certs, meta, nil
+}
+
+type uploadAppleInstallerRequest struct {
+	Installer *multipart.FileHeader
+}
+
+type uploadAppleInstallerResponse struct {
+	ID  uint  `json:"installer_id"`
+	Err error `json:"error,omitempty"`
+}
+
+// TODO(lucas): We parse the whole body before running svc.authz.Authorize.
+// An authenticated but unauthorized user could abuse this.
+func (uploadAppleInstallerRequest) DecodeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	err := r.ParseMultipartForm(512 * units.MiB)
+	if err != nil {
+		return nil, &fleet.BadRequestError{
+			Message:     "failed to parse multipart form",
+			InternalErr: err,
+		}
+	}
+	installer := r.MultipartForm.File["installer"][0]
+	return &uploadAppleInstallerRequest{
+		Installer: installer,
+	}, nil
+}
+
+func (r uploadAppleInstallerResponse) error() error { return r.Err }
+
+func uploadAppleInstallerEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*uploadAppleInstallerRequest)
+	ff, err := req.Installer.Open()
+	if err != nil {
+		return uploadAppleInstallerResponse{Err: err}, nil
+	}
+	defer ff.Close()
+	installer, err := svc.UploadMDMAppleInstaller(ctx, req.Installer.Filename, req.Installer.Size, ff)
+	if err != nil {
+		return uploadAppleInstallerResponse{Err: err}, nil
+	}
+	return &uploadAppleInstallerResponse{
+		ID: installer.ID,
+	}, nil
+}
+
+func (svc *Service) UploadMDMAppleInstaller(ctx context.Context, name string, size int64, installer io.Reader) (*fleet.MDMAppleInstaller, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleInstaller{}, fleet.ActionWrite); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	appConfig, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	token := uuid.New().String()
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	url := svc.installerURL(token, appConfig)
+
+	var installerBuf bytes.Buffer
+	manifest, err := createManifest(size, io.TeeReader(installer, &installerBuf), url)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	inst, err := svc.ds.NewMDMAppleInstaller(ctx, name, size, manifest, installerBuf.Bytes(), token)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	return inst, nil
+}
+
+func (svc *Service) installerURL(token string, appConfig *fleet.AppConfig) string {
+	return fmt.Sprintf("%s%s?token=%s", appConfig.ServerSettings.ServerURL, apple_mdm.InstallerPath, token)
+}
+
+func createManifest(size int64, installer io.Reader, url string) (string, error) {
+	manifest, err := appmanifest.New(&readerWithSize{
+		Reader: installer,
+		size:   size,
+	}, url)
+	if err != nil {
+		return "", fmt.Errorf("create manifest file: %w", err)
+	}
+	var buf bytes.Buffer
+	enc := plist.NewEncoder(&buf)
+	enc.Indent("  ")
+	if err := enc.Encode(manifest); err != nil {
+		return "", fmt.Errorf("encode manifest: %w", err)
+	}
+	return buf.String(), nil
+}
+
+type readerWithSize struct {
+	io.Reader
+	size int64
+}
+
+func (r *readerWithSize) Size() int64 {
+	return r.size
+}
+
+type getAppleInstallerDetailsRequest struct {
+	ID uint `url:"installer_id"`
+}
+
+type getAppleInstallerDetailsResponse struct {
+	Installer *fleet.MDMAppleInstaller
+	Err       error `json:"error,omitempty"`
+}
+
+func (r getAppleInstallerDetailsResponse) error() error { return r.Err }
+
+func getAppleInstallerEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getAppleInstallerDetailsRequest)
+	installer, err := svc.GetMDMAppleInstallerByID(ctx, req.ID)
+	if err != nil {
+		return getAppleInstallerDetailsResponse{Err: err}, nil
+	}
+	return &getAppleInstallerDetailsResponse{
+		Installer: installer,
+	}, nil
+}
+
+func (svc *Service) GetMDMAppleInstallerByID(ctx context.Context, id uint) (*fleet.MDMAppleInstaller, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleInstaller{}, fleet.ActionWrite); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	inst, err := svc.ds.MDMAppleInstallerDetailsByID(ctx, id)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	return inst, nil
+}
+
+type deleteAppleInstallerDetailsRequest struct {
+	ID uint `url:"installer_id"`
+}
+
+type deleteAppleInstallerDetailsResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r deleteAppleInstallerDetailsResponse) error() error { return r.Err }
+
+func deleteAppleInstallerEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*deleteAppleInstallerDetailsRequest)
+	if err := svc.DeleteMDMAppleInstaller(ctx, req.ID); err != nil {
+		return deleteAppleInstallerDetailsResponse{Err: err}, nil
+	}
+	return &deleteAppleInstallerDetailsResponse{}, nil
+}
+
+func (svc *Service) DeleteMDMAppleInstaller(ctx context.Context, id uint) error {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleInstaller{}, fleet.ActionWrite); err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+
+	if err := svc.ds.DeleteMDMAppleInstaller(ctx, id); err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+	return nil
+}
+
+type listMDMAppleDevicesRequest struct{}
+
+type listMDMAppleDevicesResponse struct {
+	Devices []fleet.MDMAppleDevice `json:"devices"`
+	Err     error                  `json:"error,omitempty"`
+}
+
+func (r listMDMAppleDevicesResponse) error() error { return r.Err }
+
+func listMDMAppleDevicesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	devices, err := svc.ListMDMAppleDevices(ctx)
+	if err != nil {
+		return listMDMAppleDevicesResponse{Err: err}, nil
+	}
+	return &listMDMAppleDevicesResponse{
+		Devices: devices,
+	}, nil
+}
+
+func (svc *Service) ListMDMAppleDevices(ctx context.Context) ([]fleet.MDMAppleDevice, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleDevice{}, fleet.ActionWrite); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	return svc.ds.MDMAppleListDevices(ctx)
+}
+
+type listMDMAppleDEPDevicesRequest struct{}
+
+type listMDMAppleDEPDevicesResponse struct {
+	Devices []fleet.MDMAppleDEPDevice `json:"devices"`
+	Err     error                     `json:"error,omitempty"`
+}
+
+func (r listMDMAppleDEPDevicesResponse) error() error { return r.Err }
+
+func listMDMAppleDEPDevicesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	devices, err := svc.ListMDMAppleDEPDevices(ctx)
+	if err != nil {
+		return listMDMAppleDEPDevicesResponse{Err: err}, nil
+	}
+	return &listMDMAppleDEPDevicesResponse{
+		Devices: devices,
+	}, nil
+}
+
+func (svc *Service) ListMDMAppleDEPDevices(ctx context.Context) ([]fleet.MDMAppleDEPDevice, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleDEPDevice{}, fleet.ActionWrite); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	depClient := apple_mdm.NewDEPClient(svc.depStorage, svc.ds, svc.logger)
+
+	// TODO(lucas): Use cursors and limit to fetch in multiple requests.
+	// This single-request version supports up to 1000 devices (max to return in one call).
+	fetchDevicesResponse, err := depClient.FetchDevices(ctx, apple_mdm.DEPName, godep.WithLimit(1000))
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	devices := make([]fleet.MDMAppleDEPDevice, len(fetchDevicesResponse.Devices))
+	for i := range fetchDevicesResponse.Devices {
+		devices[i] = fleet.MDMAppleDEPDevice{Device: fetchDevicesResponse.Devices[i]}
+	}
+	return devices, nil
+}
+
+type listMDMAppleDEPHostsReportRequest struct {
+	TeamID      *uint             `query:"team_id,optional"`
+	ListOptions fleet.ListOptions `url:"list_options"`
+}
+
+type listMDMAppleDEPHostsReportResponse struct {
+	Hosts []*fleet.MDMAppleDEPHostReportItem `json:"hosts"`
+	Err   error                              `json:"error,omitempty"`
+}
+
+func (r listMDMAppleDEPHostsReportResponse) error() error { return r.Err }
+
+func listMDMAppleDEPHostsReportEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listMDMAppleDEPHostsReportRequest)
+	hosts, err := svc.ListMDMAppleDEPHostsReport(ctx, req.TeamID, req.ListOptions)
+	if err != nil {
+		return listMDMAppleDEPHostsReportResponse{Err: err}, nil
+	}
+	if hosts == nil {
+		hosts = []*fleet.MDMAppleDEPHostReportItem{}
+	}
+	return listMDMAppleDEPHostsReportResponse{Hosts: hosts}, nil
+}
+
+func (svc *Service) ListMDMAppleDEPHostsReport(ctx context.Context, teamID *uint, opts fleet.ListOptions) ([]*fleet.MDMAppleDEPHostReportItem, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, err
+	}
+
+	vc, ok := viewer.FromContext(ctx)
+	if !ok {
+		return nil, fleet.ErrNoContext
+	}
+	filter := fleet.TeamFilter{User: vc.User, IncludeObserver: true, TeamID: teamID}
+
+	hosts, err := svc.ds.ListMDMAppleDEPHostsReport(ctx, filter, opts)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list dep hosts report")
+	}
+	return hosts, nil
+}
+
+type listMDMAppleDEPAssignmentDiscrepanciesRequest struct {
+	TeamID *uint `query:"team_id,optional"`
+}
+
+type listMDMAppleDEPAssignmentDiscrepanciesResponse struct {
+	DefaultDeviceFamilies []string                                  `json:"default_device_families"`
+	Discrepancies         []*fleet.MDMAppleDEPAssignmentDiscrepancy `json:"discrepancies"`
+	Err                   error                                     `json:"error,omitempty"`
+}
+
+func (r listMDMAppleDEPAssignmentDiscrepanciesResponse) error() error { return r.Err }
+
+func listMDMAppleDEPAssignmentDiscrepanciesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listMDMAppleDEPAssignmentDiscrepanciesRequest)
+	families, items, err := svc.ListMDMAppleDEPAssignmentDiscrepancies(ctx, req.TeamID)
+	if err != nil {
+		return listMDMAppleDEPAssignmentDiscrepanciesResponse{Err: err}, nil
+	}
+	if families == nil {
+		families = []string{}
+	}
+	if items == nil {
+		items = []*fleet.MDMAppleDEPAssignmentDiscrepancy{}
+	}
+	return listMDMAppleDEPAssignmentDiscrepanciesResponse{DefaultDeviceFamilies: families, Discrepancies: items}, nil
+}
+
+func (svc *Service) ListMDMAppleDEPAssignmentDiscrepancies(ctx context.Context, teamID *uint) ([]string, []*fleet.MDMAppleDEPAssignmentDiscrepancy, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, nil, err
+	}
+
+	vc, ok := viewer.FromContext(ctx)
+	if !ok {
+		return nil, nil, fleet.ErrNoContext
+	}
+	filter := fleet.TeamFilter{User: vc.User, IncludeObserver: true, TeamID: teamID}
+
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "get app config")
+	}
+	families := appCfg.MDM.AppleBMDefaultDeviceFamilies
+
+	items, err := svc.ds.ListMDMAppleDEPAssignmentDiscrepancies(ctx, filter, families)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list dep assignment discrepancies")
+	}
+	return families, items, nil
+}
+
+type releaseMDMAppleDEPDeviceRequest struct {
+	Serial string `url:"serial"`
+}
+
+type releaseMDMAppleDEPDeviceResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r releaseMDMAppleDEPDeviceResponse) error() error { return r.Err }
+
+func (r releaseMDMAppleDEPDeviceResponse) Status() int { return http.StatusNoContent }
+
+func releaseMDMAppleDEPDeviceEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*releaseMDMAppleDEPDeviceRequest)
+	if err := svc.ReleaseMDMAppleDEPDevice(ctx, req.Serial); err != nil {
+		return releaseMDMAppleDEPDeviceResponse{Err: err}, nil
+	}
+	return releaseMDMAppleDEPDeviceResponse{}, nil
+}
+
+func (svc *Service) ReleaseMDMAppleDEPDevice(ctx context.Context, serial string) error {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleDEPDevice{}, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	statuses, err := apple_mdm.DisownDEPDevices(ctx, svc.depStorage, svc.logger, serial)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "release dep device")
+	}
+	if status := statuses[serial]; status != apple_mdm.DEPDisownStatusSuccess {
+		// the device is not assigned to Fleet's MDM server (or Apple failed to
+		// release it), report the status returned by Apple.
+		return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("serial", fmt.Sprintf("Apple Business Manager could not release the device: %s", status)))
+	}
+
+	hostID, deleted, err := svc.ds.ReleaseMDMAppleDEPHost(ctx, serial)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "update host of released dep device")
+	}
+
+	act := &fleet.ActivityTypeReleasedMDMAppleDEPDevice{
+		HostSerial:         serial,
+		DeletedPendingHost: deleted,
+	}
+	if hostID != 0 {
+		act.HostID = &hostID
+	}
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), act); err != nil {
+		return ctxerr.Wrap(ctx, err, "create activity for released dep device")
+	}
+	return nil
+}
+
+type uploadMDMAppleDEPTeamAssignmentsRequest struct {
+	CSV *multipart.FileHeader
+}
+
+func (uploadMDMAppleDEPTeamAssignmentsRequest) DecodeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	decoded := uploadMDMAppleDEPTeamAssignmentsRequest{}
+
+	err := r.ParseMultipartForm(512 * units.MiB)
+	if err != nil {
+		return nil, &fleet.BadRequestError{
+			Message:     "failed to parse multipart form",
+			InternalErr: err,
+		}
+	}
+
+	fhs, ok := r.MultipartForm.File["csv"]
+	if !ok || len(fhs) < 1 {
+		return nil, &fleet.BadRequestError{Message: "no file headers for csv"}
+	}
+	decoded.CSV = fhs[0]
+
+	return &decoded, nil
+}
+
+type uploadMDMAppleDEPTeamAssignmentsResponse struct {
+	*fleet.MDMAppleDEPTeamAssignmentsResult
+	Err error `json:"error,omitempty"`
+}
+
+func (r uploadMDMAppleDEPTeamAssignmentsResponse) error() error { return r.Err }
+
+func uploadMDMAppleDEPTeamAssignmentsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*uploadMDMAppleDEPTeamAssignmentsRequest)
+
+	ff, err := req.CSV.Open()
+	if err != nil {
+		return uploadMDMAppleDEPTeamAssignmentsResponse{Err: err}, nil
+	}
+	defer ff.Close()
+
+	res, err := svc.UploadMDMAppleDEPTeamAssignments(ctx, ff)
+	if err != nil {
+		return uploadMDMAppleDEPTeamAssignmentsResponse{Err: err}, nil
+	}
+	return uploadMDMAppleDEPTeamAssignmentsResponse{MDMAppleDEPTeamAssignmentsResult: res}, nil
+}
+
+func (svc *Service) UploadMDMAppleDEPTeamAssignments(ctx context.Context, r io.Reader) (*fleet.MDMAppleDEPTeamAssignmentsResult, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleDEPDevice{}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	assignments, err := svc.parseMDMAppleDEPTeamAssignments(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if len(assignments) == 0 {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("csv", "no serial numbers found in the file"))
+	}
+
+	var res fleet.MDMAppleDEPTeamAssignmentsResult
+	for _, a := range assignments {
+		if a.TeamID == nil {
+			res.Removed++
+		} else {
+			res.Assigned++
+		}
+	}
+
+	if err := svc.ds.SetMDMAppleDEPTeamAssignments(ctx, assignments); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "set dep team assignments")
+	}
+
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), &fleet.ActivityTypeUploadedMDMAppleDEPTeamAssignments{
+		AssignedCount: res.Assigned,
+		RemovedCount:  res.Removed,
+	}); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "create activity for dep team assignments")
+	}
+	return &res, nil
+}
+
+// parseMDMAppleDEPTeamAssignments parses the CSV file of team assignments,
+// with one "serial_number,team" record per line. An optional header line is
+// skipped, and an empty team removes the assignment of that serial number.
+func (svc *Service) parseMDMAppleDEPTeamAssignments(ctx context.Context, r io.Reader) ([]*fleet.MDMAppleDEPTeamAssignment, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	teamsByName := make(map[string]*fleet.Team)
+	seen := make(map[string]bool)
+	var assignments []*fleet.MDMAppleDEPTeamAssignment
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("csv", fmt.Sprintf("failed to parse file: %s", err.Error())))
+		}
+		if len(rec) == 1 && strings.TrimSpace(rec[0]) == "" {
+			// skip blank lines
+			continue
+		}
+		if len(rec) > 2 {
+			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("csv", fmt.Sprintf("line %d: expected serial number and team, got %d fields", line, len(rec))))
+		}
+
+		serial := strings.TrimSpace(rec[0])
+		var teamName string
+		if len(rec) == 2 {
+			teamName = strings.TrimSpace(rec[1])
+		}
+		if line == 1 && strings.EqualFold(serial, "serial_number") {
+			continue
+		}
+		if serial == "" {
+			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("csv", fmt.Sprintf("line %d: serial number is required", line)))
+		}
+		if seen[serial] {
+			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("csv", fmt.Sprintf("line %d: duplicate serial number %s", line, serial)))
+		}
+		seen[serial] = true
+
+		a := &fleet.MDMAppleDEPTeamAssignment{SerialNumber: serial}
+		if teamName != "" {
+			tm, ok := teamsByName[teamName]
+			if !ok {
+				tm, err = svc.ds.TeamByName(ctx, teamName)
+				if err != nil {
+					if fleet.IsNotFound(err) {
+						return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("csv", fmt.Sprintf("line %d: team %s does not exist", line, teamName)))
+					}
+					return nil, ctxerr.Wrap(ctx, err, "get team by name")
+				}
+				teamsByName[teamName] = tm
+			}
+			a.TeamID = &tm.ID
+			a.TeamName = tm.Name
+		}
+		assignments = append(assignments, a)
+	}
+	return assignments, nil
+}
+
+type listMDMAppleDEPTeamAssignmentsResponse struct {
+	TeamAssignments []*fleet.MDMAppleDEPTeamAssignment `json:"team_assignments"`
+	Err             error                              `json:"error,omitempty"`
+}
+
+func (r listMDMAppleDEPTeamAssignmentsResponse) error() error { return r.Err }
+
+func listMDMAppleDEPTeamAssignmentsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	assignments, err := svc.ListMDMAppleDEPTeamAssignments(ctx)
+	if err != nil {
+		return listMDMAppleDEPTeamAssignmentsResponse{Err: err}, nil
+	}
+	return listMDMAppleDEPTeamAssignmentsResponse{TeamAssignments: assignments}, nil
+}
+
+func (svc *Service) ListMDMAppleDEPTeamAssignments(ctx context.Context) ([]*fleet.MDMAppleDEPTeamAssignment, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleDEPDevice{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	assignments, err := svc.ds.ListMDMAppleDEPTeamAssignments(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list dep team assignments")
+	}
+	return assignments, nil
+}
+
+type newMDMAppleDEPKeyPairResponse struct {
+	PublicKey  []byte `json:"public_key,omitempty"`
+	PrivateKey []byte `json:"private_key,omitempty"`
+	Err        error  `json:"error,omitempty"`
+}
+
+func (r newMDMAppleDEPKeyPairResponse) error() error { return r.Err }
+
+func newMDMAppleDEPKeyPairEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	keyPair, err := svc.NewMDMAppleDEPKeyPair(ctx)
+	if err != nil {
+		return newMDMAppleDEPKeyPairResponse{
+			Err: err,
+		}, nil
+	}
+
+	return newMDMAppleDEPKeyPairResponse{
+		PublicKey:  keyPair.PublicKey,
+		PrivateKey: keyPair.PrivateKey,
+	}, nil
+}
+
+func (svc *Service) NewMDMAppleDEPKeyPair(ctx context.Context) (*fleet.MDMAppleDEPKeyPair, error) {
+	// skipauth: Generating a new key pair does not actually make any changes to fleet, or expose any
+	// information. The user must configure fleet with the new key pair and restart the server.
+	svc.authz.SkipAuthorization(ctx)
+
+	publicKeyPEM, privateKeyPEM, err := apple_mdm.NewDEPKeyPairPEM()
+	if err != nil {
+		return nil, fmt.Errorf("generate key pair: %w", err)
+	}
+
+	return &fleet.MDMAppleDEPKeyPair{
+		PublicKey:  publicKeyPEM,
+		PrivateKey: privateKeyPEM,
+	}, nil
+}
+
+type enqueueMDMAppleCommandRequest = mdmclient.EnqueueCommandRequest
+
+type enqueueMDMAppleCommandResponse struct {
+	mdmclient.EnqueueCommandResponse
+	status int   `json:"-"`
+	Err    error `json:"error,omitempty"`
+}
+
+func (r enqueueMDMAppleCommandResponse) error() error { return r.Err }
+func (r enqueueMDMAppleCommandResponse) Status() int  { return r.status }
+
+func enqueueMDMAppleCommandEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*enqueueMDMAppleCommandRequest)
+	status, result, err := svc.EnqueueMDMAppleCommand(ctx, req.Command, req.DeviceIDs, req.TargetID, req.TeamIDs, req.LabelID, req.Priority, false)
+	if err != nil {
+		return enqueueMDMAppleCommandResponse{Err: err}, nil
+	}
+	return enqueueMDMAppleCommandResponse{
+		status:                 status,
+		EnqueueCommandResponse: mdmclient.EnqueueCommandResponse{CommandEnqueueResult: result},
+	}, nil
+}
+
+func (svc *Service) EnqueueMDMAppleCommand(
+	ctx context.Context,
+	rawBase64Cmd string,
+	deviceIDs []string,
+	targetID *uint,
+	targetTeamIDs []uint,
+	labelID *uint,
+	priority fleet.MDMAppleCommandPriority,
+	noPush bool,
+) (status int, result *fleet.CommandEnqueueResult, err error) {
+	premiumCommands := map[string]bool{
+		"EraseDevice": true,
+		"DeviceLock":  true,
+	}
+
+	// the command is decoded before authorization because its request type
+	// determines whether users with the GitOps role can run it. Decoding errors
+	// are only returned to authorized users.
+	rawXMLCmd, cmd, decodeErr := decodeMDMAppleCommand(ctx, rawBase64Cmd)
+	var gitOpsAllowed bool
+	if decodeErr == nil {
+		gitOpsAllowed = svc.config.MDM.GitOpsAllowedCommand(cmd.Command.RequestType)
+	}
+
+	// load hosts (lite) by uuids, check that the user has the rights to run
+	// commands for every affected team.
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		// GitOps users can't list hosts, but they may be allowed to run this
+		// command, which is checked per team below.
+		if !gitOpsAllowed {
+			return 0, nil, ctxerr.Wrap(ctx, err)
+		}
+	}
+
+	var targetsCount int
+	for _, set := range []bool{len(deviceIDs) > 0, targetID != nil, len(targetTeamIDs) > 0, labelID != nil} {
+		if set {
+			targetsCount++
+		}
+	}
+	if targetsCount > 1 {
+		return 0, nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("device_ids", "only one of device_ids, target_id, team_ids or label_id can be provided"))
+	}
+
+	vc, ok := viewer.FromContext(ctx)
+	if !ok {
+		return 0, nil, fleet.ErrNoContext
+	}
+	// for the team filter, we don't include observers as we require maintainer
+	// and up to run commands.
+	filter := fleet.TeamFilter{User: vc.User, IncludeObserver: false, IncludeGitOps: gitOpsAllowed}
+
+	// the hosts of a target, teams or label are expanded when the command is
+	// enqueued, so that it runs on the hosts that currently match them.
+	serverResolved := targetID != nil || len(targetTeamIDs) > 0 || labelID != nil
+	// the per-host results are only returned when the hosts are resolved from
+	// teams or a label, the caller doesn't know them.
+	perHostResults := len(targetTeamIDs) > 0 || labelID != nil
+	switch {
+	case targetID != nil:
+		if err := svc.authz.Authorize(ctx, &fleet.MDMHostTarget{}, fleet.ActionRead); err != nil {
+			return 0, nil, ctxerr.Wrap(ctx, err)
+		}
+		deviceIDs, err = svc.mdmHostTargetDeviceIDs(ctx, *targetID)
+		if err != nil {
+			return 0, nil, err
+		}
+	case perHostResults:
+		deviceIDs, err = svc.mdmAppleEnrolledDeviceIDs(ctx, filter, targetTeamIDs, labelID)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	hosts, err := svc.ds.ListHostsLiteByUUIDs(ctx, filter, deviceIDs)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(hosts) == 0 {
+		return 0, nil, newNotFoundError()
+	}
+	if serverResolved {
+		// a target can match hosts of teams the user can't see, the command is
+		// only enqueued for the hosts the user can see.
+		deviceIDs = make([]string, 0, len(hosts))
+		for _, h := range hosts {
+			deviceIDs = append(deviceIDs, h.UUID)
+		}
+	}
+
+	// collect the team IDs and verify that the user has access to run commands
+	// on all affected teams, including the requested teams.
+	teamIDs := make(map[uint]bool)
+	for _, id := range targetTeamIDs {
+		teamIDs[id] = true
+	}
+	for _, h := range hosts {
+		var id uint
+		if h.TeamID != nil {
+			id = *h.TeamID
+		}
+		teamIDs[id] = true
+	}
+
+	commandAuthz := fleet.MDMAppleCommandAuthz{GitOpsAllowed: gitOpsAllowed}
+	for tmID := range teamIDs {
+		commandAuthz.TeamID = &tmID
+		if tmID == 0 {
+			commandAuthz.TeamID = nil
+		}
+
+		if err := svc.authz.Authorize(ctx, commandAuthz, fleet.ActionWrite); err != nil {
+			return 0, nil, ctxerr.Wrap(ctx, err)
+		}
+	}
+
+	if decodeErr != nil {
+		return 0, nil, decodeErr
+	}
+
+	if premiumCommands[strings.TrimSpace(cmd.Command.RequestType)] {
+		lic, err := svc.License(ctx)
+		if err != nil {
+			return 0, nil, ctxerr.Wrap(ctx, err, "get license")
+		}
+		if !lic.IsPremium() {
+			return 0, nil, fleet.ErrMissingLicense
+		}
+	}
+
+	if !priority.IsValid() {
+		err := fleet.NewInvalidArgumentError("priority", fmt.Sprintf("invalid priority %q, must be one of urgent, normal or low", priority))
+		return 0, nil, ctxerr.Wrap(ctx, err, "validate priority")
+	}
+
+	pending, err := svc.ds.FilterMDMAppleHostUUIDsPendingApproval(ctx, deviceIDs)
+	if err != nil {
+		return 0, nil, ctxerr.Wrap(ctx, err, "check hosts pending approval")
+	}
+	if len(pending) > 0 {
+		err := fleet.NewInvalidArgumentError("device_ids", fmt.Sprintf("the enrollment of hosts %s must be approved before they can receive commands", strings.Join(pending, ", ")))
+		return 0, nil, ctxerr.Wrap(ctx, err, "hosts pending approval")
+	}
+
+	if err := svc.mdmAppleCommander.EnqueueCommandWithPriority(ctx, deviceIDs, string(rawXMLCmd), priority); err != nil {
+		// if at least one UUID enqueued properly, return success, otherwise return
+		// error
+		var apnsErr *apple_mdm.APNSDeliveryError
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &apnsErr) {
+			if len(apnsErr.FailedUUIDs) < len(deviceIDs) {
+				// some hosts properly received the command, so return success, with the list
+				// of failed uuids.
+				res := &fleet.CommandEnqueueResult{
+					CommandUUID: cmd.CommandUUID,
+					RequestType: cmd.Command.RequestType,
+					FailedUUIDs: apnsErr.FailedUUIDs,
+				}
+				if perHostResults {
+					res.Hosts = commandEnqueueHostResults(hosts, apnsErr.FailedUUIDs)
+				}
+				return http.StatusOK, res, nil
+			}
+			// push failed for all hosts
+			err := fleet.NewBadGatewayError("Apple push notificiation service", err)
+			return http.StatusBadGateway, nil, ctxerr.Wrap(ctx, err, "enqueue command")
+
+		} else if errors.As(err, &mysqlErr) {
+			// enqueue may fail with a foreign key constraint error 1452 when one of
+			// the hosts provided is not enrolled in nano_enrollments. Detect when
+			// that's the case and add information to the error.
+			if mysqlErr.Number == mysqlerr.ER_NO_REFERENCED_ROW_2 {
+				err := fleet.NewInvalidArgumentError(
+					"device_ids",
+					fmt.Sprintf("at least one of the hosts is not enrolled in MDM: %v", err),
+				).WithStatus(http.StatusConflict)
+				return http.StatusConflict, nil, ctxerr.Wrap(ctx, err, "enqueue command")
+			}
+		}
+
+		return http.StatusInternalServerError, nil, ctxerr.Wrap(ctx, err, "enqueue command")
+	}
+	res := &fleet.CommandEnqueueResult{
+		CommandUUID: cmd.CommandUUID,
+		RequestType: cmd.Command.RequestType,
+	}
+	if perHostResults {
+		res.Hosts = commandEnqueueHostResults(hosts, nil)
+	}
+	return http.StatusOK, res, nil
+}
+
+// mdmAppleEnrolledDeviceIDs returns the UUIDs of the macOS hosts enrolled in
+// Fleet's MDM that belong to the teams (0 being the hosts without a team) or
+// to the label, and that are visible with the filter.
+func (svc *Service) mdmAppleEnrolledDeviceIDs(ctx context.Context, filter fleet.TeamFilter, teamIDs []uint, labelID *uint) ([]string, error) {
+	opts := fleet.HostListOptions{
+		ListOptions:               fleet.ListOptions{PerPage: fleet.PerPageUnlimited},
+		MDMNameFilter:             ptr.String(fleet.WellKnownMDMFleet),
+		MDMEnrollmentStatusFilter: fleet.MDMEnrollStatusEnrolled,
+		DisableFailingPolicies:    true,
+	}
+
+	var hosts []*fleet.Host
+	if labelID != nil {
+		if _, err := svc.ds.Label(ctx, *labelID); err != nil {
+			if fleet.IsNotFound(err) {
+				return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("label_id", "label does not exist"))
+			}
+			return nil, ctxerr.Wrap(ctx, err, "get label")
+		}
+		labelHosts, err := svc.ds.ListHostsInLabel(ctx, filter, *labelID, opts)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "list hosts in label")
+		}
+		hosts = labelHosts
+	}
+	for _, tmID := range teamIDs {
+		opts.TeamFilter = ptr.Uint(tmID)
+		teamHosts, err := svc.ds.ListHosts(ctx, filter, opts)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "list team hosts")
+		}
+		hosts = append(hosts, teamHosts...)
+	}
+
+	seen := make(map[string]bool, len(hosts))
+	uuids := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		if h.Platform != "darwin" || seen[h.UUID] {
+			continue
+		}
+		seen[h.UUID] = true
+		uuids = append(uuids, h.UUID)
+	}
+	return uuids, nil
+}
+
+// commandEnqueueHostResults returns the per-host result of a command enqueued
+// for the hosts, failedUUIDs being the hosts whose push notification failed.
+func commandEnqueueHostResults(hosts []*fleet.Host, failedUUIDs []string) []*fleet.CommandEnqueueHostResult {
+	failed := make(map[string]bool, len(failedUUIDs))
+	for _, uuid := range failedUUIDs {
+		failed[uuid] = true
+	}
+	res := make([]*fleet.CommandEnqueueHostResult, 0, len(hosts))
+	for _, h := range hosts {
+		status := fleet.CommandEnqueueHostStatusEnqueued
+		if failed[h.UUID] {
+			status = fleet.CommandEnqueueHostStatusPushFailed
+		}
+		res = append(res, &fleet.CommandEnqueueHostResult{HostID: h.ID, HostUUID: h.UUID, Status: status})
+	}
+	return res
+}
+
+// decodeMDMAppleCommand decodes the base64-encoded plist of an MDM command,
+// it returns the raw plist and the parsed command.
+func decodeMDMAppleCommand(ctx context.Context, rawBase64Cmd string) ([]byte, *mdm.Command, error) {
+	rawXMLCmd, err := base64.RawStdEncoding.DecodeString(rawBase64Cmd)
+	if err != nil {
+		err = fleet.NewInvalidArgumentError("command", "unable to decode base64 command").WithStatus(http.StatusBadRequest)
+
+		return nil, nil, ctxerr.Wrap(ctx, err, "decode base64 command")
+	}
+	cmd, err := mdm.DecodeCommand(rawXMLCmd)
+	if err != nil {
+		err = fleet.NewInvalidArgumentError("command", "unable to decode plist command").WithStatus(http.StatusUnsupportedMediaType)
+		return nil, nil, ctxerr.Wrap(ctx, err, "decode plist command")
+	}
+	return rawXMLCmd, cmd, nil
+}
+
+type mdmAppleEnrollRequest struct {
+	Token               string `query:"token"`
+	EnrollmentReference string `query:"enrollment_reference,optional"`
+}
+
+func (r mdmAppleEnrollResponse) error() error { return r.Err }
+
+////////////////////////////////////////////////////////////////////////////////
+// Push notifications to wake up the hosts
+////////////////////////////////////////////////////////////////////////////////
+
+// mdmApplePushBatchSize is the maximum number of hosts sent a push
+// notification at once.
+const mdmApplePushBatchSize = 1000
+
+type pushMDMAppleHostsRequest struct {
+	TeamID  *uint `json:"team_id"`
+	Filters struct {
+		MatchQuery    string                    `json:"query"`
+		Status        fleet.HostStatus          `json:"status"`
+		LabelID       *uint                     `json:"label_id"`
+		MacOSSettings fleet.MacOSSettingsStatus `json:"macos_settings"`
+	} `json:"filters"`
+}
+
+type pushMDMAppleHostsResponse struct {
+	*fleet.MDMAppleHostsPushResult
+	Err error `json:"error,omitempty"`
+}
+
+func (r pushMDMAppleHostsResponse) error() error { return r.Err }
+
+func pushMDMAppleHostsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*pushMDMAppleHostsRequest)
+	opts := fleet.HostListOptions{
+		ListOptions:         fleet.ListOptions{MatchQuery: req.Filters.MatchQuery},
+		StatusFilter:        req.Filters.Status,
+		TeamFilter:          req.TeamID,
+		MacOSSettingsFilter: req.Filters.MacOSSettings,
+	}
+	res, err := svc.PushMDMAppleHosts(ctx, opts, req.Filters.LabelID)
+	if err != nil {
+		return pushMDMAppleHostsResponse{Err: err}, nil
+	}
+	return pushMDMAppleHostsResponse{MDMAppleHostsPushResult: res}, nil
+}
+
+func (svc *Service) PushMDMAppleHosts(ctx context.Context, opts fleet.HostListOptions, labelID *uint) (*fleet.MDMAppleHostsPushResult, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	if opts.MacOSSettingsFilter != "" && !opts.MacOSSettingsFilter.IsValid() {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("macos_settings", fmt.Sprintf("invalid macos_settings status %s", opts.MacOSSettingsFilter)))
+	}
+	if opts.StatusFilter != "" && labelID != nil {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("status", "may not be provided with label_id"))
+	}
+
+	vc, ok := viewer.FromContext(ctx)
+	if !ok {
+		return nil, fleet.ErrNoContext
+	}
+	// for the team filter, we don't include observers as we require maintainer
+	// and up to run commands.
+	filter := fleet.TeamFilter{User: vc.User, IncludeObserver: false}
+	opts.PerPage = fleet.PerPageUnlimited
+
+	var hosts []*fleet.Host
+	var err error
+	if labelID != nil {
+		hosts, err = svc.ds.ListHostsInLabel(ctx, filter, *labelID, opts)
+	} else {
+		hosts, err = svc.ds.ListHosts(ctx, filter, opts)
+	}
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list hosts")
+	}
+
+	// a push notification makes the host check in for its queued commands,
+	// the same rights as running commands are required.
+	teamIDs := make(map[uint]bool)
+	uuids := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		if h.Platform != "darwin" {
+			continue
+		}
+		var id uint
+		if h.TeamID != nil {
+			id = *h.TeamID
+		}
+		teamIDs[id] = true
+		uuids = append(uuids, h.UUID)
+	}
+	for tmID := range teamIDs {
+		var commandAuthz fleet.MDMAppleCommandAuthz
+		if tmID != 0 {
+			commandAuthz.TeamID = ptr.Uint(tmID)
+		}
+		if err := svc.authz.Authorize(ctx, commandAuthz, fleet.ActionWrite); err != nil {
+			return nil, ctxerr.Wrap(ctx, err)
+		}
+	}
+
+	res := &fleet.MDMAppleHostsPushResult{HostsCount: len(uuids)}
+	for len(uuids) > 0 {
+		batch := uuids
+		if len(batch) > mdmApplePushBatchSize {
+			batch = batch[:mdmApplePushBatchSize]
+		}
+		uuids = uuids[len(batch):]
+
+		pushed, err := svc.mdmPushService.Push(ctx, batch)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, fleet.NewBadGatewayError("Apple push notificiation service", err), "push hosts")
+		}
+		for _, uuid := range batch {
+			resp, ok := pushed[uuid]
+			switch {
+			case !ok || (resp.Err != nil && errors.Is(resp.Err, nanomdm_pushsvc.ErrIdNotFound)):
+				// the host is not enrolled in Fleet's MDM, no push is sent
+			case resp.Err != nil:
+				res.FailedUUIDs = append(res.FailedUUIDs, uuid)
+			default:
+				res.PushesSent++
+			}
+		}
+	}
+	return res, nil
+}
+
+type mdmAppleEnrollResponse struct {
+	Err error `json:"error,omitempty"`
+
+	// Profile field is used in hijackRender for the response.
+	Profile []byte
+}
+
+func (r mdmAppleEnrollResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
+	w.Header().Set("Content-Length", strconv.FormatInt(int64(len(r.Profile)), 10))
+	w.Header().Set("Content-Type", "application/x-apple-aspen-config")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", "attachment;fleet-enrollment-profile.mobileconfig")
+
+	// OK to just log the error here as writing anything on
+	// `http.ResponseWriter` sets the status code to 200 (and it can't be
+	// changed.) Clients should rely on matching content-length with the
+	// header provided.
+	if n, err := w.Write(r.Profile); err != nil {
+		logging.WithExtras(ctx, "err", err, "written", n)
+	}
+}
+
+func mdmAppleEnrollEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*mdmAppleEnrollRequest)
+
+	profile, err := svc.GetMDMAppleEnrollmentProfileByToken(ctx, req.Token, req.EnrollmentReference)
+	if err != nil {
+		return mdmAppleEnrollResponse{Err: err}, nil
+	}
+	return mdmAppleEnrollResponse{
+		Profile: profile,
+	}, nil
+}
+
+func (svc *Service) GetMDMAppleEnrollmentProfileByToken(ctx context.Context, token string, ref string) (profile []byte, err error) {
+	// skipauth: The enroll profile endpoint is unauthenticated.
+	svc.authz.SkipAuthorization(ctx)
+
+	_, err = svc.ds.GetMDMAppleEnrollmentProfileByToken(ctx, token)
+	switch {
+	case fleet.IsNotFound(err):
+		// end users that authenticated with the IdP get a single-use token
+		// bound to their account instead of the profile's token.
+		ssoToken, err := svc.ds.ConsumeMDMAppleSSOEnrollmentToken(ctx, token)
+		if err != nil {
+			if fleet.IsNotFound(err) {
+				return nil, fleet.NewAuthFailedError("enrollment profile not found")
+			}
+			return nil, ctxerr.Wrap(ctx, err, "consume sso enrollment token")
+		}
+		if ref != "" && ref != ssoToken.IdPAccountUUID {
+			return nil, fleet.NewAuthFailedError("enrollment reference does not match the sso enrollment token")
+		}
+		ref = ssoToken.IdPAccountUUID
+	case err != nil:
+		return nil, ctxerr.Wrap(ctx, err, "get enrollment profile")
+	}
+
+	appConfig, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	// the enrollment reference links the device to the end user that
+	// authenticated with the IdP before enrolling.
+	enrollURL, err := apple_mdm.AddEnrollmentRefToFleetURL(appConfig.ServerSettings.ServerURL, ref)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "adding reference to fleet URL")
+	}
+
+	// the profile is rendered in the languages of the team the host enrolls
+	// in.
+	var username string
+	var groups []string
+	if ref != "" {
+		acc, err := svc.ds.GetMDMIdPAccount(ctx, ref)
+		if err != nil && !fleet.IsNotFound(err) {
+			return nil, ctxerr.Wrap(ctx, err, "get MDM IdP account")
+		}
+		if acc != nil {
+			username, groups = acc.Username, acc.Groups
+		}
+	}
+	teamID, err := apple_mdm.EnrollmentTeamID(ctx, svc.ds, appConfig, username, groups)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get enrollment team")
+	}
+	strs, err := svc.mdmEndUserStrings(ctx, appConfig, teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	// TODO(lucas): Actually use enrollment (when we define which configuration we want to define
+	// on enrollments).
+	mobileconfig, err := apple_mdm.GenerateEnrollmentProfileMobileconfig(
+		appConfig.OrgInfo.OrgName,
+		enrollURL,
+		svc.config.MDM.AppleSCEPChallenge,
+		svc.mdmPushCertTopic,
+		strs,
+	)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	return mobileconfig, nil
+}
+
+type listMDMAppleSSOEnrollmentTokensRequest struct {
+	ListOptions fleet.ListOptions `url:"list_options"`
+}
+
+type listMDMAppleSSOEnrollmentTokensResponse struct {
+	SSOEnrollmentTokens []*fleet.MDMAppleSSOEnrollmentToken `json:"sso_enrollment_tokens"`
+	Err                 error                               `json:"error,omitempty"`
+}
+
+func (r listMDMAppleSSOEnrollmentTokensResponse) error() error { return r.Err }
+
+func listMDMAppleSSOEnrollmentTokensEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listMDMAppleSSOEnrollmentTokensRequest)
+	toks, err := svc.ListMDMAppleSSOEnrollmentTokens(ctx, req.ListOptions)
+	if err != nil {
+		return listMDMAppleSSOEnrollmentTokensResponse{Err: err}, nil
+	}
+	if toks == nil {
+		toks = []*fleet.MDMAppleSSOEnrollmentToken{}
+	}
+	return listMDMAppleSSOEnrollmentTokensResponse{SSOEnrollmentTokens: toks}, nil
+}
+
+func (svc *Service) ListMDMAppleSSOEnrollmentTokens(ctx context.Context, opt fleet.ListOptions) ([]*fleet.MDMAppleSSOEnrollmentToken, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleEnrollmentProfile{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	toks, err := svc.ds.ListMDMAppleSSOEnrollmentTokens(ctx, opt)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list sso enrollment tokens")
+	}
+	return toks, nil
+}
+
+type revokeMDMAppleSSOEnrollmentTokenRequest struct {
+	ID uint `url:"id"`
+}
+
+type revokeMDMAppleSSOEnrollmentTokenResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r revokeMDMAppleSSOEnrollmentTokenResponse) error() error { return r.Err }
+
+func (r revokeMDMAppleSSOEnrollmentTokenResponse) Status() int { return http.StatusNoContent }
+
+func revokeMDMAppleSSOEnrollmentTokenEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*revokeMDMAppleSSOEnrollmentTokenRequest)
+	if err := svc.RevokeMDMAppleSSOEnrollmentToken(ctx, req.ID); err != nil {
+		return revokeMDMAppleSSOEnrollmentTokenResponse{Err: err}, nil
+	}
+	return revokeMDMAppleSSOEnrollmentTokenResponse{}, nil
+}
+
+func (svc *Service) RevokeMDMAppleSSOEnrollmentToken(ctx context.Context, id uint) error {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleEnrollmentProfile{}, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	tok, err := svc.ds.RevokeMDMAppleSSOEnrollmentToken(ctx, id)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "revoke sso enrollment token")
+	}
+
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), &fleet.ActivityTypeRevokedMDMAppleSSOEnrollmentToken{
+		TokenID:     tok.ID,
+		IdPUsername: tok.IdPUsername,
+	}); err != nil {
+		return ctxerr.Wrap(ctx, err, "create activity for revoked sso enrollment token")
+	}
+	return nil
+}
+
+const (
+	// mdmAppleEnrollmentLinkDefaultExpiration is the expiration of the
+	// enrollment links if none is provided.
+	mdmAppleEnrollmentLinkDefaultExpiration = time.Hour
+	// mdmAppleEnrollmentLinkMaxExpiration is the maximum expiration of the
+	// enrollment links, as they are meant to be short-lived.
+	mdmAppleEnrollmentLinkMaxExpiration = 7 * 24 * time.Hour
+	// mdmAppleEnrollmentLinkMaxUses is the maximum number of hosts that can
+	// enroll with the same enrollment link.
+	mdmAppleEnrollmentLinkMaxUses = 1000
+)
+
+type createMDMAppleEnrollmentLinkRequest struct {
+	TeamID *uint `json:"team_id" premium:"true"`
+	// MaxUses defaults to 1 if not provided.
+	MaxUses uint `json:"max_uses"`
+	// ExpiresInSeconds defaults to mdmAppleEnrollmentLinkDefaultExpiration if
+	// not provided.
+	ExpiresInSeconds uint `json:"expires_in_seconds"`
+}
+
+type createMDMAppleEnrollmentLinkResponse struct {
+	*fleet.MDMAppleEnrollmentLink
+	Err error `json:"error,omitempty"`
+}
+
+func (r createMDMAppleEnrollmentLinkResponse) error() error { return r.Err }
+
+func createMDMAppleEnrollmentLinkEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*createMDMAppleEnrollmentLinkRequest)
+	maxUses := req.MaxUses
+	if maxUses == 0 {
+		maxUses = 1
+	}
+	expiresIn := mdmAppleEnrollmentLinkDefaultExpiration
+	if req.ExpiresInSeconds > 0 {
+		expiresIn = time.Duration(req.ExpiresInSeconds) * time.Second
+	}
+
+	link, err := svc.CreateMDMAppleEnrollmentLink(ctx, req.TeamID, maxUses, expiresIn)
+	if err != nil {
+		return createMDMAppleEnrollmentLinkResponse{Err: err}, nil
+	}
+	return createMDMAppleEnrollmentLinkResponse{MDMAppleEnrollmentLink: link}, nil
+}
+
+func (svc *Service) CreateMDMAppleEnrollmentLink(ctx context.Context, teamID *uint, maxUses uint, expiresIn time.Duration) (*fleet.MDMAppleEnrollmentLink, error) {
+	if teamID != nil && *teamID == 0 {
+		teamID = nil
+	}
+	if err := svc.authz.Authorize(ctx, &fleet.EnrollSecret{TeamID: teamID}, fleet.ActionWrite); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	if maxUses == 0 || maxUses > mdmAppleEnrollmentLinkMaxUses {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("max_uses",
+			fmt.Sprintf("max_uses must be between 1 and %d", mdmAppleEnrollmentLinkMaxUses)))
+	}
+	if expiresIn <= 0 || expiresIn > mdmAppleEnrollmentLinkMaxExpiration {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("expires_in_seconds",
+			fmt.Sprintf("expires_in_seconds must be between 1 and %d", int(mdmAppleEnrollmentLinkMaxExpiration.Seconds()))))
+	}
+	if teamID != nil {
+		if _, err := svc.EnterpriseOverrides.TeamByIDOrName(ctx, teamID, nil); err != nil {
+			return nil, ctxerr.Wrap(ctx, err)
+		}
+	}
+
+	appConfig, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	token, err := server.GenerateRandomText(24)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "generate enrollment link token")
+	}
+	link, err := svc.ds.NewMDMAppleEnrollmentLink(ctx, &fleet.MDMAppleEnrollmentLink{
+		Token:     token,
+		TeamID:    teamID,
+		MaxUses:   maxUses,
+		ExpiresAt: svc.clock.Now().Add(expiresIn).UTC(),
+	})
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	link.URL, err = apple_mdm.ResolveAppleEnrollLinkURL(appConfig.ServerSettings.ServerURL, token)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "resolve enrollment link URL")
+	}
+	// the QR code opens the enrollment link on the device.
+	link.QRCodePayload = link.URL
+	return link, nil
+}
+
+type getMDMAppleEnrollmentLinkRequest struct {
+	ID uint `url:"id"`
+}
+
+type getMDMAppleEnrollmentLinkResponse struct {
+	*fleet.MDMAppleEnrollmentLink
+	Err error `json:"error,omitempty"`
+}
+
+func (r getMDMAppleEnrollmentLinkResponse) error() error { return r.Err }
+
+func getMDMAppleEnrollmentLinkEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getMDMAppleEnrollmentLinkRequest)
+	link, err := svc.GetMDMAppleEnrollmentLink(ctx, req.ID)
+	if err != nil {
+		return getMDMAppleEnrollmentLinkResponse{Err: err}, nil
+	}
+	return getMDMAppleEnrollmentLinkResponse{MDMAppleEnrollmentLink: link}, nil
+}
+
+func (svc *Service) GetMDMAppleEnrollmentLink(ctx context.Context, id uint) (*fleet.MDMAppleEnrollmentLink, error) {
+	// first we perform a perform basic authz check
+	if err := svc.authz.Authorize(ctx, &fleet.Team{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	link, err := svc.ds.GetMDMAppleEnrollmentLink(ctx, id)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	// now we can do a specific authz check based on team id of the link
+	if err := svc.authz.Authorize(ctx, &fleet.EnrollSecret{TeamID: link.TeamID}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+type mdmAppleEnrollLinkRequest struct {
+	Token string `query:"enrollment_link"`
+}
+
+func mdmAppleEnrollLinkEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*mdmAppleEnrollLinkRequest)
+
+	profile, err := svc.GetMDMAppleEnrollmentProfileByLinkToken(ctx, req.Token)
+	if err != nil {
+		return mdmAppleEnrollResponse{Err: err}, nil
+	}
+	return mdmAppleEnrollResponse{
+		Profile: profile,
+	}, nil
+}
+
+func (svc *Service) GetMDMAppleEnrollmentProfileByLinkToken(ctx context.Context, token string) ([]byte, error) {
+	// skipauth: The enroll link endpoint is unauthenticated, the token of the
+	// link is the secret.
+	svc.authz.SkipAuthorization(ctx)
+
+	// the link is only consumed when the host checks in, but there is no point
+	// in serving the profile if it can't be used anymore.
+	link, err := svc.ds.GetMDMAppleEnrollmentLinkByToken(ctx, token)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			return nil, fleet.NewAuthFailedError("enrollment link not found, expired or already used")
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get enrollment link")
+	}
+
+	appConfig, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	// the link token links the enrolling host to the link when it checks in.
+	enrollURL, err := apple_mdm.AddEnrollmentLinkToFleetURL(appConfig.ServerSettings.ServerURL, token)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "adding enrollment link to fleet URL")
+	}
+
+	strs, err := svc.mdmEndUserStrings(ctx, appConfig, link.TeamID)
+	if err != nil {
+		return nil, err
+	}
+
+	mobileconfig, err := apple_mdm.GenerateEnrollmentProfileMobileconfig(
+		appConfig.OrgInfo.OrgName,
+		enrollURL,
+		svc.config.MDM.AppleSCEPChallenge,
+		svc.mdmPushCertTopic,
+		strs,
+	)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	return mobileconfig, nil
+}
+
+type getMDMAppleTeamEnrollmentProfileRequest struct {
+	TeamID *uint `query:"team_id,optional" premium:"true"`
+}
+
+type getMDMAppleTeamEnrollmentProfileResponse struct {
+	// Profile and CDNURL fields are used in hijackRender for the response.
+	Profile []byte
+	CDNURL  string
+
+	Err error `json:"error,omitempty"`
+}
+
+func (r getMDMAppleTeamEnrollmentProfileResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
+	if r.CDNURL != "" {
+		w.Header().Set("Location", r.CDNURL)
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+	// make the browser download the content to a file
+	w.Header().Add("Content-Disposition", `attachment; filename="fleet-mdm-enrollment-profile.mobileconfig"`)
+	// explicitly set the content length before the write, so the caller can
+	// detect short writes (if it fails to send the full content properly)
+	w.Header().Set("Content-Length", strconv.FormatInt(int64(len(r.Profile)), 10))
+	// this content type will make macos open the profile with the proper application
+	w.Header().Set("Content-Type", "application/x-apple-aspen-config; charset=urf-8")
+	// prevent detection of content, obey the provided content-type
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if n, err := w.Write(r.Profile); err != nil {
+		logging.WithExtras(ctx, "err", err, "written", n)
+	}
+}
+
+func (r getMDMAppleTeamEnrollmentProfileResponse) error() error { return r.Err }
+
+func getMDMAppleTeamEnrollmentProfileEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getMDMAppleTeamEnrollmentProfileRequest)
+	profile, err := svc.GetMDMAppleTeamEnrollmentProfile(ctx, req.TeamID)
+	if err != nil {
+		return getMDMAppleTeamEnrollmentProfileResponse{Err: err}, nil
+	}
+	cdnURL, err := svc.MDMAppleEnrollmentProfileCDNURL(ctx, profile)
+	if err != nil {
+		return getMDMAppleTeamEnrollmentProfileResponse{Err: err}, nil
+	}
+	return getMDMAppleTeamEnrollmentProfileResponse{Profile: profile, CDNURL: cdnURL}, nil
+}
+
+func (svc *Service) GetMDMAppleTeamEnrollmentProfile(ctx context.Context, teamID *uint) ([]byte, error) {
+	if teamID != nil && *teamID == 0 {
+		teamID = nil
+	}
+	if err := svc.authz.Authorize(ctx, &fleet.EnrollSecret{TeamID: teamID}, fleet.ActionRead); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	if teamID != nil {
+		if _, err := svc.EnterpriseOverrides.TeamByIDOrName(ctx, teamID, nil); err != nil {
+			return nil, ctxerr.Wrap(ctx, err)
+		}
+	}
+
+	// the token is created on the first download and then reused, so that all
+	// the profiles downloaded for the team are the same until it is rotated.
+	token, err := server.GenerateRandomText(24)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "generate team enrollment token")
+	}
+	teamToken, err := svc.ds.EnsureMDMAppleTeamEnrollmentToken(ctx, teamID, token)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	return svc.mdmAppleTeamEnrollmentProfile(ctx, teamToken.Token, teamToken.TeamID)
+}
+
+func (svc *Service) MDMAppleEnrollmentProfileCDNURL(ctx context.Context, profile []byte) (string, error) {
+	// skipauth: the caller already authorized the download of the profile,
+	// and the MDM assets CDN is only available in premium.
+	svc.authz.SkipAuthorization(ctx)
+
+	return "", nil
+}
+
+func (svc *Service) mdmAppleTeamEnrollmentProfile(ctx context.Context, token string, teamID *uint) ([]byte, error) {
+	appConfig, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	// the team token assigns the enrolling host to the team when it checks in.
+	enrollURL, err := apple_mdm.AddEnrollmentTeamTokenToFleetURL(appConfig.ServerSettings.ServerURL, token)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "adding team enrollment token to fleet URL")
+	}
+
+	strs, err := svc.mdmEndUserStrings(ctx, appConfig, teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	mobileconfig, err := apple_mdm.GenerateEnrollmentProfileMobileconfig(
+		appConfig.OrgInfo.OrgName,
+		enrollURL,
+		svc.config.MDM.AppleSCEPChallenge,
+		svc.mdmPushCertTopic,
+		strs,
+	)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	return mobileconfig, nil
+}
+
+type rotateMDMAppleTeamEnrollmentTokenRequest struct {
+	TeamID *uint `json:"team_id" premium:"true"`
+}
+
+type rotateMDMAppleTeamEnrollmentTokenResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r rotateMDMAppleTeamEnrollmentTokenResponse) error() error { return r.Err }
+
+func rotateMDMAppleTeamEnrollmentTokenEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*rotateMDMAppleTeamEnrollmentTokenRequest)
+	if err := svc.RotateMDMAppleTeamEnrollmentToken(ctx, req.TeamID); err != nil {
+		return rotateMDMAppleTeamEnrollmentTokenResponse{Err: err}, nil
+	}
+	return rotateMDMAppleTeamEnrollmentTokenResponse{}, nil
+}
+
+func (svc *Service) RotateMDMAppleTeamEnrollmentToken(ctx context.Context, teamID *uint) error {
+	if teamID != nil && *teamID == 0 {
+		teamID = nil
+	}
+	if err := svc.authz.Authorize(ctx, &fleet.EnrollSecret{TeamID: teamID}, fleet.ActionWrite); err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+	if teamID != nil {
+		if _, err := svc.EnterpriseOverrides.TeamByIDOrName(ctx, teamID, nil); err != nil {
+			return ctxerr.Wrap(ctx, err)
+		}
+	}
+
+	token, err := server.GenerateRandomText(24)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "generate team enrollment token")
+	}
+	if _, err := svc.ds.SetMDMAppleTeamEnrollmentToken(ctx, teamID, token); err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+	return nil
+}
+
+type mdmAppleCommandRemoveEnrollmentProfileRequest struct {
+	HostID uint `url:"id"`
+}
+
+type mdmAppleCommandRemoveEnrollmentProfileResponse struct {
+	*fleet.MDMAppleUnenrollResult
+	Err error `json:"error,omitempty"`
+}
+
+func (r mdmAppleCommandRemoveEnrollmentProfileResponse) error() error { return r.Err }
+
+func mdmAppleCommandRemoveEnrollmentProfileEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*mdmAppleCommandRemoveEnrollmentProfileRequest)
+	res, err := svc.EnqueueMDMAppleCommandRemoveEnrollmentProfile(ctx, req.HostID)
+	if err != nil {
+		return mdmAppleCommandRemoveEnrollmentProfileResponse{Err: err}, nil
+	}
+	return mdmAppleCommandRemoveEnrollmentProfileResponse{MDMAppleUnenrollResult: res}, nil
+}
+
+func (svc *Service) EnqueueMDMAppleCommandRemoveEnrollmentProfile(ctx context.Context, hostID uint) (*fleet.MDMAppleUnenrollResult, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, err
+	}
+
+	h, err := svc.ds.HostLite(ctx, hostID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "getting host info for mdm apple remove profile command")
+	}
+
+	info, err := svc.ds.GetHostMDMCheckinInfo(ctx, h.UUID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "getting mdm checkin info for mdm apple remove profile command")
+	}
+
+	// Check authorization again based on host info for team-based permissions.
+	if err := svc.authz.Authorize(ctx, fleet.MDMAppleCommandAuthz{
+		TeamID: h.TeamID,
+	}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	nanoEnroll, err := svc.ds.GetNanoMDMEnrollment(ctx, h.UUID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "getting mdm enrollment status for mdm apple remove profile command")
+	}
+	if nanoEnroll == nil || !nanoEnroll.Enabled {
+		return nil, fleet.NewUserMessageError(ctxerr.New(ctx, fmt.Sprintf("mdm is not enabled for host %d", hostID)), http.StatusConflict)
+	}
+
+	// The command is persisted in the host's queue before the push
+	// notification is sent, so the unenrollment completes on the next check-in
+	// of the device even if the push can't be delivered now.
+	res := &fleet.MDMAppleUnenrollResult{
+		HostID:      h.ID,
+		CommandUUID: uuid.New().String(),
+		Status:      fleet.MDMAppleUnenrollStatusPending,
+	}
+	err = svc.mdmAppleCommander.RemoveProfile(ctx, []string{h.UUID}, apple_mdm.FleetPayloadIdentifier, res.CommandUUID)
+	var apnsErr *apple_mdm.APNSDeliveryError
+	switch {
+	case errors.As(err, &apnsErr):
+		level.Info(svc.logger).Log("msg", "sending push notification, remove enrollment profile command still enqueued", "id", h.UUID, "details", err)
+		res.PushFailed = true
+	case err != nil:
+		return nil, ctxerr.Wrap(ctx, err, "enqueuing mdm apple remove profile command")
+	}
+
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), &fleet.ActivityTypeMDMUnenrolled{
+		HostSerial:       h.HardwareSerial,
+		HostDisplayName:  h.DisplayName(),
+		InstalledFromDEP: info.InstalledFromDEP,
+		MDMPlatform:      fleet.MDMPlatformApple,
+	}); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "logging activity for mdm apple remove profile command")
+	}
+
+	if !res.PushFailed && svc.pollResultMDMAppleCommandRemoveEnrollmentProfile(ctx, res.CommandUUID, h.UUID) {
+		res.Status = fleet.MDMAppleUnenrollStatusUnenrolled
+	}
+	return res, nil
+}
+
+// pollResultMDMAppleCommandRemoveEnrollmentProfile waits a few seconds for the
+// device to process the command and reports whether it checked out from
+// Fleet's MDM in that time.
+func (svc *Service) pollResultMDMAppleCommandRemoveEnrollmentProfile(ctx context.Context, cmdUUID string, deviceID string) bool {
+	ctx, cancelFn := context.WithDeadline(ctx, time.Now().Add(5*time.Second))
+	ticker := time.NewTicker(300 * time.Millisecond)
+	defer func() {
+		ticker.Stop()
+		cancelFn()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the device didn't respond in time, it will process the command on
+			// its next check-in
+			level.Info(svc.logger).Log("msg", "mdm unenrollment pending", "id", deviceID, "command_uuid", cmdUUID)
+			return false
+		case <-ticker.C:
+			nanoEnroll, err := svc.ds.GetNanoMDMEnrollment(ctx, deviceID)
+			if err != nil {
+				level.Error(svc.logger).Log("err", "get nanomdm enrollment status", "details", err, "id", deviceID, "command_uuid", cmdUUID)
+				return false
+			}
+			if nanoEnroll != nil && nanoEnroll.Enabled {
+				// check again on the next tick
+				continue
+			}
+			// success, mdm enrollment is no longer enabled for the device
+			level.Info(svc.logger).Log("msg", "mdm disabled for device", "id", deviceID, "command_uuid", cmdUUID)
+			return true
+		}
+	}
+}
+
+type mdmAppleGetInstallerRequest struct {
+	Token string `query:"token"`
+}
+
+func (r mdmAppleGetInstallerResponse) error() error { return r.Err }
+
+type mdmAppleGetInstallerResponse struct {
+	Err error `json:"error,omitempty"`
+
+	// head is used by hijackRender for the response.
+	head bool
+	// Name field is used in hijackRender for the response.
+	name string
+	// Size field is used in hijackRender for the response.
+	size int64
+	// Installer field is used in hijackRender for the response.
+	installer []byte
+}
+
+func (r mdmAppleGetInstallerResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
+	w.Header().Set("Content-Length", strconv.FormatInt(r.size, 10))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment;filename="%s"`, r.name))
+
+	if r.head {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// OK to just log the error here as writing anything on
+	// `http.ResponseWriter` sets the status code to 200 (and it can't be
+	// changed.) Clients should rely on matching content-length with the
+	// header provided
+	if n, err := w.Write(r.installer); err != nil {
+		logging.WithExtras(ctx, "err", err, "bytes_copied", n)
+	}
+}
+
+func mdmAppleGetInstallerEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*mdmAppleGetInstallerRequest)
+	installer, err := svc.GetMDMAppleInstallerByToken(ctx, req.Token)
+	if err != nil {
+		return mdmAppleGetInstallerResponse{Err: err}, nil
+	}
+	return mdmAppleGetInstallerResponse{
+		head:      false,
+		name:      installer.Name,
+		size:      installer.Size,
+		installer: installer.Installer,
+	}, nil
+}
+
+func (svc *Service) GetMDMAppleInstallerByToken(ctx context.Context, token string) (*fleet.MDMAppleInstaller, error) {
+	// skipauth: The installer endpoint uses token authentication.
+	svc.authz.SkipAuthorization(ctx)
+
+	installer, err := svc.ds.MDMAppleInstaller(ctx, token)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	return installer, nil
+}
+
+type mdmAppleHeadInstallerRequest struct {
+	Token string `query:"token"`
+}
+
+func mdmAppleHeadInstallerEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*mdmAppleHeadInstallerRequest)
+	installer, err := svc.GetMDMAppleInstallerDetailsByToken(ctx, req.Token)
+	if err != nil {
+		return mdmAppleGetInstallerResponse{Err: err}, nil
+	}
+	return mdmAppleGetInstallerResponse{
+		head: true,
+		name: installer.Name,
+		size: installer.Size,
+	}, nil
+}
+
+func (svc *Service) GetMDMAppleInstallerDetailsByToken(ctx context.Context, token string) (*fleet.MDMAppleInstaller, error) {
+	// skipauth: The installer endpoint uses token authentication.
+	svc.authz.SkipAuthorization(ctx)
+
+	installer, err := svc.ds.MDMAppleInstallerDetailsByToken(ctx, token)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	return installer, nil
+}
+
+type listMDMAppleInstallersRequest struct{}
+
+type listMDMAppleInstallersResponse struct {
+	Installers []fleet.MDMAppleInstaller `json:"installers"`
+	Err        error                     `json:"error,omitempty"`
+}
+
+func (r listMDMAppleInstallersResponse) error() error { return r.Err }
+
+func listMDMAppleInstallersEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	installers, err := svc.ListMDMAppleInstallers(ctx)
+	if err != nil {
+		return listMDMAppleInstallersResponse{
+			Err: err,
+		}, nil
+	}
+	return listMDMAppleInstallersResponse{
+		Installers: installers,
+	}, nil
+}
+
+func (svc *Service) ListMDMAppleInstallers(ctx context.Context) ([]fleet.MDMAppleInstaller, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleInstaller{}, fleet.ActionWrite); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	appConfig, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	installers, err := svc.ds.ListMDMAppleInstallers(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	for i := range installers {
+		installers[i].URL = svc.installerURL(installers[i].URLToken, appConfig)
+	}
+	return installers, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Lock a device
+////////////////////////////////////////////////////////////////////////////////
+
+type deviceLockRequest struct {
+	HostID uint `url:"id"`
+}
+
+type deviceLockResponse struct {
+	HostID    uint                       `json:"host_id,omitempty"`
+	UnlockPIN *fleet.HostMDMAppleLockPIN `json:"unlock_pin,omitempty"`
+	Err       error                      `json:"error,omitempty"`
+}
+
+func (r deviceLockResponse) error() error { return r.Err }
+
+func deviceLockEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*deviceLockRequest)
+	pin, err := svc.MDMAppleDeviceLock(ctx, req.HostID)
+	if err != nil {
+		return deviceLockResponse{Err: err}, nil
+	}
+	return deviceLockResponse{HostID: req.HostID, UnlockPIN: pin}, nil
+}
+
+func (svc *Service) MDMAppleDeviceLock(ctx context.Context, hostID uint) (*fleet.HostMDMAppleLockPIN, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
+
+	return nil, fleet.ErrMissingLicense
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Get the unlock PIN of a device
+////////////////////////////////////////////////////////////////////////////////
+
+type getHostUnlockPINRequest struct {
+	HostID uint `url:"id"`
+}
+
+type getHostUnlockPINResponse struct {
+	HostID    uint                       `json:"host_id,omitempty"`
+	UnlockPIN *fleet.HostMDMAppleLockPIN `json:"unlock_pin,omitempty"`
+	Err       error                      `json:"error,omitempty"`
+}
+
+func (r getHostUnlockPINResponse) error() error { return r.Err }
+
+func getHostUnlockPINEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getHostUnlockPINRequest)
+	pin, err := svc.HostMDMAppleLockPIN(ctx, req.HostID)
+	if err != nil {
+		return getHostUnlockPINResponse{Err: err}, nil
+	}
+	return getHostUnlockPINResponse{HostID: req.HostID, UnlockPIN: pin}, nil
+}
+
+func (svc *Service) HostMDMAppleLockPIN(ctx context.Context, hostID uint) (*fleet.HostMDMAppleLockPIN, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
+
+	return nil, fleet.ErrMissingLicense
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Quarantine a device
+////////////////////////////////////////////////////////////////////////////////
+
+type quarantineHostRequest struct {
+	HostID uint   `json:"-" url:"id"`
+	TeamID uint   `json:"team_id"`
+	CaseID string `json:"case_id"`
+	Lock   bool   `json:"lock"`
+}
+
+type quarantineHostResponse struct {
+	Quarantine *fleet.HostQuarantine `json:"quarantine,omitempty"`
+	Err        error                 `json:"error,omitempty"`
+}
+
+func (r quarantineHostResponse) error() error { return r.Err }
+
+func quarantineHostEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*quarantineHostRequest)
+	q, err := svc.MDMAppleQuarantineHost(ctx, req.HostID, req.TeamID, req.CaseID, req.Lock)
+	if err != nil {
+		return quarantineHostResponse{Err: err}, nil
+	}
+	return quarantineHostResponse{Quarantine: q}, nil
+}
+
+func (svc *Service) MDMAppleQuarantineHost(ctx context.Context, hostID, teamID uint, caseID string, lock bool) (*fleet.HostQuarantine, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
+
+	return nil, fleet.ErrMissingLicense
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Approve manual enrollments
+////////////////////////////////////////////////////////////////////////////////
+
+type listMDMApplePendingEnrollmentsResponse struct {
+	PendingEnrollments []*fleet.MDMApplePendingEnrollment `json:"pending_enrollments"`
+	Err                error                              `json:"error,omitempty"`
+}
+
+func (r listMDMApplePendingEnrollmentsResponse) error() error { return r.Err }
+
+func listMDMApplePendingEnrollmentsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	pending, err := svc.ListMDMApplePendingEnrollments(ctx)
+	if err != nil {
+		return listMDMApplePendingEnrollmentsResponse{Err: err}, nil
+	}
+	if pending == nil {
+		pending = []*fleet.MDMApplePendingEnrollment{}
+	}
+	return listMDMApplePendingEnrollmentsResponse{PendingEnrollments: pending}, nil
+}
+
+func (svc *Service) ListMDMApplePendingEnrollments(ctx context.Context) ([]*fleet.MDMApplePendingEnrollment, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, err
+	}
+
+	vc, ok := viewer.FromContext(ctx)
+	if !ok {
+		return nil, fleet.ErrNoContext
+	}
+	filter := fleet.TeamFilter{User: vc.User, IncludeObserver: true}
+	return svc.ds.ListMDMApplePendingEnrollments(ctx, filter)
+}
+
+type approveMDMAppleEnrollmentRequest struct {
+	HostID uint `url:"id"`
+}
+
+type approveMDMAppleEnrollmentResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r approveMDMAppleEnrollmentResponse) error() error { return r.Err }
+
+func (r approveMDMAppleEnrollmentResponse) Status() int { return http.StatusNoContent }
+
+func approveMDMAppleEnrollmentEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*approveMDMAppleEnrollmentRequest)
+	if err := svc.ApproveMDMAppleEnrollment(ctx, req.HostID); err != nil {
+		return approveMDMAppleEnrollmentResponse{Err: err}, nil
+	}
+	return approveMDMAppleEnrollmentResponse{}, nil
+}
+
+func (svc *Service) ApproveMDMAppleEnrollment(ctx context.Context, hostID uint) error {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return err
+	}
+
+	h, err := svc.ds.HostLite(ctx, hostID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "getting host to approve enrollment")
+	}
+
+	// approving an enrollment lets Fleet send commands to the host, it
+	// requires the same permissions as running commands.
+	if err := svc.authz.Authorize(ctx, fleet.MDMAppleCommandAuthz{
+		TeamID: h.TeamID,
+	}, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	if err := svc.ds.ApproveMDMAppleEnrollment(ctx, h.UUID); err != nil {
+		return ctxerr.Wrap(ctx, err, "approve enrollment")
+	}
+
+	// the host can now receive the profiles of its team (or no team).
+	if err := svc.ds.BulkSetPendingMDMAppleHostProfiles(ctx, nil, nil, nil, []string{h.UUID}); err != nil {
+		return ctxerr.Wrap(ctx, err, "set pending profiles of approved host")
+	}
+
+	info, err := svc.ds.GetHostMDMCheckinInfo(ctx, h.UUID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "getting mdm checkin info of approved host")
+	}
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), &fleet.ActivityTypeApprovedMDMEnrollment{
+		HostID:          h.ID,
+		HostSerial:      info.HardwareSerial,
+		HostDisplayName: info.DisplayName,
+	}); err != nil {
+		return ctxerr.Wrap(ctx, err, "create activity for approved enrollment")
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// List blocked enrollments
+////////////////////////////////////////////////////////////////////////////////
+
+type listMDMAppleBlockedEnrollmentsResponse struct {
+	BlockedEnrollments []*fleet.MDMAppleBlockedEnrollment `json:"blocked_enrollments"`
+	Err                error                              `json:"error,omitempty"`
+}
+
+func (r listMDMAppleBlockedEnrollmentsResponse) error() error { return r.Err }
+
+func listMDMAppleBlockedEnrollmentsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	blocked, err := svc.ListMDMAppleBlockedEnrollments(ctx)
+	if err != nil {
+		return listMDMAppleBlockedEnrollmentsResponse{Err: err}, nil
+	}
+	if blocked == nil {
+		blocked = []*fleet.MDMAppleBlockedEnrollment{}
+	}
+	return listMDMAppleBlockedEnrollmentsResponse{BlockedEnrollments: blocked}, nil
+}
+
+func (svc *Service) ListMDMAppleBlockedEnrollments(ctx context.Context) ([]*fleet.MDMAppleBlockedEnrollment, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, err
+	}
+
+	vc, ok := viewer.FromContext(ctx)
+	if !ok {
+		return nil, fleet.ErrNoContext
+	}
+	filter := fleet.TeamFilter{User: vc.User, IncludeObserver: true}
+	return svc.ds.ListMDMAppleBlockedEnrollments(ctx, filter)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Purge the MDM data of a host
+////////////////////////////////////////////////////////////////////////////////
+
+type purgeHostMDMAppleDataRequest struct {
+	HostID uint `url:"id"`
+}
+
+type purgeHostMDMAppleDataResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r purgeHostMDMAppleDataResponse) error() error { return r.Err }
+
+func (r purgeHostMDMAppleDataResponse) Status() int { return http.StatusNoContent }
+
+func purgeHostMDMAppleDataEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*purgeHostMDMAppleDataRequest)
+	if err := svc.PurgeHostMDMAppleData(ctx, req.HostID); err != nil {
+		return purgeHostMDMAppleDataResponse{Err: err}, nil
+	}
+	return purgeHostMDMAppleDataResponse{}, nil
+}
+
+func (svc *Service) PurgeHostMDMAppleData(ctx context.Context, hostID uint) error {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return err
+	}
+
+	h, err := svc.ds.HostLite(ctx, hostID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "getting host to purge mdm data")
+	}
+
+	if err := svc.authz.Authorize(ctx, fleet.MDMAppleHostDataAuthz{
+		TeamID: h.TeamID,
+	}, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	// the info must be loaded before the purge, to have the serial number and
+	// display name for the activity.
+	info, err := svc.ds.GetHostMDMCheckinInfo(ctx, h.UUID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "getting mdm checkin info of host to purge")
+	}
+
+	// the profiles of the host are installed again by the profiles cron job,
+	// as they are missing from its delivery status.
+	if err := svc.ds.PurgeHostMDMAppleData(ctx, h.ID, h.UUID); err != nil {
+		return ctxerr.Wrap(ctx, err, "purge host mdm data")
+	}
+
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), &fleet.ActivityTypePurgedHostMDMData{
+		HostID:          h.ID,
+		HostSerial:      info.HardwareSerial,
+		HostDisplayName: info.DisplayName,
+	}); err != nil {
+		return ctxerr.Wrap(ctx, err, "create activity for purged host mdm data")
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Approve profile changes
+////////////////////////////////////////////////////////////////////////////////
+
+type listMDMAppleProfileChangesRequest struct {
+	Status fleet.MDMAppleProfileChangeStatus `query:"status,optional"`
+}
+
+type listMDMAppleProfileChangesResponse struct {
+	ProfileChanges []*fleet.MDMAppleProfileChange `json:"profile_changes"`
+	Err            error                          `json:"error,omitempty"`
+}
+
+func (r listMDMAppleProfileChangesResponse) error() error { return r.Err }
+
+func listMDMAppleProfileChangesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listMDMAppleProfileChangesRequest)
+	changes, err := svc.ListMDMAppleProfileChanges(ctx, req.Status)
+	if err != nil {
+		return listMDMAppleProfileChangesResponse{Err: err}, nil
+	}
+	if changes == nil {
+		changes = []*fleet.MDMAppleProfileChange{}
+	}
+	return listMDMAppleProfileChangesResponse{ProfileChanges: changes}, nil
+}
+
+func (svc *Service) ListMDMAppleProfileChanges(ctx context.Context, status fleet.MDMAppleProfileChangeStatus) ([]*fleet.MDMAppleProfileChange, error) {
+	// the changes are global, as the approval settings.
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleConfigProfile{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	if status != "" && !status.IsValid() {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("status", fmt.Sprintf("invalid status %q", status)))
+	}
+	return svc.ds.ListMDMAppleProfileChanges(ctx, status)
+}
+
+type reviewMDMAppleProfileChangeRequest struct {
+	ID uint `url:"id"`
+}
+
+type reviewMDMAppleProfileChangeResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r reviewMDMAppleProfileChangeResponse) error() error { return r.Err }
+
+func (r reviewMDMAppleProfileChangeResponse) Status() int { return http.StatusNoContent }
+
+func approveMDMAppleProfileChangeEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*reviewMDMAppleProfileChangeRequest)
+	if err := svc.ReviewMDMAppleProfileChange(ctx, req.ID, true); err != nil {
+		return reviewMDMAppleProfileChangeResponse{Err: err}, nil
+	}
+	return reviewMDMAppleProfileChangeResponse{}, nil
+}
+
+func rejectMDMAppleProfileChangeEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*reviewMDMAppleProfileChangeRequest)
+	if err := svc.ReviewMDMAppleProfileChange(ctx, req.ID, false); err != nil {
+		return reviewMDMAppleProfileChangeResponse{Err: err}, nil
+	}
+	return reviewMDMAppleProfileChangeResponse{}, nil
+}
+
+func (svc *Service) ReviewMDMAppleProfileChange(ctx context.Context, id uint, approve bool) error {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleConfigProfile{}, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	status := fleet.MDMAppleProfileChangeRejected
+	if approve {
+		status = fleet.MDMAppleProfileChangeApproved
+	}
+	change, err := svc.ds.ReviewMDMAppleProfileChange(ctx, id, status)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "review profile change")
+	}
+
+	var act fleet.ActivityDetails = &fleet.ActivityTypeRejectedMDMProfileChange{
+		ChangeID:          change.ID,
+		ProfileID:         change.ProfileID,
+		ProfileName:       change.ProfileName,
+		ProfileIdentifier: change.ProfileIdentifier,
+		OperationType:     change.OperationType,
+		HostCount:         change.HostCount,
+	}
+	if approve {
+		act = &fleet.ActivityTypeApprovedMDMProfileChange{
+			ChangeID:          change.ID,
+			ProfileID:         change.ProfileID,
+			ProfileName:       change.ProfileName,
+			ProfileIdentifier: change.ProfileIdentifier,
+			OperationType:     change.OperationType,
+			HostCount:         change.HostCount,
+		}
+	}
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), act); err != nil {
+		return ctxerr.Wrap(ctx, err, "create activity for reviewed profile change")
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Wipe a device
+////////////////////////////////////////////////////////////////////////////////
+
+type deviceWipeRequest struct {
+	HostID uint `url:"id"`
+}
+
+type deviceWipeResponse struct {
+	HostID    uint                       `json:"host_id,omitempty"`
+	UnlockPIN *fleet.HostMDMAppleLockPIN `json:"unlock_pin,omitempty"`
+	Err       error                      `json:"error,omitempty"`
+}
+
+func (r deviceWipeResponse) error() error { return r.Err }
+
+func deviceWipeEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*deviceWipeRequest)
+	pin, err := svc.MDMAppleEraseDevice(ctx, req.HostID)
+	if err != nil {
+		return deviceWipeResponse{Err: err}, nil
+	}
+	return deviceWipeResponse{HostID: req.HostID, UnlockPIN: pin}, nil
+}
+
+func (svc *Service) MDMAppleEraseDevice(ctx context.Context, hostID uint) (*fleet.HostMDMAppleLockPIN, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
+
+	return nil, fleet.ErrMissingLicense
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Batch Replace MDM Apple Profiles
+////////////////////////////////////////////////////////////////////////////////
+
+type batchSetMDMAppleProfilesRequest struct {
+	TeamID                      *uint    `json:"-" query:"team_id,optional"`
+	TeamName                    *string  `json:"-" query:"team_name,optional"`
+	DryRun                      bool     `json:"-" query:"dry_run,optional"`                       // if true, apply validation but do not save changes
+	Force                       bool     `json:"-" query:"force,optional"`                         // if true, ignore the profile identifier conflicts
+	AcknowledgeReservedPayloads bool     `json:"-" query:"acknowledge_reserved_payloads,optional"` // if true, accept reserved PayloadTypes if the team allows them
+	Preview                     bool     `json:"-" query:"preview,optional"`                       // if true, return the number of affected hosts but do not save changes
+	AllTeams                    bool     `json:"-" query:"all_teams,optional"`                     // if true, set the profiles that apply to all teams
+	Profiles                    [][]byte `json:"profiles"`
+	// Exclusions are the hosts that must not receive some of the profiles.
+	Exclusions []fleet.MDMAppleProfileExclusionSpec `json:"exclusions"`
+}
+
+type batchSetMDMAppleProfilesResponse struct {
+	JobID   *uint                          `json:"job_id,omitempty"`
+	Preview *fleet.MDMAppleProfilesPreview `json:"preview,omitempty"`
+	Err     error                          `json:"error,omitempty"`
+}
+
+func (r batchSetMDMAppleProfilesResponse) error() error { return r.Err }
+
+func (r batchSetMDMAppleProfilesResponse) Status() int {
+	if r.Preview != nil {
+		return http.StatusOK
+	}
+	if r.JobID != nil {
+		// the profiles of the affected hosts are being updated asynchronously
+		return http.StatusAccepted
+	}
+	return http.StatusNoContent
+}
+
+func batchSetMDMAppleProfilesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*batchSetMDMAppleProfilesRequest)
+	if req.AllTeams {
+		if req.TeamID != nil || req.TeamName != nil || req.Preview {
+			// prevent returning an "unauthorized" error, we want that specific error
+			setAuthCheckedOnPreAuthErr(ctx)
+			err := fleet.NewInvalidArgumentError("all_teams", "cannot specify all_teams with team_id, team_name or preview")
+			return batchSetMDMAppleProfilesResponse{Err: ctxerr.Wrap(ctx, err)}, nil
+		}
+		job, err := svc.BatchSetMDMAppleAllTeamsProfiles(ctx, req.Profiles, req.Exclusions, req.DryRun, req.AcknowledgeReservedPayloads)
+		if err != nil {
+			return batchSetMDMAppleProfilesResponse{Err: err}, nil
+		}
+		var resp batchSetMDMAppleProfilesResponse
+		if job != nil {
+			resp.JobID = &job.ID
+		}
+		return resp, nil
+	}
+	if req.Preview {
+		preview, err := svc.PreviewBatchSetMDMAppleProfiles(ctx, req.TeamID, req.TeamName, req.Profiles, req.Exclusions, req.Force, req.AcknowledgeReservedPayloads)
+		if err != nil {
+			return batchSetMDMAppleProfilesResponse{Err: err}, nil
+		}
+		return batchSetMDMAppleProfilesResponse{Preview: preview}, nil
+	}
+	job, err := svc.BatchSetMDMAppleProfiles(ctx, req.TeamID, req.TeamName, req.Profiles, req.Exclusions, req.DryRun, req.Force, req.AcknowledgeReservedPayloads)
+	if err != nil {
+		return batchSetMDMAppleProfilesResponse{Err: err}, nil
+	}
+	var resp batchSetMDMAppleProfilesResponse
+	if job != nil {
+		resp.JobID = &job.ID
+	}
+	return resp, nil
+}
+
+// validateBatchSetMDMAppleProfiles resolves the team, authorizes the request
+// and validates the provided profiles for BatchSetMDMAppleProfiles and
+// PreviewBatchSetMDMAppleProfiles. It returns the resolved team ID and name
+// and the parsed profiles. If ok is false, there is nothing to apply (Fleet
+// MDM is not configured and no profile is provided).
+func (svc *Service) validateBatchSetMDMAppleProfiles(ctx context.Context, tmID *uint, tmName *string, profiles [][]byte, force, acknowledgeReserved bool) (teamID *uint, teamName *string, profs []*fleet.MDMAppleConfigProfile, ok bool, err error) {
+	if tmID != nil && tmName != nil {
+		svc.authz.SkipAuthorization(ctx) // so that the error message is not replaced by "forbidden"
+		return nil, nil, nil, false, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("team_name", "cannot specify both team_id and team_name"))
+	}
+	if tmID != nil || tmName != nil {
+		license, _ := license.FromContext(ctx)
+		if !license.IsPremium() {
+			field := "team_id"
+			if tmName != nil {
+				field = "team_name"
+			}
+			svc.authz.SkipAuthorization(ctx) // so that the error message is not replaced by "forbidden"
+			return nil, nil, nil, false, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError(field, ErrMissingLicense.Error()))
+		}
+	}
+
+	// if the team name is provided, load the corresponding team to get its id.
+	// vice-versa, if the id is provided, load it to get the name (required for
+	// the activity).
+	var tm *fleet.Team
+	if tmName != nil || tmID != nil {
+		var err error
+		tm, err = svc.EnterpriseOverrides.TeamByIDOrName(ctx, tmID, tmName)
+		if err != nil {
+			return nil, nil, nil, false, err
+		}
+		if tmID == nil {
+			tmID = &tm.ID
+		} else {
+			tmName = &tm.Name
+		}
+	}
+
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleConfigProfile{TeamID: tmID}, fleet.ActionWrite); err != nil {
+		return nil, nil, nil, false, ctxerr.Wrap(ctx, err)
+	}
+
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, nil, nil, false, ctxerr.Wrap(ctx, err)
+	}
+	allowReserved := appCfg.MDM.MacOSSettings.AllowReservedPayloads
+	if tm != nil {
+		allowReserved = tm.Config.MDM.MacOSSettings.AllowReservedPayloads
+	}
+
+	if !appCfg.MDM.EnabledAndConfigured {
+		// NOTE: in order to prevent an error when Fleet MDM is not enabled but no
+		// profile is provided, which can happen if a user runs `fleetctl get
+		// config` and tries to apply that YAML, as it will contain an empty/null
+		// custom_settings key, we just return a success response in this
+		// situation.
+		if len(profiles) == 0 {
+			return tmID, tmName, nil, false, nil
+		}
+
+		return nil, nil, nil, false, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("mdm", "cannot set custom settings: Fleet MDM is not configured"))
+	}
+
+	profs, err = parseBatchMDMAppleProfiles(ctx, tmID, profiles, allowReserved, acknowledgeReserved, svc.config.MDM.Secrets.Provider != "")
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+
+	if !force {
+		err := svc.checkMDMAppleProfileIdentifierConflicts(ctx, tmID, profs, func(i int) string {
+			return fmt.Sprintf("profiles[%d]", i)
+		}, "Couldn’t edit custom_settings.")
+		if err != nil {
+			return nil, nil, nil, false, err
+		}
+	}
+
+	return tmID, tmName, profs, true, nil
+}
+
+// parseBatchMDMAppleProfiles parses and validates the profiles of a batch
+// change of the profiles of the team tmID. Any duplicate identifier or name in
+// the provided set results in an error.
+func parseBatchMDMAppleProfiles(ctx context.Context, tmID *uint, profiles [][]byte, allowReserved, acknowledgeReserved, secretsConfigured bool) ([]*fleet.MDMAppleConfigProfile, error) {
+	profs := make([]*fleet.MDMAppleConfigProfile, 0, len(profiles))
+	byName, byIdent := make(map[string]bool, len(profiles)), make(map[string]bool, len(profiles))
+	for i, prof := range profiles {
+		mdmProf, err := fleet.NewMDMAppleConfigProfile(prof, tmID)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx,
+				fleet.NewInvalidArgumentError(fmt.Sprintf("profiles[%d]", i), err.Error()),
+				"invalid mobileconfig profile")
+		}
+
+		if err := validateUserProvidedMDMAppleProfile(mdmProf, allowReserved, acknowledgeReserved); err != nil {
+			return nil, ctxerr.Wrap(ctx,
+				fleet.NewInvalidArgumentError(fmt.Sprintf("profiles[%d]", i), err.Error()))
+		}
+		if err := validateMDMAppleProfileSecrets(mdmProf, secretsConfigured); err != nil {
+			return nil, ctxerr.Wrap(ctx,
+				fleet.NewInvalidArgumentError(fmt.Sprintf("profiles[%d]", i), err.Error()))
+		}
+
+		if byName[mdmProf.Name] {
+			return nil, ctxerr.Wrap(ctx,
+				fleet.NewInvalidArgumentError(fmt.Sprintf("profiles[%d]", i), fmt.Sprintf("Couldn’t edit custom_settings. More than one configuration profile have the same name (PayloadDisplayName): %q", mdmProf.Name)),
+				"duplicate mobileconfig profile by name")
+		}
+		byName[mdmProf.Name] = true
+
+		if byIdent[mdmProf.Identifier] {
+			return nil, ctxerr.Wrap(ctx,
+				fleet.NewInvalidArgumentError(fmt.Sprintf("profiles[%d]", i), fmt.Sprintf("Couldn’t edit custom_settings. More than one configuration profile have the same identifier (PayloadIdentifier): %q", mdmProf.Identifier)),
+				"duplicate mobileconfig profile by identifier")
+		}
+		byIdent[mdmProf.Identifier] = true
+
+		profs = append(profs, mdmProf)
+	}
+	return profs, nil
+}
+
+func (svc *Service) BatchSetMDMAppleProfiles(ctx context.Context, tmID *uint, tmName *string, profiles [][]byte, exclusions []fleet.MDMAppleProfileExclusionSpec, dryRun, force, acknowledgeReserved bool) (*fleet.Job, error) {
+	tmID, tmName, profs, ok, err := svc.validateBatchSetMDMAppleProfiles(ctx, tmID, tmName, profiles, force, acknowledgeReserved)
+	if err != nil || !ok {
+		return nil, err
+	}
+	excls, err := svc.resolveMDMAppleProfileExclusions(ctx, profs, exclusions)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return nil, nil
+	}
+
+	// the changes are computed before the profiles are replaced, to record them
+	// in the activity.
+	current, err := svc.ds.ListMDMAppleConfigProfiles(ctx, tmID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list current profiles")
+	}
+	act := editedMacosProfileActivity(tmID, tmName, current, profs, svc.profileChecksumAlgorithm())
+
+	if err := svc.ds.BatchSetMDMAppleProfiles(ctx, tmID, profs); err != nil {
+		return nil, err
+	}
+	if err := svc.ds.BatchSetMDMAppleProfileExclusions(ctx, tmID, excls); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "set profile exclusions")
+	}
+	for _, p := range profs {
+		logReservedPayloadTypes(svc.logger, p)
+	}
+	var bulkTeamID uint
+	if tmID != nil {
+		bulkTeamID = *tmID
+	}
+	job, err := worker.BulkSetPendingMDMAppleHostProfiles(ctx, svc.ds, svc.logger, nil, []uint{bulkTeamID}, nil)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
+	}
+
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), act); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "logging activity for edited macos profile")
+	}
+	return job, nil
+}
+
+func (svc *Service) BatchSetMDMAppleAllTeamsProfiles(ctx context.Context, profiles [][]byte, exclusions []fleet.MDMAppleProfileExclusionSpec, dryRun, acknowledgeReserved bool) (*fleet.Job, error) {
+	if !license.IsPremium(ctx) {
+		svc.authz.SkipAuthorization(ctx) // so that the error message is not replaced by "forbidden"
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("all_teams", ErrMissingLicense.Error()))
+	}
+
+	// the profiles that apply to all teams are global, only global users can
+	// write them.
+	tmID := ptr.Uint(fleet.MDMAppleAllTeamsProfilesTeamID)
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleConfigProfile{}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	if !appCfg.MDM.EnabledAndConfigured {
+		// same as for the team profiles, applying an empty list is a no-op when
+		// Fleet MDM is not enabled.
+		if len(profiles) == 0 {
+			return nil, nil
+		}
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("mdm", "cannot set all teams custom settings: Fleet MDM is not configured"))
+	}
+
+	profs, err := parseBatchMDMAppleProfiles(ctx, tmID, profiles, appCfg.MDM.MacOSSettings.AllowReservedPayloads, acknowledgeReserved, svc.config.MDM.Secrets.Provider != "")
+	if err != nil {
+		return nil, err
+	}
+	excls, err := svc.resolveMDMAppleProfileExclusions(ctx, profs, exclusions)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return nil, nil
+	}
+
+	current, err := svc.ds.ListMDMAppleConfigProfiles(ctx, tmID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list current all teams profiles")
+	}
+	act := editedMacosProfileActivity(nil, nil, current, profs, svc.profileChecksumAlgorithm())
+	act.AllTeams = true
+
+	if err := svc.ds.BatchSetMDMAppleProfiles(ctx, tmID, profs); err != nil {
+		return nil, err
+	}
+	if err := svc.ds.BatchSetMDMAppleProfileExclusions(ctx, tmID, excls); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "set all teams profile exclusions")
+	}
+	for _, p := range profs {
+		logReservedPayloadTypes(svc.logger, p)
+	}
+	job, err := worker.BulkSetPendingMDMAppleHostProfiles(ctx, svc.ds, svc.logger, nil, []uint{*tmID}, nil)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
+	}
+
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), act); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "logging activity for edited macos profile")
+	}
+	return job, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Batch Replace MDM Apple Profiles of Multiple Teams
+////////////////////////////////////////////////////////////////////////////////
+
+type batchSetMDMAppleTeamsProfilesRequest struct {
+	DryRun                      bool `json:"-" query:"dry_run,optional"`                       // if true, apply validation but do not save changes
+	Force                       bool `json:"-" query:"force,optional"`                         // if true, ignore the profile identifier conflicts
+	AcknowledgeReservedPayloads bool `json:"-" query:"acknowledge_reserved_payloads,optional"` // if true, accept reserved PayloadTypes if the team allows them
+	// Teams are the profiles of each team keyed by team name, the empty name
+	// being no team.
+	Teams map[string]fleet.MDMAppleTeamProfilesSpec `json:"teams"`
+}
+
+func batchSetMDMAppleTeamsProfilesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*batchSetMDMAppleTeamsProfilesRequest)
+	job, err := svc.BatchSetMDMAppleTeamsProfiles(ctx, req.Teams, req.DryRun, req.Force, req.AcknowledgeReservedPayloads)
+	if err != nil {
+		return batchSetMDMAppleProfilesResponse{Err: err}, nil
+	}
+	var resp batchSetMDMAppleProfilesResponse
+	if job != nil {
+		resp.JobID = &job.ID
+	}
+	return resp, nil
+}
+
+func (svc *Service) BatchSetMDMAppleTeamsProfiles(ctx context.Context, teams map[string]fleet.MDMAppleTeamProfilesSpec, dryRun, force, acknowledgeReserved bool) (*fleet.Job, error) {
+	if len(teams) == 0 {
+		svc.authz.SkipAuthorization(ctx) // so that the error message is not replaced by "forbidden"
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("teams", "at least one team must be provided"))
+	}
+
+	// process the teams in a deterministic order, so that the errors and
+	// activities are consistent from one call to the next.
+	names := make([]string, 0, len(teams))
+	for name := range teams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	batches := make([]*fleet.MDMAppleTeamProfiles, 0, len(teams))
+	acts := make([]*fleet.ActivityTypeEditedMacosProfile, 0, len(teams))
+	teamIDs := make([]uint, 0, len(teams))
+	for _, name := range names {
+		spec := teams[name]
+
+		var tmName *string
+		if name != "" {
+			tmName = ptr.String(name)
+		}
+		tmID, tmName, profs, ok, err := svc.validateBatchSetMDMAppleProfiles(ctx, nil, tmName, spec.Profiles, force, acknowledgeReserved)
+		if err != nil {
+			return nil, teamsBatchArgError(ctx, err, name)
+		}
+		if !ok {
+			continue
+		}
+		excls, err := svc.resolveMDMAppleProfileExclusions(ctx, profs, spec.Exclusions)
+		if err != nil {
+			return nil, teamsBatchArgError(ctx, err, name)
+		}
+		if dryRun {
+			continue
+		}
+
+		current, err := svc.ds.ListMDMAppleConfigProfiles(ctx, tmID)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "list current profiles")
+		}
+		acts = append(acts, editedMacosProfileActivity(tmID, tmName, current, profs, svc.profileChecksumAlgorithm()))
+		batches = append(batches, &fleet.MDMAppleTeamProfiles{TeamID: tmID, Profiles: profs, Exclusions: excls})
+
+		var bulkTeamID uint
+		if tmID != nil {
+			bulkTeamID = *tmID
+		}
+		teamIDs = append(teamIDs, bulkTeamID)
+	}
+
+	if dryRun || len(batches) == 0 {
+		return nil, nil
+	}
+
+	if err := svc.ds.BatchSetMDMAppleTeamsProfiles(ctx, batches); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "batch set teams profiles")
+	}
+	for _, b := range batches {
+		for _, p := range b.Profiles {
+			logReservedPayloadTypes(svc.logger, p)
+		}
+	}
+	// a single reconciliation of the hosts of all the changed teams
+	job, err := worker.BulkSetPendingMDMAppleHostProfiles(ctx, svc.ds, svc.logger, nil, teamIDs, nil)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
+	}
+
+	for _, act := range acts {
+		if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), act); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "logging activity for edited macos profile")
+		}
+	}
+	return job, nil
+}
+
+// teamsBatchArgError prefixes the names of the invalid arguments of err, if
+// any, with the team of the multi-team batch change they belong to, keeping
+// the status code of the error.
+func teamsBatchArgError(ctx context.Context, err error, tmName string) error {
+	var invalid interface{ Invalid() []map[string]string }
+	if !errors.As(err, &invalid) {
+		return err
+	}
+
+	prefixed := &fleet.InvalidArgumentError{}
+	for _, arg := range invalid.Invalid() {
+		prefixed.Append(fmt.Sprintf("teams[%q].%s", tmName, arg["name"]), arg["reason"])
+	}
+	var withStatus interface{ Status() int }
+	if errors.As(err, &withStatus) {
+		return ctxerr.Wrap(ctx, prefixed.WithStatus(withStatus.Status()))
+	}
+	return ctxerr.Wrap(ctx, prefixed)
+}
+
+func (svc *Service) PreviewBatchSetMDMAppleProfiles(ctx context.Context, tmID *uint, tmName *string, profiles [][]byte, exclusions []fleet.MDMAppleProfileExclusionSpec, force, acknowledgeReserved bool) (*fleet.MDMAppleProfilesPreview, error) {
+	tmID, _, profs, ok, err := svc.validateBatchSetMDMAppleProfiles(ctx, tmID, tmName, profiles, force, acknowledgeReserved)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &fleet.MDMAppleProfilesPreview{TeamID: tmID}, nil
+	}
+	// the exclusions are validated but the preview does not take them into
+	// account, it counts the hosts as if none was excluded.
+	if _, err := svc.resolveMDMAppleProfileExclusions(ctx, profs, exclusions); err != nil {
+		return nil, err
+	}
+
+	preview, err := svc.ds.GetMDMAppleProfilesPreview(ctx, tmID, profs)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get profiles preview")
+	}
+	return preview, nil
+}
+
+// resolveMDMAppleProfileExclusions validates the exclusions provided with a
+// batch change of profiles and resolves their labels and hosts to their ids.
+// The excluded profiles must be part of the incoming profiles. The IdP groups
+// are kept by name, they may be synced via SCIM after the exclusion is set.
+func (svc *Service) resolveMDMAppleProfileExclusions(ctx context.Context, profs []*fleet.MDMAppleConfigProfile, exclusions []fleet.MDMAppleProfileExclusionSpec) ([]*fleet.MDMAppleProfileExclusion, error) {
+	if len(exclusions) == 0 {
+		return nil, nil
+	}
+
+	idents := make(map[string]bool, len(profs))
+	for _, p := range profs {
+		idents[p.Identifier] = true
+	}
+
+	var excls []*fleet.MDMAppleProfileExclusion
+	for i, e := range exclusions {
+		field := fmt.Sprintf("exclusions[%d]", i)
+		if !idents[e.ProfileIdentifier] {
+			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError(field,
+				fmt.Sprintf("Couldn’t edit custom_settings_exclusions. The excluded profile is not in custom_settings: %q", e.ProfileIdentifier)))
+		}
+
+		for _, name := range e.Labels {
+			ids, err := svc.ds.LabelIDsByName(ctx, []string{name})
+			if err != nil {
+				return nil, ctxerr.Wrap(ctx, err, "get excluded label id")
+			}
+			if len(ids) == 0 {
+				return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError(field,
+					fmt.Sprintf("Couldn’t edit custom_settings_exclusions. Label doesn’t exist: %q", name)))
+			}
+			excls = append(excls, &fleet.MDMAppleProfileExclusion{ProfileIdentifier: e.ProfileIdentifier, LabelID: &ids[0]})
+		}
+
+		for _, ident := range e.Hosts {
+			h, err := svc.ds.HostByIdentifier(ctx, ident)
+			if err != nil {
+				if fleet.IsNotFound(err) {
+					return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError(field,
+						fmt.Sprintf("Couldn’t edit custom_settings_exclusions. Host doesn’t exist: %q", ident)))
+				}
+				return nil, ctxerr.Wrap(ctx, err, "get excluded host")
+			}
+			excls = append(excls, &fleet.MDMAppleProfileExclusion{ProfileIdentifier: e.ProfileIdentifier, HostID: &h.ID})
+		}
+
+		for _, group := range e.IdPGroups {
+			group := strings.TrimSpace(group)
+			if group == "" {
+				return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError(field,
+					"Couldn’t edit custom_settings_exclusions. IdP group name can’t be empty."))
+			}
+			excls = append(excls, &fleet.MDMAppleProfileExclusion{ProfileIdentifier: e.ProfileIdentifier, IdPGroup: &group})
+		}
+	}
+	return excls, nil
+}
+
+// profileChecksumAlgorithm returns the hash algorithm of the checksums of the
+// configuration profiles, the configuration is validated when the server
+// starts.
+func (svc *Service) profileChecksumAlgorithm() fleet.MDMProfileChecksumAlgorithm {
+	alg, _ := svc.config.MDM.ProfileChecksum()
+	return fleet.MDMProfileChecksumAlgorithm(alg)
+}
+
+// editedMacosProfileActivity returns the activity for a batch edit of the
+// macOS profiles of a team (or no team), with the profiles that were added,
+// removed or changed from the current to the incoming profiles. The profiles
+// are matched by identifier.
+func editedMacosProfileActivity(
+	tmID *uint,
+	tmName *string,
+	current, incoming []*fleet.MDMAppleConfigProfile,
+	checksumAlg fleet.MDMProfileChecksumAlgorithm,
+) *fleet.ActivityTypeEditedMacosProfile {
+	act := &fleet.ActivityTypeEditedMacosProfile{
+		TeamID:          tmID,
+		TeamName:        tmName,
+		AddedProfiles:   []fleet.ActivityMacosProfileChange{},
+		RemovedProfiles: []fleet.ActivityMacosProfileChange{},
+		ChangedProfiles: []fleet.ActivityMacosProfileChange{},
+	}
+	add := func(list *[]fleet.ActivityMacosProfileChange, change fleet.ActivityMacosProfileChange) {
+		if len(*list) >= fleet.MaxActivityMacosProfileChanges {
+			act.ProfilesTruncated = true
+			return
+		}
+		*list = append(*list, change)
+	}
+	checksum := func(prof *fleet.MDMAppleConfigProfile) string {
+		// same checksum as stored in the database
+		return hex.EncodeToString(checksumAlg.Sum(prof.Mobileconfig))
+	}
+
+	byIdent := make(map[string]*fleet.MDMAppleConfigProfile, len(current))
+	for _, prof := range current {
+		byIdent[prof.Identifier] = prof
+	}
+	for _, prof := range incoming {
+		sum := checksum(prof)
+		prev, ok := byIdent[prof.Identifier]
+		if !ok {
+			add(&act.AddedProfiles, fleet.ActivityMacosProfileChange{Name: prof.Name, Identifier: prof.Identifier, Checksum: sum})
+			continue
+		}
+		delete(byIdent, prof.Identifier)
+
+		if prevSum := checksum(prev); prevSum != sum {
+			change := fleet.ActivityMacosProfileChange{Name: prof.Name, Identifier: prof.Identifier, Checksum: sum, PreviousChecksum: prevSum}
+			if prev.Name != prof.Name {
+				change.PreviousName = prev.Name
+			}
+			add(&act.ChangedProfiles, change)
+		}
+	}
+	for _, prof := range current {
+		if _, ok := byIdent[prof.Identifier]; ok {
+			add(&act.RemovedProfiles, fleet.ActivityMacosProfileChange{Name: prof.Name, Identifier: prof.Identifier, Checksum: checksum(prof)})
+		}
+	}
+	return act
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Get MDM Apple Profiles Job
+////////////////////////////////////////////////////////////////////////////////
+
+type getMDMAppleProfilesJobRequest struct {
+	JobID uint `url:"job_id"`
+}
+
+type getMDMAppleProfilesJobResponse struct {
+	Job *fleet.Job `json:"job,omitempty"`
+	Err error      `json:"error,omitempty"`
+}
+
+func (r getMDMAppleProfilesJobResponse) error() error { return r.Err }
+
+func getMDMAppleProfilesJobEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getMDMAppleProfilesJobRequest)
+	job, err := svc.GetMDMAppleProfilesJob(ctx, req.JobID)
+	if err != nil {
+		return getMDMAppleProfilesJobResponse{Err: err}, nil
+	}
+	return getMDMAppleProfilesJobResponse{Job: job}, nil
+}
+
+func (svc *Service) GetMDMAppleProfilesJob(ctx context.Context, jobID uint) (*fleet.Job, error) {
+	// first we perform a perform basic authz check
+	if err := svc.authz.Authorize(ctx, &fleet.Team{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	job, err := svc.ds.GetJob(ctx, jobID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	if job.Name != worker.MDMAppleBulkSetPendingName {
+		return nil, ctxerr.Wrap(ctx, &notFoundError{}, "job is not an mdm apple profiles job")
+	}
+
+	// the users that can update the profiles of the team can check the job that
+	// updates the hosts for those profiles.
+	var args struct {
+		TeamIDs []uint `json:"team_ids"`
+	}
+	if job.Args != nil {
+		if err := json.Unmarshal(*job.Args, &args); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "unmarshal job args")
+		}
+	}
+	var teamID *uint
+	if len(args.TeamIDs) == 1 && args.TeamIDs[0] != 0 {
+		teamID = &args.TeamIDs[0]
+	}
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleConfigProfile{TeamID: teamID}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Update MDM Apple Settings
+////////////////////////////////////////////////////////////////////////////////
+
+type updateMDMAppleSettingsRequest struct {
+	fleet.MDMAppleSettingsPayload
+}
+
+type updateMDMAppleSettingsResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r updateMDMAppleSettingsResponse) error() error { return r.Err }
+
+func (r updateMDMAppleSettingsResponse) Status() int { return http.StatusNoContent }
+
+// This endpoint is required because the UI must allow maintainers (in addition
+// to admins) to update some MDM Apple settings, while the update config/update
+// team endpoints only allow write access to admins.
+func updateMDMAppleSettingsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*updateMDMAppleSettingsRequest)
+	if err := svc.UpdateMDMAppleSettings(ctx, req.MDMAppleSettingsPayload); err != nil {
+		return updateMDMAppleSettingsResponse{Err: err}, nil
+	}
+	return updateMDMAppleSettingsResponse{}, nil
+}
+
+func (svc *Service) UpdateMDMAppleSettings(ctx context.Context, payload fleet.MDMAppleSettingsPayload) error {
+	// for now, assume all settings require premium (this is true for the first
+	// supported setting, enable_disk_encryption. Adjust as needed in the future
+	// if this is not always the case).
+	license, err := svc.License(ctx)
+	if err != nil {
+		svc.authz.SkipAuthorization(ctx) // so that the error message is not replaced by "forbidden"
+		return err
+	}
+	if !license.IsPremium() {
+		svc.authz.SkipAuthorization(ctx) // so that the error message is not replaced by "forbidden"
+		return ErrMissingLicense
+	}
+
+	if err := svc.authz.Authorize(ctx, payload, fleet.ActionWrite); err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+
+	if payload.TeamID != nil {
+		tm, err := svc.EnterpriseOverrides.TeamByIDOrName(ctx, payload.TeamID, nil)
+		if err != nil {
+			return err
+		}
+		return svc.EnterpriseOverrides.UpdateTeamMDMAppleSettings(ctx, tm, payload)
+	}
+	return svc.updateAppConfigMDMAppleSettings(ctx, payload)
+}
+
+func (svc *Service) updateAppConfigMDMAppleSettings(ctx context.Context, payload fleet.MDMAppleSettingsPayload) error {
+	ac, err := svc.AppConfigObfuscated(ctx)
+	if err != nil {
+		return err
+	}
+
+	var didUpdate, didUpdateMacOSDiskEncryption bool
+	if payload.EnableDiskEncryption != nil {
+		if ac.MDM.MacOSSettings.EnableDiskEncryption != *payload.EnableDiskEncryption {
+			ac.MDM.MacOSSettings.EnableDiskEncryption = *payload.EnableDiskEncryption
+			didUpdate = true
+			didUpdateMacOSDiskEncryption = true
+		}
+	}
+	if payload.AllowReservedPayloads != nil {
+		if ac.MDM.MacOSSettings.AllowReservedPayloads != *payload.AllowReservedPayloads {
+			ac.MDM.MacOSSettings.AllowReservedPayloads = *payload.AllowReservedPayloads
+			didUpdate = true
+		}
+	}
+
+	if didUpdate {
+		if err := svc.ds.SaveAppConfig(ctx, ac); err != nil {
+			return err
+		}
+		if didUpdateMacOSDiskEncryption {
+			var act fleet.ActivityDetails
+			if ac.MDM.MacOSSettings.EnableDiskEncryption {
+				act = fleet.ActivityTypeEnabledMacosDiskEncryption{}
+				if err := svc.EnterpriseOverrides.MDMAppleEnableFileVaultAndEscrow(ctx, nil); err != nil {
+					return ctxerr.Wrap(ctx, err, "enable no-team filevault and escrow")
+				}
+			} else {
+				act = fleet.ActivityTypeDisabledMacosDiskEncryption{}
+				if err := svc.EnterpriseOverrides.MDMAppleDisableFileVaultAndEscrow(ctx, nil); err != nil {
+					return ctxerr.Wrap(ctx, err, "disable no-team filevault and escrow")
+				}
+			}
+			if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), act); err != nil {
+				return ctxerr.Wrap(ctx, err, "create activity for app config macos disk encryption")
+			}
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Upload a bootstrap package
+////////////////////////////////////////////////////////////////////////////////
+
+type uploadBootstrapPackageRequest struct {
+	Package *multipart.FileHeader
+	TeamID  uint
+}
+
+type uploadBootstrapPackageResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+// TODO: We parse the whole body before running svc.authz.Authorize.
+// An authenticated but unauthorized user could abuse this.
+func (uploadBootstrapPackageRequest) DecodeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	decoded := uploadBootstrapPackageRequest{}
+	err := r.ParseMultipartForm(512 * units.MiB)
+	if err != nil {
+		return nil, &fleet.BadRequestError{
+			Message:     "failed to parse multipart form",
+			InternalErr: err,
+		}
+	}
+
+	if r.MultipartForm.File["package"] == nil {
+		return nil, &fleet.BadRequestError{
+			Message:     "package multipart field is required",
+			InternalErr: err,
+		}
+	}
+
+	decoded.Package = r.MultipartForm.File["package"][0]
+
+	// default is no team
+	decoded.TeamID = 0
+	val, ok := r.MultipartForm.Value["team_id"]
+	if ok && len(val) > 0 {
+		teamID, err := strconv.Atoi(val[0])
+		if err != nil {
+			return nil, &fleet.BadRequestError{Message: fmt.Sprintf("failed to decode team_id in multipart form: %s", err.Error())}
+		}
+		decoded.TeamID = uint(teamID)
+	}
+
+	return &decoded, nil
+}
+
+func (r uploadBootstrapPackageResponse) error() error { return r.Err }
+
+func uploadBootstrapPackageEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*uploadBootstrapPackageRequest)
+	ff, err := req.Package.Open()
+	if err != nil {
+		return uploadBootstrapPackageResponse{Err: err}, nil
+	}
+	defer ff.Close()
+
+	if err := svc.MDMAppleUploadBootstrapPackage(ctx, req.Package.Filename, ff, req.TeamID); err != nil {
+		return uploadBootstrapPackageResponse{Err: err}, nil
+	}
+	return &uploadBootstrapPackageResponse{}, nil
+}
+
+func (svc *Service) MDMAppleUploadBootstrapPackage(ctx context.Context, name string, pkg io.Reader, teamID uint) error {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
+
+	return fleet.ErrMissingLicense
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Download a bootstrap package
+////////////////////////////////////////////////////////////////////////////////
+
+type downloadBootstrapPackageRequest struct {
+	Token string `query:"token"`
+}
+
+type downloadBootstrapPackageResponse struct {
+	Err error `json:"error,omitempty"`
+
+	// fields used by hijackRender for the response.
+	pkg *fleet.MDMAppleBootstrapPackage
+}
+
+func (r downloadBootstrapPackageResponse) error() error { return r.Err }
+
+func (r downloadBootstrapPackageResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
+	if r.pkg.CDNURL != "" {
+		w.Header().Set("Location", r.pkg.CDNURL)
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+	if r.pkg.Content != nil {
+		defer r.pkg.Content.Close()
+		w.Header().Set("Content-Length", strconv.FormatInt(r.pkg.Size, 10))
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(r.pkg.Bytes)))
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment;filename="%s"`, r.pkg.Name))
+
+	// OK to just log the error here as writing anything on
+	// `http.ResponseWriter` sets the status code to 200 (and it can't be
+	// changed.) Clients should rely on matching content-length with the
+	// header provided
+	if r.pkg.Content != nil {
+		if n, err := io.Copy(w, r.pkg.Content); err != nil {
+			logging.WithExtras(ctx, "err", err, "bytes_copied", n)
+		}
+		return
+	}
+	if n, err := w.Write(r.pkg.Bytes); err != nil {
+		logging.WithExtras(ctx, "err", err, "bytes_copied", n)
+	}
 }
 
-func mdmAppleHeadInstallerEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*mdmAppleHeadInstallerRequest)
-	installer, err := svc.GetMDMAppleInstallerDetailsByToken(ctx, req.Token)
+func downloadBootstrapPackageEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*downloadBootstrapPackageRequest)
+	pkg, err := svc.GetMDMAppleBootstrapPackageBytes(ctx, req.Token)
 	if err != nil {
-		return mdmAppleGetInstallerResponse{Err: err}, nil
+		return downloadBootstrapPackageResponse{Err: err}, nil
 	}
-	return mdmAppleGetInstallerResponse{
-		head: true,
-		name: installer.Name,
-		size: installer.Size,
-	}, nil
+	return downloadBootstrapPackageResponse{pkg: pkg}, nil
 }
 
-func (svc *Service) GetMDMAppleInstallerDetailsByToken(ctx context.Context, token string) (*fleet.MDMAppleInstaller, error) {
-	// skipauth: The installer endpoint uses token authentication.
+func (svc *Service) GetMDMAppleBootstrapPackageBytes(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackage, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
 	svc.authz.SkipAuthorization(ctx)
 
-	installer, err := svc.ds.MDMAppleInstallerDetailsByToken(ctx, token)
-	if err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
-	}
-	return installer, nil
+	return nil, fleet.ErrMissingLicense
 }
 
-type listMDMAppleInstallersRequest struct{}
+////////////////////////////////////////////////////////////////////////////////
+// Get metadata about a bootstrap package
+////////////////////////////////////////////////////////////////////////////////
 
-type listMDMAppleInstallersResponse struct {
-	Installers []fleet.MDMAppleInstaller `json:"installers"`
-	Err        error                     `json:"error,omitempty"`
+type bootstrapPackageMetadataRequest struct {
+	TeamID uint `url:"team_id"`
 }
 
-func (r listMDMAppleInstallersResponse) error() error { return r.Err }
-
-func listMDMAppleInstallersEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	installers, err := svc.ListMDMAppleInstallers(ctx)
-	if err != nil {
-		return listMDMAppleInstallersResponse{
-			Err: err,
-		}, nil
-	}
-	return listMDMAppleInstallersResponse{
-		Installers: installers,
-	}, nil
+type bootstrapPackageMetadataResponse struct {
+	Err                             error `json:"error,omitempty"`
+	*fleet.MDMAppleBootstrapPackage `json:",omitempty"`
 }
 
-func (svc *Service) ListMDMAppleInstallers(ctx context.Context) ([]fleet.MDMAppleInstaller, error) {
-	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleInstaller{}, fleet.ActionWrite); err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
-	}
+func (r bootstrapPackageMetadataResponse) error() error { return r.Err }
 
-	appConfig, err := svc.ds.AppConfig(ctx)
+func bootstrapPackageMetadataEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*bootstrapPackageMetadataRequest)
+	meta, err := svc.GetMDMAppleBootstrapPackageMetadata(ctx, req.TeamID)
 	if err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
+		return bootstrapPackageMetadataResponse{Err: err}, nil
 	}
+	return bootstrapPackageMetadataResponse{MDMAppleBootstrapPackage: meta}, nil
+}
 
-	installers, err := svc.ds.ListMDMAppleInstallers(ctx)
-	if err != nil {
-		return nil, ctxerr.Wrap(ctx, err)
-	}
-	for i := range installers {
-		installers[i].URL = svc.installerURL(installers[i].URLToken, appConfig)
-	}
-	return installers, nil
+func (svc *Service) GetMDMAppleBootstrapPackageMetadata(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackage, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
+
+	return nil, fleet.ErrMissingLicense
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-// Lock a device
+// Delete a bootstrap package
 ////////////////////////////////////////////////////////////////////////////////
 
-type deviceLockRequest struct {
-	HostID uint `url:"id"`
+type deleteBootstrapPackageRequest struct {
+	TeamID uint `url:"team_id"`
 }
 
-type deviceLockResponse struct {
+type deleteBootstrapPackageResponse struct {
 	Err error `json:"error,omitempty"`
 }
 
-func (r deviceLockResponse) error() error { return r.Err }
-
-func (r deviceLockResponse) Status() int { return http.StatusNoContent }
+func (r deleteBootstrapPackageResponse) error() error { return r.Err }
 
-func deviceLockEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*deviceLockRequest)
-	err := svc.MDMAppleDeviceLock(ctx, req.HostID)
-	if err != nil {
-		return deviceLockResponse{Err: err}, nil
+func deleteBootstrapPackageEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*deleteBootstrapPackageRequest)
+	if err := svc.DeleteMDMAppleBootstrapPackage(ctx, &req.TeamID); err != nil {
+		return deleteBootstrapPackageResponse{Err: err}, nil
 	}
-	return deviceLockResponse{}, nil
+	return deleteBootstrapPackageResponse{}, nil
 }
 
-func (svc *Service) MDMAppleDeviceLock(ctx context.Context, hostID uint) error {
+func (svc *Service) DeleteMDMAppleBootstrapPackage(ctx context.Context, teamID *uint) error {
 	// skipauth: No authorization check needed due to implementation returning
 	// only license error.
 	svc.authz.SkipAuthorization(ctx)
@@ -1478,383 +5508,286 @@ func (svc *Service) MDMAppleDeviceLock(ctx context.Context, hostID uint) error {
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-// Wipe a device
+// Get aggregated summary about a team's bootstrap package
 ////////////////////////////////////////////////////////////////////////////////
 
-type deviceWipeRequest struct {
-	HostID uint `url:"id"`
+type getMDMAppleBootstrapPackageSummaryRequest struct {
+	TeamID *uint `query:"team_id,optional"`
 }
 
-type deviceWipeResponse struct {
+type getMDMAppleBootstrapPackageSummaryResponse struct {
+	fleet.MDMAppleBootstrapPackageSummary
 	Err error `json:"error,omitempty"`
 }
 
-func (r deviceWipeResponse) error() error { return r.Err }
-
-func (r deviceWipeResponse) Status() int { return http.StatusNoContent }
+func (r getMDMAppleBootstrapPackageSummaryResponse) error() error { return r.Err }
 
-func deviceWipeEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*deviceWipeRequest)
-	err := svc.MDMAppleEraseDevice(ctx, req.HostID)
+func getMDMAppleBootstrapPackageSummaryEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getMDMAppleBootstrapPackageSummaryRequest)
+	summary, err := svc.GetMDMAppleBootstrapPackageSummary(ctx, req.TeamID)
 	if err != nil {
-		return deviceWipeResponse{Err: err}, nil
+		return getMDMAppleBootstrapPackageSummaryResponse{Err: err}, nil
 	}
-	return deviceWipeResponse{}, nil
+	return getMDMAppleBootstrapPackageSummaryResponse{MDMAppleBootstrapPackageSummary: *summary}, nil
 }
 
-func (svc *Service) MDMAppleEraseDevice(ctx context.Context, hostID uint) error {
+func (svc *Service) GetMDMAppleBootstrapPackageSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleBootstrapPackageSummary, error) {
 	// skipauth: No authorization check needed due to implementation returning
 	// only license error.
 	svc.authz.SkipAuthorization(ctx)
 
-	return fleet.ErrMissingLicense
+	return &fleet.MDMAppleBootstrapPackageSummary{}, fleet.ErrMissingLicense
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-// Batch Replace MDM Apple Profiles
+// Get the status of the signing chains of the bootstrap packages
 ////////////////////////////////////////////////////////////////////////////////
 
-type batchSetMDMAppleProfilesRequest struct {
-	TeamID   *uint    `json:"-" query:"team_id,optional"`
-	TeamName *string  `json:"-" query:"team_name,optional"`
-	DryRun   bool     `json:"-" query:"dry_run,optional"` // if true, apply validation but do not save changes
-	Profiles [][]byte `json:"profiles"`
-}
-
-type batchSetMDMAppleProfilesResponse struct {
+type getMDMAppleBootstrapPackageSignaturesResponse struct {
+	fleet.MDMAppleBootstrapPackageSignaturesSummary
 	Err error `json:"error,omitempty"`
 }
 
-func (r batchSetMDMAppleProfilesResponse) error() error { return r.Err }
-
-func (r batchSetMDMAppleProfilesResponse) Status() int { return http.StatusNoContent }
-
-func batchSetMDMAppleProfilesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*batchSetMDMAppleProfilesRequest)
-	if err := svc.BatchSetMDMAppleProfiles(ctx, req.TeamID, req.TeamName, req.Profiles, req.DryRun); err != nil {
-		return batchSetMDMAppleProfilesResponse{Err: err}, nil
-	}
-	return batchSetMDMAppleProfilesResponse{}, nil
-}
-
-func (svc *Service) BatchSetMDMAppleProfiles(ctx context.Context, tmID *uint, tmName *string, profiles [][]byte, dryRun bool) error {
-	if tmID != nil && tmName != nil {
-		svc.authz.SkipAuthorization(ctx) // so that the error message is not replaced by "forbidden"
-		return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("team_name", "cannot specify both team_id and team_name"))
-	}
-	if tmID != nil || tmName != nil {
-		license, _ := license.FromContext(ctx)
-		if !license.IsPremium() {
-			field := "team_id"
-			if tmName != nil {
-				field = "team_name"
-			}
-			svc.authz.SkipAuthorization(ctx) // so that the error message is not replaced by "forbidden"
-			return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError(field, ErrMissingLicense.Error()))
-		}
-	}
-
-	// if the team name is provided, load the corresponding team to get its id.
-	// vice-versa, if the id is provided, load it to get the name (required for
-	// the activity).
-	if tmName != nil || tmID != nil {
-		tm, err := svc.EnterpriseOverrides.TeamByIDOrName(ctx, tmID, tmName)
-		if err != nil {
-			return err
-		}
-		if tmID == nil {
-			tmID = &tm.ID
-		} else {
-			tmName = &tm.Name
-		}
-	}
-
-	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleConfigProfile{TeamID: tmID}, fleet.ActionWrite); err != nil {
-		return ctxerr.Wrap(ctx, err)
-	}
+func (r getMDMAppleBootstrapPackageSignaturesResponse) error() error { return r.Err }
 
-	appCfg, err := svc.ds.AppConfig(ctx)
+func getMDMAppleBootstrapPackageSignaturesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	summary, err := svc.GetMDMAppleBootstrapPackageSignatures(ctx)
 	if err != nil {
-		return ctxerr.Wrap(ctx, err)
+		return getMDMAppleBootstrapPackageSignaturesResponse{Err: err}, nil
 	}
+	return getMDMAppleBootstrapPackageSignaturesResponse{MDMAppleBootstrapPackageSignaturesSummary: *summary}, nil
+}
 
-	if !appCfg.MDM.EnabledAndConfigured {
-		// NOTE: in order to prevent an error when Fleet MDM is not enabled but no
-		// profile is provided, which can happen if a user runs `fleetctl get
-		// config` and tries to apply that YAML, as it will contain an empty/null
-		// custom_settings key, we just return a success response in this
-		// situation.
-		if len(profiles) == 0 {
-			return nil
-		}
+func (svc *Service) GetMDMAppleBootstrapPackageSignatures(ctx context.Context) (*fleet.MDMAppleBootstrapPackageSignaturesSummary, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
 
-		return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("mdm", "cannot set custom settings: Fleet MDM is not configured"))
-	}
+	return nil, fleet.ErrMissingLicense
+}
 
-	// any duplicate identifier or name in the provided set results in an error
-	profs := make([]*fleet.MDMAppleConfigProfile, 0, len(profiles))
-	byName, byIdent := make(map[string]bool, len(profiles)), make(map[string]bool, len(profiles))
-	for i, prof := range profiles {
-		mdmProf, err := fleet.NewMDMAppleConfigProfile(prof, tmID)
-		if err != nil {
-			return ctxerr.Wrap(ctx,
-				fleet.NewInvalidArgumentError(fmt.Sprintf("profiles[%d]", i), err.Error()),
-				"invalid mobileconfig profile")
-		}
+////////////////////////////////////////////////////////////////////////////////
+// Get aggregated summary about a team's compliance with macOS updates
+////////////////////////////////////////////////////////////////////////////////
 
-		if err := mdmProf.ValidateUserProvided(); err != nil {
-			return ctxerr.Wrap(ctx,
-				fleet.NewInvalidArgumentError(fmt.Sprintf("profiles[%d]", i), err.Error()))
-		}
+type getMDMAppleOSUpdatesSummaryRequest struct {
+	TeamID *uint `query:"team_id,optional"`
+}
 
-		if byName[mdmProf.Name] {
-			return ctxerr.Wrap(ctx,
-				fleet.NewInvalidArgumentError(fmt.Sprintf("profiles[%d]", i), fmt.Sprintf("Couldn’t edit custom_settings. More than one configuration profile have the same name (PayloadDisplayName): %q", mdmProf.Name)),
-				"duplicate mobileconfig profile by name")
-		}
-		byName[mdmProf.Name] = true
+type getMDMAppleOSUpdatesSummaryResponse struct {
+	fleet.MDMAppleOSUpdatesSummary
+	Err error `json:"error,omitempty"`
+}
 
-		if byIdent[mdmProf.Identifier] {
-			return ctxerr.Wrap(ctx,
-				fleet.NewInvalidArgumentError(fmt.Sprintf("profiles[%d]", i), fmt.Sprintf("Couldn’t edit custom_settings. More than one configuration profile have the same identifier (PayloadIdentifier): %q", mdmProf.Identifier)),
-				"duplicate mobileconfig profile by identifier")
-		}
-		byIdent[mdmProf.Identifier] = true
+func (r getMDMAppleOSUpdatesSummaryResponse) error() error { return r.Err }
 
-		profs = append(profs, mdmProf)
+func getMDMAppleOSUpdatesSummaryEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getMDMAppleOSUpdatesSummaryRequest)
+	summary, err := svc.GetMDMAppleOSUpdatesSummary(ctx, req.TeamID)
+	if err != nil {
+		return getMDMAppleOSUpdatesSummaryResponse{Err: err}, nil
 	}
+	return getMDMAppleOSUpdatesSummaryResponse{MDMAppleOSUpdatesSummary: *summary}, nil
+}
 
-	if dryRun {
-		return nil
-	}
-	if err := svc.ds.BatchSetMDMAppleProfiles(ctx, tmID, profs); err != nil {
-		return err
-	}
-	var bulkTeamID uint
-	if tmID != nil {
-		bulkTeamID = *tmID
-	}
-	if err := svc.ds.BulkSetPendingMDMAppleHostProfiles(ctx, nil, []uint{bulkTeamID}, nil, nil); err != nil {
-		return ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
-	}
+func (svc *Service) GetMDMAppleOSUpdatesSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleOSUpdatesSummary, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
 
-	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), &fleet.ActivityTypeEditedMacosProfile{
-		TeamID:   tmID,
-		TeamName: tmName,
-	}); err != nil {
-		return ctxerr.Wrap(ctx, err, "logging activity for edited macos profile")
-	}
-	return nil
+	return &fleet.MDMAppleOSUpdatesSummary{}, fleet.ErrMissingLicense
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-// Update MDM Apple Settings
+// Initiate a resumable bootstrap package upload
 ////////////////////////////////////////////////////////////////////////////////
 
-type updateMDMAppleSettingsRequest struct {
-	fleet.MDMAppleSettingsPayload
-}
+// maxBootstrapPackageChunkSize is the maximum size of a chunk of a resumable
+// bootstrap package upload.
+const maxBootstrapPackageChunkSize = 32 * units.MiB
 
-type updateMDMAppleSettingsResponse struct {
-	Err error `json:"error,omitempty"`
+type initiateBootstrapPackageUploadRequest struct {
+	TeamID uint   `json:"team_id"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	// Sha256 is the hex-encoded sha256 checksum of the package.
+	Sha256 string `json:"sha256"`
 }
 
-func (r updateMDMAppleSettingsResponse) error() error { return r.Err }
-
-func (r updateMDMAppleSettingsResponse) Status() int { return http.StatusNoContent }
-
-// This endpoint is required because the UI must allow maintainers (in addition
-// to admins) to update some MDM Apple settings, while the update config/update
-// team endpoints only allow write access to admins.
-func updateMDMAppleSettingsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*updateMDMAppleSettingsRequest)
-	if err := svc.UpdateMDMAppleSettings(ctx, req.MDMAppleSettingsPayload); err != nil {
-		return updateMDMAppleSettingsResponse{Err: err}, nil
-	}
-	return updateMDMAppleSettingsResponse{}, nil
+type bootstrapPackageUploadResponse struct {
+	*fleet.MDMAppleBootstrapPackageUpload
+	Err error `json:"error,omitempty"`
 }
-
-func (svc *Service) UpdateMDMAppleSettings(ctx context.Context, payload fleet.MDMAppleSettingsPayload) error {
-	// for now, assume all settings require premium (this is true for the first
-	// supported setting, enable_disk_encryption. Adjust as needed in the future
-	// if this is not always the case).
-	license, err := svc.License(ctx)
+
+func (r bootstrapPackageUploadResponse) error() error { return r.Err }
+
+func initiateBootstrapPackageUploadEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*initiateBootstrapPackageUploadRequest)
+	checksum, err := hex.DecodeString(req.Sha256)
 	if err != nil {
-		svc.authz.SkipAuthorization(ctx) // so that the error message is not replaced by "forbidden"
-		return err
+		return bootstrapPackageUploadResponse{Err: badRequestErr("invalid sha256 checksum", err)}, nil
 	}
-	if !license.IsPremium() {
-		svc.authz.SkipAuthorization(ctx) // so that the error message is not replaced by "forbidden"
-		return ErrMissingLicense
+	upload, err := svc.InitiateMDMAppleBootstrapPackageUpload(ctx, req.TeamID, req.Name, req.Size, checksum)
+	if err != nil {
+		return bootstrapPackageUploadResponse{Err: err}, nil
 	}
+	return bootstrapPackageUploadResponse{MDMAppleBootstrapPackageUpload: upload}, nil
+}
 
-	if err := svc.authz.Authorize(ctx, payload, fleet.ActionWrite); err != nil {
-		return ctxerr.Wrap(ctx, err)
-	}
+func (svc *Service) InitiateMDMAppleBootstrapPackageUpload(ctx context.Context, teamID uint, name string, size int64, sha256 []byte) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
 
-	if payload.TeamID != nil {
-		tm, err := svc.EnterpriseOverrides.TeamByIDOrName(ctx, payload.TeamID, nil)
-		if err != nil {
-			return err
-		}
-		return svc.EnterpriseOverrides.UpdateTeamMDMAppleSettings(ctx, tm, payload)
-	}
-	return svc.updateAppConfigMDMAppleSettings(ctx, payload)
+	return nil, fleet.ErrMissingLicense
 }
 
-func (svc *Service) updateAppConfigMDMAppleSettings(ctx context.Context, payload fleet.MDMAppleSettingsPayload) error {
-	ac, err := svc.AppConfigObfuscated(ctx)
+////////////////////////////////////////////////////////////////////////////////
+// Get the state of a resumable bootstrap package upload
+////////////////////////////////////////////////////////////////////////////////
+
+type getBootstrapPackageUploadRequest struct {
+	Token string `url:"token"`
+}
+
+func getBootstrapPackageUploadEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getBootstrapPackageUploadRequest)
+	upload, err := svc.GetMDMAppleBootstrapPackageUpload(ctx, req.Token)
 	if err != nil {
-		return err
+		return bootstrapPackageUploadResponse{Err: err}, nil
 	}
+	return bootstrapPackageUploadResponse{MDMAppleBootstrapPackageUpload: upload}, nil
+}
 
-	var didUpdate, didUpdateMacOSDiskEncryption bool
-	if payload.EnableDiskEncryption != nil {
-		if ac.MDM.MacOSSettings.EnableDiskEncryption != *payload.EnableDiskEncryption {
-			ac.MDM.MacOSSettings.EnableDiskEncryption = *payload.EnableDiskEncryption
-			didUpdate = true
-			didUpdateMacOSDiskEncryption = true
-		}
-	}
+func (svc *Service) GetMDMAppleBootstrapPackageUpload(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
 
-	if didUpdate {
-		if err := svc.ds.SaveAppConfig(ctx, ac); err != nil {
-			return err
-		}
-		if didUpdateMacOSDiskEncryption {
-			var act fleet.ActivityDetails
-			if ac.MDM.MacOSSettings.EnableDiskEncryption {
-				act = fleet.ActivityTypeEnabledMacosDiskEncryption{}
-				if err := svc.EnterpriseOverrides.MDMAppleEnableFileVaultAndEscrow(ctx, nil); err != nil {
-					return ctxerr.Wrap(ctx, err, "enable no-team filevault and escrow")
-				}
-			} else {
-				act = fleet.ActivityTypeDisabledMacosDiskEncryption{}
-				if err := svc.EnterpriseOverrides.MDMAppleDisableFileVaultAndEscrow(ctx, nil); err != nil {
-					return ctxerr.Wrap(ctx, err, "disable no-team filevault and escrow")
-				}
-			}
-			if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), act); err != nil {
-				return ctxerr.Wrap(ctx, err, "create activity for app config macos disk encryption")
-			}
-		}
-	}
-	return nil
+	return nil, fleet.ErrMissingLicense
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-// Upload a bootstrap package
+// Append a chunk to a resumable bootstrap package upload
 ////////////////////////////////////////////////////////////////////////////////
 
-type uploadBootstrapPackageRequest struct {
-	Package *multipart.FileHeader
-	TeamID  uint
+type appendBootstrapPackageUploadRequest struct {
+	Token  string
+	Offset int64
+	Chunk  []byte
 }
 
-type uploadBootstrapPackageResponse struct {
-	Err error `json:"error,omitempty"`
-}
+func (appendBootstrapPackageUploadRequest) DecodeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var decoded appendBootstrapPackageUploadRequest
 
-// TODO: We parse the whole body before running svc.authz.Authorize.
-// An authenticated but unauthorized user could abuse this.
-func (uploadBootstrapPackageRequest) DecodeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
-	decoded := uploadBootstrapPackageRequest{}
-	err := r.ParseMultipartForm(512 * units.MiB)
+	token, err := stringFromRequest(r, "token")
 	if err != nil {
-		return nil, &fleet.BadRequestError{
-			Message:     "failed to parse multipart form",
-			InternalErr: err,
-		}
+		return nil, badRequestErr("stringFromRequest", err)
 	}
+	decoded.Token = token
 
-	if r.MultipartForm.File["package"] == nil {
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
 		return nil, &fleet.BadRequestError{
-			Message:     "package multipart field is required",
+			Message:     "offset query parameter is required and must be a non-negative integer",
 			InternalErr: err,
 		}
 	}
+	decoded.Offset = offset
 
-	decoded.Package = r.MultipartForm.File["package"][0]
-
-	// default is no team
-	decoded.TeamID = 0
-	val, ok := r.MultipartForm.Value["team_id"]
-	if ok && len(val) > 0 {
-		teamID, err := strconv.Atoi(val[0])
-		if err != nil {
-			return nil, &fleet.BadRequestError{Message: fmt.Sprintf("failed to decode team_id in multipart form: %s", err.Error())}
-		}
-		decoded.TeamID = uint(teamID)
+	chunk, err := io.ReadAll(io.LimitReader(r.Body, maxBootstrapPackageChunkSize+1))
+	if err != nil {
+		return nil, badRequestErr("failed to read chunk", err)
+	}
+	if len(chunk) == 0 {
+		return nil, badRequest("chunk is empty")
+	}
+	if len(chunk) > maxBootstrapPackageChunkSize {
+		return nil, badRequest(fmt.Sprintf("chunk is too large, the maximum size is %d bytes", maxBootstrapPackageChunkSize))
 	}
+	decoded.Chunk = chunk
 
 	return &decoded, nil
 }
 
-func (r uploadBootstrapPackageResponse) error() error { return r.Err }
-
-func uploadBootstrapPackageEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*uploadBootstrapPackageRequest)
-	ff, err := req.Package.Open()
+func appendBootstrapPackageUploadEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*appendBootstrapPackageUploadRequest)
+	upload, err := svc.AppendMDMAppleBootstrapPackageUpload(ctx, req.Token, req.Offset, req.Chunk)
 	if err != nil {
-		return uploadBootstrapPackageResponse{Err: err}, nil
-	}
-	defer ff.Close()
-
-	if err := svc.MDMAppleUploadBootstrapPackage(ctx, req.Package.Filename, ff, req.TeamID); err != nil {
-		return uploadBootstrapPackageResponse{Err: err}, nil
+		return bootstrapPackageUploadResponse{Err: err}, nil
 	}
-	return &uploadBootstrapPackageResponse{}, nil
+	return bootstrapPackageUploadResponse{MDMAppleBootstrapPackageUpload: upload}, nil
 }
 
-func (svc *Service) MDMAppleUploadBootstrapPackage(ctx context.Context, name string, pkg io.Reader, teamID uint) error {
+func (svc *Service) AppendMDMAppleBootstrapPackageUpload(ctx context.Context, token string, offset int64, chunk []byte) (*fleet.MDMAppleBootstrapPackageUpload, error) {
 	// skipauth: No authorization check needed due to implementation returning
 	// only license error.
 	svc.authz.SkipAuthorization(ctx)
 
-	return fleet.ErrMissingLicense
+	return nil, fleet.ErrMissingLicense
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-// Download a bootstrap package
+// Commit a resumable bootstrap package upload
 ////////////////////////////////////////////////////////////////////////////////
 
-type downloadBootstrapPackageRequest struct {
-	Token string `query:"token"`
+type commitBootstrapPackageUploadRequest struct {
+	Token string `url:"token"`
 }
 
-type downloadBootstrapPackageResponse struct {
+type commitBootstrapPackageUploadResponse struct {
 	Err error `json:"error,omitempty"`
+}
 
-	// fields used by hijackRender for the response.
-	pkg *fleet.MDMAppleBootstrapPackage
+func (r commitBootstrapPackageUploadResponse) error() error { return r.Err }
+
+func commitBootstrapPackageUploadEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*commitBootstrapPackageUploadRequest)
+	if err := svc.CommitMDMAppleBootstrapPackageUpload(ctx, req.Token); err != nil {
+		return commitBootstrapPackageUploadResponse{Err: err}, nil
+	}
+	return commitBootstrapPackageUploadResponse{}, nil
 }
 
-func (r downloadBootstrapPackageResponse) error() error { return r.Err }
+func (svc *Service) CommitMDMAppleBootstrapPackageUpload(ctx context.Context, token string) error {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
 
-func (r downloadBootstrapPackageResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
-	w.Header().Set("Content-Length", strconv.Itoa(len(r.pkg.Bytes)))
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment;filename="%s"`, r.pkg.Name))
+	return fleet.ErrMissingLicense
+}
 
-	// OK to just log the error here as writing anything on
-	// `http.ResponseWriter` sets the status code to 200 (and it can't be
-	// changed.) Clients should rely on matching content-length with the
-	// header provided
-	if n, err := w.Write(r.pkg.Bytes); err != nil {
-		logging.WithExtras(ctx, "err", err, "bytes_copied", n)
-	}
+////////////////////////////////////////////////////////////////////////////////
+// Create or update an MDM Apple Setup Assistant
+////////////////////////////////////////////////////////////////////////////////
+
+type createMDMAppleSetupAssistantRequest struct {
+	TeamID            *uint           `json:"team_id"`
+	Name              string          `json:"name"`
+	EnrollmentProfile json.RawMessage `json:"enrollment_profile"`
 }
 
-func downloadBootstrapPackageEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*downloadBootstrapPackageRequest)
-	pkg, err := svc.GetMDMAppleBootstrapPackageBytes(ctx, req.Token)
+type createMDMAppleSetupAssistantResponse struct {
+	fleet.MDMAppleSetupAssistant
+	Err error `json:"error,omitempty"`
+}
+
+func (r createMDMAppleSetupAssistantResponse) error() error { return r.Err }
+
+func createMDMAppleSetupAssistantEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*createMDMAppleSetupAssistantRequest)
+	asst, err := svc.SetOrUpdateMDMAppleSetupAssistant(ctx, &fleet.MDMAppleSetupAssistant{
+		TeamID:  req.TeamID,
+		Name:    req.Name,
+		Profile: req.EnrollmentProfile,
+	})
 	if err != nil {
-		return downloadBootstrapPackageResponse{Err: err}, nil
+		return createMDMAppleSetupAssistantResponse{Err: err}, nil
 	}
-	return downloadBootstrapPackageResponse{pkg: pkg}, nil
+	return createMDMAppleSetupAssistantResponse{MDMAppleSetupAssistant: *asst}, nil
 }
 
-func (svc *Service) GetMDMAppleBootstrapPackageBytes(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackage, error) {
+func (svc *Service) SetOrUpdateMDMAppleSetupAssistant(ctx context.Context, asst *fleet.MDMAppleSetupAssistant) (*fleet.MDMAppleSetupAssistant, error) {
 	// skipauth: No authorization check needed due to implementation returning
 	// only license error.
 	svc.authz.SkipAuthorization(ctx)
@@ -1863,30 +5796,30 @@ func (svc *Service) GetMDMAppleBootstrapPackageBytes(ctx context.Context, token
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-// Get metadata about a bootstrap package
+// Get the MDM Apple Setup Assistant
 ////////////////////////////////////////////////////////////////////////////////
 
-type bootstrapPackageMetadataRequest struct {
-	TeamID uint `url:"team_id"`
+type getMDMAppleSetupAssistantRequest struct {
+	TeamID *uint `query:"team_id,optional"`
 }
 
-type bootstrapPackageMetadataResponse struct {
-	Err                             error `json:"error,omitempty"`
-	*fleet.MDMAppleBootstrapPackage `json:",omitempty"`
+type getMDMAppleSetupAssistantResponse struct {
+	fleet.MDMAppleSetupAssistant
+	Err error `json:"error,omitempty"`
 }
 
-func (r bootstrapPackageMetadataResponse) error() error { return r.Err }
+func (r getMDMAppleSetupAssistantResponse) error() error { return r.Err }
 
-func bootstrapPackageMetadataEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*bootstrapPackageMetadataRequest)
-	meta, err := svc.GetMDMAppleBootstrapPackageMetadata(ctx, req.TeamID)
+func getMDMAppleSetupAssistantEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getMDMAppleSetupAssistantRequest)
+	asst, err := svc.GetMDMAppleSetupAssistant(ctx, req.TeamID)
 	if err != nil {
-		return bootstrapPackageMetadataResponse{Err: err}, nil
+		return getMDMAppleSetupAssistantResponse{Err: err}, nil
 	}
-	return bootstrapPackageMetadataResponse{MDMAppleBootstrapPackage: meta}, nil
+	return getMDMAppleSetupAssistantResponse{MDMAppleSetupAssistant: *asst}, nil
 }
 
-func (svc *Service) GetMDMAppleBootstrapPackageMetadata(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackage, error) {
+func (svc *Service) GetMDMAppleSetupAssistant(ctx context.Context, teamID *uint) (*fleet.MDMAppleSetupAssistant, error) {
 	// skipauth: No authorization check needed due to implementation returning
 	// only license error.
 	svc.authz.SkipAuthorization(ctx)
@@ -1895,28 +5828,29 @@ func (svc *Service) GetMDMAppleBootstrapPackageMetadata(ctx context.Context, tea
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-// Delete a bootstrap package
+// Delete an MDM Apple Setup Assistant
 ////////////////////////////////////////////////////////////////////////////////
 
-type deleteBootstrapPackageRequest struct {
-	TeamID uint `url:"team_id"`
+type deleteMDMAppleSetupAssistantRequest struct {
+	TeamID *uint `query:"team_id,optional"`
 }
 
-type deleteBootstrapPackageResponse struct {
+type deleteMDMAppleSetupAssistantResponse struct {
 	Err error `json:"error,omitempty"`
 }
 
-func (r deleteBootstrapPackageResponse) error() error { return r.Err }
+func (r deleteMDMAppleSetupAssistantResponse) error() error { return r.Err }
+func (r deleteMDMAppleSetupAssistantResponse) Status() int  { return http.StatusNoContent }
 
-func deleteBootstrapPackageEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*deleteBootstrapPackageRequest)
-	if err := svc.DeleteMDMAppleBootstrapPackage(ctx, &req.TeamID); err != nil {
-		return deleteBootstrapPackageResponse{Err: err}, nil
+func deleteMDMAppleSetupAssistantEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*deleteMDMAppleSetupAssistantRequest)
+	if err := svc.DeleteMDMAppleSetupAssistant(ctx, req.TeamID); err != nil {
+		return deleteMDMAppleSetupAssistantResponse{Err: err}, nil
 	}
-	return deleteBootstrapPackageResponse{}, nil
+	return deleteMDMAppleSetupAssistantResponse{}, nil
 }
 
-func (svc *Service) DeleteMDMAppleBootstrapPackage(ctx context.Context, teamID *uint) error {
+func (svc *Service) DeleteMDMAppleSetupAssistant(ctx context.Context, teamID *uint) error {
 	// skipauth: No authorization check needed due to implementation returning
 	// only license error.
 	svc.authz.SkipAuthorization(ctx)
@@ -1925,136 +5859,252 @@ func (svc *Service) DeleteMDMAppleBootstrapPackage(ctx context.Context, teamID *
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-// Get aggregated summary about a team's bootstrap package
+// Get the DEP profile override of a host
+////////////////////////////////////////////////////////////////////////////////
+
+type getHostMDMAppleDEPProfileOverrideRequest struct {
+	ID uint `url:"id"`
+}
+
+type getHostMDMAppleDEPProfileOverrideResponse struct {
+	HostID             uint                                  `json:"host_id"`
+	DEPProfileOverride *fleet.HostMDMAppleDEPProfileOverride `json:"dep_profile_override"`
+	Err                error                                 `json:"error,omitempty"`
+}
+
+func (r getHostMDMAppleDEPProfileOverrideResponse) error() error { return r.Err }
+
+func getHostMDMAppleDEPProfileOverrideEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getHostMDMAppleDEPProfileOverrideRequest)
+	override, err := svc.GetHostMDMAppleDEPProfileOverride(ctx, req.ID)
+	if err != nil {
+		return getHostMDMAppleDEPProfileOverrideResponse{Err: err}, nil
+	}
+	return getHostMDMAppleDEPProfileOverrideResponse{HostID: req.ID, DEPProfileOverride: override}, nil
+}
+
+func (svc *Service) GetHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint) (*fleet.HostMDMAppleDEPProfileOverride, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
+
+	return nil, fleet.ErrMissingLicense
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Set the DEP profile override of a host
+////////////////////////////////////////////////////////////////////////////////
+
+type setHostMDMAppleDEPProfileOverrideRequest struct {
+	ID         uint            `url:"id"`
+	DEPProfile json.RawMessage `json:"dep_profile"`
+}
+
+func setHostMDMAppleDEPProfileOverrideEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*setHostMDMAppleDEPProfileOverrideRequest)
+	override, err := svc.SetHostMDMAppleDEPProfileOverride(ctx, req.ID, req.DEPProfile)
+	if err != nil {
+		return getHostMDMAppleDEPProfileOverrideResponse{Err: err}, nil
+	}
+	return getHostMDMAppleDEPProfileOverrideResponse{HostID: req.ID, DEPProfileOverride: override}, nil
+}
+
+func (svc *Service) SetHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint, profile json.RawMessage) (*fleet.HostMDMAppleDEPProfileOverride, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
+
+	return nil, fleet.ErrMissingLicense
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Delete the DEP profile override of a host
 ////////////////////////////////////////////////////////////////////////////////
 
-type getMDMAppleBootstrapPackageSummaryRequest struct {
-	TeamID *uint `query:"team_id,optional"`
+type deleteHostMDMAppleDEPProfileOverrideRequest struct {
+	ID uint `url:"id"`
 }
 
-type getMDMAppleBootstrapPackageSummaryResponse struct {
-	fleet.MDMAppleBootstrapPackageSummary
+type deleteHostMDMAppleDEPProfileOverrideResponse struct {
 	Err error `json:"error,omitempty"`
 }
 
-func (r getMDMAppleBootstrapPackageSummaryResponse) error() error { return r.Err }
+func (r deleteHostMDMAppleDEPProfileOverrideResponse) error() error { return r.Err }
+func (r deleteHostMDMAppleDEPProfileOverrideResponse) Status() int  { return http.StatusNoContent }
 
-func getMDMAppleBootstrapPackageSummaryEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*getMDMAppleBootstrapPackageSummaryRequest)
-	summary, err := svc.GetMDMAppleBootstrapPackageSummary(ctx, req.TeamID)
-	if err != nil {
-		return getMDMAppleBootstrapPackageSummaryResponse{Err: err}, nil
+func deleteHostMDMAppleDEPProfileOverrideEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*deleteHostMDMAppleDEPProfileOverrideRequest)
+	if err := svc.DeleteHostMDMAppleDEPProfileOverride(ctx, req.ID); err != nil {
+		return deleteHostMDMAppleDEPProfileOverrideResponse{Err: err}, nil
 	}
-	return getMDMAppleBootstrapPackageSummaryResponse{MDMAppleBootstrapPackageSummary: *summary}, nil
+	return deleteHostMDMAppleDEPProfileOverrideResponse{}, nil
 }
 
-func (svc *Service) GetMDMAppleBootstrapPackageSummary(ctx context.Context, teamID *uint) (*fleet.MDMAppleBootstrapPackageSummary, error) {
+func (svc *Service) DeleteHostMDMAppleDEPProfileOverride(ctx context.Context, hostID uint) error {
 	// skipauth: No authorization check needed due to implementation returning
 	// only license error.
 	svc.authz.SkipAuthorization(ctx)
 
-	return &fleet.MDMAppleBootstrapPackageSummary{}, fleet.ErrMissingLicense
+	return fleet.ErrMissingLicense
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-// Create or update an MDM Apple Setup Assistant
+// Set the MDM action of a policy
 ////////////////////////////////////////////////////////////////////////////////
 
-type createMDMAppleSetupAssistantRequest struct {
-	TeamID            *uint           `json:"team_id"`
-	Name              string          `json:"name"`
-	EnrollmentProfile json.RawMessage `json:"enrollment_profile"`
+type setMDMApplePolicyActionRequest struct {
+	PolicyID           uint   `url:"policy_id"`
+	RemediationProfile []byte `json:"remediation_profile"`
+	CommandTemplate    string `json:"command_template"`
+	TeamIDs            []uint `json:"team_ids" premium:"true"`
 }
 
-type createMDMAppleSetupAssistantResponse struct {
-	fleet.MDMAppleSetupAssistant
-	Err error `json:"error,omitempty"`
+type setMDMApplePolicyActionResponse struct {
+	MDMAction *fleet.MDMApplePolicyAction `json:"mdm_action,omitempty"`
+	Err       error                       `json:"error,omitempty"`
 }
 
-func (r createMDMAppleSetupAssistantResponse) error() error { return r.Err }
+func (r setMDMApplePolicyActionResponse) error() error { return r.Err }
 
-func createMDMAppleSetupAssistantEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*createMDMAppleSetupAssistantRequest)
-	asst, err := svc.SetOrUpdateMDMAppleSetupAssistant(ctx, &fleet.MDMAppleSetupAssistant{
-		TeamID:  req.TeamID,
-		Name:    req.Name,
-		Profile: req.EnrollmentProfile,
-	})
+func setMDMApplePolicyActionEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*setMDMApplePolicyActionRequest)
+	action, err := svc.SetMDMApplePolicyAction(ctx, req.PolicyID, req.RemediationProfile, req.CommandTemplate, req.TeamIDs)
 	if err != nil {
-		return createMDMAppleSetupAssistantResponse{Err: err}, nil
+		return setMDMApplePolicyActionResponse{Err: err}, nil
 	}
-	return createMDMAppleSetupAssistantResponse{MDMAppleSetupAssistant: *asst}, nil
+	return setMDMApplePolicyActionResponse{MDMAction: action}, nil
 }
 
-func (svc *Service) SetOrUpdateMDMAppleSetupAssistant(ctx context.Context, asst *fleet.MDMAppleSetupAssistant) (*fleet.MDMAppleSetupAssistant, error) {
-	// skipauth: No authorization check needed due to implementation returning
-	// only license error.
-	svc.authz.SkipAuthorization(ctx)
+func (svc *Service) SetMDMApplePolicyAction(ctx context.Context, policyID uint, remediationProfile []byte, commandTemplate string, teamIDs []uint) (*fleet.MDMApplePolicyAction, error) {
+	policy, err := svc.authorizeMDMApplePolicyAction(ctx, policyID, fleet.ActionWrite)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil, fleet.ErrMissingLicense
+	if len(remediationProfile) == 0 && commandTemplate == "" {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("remediation_profile", "At least one of remediation_profile or command_template is required."))
+	}
+	if len(remediationProfile) > 0 {
+		cp, err := fleet.NewMDMAppleConfigProfile(remediationProfile, policy.TeamID)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("remediation_profile", fmt.Sprintf("Couldn’t parse the remediation profile: %s", err)))
+		}
+		if err := cp.ValidateUserProvided(); err != nil {
+			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("remediation_profile", err.Error()))
+		}
+	}
+	if commandTemplate != "" {
+		if err := apple_mdm.ValidatePolicyCommandTemplate(commandTemplate); err != nil {
+			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("command_template", fmt.Sprintf("Invalid command template: %s", err)))
+		}
+	}
+	if len(teamIDs) > 0 {
+		if policy.TeamID != nil {
+			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("team_ids", "Teams can only be set for the MDM action of a global policy."))
+		}
+		for _, tmID := range teamIDs {
+			if tmID == 0 {
+				// hosts in no team
+				continue
+			}
+			if _, err := svc.ds.Team(ctx, tmID); err != nil {
+				if fleet.IsNotFound(err) {
+					return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("team_ids", fmt.Sprintf("Team doesn’t exist: %d", tmID)))
+				}
+				return nil, ctxerr.Wrap(ctx, err, "get team")
+			}
+		}
+	}
+
+	if err := svc.ds.SetMDMApplePolicyAction(ctx, &fleet.MDMApplePolicyAction{
+		PolicyID:           policyID,
+		RemediationProfile: remediationProfile,
+		CommandTemplate:    commandTemplate,
+		TeamIDs:            teamIDs,
+	}); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "set policy mdm action")
+	}
+	return svc.ds.GetMDMApplePolicyAction(ctx, policyID)
+}
+
+// authorizeMDMApplePolicyAction authorizes the action on the MDM action of the
+// policy, which is subject to the same permissions as the policy itself. It
+// returns the policy.
+func (svc *Service) authorizeMDMApplePolicyAction(ctx context.Context, policyID uint, action string) (*fleet.Policy, error) {
+	policy, err := svc.ds.Policy(ctx, policyID)
+	if err != nil {
+		// the team of the policy is unknown, only report the error to users
+		// that can act on global policies.
+		if err := svc.authz.Authorize(ctx, &fleet.Policy{}, action); err != nil {
+			return nil, err
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get policy")
+	}
+	if err := svc.authz.Authorize(ctx, &fleet.Policy{PolicyData: fleet.PolicyData{TeamID: policy.TeamID}}, action); err != nil {
+		return nil, err
+	}
+	return policy, nil
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-// Get the MDM Apple Setup Assistant
+// Get the MDM action of a policy
 ////////////////////////////////////////////////////////////////////////////////
 
-type getMDMAppleSetupAssistantRequest struct {
-	TeamID *uint `query:"team_id,optional"`
+type getMDMApplePolicyActionRequest struct {
+	PolicyID uint `url:"policy_id"`
 }
 
-type getMDMAppleSetupAssistantResponse struct {
-	fleet.MDMAppleSetupAssistant
-	Err error `json:"error,omitempty"`
+type getMDMApplePolicyActionResponse struct {
+	MDMAction *fleet.MDMApplePolicyAction `json:"mdm_action,omitempty"`
+	Err       error                       `json:"error,omitempty"`
 }
 
-func (r getMDMAppleSetupAssistantResponse) error() error { return r.Err }
+func (r getMDMApplePolicyActionResponse) error() error { return r.Err }
 
-func getMDMAppleSetupAssistantEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*getMDMAppleSetupAssistantRequest)
-	asst, err := svc.GetMDMAppleSetupAssistant(ctx, req.TeamID)
+func getMDMApplePolicyActionEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getMDMApplePolicyActionRequest)
+	action, err := svc.GetMDMApplePolicyAction(ctx, req.PolicyID)
 	if err != nil {
-		return getMDMAppleSetupAssistantResponse{Err: err}, nil
+		return getMDMApplePolicyActionResponse{Err: err}, nil
 	}
-	return getMDMAppleSetupAssistantResponse{MDMAppleSetupAssistant: *asst}, nil
+	return getMDMApplePolicyActionResponse{MDMAction: action}, nil
 }
 
-func (svc *Service) GetMDMAppleSetupAssistant(ctx context.Context, teamID *uint) (*fleet.MDMAppleSetupAssistant, error) {
-	// skipauth: No authorization check needed due to implementation returning
-	// only license error.
-	svc.authz.SkipAuthorization(ctx)
-
-	return nil, fleet.ErrMissingLicense
+func (svc *Service) GetMDMApplePolicyAction(ctx context.Context, policyID uint) (*fleet.MDMApplePolicyAction, error) {
+	if _, err := svc.authorizeMDMApplePolicyAction(ctx, policyID, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+	return svc.ds.GetMDMApplePolicyAction(ctx, policyID)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-// Delete an MDM Apple Setup Assistant
+// Delete the MDM action of a policy
 ////////////////////////////////////////////////////////////////////////////////
 
-type deleteMDMAppleSetupAssistantRequest struct {
-	TeamID *uint `query:"team_id,optional"`
+type deleteMDMApplePolicyActionRequest struct {
+	PolicyID uint `url:"policy_id"`
 }
 
-type deleteMDMAppleSetupAssistantResponse struct {
+type deleteMDMApplePolicyActionResponse struct {
 	Err error `json:"error,omitempty"`
 }
 
-func (r deleteMDMAppleSetupAssistantResponse) error() error { return r.Err }
-func (r deleteMDMAppleSetupAssistantResponse) Status() int  { return http.StatusNoContent }
+func (r deleteMDMApplePolicyActionResponse) error() error { return r.Err }
+func (r deleteMDMApplePolicyActionResponse) Status() int  { return http.StatusNoContent }
 
-func deleteMDMAppleSetupAssistantEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
-	req := request.(*deleteMDMAppleSetupAssistantRequest)
-	if err := svc.DeleteMDMAppleSetupAssistant(ctx, req.TeamID); err != nil {
-		return deleteMDMAppleSetupAssistantResponse{Err: err}, nil
+func deleteMDMApplePolicyActionEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*deleteMDMApplePolicyActionRequest)
+	if err := svc.DeleteMDMApplePolicyAction(ctx, req.PolicyID); err != nil {
+		return deleteMDMApplePolicyActionResponse{Err: err}, nil
 	}
-	return deleteMDMAppleSetupAssistantResponse{}, nil
+	return deleteMDMApplePolicyActionResponse{}, nil
 }
 
-func (svc *Service) DeleteMDMAppleSetupAssistant(ctx context.Context, teamID *uint) error {
-	// skipauth: No authorization check needed due to implementation returning
-	// only license error.
-	svc.authz.SkipAuthorization(ctx)
-
-	return fleet.ErrMissingLicense
+func (svc *Service) DeleteMDMApplePolicyAction(ctx context.Context, policyID uint) error {
+	if _, err := svc.authorizeMDMApplePolicyAction(ctx, policyID, fleet.ActionWrite); err != nil {
+		return err
+	}
+	return svc.ds.DeleteMDMApplePolicyAction(ctx, policyID)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -2124,13 +6174,51 @@ func (r callbackMDMAppleSSOResponse) hijackRender(ctx context.Context, w http.Re
 
 func (r callbackMDMAppleSSOResponse) error() error { return r.Err }
 
+// callbackMDMAppleSSOErrorResponse renders the error page displayed to the
+// end user when the SSO callback fails, the error is still logged.
+type callbackMDMAppleSSOErrorResponse struct {
+	Err error `json:"error,omitempty"`
+
+	content string
+}
+
+func (r callbackMDMAppleSSOErrorResponse) error() error { return r.Err }
+
+// If html is present we return a web page
+func (r callbackMDMAppleSSOErrorResponse) html() string { return r.content }
+
+var mdmAppleSSOErrorPageTemplate = template.Must(template.New("mdmSSOError").Parse(`<!DOCTYPE html>
+<html lang="{{ .Language }}">
+  <head><meta charset="utf-8"></head>
+  <body>
+    <p>{{ .Message }}</p>
+  </body>
+</html>
+`))
+
 func callbackMDMAppleSSOEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
 	auth := request.(fleet.Auth)
 
 	// validate that the SSO response is valid
 	redirectURL, err := svc.InitiateMDMAppleSSOCallback(ctx, auth)
 	if err != nil {
-		return callbackMDMAppleSSOResponse{Err: err}, nil
+		// the end user is shown a localized error page, an error getting the
+		// translations falls back to the English message.
+		page := struct {
+			Language string
+			Message  string
+		}{Language: "en", Message: fleet.DefaultMDMSSOErrorMessage}
+		if strs, strsErr := svc.GetMDMAppleSSOEndUserStrings(ctx, auth); strsErr == nil {
+			if strs.Language != "" {
+				page.Language = strs.Language
+			}
+			page.Message = strs.SSOErrorMessage
+		}
+		var content bytes.Buffer
+		if tmplErr := mdmAppleSSOErrorPageTemplate.Execute(&content, page); tmplErr != nil {
+			return callbackMDMAppleSSOResponse{Err: err}, nil
+		}
+		return callbackMDMAppleSSOErrorResponse{Err: err, content: content.String()}, nil
 	}
 	return callbackMDMAppleSSOResponse{redirectURL: redirectURL}, nil
 }
@@ -2143,6 +6231,16 @@ func (svc *Service) InitiateMDMAppleSSOCallback(ctx context.Context, auth fleet.
 	return "", fleet.ErrMissingLicense
 }
 
+func (svc *Service) GetMDMAppleSSOEndUserStrings(ctx context.Context, auth fleet.Auth) (*fleet.MDMEndUserStrings, error) {
+	// skipauth: The strings are rendered to the end users, on pages that
+	// aren't authenticated.
+	svc.authz.SkipAuthorization(ctx)
+
+	// the end user can't authenticate without a license, the strings are the
+	// global ones.
+	return svc.GetMDMEndUserStrings(ctx, nil)
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // FileVault-related free version implementation
 ////////////////////////////////////////////////////////////////////////////////
@@ -2163,10 +6261,28 @@ type MDMAppleCheckinAndCommandService struct {
 	ds        fleet.Datastore
 	logger    kitlog.Logger
 	commander *apple_mdm.MDMAppleCommander
+	// scepCert is the certificate of the SCEP CA, the secrets reported by the
+	// hosts are stored encrypted with it.
+	scepCert *x509.Certificate
+}
+
+func NewMDMAppleCheckinAndCommandService(ds fleet.Datastore, commander *apple_mdm.MDMAppleCommander, scepCert *x509.Certificate, logger kitlog.Logger) *MDMAppleCheckinAndCommandService {
+	return &MDMAppleCheckinAndCommandService{ds: ds, commander: commander, scepCert: scepCert, logger: logger}
+}
+
+// loggerFor returns the service logger with the trace ID of the MDM request
+// that ctx belongs to, if any.
+func (svc *MDMAppleCheckinAndCommandService) loggerFor(ctx context.Context) kitlog.Logger {
+	return apple_mdm.LoggerWithTraceID(ctx, svc.logger)
 }
 
-func NewMDMAppleCheckinAndCommandService(ds fleet.Datastore, commander *apple_mdm.MDMAppleCommander, logger kitlog.Logger) *MDMAppleCheckinAndCommandService {
-	return &MDMAppleCheckinAndCommandService{ds: ds, commander: commander, logger: logger}
+// queueMDMWebhookEvent queues the delivery of an MDM event to the MDM events
+// webhook, if enabled. A failure to queue the event is logged but does not
+// fail the MDM request.
+func (svc *MDMAppleCheckinAndCommandService) queueMDMWebhookEvent(ctx context.Context, eventType fleet.MDMWebhookEventType, hostUUID string, details interface{}) {
+	if err := worker.QueueMDMWebhookEvent(ctx, svc.ds, svc.loggerFor(ctx), eventType, hostUUID, details); err != nil {
+		level.Error(svc.loggerFor(ctx)).Log("err", "queue MDM webhook event", "details", err, "event_type", eventType, "host_uuid", hostUUID)
+	}
 }
 
 // Authenticate handles MDM [Authenticate][1] requests.
@@ -2180,6 +6296,16 @@ func NewMDMAppleCheckinAndCommandService(ds fleet.Datastore, commander *apple_md
 //
 // [1]: https://developer.apple.com/documentation/devicemanagement/authenticate
 func (svc *MDMAppleCheckinAndCommandService) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	// the enrollment link is consumed before the host is created, so that a
+	// rejected enrollment doesn't leave a host behind.
+	var link *fleet.MDMAppleEnrollmentLink
+	if token := r.Params[apple_mdm.EnrollLinkKey]; token != "" {
+		var err error
+		if link, err = svc.consumeEnrollmentLink(r.Context, m.UDID, token); err != nil {
+			return err
+		}
+	}
+
 	host := fleet.MDMAppleHostDetails{}
 	host.SerialNumber = m.SerialNumber
 	host.UDID = m.UDID
@@ -2187,23 +6313,292 @@ func (svc *MDMAppleCheckinAndCommandService) Authenticate(r *mdm.Request, m *mdm
 	if err := svc.ds.IngestMDMAppleDeviceFromCheckin(r.Context, host); err != nil {
 		return err
 	}
+	if err := svc.ds.MarkHostMDMCheckedIn(r.Context, m.UDID, time.Now()); err != nil {
+		return err
+	}
+	if ref := r.Params[apple_mdm.EnrollReferenceKey]; ref != "" {
+		if err := svc.assignHostToIdPAccount(r.Context, m.UDID, ref); err != nil {
+			return err
+		}
+	}
+	if link != nil {
+		if err := svc.assignHostToEnrollmentLinkTeam(r.Context, m.UDID, link); err != nil {
+			return err
+		}
+	}
+	if token := r.Params[apple_mdm.EnrollTeamTokenKey]; token != "" {
+		if err := svc.assignHostToTokenTeam(r.Context, m.UDID, token); err != nil {
+			return err
+		}
+	}
 	info, err := svc.ds.GetHostMDMCheckinInfo(r.Context, m.Enrollment.UDID)
 	if err != nil {
 		return err
 	}
-	return svc.ds.NewActivity(r.Context, nil, &fleet.ActivityTypeMDMEnrolled{
+	flagged, err := svc.checkEnrollmentEligibility(r.Context, m, info)
+	if err != nil {
+		return err
+	}
+	if err := svc.ds.NewActivity(r.Context, nil, &fleet.ActivityTypeMDMEnrolled{
 		HostSerial:       info.HardwareSerial,
 		HostDisplayName:  info.DisplayName,
 		InstalledFromDEP: info.InstalledFromDEP,
+		MDMPlatform:      fleet.MDMPlatformApple,
+	}); err != nil {
+		return err
+	}
+	svc.queueMDMWebhookEvent(r.Context, fleet.MDMWebhookEventEnrolled, m.UDID, map[string]interface{}{
+		"serial_number":      m.SerialNumber,
+		"model":              m.Model,
+		"installed_from_dep": info.InstalledFromDEP,
+	})
+	if flagged {
+		return svc.holdIneligibleEnrollment(r.Context, m.UDID, info)
+	}
+	if !info.InstalledFromDEP {
+		return svc.requireManualEnrollmentApproval(r.Context, m.UDID, info)
+	}
+	return nil
+}
+
+// checkEnrollmentEligibility checks the hardware of the enrolling host against
+// the enrollment eligibility rules of its team. If the host is ineligible, it
+// is recorded as a blocked enrollment and, depending on the rules' action, an
+// error is returned to reject the enrollment or true is returned to flag it.
+func (svc *MDMAppleCheckinAndCommandService) checkEnrollmentEligibility(ctx context.Context, m *mdm.Authenticate, info *fleet.HostMDMCheckinInfo) (flagged bool, err error) {
+	if info.TeamID == 0 {
+		return false, nil
+	}
+	tm, err := svc.ds.Team(ctx, info.TeamID)
+	if err != nil {
+		return false, ctxerr.Wrap(ctx, err, "get team of enrolling host")
+	}
+	rules := tm.Config.MDM.MacOSEnrollmentEligibility
+	if !rules.IsSet() {
+		return false, nil
+	}
+
+	hw, ok := apple_mdm.MacHardwareFromModelIdentifier(m.Model)
+	if !ok {
+		svc.loggerFor(ctx).Log("info", "unknown hardware model, skipping enrollment eligibility check",
+			"host_uuid", m.UDID, "model", m.Model)
+		return false, nil
+	}
+	reason := rules.IneligibilityReason(hw.ModelYear, hw.Architecture)
+	if reason == "" {
+		if err := svc.ds.DeleteMDMAppleBlockedEnrollments(ctx, []string{m.SerialNumber}); err != nil {
+			return false, ctxerr.Wrap(ctx, err, "delete blocked enrollment of eligible host")
+		}
+		return false, nil
+	}
+
+	action := rules.EffectiveAction()
+	if err := svc.ds.UpsertMDMAppleBlockedEnrollment(ctx, &fleet.MDMAppleBlockedEnrollment{
+		SerialNumber:  m.SerialNumber,
+		HostUUID:      m.UDID,
+		TeamID:        &tm.ID,
+		HardwareModel: m.Model,
+		Source:        fleet.MDMAppleBlockedEnrollmentSourceEnrollment,
+		Action:        action,
+		Reason:        reason,
+	}); err != nil {
+		return false, ctxerr.Wrap(ctx, err, "record blocked enrollment")
+	}
+	svc.loggerFor(ctx).Log("info", "ineligible hardware enrolling", "host_uuid", m.UDID,
+		"model", m.Model, "action", action, "reason", reason)
+
+	if action == fleet.MacOSEnrollmentEligibilityActionFlag {
+		return true, nil
+	}
+	return false, ctxerr.Errorf(ctx, "enrollment blocked: host hardware is ineligible: %s", reason)
+}
+
+// holdIneligibleEnrollment holds the enrollment of a host flagged as
+// ineligible for approval, regardless of the manual enrollment approval
+// settings, so that it receives no profiles and no commands.
+func (svc *MDMAppleCheckinAndCommandService) holdIneligibleEnrollment(ctx context.Context, hostUUID string, info *fleet.HostMDMCheckinInfo) error {
+	queued, err := svc.ds.SetMDMAppleEnrollmentPendingApproval(ctx, hostUUID)
+	if err != nil {
+		return err
+	}
+	if !queued {
+		return nil
+	}
+	return svc.ds.NewActivity(ctx, nil, &fleet.ActivityTypeMDMEnrollmentPendingApproval{
+		HostSerial:      info.HardwareSerial,
+		HostDisplayName: info.DisplayName,
+	})
+}
+
+// requireManualEnrollmentApproval queues the manual enrollment of the host
+// for approval if the approval of manual enrollments is enabled. The host
+// receives no profiles and no commands until it is approved.
+func (svc *MDMAppleCheckinAndCommandService) requireManualEnrollmentApproval(ctx context.Context, hostUUID string, info *fleet.HostMDMCheckinInfo) error {
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if !appCfg.MDM.ManualEnrollmentApproval.Enable {
+		return nil
+	}
+
+	queued, err := svc.ds.SetMDMAppleEnrollmentPendingApproval(ctx, hostUUID)
+	if err != nil {
+		return err
+	}
+	if !queued {
+		return nil
+	}
+	svc.loggerFor(ctx).Log("info", "manual enrollment pending approval", "host_uuid", hostUUID)
+	return svc.ds.NewActivity(ctx, nil, &fleet.ActivityTypeMDMEnrollmentPendingApproval{
+		HostSerial:      info.HardwareSerial,
+		HostDisplayName: info.DisplayName,
 	})
 }
 
+// assignHostToIdPAccount records the end user that authenticated with the IdP
+// before enrolling the host, sets the IdP custom attributes and the assigned
+// user of the host, and transfers the host to the team of the first team rule
+// that matches the end user's IdP groups, including the groups synced via
+// SCIM.
+func (svc *MDMAppleCheckinAndCommandService) assignHostToIdPAccount(ctx context.Context, hostUUID, ref string) error {
+	acc, err := svc.ds.GetMDMIdPAccount(ctx, ref)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			svc.loggerFor(ctx).Log("info", "unknown enrollment reference, skipping end user assignment", "host_uuid", hostUUID)
+			return nil
+		}
+		return ctxerr.Wrap(ctx, err, "get MDM IdP account")
+	}
+	if err := svc.ds.AssociateHostMDMIdPAccount(ctx, hostUUID, acc.UUID); err != nil {
+		return ctxerr.Wrap(ctx, err, "associate host with MDM IdP account")
+	}
+
+	host, err := svc.ds.HostByIdentifier(ctx, hostUUID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get enrolling host")
+	}
+	idpAttrs := map[string]*string{
+		fleet.HostCustomAttributeIdPUsername: ptr.String(acc.Username),
+		fleet.HostCustomAttributeIdPFullName: ptr.String(acc.FullName),
+	}
+	if err := svc.ds.SetHostCustomAttributes(ctx, host.ID, idpAttrs, fleet.HostCustomAttributeSourceIdP); err != nil {
+		return ctxerr.Wrap(ctx, err, "set host IdP custom attributes")
+	}
+	// the IdP username is the email of the end user for the supported IdPs,
+	// the host is not assigned if it isn't.
+	if err := fleet.ValidateHostAssignedUser(acc.Username, acc.FullName); err != nil {
+		svc.loggerFor(ctx).Log("info", "end user username is not a valid email, skipping host assigned user", "host_uuid", hostUUID, "err", err)
+	} else {
+		assigned := &fleet.HostAssignedUser{Email: acc.Username, FullName: acc.FullName, Source: fleet.HostAssignedUserSourceSSO}
+		if err := svc.ds.SetHostAssignedUser(ctx, host.ID, assigned); err != nil {
+			return ctxerr.Wrap(ctx, err, "set host assigned user")
+		}
+	}
+
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get app config")
+	}
+	team, err := apple_mdm.EndUserTeam(ctx, svc.ds, appCfg, acc.Username, acc.Groups)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get team of matching team rule")
+	}
+	if team == nil {
+		return nil
+	}
+	if host.TeamID != nil && *host.TeamID == team.ID {
+		return nil
+	}
+	if err := transferHostsToTeam(ctx, svc.ds, svc.logger, nil, &team.ID, []uint{host.ID}); err != nil {
+		return ctxerr.Wrap(ctx, err, "transfer host to team of matching team rule")
+	}
+	svc.loggerFor(ctx).Log("info", "transferred enrolling host to team of matching team rule", "host_uuid", hostUUID, "team", team.Name)
+	return nil
+}
+
+// consumeEnrollmentLink records that the host enrolls with the enrollment
+// link. The enrollment is rejected if the link expired or reached its maximum
+// number of uses after the profile was downloaded, as the profile can be
+// installed on any number of devices.
+func (svc *MDMAppleCheckinAndCommandService) consumeEnrollmentLink(ctx context.Context, hostUUID, token string) (*fleet.MDMAppleEnrollmentLink, error) {
+	link, err := svc.ds.ConsumeMDMAppleEnrollmentLink(ctx, token, hostUUID)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			svc.loggerFor(ctx).Log("info", "enrollment link not found, expired or already used, rejecting enrollment", "host_uuid", hostUUID)
+			return nil, ctxerr.New(ctx, "enrollment rejected: the enrollment link expired or reached its maximum number of uses")
+		}
+		return nil, ctxerr.Wrap(ctx, err, "consume enrollment link")
+	}
+	return link, nil
+}
+
+// assignHostToEnrollmentLinkTeam transfers the host to the team of the
+// enrollment link it enrolled with, if any.
+func (svc *MDMAppleCheckinAndCommandService) assignHostToEnrollmentLinkTeam(ctx context.Context, hostUUID string, link *fleet.MDMAppleEnrollmentLink) error {
+	if link.TeamID == nil {
+		return nil
+	}
+
+	host, err := svc.ds.HostByIdentifier(ctx, hostUUID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get enrolling host")
+	}
+	if host.TeamID != nil && *host.TeamID == *link.TeamID {
+		return nil
+	}
+	if err := transferHostsToTeam(ctx, svc.ds, svc.logger, nil, link.TeamID, []uint{host.ID}); err != nil {
+		return ctxerr.Wrap(ctx, err, "transfer host to team of enrollment link")
+	}
+	svc.loggerFor(ctx).Log("info", "transferred enrolling host to team of enrollment link", "host_uuid", hostUUID, "link_id", link.ID)
+	return nil
+}
+
+// assignHostToTokenTeam transfers the host to the team of the reusable
+// enrollment profile it enrolled with.
+func (svc *MDMAppleCheckinAndCommandService) assignHostToTokenTeam(ctx context.Context, hostUUID, token string) error {
+	teamToken, err := svc.ds.GetMDMAppleTeamEnrollmentTokenByToken(ctx, token)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			// the token was rotated (or its team deleted) after the profile was
+			// downloaded, the host stays in its current team.
+			svc.loggerFor(ctx).Log("info", "team enrollment token not found, skipping team assignment", "host_uuid", hostUUID)
+			return nil
+		}
+		return ctxerr.Wrap(ctx, err, "get team enrollment token")
+	}
+
+	host, err := svc.ds.HostByIdentifier(ctx, hostUUID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get enrolling host")
+	}
+	if (host.TeamID == nil && teamToken.TeamID == nil) ||
+		(host.TeamID != nil && teamToken.TeamID != nil && *host.TeamID == *teamToken.TeamID) {
+		return nil
+	}
+	if err := transferHostsToTeam(ctx, svc.ds, svc.logger, nil, teamToken.TeamID, []uint{host.ID}); err != nil {
+		return ctxerr.Wrap(ctx, err, "transfer host to team of enrollment token")
+	}
+	svc.loggerFor(ctx).Log("info", "transferred enrolling host to team of enrollment token", "host_uuid", hostUUID)
+	return nil
+}
+
 // TokenUpdate handles MDM [TokenUpdate][1] requests.
 //
 // This method is executed after the request has been handled by nanomdm.
 //
 // [1]: https://developer.apple.com/documentation/devicemanagement/token_update
 func (svc *MDMAppleCheckinAndCommandService) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	if err := svc.ds.MarkHostMDMCheckedIn(r.Context, m.UDID, time.Now()); err != nil {
+		return err
+	}
+
+	// the device sent a (possibly new) push token, the push failures recorded
+	// for its enrollment don't apply anymore.
+	if err := svc.ds.ClearMDMApplePushFailures(r.Context, r.ID); err != nil {
+		return err
+	}
+
 	nanoEnroll, err := svc.ds.GetNanoMDMEnrollment(r.Context, r.ID)
 	if err != nil {
 		return err
@@ -2220,18 +6615,25 @@ func (svc *MDMAppleCheckinAndCommandService) TokenUpdate(r *mdm.Request, m *mdm.
 			return err
 		}
 		if info.InstalledFromDEP {
-			svc.logger.Log("info", "running post-enroll commands in newly enrolled DEP device", "host_uuid", r.ID)
+			svc.loggerFor(r.Context).Log("info", "running post-enroll commands in newly enrolled DEP device", "host_uuid", r.ID)
 			cmdUUID := uuid.New().String()
 			if err := svc.commander.InstallEnterpriseApplication(r.Context, []string{m.Enrollment.UDID}, cmdUUID, apple_mdm.FleetdPublicManifestURL); err != nil {
 				return err
 			}
-			svc.logger.Log("info", "sent command to install fleetd", "host_uuid", r.ID)
+			svc.loggerFor(r.Context).Log("info", "sent command to install fleetd", "host_uuid", r.ID)
+
+			// hosts enrolled via DEP are supervised, escrow their Activation Lock
+			// bypass code so that they can be activated after they are wiped.
+			if err := svc.commander.ActivationLockBypassCode(r.Context, []string{m.Enrollment.UDID}, uuid.New().String()); err != nil {
+				return err
+			}
+			svc.loggerFor(r.Context).Log("info", "sent command to get the activation lock bypass code", "host_uuid", r.ID)
 
 			meta, err := svc.ds.GetMDMAppleBootstrapPackageMeta(r.Context, info.TeamID)
 			if err != nil {
 				var nfe fleet.NotFoundError
 				if errors.As(err, &nfe) {
-					svc.logger.Log("info", "unable to find a bootstrap package for DEP enrolled device, skppping installation", "host_uuid", r.ID)
+					svc.loggerFor(r.Context).Log("info", "unable to find a bootstrap package for DEP enrolled device, skppping installation", "host_uuid", r.ID)
 					return nil
 				}
 
@@ -2243,6 +6645,11 @@ func (svc *MDMAppleCheckinAndCommandService) TokenUpdate(r *mdm.Request, m *mdm.
 				return err
 			}
 
+			if appCfg.MDM.BlockInvalidBootstrapPackages && meta.SignatureStatus == fleet.MDMAppleBootstrapPackageSignatureInvalid {
+				svc.loggerFor(r.Context).Log("info", "bootstrap package signature is invalid, skipping installation", "host_uuid", r.ID)
+				return nil
+			}
+
 			url, err := meta.URL(appCfg.ServerSettings.ServerURL)
 			if err != nil {
 				return err
@@ -2258,7 +6665,7 @@ func (svc *MDMAppleCheckinAndCommandService) TokenUpdate(r *mdm.Request, m *mdm.
 			if err != nil {
 				return err
 			}
-			svc.logger.Log("info", "sent command to install bootstrap package", "host_uuid", r.ID)
+			svc.loggerFor(r.Context).Log("info", "sent command to install bootstrap package", "host_uuid", r.ID)
 		}
 	}
 	return nil
@@ -2272,6 +6679,10 @@ func (svc *MDMAppleCheckinAndCommandService) TokenUpdate(r *mdm.Request, m *mdm.
 //
 // [1]: https://developer.apple.com/documentation/devicemanagement/check_out
 func (svc *MDMAppleCheckinAndCommandService) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	if err := svc.ds.MarkHostMDMCheckedIn(r.Context, m.UDID, time.Now()); err != nil {
+		return err
+	}
+
 	info, err := svc.ds.GetHostMDMCheckinInfo(r.Context, m.Enrollment.UDID)
 	if err != nil {
 		return err
@@ -2284,6 +6695,7 @@ func (svc *MDMAppleCheckinAndCommandService) CheckOut(r *mdm.Request, m *mdm.Che
 		HostSerial:       info.HardwareSerial,
 		HostDisplayName:  info.DisplayName,
 		InstalledFromDEP: info.InstalledFromDEP,
+		MDMPlatform:      fleet.MDMPlatformApple,
 	})
 }
 
@@ -2329,6 +6741,12 @@ func (svc *MDMAppleCheckinAndCommandService) DeclarativeManagement(*mdm.Request,
 //
 // [1]: https://developer.apple.com/documentation/devicemanagement/commands_and_queries
 func (svc *MDMAppleCheckinAndCommandService) CommandAndReportResults(r *mdm.Request, res *mdm.CommandResults) (*mdm.Command, error) {
+	// the device connects to fetch its next command (or report the result of
+	// the previous one), which counts as a check-in for the host.
+	if err := svc.ds.MarkHostMDMCheckedIn(r.Context, res.UDID, time.Now()); err != nil {
+		return nil, ctxerr.Wrap(r.Context, err, "mark host checked in")
+	}
+
 	// Sometimes we get results with Status == "Idle" which don't contain a command
 	// UUID and are not actionable anyways.
 	if res.CommandUUID == "" {
@@ -2342,28 +6760,267 @@ func (svc *MDMAppleCheckinAndCommandService) CommandAndReportResults(r *mdm.Requ
 	if err != nil {
 		return nil, ctxerr.Wrap(r.Context, err, "command service")
 	}
+	svc.queueMDMWebhookEvent(r.Context, fleet.MDMWebhookEventCommandResult, res.UDID, map[string]interface{}{
+		"command_uuid": res.CommandUUID,
+		"request_type": requestType,
+		"status":       res.Status,
+		"error":        apple_mdm.FmtErrorChain(res.ErrorChain),
+	})
 
 	switch requestType {
 	case "InstallProfile":
-		return nil, svc.ds.UpdateOrDeleteHostMDMAppleProfile(r.Context, &fleet.HostMDMAppleProfile{
-			CommandUUID:   res.CommandUUID,
-			HostUUID:      res.UDID,
-			Status:        fleet.MDMAppleDeliveryStatusFromCommandStatus(res.Status),
-			Detail:        apple_mdm.FmtErrorChain(res.ErrorChain),
-			OperationType: fleet.MDMAppleOperationTypeInstall,
-		})
+		if strings.HasPrefix(res.CommandUUID, fleet.MDMAppleServerURLMigrationCommandPrefix) {
+			return nil, svc.updateServerURLMigrationHostFromResults(r.Context, res)
+		}
+		if err := svc.updateHostProfileFromResults(r.Context, res, fleet.MDMAppleOperationTypeInstall); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(res.CommandUUID, fleet.MDMAppleProfileSecretsCommandPrefix) {
+			return nil, svc.redactProfileSecretsCommand(r.Context, res)
+		}
+		return nil, nil
 	case "RemoveProfile":
-		return nil, svc.ds.UpdateOrDeleteHostMDMAppleProfile(r.Context, &fleet.HostMDMAppleProfile{
-			CommandUUID:   res.CommandUUID,
-			HostUUID:      res.UDID,
-			Status:        fleet.MDMAppleDeliveryStatusFromCommandStatus(res.Status),
-			Detail:        apple_mdm.FmtErrorChain(res.ErrorChain),
-			OperationType: fleet.MDMAppleOperationTypeRemove,
-		})
+		return nil, svc.updateHostProfileFromResults(r.Context, res, fleet.MDMAppleOperationTypeRemove)
+	case "ActivationLockBypassCode":
+		return nil, svc.escrowActivationLockBypassCode(r.Context, res)
+	case "CertificateList":
+		return nil, svc.storeHostCertificates(r.Context, res)
+	case "ProfileList":
+		return nil, svc.verifyHostInstalledProfiles(r.Context, res)
 	}
 	return nil, nil
 }
 
+// updateHostProfileFromResults updates the status of the host's profile
+// targeted by the InstallProfile or RemoveProfile command. A failure with an
+// error code that is retryable according to the profile failure grace period
+// of the host's team is only reported as failed once the grace period is over,
+// the profile is queued to be applied again until then.
+func (svc *MDMAppleCheckinAndCommandService) updateHostProfileFromResults(ctx context.Context, res *mdm.CommandResults, opType fleet.MDMAppleOperationType) error {
+	profile := &fleet.HostMDMAppleProfile{
+		CommandUUID:   res.CommandUUID,
+		HostUUID:      res.UDID,
+		Status:        fleet.MDMAppleDeliveryStatusFromCommandStatus(res.Status),
+		Detail:        apple_mdm.FmtErrorChain(res.ErrorChain),
+		OperationType: opType,
+	}
+	if profile.Status == nil || *profile.Status != fleet.MDMAppleDeliveryFailed || profile.IgnoreMDMClientError() {
+		return svc.ds.UpdateOrDeleteHostMDMAppleProfile(ctx, profile)
+	}
+
+	gracePeriod, err := svc.profileFailureGracePeriod(ctx, res.UDID)
+	if err != nil {
+		return err
+	}
+	errorCodes := make([]int, 0, len(res.ErrorChain))
+	for _, e := range res.ErrorChain {
+		errorCodes = append(errorCodes, e.ErrorCode)
+	}
+	if !gracePeriod.IsRetryable(errorCodes) {
+		return svc.ds.UpdateOrDeleteHostMDMAppleProfile(ctx, profile)
+	}
+
+	failed, err := svc.ds.UpdateHostMDMAppleProfileRetryableFailure(ctx, profile, gracePeriod)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "update host profile retryable failure")
+	}
+	if !failed {
+		svc.loggerFor(ctx).Log("info", "profile failed with a retryable error, queued to be applied again", "host_uuid", res.UDID,
+			"command_uuid", res.CommandUUID, "detail", profile.Detail)
+	}
+	return nil
+}
+
+// redactProfileSecretsCommand replaces the profile installed by the
+// InstallProfile command, with the values of the secrets it references, by a
+// redacted value once all the hosts targeted by the command have reported a
+// result for it, so that the secrets are not kept in the database.
+func (svc *MDMAppleCheckinAndCommandService) redactProfileSecretsCommand(ctx context.Context, res *mdm.CommandResults) error {
+	if res.Status == fleet.MDMAppleStatusNotNow {
+		// the command is delivered again later on
+		return nil
+	}
+	redacted := apple_mdm.RedactedInstallProfileCommand(res.CommandUUID)
+	if err := svc.ds.RedactMDMAppleCommand(ctx, res.CommandUUID, redacted); err != nil {
+		return ctxerr.Wrap(ctx, err, "redact profile secrets command")
+	}
+	return nil
+}
+
+// updateServerURLMigrationHostFromResults records the result of the
+// InstallProfile command that redelivered the enrollment profile to the host
+// after a change of the server URL.
+func (svc *MDMAppleCheckinAndCommandService) updateServerURLMigrationHostFromResults(ctx context.Context, res *mdm.CommandResults) error {
+	var status fleet.MDMAppleServerURLMigrationHostStatus
+	switch res.Status {
+	case fleet.MDMAppleStatusAcknowledged:
+		status = fleet.MDMAppleServerURLMigrationHostAcknowledged
+	case fleet.MDMAppleStatusError, fleet.MDMAppleStatusCommandFormatError:
+		status = fleet.MDMAppleServerURLMigrationHostFailed
+	default:
+		// the command will be sent again, e.g. NotNow
+		return nil
+	}
+	return svc.ds.SetMDMAppleServerURLMigrationHostResult(ctx, res.UDID, res.CommandUUID, status, apple_mdm.FmtErrorChain(res.ErrorChain))
+}
+
+// profileFailureGracePeriod returns the profile failure grace period settings
+// of the host's team (or no team).
+func (svc *MDMAppleCheckinAndCommandService) profileFailureGracePeriod(ctx context.Context, hostUUID string) (fleet.MacOSProfileFailureGracePeriod, error) {
+	info, err := svc.ds.GetHostMDMCheckinInfo(ctx, hostUUID)
+	if err != nil {
+		return fleet.MacOSProfileFailureGracePeriod{}, ctxerr.Wrap(ctx, err, "get host mdm checkin info")
+	}
+	if info.TeamID != 0 {
+		tmMDM, err := svc.ds.TeamMDMConfig(ctx, info.TeamID)
+		if err != nil {
+			return fleet.MacOSProfileFailureGracePeriod{}, ctxerr.Wrap(ctx, err, "get team mdm config")
+		}
+		if tmMDM == nil {
+			return fleet.MacOSProfileFailureGracePeriod{}, nil
+		}
+		return tmMDM.MacOSSettings.ProfileFailureGracePeriod, nil
+	}
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return fleet.MacOSProfileFailureGracePeriod{}, ctxerr.Wrap(ctx, err, "get app config")
+	}
+	return appCfg.MDM.MacOSSettings.ProfileFailureGracePeriod, nil
+}
+
+// escrowActivationLockBypassCode stores the Activation Lock bypass code
+// returned by the host in the result of an ActivationLockBypassCode command.
+func (svc *MDMAppleCheckinAndCommandService) escrowActivationLockBypassCode(ctx context.Context, res *mdm.CommandResults) error {
+	if res.Status != fleet.MDMAppleStatusAcknowledged {
+		svc.loggerFor(ctx).Log("info", "activation lock bypass code command not acknowledged", "host_uuid", res.UDID, "status", res.Status,
+			"detail", apple_mdm.FmtErrorChain(res.ErrorChain))
+		return nil
+	}
+
+	var payload struct {
+		ActivationLockBypassCode string
+	}
+	if err := plist.Unmarshal(res.Raw, &payload); err != nil {
+		return ctxerr.Wrap(ctx, err, "unmarshal activation lock bypass code result")
+	}
+	if payload.ActivationLockBypassCode == "" {
+		// the host did not generate a code, e.g. it is not supervised.
+		svc.loggerFor(ctx).Log("info", "host did not return an activation lock bypass code", "host_uuid", res.UDID)
+		return nil
+	}
+	encrypted, err := apple_mdm.EncryptBase64WithCertificate([]byte(payload.ActivationLockBypassCode), svc.scepCert)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "encrypt activation lock bypass code")
+	}
+	if err := svc.ds.SetHostMDMActivationLockBypassCode(ctx, res.UDID, encrypted); err != nil {
+		return ctxerr.Wrap(ctx, err, "escrow activation lock bypass code")
+	}
+	// the code is only kept encrypted, remove it from the result stored by
+	// nanomdm.
+	redacted := bytes.ReplaceAll(res.Raw, []byte(payload.ActivationLockBypassCode), []byte(fleet.MDMAppleRedactedValue))
+	if err := svc.ds.RedactMDMAppleCommandResult(ctx, res.UDID, res.CommandUUID, redacted); err != nil {
+		return ctxerr.Wrap(ctx, err, "redact activation lock bypass code command result")
+	}
+	svc.queueMDMWebhookEvent(ctx, fleet.MDMWebhookEventKeyEscrowed, res.UDID, map[string]interface{}{
+		"key_type": "activation_lock_bypass_code",
+	})
+	return nil
+}
+
+// storeHostCertificates stores the list of certificates returned by the host
+// in the result of a CertificateList command. The command UUID tells if it is
+// the list of all the certificates installed on the host or only of those
+// installed by MDM payloads.
+func (svc *MDMAppleCheckinAndCommandService) storeHostCertificates(ctx context.Context, res *mdm.CommandResults) error {
+	if res.Status != fleet.MDMAppleStatusAcknowledged {
+		svc.loggerFor(ctx).Log("info", "certificate list command not acknowledged", "host_uuid", res.UDID, "status", res.Status,
+			"detail", apple_mdm.FmtErrorChain(res.ErrorChain))
+		return nil
+	}
+
+	certs, err := apple_mdm.ParseCertificateListResult(res.Raw)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "parse certificate list result")
+	}
+
+	switch {
+	case strings.HasPrefix(res.CommandUUID, fleet.MDMAppleCertificateListAllCommandPrefix):
+		return ctxerr.Wrap(ctx, svc.ds.ReplaceHostMDMAppleCertificates(ctx, res.UDID, certs), "replace host certificates")
+	case strings.HasPrefix(res.CommandUUID, fleet.MDMAppleCertificateListManagedCommandPrefix):
+		return ctxerr.Wrap(ctx, svc.ds.UpdateHostMDMAppleManagedCertificates(ctx, res.UDID, certs), "update host managed certificates")
+	default:
+		svc.loggerFor(ctx).Log("info", "ignoring result of certificate list command not sent by fleet", "host_uuid", res.UDID,
+			"command_uuid", res.CommandUUID)
+		return nil
+	}
+}
+
+// verifyHostInstalledProfiles records the PayloadUUIDs of the profiles
+// reported installed by the host in the result of a ProfileList command, and
+// queues the reinstallation of the profiles for which the host runs a stale
+// version, i.e. a PayloadUUID different from the one of the current version of
+// the profile (e.g. because the profile was edited and the new version was
+// never installed).
+func (svc *MDMAppleCheckinAndCommandService) verifyHostInstalledProfiles(ctx context.Context, res *mdm.CommandResults) error {
+	if !strings.HasPrefix(res.CommandUUID, fleet.MDMAppleProfileListCommandPrefix) {
+		svc.loggerFor(ctx).Log("info", "ignoring result of profile list command not sent by fleet", "host_uuid", res.UDID,
+			"command_uuid", res.CommandUUID)
+		return nil
+	}
+	if res.Status != fleet.MDMAppleStatusAcknowledged {
+		svc.loggerFor(ctx).Log("info", "profile list command not acknowledged", "host_uuid", res.UDID, "status", res.Status,
+			"detail", apple_mdm.FmtErrorChain(res.ErrorChain))
+		return nil
+	}
+
+	installed, err := apple_mdm.ParseProfileListResult(res.Raw)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "parse profile list result")
+	}
+
+	profiles, err := svc.ds.ListHostMDMAppleProfileInstalls(ctx, res.UDID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list host profile installs")
+	}
+
+	payloadUUIDs := make(map[uint]string, len(profiles))
+	var stale []uint
+	for _, p := range profiles {
+		installedUUID, ok := installed[p.ProfileIdentifier]
+		if !ok {
+			continue
+		}
+		payloadUUIDs[p.ProfileID] = installedUUID
+
+		// only the profiles that Fleet considers installed are verified, the
+		// others are either being installed or failed to install.
+		if p.Status == nil || *p.Status != fleet.MDMAppleDeliveryVerifying {
+			continue
+		}
+		parsed, err := p.Mobileconfig.ParseConfigProfile()
+		if err != nil {
+			svc.loggerFor(ctx).Log("info", "cannot parse profile to verify its installed version", "host_uuid", res.UDID,
+				"profile_id", p.ProfileID, "err", err)
+			continue
+		}
+		if parsed.PayloadUUID != "" && parsed.PayloadUUID != installedUUID {
+			stale = append(stale, p.ProfileID)
+		}
+	}
+
+	if err := svc.ds.UpdateHostMDMAppleProfilesInstalledPayloadUUIDs(ctx, res.UDID, payloadUUIDs); err != nil {
+		return ctxerr.Wrap(ctx, err, "update host installed payload uuids")
+	}
+	if len(stale) > 0 {
+		svc.loggerFor(ctx).Log("info", "host runs stale versions of profiles, reinstalling", "host_uuid", res.UDID,
+			"profile_ids", fmt.Sprint(stale))
+		if err := svc.ds.SetHostMDMAppleProfilesToReinstall(ctx, res.UDID, stale); err != nil {
+			return ctxerr.Wrap(ctx, err, "set host profiles to reinstall")
+		}
+	}
+	return nil
+}
+
 // ensureFleetdConfig ensures there's a fleetd configuration profile in
 // mdm_apple_configuration_profiles for each team and for "no team"
 //
@@ -2404,18 +7061,12 @@ func ensureFleetdConfig(ctx context.Context, ds fleet.Datastore, logger kitlog.L
 			es.Secret = globalSecret
 		}
 
-		var contents bytes.Buffer
-		params := mobileconfig.FleetdProfileOptions{
-			EnrollSecret: es.Secret,
-			ServerURL:    appCfg.ServerSettings.ServerURL,
-			PayloadType:  mobileconfig.FleetdConfigPayloadIdentifier,
-		}
-
-		if err := mobileconfig.FleetdProfileTemplate.Execute(&contents, params); err != nil {
+		contents, err := generateFleetdProfile(appCfg.ServerSettings.ServerURL, es.Secret)
+		if err != nil {
 			return ctxerr.Wrap(ctx, err, "executing fleetd config template")
 		}
 
-		cp, err := fleet.NewMDMAppleConfigProfile(contents.Bytes(), es.TeamID)
+		cp, err := fleet.NewMDMAppleConfigProfile(contents, es.TeamID)
 		if err != nil {
 			return ctxerr.Wrap(ctx, err, "building configuration profile")
 		}
@@ -2431,16 +7082,314 @@ func ensureFleetdConfig(ctx context.Context, ds fleet.Datastore, logger kitlog.L
 	return nil
 }
 
+// generateFleetdProfile returns the fleetd configuration profile that
+// configures fleetd to enroll to the server with the enroll secret.
+func generateFleetdProfile(serverURL, enrollSecret string) ([]byte, error) {
+	var contents bytes.Buffer
+	params := mobileconfig.FleetdProfileOptions{
+		EnrollSecret: enrollSecret,
+		ServerURL:    serverURL,
+		PayloadType:  mobileconfig.FleetdConfigPayloadIdentifier,
+	}
+	if err := mobileconfig.FleetdProfileTemplate.Execute(&contents, params); err != nil {
+		return nil, err
+	}
+	return contents.Bytes(), nil
+}
+
+// mdmAppleDuplicateCommandsSuppressed counts the profile commands that were
+// not enqueued because an identical command was still queued for the host.
+var mdmAppleDuplicateCommandsSuppressed = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "mdm_apple",
+		Name:      "duplicate_commands_suppressed_total",
+		Help:      "Total number of MDM profile commands not enqueued because an identical command was still queued for the host.",
+	},
+	[]string{"operation"},
+)
+
+func init() {
+	prometheus.MustRegister(mdmAppleDuplicateCommandsSuppressed)
+}
+
+// suppressDuplicateProfileCommands returns the profiles for which a command
+// must be enqueued, and the host profiles of the others, which already have
+// an identical command (same host, operation and profile checksum) queued.
+// Those keep the queued command instead of getting a duplicate one.
+func suppressDuplicateProfileCommands(
+	ctx context.Context,
+	ds fleet.Datastore,
+	op fleet.MDMAppleOperationType,
+	profiles []*fleet.MDMAppleProfilePayload,
+) ([]*fleet.MDMAppleProfilePayload, []*fleet.MDMAppleBulkUpsertHostProfilePayload, error) {
+	if len(profiles) == 0 {
+		return profiles, nil, nil
+	}
+
+	hostUUIDs := make([]string, 0, len(profiles))
+	seen := make(map[string]bool, len(profiles))
+	for _, p := range profiles {
+		if !seen[p.HostUUID] {
+			seen[p.HostUUID] = true
+			hostUUIDs = append(hostUUIDs, p.HostUUID)
+		}
+	}
+	queued, err := ds.ListMDMAppleHostProfilesWithQueuedCommand(ctx, op, hostUUIDs)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list profiles with queued command")
+	}
+	if len(queued) == 0 {
+		return profiles, nil, nil
+	}
+
+	dedupKey := func(p *fleet.MDMAppleProfilePayload) string {
+		return p.HostUUID + "\x00" + string(p.Checksum)
+	}
+	queuedCmds := make(map[string]string, len(queued))
+	for _, p := range queued {
+		queuedCmds[dedupKey(p)] = p.CommandUUID
+	}
+
+	toEnqueue := make([]*fleet.MDMAppleProfilePayload, 0, len(profiles))
+	var suppressed []*fleet.MDMAppleBulkUpsertHostProfilePayload
+	for _, p := range profiles {
+		cmdUUID, ok := queuedCmds[dedupKey(p)]
+		if !ok {
+			toEnqueue = append(toEnqueue, p)
+			continue
+		}
+		suppressed = append(suppressed, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+			ProfileID:         p.ProfileID,
+			HostUUID:          p.HostUUID,
+			OperationType:     op,
+			Status:            &fleet.MDMAppleDeliveryPending,
+			CommandUUID:       cmdUUID,
+			ProfileIdentifier: p.ProfileIdentifier,
+			ProfileName:       p.ProfileName,
+			Checksum:          p.Checksum,
+		})
+	}
+	mdmAppleDuplicateCommandsSuppressed.WithLabelValues(string(op)).Add(float64(len(suppressed)))
+	return toEnqueue, suppressed, nil
+}
+
+// holdUnapprovedProfileChanges returns the profiles to install and remove
+// without those of the changes that must be approved and are not, if the
+// approval of profile changes is enabled. A change is the installation or
+// removal of a version of a profile, and it must be approved if it affects
+// more hosts than the configured threshold. The hosts of the changes not
+// approved are left untouched, so that they are computed again by the next
+// runs.
+func holdUnapprovedProfileChanges(
+	ctx context.Context,
+	ds fleet.Datastore,
+	logger kitlog.Logger,
+	toInstall, toRemove []*fleet.MDMAppleProfilePayload,
+) ([]*fleet.MDMAppleProfilePayload, []*fleet.MDMAppleProfilePayload, error) {
+	appCfg, err := ds.AppConfig(ctx)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "get app config")
+	}
+	approvalCfg := appCfg.MDM.ProfileChangeApproval
+	if !approvalCfg.Enable {
+		return toInstall, toRemove, nil
+	}
+
+	if err := ds.ExpireMDMAppleProfileChanges(ctx); err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "expire profile changes")
+	}
+
+	changeKey := func(op fleet.MDMAppleOperationType, p *fleet.MDMAppleProfilePayload) string {
+		return fmt.Sprintf("%s\x00%d\x00%s", op, p.ProfileID, p.Checksum)
+	}
+	changesByKey := make(map[string]*fleet.MDMAppleProfileChange)
+	var changes []*fleet.MDMAppleProfileChange
+	countHosts := func(op fleet.MDMAppleOperationType, profiles []*fleet.MDMAppleProfilePayload) {
+		for _, p := range profiles {
+			c := changesByKey[changeKey(op, p)]
+			if c == nil {
+				c = &fleet.MDMAppleProfileChange{
+					ProfileID:         p.ProfileID,
+					ProfileIdentifier: p.ProfileIdentifier,
+					ProfileName:       p.ProfileName,
+					Checksum:          p.Checksum,
+					OperationType:     op,
+				}
+				changesByKey[changeKey(op, p)] = c
+				changes = append(changes, c)
+			}
+			c.HostCount++
+		}
+	}
+	countHosts(fleet.MDMAppleOperationTypeInstall, toInstall)
+	countHosts(fleet.MDMAppleOperationTypeRemove, toRemove)
+
+	var gated []*fleet.MDMAppleProfileChange
+	for _, c := range changes {
+		if c.HostCount > approvalCfg.HostThreshold {
+			gated = append(gated, c)
+		}
+	}
+	if len(gated) == 0 {
+		return toInstall, toRemove, nil
+	}
+
+	expiresAt := time.Now().UTC().Add(approvalCfg.Expiry.ValueOr(fleet.DefaultMDMProfileChangeApprovalExpiry))
+	created, err := ds.RequestMDMAppleProfileChanges(ctx, gated, expiresAt)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "request profile changes approval")
+	}
+	for _, c := range created {
+		level.Info(logger).Log("msg", "profile change pending approval", "change_id", c.ID,
+			"profile_id", c.ProfileID, "operation", c.OperationType, "host_count", c.HostCount)
+		if err := ds.NewActivity(ctx, nil, &fleet.ActivityTypeMDMProfileChangePendingApproval{
+			ChangeID:          c.ID,
+			ProfileID:         c.ProfileID,
+			ProfileName:       c.ProfileName,
+			ProfileIdentifier: c.ProfileIdentifier,
+			OperationType:     c.OperationType,
+			HostCount:         c.HostCount,
+		}); err != nil {
+			return nil, nil, ctxerr.Wrap(ctx, err, "create activity for profile change pending approval")
+		}
+	}
+
+	var held int
+	filter := func(op fleet.MDMAppleOperationType, profiles []*fleet.MDMAppleProfilePayload) []*fleet.MDMAppleProfilePayload {
+		kept := profiles[:0]
+		for _, p := range profiles {
+			c := changesByKey[changeKey(op, p)]
+			if c.HostCount > approvalCfg.HostThreshold && c.Status != fleet.MDMAppleProfileChangeApproved {
+				held++
+				continue
+			}
+			kept = append(kept, p)
+		}
+		return kept
+	}
+	toInstall = filter(fleet.MDMAppleOperationTypeInstall, toInstall)
+	toRemove = filter(fleet.MDMAppleOperationTypeRemove, toRemove)
+	if held > 0 {
+		level.Info(logger).Log("msg", "held profile commands pending approval", "count", held)
+	}
+	return toInstall, toRemove, nil
+}
+
+// resolveProfileSecrets reads the secrets referenced by the profiles from the
+// secrets manager and updates the checksum of the versions of the secrets of
+// each profile, which is part of the checksum of the profile delivered to the
+// hosts. It returns the values of the secrets and the errors of the secrets
+// that can't be read, keyed by secret name. The checksum of a profile with a
+// secret that can't be read is left as-is.
+func resolveProfileSecrets(
+	ctx context.Context,
+	ds fleet.Datastore,
+	secretStore secrets.Store,
+	logger kitlog.Logger,
+) (map[string]string, map[string]error, error) {
+	values, errs := make(map[string]string), make(map[string]error)
+	if secretStore == nil {
+		return values, errs, nil
+	}
+
+	profiles, err := ds.ListMDMAppleProfilesReferencingSecrets(ctx)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list profiles referencing secrets")
+	}
+	versions := make(map[string]string)
+	for pid, contents := range profiles {
+		names := apple_mdm.ProfileSecretNames(contents)
+		if len(names) == 0 {
+			continue
+		}
+		sum := md5.New() //nolint:gosec
+		resolved := true
+		for _, name := range names {
+			if _, ok := values[name]; !ok && errs[name] == nil {
+				secret, err := secretStore.GetSecret(ctx, name)
+				if err != nil {
+					errs[name] = err
+					level.Error(logger).Log("msg", "resolve profile secret", "secret", name, "err", err)
+				} else {
+					values[name], versions[name] = secret.Value, secret.Version
+				}
+			}
+			if errs[name] != nil {
+				resolved = false
+				break
+			}
+			fmt.Fprintf(sum, "%s\x00%s\n", name, versions[name])
+		}
+		if !resolved {
+			continue
+		}
+		if err := ds.SetMDMAppleProfileSecretsChecksum(ctx, pid, sum.Sum(nil)); err != nil {
+			return nil, nil, ctxerr.Wrap(ctx, err, "set profile secrets checksum")
+		}
+	}
+	return values, errs, nil
+}
+
+// retryFailedProfiles applies the profile retry policy of each team, and of
+// the hosts in no team, to their failed profiles. The errors are logged and
+// don't prevent the reconciliation of the other profiles.
+func retryFailedProfiles(ctx context.Context, ds fleet.Datastore, logger kitlog.Logger) {
+	appCfg, err := ds.AppConfig(ctx)
+	if err != nil {
+		level.Error(logger).Log("msg", "get app config for profile retries", "err", err)
+		return
+	}
+	policies, err := ds.ListTeamsMacOSProfileRetryPolicies(ctx)
+	if err != nil {
+		// the profiles of the hosts in no team are still retried
+		level.Error(logger).Log("msg", "list teams profile retry policies", "err", err)
+	}
+	if policies == nil {
+		policies = make(map[uint]fleet.MacOSProfileRetryPolicy)
+	}
+	policies[0] = appCfg.MDM.MacOSSettings.ProfileRetryPolicy
+
+	for teamID, policy := range policies {
+		if !policy.Enabled() {
+			continue
+		}
+		retried, err := ds.RetryFailedHostMDMAppleProfiles(ctx, teamID, policy)
+		if err != nil {
+			level.Error(logger).Log("msg", "retry failed profiles", "team_id", teamID, "err", err)
+			continue
+		}
+		if retried > 0 {
+			level.Info(logger).Log("msg", "retrying failed profiles", "team_id", teamID, "count", retried)
+		}
+	}
+}
+
 func ReconcileProfiles(
 	ctx context.Context,
 	ds fleet.Datastore,
 	commander *apple_mdm.MDMAppleCommander,
+	secretStore secrets.Store,
 	logger kitlog.Logger,
 ) error {
 	if err := ensureFleetdConfig(ctx, ds, logger); err != nil {
 		logger.Log("err", "unable to ensure a fleetd configuration profiles are in place", "details", err)
 	}
 
+	// queue the failed profiles that are due to be retried so that they are
+	// installed again in this run, the profiles to install are then read from
+	// the primary to see them despite the replication lag.
+	retryFailedProfiles(ctx, ds, logger)
+	ctx = ctxdb.RequirePrimary(ctx, true)
+
+	// read the secrets referenced by the profiles before retrieving the
+	// profiles to install, so that the profiles whose secrets were rotated are
+	// installed again in this run. The values are only kept in memory to build
+	// the commands.
+	secretValues, secretErrs, err := resolveProfileSecrets(ctx, ds, secretStore, logger)
+	if err != nil {
+		return err
+	}
+
 	// retrieve the profiles to install/remove.
 	toInstall, err := ds.ListMDMAppleProfilesToInstall(ctx)
 	if err != nil {
@@ -2451,6 +7400,27 @@ func ReconcileProfiles(
 		return ctxerr.Wrap(ctx, err, "getting profiles to remove")
 	}
 
+	// hold the changes that affect more hosts than allowed without approval
+	// until they are approved.
+	toInstall, toRemove, err = holdUnapprovedProfileChanges(ctx, ds, logger, toInstall, toRemove)
+	if err != nil {
+		return err
+	}
+
+	// don't enqueue the commands that are identical to a command still queued
+	// for the host (e.g. after a retry), those hosts keep the queued command.
+	toInstall, suppressedInstalls, err := suppressDuplicateProfileCommands(ctx, ds, fleet.MDMAppleOperationTypeInstall, toInstall)
+	if err != nil {
+		return err
+	}
+	toRemove, suppressedRemoves, err := suppressDuplicateProfileCommands(ctx, ds, fleet.MDMAppleOperationTypeRemove, toRemove)
+	if err != nil {
+		return err
+	}
+	if n := len(suppressedInstalls) + len(suppressedRemoves); n > 0 {
+		level.Info(logger).Log("msg", "suppressed duplicate profile commands", "count", n)
+	}
+
 	// Perform aggregations to support all the operations we need to do
 
 	// toGetContents contains the IDs of all the profiles from which we
@@ -2458,31 +7428,152 @@ func ReconcileProfiles(
 	// per host, it would be too expensive to retrieve the profile contents
 	// there, so we make another request. Using a map to deduplicate.
 	toGetContents := make(map[uint]bool)
+	for _, p := range toInstall {
+		toGetContents[p.ProfileID] = true
+	}
+
+	// Grab the contents of all the profiles we need to install
+	profileIDs := make([]uint, 0, len(toGetContents))
+	for pid := range toGetContents {
+		profileIDs = append(profileIDs, pid)
+	}
+	profileContents, err := ds.GetMDMAppleProfilesContents(ctx, profileIDs)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get profile contents")
+	}
+
+	// the profiles that reference host custom attributes or the host assigned
+	// user are installed with the values of each host, so they need one
+	// command per host.
+	templatedProfiles := make(map[uint]bool)
+	var templatedHostUUIDs []string
+	for _, p := range toInstall {
+		if _, ok := templatedProfiles[p.ProfileID]; !ok {
+			contents := profileContents[p.ProfileID]
+			templatedProfiles[p.ProfileID] = apple_mdm.HasHostCustomAttributeVars(contents) || apple_mdm.HasHostAssignedUserVars(contents)
+		}
+		if templatedProfiles[p.ProfileID] {
+			templatedHostUUIDs = append(templatedHostUUIDs, p.HostUUID)
+		}
+	}
+	var hostCustomAttrs map[string]map[string]string
+	var hostAssignedUsers map[string]*fleet.HostAssignedUser
+	if len(templatedHostUUIDs) > 0 {
+		hostCustomAttrs, err = ds.ListHostCustomAttributesByHostUUIDs(ctx, templatedHostUUIDs)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "get host custom attributes")
+		}
+		hostAssignedUsers, err = ds.ListHostAssignedUsersByHostUUIDs(ctx, templatedHostUUIDs)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "get host assigned users")
+		}
+	}
+
+	// the profiles that reference secrets are installed with the values read
+	// from the secrets manager. A profile whose secrets can't be read fails to
+	// install.
+	profileSecretDetails := make(map[uint]string)
+	profileSecretNames := make(map[uint][]string)
+	for pid := range toGetContents {
+		names := apple_mdm.ProfileSecretNames(profileContents[pid])
+		if len(names) == 0 {
+			continue
+		}
+		profileSecretNames[pid] = names
+		for _, name := range names {
+			if _, ok := secretValues[name]; ok {
+				continue
+			}
+			err := secretErrs[name]
+			if err == nil {
+				if secretStore == nil {
+					err = errors.New("no secrets manager is configured")
+				} else if secret, getErr := secretStore.GetSecret(ctx, name); getErr != nil {
+					err = getErr
+				} else {
+					secretValues[name] = secret.Value
+					continue
+				}
+				secretErrs[name] = err
+				level.Error(logger).Log("msg", "resolve profile secret", "secret", name, "err", err)
+			}
+			profileSecretDetails[pid] = fmt.Sprintf("The secret %s referenced by the profile could not be resolved.", name)
+			break
+		}
+		if profileSecretDetails[pid] == "" {
+			profileContents[pid] = apple_mdm.ExpandProfileSecrets(profileContents[pid], secretValues)
+		}
+	}
 
 	// hostProfiles tracks each host_mdm_apple_profile we need to upsert
 	// with the new status, operation_type, etc.
-	hostProfiles := make([]*fleet.MDMAppleBulkUpsertHostProfilePayload, 0, len(toInstall)+len(toRemove))
+	hostProfiles := make([]*fleet.MDMAppleBulkUpsertHostProfilePayload, 0, len(toInstall)+len(toRemove)+len(suppressedInstalls)+len(suppressedRemoves))
+	hostProfiles = append(hostProfiles, suppressedInstalls...)
+	hostProfiles = append(hostProfiles, suppressedRemoves...)
 
 	// install/removeTargets are maps from profileID -> command uuid and host
 	// UUIDs as the underlying MDM services are optimized to send one command to
 	// multiple hosts at the same time. Note that the same command uuid is used
 	// for all hosts in a given install/remove target operation.
+	//
+	// hostInstallTargets are the install targets of the profiles that
+	// reference host custom attributes, with the contents expanded for their
+	// single host.
 	type cmdTarget struct {
 		cmdUUID   string
 		profIdent string
 		hostUUIDs []string
+		contents  mobileconfig.Mobileconfig
 	}
 	installTargets, removeTargets := make(map[uint]*cmdTarget), make(map[uint]*cmdTarget)
+	hostInstallTargets := make(map[uint][]*cmdTarget)
+	secretProfileNames, secretProfileHosts := make(map[uint]string), make(map[uint]int)
 	for _, p := range toInstall {
-		toGetContents[p.ProfileID] = true
+		if detail := profileSecretDetails[p.ProfileID]; detail != "" {
+			hostProfiles = append(hostProfiles, &fleet.MDMAppleBulkUpsertHostProfilePayload{
+				ProfileID:         p.ProfileID,
+				HostUUID:          p.HostUUID,
+				OperationType:     fleet.MDMAppleOperationTypeInstall,
+				Status:            &fleet.MDMAppleDeliveryFailed,
+				ProfileIdentifier: p.ProfileIdentifier,
+				ProfileName:       p.ProfileName,
+				Checksum:          p.Checksum,
+				Detail:            &detail,
+			})
+			continue
+		}
+		if _, ok := profileSecretNames[p.ProfileID]; ok {
+			secretProfileNames[p.ProfileID] = p.ProfileName
+			secretProfileHosts[p.ProfileID]++
+		}
 
-		target := installTargets[p.ProfileID]
-		if target == nil {
+		// the commands that hold the values of secrets are redacted once
+		// processed, they are recognized by the prefix of their UUID.
+		cmdUUID := uuid.New().String()
+		if _, ok := profileSecretNames[p.ProfileID]; ok {
+			cmdUUID = fleet.MDMAppleProfileSecretsCommandPrefix + cmdUUID
+		}
+
+		var target *cmdTarget
+		if templatedProfiles[p.ProfileID] {
 			target = &cmdTarget{
-				cmdUUID:   uuid.New().String(),
+				cmdUUID:   cmdUUID,
 				profIdent: p.ProfileIdentifier,
+				contents: apple_mdm.ExpandProfileHostAssignedUserVars(
+					apple_mdm.ExpandProfileHostCustomAttributeVars(profileContents[p.ProfileID], hostCustomAttrs[p.HostUUID]),
+					hostAssignedUsers[p.HostUUID],
+				),
+			}
+			hostInstallTargets[p.ProfileID] = append(hostInstallTargets[p.ProfileID], target)
+		} else {
+			target = installTargets[p.ProfileID]
+			if target == nil {
+				target = &cmdTarget{
+					cmdUUID:   cmdUUID,
+					profIdent: p.ProfileIdentifier,
+				}
+				installTargets[p.ProfileID] = target
 			}
-			installTargets[p.ProfileID] = target
 		}
 		target.hostUUIDs = append(target.hostUUIDs, p.HostUUID)
 
@@ -2521,6 +7612,20 @@ func ReconcileProfiles(
 		})
 	}
 
+	// audit the accesses to the secrets before they are sent to the hosts.
+	for pid, hostCount := range secretProfileHosts {
+		for _, name := range profileSecretNames[pid] {
+			if err := ds.NewActivity(ctx, nil, &fleet.ActivityTypeAccessedMDMSecret{
+				SecretName:  name,
+				ProfileID:   pid,
+				ProfileName: secretProfileNames[pid],
+				HostCount:   hostCount,
+			}); err != nil {
+				return ctxerr.Wrap(ctx, err, "create activity for accessed secret")
+			}
+		}
+	}
+
 	// First update all the profiles in the database before sending the
 	// commands, this prevents race conditions where we could get a
 	// response from the device before we set its status as 'pending'
@@ -2531,16 +7636,6 @@ func ReconcileProfiles(
 		return ctxerr.Wrap(ctx, err, "updating host profiles")
 	}
 
-	// Grab the contents of all the profiles we need to install
-	profileIDs := make([]uint, 0, len(toGetContents))
-	for pid := range toGetContents {
-		profileIDs = append(profileIDs, pid)
-	}
-	profileContents, err := ds.GetMDMAppleProfilesContents(ctx, profileIDs)
-	if err != nil {
-		return ctxerr.Wrap(ctx, err, "get profile contents")
-	}
-
 	type remoteResult struct {
 		Err     error
 		CmdUUID string
@@ -2556,7 +7651,11 @@ func ReconcileProfiles(
 		var err error
 		switch op {
 		case fleet.MDMAppleOperationTypeInstall:
-			err = commander.InstallProfile(ctx, target.hostUUIDs, profileContents[profID], target.cmdUUID)
+			contents := target.contents
+			if contents == nil {
+				contents = profileContents[profID]
+			}
+			err = commander.InstallProfile(ctx, target.hostUUIDs, contents, target.cmdUUID)
 		case fleet.MDMAppleOperationTypeRemove:
 			err = commander.RemoveProfile(ctx, target.hostUUIDs, target.profIdent, target.cmdUUID)
 		}
@@ -2574,6 +7673,12 @@ func ReconcileProfiles(
 		wgProd.Add(1)
 		go execCmd(profID, target, fleet.MDMAppleOperationTypeInstall)
 	}
+	for profID, targets := range hostInstallTargets {
+		for _, target := range targets {
+			wgProd.Add(1)
+			go execCmd(profID, target, fleet.MDMAppleOperationTypeInstall)
+		}
+	}
 	for profID, target := range removeTargets {
 		wgProd.Add(1)
 		go execCmd(profID, target, fleet.MDMAppleOperationTypeRemove)
@@ -2618,3 +7723,594 @@ func ReconcileProfiles(
 	}
 	return nil
 }
+
+const (
+	// mdmAppleCertificatesRefreshInterval is the minimum time between two
+	// requests of the list of installed certificates of a host.
+	mdmAppleCertificatesRefreshInterval = 24 * time.Hour
+
+	// mdmAppleCertificatesRefreshBatchSize is the maximum number of hosts for
+	// which the list of installed certificates is requested in a single run.
+	mdmAppleCertificatesRefreshBatchSize = 1000
+)
+
+// RefreshMDMAppleHostCertificates enqueues the CertificateList commands to
+// refresh the inventory of certificates installed on the MDM-enrolled macOS
+// hosts that were not refreshed recently. Two commands are sent to each host,
+// one to list all the certificates and one to list only those installed by MDM
+// payloads, so that the origin of the certificates can be reported.
+func RefreshMDMAppleHostCertificates(
+	ctx context.Context,
+	ds fleet.Datastore,
+	commander *apple_mdm.MDMAppleCommander,
+	logger kitlog.Logger,
+) error {
+	hostUUIDs, err := ds.ListMDMAppleHostUUIDsToRefreshCertificates(ctx, mdmAppleCertificatesRefreshInterval, mdmAppleCertificatesRefreshBatchSize)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list hosts to refresh certificates")
+	}
+	if len(hostUUIDs) == 0 {
+		return nil
+	}
+
+	// mark the hosts as requested first, so that a host that cannot be sent the
+	// commands is not retried on every run.
+	if err := ds.SetMDMAppleHostCertificatesRefreshRequested(ctx, hostUUIDs); err != nil {
+		return ctxerr.Wrap(ctx, err, "set hosts certificates refresh requested")
+	}
+
+	for _, cmd := range []struct {
+		prefix      string
+		managedOnly bool
+	}{
+		{fleet.MDMAppleCertificateListAllCommandPrefix, false},
+		{fleet.MDMAppleCertificateListManagedCommandPrefix, true},
+	} {
+		err := commander.CertificateList(ctx, hostUUIDs, cmd.prefix+uuid.New().String(), cmd.managedOnly)
+		var e *apple_mdm.APNSDeliveryError
+		switch {
+		case errors.As(err, &e):
+			level.Debug(logger).Log("err", "sending push notifications, certificate list commands still enqueued", "details", err)
+		case err != nil:
+			return ctxerr.Wrap(ctx, err, "enqueue certificate list command")
+		}
+	}
+	level.Debug(logger).Log("msg", "requested certificates refresh", "hosts_count", len(hostUUIDs))
+	return nil
+}
+
+const (
+	// mdmAppleProfileListRefreshInterval is the minimum time between two
+	// requests of the list of installed profiles of a host.
+	mdmAppleProfileListRefreshInterval = 24 * time.Hour
+
+	// mdmAppleProfileListRefreshBatchSize is the maximum number of hosts for
+	// which the list of installed profiles is requested in a single run.
+	mdmAppleProfileListRefreshBatchSize = 1000
+)
+
+// RefreshMDMAppleHostProfileLists enqueues the ProfileList commands to verify
+// the versions of the profiles installed on the MDM-enrolled macOS hosts that
+// were not verified recently. The results are handled by
+// MDMAppleCheckinAndCommandService.verifyHostInstalledProfiles.
+func RefreshMDMAppleHostProfileLists(
+	ctx context.Context,
+	ds fleet.Datastore,
+	commander *apple_mdm.MDMAppleCommander,
+	logger kitlog.Logger,
+) error {
+	hostUUIDs, err := ds.ListMDMAppleHostUUIDsToRefreshProfileList(ctx, mdmAppleProfileListRefreshInterval, mdmAppleProfileListRefreshBatchSize)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list hosts to refresh profile list")
+	}
+	if len(hostUUIDs) == 0 {
+		return nil
+	}
+
+	// mark the hosts as requested first, so that a host that cannot be sent the
+	// command is not retried on every run.
+	if err := ds.SetMDMAppleHostProfileListRefreshRequested(ctx, hostUUIDs); err != nil {
+		return ctxerr.Wrap(ctx, err, "set hosts profile list refresh requested")
+	}
+
+	err = commander.ProfileList(ctx, hostUUIDs, fleet.MDMAppleProfileListCommandPrefix+uuid.New().String())
+	var e *apple_mdm.APNSDeliveryError
+	switch {
+	case errors.As(err, &e):
+		level.Debug(logger).Log("err", "sending push notifications, profile list command still enqueued", "details", err)
+	case err != nil:
+		return ctxerr.Wrap(ctx, err, "enqueue profile list command")
+	}
+	level.Debug(logger).Log("msg", "requested profile list refresh", "hosts_count", len(hostUUIDs))
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// MDM Apple Server URL Migration
+////////////////////////////////////////////////////////////////////////////////
+
+// mdmServerURLCheckClient is the client used to check that the new server URL
+// of a server URL migration is served by Fleet.
+var mdmServerURLCheckClient = fleethttp.NewClient(fleethttp.WithTimeout(10 * time.Second))
+
+type startMDMAppleServerURLMigrationRequest struct {
+	ServerURL string `json:"server_url"`
+	DryRun    bool   `json:"dry_run"`
+}
+
+type startMDMAppleServerURLMigrationResponse struct {
+	Checks    []fleet.MDMAppleServerURLMigrationCheck `json:"checks"`
+	Migration *fleet.MDMAppleServerURLMigration       `json:"migration,omitempty"`
+	Err       error                                   `json:"error,omitempty"`
+}
+
+func (r startMDMAppleServerURLMigrationResponse) error() error { return r.Err }
+
+func startMDMAppleServerURLMigrationEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*startMDMAppleServerURLMigrationRequest)
+	checks, migration, err := svc.StartMDMAppleServerURLMigration(ctx, req.ServerURL, req.DryRun)
+	if err != nil {
+		return startMDMAppleServerURLMigrationResponse{Err: err}, nil
+	}
+	return startMDMAppleServerURLMigrationResponse{Checks: checks, Migration: migration}, nil
+}
+
+func (svc *Service) StartMDMAppleServerURLMigration(ctx context.Context, serverURL string, dryRun bool) ([]fleet.MDMAppleServerURLMigrationCheck, *fleet.MDMAppleServerURLMigration, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.AppConfig{}, fleet.ActionWrite); err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err)
+	}
+
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err)
+	}
+	oldServerURL := appCfg.ServerSettings.ServerURL
+	serverURL = strings.TrimSuffix(serverURL, "/")
+
+	checks, err := svc.mdmAppleServerURLMigrationChecks(ctx, oldServerURL, serverURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dryRun {
+		return checks, nil, nil
+	}
+
+	invalid := &fleet.InvalidArgumentError{}
+	for _, c := range checks {
+		if !c.Passed {
+			invalid.Append("server_url", c.Detail)
+		}
+	}
+	if invalid.HasErrors() {
+		return nil, nil, ctxerr.Wrap(ctx, invalid, "server url migration pre-flight checks")
+	}
+
+	appCfg.ServerSettings.ServerURL = serverURL
+	if err := svc.ds.SaveAppConfig(ctx, appCfg); err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "save server url")
+	}
+
+	// the DEP profile embeds the enrollment URL, it must be registered again
+	// with Apple so that the hosts enrolling from now on use the new URL.
+	if license.IsPremium(ctx) {
+		if err := svc.EnterpriseOverrides.MDMAppleSyncDEPProfile(ctx); err != nil {
+			return nil, nil, ctxerr.Wrap(ctx, err, "sync DEP profile")
+		}
+	}
+
+	// the enrollment profile with the new URL is redelivered in batches to the
+	// currently enrolled hosts by the MDM Apple profile manager cron job.
+	migration, err := svc.ds.NewMDMAppleServerURLMigration(ctx, oldServerURL, serverURL)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "create server url migration")
+	}
+
+	if err := svc.ds.NewActivity(ctx, authz.UserFromContext(ctx), fleet.ActivityTypeMigratedMDMServerURL{
+		OldServerURL: oldServerURL,
+		NewServerURL: serverURL,
+		HostsCount:   migration.Hosts,
+	}); err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "logging activity for server url migration")
+	}
+	return checks, migration, nil
+}
+
+// mdmAppleServerURLMigrationChecks runs the pre-flight checks of a migration
+// of the server URL from oldServerURL to newServerURL.
+func (svc *Service) mdmAppleServerURLMigrationChecks(ctx context.Context, oldServerURL, newServerURL string) ([]fleet.MDMAppleServerURLMigrationCheck, error) {
+	var checks []fleet.MDMAppleServerURLMigrationCheck
+
+	validURL := fleet.MDMAppleServerURLMigrationCheck{Name: "valid_url", Passed: true}
+	u, err := url.Parse(newServerURL)
+	switch {
+	case err != nil:
+		validURL.Passed, validURL.Detail = false, fmt.Sprintf("The server URL is invalid: %v", err)
+	case u.Scheme != "https" || u.Host == "":
+		validURL.Passed, validURL.Detail = false, "The server URL must be an absolute https URL, Apple devices only connect to MDM servers over https."
+	case u.RawQuery != "" || u.Fragment != "":
+		validURL.Passed, validURL.Detail = false, "The server URL must not have a query string or a fragment."
+	}
+	checks = append(checks, validURL)
+
+	changed := fleet.MDMAppleServerURLMigrationCheck{Name: "url_changed", Passed: newServerURL != strings.TrimSuffix(oldServerURL, "/")}
+	if !changed.Passed {
+		changed.Detail = "The server URL is already the current server URL."
+	}
+	checks = append(checks, changed)
+
+	reachable := fleet.MDMAppleServerURLMigrationCheck{Name: "reachable", Passed: validURL.Passed}
+	if validURL.Passed {
+		if err := checkFleetServerURLReachable(ctx, mdmServerURLCheckClient, newServerURL); err != nil {
+			reachable.Passed, reachable.Detail = false, fmt.Sprintf("Fleet is not reachable at the server URL: %v", err)
+		}
+	} else {
+		reachable.Detail = "The server URL is invalid."
+	}
+	checks = append(checks, reachable)
+
+	// only one migration can redeliver the enrollment profile at a time, the
+	// hosts that were already sent the profile of the previous one are not
+	// waited for as they may never check in again.
+	noMigration := fleet.MDMAppleServerURLMigrationCheck{Name: "no_migration_in_progress", Passed: true}
+	latest, err := svc.ds.GetLatestMDMAppleServerURLMigration(ctx)
+	if err != nil && !fleet.IsNotFound(err) {
+		return nil, ctxerr.Wrap(ctx, err, "get latest server url migration")
+	}
+	if latest != nil && latest.InProgress() {
+		noMigration.Passed = false
+		noMigration.Detail = fmt.Sprintf("The migration to %s is still in progress, the enrollment profile has yet to be sent to %d host(s).", latest.NewServerURL, latest.Pending)
+	}
+	checks = append(checks, noMigration)
+
+	return checks, nil
+}
+
+// checkFleetServerURLReachable checks that the Fleet server answers at the
+// provided server URL.
+func checkFleetServerURLReachable(ctx context.Context, client *http.Client, serverURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serverURL+"/version", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET /version returned status %d", resp.StatusCode)
+	}
+
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&version); err != nil || version.Version == "" {
+		return errors.New("GET /version did not return the version of Fleet")
+	}
+	return nil
+}
+
+type getMDMAppleServerURLMigrationResponse struct {
+	Migration *fleet.MDMAppleServerURLMigration `json:"migration"`
+	Err       error                             `json:"error,omitempty"`
+}
+
+func (r getMDMAppleServerURLMigrationResponse) error() error { return r.Err }
+
+func getMDMAppleServerURLMigrationEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	migration, err := svc.GetMDMAppleServerURLMigration(ctx)
+	if err != nil {
+		return getMDMAppleServerURLMigrationResponse{Err: err}, nil
+	}
+	return getMDMAppleServerURLMigrationResponse{Migration: migration}, nil
+}
+
+func (svc *Service) GetMDMAppleServerURLMigration(ctx context.Context) (*fleet.MDMAppleServerURLMigration, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.AppConfig{}, fleet.ActionRead); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	migration, err := svc.ds.GetLatestMDMAppleServerURLMigration(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	return migration, nil
+}
+
+type getMDMAppleSCEPCARotationResponse struct {
+	Rotation *fleet.MDMAppleSCEPCARotation `json:"rotation,omitempty"`
+	Err      error                         `json:"error,omitempty"`
+}
+
+func (r getMDMAppleSCEPCARotationResponse) error() error { return r.Err }
+
+func getMDMAppleSCEPCARotationEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	rotation, err := svc.GetMDMAppleSCEPCARotation(ctx)
+	if err != nil {
+		return getMDMAppleSCEPCARotationResponse{Err: err}, nil
+	}
+	return getMDMAppleSCEPCARotationResponse{Rotation: rotation}, nil
+}
+
+// mdmAppleSCEPIdentityCertificatesBatchSize is the number of identity
+// certificates loaded at once to compute the status of a SCEP CA rotation.
+const mdmAppleSCEPIdentityCertificatesBatchSize = 1000
+
+func (svc *Service) GetMDMAppleSCEPCARotation(ctx context.Context) (*fleet.MDMAppleSCEPCARotation, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.AppConfig{}, fleet.ActionRead); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	cur, _, _, err := svc.config.MDM.AppleSCEP()
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get SCEP CA certificate")
+	}
+	prev, err := svc.config.MDM.AppleSCEPPrevious()
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get previous SCEP CA certificate")
+	}
+
+	rotation := &fleet.MDMAppleSCEPCARotation{
+		InProgress: prev != nil,
+		CurrentCA:  mdmAppleSCEPCA(cur.Leaf),
+	}
+	if prev != nil {
+		prevCA := mdmAppleSCEPCA(prev.Leaf)
+		rotation.PreviousCA = &prevCA
+	}
+
+	var afterSerial int64
+	for {
+		certs, err := svc.ds.ListMDMAppleSCEPIdentityCertificates(ctx, afterSerial, mdmAppleSCEPIdentityCertificatesBatchSize)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "list scep identity certificates")
+		}
+		for _, c := range certs {
+			afterSerial = c.Serial
+
+			var cert *x509.Certificate
+			if block, _ := pem.Decode(c.CertificatePEM); block != nil {
+				cert, _ = x509.ParseCertificate(block.Bytes)
+			}
+			switch {
+			case cert != nil && cert.CheckSignatureFrom(cur.Leaf) == nil:
+				rotation.HostsOnCurrentCA++
+			case cert != nil && prev != nil && cert.CheckSignatureFrom(prev.Leaf) == nil:
+				rotation.HostsOnPreviousCA++
+			default:
+				rotation.HostsOnUnknownCA++
+			}
+		}
+		if len(certs) < mdmAppleSCEPIdentityCertificatesBatchSize {
+			break
+		}
+	}
+	return rotation, nil
+}
+
+func mdmAppleSCEPCA(cert *x509.Certificate) fleet.MDMAppleSCEPCA {
+	sum := sha256.Sum256(cert.Raw)
+	return fleet.MDMAppleSCEPCA{
+		Subject:       cert.Subject.String(),
+		SHA256Sum:     hex.EncodeToString(sum[:]),
+		NotValidAfter: cert.NotAfter,
+	}
+}
+
+type setupMDMAppleRequest struct {
+	fleet.MDMAppleSetupPayload
+}
+
+type setupMDMAppleResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r setupMDMAppleResponse) error() error { return r.Err }
+
+func setupMDMAppleEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*setupMDMAppleRequest)
+	if err := svc.SetupMDMApple(ctx, req.MDMAppleSetupPayload); err != nil {
+		return setupMDMAppleResponse{Err: err}, nil
+	}
+	return setupMDMAppleResponse{}, nil
+}
+
+// validateAPNsConnection checks that the APNs certificate authenticates to
+// Apple's push notification service. It is a variable so that tests can
+// replace it.
+var validateAPNsConnection = func(ctx context.Context, cert *tls.Certificate) error {
+	const (
+		apnsConnectionTimeout = 10 * time.Second
+		apnsConnectionURL     = "https://api.sandbox.push.apple.com"
+	)
+	ctx, cancel := context.WithTimeout(ctx, apnsConnectionTimeout)
+	defer cancel()
+	return certificate.ValidateClientAuthTLSConnection(ctx, cert, apnsConnectionURL)
+}
+
+// validateABMToken checks that a session can be opened with Apple Business
+// Manager with the token. It is a variable so that tests can replace it.
+var validateABMToken = func(ctx context.Context, tok *nanodep_client.OAuth1Tokens) error {
+	depClient := godep.NewClient(abmTokenStorage{tok: tok}, fleethttp.NewClient())
+	_, err := depClient.AccountDetail(ctx, apple_mdm.DEPName)
+	return err
+}
+
+// abmTokenStorage is the storage of the DEP client used to validate an Apple
+// Business Manager token before it is stored.
+type abmTokenStorage struct {
+	tok *nanodep_client.OAuth1Tokens
+}
+
+func (s abmTokenStorage) RetrieveAuthTokens(ctx context.Context, name string) (*nanodep_client.OAuth1Tokens, error) {
+	return s.tok, nil
+}
+
+func (s abmTokenStorage) RetrieveConfig(ctx context.Context, name string) (*nanodep_client.Config, error) {
+	// use the default Apple DEP API URL
+	return nil, nil
+}
+
+func (svc *Service) SetupMDMApple(ctx context.Context, payload fleet.MDMAppleSetupPayload) error {
+	if err := svc.authz.Authorize(ctx, &fleet.AppleMDM{}, fleet.ActionWrite); err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+
+	if svc.config.MDM.IsAppleAPNsSet() || svc.config.MDM.IsAppleSCEPSet() {
+		return ctxerr.Wrap(ctx, &fleet.BadRequestError{
+			Message: "Apple MDM is configured in the server configuration, it can't be set up via the API.",
+		})
+	}
+	if svc.config.Server.PrivateKey == "" {
+		return ctxerr.Wrap(ctx, &fleet.BadRequestError{
+			Message: "The server private key (server.private_key) must be configured to store the Apple MDM assets.",
+		})
+	}
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+	if appCfg.MDM.EnabledAndConfigured {
+		return ctxerr.Wrap(ctx, &fleet.BadRequestError{Message: "Apple MDM is already enabled and configured."})
+	}
+
+	withABM := payload.ABMCert != "" || payload.ABMKey != "" || payload.ABMToken != ""
+	if withABM && !license.IsPremium(ctx) {
+		return fleet.ErrMissingLicense
+	}
+
+	// validate the assets the same way as when they are provided via the
+	// server configuration.
+	mdmCfg := config.MDMConfig{
+		AppleAPNsCertBytes:      payload.APNsCert,
+		AppleAPNsKeyBytes:       payload.APNsKey,
+		AppleSCEPCertBytes:      payload.SCEPCert,
+		AppleSCEPKeyBytes:       payload.SCEPKey,
+		AppleBMCertBytes:        payload.ABMCert,
+		AppleBMKeyBytes:         payload.ABMKey,
+		AppleBMServerTokenBytes: payload.ABMToken,
+	}
+	invalid := &fleet.InvalidArgumentError{}
+	apnsCert, _, _, err := mdmCfg.AppleAPNs()
+	if err != nil {
+		invalid.Append("apns_cert", err.Error())
+	} else if _, err := cryptoutil.TopicFromCert(apnsCert.Leaf); err != nil {
+		invalid.Appendf("apns_cert", "Apple MDM APNs configuration: get topic from certificate: %v", err)
+	}
+	if _, _, _, err := mdmCfg.AppleSCEP(); err != nil {
+		invalid.Append("scep_cert", err.Error())
+	}
+	var abmTok *nanodep_client.OAuth1Tokens
+	if withABM {
+		if payload.ABMCert == "" || payload.ABMKey == "" || payload.ABMToken == "" {
+			invalid.Append("abm_token", "The Apple Business Manager certificate, key and token must be provided together.")
+		} else if abmTok, err = mdmCfg.AppleBM(); err != nil {
+			invalid.Append("abm_token", err.Error())
+		}
+	}
+	if invalid.HasErrors() {
+		return ctxerr.Wrap(ctx, invalid)
+	}
+
+	// validate that the assets work end-to-end before enabling MDM.
+	if err := validateAPNsConnection(ctx, apnsCert); err != nil {
+		return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("apns_cert",
+			fmt.Sprintf("Couldn't authenticate to Apple Push Notification service with the certificate: %v", err)))
+	}
+	if abmTok != nil {
+		if err := validateABMToken(ctx, abmTok); err != nil {
+			return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("abm_token",
+				fmt.Sprintf("Couldn't open a session with Apple Business Manager with the token: %v", err)))
+		}
+	}
+
+	assets := []fleet.MDMConfigAsset{
+		{Name: fleet.MDMConfigAssetAPNsCert, Value: []byte(payload.APNsCert)},
+		{Name: fleet.MDMConfigAssetAPNsKey, Value: []byte(payload.APNsKey)},
+		{Name: fleet.MDMConfigAssetSCEPCert, Value: []byte(payload.SCEPCert)},
+		{Name: fleet.MDMConfigAssetSCEPKey, Value: []byte(payload.SCEPKey)},
+	}
+	if withABM {
+		assets = append(assets,
+			fleet.MDMConfigAsset{Name: fleet.MDMConfigAssetABMCert, Value: []byte(payload.ABMCert)},
+			fleet.MDMConfigAsset{Name: fleet.MDMConfigAssetABMKey, Value: []byte(payload.ABMKey)},
+			fleet.MDMConfigAsset{Name: fleet.MDMConfigAssetABMToken, Value: []byte(payload.ABMToken)},
+		)
+	}
+	if err := svc.ds.SetupMDMAppleConfigAssets(ctx, assets); err != nil {
+		return ctxerr.Wrap(ctx, err, "store mdm config assets")
+	}
+	return nil
+}
+
+// mdmAppleServerURLMigrationBatchSize is the maximum number of hosts the
+// enrollment profile is sent to in a single run, so that the hosts don't all
+// connect to the new server URL at once.
+const mdmAppleServerURLMigrationBatchSize = 200
+
+// RedeliverMDMAppleEnrollmentProfiles sends the enrollment profile with the
+// current server URL to the next batch of hosts of the latest server URL
+// migration. The results are handled by
+// MDMAppleCheckinAndCommandService.updateServerURLMigrationHostFromResults.
+func RedeliverMDMAppleEnrollmentProfiles(
+	ctx context.Context,
+	ds fleet.Datastore,
+	commander *apple_mdm.MDMAppleCommander,
+	scepChallenge string,
+	pushCertTopic string,
+	logger kitlog.Logger,
+) error {
+	migration, err := ds.GetLatestMDMAppleServerURLMigration(ctx)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			return nil
+		}
+		return ctxerr.Wrap(ctx, err, "get latest server url migration")
+	}
+	if !migration.InProgress() {
+		return nil
+	}
+
+	hostUUIDs, err := ds.ListMDMAppleServerURLMigrationPendingHosts(ctx, migration.ID, mdmAppleServerURLMigrationBatchSize)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list server url migration pending hosts")
+	}
+	if len(hostUUIDs) == 0 {
+		return nil
+	}
+
+	appCfg, err := ds.AppConfig(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get app config")
+	}
+	// the hosts are migrated in the background, there is no end user request
+	// to localize the profile for.
+	enrollmentProfile, err := apple_mdm.GenerateEnrollmentProfileMobileconfig(
+		appCfg.OrgInfo.OrgName,
+		appCfg.ServerSettings.ServerURL,
+		scepChallenge,
+		pushCertTopic,
+		fleet.LocalizeMDMEndUserStrings("", appCfg.MDM.Localization),
+	)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "generate enrollment profile")
+	}
+
+	// mark the hosts as sent first, so that a host that cannot be sent the
+	// command is not retried on every run.
+	cmdUUID := fleet.MDMAppleServerURLMigrationCommandPrefix + uuid.New().String()
+	if err := ds.SetMDMAppleServerURLMigrationHostsSent(ctx, migration.ID, hostUUIDs, cmdUUID); err != nil {
+		return ctxerr.Wrap(ctx, err, "set server url migration hosts sent")
+	}
+
+	err = commander.InstallProfile(ctx, hostUUIDs, enrollmentProfile, cmdUUID)
+	var e *apple_mdm.APNSDeliveryError
+	switch {
+	case errors.As(err, &e):
+		level.Debug(logger).Log("err", "sending push notifications, enrollment profile command still enqueued", "details", err)
+	case err != nil:
+		return ctxerr.Wrap(ctx, err, "enqueue enrollment profile command")
+	}
+	level.Info(logger).Log("msg", "redelivered enrollment profile", "migration_id", migration.ID, "hosts_count", len(hostUUIDs))
+	return nil
+}
@@ -3,17 +3,29 @@ package service
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/server/authz"
 	"github.com/fleetdm/fleet/v4/server/config"
@@ -22,22 +34,26 @@ import (
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	apple_mdm "github.com/fleetdm/fleet/v4/server/mdm/apple"
 	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
+	"github.com/fleetdm/fleet/v4/server/mdm/secrets"
 	"github.com/fleetdm/fleet/v4/server/mock"
 	nanodep_mock "github.com/fleetdm/fleet/v4/server/mock/nanodep"
 	nanomdm_mock "github.com/fleetdm/fleet/v4/server/mock/nanomdm"
 	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/test"
+	"github.com/fleetdm/fleet/v4/server/worker"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/google/uuid"
 	nanodep_client "github.com/micromdm/nanodep/client"
 	"github.com/micromdm/nanomdm/mdm"
 	nanomdm_pushsvc "github.com/micromdm/nanomdm/push/service"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 )
 
 func setupAppleMDMService(t *testing.T) (fleet.Service, context.Context, *mock.Store) {
 	ds := new(mock.Store)
 	cfg := config.TestConfig()
+	cfg.MDM.GitOpsAllowedCommands = "ProfileList"
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch {
 		case strings.Contains(r.URL.Path, "/server/devices"):
@@ -48,6 +64,20 @@ func setupAppleMDMService(t *testing.T) (fleet.Service, context.Context, *mock.S
 			_, err := w.Write([]byte(`{"auth_session_token": "yoo"}`))
 			require.NoError(t, err)
 			return
+		case strings.Contains(r.URL.Path, "/devices/disown"):
+			var req struct {
+				Devices []string `json:"devices"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			res := map[string]string{}
+			for _, serial := range req.Devices {
+				res[serial] = "NOT_ACCESSIBLE"
+				if serial == "DEP-SERIAL" {
+					res[serial] = "SUCCESS"
+				}
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"devices": res}))
+			return
 		}
 	}))
 
@@ -233,7 +263,7 @@ func TestAppleMDMAuthorization(t *testing.T) {
 	ctx = test.UserContext(ctx, test.UserNoRoles)
 	_, err := svc.GetMDMAppleInstallerByToken(ctx, "foo")
 	require.NoError(t, err)
-	_, err = svc.GetMDMAppleEnrollmentProfileByToken(ctx, "foo")
+	_, err = svc.GetMDMAppleEnrollmentProfileByToken(ctx, "foo", "")
 	require.NoError(t, err)
 	_, err = svc.GetMDMAppleInstallerDetailsByToken(ctx, "foo")
 	require.NoError(t, err)
@@ -270,6 +300,15 @@ func TestAppleMDMAuthorization(t *testing.T) {
 		}
 		return hosts, nil
 	}
+	ds.FilterMDMAppleHostUUIDsPendingApprovalFunc = func(ctx context.Context, hostUUIDs []string) ([]string, error) {
+		var pending []string
+		for _, uuid := range hostUUIDs {
+			if uuid == "host5" {
+				pending = append(pending, uuid)
+			}
+		}
+		return pending, nil
+	}
 
 	rawB64FreeCmd := base64.RawStdEncoding.EncodeToString([]byte(`<?xml version="1.0" encoding="UTF-8"?>
 <!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
@@ -303,15 +342,60 @@ func TestAppleMDMAuthorization(t *testing.T) {
 			{"team 1 admin cannot run team 2", test.UserTeamAdminTeam1, []string{"host3"}, true},
 			{"team 1 admin cannot run no team", test.UserTeamAdminTeam1, []string{"host4"}, true},
 			{"team 1 admin cannot run mix of team 1 and 2", test.UserTeamAdminTeam1, []string{"host1", "host3"}, true},
+			{"gitops cannot run", test.UserGitOps, []string{"host1", "host2", "host3", "host4"}, true},
+			{"team 1 gitops cannot run team 1", test.UserTeamGitOpsTeam1, []string{"host1", "host2"}, true},
 		}
 		for _, c := range enqueueCmdCases {
 			t.Run(c.desc, func(t *testing.T) {
 				ctx = test.UserContext(ctx, c.user)
-				_, _, err = svc.EnqueueMDMAppleCommand(ctx, rawB64FreeCmd, c.uuids, false)
+				_, _, err = svc.EnqueueMDMAppleCommand(ctx, rawB64FreeCmd, c.uuids, nil, nil, nil, "", false)
+				checkAuthErr(t, err, c.shoudFailWithAuth)
+			})
+		}
+
+		// gitops users can only run the commands allowed in the configuration
+		rawB64AllowedCmd := base64.RawStdEncoding.EncodeToString([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>Command</key>
+    <dict>
+        <key>RequestType</key>
+        <string>ProfileList</string>
+    </dict>
+    <key>CommandUUID</key>
+    <string>uuid</string>
+</dict>
+</plist>`))
+		allowedCmdCases := []struct {
+			desc              string
+			user              *fleet.User
+			uuids             []string
+			shoudFailWithAuth bool
+		}{
+			{"gitops can run", test.UserGitOps, []string{"host1", "host2", "host3", "host4"}, false},
+			{"team 1 gitops can run team 1", test.UserTeamGitOpsTeam1, []string{"host1", "host2"}, false},
+			{"team 1 gitops cannot run team 2", test.UserTeamGitOpsTeam1, []string{"host3"}, true},
+			{"team 1 gitops cannot run no team", test.UserTeamGitOpsTeam1, []string{"host4"}, true},
+			{"observer cannot run", test.UserObserver, []string{"host1", "host2", "host3", "host4"}, true},
+			{"maintainer can run", test.UserMaintainer, []string{"host1", "host2", "host3", "host4"}, false},
+		}
+		for _, c := range allowedCmdCases {
+			t.Run(c.desc, func(t *testing.T) {
+				ctx = test.UserContext(ctx, c.user)
+				_, _, err = svc.EnqueueMDMAppleCommand(ctx, rawB64AllowedCmd, c.uuids, nil, nil, nil, "", false)
 				checkAuthErr(t, err, c.shoudFailWithAuth)
 			})
 		}
 
+		// invalid commands are reported only to authorized users
+		ctx = test.UserContext(ctx, test.UserGitOps)
+		_, _, err = svc.EnqueueMDMAppleCommand(ctx, "not a command", []string{"host1"}, nil, nil, nil, "", false)
+		checkAuthErr(t, err, true)
+		ctx = test.UserContext(ctx, test.UserAdmin)
+		_, _, err = svc.EnqueueMDMAppleCommand(ctx, "not a command", []string{"host1"}, nil, nil, nil, "", false)
+		require.ErrorContains(t, err, "unable to decode base64 command")
+
 		// test with a command that requires a premium license
 		ctx = test.UserContext(ctx, test.UserAdmin)
 		ctx = license.NewContext(ctx, &fleet.LicenseInfo{Tier: fleet.TierFree})
@@ -328,9 +412,73 @@ func TestAppleMDMAuthorization(t *testing.T) {
     <string>uuid</string>
 </dict>
 </plist>`, "DeviceLock")))
-		_, _, err = svc.EnqueueMDMAppleCommand(ctx, rawB64PremiumCmd, []string{"host1"}, false)
+		_, _, err = svc.EnqueueMDMAppleCommand(ctx, rawB64PremiumCmd, []string{"host1"}, nil, nil, nil, "", false)
 		require.Error(t, err)
 		require.ErrorContains(t, err, fleet.ErrMissingLicense.Error())
+
+		// hosts whose enrollment is pending approval cannot receive commands
+		_, _, err = svc.EnqueueMDMAppleCommand(ctx, rawB64FreeCmd, []string{"host4", "host5"}, nil, nil, nil, "", false)
+		require.ErrorContains(t, err, "the enrollment of hosts host5 must be approved")
+
+		// commands can target the hosts matching an MDM host target
+		ds.MDMHostTargetFunc = func(ctx context.Context, id uint) (*fleet.MDMHostTarget, error) {
+			return &fleet.MDMHostTarget{ID: id, Name: "target"}, nil
+		}
+		ds.ListMDMHostTargetHostsFunc = func(ctx context.Context, id uint) ([]*fleet.MDMHostTargetHost, error) {
+			return []*fleet.MDMHostTargetHost{{UUID: "host1"}, {UUID: "host3"}}, nil
+		}
+		ctx = test.UserContext(ctx, test.UserAdmin)
+		_, _, err = svc.EnqueueMDMAppleCommand(ctx, rawB64FreeCmd, nil, ptr.Uint(1), nil, nil, "", false)
+		require.NoError(t, err)
+		_, _, err = svc.EnqueueMDMAppleCommand(ctx, rawB64FreeCmd, []string{"host1"}, ptr.Uint(1), nil, nil, "", false)
+		require.ErrorContains(t, err, "only one of device_ids, target_id, team_ids or label_id can be provided")
+		ctx = test.UserContext(ctx, test.UserTeamAdminTeam1)
+		_, _, err = svc.EnqueueMDMAppleCommand(ctx, rawB64FreeCmd, nil, ptr.Uint(1), nil, nil, "", false)
+		checkAuthErr(t, err, true)
+
+		// commands can target the hosts enrolled in Fleet's MDM of teams or of a label
+		ds.ListHostsFunc = func(ctx context.Context, filter fleet.TeamFilter, opt fleet.HostListOptions) ([]*fleet.Host, error) {
+			require.Equal(t, fleet.MDMEnrollStatusEnrolled, opt.MDMEnrollmentStatusFilter)
+			require.NotNil(t, opt.TeamFilter)
+			var hosts []*fleet.Host
+			for uuid, tmID := range hostUUIDsToTeamID {
+				if tmID == *opt.TeamFilter {
+					hosts = append(hosts, &fleet.Host{UUID: uuid, Platform: "darwin"})
+				}
+			}
+			return append(hosts, &fleet.Host{UUID: "windows", Platform: "windows"}), nil
+		}
+		ds.LabelFunc = func(ctx context.Context, lid uint) (*fleet.Label, error) {
+			if lid != 1 {
+				return nil, newNotFoundError()
+			}
+			return &fleet.Label{ID: lid}, nil
+		}
+		ds.ListHostsInLabelFunc = func(ctx context.Context, filter fleet.TeamFilter, lid uint, opt fleet.HostListOptions) ([]*fleet.Host, error) {
+			return []*fleet.Host{{UUID: "host1", Platform: "darwin"}, {UUID: "host4", Platform: "darwin"}}, nil
+		}
+		ctx = test.UserContext(ctx, test.UserAdmin)
+		_, res, err := svc.EnqueueMDMAppleCommand(ctx, rawB64FreeCmd, nil, nil, []uint{1, 0}, nil, "", false)
+		require.NoError(t, err)
+		require.Len(t, res.Hosts, 3)
+		for _, h := range res.Hosts {
+			require.Contains(t, []string{"host1", "host2", "host4"}, h.HostUUID)
+			require.Equal(t, fleet.CommandEnqueueHostStatusEnqueued, h.Status)
+		}
+		_, res, err = svc.EnqueueMDMAppleCommand(ctx, rawB64FreeCmd, nil, nil, nil, ptr.Uint(1), "", false)
+		require.NoError(t, err)
+		require.Len(t, res.Hosts, 2)
+		_, _, err = svc.EnqueueMDMAppleCommand(ctx, rawB64FreeCmd, nil, nil, nil, ptr.Uint(2), "", false)
+		require.ErrorContains(t, err, "label does not exist")
+		_, _, err = svc.EnqueueMDMAppleCommand(ctx, rawB64FreeCmd, nil, nil, []uint{1}, ptr.Uint(1), "", false)
+		require.ErrorContains(t, err, "only one of device_ids, target_id, team_ids or label_id can be provided")
+
+		// the user must be able to run commands on all the requested teams
+		ctx = test.UserContext(ctx, test.UserTeamAdminTeam1)
+		_, _, err = svc.EnqueueMDMAppleCommand(ctx, rawB64FreeCmd, nil, nil, []uint{1}, nil, "", false)
+		require.NoError(t, err)
+		_, _, err = svc.EnqueueMDMAppleCommand(ctx, rawB64FreeCmd, nil, nil, []uint{1, 2}, nil, "", false)
+		checkAuthErr(t, err, true)
 	})
 
 	cmdUUIDToHostUUIDs := map[string][]string{
@@ -400,6 +548,49 @@ func TestAppleMDMAuthorization(t *testing.T) {
 		}
 	})
 
+	t.Run("GetMDMAppleCommandResults withholds the activation lock bypass code", func(t *testing.T) {
+		getResults := ds.GetMDMAppleCommandResultsFunc
+		t.Cleanup(func() { ds.GetMDMAppleCommandResultsFunc = getResults })
+		ds.GetMDMAppleCommandResultsFunc = func(ctx context.Context, commandUUID string) ([]*fleet.MDMAppleCommandResult, error) {
+			res := make([]*fleet.MDMAppleCommandResult, 0, len(cmdUUIDToHostUUIDs[commandUUID]))
+			for _, h := range cmdUUIDToHostUUIDs[commandUUID] {
+				res = append(res, &fleet.MDMAppleCommandResult{
+					DeviceID:    h,
+					RequestType: "ActivationLockBypassCode",
+					Result:      []byte("AAAA-BBBB"),
+				})
+			}
+			return res, nil
+		}
+
+		cases := []struct {
+			user     *fleet.User
+			cmdUUID  string
+			withheld bool
+		}{
+			{test.UserAdmin, "uuidMixTm1Tm2", false},
+			{test.UserMaintainer, "uuidMixTm1Tm2", true},
+			{test.UserObserver, "uuidMixTm1Tm2", true},
+			{test.UserTeamAdminTeam1, "uuidTm1", false},
+			{test.UserTeamMaintainerTeam1, "uuidTm1", true},
+			{test.UserTeamObserverTeam1, "uuidTm1", true},
+		}
+		for _, c := range cases {
+			t.Run(c.user.Email, func(t *testing.T) {
+				results, err := svc.GetMDMAppleCommandResults(test.UserContext(ctx, c.user), c.cmdUUID)
+				require.NoError(t, err)
+				require.NotEmpty(t, results)
+				for _, res := range results {
+					if c.withheld {
+						require.Nil(t, res.Result)
+					} else {
+						require.Equal(t, []byte("AAAA-BBBB"), res.Result)
+					}
+				}
+			})
+		}
+	})
+
 	t.Run("ListMDMAppleCommands", func(t *testing.T) {
 		ds.ListMDMAppleCommandsFunc = func(ctx context.Context, tmFilter fleet.TeamFilter, opt *fleet.MDMAppleCommandListOptions) ([]*fleet.MDMAppleCommand, error) {
 			return []*fleet.MDMAppleCommand{
@@ -452,66 +643,105 @@ func TestMDMAppleConfigProfileAuthz(t *testing.T) {
 		user             *fleet.User
 		shouldFailGlobal bool
 		shouldFailTeam   bool
+		// observers can read the profiles but not change them
+		shouldFailGlobalRead bool
+		shouldFailTeamRead   bool
 	}{
 		{
 			"global admin",
 			&fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)},
 			false,
 			false,
+			false,
+			false,
 		},
 		{
 			"global maintainer",
 			&fleet.User{GlobalRole: ptr.String(fleet.RoleMaintainer)},
 			false,
 			false,
+			false,
+			false,
 		},
 		{
 			"global observer",
 			&fleet.User{GlobalRole: ptr.String(fleet.RoleObserver)},
 			true,
 			true,
+			false,
+			false,
+		},
+		{
+			"global observer+",
+			&fleet.User{GlobalRole: ptr.String(fleet.RoleObserverPlus)},
+			true,
+			true,
+			false,
+			false,
 		},
 		{
 			"team admin, belongs to team",
 			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleAdmin}}},
 			true,
 			false,
+			true,
+			false,
 		},
 		{
 			"team admin, DOES NOT belong to team",
 			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 2}, Role: fleet.RoleAdmin}}},
 			true,
 			true,
+			true,
+			true,
 		},
 		{
 			"team maintainer, belongs to team",
 			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleMaintainer}}},
 			true,
 			false,
+			true,
+			false,
 		},
 		{
 			"team maintainer, DOES NOT belong to team",
 			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 2}, Role: fleet.RoleMaintainer}}},
 			true,
 			true,
+			true,
+			true,
 		},
 		{
 			"team observer, belongs to team",
 			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleObserver}}},
 			true,
 			true,
+			true,
+			false,
 		},
 		{
 			"team observer, DOES NOT belong to team",
 			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 2}, Role: fleet.RoleObserver}}},
 			true,
 			true,
+			true,
+			true,
+		},
+		{
+			"team observer+, belongs to team",
+			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleObserverPlus}}},
+			true,
+			true,
+			true,
+			false,
 		},
 		{
 			"user no roles",
 			&fleet.User{ID: 1337},
 			true,
 			true,
+			true,
+			true,
 		},
 	}
 
@@ -525,11 +755,20 @@ func TestMDMAppleConfigProfileAuthz(t *testing.T) {
 		return nil
 	}
 	ds.GetMDMAppleHostsProfilesSummaryFunc = func(context.Context, *uint) (*fleet.MDMAppleConfigProfilesSummary, error) {
+		return &fleet.MDMAppleConfigProfilesSummary{}, nil
+	}
+	ds.ListMDMAppleReservedPayloadConflictsFunc = func(context.Context, *uint) ([]fleet.MDMAppleReservedPayloadConflict, error) {
 		return nil, nil
 	}
 	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
 		return nil
 	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		return 0, nil
+	}
+	ds.ListMDMAppleProfileIdentifierConflictsFunc = func(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+		return nil, nil
+	}
 	mockGetFuncWithTeamID := func(teamID uint) mock.GetMDMAppleConfigProfileFunc {
 		return func(ctx context.Context, profileID uint) (*fleet.MDMAppleConfigProfile, error) {
 			require.Equal(t, uint(42), profileID)
@@ -555,6 +794,13 @@ func TestMDMAppleConfigProfileAuthz(t *testing.T) {
 		}
 	}
 
+	mockGetJobFuncWithTeamID := func(teamID uint) mock.GetJobFunc {
+		return func(ctx context.Context, id uint) (*fleet.Job, error) {
+			args := json.RawMessage(fmt.Sprintf(`{"team_ids": [%d]}`, teamID))
+			return &fleet.Job{ID: id, Name: worker.MDMAppleBulkSetPendingName, Args: &args, State: fleet.JobStateQueued}, nil
+		}
+	}
+
 	checkShouldFail := func(err error, shouldFail bool) {
 		if !shouldFail {
 			require.NoError(t, err)
@@ -566,31 +812,60 @@ func TestMDMAppleConfigProfileAuthz(t *testing.T) {
 
 	mcBytes := mcBytesForTest("Foo", "Bar", "UUID")
 
+	mockListByIDsFuncWithTeamID := func(teamID uint) mock.ListMDMAppleConfigProfilesByIDsOrIdentifiersFunc {
+		return func(ctx context.Context, profileIDs []uint, identifiers []string) ([]*fleet.MDMAppleConfigProfile, error) {
+			require.Equal(t, []uint{42}, profileIDs)
+			return []*fleet.MDMAppleConfigProfile{{ProfileID: 42, TeamID: &teamID, Identifier: "Bar"}}, nil
+		}
+	}
+	mockGetWithContentFuncWithTeamID := func(teamID uint) mock.GetMDMAppleConfigProfileFunc {
+		return func(ctx context.Context, profileID uint) (*fleet.MDMAppleConfigProfile, error) {
+			return &fleet.MDMAppleConfigProfile{ProfileID: profileID, TeamID: &teamID, Identifier: "Bar", Mobileconfig: mcBytes}, nil
+		}
+	}
+	ds.DeleteMDMAppleConfigProfilesFunc = func(ctx context.Context, profileIDs []uint) error {
+		return nil
+	}
+	ds.CopyMDMAppleConfigProfileToTeamsFunc = func(ctx context.Context, cp *fleet.MDMAppleConfigProfile, teamIDs []uint) ([]*fleet.MDMAppleConfigProfile, error) {
+		return []*fleet.MDMAppleConfigProfile{cp}, nil
+	}
+	ds.ListMDMAppleEnrollmentMismatchesFunc = func(ctx context.Context, teamID *uint, mdmServerURL string) ([]*fleet.MDMAppleEnrollmentMismatch, error) {
+		return nil, nil
+	}
+
 	for _, tt := range testCases {
 		ctx := viewer.NewContext(ctx, viewer.Viewer{User: tt.user})
 		ds.TeamFunc = mockTeamFuncWithUser(tt.user)
 
 		t.Run(tt.name, func(t *testing.T) {
 			// test authz create new profile (no team)
-			_, err := svc.NewMDMAppleConfigProfile(ctx, 0, bytes.NewReader(mcBytes), int64(len(mcBytes)))
+			_, err := svc.NewMDMAppleConfigProfile(ctx, 0, bytes.NewReader(mcBytes), int64(len(mcBytes)), false, false)
 			checkShouldFail(err, tt.shouldFailGlobal)
 
 			// test authz create new profile (team 1)
-			_, err = svc.NewMDMAppleConfigProfile(ctx, 1, bytes.NewReader(mcBytes), int64(len(mcBytes)))
+			_, err = svc.NewMDMAppleConfigProfile(ctx, 1, bytes.NewReader(mcBytes), int64(len(mcBytes)), false, false)
 			checkShouldFail(err, tt.shouldFailTeam)
 
 			// test authz list profiles (no team)
 			_, err = svc.ListMDMAppleConfigProfiles(ctx, 0)
-			checkShouldFail(err, tt.shouldFailGlobal)
+			checkShouldFail(err, tt.shouldFailGlobalRead)
+
+			// test authz list identifier conflicts (no team)
+			_, err = svc.ListMDMAppleProfileIdentifierConflicts(ctx, nil, "Bar")
+			checkShouldFail(err, tt.shouldFailGlobalRead)
+
+			// test authz list identifier conflicts (team 1)
+			_, err = svc.ListMDMAppleProfileIdentifierConflicts(ctx, ptr.Uint(1), "Bar")
+			checkShouldFail(err, tt.shouldFailTeamRead)
 
 			// test authz list profiles (team 1)
 			_, err = svc.ListMDMAppleConfigProfiles(ctx, 1)
-			checkShouldFail(err, tt.shouldFailTeam)
+			checkShouldFail(err, tt.shouldFailTeamRead)
 
 			// test authz get config profile (no team)
 			ds.GetMDMAppleConfigProfileFunc = mockGetFuncWithTeamID(0)
 			_, err = svc.GetMDMAppleConfigProfile(ctx, 42)
-			checkShouldFail(err, tt.shouldFailGlobal)
+			checkShouldFail(err, tt.shouldFailGlobalRead)
 
 			// test authz delete config profile (no team)
 			ds.DeleteMDMAppleConfigProfileFunc = mockDeleteFuncWithTeamID(0)
@@ -600,24 +875,300 @@ func TestMDMAppleConfigProfileAuthz(t *testing.T) {
 			// test authz get config profile (team 1)
 			ds.GetMDMAppleConfigProfileFunc = mockGetFuncWithTeamID(1)
 			_, err = svc.GetMDMAppleConfigProfile(ctx, 42)
-			checkShouldFail(err, tt.shouldFailTeam)
+			checkShouldFail(err, tt.shouldFailTeamRead)
 
 			// test authz delete config profile (team 1)
 			ds.DeleteMDMAppleConfigProfileFunc = mockDeleteFuncWithTeamID(1)
 			err = svc.DeleteMDMAppleConfigProfile(ctx, 42)
 			checkShouldFail(err, tt.shouldFailTeam)
 
+			// test authz delete multiple config profiles (no team)
+			ds.ListMDMAppleConfigProfilesByIDsOrIdentifiersFunc = mockListByIDsFuncWithTeamID(0)
+			_, err = svc.DeleteMDMAppleConfigProfiles(ctx, []uint{42}, nil, nil)
+			checkShouldFail(err, tt.shouldFailGlobal)
+
+			// test authz delete multiple config profiles (team 1)
+			ds.ListMDMAppleConfigProfilesByIDsOrIdentifiersFunc = mockListByIDsFuncWithTeamID(1)
+			_, err = svc.DeleteMDMAppleConfigProfiles(ctx, []uint{42}, nil, nil)
+			checkShouldFail(err, tt.shouldFailTeam)
+
+			// test authz copy config profile (no team to no team)
+			ds.GetMDMAppleConfigProfileFunc = mockGetWithContentFuncWithTeamID(0)
+			_, err = svc.CopyMDMAppleConfigProfile(ctx, 42, []uint{0}, false)
+			checkShouldFail(err, tt.shouldFailGlobal)
+
+			// test authz copy config profile (team 1 to team 1)
+			ds.GetMDMAppleConfigProfileFunc = mockGetWithContentFuncWithTeamID(1)
+			_, err = svc.CopyMDMAppleConfigProfile(ctx, 42, []uint{1}, false)
+			checkShouldFail(err, tt.shouldFailTeam)
+
+			// test authz copy config profile (team 1 to no team)
+			_, err = svc.CopyMDMAppleConfigProfile(ctx, 42, []uint{0}, false)
+			checkShouldFail(err, tt.shouldFailGlobal || tt.shouldFailTeam)
+
 			// test authz get profiles summary (no team)
 			_, err = svc.GetMDMAppleProfilesSummary(ctx, nil)
-			checkShouldFail(err, tt.shouldFailGlobal)
+			checkShouldFail(err, tt.shouldFailGlobalRead)
 
 			// test authz get profiles summary (no team)
 			_, err = svc.GetMDMAppleProfilesSummary(ctx, ptr.Uint(1))
+			checkShouldFail(err, tt.shouldFailTeamRead)
+
+			// test authz list enrollment mismatches (no team)
+			_, err = svc.ListMDMAppleEnrollmentMismatches(ctx, nil)
+			checkShouldFail(err, tt.shouldFailGlobalRead)
+
+			// test authz list enrollment mismatches (team 1)
+			_, err = svc.ListMDMAppleEnrollmentMismatches(ctx, ptr.Uint(1))
+			checkShouldFail(err, tt.shouldFailTeamRead)
+
+			// test authz get profiles job (no team)
+			ds.GetJobFunc = mockGetJobFuncWithTeamID(0)
+			_, err = svc.GetMDMAppleProfilesJob(ctx, 1)
+			checkShouldFail(err, tt.shouldFailGlobal)
+
+			// test authz get profiles job (team 1)
+			ds.GetJobFunc = mockGetJobFuncWithTeamID(1)
+			_, err = svc.GetMDMAppleProfilesJob(ctx, 1)
 			checkShouldFail(err, tt.shouldFailTeam)
 		})
 	}
 }
 
+func TestDeleteMDMAppleConfigProfiles(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}})
+
+	profs := []*fleet.MDMAppleConfigProfile{
+		{ProfileID: 1, TeamID: ptr.Uint(0), Name: "N1", Identifier: "I1"},
+		{ProfileID: 2, TeamID: ptr.Uint(1), Name: "N1", Identifier: "I1"},
+		{ProfileID: 3, TeamID: ptr.Uint(2), Name: "N1", Identifier: "I1"},
+		{ProfileID: 4, TeamID: ptr.Uint(2), Name: "N2", Identifier: "I2"},
+	}
+	ds.ListMDMAppleConfigProfilesByIDsOrIdentifiersFunc = func(ctx context.Context, profileIDs []uint, identifiers []string) ([]*fleet.MDMAppleConfigProfile, error) {
+		var res []*fleet.MDMAppleConfigProfile
+		for _, p := range profs {
+			for _, id := range profileIDs {
+				if p.ProfileID == id {
+					res = append(res, p)
+				}
+			}
+			for _, ident := range identifiers {
+				if p.Identifier == ident {
+					res = append(res, p)
+				}
+			}
+		}
+		return res, nil
+	}
+	var deletedIDs []uint
+	ds.DeleteMDMAppleConfigProfilesFunc = func(ctx context.Context, profileIDs []uint) error {
+		deletedIDs = profileIDs
+		return nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: tid, Name: fmt.Sprintf("team%d", tid)}, nil
+	}
+	var pendingTeamIDs []uint
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		pendingTeamIDs = tids
+		return 0, nil
+	}
+	var act *fleet.ActivityTypeDeletedMultipleMacosProfiles
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		act = activity.(*fleet.ActivityTypeDeletedMultipleMacosProfiles)
+		return nil
+	}
+
+	// by identifier, in all teams
+	ids, err := svc.DeleteMDMAppleConfigProfiles(ctx, nil, []string{"I1"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []uint{1, 2, 3}, ids)
+	require.Equal(t, []uint{1, 2, 3}, deletedIDs)
+	require.Equal(t, []uint{0, 1, 2}, pendingTeamIDs)
+	require.Len(t, act.Profiles, 3)
+	require.Nil(t, act.Profiles[0].TeamID)
+	require.Nil(t, act.Profiles[0].TeamName)
+	require.Equal(t, uint(1), *act.Profiles[1].TeamID)
+	require.Equal(t, "team1", *act.Profiles[1].TeamName)
+	require.Equal(t, "I1", act.Profiles[2].ProfileIdentifier)
+
+	// by identifier in some teams, and by id
+	ids, err = svc.DeleteMDMAppleConfigProfiles(ctx, []uint{4}, []string{"I1"}, []uint{0, 1})
+	require.NoError(t, err)
+	require.Equal(t, []uint{1, 2, 4}, ids)
+	require.Equal(t, []uint{0, 1, 2}, pendingTeamIDs)
+
+	// no profile matching the identifier
+	ds.DeleteMDMAppleConfigProfilesFuncInvoked = false
+	ids, err = svc.DeleteMDMAppleConfigProfiles(ctx, nil, []string{"I3"}, nil)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	require.False(t, ds.DeleteMDMAppleConfigProfilesFuncInvoked)
+
+	// unknown profile id
+	_, err = svc.DeleteMDMAppleConfigProfiles(ctx, []uint{1, 99}, nil, nil)
+	require.True(t, fleet.IsNotFound(err))
+	require.False(t, ds.DeleteMDMAppleConfigProfilesFuncInvoked)
+
+	// nothing to delete
+	_, err = svc.DeleteMDMAppleConfigProfiles(ctx, nil, nil, []uint{1})
+	require.ErrorContains(t, err, "at least one of profile_ids or identifiers is required")
+
+	// profiles managed by fleet cannot be deleted
+	profs = append(profs, &fleet.MDMAppleConfigProfile{ProfileID: 5, TeamID: ptr.Uint(0), Identifier: mobileconfig.FleetFileVaultPayloadIdentifier})
+	_, err = svc.DeleteMDMAppleConfigProfiles(ctx, []uint{1, 5}, nil, nil)
+	require.ErrorContains(t, err, "profiles managed by Fleet can't be deleted")
+	require.False(t, ds.DeleteMDMAppleConfigProfilesFuncInvoked)
+}
+
+func TestCopyMDMAppleConfigProfile(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}})
+
+	mcBytes := mcBytesForTest("Foo", "Bar", "UUID")
+	ds.GetMDMAppleConfigProfileFunc = func(ctx context.Context, profileID uint) (*fleet.MDMAppleConfigProfile, error) {
+		return &fleet.MDMAppleConfigProfile{ProfileID: profileID, TeamID: ptr.Uint(1), Name: "Foo", Identifier: "Bar", Mobileconfig: mcBytes}, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: tid, Name: fmt.Sprintf("team%d", tid)}, nil
+	}
+	ds.CopyMDMAppleConfigProfileToTeamsFunc = func(ctx context.Context, cp *fleet.MDMAppleConfigProfile, teamIDs []uint) ([]*fleet.MDMAppleConfigProfile, error) {
+		require.Equal(t, "Bar", cp.Identifier)
+		require.Equal(t, mcBytes, []byte(cp.Mobileconfig))
+		var res []*fleet.MDMAppleConfigProfile
+		for i, tid := range teamIDs {
+			tid := tid
+			res = append(res, &fleet.MDMAppleConfigProfile{ProfileID: uint(100 + i), TeamID: &tid, Identifier: cp.Identifier})
+		}
+		return res, nil
+	}
+	var pendingProfileIDs []uint
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		pendingProfileIDs = pids
+		return 0, nil
+	}
+	ds.ListMDMAppleProfileIdentifierConflictsFunc = func(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+		return nil, nil
+	}
+	var act *fleet.ActivityTypeCopiedMacosProfile
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		act = activity.(*fleet.ActivityTypeCopiedMacosProfile)
+		return nil
+	}
+
+	copies, err := svc.CopyMDMAppleConfigProfile(ctx, 1, []uint{2, 0}, false)
+	require.NoError(t, err)
+	require.Len(t, copies, 2)
+	require.Equal(t, []uint{100, 101}, pendingProfileIDs)
+	require.Equal(t, "Bar", act.ProfileIdentifier)
+	require.Equal(t, uint(1), *act.TeamID)
+	require.Equal(t, "team1", *act.TeamName)
+	require.Len(t, act.TargetTeams, 2)
+	require.Equal(t, uint(2), *act.TargetTeams[0].TeamID)
+	require.Equal(t, "team2", *act.TargetTeams[0].TeamName)
+	require.Nil(t, act.TargetTeams[1].TeamID)
+
+	// no target team
+	_, err = svc.CopyMDMAppleConfigProfile(ctx, 1, nil, false)
+	require.ErrorContains(t, err, "at least one team is required")
+
+	// the identifier conflicts with a profile from another source on hosts of
+	// a target team
+	ds.ListMDMAppleProfileIdentifierConflictsFunc = func(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+		if *teamID == 2 {
+			return []*fleet.MDMAppleProfileIdentifierConflict{{Identifier: "Bar", HostsCount: 3}}, nil
+		}
+		return nil, nil
+	}
+	ds.CopyMDMAppleConfigProfileToTeamsFuncInvoked = false
+	_, err = svc.CopyMDMAppleConfigProfile(ctx, 1, []uint{0, 2}, false)
+	var se interface{ Status() int }
+	require.ErrorAs(t, err, &se)
+	require.Equal(t, http.StatusConflict, se.Status())
+	require.False(t, ds.CopyMDMAppleConfigProfileToTeamsFuncInvoked)
+
+	// forcing the copy ignores the conflicts
+	_, err = svc.CopyMDMAppleConfigProfile(ctx, 1, []uint{0, 2}, true)
+	require.NoError(t, err)
+	require.True(t, ds.CopyMDMAppleConfigProfileToTeamsFuncInvoked)
+
+	// reserved payloads are rejected if the target team does not allow them
+	mcBytes = mobileconfigForTestWithContent("N1", "I1", "II1", "com.apple.MCX.FileVault2")
+	ds.CopyMDMAppleConfigProfileToTeamsFuncInvoked = false
+	_, err = svc.CopyMDMAppleConfigProfile(ctx, 1, []uint{2}, true)
+	require.ErrorContains(t, err, "unsupported PayloadType(s): com.apple.MCX.FileVault2")
+	require.False(t, ds.CopyMDMAppleConfigProfileToTeamsFuncInvoked)
+}
+
+func TestRetryMDMAppleConfigProfile(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}})
+
+	ds.GetMDMAppleConfigProfileFunc = func(ctx context.Context, profileID uint) (*fleet.MDMAppleConfigProfile, error) {
+		return &fleet.MDMAppleConfigProfile{ProfileID: profileID, TeamID: ptr.Uint(1), Name: "Foo", Identifier: "Bar"}, nil
+	}
+	host := &fleet.Host{ID: 1, UUID: "host-uuid", Platform: "darwin", TeamID: ptr.Uint(1)}
+	ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
+		if id != host.ID {
+			return nil, newNotFoundError()
+		}
+		return host, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		if tid != 1 {
+			return nil, newNotFoundError()
+		}
+		return &fleet.Team{ID: tid}, nil
+	}
+	var gotHostUUID string
+	var gotTeamID uint
+	ds.ResetFailedHostMDMAppleProfileFunc = func(ctx context.Context, profileID uint, hostUUID string, teamID uint) (int64, error) {
+		require.Equal(t, uint(1), profileID)
+		gotHostUUID, gotTeamID = hostUUID, teamID
+		return 3, nil
+	}
+
+	// for a host
+	n, err := svc.RetryMDMAppleConfigProfile(ctx, 1, &host.ID, nil)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, n)
+	require.Equal(t, host.UUID, gotHostUUID)
+
+	// for a team
+	n, err = svc.RetryMDMAppleConfigProfile(ctx, 1, nil, ptr.Uint(1))
+	require.NoError(t, err)
+	require.EqualValues(t, 3, n)
+	require.Empty(t, gotHostUUID)
+	require.Equal(t, uint(1), gotTeamID)
+
+	// for no team
+	_, err = svc.RetryMDMAppleConfigProfile(ctx, 1, nil, ptr.Uint(0))
+	require.NoError(t, err)
+	require.Zero(t, gotTeamID)
+
+	// exactly one of host or team
+	ds.ResetFailedHostMDMAppleProfileFuncInvoked = false
+	_, err = svc.RetryMDMAppleConfigProfile(ctx, 1, nil, nil)
+	require.ErrorContains(t, err, "exactly one of host_id or team_id must be provided")
+	_, err = svc.RetryMDMAppleConfigProfile(ctx, 1, &host.ID, ptr.Uint(1))
+	require.ErrorContains(t, err, "exactly one of host_id or team_id must be provided")
+
+	// unknown host or team
+	_, err = svc.RetryMDMAppleConfigProfile(ctx, 1, ptr.Uint(99), nil)
+	require.True(t, fleet.IsNotFound(err))
+	_, err = svc.RetryMDMAppleConfigProfile(ctx, 1, nil, ptr.Uint(99))
+	require.True(t, fleet.IsNotFound(err))
+	require.False(t, ds.ResetFailedHostMDMAppleProfileFuncInvoked)
+
+	// an observer can't force the retry
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleObserver)}})
+	_, err = svc.RetryMDMAppleConfigProfile(ctx, 1, nil, ptr.Uint(1))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), authz.ForbiddenErrorMessage)
+	require.False(t, ds.ResetFailedHostMDMAppleProfileFuncInvoked)
+}
+
 func TestNewMDMAppleConfigProfile(t *testing.T) {
 	svc, ctx, ds := setupAppleMDMService(t)
 	ctx = viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}})
@@ -638,64 +1189,273 @@ func TestNewMDMAppleConfigProfile(t *testing.T) {
 	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
 		return nil
 	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		return 0, nil
+	}
+	ds.ListMDMAppleProfileIdentifierConflictsFunc = func(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+		return nil, nil
+	}
 
-	cp, err := svc.NewMDMAppleConfigProfile(ctx, 0, r, r.Size())
+	cp, err := svc.NewMDMAppleConfigProfile(ctx, 0, r, r.Size(), false, false)
 	require.NoError(t, err)
 	require.Equal(t, "Foo", cp.Name)
 	require.Equal(t, "Bar", cp.Identifier)
 	require.Equal(t, mcBytes, []byte(cp.Mobileconfig))
-}
 
-func mcBytesForTest(name, identifier, uuid string) []byte {
-	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
-<plist version="1.0">
-<dict>
-	<key>PayloadContent</key>
-	<array/>
-	<key>PayloadDisplayName</key>
-	<string>%s</string>
-	<key>PayloadIdentifier</key>
-	<string>%s</string>
-	<key>PayloadType</key>
-	<string>Configuration</string>
-	<key>PayloadUUID</key>
-	<string>%s</string>
-	<key>PayloadVersion</key>
-	<integer>1</integer>
-</dict>
-</plist>
-`, name, identifier, uuid))
+	// the identifier is already used by a profile from another source on some
+	// hosts of the team
+	ds.ListMDMAppleProfileIdentifierConflictsFunc = func(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+		require.Equal(t, []string{"Bar"}, identifiers)
+		return []*fleet.MDMAppleProfileIdentifierConflict{{Identifier: "Bar", HostsCount: 3}}, nil
+	}
+	ds.NewMDMAppleConfigProfileFuncInvoked = false
+	_, err = svc.NewMDMAppleConfigProfile(ctx, 0, bytes.NewReader(mcBytes), int64(len(mcBytes)), false, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `The identifier (PayloadIdentifier) "Bar" is already used by a profile from another source on 3 host(s) of this team.`)
+	var se interface{ Status() int }
+	require.ErrorAs(t, err, &se)
+	require.Equal(t, http.StatusConflict, se.Status())
+	require.False(t, ds.NewMDMAppleConfigProfileFuncInvoked)
+
+	// forcing the upload ignores the conflicts
+	_, err = svc.NewMDMAppleConfigProfile(ctx, 0, bytes.NewReader(mcBytes), int64(len(mcBytes)), true, false)
+	require.NoError(t, err)
+	require.True(t, ds.NewMDMAppleConfigProfileFuncInvoked)
 }
 
-func TestHostDetailsMDMProfiles(t *testing.T) {
+func TestNewMDMAppleConfigProfileReservedPayloads(t *testing.T) {
 	svc, ctx, ds := setupAppleMDMService(t)
 	ctx = viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}})
 
-	expected := []fleet.HostMDMAppleProfile{
-		{HostUUID: "H057-UU1D-1337", Name: "NAME-5", ProfileID: uint(5), CommandUUID: "CMD-UU1D-5", Status: &fleet.MDMAppleDeliveryPending, OperationType: fleet.MDMAppleOperationTypeInstall, Detail: ""},
-		{HostUUID: "H057-UU1D-1337", Name: "NAME-9", ProfileID: uint(8), CommandUUID: "CMD-UU1D-8", Status: &fleet.MDMAppleDeliveryVerifying, OperationType: fleet.MDMAppleOperationTypeInstall, Detail: ""},
-		{HostUUID: "H057-UU1D-1337", Name: "NAME-13", ProfileID: uint(13), CommandUUID: "CMD-UU1D-13", Status: &fleet.MDMAppleDeliveryFailed, OperationType: fleet.MDMAppleOperationTypeRemove, Detail: "Error removing profile"},
+	var allowReserved bool
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		appCfg := &fleet.AppConfig{}
+		appCfg.MDM.MacOSSettings.AllowReservedPayloads = allowReserved
+		return appCfg, nil
 	}
-	expectedByProfileID := make(map[uint]fleet.HostMDMAppleProfile)
-	for _, ep := range expected {
-		expectedByProfileID[ep.ProfileID] = ep
+	var gotTypes []string
+	ds.NewMDMAppleConfigProfileFunc = func(ctx context.Context, cp fleet.MDMAppleConfigProfile) (*fleet.MDMAppleConfigProfile, error) {
+		gotTypes = cp.ReservedPayloadTypes
+		cp.ProfileID = 1
+		return &cp, nil
 	}
-
-	ds.GetHostMDMProfilesFunc = func(ctx context.Context, hostUUID string) ([]fleet.HostMDMAppleProfile, error) {
-		if hostUUID == "H057-UU1D-1337" {
-			return expected, nil
-		}
-		return []fleet.HostMDMAppleProfile{}, nil
+	ds.NewActivityFunc = func(context.Context, *fleet.User, fleet.ActivityDetails) error {
+		return nil
 	}
-	ds.HostFunc = func(ctx context.Context, hostID uint) (*fleet.Host, error) {
-		if hostID == uint(42) {
-			return &fleet.Host{ID: uint(42), UUID: "H057-UU1D-1337"}, nil
-		}
-		return &fleet.Host{ID: hostID, UUID: "WR0N6-UU1D"}, nil
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		return 0, nil
 	}
-	ds.HostByIdentifierFunc = func(ctx context.Context, identifier string) (*fleet.Host, error) {
-		if identifier == "h0571d3n71f13r" {
+	ds.ListMDMAppleProfileIdentifierConflictsFunc = func(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+		return nil, nil
+	}
+
+	mcBytes := mobileconfigForTestWithContent("N1", "I1", "II1", "com.apple.MCX.FileVault2")
+	upload := func(ack bool) error {
+		_, err := svc.NewMDMAppleConfigProfile(ctx, 0, bytes.NewReader(mcBytes), int64(len(mcBytes)), false, ack)
+		return err
+	}
+
+	// reserved payloads are rejected if the team does not allow them, even if
+	// acknowledged
+	err := upload(true)
+	require.ErrorContains(t, err, "unsupported PayloadType(s): com.apple.MCX.FileVault2")
+	require.False(t, ds.NewMDMAppleConfigProfileFuncInvoked)
+
+	// the team allows them, but they must be acknowledged
+	allowReserved = true
+	err = upload(false)
+	require.ErrorContains(t, err, "PayloadType(s) reserved by Fleet: com.apple.MCX.FileVault2")
+	require.False(t, ds.NewMDMAppleConfigProfileFuncInvoked)
+
+	err = upload(true)
+	require.NoError(t, err)
+	require.True(t, ds.NewMDMAppleConfigProfileFuncInvoked)
+	require.Equal(t, []string{"com.apple.MCX.FileVault2"}, gotTypes)
+
+	// reserved identifiers are always rejected
+	mcBytes = mobileconfigForTestWithContent("N2", "I2", mobileconfig.FleetFileVaultPayloadIdentifier, "com.apple.security.firewall")
+	err = upload(true)
+	require.ErrorContains(t, err, "unsupported PayloadIdentifier(s): "+mobileconfig.FleetFileVaultPayloadIdentifier)
+}
+
+func TestNewMDMAppleConfigProfileSecrets(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}})
+
+	// no secrets manager is configured, profiles referencing secrets are rejected
+	mcBytes := mcBytesForTest("Wi-Fi $FLEET_SECRET_WIFI_PASSWORD", "I1", "UUID1")
+	_, err := svc.NewMDMAppleConfigProfile(ctx, 0, bytes.NewReader(mcBytes), int64(len(mcBytes)), false, false)
+	require.ErrorContains(t, err, "The profile references secrets (WIFI_PASSWORD) but no secrets manager is configured.")
+	require.False(t, ds.NewMDMAppleConfigProfileFuncInvoked)
+
+	cp := &fleet.MDMAppleConfigProfile{Mobileconfig: mcBytes}
+	require.Error(t, validateMDMAppleProfileSecrets(cp, false))
+	require.NoError(t, validateMDMAppleProfileSecrets(cp, true))
+	cp.Mobileconfig = mcBytesForTest("Wi-Fi", "I1", "UUID1")
+	require.NoError(t, validateMDMAppleProfileSecrets(cp, false))
+}
+
+func TestMDMAppleHostProfile(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}})
+
+	host := &fleet.Host{ID: 1, UUID: "host-uuid", Platform: "darwin", TeamID: ptr.Uint(2), Hostname: "host1"}
+	ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
+		if id != host.ID {
+			return nil, newNotFoundError()
+		}
+		return host, nil
+	}
+	var hostProfiles []fleet.HostMDMAppleProfile
+	ds.GetHostMDMProfilesFunc = func(ctx context.Context, hostUUID string) ([]fleet.HostMDMAppleProfile, error) {
+		require.Equal(t, host.UUID, hostUUID)
+		return hostProfiles, nil
+	}
+	var created *fleet.MDMAppleConfigProfile
+	ds.NewMDMAppleConfigProfileFunc = func(ctx context.Context, cp fleet.MDMAppleConfigProfile) (*fleet.MDMAppleConfigProfile, error) {
+		cp.ProfileID = 10
+		created = &cp
+		return &cp, nil
+	}
+	ds.GetMDMAppleConfigProfileFunc = func(ctx context.Context, profileID uint) (*fleet.MDMAppleConfigProfile, error) {
+		switch profileID {
+		case created.ProfileID:
+			return created, nil
+		case 11:
+			return &fleet.MDMAppleConfigProfile{ProfileID: 11, TeamID: ptr.Uint(2), Identifier: "team"}, nil
+		}
+		return nil, newNotFoundError()
+	}
+	ds.DeleteMDMAppleConfigProfileFunc = func(ctx context.Context, profileID uint) error {
+		require.Equal(t, created.ProfileID, profileID)
+		return nil
+	}
+	var pendingHostIDs []uint
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		pendingHostIDs = hids
+		return 0, nil
+	}
+	var activities []fleet.ActivityDetails
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		activities = append(activities, activity)
+		return nil
+	}
+
+	mcBytes := mcBytesForTest("Debug", "com.example.debug", "UUID")
+	install := func(hostID uint, force bool) (*fleet.MDMAppleConfigProfile, error) {
+		return svc.InstallMDMAppleHostProfile(ctx, hostID, bytes.NewReader(mcBytes), int64(len(mcBytes)), force)
+	}
+
+	cp, err := install(host.ID, false)
+	require.NoError(t, err)
+	require.Equal(t, uint(10), cp.ProfileID)
+	require.Equal(t, host.ID, created.HostID)
+	require.Equal(t, fleet.MDMAppleHostProfilesTeamID, *created.TeamID)
+	require.Equal(t, []uint{host.ID}, pendingHostIDs)
+	require.Equal(t, []fleet.ActivityDetails{&fleet.ActivityTypeInstalledHostMacosProfile{
+		HostID:            host.ID,
+		HostDisplayName:   "host1",
+		ProfileName:       "Debug",
+		ProfileIdentifier: "com.example.debug",
+	}}, activities)
+
+	// the identifier is already used by a profile installed on the host
+	hostProfiles = []fleet.HostMDMAppleProfile{{Identifier: "com.example.debug", OperationType: fleet.MDMAppleOperationTypeInstall}}
+	ds.NewMDMAppleConfigProfileFuncInvoked = false
+	_, err = install(host.ID, false)
+	require.ErrorContains(t, err, `The identifier (PayloadIdentifier) "com.example.debug" is already used by a profile installed on the host.`)
+	var se interface{ Status() int }
+	require.ErrorAs(t, err, &se)
+	require.Equal(t, http.StatusConflict, se.Status())
+	require.False(t, ds.NewMDMAppleConfigProfileFuncInvoked)
+
+	_, err = install(host.ID, true)
+	require.NoError(t, err)
+	require.True(t, ds.NewMDMAppleConfigProfileFuncInvoked)
+
+	// only macOS hosts are supported
+	host.Platform = "windows"
+	_, err = install(host.ID, false)
+	require.ErrorContains(t, err, "Profiles can only be installed on macOS hosts.")
+	host.Platform = "darwin"
+
+	_, err = install(123, false)
+	require.True(t, fleet.IsNotFound(err))
+
+	// a team observer can't install profiles
+	obsCtx := viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 2}, Role: fleet.RoleObserver}}}})
+	_, err = svc.InstallMDMAppleHostProfile(obsCtx, host.ID, bytes.NewReader(mcBytes), int64(len(mcBytes)), false)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+
+	// team profiles can't be deleted from the host
+	err = svc.DeleteMDMAppleHostProfile(ctx, host.ID, 11)
+	require.True(t, fleet.IsNotFound(err))
+	require.False(t, ds.DeleteMDMAppleConfigProfileFuncInvoked)
+
+	activities = nil
+	pendingHostIDs = nil
+	err = svc.DeleteMDMAppleHostProfile(ctx, host.ID, created.ProfileID)
+	require.NoError(t, err)
+	require.True(t, ds.DeleteMDMAppleConfigProfileFuncInvoked)
+	require.Equal(t, []uint{host.ID}, pendingHostIDs)
+	require.Equal(t, []fleet.ActivityDetails{&fleet.ActivityTypeDeletedHostMacosProfile{
+		HostID:            host.ID,
+		HostDisplayName:   "host1",
+		ProfileName:       "Debug",
+		ProfileIdentifier: "com.example.debug",
+	}}, activities)
+}
+
+func mcBytesForTest(name, identifier, uuid string) []byte {
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array/>
+	<key>PayloadDisplayName</key>
+	<string>%s</string>
+	<key>PayloadIdentifier</key>
+	<string>%s</string>
+	<key>PayloadType</key>
+	<string>Configuration</string>
+	<key>PayloadUUID</key>
+	<string>%s</string>
+	<key>PayloadVersion</key>
+	<integer>1</integer>
+</dict>
+</plist>
+`, name, identifier, uuid))
+}
+
+func TestHostDetailsMDMProfiles(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}})
+
+	expected := []fleet.HostMDMAppleProfile{
+		{HostUUID: "H057-UU1D-1337", Name: "NAME-5", ProfileID: uint(5), CommandUUID: "CMD-UU1D-5", Status: &fleet.MDMAppleDeliveryPending, OperationType: fleet.MDMAppleOperationTypeInstall, Detail: ""},
+		{HostUUID: "H057-UU1D-1337", Name: "NAME-9", ProfileID: uint(8), CommandUUID: "CMD-UU1D-8", Status: &fleet.MDMAppleDeliveryVerifying, OperationType: fleet.MDMAppleOperationTypeInstall, Detail: ""},
+		{HostUUID: "H057-UU1D-1337", Name: "NAME-13", ProfileID: uint(13), CommandUUID: "CMD-UU1D-13", Status: &fleet.MDMAppleDeliveryFailed, OperationType: fleet.MDMAppleOperationTypeRemove, Detail: "Error removing profile"},
+	}
+	expectedByProfileID := make(map[uint]fleet.HostMDMAppleProfile)
+	for _, ep := range expected {
+		expectedByProfileID[ep.ProfileID] = ep
+	}
+
+	ds.GetHostMDMProfilesFunc = func(ctx context.Context, hostUUID string) ([]fleet.HostMDMAppleProfile, error) {
+		if hostUUID == "H057-UU1D-1337" {
+			return expected, nil
+		}
+		return []fleet.HostMDMAppleProfile{}, nil
+	}
+	ds.HostFunc = func(ctx context.Context, hostID uint) (*fleet.Host, error) {
+		if hostID == uint(42) {
+			return &fleet.Host{ID: uint(42), UUID: "H057-UU1D-1337"}, nil
+		}
+		return &fleet.Host{ID: hostID, UUID: "WR0N6-UU1D"}, nil
+	}
+	ds.HostByIdentifierFunc = func(ctx context.Context, identifier string) (*fleet.Host, error) {
+		if identifier == "h0571d3n71f13r" {
 			return &fleet.Host{ID: uint(42), UUID: "H057-UU1D-1337"}, nil
 		}
 		return &fleet.Host{ID: uint(21), UUID: "WR0N6-UU1D"}, nil
@@ -712,12 +1472,18 @@ func TestHostDetailsMDMProfiles(t *testing.T) {
 	ds.ListHostBatteriesFunc = func(ctx context.Context, id uint) ([]*fleet.HostBattery, error) {
 		return nil, nil
 	}
+	ds.GetHostAssignedUserFunc = func(ctx context.Context, hostID uint) (*fleet.HostAssignedUser, error) {
+		return nil, &notFoundError{}
+	}
 	ds.ListPoliciesForHostFunc = func(ctx context.Context, host *fleet.Host) ([]*fleet.HostPolicy, error) {
 		return nil, nil
 	}
 	ds.GetHostMDMMacOSSetupFunc = func(ctx context.Context, hostID uint) (*fleet.HostMDMMacOSSetup, error) {
 		return nil, nil
 	}
+	ds.GetHostMDMIdPAccountFunc = func(ctx context.Context, hostUUID string) (*fleet.MDMIdPAccount, error) {
+		return nil, &notFoundError{}
+	}
 
 	expectedNilSlice := []fleet.HostMDMAppleProfile(nil)
 	expectedEmptySlice := []fleet.HostMDMAppleProfile{}
@@ -942,18 +1708,20 @@ func TestMDMCommandAuthz(t *testing.T) {
 			ctx := viewer.NewContext(ctx, viewer.Viewer{User: tt.user})
 
 			mdmEnabled.Store(true)
-			err := svc.EnqueueMDMAppleCommandRemoveEnrollmentProfile(ctx, 42) // global host
+			res, err := svc.EnqueueMDMAppleCommandRemoveEnrollmentProfile(ctx, 42) // global host
 			if !tt.shouldFailGlobal {
 				require.NoError(t, err)
+				require.Equal(t, fleet.MDMAppleUnenrollStatusUnenrolled, res.Status)
 			} else {
 				require.Error(t, err)
 				require.Contains(t, err.Error(), authz.ForbiddenErrorMessage)
 			}
 
 			mdmEnabled.Store(true)
-			err = svc.EnqueueMDMAppleCommandRemoveEnrollmentProfile(ctx, 1) // host belongs to team 1
+			res, err = svc.EnqueueMDMAppleCommandRemoveEnrollmentProfile(ctx, 1) // host belongs to team 1
 			if !tt.shouldFailTeam {
 				require.NoError(t, err)
+				require.Equal(t, fleet.MDMAppleUnenrollStatusUnenrolled, res.Status)
 			} else {
 				require.Error(t, err)
 				require.Contains(t, err.Error(), authz.ForbiddenErrorMessage)
@@ -962,53 +1730,64 @@ func TestMDMCommandAuthz(t *testing.T) {
 	}
 }
 
-func TestMDMAuthenticate(t *testing.T) {
+func TestMDMAppleUnenrollPushFailure(t *testing.T) {
 	ds := new(mock.Store)
-	svc := MDMAppleCheckinAndCommandService{ds: ds}
-	ctx := context.Background()
-	uuid, serial, model := "ABC-DEF-GHI", "XYZABC", "MacBookPro 16,1"
+	mdmStorage := &nanomdm_mock.Storage{}
+	pushFactory, _ := newMockAPNSPushProviderFactory()
+	pusher := nanomdm_pushsvc.New(
+		mdmStorage,
+		mdmStorage,
+		pushFactory,
+		NewNanoMDMLogger(kitlog.NewNopLogger()),
+	)
+	svc, ctx := newTestService(t, ds, nil, nil, &TestServerOpts{MDMStorage: mdmStorage, MDMPusher: pusher})
+	ctx = test.UserContext(ctx, test.UserAdmin)
 
-	ds.IngestMDMAppleDeviceFromCheckinFunc = func(ctx context.Context, mdmHost fleet.MDMAppleHostDetails) error {
-		require.Equal(t, uuid, mdmHost.UDID)
-		require.Equal(t, serial, mdmHost.SerialNumber)
-		require.Equal(t, model, mdmHost.Model)
+	ds.HostLiteFunc = func(ctx context.Context, hostID uint) (*fleet.Host, error) {
+		return &fleet.Host{ID: hostID, UUID: "test-host"}, nil
+	}
+	ds.GetHostMDMCheckinInfoFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		return &fleet.HostMDMCheckinInfo{}, nil
+	}
+	ds.GetNanoMDMEnrollmentFunc = func(ctx context.Context, hostUUID string) (*fleet.NanoEnrollment, error) {
+		return &fleet.NanoEnrollment{Enabled: true}, nil
+	}
+	ds.NewActivityFunc = func(context.Context, *fleet.User, fleet.ActivityDetails) error {
 		return nil
 	}
 
-	ds.GetHostMDMCheckinInfoFunc = func(ct context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
-		require.Equal(t, uuid, hostUUID)
-		return &fleet.HostMDMCheckinInfo{HardwareSerial: serial, DisplayName: fmt.Sprintf("%s (%s)", model, serial), InstalledFromDEP: false}, nil
+	var enqueued []string
+	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+		require.Equal(t, "RemoveProfile", cmd.Command.RequestType)
+		enqueued = append(enqueued, id...)
+		return nil, nil
 	}
-
-	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
-		a, ok := activity.(*fleet.ActivityTypeMDMEnrolled)
-		require.True(t, ok)
-		require.Nil(t, user)
-		require.Equal(t, "mdm_enrolled", activity.ActivityName())
-		require.Equal(t, serial, a.HostSerial)
-		require.Equal(t, a.HostDisplayName, fmt.Sprintf("%s (%s)", model, serial))
-		require.False(t, a.InstalledFromDEP)
-		return nil
+	mdmStorage.RetrievePushInfoFunc = func(ctx context.Context, tokens []string) (map[string]*mdm.Push, error) {
+		return nil, errors.New("push unavailable")
 	}
 
-	err := svc.Authenticate(
-		&mdm.Request{Context: ctx},
-		&mdm.Authenticate{
-			Enrollment: mdm.Enrollment{
-				UDID: uuid,
-			},
-			SerialNumber: serial,
-			Model:        model,
-		},
-	)
+	// the command is enqueued even if the push fails, and the host is reported
+	// as pending without waiting for it to respond.
+	start := time.Now()
+	res, err := svc.EnqueueMDMAppleCommandRemoveEnrollmentProfile(ctx, 42)
 	require.NoError(t, err)
-	require.True(t, ds.IngestMDMAppleDeviceFromCheckinFuncInvoked)
-	require.True(t, ds.GetHostMDMCheckinInfoFuncInvoked)
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.Equal(t, []string{"test-host"}, enqueued)
+	require.Equal(t, uint(42), res.HostID)
+	require.NotEmpty(t, res.CommandUUID)
+	require.Equal(t, fleet.MDMAppleUnenrollStatusPending, res.Status)
+	require.True(t, res.PushFailed)
 	require.True(t, ds.NewActivityFuncInvoked)
+
+	// if the command can't be enqueued, the request fails
+	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+		return nil, errors.New("enqueue failed")
+	}
+	_, err = svc.EnqueueMDMAppleCommandRemoveEnrollmentProfile(ctx, 42)
+	require.ErrorContains(t, err, "enqueue failed")
 }
 
-func TestMDMTokenUpdate(t *testing.T) {
-	ctx := context.Background()
+func TestInstallMDMAppleFleetd(t *testing.T) {
 	ds := new(mock.Store)
 	mdmStorage := &nanomdm_mock.Storage{}
 	pushFactory, _ := newMockAPNSPushProviderFactory()
@@ -1016,261 +1795,1937 @@ func TestMDMTokenUpdate(t *testing.T) {
 		mdmStorage,
 		mdmStorage,
 		pushFactory,
-		NewNanoMDMLogger(kitlog.NewJSONLogger(os.Stdout)),
+		NewNanoMDMLogger(kitlog.NewNopLogger()),
 	)
-	cmdr := apple_mdm.NewMDMAppleCommander(mdmStorage, pusher)
-	svc := MDMAppleCheckinAndCommandService{ds: ds, commander: cmdr, logger: kitlog.NewNopLogger()}
-	uuid, serial, model, wantTeamID := "ABC-DEF-GHI", "XYZABC", "MacBookPro 16,1", uint(12)
-	serverURL := "https://example.com"
-	installEnterpriseApplicationCalls := 0
+	svc, ctx := newTestService(t, ds, nil, nil, &TestServerOpts{MDMStorage: mdmStorage, MDMPusher: pusher})
 
+	host := &fleet.Host{ID: 42, UUID: "test-host", Hostname: "test.local", TeamID: ptr.Uint(1)}
+	ds.HostLiteFunc = func(ctx context.Context, hostID uint) (*fleet.Host, error) {
+		return host, nil
+	}
+	enrollment := &fleet.NanoEnrollment{Enabled: true, Type: "Device"}
+	ds.GetNanoMDMEnrollmentFunc = func(ctx context.Context, hostUUID string) (*fleet.NanoEnrollment, error) {
+		return enrollment, nil
+	}
+	var activity fleet.ActivityTypeRequestedFleetdInstall
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, act fleet.ActivityDetails) error {
+		activity = act.(fleet.ActivityTypeRequestedFleetdInstall)
+		return nil
+	}
+	var enqueued []string
 	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
-		installEnterpriseApplicationCalls++
-		require.NotNil(t, cmd)
 		require.Equal(t, "InstallEnterpriseApplication", cmd.Command.RequestType)
+		enqueued = append(enqueued, id...)
 		return nil, nil
 	}
-
-	mdmStorage.RetrievePushInfoFunc = func(p0 context.Context, targetUUIDs []string) (map[string]*mdm.Push, error) {
-		require.ElementsMatch(t, []string{uuid}, targetUUIDs)
-		pushes := make(map[string]*mdm.Push, len(targetUUIDs))
-		for _, uuid := range targetUUIDs {
-			pushes[uuid] = &mdm.Push{
-				PushMagic: "magic" + uuid,
-				Token:     []byte("token" + uuid),
-				Topic:     "topic" + uuid,
-			}
+	mdmStorage.RetrievePushInfoFunc = func(ctx context.Context, tokens []string) (map[string]*mdm.Push, error) {
+		res := make(map[string]*mdm.Push, len(tokens))
+		for _, t := range tokens {
+			res[t] = &mdm.Push{Token: []byte(t)}
 		}
-
-		return pushes, nil
+		return res, nil
 	}
-
 	mdmStorage.RetrievePushCertFunc = func(ctx context.Context, topic string) (*tls.Certificate, string, error) {
 		cert, err := tls.LoadX509KeyPair("testdata/server.pem", "testdata/server.key")
 		return &cert, "", err
 	}
-
 	mdmStorage.IsPushCertStaleFunc = func(ctx context.Context, topic string, staleToken string) (bool, error) {
 		return false, nil
 	}
 
-	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
-		appCfg := &fleet.AppConfig{}
-		appCfg.ServerSettings.ServerURL = serverURL
-		return appCfg, nil
+	// observers can't install fleetd, nor users of another team
+	_, err := svc.InstallMDMAppleFleetd(test.UserContext(ctx, test.UserObserver), host.ID)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	_, err = svc.InstallMDMAppleFleetd(test.UserContext(ctx, test.UserTeamAdminTeam2), host.ID)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	require.Empty(t, enqueued)
+
+	ctx = test.UserContext(ctx, test.UserTeamAdminTeam1)
+	cmdUUID, err := svc.InstallMDMAppleFleetd(ctx, host.ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, cmdUUID)
+	require.Equal(t, []string{"test-host"}, enqueued)
+	require.Equal(t, fleet.ActivityTypeRequestedFleetdInstall{HostID: 42, HostDisplayName: "test.local", CommandUUID: cmdUUID}, activity)
+
+	// the host must be enrolled in MDM
+	enrollment.Enabled = false
+	_, err = svc.InstallMDMAppleFleetd(ctx, host.ID)
+	var bre *fleet.BadRequestError
+	require.ErrorAs(t, err, &bre)
+
+	// fleetd must not be enrolled already
+	enrollment.Enabled = true
+	host.NodeKey = ptr.String("abc")
+	_, err = svc.InstallMDMAppleFleetd(ctx, host.ID)
+	require.ErrorAs(t, err, &bre)
+	require.Len(t, enqueued, 1)
+}
+
+func TestMDMAppleHostQueuedCommands(t *testing.T) {
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil)
+
+	host := &fleet.Host{ID: 42, UUID: "test-host", Hostname: "test.local", TeamID: ptr.Uint(1)}
+	ds.HostLiteFunc = func(ctx context.Context, hostID uint) (*fleet.Host, error) {
+		if hostID != host.ID {
+			return nil, &notFoundError{}
+		}
+		return host, nil
 	}
-	ds.GetNanoMDMEnrollmentFunc = func(ctx context.Context, hostUUID string) (*fleet.NanoEnrollment, error) {
-		return &fleet.NanoEnrollment{Enabled: true, Type: "Device", TokenUpdateTally: 1}, nil
+	queued := []*fleet.MDMAppleHostQueuedCommand{
+		{CommandUUID: "wipe", RequestType: "EraseDevice", Status: "Pending"},
+		{CommandUUID: "profile", RequestType: "InstallProfile", Status: "NotNow", ProfileIdentifier: ptr.String("com.example.wifi")},
 	}
-
-	ds.GetHostMDMCheckinInfoFunc = func(ct context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
-		require.Equal(t, uuid, hostUUID)
-		return &fleet.HostMDMCheckinInfo{
-			HardwareSerial:   serial,
-			DisplayName:      model,
-			InstalledFromDEP: true,
-			TeamID:           wantTeamID,
-		}, nil
+	ds.ListMDMAppleHostQueuedCommandsFunc = func(ctx context.Context, hostUUID string) ([]*fleet.MDMAppleHostQueuedCommand, error) {
+		require.Equal(t, host.UUID, hostUUID)
+		return queued, nil
 	}
-	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
+	ds.CancelMDMAppleHostQueuedCommandFunc = func(ctx context.Context, hostUUID, commandUUID string) error {
+		require.Equal(t, host.UUID, hostUUID)
+		require.Equal(t, "wipe", commandUUID)
 		return nil
 	}
-
-	ds.GetMDMAppleBootstrapPackageMetaFunc = func(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackage, error) {
-		require.Equal(t, wantTeamID, teamID)
-		return &fleet.MDMAppleBootstrapPackage{}, nil
-	}
-
-	ds.RecordHostBootstrapPackageFunc = func(ctx context.Context, commandUUID string, hostUUID string) error {
-		require.Equal(t, uuid, hostUUID)
-		require.NotEmpty(t, commandUUID)
+	var activity *fleet.ActivityTypeCanceledMDMAppleCommand
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, act fleet.ActivityDetails) error {
+		activity = act.(*fleet.ActivityTypeCanceledMDMAppleCommand)
 		return nil
 	}
 
-	err := svc.TokenUpdate(
-		&mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: uuid}},
-		&mdm.TokenUpdate{
-			Enrollment: mdm.Enrollment{
-				UDID: uuid,
-			},
-		},
-	)
+	// observers can list the commands but not cancel them, users of another
+	// team can do neither
+	cmds, err := svc.ListMDMAppleHostQueuedCommands(test.UserContext(ctx, test.UserObserver), host.ID)
 	require.NoError(t, err)
-	require.True(t, ds.BulkSetPendingMDMAppleHostProfilesFuncInvoked)
-	require.True(t, ds.GetHostMDMCheckinInfoFuncInvoked)
-	require.True(t, ds.AppConfigFuncInvoked)
-	require.True(t, ds.RecordHostBootstrapPackageFuncInvoked)
-	require.Equal(t, 2, installEnterpriseApplicationCalls)
+	require.Equal(t, queued, cmds)
+	err = svc.CancelMDMAppleHostQueuedCommand(test.UserContext(ctx, test.UserObserver), host.ID, "wipe")
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	_, err = svc.ListMDMAppleHostQueuedCommands(test.UserContext(ctx, test.UserTeamAdminTeam2), host.ID)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	err = svc.CancelMDMAppleHostQueuedCommand(test.UserContext(ctx, test.UserTeamAdminTeam2), host.ID, "wipe")
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	require.False(t, ds.CancelMDMAppleHostQueuedCommandFuncInvoked)
+
+	ctx = test.UserContext(ctx, test.UserTeamMaintainerTeam1)
+
+	// the command must be queued for the host
+	err = svc.CancelMDMAppleHostQueuedCommand(ctx, host.ID, "no-such-command")
+	require.True(t, fleet.IsNotFound(err))
+	err = svc.CancelMDMAppleHostQueuedCommand(ctx, 1, "wipe")
+	require.True(t, fleet.IsNotFound(err))
+
+	// the commands of the profiles can't be canceled
+	err = svc.CancelMDMAppleHostQueuedCommand(ctx, host.ID, "profile")
+	var bre *fleet.BadRequestError
+	require.ErrorAs(t, err, &bre)
+	require.ErrorContains(t, err, "com.example.wifi")
+	require.False(t, ds.CancelMDMAppleHostQueuedCommandFuncInvoked)
+
+	require.NoError(t, svc.CancelMDMAppleHostQueuedCommand(ctx, host.ID, "wipe"))
+	require.True(t, ds.CancelMDMAppleHostQueuedCommandFuncInvoked)
+	require.Equal(t, &fleet.ActivityTypeCanceledMDMAppleCommand{
+		HostID:          42,
+		HostDisplayName: "test.local",
+		CommandUUID:     "wipe",
+		RequestType:     "EraseDevice",
+	}, activity)
 }
 
-func TestMDMCheckout(t *testing.T) {
+func TestListMDMAppleHostCommands(t *testing.T) {
 	ds := new(mock.Store)
-	svc := MDMAppleCheckinAndCommandService{ds: ds}
-	ctx := context.Background()
-	uuid, serial, installedFromDEP, displayName := "ABC-DEF-GHI", "XYZABC", true, "Test's MacBook"
+	svc, ctx := newTestService(t, ds, nil, nil)
 
-	ds.UpdateHostTablesOnMDMUnenrollFunc = func(ctx context.Context, hostUUID string) error {
-		require.Equal(t, uuid, hostUUID)
-		return nil
+	host := &fleet.Host{ID: 42, UUID: "test-host", Hostname: "test.local", TeamID: ptr.Uint(1)}
+	ds.HostLiteFunc = func(ctx context.Context, hostID uint) (*fleet.Host, error) {
+		if hostID != host.ID {
+			return nil, &notFoundError{}
+		}
+		return host, nil
+	}
+	history := []*fleet.MDMAppleHostCommand{
+		{CommandUUID: "lock", RequestType: "DeviceLock", Status: "Pending"},
+		{CommandUUID: "wipe", RequestType: "EraseDevice", Status: "Canceled"},
+		{CommandUUID: "list", RequestType: "ProfileList", Status: "Acknowledged"},
+	}
+	ds.ListMDMAppleHostCommandsFunc = func(ctx context.Context, hostUUID string, opt fleet.ListOptions) ([]*fleet.MDMAppleHostCommand, *fleet.PaginationMetadata, error) {
+		require.Equal(t, host.UUID, hostUUID)
+		require.EqualValues(t, 3, opt.PerPage)
+		return history, &fleet.PaginationMetadata{HasNextResults: true}, nil
 	}
 
-	ds.GetHostMDMCheckinInfoFunc = func(ct context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
-		require.Equal(t, uuid, hostUUID)
-		return &fleet.HostMDMCheckinInfo{
-			HardwareSerial:   serial,
-			DisplayName:      displayName,
-			InstalledFromDEP: installedFromDEP,
-		}, nil
+	// users of another team can't list the commands
+	_, _, err := svc.ListMDMAppleHostCommands(test.UserContext(ctx, test.UserTeamAdminTeam2), host.ID, fleet.ListOptions{PerPage: 3})
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	require.False(t, ds.ListMDMAppleHostCommandsFuncInvoked)
+
+	ctx = test.UserContext(ctx, test.UserTeamObserverTeam1)
+
+	_, _, err = svc.ListMDMAppleHostCommands(ctx, 1, fleet.ListOptions{PerPage: 3})
+	require.True(t, fleet.IsNotFound(err))
+
+	cmds, meta, err := svc.ListMDMAppleHostCommands(ctx, host.ID, fleet.ListOptions{PerPage: 3})
+	require.NoError(t, err)
+	require.Equal(t, history, cmds)
+	require.True(t, meta.HasNextResults)
+}
+
+func TestListMDMWebhookDeadLetters(t *testing.T) {
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil)
+
+	args := json.RawMessage(`{"event": {"id": "evt-1", "type": "mdm_enrolled", "host_uuid": "test-host"}}`)
+	ds.ListJobsFunc = func(ctx context.Context, name string, state fleet.JobState, opt fleet.ListOptions) ([]*fleet.Job, error) {
+		require.Equal(t, worker.MDMWebhookName, name)
+		require.Equal(t, fleet.JobStateFailure, state)
+		return []*fleet.Job{{ID: 7, Name: name, Args: &args, State: state, Retries: 10, Error: "unexpected status 500"}}, nil
+	}
+
+	_, err := svc.ListMDMWebhookDeadLetters(test.UserContext(ctx, test.UserMaintainer), fleet.ListOptions{})
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	require.False(t, ds.ListJobsFuncInvoked)
+
+	letters, err := svc.ListMDMWebhookDeadLetters(test.UserContext(ctx, test.UserAdmin), fleet.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	require.Equal(t, uint(7), letters[0].JobID)
+	require.Equal(t, "evt-1", letters[0].Event.ID)
+	require.Equal(t, fleet.MDMWebhookEventEnrolled, letters[0].Event.Type)
+	require.Equal(t, "test-host", letters[0].Event.HostUUID)
+	require.Equal(t, 11, letters[0].Attempts)
+	require.Equal(t, "unexpected status 500", letters[0].Error)
+}
+
+func TestListMDMHostChanges(t *testing.T) {
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil)
+
+	var gotSince uint64
+	var gotLimit int
+	ds.ListHostMDMChangesFunc = func(ctx context.Context, since uint64, limit int) ([]*fleet.HostMDMChange, error) {
+		gotSince, gotLimit = since, limit
+		var changes []*fleet.HostMDMChange
+		for i := 1; i <= 3 && i <= limit; i++ {
+			changes = append(changes, &fleet.HostMDMChange{ID: since + uint64(i), HostUUID: "uuid", Type: fleet.HostMDMChangeEnrolled})
+		}
+		return changes, nil
+	}
+
+	_, _, err := svc.ListMDMHostChanges(test.UserContext(ctx, test.UserMaintainer), "", 0)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	require.False(t, ds.ListHostMDMChangesFuncInvoked)
+
+	ctx = test.UserContext(ctx, test.UserAdmin)
+	changes, hasMore, err := svc.ListMDMHostChanges(ctx, "", 0)
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+	require.False(t, hasMore)
+	require.Zero(t, gotSince)
+	require.Equal(t, defaultMDMHostChangesPerPage+1, gotLimit)
+
+	changes, hasMore, err = svc.ListMDMHostChanges(ctx, "10", 2)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.True(t, hasMore)
+	require.EqualValues(t, 10, gotSince)
+	require.EqualValues(t, 12, changes[1].ID)
+
+	_, _, err = svc.ListMDMHostChanges(ctx, "abc", 0)
+	require.ErrorContains(t, err, "invalid cursor")
+	_, _, err = svc.ListMDMHostChanges(ctx, "", maxMDMHostChangesPerPage+1)
+	require.ErrorContains(t, err, "must be at most")
+}
+
+func TestListMDMAppleHostsMissingFleetd(t *testing.T) {
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil)
+
+	var gotBefore time.Time
+	ds.ListMDMAppleHostsMissingFleetdFunc = func(ctx context.Context, teamID *uint, enrolledBefore time.Time) ([]*fleet.MDMAppleHostMissingFleetd, error) {
+		gotBefore = enrolledBefore
+		return []*fleet.MDMAppleHostMissingFleetd{{HostID: 1}}, nil
+	}
+
+	_, err := svc.ListMDMAppleHostsMissingFleetd(test.UserContext(ctx, test.UserTeamAdminTeam1), nil, nil)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+
+	ctx = test.UserContext(ctx, test.UserAdmin)
+	hosts, err := svc.ListMDMAppleHostsMissingFleetd(ctx, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	require.WithinDuration(t, time.Now().Add(-defaultMDMAppleMissingFleetdMinHours*time.Hour), gotBefore, time.Minute)
+
+	_, err = svc.ListMDMAppleHostsMissingFleetd(ctx, nil, ptr.Uint(0))
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), gotBefore, time.Minute)
+}
+
+func TestMDMAuthenticate(t *testing.T) {
+	ds := new(mock.Store)
+	svc := MDMAppleCheckinAndCommandService{ds: ds}
+	ctx := context.Background()
+	uuid, serial, model := "ABC-DEF-GHI", "XYZABC", "MacBookPro 16,1"
+
+	ds.IngestMDMAppleDeviceFromCheckinFunc = func(ctx context.Context, mdmHost fleet.MDMAppleHostDetails) error {
+		require.Equal(t, uuid, mdmHost.UDID)
+		require.Equal(t, serial, mdmHost.SerialNumber)
+		require.Equal(t, model, mdmHost.Model)
+		return nil
+	}
+
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, checkinTime time.Time) error {
+		require.Equal(t, uuid, hostUUID)
+		require.WithinDuration(t, time.Now(), checkinTime, time.Minute)
+		return nil
+	}
+
+	ds.GetHostMDMCheckinInfoFunc = func(ct context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		require.Equal(t, uuid, hostUUID)
+		return &fleet.HostMDMCheckinInfo{HardwareSerial: serial, DisplayName: fmt.Sprintf("%s (%s)", model, serial), InstalledFromDEP: false}, nil
 	}
 
 	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
-		a, ok := activity.(*fleet.ActivityTypeMDMUnenrolled)
+		a, ok := activity.(*fleet.ActivityTypeMDMEnrolled)
 		require.True(t, ok)
 		require.Nil(t, user)
-		require.Equal(t, "mdm_unenrolled", activity.ActivityName())
+		require.Equal(t, "mdm_enrolled", activity.ActivityName())
 		require.Equal(t, serial, a.HostSerial)
-		require.Equal(t, displayName, a.HostDisplayName)
-		require.True(t, a.InstalledFromDEP)
+		require.Equal(t, a.HostDisplayName, fmt.Sprintf("%s (%s)", model, serial))
+		require.False(t, a.InstalledFromDEP)
 		return nil
 	}
 
-	err := svc.CheckOut(
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+
+	err := svc.Authenticate(
 		&mdm.Request{Context: ctx},
-		&mdm.CheckOut{
+		&mdm.Authenticate{
 			Enrollment: mdm.Enrollment{
 				UDID: uuid,
 			},
+			SerialNumber: serial,
+			Model:        model,
 		},
 	)
 	require.NoError(t, err)
-	require.True(t, ds.UpdateHostTablesOnMDMUnenrollFuncInvoked)
+	require.True(t, ds.IngestMDMAppleDeviceFromCheckinFuncInvoked)
+	require.True(t, ds.MarkHostMDMCheckedInFuncInvoked)
 	require.True(t, ds.GetHostMDMCheckinInfoFuncInvoked)
 	require.True(t, ds.NewActivityFuncInvoked)
+	require.False(t, ds.SetMDMAppleEnrollmentPendingApprovalFuncInvoked)
 }
 
-func TestMDMCommandAndReportResultsProfileHandling(t *testing.T) {
+func TestMDMAuthenticateManualEnrollmentApproval(t *testing.T) {
 	ds := new(mock.Store)
-	svc := MDMAppleCheckinAndCommandService{ds: ds}
+	svc := MDMAppleCheckinAndCommandService{ds: ds, logger: kitlog.NewNopLogger()}
 	ctx := context.Background()
-	hostUUID := "ABC-DEF-GHI"
-	commandUUID := "COMMAND-UUID"
+	uuid, serial := "ABC-DEF-GHI", "XYZABC"
 
-	cases := []struct {
-		status      string
-		requestType string
-		errors      []mdm.ErrorChain
-		want        *fleet.HostMDMAppleProfile
-	}{
-		{
-			status:      "Acknowledged",
-			requestType: "InstallProfile",
-			errors:      nil,
-			want: &fleet.HostMDMAppleProfile{
-				Status:        &fleet.MDMAppleDeliveryVerifying,
-				Detail:        "",
-				OperationType: fleet.MDMAppleOperationTypeInstall,
-			},
-		},
-		{
-			status:      "Acknowledged",
-			requestType: "RemoveProfile",
-			errors:      nil,
-			want: &fleet.HostMDMAppleProfile{
-				Status:        &fleet.MDMAppleDeliveryVerifying,
-				Detail:        "",
-				OperationType: fleet.MDMAppleOperationTypeRemove,
-			},
-		},
-		{
-			status:      "Error",
-			requestType: "InstallProfile",
-			errors: []mdm.ErrorChain{
-				{ErrorCode: 123, ErrorDomain: "testDomain", USEnglishDescription: "testMessage"},
-			},
-			want: &fleet.HostMDMAppleProfile{
-				Status:        &fleet.MDMAppleDeliveryFailed,
-				Detail:        "testDomain (123): testMessage\n",
-				OperationType: fleet.MDMAppleOperationTypeInstall,
-			},
-		},
-		{
-			status:      "Error",
-			requestType: "RemoveProfile",
-			errors: []mdm.ErrorChain{
-				{ErrorCode: 123, ErrorDomain: "testDomain", USEnglishDescription: "testMessage"},
-				{ErrorCode: 321, ErrorDomain: "domainTest", USEnglishDescription: "messageTest"},
-			},
-			want: &fleet.HostMDMAppleProfile{
-				Status:        &fleet.MDMAppleDeliveryFailed,
-				Detail:        "testDomain (123): testMessage\ndomainTest (321): messageTest\n",
-				OperationType: fleet.MDMAppleOperationTypeRemove,
-			},
-		},
-		{
-			status:      "Error",
-			requestType: "RemoveProfile",
-			errors:      nil,
-			want: &fleet.HostMDMAppleProfile{
-				Status:        &fleet.MDMAppleDeliveryFailed,
-				Detail:        "",
-				OperationType: fleet.MDMAppleOperationTypeRemove,
-			},
-		},
+	ds.IngestMDMAppleDeviceFromCheckinFunc = func(ctx context.Context, mdmHost fleet.MDMAppleHostDetails) error {
+		return nil
+	}
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, checkinTime time.Time) error {
+		return nil
+	}
+	var fromDEP bool
+	ds.GetHostMDMCheckinInfoFunc = func(ct context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		return &fleet.HostMDMCheckinInfo{HardwareSerial: serial, DisplayName: serial, InstalledFromDEP: fromDEP}, nil
+	}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		appCfg := &fleet.AppConfig{}
+		appCfg.MDM.ManualEnrollmentApproval.Enable = true
+		return appCfg, nil
+	}
+	alreadyQueued := false
+	ds.SetMDMAppleEnrollmentPendingApprovalFunc = func(ctx context.Context, hostUUID string) (bool, error) {
+		require.Equal(t, uuid, hostUUID)
+		return !alreadyQueued, nil
+	}
+	var activities []string
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		activities = append(activities, activity.ActivityName())
+		return nil
 	}
 
-	for _, c := range cases {
-		ds.GetMDMAppleCommandRequestTypeFunc = func(ctx context.Context, targetCmd string) (string, error) {
-			require.Equal(t, commandUUID, targetCmd)
-			return c.requestType, nil
-		}
+	authenticate := func() error {
+		return svc.Authenticate(
+			&mdm.Request{Context: ctx},
+			&mdm.Authenticate{Enrollment: mdm.Enrollment{UDID: uuid}, SerialNumber: serial},
+		)
+	}
 
-		ds.UpdateOrDeleteHostMDMAppleProfileFunc = func(ctx context.Context, profile *fleet.HostMDMAppleProfile) error {
-			c.want.CommandUUID = commandUUID
-			c.want.HostUUID = hostUUID
-			require.Equal(t, c.want, profile)
-			return nil
-		}
+	// a manual enrollment is queued for approval
+	require.NoError(t, authenticate())
+	require.True(t, ds.SetMDMAppleEnrollmentPendingApprovalFuncInvoked)
+	require.Equal(t, []string{"mdm_enrolled", "mdm_enrollment_pending_approval"}, activities)
+
+	// no new activity if the host was already queued or approved
+	activities = nil
+	alreadyQueued = true
+	require.NoError(t, authenticate())
+	require.Equal(t, []string{"mdm_enrolled"}, activities)
+
+	// DEP enrollments don't need to be approved
+	activities = nil
+	ds.SetMDMAppleEnrollmentPendingApprovalFuncInvoked = false
+	fromDEP = true
+	require.NoError(t, authenticate())
+	require.False(t, ds.SetMDMAppleEnrollmentPendingApprovalFuncInvoked)
+	require.Equal(t, []string{"mdm_enrolled"}, activities)
+}
 
-		_, err := svc.CommandAndReportResults(
+func TestMDMAuthenticateEnrollmentEligibility(t *testing.T) {
+	ds := new(mock.Store)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+	svc := MDMAppleCheckinAndCommandService{ds: ds, logger: kitlog.NewNopLogger()}
+	ctx := context.Background()
+	uuid, serial := "ABC-DEF-GHI", "XYZABC"
+
+	ds.IngestMDMAppleDeviceFromCheckinFunc = func(ctx context.Context, mdmHost fleet.MDMAppleHostDetails) error {
+		return nil
+	}
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, checkinTime time.Time) error {
+		return nil
+	}
+	var teamID uint
+	ds.GetHostMDMCheckinInfoFunc = func(ct context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		return &fleet.HostMDMCheckinInfo{HardwareSerial: serial, DisplayName: serial, InstalledFromDEP: true, TeamID: teamID}, nil
+	}
+	team := &fleet.Team{ID: 1, Name: "team"}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		require.Equal(t, team.ID, tid)
+		return team, nil
+	}
+	var upserted *fleet.MDMAppleBlockedEnrollment
+	ds.UpsertMDMAppleBlockedEnrollmentFunc = func(ctx context.Context, blocked *fleet.MDMAppleBlockedEnrollment) error {
+		upserted = blocked
+		return nil
+	}
+	ds.DeleteMDMAppleBlockedEnrollmentsFunc = func(ctx context.Context, serials []string) error {
+		require.Equal(t, []string{serial}, serials)
+		return nil
+	}
+	ds.SetMDMAppleEnrollmentPendingApprovalFunc = func(ctx context.Context, hostUUID string) (bool, error) {
+		require.Equal(t, uuid, hostUUID)
+		return true, nil
+	}
+	var activities []string
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		activities = append(activities, activity.ActivityName())
+		return nil
+	}
+
+	authenticate := func(model string) error {
+		activities = nil
+		return svc.Authenticate(
 			&mdm.Request{Context: ctx},
-			&mdm.CommandResults{
-				Enrollment:  mdm.Enrollment{UDID: hostUUID},
-				CommandUUID: commandUUID,
-				Status:      c.status,
-				RequestType: c.requestType,
-				ErrorChain:  c.errors,
-			},
+			&mdm.Authenticate{Enrollment: mdm.Enrollment{UDID: uuid}, SerialNumber: serial, Model: model},
 		)
-		require.NoError(t, err)
-		require.True(t, ds.GetMDMAppleCommandRequestTypeFuncInvoked)
-		require.True(t, ds.UpdateOrDeleteHostMDMAppleProfileFuncInvoked)
 	}
+
+	// hosts without a team have no rules
+	require.NoError(t, authenticate("MacBookPro11,1"))
+	require.False(t, ds.TeamFuncInvoked)
+
+	// team without rules
+	teamID = team.ID
+	require.NoError(t, authenticate("MacBookPro11,1"))
+	require.True(t, ds.TeamFuncInvoked)
+	require.False(t, ds.UpsertMDMAppleBlockedEnrollmentFuncInvoked)
+
+	team.Config.MDM.MacOSEnrollmentEligibility = fleet.MacOSEnrollmentEligibility{MinimumModelYear: 2018}
+
+	// eligible host, its previous blocked enrollment is deleted
+	require.NoError(t, authenticate("MacBookPro17,1"))
+	require.True(t, ds.DeleteMDMAppleBlockedEnrollmentsFuncInvoked)
+	require.False(t, ds.UpsertMDMAppleBlockedEnrollmentFuncInvoked)
+
+	// unknown model, the eligibility can't be checked
+	ds.DeleteMDMAppleBlockedEnrollmentsFuncInvoked = false
+	require.NoError(t, authenticate("VirtualMac2,1"))
+	require.False(t, ds.DeleteMDMAppleBlockedEnrollmentsFuncInvoked)
+	require.False(t, ds.UpsertMDMAppleBlockedEnrollmentFuncInvoked)
+
+	// ineligible host is blocked
+	err := authenticate("MacBookPro11,1")
+	require.ErrorContains(t, err, "enrollment blocked: host hardware is ineligible: model year 2013")
+	require.NotNil(t, upserted)
+	require.Equal(t, serial, upserted.SerialNumber)
+	require.Equal(t, uuid, upserted.HostUUID)
+	require.Equal(t, "MacBookPro11,1", upserted.HardwareModel)
+	require.Equal(t, fleet.MDMAppleBlockedEnrollmentSourceEnrollment, upserted.Source)
+	require.Equal(t, fleet.MacOSEnrollmentEligibilityActionBlock, upserted.Action)
+	require.Empty(t, activities)
+	require.False(t, ds.SetMDMAppleEnrollmentPendingApprovalFuncInvoked)
+
+	// ineligible host is flagged, its enrollment is held for approval
+	team.Config.MDM.MacOSEnrollmentEligibility.Action = fleet.MacOSEnrollmentEligibilityActionFlag
+	require.NoError(t, authenticate("MacBookPro11,1"))
+	require.Equal(t, fleet.MacOSEnrollmentEligibilityActionFlag, upserted.Action)
+	require.True(t, ds.SetMDMAppleEnrollmentPendingApprovalFuncInvoked)
+	require.Equal(t, []string{"mdm_enrolled", "mdm_enrollment_pending_approval"}, activities)
 }
 
-func TestMDMBatchSetAppleProfiles(t *testing.T) {
-	svc, ctx, ds := setupAppleMDMService(t)
+func TestListMDMAppleBlockedEnrollments(t *testing.T) {
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil)
 
-	ds.TeamByNameFunc = func(ctx context.Context, name string) (*fleet.Team, error) {
-		return &fleet.Team{ID: 1, Name: name}, nil
+	blocked := []*fleet.MDMAppleBlockedEnrollment{
+		{SerialNumber: "ABC", TeamID: ptr.Uint(1), Source: fleet.MDMAppleBlockedEnrollmentSourceDEP, Action: fleet.MacOSEnrollmentEligibilityActionBlock, Reason: "too old"},
 	}
-	ds.TeamFunc = func(ctx context.Context, id uint) (*fleet.Team, error) {
-		return &fleet.Team{ID: id, Name: "team"}, nil
+	ds.ListMDMAppleBlockedEnrollmentsFunc = func(ctx context.Context, filter fleet.TeamFilter) ([]*fleet.MDMAppleBlockedEnrollment, error) {
+		require.NotNil(t, filter.User)
+		require.True(t, filter.IncludeObserver)
+		return blocked, nil
 	}
-	ds.BatchSetMDMAppleProfilesFunc = func(ctx context.Context, teamID *uint, profiles []*fleet.MDMAppleConfigProfile) error {
+
+	_, err := svc.ListMDMAppleBlockedEnrollments(ctx)
+	require.Error(t, err)
+	require.False(t, ds.ListMDMAppleBlockedEnrollmentsFuncInvoked)
+
+	got, err := svc.ListMDMAppleBlockedEnrollments(test.UserContext(ctx, test.UserObserver))
+	require.NoError(t, err)
+	require.Equal(t, blocked, got)
+}
+
+func TestMDMAuthenticateWithEnrollmentReference(t *testing.T) {
+	ds := new(mock.Store)
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, t time.Time) error {
+		return nil
+	}
+	svc := MDMAppleCheckinAndCommandService{ds: ds, logger: kitlog.NewNopLogger()}
+	ctx := context.Background()
+	hostUUID := "ABC-DEF-GHI"
+
+	accounts := map[string]*fleet.MDMIdPAccount{
+		"ref-eng":  {UUID: "ref-eng", Username: "jane@example.com", FullName: "Jane Doe", Groups: []string{"everyone", "engineering"}},
+		"ref-none": {UUID: "ref-none", Username: "john", Groups: []string{"everyone"}},
+		"ref-scim": {UUID: "ref-scim", Username: "sam@example.com", Groups: []string{"everyone"}},
+	}
+	var associated string
+	var assignedTeamID *uint
+	ds.IngestMDMAppleDeviceFromCheckinFunc = func(ctx context.Context, mdmHost fleet.MDMAppleHostDetails) error {
 		return nil
 	}
+	ds.GetHostMDMCheckinInfoFunc = func(ct context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		return &fleet.HostMDMCheckinInfo{}, nil
+	}
+	var transferred *fleet.ActivityTypeTransferredHostsToTeam
 	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		if act, ok := activity.(*fleet.ActivityTypeTransferredHostsToTeam); ok {
+			require.Nil(t, user)
+			transferred = act
+		}
 		return nil
 	}
-	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
+	ds.GetMDMIdPAccountFunc = func(ctx context.Context, uuid string) (*fleet.MDMIdPAccount, error) {
+		acc, ok := accounts[uuid]
+		if !ok {
+			return nil, &notFoundError{}
+		}
+		return acc, nil
+	}
+	ds.AssociateHostMDMIdPAccountFunc = func(ctx context.Context, hUUID, accountUUID string) error {
+		require.Equal(t, hostUUID, hUUID)
+		associated = accountUUID
+		return nil
+	}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		appCfg := &fleet.AppConfig{}
+		appCfg.MDM.EndUserAuthentication.TeamRules = fleet.MDMSSOTeamRules{
+			{Group: "engineering", Team: "Engineering"},
+			{Group: "everyone", Team: "Deleted"},
+		}
+		return appCfg, nil
+	}
+	ds.ScimGroupNamesForUserNameFunc = func(ctx context.Context, userName string) ([]string, error) {
+		if userName == "sam@example.com" {
+			return []string{"engineering"}, nil
+		}
+		return nil, nil
+	}
+	ds.TeamByNameFunc = func(ctx context.Context, name string) (*fleet.Team, error) {
+		if name == "Engineering" {
+			return &fleet.Team{ID: 1, Name: name}, nil
+		}
+		return nil, sql.ErrNoRows
+	}
+	ds.HostByIdentifierFunc = func(ctx context.Context, identifier string) (*fleet.Host, error) {
+		require.Equal(t, hostUUID, identifier)
+		return &fleet.Host{ID: 42, UUID: hostUUID}, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: tid, Name: "Engineering"}, nil
+	}
+	ds.AddHostsToTeamFunc = func(ctx context.Context, teamID *uint, hostIDs []uint) error {
+		require.Equal(t, []uint{42}, hostIDs)
+		assignedTeamID = teamID
+		return nil
+	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, limit int) (int, error) {
+		require.Equal(t, []uint{42}, hostIDs)
+		return 1, nil
+	}
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, uuids []string) error {
+		require.Equal(t, []uint{42}, hostIDs)
+		return nil
+	}
+	var customAttrs map[string]*string
+	ds.SetHostCustomAttributesFunc = func(ctx context.Context, hostID uint, attrs map[string]*string, source string) error {
+		require.Equal(t, uint(42), hostID)
+		require.Equal(t, fleet.HostCustomAttributeSourceIdP, source)
+		customAttrs = attrs
+		return nil
+	}
+	var assignedUser *fleet.HostAssignedUser
+	ds.SetHostAssignedUserFunc = func(ctx context.Context, hostID uint, user *fleet.HostAssignedUser) error {
+		require.Equal(t, uint(42), hostID)
+		assignedUser = user
 		return nil
 	}
 
-	testCases := []struct {
-		name     string
-		user     *fleet.User
-		premium  bool
-		teamID   *uint
+	authenticate := func(ref string) error {
+		return svc.Authenticate(
+			&mdm.Request{Context: ctx, Params: map[string]string{apple_mdm.EnrollReferenceKey: ref}},
+			&mdm.Authenticate{Enrollment: mdm.Enrollment{UDID: hostUUID}},
+		)
+	}
+
+	// the first matching rule assigns the team
+	require.NoError(t, authenticate("ref-eng"))
+	require.Equal(t, "ref-eng", associated)
+	require.NotNil(t, assignedTeamID)
+	require.Equal(t, uint(1), *assignedTeamID)
+	// the transfer has the side effects of a transfer via the API
+	require.True(t, ds.BulkSetPendingMDMAppleHostProfilesFuncInvoked)
+	require.Equal(t, &fleet.ActivityTypeTransferredHostsToTeam{
+		TeamID:   ptr.Uint(1),
+		TeamName: ptr.String("Engineering"),
+		HostIDs:  []uint{42},
+	}, transferred)
+	require.Equal(t, map[string]*string{
+		fleet.HostCustomAttributeIdPUsername: ptr.String("jane@example.com"),
+		fleet.HostCustomAttributeIdPFullName: ptr.String("Jane Doe"),
+	}, customAttrs)
+	require.Equal(t, &fleet.HostAssignedUser{
+		Email:    "jane@example.com",
+		FullName: "Jane Doe",
+		Source:   fleet.HostAssignedUserSourceSSO,
+	}, assignedUser)
+
+	// the team of the matching rule does not exist anymore, and the username
+	// is not an email so the host is not assigned to the user
+	associated, assignedTeamID, transferred = "", nil, nil
+	ds.AddHostsToTeamFuncInvoked = false
+	ds.SetHostAssignedUserFuncInvoked = false
+	require.NoError(t, authenticate("ref-none"))
+	require.Equal(t, "ref-none", associated)
+	require.False(t, ds.AddHostsToTeamFuncInvoked)
+	require.Nil(t, transferred)
+	require.False(t, ds.SetHostAssignedUserFuncInvoked)
+
+	// the groups synced via SCIM match the rules too
+	associated, assignedTeamID = "", nil
+	require.NoError(t, authenticate("ref-scim"))
+	require.Equal(t, "ref-scim", associated)
+	require.NotNil(t, assignedTeamID)
+	require.Equal(t, uint(1), *assignedTeamID)
+
+	// an unknown reference does not prevent the enrollment
+	associated = ""
+	ds.AddHostsToTeamFuncInvoked = false
+	ds.AssociateHostMDMIdPAccountFuncInvoked = false
+	ds.SetHostCustomAttributesFuncInvoked = false
+	require.NoError(t, authenticate("ref-unknown"))
+	require.False(t, ds.AssociateHostMDMIdPAccountFuncInvoked)
+	require.False(t, ds.SetHostCustomAttributesFuncInvoked)
+	require.False(t, ds.AddHostsToTeamFuncInvoked)
+	require.True(t, ds.NewActivityFuncInvoked)
+}
+
+func TestMDMAuthenticateWithEnrollmentLink(t *testing.T) {
+	ds := new(mock.Store)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, t time.Time) error {
+		return nil
+	}
+	svc := MDMAppleCheckinAndCommandService{ds: ds, logger: kitlog.NewNopLogger()}
+	ctx := context.Background()
+	hostUUID := "ABC-DEF-GHI"
+
+	var assignedTeamID *uint
+	ds.IngestMDMAppleDeviceFromCheckinFunc = func(ctx context.Context, mdmHost fleet.MDMAppleHostDetails) error {
+		return nil
+	}
+	ds.GetHostMDMCheckinInfoFunc = func(ct context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		return &fleet.HostMDMCheckinInfo{}, nil
+	}
+	var transferred *fleet.ActivityTypeTransferredHostsToTeam
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		if act, ok := activity.(*fleet.ActivityTypeTransferredHostsToTeam); ok {
+			transferred = act
+		}
+		return nil
+	}
+	ds.ConsumeMDMAppleEnrollmentLinkFunc = func(ctx context.Context, token, hUUID string) (*fleet.MDMAppleEnrollmentLink, error) {
+		require.Equal(t, hostUUID, hUUID)
+		switch token {
+		case "team-link":
+			return &fleet.MDMAppleEnrollmentLink{ID: 1, TeamID: ptr.Uint(3)}, nil
+		case "no-team-link":
+			return &fleet.MDMAppleEnrollmentLink{ID: 2}, nil
+		}
+		return nil, &notFoundError{}
+	}
+	ds.HostByIdentifierFunc = func(ctx context.Context, identifier string) (*fleet.Host, error) {
+		require.Equal(t, hostUUID, identifier)
+		return &fleet.Host{ID: 42, UUID: hostUUID}, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: tid, Name: "Workstations"}, nil
+	}
+	ds.AddHostsToTeamFunc = func(ctx context.Context, teamID *uint, hostIDs []uint) error {
+		require.Equal(t, []uint{42}, hostIDs)
+		assignedTeamID = teamID
+		return nil
+	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, limit int) (int, error) {
+		return 1, nil
+	}
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, uuids []string) error {
+		return nil
+	}
+
+	authenticate := func(token string) error {
+		return svc.Authenticate(
+			&mdm.Request{Context: ctx, Params: map[string]string{apple_mdm.EnrollLinkKey: token}},
+			&mdm.Authenticate{Enrollment: mdm.Enrollment{UDID: hostUUID}},
+		)
+	}
+
+	// the host is transferred to the team of the link
+	require.NoError(t, authenticate("team-link"))
+	require.True(t, ds.ConsumeMDMAppleEnrollmentLinkFuncInvoked)
+	require.NotNil(t, assignedTeamID)
+	require.Equal(t, uint(3), *assignedTeamID)
+	require.True(t, ds.BulkSetPendingMDMAppleHostProfilesFuncInvoked)
+	require.Equal(t, &fleet.ActivityTypeTransferredHostsToTeam{
+		TeamID:   ptr.Uint(3),
+		TeamName: ptr.String("Workstations"),
+		HostIDs:  []uint{42},
+	}, transferred)
+
+	// a link for no team does not transfer the host
+	ds.AddHostsToTeamFuncInvoked = false
+	require.NoError(t, authenticate("no-team-link"))
+	require.False(t, ds.AddHostsToTeamFuncInvoked)
+
+	// an expired or exhausted link rejects the enrollment, before the host is
+	// created
+	ds.IngestMDMAppleDeviceFromCheckinFuncInvoked = false
+	ds.NewActivityFuncInvoked = false
+	require.ErrorContains(t, authenticate("expired-link"), "enrollment rejected")
+	require.False(t, ds.IngestMDMAppleDeviceFromCheckinFuncInvoked)
+	require.False(t, ds.AddHostsToTeamFuncInvoked)
+	require.False(t, ds.NewActivityFuncInvoked)
+}
+
+func TestMDMAuthenticateWithExhaustedEnrollmentLink(t *testing.T) {
+	ds := new(mock.Store)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, t time.Time) error {
+		return nil
+	}
+	ds.IngestMDMAppleDeviceFromCheckinFunc = func(ctx context.Context, mdmHost fleet.MDMAppleHostDetails) error {
+		return nil
+	}
+	ds.GetHostMDMCheckinInfoFunc = func(ct context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		return &fleet.HostMDMCheckinInfo{}, nil
+	}
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		return nil
+	}
+	svc := MDMAppleCheckinAndCommandService{ds: ds, logger: kitlog.NewNopLogger()}
+	ctx := context.Background()
+
+	// the link can be used by a single host, as in the datastore the hosts
+	// that already used it can use it again
+	link := &fleet.MDMAppleEnrollmentLink{ID: 1, Token: "link", MaxUses: 1}
+	usedBy := make(map[string]bool)
+	ds.ConsumeMDMAppleEnrollmentLinkFunc = func(ctx context.Context, token, hostUUID string) (*fleet.MDMAppleEnrollmentLink, error) {
+		if token != link.Token {
+			return nil, &notFoundError{}
+		}
+		if !usedBy[hostUUID] {
+			if link.UsesCount >= link.MaxUses {
+				return nil, &notFoundError{}
+			}
+			link.UsesCount++
+			usedBy[hostUUID] = true
+		}
+		return link, nil
+	}
+
+	authenticate := func(hostUUID string) error {
+		return svc.Authenticate(
+			&mdm.Request{Context: ctx, Params: map[string]string{apple_mdm.EnrollLinkKey: link.Token}},
+			&mdm.Authenticate{Enrollment: mdm.Enrollment{UDID: hostUUID}},
+		)
+	}
+
+	require.NoError(t, authenticate("host-1"))
+	require.Equal(t, link.MaxUses, link.UsesCount)
+
+	// max_uses is reached, another device installing the same profile can't
+	// enroll
+	ds.IngestMDMAppleDeviceFromCheckinFuncInvoked = false
+	err := authenticate("host-2")
+	require.ErrorContains(t, err, "enrollment rejected: the enrollment link expired or reached its maximum number of uses")
+	require.False(t, ds.IngestMDMAppleDeviceFromCheckinFuncInvoked)
+
+	// the host that used the link can still re-enroll
+	require.NoError(t, authenticate("host-1"))
+}
+
+func TestMDMAppleEnrollmentLinks(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+	ctx = test.UserContext(ctx, test.UserAdmin)
+
+	var created *fleet.MDMAppleEnrollmentLink
+	ds.NewMDMAppleEnrollmentLinkFunc = func(ctx context.Context, link *fleet.MDMAppleEnrollmentLink) (*fleet.MDMAppleEnrollmentLink, error) {
+		created = link
+		return &fleet.MDMAppleEnrollmentLink{ID: 1, Token: link.Token, MaxUses: link.MaxUses, ExpiresAt: link.ExpiresAt}, nil
+	}
+	ds.GetMDMAppleEnrollmentLinkByTokenFunc = func(ctx context.Context, token string) (*fleet.MDMAppleEnrollmentLink, error) {
+		if token == "valid" {
+			return &fleet.MDMAppleEnrollmentLink{ID: 1}, nil
+		}
+		return nil, &notFoundError{}
+	}
+
+	_, err := svc.CreateMDMAppleEnrollmentLink(ctx, nil, 0, time.Hour)
+	require.ErrorContains(t, err, "max_uses must be between 1 and 1000")
+	_, err = svc.CreateMDMAppleEnrollmentLink(ctx, nil, 1001, time.Hour)
+	require.ErrorContains(t, err, "max_uses must be between 1 and 1000")
+	_, err = svc.CreateMDMAppleEnrollmentLink(ctx, nil, 1, 8*24*time.Hour)
+	require.ErrorContains(t, err, "expires_in_seconds must be between 1 and 604800")
+	require.False(t, ds.NewMDMAppleEnrollmentLinkFuncInvoked)
+
+	link, err := svc.CreateMDMAppleEnrollmentLink(ctx, ptr.Uint(0), 5, time.Hour)
+	require.NoError(t, err)
+	require.Nil(t, created.TeamID)
+	require.NotEmpty(t, created.Token)
+	require.EqualValues(t, 5, created.MaxUses)
+	require.WithinDuration(t, time.Now().Add(time.Hour), created.ExpiresAt, time.Minute)
+	require.Equal(t, "https://foo.example.com/api/mdm/apple/enroll_link?enrollment_link="+url.QueryEscape(created.Token), link.URL)
+	require.Equal(t, link.URL, link.QRCodePayload)
+
+	// the profile served by the link carries its token
+	profile, err := svc.GetMDMAppleEnrollmentProfileByLinkToken(ctx, "valid")
+	require.NoError(t, err)
+	require.Contains(t, string(profile), "https://foo.example.com/mdm/apple/mdm?enrollment_link=valid")
+
+	_, err = svc.GetMDMAppleEnrollmentProfileByLinkToken(ctx, "expired")
+	var authErr *fleet.AuthFailedError
+	require.ErrorAs(t, err, &authErr)
+
+	// the links grant the same access as the enroll secrets
+	ds.GetMDMAppleEnrollmentLinkFunc = func(ctx context.Context, id uint) (*fleet.MDMAppleEnrollmentLink, error) {
+		return &fleet.MDMAppleEnrollmentLink{ID: id}, nil
+	}
+	for _, tc := range []struct {
+		user               *fleet.User
+		shouldFailWithAuth bool
+	}{
+		{test.UserAdmin, false},
+		{test.UserMaintainer, false},
+		{test.UserObserver, true},
+		{test.UserObserverPlus, true},
+		{test.UserTeamAdminTeam1, true},
+		{test.UserNoRoles, true},
+	} {
+		ctx := test.UserContext(ctx, tc.user)
+		_, createErr := svc.CreateMDMAppleEnrollmentLink(ctx, nil, 1, time.Hour)
+		_, getErr := svc.GetMDMAppleEnrollmentLink(ctx, 1)
+		for _, err := range []error{createErr, getErr} {
+			if tc.shouldFailWithAuth {
+				require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+			} else {
+				require.NoError(t, err)
+			}
+		}
+	}
+}
+
+func TestMDMAppleEnrollmentProfileTeamLocalization(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	appCfg := &fleet.AppConfig{
+		OrgInfo:        fleet.OrgInfo{OrgName: "Acme"},
+		ServerSettings: fleet.ServerSettings{ServerURL: "https://foo.example.com"},
+		MDM: fleet.MDM{
+			AppleBMDefaultTeam: "Workstations",
+			EndUserAuthentication: fleet.MDMEndUserAuthentication{
+				AttributeMapping: fleet.MDMSSOAttributeMapping{Groups: "memberOf"},
+				TeamRules:        fleet.MDMSSOTeamRules{{Group: "engineering", Team: "Engineering"}},
+			},
+		},
+	}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return appCfg, nil
+	}
+	teams := map[string]*fleet.Team{"Workstations": {ID: 1, Name: "Workstations"}, "Engineering": {ID: 2, Name: "Engineering"}}
+	teams["Workstations"].Config.MDM.Localization = fleet.MDMLocalization{
+		DefaultLanguage: "de",
+		Translations: []fleet.MDMEndUserStrings{
+			{Language: "de", EnrollmentProfileDisplayName: "Anmeldung", SSOErrorMessage: "Anmeldung fehlgeschlagen"},
+		},
+	}
+	teams["Engineering"].Config.MDM.Localization = fleet.MDMLocalization{
+		DefaultLanguage: "fr",
+		Translations: []fleet.MDMEndUserStrings{
+			{Language: "fr", EnrollmentProfileDisplayName: "Inscription", SSOErrorMessage: "Erreur d'authentification"},
+		},
+	}
+	ds.TeamByNameFunc = func(ctx context.Context, name string) (*fleet.Team, error) {
+		if tm, ok := teams[name]; ok {
+			return tm, nil
+		}
+		return nil, sql.ErrNoRows
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		for _, tm := range teams {
+			if tm.ID == tid {
+				return tm, nil
+			}
+		}
+		return nil, &notFoundError{}
+	}
+	ds.GetMDMAppleEnrollmentProfileByTokenFunc = func(ctx context.Context, token string) (*fleet.MDMAppleEnrollmentProfile, error) {
+		if token != "dep-tok" {
+			return nil, &notFoundError{}
+		}
+		return &fleet.MDMAppleEnrollmentProfile{Token: token, Type: fleet.MDMAppleEnrollmentTypeAutomatic}, nil
+	}
+	ds.ConsumeMDMAppleSSOEnrollmentTokenFunc = func(ctx context.Context, token string) (*fleet.MDMAppleSSOEnrollmentToken, error) {
+		return &fleet.MDMAppleSSOEnrollmentToken{Token: token, IdPAccountUUID: "acc-1"}, nil
+	}
+	ds.GetMDMIdPAccountFunc = func(ctx context.Context, uuid string) (*fleet.MDMIdPAccount, error) {
+		return &fleet.MDMIdPAccount{UUID: uuid, Username: "jdoe@example.com"}, nil
+	}
+	var scimGroups []string
+	ds.ScimGroupNamesForUserNameFunc = func(ctx context.Context, userName string) ([]string, error) {
+		return scimGroups, nil
+	}
+
+	// the profile of the DEP token is localized for the default team of the
+	// hosts enrolled with Apple Business Manager
+	profile, err := svc.GetMDMAppleEnrollmentProfileByToken(ctx, "dep-tok", "")
+	require.NoError(t, err)
+	require.Contains(t, string(profile), "Anmeldung")
+
+	// the profile of the end user is localized for the team of the matching
+	// IdP team rule, or else for the default team
+	profile, err = svc.GetMDMAppleEnrollmentProfileByToken(ctx, "sso-tok", "")
+	require.NoError(t, err)
+	require.Contains(t, string(profile), "Anmeldung")
+	scimGroups = []string{"engineering"}
+	profile, err = svc.GetMDMAppleEnrollmentProfileByToken(ctx, "sso-tok", "")
+	require.NoError(t, err)
+	require.Contains(t, string(profile), "Inscription")
+
+	// same for the error page of the SSO callback, based on the groups of the
+	// SAML assertion
+	scimGroups = nil
+	strs, err := svc.GetMDMAppleSSOEndUserStrings(ctx, &testAuth{userID: "jdoe@example.com"})
+	require.NoError(t, err)
+	require.Equal(t, "de", strs.Language)
+	require.Equal(t, "Anmeldung fehlgeschlagen", strs.SSOErrorMessage)
+	strs, err = svc.GetMDMAppleSSOEndUserStrings(ctx, &testAuth{
+		userID:              "jdoe@example.com",
+		assertionAttributes: []fleet.SAMLAttribute{{Name: "memberOf", Values: []fleet.SAMLAttributeValue{{Value: "engineering"}}}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "fr", strs.Language)
+	require.Equal(t, "Erreur d'authentification", strs.SSOErrorMessage)
+
+	// the global strings are used if the team doesn't exist
+	appCfg.MDM.AppleBMDefaultTeam = "Deleted"
+	strs, err = svc.GetMDMAppleSSOEndUserStrings(ctx, &testAuth{userID: "jdoe@example.com"})
+	require.NoError(t, err)
+	require.Equal(t, fleet.DefaultMDMSSOErrorMessage, strs.SSOErrorMessage)
+}
+
+func TestMDMAppleSSOEnrollmentTokens(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	ds.GetMDMAppleEnrollmentProfileByTokenFunc = func(ctx context.Context, token string) (*fleet.MDMAppleEnrollmentProfile, error) {
+		return nil, &notFoundError{}
+	}
+	consumed := map[string]bool{}
+	ds.ConsumeMDMAppleSSOEnrollmentTokenFunc = func(ctx context.Context, token string) (*fleet.MDMAppleSSOEnrollmentToken, error) {
+		if token != "sso-tok" && token != "sso-tok2" || consumed[token] {
+			return nil, &notFoundError{}
+		}
+		consumed[token] = true
+		return &fleet.MDMAppleSSOEnrollmentToken{ID: 1, Token: token, IdPAccountUUID: "acc-1"}, nil
+	}
+	ds.GetMDMIdPAccountFunc = func(ctx context.Context, uuid string) (*fleet.MDMIdPAccount, error) {
+		return &fleet.MDMIdPAccount{UUID: uuid, Username: "jdoe@example.com"}, nil
+	}
+	ds.ScimGroupNamesForUserNameFunc = func(ctx context.Context, userName string) ([]string, error) {
+		return nil, nil
+	}
+
+	// the profile served by the token carries the account of the end user,
+	// and the token can only be used once
+	profile, err := svc.GetMDMAppleEnrollmentProfileByToken(ctx, "sso-tok", "")
+	require.NoError(t, err)
+	require.Contains(t, string(profile), "https://foo.example.com/mdm/apple/mdm?enrollment_reference=acc-1")
+	_, err = svc.GetMDMAppleEnrollmentProfileByToken(ctx, "sso-tok", "")
+	var authErr *fleet.AuthFailedError
+	require.ErrorAs(t, err, &authErr)
+	_, err = svc.GetMDMAppleEnrollmentProfileByToken(ctx, "no-such-token", "")
+	require.ErrorAs(t, err, &authErr)
+
+	// the enrollment reference must be the one the token was issued to
+	_, err = svc.GetMDMAppleEnrollmentProfileByToken(ctx, "sso-tok2", "acc-2")
+	require.ErrorAs(t, err, &authErr)
+
+	ds.ListMDMAppleSSOEnrollmentTokensFunc = func(ctx context.Context, opt fleet.ListOptions) ([]*fleet.MDMAppleSSOEnrollmentToken, error) {
+		return []*fleet.MDMAppleSSOEnrollmentToken{{ID: 1, IdPAccountUUID: "acc-1", IdPUsername: "jdoe@example.com"}}, nil
+	}
+	ds.RevokeMDMAppleSSOEnrollmentTokenFunc = func(ctx context.Context, id uint) (*fleet.MDMAppleSSOEnrollmentToken, error) {
+		if id != 1 {
+			return nil, &notFoundError{}
+		}
+		return &fleet.MDMAppleSSOEnrollmentToken{ID: id, IdPAccountUUID: "acc-1", IdPUsername: "jdoe@example.com"}, nil
+	}
+	var gotActivity *fleet.ActivityTypeRevokedMDMAppleSSOEnrollmentToken
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		act, ok := activity.(*fleet.ActivityTypeRevokedMDMAppleSSOEnrollmentToken)
+		require.True(t, ok)
+		gotActivity = act
+		return nil
+	}
+
+	for _, u := range []*fleet.User{test.UserMaintainer, test.UserObserver, test.UserTeamAdminTeam1} {
+		uctx := test.UserContext(ctx, u)
+		_, err := svc.ListMDMAppleSSOEnrollmentTokens(uctx, fleet.ListOptions{})
+		require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+		err = svc.RevokeMDMAppleSSOEnrollmentToken(uctx, 1)
+		require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	}
+	require.False(t, ds.RevokeMDMAppleSSOEnrollmentTokenFuncInvoked)
+
+	ctx = test.UserContext(ctx, test.UserAdmin)
+	toks, err := svc.ListMDMAppleSSOEnrollmentTokens(ctx, fleet.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, toks, 1)
+
+	err = svc.RevokeMDMAppleSSOEnrollmentToken(ctx, 2)
+	require.True(t, fleet.IsNotFound(err))
+	require.Nil(t, gotActivity)
+	require.NoError(t, svc.RevokeMDMAppleSSOEnrollmentToken(ctx, 1))
+	require.Equal(t, &fleet.ActivityTypeRevokedMDMAppleSSOEnrollmentToken{TokenID: 1, IdPUsername: "jdoe@example.com"}, gotActivity)
+}
+
+func TestMDMAuthenticateWithTeamEnrollmentToken(t *testing.T) {
+	ds := new(mock.Store)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, t time.Time) error {
+		return nil
+	}
+	svc := MDMAppleCheckinAndCommandService{ds: ds, logger: kitlog.NewNopLogger()}
+	ctx := context.Background()
+	hostUUID := "ABC-DEF-GHI"
+
+	var hostTeamID, assignedTeamID *uint
+	ds.IngestMDMAppleDeviceFromCheckinFunc = func(ctx context.Context, mdmHost fleet.MDMAppleHostDetails) error {
+		return nil
+	}
+	ds.GetHostMDMCheckinInfoFunc = func(ct context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		return &fleet.HostMDMCheckinInfo{}, nil
+	}
+	var transferred *fleet.ActivityTypeTransferredHostsToTeam
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		if act, ok := activity.(*fleet.ActivityTypeTransferredHostsToTeam); ok {
+			transferred = act
+		}
+		return nil
+	}
+	ds.GetMDMAppleTeamEnrollmentTokenByTokenFunc = func(ctx context.Context, token string) (*fleet.MDMAppleTeamEnrollmentToken, error) {
+		switch token {
+		case "team-token":
+			return &fleet.MDMAppleTeamEnrollmentToken{TeamID: ptr.Uint(3), Token: token}, nil
+		case "no-team-token":
+			return &fleet.MDMAppleTeamEnrollmentToken{Token: token}, nil
+		}
+		return nil, &notFoundError{}
+	}
+	ds.HostByIdentifierFunc = func(ctx context.Context, identifier string) (*fleet.Host, error) {
+		require.Equal(t, hostUUID, identifier)
+		return &fleet.Host{ID: 42, UUID: hostUUID, TeamID: hostTeamID}, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: tid, Name: "Workstations"}, nil
+	}
+	ds.AddHostsToTeamFunc = func(ctx context.Context, teamID *uint, hostIDs []uint) error {
+		require.Equal(t, []uint{42}, hostIDs)
+		assignedTeamID = teamID
+		return nil
+	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, limit int) (int, error) {
+		return 1, nil
+	}
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, uuids []string) error {
+		return nil
+	}
+
+	authenticate := func(token string) error {
+		return svc.Authenticate(
+			&mdm.Request{Context: ctx, Params: map[string]string{apple_mdm.EnrollTeamTokenKey: token}},
+			&mdm.Authenticate{Enrollment: mdm.Enrollment{UDID: hostUUID}},
+		)
+	}
+
+	// the host is transferred to the team of the token
+	require.NoError(t, authenticate("team-token"))
+	require.True(t, ds.AddHostsToTeamFuncInvoked)
+	require.NotNil(t, assignedTeamID)
+	require.Equal(t, uint(3), *assignedTeamID)
+	require.True(t, ds.BulkSetPendingMDMAppleHostProfilesFuncInvoked)
+	require.Equal(t, &fleet.ActivityTypeTransferredHostsToTeam{
+		TeamID:   ptr.Uint(3),
+		TeamName: ptr.String("Workstations"),
+		HostIDs:  []uint{42},
+	}, transferred)
+
+	// the host is already in the team of the token
+	ds.AddHostsToTeamFuncInvoked = false
+	hostTeamID = ptr.Uint(3)
+	require.NoError(t, authenticate("team-token"))
+	require.False(t, ds.AddHostsToTeamFuncInvoked)
+
+	// the token of no team transfers the host out of its team
+	require.NoError(t, authenticate("no-team-token"))
+	require.True(t, ds.AddHostsToTeamFuncInvoked)
+	require.Nil(t, assignedTeamID)
+	require.Equal(t, &fleet.ActivityTypeTransferredHostsToTeam{HostIDs: []uint{42}}, transferred)
+
+	// a rotated token does not prevent the enrollment
+	ds.AddHostsToTeamFuncInvoked = false
+	require.NoError(t, authenticate("rotated-token"))
+	require.False(t, ds.AddHostsToTeamFuncInvoked)
+	require.True(t, ds.NewActivityFuncInvoked)
+}
+
+func TestMDMAppleTeamEnrollmentProfile(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+	ctx = test.UserContext(ctx, test.UserAdmin)
+
+	currentToken := ""
+	ds.EnsureMDMAppleTeamEnrollmentTokenFunc = func(ctx context.Context, teamID *uint, token string) (*fleet.MDMAppleTeamEnrollmentToken, error) {
+		require.Nil(t, teamID)
+		require.NotEmpty(t, token)
+		if currentToken == "" {
+			currentToken = token
+		}
+		return &fleet.MDMAppleTeamEnrollmentToken{Token: currentToken}, nil
+	}
+	ds.SetMDMAppleTeamEnrollmentTokenFunc = func(ctx context.Context, teamID *uint, token string) (*fleet.MDMAppleTeamEnrollmentToken, error) {
+		require.Nil(t, teamID)
+		require.NotEmpty(t, token)
+		currentToken = token
+		return &fleet.MDMAppleTeamEnrollmentToken{Token: currentToken}, nil
+	}
+
+	// the profile carries the token of the team and is the same on every
+	// download
+	profile, err := svc.GetMDMAppleTeamEnrollmentProfile(ctx, ptr.Uint(0))
+	require.NoError(t, err)
+	require.NotEmpty(t, currentToken)
+	require.Contains(t, string(profile), "https://foo.example.com/mdm/apple/mdm?enrollment_team_token="+url.QueryEscape(currentToken))
+	again, err := svc.GetMDMAppleTeamEnrollmentProfile(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, profile, again)
+
+	// rotating the token changes the profile
+	prevToken := currentToken
+	require.NoError(t, svc.RotateMDMAppleTeamEnrollmentToken(ctx, nil))
+	require.NotEqual(t, prevToken, currentToken)
+	rotated, err := svc.GetMDMAppleTeamEnrollmentProfile(ctx, nil)
+	require.NoError(t, err)
+	require.NotContains(t, string(rotated), url.QueryEscape(prevToken))
+
+	// the profiles grant the same access as the enroll secrets
+	for _, tc := range []struct {
+		user               *fleet.User
+		shouldFailWithAuth bool
+	}{
+		{test.UserAdmin, false},
+		{test.UserMaintainer, false},
+		{test.UserObserver, true},
+		{test.UserObserverPlus, true},
+		{test.UserTeamAdminTeam1, true},
+		{test.UserNoRoles, true},
+	} {
+		ctx := test.UserContext(ctx, tc.user)
+		_, getErr := svc.GetMDMAppleTeamEnrollmentProfile(ctx, nil)
+		rotateErr := svc.RotateMDMAppleTeamEnrollmentToken(ctx, nil)
+		for _, err := range []error{getErr, rotateErr} {
+			if tc.shouldFailWithAuth {
+				require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+			} else {
+				require.NoError(t, err)
+			}
+		}
+	}
+}
+
+func TestMDMTokenUpdate(t *testing.T) {
+	ctx := context.Background()
+	ds := new(mock.Store)
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, t time.Time) error {
+		return nil
+	}
+	mdmStorage := &nanomdm_mock.Storage{}
+	pushFactory, _ := newMockAPNSPushProviderFactory()
+	pusher := nanomdm_pushsvc.New(
+		mdmStorage,
+		mdmStorage,
+		pushFactory,
+		NewNanoMDMLogger(kitlog.NewJSONLogger(os.Stdout)),
+	)
+	cmdr := apple_mdm.NewMDMAppleCommander(mdmStorage, pusher)
+	svc := MDMAppleCheckinAndCommandService{ds: ds, commander: cmdr, logger: kitlog.NewNopLogger()}
+	uuid, serial, model, wantTeamID := "ABC-DEF-GHI", "XYZABC", "MacBookPro 16,1", uint(12)
+	serverURL := "https://example.com"
+	installEnterpriseApplicationCalls, activationLockBypassCodeCalls := 0, 0
+
+	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+		require.NotNil(t, cmd)
+		switch cmd.Command.RequestType {
+		case "InstallEnterpriseApplication":
+			installEnterpriseApplicationCalls++
+		case "ActivationLockBypassCode":
+			activationLockBypassCodeCalls++
+		default:
+			t.Fatalf("unexpected command %s", cmd.Command.RequestType)
+		}
+		return nil, nil
+	}
+
+	mdmStorage.RetrievePushInfoFunc = func(p0 context.Context, targetUUIDs []string) (map[string]*mdm.Push, error) {
+		require.ElementsMatch(t, []string{uuid}, targetUUIDs)
+		pushes := make(map[string]*mdm.Push, len(targetUUIDs))
+		for _, uuid := range targetUUIDs {
+			pushes[uuid] = &mdm.Push{
+				PushMagic: "magic" + uuid,
+				Token:     []byte("token" + uuid),
+				Topic:     "topic" + uuid,
+			}
+		}
+
+		return pushes, nil
+	}
+
+	mdmStorage.RetrievePushCertFunc = func(ctx context.Context, topic string) (*tls.Certificate, string, error) {
+		cert, err := tls.LoadX509KeyPair("testdata/server.pem", "testdata/server.key")
+		return &cert, "", err
+	}
+
+	mdmStorage.IsPushCertStaleFunc = func(ctx context.Context, topic string, staleToken string) (bool, error) {
+		return false, nil
+	}
+
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		appCfg := &fleet.AppConfig{}
+		appCfg.ServerSettings.ServerURL = serverURL
+		return appCfg, nil
+	}
+	ds.GetNanoMDMEnrollmentFunc = func(ctx context.Context, hostUUID string) (*fleet.NanoEnrollment, error) {
+		return &fleet.NanoEnrollment{Enabled: true, Type: "Device", TokenUpdateTally: 1}, nil
+	}
+	ds.ClearMDMApplePushFailuresFunc = func(ctx context.Context, enrollmentID string) error {
+		require.Equal(t, uuid, enrollmentID)
+		return nil
+	}
+
+	ds.GetHostMDMCheckinInfoFunc = func(ct context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		require.Equal(t, uuid, hostUUID)
+		return &fleet.HostMDMCheckinInfo{
+			HardwareSerial:   serial,
+			DisplayName:      model,
+			InstalledFromDEP: true,
+			TeamID:           wantTeamID,
+		}, nil
+	}
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
+		return nil
+	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		return 0, nil
+	}
+	ds.ListMDMAppleProfileIdentifierConflictsFunc = func(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+		return nil, nil
+	}
+
+	ds.GetMDMAppleBootstrapPackageMetaFunc = func(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackage, error) {
+		require.Equal(t, wantTeamID, teamID)
+		return &fleet.MDMAppleBootstrapPackage{}, nil
+	}
+
+	ds.RecordHostBootstrapPackageFunc = func(ctx context.Context, commandUUID string, hostUUID string) error {
+		require.Equal(t, uuid, hostUUID)
+		require.NotEmpty(t, commandUUID)
+		return nil
+	}
+
+	err := svc.TokenUpdate(
+		&mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: uuid}},
+		&mdm.TokenUpdate{
+			Enrollment: mdm.Enrollment{
+				UDID: uuid,
+			},
+		},
+	)
+	require.NoError(t, err)
+	require.True(t, ds.ClearMDMApplePushFailuresFuncInvoked)
+	require.True(t, ds.BulkSetPendingMDMAppleHostProfilesFuncInvoked)
+	require.True(t, ds.GetHostMDMCheckinInfoFuncInvoked)
+	require.True(t, ds.AppConfigFuncInvoked)
+	require.True(t, ds.RecordHostBootstrapPackageFuncInvoked)
+	require.Equal(t, 2, installEnterpriseApplicationCalls)
+	require.Equal(t, 1, activationLockBypassCodeCalls)
+
+	// bootstrap packages with an invalid signature are not installed when
+	// blocking is enabled.
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		appCfg := &fleet.AppConfig{}
+		appCfg.ServerSettings.ServerURL = serverURL
+		appCfg.MDM.BlockInvalidBootstrapPackages = true
+		return appCfg, nil
+	}
+	ds.GetMDMAppleBootstrapPackageMetaFunc = func(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackage, error) {
+		return &fleet.MDMAppleBootstrapPackage{SignatureStatus: fleet.MDMAppleBootstrapPackageSignatureInvalid}, nil
+	}
+	ds.RecordHostBootstrapPackageFuncInvoked = false
+	err = svc.TokenUpdate(
+		&mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: uuid}},
+		&mdm.TokenUpdate{
+			Enrollment: mdm.Enrollment{
+				UDID: uuid,
+			},
+		},
+	)
+	require.NoError(t, err)
+	require.False(t, ds.RecordHostBootstrapPackageFuncInvoked)
+	// only fleetd was installed
+	require.Equal(t, 3, installEnterpriseApplicationCalls)
+}
+
+func TestMDMCheckout(t *testing.T) {
+	ds := new(mock.Store)
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, t time.Time) error {
+		return nil
+	}
+	svc := MDMAppleCheckinAndCommandService{ds: ds}
+	ctx := context.Background()
+	uuid, serial, installedFromDEP, displayName := "ABC-DEF-GHI", "XYZABC", true, "Test's MacBook"
+
+	ds.UpdateHostTablesOnMDMUnenrollFunc = func(ctx context.Context, hostUUID string) error {
+		require.Equal(t, uuid, hostUUID)
+		return nil
+	}
+
+	ds.GetHostMDMCheckinInfoFunc = func(ct context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		require.Equal(t, uuid, hostUUID)
+		return &fleet.HostMDMCheckinInfo{
+			HardwareSerial:   serial,
+			DisplayName:      displayName,
+			InstalledFromDEP: installedFromDEP,
+		}, nil
+	}
+
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		a, ok := activity.(*fleet.ActivityTypeMDMUnenrolled)
+		require.True(t, ok)
+		require.Nil(t, user)
+		require.Equal(t, "mdm_unenrolled", activity.ActivityName())
+		require.Equal(t, serial, a.HostSerial)
+		require.Equal(t, displayName, a.HostDisplayName)
+		require.True(t, a.InstalledFromDEP)
+		return nil
+	}
+
+	err := svc.CheckOut(
+		&mdm.Request{Context: ctx},
+		&mdm.CheckOut{
+			Enrollment: mdm.Enrollment{
+				UDID: uuid,
+			},
+		},
+	)
+	require.NoError(t, err)
+	require.True(t, ds.UpdateHostTablesOnMDMUnenrollFuncInvoked)
+	require.True(t, ds.GetHostMDMCheckinInfoFuncInvoked)
+	require.True(t, ds.NewActivityFuncInvoked)
+}
+
+func TestMDMCommandAndReportResultsProfileHandling(t *testing.T) {
+	ds := new(mock.Store)
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, t time.Time) error {
+		return nil
+	}
+	svc := MDMAppleCheckinAndCommandService{ds: ds}
+	ctx := context.Background()
+	hostUUID := "ABC-DEF-GHI"
+	commandUUID := "COMMAND-UUID"
+
+	cases := []struct {
+		status      string
+		requestType string
+		errors      []mdm.ErrorChain
+		want        *fleet.HostMDMAppleProfile
+	}{
+		{
+			status:      "Acknowledged",
+			requestType: "InstallProfile",
+			errors:      nil,
+			want: &fleet.HostMDMAppleProfile{
+				Status:        &fleet.MDMAppleDeliveryVerifying,
+				Detail:        "",
+				OperationType: fleet.MDMAppleOperationTypeInstall,
+			},
+		},
+		{
+			status:      "Acknowledged",
+			requestType: "RemoveProfile",
+			errors:      nil,
+			want: &fleet.HostMDMAppleProfile{
+				Status:        &fleet.MDMAppleDeliveryVerifying,
+				Detail:        "",
+				OperationType: fleet.MDMAppleOperationTypeRemove,
+			},
+		},
+		{
+			status:      "Error",
+			requestType: "InstallProfile",
+			errors: []mdm.ErrorChain{
+				{ErrorCode: 123, ErrorDomain: "testDomain", USEnglishDescription: "testMessage"},
+			},
+			want: &fleet.HostMDMAppleProfile{
+				Status:        &fleet.MDMAppleDeliveryFailed,
+				Detail:        "testDomain (123): testMessage\n",
+				OperationType: fleet.MDMAppleOperationTypeInstall,
+			},
+		},
+		{
+			status:      "Error",
+			requestType: "RemoveProfile",
+			errors: []mdm.ErrorChain{
+				{ErrorCode: 123, ErrorDomain: "testDomain", USEnglishDescription: "testMessage"},
+				{ErrorCode: 321, ErrorDomain: "domainTest", USEnglishDescription: "messageTest"},
+			},
+			want: &fleet.HostMDMAppleProfile{
+				Status:        &fleet.MDMAppleDeliveryFailed,
+				Detail:        "testDomain (123): testMessage\ndomainTest (321): messageTest\n",
+				OperationType: fleet.MDMAppleOperationTypeRemove,
+			},
+		},
+		{
+			status:      "Error",
+			requestType: "RemoveProfile",
+			errors:      nil,
+			want: &fleet.HostMDMAppleProfile{
+				Status:        &fleet.MDMAppleDeliveryFailed,
+				Detail:        "",
+				OperationType: fleet.MDMAppleOperationTypeRemove,
+			},
+		},
+	}
+
+	// no profile failure grace period is configured
+	ds.GetHostMDMCheckinInfoFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		return &fleet.HostMDMCheckinInfo{}, nil
+	}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+
+	for _, c := range cases {
+		ds.GetMDMAppleCommandRequestTypeFunc = func(ctx context.Context, targetCmd string) (string, error) {
+			require.Equal(t, commandUUID, targetCmd)
+			return c.requestType, nil
+		}
+
+		ds.UpdateOrDeleteHostMDMAppleProfileFunc = func(ctx context.Context, profile *fleet.HostMDMAppleProfile) error {
+			c.want.CommandUUID = commandUUID
+			c.want.HostUUID = hostUUID
+			require.Equal(t, c.want, profile)
+			return nil
+		}
+
+		_, err := svc.CommandAndReportResults(
+			&mdm.Request{Context: ctx},
+			&mdm.CommandResults{
+				Enrollment:  mdm.Enrollment{UDID: hostUUID},
+				CommandUUID: commandUUID,
+				Status:      c.status,
+				RequestType: c.requestType,
+				ErrorChain:  c.errors,
+			},
+		)
+		require.NoError(t, err)
+		require.True(t, ds.GetMDMAppleCommandRequestTypeFuncInvoked)
+		require.True(t, ds.UpdateOrDeleteHostMDMAppleProfileFuncInvoked)
+	}
+}
+
+func TestMDMCommandAndReportResultsProfileRetryableFailure(t *testing.T) {
+	ds := new(mock.Store)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, t time.Time) error {
+		return nil
+	}
+	svc := MDMAppleCheckinAndCommandService{ds: ds, logger: kitlog.NewNopLogger()}
+	ctx := context.Background()
+	hostUUID := "ABC-DEF-GHI"
+	commandUUID := "COMMAND-UUID"
+
+	grace := fleet.MacOSProfileFailureGracePeriod{RetryableErrorCodes: []int{12021}, MinConsecutiveFailures: 3}
+	ds.GetMDMAppleCommandRequestTypeFunc = func(ctx context.Context, targetCmd string) (string, error) {
+		return "InstallProfile", nil
+	}
+	ds.GetHostMDMCheckinInfoFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		return &fleet.HostMDMCheckinInfo{TeamID: 1}, nil
+	}
+	ds.TeamMDMConfigFunc = func(ctx context.Context, teamID uint) (*fleet.TeamMDM, error) {
+		require.Equal(t, uint(1), teamID)
+		return &fleet.TeamMDM{MacOSSettings: fleet.MacOSSettings{ProfileFailureGracePeriod: grace}}, nil
+	}
+	ds.UpdateOrDeleteHostMDMAppleProfileFunc = func(ctx context.Context, profile *fleet.HostMDMAppleProfile) error {
+		return nil
+	}
+	ds.UpdateHostMDMAppleProfileRetryableFailureFunc = func(ctx context.Context, profile *fleet.HostMDMAppleProfile, gracePeriod fleet.MacOSProfileFailureGracePeriod) (bool, error) {
+		require.Equal(t, grace, gracePeriod)
+		require.Equal(t, hostUUID, profile.HostUUID)
+		require.Equal(t, commandUUID, profile.CommandUUID)
+		require.Equal(t, fleet.MDMAppleOperationTypeInstall, profile.OperationType)
+		return false, nil
+	}
+
+	report := func(errs []mdm.ErrorChain) {
+		ds.UpdateOrDeleteHostMDMAppleProfileFuncInvoked = false
+		ds.UpdateHostMDMAppleProfileRetryableFailureFuncInvoked = false
+		_, err := svc.CommandAndReportResults(
+			&mdm.Request{Context: ctx},
+			&mdm.CommandResults{
+				Enrollment:  mdm.Enrollment{UDID: hostUUID},
+				CommandUUID: commandUUID,
+				Status:      "Error",
+				ErrorChain:  errs,
+			},
+		)
+		require.NoError(t, err)
+	}
+
+	// a retryable error code anywhere in the chain
+	report([]mdm.ErrorChain{
+		{ErrorCode: 4, ErrorDomain: "MCProfileErrorDomain", USEnglishDescription: "failed"},
+		{ErrorCode: 12021, ErrorDomain: "MDMClientError", USEnglishDescription: "timeout"},
+	})
+	require.True(t, ds.UpdateHostMDMAppleProfileRetryableFailureFuncInvoked)
+	require.False(t, ds.UpdateOrDeleteHostMDMAppleProfileFuncInvoked)
+
+	// a non-retryable error fails right away
+	report([]mdm.ErrorChain{{ErrorCode: 4, ErrorDomain: "MCProfileErrorDomain", USEnglishDescription: "failed"}})
+	require.False(t, ds.UpdateHostMDMAppleProfileRetryableFailureFuncInvoked)
+	require.True(t, ds.UpdateOrDeleteHostMDMAppleProfileFuncInvoked)
+}
+
+func TestMDMCommandAndReportResultsProfileSecrets(t *testing.T) {
+	ds := new(mock.Store)
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, t time.Time) error {
+		return nil
+	}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+	ds.GetHostMDMCheckinInfoFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		return &fleet.HostMDMCheckinInfo{}, nil
+	}
+	svc := MDMAppleCheckinAndCommandService{ds: ds, logger: kitlog.NewNopLogger()}
+	ctx := context.Background()
+	hostUUID := "ABC-DEF-GHI"
+
+	ds.GetMDMAppleCommandRequestTypeFunc = func(ctx context.Context, targetCmd string) (string, error) {
+		return "InstallProfile", nil
+	}
+	ds.UpdateOrDeleteHostMDMAppleProfileFunc = func(ctx context.Context, profile *fleet.HostMDMAppleProfile) error {
+		return nil
+	}
+	var redactedCmds []string
+	ds.RedactMDMAppleCommandFunc = func(ctx context.Context, commandUUID string, redactedCommand string) error {
+		require.Contains(t, redactedCommand, "<string>"+commandUUID+"</string>")
+		require.Contains(t, redactedCommand, "<data>"+base64.StdEncoding.EncodeToString([]byte(fleet.MDMAppleRedactedValue))+"</data>")
+		redactedCmds = append(redactedCmds, commandUUID)
+		return nil
+	}
+
+	report := func(commandUUID, status string) {
+		_, err := svc.CommandAndReportResults(
+			&mdm.Request{Context: ctx},
+			&mdm.CommandResults{
+				Enrollment:  mdm.Enrollment{UDID: hostUUID},
+				CommandUUID: commandUUID,
+				Status:      status,
+			},
+		)
+		require.NoError(t, err)
+	}
+
+	// the commands of the profiles without secrets are kept
+	report("COMMAND-UUID", fleet.MDMAppleStatusAcknowledged)
+	require.Empty(t, redactedCmds)
+
+	// the commands of the profiles with secrets are redacted once processed
+	secretsCmd := fleet.MDMAppleProfileSecretsCommandPrefix + "COMMAND-UUID"
+	report(secretsCmd, fleet.MDMAppleStatusNotNow)
+	require.Empty(t, redactedCmds)
+	report(secretsCmd, fleet.MDMAppleStatusAcknowledged)
+	require.Equal(t, []string{secretsCmd}, redactedCmds)
+	report(secretsCmd, fleet.MDMAppleStatusError)
+	require.Equal(t, []string{secretsCmd, secretsCmd}, redactedCmds)
+}
+
+func TestMDMCommandAndReportResultsActivationLockBypassCode(t *testing.T) {
+	ds := new(mock.Store)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, t time.Time) error {
+		return nil
+	}
+	scepCert, scepKey, err := apple_mdm.NewSCEPCACertKey()
+	require.NoError(t, err)
+	svc := MDMAppleCheckinAndCommandService{ds: ds, scepCert: scepCert, logger: kitlog.NewNopLogger()}
+	ctx := context.Background()
+	hostUUID := "ABC-DEF-GHI"
+
+	ds.GetMDMAppleCommandRequestTypeFunc = func(ctx context.Context, targetCmd string) (string, error) {
+		return "ActivationLockBypassCode", nil
+	}
+	var escrowed string
+	ds.SetHostMDMActivationLockBypassCodeFunc = func(ctx context.Context, hUUID, base64Encrypted string) error {
+		require.Equal(t, hostUUID, hUUID)
+		decrypted, err := apple_mdm.DecryptBase64WithCertificates(base64Encrypted, []*tls.Certificate{{PrivateKey: scepKey, Leaf: scepCert}})
+		require.NoError(t, err)
+		escrowed = string(decrypted)
+		return nil
+	}
+	var redacted []byte
+	ds.RedactMDMAppleCommandResultFunc = func(ctx context.Context, hUUID, commandUUID string, redactedResult []byte) error {
+		require.Equal(t, hostUUID, hUUID)
+		require.Equal(t, "COMMAND-UUID", commandUUID)
+		redacted = redactedResult
+		return nil
+	}
+
+	report := func(status string, raw []byte) error {
+		_, err := svc.CommandAndReportResults(
+			&mdm.Request{Context: ctx},
+			&mdm.CommandResults{
+				Enrollment:  mdm.Enrollment{UDID: hostUUID},
+				CommandUUID: "COMMAND-UUID",
+				Status:      status,
+				Raw:         raw,
+			},
+		)
+		return err
+	}
+	result := func(code string) []byte {
+		return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>ActivationLockBypassCode</key>
+	<string>%s</string>
+	<key>CommandUUID</key>
+	<string>COMMAND-UUID</string>
+	<key>Status</key>
+	<string>Acknowledged</string>
+	<key>UDID</key>
+	<string>%s</string>
+</dict>
+</plist>`, code, hostUUID))
+	}
+
+	require.NoError(t, report("Acknowledged", result("AAAAA-BBBBB-CCCCC")))
+	require.Equal(t, "AAAAA-BBBBB-CCCCC", escrowed)
+	require.NotContains(t, string(redacted), "AAAAA-BBBBB-CCCCC")
+	require.Contains(t, string(redacted), fleet.MDMAppleRedactedValue)
+
+	// no code returned or command failed, nothing is escrowed
+	ds.SetHostMDMActivationLockBypassCodeFuncInvoked = false
+	require.NoError(t, report("Acknowledged", result("")))
+	require.NoError(t, report("Error", nil))
+	require.False(t, ds.SetHostMDMActivationLockBypassCodeFuncInvoked)
+
+	// with the MDM events webhook enabled, the command result and the escrow
+	// are delivered as events
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{WebhookSettings: fleet.WebhookSettings{
+			MDMEventsWebhook: fleet.MDMEventsWebhookSettings{Enable: true, DestinationURL: "https://example.com"},
+		}}, nil
+	}
+	var events []fleet.MDMWebhookEventType
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		require.Equal(t, worker.MDMWebhookName, job.Name)
+		var args struct {
+			Event fleet.MDMWebhookEvent `json:"event"`
+		}
+		require.NoError(t, json.Unmarshal(*job.Args, &args))
+		require.Equal(t, hostUUID, args.Event.HostUUID)
+		events = append(events, args.Event.Type)
+		return job, nil
+	}
+	require.NoError(t, report("Acknowledged", result("AAAAA-BBBBB-CCCCC")))
+	require.Equal(t, []fleet.MDMWebhookEventType{fleet.MDMWebhookEventCommandResult, fleet.MDMWebhookEventKeyEscrowed}, events)
+
+	events = nil
+	require.NoError(t, report("Error", nil))
+	require.Equal(t, []fleet.MDMWebhookEventType{fleet.MDMWebhookEventCommandResult}, events)
+}
+
+func TestMDMCommandAndReportResultsCertificateList(t *testing.T) {
+	ds := new(mock.Store)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, t time.Time) error {
+		return nil
+	}
+	svc := MDMAppleCheckinAndCommandService{ds: ds, logger: kitlog.NewNopLogger()}
+	ctx := context.Background()
+	hostUUID := "ABC-DEF-GHI"
+
+	ds.GetMDMAppleCommandRequestTypeFunc = func(ctx context.Context, targetCmd string) (string, error) {
+		return "CertificateList", nil
+	}
+	var replaced, managed []*fleet.HostMDMCertificate
+	ds.ReplaceHostMDMAppleCertificatesFunc = func(ctx context.Context, hUUID string, certs []*fleet.HostMDMCertificate) error {
+		require.Equal(t, hostUUID, hUUID)
+		replaced = certs
+		return nil
+	}
+	ds.UpdateHostMDMAppleManagedCertificatesFunc = func(ctx context.Context, hUUID string, certs []*fleet.HostMDMCertificate) error {
+		require.Equal(t, hostUUID, hUUID)
+		managed = certs
+		return nil
+	}
+
+	raw := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CertificateList</key>
+	<array>
+		<dict>
+			<key>CommonName</key>
+			<string>VPN</string>
+			<key>Data</key>
+			<data>Zm9v</data>
+			<key>IsIdentity</key>
+			<true/>
+		</dict>
+	</array>
+	<key>Status</key>
+	<string>Acknowledged</string>
+</dict>
+</plist>`)
+	report := func(cmdUUID, status string) error {
+		_, err := svc.CommandAndReportResults(
+			&mdm.Request{Context: ctx},
+			&mdm.CommandResults{
+				Enrollment:  mdm.Enrollment{UDID: hostUUID},
+				CommandUUID: cmdUUID,
+				Status:      status,
+				Raw:         raw,
+			},
+		)
+		return err
+	}
+
+	require.NoError(t, report(fleet.MDMAppleCertificateListAllCommandPrefix+"uuid", "Acknowledged"))
+	require.Len(t, replaced, 1)
+	require.Equal(t, "VPN", replaced[0].CommonName)
+	require.True(t, replaced[0].IsIdentity)
+	require.False(t, ds.UpdateHostMDMAppleManagedCertificatesFuncInvoked)
+
+	require.NoError(t, report(fleet.MDMAppleCertificateListManagedCommandPrefix+"uuid", "Acknowledged"))
+	require.Len(t, managed, 1)
+	require.Equal(t, replaced[0].SHA1Sum, managed[0].SHA1Sum)
+
+	// commands not sent by fleet and failed commands are ignored
+	ds.ReplaceHostMDMAppleCertificatesFuncInvoked = false
+	ds.UpdateHostMDMAppleManagedCertificatesFuncInvoked = false
+	require.NoError(t, report("other-uuid", "Acknowledged"))
+	require.NoError(t, report(fleet.MDMAppleCertificateListAllCommandPrefix+"uuid", "Error"))
+	require.False(t, ds.ReplaceHostMDMAppleCertificatesFuncInvoked)
+	require.False(t, ds.UpdateHostMDMAppleManagedCertificatesFuncInvoked)
+}
+
+func TestMDMCommandAndReportResultsProfileList(t *testing.T) {
+	ds := new(mock.Store)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+	ds.MarkHostMDMCheckedInFunc = func(ctx context.Context, hostUUID string, t time.Time) error {
+		return nil
+	}
+	svc := MDMAppleCheckinAndCommandService{ds: ds, logger: kitlog.NewNopLogger()}
+	ctx := context.Background()
+	hostUUID := "ABC-DEF-GHI"
+
+	ds.GetMDMAppleCommandRequestTypeFunc = func(ctx context.Context, targetCmd string) (string, error) {
+		return "ProfileList", nil
+	}
+	ds.ListHostMDMAppleProfileInstallsFunc = func(ctx context.Context, hUUID string) ([]*fleet.HostMDMAppleProfileInstall, error) {
+		require.Equal(t, hostUUID, hUUID)
+		return []*fleet.HostMDMAppleProfileInstall{
+			// up to date
+			{ProfileID: 1, ProfileIdentifier: "com.example.wifi", Status: &fleet.MDMAppleDeliveryVerifying, Mobileconfig: mcBytesForTest("Wi-Fi", "com.example.wifi", "uuid-1")},
+			// stale
+			{ProfileID: 2, ProfileIdentifier: "com.example.vpn", Status: &fleet.MDMAppleDeliveryVerifying, Mobileconfig: mcBytesForTest("VPN", "com.example.vpn", "uuid-2-new")},
+			// stale but the new version is being installed
+			{ProfileID: 3, ProfileIdentifier: "com.example.dock", Status: &fleet.MDMAppleDeliveryPending, Mobileconfig: mcBytesForTest("Dock", "com.example.dock", "uuid-3-new")},
+			// not reported installed
+			{ProfileID: 4, ProfileIdentifier: "com.example.missing", Status: &fleet.MDMAppleDeliveryVerifying, Mobileconfig: mcBytesForTest("Missing", "com.example.missing", "uuid-4")},
+		}, nil
+	}
+	var payloadUUIDs map[uint]string
+	ds.UpdateHostMDMAppleProfilesInstalledPayloadUUIDsFunc = func(ctx context.Context, hUUID string, uuids map[uint]string) error {
+		require.Equal(t, hostUUID, hUUID)
+		payloadUUIDs = uuids
+		return nil
+	}
+	var reinstalled []uint
+	ds.SetHostMDMAppleProfilesToReinstallFunc = func(ctx context.Context, hUUID string, profileIDs []uint) error {
+		require.Equal(t, hostUUID, hUUID)
+		reinstalled = profileIDs
+		return nil
+	}
+
+	raw := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>ProfileList</key>
+	<array>
+		<dict>
+			<key>PayloadIdentifier</key>
+			<string>com.example.wifi</string>
+			<key>PayloadUUID</key>
+			<string>uuid-1</string>
+		</dict>
+		<dict>
+			<key>PayloadIdentifier</key>
+			<string>com.example.vpn</string>
+			<key>PayloadUUID</key>
+			<string>uuid-2-old</string>
+		</dict>
+		<dict>
+			<key>PayloadIdentifier</key>
+			<string>com.example.dock</string>
+			<key>PayloadUUID</key>
+			<string>uuid-3-old</string>
+		</dict>
+		<dict>
+			<key>PayloadIdentifier</key>
+			<string>com.example.other</string>
+			<key>PayloadUUID</key>
+			<string>uuid-other</string>
+		</dict>
+	</array>
+	<key>Status</key>
+	<string>Acknowledged</string>
+</dict>
+</plist>`)
+	report := func(cmdUUID, status string) error {
+		_, err := svc.CommandAndReportResults(
+			&mdm.Request{Context: ctx},
+			&mdm.CommandResults{
+				Enrollment:  mdm.Enrollment{UDID: hostUUID},
+				CommandUUID: cmdUUID,
+				Status:      status,
+				Raw:         raw,
+			},
+		)
+		return err
+	}
+
+	require.NoError(t, report(fleet.MDMAppleProfileListCommandPrefix+"uuid", "Acknowledged"))
+	require.Equal(t, map[uint]string{1: "uuid-1", 2: "uuid-2-old", 3: "uuid-3-old"}, payloadUUIDs)
+	require.Equal(t, []uint{2}, reinstalled)
+
+	// commands not sent by fleet and failed commands are ignored
+	ds.ListHostMDMAppleProfileInstallsFuncInvoked = false
+	ds.UpdateHostMDMAppleProfilesInstalledPayloadUUIDsFuncInvoked = false
+	ds.SetHostMDMAppleProfilesToReinstallFuncInvoked = false
+	require.NoError(t, report("other-uuid", "Acknowledged"))
+	require.NoError(t, report(fleet.MDMAppleProfileListCommandPrefix+"uuid", "Error"))
+	require.False(t, ds.ListHostMDMAppleProfileInstallsFuncInvoked)
+	require.False(t, ds.UpdateHostMDMAppleProfilesInstalledPayloadUUIDsFuncInvoked)
+	require.False(t, ds.SetHostMDMAppleProfilesToReinstallFuncInvoked)
+}
+
+func TestMDMBatchSetAppleProfiles(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	ds.TeamByNameFunc = func(ctx context.Context, name string) (*fleet.Team, error) {
+		return &fleet.Team{ID: 1, Name: name}, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, id uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: id, Name: "team"}, nil
+	}
+	ds.BatchSetMDMAppleProfilesFunc = func(ctx context.Context, teamID *uint, profiles []*fleet.MDMAppleConfigProfile) error {
+		return nil
+	}
+	ds.BatchSetMDMAppleProfileExclusionsFunc = func(ctx context.Context, teamID *uint, exclusions []*fleet.MDMAppleProfileExclusion) error {
+		return nil
+	}
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		return nil
+	}
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
+		return nil
+	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		return 0, nil
+	}
+	ds.ListMDMAppleProfileIdentifierConflictsFunc = func(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+		return nil, nil
+	}
+	ds.ListMDMAppleConfigProfilesFunc = func(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleConfigProfile, error) {
+		return nil, nil
+	}
+
+	testCases := []struct {
+		name     string
+		user     *fleet.User
+		premium  bool
+		teamID   *uint
 		teamName *string
 		profiles [][]byte
 		wantErr  string
@@ -1509,226 +3964,795 @@ func TestMDMBatchSetAppleProfiles(t *testing.T) {
 		},
 	}
 
-	for _, tt := range testCases {
-		t.Run(tt.name, func(t *testing.T) {
-			defer func() { ds.BatchSetMDMAppleProfilesFuncInvoked = false }()
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() { ds.BatchSetMDMAppleProfilesFuncInvoked = false }()
+
+			// prepare the context with the user and license
+			ctx := viewer.NewContext(ctx, viewer.Viewer{User: tt.user})
+			tier := fleet.TierFree
+			if tt.premium {
+				tier = fleet.TierPremium
+			}
+			ctx = license.NewContext(ctx, &fleet.LicenseInfo{Tier: tier})
+
+			_, err := svc.BatchSetMDMAppleProfiles(ctx, tt.teamID, tt.teamName, tt.profiles, nil, false, false, false)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				require.True(t, ds.BatchSetMDMAppleProfilesFuncInvoked)
+				return
+			}
+			require.Error(t, err)
+			require.ErrorContains(t, err, tt.wantErr)
+			require.False(t, ds.BatchSetMDMAppleProfilesFuncInvoked)
+		})
+	}
+}
+
+func TestEditedMacosProfileActivity(t *testing.T) {
+	newProf := func(name, ident string) *fleet.MDMAppleConfigProfile {
+		prof, err := fleet.NewMDMAppleConfigProfile(mobileconfigForTest(name, ident), nil)
+		require.NoError(t, err)
+		return prof
+	}
+	checksum := func(prof *fleet.MDMAppleConfigProfile) string {
+		return hex.EncodeToString(fleet.MDMProfileChecksumMD5.Sum(prof.Mobileconfig))
+	}
+
+	// no current nor incoming profile
+	act := editedMacosProfileActivity(nil, nil, nil, nil, "")
+	b, err := json.Marshal(act)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"team_id": null, "team_name": null, "added_profiles": [], "removed_profiles": [],
+		"changed_profiles": [], "profiles_truncated": false}`, string(b))
+
+	n1, n2, n3 := newProf("N1", "I1"), newProf("N2", "I2"), newProf("N3", "I3")
+	n2b, n3b, n4 := newProf("N2b", "I2"), newProf("N3", "I3"), newProf("N4", "I4")
+	n3b.Mobileconfig = append(n3b.Mobileconfig, '\n')
+
+	act = editedMacosProfileActivity(ptr.Uint(1), ptr.String("team"),
+		[]*fleet.MDMAppleConfigProfile{n1, n2, n3},
+		[]*fleet.MDMAppleConfigProfile{n2b, n3b, n4}, fleet.MDMProfileChecksumMD5)
+	require.Equal(t, &fleet.ActivityTypeEditedMacosProfile{
+		TeamID:   ptr.Uint(1),
+		TeamName: ptr.String("team"),
+		AddedProfiles: []fleet.ActivityMacosProfileChange{
+			{Name: "N4", Identifier: "I4", Checksum: checksum(n4)},
+		},
+		RemovedProfiles: []fleet.ActivityMacosProfileChange{
+			{Name: "N1", Identifier: "I1", Checksum: checksum(n1)},
+		},
+		ChangedProfiles: []fleet.ActivityMacosProfileChange{
+			{Name: "N2b", Identifier: "I2", Checksum: checksum(n2b), PreviousName: "N2", PreviousChecksum: checksum(n2)},
+			{Name: "N3", Identifier: "I3", Checksum: checksum(n3b), PreviousChecksum: checksum(n3)},
+		},
+	}, act)
+
+	// unchanged profiles are not recorded
+	n1b, err := fleet.NewMDMAppleConfigProfile(n1.Mobileconfig, nil)
+	require.NoError(t, err)
+	act = editedMacosProfileActivity(nil, nil, []*fleet.MDMAppleConfigProfile{n1}, []*fleet.MDMAppleConfigProfile{n1b}, "")
+	require.Empty(t, act.AddedProfiles)
+	require.Empty(t, act.RemovedProfiles)
+	require.Empty(t, act.ChangedProfiles)
+
+	// the lists are bounded
+	var many []*fleet.MDMAppleConfigProfile
+	for i := 0; i < fleet.MaxActivityMacosProfileChanges+5; i++ {
+		many = append(many, newProf(fmt.Sprintf("N%d", i), fmt.Sprintf("I%d", i)))
+	}
+	act = editedMacosProfileActivity(nil, nil, nil, many, "")
+	require.Len(t, act.AddedProfiles, fleet.MaxActivityMacosProfileChanges)
+	require.True(t, act.ProfilesTruncated)
+
+	// the checksums use the configured algorithm
+	act = editedMacosProfileActivity(nil, nil, nil, []*fleet.MDMAppleConfigProfile{n1}, fleet.MDMProfileChecksumSHA256)
+	canonical, err := n1.Mobileconfig.Canonical()
+	require.NoError(t, err)
+	sum := sha256.Sum256(canonical)
+	require.Equal(t, hex.EncodeToString(sum[:16]), act.AddedProfiles[0].Checksum)
+
+	// cosmetic changes of the profiles are not recorded
+	reformatted := *n1
+	reformatted.Mobileconfig = bytes.ReplaceAll(n1.Mobileconfig, []byte("\n\t"), []byte("\n    "))
+	require.NotEqual(t, n1.Mobileconfig, reformatted.Mobileconfig)
+	act = editedMacosProfileActivity(nil, nil, []*fleet.MDMAppleConfigProfile{n1}, []*fleet.MDMAppleConfigProfile{&reformatted}, "")
+	require.Empty(t, act.ChangedProfiles)
+}
+
+func TestUpdateMDMAppleSettings(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	ds.TeamFunc = func(ctx context.Context, id uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: id, Name: "team"}, nil
+	}
+	ds.SaveTeamFunc = func(ctx context.Context, team *fleet.Team) (*fleet.Team, error) {
+		return team, nil
+	}
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		return nil
+	}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+	ds.SaveAppConfigFunc = func(ctx context.Context, appConfig *fleet.AppConfig) error {
+		return nil
+	}
+
+	testCases := []struct {
+		name    string
+		user    *fleet.User
+		premium bool
+		teamID  *uint
+		wantErr string
+	}{
+		{
+			"global admin",
+			&fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)},
+			false,
+			nil,
+			ErrMissingLicense.Error(),
+		},
+		{
+			"global admin premium",
+			&fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)},
+			true,
+			nil,
+			"",
+		},
+		{
+			"global admin, team",
+			&fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)},
+			true,
+			ptr.Uint(1),
+			"",
+		},
+		{
+			"global maintainer",
+			&fleet.User{GlobalRole: ptr.String(fleet.RoleMaintainer)},
+			false,
+			nil,
+			ErrMissingLicense.Error(),
+		},
+		{
+			"global maintainer premium",
+			&fleet.User{GlobalRole: ptr.String(fleet.RoleMaintainer)},
+			true,
+			nil,
+			"",
+		},
+		{
+			"global maintainer, team",
+			&fleet.User{GlobalRole: ptr.String(fleet.RoleMaintainer)},
+			true,
+			ptr.Uint(1),
+			"",
+		},
+		{
+			"global observer",
+			&fleet.User{GlobalRole: ptr.String(fleet.RoleObserver)},
+			true,
+			nil,
+			authz.ForbiddenErrorMessage,
+		},
+		{
+			"team admin, DOES belong to team",
+			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleAdmin}}},
+			true,
+			ptr.Uint(1),
+			"",
+		},
+		{
+			"team admin, DOES NOT belong to team",
+			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 2}, Role: fleet.RoleAdmin}}},
+			true,
+			ptr.Uint(1),
+			authz.ForbiddenErrorMessage,
+		},
+		{
+			"team maintainer, DOES belong to team",
+			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleMaintainer}}},
+			true,
+			ptr.Uint(1),
+			"",
+		},
+		{
+			"team maintainer, DOES NOT belong to team",
+			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 2}, Role: fleet.RoleMaintainer}}},
+			true,
+			ptr.Uint(1),
+			authz.ForbiddenErrorMessage,
+		},
+		{
+			"team observer, DOES belong to team",
+			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleObserver}}},
+			true,
+			ptr.Uint(1),
+			authz.ForbiddenErrorMessage,
+		},
+		{
+			"team observer, DOES NOT belong to team",
+			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 2}, Role: fleet.RoleObserver}}},
+			true,
+			ptr.Uint(1),
+			authz.ForbiddenErrorMessage,
+		},
+		{
+			"user no roles",
+			&fleet.User{ID: 1337},
+			true,
+			nil,
+			authz.ForbiddenErrorMessage,
+		},
+		{
+			"team id with free license",
+			&fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)},
+			false,
+			ptr.Uint(1),
+			ErrMissingLicense.Error(),
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// prepare the context with the user and license
+			ctx := viewer.NewContext(ctx, viewer.Viewer{User: tt.user})
+			tier := fleet.TierFree
+			if tt.premium {
+				tier = fleet.TierPremium
+			}
+			ctx = license.NewContext(ctx, &fleet.LicenseInfo{Tier: tier})
+
+			err := svc.UpdateMDMAppleSettings(ctx, fleet.MDMAppleSettingsPayload{TeamID: tt.teamID})
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestMDMAppleCommander(t *testing.T) {
+	ctx := context.Background()
+	mdmStorage := &nanomdm_mock.Storage{}
+	pushFactory, _ := newMockAPNSPushProviderFactory()
+	pusher := nanomdm_pushsvc.New(
+		mdmStorage,
+		mdmStorage,
+		pushFactory,
+		NewNanoMDMLogger(kitlog.NewJSONLogger(os.Stdout)),
+	)
+	cmdr := apple_mdm.NewMDMAppleCommander(mdmStorage, pusher)
+
+	// TODO(roberto): there's a data race in the mock when more
+	// than one host ID is provided because the pusher uses one
+	// goroutine per uuid to send the commands
+	hostUUIDs := []string{"A"}
+	payloadName := "com.foo.bar"
+	payloadIdentifier := "com-foo-bar"
+	mc := mobileconfigForTest(payloadName, payloadIdentifier)
+
+	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+		require.NotNil(t, cmd)
+		require.Equal(t, cmd.Command.RequestType, "InstallProfile")
+		require.Contains(t, string(cmd.Raw), base64.StdEncoding.EncodeToString(mc))
+		return nil, nil
+	}
+
+	mdmStorage.RetrievePushInfoFunc = func(p0 context.Context, targetUUIDs []string) (map[string]*mdm.Push, error) {
+		require.ElementsMatch(t, hostUUIDs, targetUUIDs)
+		pushes := make(map[string]*mdm.Push, len(targetUUIDs))
+		for _, uuid := range targetUUIDs {
+			pushes[uuid] = &mdm.Push{
+				PushMagic: "magic" + uuid,
+				Token:     []byte("token" + uuid),
+				Topic:     "topic" + uuid,
+			}
+		}
+
+		return pushes, nil
+	}
+
+	mdmStorage.RetrievePushCertFunc = func(ctx context.Context, topic string) (*tls.Certificate, string, error) {
+		cert, err := tls.LoadX509KeyPair("testdata/server.pem", "testdata/server.key")
+		return &cert, "", err
+	}
+	mdmStorage.IsPushCertStaleFunc = func(ctx context.Context, topic string, staleToken string) (bool, error) {
+		return false, nil
+	}
 
-			// prepare the context with the user and license
-			ctx := viewer.NewContext(ctx, viewer.Viewer{User: tt.user})
-			tier := fleet.TierFree
-			if tt.premium {
-				tier = fleet.TierPremium
+	cmdUUID := uuid.New().String()
+	err := cmdr.InstallProfile(ctx, hostUUIDs, mc, cmdUUID)
+	require.NoError(t, err)
+	require.True(t, mdmStorage.EnqueueCommandFuncInvoked)
+	mdmStorage.EnqueueCommandFuncInvoked = false
+	require.True(t, mdmStorage.RetrievePushInfoFuncInvoked)
+	mdmStorage.RetrievePushInfoFuncInvoked = false
+
+	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+		require.NotNil(t, cmd)
+		require.Equal(t, "RemoveProfile", cmd.Command.RequestType)
+		require.Contains(t, string(cmd.Raw), payloadIdentifier)
+		return nil, nil
+	}
+	cmdUUID = uuid.New().String()
+	err = cmdr.RemoveProfile(ctx, hostUUIDs, payloadIdentifier, cmdUUID)
+	require.True(t, mdmStorage.EnqueueCommandFuncInvoked)
+	mdmStorage.EnqueueCommandFuncInvoked = false
+	require.True(t, mdmStorage.RetrievePushInfoFuncInvoked)
+	mdmStorage.RetrievePushInfoFuncInvoked = false
+	require.NoError(t, err)
+
+	cmdUUID = uuid.New().String()
+	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+		require.NotNil(t, cmd)
+		require.Equal(t, "InstallEnterpriseApplication", cmd.Command.RequestType)
+		require.Contains(t, string(cmd.Raw), "http://test.example.com")
+		require.Contains(t, string(cmd.Raw), cmdUUID)
+		return nil, nil
+	}
+	err = cmdr.InstallEnterpriseApplication(ctx, hostUUIDs, "http://test.example.com", cmdUUID)
+	require.NoError(t, err)
+	require.True(t, mdmStorage.EnqueueCommandFuncInvoked)
+	mdmStorage.EnqueueCommandFuncInvoked = false
+	require.True(t, mdmStorage.RetrievePushInfoFuncInvoked)
+	mdmStorage.RetrievePushInfoFuncInvoked = false
+}
+
+func TestMDMAppleRefreshHostCertificates(t *testing.T) {
+	ctx := context.Background()
+	mdmStorage := &nanomdm_mock.Storage{}
+	ds := new(mock.Store)
+	pushFactory, _ := newMockAPNSPushProviderFactory()
+	pusher := nanomdm_pushsvc.New(
+		mdmStorage,
+		mdmStorage,
+		pushFactory,
+		NewNanoMDMLogger(kitlog.NewNopLogger()),
+	)
+	cmdr := apple_mdm.NewMDMAppleCommander(mdmStorage, pusher)
+	hostUUIDs := []string{"ABC-DEF"}
+
+	ds.ListMDMAppleHostUUIDsToRefreshCertificatesFunc = func(ctx context.Context, interval time.Duration, limit int) ([]string, error) {
+		require.Equal(t, mdmAppleCertificatesRefreshInterval, interval)
+		require.Equal(t, mdmAppleCertificatesRefreshBatchSize, limit)
+		return hostUUIDs, nil
+	}
+	ds.SetMDMAppleHostCertificatesRefreshRequestedFunc = func(ctx context.Context, uuids []string) error {
+		require.Equal(t, hostUUIDs, uuids)
+		return nil
+	}
+
+	var cmds []*mdm.Command
+	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+		require.Equal(t, hostUUIDs, id)
+		require.Equal(t, "CertificateList", cmd.Command.RequestType)
+		cmds = append(cmds, cmd)
+		return nil, nil
+	}
+	mdmStorage.RetrievePushInfoFunc = func(p0 context.Context, targetUUIDs []string) (map[string]*mdm.Push, error) {
+		pushes := make(map[string]*mdm.Push, len(targetUUIDs))
+		for _, uuid := range targetUUIDs {
+			pushes[uuid] = &mdm.Push{
+				PushMagic: "magic" + uuid,
+				Token:     []byte("token" + uuid),
+				Topic:     "topic" + uuid,
 			}
-			ctx = license.NewContext(ctx, &fleet.LicenseInfo{Tier: tier})
+		}
+		return pushes, nil
+	}
+	mdmStorage.RetrievePushCertFunc = func(ctx context.Context, topic string) (*tls.Certificate, string, error) {
+		cert, err := tls.LoadX509KeyPair("testdata/server.pem", "testdata/server.key")
+		return &cert, "", err
+	}
+	mdmStorage.IsPushCertStaleFunc = func(ctx context.Context, topic string, staleToken string) (bool, error) {
+		return false, nil
+	}
 
-			err := svc.BatchSetMDMAppleProfiles(ctx, tt.teamID, tt.teamName, tt.profiles, false)
-			if tt.wantErr == "" {
-				require.NoError(t, err)
-				require.True(t, ds.BatchSetMDMAppleProfilesFuncInvoked)
-				return
+	err := RefreshMDMAppleHostCertificates(ctx, ds, cmdr, kitlog.NewNopLogger())
+	require.NoError(t, err)
+	require.True(t, ds.SetMDMAppleHostCertificatesRefreshRequestedFuncInvoked)
+	require.Len(t, cmds, 2)
+	require.True(t, strings.HasPrefix(cmds[0].CommandUUID, fleet.MDMAppleCertificateListAllCommandPrefix))
+	require.Contains(t, string(cmds[0].Raw), "<false/>")
+	require.True(t, strings.HasPrefix(cmds[1].CommandUUID, fleet.MDMAppleCertificateListManagedCommandPrefix))
+	require.Contains(t, string(cmds[1].Raw), "<true/>")
+
+	// no hosts to refresh
+	cmds = nil
+	ds.SetMDMAppleHostCertificatesRefreshRequestedFuncInvoked = false
+	ds.ListMDMAppleHostUUIDsToRefreshCertificatesFunc = func(ctx context.Context, interval time.Duration, limit int) ([]string, error) {
+		return nil, nil
+	}
+	err = RefreshMDMAppleHostCertificates(ctx, ds, cmdr, kitlog.NewNopLogger())
+	require.NoError(t, err)
+	require.False(t, ds.SetMDMAppleHostCertificatesRefreshRequestedFuncInvoked)
+	require.Empty(t, cmds)
+}
+
+func TestMDMAppleReconcileProfiles(t *testing.T) {
+	ctx := context.Background()
+	mdmStorage := &nanomdm_mock.Storage{}
+	ds := new(mock.Store)
+	pushFactory, _ := newMockAPNSPushProviderFactory()
+	pusher := nanomdm_pushsvc.New(
+		mdmStorage,
+		mdmStorage,
+		pushFactory,
+		NewNanoMDMLogger(kitlog.NewNopLogger()),
+	)
+	cmdr := apple_mdm.NewMDMAppleCommander(mdmStorage, pusher)
+	hostUUID, hostUUID2 := "ABC-DEF", "GHI-JKL"
+	contents1 := []byte("test-content-1")
+	contents1Base64 := base64.StdEncoding.EncodeToString(contents1)
+	contents2 := []byte("test-content-2")
+	contents2Base64 := base64.StdEncoding.EncodeToString(contents2)
+	contents4 := []byte("test-content-4")
+	contents4Base64 := base64.StdEncoding.EncodeToString(contents4)
+
+	ds.ListMDMAppleProfilesToInstallFunc = func(ctx context.Context) ([]*fleet.MDMAppleProfilePayload, error) {
+		return []*fleet.MDMAppleProfilePayload{
+			{ProfileID: 1, ProfileIdentifier: "com.add.profile", HostUUID: hostUUID},
+			{ProfileID: 2, ProfileIdentifier: "com.add.profile.two", HostUUID: hostUUID},
+			{ProfileID: 2, ProfileIdentifier: "com.add.profile.two", HostUUID: hostUUID2},
+			{ProfileID: 4, ProfileIdentifier: "com.add.profile.four", HostUUID: hostUUID2},
+		}, nil
+	}
+
+	ds.ListMDMAppleProfilesToRemoveFunc = func(ctx context.Context) ([]*fleet.MDMAppleProfilePayload, error) {
+		return []*fleet.MDMAppleProfilePayload{
+			{ProfileID: 3, ProfileIdentifier: "com.remove.profile", HostUUID: hostUUID},
+			{ProfileID: 3, ProfileIdentifier: "com.remove.profile", HostUUID: hostUUID2},
+		}, nil
+	}
+
+	ds.ListMDMAppleHostProfilesWithQueuedCommandFunc = func(ctx context.Context, opType fleet.MDMAppleOperationType, hostUUIDs []string) ([]*fleet.MDMAppleProfilePayload, error) {
+		return nil, nil
+	}
+	ds.GetMDMAppleProfilesContentsFunc = func(ctx context.Context, profileIDs []uint) (map[uint]mobileconfig.Mobileconfig, error) {
+		require.ElementsMatch(t, []uint{1, 2, 4}, profileIDs)
+		// only those profiles that are to be installed
+		return map[uint]mobileconfig.Mobileconfig{
+			1: contents1,
+			2: contents2,
+			4: contents4,
+		}, nil
+	}
+
+	var enqueueFailForOp fleet.MDMAppleOperationType
+	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+		require.NotNil(t, cmd)
+		require.NotEmpty(t, cmd.CommandUUID)
+
+		switch cmd.Command.RequestType {
+		case "InstallProfile":
+			// may be called for a single host or both
+			if len(id) == 2 {
+				require.ElementsMatch(t, []string{hostUUID, hostUUID2}, id)
+			} else {
+				require.Len(t, id, 1)
 			}
-			require.Error(t, err)
-			require.ErrorContains(t, err, tt.wantErr)
-			require.False(t, ds.BatchSetMDMAppleProfilesFuncInvoked)
-		})
+
+			if !strings.Contains(string(cmd.Raw), contents1Base64) && !strings.Contains(string(cmd.Raw), contents2Base64) &&
+				!strings.Contains(string(cmd.Raw), contents4Base64) {
+				require.Failf(t, "profile contents don't match", "expected to contain %s, %s or %s but got %s",
+					contents1Base64, contents2Base64, contents4Base64, string(cmd.Raw))
+			}
+		case "RemoveProfile":
+			require.ElementsMatch(t, []string{hostUUID, hostUUID2}, id)
+			require.Contains(t, string(cmd.Raw), "com.remove.profile")
+		}
+		switch {
+		case enqueueFailForOp == fleet.MDMAppleOperationTypeInstall && cmd.Command.RequestType == "InstallProfile":
+			return nil, errors.New("enqueue error")
+		case enqueueFailForOp == fleet.MDMAppleOperationTypeRemove && cmd.Command.RequestType == "RemoveProfile":
+			return nil, errors.New("enqueue error")
+		}
+		return nil, nil
+	}
+
+	mdmStorage.RetrievePushInfoFunc = func(ctx context.Context, tokens []string) (map[string]*mdm.Push, error) {
+		res := make(map[string]*mdm.Push, len(tokens))
+		for _, t := range tokens {
+			res[t] = &mdm.Push{
+				PushMagic: "",
+				Token:     []byte(t),
+				Topic:     "",
+			}
+		}
+		return res, nil
+	}
+	mdmStorage.RetrievePushCertFunc = func(ctx context.Context, topic string) (*tls.Certificate, string, error) {
+		cert, err := tls.LoadX509KeyPair("testdata/server.pem", "testdata/server.key")
+		return &cert, "", err
+	}
+	mdmStorage.IsPushCertStaleFunc = func(ctx context.Context, topic string, staleToken string) (bool, error) {
+		return false, nil
+	}
+
+	var failedCall bool
+	var failedCheck func([]*fleet.MDMAppleBulkUpsertHostProfilePayload)
+	ds.BulkUpsertMDMAppleHostProfilesFunc = func(ctx context.Context, payload []*fleet.MDMAppleBulkUpsertHostProfilePayload) error {
+		if failedCall {
+			failedCheck(payload)
+			return nil
+		}
+
+		// next call will be failed call, until reset
+		failedCall = true
+
+		// first time it is called, it is to set the status to pending and all
+		// host profiles have a command uuid
+		cmdUUIDByProfileIDInstall := make(map[uint]string)
+		cmdUUIDByProfileIDRemove := make(map[uint]string)
+		copies := make([]*fleet.MDMAppleBulkUpsertHostProfilePayload, len(payload))
+		for i, p := range payload {
+			if p.OperationType == fleet.MDMAppleOperationTypeInstall {
+				existing, ok := cmdUUIDByProfileIDInstall[p.ProfileID]
+				if ok {
+					require.Equal(t, existing, p.CommandUUID)
+				} else {
+					cmdUUIDByProfileIDInstall[p.ProfileID] = p.CommandUUID
+				}
+			} else {
+				require.Equal(t, fleet.MDMAppleOperationTypeRemove, p.OperationType)
+				existing, ok := cmdUUIDByProfileIDRemove[p.ProfileID]
+				if ok {
+					require.Equal(t, existing, p.CommandUUID)
+				} else {
+					cmdUUIDByProfileIDRemove[p.ProfileID] = p.CommandUUID
+				}
+			}
+
+			// clear the command UUID (in a copy so that it does not affect the
+			// pointed-to struct) from the payload for the subsequent checks
+			copyp := *p
+			copyp.CommandUUID = ""
+			copies[i] = &copyp
+		}
+
+		require.ElementsMatch(t, []*fleet.MDMAppleBulkUpsertHostProfilePayload{
+			{
+				ProfileID:         1,
+				ProfileIdentifier: "com.add.profile",
+				HostUUID:          hostUUID,
+				OperationType:     fleet.MDMAppleOperationTypeInstall,
+				Status:            &fleet.MDMAppleDeliveryPending,
+			},
+			{
+				ProfileID:         2,
+				ProfileIdentifier: "com.add.profile.two",
+				HostUUID:          hostUUID,
+				OperationType:     fleet.MDMAppleOperationTypeInstall,
+				Status:            &fleet.MDMAppleDeliveryPending,
+			},
+			{
+				ProfileID:         2,
+				ProfileIdentifier: "com.add.profile.two",
+				HostUUID:          hostUUID2,
+				OperationType:     fleet.MDMAppleOperationTypeInstall,
+				Status:            &fleet.MDMAppleDeliveryPending,
+			},
+			{
+				ProfileID:         3,
+				ProfileIdentifier: "com.remove.profile",
+				HostUUID:          hostUUID,
+				OperationType:     fleet.MDMAppleOperationTypeRemove,
+				Status:            &fleet.MDMAppleDeliveryPending,
+			},
+			{
+				ProfileID:         3,
+				ProfileIdentifier: "com.remove.profile",
+				HostUUID:          hostUUID2,
+				OperationType:     fleet.MDMAppleOperationTypeRemove,
+				Status:            &fleet.MDMAppleDeliveryPending,
+			},
+			{
+				ProfileID:         4,
+				ProfileIdentifier: "com.add.profile.four",
+				HostUUID:          hostUUID2,
+				OperationType:     fleet.MDMAppleOperationTypeInstall,
+				Status:            &fleet.MDMAppleDeliveryPending,
+			},
+		}, copies)
+		return nil
 	}
-}
-
-func TestUpdateMDMAppleSettings(t *testing.T) {
-	svc, ctx, ds := setupAppleMDMService(t)
 
-	ds.TeamFunc = func(ctx context.Context, id uint) (*fleet.Team, error) {
-		return &fleet.Team{ID: id, Name: "team"}, nil
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		appCfg := &fleet.AppConfig{}
+		appCfg.ServerSettings.ServerURL = "https://test.example.com"
+		return appCfg, nil
 	}
-	ds.SaveTeamFunc = func(ctx context.Context, team *fleet.Team) (*fleet.Team, error) {
-		return team, nil
+	ds.ListTeamsMacOSProfileRetryPoliciesFunc = func(ctx context.Context) (map[uint]fleet.MacOSProfileRetryPolicy, error) {
+		return nil, nil
 	}
-	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+
+	ds.BulkUpsertMDMAppleConfigProfilesFunc = func(ctx context.Context, p []*fleet.MDMAppleConfigProfile) error {
 		return nil
 	}
-	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
-		return &fleet.AppConfig{}, nil
-	}
-	ds.SaveAppConfigFunc = func(ctx context.Context, appConfig *fleet.AppConfig) error {
-		return nil
+
+	ds.AggregateEnrollSecretPerTeamFunc = func(ctx context.Context) ([]*fleet.EnrollSecret, error) {
+		return []*fleet.EnrollSecret{}, nil
 	}
 
-	testCases := []struct {
-		name    string
-		user    *fleet.User
-		premium bool
-		teamID  *uint
-		wantErr string
-	}{
-		{
-			"global admin",
-			&fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)},
-			false,
-			nil,
-			ErrMissingLicense.Error(),
-		},
-		{
-			"global admin premium",
-			&fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)},
-			true,
-			nil,
-			"",
-		},
-		{
-			"global admin, team",
-			&fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)},
-			true,
-			ptr.Uint(1),
-			"",
-		},
-		{
-			"global maintainer",
-			&fleet.User{GlobalRole: ptr.String(fleet.RoleMaintainer)},
-			false,
-			nil,
-			ErrMissingLicense.Error(),
-		},
-		{
-			"global maintainer premium",
-			&fleet.User{GlobalRole: ptr.String(fleet.RoleMaintainer)},
-			true,
-			nil,
-			"",
-		},
-		{
-			"global maintainer, team",
-			&fleet.User{GlobalRole: ptr.String(fleet.RoleMaintainer)},
-			true,
-			ptr.Uint(1),
-			"",
-		},
-		{
-			"global observer",
-			&fleet.User{GlobalRole: ptr.String(fleet.RoleObserver)},
-			true,
-			nil,
-			authz.ForbiddenErrorMessage,
-		},
-		{
-			"team admin, DOES belong to team",
-			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleAdmin}}},
-			true,
-			ptr.Uint(1),
-			"",
-		},
-		{
-			"team admin, DOES NOT belong to team",
-			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 2}, Role: fleet.RoleAdmin}}},
-			true,
-			ptr.Uint(1),
-			authz.ForbiddenErrorMessage,
-		},
-		{
-			"team maintainer, DOES belong to team",
-			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleMaintainer}}},
-			true,
-			ptr.Uint(1),
-			"",
-		},
-		{
-			"team maintainer, DOES NOT belong to team",
-			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 2}, Role: fleet.RoleMaintainer}}},
-			true,
-			ptr.Uint(1),
-			authz.ForbiddenErrorMessage,
-		},
-		{
-			"team observer, DOES belong to team",
-			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleObserver}}},
-			true,
-			ptr.Uint(1),
-			authz.ForbiddenErrorMessage,
-		},
-		{
-			"team observer, DOES NOT belong to team",
-			&fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 2}, Role: fleet.RoleObserver}}},
-			true,
-			ptr.Uint(1),
-			authz.ForbiddenErrorMessage,
-		},
-		{
-			"user no roles",
-			&fleet.User{ID: 1337},
-			true,
-			nil,
-			authz.ForbiddenErrorMessage,
-		},
-		{
-			"team id with free license",
-			&fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)},
-			false,
-			ptr.Uint(1),
-			ErrMissingLicense.Error(),
-		},
+	checkAndReset := func(t *testing.T, want bool, invoked *bool) {
+		if want {
+			require.True(t, *invoked)
+		} else {
+			require.False(t, *invoked)
+		}
+		*invoked = false
 	}
 
-	for _, tt := range testCases {
-		t.Run(tt.name, func(t *testing.T) {
-			// prepare the context with the user and license
-			ctx := viewer.NewContext(ctx, viewer.Viewer{User: tt.user})
-			tier := fleet.TierFree
-			if tt.premium {
-				tier = fleet.TierPremium
-			}
-			ctx = license.NewContext(ctx, &fleet.LicenseInfo{Tier: tier})
+	t.Run("success", func(t *testing.T) {
+		var failedCount int
+		failedCall = false
+		failedCheck = func(payload []*fleet.MDMAppleBulkUpsertHostProfilePayload) {
+			failedCount++
+			require.Len(t, payload, 0)
+		}
+		err := ReconcileProfiles(ctx, ds, cmdr, nil, kitlog.NewNopLogger())
+		require.NoError(t, err)
+		require.Equal(t, 1, failedCount)
+		checkAndReset(t, true, &ds.ListMDMAppleProfilesToInstallFuncInvoked)
+		checkAndReset(t, true, &ds.ListMDMAppleProfilesToRemoveFuncInvoked)
+		checkAndReset(t, true, &ds.GetMDMAppleProfilesContentsFuncInvoked)
+		checkAndReset(t, true, &ds.BulkUpsertMDMAppleHostProfilesFuncInvoked)
+	})
+
+	t.Run("fail enqueue remove ops", func(t *testing.T) {
+		var failedCount int
+		failedCall = false
+		failedCheck = func(payload []*fleet.MDMAppleBulkUpsertHostProfilePayload) {
+			failedCount++
+			require.Len(t, payload, 2) // the 2 remove ops
+			require.ElementsMatch(t, []*fleet.MDMAppleBulkUpsertHostProfilePayload{
+				{
+					ProfileID:         3,
+					ProfileIdentifier: "com.remove.profile",
+					HostUUID:          hostUUID,
+					OperationType:     fleet.MDMAppleOperationTypeRemove,
+					Status:            nil,
+					CommandUUID:       "",
+				},
+				{
+					ProfileID:         3,
+					ProfileIdentifier: "com.remove.profile",
+					HostUUID:          hostUUID2,
+					OperationType:     fleet.MDMAppleOperationTypeRemove,
+					Status:            nil,
+					CommandUUID:       "",
+				},
+			}, payload)
+		}
+
+		enqueueFailForOp = fleet.MDMAppleOperationTypeRemove
+		err := ReconcileProfiles(ctx, ds, cmdr, nil, kitlog.NewNopLogger())
+		require.NoError(t, err)
+		require.Equal(t, 1, failedCount)
+		checkAndReset(t, true, &ds.ListMDMAppleProfilesToInstallFuncInvoked)
+		checkAndReset(t, true, &ds.ListMDMAppleProfilesToRemoveFuncInvoked)
+		checkAndReset(t, true, &ds.GetMDMAppleProfilesContentsFuncInvoked)
+		checkAndReset(t, true, &ds.BulkUpsertMDMAppleHostProfilesFuncInvoked)
+	})
+
+	t.Run("fail enqueue install ops", func(t *testing.T) {
+		var failedCount int
+		failedCall = false
+		failedCheck = func(payload []*fleet.MDMAppleBulkUpsertHostProfilePayload) {
+			failedCount++
+
+			require.Len(t, payload, 4) // the 4 install ops
+			require.ElementsMatch(t, []*fleet.MDMAppleBulkUpsertHostProfilePayload{
+				{
+					ProfileID:         1,
+					ProfileIdentifier: "com.add.profile",
+					HostUUID:          hostUUID,
+					OperationType:     fleet.MDMAppleOperationTypeInstall,
+					Status:            nil,
+					CommandUUID:       "",
+				},
+				{
+					ProfileID:         2,
+					ProfileIdentifier: "com.add.profile.two",
+					HostUUID:          hostUUID,
+					OperationType:     fleet.MDMAppleOperationTypeInstall,
+					Status:            nil,
+					CommandUUID:       "",
+				},
+				{
+					ProfileID:         2,
+					ProfileIdentifier: "com.add.profile.two",
+					HostUUID:          hostUUID2,
+					OperationType:     fleet.MDMAppleOperationTypeInstall,
+					Status:            nil,
+					CommandUUID:       "",
+				},
+				{
+					ProfileID:         4,
+					ProfileIdentifier: "com.add.profile.four",
+					HostUUID:          hostUUID2,
+					OperationType:     fleet.MDMAppleOperationTypeInstall,
+					Status:            nil,
+					CommandUUID:       "",
+				},
+			}, payload)
+		}
 
-			err := svc.UpdateMDMAppleSettings(ctx, fleet.MDMAppleSettingsPayload{TeamID: tt.teamID})
-			if tt.wantErr == "" {
-				require.NoError(t, err)
-				return
-			}
-			require.Error(t, err)
-			require.ErrorContains(t, err, tt.wantErr)
-		})
-	}
+		enqueueFailForOp = fleet.MDMAppleOperationTypeInstall
+		err := ReconcileProfiles(ctx, ds, cmdr, nil, kitlog.NewNopLogger())
+		require.NoError(t, err)
+		require.Equal(t, 1, failedCount)
+		checkAndReset(t, true, &ds.ListMDMAppleProfilesToInstallFuncInvoked)
+		checkAndReset(t, true, &ds.ListMDMAppleProfilesToRemoveFuncInvoked)
+		checkAndReset(t, true, &ds.GetMDMAppleProfilesContentsFuncInvoked)
+		checkAndReset(t, true, &ds.BulkUpsertMDMAppleHostProfilesFuncInvoked)
+	})
 }
 
-func TestMDMAppleCommander(t *testing.T) {
+func TestMDMAppleReconcileProfilesHostCustomAttributes(t *testing.T) {
 	ctx := context.Background()
 	mdmStorage := &nanomdm_mock.Storage{}
+	ds := new(mock.Store)
 	pushFactory, _ := newMockAPNSPushProviderFactory()
 	pusher := nanomdm_pushsvc.New(
 		mdmStorage,
 		mdmStorage,
 		pushFactory,
-		NewNanoMDMLogger(kitlog.NewJSONLogger(os.Stdout)),
+		NewNanoMDMLogger(kitlog.NewNopLogger()),
 	)
 	cmdr := apple_mdm.NewMDMAppleCommander(mdmStorage, pusher)
+	hostUUID, hostUUID2 := "ABC-DEF", "GHI-JKL"
+	templated := []byte("<string>$FLEET_VAR_HOST_CUSTOM_ATTRIBUTE_COST_CENTER$FLEET_VAR_HOST_ASSIGNED_USER_EMAIL</string>")
+	static := []byte("<string>static</string>")
 
-	// TODO(roberto): there's a data race in the mock when more
-	// than one host ID is provided because the pusher uses one
-	// goroutine per uuid to send the commands
-	hostUUIDs := []string{"A"}
-	payloadName := "com.foo.bar"
-	payloadIdentifier := "com-foo-bar"
-	mc := mobileconfigForTest(payloadName, payloadIdentifier)
-
-	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
-		require.NotNil(t, cmd)
-		require.Equal(t, cmd.Command.RequestType, "InstallProfile")
-		require.Contains(t, string(cmd.Raw), base64.StdEncoding.EncodeToString(mc))
+	ds.ListMDMAppleProfilesToInstallFunc = func(ctx context.Context) ([]*fleet.MDMAppleProfilePayload, error) {
+		return []*fleet.MDMAppleProfilePayload{
+			{ProfileID: 1, ProfileIdentifier: "com.templated", HostUUID: hostUUID},
+			{ProfileID: 1, ProfileIdentifier: "com.templated", HostUUID: hostUUID2},
+			{ProfileID: 2, ProfileIdentifier: "com.static", HostUUID: hostUUID},
+			{ProfileID: 2, ProfileIdentifier: "com.static", HostUUID: hostUUID2},
+		}, nil
+	}
+	ds.ListMDMAppleProfilesToRemoveFunc = func(ctx context.Context) ([]*fleet.MDMAppleProfilePayload, error) {
+		return nil, nil
+	}
+	ds.ListMDMAppleHostProfilesWithQueuedCommandFunc = func(ctx context.Context, opType fleet.MDMAppleOperationType, hostUUIDs []string) ([]*fleet.MDMAppleProfilePayload, error) {
 		return nil, nil
 	}
+	ds.GetMDMAppleProfilesContentsFunc = func(ctx context.Context, profileIDs []uint) (map[uint]mobileconfig.Mobileconfig, error) {
+		return map[uint]mobileconfig.Mobileconfig{1: templated, 2: static}, nil
+	}
+	ds.ListHostCustomAttributesByHostUUIDsFunc = func(ctx context.Context, hostUUIDs []string) (map[string]map[string]string, error) {
+		require.ElementsMatch(t, []string{hostUUID, hostUUID2}, hostUUIDs)
+		return map[string]map[string]string{hostUUID: {"cost_center": "CC<1>"}}, nil
+	}
+	ds.ListHostAssignedUsersByHostUUIDsFunc = func(ctx context.Context, hostUUIDs []string) (map[string]*fleet.HostAssignedUser, error) {
+		require.ElementsMatch(t, []string{hostUUID, hostUUID2}, hostUUIDs)
+		return map[string]*fleet.HostAssignedUser{hostUUID2: {Email: "jane@example.com"}}, nil
+	}
 
-	mdmStorage.RetrievePushInfoFunc = func(p0 context.Context, targetUUIDs []string) (map[string]*mdm.Push, error) {
-		require.ElementsMatch(t, hostUUIDs, targetUUIDs)
-		pushes := make(map[string]*mdm.Push, len(targetUUIDs))
-		for _, uuid := range targetUUIDs {
-			pushes[uuid] = &mdm.Push{
-				PushMagic: "magic" + uuid,
-				Token:     []byte("token" + uuid),
-				Topic:     "topic" + uuid,
+	var mu sync.Mutex
+	installed := make(map[string][]string) // host UUIDs by profile contents
+	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, contents := range []string{"<string>CC&lt;1&gt;</string>", "<string>jane@example.com</string>", string(static)} {
+			if strings.Contains(string(cmd.Raw), base64.StdEncoding.EncodeToString([]byte(contents))) {
+				installed[contents] = append(installed[contents], id...)
 			}
 		}
-
-		return pushes, nil
+		return nil, nil
+	}
+	mdmStorage.RetrievePushInfoFunc = func(ctx context.Context, tokens []string) (map[string]*mdm.Push, error) {
+		res := make(map[string]*mdm.Push, len(tokens))
+		for _, t := range tokens {
+			res[t] = &mdm.Push{Token: []byte(t)}
+		}
+		return res, nil
 	}
-
 	mdmStorage.RetrievePushCertFunc = func(ctx context.Context, topic string) (*tls.Certificate, string, error) {
 		cert, err := tls.LoadX509KeyPair("testdata/server.pem", "testdata/server.key")
 		return &cert, "", err
@@ -1737,45 +4761,51 @@ func TestMDMAppleCommander(t *testing.T) {
 		return false, nil
 	}
 
-	cmdUUID := uuid.New().String()
-	err := cmdr.InstallProfile(ctx, hostUUIDs, mc, cmdUUID)
-	require.NoError(t, err)
-	require.True(t, mdmStorage.EnqueueCommandFuncInvoked)
-	mdmStorage.EnqueueCommandFuncInvoked = false
-	require.True(t, mdmStorage.RetrievePushInfoFuncInvoked)
-	mdmStorage.RetrievePushInfoFuncInvoked = false
-
-	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
-		require.NotNil(t, cmd)
-		require.Equal(t, "RemoveProfile", cmd.Command.RequestType)
-		require.Contains(t, string(cmd.Raw), payloadIdentifier)
-		return nil, nil
+	var upserted []*fleet.MDMAppleBulkUpsertHostProfilePayload
+	ds.BulkUpsertMDMAppleHostProfilesFunc = func(ctx context.Context, payload []*fleet.MDMAppleBulkUpsertHostProfilePayload) error {
+		if upserted == nil {
+			upserted = payload
+		}
+		return nil
 	}
-	cmdUUID = uuid.New().String()
-	err = cmdr.RemoveProfile(ctx, hostUUIDs, payloadIdentifier, cmdUUID)
-	require.True(t, mdmStorage.EnqueueCommandFuncInvoked)
-	mdmStorage.EnqueueCommandFuncInvoked = false
-	require.True(t, mdmStorage.RetrievePushInfoFuncInvoked)
-	mdmStorage.RetrievePushInfoFuncInvoked = false
-	require.NoError(t, err)
-
-	cmdUUID = uuid.New().String()
-	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
-		require.NotNil(t, cmd)
-		require.Equal(t, "InstallEnterpriseApplication", cmd.Command.RequestType)
-		require.Contains(t, string(cmd.Raw), "http://test.example.com")
-		require.Contains(t, string(cmd.Raw), cmdUUID)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		appCfg := &fleet.AppConfig{}
+		appCfg.ServerSettings.ServerURL = "https://test.example.com"
+		return appCfg, nil
+	}
+	ds.ListTeamsMacOSProfileRetryPoliciesFunc = func(ctx context.Context) (map[uint]fleet.MacOSProfileRetryPolicy, error) {
 		return nil, nil
 	}
-	err = cmdr.InstallEnterpriseApplication(ctx, hostUUIDs, "http://test.example.com", cmdUUID)
+	ds.BulkUpsertMDMAppleConfigProfilesFunc = func(ctx context.Context, p []*fleet.MDMAppleConfigProfile) error {
+		return nil
+	}
+	ds.AggregateEnrollSecretPerTeamFunc = func(ctx context.Context) ([]*fleet.EnrollSecret, error) {
+		return []*fleet.EnrollSecret{}, nil
+	}
+
+	err := ReconcileProfiles(ctx, ds, cmdr, nil, kitlog.NewNopLogger())
 	require.NoError(t, err)
-	require.True(t, mdmStorage.EnqueueCommandFuncInvoked)
-	mdmStorage.EnqueueCommandFuncInvoked = false
-	require.True(t, mdmStorage.RetrievePushInfoFuncInvoked)
-	mdmStorage.RetrievePushInfoFuncInvoked = false
+	require.True(t, ds.ListHostCustomAttributesByHostUUIDsFuncInvoked)
+	require.True(t, ds.ListHostAssignedUsersByHostUUIDsFuncInvoked)
+
+	// the templated profile is installed with the values of each host, the
+	// static one with a single command for both hosts
+	require.Equal(t, []string{hostUUID}, installed["<string>CC&lt;1&gt;</string>"])
+	require.Equal(t, []string{hostUUID2}, installed["<string>jane@example.com</string>"])
+	require.ElementsMatch(t, []string{hostUUID, hostUUID2}, installed[string(static)])
+
+	cmdUUIDs := make(map[uint]map[string]bool)
+	for _, p := range upserted {
+		if cmdUUIDs[p.ProfileID] == nil {
+			cmdUUIDs[p.ProfileID] = make(map[string]bool)
+		}
+		cmdUUIDs[p.ProfileID][p.CommandUUID] = true
+	}
+	require.Len(t, cmdUUIDs[1], 2)
+	require.Len(t, cmdUUIDs[2], 1)
 }
 
-func TestMDMAppleReconcileProfiles(t *testing.T) {
+func TestMDMAppleReconcileProfilesSuppressDuplicates(t *testing.T) {
 	ctx := context.Background()
 	mdmStorage := &nanomdm_mock.Storage{}
 	ds := new(mock.Store)
@@ -1788,79 +4818,216 @@ func TestMDMAppleReconcileProfiles(t *testing.T) {
 	)
 	cmdr := apple_mdm.NewMDMAppleCommander(mdmStorage, pusher)
 	hostUUID, hostUUID2 := "ABC-DEF", "GHI-JKL"
-	contents1 := []byte("test-content-1")
-	contents1Base64 := base64.StdEncoding.EncodeToString(contents1)
-	contents2 := []byte("test-content-2")
-	contents2Base64 := base64.StdEncoding.EncodeToString(contents2)
-	contents4 := []byte("test-content-4")
-	contents4Base64 := base64.StdEncoding.EncodeToString(contents4)
 
 	ds.ListMDMAppleProfilesToInstallFunc = func(ctx context.Context) ([]*fleet.MDMAppleProfilePayload, error) {
 		return []*fleet.MDMAppleProfilePayload{
-			{ProfileID: 1, ProfileIdentifier: "com.add.profile", HostUUID: hostUUID},
-			{ProfileID: 2, ProfileIdentifier: "com.add.profile.two", HostUUID: hostUUID},
-			{ProfileID: 2, ProfileIdentifier: "com.add.profile.two", HostUUID: hostUUID2},
-			{ProfileID: 4, ProfileIdentifier: "com.add.profile.four", HostUUID: hostUUID2},
+			{ProfileID: 1, ProfileIdentifier: "com.add.profile", HostUUID: hostUUID, Checksum: []byte("v2")},
+			{ProfileID: 1, ProfileIdentifier: "com.add.profile", HostUUID: hostUUID2, Checksum: []byte("v2")},
 		}, nil
 	}
-
 	ds.ListMDMAppleProfilesToRemoveFunc = func(ctx context.Context) ([]*fleet.MDMAppleProfilePayload, error) {
 		return []*fleet.MDMAppleProfilePayload{
-			{ProfileID: 3, ProfileIdentifier: "com.remove.profile", HostUUID: hostUUID},
-			{ProfileID: 3, ProfileIdentifier: "com.remove.profile", HostUUID: hostUUID2},
+			{ProfileID: 2, ProfileIdentifier: "com.remove.profile", HostUUID: hostUUID, Checksum: []byte("v1")},
 		}, nil
 	}
-
+	ds.ListMDMAppleHostProfilesWithQueuedCommandFunc = func(ctx context.Context, opType fleet.MDMAppleOperationType, hostUUIDs []string) ([]*fleet.MDMAppleProfilePayload, error) {
+		switch opType {
+		case fleet.MDMAppleOperationTypeInstall:
+			require.ElementsMatch(t, []string{hostUUID, hostUUID2}, hostUUIDs)
+			return []*fleet.MDMAppleProfilePayload{
+				// identical command still queued
+				{ProfileID: 1, HostUUID: hostUUID, Checksum: []byte("v2"), CommandUUID: "queued-install"},
+				// the queued command installs an outdated version
+				{ProfileID: 1, HostUUID: hostUUID2, Checksum: []byte("v1"), CommandUUID: "outdated-install"},
+			}, nil
+		case fleet.MDMAppleOperationTypeRemove:
+			require.Equal(t, []string{hostUUID}, hostUUIDs)
+			return []*fleet.MDMAppleProfilePayload{
+				{ProfileID: 2, HostUUID: hostUUID, Checksum: []byte("v1"), CommandUUID: "queued-remove"},
+			}, nil
+		}
+		return nil, nil
+	}
 	ds.GetMDMAppleProfilesContentsFunc = func(ctx context.Context, profileIDs []uint) (map[uint]mobileconfig.Mobileconfig, error) {
-		require.ElementsMatch(t, []uint{1, 2, 4}, profileIDs)
-		// only those profiles that are to be installed
-		return map[uint]mobileconfig.Mobileconfig{
-			1: contents1,
-			2: contents2,
-			4: contents4,
-		}, nil
+		return map[uint]mobileconfig.Mobileconfig{1: []byte("test-content-1")}, nil
 	}
 
-	var enqueueFailForOp fleet.MDMAppleOperationType
+	var mu sync.Mutex
+	enqueued := make(map[string][]string) // host UUIDs by request type
 	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
-		require.NotNil(t, cmd)
-		require.NotEmpty(t, cmd.CommandUUID)
-
-		switch cmd.Command.RequestType {
-		case "InstallProfile":
-			// may be called for a single host or both
-			if len(id) == 2 {
-				require.ElementsMatch(t, []string{hostUUID, hostUUID2}, id)
-			} else {
-				require.Len(t, id, 1)
-			}
+		mu.Lock()
+		defer mu.Unlock()
+		enqueued[cmd.Command.RequestType] = append(enqueued[cmd.Command.RequestType], id...)
+		return nil, nil
+	}
+	mdmStorage.RetrievePushInfoFunc = func(ctx context.Context, tokens []string) (map[string]*mdm.Push, error) {
+		res := make(map[string]*mdm.Push, len(tokens))
+		for _, t := range tokens {
+			res[t] = &mdm.Push{Token: []byte(t)}
+		}
+		return res, nil
+	}
+	mdmStorage.RetrievePushCertFunc = func(ctx context.Context, topic string) (*tls.Certificate, string, error) {
+		cert, err := tls.LoadX509KeyPair("testdata/server.pem", "testdata/server.key")
+		return &cert, "", err
+	}
+	mdmStorage.IsPushCertStaleFunc = func(ctx context.Context, topic string, staleToken string) (bool, error) {
+		return false, nil
+	}
 
-			if !strings.Contains(string(cmd.Raw), contents1Base64) && !strings.Contains(string(cmd.Raw), contents2Base64) &&
-				!strings.Contains(string(cmd.Raw), contents4Base64) {
-				require.Failf(t, "profile contents don't match", "expected to contain %s, %s or %s but got %s",
-					contents1Base64, contents2Base64, contents4Base64, string(cmd.Raw))
-			}
-		case "RemoveProfile":
-			require.ElementsMatch(t, []string{hostUUID, hostUUID2}, id)
-			require.Contains(t, string(cmd.Raw), "com.remove.profile")
+	var upserted []*fleet.MDMAppleBulkUpsertHostProfilePayload
+	ds.BulkUpsertMDMAppleHostProfilesFunc = func(ctx context.Context, payload []*fleet.MDMAppleBulkUpsertHostProfilePayload) error {
+		if upserted == nil {
+			upserted = payload
 		}
-		switch {
-		case enqueueFailForOp == fleet.MDMAppleOperationTypeInstall && cmd.Command.RequestType == "InstallProfile":
-			return nil, errors.New("enqueue error")
-		case enqueueFailForOp == fleet.MDMAppleOperationTypeRemove && cmd.Command.RequestType == "RemoveProfile":
-			return nil, errors.New("enqueue error")
+		return nil
+	}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		appCfg := &fleet.AppConfig{}
+		appCfg.ServerSettings.ServerURL = "https://test.example.com"
+		return appCfg, nil
+	}
+	ds.ListTeamsMacOSProfileRetryPoliciesFunc = func(ctx context.Context) (map[uint]fleet.MacOSProfileRetryPolicy, error) {
+		return nil, nil
+	}
+	ds.BulkUpsertMDMAppleConfigProfilesFunc = func(ctx context.Context, p []*fleet.MDMAppleConfigProfile) error {
+		return nil
+	}
+	ds.AggregateEnrollSecretPerTeamFunc = func(ctx context.Context) ([]*fleet.EnrollSecret, error) {
+		return []*fleet.EnrollSecret{}, nil
+	}
+
+	before := testutil.ToFloat64(mdmAppleDuplicateCommandsSuppressed.WithLabelValues(string(fleet.MDMAppleOperationTypeInstall)))
+	err := ReconcileProfiles(ctx, ds, cmdr, nil, kitlog.NewNopLogger())
+	require.NoError(t, err)
+
+	// only the host with an outdated queued command gets a new one
+	require.Equal(t, map[string][]string{"InstallProfile": {hostUUID2}}, enqueued)
+	require.Equal(t, before+1, testutil.ToFloat64(mdmAppleDuplicateCommandsSuppressed.WithLabelValues(string(fleet.MDMAppleOperationTypeInstall))))
+
+	// the hosts with an identical queued command keep it
+	cmdUUIDs := make(map[string]string)
+	for _, p := range upserted {
+		require.Equal(t, &fleet.MDMAppleDeliveryPending, p.Status)
+		cmdUUIDs[fmt.Sprintf("%d-%s", p.ProfileID, p.HostUUID)] = p.CommandUUID
+	}
+	require.Len(t, cmdUUIDs, 3)
+	require.Equal(t, "queued-install", cmdUUIDs["1-"+hostUUID])
+	require.Equal(t, "queued-remove", cmdUUIDs["2-"+hostUUID])
+	require.NotEqual(t, "outdated-install", cmdUUIDs["1-"+hostUUID2])
+	require.NotEmpty(t, cmdUUIDs["1-"+hostUUID2])
+}
+
+type mockMDMSecretStore map[string]*secrets.Secret
+
+func (m mockMDMSecretStore) GetSecret(ctx context.Context, name string) (*secrets.Secret, error) {
+	v, ok := m[name]
+	if !ok {
+		return nil, errors.New("secret not found")
+	}
+	return v, nil
+}
+
+func TestMDMAppleRetryFailedProfiles(t *testing.T) {
+	ctx := context.Background()
+	ds := new(mock.Store)
+
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		appCfg := &fleet.AppConfig{}
+		appCfg.MDM.MacOSSettings.ProfileRetryPolicy = fleet.MacOSProfileRetryPolicy{MaxRetries: 3}
+		return appCfg, nil
+	}
+	ds.ListTeamsMacOSProfileRetryPoliciesFunc = func(ctx context.Context) (map[uint]fleet.MacOSProfileRetryPolicy, error) {
+		return map[uint]fleet.MacOSProfileRetryPolicy{
+			1: {MaxRetries: 5},
+			2: {}, // the retries are disabled
+			3: {MaxRetries: 2},
+		}, nil
+	}
+	retried := make(map[uint]int)
+	ds.RetryFailedHostMDMAppleProfilesFunc = func(ctx context.Context, teamID uint, policy fleet.MacOSProfileRetryPolicy) (int64, error) {
+		retried[teamID] = policy.MaxRetries
+		return 1, nil
+	}
+
+	retryFailedProfiles(ctx, ds, kitlog.NewNopLogger())
+	require.Equal(t, map[uint]int{0: 3, 1: 5, 3: 2}, retried)
+
+	// an error for a team doesn't prevent the retries of the other teams
+	retried = make(map[uint]int)
+	ds.RetryFailedHostMDMAppleProfilesFunc = func(ctx context.Context, teamID uint, policy fleet.MacOSProfileRetryPolicy) (int64, error) {
+		if teamID == 1 {
+			return 0, errors.New("boom")
 		}
+		retried[teamID] = policy.MaxRetries
+		return 1, nil
+	}
+	retryFailedProfiles(ctx, ds, kitlog.NewNopLogger())
+	require.Equal(t, map[uint]int{0: 3, 3: 2}, retried)
+
+	// the hosts in no team are retried even if the teams' policies can't be
+	// loaded
+	retried = make(map[uint]int)
+	ds.ListTeamsMacOSProfileRetryPoliciesFunc = func(ctx context.Context) (map[uint]fleet.MacOSProfileRetryPolicy, error) {
+		return nil, errors.New("boom")
+	}
+	retryFailedProfiles(ctx, ds, kitlog.NewNopLogger())
+	require.Equal(t, map[uint]int{0: 3}, retried)
+}
+
+func TestMDMAppleReconcileProfilesSecrets(t *testing.T) {
+	ctx := context.Background()
+	mdmStorage := &nanomdm_mock.Storage{}
+	ds := new(mock.Store)
+	pushFactory, _ := newMockAPNSPushProviderFactory()
+	pusher := nanomdm_pushsvc.New(
+		mdmStorage,
+		mdmStorage,
+		pushFactory,
+		NewNanoMDMLogger(kitlog.NewNopLogger()),
+	)
+	cmdr := apple_mdm.NewMDMAppleCommander(mdmStorage, pusher)
+	hostUUID, hostUUID2 := "ABC-DEF", "GHI-JKL"
+	withSecret := []byte("<string>$FLEET_SECRET_WIFI_PASSWORD</string>")
+	withMissingSecret := []byte("<string>${FLEET_SECRET_MISSING}</string>")
+
+	ds.ListMDMAppleProfilesToInstallFunc = func(ctx context.Context) ([]*fleet.MDMAppleProfilePayload, error) {
+		return []*fleet.MDMAppleProfilePayload{
+			{ProfileID: 1, ProfileIdentifier: "com.secret", ProfileName: "Wi-Fi", HostUUID: hostUUID},
+			{ProfileID: 1, ProfileIdentifier: "com.secret", ProfileName: "Wi-Fi", HostUUID: hostUUID2},
+			{ProfileID: 2, ProfileIdentifier: "com.missing", ProfileName: "Missing", HostUUID: hostUUID},
+		}, nil
+	}
+	ds.ListMDMAppleProfilesToRemoveFunc = func(ctx context.Context) ([]*fleet.MDMAppleProfilePayload, error) {
+		return nil, nil
+	}
+	ds.ListMDMAppleHostProfilesWithQueuedCommandFunc = func(ctx context.Context, opType fleet.MDMAppleOperationType, hostUUIDs []string) ([]*fleet.MDMAppleProfilePayload, error) {
 		return nil, nil
 	}
+	ds.GetMDMAppleProfilesContentsFunc = func(ctx context.Context, profileIDs []uint) (map[uint]mobileconfig.Mobileconfig, error) {
+		return map[uint]mobileconfig.Mobileconfig{1: withSecret, 2: withMissingSecret}, nil
+	}
+	ds.ListMDMAppleProfilesReferencingSecretsFunc = func(ctx context.Context) (map[uint]mobileconfig.Mobileconfig, error) {
+		return map[uint]mobileconfig.Mobileconfig{1: withSecret, 2: withMissingSecret}, nil
+	}
+	secretsChecksums := make(map[uint][]byte)
+	ds.SetMDMAppleProfileSecretsChecksumFunc = func(ctx context.Context, profileID uint, checksum []byte) error {
+		secretsChecksums[profileID] = checksum
+		return nil
+	}
 
+	var mu sync.Mutex
+	var installed [][]byte
+	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		installed = append(installed, cmd.Raw)
+		return nil, nil
+	}
 	mdmStorage.RetrievePushInfoFunc = func(ctx context.Context, tokens []string) (map[string]*mdm.Push, error) {
 		res := make(map[string]*mdm.Push, len(tokens))
 		for _, t := range tokens {
-			res[t] = &mdm.Push{
-				PushMagic: "",
-				Token:     []byte(t),
-				Topic:     "",
-			}
+			res[t] = &mdm.Push{Token: []byte(t)}
 		}
 		return res, nil
 	}
@@ -1872,221 +5039,89 @@ func TestMDMAppleReconcileProfiles(t *testing.T) {
 		return false, nil
 	}
 
-	var failedCall bool
-	var failedCheck func([]*fleet.MDMAppleBulkUpsertHostProfilePayload)
+	var upserted []*fleet.MDMAppleBulkUpsertHostProfilePayload
 	ds.BulkUpsertMDMAppleHostProfilesFunc = func(ctx context.Context, payload []*fleet.MDMAppleBulkUpsertHostProfilePayload) error {
-		if failedCall {
-			failedCheck(payload)
-			return nil
-		}
-
-		// next call will be failed call, until reset
-		failedCall = true
-
-		// first time it is called, it is to set the status to pending and all
-		// host profiles have a command uuid
-		cmdUUIDByProfileIDInstall := make(map[uint]string)
-		cmdUUIDByProfileIDRemove := make(map[uint]string)
-		copies := make([]*fleet.MDMAppleBulkUpsertHostProfilePayload, len(payload))
-		for i, p := range payload {
-			if p.OperationType == fleet.MDMAppleOperationTypeInstall {
-				existing, ok := cmdUUIDByProfileIDInstall[p.ProfileID]
-				if ok {
-					require.Equal(t, existing, p.CommandUUID)
-				} else {
-					cmdUUIDByProfileIDInstall[p.ProfileID] = p.CommandUUID
-				}
-			} else {
-				require.Equal(t, fleet.MDMAppleOperationTypeRemove, p.OperationType)
-				existing, ok := cmdUUIDByProfileIDRemove[p.ProfileID]
-				if ok {
-					require.Equal(t, existing, p.CommandUUID)
-				} else {
-					cmdUUIDByProfileIDRemove[p.ProfileID] = p.CommandUUID
-				}
-			}
-
-			// clear the command UUID (in a copy so that it does not affect the
-			// pointed-to struct) from the payload for the subsequent checks
-			copyp := *p
-			copyp.CommandUUID = ""
-			copies[i] = &copyp
+		if upserted == nil {
+			upserted = payload
 		}
-
-		require.ElementsMatch(t, []*fleet.MDMAppleBulkUpsertHostProfilePayload{
-			{
-				ProfileID:         1,
-				ProfileIdentifier: "com.add.profile",
-				HostUUID:          hostUUID,
-				OperationType:     fleet.MDMAppleOperationTypeInstall,
-				Status:            &fleet.MDMAppleDeliveryPending,
-			},
-			{
-				ProfileID:         2,
-				ProfileIdentifier: "com.add.profile.two",
-				HostUUID:          hostUUID,
-				OperationType:     fleet.MDMAppleOperationTypeInstall,
-				Status:            &fleet.MDMAppleDeliveryPending,
-			},
-			{
-				ProfileID:         2,
-				ProfileIdentifier: "com.add.profile.two",
-				HostUUID:          hostUUID2,
-				OperationType:     fleet.MDMAppleOperationTypeInstall,
-				Status:            &fleet.MDMAppleDeliveryPending,
-			},
-			{
-				ProfileID:         3,
-				ProfileIdentifier: "com.remove.profile",
-				HostUUID:          hostUUID,
-				OperationType:     fleet.MDMAppleOperationTypeRemove,
-				Status:            &fleet.MDMAppleDeliveryPending,
-			},
-			{
-				ProfileID:         3,
-				ProfileIdentifier: "com.remove.profile",
-				HostUUID:          hostUUID2,
-				OperationType:     fleet.MDMAppleOperationTypeRemove,
-				Status:            &fleet.MDMAppleDeliveryPending,
-			},
-			{
-				ProfileID:         4,
-				ProfileIdentifier: "com.add.profile.four",
-				HostUUID:          hostUUID2,
-				OperationType:     fleet.MDMAppleOperationTypeInstall,
-				Status:            &fleet.MDMAppleDeliveryPending,
-			},
-		}, copies)
 		return nil
 	}
-
+	var activities []*fleet.ActivityTypeAccessedMDMSecret
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		require.Nil(t, user)
+		act, ok := activity.(*fleet.ActivityTypeAccessedMDMSecret)
+		require.True(t, ok)
+		activities = append(activities, act)
+		return nil
+	}
 	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
 		appCfg := &fleet.AppConfig{}
 		appCfg.ServerSettings.ServerURL = "https://test.example.com"
 		return appCfg, nil
 	}
-
+	ds.ListTeamsMacOSProfileRetryPoliciesFunc = func(ctx context.Context) (map[uint]fleet.MacOSProfileRetryPolicy, error) {
+		return nil, nil
+	}
 	ds.BulkUpsertMDMAppleConfigProfilesFunc = func(ctx context.Context, p []*fleet.MDMAppleConfigProfile) error {
 		return nil
 	}
-
 	ds.AggregateEnrollSecretPerTeamFunc = func(ctx context.Context) ([]*fleet.EnrollSecret, error) {
 		return []*fleet.EnrollSecret{}, nil
 	}
 
-	checkAndReset := func(t *testing.T, want bool, invoked *bool) {
-		if want {
-			require.True(t, *invoked)
-		} else {
-			require.False(t, *invoked)
-		}
-		*invoked = false
-	}
-
-	t.Run("success", func(t *testing.T) {
-		var failedCount int
-		failedCall = false
-		failedCheck = func(payload []*fleet.MDMAppleBulkUpsertHostProfilePayload) {
-			failedCount++
-			require.Len(t, payload, 0)
-		}
-		err := ReconcileProfiles(ctx, ds, cmdr, kitlog.NewNopLogger())
-		require.NoError(t, err)
-		require.Equal(t, 1, failedCount)
-		checkAndReset(t, true, &ds.ListMDMAppleProfilesToInstallFuncInvoked)
-		checkAndReset(t, true, &ds.ListMDMAppleProfilesToRemoveFuncInvoked)
-		checkAndReset(t, true, &ds.GetMDMAppleProfilesContentsFuncInvoked)
-		checkAndReset(t, true, &ds.BulkUpsertMDMAppleHostProfilesFuncInvoked)
-	})
+	store := mockMDMSecretStore{"WIFI_PASSWORD": {Value: "p<ss", Version: "1"}}
+	err := ReconcileProfiles(ctx, ds, cmdr, store, kitlog.NewNopLogger())
+	require.NoError(t, err)
 
-	t.Run("fail enqueue remove ops", func(t *testing.T) {
-		var failedCount int
-		failedCall = false
-		failedCheck = func(payload []*fleet.MDMAppleBulkUpsertHostProfilePayload) {
-			failedCount++
-			require.Len(t, payload, 2) // the 2 remove ops
-			require.ElementsMatch(t, []*fleet.MDMAppleBulkUpsertHostProfilePayload{
-				{
-					ProfileID:         3,
-					ProfileIdentifier: "com.remove.profile",
-					HostUUID:          hostUUID,
-					OperationType:     fleet.MDMAppleOperationTypeRemove,
-					Status:            nil,
-					CommandUUID:       "",
-				},
-				{
-					ProfileID:         3,
-					ProfileIdentifier: "com.remove.profile",
-					HostUUID:          hostUUID2,
-					OperationType:     fleet.MDMAppleOperationTypeRemove,
-					Status:            nil,
-					CommandUUID:       "",
-				},
-			}, payload)
+	// only the profile with a resolved secret is sent, with the secret value,
+	// in a command that is redacted once processed
+	require.Len(t, installed, 1)
+	require.Contains(t, string(installed[0]), base64.StdEncoding.EncodeToString([]byte("<string>p&lt;ss</string>")))
+	for _, p := range upserted {
+		if p.ProfileID == 1 {
+			require.True(t, strings.HasPrefix(p.CommandUUID, fleet.MDMAppleProfileSecretsCommandPrefix))
 		}
+	}
 
-		enqueueFailForOp = fleet.MDMAppleOperationTypeRemove
-		err := ReconcileProfiles(ctx, ds, cmdr, kitlog.NewNopLogger())
-		require.NoError(t, err)
-		require.Equal(t, 1, failedCount)
-		checkAndReset(t, true, &ds.ListMDMAppleProfilesToInstallFuncInvoked)
-		checkAndReset(t, true, &ds.ListMDMAppleProfilesToRemoveFuncInvoked)
-		checkAndReset(t, true, &ds.GetMDMAppleProfilesContentsFuncInvoked)
-		checkAndReset(t, true, &ds.BulkUpsertMDMAppleHostProfilesFuncInvoked)
-	})
-
-	t.Run("fail enqueue install ops", func(t *testing.T) {
-		var failedCount int
-		failedCall = false
-		failedCheck = func(payload []*fleet.MDMAppleBulkUpsertHostProfilePayload) {
-			failedCount++
-
-			require.Len(t, payload, 4) // the 4 install ops
-			require.ElementsMatch(t, []*fleet.MDMAppleBulkUpsertHostProfilePayload{
-				{
-					ProfileID:         1,
-					ProfileIdentifier: "com.add.profile",
-					HostUUID:          hostUUID,
-					OperationType:     fleet.MDMAppleOperationTypeInstall,
-					Status:            nil,
-					CommandUUID:       "",
-				},
-				{
-					ProfileID:         2,
-					ProfileIdentifier: "com.add.profile.two",
-					HostUUID:          hostUUID,
-					OperationType:     fleet.MDMAppleOperationTypeInstall,
-					Status:            nil,
-					CommandUUID:       "",
-				},
-				{
-					ProfileID:         2,
-					ProfileIdentifier: "com.add.profile.two",
-					HostUUID:          hostUUID2,
-					OperationType:     fleet.MDMAppleOperationTypeInstall,
-					Status:            nil,
-					CommandUUID:       "",
-				},
-				{
-					ProfileID:         4,
-					ProfileIdentifier: "com.add.profile.four",
-					HostUUID:          hostUUID2,
-					OperationType:     fleet.MDMAppleOperationTypeInstall,
-					Status:            nil,
-					CommandUUID:       "",
-				},
-			}, payload)
+	// the checksum of the versions of the secrets is only set for the profile
+	// with resolved secrets
+	require.Len(t, secretsChecksums, 1)
+	require.Len(t, secretsChecksums[1], md5.Size)
+
+	// the access to the secret is recorded
+	require.Equal(t, []*fleet.ActivityTypeAccessedMDMSecret{
+		{SecretName: "WIFI_PASSWORD", ProfileID: 1, ProfileName: "Wi-Fi", HostCount: 2},
+	}, activities)
+
+	// the profile with a missing secret fails without a command
+	var failed []*fleet.MDMAppleBulkUpsertHostProfilePayload
+	for _, p := range upserted {
+		if p.ProfileID == 2 {
+			failed = append(failed, p)
 		}
+	}
+	require.Len(t, failed, 1)
+	require.Equal(t, hostUUID, failed[0].HostUUID)
+	require.Equal(t, &fleet.MDMAppleDeliveryFailed, failed[0].Status)
+	require.Empty(t, failed[0].CommandUUID)
+	require.NotNil(t, failed[0].Detail)
+	require.Contains(t, *failed[0].Detail, "MISSING")
+
+	// the checksum changes when the secret is rotated, not when it is read
+	// again
+	prevChecksum := secretsChecksums[1]
+	installed, upserted = nil, nil
+	err = ReconcileProfiles(ctx, ds, cmdr, store, kitlog.NewNopLogger())
+	require.NoError(t, err)
+	require.Equal(t, prevChecksum, secretsChecksums[1])
 
-		enqueueFailForOp = fleet.MDMAppleOperationTypeInstall
-		err := ReconcileProfiles(ctx, ds, cmdr, kitlog.NewNopLogger())
-		require.NoError(t, err)
-		require.Equal(t, 1, failedCount)
-		checkAndReset(t, true, &ds.ListMDMAppleProfilesToInstallFuncInvoked)
-		checkAndReset(t, true, &ds.ListMDMAppleProfilesToRemoveFuncInvoked)
-		checkAndReset(t, true, &ds.GetMDMAppleProfilesContentsFuncInvoked)
-		checkAndReset(t, true, &ds.BulkUpsertMDMAppleHostProfilesFuncInvoked)
-	})
+	store["WIFI_PASSWORD"] = &secrets.Secret{Value: "rotated", Version: "2"}
+	installed, upserted = nil, nil
+	err = ReconcileProfiles(ctx, ds, cmdr, store, kitlog.NewNopLogger())
+	require.NoError(t, err)
+	require.NotEqual(t, prevChecksum, secretsChecksums[1])
+	require.Len(t, installed, 1)
+	require.Contains(t, string(installed[0]), base64.StdEncoding.EncodeToString([]byte("<string>rotated</string>")))
 }
 
 func TestAppleMDMFileVaultEscrowFunctions(t *testing.T) {
@@ -2101,9 +5136,21 @@ func TestAppleMDMFileVaultEscrowFunctions(t *testing.T) {
 
 func TestGenerateEnrollmentProfileMobileConfig(t *testing.T) {
 	// SCEP challenge should be escaped for XML
-	b, err := apple_mdm.GenerateEnrollmentProfileMobileconfig("foo", "https://example.com", "foo&bar", "topic")
+	b, err := apple_mdm.GenerateEnrollmentProfileMobileconfig("foo", "https://example.com", "foo&bar", "topic", fleet.MDMEndUserStrings{})
 	require.NoError(t, err)
 	require.Contains(t, string(b), "foo&amp;bar")
+	require.Contains(t, string(b), "<string>foo enrollment</string>")
+	require.NotContains(t, string(b), "PayloadDescription")
+
+	// the localized display name and description are escaped for XML
+	b, err = apple_mdm.GenerateEnrollmentProfileMobileconfig("foo", "https://example.com", "challenge", "topic", fleet.MDMEndUserStrings{
+		EnrollmentProfileDisplayName: "Inscription de foo",
+		EnrollmentProfileDescription: "Gestion des appareils & sécurité",
+	})
+	require.NoError(t, err)
+	require.Contains(t, string(b), "<string>Inscription de foo</string>")
+	require.Contains(t, string(b), "<string>Gestion des appareils &amp; sécurité</string>")
+	require.NotContains(t, string(b), "foo enrollment")
 }
 
 func TestEnsureFleetdConfig(t *testing.T) {
@@ -2144,27 +5191,59 @@ func TestEnsureFleetdConfig(t *testing.T) {
 		ds.AggregateEnrollSecretPerTeamFunc = func(ctx context.Context) ([]*fleet.EnrollSecret, error) {
 			return secrets, nil
 		}
-		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
-			return &fleet.AppConfig{}, nil
-		}
+		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+			return &fleet.AppConfig{}, nil
+		}
+		ds.BulkUpsertMDMAppleConfigProfilesFunc = func(ctx context.Context, ps []*fleet.MDMAppleConfigProfile) error {
+			require.Empty(t, ps)
+			return nil
+		}
+		err := ensureFleetdConfig(ctx, ds, logger)
+		require.NoError(t, err)
+		require.True(t, ds.BulkUpsertMDMAppleConfigProfilesFuncInvoked)
+		require.True(t, ds.AggregateEnrollSecretPerTeamFuncInvoked)
+		require.True(t, ds.AppConfigFuncInvoked)
+	})
+
+	t.Run("uses the enroll secret of each team if available", func(t *testing.T) {
+		ctx := context.Background()
+		ds := new(mock.Store)
+		secrets := []*fleet.EnrollSecret{
+			{Secret: "global", TeamID: nil},
+			{Secret: "team-1", TeamID: ptr.Uint(1)},
+			{Secret: "team-2", TeamID: ptr.Uint(2)},
+		}
+		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+			appCfg := &fleet.AppConfig{}
+			appCfg.ServerSettings.ServerURL = testURL
+			appCfg.MDM.AppleBMDefaultTeam = testTeamName
+			return appCfg, nil
+		}
+		ds.AggregateEnrollSecretPerTeamFunc = func(ctx context.Context) ([]*fleet.EnrollSecret, error) {
+			return secrets, nil
+		}
 		ds.BulkUpsertMDMAppleConfigProfilesFunc = func(ctx context.Context, ps []*fleet.MDMAppleConfigProfile) error {
-			require.Empty(t, ps)
+			require.Len(t, ps, len(secrets))
+			for i, p := range ps {
+				require.Contains(t, string(p.Mobileconfig), testURL)
+				require.Contains(t, string(p.Mobileconfig), secrets[i].Secret)
+				require.Equal(t, mobileconfig.FleetdConfigPayloadIdentifier, p.Identifier)
+			}
 			return nil
 		}
+
 		err := ensureFleetdConfig(ctx, ds, logger)
 		require.NoError(t, err)
-		require.True(t, ds.BulkUpsertMDMAppleConfigProfilesFuncInvoked)
 		require.True(t, ds.AggregateEnrollSecretPerTeamFuncInvoked)
-		require.True(t, ds.AppConfigFuncInvoked)
+		require.True(t, ds.BulkUpsertMDMAppleConfigProfilesFuncInvoked)
 	})
 
-	t.Run("uses the enroll secret of each team if available", func(t *testing.T) {
+	t.Run("if the team doesn't have an enroll secret, fallback to no team", func(t *testing.T) {
 		ctx := context.Background()
 		ds := new(mock.Store)
 		secrets := []*fleet.EnrollSecret{
 			{Secret: "global", TeamID: nil},
-			{Secret: "team-1", TeamID: ptr.Uint(1)},
-			{Secret: "team-2", TeamID: ptr.Uint(2)},
+			{Secret: "", TeamID: ptr.Uint(1)},
 		}
 		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
 			appCfg := &fleet.AppConfig{}
@@ -2184,90 +5263,816 @@ func TestEnsureFleetdConfig(t *testing.T) {
 			}
 			return nil
 		}
-
 		err := ensureFleetdConfig(ctx, ds, logger)
 		require.NoError(t, err)
+		require.True(t, ds.AppConfigFuncInvoked)
+		require.True(t, ds.AggregateEnrollSecretPerTeamFuncInvoked)
+		require.True(t, ds.BulkUpsertMDMAppleConfigProfilesFuncInvoked)
+	})
+
+	t.Run("returns an error if there's a problem retrieving AppConfig", func(t *testing.T) {
+		ctx := context.Background()
+		ds := new(mock.Store)
+		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+			return nil, testError
+		}
+		err := ensureFleetdConfig(ctx, ds, logger)
+		require.ErrorIs(t, err, testError)
+	})
+
+	t.Run("returns an error if there's a problem retrieving secrets", func(t *testing.T) {
+		ctx := context.Background()
+		ds := new(mock.Store)
+		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+			return &fleet.AppConfig{}, nil
+		}
+		ds.AggregateEnrollSecretPerTeamFunc = func(ctx context.Context) ([]*fleet.EnrollSecret, error) {
+			return nil, testError
+		}
+		err := ensureFleetdConfig(ctx, ds, logger)
+		require.ErrorIs(t, err, testError)
+	})
+
+	t.Run("returns an error if there's a problem upserting profiles", func(t *testing.T) {
+		ctx := context.Background()
+		ds := new(mock.Store)
+		secrets := []*fleet.EnrollSecret{
+			{Secret: "global", TeamID: nil},
+			{Secret: "team-1", TeamID: ptr.Uint(1)},
+		}
+		ds.AggregateEnrollSecretPerTeamFunc = func(ctx context.Context) ([]*fleet.EnrollSecret, error) {
+			return secrets, nil
+		}
+		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+			return &fleet.AppConfig{}, nil
+		}
+		ds.BulkUpsertMDMAppleConfigProfilesFunc = func(ctx context.Context, p []*fleet.MDMAppleConfigProfile) error {
+			return testError
+		}
+		err := ensureFleetdConfig(ctx, ds, logger)
+		require.ErrorIs(t, err, testError)
+		require.True(t, ds.AppConfigFuncInvoked)
 		require.True(t, ds.AggregateEnrollSecretPerTeamFuncInvoked)
 		require.True(t, ds.BulkUpsertMDMAppleConfigProfilesFuncInvoked)
 	})
+}
+
+func TestMDMBatchSetAppleProfilesExclusions(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}})
+
+	ds.BatchSetMDMAppleProfilesFunc = func(ctx context.Context, teamID *uint, profiles []*fleet.MDMAppleConfigProfile) error {
+		return nil
+	}
+	var gotExclusions []*fleet.MDMAppleProfileExclusion
+	ds.BatchSetMDMAppleProfileExclusionsFunc = func(ctx context.Context, teamID *uint, exclusions []*fleet.MDMAppleProfileExclusion) error {
+		gotExclusions = exclusions
+		return nil
+	}
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		return nil
+	}
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
+		return nil
+	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		return 0, nil
+	}
+	ds.ListMDMAppleProfileIdentifierConflictsFunc = func(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+		return nil, nil
+	}
+	ds.ListMDMAppleConfigProfilesFunc = func(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleConfigProfile, error) {
+		return nil, nil
+	}
+	ds.LabelIDsByNameFunc = func(ctx context.Context, names []string) ([]uint, error) {
+		if names[0] == "kiosks" {
+			return []uint{7}, nil
+		}
+		return nil, nil
+	}
+	ds.HostByIdentifierFunc = func(ctx context.Context, identifier string) (*fleet.Host, error) {
+		if identifier == "ABC123" {
+			return &fleet.Host{ID: 3}, nil
+		}
+		return nil, newNotFoundError()
+	}
+
+	profiles := [][]byte{mobileconfigForTest("N1", "I1"), mobileconfigForTest("N2", "I2")}
+
+	cases := []struct {
+		desc       string
+		exclusions []fleet.MDMAppleProfileExclusionSpec
+		wantErr    string
+	}{
+		{"unknown profile", []fleet.MDMAppleProfileExclusionSpec{{ProfileIdentifier: "I3", Labels: []string{"kiosks"}}}, `The excluded profile is not in custom_settings: "I3"`},
+		{"unknown label", []fleet.MDMAppleProfileExclusionSpec{{ProfileIdentifier: "I1", Labels: []string{"nope"}}}, `Label doesn’t exist: "nope"`},
+		{"unknown host", []fleet.MDMAppleProfileExclusionSpec{{ProfileIdentifier: "I1", Hosts: []string{"nope"}}}, `Host doesn’t exist: "nope"`},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			ds.BatchSetMDMAppleProfilesFuncInvoked = false
+			_, err := svc.BatchSetMDMAppleProfiles(ctx, nil, nil, profiles, c.exclusions, false, false, false)
+			require.ErrorContains(t, err, c.wantErr)
+			require.False(t, ds.BatchSetMDMAppleProfilesFuncInvoked)
+		})
+	}
+
+	_, err := svc.BatchSetMDMAppleProfiles(ctx, nil, nil, profiles, []fleet.MDMAppleProfileExclusionSpec{
+		{ProfileIdentifier: "I1", Labels: []string{"kiosks"}, Hosts: []string{"ABC123"}},
+	}, false, false, false)
+	require.NoError(t, err)
+	require.Equal(t, []*fleet.MDMAppleProfileExclusion{
+		{ProfileIdentifier: "I1", LabelID: ptr.Uint(7)},
+		{ProfileIdentifier: "I1", HostID: ptr.Uint(3)},
+	}, gotExclusions)
+
+	// in dry run mode the exclusions are validated but not saved
+	gotExclusions = nil
+	ds.BatchSetMDMAppleProfileExclusionsFuncInvoked = false
+	_, err = svc.BatchSetMDMAppleProfiles(ctx, nil, nil, profiles, []fleet.MDMAppleProfileExclusionSpec{
+		{ProfileIdentifier: "I2", Labels: []string{"nope"}},
+	}, true, false, false)
+	require.ErrorContains(t, err, `Label doesn’t exist: "nope"`)
+	_, err = svc.BatchSetMDMAppleProfiles(ctx, nil, nil, profiles, []fleet.MDMAppleProfileExclusionSpec{
+		{ProfileIdentifier: "I2", Labels: []string{"kiosks"}},
+	}, true, false, false)
+	require.NoError(t, err)
+	require.False(t, ds.BatchSetMDMAppleProfileExclusionsFuncInvoked)
+}
+
+func TestMDMBatchSetAppleAllTeamsProfiles(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	mdmEnabled := true
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{MDM: fleet.MDM{EnabledAndConfigured: mdmEnabled}}, nil
+	}
+	var gotTeamID *uint
+	var gotProfiles []*fleet.MDMAppleConfigProfile
+	ds.BatchSetMDMAppleProfilesFunc = func(ctx context.Context, teamID *uint, profiles []*fleet.MDMAppleConfigProfile) error {
+		gotTeamID = teamID
+		gotProfiles = profiles
+		return nil
+	}
+	ds.BatchSetMDMAppleProfileExclusionsFunc = func(ctx context.Context, teamID *uint, exclusions []*fleet.MDMAppleProfileExclusion) error {
+		return nil
+	}
+	var gotActivity *fleet.ActivityTypeEditedMacosProfile
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		act, ok := activity.(*fleet.ActivityTypeEditedMacosProfile)
+		require.True(t, ok)
+		gotActivity = act
+		return nil
+	}
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
+		return nil
+	}
+	var gotBulkTeamIDs []uint
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		gotBulkTeamIDs = tids
+		return 0, nil
+	}
+	ds.ListMDMAppleConfigProfilesFunc = func(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleConfigProfile, error) {
+		return nil, nil
+	}
+
+	profiles := [][]byte{mobileconfigForTest("N1", "I1"), mobileconfigForTest("N2", "I2")}
+
+	// only global admins and maintainers can set the all teams profiles
+	for _, u := range []*fleet.User{test.UserObserver, test.UserTeamAdminTeam1, test.UserTeamMaintainerTeam1} {
+		uctx := viewer.NewContext(ctx, viewer.Viewer{User: u})
+		_, err := svc.BatchSetMDMAppleAllTeamsProfiles(uctx, profiles, nil, false, false)
+		checkAuthErr(t, true, err)
+	}
+
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: test.UserAdmin})
+
+	// requires a premium license
+	_, err := svc.BatchSetMDMAppleAllTeamsProfiles(license.NewContext(ctx, &fleet.LicenseInfo{Tier: fleet.TierFree}), profiles, nil, false, false)
+	require.ErrorContains(t, err, ErrMissingLicense.Error())
+
+	// duplicate identifiers are rejected
+	_, err = svc.BatchSetMDMAppleAllTeamsProfiles(ctx, [][]byte{mobileconfigForTest("N1", "I1"), mobileconfigForTest("N2", "I1")}, nil, false, false)
+	require.ErrorContains(t, err, "More than one configuration profile have the same identifier")
+	require.False(t, ds.BatchSetMDMAppleProfilesFuncInvoked)
+
+	// dry run does not save anything
+	_, err = svc.BatchSetMDMAppleAllTeamsProfiles(ctx, profiles, nil, true, false)
+	require.NoError(t, err)
+	require.False(t, ds.BatchSetMDMAppleProfilesFuncInvoked)
+
+	_, err = svc.BatchSetMDMAppleAllTeamsProfiles(ctx, profiles, nil, false, false)
+	require.NoError(t, err)
+	require.NotNil(t, gotTeamID)
+	require.Equal(t, fleet.MDMAppleAllTeamsProfilesTeamID, *gotTeamID)
+	require.Len(t, gotProfiles, 2)
+	require.Equal(t, []uint{fleet.MDMAppleAllTeamsProfilesTeamID}, gotBulkTeamIDs)
+	require.NotNil(t, gotActivity)
+	require.True(t, gotActivity.AllTeams)
+	require.Nil(t, gotActivity.TeamID)
+	require.Len(t, gotActivity.AddedProfiles, 2)
+
+	// when MDM is not enabled, clearing the profiles is a no-op but setting
+	// some is an error
+	mdmEnabled = false
+	ds.BatchSetMDMAppleProfilesFuncInvoked = false
+	_, err = svc.BatchSetMDMAppleAllTeamsProfiles(ctx, nil, nil, false, false)
+	require.NoError(t, err)
+	_, err = svc.BatchSetMDMAppleAllTeamsProfiles(ctx, profiles, nil, false, false)
+	require.ErrorContains(t, err, "Fleet MDM is not configured")
+	require.False(t, ds.BatchSetMDMAppleProfilesFuncInvoked)
+}
+
+func TestMDMBatchSetAppleTeamsProfiles(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+	ctx = license.NewContext(ctx, &fleet.LicenseInfo{Tier: fleet.TierPremium})
+
+	teamIDs := map[string]uint{"team1": 1, "team2": 2}
+	ds.TeamByNameFunc = func(ctx context.Context, name string) (*fleet.Team, error) {
+		id, ok := teamIDs[name]
+		if !ok {
+			return nil, newNotFoundError()
+		}
+		return &fleet.Team{ID: id, Name: name}, nil
+	}
+	var gotBatches []*fleet.MDMAppleTeamProfiles
+	ds.BatchSetMDMAppleTeamsProfilesFunc = func(ctx context.Context, teams []*fleet.MDMAppleTeamProfiles) error {
+		gotBatches = teams
+		return nil
+	}
+	var gotActivities []*fleet.ActivityTypeEditedMacosProfile
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		act, ok := activity.(*fleet.ActivityTypeEditedMacosProfile)
+		require.True(t, ok)
+		gotActivities = append(gotActivities, act)
+		return nil
+	}
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
+		return nil
+	}
+	var bulkCalls int
+	var gotBulkTeamIDs []uint
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		bulkCalls++
+		gotBulkTeamIDs = tids
+		return 0, nil
+	}
+	ds.ListMDMAppleProfileIdentifierConflictsFunc = func(ctx context.Context, teamID *uint, identifiers []string) ([]*fleet.MDMAppleProfileIdentifierConflict, error) {
+		return nil, nil
+	}
+	ds.ListMDMAppleConfigProfilesFunc = func(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleConfigProfile, error) {
+		return nil, nil
+	}
+
+	teams := map[string]fleet.MDMAppleTeamProfilesSpec{
+		"team2": {Profiles: [][]byte{mobileconfigForTest("N1", "I1")}},
+		"team1": {Profiles: [][]byte{mobileconfigForTest("N1", "I1"), mobileconfigForTest("N2", "I2")}},
+		"":      {},
+	}
+
+	// a team admin can't change the profiles of other teams
+	uctx := viewer.NewContext(ctx, viewer.Viewer{User: test.UserTeamAdminTeam1})
+	_, err := svc.BatchSetMDMAppleTeamsProfiles(uctx, teams, false, false, false)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	require.False(t, ds.BatchSetMDMAppleTeamsProfilesFuncInvoked)
+
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: test.UserAdmin})
+
+	_, err = svc.BatchSetMDMAppleTeamsProfiles(ctx, nil, false, false, false)
+	require.ErrorContains(t, err, "at least one team must be provided")
+
+	// an invalid profile of any team fails the whole batch, the error
+	// identifies the team
+	_, err = svc.BatchSetMDMAppleTeamsProfiles(ctx, map[string]fleet.MDMAppleTeamProfilesSpec{
+		"team1": teams["team1"],
+		"team2": {Profiles: [][]byte{mobileconfigForTest("N1", "I1"), mobileconfigForTest("N2", "I1")}},
+	}, false, false, false)
+	var invalid *fleet.InvalidArgumentError
+	require.ErrorAs(t, err, &invalid)
+	require.Equal(t, `teams["team2"].profiles[1]`, invalid.Invalid()[0]["name"])
+	require.False(t, ds.BatchSetMDMAppleTeamsProfilesFuncInvoked)
+
+	// unknown teams fail the whole batch too
+	_, err = svc.BatchSetMDMAppleTeamsProfiles(ctx, map[string]fleet.MDMAppleTeamProfilesSpec{"team1": teams["team1"], "nope": {}}, false, false, false)
+	require.True(t, fleet.IsNotFound(err))
+	require.False(t, ds.BatchSetMDMAppleTeamsProfilesFuncInvoked)
+
+	// dry run does not save anything
+	_, err = svc.BatchSetMDMAppleTeamsProfiles(ctx, teams, true, false, false)
+	require.NoError(t, err)
+	require.False(t, ds.BatchSetMDMAppleTeamsProfilesFuncInvoked)
+	require.Zero(t, bulkCalls)
+
+	_, err = svc.BatchSetMDMAppleTeamsProfiles(ctx, teams, false, false, false)
+	require.NoError(t, err)
+	require.Len(t, gotBatches, 3)
+	require.Nil(t, gotBatches[0].TeamID)
+	require.Empty(t, gotBatches[0].Profiles)
+	require.Equal(t, ptr.Uint(1), gotBatches[1].TeamID)
+	require.Len(t, gotBatches[1].Profiles, 2)
+	require.Equal(t, ptr.Uint(2), gotBatches[2].TeamID)
+	require.Len(t, gotBatches[2].Profiles, 1)
+	require.Equal(t, uint(2), *gotBatches[2].Profiles[0].TeamID)
+
+	// the hosts of all the teams are reconciled at once
+	require.Equal(t, 1, bulkCalls)
+	require.Equal(t, []uint{0, 1, 2}, gotBulkTeamIDs)
+
+	require.Len(t, gotActivities, 3)
+	require.Nil(t, gotActivities[0].TeamName)
+	require.Equal(t, "team1", *gotActivities[1].TeamName)
+	require.Len(t, gotActivities[1].AddedProfiles, 2)
+	require.Equal(t, "team2", *gotActivities[2].TeamName)
+}
+
+func TestMDMAppleApproveEnrollment(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
+		return &fleet.Host{ID: id, UUID: "host-uuid", TeamID: ptr.Uint(1)}, nil
+	}
+	pending := true
+	ds.ApproveMDMAppleEnrollmentFunc = func(ctx context.Context, hostUUID string) error {
+		require.Equal(t, "host-uuid", hostUUID)
+		if !pending {
+			return newNotFoundError()
+		}
+		pending = false
+		return nil
+	}
+	var gotUUIDs []string
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
+		gotUUIDs = uuids
+		return nil
+	}
+	ds.GetHostMDMCheckinInfoFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		return &fleet.HostMDMCheckinInfo{HardwareSerial: "ABC", DisplayName: "Mac (ABC)"}, nil
+	}
+	var gotActivity *fleet.ActivityTypeApprovedMDMEnrollment
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		act, ok := activity.(*fleet.ActivityTypeApprovedMDMEnrollment)
+		require.True(t, ok)
+		gotActivity = act
+		return nil
+	}
+
+	// only users that can run commands on the host's team can approve it
+	for _, u := range []*fleet.User{test.UserObserver, test.UserTeamObserverTeam1, test.UserTeamAdminTeam2} {
+		err := svc.ApproveMDMAppleEnrollment(test.UserContext(ctx, u), 42)
+		checkAuthErr(t, true, err)
+	}
+	require.False(t, ds.ApproveMDMAppleEnrollmentFuncInvoked)
+
+	err := svc.ApproveMDMAppleEnrollment(test.UserContext(ctx, test.UserTeamMaintainerTeam1), 42)
+	require.NoError(t, err)
+	require.Equal(t, []string{"host-uuid"}, gotUUIDs)
+	require.Equal(t, &fleet.ActivityTypeApprovedMDMEnrollment{HostID: 42, HostSerial: "ABC", HostDisplayName: "Mac (ABC)"}, gotActivity)
+
+	// the host is not pending approval anymore
+	err = svc.ApproveMDMAppleEnrollment(test.UserContext(ctx, test.UserAdmin), 42)
+	require.True(t, fleet.IsNotFound(err))
+}
+
+func TestMDMAppleReviewProfileChange(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	pending := true
+	ds.ReviewMDMAppleProfileChangeFunc = func(ctx context.Context, id uint, status fleet.MDMAppleProfileChangeStatus) (*fleet.MDMAppleProfileChange, error) {
+		if !pending {
+			return nil, newNotFoundError()
+		}
+		pending = false
+		return &fleet.MDMAppleProfileChange{
+			ID:                id,
+			ProfileID:         2,
+			ProfileName:       "Profile",
+			ProfileIdentifier: "com.example",
+			OperationType:     fleet.MDMAppleOperationTypeInstall,
+			HostCount:         600,
+			Status:            status,
+		}, nil
+	}
+	ds.ListMDMAppleProfileChangesFunc = func(ctx context.Context, status fleet.MDMAppleProfileChangeStatus) ([]*fleet.MDMAppleProfileChange, error) {
+		return nil, nil
+	}
+	var gotActivity fleet.ActivityDetails
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		gotActivity = activity
+		return nil
+	}
+
+	// the changes are global, only global users can list them and global
+	// admins and maintainers can review them
+	for _, u := range []*fleet.User{test.UserTeamAdminTeam1, test.UserTeamMaintainerTeam1} {
+		_, err := svc.ListMDMAppleProfileChanges(test.UserContext(ctx, u), "")
+		require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	}
+	for _, u := range []*fleet.User{test.UserObserver, test.UserTeamAdminTeam1} {
+		err := svc.ReviewMDMAppleProfileChange(test.UserContext(ctx, u), 1, true)
+		require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	}
+	require.False(t, ds.ReviewMDMAppleProfileChangeFuncInvoked)
+
+	_, err := svc.ListMDMAppleProfileChanges(test.UserContext(ctx, test.UserObserver), fleet.MDMAppleProfileChangePending)
+	require.NoError(t, err)
+	_, err = svc.ListMDMAppleProfileChanges(test.UserContext(ctx, test.UserObserver), "nope")
+	require.ErrorContains(t, err, "invalid status")
+
+	err = svc.ReviewMDMAppleProfileChange(test.UserContext(ctx, test.UserMaintainer), 1, true)
+	require.NoError(t, err)
+	require.Equal(t, &fleet.ActivityTypeApprovedMDMProfileChange{
+		ChangeID:          1,
+		ProfileID:         2,
+		ProfileName:       "Profile",
+		ProfileIdentifier: "com.example",
+		OperationType:     fleet.MDMAppleOperationTypeInstall,
+		HostCount:         600,
+	}, gotActivity)
+
+	// the change is not pending anymore
+	err = svc.ReviewMDMAppleProfileChange(test.UserContext(ctx, test.UserAdmin), 1, false)
+	require.True(t, fleet.IsNotFound(err))
+
+	pending = true
+	err = svc.ReviewMDMAppleProfileChange(test.UserContext(ctx, test.UserAdmin), 1, false)
+	require.NoError(t, err)
+	require.IsType(t, &fleet.ActivityTypeRejectedMDMProfileChange{}, gotActivity)
+}
+
+func TestHoldUnapprovedProfileChanges(t *testing.T) {
+	ctx := context.Background()
+	ds := new(mock.Store)
+
+	approvalCfg := fleet.MDMProfileChangeApproval{Enable: true, HostThreshold: 2}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{MDM: fleet.MDM{ProfileChangeApproval: approvalCfg}}, nil
+	}
+	ds.ExpireMDMAppleProfileChangesFunc = func(ctx context.Context) error {
+		return nil
+	}
+	approved := make(map[uint]bool)
+	var requested []*fleet.MDMAppleProfileChange
+	var gotExpiresAt time.Time
+	ds.RequestMDMAppleProfileChangesFunc = func(ctx context.Context, changes []*fleet.MDMAppleProfileChange, expiresAt time.Time) ([]*fleet.MDMAppleProfileChange, error) {
+		requested = changes
+		gotExpiresAt = expiresAt
+		var created []*fleet.MDMAppleProfileChange
+		for _, c := range changes {
+			c.ID = c.ProfileID
+			c.Status = fleet.MDMAppleProfileChangePending
+			if approved[c.ProfileID] {
+				c.Status = fleet.MDMAppleProfileChangeApproved
+			} else {
+				created = append(created, c)
+			}
+		}
+		return created, nil
+	}
+	var activities []fleet.ActivityDetails
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		activities = append(activities, activity)
+		return nil
+	}
+
+	payloads := func(profileID uint, n int) []*fleet.MDMAppleProfilePayload {
+		var res []*fleet.MDMAppleProfilePayload
+		for i := 0; i < n; i++ {
+			res = append(res, &fleet.MDMAppleProfilePayload{ProfileID: profileID, HostUUID: fmt.Sprintf("host%d", i), Checksum: []byte("a")})
+		}
+		return res
+	}
+	hold := func() ([]*fleet.MDMAppleProfilePayload, []*fleet.MDMAppleProfilePayload) {
+		toInstall := append(payloads(1, 2), payloads(2, 3)...)
+		toRemove := payloads(3, 3)
+		toInstall, toRemove, err := holdUnapprovedProfileChanges(ctx, ds, kitlog.NewNopLogger(), toInstall, toRemove)
+		require.NoError(t, err)
+		return toInstall, toRemove
+	}
+
+	// profile 1 is below the threshold, the others must be approved
+	toInstall, toRemove := hold()
+	require.Len(t, toInstall, 2)
+	require.Equal(t, uint(1), toInstall[0].ProfileID)
+	require.Empty(t, toRemove)
+	require.Len(t, requested, 2)
+	require.Equal(t, uint(2), requested[0].ProfileID)
+	require.Equal(t, fleet.MDMAppleOperationTypeInstall, requested[0].OperationType)
+	require.Equal(t, 3, requested[0].HostCount)
+	require.Equal(t, uint(3), requested[1].ProfileID)
+	require.Equal(t, fleet.MDMAppleOperationTypeRemove, requested[1].OperationType)
+	require.WithinDuration(t, time.Now().Add(fleet.DefaultMDMProfileChangeApprovalExpiry), gotExpiresAt, time.Minute)
+	require.Len(t, activities, 2)
+	require.Equal(t, &fleet.ActivityTypeMDMProfileChangePendingApproval{
+		ChangeID:      2,
+		ProfileID:     2,
+		OperationType: fleet.MDMAppleOperationTypeInstall,
+		HostCount:     3,
+	}, activities[0])
+
+	// once approved, the commands of the change are sent
+	activities = nil
+	approved[3] = true
+	toInstall, toRemove = hold()
+	require.Len(t, toInstall, 2)
+	require.Len(t, toRemove, 3)
+	require.Len(t, activities, 1)
+
+	// nothing is held when the approval is disabled
+	requested = nil
+	approvalCfg.Enable = false
+	toInstall, toRemove = hold()
+	require.Len(t, toInstall, 5)
+	require.Len(t, toRemove, 3)
+	require.Nil(t, requested)
+}
+
+func TestMDMApplePurgeHostData(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
+		return &fleet.Host{ID: id, UUID: "host-uuid", TeamID: ptr.Uint(1)}, nil
+	}
+	ds.PurgeHostMDMAppleDataFunc = func(ctx context.Context, hostID uint, hostUUID string) error {
+		require.Equal(t, uint(42), hostID)
+		require.Equal(t, "host-uuid", hostUUID)
+		return nil
+	}
+	ds.GetHostMDMCheckinInfoFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMCheckinInfo, error) {
+		return &fleet.HostMDMCheckinInfo{HardwareSerial: "ABC", DisplayName: "Mac (ABC)"}, nil
+	}
+	var gotActivity *fleet.ActivityTypePurgedHostMDMData
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		act, ok := activity.(*fleet.ActivityTypePurgedHostMDMData)
+		require.True(t, ok)
+		gotActivity = act
+		return nil
+	}
+
+	// only admins of the host's team can purge its data
+	for _, u := range []*fleet.User{
+		test.UserMaintainer, test.UserObserver, test.UserGitOps,
+		test.UserTeamMaintainerTeam1, test.UserTeamAdminTeam2,
+	} {
+		err := svc.PurgeHostMDMAppleData(test.UserContext(ctx, u), 42)
+		require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	}
+	require.False(t, ds.PurgeHostMDMAppleDataFuncInvoked)
+
+	err := svc.PurgeHostMDMAppleData(test.UserContext(ctx, test.UserTeamAdminTeam1), 42)
+	require.NoError(t, err)
+	require.True(t, ds.PurgeHostMDMAppleDataFuncInvoked)
+	require.Equal(t, &fleet.ActivityTypePurgedHostMDMData{HostID: 42, HostSerial: "ABC", HostDisplayName: "Mac (ABC)"}, gotActivity)
+
+	ds.PurgeHostMDMAppleDataFuncInvoked = false
+	err = svc.PurgeHostMDMAppleData(test.UserContext(ctx, test.UserAdmin), 42)
+	require.NoError(t, err)
+	require.True(t, ds.PurgeHostMDMAppleDataFuncInvoked)
+}
+
+func TestMDMAppleNanoEnrollments(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
 
-	t.Run("if the team doesn't have an enroll secret, fallback to no team", func(t *testing.T) {
-		ctx := context.Background()
-		ds := new(mock.Store)
-		secrets := []*fleet.EnrollSecret{
-			{Secret: "global", TeamID: nil},
-			{Secret: "", TeamID: ptr.Uint(1)},
-		}
-		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
-			appCfg := &fleet.AppConfig{}
-			appCfg.ServerSettings.ServerURL = testURL
-			appCfg.MDM.AppleBMDefaultTeam = testTeamName
-			return appCfg, nil
+	enrollments := map[string]*fleet.MDMAppleNanoEnrollment{
+		"linked": {ID: "linked", DeviceID: "linked", Type: "Device", HostID: ptr.Uint(1)},
+		"orphan": {ID: "orphan", DeviceID: "orphan", Type: "Device", SerialNumber: ptr.String("ABC")},
+	}
+	ds.ListMDMAppleNanoEnrollmentsFunc = func(ctx context.Context, opt fleet.MDMAppleNanoEnrollmentListOptions) ([]*fleet.MDMAppleNanoEnrollment, *fleet.PaginationMetadata, error) {
+		return []*fleet.MDMAppleNanoEnrollment{enrollments["orphan"]}, &fleet.PaginationMetadata{}, nil
+	}
+	ds.GetMDMAppleNanoEnrollmentFunc = func(ctx context.Context, id string) (*fleet.MDMAppleNanoEnrollment, error) {
+		e, ok := enrollments[id]
+		if !ok {
+			return nil, newNotFoundError()
 		}
-		ds.AggregateEnrollSecretPerTeamFunc = func(ctx context.Context) ([]*fleet.EnrollSecret, error) {
-			return secrets, nil
+		return e, nil
+	}
+	ds.DeleteMDMAppleNanoEnrollmentFunc = func(ctx context.Context, id string) error {
+		return nil
+	}
+	ds.LinkMDMAppleNanoEnrollmentToHostFunc = func(ctx context.Context, id string, hostID uint) error {
+		return nil
+	}
+	ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
+		switch id {
+		case 2:
+			return &fleet.Host{ID: id, Platform: "darwin", HardwareSerial: "ABC"}, nil
+		case 3:
+			return &fleet.Host{ID: id, Platform: "darwin", HardwareSerial: "DEF"}, nil
+		default:
+			return &fleet.Host{ID: id, Platform: "windows"}, nil
 		}
-		ds.BulkUpsertMDMAppleConfigProfilesFunc = func(ctx context.Context, ps []*fleet.MDMAppleConfigProfile) error {
-			require.Len(t, ps, len(secrets))
-			for i, p := range ps {
-				require.Contains(t, string(p.Mobileconfig), testURL)
-				require.Contains(t, string(p.Mobileconfig), secrets[i].Secret)
-				require.Equal(t, mobileconfig.FleetdConfigPayloadIdentifier, p.Identifier)
-			}
-			return nil
+	}
+
+	// only global admins can manage the MDM server's enrollments
+	for _, u := range []*fleet.User{test.UserMaintainer, test.UserObserver, test.UserTeamAdminTeam1} {
+		uctx := test.UserContext(ctx, u)
+		_, _, err := svc.ListMDMAppleNanoEnrollments(uctx, fleet.MDMAppleNanoEnrollmentListOptions{})
+		checkAuthErr(t, true, err)
+		err = svc.DeleteMDMAppleNanoEnrollment(uctx, "orphan")
+		checkAuthErr(t, true, err)
+		err = svc.LinkMDMAppleNanoEnrollmentToHost(uctx, "orphan", 2)
+		checkAuthErr(t, true, err)
+	}
+	require.False(t, ds.DeleteMDMAppleNanoEnrollmentFuncInvoked)
+	require.False(t, ds.LinkMDMAppleNanoEnrollmentToHostFuncInvoked)
+
+	ctx = test.UserContext(ctx, test.UserAdmin)
+	res, _, err := svc.ListMDMAppleNanoEnrollments(ctx, fleet.MDMAppleNanoEnrollmentListOptions{OrphanedOnly: true})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	// enrollments linked to a host cannot be deleted nor re-linked
+	err = svc.DeleteMDMAppleNanoEnrollment(ctx, "linked")
+	require.ErrorContains(t, err, "enrollment is linked to host 1")
+	err = svc.LinkMDMAppleNanoEnrollmentToHost(ctx, "linked", 2)
+	require.ErrorContains(t, err, "enrollment is already linked to host 1")
+	err = svc.DeleteMDMAppleNanoEnrollment(ctx, "no-such-enrollment")
+	require.True(t, fleet.IsNotFound(err))
+	require.False(t, ds.DeleteMDMAppleNanoEnrollmentFuncInvoked)
+
+	// the host must be a macOS host with the serial number of the device
+	err = svc.LinkMDMAppleNanoEnrollmentToHost(ctx, "orphan", 1)
+	require.ErrorContains(t, err, "host is not a macOS host")
+	err = svc.LinkMDMAppleNanoEnrollmentToHost(ctx, "orphan", 3)
+	require.ErrorContains(t, err, "host serial number does not match")
+	require.False(t, ds.LinkMDMAppleNanoEnrollmentToHostFuncInvoked)
+
+	require.NoError(t, svc.LinkMDMAppleNanoEnrollmentToHost(ctx, "orphan", 2))
+	require.True(t, ds.LinkMDMAppleNanoEnrollmentToHostFuncInvoked)
+	require.NoError(t, svc.DeleteMDMAppleNanoEnrollment(ctx, "orphan"))
+	require.True(t, ds.DeleteMDMAppleNanoEnrollmentFuncInvoked)
+}
+
+func TestMDMAppleReleaseDEPDevice(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	ds.ReleaseMDMAppleDEPHostFunc = func(ctx context.Context, serial string) (uint, bool, error) {
+		require.Equal(t, "DEP-SERIAL", serial)
+		return 42, true, nil
+	}
+	var gotActivity *fleet.ActivityTypeReleasedMDMAppleDEPDevice
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		act, ok := activity.(*fleet.ActivityTypeReleasedMDMAppleDEPDevice)
+		require.True(t, ok)
+		gotActivity = act
+		return nil
+	}
+
+	for _, u := range []*fleet.User{test.UserMaintainer, test.UserObserver, test.UserTeamAdminTeam1} {
+		err := svc.ReleaseMDMAppleDEPDevice(test.UserContext(ctx, u), "DEP-SERIAL")
+		checkAuthErr(t, true, err)
+	}
+
+	ctx = test.UserContext(ctx, test.UserAdmin)
+
+	// the device is not assigned to Fleet's MDM server
+	err := svc.ReleaseMDMAppleDEPDevice(ctx, "OTHER-SERIAL")
+	require.ErrorContains(t, err, "Apple Business Manager could not release the device: NOT_ACCESSIBLE")
+	require.False(t, ds.ReleaseMDMAppleDEPHostFuncInvoked)
+
+	err = svc.ReleaseMDMAppleDEPDevice(ctx, "DEP-SERIAL")
+	require.NoError(t, err)
+	require.True(t, ds.ReleaseMDMAppleDEPHostFuncInvoked)
+	require.Equal(t, &fleet.ActivityTypeReleasedMDMAppleDEPDevice{
+		HostSerial:         "DEP-SERIAL",
+		HostID:             ptr.Uint(42),
+		DeletedPendingHost: true,
+	}, gotActivity)
+}
+
+func TestMDMAppleUploadDEPTeamAssignments(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	ds.TeamByNameFunc = func(ctx context.Context, name string) (*fleet.Team, error) {
+		switch name {
+		case "Workstations":
+			return &fleet.Team{ID: 1, Name: name}, nil
+		case "Kiosks":
+			return &fleet.Team{ID: 2, Name: name}, nil
 		}
-		err := ensureFleetdConfig(ctx, ds, logger)
+		return nil, &notFoundError{}
+	}
+	var gotAssignments []*fleet.MDMAppleDEPTeamAssignment
+	ds.SetMDMAppleDEPTeamAssignmentsFunc = func(ctx context.Context, assignments []*fleet.MDMAppleDEPTeamAssignment) error {
+		gotAssignments = assignments
+		return nil
+	}
+	ds.ListMDMAppleDEPTeamAssignmentsFunc = func(ctx context.Context) ([]*fleet.MDMAppleDEPTeamAssignment, error) {
+		return []*fleet.MDMAppleDEPTeamAssignment{{SerialNumber: "A", TeamID: ptr.Uint(1), TeamName: "Workstations"}}, nil
+	}
+	var gotActivity *fleet.ActivityTypeUploadedMDMAppleDEPTeamAssignments
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		act, ok := activity.(*fleet.ActivityTypeUploadedMDMAppleDEPTeamAssignments)
+		require.True(t, ok)
+		gotActivity = act
+		return nil
+	}
+
+	for _, u := range []*fleet.User{test.UserMaintainer, test.UserObserver, test.UserTeamAdminTeam1} {
+		uctx := test.UserContext(ctx, u)
+		_, err := svc.UploadMDMAppleDEPTeamAssignments(uctx, strings.NewReader("A,Workstations"))
+		checkAuthErr(t, true, err)
+		_, err = svc.ListMDMAppleDEPTeamAssignments(uctx)
+		checkAuthErr(t, true, err)
+	}
+	require.False(t, ds.SetMDMAppleDEPTeamAssignmentsFuncInvoked)
+
+	ctx = test.UserContext(ctx, test.UserAdmin)
+
+	cases := []struct {
+		desc    string
+		csv     string
+		wantErr string
+	}{
+		{"empty file", "", "no serial numbers found in the file"},
+		{"header only", "serial_number,team\n", "no serial numbers found in the file"},
+		{"unknown team", "A,Workstations\nB,Servers\n", "line 2: team Servers does not exist"},
+		{"duplicate serial", "A,Workstations\nA,Kiosks\n", "line 2: duplicate serial number A"},
+		{"missing serial", "A,Workstations\n,Kiosks\n", "line 2: serial number is required"},
+		{"too many fields", "A,Workstations,extra\n", "line 1: expected serial number and team, got 3 fields"},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			_, err := svc.UploadMDMAppleDEPTeamAssignments(ctx, strings.NewReader(c.csv))
+			require.ErrorContains(t, err, c.wantErr)
+		})
+	}
+	require.False(t, ds.SetMDMAppleDEPTeamAssignmentsFuncInvoked)
+
+	res, err := svc.UploadMDMAppleDEPTeamAssignments(ctx, strings.NewReader("serial_number,team\nA, Workstations\n\nB,Kiosks\nC,Workstations\nD,\n"))
+	require.NoError(t, err)
+	require.Equal(t, &fleet.MDMAppleDEPTeamAssignmentsResult{Assigned: 3, Removed: 1}, res)
+	require.Equal(t, []*fleet.MDMAppleDEPTeamAssignment{
+		{SerialNumber: "A", TeamID: ptr.Uint(1), TeamName: "Workstations"},
+		{SerialNumber: "B", TeamID: ptr.Uint(2), TeamName: "Kiosks"},
+		{SerialNumber: "C", TeamID: ptr.Uint(1), TeamName: "Workstations"},
+		{SerialNumber: "D"},
+	}, gotAssignments)
+	require.Equal(t, &fleet.ActivityTypeUploadedMDMAppleDEPTeamAssignments{AssignedCount: 3, RemovedCount: 1}, gotActivity)
+
+	assignments, err := svc.ListMDMAppleDEPTeamAssignments(ctx)
+	require.NoError(t, err)
+	require.Len(t, assignments, 1)
+}
+
+func TestMDMAppleDEPHostsReport(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	var gotFilter fleet.TeamFilter
+	ds.ListMDMAppleDEPHostsReportFunc = func(ctx context.Context, filter fleet.TeamFilter, opts fleet.ListOptions) ([]*fleet.MDMAppleDEPHostReportItem, error) {
+		gotFilter = filter
+		return []*fleet.MDMAppleDEPHostReportItem{
+			{ID: 1, HardwareSerial: "ABC", HostMDMAppleDEPDevice: fleet.HostMDMAppleDEPDevice{HostID: 1, OrgName: "Acme Inc."}},
+		}, nil
+	}
+
+	_, err := svc.ListMDMAppleDEPHostsReport(test.UserContext(ctx, test.UserNoRoles), nil, fleet.ListOptions{})
+	checkAuthErr(t, true, err)
+
+	// the hosts are filtered by the teams of the user
+	for _, u := range []*fleet.User{test.UserAdmin, test.UserObserver, test.UserTeamObserverTeam1} {
+		items, err := svc.ListMDMAppleDEPHostsReport(test.UserContext(ctx, u), ptr.Uint(1), fleet.ListOptions{})
 		require.NoError(t, err)
-		require.True(t, ds.AppConfigFuncInvoked)
-		require.True(t, ds.AggregateEnrollSecretPerTeamFuncInvoked)
-		require.True(t, ds.BulkUpsertMDMAppleConfigProfilesFuncInvoked)
-	})
+		require.Len(t, items, 1)
+		require.Equal(t, "Acme Inc.", items[0].OrgName)
+		require.Equal(t, u, gotFilter.User)
+		require.True(t, gotFilter.IncludeObserver)
+		require.Equal(t, ptr.Uint(1), gotFilter.TeamID)
+	}
+}
 
-	t.Run("returns an error if there's a problem retrieving AppConfig", func(t *testing.T) {
-		ctx := context.Background()
-		ds := new(mock.Store)
-		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
-			return nil, testError
-		}
-		err := ensureFleetdConfig(ctx, ds, logger)
-		require.ErrorIs(t, err, testError)
-	})
+func TestListMDMAppleDEPAssignmentDiscrepancies(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
 
-	t.Run("returns an error if there's a problem retrieving secrets", func(t *testing.T) {
-		ctx := context.Background()
-		ds := new(mock.Store)
-		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
-			return &fleet.AppConfig{}, nil
-		}
-		ds.AggregateEnrollSecretPerTeamFunc = func(ctx context.Context) ([]*fleet.EnrollSecret, error) {
-			return nil, testError
-		}
-		err := ensureFleetdConfig(ctx, ds, logger)
-		require.ErrorIs(t, err, testError)
-	})
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{MDM: fleet.MDM{AppleBMDefaultDeviceFamilies: []string{"Mac"}}}, nil
+	}
+	var gotFilter fleet.TeamFilter
+	var gotFamilies []string
+	ds.ListMDMAppleDEPAssignmentDiscrepanciesFunc = func(ctx context.Context, filter fleet.TeamFilter, defaultFamilies []string) ([]*fleet.MDMAppleDEPAssignmentDiscrepancy, error) {
+		gotFilter = filter
+		gotFamilies = defaultFamilies
+		return []*fleet.MDMAppleDEPAssignmentDiscrepancy{
+			{HostID: 1, HardwareSerial: "ABC", Reason: fleet.MDMAppleDEPAssignmentDiscrepancyUnassigned},
+		}, nil
+	}
 
-	t.Run("returns an error if there's a problem upserting profiles", func(t *testing.T) {
-		ctx := context.Background()
-		ds := new(mock.Store)
-		secrets := []*fleet.EnrollSecret{
-			{Secret: "global", TeamID: nil},
-			{Secret: "team-1", TeamID: ptr.Uint(1)},
-		}
-		ds.AggregateEnrollSecretPerTeamFunc = func(ctx context.Context) ([]*fleet.EnrollSecret, error) {
-			return secrets, nil
-		}
-		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
-			return &fleet.AppConfig{}, nil
-		}
-		ds.BulkUpsertMDMAppleConfigProfilesFunc = func(ctx context.Context, p []*fleet.MDMAppleConfigProfile) error {
-			return testError
-		}
-		err := ensureFleetdConfig(ctx, ds, logger)
-		require.ErrorIs(t, err, testError)
-		require.True(t, ds.AppConfigFuncInvoked)
-		require.True(t, ds.AggregateEnrollSecretPerTeamFuncInvoked)
-		require.True(t, ds.BulkUpsertMDMAppleConfigProfilesFuncInvoked)
-	})
+	_, _, err := svc.ListMDMAppleDEPAssignmentDiscrepancies(test.UserContext(ctx, test.UserNoRoles), nil)
+	checkAuthErr(t, true, err)
+	require.False(t, ds.ListMDMAppleDEPAssignmentDiscrepanciesFuncInvoked)
+
+	// the hosts are filtered by the teams of the user
+	for _, u := range []*fleet.User{test.UserAdmin, test.UserObserver, test.UserTeamObserverTeam1} {
+		families, items, err := svc.ListMDMAppleDEPAssignmentDiscrepancies(test.UserContext(ctx, u), ptr.Uint(1))
+		require.NoError(t, err)
+		require.Equal(t, []string{"Mac"}, families)
+		require.Equal(t, []string{"Mac"}, gotFamilies)
+		require.Len(t, items, 1)
+		require.Equal(t, "ABC", items[0].HardwareSerial)
+		require.Equal(t, u, gotFilter.User)
+		require.True(t, gotFilter.IncludeObserver)
+		require.Equal(t, ptr.Uint(1), gotFilter.TeamID)
+	}
 }
 
 func TestMDMAppleSetupAssistant(t *testing.T) {
@@ -2343,10 +6148,279 @@ func TestMDMAppleSetupAssistant(t *testing.T) {
 			})
 			checkAuthErr(t, tt.shouldFailWrite, err)
 
-			err = svc.DeleteMDMAppleSetupAssistant(ctx, tt.teamID)
-			checkAuthErr(t, tt.shouldFailWrite, err)
-		})
-	}
+			err = svc.DeleteMDMAppleSetupAssistant(ctx, tt.teamID)
+			checkAuthErr(t, tt.shouldFailWrite, err)
+		})
+	}
+}
+
+func TestHostMDMAppleDEPProfileOverride(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	var stored *json.RawMessage
+	ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
+		return &fleet.Host{ID: id, TeamID: ptr.Uint(1), Platform: "darwin"}, nil
+	}
+	ds.GetHostMDMAppleDEPDeviceFunc = func(ctx context.Context, hostID uint) (*fleet.HostMDMAppleDEPDevice, error) {
+		return &fleet.HostMDMAppleDEPDevice{}, nil
+	}
+	ds.GetHostMDMAppleDEPProfileOverrideFunc = func(ctx context.Context, hostID uint) (*fleet.HostMDMAppleDEPProfileOverride, error) {
+		if stored == nil {
+			return nil, &notFoundError{}
+		}
+		return &fleet.HostMDMAppleDEPProfileOverride{HostID: hostID, Profile: stored}, nil
+	}
+	ds.SetHostMDMAppleDEPProfileOverrideFunc = func(ctx context.Context, hostID uint, profile *json.RawMessage) error {
+		stored = profile
+		return nil
+	}
+
+	testCases := []struct {
+		name            string
+		user            *fleet.User
+		shouldFailRead  bool
+		shouldFailWrite bool
+	}{
+		{"no role", test.UserNoRoles, true, true},
+		{"global admin", test.UserAdmin, false, false},
+		{"global maintainer", test.UserMaintainer, false, false},
+		{"global observer", test.UserObserver, false, true},
+		{"team admin", test.UserTeamAdminTeam1, false, false},
+		{"team admin other team", test.UserTeamAdminTeam2, true, true},
+		{"team maintainer", test.UserTeamMaintainerTeam1, false, false},
+		{"team observer", test.UserTeamObserverTeam1, false, true},
+		{"team observer other team", test.UserTeamObserverTeam2, true, true},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := viewer.NewContext(ctx, viewer.Viewer{User: tt.user})
+
+			_, err := svc.GetHostMDMAppleDEPProfileOverride(ctx, 1)
+			checkAuthErr(t, tt.shouldFailRead, err)
+
+			_, err = svc.SetHostMDMAppleDEPProfileOverride(ctx, 1, json.RawMessage(`{}`))
+			checkAuthErr(t, tt.shouldFailWrite, err)
+
+			err = svc.DeleteHostMDMAppleDEPProfileOverride(ctx, 1)
+			checkAuthErr(t, tt.shouldFailWrite, err)
+		})
+	}
+
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: test.UserAdmin})
+
+	// the profile is validated like the setup assistant
+	for _, c := range []struct {
+		profile string
+		wantErr string
+	}{
+		{`[]`, "The profile must be a JSON object"},
+		{`null`, "The profile must be a JSON object"},
+		{`{"url": "https://example.com"}`, "can’t include url"},
+		{`{"await_device_configured": true}`, "can’t include await_device_configured"},
+		{`{"department": "$FLEET_VAR_NOPE"}`, "FLEET_VAR_NOPE"},
+	} {
+		_, err := svc.SetHostMDMAppleDEPProfileOverride(ctx, 1, json.RawMessage(c.profile))
+		require.ErrorContains(t, err, c.wantErr, c.profile)
+	}
+
+	override, err := svc.GetHostMDMAppleDEPProfileOverride(ctx, 1)
+	require.NoError(t, err)
+	require.Nil(t, override)
+
+	override, err = svc.SetHostMDMAppleDEPProfileOverride(ctx, 1, json.RawMessage(`{"skip_setup_items": ["Location"]}`))
+	require.NoError(t, err)
+	require.NotNil(t, override.Profile)
+	require.JSONEq(t, `{"skip_setup_items": ["Location"]}`, string(*override.Profile))
+
+	require.NoError(t, svc.DeleteHostMDMAppleDEPProfileOverride(ctx, 1))
+	require.Nil(t, stored)
+
+	// the host must be assigned to Fleet in ABM
+	ds.GetHostMDMAppleDEPDeviceFunc = func(ctx context.Context, hostID uint) (*fleet.HostMDMAppleDEPDevice, error) {
+		return nil, &notFoundError{}
+	}
+	_, err = svc.SetHostMDMAppleDEPProfileOverride(ctx, 1, json.RawMessage(`{}`))
+	require.ErrorContains(t, err, "isn’t assigned to Fleet in Apple Business Manager")
+}
+
+func TestMDMAppleBootstrapPackageUpload(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	pkg, err := os.ReadFile("../../pkg/file/testdata/signed.pkg")
+	require.NoError(t, err)
+	checksum := sha256.Sum256(pkg)
+
+	var uploads map[string]*fleet.MDMAppleBootstrapPackageUpload
+	var chunks [][]byte
+	var committed string
+	reset := func() {
+		uploads = make(map[string]*fleet.MDMAppleBootstrapPackageUpload)
+		chunks = nil
+		committed = ""
+	}
+
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		return nil
+	}
+	ds.TeamFunc = func(ctx context.Context, id uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: id, Name: "team"}, nil
+	}
+	ds.GetMDMAppleBootstrapPackageMetaFunc = func(ctx context.Context, teamID uint) (*fleet.MDMAppleBootstrapPackage, error) {
+		return nil, &notFoundError{}
+	}
+	ds.NewMDMAppleBootstrapPackageUploadFunc = func(ctx context.Context, upload *fleet.MDMAppleBootstrapPackageUpload) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+		upload.ID = uint(len(uploads) + 1)
+		uploads[upload.Token] = upload
+		return upload, nil
+	}
+	ds.GetMDMAppleBootstrapPackageUploadFunc = func(ctx context.Context, token string) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+		upload, ok := uploads[token]
+		if !ok {
+			return nil, &notFoundError{}
+		}
+		return upload, nil
+	}
+	ds.AppendMDMAppleBootstrapPackageUploadChunkFunc = func(ctx context.Context, token string, offset int64, chunk []byte) (*fleet.MDMAppleBootstrapPackageUpload, error) {
+		upload := uploads[token]
+		if offset != upload.Offset {
+			return nil, &fleet.MDMAppleBootstrapPackageUploadOffsetError{Expected: upload.Offset, Got: offset}
+		}
+		chunks = append(chunks, chunk)
+		upload.Offset += int64(len(chunk))
+		upload.Chunks++
+		return upload, nil
+	}
+	ds.GetMDMAppleBootstrapPackageUploadChunkFunc = func(ctx context.Context, uploadID uint, index int) ([]byte, error) {
+		if index >= len(chunks) {
+			return nil, &notFoundError{}
+		}
+		return chunks[index], nil
+	}
+	ds.CommitMDMAppleBootstrapPackageUploadFunc = func(ctx context.Context, token string, pkgToken string) error {
+		committed = token
+		return nil
+	}
+
+	testCases := []struct {
+		name       string
+		user       *fleet.User
+		teamID     uint
+		shouldFail bool
+	}{
+		{"no role no team", test.UserNoRoles, 0, true},
+		{"no role team", test.UserNoRoles, 1, true},
+		{"global admin no team", test.UserAdmin, 0, false},
+		{"global admin team", test.UserAdmin, 1, false},
+		{"global maintainer no team", test.UserMaintainer, 0, false},
+		{"global maintainer team", test.UserMaintainer, 1, false},
+		{"global observer no team", test.UserObserver, 0, true},
+		{"global observer team", test.UserObserver, 1, true},
+		{"global gitops team", test.UserGitOps, 1, true},
+		{"team admin no team", test.UserTeamAdminTeam1, 0, true},
+		{"team admin team", test.UserTeamAdminTeam1, 1, false},
+		{"team admin other team", test.UserTeamAdminTeam2, 1, true},
+		{"team maintainer team", test.UserTeamMaintainerTeam1, 1, false},
+		{"team maintainer other team", test.UserTeamMaintainerTeam2, 1, true},
+		{"team observer team", test.UserTeamObserverTeam1, 1, true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			reset()
+			uploads["existing"] = &fleet.MDMAppleBootstrapPackageUpload{
+				ID:     99,
+				Token:  "existing",
+				TeamID: tt.teamID,
+				Name:   "signed.pkg",
+				Size:   int64(len(pkg)),
+				Sha256: checksum[:],
+			}
+
+			// prepare the context with the user and license
+			ctx := viewer.NewContext(ctx, viewer.Viewer{User: tt.user})
+
+			_, err := svc.InitiateMDMAppleBootstrapPackageUpload(ctx, tt.teamID, "signed.pkg", int64(len(pkg)), checksum[:])
+			checkAuthErr(t, tt.shouldFail, err)
+
+			_, err = svc.GetMDMAppleBootstrapPackageUpload(ctx, "existing")
+			checkAuthErr(t, tt.shouldFail, err)
+
+			_, err = svc.AppendMDMAppleBootstrapPackageUpload(ctx, "existing", 0, pkg[:10])
+			checkAuthErr(t, tt.shouldFail, err)
+		})
+	}
+
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: test.UserAdmin})
+
+	upload := func(t *testing.T, sum []byte) string {
+		up, err := svc.InitiateMDMAppleBootstrapPackageUpload(ctx, 1, "signed.pkg", int64(len(pkg)), sum)
+		require.NoError(t, err)
+
+		const chunkSize = 1000
+		for offset := 0; offset < len(pkg); offset += chunkSize {
+			end := min(offset+chunkSize, len(pkg))
+			up, err = svc.AppendMDMAppleBootstrapPackageUpload(ctx, up.Token, int64(offset), pkg[offset:end])
+			require.NoError(t, err)
+			require.Equal(t, int64(end), up.Offset)
+		}
+		return up.Token
+	}
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		reset()
+		_, err := svc.InitiateMDMAppleBootstrapPackageUpload(ctx, 1, "", 0, []byte("abc"))
+		var iae *fleet.InvalidArgumentError
+		require.ErrorAs(t, err, &iae)
+		require.Len(t, iae.Invalid(), 3)
+	})
+
+	t.Run("offset mismatch and oversized chunk", func(t *testing.T) {
+		reset()
+		up, err := svc.InitiateMDMAppleBootstrapPackageUpload(ctx, 1, "signed.pkg", int64(len(pkg)), checksum[:])
+		require.NoError(t, err)
+
+		_, err = svc.AppendMDMAppleBootstrapPackageUpload(ctx, up.Token, 10, pkg[10:20])
+		var oe *fleet.MDMAppleBootstrapPackageUploadOffsetError
+		require.ErrorAs(t, err, &oe)
+		require.EqualValues(t, 0, oe.Expected)
+
+		_, err = svc.AppendMDMAppleBootstrapPackageUpload(ctx, up.Token, 0, append(pkg, 'a'))
+		var bre *fleet.BadRequestError
+		require.ErrorAs(t, err, &bre)
+	})
+
+	t.Run("incomplete upload", func(t *testing.T) {
+		reset()
+		up, err := svc.InitiateMDMAppleBootstrapPackageUpload(ctx, 1, "signed.pkg", int64(len(pkg)), checksum[:])
+		require.NoError(t, err)
+		_, err = svc.AppendMDMAppleBootstrapPackageUpload(ctx, up.Token, 0, pkg[:10])
+		require.NoError(t, err)
+
+		err = svc.CommitMDMAppleBootstrapPackageUpload(ctx, up.Token)
+		require.ErrorContains(t, err, "upload is incomplete")
+		require.Empty(t, committed)
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		reset()
+		wrong := sha256.Sum256([]byte("wrong"))
+		token := upload(t, wrong[:])
+
+		err := svc.CommitMDMAppleBootstrapPackageUpload(ctx, token)
+		require.ErrorContains(t, err, "checksum")
+		require.Empty(t, committed)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		reset()
+		ds.NewActivityFuncInvoked = false
+		token := upload(t, checksum[:])
+
+		err := svc.CommitMDMAppleBootstrapPackageUpload(ctx, token)
+		require.NoError(t, err)
+		require.Equal(t, token, committed)
+		require.True(t, ds.NewActivityFuncInvoked)
+	})
 }
 
 func mobileconfigForTest(name, identifier string) []byte {
@@ -2407,3 +6481,678 @@ func mobileconfigForTestWithContent(name, identifier, inneridentifier, innertype
 </plist>
 `, name+".inner", inneridentifier, innertype, name, identifier, uuid.New().String()))
 }
+
+func TestListMDMAppleSCEPCertificates(t *testing.T) {
+	globalHost := &fleet.Host{ID: 1, Hostname: "test_hostname", UUID: "test_uuid"}
+	teamHost := &fleet.Host{ID: 2, Hostname: "test_hostname_2", UUID: "test_uuid_2", TeamID: ptr.Uint(1)}
+
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil)
+
+	ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
+		if id == globalHost.ID {
+			return globalHost, nil
+		}
+		return teamHost, nil
+	}
+	var gotFilter fleet.TeamFilter
+	ds.ListMDMAppleSCEPCertificatesFunc = func(ctx context.Context, filter fleet.TeamFilter, opt fleet.MDMAppleSCEPCertificateListOptions) ([]*fleet.MDMAppleSCEPCertificate, *fleet.PaginationMetadata, error) {
+		gotFilter = filter
+		return []*fleet.MDMAppleSCEPCertificate{{Serial: 2, HostID: opt.HostID}}, &fleet.PaginationMetadata{}, nil
+	}
+
+	cases := []struct {
+		user          *fleet.User
+		allowedList   bool
+		allowedGlobal bool
+		allowedTeam   bool
+	}{
+		{test.UserAdmin, true, true, true},
+		{test.UserMaintainer, true, true, true},
+		{test.UserObserver, true, true, true},
+		{test.UserTeamAdminTeam1, true, false, true},
+		{test.UserTeamObserverTeam1, true, false, true},
+		{test.UserTeamAdminTeam2, true, false, false},
+		{test.UserNoRoles, false, false, false},
+	}
+	checkErr := func(t *testing.T, allowed bool, err error) {
+		if allowed {
+			require.NoError(t, err)
+		} else {
+			require.Error(t, err)
+			require.Contains(t, authz.ForbiddenErrorMessage, err.Error())
+		}
+	}
+	for _, c := range cases {
+		t.Run(c.user.Email, func(t *testing.T) {
+			ctx := test.UserContext(ctx, c.user)
+
+			certs, _, err := svc.ListMDMAppleSCEPCertificates(ctx, fleet.MDMAppleSCEPCertificateListOptions{ExpiresWithinDays: ptr.Uint(30)})
+			checkErr(t, c.allowedList, err)
+			if c.allowedList {
+				require.Len(t, certs, 1)
+				require.Equal(t, c.user, gotFilter.User)
+				require.True(t, gotFilter.IncludeObserver)
+			}
+
+			_, _, err = svc.ListMDMAppleSCEPCertificates(ctx, fleet.MDMAppleSCEPCertificateListOptions{HostID: &globalHost.ID})
+			checkErr(t, c.allowedGlobal, err)
+
+			_, _, err = svc.ListMDMAppleSCEPCertificates(ctx, fleet.MDMAppleSCEPCertificateListOptions{HostID: &teamHost.ID})
+			checkErr(t, c.allowedTeam, err)
+		})
+	}
+}
+
+func TestMDMApplePolicyActions(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	pols := map[uint]*fleet.Policy{
+		1: {PolicyData: fleet.PolicyData{ID: 1, Name: "global"}},
+		2: {PolicyData: fleet.PolicyData{ID: 2, Name: "team", TeamID: ptr.Uint(1)}},
+	}
+	ds.PolicyFunc = func(ctx context.Context, id uint) (*fleet.Policy, error) {
+		p, ok := pols[id]
+		if !ok {
+			return nil, newNotFoundError()
+		}
+		return p, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, id uint) (*fleet.Team, error) {
+		if id == 1 {
+			return &fleet.Team{ID: 1}, nil
+		}
+		return nil, newNotFoundError()
+	}
+	var saved *fleet.MDMApplePolicyAction
+	ds.SetMDMApplePolicyActionFunc = func(ctx context.Context, action *fleet.MDMApplePolicyAction) error {
+		saved = action
+		return nil
+	}
+	ds.GetMDMApplePolicyActionFunc = func(ctx context.Context, policyID uint) (*fleet.MDMApplePolicyAction, error) {
+		return saved, nil
+	}
+	ds.DeleteMDMApplePolicyActionFunc = func(ctx context.Context, policyID uint) error {
+		return nil
+	}
+
+	template := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>DeviceLock</string>
+		<key>Message</key>
+		<string>$FLEET_VAR_HOST_DISPLAY_NAME</string>
+	</dict>
+</dict>
+</plist>`
+
+	// team users can only act on the policies of their team
+	teamCtx := viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleMaintainer}}}})
+	_, err := svc.SetMDMApplePolicyAction(teamCtx, 1, nil, template, nil)
+	checkAuthErr(t, true, err)
+	_, err = svc.SetMDMApplePolicyAction(teamCtx, 2, nil, template, nil)
+	require.NoError(t, err)
+	_, err = svc.GetMDMApplePolicyAction(teamCtx, 2)
+	require.NoError(t, err)
+	err = svc.DeleteMDMApplePolicyAction(teamCtx, 1)
+	checkAuthErr(t, true, err)
+	err = svc.DeleteMDMApplePolicyAction(teamCtx, 2)
+	require.NoError(t, err)
+
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}})
+
+	cases := []struct {
+		desc     string
+		policyID uint
+		profile  []byte
+		template string
+		teamIDs  []uint
+		wantErr  string
+	}{
+		{"unknown policy", 3, nil, template, nil, "not found"},
+		{"no action", 1, nil, "", nil, "At least one of remediation_profile or command_template is required."},
+		{"invalid profile", 1, []byte("nope"), "", nil, "Couldn’t parse the remediation profile"},
+		{"invalid template", 1, nil, "nope", nil, "Invalid command template"},
+		{"unknown variable", 1, nil, strings.Replace(template, "HOST_DISPLAY_NAME", "NOPE", 1), nil, "unsupported variable(s): FLEET_VAR_NOPE"},
+		{"teams of team policy", 2, nil, template, []uint{1}, "Teams can only be set for the MDM action of a global policy."},
+		{"unknown team", 1, nil, template, []uint{0, 2}, "Team doesn’t exist: 2"},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			_, err := svc.SetMDMApplePolicyAction(ctx, c.policyID, c.profile, c.template, c.teamIDs)
+			require.ErrorContains(t, err, c.wantErr)
+		})
+	}
+
+	action, err := svc.SetMDMApplePolicyAction(ctx, 1, mobileconfigForTest("N1", "I1"), template, []uint{0, 1})
+	require.NoError(t, err)
+	require.Equal(t, uint(1), action.PolicyID)
+	require.Equal(t, template, action.CommandTemplate)
+	require.Equal(t, []uint{0, 1}, action.TeamIDs)
+}
+
+func TestMDMAppleServerURLMigration(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/version", r.URL.Path)
+		_, _ = w.Write([]byte(`{"version": "4.33.0"}`))
+	}))
+	t.Cleanup(srv.Close)
+	newURL := srv.URL
+
+	origClient := mdmServerURLCheckClient
+	t.Cleanup(func() { mdmServerURLCheckClient = origClient })
+	mdmServerURLCheckClient = srv.Client()
+
+	appCfg := &fleet.AppConfig{}
+	appCfg.ServerSettings.ServerURL = "https://old.fleet.invalid"
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		cfg := *appCfg
+		return &cfg, nil
+	}
+	var savedURL string
+	ds.SaveAppConfigFunc = func(ctx context.Context, info *fleet.AppConfig) error {
+		savedURL = info.ServerSettings.ServerURL
+		return nil
+	}
+	var latest *fleet.MDMAppleServerURLMigration
+	ds.GetLatestMDMAppleServerURLMigrationFunc = func(ctx context.Context) (*fleet.MDMAppleServerURLMigration, error) {
+		if latest == nil {
+			return nil, newNotFoundError()
+		}
+		return latest, nil
+	}
+	ds.NewMDMAppleServerURLMigrationFunc = func(ctx context.Context, oldServerURL, newServerURL string) (*fleet.MDMAppleServerURLMigration, error) {
+		return &fleet.MDMAppleServerURLMigration{ID: 1, OldServerURL: oldServerURL, NewServerURL: newServerURL, Hosts: 3, Pending: 3}, nil
+	}
+	var gotActivity *fleet.ActivityTypeMigratedMDMServerURL
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		act, ok := activity.(fleet.ActivityTypeMigratedMDMServerURL)
+		require.True(t, ok)
+		gotActivity = &act
+		return nil
+	}
+
+	// only global admins can migrate the server URL
+	for _, u := range []*fleet.User{test.UserMaintainer, test.UserTeamAdminTeam1} {
+		uctx := viewer.NewContext(ctx, viewer.Viewer{User: u})
+		_, _, err := svc.StartMDMAppleServerURLMigration(uctx, newURL, true)
+		require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	}
+
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: test.UserAdmin})
+	ctx = license.NewContext(ctx, &fleet.LicenseInfo{Tier: fleet.TierFree})
+
+	failedChecks := func(checks []fleet.MDMAppleServerURLMigrationCheck) []string {
+		var names []string
+		for _, c := range checks {
+			if !c.Passed {
+				names = append(names, c.Name)
+			}
+		}
+		return names
+	}
+
+	cases := []struct {
+		serverURL string
+		failed    []string
+	}{
+		{newURL, nil},
+		{newURL + "/", nil},
+		{strings.Replace(newURL, "https:", "http:", 1), []string{"valid_url", "reachable"}},
+		{newURL + "?q=1", []string{"valid_url", "reachable"}},
+		{"https://old.fleet.invalid", []string{"url_changed", "reachable"}},
+		{"https://127.0.0.1:1", []string{"reachable"}},
+	}
+	for _, c := range cases {
+		t.Run(c.serverURL, func(t *testing.T) {
+			checks, migration, err := svc.StartMDMAppleServerURLMigration(ctx, c.serverURL, true)
+			require.NoError(t, err)
+			require.Nil(t, migration)
+			require.Len(t, checks, 4)
+			require.Equal(t, c.failed, failedChecks(checks))
+		})
+	}
+	require.False(t, ds.SaveAppConfigFuncInvoked)
+
+	// the migration is not started if a check fails
+	_, _, err := svc.StartMDMAppleServerURLMigration(ctx, "https://127.0.0.1:1", false)
+	require.ErrorContains(t, err, "Fleet is not reachable at the server URL")
+	require.False(t, ds.SaveAppConfigFuncInvoked)
+	require.False(t, ds.NewMDMAppleServerURLMigrationFuncInvoked)
+
+	checks, migration, err := svc.StartMDMAppleServerURLMigration(ctx, newURL+"/", false)
+	require.NoError(t, err)
+	require.Empty(t, failedChecks(checks))
+	require.Equal(t, newURL, savedURL)
+	require.NotNil(t, migration)
+	require.Equal(t, "https://old.fleet.invalid", migration.OldServerURL)
+	require.Equal(t, &fleet.ActivityTypeMigratedMDMServerURL{
+		OldServerURL: "https://old.fleet.invalid",
+		NewServerURL: newURL,
+		HostsCount:   3,
+	}, gotActivity)
+
+	// another migration can't start while the profile has yet to be sent to
+	// some hosts
+	latest = migration
+	checks, _, err = svc.StartMDMAppleServerURLMigration(ctx, newURL, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"no_migration_in_progress"}, failedChecks(checks))
+	latest.Pending, latest.Sent = 0, 3
+	checks, _, err = svc.StartMDMAppleServerURLMigration(ctx, newURL, true)
+	require.NoError(t, err)
+	require.Empty(t, failedChecks(checks))
+
+	// with a premium license, the DEP profile is registered with the new URL
+	ds.ListMDMAppleEnrollmentProfilesFunc = func(ctx context.Context) ([]*fleet.MDMAppleEnrollmentProfile, error) {
+		return nil, errors.New("list enrollment profiles")
+	}
+	ctx = license.NewContext(ctx, &fleet.LicenseInfo{Tier: fleet.TierPremium})
+	_, _, err = svc.StartMDMAppleServerURLMigration(ctx, newURL, false)
+	require.ErrorContains(t, err, "sync DEP profile")
+	require.True(t, ds.ListMDMAppleEnrollmentProfilesFuncInvoked)
+
+	got, err := svc.GetMDMAppleServerURLMigration(ctx)
+	require.NoError(t, err)
+	require.Equal(t, latest, got)
+}
+
+func TestGetMDMAppleSCEPCARotation(t *testing.T) {
+	curCert, curKey, err := apple_mdm.NewSCEPCACertKey()
+	require.NoError(t, err)
+	prevCert, prevKey, err := apple_mdm.NewSCEPCACertKey()
+	require.NoError(t, err)
+	otherCert, otherKey, err := apple_mdm.NewSCEPCACertKey()
+	require.NoError(t, err)
+
+	issue := func(serial int64, ca *x509.Certificate, key *rsa.PrivateKey) *fleet.MDMAppleSCEPIdentityCertificate {
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "fleet-host"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, key)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		return &fleet.MDMAppleSCEPIdentityCertificate{
+			Serial:         serial,
+			HostUUID:       fmt.Sprintf("uuid-%d", serial),
+			CertificatePEM: apple_mdm.EncodeCertPEM(cert),
+		}
+	}
+	certs := []*fleet.MDMAppleSCEPIdentityCertificate{
+		issue(2, prevCert, prevKey),
+		issue(3, curCert, curKey),
+		issue(4, prevCert, prevKey),
+		issue(5, otherCert, otherKey),
+		{Serial: 6, HostUUID: "uuid-6", CertificatePEM: []byte("not a certificate")},
+	}
+
+	cfg := config.TestConfig()
+	config.SetTestMDMConfig(t, &cfg, apple_mdm.EncodeCertPEM(curCert), apple_mdm.EncodePrivateKeyPEM(curKey), nil)
+
+	ds := new(mock.Store)
+	ds.ListMDMAppleSCEPIdentityCertificatesFunc = func(ctx context.Context, afterSerial int64, limit uint) ([]*fleet.MDMAppleSCEPIdentityCertificate, error) {
+		require.Zero(t, afterSerial)
+		return certs, nil
+	}
+
+	// without a previous CA, no rotation is in progress
+	svc, ctx := newTestServiceWithConfig(t, ds, cfg, nil, nil)
+	ctx = test.UserContext(ctx, test.UserAdmin)
+	got, err := svc.GetMDMAppleSCEPCARotation(ctx)
+	require.NoError(t, err)
+	require.False(t, got.InProgress)
+	require.Nil(t, got.PreviousCA)
+	require.Equal(t, curCert.Subject.String(), got.CurrentCA.Subject)
+	require.Equal(t, curCert.NotAfter, got.CurrentCA.NotValidAfter)
+	require.EqualValues(t, 1, got.HostsOnCurrentCA)
+	require.EqualValues(t, 0, got.HostsOnPreviousCA)
+	require.EqualValues(t, 4, got.HostsOnUnknownCA)
+
+	cfg.MDM.AppleSCEPPreviousCertBytes = string(apple_mdm.EncodeCertPEM(prevCert))
+	cfg.MDM.AppleSCEPPreviousKeyBytes = string(apple_mdm.EncodePrivateKeyPEM(prevKey))
+	svc, ctx = newTestServiceWithConfig(t, ds, cfg, nil, nil)
+	ctx = test.UserContext(ctx, test.UserAdmin)
+	got, err = svc.GetMDMAppleSCEPCARotation(ctx)
+	require.NoError(t, err)
+	require.True(t, got.InProgress)
+	require.NotNil(t, got.PreviousCA)
+	prevSum := sha256.Sum256(prevCert.Raw)
+	require.Equal(t, hex.EncodeToString(prevSum[:]), got.PreviousCA.SHA256Sum)
+	require.EqualValues(t, 1, got.HostsOnCurrentCA)
+	require.EqualValues(t, 2, got.HostsOnPreviousCA)
+	require.EqualValues(t, 2, got.HostsOnUnknownCA)
+
+	ctx = test.UserContext(ctx, test.UserNoRoles)
+	_, err = svc.GetMDMAppleSCEPCARotation(ctx)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+}
+
+func TestListMDMAppleProfilesSummaryByTeam(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	teams := []*fleet.MDMAppleConfigProfilesTeamSummary{
+		{Verifying: 1, Pending: 2},
+		{TeamID: ptr.Uint(1), TeamName: "team1", Failed: 3},
+	}
+	ds.ListMDMAppleHostsProfilesSummaryByTeamFunc = func(ctx context.Context) ([]*fleet.MDMAppleConfigProfilesTeamSummary, error) {
+		return teams, nil
+	}
+
+	// only global admins can summarize all teams
+	for _, u := range []*fleet.User{
+		test.UserMaintainer,
+		test.UserObserver,
+		test.UserGitOps,
+		{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleAdmin}}},
+	} {
+		_, err := svc.ListMDMAppleProfilesSummaryByTeam(test.UserContext(ctx, u))
+		require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	}
+	require.False(t, ds.ListMDMAppleHostsProfilesSummaryByTeamFuncInvoked)
+
+	got, err := svc.ListMDMAppleProfilesSummaryByTeam(test.UserContext(ctx, test.UserAdmin))
+	require.NoError(t, err)
+	require.Equal(t, teams, got)
+}
+
+func TestRedeliverMDMAppleEnrollmentProfiles(t *testing.T) {
+	ctx := context.Background()
+	mdmStorage := &nanomdm_mock.Storage{}
+	ds := new(mock.Store)
+	pushFactory, _ := newMockAPNSPushProviderFactory()
+	pusher := nanomdm_pushsvc.New(
+		mdmStorage,
+		mdmStorage,
+		pushFactory,
+		NewNanoMDMLogger(kitlog.NewNopLogger()),
+	)
+	cmdr := apple_mdm.NewMDMAppleCommander(mdmStorage, pusher)
+
+	var enqueued []*mdm.Command
+	var enqueuedIDs []string
+	mdmStorage.EnqueueCommandFunc = func(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+		enqueued = append(enqueued, cmd)
+		enqueuedIDs = id
+		return nil, nil
+	}
+	mdmStorage.RetrievePushInfoFunc = func(ctx context.Context, tokens []string) (map[string]*mdm.Push, error) {
+		res := make(map[string]*mdm.Push, len(tokens))
+		for _, t := range tokens {
+			res[t] = &mdm.Push{Token: []byte(t)}
+		}
+		return res, nil
+	}
+	mdmStorage.RetrievePushCertFunc = func(ctx context.Context, topic string) (*tls.Certificate, string, error) {
+		cert, err := tls.LoadX509KeyPair("testdata/server.pem", "testdata/server.key")
+		return &cert, "", err
+	}
+	mdmStorage.IsPushCertStaleFunc = func(ctx context.Context, topic string, staleToken string) (bool, error) {
+		return false, nil
+	}
+
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		appCfg := &fleet.AppConfig{}
+		appCfg.ServerSettings.ServerURL = "https://new.example.com"
+		return appCfg, nil
+	}
+	var latest *fleet.MDMAppleServerURLMigration
+	ds.GetLatestMDMAppleServerURLMigrationFunc = func(ctx context.Context) (*fleet.MDMAppleServerURLMigration, error) {
+		if latest == nil {
+			return nil, newNotFoundError()
+		}
+		return latest, nil
+	}
+	ds.ListMDMAppleServerURLMigrationPendingHostsFunc = func(ctx context.Context, migrationID uint, limit int) ([]string, error) {
+		require.Equal(t, uint(1), migrationID)
+		require.Equal(t, mdmAppleServerURLMigrationBatchSize, limit)
+		return []string{"host-1", "host-2"}, nil
+	}
+	var sentUUIDs []string
+	var sentCmdUUID string
+	ds.SetMDMAppleServerURLMigrationHostsSentFunc = func(ctx context.Context, migrationID uint, hostUUIDs []string, commandUUID string) error {
+		sentUUIDs, sentCmdUUID = hostUUIDs, commandUUID
+		return nil
+	}
+
+	// nothing to do without a migration in progress
+	require.NoError(t, RedeliverMDMAppleEnrollmentProfiles(ctx, ds, cmdr, "challenge", "topic", kitlog.NewNopLogger()))
+	latest = &fleet.MDMAppleServerURLMigration{ID: 1, Hosts: 2, Sent: 2}
+	require.NoError(t, RedeliverMDMAppleEnrollmentProfiles(ctx, ds, cmdr, "challenge", "topic", kitlog.NewNopLogger()))
+	require.False(t, ds.ListMDMAppleServerURLMigrationPendingHostsFuncInvoked)
+	require.Empty(t, enqueued)
+
+	latest.Pending = 2
+	require.NoError(t, RedeliverMDMAppleEnrollmentProfiles(ctx, ds, cmdr, "challenge", "topic", kitlog.NewNopLogger()))
+	require.Equal(t, []string{"host-1", "host-2"}, sentUUIDs)
+	require.True(t, strings.HasPrefix(sentCmdUUID, fleet.MDMAppleServerURLMigrationCommandPrefix))
+	require.Len(t, enqueued, 1)
+	require.Equal(t, sentCmdUUID, enqueued[0].CommandUUID)
+	require.Equal(t, "InstallProfile", enqueued[0].Command.RequestType)
+	require.ElementsMatch(t, []string{"host-1", "host-2"}, enqueuedIDs)
+	enrollmentProfile, err := apple_mdm.GenerateEnrollmentProfileMobileconfig("", "https://new.example.com", "challenge", "topic", fleet.MDMEndUserStrings{})
+	require.NoError(t, err)
+	require.Contains(t, string(enqueued[0].Raw), base64.StdEncoding.EncodeToString(enrollmentProfile))
+}
+
+func TestSetupMDMApple(t *testing.T) {
+	apnsKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	apnsTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "APSP:test",
+			ExtraNames: []pkix.AttributeTypeAndValue{
+				{Type: []int{0, 9, 2342, 19200300, 100, 1, 1}, Value: "com.apple.mgmt.External.test"},
+			},
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	apnsDER, err := x509.CreateCertificate(rand.Reader, apnsTmpl, apnsTmpl, &apnsKey.PublicKey, apnsKey)
+	require.NoError(t, err)
+	apnsCert, err := x509.ParseCertificate(apnsDER)
+	require.NoError(t, err)
+	scepCert, scepKey, err := apple_mdm.NewSCEPCACertKey()
+	require.NoError(t, err)
+
+	validPayload := fleet.MDMAppleSetupPayload{
+		APNsCert: string(apple_mdm.EncodeCertPEM(apnsCert)),
+		APNsKey:  string(apple_mdm.EncodePrivateKeyPEM(apnsKey)),
+		SCEPCert: string(apple_mdm.EncodeCertPEM(scepCert)),
+		SCEPKey:  string(apple_mdm.EncodePrivateKeyPEM(scepKey)),
+	}
+
+	origValidateAPNs := validateAPNsConnection
+	t.Cleanup(func() { validateAPNsConnection = origValidateAPNs })
+	var apnsErr error
+	validateAPNsConnection = func(ctx context.Context, cert *tls.Certificate) error {
+		require.Equal(t, apnsCert.Raw, cert.Leaf.Raw)
+		return apnsErr
+	}
+
+	ds := new(mock.Store)
+	var enabled bool
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		appCfg := &fleet.AppConfig{}
+		appCfg.MDM.EnabledAndConfigured = enabled
+		return appCfg, nil
+	}
+	ds.SetupMDMAppleConfigAssetsFunc = func(ctx context.Context, assets []fleet.MDMConfigAsset) error {
+		require.Len(t, assets, 4)
+		require.Equal(t, fleet.MDMConfigAssetAPNsCert, assets[0].Name)
+		require.Equal(t, validPayload.APNsCert, string(assets[0].Value))
+		require.Equal(t, fleet.MDMConfigAssetSCEPKey, assets[3].Name)
+		require.Equal(t, validPayload.SCEPKey, string(assets[3].Value))
+		return nil
+	}
+
+	cfg := config.TestConfig()
+	svc, ctx := newTestServiceWithConfig(t, ds, cfg, nil, nil)
+
+	// only global admins can set up MDM
+	err = svc.SetupMDMApple(test.UserContext(ctx, test.UserObserver), validPayload)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), authz.ForbiddenErrorMessage)
+
+	ctx = test.UserContext(ctx, test.UserAdmin)
+
+	// the server private key is required
+	err = svc.SetupMDMApple(ctx, validPayload)
+	require.ErrorContains(t, err, "server private key")
+	require.False(t, ds.SetupMDMAppleConfigAssetsFuncInvoked)
+
+	cfg.Server.PrivateKey = "0123456789abcdef0123456789abcdef"
+	svc, ctx = newTestServiceWithConfig(t, ds, cfg, nil, nil)
+	ctx = test.UserContext(ctx, test.UserAdmin)
+
+	// ABM requires a premium license
+	err = svc.SetupMDMApple(ctx, fleet.MDMAppleSetupPayload{ABMToken: "token"})
+	require.ErrorIs(t, err, fleet.ErrMissingLicense)
+
+	// invalid assets
+	err = svc.SetupMDMApple(ctx, fleet.MDMAppleSetupPayload{
+		APNsCert: validPayload.SCEPCert,
+		APNsKey:  validPayload.SCEPKey,
+		SCEPCert: "not a cert",
+		SCEPKey:  validPayload.SCEPKey,
+	})
+	var invalidErr *fleet.InvalidArgumentError
+	require.ErrorAs(t, err, &invalidErr)
+	invalid := invalidErr.Invalid()
+	require.Len(t, invalid, 2)
+	require.Equal(t, "apns_cert", invalid[0]["name"])
+	require.Contains(t, invalid[0]["reason"], "get topic from certificate")
+	require.Equal(t, "scep_cert", invalid[1]["name"])
+	require.Contains(t, invalid[1]["reason"], "Apple MDM SCEP configuration")
+	require.False(t, ds.SetupMDMAppleConfigAssetsFuncInvoked)
+
+	// APNs end-to-end validation fails
+	apnsErr = errors.New("bad certificate")
+	err = svc.SetupMDMApple(ctx, validPayload)
+	require.ErrorAs(t, err, &invalidErr)
+	require.ErrorContains(t, err, "Couldn't authenticate to Apple Push Notification service")
+	require.False(t, ds.SetupMDMAppleConfigAssetsFuncInvoked)
+
+	// success
+	apnsErr = nil
+	err = svc.SetupMDMApple(ctx, validPayload)
+	require.NoError(t, err)
+	require.True(t, ds.SetupMDMAppleConfigAssetsFuncInvoked)
+
+	// MDM is already enabled
+	ds.SetupMDMAppleConfigAssetsFuncInvoked = false
+	enabled = true
+	err = svc.SetupMDMApple(ctx, validPayload)
+	require.ErrorContains(t, err, "already enabled")
+	require.False(t, ds.SetupMDMAppleConfigAssetsFuncInvoked)
+
+	// MDM configured via the server configuration can't be set up via the API
+	enabled = false
+	config.SetTestMDMConfig(t, &cfg, apple_mdm.EncodeCertPEM(scepCert), apple_mdm.EncodePrivateKeyPEM(scepKey), nil)
+	svc, ctx = newTestServiceWithConfig(t, ds, cfg, nil, nil)
+	ctx = test.UserContext(ctx, test.UserAdmin)
+	err = svc.SetupMDMApple(ctx, validPayload)
+	require.ErrorContains(t, err, "configured in the server configuration")
+	require.False(t, ds.SetupMDMAppleConfigAssetsFuncInvoked)
+}
+
+func TestPushMDMAppleHostsAuthz(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	ds.ListHostsFunc = func(ctx context.Context, filter fleet.TeamFilter, opt fleet.HostListOptions) ([]*fleet.Host, error) {
+		return []*fleet.Host{
+			{UUID: "host-team-1", Platform: "darwin", TeamID: ptr.Uint(1)},
+			{UUID: "host-linux-team-2", Platform: "ubuntu", TeamID: ptr.Uint(2)},
+		}, nil
+	}
+
+	// observers can't wake up the hosts
+	_, err := svc.PushMDMAppleHosts(test.UserContext(ctx, test.UserObserver), fleet.HostListOptions{}, nil)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+
+	// team 2 users can't wake up the hosts of team 1, the linux host is ignored
+	_, err = svc.PushMDMAppleHosts(test.UserContext(ctx, test.UserTeamMaintainerTeam2), fleet.HostListOptions{}, nil)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+
+	_, err = svc.PushMDMAppleHosts(test.UserContext(ctx, test.UserAdmin), fleet.HostListOptions{MacOSSettingsFilter: "nope"}, nil)
+	require.ErrorContains(t, err, "invalid macos_settings status")
+	_, err = svc.PushMDMAppleHosts(test.UserContext(ctx, test.UserAdmin), fleet.HostListOptions{StatusFilter: fleet.StatusOnline}, ptr.Uint(1))
+	require.ErrorContains(t, err, "may not be provided with label_id")
+}
+
+func TestGetMDMAppleReconciliationPlan(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{MDM: fleet.MDM{
+			EnabledAndConfigured:  true,
+			ProfileChangeApproval: fleet.MDMProfileChangeApproval{Enable: true, HostThreshold: 1},
+		}}, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: tid}, nil
+	}
+	ds.ListMDMAppleReconciliationPlanFunc = func(ctx context.Context, teamID *uint) ([]*fleet.MDMAppleReconciliationPlanItem, error) {
+		return []*fleet.MDMAppleReconciliationPlanItem{
+			{HostUUID: "h1", ProfileID: 1, OperationType: fleet.MDMAppleOperationTypeInstall, Reason: fleet.MDMAppleReconciliationReasonNewProfile, Checksum: []byte("c1")},
+			{HostUUID: "h1", ProfileID: 2, OperationType: fleet.MDMAppleOperationTypeRemove, Reason: fleet.MDMAppleReconciliationReasonProfileDeleted, Checksum: []byte("c2")},
+			{HostUUID: "h2", ProfileID: 1, OperationType: fleet.MDMAppleOperationTypeInstall, Reason: fleet.MDMAppleReconciliationReasonNewProfile, Checksum: []byte("c1")},
+		}, nil
+	}
+	// the installation of profile 1 affects 2 hosts across all teams, the
+	// removal of profile 2 too but it is approved
+	ds.ListMDMAppleProfilesToInstallFunc = func(ctx context.Context) ([]*fleet.MDMAppleProfilePayload, error) {
+		return []*fleet.MDMAppleProfilePayload{
+			{HostUUID: "h1", ProfileID: 1, Checksum: []byte("c1")},
+			{HostUUID: "h2", ProfileID: 1, Checksum: []byte("c1")},
+		}, nil
+	}
+	ds.ListMDMAppleProfilesToRemoveFunc = func(ctx context.Context) ([]*fleet.MDMAppleProfilePayload, error) {
+		return []*fleet.MDMAppleProfilePayload{
+			{HostUUID: "h1", ProfileID: 2, Checksum: []byte("c2")},
+			{HostUUID: "h3", ProfileID: 2, Checksum: []byte("c2")},
+		}, nil
+	}
+	ds.ListMDMAppleProfileChangesFunc = func(ctx context.Context, status fleet.MDMAppleProfileChangeStatus) ([]*fleet.MDMAppleProfileChange, error) {
+		require.Equal(t, fleet.MDMAppleProfileChangeApproved, status)
+		return []*fleet.MDMAppleProfileChange{
+			{ProfileID: 2, OperationType: fleet.MDMAppleOperationTypeRemove, Checksum: []byte("c2")},
+		}, nil
+	}
+	ds.ListMDMAppleHostProfilesWithQueuedCommandFunc = func(ctx context.Context, op fleet.MDMAppleOperationType, hostUUIDs []string) ([]*fleet.MDMAppleProfilePayload, error) {
+		if op == fleet.MDMAppleOperationTypeInstall {
+			require.Equal(t, []string{"h1", "h2"}, hostUUIDs)
+			return []*fleet.MDMAppleProfilePayload{{HostUUID: "h2", ProfileID: 1, Checksum: []byte("c1"), CommandUUID: "cmd"}}, nil
+		}
+		require.Equal(t, []string{"h1"}, hostUUIDs)
+		return nil, nil
+	}
+
+	// only global admins can see the plan
+	_, err := svc.GetMDMAppleReconciliationPlan(test.UserContext(ctx, test.UserMaintainer), nil)
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	_, err = svc.GetMDMAppleReconciliationPlan(test.UserContext(ctx, test.UserTeamAdminTeam1), ptr.Uint(1))
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+	require.False(t, ds.ListMDMAppleReconciliationPlanFuncInvoked)
+
+	items, err := svc.GetMDMAppleReconciliationPlan(test.UserContext(ctx, test.UserAdmin), ptr.Uint(1))
+	require.NoError(t, err)
+	require.True(t, ds.TeamFuncInvoked)
+	require.Len(t, items, 3)
+	require.True(t, items[0].HeldForApproval)
+	require.Empty(t, items[0].QueuedCommandUUID)
+	require.False(t, items[1].HeldForApproval)
+	require.Empty(t, items[1].QueuedCommandUUID)
+	require.True(t, items[2].HeldForApproval)
+	require.Equal(t, "cmd", items[2].QueuedCommandUUID)
+}
@@ -10,12 +10,14 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/fleetdm/fleet/v4/pkg/optjson"
 	"github.com/fleetdm/fleet/v4/pkg/spec"
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
 )
 
 // Client is used to consume Fleet APIs from Go code
@@ -260,6 +262,19 @@ func (c *Client) ApplyGroup(
 			logf(format, args...)
 		}
 	}
+
+	// the unmanaged MDM assets are always listed before applying the specs, so
+	// that the deletions can be reviewed with --dry-run.
+	var unmanaged []*unmanagedMDMAssets
+	if opts.DeleteUnmanaged {
+		var err error
+		unmanaged, err = c.findUnmanagedMDMAssets(specs, baseDir)
+		if err != nil {
+			return fmt.Errorf("finding unmanaged MDM assets: %w", err)
+		}
+		logUnmanagedMDMAssets(logfn, unmanaged, opts.DryRun)
+	}
+
 	if len(specs.Queries) > 0 {
 		if opts.DryRun {
 			logfn("[!] ignoring queries, dry run mode only supported for 'config' and 'team' specs\n")
@@ -322,9 +337,38 @@ func (c *Client) ApplyGroup(
 				}
 				fileContents[i] = b
 			}
-			if err := c.ApplyNoTeamProfiles(fileContents, opts); err != nil {
+			exclusions, err := resolveMacOSCustomSettingsExclusions(macosCustomSettings, fileContents,
+				extractAppCfgMacOSCustomSettingsExclusions(specs.AppConfig))
+			if err != nil {
+				return fmt.Errorf("applying fleet config: %w", err)
+			}
+			if err := c.ApplyNoTeamProfiles(fileContents, exclusions, opts); err != nil {
 				return fmt.Errorf("applying custom settings: %w", err)
 			}
+			if opts.DryRun {
+				preview, err := c.PreviewNoTeamProfiles(fileContents, exclusions, opts)
+				if err != nil {
+					return fmt.Errorf("previewing custom settings: %w", err)
+				}
+				logProfilesPreview(logfn, "", len(fileContents), len(exclusions), preview)
+			}
+		} else if len(extractAppCfgMacOSCustomSettingsExclusions(specs.AppConfig)) > 0 {
+			return errors.New("applying fleet config: custom_settings_exclusions requires custom_settings")
+		}
+		if allTeamsCustomSettings := extractAppCfgAllTeamsMacOSCustomSettings(specs.AppConfig); allTeamsCustomSettings != nil {
+			files := resolveApplyRelativePaths(baseDir, allTeamsCustomSettings)
+
+			fileContents := make([][]byte, len(files))
+			for i, f := range files {
+				b, err := os.ReadFile(f)
+				if err != nil {
+					return fmt.Errorf("applying fleet config: %w", err)
+				}
+				fileContents[i] = b
+			}
+			if err := c.ApplyAllTeamsProfiles(fileContents, opts); err != nil {
+				return fmt.Errorf("applying all teams custom settings: %w", err)
+			}
 		}
 		if macosSetup := extractAppCfgMacOSSetup(specs.AppConfig); macosSetup != nil {
 			if macosSetup.BootstrapPackage.Value != "" {
@@ -350,6 +394,17 @@ func (c *Client) ApplyGroup(
 					}
 				}
 			}
+			if macosSetup.EULA.Value != "" {
+				eula, err := c.validateEULA(baseDir, macosSetup.EULA.Value)
+				if err != nil {
+					return fmt.Errorf("applying fleet config: %w", err)
+				}
+				if !opts.DryRun {
+					if err := c.EnsureEULA(eula); err != nil {
+						return fmt.Errorf("applying fleet config: %w", err)
+					}
+				}
+			}
 		}
 		if err := c.ApplyAppConfig(specs.AppConfig, opts); err != nil {
 			return fmt.Errorf("applying fleet config: %w", err)
@@ -376,8 +431,10 @@ func (c *Client) ApplyGroup(
 		// extract the teams' custom settings and resolve the files immediately, so
 		// that any non-existing file error is found before applying the specs.
 		tmMacSettings := extractTmSpecsMacOSCustomSettings(specs.Teams)
+		tmMacSettingsExclusions := extractTmSpecsMacOSCustomSettingsExclusions(specs.Teams)
 
 		tmFileContents := make(map[string][][]byte, len(tmMacSettings))
+		tmExclusions := make(map[string][]fleet.MDMAppleProfileExclusionSpec, len(tmMacSettings))
 		for k, paths := range tmMacSettings {
 			files := resolveApplyRelativePaths(baseDir, paths)
 			fileContents := make([][]byte, len(files))
@@ -389,6 +446,17 @@ func (c *Client) ApplyGroup(
 				fileContents[i] = b
 			}
 			tmFileContents[k] = fileContents
+
+			exclusions, err := resolveMacOSCustomSettingsExclusions(paths, fileContents, tmMacSettingsExclusions[k])
+			if err != nil {
+				return fmt.Errorf("applying teams: team %q: %w", k, err)
+			}
+			tmExclusions[k] = exclusions
+		}
+		for k, excls := range tmMacSettingsExclusions {
+			if _, ok := tmMacSettings[k]; !ok && len(excls) > 0 {
+				return fmt.Errorf("applying teams: team %q: custom_settings_exclusions requires custom_settings", k)
+			}
 		}
 
 		tmMacSetup := extractTmSpecsMacOSSetup(specs.Teams)
@@ -419,9 +487,21 @@ func (c *Client) ApplyGroup(
 
 		if len(tmFileContents) > 0 {
 			for tmName, profs := range tmFileContents {
-				if err := c.ApplyTeamProfiles(tmName, profs, opts); err != nil {
+				if err := c.ApplyTeamProfiles(tmName, profs, tmExclusions[tmName], opts); err != nil {
 					return fmt.Errorf("applying custom settings for team %q: %w", tmName, err)
 				}
+				if opts.DryRun {
+					preview, err := c.PreviewTeamProfiles(tmName, profs, tmExclusions[tmName], opts)
+					if err != nil {
+						// the team does not exist yet in dry run mode if it is new
+						var nfe NotFoundErr
+						if errors.As(err, &nfe) {
+							continue
+						}
+						return fmt.Errorf("previewing custom settings for team %q: %w", tmName, err)
+					}
+					logProfilesPreview(logfn, tmName, len(profs), len(tmExclusions[tmName]), preview)
+				}
 			}
 		}
 		if len(tmBootstrapPackages)+len(tmMacSetupAssistants) > 0 && !opts.DryRun {
@@ -463,6 +543,13 @@ func (c *Client) ApplyGroup(
 			logfn("[+] applied user roles\n")
 		}
 	}
+
+	if len(unmanaged) > 0 && !opts.DryRun {
+		if err := c.deleteUnmanagedMDMAssets(unmanaged, opts); err != nil {
+			return fmt.Errorf("deleting unmanaged MDM assets: %w", err)
+		}
+		logfn("[+] deleted unmanaged MDM assets\n")
+	}
 	return nil
 }
 
@@ -481,9 +568,11 @@ func extractAppCfgMacOSSetup(appCfg any) *fleet.MacOSSetup {
 	}
 	bp, _ := mos["bootstrap_package"].(string) // if not a string, bp == ""
 	msa, _ := mos["macos_setup_assistant"].(string)
+	eula, _ := mos["eula"].(string)
 	return &fleet.MacOSSetup{
 		BootstrapPackage:    optjson.SetString(bp),
 		MacOSSetupAssistant: optjson.SetString(msa),
+		EULA:                optjson.SetString(eula),
 	}
 }
 
@@ -546,6 +635,110 @@ func extractAppCfgMacOSCustomSettings(appCfg interface{}) []string {
 	return csStrings
 }
 
+// extractAppCfgAllTeamsMacOSCustomSettings returns the paths of the profiles
+// that apply to all teams, as specified in
+// mdm.all_teams_macos_settings.custom_settings. It returns nil if the key is
+// not present, and an empty slice if it is present but empty.
+func extractAppCfgAllTeamsMacOSCustomSettings(appCfg interface{}) []string {
+	asMap, ok := appCfg.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	mmdm, ok := asMap["mdm"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	mos, ok := mmdm["all_teams_macos_settings"].(map[string]interface{})
+	if !ok || mos == nil {
+		return nil
+	}
+
+	cs, ok := mos["custom_settings"]
+	if !ok {
+		return nil
+	}
+
+	csAny, ok := cs.([]interface{})
+	if !ok || csAny == nil {
+		return []string{}
+	}
+
+	csStrings := make([]string, 0, len(csAny))
+	for _, v := range csAny {
+		s, _ := v.(string)
+		if s != "" {
+			csStrings = append(csStrings, s)
+		}
+	}
+	return csStrings
+}
+
+func extractAppCfgMacOSCustomSettingsExclusions(appCfg interface{}) []fleet.MacOSCustomSettingsExclusion {
+	asMap, ok := appCfg.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	mmdm, ok := asMap["mdm"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	mos, ok := mmdm["macos_settings"].(map[string]interface{})
+	if !ok || mos == nil {
+		return nil
+	}
+
+	v, ok := mos["custom_settings_exclusions"]
+	if !ok || v == nil {
+		return nil
+	}
+
+	// the exclusions are a list of objects, decode them as JSON. Any error is
+	// ignored, it will fail in the call to apply the app config.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var excls []fleet.MacOSCustomSettingsExclusion
+	_ = json.Unmarshal(b, &excls)
+	return excls
+}
+
+// resolveMacOSCustomSettingsExclusions returns the exclusions to send along
+// with the custom settings, with the excluded profile paths resolved to the
+// PayloadIdentifier of the corresponding profiles. The paths and contents are
+// those of the custom settings, in the same order.
+func resolveMacOSCustomSettingsExclusions(paths []string, contents [][]byte, excls []fleet.MacOSCustomSettingsExclusion) ([]fleet.MDMAppleProfileExclusionSpec, error) {
+	if len(excls) == 0 {
+		return nil, nil
+	}
+
+	specs := make([]fleet.MDMAppleProfileExclusionSpec, 0, len(excls))
+	for _, e := range excls {
+		idx := -1
+		for i, p := range paths {
+			if p == e.Profile {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("excluded profile %q is not in custom_settings", e.Profile)
+		}
+
+		parsed, err := mobileconfig.Mobileconfig(contents[idx]).ParseConfigProfile()
+		if err != nil {
+			return nil, fmt.Errorf("parsing excluded profile %q: %w", e.Profile, err)
+		}
+		specs = append(specs, fleet.MDMAppleProfileExclusionSpec{
+			ProfileIdentifier: parsed.PayloadIdentifier,
+			Labels:            e.Labels,
+			Hosts:             e.Hosts,
+			IdPGroups:         e.IdPGroups,
+		})
+	}
+	return specs, nil
+}
+
 // returns the custom settings keyed by team name.
 func extractTmSpecsMacOSCustomSettings(tmSpecs []json.RawMessage) map[string][]string {
 	var m map[string][]string
@@ -582,6 +775,32 @@ func extractTmSpecsMacOSCustomSettings(tmSpecs []json.RawMessage) map[string][]s
 	return m
 }
 
+// returns the custom settings exclusions keyed by team name.
+func extractTmSpecsMacOSCustomSettingsExclusions(tmSpecs []json.RawMessage) map[string][]fleet.MacOSCustomSettingsExclusion {
+	var m map[string][]fleet.MacOSCustomSettingsExclusion
+	for _, tm := range tmSpecs {
+		var spec struct {
+			Name string `json:"name"`
+			MDM  struct {
+				MacOSSettings struct {
+					CustomSettingsExclusions []fleet.MacOSCustomSettingsExclusion `json:"custom_settings_exclusions"`
+				} `json:"macos_settings"`
+			} `json:"mdm"`
+		}
+		if err := json.Unmarshal(tm, &spec); err != nil {
+			// ignore, this will fail in the call to apply team specs
+			continue
+		}
+		if spec.Name != "" && len(spec.MDM.MacOSSettings.CustomSettingsExclusions) > 0 {
+			if m == nil {
+				m = make(map[string][]fleet.MacOSCustomSettingsExclusion)
+			}
+			m[spec.Name] = spec.MDM.MacOSSettings.CustomSettingsExclusions
+		}
+	}
+	return m
+}
+
 // returns the macos_setup keyed by team name.
 func extractTmSpecsMacOSSetup(tmSpecs []json.RawMessage) map[string]*fleet.MacOSSetup {
 	var m map[string]*fleet.MacOSSetup
@@ -605,3 +824,199 @@ func extractTmSpecsMacOSSetup(tmSpecs []json.RawMessage) map[string]*fleet.MacOS
 	}
 	return m
 }
+
+// logProfilesPreview logs the number of hosts that would be affected by the
+// custom settings of a team (or no team if tmName is empty) in dry run mode.
+func logProfilesPreview(logfn func(format string, args ...interface{}), tmName string, count, exclusionsCount int, preview *fleet.MDMAppleProfilesPreview) {
+	if preview == nil {
+		return
+	}
+	scope := "no team"
+	if tmName != "" {
+		scope = fmt.Sprintf("team %q", tmName)
+	}
+	logfn("[+] would've applied %d custom settings for %s: %d of %d enrolled hosts would install profiles, %d would remove profiles\n",
+		count, scope, preview.InstallHosts, preview.EnrolledHosts, preview.RemoveHosts)
+	if exclusionsCount > 0 {
+		// the preview does not take the exclusions into account
+		logfn("[+] would've applied %d custom settings exclusions for %s\n", exclusionsCount, scope)
+	}
+}
+
+// unmanagedMDMAssets are the MDM assets of a team (or no team) that exist in
+// Fleet but are not in the applied specs.
+type unmanagedMDMAssets struct {
+	// teamName is empty for no team, in which case teamID is nil.
+	teamName string
+	teamID   *uint
+	// profiles are the configuration profiles that are not in the custom
+	// settings. If the specs have custom settings for the team, they are
+	// removed by applying them, otherwise deleteProfiles is true.
+	profiles       []*fleet.MDMAppleConfigProfile
+	deleteProfiles bool
+	// setupAssistant is the macOS setup assistant if the specs have none.
+	setupAssistant *fleet.MDMAppleSetupAssistant
+}
+
+// findUnmanagedMDMAssets returns the MDM assets of the teams targeted by the
+// specs that are not in the specs. No team is targeted by the config spec,
+// and the teams that don't exist yet have no assets.
+func (c *Client) findUnmanagedMDMAssets(specs *spec.Group, baseDir string) ([]*unmanagedMDMAssets, error) {
+	type target struct {
+		name           string
+		customSettings []string // nil if not in the specs
+		setupAssistant string
+	}
+
+	var targets []target
+	if specs.AppConfig != nil {
+		t := target{customSettings: extractAppCfgMacOSCustomSettings(specs.AppConfig)}
+		if setup := extractAppCfgMacOSSetup(specs.AppConfig); setup != nil {
+			t.setupAssistant = setup.MacOSSetupAssistant.Value
+		}
+		targets = append(targets, t)
+	}
+	if len(specs.Teams) > 0 {
+		tmCustomSettings := extractTmSpecsMacOSCustomSettings(specs.Teams)
+		for name, setup := range extractTmSpecsMacOSSetup(specs.Teams) {
+			targets = append(targets, target{
+				name:           name,
+				customSettings: tmCustomSettings[name],
+				setupAssistant: setup.MacOSSetupAssistant.Value,
+			})
+		}
+		sort.Slice(targets, func(i, j int) bool { return targets[i].name < targets[j].name })
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	appCfg, err := c.GetAppConfig()
+	if err != nil {
+		return nil, err
+	}
+	if !appCfg.MDM.EnabledAndConfigured {
+		// there are no MDM assets to delete
+		return nil, nil
+	}
+
+	teamIDs := make(map[string]uint)
+	if len(specs.Teams) > 0 {
+		tms, err := c.ListTeams("")
+		if err != nil {
+			return nil, err
+		}
+		for _, tm := range tms {
+			teamIDs[tm.Name] = tm.ID
+		}
+	}
+
+	var unmanaged []*unmanagedMDMAssets
+	for _, t := range targets {
+		u := &unmanagedMDMAssets{teamName: t.name, deleteProfiles: t.customSettings == nil}
+		if t.name != "" {
+			id, ok := teamIDs[t.name]
+			if !ok {
+				// the team is created by the specs
+				continue
+			}
+			u.teamID = &id
+		}
+
+		// the profiles are identified by their PayloadIdentifier, the files that
+		// can't be read or parsed fail when the specs are applied.
+		managed := make(map[string]bool, len(t.customSettings))
+		for _, f := range resolveApplyRelativePaths(baseDir, t.customSettings) {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				continue
+			}
+			if parsed, err := mobileconfig.Mobileconfig(b).ParseConfigProfile(); err == nil {
+				managed[parsed.PayloadIdentifier] = true
+			}
+		}
+		var listTeamID uint
+		if u.teamID != nil {
+			listTeamID = *u.teamID
+		}
+		profs, err := c.MDMAppleListConfigProfiles(listTeamID)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range profs {
+			if !managed[p.Identifier] {
+				u.profiles = append(u.profiles, p)
+			}
+		}
+
+		if t.setupAssistant == "" {
+			asst, err := c.MDMAppleGetSetupAssistant(u.teamID)
+			if err != nil {
+				var nfe NotFoundErr
+				if !errors.As(err, &nfe) {
+					return nil, err
+				}
+				asst = nil
+			}
+			u.setupAssistant = asst
+		}
+
+		if len(u.profiles) > 0 || u.setupAssistant != nil {
+			unmanaged = append(unmanaged, u)
+		}
+	}
+	return unmanaged, nil
+}
+
+// deleteUnmanagedMDMAssets deletes the unmanaged MDM assets that are not
+// already removed by applying the specs.
+func (c *Client) deleteUnmanagedMDMAssets(unmanaged []*unmanagedMDMAssets, opts fleet.ApplySpecOptions) error {
+	for _, u := range unmanaged {
+		if u.deleteProfiles && len(u.profiles) > 0 {
+			var err error
+			if u.teamName == "" {
+				err = c.ApplyNoTeamProfiles(nil, nil, opts)
+			} else {
+				err = c.ApplyTeamProfiles(u.teamName, nil, nil, opts)
+			}
+			if err != nil {
+				return fmt.Errorf("deleting profiles for %s: %w", unmanagedMDMAssetsScope(u), err)
+			}
+		}
+		if u.setupAssistant != nil {
+			if err := c.MDMAppleDeleteSetupAssistant(u.teamID); err != nil {
+				return fmt.Errorf("deleting macOS setup assistant for %s: %w", unmanagedMDMAssetsScope(u), err)
+			}
+		}
+	}
+	return nil
+}
+
+// logUnmanagedMDMAssets logs the unmanaged MDM assets that would be (or
+// are going to be) deleted.
+func logUnmanagedMDMAssets(logfn func(format string, args ...interface{}), unmanaged []*unmanagedMDMAssets, dryRun bool) {
+	verb := "deleting"
+	if dryRun {
+		verb = "would delete"
+	}
+	if len(unmanaged) == 0 {
+		logfn("[+] no unmanaged MDM assets to delete\n")
+		return
+	}
+	for _, u := range unmanaged {
+		scope := unmanagedMDMAssetsScope(u)
+		for _, p := range u.profiles {
+			logfn("[!] %s profile %q (%s) for %s, not in the specs\n", verb, p.Name, p.Identifier, scope)
+		}
+		if u.setupAssistant != nil {
+			logfn("[!] %s macOS setup assistant %q for %s, not in the specs\n", verb, u.setupAssistant.Name, scope)
+		}
+	}
+}
+
+func unmanagedMDMAssetsScope(u *unmanagedMDMAssets) string {
+	if u.teamName == "" {
+		return "no team"
+	}
+	return fmt.Sprintf("team %q", u.teamName)
+}
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"net/url"
+
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/kolide/kit/version"
 )
@@ -13,10 +15,38 @@ func (c *Client) ApplyAppConfig(payload interface{}, opts fleet.ApplySpecOptions
 }
 
 // ApplyNoTeamProfiles sends the list of profiles to be applied for the hosts
-// in no team.
-func (c *Client) ApplyNoTeamProfiles(profiles [][]byte, opts fleet.ApplySpecOptions) error {
+// in no team, along with the exclusions of hosts from those profiles.
+func (c *Client) ApplyNoTeamProfiles(profiles [][]byte, exclusions []fleet.MDMAppleProfileExclusionSpec, opts fleet.ApplySpecOptions) error {
+	verb, path := "POST", "/api/latest/fleet/mdm/apple/profiles/batch"
+	return c.authenticatedRequestWithQuery(map[string]interface{}{"profiles": profiles, "exclusions": exclusions}, verb, path, nil, opts.RawQuery())
+}
+
+// ApplyAllTeamsProfiles sends the list of profiles to be applied for the
+// hosts of all teams (and no team).
+func (c *Client) ApplyAllTeamsProfiles(profiles [][]byte, opts fleet.ApplySpecOptions) error {
+	verb, path := "POST", "/api/latest/fleet/mdm/apple/profiles/batch"
+	query, err := url.ParseQuery(opts.RawQuery())
+	if err != nil {
+		return err
+	}
+	query.Set("all_teams", "true")
+	return c.authenticatedRequestWithQuery(map[string]interface{}{"profiles": profiles}, verb, path, nil, query.Encode())
+}
+
+// PreviewNoTeamProfiles returns the number of hosts in no team that would be
+// affected if the list of profiles was applied, without applying it.
+func (c *Client) PreviewNoTeamProfiles(profiles [][]byte, exclusions []fleet.MDMAppleProfileExclusionSpec, opts fleet.ApplySpecOptions) (*fleet.MDMAppleProfilesPreview, error) {
 	verb, path := "POST", "/api/latest/fleet/mdm/apple/profiles/batch"
-	return c.authenticatedRequestWithQuery(map[string]interface{}{"profiles": profiles}, verb, path, nil, opts.RawQuery())
+	query, err := url.ParseQuery(opts.RawQuery())
+	if err != nil {
+		return nil, err
+	}
+	query.Set("preview", "true")
+	var responseBody batchSetMDMAppleProfilesResponse
+	if err := c.authenticatedRequestWithQuery(map[string]interface{}{"profiles": profiles, "exclusions": exclusions}, verb, path, &responseBody, query.Encode()); err != nil {
+		return nil, err
+	}
+	return responseBody.Preview, nil
 }
 
 // GetAppConfig fetches the application config from the server API
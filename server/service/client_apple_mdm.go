@@ -1,17 +1,22 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	apple_mdm "github.com/fleetdm/fleet/v4/server/mdm/apple"
 	"github.com/google/uuid"
 	"howett.net/plist"
 )
 
-func (c *Client) EnqueueCommand(deviceIDs []string, rawPlist []byte) (*fleet.CommandEnqueueResult, error) {
+func (c *Client) EnqueueCommand(deviceIDs []string, rawPlist []byte, priority fleet.MDMAppleCommandPriority) (*fleet.CommandEnqueueResult, error) {
 	var commandPayload map[string]interface{}
 	if _, err := plist.Unmarshal(rawPlist, &commandPayload); err != nil {
 		return nil, fmt.Errorf("The payload isn't valid XML. Please provide a file with valid XML: %w", err)
@@ -28,6 +33,7 @@ func (c *Client) EnqueueCommand(deviceIDs []string, rawPlist []byte) (*fleet.Com
 	request := enqueueMDMAppleCommandRequest{
 		Command:   base64.RawStdEncoding.EncodeToString(b),
 		DeviceIDs: deviceIDs,
+		Priority:  priority,
 	}
 	var response enqueueMDMAppleCommandResponse
 	if err := c.authenticatedRequest(request, "POST", "/api/latest/fleet/mdm/apple/enqueue", &response); err != nil {
@@ -51,7 +57,49 @@ func (c *Client) MDMAppleGetCommandResults(commandUUID string) ([]*fleet.MDMAppl
 	return responseBody.Results, nil
 }
 
+// MDMAppleLockHost locks the host and returns the PIN that unlocks it.
+func (c *Client) MDMAppleLockHost(hostID uint) (*fleet.HostMDMAppleLockPIN, error) {
+	verb, path := http.MethodPost, fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/lock", hostID)
+	var response deviceLockResponse
+	if err := c.authenticatedRequest(nil, verb, path, &response); err != nil {
+		return nil, err
+	}
+	return response.UnlockPIN, nil
+}
+
+// MDMAppleWipeHost wipes the host and returns the PIN that unlocks it if
+// Find My locks it after it is wiped.
+func (c *Client) MDMAppleWipeHost(hostID uint) (*fleet.HostMDMAppleLockPIN, error) {
+	verb, path := http.MethodPost, fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/wipe", hostID)
+	var response deviceWipeResponse
+	if err := c.authenticatedRequest(nil, verb, path, &response); err != nil {
+		return nil, err
+	}
+	return response.UnlockPIN, nil
+}
+
+// MDMAppleGetHostUnlockPIN returns the PIN of the latest lock or wipe of the
+// host.
+func (c *Client) MDMAppleGetHostUnlockPIN(hostID uint) (*fleet.HostMDMAppleLockPIN, error) {
+	verb, path := http.MethodGet, fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/unlock_pin", hostID)
+	var response getHostUnlockPINResponse
+	if err := c.authenticatedRequest(nil, verb, path, &response); err != nil {
+		return nil, err
+	}
+	return response.UnlockPIN, nil
+}
+
 func (c *Client) MDMAppleListCommands() ([]*fleet.MDMAppleCommand, error) {
+	return c.listMDMAppleCommands("")
+}
+
+// MDMAppleListCommandHosts returns the status of the command for each of the
+// hosts it targets, including the hosts that didn't respond yet.
+func (c *Client) MDMAppleListCommandHosts(commandUUID string) ([]*fleet.MDMAppleCommand, error) {
+	return c.listMDMAppleCommands(commandUUID)
+}
+
+func (c *Client) listMDMAppleCommands(commandUUID string) ([]*fleet.MDMAppleCommand, error) {
 	const defaultCommandsPerPage = 1000
 
 	verb, path := http.MethodGet, "/api/latest/fleet/mdm/apple/commands"
@@ -60,6 +108,9 @@ func (c *Client) MDMAppleListCommands() ([]*fleet.MDMAppleCommand, error) {
 	query.Set("per_page", fmt.Sprint(defaultCommandsPerPage))
 	query.Set("order_key", "updated_at")
 	query.Set("order_direction", "desc")
+	if commandUUID != "" {
+		query.Set("command_uuid", commandUUID)
+	}
 
 	var responseBody listMDMAppleCommandsResponse
 	err := c.authenticatedRequestWithQuery(nil, verb, path, &responseBody, query.Encode())
@@ -69,3 +120,180 @@ func (c *Client) MDMAppleListCommands() ([]*fleet.MDMAppleCommand, error) {
 
 	return responseBody.Results, nil
 }
+
+// MDMAppleGetTeamEnrollmentProfile downloads the reusable enrollment profile of
+// the team (or no team if teamID is nil).
+func (c *Client) MDMAppleGetTeamEnrollmentProfile(teamID *uint) ([]byte, error) {
+	verb, path := http.MethodGet, "/api/latest/fleet/mdm/apple/team_enrollment_profile"
+
+	query := url.Values{}
+	if teamID != nil {
+		query.Set("team_id", fmt.Sprint(*teamID))
+	}
+
+	response, err := c.AuthenticatedDo(verb, path, query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", verb, path, err)
+	}
+	defer response.Body.Close()
+
+	if err := c.parseResponse(verb, path, response, nil); err != nil {
+		return nil, err
+	}
+	profile, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	return profile, nil
+}
+
+// MDMAppleRotateTeamEnrollmentToken rotates the token embedded in the reusable
+// enrollment profile of the team (or no team if teamID is nil).
+func (c *Client) MDMAppleRotateTeamEnrollmentToken(teamID *uint) error {
+	verb, path := http.MethodPost, "/api/latest/fleet/mdm/apple/team_enrollment_profile/rotate"
+	request := rotateMDMAppleTeamEnrollmentTokenRequest{TeamID: teamID}
+	var response rotateMDMAppleTeamEnrollmentTokenResponse
+	return c.authenticatedRequest(request, verb, path, &response)
+}
+
+// MDMAppleUploadDEPTeamAssignments uploads the CSV file of serial numbers and
+// teams that the devices are assigned to when synced from Apple Business
+// Manager.
+func (c *Client) MDMAppleUploadDEPTeamAssignments(name string, contents []byte) (*fleet.MDMAppleDEPTeamAssignmentsResult, error) {
+	verb, path := http.MethodPost, "/api/latest/fleet/mdm/apple/dep/team_assignments"
+
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+
+	fw, err := w.CreateFormFile("csv", name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(fw, bytes.NewReader(contents)); err != nil {
+		return nil, err
+	}
+	w.Close()
+
+	response, err := c.doContextWithBodyAndHeaders(context.Background(), verb, path, "",
+		b.Bytes(),
+		map[string]string{
+			"Content-Type":  w.FormDataContentType(),
+			"Accept":        "application/json",
+			"Authorization": fmt.Sprintf("Bearer %s", c.token),
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("do multipart request: %w", err)
+	}
+
+	var uploadResponse uploadMDMAppleDEPTeamAssignmentsResponse
+	if err := c.parseResponse(verb, path, response, &uploadResponse); err != nil {
+		return nil, fmt.Errorf("parse response: %w", err)
+	}
+	return uploadResponse.MDMAppleDEPTeamAssignmentsResult, nil
+}
+
+// MDMAppleListConfigProfiles lists the configuration profiles of the team (or
+// no team if teamID is 0).
+func (c *Client) MDMAppleListConfigProfiles(teamID uint) ([]*fleet.MDMAppleConfigProfile, error) {
+	verb, path := http.MethodGet, "/api/latest/fleet/mdm/apple/profiles"
+	var response listMDMAppleConfigProfilesResponse
+	if err := c.authenticatedRequestWithQuery(nil, verb, path, &response, fmt.Sprintf("team_id=%d", teamID)); err != nil {
+		return nil, err
+	}
+	return response.ConfigProfiles, nil
+}
+
+// MDMAppleGetConfigProfileContents downloads the mobileconfig of the
+// configuration profile.
+func (c *Client) MDMAppleGetConfigProfileContents(profileID uint) ([]byte, error) {
+	verb, path := http.MethodGet, fmt.Sprintf("/api/latest/fleet/mdm/apple/profiles/%d", profileID)
+	response, err := c.AuthenticatedDo(verb, path, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", verb, path, err)
+	}
+	defer response.Body.Close()
+
+	if err := c.parseResponse(verb, path, response, nil); err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	return b, nil
+}
+
+// MDMAppleGetSetupAssistant returns the macOS setup assistant of the team (or
+// no team if teamID is nil).
+func (c *Client) MDMAppleGetSetupAssistant(teamID *uint) (*fleet.MDMAppleSetupAssistant, error) {
+	verb, path := http.MethodGet, "/api/latest/fleet/mdm/apple/enrollment_profile"
+
+	query := url.Values{}
+	if teamID != nil {
+		query.Set("team_id", fmt.Sprint(*teamID))
+	}
+
+	var response getMDMAppleSetupAssistantResponse
+	if err := c.authenticatedRequestWithQuery(nil, verb, path, &response, query.Encode()); err != nil {
+		return nil, err
+	}
+	return &response.MDMAppleSetupAssistant, nil
+}
+
+// MDMAppleDeleteSetupAssistant deletes the macOS setup assistant of the team
+// (or no team if teamID is nil).
+func (c *Client) MDMAppleDeleteSetupAssistant(teamID *uint) error {
+	verb, path := http.MethodDelete, "/api/latest/fleet/mdm/apple/enrollment_profile"
+
+	query := url.Values{}
+	if teamID != nil {
+		query.Set("team_id", fmt.Sprint(*teamID))
+	}
+	return c.authenticatedRequestWithQuery(nil, verb, path, nil, query.Encode())
+}
+
+// MDMAppleListProfilesSummaryByTeam returns the status of the configuration
+// profiles of the hosts, by team.
+func (c *Client) MDMAppleListProfilesSummaryByTeam() ([]*fleet.MDMAppleConfigProfilesTeamSummary, error) {
+	verb, path := http.MethodGet, "/api/latest/fleet/mdm/apple/profiles/summary/all"
+	var response listMDMAppleProfilesSummaryByTeamResponse
+	if err := c.authenticatedRequest(nil, verb, path, &response); err != nil {
+		return nil, err
+	}
+	return response.Teams, nil
+}
+
+// MDMAppleListDEPDevices returns the devices of the organization in Apple
+// Business Manager.
+func (c *Client) MDMAppleListDEPDevices() ([]fleet.MDMAppleDEPDevice, error) {
+	verb, path := http.MethodGet, "/api/latest/fleet/mdm/apple/dep/devices"
+	var response listMDMAppleDEPDevicesResponse
+	if err := c.authenticatedRequest(nil, verb, path, &response); err != nil {
+		return nil, err
+	}
+	return response.Devices, nil
+}
+
+// MDMAppleCheckSCEP checks that the SCEP service of the server is reachable
+// by requesting its CA certificate, as devices do when they enroll.
+func (c *Client) MDMAppleCheckSCEP() error {
+	verb, path := http.MethodGet, apple_mdm.SCEPPath
+	response, err := c.Do(verb, path, "operation=GetCACert", nil)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", verb, path, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: unexpected status %d", verb, path, response.StatusCode)
+	}
+	cert, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %w", err)
+	}
+	if len(cert) == 0 {
+		return fmt.Errorf("%s %s: empty CA certificate", verb, path)
+	}
+	return nil
+}
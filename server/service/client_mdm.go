@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -64,7 +66,20 @@ func (c *Client) DeleteBootstrapPackage(teamID uint) error {
 	return err
 }
 
+// bootstrapPackageChunkSize is the size of the chunks used to upload large
+// bootstrap packages via a resumable upload session. It is small enough to go
+// through proxies that limit the size of request bodies.
+const bootstrapPackageChunkSize = 8 << 20
+
+// bootstrapPackageChunkRetries is the number of times a chunk upload is
+// retried (resuming at the offset reported by the server) before giving up.
+const bootstrapPackageChunkRetries = 3
+
 func (c *Client) UploadBootstrapPackage(pkg *fleet.MDMAppleBootstrapPackage) error {
+	if len(pkg.Bytes) > bootstrapPackageChunkSize {
+		return c.uploadBootstrapPackageInChunks(pkg)
+	}
+
 	verb, path := "POST", "/api/latest/fleet/mdm/apple/bootstrap"
 
 	var b bytes.Buffer
@@ -106,6 +121,75 @@ func (c *Client) UploadBootstrapPackage(pkg *fleet.MDMAppleBootstrapPackage) err
 	return nil
 }
 
+func (c *Client) uploadBootstrapPackageInChunks(pkg *fleet.MDMAppleBootstrapPackage) error {
+	request := initiateBootstrapPackageUploadRequest{
+		TeamID: pkg.TeamID,
+		Name:   pkg.Name,
+		Size:   int64(len(pkg.Bytes)),
+		Sha256: hex.EncodeToString(pkg.Sha256),
+	}
+	var initResponse bootstrapPackageUploadResponse
+	if err := c.authenticatedRequest(request, "POST", "/api/latest/fleet/mdm/apple/bootstrap/uploads", &initResponse); err != nil {
+		return fmt.Errorf("initiating bootstrap package upload: %w", err)
+	}
+	path := "/api/latest/fleet/mdm/apple/bootstrap/uploads/" + url.PathEscape(initResponse.Token)
+
+	var offset int64
+	var retries int
+	for offset < int64(len(pkg.Bytes)) {
+		end := min(offset+bootstrapPackageChunkSize, int64(len(pkg.Bytes)))
+		next, err := c.appendBootstrapPackageChunk(path, offset, pkg.Bytes[offset:end])
+		if err != nil {
+			if retries >= bootstrapPackageChunkRetries {
+				return fmt.Errorf("uploading bootstrap package chunk: %w", err)
+			}
+			retries++
+
+			// the request may have failed after the server received the chunk,
+			// so resume from the offset reported by the server.
+			var statusResponse bootstrapPackageUploadResponse
+			if err := c.authenticatedRequest(nil, "GET", path, &statusResponse); err != nil {
+				return fmt.Errorf("getting bootstrap package upload: %w", err)
+			}
+			offset = statusResponse.Offset
+			continue
+		}
+		retries = 0
+		offset = next
+	}
+
+	if err := c.authenticatedRequest(nil, "POST", path+"/commit", &commitBootstrapPackageUploadResponse{}); err != nil {
+		return fmt.Errorf("committing bootstrap package upload: %w", err)
+	}
+	return nil
+}
+
+// appendBootstrapPackageChunk sends a chunk of a bootstrap package to the
+// upload session at path, and returns the offset at which the next chunk
+// must be sent.
+func (c *Client) appendBootstrapPackageChunk(path string, offset int64, chunk []byte) (int64, error) {
+	verb := "PATCH"
+	response, err := c.doContextWithBodyAndHeaders(context.Background(), verb, path,
+		fmt.Sprintf("offset=%d", offset),
+		chunk,
+		map[string]string{
+			"Content-Type":  "application/octet-stream",
+			"Accept":        "application/json",
+			"Authorization": fmt.Sprintf("Bearer %s", c.token),
+		},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("do chunk request: %w", err)
+	}
+	defer response.Body.Close()
+
+	var appendResponse bootstrapPackageUploadResponse
+	if err := c.parseResponse(verb, path, response, &appendResponse); err != nil {
+		return 0, fmt.Errorf("parse response: %w", err)
+	}
+	return appendResponse.Offset, nil
+}
+
 func (c *Client) EnsureBootstrapPackage(bp *fleet.MDMAppleBootstrapPackage, teamID uint) error {
 	isFirstTime := false
 	oldMeta, err := c.GetBootstrapPackageMetadata(teamID)
@@ -226,3 +310,149 @@ func (c *Client) uploadMacOSSetupAssistant(data []byte, teamID *uint, name strin
 	}
 	return c.authenticatedRequest(request, verb, path, nil)
 }
+
+func (c *Client) GetEULAMetadata() (*fleet.MDMAppleEULA, error) {
+	verb, path := "GET", "/api/latest/fleet/mdm/apple/setup/eula/metadata"
+	request := getMDMAppleEULAMetadataRequest{}
+	var responseBody getMDMAppleEULAMetadataResponse
+	err := c.authenticatedRequest(request, verb, path, &responseBody)
+	return responseBody.MDMAppleEULA, err
+}
+
+// DownloadEULA returns the contents of the EULA identified by token.
+func (c *Client) DownloadEULA(token string) ([]byte, error) {
+	verb, path := "GET", "/api/latest/fleet/mdm/apple/setup/eula/"+url.PathEscape(token)
+	response, err := c.AuthenticatedDo(verb, path, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", verb, path, err)
+	}
+	defer response.Body.Close()
+
+	if err := c.parseResponse(verb, path, response, nil); err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	return b, nil
+}
+
+func (c *Client) DeleteEULA(token string) error {
+	verb, path := "DELETE", "/api/latest/fleet/mdm/apple/setup/eula/"+url.PathEscape(token)
+	request := deleteMDMAppleEULARequest{}
+	var responseBody deleteMDMAppleEULAResponse
+	return c.authenticatedRequest(request, verb, path, &responseBody)
+}
+
+func (c *Client) UploadEULA(eula *fleet.MDMAppleEULA) error {
+	verb, path := "POST", "/api/latest/fleet/mdm/apple/setup/eula"
+
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+
+	fw, err := w.CreateFormFile("eula", eula.Name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, bytes.NewBuffer(eula.Bytes)); err != nil {
+		return err
+	}
+	w.Close()
+
+	response, err := c.doContextWithBodyAndHeaders(context.Background(), verb, path, "",
+		b.Bytes(),
+		map[string]string{
+			"Content-Type":  w.FormDataContentType(),
+			"Accept":        "application/json",
+			"Authorization": fmt.Sprintf("Bearer %s", c.token),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("do multipart request: %w", err)
+	}
+
+	var eulaResponse createMDMAppleEULAResponse
+	if err := c.parseResponse(verb, path, response, &eulaResponse); err != nil {
+		return fmt.Errorf("parse response: %w", err)
+	}
+
+	return nil
+}
+
+// EnsureEULA uploads the EULA unless it is identical to the one already
+// uploaded. As there can only be one EULA, a different one is deleted first.
+func (c *Client) EnsureEULA(eula *fleet.MDMAppleEULA) error {
+	oldMeta, err := c.GetEULAMetadata()
+	if err != nil {
+		// not found is OK, it means this is our first time uploading a EULA
+		if !errors.Is(err, notFoundErr{}) {
+			return fmt.Errorf("getting EULA metadata: %w", err)
+		}
+		oldMeta = nil
+	}
+
+	if oldMeta != nil {
+		// compare checksums, if they're equal then we can skip the upload.
+		if bytes.Equal(oldMeta.Sha256, eula.Sha256) {
+			return nil
+		}
+
+		if err := c.DeleteEULA(oldMeta.Token); err != nil {
+			return fmt.Errorf("deleting old EULA: %w", err)
+		}
+	}
+
+	return c.UploadEULA(eula)
+}
+
+// validateEULA reads the EULA from the http(s) URL or local path (relative to
+// baseDir) and checks that it is a PDF.
+func (c *Client) validateEULA(baseDir, location string) (*fleet.MDMAppleEULA, error) {
+	if err := c.CheckPremiumMDMEnabled(); err != nil {
+		return nil, err
+	}
+
+	var (
+		name    string
+		content []byte
+	)
+	if u, err := url.Parse(location); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		resp, err := http.Get(location) // nolint:gosec // we want this URL to be provided by the user. It will run on their machine.
+		if err != nil {
+			return nil, fmt.Errorf("downloading EULA: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.New("the URL to the eula doesn't exist. Please make this URL publicly accessible to the internet.")
+		}
+		if content, err = io.ReadAll(resp.Body); err != nil {
+			return nil, fmt.Errorf("downloading EULA: %w", err)
+		}
+		name = filepath.Base(u.Path)
+	} else {
+		location = resolveApplyRelativePath(baseDir, location)
+		if content, err = os.ReadFile(location); err != nil {
+			return nil, err
+		}
+		name = filepath.Base(location)
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "eula.pdf"
+	}
+
+	if err := file.CheckPDF(bytes.NewReader(content)); err != nil {
+		if errors.Is(err, file.ErrInvalidType) {
+			return nil, errors.New("Couldn’t edit eula. The file must be a PDF (.pdf).")
+		}
+		return nil, fmt.Errorf("checking EULA: %w", err)
+	}
+
+	checksum := sha256.Sum256(content)
+	return &fleet.MDMAppleEULA{
+		Name:   name,
+		Bytes:  content,
+		Sha256: checksum[:],
+	}, nil
+}
@@ -27,15 +27,32 @@ func (c *Client) ApplyTeams(specs []json.RawMessage, opts fleet.ApplySpecOptions
 }
 
 // ApplyTeamProfiles sends the list of profiles to be applied for the specified
-// team.
-func (c *Client) ApplyTeamProfiles(tmName string, profiles [][]byte, opts fleet.ApplySpecOptions) error {
+// team, along with the exclusions of hosts from those profiles.
+func (c *Client) ApplyTeamProfiles(tmName string, profiles [][]byte, exclusions []fleet.MDMAppleProfileExclusionSpec, opts fleet.ApplySpecOptions) error {
 	verb, path := "POST", "/api/latest/fleet/mdm/apple/profiles/batch"
 	query, err := url.ParseQuery(opts.RawQuery())
 	if err != nil {
 		return err
 	}
 	query.Add("team_name", tmName)
-	return c.authenticatedRequestWithQuery(map[string]interface{}{"profiles": profiles}, verb, path, nil, query.Encode())
+	return c.authenticatedRequestWithQuery(map[string]interface{}{"profiles": profiles, "exclusions": exclusions}, verb, path, nil, query.Encode())
+}
+
+// PreviewTeamProfiles returns the number of hosts in the specified team that
+// would be affected if the list of profiles was applied, without applying it.
+func (c *Client) PreviewTeamProfiles(tmName string, profiles [][]byte, exclusions []fleet.MDMAppleProfileExclusionSpec, opts fleet.ApplySpecOptions) (*fleet.MDMAppleProfilesPreview, error) {
+	verb, path := "POST", "/api/latest/fleet/mdm/apple/profiles/batch"
+	query, err := url.ParseQuery(opts.RawQuery())
+	if err != nil {
+		return nil, err
+	}
+	query.Add("team_name", tmName)
+	query.Set("preview", "true")
+	var responseBody batchSetMDMAppleProfilesResponse
+	if err := c.authenticatedRequestWithQuery(map[string]interface{}{"profiles": profiles, "exclusions": exclusions}, verb, path, &responseBody, query.Encode()); err != nil {
+		return nil, err
+	}
+	return responseBody.Preview, nil
 }
 
 // ApplyPolicies sends the list of Policies to be applied to the
@@ -2,8 +2,16 @@ package service
 
 import (
 	"context"
+	"html/template"
+	"time"
 
+	"github.com/fleetdm/fleet/v4/server/config"
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/mail"
+	"github.com/fleetdm/fleet/v4/server/worker"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 )
 
 // TriggerCronSchedule attempts to trigger an ad-hoc run of the named cron schedule.
@@ -13,3 +21,281 @@ func (svc *Service) TriggerCronSchedule(ctx context.Context, name string) error
 	}
 	return svc.cronSchedulesService.TriggerCronSchedule(name)
 }
+
+////////////////////////////////////////////////////////////////////////////////
+// GET /mdm/schedules
+////////////////////////////////////////////////////////////////////////////////
+
+type listMDMCronSchedulesResponse struct {
+	Schedules []*fleet.CronScheduleStatus `json:"schedules"`
+	Err       error                       `json:"error,omitempty"`
+}
+
+func (r listMDMCronSchedulesResponse) error() error { return r.Err }
+
+func listMDMCronSchedulesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	schedules, err := svc.ListMDMCronSchedules(ctx)
+	if err != nil {
+		return listMDMCronSchedulesResponse{Err: err}, nil
+	}
+	return listMDMCronSchedulesResponse{Schedules: schedules}, nil
+}
+
+// ListMDMCronSchedules returns the health status of the MDM cron schedules.
+func (svc *Service) ListMDMCronSchedules(ctx context.Context) ([]*fleet.CronScheduleStatus, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.CronSchedules{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	res := make([]*fleet.CronScheduleStatus, 0, len(fleet.MDMCronScheduleNames))
+	for _, name := range fleet.MDMCronScheduleNames {
+		status, err := svc.cronScheduleStatus(ctx, string(name))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, status)
+	}
+	return res, nil
+}
+
+func (svc *Service) cronScheduleStatus(ctx context.Context, name string) (*fleet.CronScheduleStatus, error) {
+	status := &fleet.CronScheduleStatus{Name: name}
+
+	latest, err := svc.ds.GetLatestCronStats(ctx, name)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get latest cron stats")
+	}
+	var lastScheduledAt time.Time
+	for _, stats := range latest {
+		if stats.Status == fleet.CronStatsStatusPending {
+			status.Running = true
+		}
+		if stats.StatsType == fleet.CronStatsTypeScheduled {
+			lastScheduledAt = stats.CreatedAt
+		}
+	}
+
+	completed, err := svc.ds.GetLatestCompletedCronStats(ctx, name)
+	if err != nil && !fleet.IsNotFound(err) {
+		return nil, ctxerr.Wrap(ctx, err, "get latest completed cron stats")
+	}
+	if completed != nil {
+		status.LastRun = &fleet.CronScheduleRun{
+			Type:            completed.StatsType,
+			Instance:        completed.Instance,
+			StartedAt:       completed.CreatedAt,
+			CompletedAt:     completed.UpdatedAt,
+			DurationSeconds: int64(completed.UpdatedAt.Sub(completed.CreatedAt).Seconds()),
+			Errors:          completed.Errors,
+		}
+	}
+
+	interval, ok := svc.cronSchedulesService.CronScheduleInterval(name)
+	if !ok {
+		return status, nil
+	}
+	status.Enabled = true
+	if !lastScheduledAt.IsZero() && interval > 0 {
+		// scheduled runs start at every interval since the last one, a run that
+		// is skipped (e.g. because another one is still pending) moves to the
+		// next interval.
+		next := lastScheduledAt.Add(interval)
+		if elapsed := time.Since(lastScheduledAt); elapsed > interval {
+			next = lastScheduledAt.Add((elapsed/interval + 1) * interval)
+		}
+		status.NextRunAt = &next
+	}
+	return status, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// POST /mdm/schedules/{name}/trigger
+////////////////////////////////////////////////////////////////////////////////
+
+type triggerMDMCronScheduleRequest struct {
+	Name string `url:"name"`
+}
+
+type triggerMDMCronScheduleResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r triggerMDMCronScheduleResponse) error() error { return r.Err }
+
+func triggerMDMCronScheduleEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*triggerMDMCronScheduleRequest)
+	if err := svc.TriggerMDMCronSchedule(ctx, req.Name); err != nil {
+		return triggerMDMCronScheduleResponse{Err: err}, nil
+	}
+	return triggerMDMCronScheduleResponse{}, nil
+}
+
+// TriggerMDMCronSchedule attempts to trigger an ad-hoc run of the named MDM cron schedule.
+func (svc *Service) TriggerMDMCronSchedule(ctx context.Context, name string) error {
+	if err := svc.authz.Authorize(ctx, &fleet.CronSchedules{}, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	if !fleet.IsMDMCronSchedule(name) {
+		return ctxerr.Wrap(ctx, newNotFoundError(), "unknown mdm schedule")
+	}
+	if _, ok := svc.cronSchedulesService.CronScheduleInterval(name); !ok {
+		return ctxerr.Wrap(ctx, badRequest("schedule is not enabled on this Fleet server"), "trigger mdm schedule")
+	}
+	return svc.cronSchedulesService.TriggerCronSchedule(name)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// MDM cron jobs health
+////////////////////////////////////////////////////////////////////////////////
+
+// mdmCronHealthMaxRuns is the maximum number of runs of an MDM cron schedule
+// loaded to count the consecutive failures of its jobs.
+const mdmCronHealthMaxRuns = 100
+
+// mdmCronFailureThreshold returns the configured number of consecutive failed
+// runs after which an MDM cron job is unhealthy, at least 1.
+func mdmCronFailureThreshold(cfg config.MDMConfig) int {
+	if cfg.CronFailureThreshold < 1 {
+		return 1
+	}
+	return cfg.CronFailureThreshold
+}
+
+// MDMCronHealth returns the health of the monitored MDM cron jobs. It is
+// empty if MDM is not configured.
+func (svc *Service) MDMCronHealth(ctx context.Context) ([]*fleet.MDMCronJobHealth, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.AppConfig{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get app config")
+	}
+	if !appCfg.MDM.EnabledAndConfigured {
+		return nil, nil
+	}
+
+	threshold := mdmCronFailureThreshold(svc.config.MDM)
+	res := make([]*fleet.MDMCronJobHealth, 0, len(fleet.MDMMonitoredCronJobs))
+	for _, job := range fleet.MDMMonitoredCronJobs {
+		runs, err := svc.ds.ListLatestCompletedCronStats(ctx, string(job.Schedule), mdmCronHealthMaxRuns)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "list latest completed cron stats")
+		}
+		res = append(res, fleet.NewMDMCronJobHealth(job, runs, threshold))
+	}
+	return res, nil
+}
+
+// mdmCronJobEventDetails are the details of the MDM events webhook events
+// delivered for the monitored MDM cron jobs.
+type mdmCronJobEventDetails struct {
+	Schedule            string `json:"schedule"`
+	Job                 string `json:"job"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	Error               string `json:"error,omitempty"`
+}
+
+// MonitorMDMCronJob wraps the function of a monitored MDM cron job so that,
+// after each run, reaching the failure threshold and recovering from it are
+// notified via the MDM events webhook and by email to the global admins. The
+// error of fn is returned as-is, the notification errors are only logged.
+func MonitorMDMCronJob(
+	ds fleet.Datastore,
+	mailService fleet.MailService,
+	cfg config.FleetConfig,
+	logger kitlog.Logger,
+	job fleet.MDMMonitoredCronJob,
+	fn func(context.Context) error,
+) func(context.Context) error {
+	return func(ctx context.Context) error {
+		jobErr := fn(ctx)
+		if err := notifyMDMCronJobHealth(ctx, ds, mailService, cfg, logger, job, jobErr); err != nil {
+			level.Error(logger).Log("msg", "notify mdm cron job health", "job", job.Job, "err", err)
+			ctxerr.Handle(ctx, err)
+		}
+		return jobErr
+	}
+}
+
+func notifyMDMCronJobHealth(
+	ctx context.Context,
+	ds fleet.Datastore,
+	mailService fleet.MailService,
+	cfg config.FleetConfig,
+	logger kitlog.Logger,
+	job fleet.MDMMonitoredCronJob,
+	jobErr error,
+) error {
+	// the current run is still pending, its outcome and the previous runs tell
+	// if it reaches the threshold (which is notified only once) or ends a
+	// failure streak that was notified.
+	threshold := mdmCronFailureThreshold(cfg.MDM)
+	prev, err := ds.ListLatestCompletedCronStats(ctx, string(job.Schedule), threshold)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list latest completed cron stats")
+	}
+	prevHealth := fleet.NewMDMCronJobHealth(job, prev, threshold)
+
+	details := mdmCronJobEventDetails{Schedule: string(job.Schedule), Job: job.Job}
+	var eventType fleet.MDMWebhookEventType
+	switch {
+	case jobErr != nil && prevHealth.ConsecutiveFailures == threshold-1:
+		eventType = fleet.MDMWebhookEventCronJobFailing
+		details.ConsecutiveFailures = threshold
+		details.Error = jobErr.Error()
+		level.Warn(logger).Log("msg", "mdm cron job reached the failure threshold", "job", job.Job, "consecutive_failures", threshold, "err", jobErr)
+	case jobErr == nil && !prevHealth.Healthy:
+		eventType = fleet.MDMWebhookEventCronJobRecovered
+		level.Info(logger).Log("msg", "mdm cron job recovered", "job", job.Job)
+	default:
+		return nil
+	}
+
+	if err := worker.QueueMDMWebhookEvent(ctx, ds, logger, eventType, "", details); err != nil {
+		return ctxerr.Wrap(ctx, err, "queue mdm cron job webhook event")
+	}
+
+	appCfg, err := ds.AppConfig(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get app config")
+	}
+	if mailService == nil || (!appCfg.SMTPSettings.SMTPConfigured && cfg.Email.EmailBackend == "") {
+		return nil
+	}
+	users, err := ds.ListUsers(ctx, fleet.UserListOptions{})
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list users")
+	}
+	var admins []string
+	for _, u := range users {
+		if !u.APIOnly && u.GlobalRole != nil && *u.GlobalRole == fleet.RoleAdmin {
+			admins = append(admins, u.Email)
+		}
+	}
+	if len(admins) == 0 {
+		return nil
+	}
+
+	subject := "An MDM background job is failing in Fleet"
+	if eventType == fleet.MDMWebhookEventCronJobRecovered {
+		subject = "An MDM background job recovered in Fleet"
+	}
+	err = mailService.SendEmail(fleet.Email{
+		Subject: subject,
+		To:      admins,
+		Config:  appCfg,
+		Mailer: &mail.MDMCronJobFailureMailer{
+			BaseURL:             template.URL(appCfg.ServerSettings.ServerURL + cfg.Server.URLPrefix),
+			AssetURL:            getAssetURL(),
+			Schedule:            details.Schedule,
+			Job:                 details.Job,
+			ConsecutiveFailures: details.ConsecutiveFailures,
+			Error:               details.Error,
+			Recovered:           eventType == fleet.MDMWebhookEventCronJobRecovered,
+		},
+	})
+	return ctxerr.Wrap(ctx, err, "send mdm cron job email")
+}
@@ -2,16 +2,20 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/fleetdm/fleet/v4/server/authz"
+	"github.com/fleetdm/fleet/v4/server/config"
 	"github.com/fleetdm/fleet/v4/server/contexts/viewer"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/mock"
 	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/service/schedule"
+	kitlog "github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/require"
 )
 
@@ -140,3 +144,231 @@ func TestCronSchedulesService(t *testing.T) {
 	time.Sleep(1500 * time.Millisecond)
 	require.Equal(t, uint32(3), atomic.LoadUint32(&jobsDone)) // 2 regularly scheduled (at 3s and 6s) plus 1 triggered
 }
+
+func TestMDMCronSchedules(t *testing.T) {
+	ds := new(mock.Store)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	ds.GetLatestCronStatsFunc = func(ctx context.Context, name string) ([]fleet.CronStats, error) {
+		if name != string(fleet.CronMDMAppleProfileManager) {
+			return []fleet.CronStats{}, nil
+		}
+		return []fleet.CronStats{
+			{ID: 2, Name: name, StatsType: fleet.CronStatsTypeScheduled, Status: fleet.CronStatsStatusCompleted, CreatedAt: now.Add(-10 * time.Second), UpdatedAt: now.Add(-8 * time.Second)},
+			{ID: 3, Name: name, StatsType: fleet.CronStatsTypeTriggered, Status: fleet.CronStatsStatusPending, CreatedAt: now, UpdatedAt: now},
+		}, nil
+	}
+	ds.GetLatestCompletedCronStatsFunc = func(ctx context.Context, name string) (*fleet.CronStats, error) {
+		if name != string(fleet.CronMDMAppleProfileManager) {
+			return nil, &notFoundError{}
+		}
+		return &fleet.CronStats{
+			ID: 2, Name: name, Instance: "id", StatsType: fleet.CronStatsTypeScheduled, Status: fleet.CronStatsStatusCompleted,
+			CreatedAt: now.Add(-10 * time.Second), UpdatedAt: now.Add(-8 * time.Second),
+			Errors: fleet.CronScheduleErrors{"manage_profiles": "failed"},
+		}, nil
+	}
+
+	svc, ctx := newTestService(t, ds, nil, nil, &TestServerOpts{StartCronSchedules: []TestNewScheduleFunc{
+		func(ctx context.Context, ds fleet.Datastore) fleet.NewCronScheduleFunc {
+			return func() (fleet.CronSchedule, error) {
+				s := schedule.New(
+					ctx, string(fleet.CronMDMAppleProfileManager), "id", 1*time.Minute, schedule.NopLocker{}, schedule.NopStatsStore{},
+					schedule.WithJob("manage_profiles", func(ctx context.Context) error {
+						return nil
+					}),
+				)
+				return s, nil
+			}
+		},
+	}})
+
+	t.Run("authorization", func(t *testing.T) {
+		for _, user := range []*fleet.User{
+			{GlobalRole: ptr.String(fleet.RoleMaintainer)},
+			{GlobalRole: ptr.String(fleet.RoleObserver)},
+			{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleAdmin}}},
+		} {
+			ctx := viewer.NewContext(ctx, viewer.Viewer{User: user})
+			_, err := svc.ListMDMCronSchedules(ctx)
+			require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+			err = svc.TriggerMDMCronSchedule(ctx, string(fleet.CronMDMAppleProfileManager))
+			require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+		}
+	})
+
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}})
+
+	t.Run("list", func(t *testing.T) {
+		schedules, err := svc.ListMDMCronSchedules(ctx)
+		require.NoError(t, err)
+		require.Len(t, schedules, 2)
+
+		profiles := schedules[0]
+		require.Equal(t, string(fleet.CronMDMAppleProfileManager), profiles.Name)
+		require.True(t, profiles.Enabled)
+		require.True(t, profiles.Running)
+		require.NotNil(t, profiles.LastRun)
+		require.Equal(t, fleet.CronStatsTypeScheduled, profiles.LastRun.Type)
+		require.Equal(t, int64(2), profiles.LastRun.DurationSeconds)
+		require.Equal(t, fleet.CronScheduleErrors{"manage_profiles": "failed"}, profiles.LastRun.Errors)
+		require.NotNil(t, profiles.NextRunAt)
+		require.Equal(t, now.Add(50*time.Second), *profiles.NextRunAt)
+
+		dep := schedules[1]
+		require.Equal(t, string(fleet.CronAppleMDMDEPProfileAssigner), dep.Name)
+		require.False(t, dep.Enabled)
+		require.False(t, dep.Running)
+		require.Nil(t, dep.LastRun)
+		require.Nil(t, dep.NextRunAt)
+	})
+
+	t.Run("trigger", func(t *testing.T) {
+		require.NoError(t, svc.TriggerMDMCronSchedule(ctx, string(fleet.CronMDMAppleProfileManager)))
+
+		// not an mdm schedule
+		err := svc.TriggerMDMCronSchedule(ctx, string(fleet.CronVulnerabilities))
+		require.True(t, fleet.IsNotFound(err))
+
+		// mdm schedule not enabled on this server
+		err = svc.TriggerMDMCronSchedule(ctx, string(fleet.CronAppleMDMDEPProfileAssigner))
+		require.ErrorContains(t, err, "schedule is not enabled")
+	})
+}
+
+func TestMDMCronHealth(t *testing.T) {
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil)
+
+	mdmEnabled := false
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{MDM: fleet.MDM{EnabledAndConfigured: mdmEnabled}}, nil
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	ds.ListLatestCompletedCronStatsFunc = func(ctx context.Context, name string, limit int) ([]fleet.CronStats, error) {
+		if name != string(fleet.CronAppleMDMDEPProfileAssigner) {
+			return []fleet.CronStats{{ID: 1}}, nil
+		}
+		return []fleet.CronStats{
+			{ID: 5, UpdatedAt: now, Errors: fleet.CronScheduleErrors{"dep_syncer": "token expired"}},
+			{ID: 4, UpdatedAt: now.Add(-time.Minute), Errors: fleet.CronScheduleErrors{"dep_syncer": "token expired"}},
+			{ID: 3, UpdatedAt: now.Add(-2 * time.Minute), Errors: fleet.CronScheduleErrors{"dep_syncer": "timeout"}},
+			{ID: 2, UpdatedAt: now.Add(-3 * time.Minute)},
+			{ID: 1, UpdatedAt: now.Add(-4 * time.Minute), Errors: fleet.CronScheduleErrors{"dep_syncer": "timeout"}},
+		}, nil
+	}
+
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleObserver)}})
+
+	// empty if MDM is not configured
+	health, err := svc.MDMCronHealth(ctx)
+	require.NoError(t, err)
+	require.Empty(t, health)
+	require.False(t, ds.ListLatestCompletedCronStatsFuncInvoked)
+
+	mdmEnabled = true
+	health, err = svc.MDMCronHealth(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []*fleet.MDMCronJobHealth{
+		{
+			Schedule: string(fleet.CronAppleMDMDEPProfileAssigner), Job: "dep_syncer", Healthy: false,
+			ConsecutiveFailures: 3, LastError: "token expired", LastFailedAt: &now,
+		},
+		{Schedule: string(fleet.CronMDMAppleProfileManager), Job: "manage_profiles", Healthy: true},
+	}, health)
+}
+
+func TestMonitorMDMCronJob(t *testing.T) {
+	ds := new(mock.Store)
+	ctx := context.Background()
+
+	smtpConfigured := true
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{
+			ServerSettings:  fleet.ServerSettings{ServerURL: "https://example.com"},
+			SMTPSettings:    fleet.SMTPSettings{SMTPConfigured: smtpConfigured},
+			WebhookSettings: fleet.WebhookSettings{MDMEventsWebhook: fleet.MDMEventsWebhookSettings{Enable: true, DestinationURL: "https://example.com/hook"}},
+		}, nil
+	}
+	ds.ListUsersFunc = func(ctx context.Context, opt fleet.UserListOptions) ([]*fleet.User, error) {
+		return []*fleet.User{
+			{Email: "admin@example.com", GlobalRole: ptr.String(fleet.RoleAdmin)},
+			{Email: "api@example.com", GlobalRole: ptr.String(fleet.RoleAdmin), APIOnly: true},
+			{Email: "observer@example.com", GlobalRole: ptr.String(fleet.RoleObserver)},
+			{Email: "team-admin@example.com", Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleAdmin}}},
+		}, nil
+	}
+
+	// the completed runs of the schedule, from the most recent
+	var runs []fleet.CronStats
+	ds.ListLatestCompletedCronStatsFunc = func(ctx context.Context, name string, limit int) ([]fleet.CronStats, error) {
+		require.Equal(t, string(fleet.CronAppleMDMDEPProfileAssigner), name)
+		if len(runs) < limit {
+			return runs, nil
+		}
+		return runs[:limit], nil
+	}
+	var events []fleet.MDMWebhookEvent
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		var args struct {
+			Event fleet.MDMWebhookEvent `json:"event"`
+		}
+		require.NoError(t, json.Unmarshal(*job.Args, &args))
+		events = append(events, args.Event)
+		return job, nil
+	}
+	var emails []fleet.Email
+	mailer := &mockMailService{SendEmailFn: func(e fleet.Email) error {
+		emails = append(emails, e)
+		return nil
+	}}
+
+	var cfg config.FleetConfig
+	cfg.MDM.CronFailureThreshold = 2
+	var jobErr error
+	fn := MonitorMDMCronJob(ds, mailer, cfg, kitlog.NewNopLogger(),
+		fleet.MDMMonitoredCronJob{Schedule: fleet.CronAppleMDMDEPProfileAssigner, Job: "dep_syncer"},
+		func(ctx context.Context) error { return jobErr },
+	)
+	run := func(err error) {
+		jobErr = err
+		require.Equal(t, err, fn(ctx))
+		var errs fleet.CronScheduleErrors
+		if err != nil {
+			errs = fleet.CronScheduleErrors{"dep_syncer": err.Error()}
+		}
+		runs = append([]fleet.CronStats{{ID: len(runs) + 1, Errors: errs}}, runs...)
+	}
+
+	// a single failure is below the threshold
+	run(nil)
+	run(errors.New("token expired"))
+	require.Empty(t, events)
+	require.Empty(t, emails)
+
+	// the threshold is notified once
+	run(errors.New("token expired"))
+	run(errors.New("token expired"))
+	require.Len(t, events, 1)
+	require.Equal(t, fleet.MDMWebhookEventCronJobFailing, events[0].Type)
+	require.Empty(t, events[0].HostUUID)
+	require.JSONEq(t, `{"schedule": "apple_mdm_dep_profile_assigner", "job": "dep_syncer", "consecutive_failures": 2, "error": "token expired"}`, string(events[0].Details))
+	require.Len(t, emails, 1)
+	require.Equal(t, []string{"admin@example.com"}, emails[0].To)
+	require.Equal(t, "An MDM background job is failing in Fleet", emails[0].Subject)
+
+	// the recovery is notified once
+	run(nil)
+	run(nil)
+	require.Len(t, events, 2)
+	require.Equal(t, fleet.MDMWebhookEventCronJobRecovered, events[1].Type)
+	require.Len(t, emails, 2)
+	require.Equal(t, "An MDM background job recovered in Fleet", emails[1].Subject)
+
+	// no email without SMTP
+	smtpConfigured = false
+	run(errors.New("timeout"))
+	run(errors.New("timeout"))
+	require.Len(t, events, 3)
+	require.Len(t, emails, 2)
+}
@@ -50,6 +50,9 @@ func (svc *Service) DisableAuthForPing(ctx context.Context) {
 type fleetDesktopResponse struct {
 	Err             error `json:"error,omitempty"`
 	FailingPolicies *uint `json:"failing_policies_count,omitempty"`
+	// MigrationMessage is the localized message displayed to the end user
+	// when the migration to Fleet's MDM of the host's team is enabled.
+	MigrationMessage string `json:"migration_message,omitempty"`
 }
 
 func (r fleetDesktopResponse) error() error { return r.Err }
@@ -77,7 +80,16 @@ func getFleetDesktopEndpoint(ctx context.Context, request interface{}, svc fleet
 		return fleetDesktopResponse{Err: err}, nil
 	}
 
-	return fleetDesktopResponse{FailingPolicies: &r}, nil
+	var migrationMessage string
+	if host.Platform == "darwin" && host.TeamID != nil {
+		strs, err := svc.GetMDMEndUserStrings(ctx, host.TeamID)
+		if err != nil {
+			return fleetDesktopResponse{Err: err}, nil
+		}
+		migrationMessage = strs.MigrationMessage
+	}
+
+	return fleetDesktopResponse{FailingPolicies: &r, MigrationMessage: migrationMessage}, nil
 }
 
 /////////////////////////////////////////////////////////////////////////////////
@@ -361,13 +373,19 @@ func (r *getDeviceMDMManualEnrollProfileRequest) deviceAuthToken() string {
 }
 
 type getDeviceMDMManualEnrollProfileResponse struct {
-	// Profile field is used in hijackRender for the response.
+	// Profile and CDNURL fields are used in hijackRender for the response.
 	Profile []byte
+	CDNURL  string
 
 	Err error `json:"error,omitempty"`
 }
 
 func (r getDeviceMDMManualEnrollProfileResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
+	if r.CDNURL != "" {
+		w.Header().Set("Location", r.CDNURL)
+		w.WriteHeader(http.StatusFound)
+		return
+	}
 	// make the browser download the content to a file
 	w.Header().Add("Content-Disposition", `attachment; filename="fleet-mdm-enrollment-profile.mobileconfig"`)
 	// explicitly set the content length before the write, so the caller can
@@ -397,7 +415,11 @@ func getDeviceMDMManualEnrollProfileEndpoint(ctx context.Context, request interf
 	if err != nil {
 		return getDeviceMDMManualEnrollProfileResponse{Err: err}, nil
 	}
-	return getDeviceMDMManualEnrollProfileResponse{Profile: profile}, nil
+	cdnURL, err := svc.MDMAppleEnrollmentProfileCDNURL(ctx, profile)
+	if err != nil {
+		return getDeviceMDMManualEnrollProfileResponse{Err: err}, nil
+	}
+	return getDeviceMDMManualEnrollProfileResponse{Profile: profile, CDNURL: cdnURL}, nil
 }
 
 func (svc *Service) GetDeviceMDMAppleEnrollmentProfile(ctx context.Context) ([]byte, error) {
@@ -411,11 +433,21 @@ func (svc *Service) GetDeviceMDMAppleEnrollmentProfile(ctx context.Context) ([]b
 		return nil, ctxerr.Wrap(ctx, err)
 	}
 
+	var teamID *uint
+	if host, ok := hostctx.FromContext(ctx); ok {
+		teamID = host.TeamID
+	}
+	strs, err := svc.mdmEndUserStrings(ctx, appConfig, teamID)
+	if err != nil {
+		return nil, err
+	}
+
 	mobileConfig, err := apple_mdm.GenerateEnrollmentProfileMobileconfig(
 		appConfig.OrgInfo.OrgName,
 		appConfig.ServerSettings.ServerURL,
 		svc.config.MDM.AppleSCEPChallenge,
 		svc.mdmPushCertTopic,
+		strs,
 	)
 	if err != nil {
 		return nil, ctxerr.Wrap(ctx, err)
@@ -18,13 +18,14 @@ import (
 /////////////////////////////////////////////////////////////////////////////////
 
 type globalPolicyRequest struct {
-	QueryID     *uint  `json:"query_id"`
-	Query       string `json:"query"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Resolution  string `json:"resolution"`
-	Platform    string `json:"platform"`
-	Critical    bool   `json:"critical" premium:"true"`
+	QueryID       *uint                     `json:"query_id"`
+	Query         string                    `json:"query"`
+	Name          string                    `json:"name"`
+	Description   string                    `json:"description"`
+	Resolution    string                    `json:"resolution"`
+	Platform      string                    `json:"platform"`
+	Critical      bool                      `json:"critical" premium:"true"`
+	MDMConditions fleet.PolicyMDMConditions `json:"mdm_conditions"`
 }
 
 type globalPolicyResponse struct {
@@ -37,13 +38,14 @@ func (r globalPolicyResponse) error() error { return r.Err }
 func globalPolicyEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
 	req := request.(*globalPolicyRequest)
 	resp, err := svc.NewGlobalPolicy(ctx, fleet.PolicyPayload{
-		QueryID:     req.QueryID,
-		Query:       req.Query,
-		Name:        req.Name,
-		Description: req.Description,
-		Resolution:  req.Resolution,
-		Platform:    req.Platform,
-		Critical:    req.Critical,
+		QueryID:       req.QueryID,
+		Query:         req.Query,
+		Name:          req.Name,
+		Description:   req.Description,
+		Resolution:    req.Resolution,
+		Platform:      req.Platform,
+		Critical:      req.Critical,
+		MDMConditions: req.MDMConditions,
 	})
 	if err != nil {
 		return globalPolicyResponse{Err: err}, nil
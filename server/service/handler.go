@@ -10,10 +10,12 @@ import (
 	"regexp"
 
 	"github.com/fleetdm/fleet/v4/server/config"
+	"github.com/fleetdm/fleet/v4/server/contexts/locale"
 	"github.com/fleetdm/fleet/v4/server/contexts/logging"
 	"github.com/fleetdm/fleet/v4/server/contexts/publicip"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	apple_mdm "github.com/fleetdm/fleet/v4/server/mdm/apple"
+	microsoft_mdm "github.com/fleetdm/fleet/v4/server/mdm/microsoft"
 	"github.com/fleetdm/fleet/v4/server/service/middleware/authzcheck"
 	"github.com/fleetdm/fleet/v4/server/service/middleware/mdmconfigured"
 	"github.com/fleetdm/fleet/v4/server/service/middleware/ratelimit"
@@ -146,7 +148,16 @@ func MakeHandler(
 		}
 	}
 
+	clientCertHeader, clientCertProxies, err := config.MDM.MicrosoftClientCertProxies()
+	if err != nil {
+		// the configuration is validated when the server starts, the forwarded
+		// client certificates are ignored if it is invalid.
+		level.Error(logger).Log("msg", "invalid Windows MDM client certificate configuration", "err", err)
+		clientCertHeader = ""
+	}
+	r.Use(clientCertificates(clientCertHeader, clientCertProxies))
 	r.Use(publicIP)
+	r.Use(acceptLanguage)
 
 	attachFleetAPIRoutes(r, svc, config, logger, limitStore, fleetAPIOptions, eopts)
 	addMetrics(r)
@@ -164,6 +175,14 @@ func publicIP(handler http.Handler) http.Handler {
 	})
 }
 
+// acceptLanguage stores the languages accepted by the client in the context,
+// to localize the strings rendered to the end users.
+func acceptLanguage(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(locale.NewContext(r.Context(), r.Header.Get("Accept-Language"))))
+	})
+}
+
 // PrometheusMetricsHandler wraps the provided handler with prometheus metrics
 // middleware and returns the resulting handler that should be mounted for that
 // route.
@@ -269,6 +288,7 @@ func attachFleetAPIRoutes(r *mux.Router, svc fleet.Service, config config.FleetC
 	ue.PATCH("/api/_version_/fleet/config", modifyAppConfigEndpoint, modifyAppConfigRequest{})
 	ue.POST("/api/_version_/fleet/spec/enroll_secret", applyEnrollSecretSpecEndpoint, applyEnrollSecretSpecRequest{})
 	ue.GET("/api/_version_/fleet/spec/enroll_secret", getEnrollSecretSpecEndpoint, nil)
+	ue.GET("/api/_version_/fleet/fleetd_profile", getFleetdProfileEndpoint, getFleetdProfileRequest{})
 	ue.GET("/api/_version_/fleet/version", versionEndpoint, nil)
 
 	ue.POST("/api/_version_/fleet/users/roles/spec", applyUserRoleSpecsEndpoint, applyUserRoleSpecsRequest{})
@@ -280,6 +300,8 @@ func attachFleetAPIRoutes(r *mux.Router, svc fleet.Service, config config.FleetC
 	ue.GET("/api/_version_/fleet/teams/{id:[0-9]+}", getTeamEndpoint, getTeamRequest{})
 	ue.PATCH("/api/_version_/fleet/teams/{id:[0-9]+}", modifyTeamEndpoint, modifyTeamRequest{})
 	ue.DELETE("/api/_version_/fleet/teams/{id:[0-9]+}", deleteTeamEndpoint, deleteTeamRequest{})
+	ue.GET("/api/_version_/fleet/teams/{id:[0-9]+}/deletion_report", getTeamDeletionReportEndpoint, getTeamDeletionReportRequest{})
+	ue.GET("/api/_version_/fleet/teams/deletions/{job_id:[0-9]+}", getTeamDeletionProgressEndpoint, getTeamDeletionProgressRequest{})
 	ue.POST("/api/_version_/fleet/teams/{id:[0-9]+}/agent_options", modifyTeamAgentOptionsEndpoint, modifyTeamAgentOptionsRequest{})
 	ue.GET("/api/_version_/fleet/teams/{id:[0-9]+}/users", listTeamUsersEndpoint, listTeamUsersRequest{})
 	ue.PATCH("/api/_version_/fleet/teams/{id:[0-9]+}/users", addTeamUsersEndpoint, modifyTeamUsersRequest{})
@@ -367,9 +389,30 @@ func attachFleetAPIRoutes(r *mux.Router, svc fleet.Service, config config.FleetC
 	ue.POST("/api/_version_/fleet/hosts/transfer/filter", addHostsToTeamByFilterEndpoint, addHostsToTeamByFilterRequest{})
 	ue.POST("/api/_version_/fleet/hosts/{id:[0-9]+}/refetch", refetchHostEndpoint, refetchHostRequest{})
 	ue.GET("/api/_version_/fleet/hosts/{id:[0-9]+}/device_mapping", listHostDeviceMappingEndpoint, listHostDeviceMappingRequest{})
+	ue.GET("/api/_version_/fleet/hosts/{id:[0-9]+}/custom_attributes", listHostCustomAttributesEndpoint, listHostCustomAttributesRequest{})
+	ue.PATCH("/api/_version_/fleet/hosts/{id:[0-9]+}/custom_attributes", setHostCustomAttributesEndpoint, setHostCustomAttributesRequest{})
+	ue.GET("/api/_version_/fleet/hosts/{id:[0-9]+}/assigned_user", getHostAssignedUserEndpoint, getHostAssignedUserRequest{})
+	ue.PATCH("/api/_version_/fleet/hosts/{id:[0-9]+}/assigned_user", setHostAssignedUserEndpoint, setHostAssignedUserRequest{})
+	ue.DELETE("/api/_version_/fleet/hosts/{id:[0-9]+}/assigned_user", deleteHostAssignedUserEndpoint, deleteHostAssignedUserRequest{})
+	ue.POST("/api/_version_/fleet/hosts/assigned_users", syncHostAssignedUsersEndpoint, syncHostAssignedUsersRequest{})
 	ue.GET("/api/_version_/fleet/hosts/report", hostsReportEndpoint, hostsReportRequest{})
 	ue.GET("/api/_version_/fleet/os_versions", osVersionsEndpoint, osVersionsRequest{})
 
+	// SCIM endpoints used by the IdP to push its users and groups
+	ue.GET("/api/_version_/fleet/scim/Users", listScimUsersEndpoint, listScimUsersRequest{})
+	ue.POST("/api/_version_/fleet/scim/Users", createScimUserEndpoint, createScimUserRequest{})
+	ue.GET("/api/_version_/fleet/scim/Users/{id:[0-9]+}", getScimUserEndpoint, getScimUserRequest{})
+	ue.PUT("/api/_version_/fleet/scim/Users/{id:[0-9]+}", replaceScimUserEndpoint, replaceScimUserRequest{})
+	ue.PATCH("/api/_version_/fleet/scim/Users/{id:[0-9]+}", patchScimUserEndpoint, patchScimRequest{})
+	ue.DELETE("/api/_version_/fleet/scim/Users/{id:[0-9]+}", deleteScimUserEndpoint, deleteScimRequest{})
+	ue.GET("/api/_version_/fleet/scim/Groups", listScimGroupsEndpoint, listScimGroupsRequest{})
+	ue.POST("/api/_version_/fleet/scim/Groups", createScimGroupEndpoint, createScimGroupRequest{})
+	ue.GET("/api/_version_/fleet/scim/Groups/{id:[0-9]+}", getScimGroupEndpoint, getScimGroupRequest{})
+	ue.PUT("/api/_version_/fleet/scim/Groups/{id:[0-9]+}", replaceScimGroupEndpoint, replaceScimGroupRequest{})
+	ue.PATCH("/api/_version_/fleet/scim/Groups/{id:[0-9]+}", patchScimGroupEndpoint, patchScimRequest{})
+	ue.DELETE("/api/_version_/fleet/scim/Groups/{id:[0-9]+}", deleteScimGroupEndpoint, deleteScimRequest{})
+	ue.GET("/api/_version_/fleet/scim/details", getScimDetailsEndpoint, nil)
+
 	ue.GET("/api/_version_/fleet/hosts/summary/mdm", getHostMDMSummary, getHostMDMSummaryRequest{})
 	ue.GET("/api/_version_/fleet/hosts/{id:[0-9]+}/mdm", getHostMDM, getHostMDMRequest{})
 
@@ -394,6 +437,8 @@ func attachFleetAPIRoutes(r *mux.Router, svc fleet.Service, config config.FleetC
 	ue.POST("/api/_version_/fleet/queries/run_by_names", createDistributedQueryCampaignByNamesEndpoint, createDistributedQueryCampaignByNamesRequest{})
 
 	ue.GET("/api/_version_/fleet/activities", listActivitiesEndpoint, listActivitiesRequest{})
+	ue.GET("/api/_version_/fleet/security_audit_log", listSecurityAuditLogEndpoint, listSecurityAuditLogRequest{})
+	ue.GET("/api/_version_/fleet/security_audit_log/verify", verifySecurityAuditLogEndpoint, verifySecurityAuditLogRequest{})
 
 	ue.POST("/api/_version_/fleet/download_installer/{kind}", getInstallerEndpoint, getInstallerRequest{})
 	ue.HEAD("/api/_version_/fleet/download_installer/{kind}", checkInstallerEndpoint, checkInstallerRequest{})
@@ -448,37 +493,108 @@ func attachFleetAPIRoutes(r *mux.Router, svc fleet.Service, config config.FleetC
 	mdm.GET("/api/_version_/fleet/mdm/apple/commandresults", getMDMAppleCommandResultsEndpoint, getMDMAppleCommandResultsRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/apple/commands", listMDMAppleCommandsEndpoint, listMDMAppleCommandsRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/apple/filevault/summary", getMdmAppleFileVaultSummaryEndpoint, getMDMAppleFileVaultSummaryRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/apple/filevault/rotate", rotateMDMAppleFileVaultKeysEndpoint, rotateMDMAppleFileVaultKeysRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/enrollment_mismatches", listMDMAppleEnrollmentMismatchesEndpoint, listMDMAppleEnrollmentMismatchesRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/hosts_missing_fleetd", listMDMAppleHostsMissingFleetdEndpoint, listMDMAppleHostsMissingFleetdRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/scep/certificates", listMDMAppleSCEPCertificatesEndpoint, listMDMAppleSCEPCertificatesRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/nano_enrollments", listMDMAppleNanoEnrollmentsEndpoint, listMDMAppleNanoEnrollmentsRequest{})
+	mdm.DELETE("/api/_version_/fleet/mdm/apple/nano_enrollments/{id}", deleteMDMAppleNanoEnrollmentEndpoint, deleteMDMAppleNanoEnrollmentRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/apple/nano_enrollments/{id}/link", linkMDMAppleNanoEnrollmentEndpoint, linkMDMAppleNanoEnrollmentRequest{})
 	mdm.POST("/api/_version_/fleet/mdm/apple/profiles", newMDMAppleConfigProfileEndpoint, newMDMAppleConfigProfileRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/apple/profiles", listMDMAppleConfigProfilesEndpoint, listMDMAppleConfigProfilesRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/apple/profiles/{profile_id:[0-9]+}", getMDMAppleConfigProfileEndpoint, getMDMAppleConfigProfileRequest{})
 	mdm.DELETE("/api/_version_/fleet/mdm/apple/profiles/{profile_id:[0-9]+}", deleteMDMAppleConfigProfileEndpoint, deleteMDMAppleConfigProfileRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/apple/profiles/delete", deleteMDMAppleConfigProfilesEndpoint, deleteMDMAppleConfigProfilesRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/apple/profiles/{profile_id:[0-9]+}/copy", copyMDMAppleConfigProfileEndpoint, copyMDMAppleConfigProfileRequest{})
+	mdm.PATCH("/api/_version_/fleet/mdm/apple/profiles/{profile_id:[0-9]+}/retry", retryMDMAppleConfigProfileEndpoint, retryMDMAppleConfigProfileRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/apple/profiles/summary", getMDMAppleProfilesSummaryEndpoint, getMDMAppleProfilesSummaryRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/profiles/summary/all", listMDMAppleProfilesSummaryByTeamEndpoint, listMDMAppleProfilesSummaryByTeamRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/posture_score", getMDMPostureScoreEndpoint, getMDMPostureScoreRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/profiles/conflicts", listMDMAppleProfileIdentifierConflictsEndpoint, listMDMAppleProfileIdentifierConflictsRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/profiles/reconciliation_plan", getMDMAppleReconciliationPlanEndpoint, getMDMAppleReconciliationPlanRequest{})
+	mdm.PATCH("/api/_version_/fleet/mdm/apple/profiles/{profile_id:[0-9]+}/target", setMDMAppleConfigProfileTargetEndpoint, setMDMAppleConfigProfileTargetRequest{})
+	mdm.PATCH("/api/_version_/fleet/mdm/apple/profiles/{profile_id:[0-9]+}/os_versions", setMDMAppleConfigProfileMacOSVersionsEndpoint, setMDMAppleConfigProfileMacOSVersionsRequest{})
+
+	mdm.POST("/api/_version_/fleet/mdm/apple/host_targets", newMDMHostTargetEndpoint, newMDMHostTargetRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/host_targets", listMDMHostTargetsEndpoint, listMDMHostTargetsRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/host_targets/{id:[0-9]+}/hosts", listMDMHostTargetHostsEndpoint, listMDMHostTargetHostsRequest{})
+	mdm.DELETE("/api/_version_/fleet/mdm/apple/host_targets/{id:[0-9]+}", deleteMDMHostTargetEndpoint, deleteMDMHostTargetRequest{})
 	mdm.POST("/api/_version_/fleet/mdm/apple/enrollment_profile", createMDMAppleSetupAssistantEndpoint, createMDMAppleSetupAssistantRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/apple/enrollment_profile", getMDMAppleSetupAssistantEndpoint, getMDMAppleSetupAssistantRequest{})
 	mdm.DELETE("/api/_version_/fleet/mdm/apple/enrollment_profile", deleteMDMAppleSetupAssistantEndpoint, deleteMDMAppleSetupAssistantRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/apple/policies/{policy_id:[0-9]+}/action", setMDMApplePolicyActionEndpoint, setMDMApplePolicyActionRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/policies/{policy_id:[0-9]+}/action", getMDMApplePolicyActionEndpoint, getMDMApplePolicyActionRequest{})
+	mdm.DELETE("/api/_version_/fleet/mdm/apple/policies/{policy_id:[0-9]+}/action", deleteMDMApplePolicyActionEndpoint, deleteMDMApplePolicyActionRequest{})
 
 	// TODO: are those undocumented endpoints still needed? I think they were only used
 	// by 'fleetctl apple-mdm' sub-commands.
 	mdm.POST("/api/_version_/fleet/mdm/apple/enrollmentprofiles", createMDMAppleEnrollmentProfilesEndpoint, createMDMAppleEnrollmentProfileRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/apple/enrollmentprofiles", listMDMAppleEnrollmentsEndpoint, listMDMAppleEnrollmentProfilesRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/apple/enrollment_links", createMDMAppleEnrollmentLinkEndpoint, createMDMAppleEnrollmentLinkRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/enrollment_links/{id:[0-9]+}", getMDMAppleEnrollmentLinkEndpoint, getMDMAppleEnrollmentLinkRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/sso_enrollment_tokens", listMDMAppleSSOEnrollmentTokensEndpoint, listMDMAppleSSOEnrollmentTokensRequest{})
+	mdm.DELETE("/api/_version_/fleet/mdm/apple/sso_enrollment_tokens/{id:[0-9]+}", revokeMDMAppleSSOEnrollmentTokenEndpoint, revokeMDMAppleSSOEnrollmentTokenRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/team_enrollment_profile", getMDMAppleTeamEnrollmentProfileEndpoint, getMDMAppleTeamEnrollmentProfileRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/apple/team_enrollment_profile/rotate", rotateMDMAppleTeamEnrollmentTokenEndpoint, rotateMDMAppleTeamEnrollmentTokenRequest{})
 	mdm.POST("/api/_version_/fleet/mdm/apple/installers", uploadAppleInstallerEndpoint, uploadAppleInstallerRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/apple/installers/{installer_id:[0-9]+}", getAppleInstallerEndpoint, getAppleInstallerDetailsRequest{})
 	mdm.DELETE("/api/_version_/fleet/mdm/apple/installers/{installer_id:[0-9]+}", deleteAppleInstallerEndpoint, deleteAppleInstallerDetailsRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/apple/installers", listMDMAppleInstallersEndpoint, listMDMAppleInstallersRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/apple/devices", listMDMAppleDevicesEndpoint, listMDMAppleDevicesRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/apple/dep/devices", listMDMAppleDEPDevicesEndpoint, listMDMAppleDEPDevicesRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/dep/hosts_report", listMDMAppleDEPHostsReportEndpoint, listMDMAppleDEPHostsReportRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/dep/assignment_discrepancies", listMDMAppleDEPAssignmentDiscrepanciesEndpoint, listMDMAppleDEPAssignmentDiscrepanciesRequest{})
+	mdm.DELETE("/api/_version_/fleet/mdm/apple/dep/devices/{serial}", releaseMDMAppleDEPDeviceEndpoint, releaseMDMAppleDEPDeviceRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/apple/dep/team_assignments", uploadMDMAppleDEPTeamAssignmentsEndpoint, uploadMDMAppleDEPTeamAssignmentsRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/dep/team_assignments", listMDMAppleDEPTeamAssignmentsEndpoint, nil)
 
 	// bootstrap-package routes
 	mdm.POST("/api/_version_/fleet/mdm/apple/bootstrap", uploadBootstrapPackageEndpoint, uploadBootstrapPackageRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/apple/bootstrap/{team_id:[0-9]+}/metadata", bootstrapPackageMetadataEndpoint, bootstrapPackageMetadataRequest{})
 	mdm.DELETE("/api/_version_/fleet/mdm/apple/bootstrap/{team_id:[0-9]+}", deleteBootstrapPackageEndpoint, deleteBootstrapPackageRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/apple/bootstrap/summary", getMDMAppleBootstrapPackageSummaryEndpoint, getMDMAppleBootstrapPackageSummaryRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/bootstrap/signatures", getMDMAppleBootstrapPackageSignaturesEndpoint, nil)
+	mdm.GET("/api/_version_/fleet/mdm/apple/os_updates/summary", getMDMAppleOSUpdatesSummaryEndpoint, getMDMAppleOSUpdatesSummaryRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/apple/bootstrap/uploads", initiateBootstrapPackageUploadEndpoint, initiateBootstrapPackageUploadRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/bootstrap/uploads/{token}", getBootstrapPackageUploadEndpoint, getBootstrapPackageUploadRequest{})
+	mdm.PATCH("/api/_version_/fleet/mdm/apple/bootstrap/uploads/{token}", appendBootstrapPackageUploadEndpoint, appendBootstrapPackageUploadRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/apple/bootstrap/uploads/{token}/commit", commitBootstrapPackageUploadEndpoint, commitBootstrapPackageUploadRequest{})
 
 	// host-specific mdm routes
 	mdm.PATCH("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/unenroll", mdmAppleCommandRemoveEnrollmentProfileEndpoint, mdmAppleCommandRemoveEnrollmentProfileRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/encryption_key", getHostEncryptionKey, getHostEncryptionKeyRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/encryption_key/accesses", listHostEncryptionKeyAccessesEndpoint, listHostEncryptionKeyAccessesRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/activation_lock_bypass_code", getHostActivationLockBypassCodeEndpoint, getHostActivationLockBypassCodeRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/certificates", listHostCertificatesEndpoint, listHostCertificatesRequest{})
 	mdm.POST("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/lock", deviceLockEndpoint, deviceLockRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/unlock_pin", getHostUnlockPINEndpoint, getHostUnlockPINRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/dep_profile", getHostMDMAppleDEPProfileOverrideEndpoint, getHostMDMAppleDEPProfileOverrideRequest{})
+	mdm.PUT("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/dep_profile", setHostMDMAppleDEPProfileOverrideEndpoint, setHostMDMAppleDEPProfileOverrideRequest{})
+	mdm.DELETE("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/dep_profile", deleteHostMDMAppleDEPProfileOverrideEndpoint, deleteHostMDMAppleDEPProfileOverrideRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/quarantine", quarantineHostEndpoint, quarantineHostRequest{})
 	mdm.POST("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/wipe", deviceWipeEndpoint, deviceWipeRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/approve", approveMDMAppleEnrollmentEndpoint, approveMDMAppleEnrollmentRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/install_fleetd", installMDMAppleFleetdEndpoint, installMDMAppleFleetdRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/queued_commands", listMDMAppleHostQueuedCommandsEndpoint, listMDMAppleHostQueuedCommandsRequest{})
+	mdm.DELETE("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/queued_commands/{command_uuid}", cancelMDMAppleHostQueuedCommandEndpoint, cancelMDMAppleHostQueuedCommandRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/commands", listMDMAppleHostCommandsEndpoint, listMDMAppleHostCommandsRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/webhooks/dead_letters", listMDMWebhookDeadLettersEndpoint, listMDMWebhookDeadLettersRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/changes", listMDMHostChangesEndpoint, listMDMHostChangesRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/hosts/{id:[0-9]+}/purge", purgeHostMDMAppleDataEndpoint, purgeHostMDMAppleDataRequest{})
+	mdm.POST("/api/_version_/fleet/hosts/{id:[0-9]+}/mdm/profiles", installMDMAppleHostProfileEndpoint, installMDMAppleHostProfileRequest{})
+	mdm.DELETE("/api/_version_/fleet/hosts/{id:[0-9]+}/mdm/profiles/{profile_id:[0-9]+}", deleteMDMAppleHostProfileEndpoint, deleteMDMAppleHostProfileRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/pending_enrollments", listMDMApplePendingEnrollmentsEndpoint, nil)
+	mdm.GET("/api/_version_/fleet/mdm/apple/profile_changes", listMDMAppleProfileChangesEndpoint, listMDMAppleProfileChangesRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/apple/profile_changes/{id:[0-9]+}/approve", approveMDMAppleProfileChangeEndpoint, reviewMDMAppleProfileChangeRequest{})
+	mdm.POST("/api/_version_/fleet/mdm/apple/profile_changes/{id:[0-9]+}/reject", rejectMDMAppleProfileChangeEndpoint, reviewMDMAppleProfileChangeRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/blocked_enrollments", listMDMAppleBlockedEnrollmentsEndpoint, nil)
+	mdm.POST("/api/_version_/fleet/mdm/apple/server_url_migration", startMDMAppleServerURLMigrationEndpoint, startMDMAppleServerURLMigrationRequest{})
+	mdm.GET("/api/_version_/fleet/mdm/apple/server_url_migration", getMDMAppleServerURLMigrationEndpoint, nil)
+	mdm.GET("/api/_version_/fleet/mdm/apple/scep/ca_rotation", getMDMAppleSCEPCARotationEndpoint, nil)
+
+	// health status of the mdm cron schedules
+	mdm.GET("/api/_version_/fleet/mdm/schedules", listMDMCronSchedulesEndpoint, nil)
+	mdm.POST("/api/_version_/fleet/mdm/schedules/{name}/trigger", triggerMDMCronScheduleEndpoint, triggerMDMCronScheduleRequest{})
 
 	mdm.PATCH("/api/_version_/fleet/mdm/apple/settings", updateMDMAppleSettingsEndpoint, updateMDMAppleSettingsRequest{})
 	mdm.GET("/api/_version_/fleet/mdm/apple", getAppleMDMEndpoint, nil)
@@ -489,14 +605,18 @@ func attachFleetAPIRoutes(r *mux.Router, svc fleet.Service, config config.FleetC
 
 	// the following set of mdm endpoints must always be accessible (even
 	// if MDM is not configured) as it bootstraps the setup of MDM
-	// (generates CSR request for APNs, plus the SCEP and ABM keypairs).
+	// (generates CSR request for APNs, plus the SCEP and ABM keypairs, and
+	// stores the resulting assets).
 	ue.POST("/api/_version_/fleet/mdm/apple/request_csr", requestMDMAppleCSREndpoint, requestMDMAppleCSRRequest{})
 	ue.POST("/api/_version_/fleet/mdm/apple/dep/key_pair", newMDMAppleDEPKeyPairEndpoint, nil)
+	ue.POST("/api/_version_/fleet/mdm/apple/setup", setupMDMAppleEndpoint, setupMDMAppleRequest{})
 	ue.GET("/api/_version_/fleet/mdm/apple_bm", getAppleBMEndpoint, nil)
 	// batch-apply is accessible even though MDM is not enabled, it needs
 	// to support the case where `fleetctl get config`'s output is used as
 	// input to `fleetctl apply`
 	ue.POST("/api/_version_/fleet/mdm/apple/profiles/batch", batchSetMDMAppleProfilesEndpoint, batchSetMDMAppleProfilesRequest{})
+	ue.POST("/api/_version_/fleet/mdm/apple/profiles/batch/teams", batchSetMDMAppleTeamsProfilesEndpoint, batchSetMDMAppleTeamsProfilesRequest{})
+	ue.GET("/api/_version_/fleet/mdm/apple/profiles/jobs/{job_id:[0-9]+}", getMDMAppleProfilesJobEndpoint, getMDMAppleProfilesJobRequest{})
 
 	errorLimiter := ratelimit.NewErrorMiddleware(limitStore)
 
@@ -562,6 +682,7 @@ func attachFleetAPIRoutes(r *mux.Router, svc fleet.Service, config config.FleetC
 	oe := newOrbitAuthenticatedEndpointer(svc, logger, opts, r, apiVersions...)
 	oe.POST("/api/fleet/orbit/device_token", setOrUpdateDeviceTokenEndpoint, setOrUpdateDeviceTokenRequest{})
 	oe.POST("/api/fleet/orbit/config", getOrbitConfigEndpoint, orbitGetConfigRequest{})
+	oe.POST("/api/fleet/orbit/mdm_enrollment_status", setOrbitMDMEnrollmentStatusEndpoint, setOrbitMDMEnrollmentStatusRequest{})
 
 	// unauthenticated endpoints - most of those are either login-related,
 	// invite-related or host-enrolling. So they typically do some kind of
@@ -579,11 +700,21 @@ func attachFleetAPIRoutes(r *mux.Router, svc fleet.Service, config config.FleetC
 	// endpoints using `mdm.*` above in this file.
 	neMDM := ne.WithCustomMiddleware(mdmConfiguredMiddleware.Verify())
 	neMDM.GET(apple_mdm.EnrollPath, mdmAppleEnrollEndpoint, mdmAppleEnrollRequest{})
+	neMDM.GET(apple_mdm.EnrollLinkPath, mdmAppleEnrollLinkEndpoint, mdmAppleEnrollLinkRequest{})
 	neMDM.GET(apple_mdm.InstallerPath, mdmAppleGetInstallerEndpoint, mdmAppleGetInstallerRequest{})
 	neMDM.HEAD(apple_mdm.InstallerPath, mdmAppleHeadInstallerEndpoint, mdmAppleHeadInstallerRequest{})
 	neMDM.GET("/api/_version_/fleet/mdm/apple/bootstrap", downloadBootstrapPackageEndpoint, downloadBootstrapPackageRequest{})
 	neMDM.GET("/api/_version_/fleet/mdm/apple/setup/eula/{token}", getMDMAppleEULAEndpoint, getMDMAppleEULARequest{})
 
+	// Windows MDM enrollment (MS-MDE2) and management (MS-MDM) endpoints,
+	// called by the Windows devices.
+	neWindowsMDM := ne.WithCustomMiddleware(mdmconfigured.NewWindowsMiddleware(svc).Verify())
+	neWindowsMDM.GET(microsoft_mdm.MDE2DiscoveryPath, mdmMicrosoftDiscoveryGetEndpoint, nil)
+	neWindowsMDM.POST(microsoft_mdm.MDE2DiscoveryPath, mdmMicrosoftDiscoveryEndpoint, mdmMicrosoftSoapRequest{})
+	neWindowsMDM.POST(microsoft_mdm.MDE2PolicyPath, mdmMicrosoftPolicyEndpoint, mdmMicrosoftSoapRequest{})
+	neWindowsMDM.POST(microsoft_mdm.MDE2EnrollPath, mdmMicrosoftEnrollEndpoint, mdmMicrosoftSoapRequest{})
+	neWindowsMDM.POST(microsoft_mdm.MDE2ManagementPath, mdmMicrosoftManagementEndpoint, mdmMicrosoftManagementRequest{})
+
 	ne.POST("/api/fleet/orbit/enroll", enrollOrbitEndpoint, EnrollOrbitRequest{})
 
 	// For some reason osquery does not provide a node key with the block data.
@@ -620,6 +751,13 @@ func attachFleetAPIRoutes(r *mux.Router, svc fleet.Service, config config.FleetC
 
 	ne.WithCustomMiddleware(limiter.Limit("login", throttled.RateQuota{MaxRate: loginRateLimit, MaxBurst: 9})).
 		POST("/api/_version_/fleet/login", loginEndpoint, loginRequest{})
+	ue.WithCustomMiddleware(limiter.Limit("reauthenticate", throttled.RateQuota{MaxRate: loginRateLimit, MaxBurst: 9})).
+		POST("/api/_version_/fleet/sessions/reauthenticate", reauthenticateSessionEndpoint, reauthenticateSessionRequest{})
+
+	// pushes wake up all the matching hosts at once, limit how often they can
+	// be sent to avoid overloading the server with check-ins.
+	mdm.WithCustomMiddleware(limiter.Limit("mdm_apple_push", throttled.RateQuota{MaxRate: throttled.PerMin(1), MaxBurst: 4})).
+		POST("/api/_version_/fleet/mdm/apple/push", pushMDMAppleHostsEndpoint, pushMDMAppleHostsRequest{})
 
 	// Fleet Sandbox demo login (always errors unless config.server.sandbox_enabled is set)
 	ne.WithCustomMiddleware(limiter.Limit("login", throttled.RateQuota{MaxRate: loginRateLimit, MaxBurst: 9})).
@@ -741,10 +879,24 @@ func RegisterAppleMDMProtocolServices(
 	if err != nil {
 		return fmt.Errorf("load SCEP CA certificates and key: %w", err)
 	}
-	if err := registerSCEP(mux, scepConfig, scepCACerts[0], scepCAKey, scepStorage, logger); err != nil {
+	// the SCEP and MDM enrollment requests share the same admission control,
+	// nil if it is disabled.
+	admission := apple_mdm.NewEnrollmentAdmission(scepConfig.EnrollmentMaxConcurrent,
+		scepConfig.EnrollmentMaxQueued, scepConfig.EnrollmentQueueTimeout)
+	if err := registerSCEP(mux, scepConfig, scepCACerts[0], scepCAKey, scepStorage, admission, logger); err != nil {
 		return fmt.Errorf("scep: %w", err)
 	}
-	if err := registerMDM(mux, scepCACerts[0], mdmStorage, checkinAndCommandService, logger); err != nil {
+	// during a SCEP CA rotation, the identities issued by the previous CA are
+	// still accepted until the devices renew them or re-enroll.
+	verifierCerts := []*x509.Certificate{scepCACerts[0]}
+	prevSCEPCert, err := scepConfig.AppleSCEPPrevious()
+	if err != nil {
+		return fmt.Errorf("load previous SCEP CA certificate: %w", err)
+	}
+	if prevSCEPCert != nil {
+		verifierCerts = append(verifierCerts, prevSCEPCert.Leaf)
+	}
+	if err := registerMDM(mux, verifierCerts, mdmStorage, checkinAndCommandService, admission, logger); err != nil {
 		return fmt.Errorf("mdm: %w", err)
 	}
 	return nil
@@ -758,6 +910,7 @@ func registerSCEP(
 	scepCert *x509.Certificate,
 	scepKey *rsa.PrivateKey,
 	scepStorage scep_depot.Depot,
+	admission *apple_mdm.EnrollmentAdmission,
 	logger kitlog.Logger,
 ) error {
 	var signer scepserver.CSRSigner = scep_depot.NewSigner(
@@ -777,15 +930,28 @@ func registerSCEP(
 	if err != nil {
 		return fmt.Errorf("initialize SCEP service: %w", err)
 	}
+	if scepConfig.FIPSMode {
+		scepService = fipsSCEPService{scepService}
+	}
 	scepLogger := kitlog.With(logger, "component", "http-mdm-apple-scep")
 	e := scepserver.MakeServerEndpoints(scepService)
 	e.GetEndpoint = scepserver.EndpointLoggingMiddleware(scepLogger)(e.GetEndpoint)
 	e.PostEndpoint = scepserver.EndpointLoggingMiddleware(scepLogger)(e.PostEndpoint)
 	scepHandler := scepserver.MakeHTTPHandler(e, scepService, scepLogger)
-	mux.Handle(apple_mdm.SCEPPath, scepHandler)
+	mux.Handle(apple_mdm.SCEPPath, admission.Middleware(scepHandler, apple_mdm.ClassifySCEPEnrollment))
 	return nil
 }
 
+// fipsSCEPService is a SCEP service that only advertises the FIPS-approved
+// capabilities, so that the devices don't use SHA-1 or Triple DES.
+type fipsSCEPService struct {
+	scepserver.Service
+}
+
+func (s fipsSCEPService) GetCACaps(ctx context.Context) ([]byte, error) {
+	return []byte("Renewal\nSHA-256\nAES\nSCEPStandard\nPOSTPKIOperation"), nil
+}
+
 // NanoMDMLogger is a logger adapter for nanomdm.
 type NanoMDMLogger struct {
 	logger kitlog.Logger
@@ -813,17 +979,20 @@ func (l *NanoMDMLogger) With(keyvals ...interface{}) nanomdm_log.Logger {
 }
 
 // registerMDM registers the HTTP handlers that serve core MDM services (like checking in for MDM commands).
+// The identity certificates of the devices must be issued by one of scepCACerts.
 func registerMDM(
 	mux *http.ServeMux,
-	scepCACert *x509.Certificate,
+	scepCACerts []*x509.Certificate,
 	mdmStorage nanomdm_storage.AllStorage,
 	checkinAndCommandService nanomdm_service.CheckinAndCommandService,
+	admission *apple_mdm.EnrollmentAdmission,
 	logger kitlog.Logger,
 ) error {
-	certVerifier, err := certverify.NewPoolVerifier(
-		apple_mdm.EncodeCertPEM(scepCACert),
-		x509.ExtKeyUsageClientAuth,
-	)
+	var rootsPEM []byte
+	for _, cert := range scepCACerts {
+		rootsPEM = append(rootsPEM, apple_mdm.EncodeCertPEM(cert)...)
+	}
+	certVerifier, err := certverify.NewPoolVerifier(rootsPEM, x509.ExtKeyUsageClientAuth)
 	if err != nil {
 		return fmt.Errorf("certificate pool verifier: %w", err)
 	}
@@ -837,17 +1006,23 @@ func registerMDM(
 	// enrollments and updates the Fleet hosts table accordingly with the UDID and serial number of
 	// the device.
 	// 5. Run actual MDM service operation (checkin handler or command and results handler).
+	//
+	// Before all of that, a trace ID is assigned to the request, it is included
+	// in the logs and stored with the command results, and the enrollment
+	// check-ins go through the admission control.
 	coreMDMService := nanomdm.New(mdmStorage, nanomdm.WithLogger(mdmLogger))
 	// NOTE: it is critical that the coreMDMService runs first, as the first
 	// service in the multi-service feature is run to completion _before_ running
 	// the other ones in parallel. This way, subsequent services have access to
 	// the result of the core service, e.g. the device is enrolled, etc.
-	var mdmService nanomdm_service.CheckinAndCommandService = multi.New(mdmLogger, coreMDMService, checkinAndCommandService)
+	var mdmService nanomdm_service.CheckinAndCommandService = multi.New(mdmLogger, coreMDMService, apple_mdm.NewTraceIDService(checkinAndCommandService))
 
 	mdmService = certauth.New(mdmService, mdmStorage)
 	var mdmHandler http.Handler = httpmdm.CheckinAndCommandHandler(mdmService, mdmLogger.With("handler", "checkin-command"))
 	mdmHandler = httpmdm.CertVerifyMiddleware(mdmHandler, certVerifier, mdmLogger.With("handler", "cert-verify"))
 	mdmHandler = httpmdm.CertExtractMdmSignatureMiddleware(mdmHandler, mdmLogger.With("handler", "cert-extract"))
+	mdmHandler = apple_mdm.TraceIDMiddleware(mdmHandler)
+	mdmHandler = admission.Middleware(mdmHandler, apple_mdm.ClassifyMDMEnrollment)
 	mux.Handle(apple_mdm.MDMPath, mdmHandler)
 	return nil
 }
@@ -11,15 +11,19 @@ import (
 	"strings"
 	"time"
 
+	"github.com/fleetdm/fleet/v4/pkg/mdmclient"
 	"github.com/fleetdm/fleet/v4/server/authz"
 	authzctx "github.com/fleetdm/fleet/v4/server/contexts/authz"
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/contexts/license"
 	"github.com/fleetdm/fleet/v4/server/contexts/logging"
+	"github.com/fleetdm/fleet/v4/server/contexts/publicip"
 	"github.com/fleetdm/fleet/v4/server/contexts/viewer"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	apple_mdm "github.com/fleetdm/fleet/v4/server/mdm/apple"
 	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
+	"github.com/fleetdm/fleet/v4/server/worker"
+	kitlog "github.com/go-kit/kit/log"
 	"github.com/gocarina/gocsv"
 )
 
@@ -498,6 +502,12 @@ func (svc *Service) GetHostSummary(ctx context.Context, teamID *uint, platform *
 	}
 	hostSummary.AllLinuxCount = linuxCount
 
+	mdmSummary, err := svc.ds.GenerateHostMDMStatistics(ctx, filter, platform)
+	if err != nil {
+		return nil, err
+	}
+	hostSummary.MDM = mdmSummary
+
 	labelsSummary, err := svc.ds.LabelsSummary(ctx)
 	if err != nil {
 		return nil, err
@@ -643,12 +653,37 @@ func (svc *Service) AddHostsToTeam(ctx context.Context, teamID *uint, hostIDs []
 		return err
 	}
 
-	if err := svc.ds.AddHostsToTeam(ctx, teamID, hostIDs); err != nil {
+	return transferHostsToTeam(ctx, svc.ds, svc.logger, authz.UserFromContext(ctx), teamID, hostIDs)
+}
+
+// transferHostsToTeam transfers the hosts to the team (or to no team if
+// teamID is nil) with the side effects of a transfer: the disk encryption
+// keys of the hosts are reset by the datastore, the MDM profiles of the team
+// are queued for the hosts and the transfer is recorded in the activities.
+// The user is nil if the hosts are transferred automatically.
+func transferHostsToTeam(ctx context.Context, ds fleet.Datastore, logger kitlog.Logger, user *fleet.User, teamID *uint, hostIDs []uint) error {
+	var teamName *string
+	if teamID != nil {
+		team, err := ds.Team(ctx, *teamID)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "get team")
+		}
+		teamName = &team.Name
+	}
+
+	if err := ds.AddHostsToTeam(ctx, teamID, hostIDs); err != nil {
 		return err
 	}
-	if err := svc.ds.BulkSetPendingMDMAppleHostProfiles(ctx, hostIDs, nil, nil, nil); err != nil {
+	if _, err := worker.BulkSetPendingMDMAppleHostProfiles(ctx, ds, logger, hostIDs, nil, nil); err != nil {
 		return ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
 	}
+	if err := ds.NewActivity(ctx, user, &fleet.ActivityTypeTransferredHostsToTeam{
+		TeamID:   teamID,
+		TeamName: teamName,
+		HostIDs:  hostIDs,
+	}); err != nil {
+		return ctxerr.Wrap(ctx, err, "create activity for transferred hosts")
+	}
 	return nil
 }
 
@@ -704,13 +739,7 @@ func (svc *Service) AddHostsToTeamByFilter(ctx context.Context, teamID *uint, op
 	}
 
 	// Apply the team to the selected hosts.
-	if err := svc.ds.AddHostsToTeam(ctx, teamID, hostIDs); err != nil {
-		return err
-	}
-	if err := svc.ds.BulkSetPendingMDMAppleHostProfiles(ctx, hostIDs, nil, nil, nil); err != nil {
-		return ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
-	}
-	return nil
+	return transferHostsToTeam(ctx, svc.ds, svc.logger, authz.UserFromContext(ctx), teamID, hostIDs)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -843,15 +872,55 @@ func (svc *Service) getHostDetails(ctx context.Context, host *fleet.Host, opts f
 			// TODO(Sarah): What should we do for not found? Should we return an empty struct or nil?
 			macOSSetup = &fleet.HostMDMMacOSSetup{}
 		}
+
+		acc, err := svc.ds.GetHostMDMIdPAccount(ctx, host.UUID)
+		if err != nil && !fleet.IsNotFound(err) {
+			return nil, ctxerr.Wrap(ctx, err, "get host mdm end user")
+		}
+		if acc != nil {
+			host.MDM.EndUser = &fleet.HostMDMEndUser{
+				Username: acc.Username,
+				FullName: acc.FullName,
+				Groups:   acc.Groups,
+			}
+		}
 	}
 	host.MDM.MacOSSetup = macOSSetup
 
+	assignedUser, err := svc.ds.GetHostAssignedUser(ctx, host.ID)
+	if err != nil && !fleet.IsNotFound(err) {
+		return nil, ctxerr.Wrap(ctx, err, "get host assigned user")
+	}
+
+	if ac.MDM.AppleBMEnabledAndConfigured && host.Platform == "darwin" {
+		dev, err := svc.ds.GetHostMDMAppleDEPDevice(ctx, host.ID)
+		if err != nil && !fleet.IsNotFound(err) {
+			return nil, ctxerr.Wrap(ctx, err, "get host mdm dep device")
+		}
+		host.MDM.DEPDevice = dev
+
+		override, err := svc.ds.GetHostMDMAppleDEPProfileOverride(ctx, host.ID)
+		if err != nil && !fleet.IsNotFound(err) {
+			return nil, ctxerr.Wrap(ctx, err, "get host mdm dep profile override")
+		}
+		host.MDM.DEPProfileOverride = override
+	}
+
+	if ac.MDM.EnabledAndConfigured && host.Platform == "darwin" {
+		failure, err := svc.ds.GetHostMDMApplePushFailure(ctx, host.UUID)
+		if err != nil && !fleet.IsNotFound(err) {
+			return nil, ctxerr.Wrap(ctx, err, "get host mdm push failure")
+		}
+		host.MDM.PushFailure = failure
+	}
+
 	return &fleet.HostDetail{
-		Host:      *host,
-		Labels:    labels,
-		Packs:     packs,
-		Policies:  policies,
-		Batteries: &bats,
+		Host:         *host,
+		Labels:       labels,
+		Packs:        packs,
+		Policies:     policies,
+		Batteries:    &bats,
+		AssignedUser: assignedUser,
 	}, nil
 }
 
@@ -943,6 +1012,331 @@ func (svc *Service) ListHostDeviceMapping(ctx context.Context, id uint) ([]*flee
 	return svc.ds.ListHostDeviceMapping(ctx, id)
 }
 
+////////////////////////////////////////////////////////////////////////////////
+// Host Custom Attributes
+////////////////////////////////////////////////////////////////////////////////
+
+type listHostCustomAttributesRequest struct {
+	ID uint `url:"id"`
+}
+
+type listHostCustomAttributesResponse struct {
+	HostID           uint                         `json:"host_id"`
+	CustomAttributes []*fleet.HostCustomAttribute `json:"custom_attributes"`
+	Err              error                        `json:"error,omitempty"`
+}
+
+func (r listHostCustomAttributesResponse) error() error { return r.Err }
+
+func listHostCustomAttributesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listHostCustomAttributesRequest)
+	attrs, err := svc.ListHostCustomAttributes(ctx, req.ID)
+	if err != nil {
+		return listHostCustomAttributesResponse{Err: err}, nil
+	}
+	if attrs == nil {
+		attrs = []*fleet.HostCustomAttribute{}
+	}
+	return listHostCustomAttributesResponse{HostID: req.ID, CustomAttributes: attrs}, nil
+}
+
+func (svc *Service) ListHostCustomAttributes(ctx context.Context, id uint) ([]*fleet.HostCustomAttribute, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, err
+	}
+
+	host, err := svc.ds.HostLite(ctx, id)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get host")
+	}
+
+	// Authorize again with team loaded now that we have team_id
+	if err := svc.authz.Authorize(ctx, host, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	return svc.ds.ListHostCustomAttributes(ctx, id)
+}
+
+type setHostCustomAttributesRequest struct {
+	ID               uint               `url:"id"`
+	CustomAttributes map[string]*string `json:"custom_attributes"`
+}
+
+type setHostCustomAttributesResponse struct {
+	HostID           uint                         `json:"host_id"`
+	CustomAttributes []*fleet.HostCustomAttribute `json:"custom_attributes"`
+	Err              error                        `json:"error,omitempty"`
+}
+
+func (r setHostCustomAttributesResponse) error() error { return r.Err }
+
+func setHostCustomAttributesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*setHostCustomAttributesRequest)
+	if err := svc.SetHostCustomAttributes(ctx, req.ID, req.CustomAttributes); err != nil {
+		return setHostCustomAttributesResponse{Err: err}, nil
+	}
+	attrs, err := svc.ListHostCustomAttributes(ctx, req.ID)
+	if err != nil {
+		return setHostCustomAttributesResponse{Err: err}, nil
+	}
+	if attrs == nil {
+		attrs = []*fleet.HostCustomAttribute{}
+	}
+	return setHostCustomAttributesResponse{HostID: req.ID, CustomAttributes: attrs}, nil
+}
+
+func (svc *Service) SetHostCustomAttributes(ctx context.Context, id uint, attrs map[string]*string) error {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return err
+	}
+
+	host, err := svc.ds.HostLite(ctx, id)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get host")
+	}
+
+	// Authorize again with team loaded now that we have team_id
+	if err := svc.authz.Authorize(ctx, host, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	normalized := make(map[string]*string, len(attrs))
+	for name, value := range attrs {
+		name = strings.ToLower(name)
+		var v string
+		if value != nil {
+			v = *value
+		}
+		if err := fleet.ValidateHostCustomAttribute(name, v); err != nil {
+			return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("custom_attributes", err.Error()))
+		}
+		normalized[name] = value
+	}
+
+	if err := svc.ds.SetHostCustomAttributes(ctx, id, normalized, fleet.HostCustomAttributeSourceAPI); err != nil {
+		return ctxerr.Wrap(ctx, err, "set host custom attributes")
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Host Assigned User
+////////////////////////////////////////////////////////////////////////////////
+
+type getHostAssignedUserRequest struct {
+	ID uint `url:"id"`
+}
+
+type getHostAssignedUserResponse struct {
+	HostID       uint                    `json:"host_id"`
+	AssignedUser *fleet.HostAssignedUser `json:"assigned_user"`
+	Err          error                   `json:"error,omitempty"`
+}
+
+func (r getHostAssignedUserResponse) error() error { return r.Err }
+
+func getHostAssignedUserEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getHostAssignedUserRequest)
+	user, err := svc.GetHostAssignedUser(ctx, req.ID)
+	if err != nil {
+		return getHostAssignedUserResponse{Err: err}, nil
+	}
+	return getHostAssignedUserResponse{HostID: req.ID, AssignedUser: user}, nil
+}
+
+func (svc *Service) GetHostAssignedUser(ctx context.Context, id uint) (*fleet.HostAssignedUser, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, err
+	}
+
+	host, err := svc.ds.HostLite(ctx, id)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get host")
+	}
+
+	// Authorize again with team loaded now that we have team_id
+	if err := svc.authz.Authorize(ctx, host, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	user, err := svc.ds.GetHostAssignedUser(ctx, id)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get host assigned user")
+	}
+	return user, nil
+}
+
+type setHostAssignedUserRequest struct {
+	ID       uint   `url:"id"`
+	Email    string `json:"email"`
+	FullName string `json:"full_name"`
+}
+
+func setHostAssignedUserEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*setHostAssignedUserRequest)
+	if err := svc.SetHostAssignedUser(ctx, req.ID, req.Email, req.FullName); err != nil {
+		return getHostAssignedUserResponse{Err: err}, nil
+	}
+	user, err := svc.GetHostAssignedUser(ctx, req.ID)
+	if err != nil {
+		return getHostAssignedUserResponse{Err: err}, nil
+	}
+	return getHostAssignedUserResponse{HostID: req.ID, AssignedUser: user}, nil
+}
+
+func (svc *Service) SetHostAssignedUser(ctx context.Context, id uint, email, fullName string) error {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return err
+	}
+
+	host, err := svc.ds.HostLite(ctx, id)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get host")
+	}
+
+	// Authorize again with team loaded now that we have team_id
+	if err := svc.authz.Authorize(ctx, host, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	email, fullName = strings.TrimSpace(email), strings.TrimSpace(fullName)
+	if err := fleet.ValidateHostAssignedUser(email, fullName); err != nil {
+		return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("email", err.Error()))
+	}
+
+	user := &fleet.HostAssignedUser{Email: email, FullName: fullName, Source: fleet.HostAssignedUserSourceAPI}
+	if err := svc.ds.SetHostAssignedUser(ctx, id, user); err != nil {
+		return ctxerr.Wrap(ctx, err, "set host assigned user")
+	}
+	return nil
+}
+
+type deleteHostAssignedUserRequest struct {
+	ID uint `url:"id"`
+}
+
+type deleteHostAssignedUserResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r deleteHostAssignedUserResponse) error() error { return r.Err }
+
+func (r deleteHostAssignedUserResponse) Status() int { return http.StatusNoContent }
+
+func deleteHostAssignedUserEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*deleteHostAssignedUserRequest)
+	if err := svc.DeleteHostAssignedUser(ctx, req.ID); err != nil {
+		return deleteHostAssignedUserResponse{Err: err}, nil
+	}
+	return deleteHostAssignedUserResponse{}, nil
+}
+
+func (svc *Service) DeleteHostAssignedUser(ctx context.Context, id uint) error {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return err
+	}
+
+	host, err := svc.ds.HostLite(ctx, id)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get host")
+	}
+
+	// Authorize again with team loaded now that we have team_id
+	if err := svc.authz.Authorize(ctx, host, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	if err := svc.ds.SetHostAssignedUser(ctx, id, nil); err != nil {
+		return ctxerr.Wrap(ctx, err, "delete host assigned user")
+	}
+	return nil
+}
+
+// maxSyncHostAssignedUsers is the maximum number of assigned users that can
+// be synced in a single request.
+const maxSyncHostAssignedUsers = 1000
+
+type syncHostAssignedUsersRequest struct {
+	AssignedUsers []fleet.HostAssignedUserSync `json:"assigned_users"`
+}
+
+type syncHostAssignedUsersResponse struct {
+	UpdatedCount int      `json:"updated_count"`
+	NotFound     []string `json:"not_found"`
+	Err          error    `json:"error,omitempty"`
+}
+
+func (r syncHostAssignedUsersResponse) error() error { return r.Err }
+
+func syncHostAssignedUsersEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*syncHostAssignedUsersRequest)
+	updated, notFound, err := svc.SyncHostAssignedUsers(ctx, req.AssignedUsers)
+	if err != nil {
+		return syncHostAssignedUsersResponse{Err: err}, nil
+	}
+	if notFound == nil {
+		notFound = []string{}
+	}
+	return syncHostAssignedUsersResponse{UpdatedCount: updated, NotFound: notFound}, nil
+}
+
+func (svc *Service) SyncHostAssignedUsers(ctx context.Context, users []fleet.HostAssignedUserSync) (int, []string, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return 0, nil, err
+	}
+
+	if len(users) > maxSyncHostAssignedUsers {
+		return 0, nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("assigned_users",
+			fmt.Sprintf("at most %d assigned users can be synced in a single request", maxSyncHostAssignedUsers)))
+	}
+	for i := range users {
+		u := &users[i]
+		u.HostIdentifier, u.Email, u.FullName = strings.TrimSpace(u.HostIdentifier), strings.TrimSpace(u.Email), strings.TrimSpace(u.FullName)
+		if u.HostIdentifier == "" {
+			return 0, nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("assigned_users", "host_identifier is required"))
+		}
+		if err := fleet.ValidateHostAssignedUser(u.Email, u.FullName); err != nil {
+			return 0, nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("assigned_users",
+				fmt.Sprintf("host %s: %s", u.HostIdentifier, err.Error())))
+		}
+	}
+
+	// resolve and authorize all the hosts before updating any of them
+	hostIDs := make([]uint, len(users))
+	var notFound []string
+	for i, u := range users {
+		host, err := svc.ds.HostByIdentifier(ctx, u.HostIdentifier)
+		if err != nil {
+			if fleet.IsNotFound(err) {
+				notFound = append(notFound, u.HostIdentifier)
+				continue
+			}
+			return 0, nil, ctxerr.Wrap(ctx, err, "get host by identifier")
+		}
+		if err := svc.authz.Authorize(ctx, host, fleet.ActionWrite); err != nil {
+			return 0, nil, err
+		}
+		hostIDs[i] = host.ID
+	}
+
+	var updated int
+	for i, u := range users {
+		if hostIDs[i] == 0 {
+			continue
+		}
+		user := &fleet.HostAssignedUser{Email: u.Email, FullName: u.FullName, Source: fleet.HostAssignedUserSourceIdPSync}
+		if err := svc.ds.SetHostAssignedUser(ctx, hostIDs[i], user); err != nil {
+			return 0, nil, ctxerr.Wrap(ctx, err, "set host assigned user")
+		}
+		updated++
+	}
+	return updated, notFound, nil
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // MDM
 ////////////////////////////////////////////////////////////////////////////////
@@ -1421,27 +1815,42 @@ func (svc *Service) OSVersions(ctx context.Context, teamID *uint, platform *stri
 ////////////////////////////////////////////////////////////////////////////////
 
 type getHostEncryptionKeyRequest struct {
-	ID uint `url:"id"`
+	ID            uint   `url:"id"`
+	Justification string `query:"justification,optional"`
 }
 
 type getHostEncryptionKeyResponse struct {
-	Err           error                        `json:"error,omitempty"`
-	EncryptionKey *fleet.HostDiskEncryptionKey `json:"encryption_key,omitempty"`
-	HostID        uint                         `json:"host_id,omitempty"`
+	mdmclient.GetHostEncryptionKeyResponse
+	Err error `json:"error,omitempty"`
 }
 
 func (r getHostEncryptionKeyResponse) error() error { return r.Err }
 
+func (r getHostEncryptionKeyResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
+	// the decrypted key must only be displayed for the duration of its TTL,
+	// it must not be cached by the browser or any proxy.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		logging.WithExtras(ctx, "encode_encryption_key_error", err)
+	}
+}
+
 func getHostEncryptionKey(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
 	req := request.(*getHostEncryptionKeyRequest)
-	key, err := svc.HostEncryptionKey(ctx, req.ID)
+	key, err := svc.HostEncryptionKey(ctx, req.ID, req.Justification)
 	if err != nil {
 		return getHostEncryptionKeyResponse{Err: err}, nil
 	}
-	return getHostEncryptionKeyResponse{EncryptionKey: key, HostID: req.ID}, nil
+	return getHostEncryptionKeyResponse{
+		GetHostEncryptionKeyResponse: mdmclient.GetHostEncryptionKeyResponse{EncryptionKey: key, HostID: req.ID},
+	}, nil
 }
 
-func (svc *Service) HostEncryptionKey(ctx context.Context, id uint) (*fleet.HostDiskEncryptionKey, error) {
+func (svc *Service) HostEncryptionKey(ctx context.Context, id uint, justification string) (*fleet.HostDiskEncryptionKey, error) {
 	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
 		return nil, err
 	}
@@ -1457,6 +1866,12 @@ func (svc *Service) HostEncryptionKey(ctx context.Context, id uint) (*fleet.Host
 		return nil, err
 	}
 
+	// reading a key is security-sensitive, the user must have logged in or
+	// re-authenticated recently.
+	if err := svc.requireSudoMode(ctx); err != nil {
+		return nil, err
+	}
+
 	key, err := svc.ds.GetHostDiskEncryptionKey(ctx, id)
 	if err != nil {
 		return nil, ctxerr.Wrap(ctx, err, "getting host encryption key")
@@ -1466,17 +1881,42 @@ func (svc *Service) HostEncryptionKey(ctx context.Context, id uint) (*fleet.Host
 		return nil, ctxerr.Wrap(ctx, newNotFoundError(), "getting host encryption key")
 	}
 
-	cert, _, _, err := svc.config.MDM.AppleSCEP()
+	certs, err := svc.config.MDM.AppleSCEPCertificates()
 	if err != nil {
 		return nil, ctxerr.Wrap(ctx, err, "getting host encryption key")
 	}
 
-	decryptedKey, err := apple_mdm.DecryptBase64CMS(key.Base64Encrypted, cert.Leaf, cert.PrivateKey)
+	decryptedKey, err := apple_mdm.DecryptBase64CMSWithCertificates(key.Base64Encrypted, certs)
 	if err != nil {
 		return nil, ctxerr.Wrap(ctx, err, "getting host encryption key")
 	}
 
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "getting app config")
+	}
 	key.DecryptedValue = string(decryptedKey)
+	expiresAt := svc.clock.Now().Add(appCfg.MDM.DiskEncryptionKeyViewTTL.ValueOr(fleet.DefaultDiskEncryptionKeyViewTTL))
+	key.ExpiresAt = &expiresAt
+
+	access := &fleet.HostDiskEncryptionKeyAccess{
+		HostID:        host.ID,
+		IPAddress:     publicip.FromContext(ctx),
+		Justification: justification,
+	}
+	if user := authz.UserFromContext(ctx); user != nil {
+		access.UserID = &user.ID
+		access.UserName = user.Name
+		access.UserEmail = user.Email
+	}
+	if err := svc.ds.NewHostDiskEncryptionKeyAccess(ctx, access); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "record host disk encryption key access")
+	}
+	if err := svc.ds.NewSecurityAuditLogEntry(ctx, fleet.NewSecurityAuditLogEntry(
+		fleet.SecurityAuditActionReadHostDiskEncryptionKey, authz.UserFromContext(ctx), publicip.FromContext(ctx), host,
+	)); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "record host disk encryption key read in security audit log")
+	}
 
 	err = svc.ds.NewActivity(
 		ctx,
@@ -1492,3 +1932,191 @@ func (svc *Service) HostEncryptionKey(ctx context.Context, id uint) (*fleet.Host
 
 	return key, nil
 }
+
+type listHostEncryptionKeyAccessesRequest struct {
+	ID          uint              `url:"id"`
+	ListOptions fleet.ListOptions `url:"list_options"`
+}
+
+type listHostEncryptionKeyAccessesResponse struct {
+	Meta     *fleet.PaginationMetadata            `json:"meta"`
+	Accesses []*fleet.HostDiskEncryptionKeyAccess `json:"accesses"`
+	Err      error                                `json:"error,omitempty"`
+}
+
+func (r listHostEncryptionKeyAccessesResponse) error() error { return r.Err }
+
+func listHostEncryptionKeyAccessesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listHostEncryptionKeyAccessesRequest)
+	accesses, meta, err := svc.ListHostEncryptionKeyAccesses(ctx, req.ID, req.ListOptions)
+	if err != nil {
+		return listHostEncryptionKeyAccessesResponse{Err: err}, nil
+	}
+	return listHostEncryptionKeyAccessesResponse{Meta: meta, Accesses: accesses}, nil
+}
+
+func (svc *Service) ListHostEncryptionKeyAccesses(ctx context.Context, id uint, opt fleet.ListOptions) ([]*fleet.HostDiskEncryptionKeyAccess, *fleet.PaginationMetadata, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, nil, err
+	}
+
+	host, err := svc.ds.HostLite(ctx, id)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list host encryption key accesses")
+	}
+
+	// the accesses can be listed by the same users that can read the key.
+	if err := svc.authz.Authorize(ctx, host, fleet.ActionRead); err != nil {
+		return nil, nil, err
+	}
+
+	accesses, meta, err := svc.ds.ListHostDiskEncryptionKeyAccesses(ctx, id, opt)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list host encryption key accesses")
+	}
+	return accesses, meta, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Host Activation Lock Bypass Code
+////////////////////////////////////////////////////////////////////////////////
+
+type getHostActivationLockBypassCodeRequest struct {
+	ID uint `url:"id"`
+}
+
+type getHostActivationLockBypassCodeResponse struct {
+	Err                      error                                  `json:"error,omitempty"`
+	ActivationLockBypassCode *fleet.HostMDMActivationLockBypassCode `json:"activation_lock_bypass_code,omitempty"`
+	HostID                   uint                                   `json:"host_id,omitempty"`
+}
+
+func (r getHostActivationLockBypassCodeResponse) error() error { return r.Err }
+
+func getHostActivationLockBypassCodeEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getHostActivationLockBypassCodeRequest)
+	code, err := svc.HostActivationLockBypassCode(ctx, req.ID)
+	if err != nil {
+		return getHostActivationLockBypassCodeResponse{Err: err}, nil
+	}
+	return getHostActivationLockBypassCodeResponse{ActivationLockBypassCode: code, HostID: req.ID}, nil
+}
+
+func (svc *Service) HostActivationLockBypassCode(ctx context.Context, id uint) (*fleet.HostMDMActivationLockBypassCode, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, err
+	}
+
+	host, err := svc.ds.HostLite(ctx, id)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "getting host activation lock bypass code")
+	}
+
+	// Unlike the encryption keys, only admins can read the bypass codes.
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleActivationLockBypassCodeAuthz{TeamID: host.TeamID}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	code, err := svc.ds.GetHostMDMActivationLockBypassCode(ctx, host.UUID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "getting host activation lock bypass code")
+	}
+	if err := svc.decryptActivationLockBypassCode(ctx, code); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "getting host activation lock bypass code")
+	}
+
+	if err := svc.ds.NewSecurityAuditLogEntry(ctx, fleet.NewSecurityAuditLogEntry(
+		fleet.SecurityAuditActionReadHostActivationLockBypassCode, authz.UserFromContext(ctx), publicip.FromContext(ctx), host,
+	)); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "record host activation lock bypass code read in security audit log")
+	}
+
+	err = svc.ds.NewActivity(
+		ctx,
+		authz.UserFromContext(ctx),
+		fleet.ActivityTypeReadHostActivationLockBypassCode{
+			HostID:          host.ID,
+			HostDisplayName: host.DisplayName(),
+		},
+	)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "create read host activation lock bypass code activity")
+	}
+
+	return code, nil
+}
+
+// decryptActivationLockBypassCode decrypts the bypass code with the SCEP CA.
+// A code escrowed in plain text before the codes were encrypted at rest is
+// encrypted and stored again.
+func (svc *Service) decryptActivationLockBypassCode(ctx context.Context, code *fleet.HostMDMActivationLockBypassCode) error {
+	certs, err := svc.config.MDM.AppleSCEPCertificates()
+	if err != nil {
+		return err
+	}
+
+	if code.Base64Encrypted == "" {
+		encrypted, err := apple_mdm.EncryptBase64WithCertificate([]byte(code.BypassCode), certs[0].Leaf)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "encrypt activation lock bypass code")
+		}
+		if err := svc.ds.SetHostMDMActivationLockBypassCode(ctx, code.HostUUID, encrypted); err != nil {
+			return ctxerr.Wrap(ctx, err, "store encrypted activation lock bypass code")
+		}
+		return nil
+	}
+
+	decrypted, err := apple_mdm.DecryptBase64WithCertificates(code.Base64Encrypted, certs)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "decrypt activation lock bypass code")
+	}
+	code.BypassCode = string(decrypted)
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Host Certificates
+////////////////////////////////////////////////////////////////////////////////
+
+type listHostCertificatesRequest struct {
+	ID          uint              `url:"id"`
+	ListOptions fleet.ListOptions `url:"list_options"`
+}
+
+type listHostCertificatesResponse struct {
+	Meta         *fleet.PaginationMetadata   `json:"meta"`
+	Certificates []*fleet.HostMDMCertificate `json:"certificates"`
+	Err          error                       `json:"error,omitempty"`
+}
+
+func (r listHostCertificatesResponse) error() error { return r.Err }
+
+func listHostCertificatesEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listHostCertificatesRequest)
+	certs, meta, err := svc.ListHostCertificates(ctx, req.ID, req.ListOptions)
+	if err != nil {
+		return listHostCertificatesResponse{Err: err}, nil
+	}
+	return listHostCertificatesResponse{Meta: meta, Certificates: certs}, nil
+}
+
+func (svc *Service) ListHostCertificates(ctx context.Context, id uint, opt fleet.ListOptions) ([]*fleet.HostMDMCertificate, *fleet.PaginationMetadata, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, nil, err
+	}
+
+	host, err := svc.ds.HostLite(ctx, id)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list host certificates")
+	}
+
+	if err := svc.authz.Authorize(ctx, host, fleet.ActionRead); err != nil {
+		return nil, nil, err
+	}
+
+	certs, meta, err := svc.ds.ListHostMDMAppleCertificates(ctx, host.UUID, opt)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list host certificates")
+	}
+	return certs, meta, nil
+}
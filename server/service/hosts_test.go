@@ -2,17 +2,20 @@ package service
 
 import (
 	"context"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/WatchBeam/clock"
 	"github.com/fleetdm/fleet/v4/server/authz"
 	"github.com/fleetdm/fleet/v4/server/config"
+	"github.com/fleetdm/fleet/v4/server/contexts/publicip"
 	"github.com/fleetdm/fleet/v4/server/contexts/viewer"
 	"github.com/fleetdm/fleet/v4/server/datastore/mysql"
 	"github.com/fleetdm/fleet/v4/server/fleet"
@@ -66,6 +69,10 @@ func TestHostDetails(t *testing.T) {
 	ds.ListHostBatteriesFunc = func(ctx context.Context, hostID uint) ([]*fleet.HostBattery, error) {
 		return dsBats, nil
 	}
+	expectedAssignedUser := &fleet.HostAssignedUser{Email: "jane@example.com", FullName: "Jane Doe", Source: fleet.HostAssignedUserSourceAPI}
+	ds.GetHostAssignedUserFunc = func(ctx context.Context, hostID uint) (*fleet.HostAssignedUser, error) {
+		return expectedAssignedUser, nil
+	}
 	// Health should be replaced at the service layer with custom values determined by the cycle count. See https://github.com/fleetdm/fleet/issues/6763.
 	expectedBats := []*fleet.HostBattery{{HostID: host.ID, SerialNumber: "a", CycleCount: 999, Health: "Normal"}, {HostID: host.ID, SerialNumber: "b", CycleCount: 1001, Health: "Replacement recommended"}}
 
@@ -79,9 +86,118 @@ func TestHostDetails(t *testing.T) {
 	assert.Equal(t, expectedPacks, hostDetail.Packs)
 	require.NotNil(t, hostDetail.Batteries)
 	assert.Equal(t, expectedBats, *hostDetail.Batteries)
+	assert.Equal(t, expectedAssignedUser, hostDetail.AssignedUser)
 	require.Nil(t, hostDetail.MDM.MacOSSettings)
 }
 
+func TestHostDetailsMDMDEPDevice(t *testing.T) {
+	ds := new(mock.Store)
+	svc := &Service{ds: ds}
+
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{MDM: fleet.MDM{AppleBMEnabledAndConfigured: true}}, nil
+	}
+	ds.ListLabelsForHostFunc = func(ctx context.Context, hid uint) ([]*fleet.Label, error) {
+		return nil, nil
+	}
+	ds.ListPacksForHostFunc = func(ctx context.Context, hid uint) ([]*fleet.Pack, error) {
+		return nil, nil
+	}
+	ds.LoadHostSoftwareFunc = func(ctx context.Context, host *fleet.Host, includeCVEScores bool) error {
+		return nil
+	}
+	ds.ListHostBatteriesFunc = func(ctx context.Context, hostID uint) ([]*fleet.HostBattery, error) {
+		return nil, nil
+	}
+	ds.GetHostAssignedUserFunc = func(ctx context.Context, hostID uint) (*fleet.HostAssignedUser, error) {
+		return nil, &notFoundError{}
+	}
+	dev := &fleet.HostMDMAppleDEPDevice{HostID: 3, Description: "MBP 13.3 SPG", Color: "SPACE GRAY", AssetTag: "A-123"}
+	ds.GetHostMDMAppleDEPDeviceFunc = func(ctx context.Context, hostID uint) (*fleet.HostMDMAppleDEPDevice, error) {
+		if hostID != dev.HostID {
+			return nil, &notFoundError{}
+		}
+		return dev, nil
+	}
+	override := &fleet.HostMDMAppleDEPProfileOverride{HostID: 3, ProfileUUID: "abc"}
+	ds.GetHostMDMAppleDEPProfileOverrideFunc = func(ctx context.Context, hostID uint) (*fleet.HostMDMAppleDEPProfileOverride, error) {
+		if hostID != override.HostID {
+			return nil, &notFoundError{}
+		}
+		return override, nil
+	}
+
+	ctx := test.UserContext(context.Background(), test.UserAdmin)
+	cases := []struct {
+		host         *fleet.Host
+		want         *fleet.HostMDMAppleDEPDevice
+		wantOverride *fleet.HostMDMAppleDEPProfileOverride
+	}{
+		{&fleet.Host{ID: 3, Platform: "darwin"}, dev, override},
+		{&fleet.Host{ID: 4, Platform: "darwin"}, nil, nil},
+		{&fleet.Host{ID: 3, Platform: "windows"}, nil, nil},
+	}
+	for _, c := range cases {
+		ds.GetHostMDMAppleDEPDeviceFuncInvoked = false
+		hostDetail, err := svc.getHostDetails(ctx, c.host, fleet.HostDetailOptions{})
+		require.NoError(t, err)
+		require.Equal(t, c.want, hostDetail.MDM.DEPDevice)
+		require.Equal(t, c.wantOverride, hostDetail.MDM.DEPProfileOverride)
+		require.Equal(t, c.host.Platform == "darwin", ds.GetHostMDMAppleDEPDeviceFuncInvoked)
+	}
+}
+
+func TestHostDetailsMDMPushFailure(t *testing.T) {
+	ds := new(mock.Store)
+	svc := &Service{ds: ds}
+
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{MDM: fleet.MDM{EnabledAndConfigured: true}}, nil
+	}
+	ds.ListLabelsForHostFunc = func(ctx context.Context, hid uint) ([]*fleet.Label, error) {
+		return nil, nil
+	}
+	ds.ListPacksForHostFunc = func(ctx context.Context, hid uint) ([]*fleet.Pack, error) {
+		return nil, nil
+	}
+	ds.LoadHostSoftwareFunc = func(ctx context.Context, host *fleet.Host, includeCVEScores bool) error {
+		return nil
+	}
+	ds.ListHostBatteriesFunc = func(ctx context.Context, hostID uint) ([]*fleet.HostBattery, error) {
+		return nil, nil
+	}
+	ds.GetHostAssignedUserFunc = func(ctx context.Context, hostID uint) (*fleet.HostAssignedUser, error) {
+		return nil, &notFoundError{}
+	}
+	ds.GetHostMDMProfilesFunc = func(ctx context.Context, hostUUID string) ([]fleet.HostMDMAppleProfile, error) {
+		return nil, nil
+	}
+	failure := &fleet.HostMDMApplePushFailure{FailureCount: 2, LastError: "device token is inactive", TokenInvalid: true, LastFailedAt: time.Now()}
+	ds.GetHostMDMApplePushFailureFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMApplePushFailure, error) {
+		if hostUUID != "failing" {
+			return nil, &notFoundError{}
+		}
+		return failure, nil
+	}
+
+	ctx := test.UserContext(context.Background(), test.UserAdmin)
+	cases := []struct {
+		host *fleet.Host
+		want *fleet.HostMDMApplePushFailure
+	}{
+		{&fleet.Host{ID: 1, UUID: "failing", Platform: "darwin"}, failure},
+		{&fleet.Host{ID: 2, UUID: "ok", Platform: "darwin"}, nil},
+		{&fleet.Host{ID: 3, UUID: "failing", Platform: "windows"}, nil},
+	}
+	for _, c := range cases {
+		ds.GetHostMDMApplePushFailureFuncInvoked = false
+		hostDetail, err := svc.getHostDetails(ctx, c.host, fleet.HostDetailOptions{})
+		require.NoError(t, err)
+		require.Equal(t, c.want, hostDetail.MDM.PushFailure)
+		require.Equal(t, c.host.Platform == "darwin", ds.GetHostMDMApplePushFailureFuncInvoked)
+	}
+}
+
 func TestHostDetailsMDMDiskEncryption(t *testing.T) {
 	ds := new(mock.Store)
 	svc := &Service{ds: ds}
@@ -104,6 +220,9 @@ func TestHostDetailsMDMDiskEncryption(t *testing.T) {
 	ds.ListHostBatteriesFunc = func(ctx context.Context, hostID uint) ([]*fleet.HostBattery, error) {
 		return nil, nil
 	}
+	ds.GetHostAssignedUserFunc = func(ctx context.Context, hostID uint) (*fleet.HostAssignedUser, error) {
+		return nil, &notFoundError{}
+	}
 
 	cases := []struct {
 		name       string
@@ -379,12 +498,21 @@ func TestHostAuth(t *testing.T) {
 	ds.AddHostsToTeamFunc = func(ctx context.Context, teamID *uint, hostIDs []uint) error {
 		return nil
 	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: tid}, nil
+	}
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		return nil
+	}
 	ds.ListPoliciesForHostFunc = func(ctx context.Context, host *fleet.Host) ([]*fleet.HostPolicy, error) {
 		return nil, nil
 	}
 	ds.ListHostBatteriesFunc = func(ctx context.Context, hostID uint) ([]*fleet.HostBattery, error) {
 		return nil, nil
 	}
+	ds.GetHostAssignedUserFunc = func(ctx context.Context, hostID uint) (*fleet.HostAssignedUser, error) {
+		return nil, &notFoundError{}
+	}
 	ds.DeleteHostsFunc = func(ctx context.Context, ids []uint) error {
 		return nil
 	}
@@ -399,6 +527,9 @@ func TestHostAuth(t *testing.T) {
 	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
 		return nil
 	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		return 0, nil
+	}
 
 	testCases := []struct {
 		name                  string
@@ -545,6 +676,16 @@ func TestGetHostSummary(t *testing.T) {
 			Platforms:        []*fleet.HostSummaryPlatform{{Platform: "darwin", HostsCount: 1}, {Platform: "debian", HostsCount: 2}, {Platform: "centos", HostsCount: 3}, {Platform: "ubuntu", HostsCount: 4}},
 		}, nil
 	}
+	ds.GenerateHostMDMStatisticsFunc = func(ctx context.Context, filter fleet.TeamFilter, platform *string) (*fleet.HostSummaryMDM, error) {
+		return &fleet.HostSummaryMDM{
+			EnrolledCount:          3,
+			EnrolledManualCount:    1,
+			EnrolledAutomatedCount: 2,
+			PendingCount:           1,
+			UnenrolledCount:        1,
+			ProfilesFailedCount:    2,
+		}, nil
+	}
 	ds.LabelsSummaryFunc = func(ctx context.Context) ([]*fleet.LabelSummary, error) {
 		return []*fleet.LabelSummary{{ID: 1, Name: "All hosts", Description: "All hosts enrolled in Fleet", LabelType: fleet.LabelTypeBuiltIn}, {ID: 10, Name: "Other label", Description: "Not a builtin label", LabelType: fleet.LabelTypeRegular}}, nil
 	}
@@ -562,6 +703,10 @@ func TestGetHostSummary(t *testing.T) {
 	require.Nil(t, summary.LowDiskSpaceCount)
 	require.Len(t, summary.BuiltinLabels, 1)
 	require.Equal(t, "All hosts", summary.BuiltinLabels[0].Name)
+	require.NotNil(t, summary.MDM)
+	require.Equal(t, uint(3), summary.MDM.EnrolledCount)
+	require.Equal(t, uint(1), summary.MDM.PendingCount)
+	require.Equal(t, uint(2), summary.MDM.ProfilesFailedCount)
 
 	// a user is required
 	_, err = svc.GetHostSummary(ctx, nil, nil, nil)
@@ -610,10 +755,19 @@ func TestAddHostsToTeamByFilter(t *testing.T) {
 	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
 		return nil
 	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		return 0, nil
+	}
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		require.Equal(t, test.UserAdmin, user)
+		require.Equal(t, &fleet.ActivityTypeTransferredHostsToTeam{HostIDs: expectedHostIDs}, activity)
+		return nil
+	}
 
 	require.NoError(t, svc.AddHostsToTeamByFilter(test.UserContext(ctx, test.UserAdmin), expectedTeam, fleet.HostListOptions{}, nil))
 	assert.True(t, ds.ListHostsFuncInvoked)
 	assert.True(t, ds.AddHostsToTeamFuncInvoked)
+	assert.True(t, ds.NewActivityFuncInvoked)
 }
 
 func TestAddHostsToTeamByFilterLabel(t *testing.T) {
@@ -639,6 +793,20 @@ func TestAddHostsToTeamByFilterLabel(t *testing.T) {
 	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
 		return nil
 	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		return 0, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		return &fleet.Team{ID: tid, Name: "team1"}, nil
+	}
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		require.Equal(t, &fleet.ActivityTypeTransferredHostsToTeam{
+			TeamID:   expectedTeam,
+			TeamName: ptr.String("team1"),
+			HostIDs:  expectedHostIDs,
+		}, activity)
+		return nil
+	}
 
 	require.NoError(t, svc.AddHostsToTeamByFilter(test.UserContext(ctx, test.UserAdmin), expectedTeam, fleet.HostListOptions{}, expectedLabel))
 	assert.True(t, ds.ListHostsInLabelFuncInvoked)
@@ -658,6 +826,9 @@ func TestAddHostsToTeamByFilterEmptyHosts(t *testing.T) {
 	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
 		return nil
 	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		return 0, nil
+	}
 
 	require.NoError(t, svc.AddHostsToTeamByFilter(test.UserContext(ctx, test.UserAdmin), nil, fleet.HostListOptions{}, nil))
 	assert.True(t, ds.ListHostsFuncInvoked)
@@ -781,6 +952,15 @@ func TestEmptyTeamOSVersions(t *testing.T) {
 	require.Equal(t, "some unknown error", fmt.Sprint(err))
 }
 
+// sudoUserContext returns a context with the provided user logged in a
+// session that is in sudo mode.
+func sudoUserContext(ctx context.Context, user *fleet.User) context.Context {
+	return viewer.NewContext(ctx, viewer.Viewer{
+		User:    user,
+		Session: &fleet.Session{ID: 1, UserID: user.ID, CreateTimestamp: fleet.CreateTimestamp{CreatedAt: time.Now()}},
+	})
+}
+
 func TestHostEncryptionKey(t *testing.T) {
 	cases := []struct {
 		name            string
@@ -885,23 +1065,75 @@ func TestHostEncryptionKey(t *testing.T) {
 				return nil
 			}
 
+			ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+				return &fleet.AppConfig{}, nil
+			}
+
+			var accesses []*fleet.HostDiskEncryptionKeyAccess
+			ds.NewHostDiskEncryptionKeyAccessFunc = func(ctx context.Context, access *fleet.HostDiskEncryptionKeyAccess) error {
+				accesses = append(accesses, access)
+				return nil
+			}
+
+			var auditEntries []*fleet.SecurityAuditLogEntry
+			ds.NewSecurityAuditLogEntryFunc = func(ctx context.Context, entry *fleet.SecurityAuditLogEntry) error {
+				auditEntries = append(auditEntries, entry)
+				return nil
+			}
+
 			t.Run("allowed users", func(t *testing.T) {
+				accesses = nil
 				for _, u := range tt.allowedUsers {
-					_, err := svc.HostEncryptionKey(test.UserContext(ctx, u), tt.host.ID)
+					uctx := publicip.NewContext(sudoUserContext(ctx, u), "1.2.3.4")
+					key, err := svc.HostEncryptionKey(uctx, tt.host.ID, "ticket "+u.Name)
 					require.NoError(t, err)
+					require.Equal(t, recoveryKey, key.DecryptedValue)
+					require.NotNil(t, key.ExpiresAt)
+					require.WithinDuration(t, time.Now().Add(fleet.DefaultDiskEncryptionKeyViewTTL), *key.ExpiresAt, time.Minute)
+				}
+				require.Len(t, accesses, len(tt.allowedUsers))
+				for i, u := range tt.allowedUsers {
+					require.Equal(t, tt.host.ID, accesses[i].HostID)
+					require.Equal(t, &u.ID, accesses[i].UserID)
+					require.Equal(t, u.Email, accesses[i].UserEmail)
+					require.Equal(t, "1.2.3.4", accesses[i].IPAddress)
+					require.Equal(t, "ticket "+u.Name, accesses[i].Justification)
+				}
+				// the reads are recorded in the security audit log
+				require.Len(t, auditEntries, len(tt.allowedUsers))
+				for i, u := range tt.allowedUsers {
+					require.Equal(t, fleet.SecurityAuditActionReadHostDiskEncryptionKey, auditEntries[i].Action)
+					require.Equal(t, tt.host.ID, auditEntries[i].HostID)
+					require.Equal(t, u.Email, auditEntries[i].UserEmail)
+					require.Equal(t, "1.2.3.4", auditEntries[i].IPAddress)
 				}
 			})
 
 			t.Run("disallowed users", func(t *testing.T) {
 				for _, u := range tt.disallowedUsers {
-					_, err := svc.HostEncryptionKey(test.UserContext(ctx, u), tt.host.ID)
+					_, err := svc.HostEncryptionKey(sudoUserContext(ctx, u), tt.host.ID, "")
 					require.Error(t, err)
 					require.Contains(t, authz.ForbiddenErrorMessage, err.Error())
 				}
 			})
 
+			t.Run("session not in sudo mode", func(t *testing.T) {
+				accesses = nil
+				u := tt.allowedUsers[0]
+				_, err := svc.HostEncryptionKey(test.UserContext(ctx, u), tt.host.ID, "")
+				require.ErrorIs(t, err, fleet.ErrSudoModeRequired)
+
+				uctx := viewer.NewContext(ctx, viewer.Viewer{
+					User:    u,
+					Session: &fleet.Session{ID: 1, UserID: u.ID, CreateTimestamp: fleet.CreateTimestamp{CreatedAt: time.Now().Add(-time.Hour)}},
+				})
+				_, err = svc.HostEncryptionKey(uctx, tt.host.ID, "")
+				require.ErrorIs(t, err, fleet.ErrSudoModeRequired)
+				require.Empty(t, accesses)
+			})
+
 			t.Run("no user in context", func(t *testing.T) {
-				_, err := svc.HostEncryptionKey(ctx, tt.host.ID)
+				_, err := svc.HostEncryptionKey(ctx, tt.host.ID, "")
 				require.Error(t, err)
 				require.Contains(t, authz.ForbiddenErrorMessage, err.Error())
 			})
@@ -911,13 +1143,13 @@ func TestHostEncryptionKey(t *testing.T) {
 	t.Run("test error cases", func(t *testing.T) {
 		ds := new(mock.Store)
 		svc, ctx := newTestService(t, ds, nil, nil)
-		ctx = test.UserContext(ctx, test.UserAdmin)
+		ctx = sudoUserContext(ctx, test.UserAdmin)
 
 		hostErr := errors.New("host error")
 		ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
 			return nil, hostErr
 		}
-		_, err := svc.HostEncryptionKey(ctx, 1)
+		_, err := svc.HostEncryptionKey(ctx, 1, "")
 		require.ErrorIs(t, err, hostErr)
 		ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
 			return &fleet.Host{}, nil
@@ -927,7 +1159,7 @@ func TestHostEncryptionKey(t *testing.T) {
 		ds.GetHostDiskEncryptionKeyFunc = func(ctx context.Context, id uint) (*fleet.HostDiskEncryptionKey, error) {
 			return nil, keyErr
 		}
-		_, err = svc.HostEncryptionKey(ctx, 1)
+		_, err = svc.HostEncryptionKey(ctx, 1, "")
 		require.ErrorIs(t, err, keyErr)
 		ds.GetHostDiskEncryptionKeyFunc = func(ctx context.Context, id uint) (*fleet.HostDiskEncryptionKey, error) {
 			return &fleet.HostDiskEncryptionKey{Base64Encrypted: "key"}, nil
@@ -937,7 +1169,380 @@ func TestHostEncryptionKey(t *testing.T) {
 			return errors.New("activity error")
 		}
 
-		_, err = svc.HostEncryptionKey(ctx, 1)
+		_, err = svc.HostEncryptionKey(ctx, 1, "")
 		require.Error(t, err)
 	})
 }
+
+func TestHostActivationLockBypassCode(t *testing.T) {
+	globalHost := &fleet.Host{ID: 1, Hostname: "test_hostname", UUID: "test_uuid"}
+	teamHost := &fleet.Host{ID: 2, Hostname: "test_hostname_2", UUID: "test_uuid_2", TeamID: ptr.Uint(1)}
+
+	testCert, testKey, err := apple_mdm.NewSCEPCACertKey()
+	require.NoError(t, err)
+	fleetCfg := config.TestConfig()
+	config.SetTestMDMConfig(t, &fleetCfg, tokenpki.PEMCertificate(testCert.Raw), tokenpki.PEMRSAPrivateKey(testKey), nil)
+
+	encryptedCode, err := apple_mdm.EncryptBase64WithCertificate([]byte("AAAA-BBBB"), testCert)
+	require.NoError(t, err)
+
+	ds := new(mock.Store)
+	svc, ctx := newTestServiceWithConfig(t, ds, fleetCfg, nil, nil)
+
+	ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
+		if id == globalHost.ID {
+			return globalHost, nil
+		}
+		return teamHost, nil
+	}
+	ds.GetHostMDMActivationLockBypassCodeFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMActivationLockBypassCode, error) {
+		return &fleet.HostMDMActivationLockBypassCode{HostUUID: hostUUID, Base64Encrypted: encryptedCode}, nil
+	}
+	var readHostIDs []uint
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		act := activity.(fleet.ActivityTypeReadHostActivationLockBypassCode)
+		readHostIDs = append(readHostIDs, act.HostID)
+		return nil
+	}
+	var auditHostIDs []uint
+	ds.NewSecurityAuditLogEntryFunc = func(ctx context.Context, entry *fleet.SecurityAuditLogEntry) error {
+		require.Equal(t, fleet.SecurityAuditActionReadHostActivationLockBypassCode, entry.Action)
+		auditHostIDs = append(auditHostIDs, entry.HostID)
+		return nil
+	}
+
+	cases := []struct {
+		user          *fleet.User
+		allowedGlobal bool
+		allowedTeam   bool
+	}{
+		{test.UserAdmin, true, true},
+		{test.UserMaintainer, false, false},
+		{test.UserObserver, false, false},
+		{test.UserObserverPlus, false, false},
+		{test.UserTeamAdminTeam1, false, true},
+		{test.UserTeamMaintainerTeam1, false, false},
+		{test.UserTeamObserverTeam1, false, false},
+		{test.UserTeamAdminTeam2, false, false},
+		{test.UserNoRoles, false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.user.Email, func(t *testing.T) {
+			readHostIDs, auditHostIDs = nil, nil
+			for _, h := range []struct {
+				host    *fleet.Host
+				allowed bool
+			}{{globalHost, c.allowedGlobal}, {teamHost, c.allowedTeam}} {
+				code, err := svc.HostActivationLockBypassCode(test.UserContext(ctx, c.user), h.host.ID)
+				if h.allowed {
+					require.NoError(t, err)
+					require.Equal(t, "AAAA-BBBB", code.BypassCode)
+					require.Contains(t, readHostIDs, h.host.ID)
+					require.Contains(t, auditHostIDs, h.host.ID)
+				} else {
+					require.Error(t, err)
+					require.Contains(t, authz.ForbiddenErrorMessage, err.Error())
+					require.NotContains(t, readHostIDs, h.host.ID)
+					require.NotContains(t, auditHostIDs, h.host.ID)
+				}
+			}
+		})
+	}
+
+	// a code escrowed in plain text is returned and stored encrypted
+	ds.GetHostMDMActivationLockBypassCodeFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMActivationLockBypassCode, error) {
+		return &fleet.HostMDMActivationLockBypassCode{HostUUID: hostUUID, BypassCode: "CCCC-DDDD"}, nil
+	}
+	var stored string
+	ds.SetHostMDMActivationLockBypassCodeFunc = func(ctx context.Context, hostUUID, base64Encrypted string) error {
+		require.Equal(t, globalHost.UUID, hostUUID)
+		stored = base64Encrypted
+		return nil
+	}
+	code, err := svc.HostActivationLockBypassCode(test.UserContext(ctx, test.UserAdmin), globalHost.ID)
+	require.NoError(t, err)
+	require.Equal(t, "CCCC-DDDD", code.BypassCode)
+	decrypted, err := apple_mdm.DecryptBase64WithCertificates(stored, []*tls.Certificate{{Certificate: [][]byte{testCert.Raw}, PrivateKey: testKey, Leaf: testCert}})
+	require.NoError(t, err)
+	require.Equal(t, "CCCC-DDDD", string(decrypted))
+
+	// no code escrowed for the host
+	ds.GetHostMDMActivationLockBypassCodeFunc = func(ctx context.Context, hostUUID string) (*fleet.HostMDMActivationLockBypassCode, error) {
+		return nil, &notFoundError{}
+	}
+	_, err = svc.HostActivationLockBypassCode(test.UserContext(ctx, test.UserAdmin), globalHost.ID)
+	require.True(t, fleet.IsNotFound(err))
+}
+
+func TestListHostCertificates(t *testing.T) {
+	globalHost := &fleet.Host{ID: 1, Hostname: "test_hostname", UUID: "test_uuid"}
+	teamHost := &fleet.Host{ID: 2, Hostname: "test_hostname_2", UUID: "test_uuid_2", TeamID: ptr.Uint(1)}
+
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil)
+
+	ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
+		if id == globalHost.ID {
+			return globalHost, nil
+		}
+		return teamHost, nil
+	}
+	ds.ListHostMDMAppleCertificatesFunc = func(ctx context.Context, hostUUID string, opt fleet.ListOptions) ([]*fleet.HostMDMCertificate, *fleet.PaginationMetadata, error) {
+		return []*fleet.HostMDMCertificate{{HostUUID: hostUUID, CommonName: "Wi-Fi"}}, &fleet.PaginationMetadata{}, nil
+	}
+
+	cases := []struct {
+		user          *fleet.User
+		allowedGlobal bool
+		allowedTeam   bool
+	}{
+		{test.UserAdmin, true, true},
+		{test.UserMaintainer, true, true},
+		{test.UserObserver, true, true},
+		{test.UserTeamAdminTeam1, false, true},
+		{test.UserTeamObserverTeam1, false, true},
+		{test.UserTeamAdminTeam2, false, false},
+		{test.UserNoRoles, false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.user.Email, func(t *testing.T) {
+			for _, h := range []struct {
+				host    *fleet.Host
+				allowed bool
+			}{{globalHost, c.allowedGlobal}, {teamHost, c.allowedTeam}} {
+				certs, _, err := svc.ListHostCertificates(test.UserContext(ctx, c.user), h.host.ID, fleet.ListOptions{})
+				if h.allowed {
+					require.NoError(t, err)
+					require.Len(t, certs, 1)
+					require.Equal(t, h.host.UUID, certs[0].HostUUID)
+				} else {
+					require.Error(t, err)
+					require.Contains(t, authz.ForbiddenErrorMessage, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestHostCustomAttributes(t *testing.T) {
+	globalHost := &fleet.Host{ID: 1, Hostname: "test_hostname", UUID: "test_uuid"}
+	teamHost := &fleet.Host{ID: 2, Hostname: "test_hostname_2", UUID: "test_uuid_2", TeamID: ptr.Uint(1)}
+
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil)
+
+	ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
+		if id == globalHost.ID {
+			return globalHost, nil
+		}
+		return teamHost, nil
+	}
+	ds.ListHostCustomAttributesFunc = func(ctx context.Context, hostID uint) ([]*fleet.HostCustomAttribute, error) {
+		return []*fleet.HostCustomAttribute{{Name: "cost_center", Value: "CC-1", Source: fleet.HostCustomAttributeSourceAPI}}, nil
+	}
+	var setAttrs map[string]*string
+	ds.SetHostCustomAttributesFunc = func(ctx context.Context, hostID uint, attrs map[string]*string, source string) error {
+		require.Equal(t, fleet.HostCustomAttributeSourceAPI, source)
+		setAttrs = attrs
+		return nil
+	}
+
+	cases := []struct {
+		user        *fleet.User
+		readGlobal  bool
+		readTeam    bool
+		writeGlobal bool
+		writeTeam   bool
+	}{
+		{test.UserAdmin, true, true, true, true},
+		{test.UserMaintainer, true, true, true, true},
+		{test.UserObserver, true, true, false, false},
+		{test.UserTeamAdminTeam1, false, true, false, true},
+		{test.UserTeamMaintainerTeam1, false, true, false, true},
+		{test.UserTeamObserverTeam1, false, true, false, false},
+		{test.UserTeamAdminTeam2, false, false, false, false},
+		{test.UserNoRoles, false, false, false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.user.Email, func(t *testing.T) {
+			userCtx := test.UserContext(ctx, c.user)
+			for _, h := range []struct {
+				host        *fleet.Host
+				read, write bool
+			}{{globalHost, c.readGlobal, c.writeGlobal}, {teamHost, c.readTeam, c.writeTeam}} {
+				attrs, err := svc.ListHostCustomAttributes(userCtx, h.host.ID)
+				if h.read {
+					require.NoError(t, err)
+					require.Len(t, attrs, 1)
+				} else {
+					require.Error(t, err)
+					require.Contains(t, authz.ForbiddenErrorMessage, err.Error())
+				}
+
+				err = svc.SetHostCustomAttributes(userCtx, h.host.ID, map[string]*string{"Cost_Center": ptr.String("CC-2"), "owner": nil})
+				if h.write {
+					require.NoError(t, err)
+					require.Equal(t, map[string]*string{"cost_center": ptr.String("CC-2"), "owner": nil}, setAttrs)
+				} else {
+					require.Error(t, err)
+					require.Contains(t, authz.ForbiddenErrorMessage, err.Error())
+				}
+			}
+		})
+	}
+
+	// invalid names and values are rejected
+	adminCtx := test.UserContext(ctx, test.UserAdmin)
+	for _, attrs := range []map[string]*string{
+		{"1abc": ptr.String("a")},
+		{"cost-center": ptr.String("a")},
+		{"": ptr.String("a")},
+		{strings.Repeat("a", 65): ptr.String("a")},
+		{"cost_center": ptr.String(strings.Repeat("a", fleet.HostCustomAttributeMaxValueLength+1))},
+	} {
+		err := svc.SetHostCustomAttributes(adminCtx, globalHost.ID, attrs)
+		var iae *fleet.InvalidArgumentError
+		require.ErrorAs(t, err, &iae)
+	}
+}
+
+func TestHostAssignedUser(t *testing.T) {
+	globalHost := &fleet.Host{ID: 1, Hostname: "test_hostname", UUID: "test_uuid"}
+	teamHost := &fleet.Host{ID: 2, Hostname: "test_hostname_2", UUID: "test_uuid_2", TeamID: ptr.Uint(1)}
+
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil)
+
+	ds.HostLiteFunc = func(ctx context.Context, id uint) (*fleet.Host, error) {
+		if id == globalHost.ID {
+			return globalHost, nil
+		}
+		return teamHost, nil
+	}
+	ds.HostByIdentifierFunc = func(ctx context.Context, identifier string) (*fleet.Host, error) {
+		switch identifier {
+		case globalHost.UUID:
+			return globalHost, nil
+		case teamHost.UUID:
+			return teamHost, nil
+		}
+		return nil, &notFoundError{}
+	}
+	ds.GetHostAssignedUserFunc = func(ctx context.Context, hostID uint) (*fleet.HostAssignedUser, error) {
+		if hostID == globalHost.ID {
+			return nil, &notFoundError{}
+		}
+		return &fleet.HostAssignedUser{HostID: hostID, Email: "jane@example.com", Source: fleet.HostAssignedUserSourceSSO}, nil
+	}
+	setUsers := make(map[uint]*fleet.HostAssignedUser)
+	ds.SetHostAssignedUserFunc = func(ctx context.Context, hostID uint, user *fleet.HostAssignedUser) error {
+		setUsers[hostID] = user
+		return nil
+	}
+
+	cases := []struct {
+		user        *fleet.User
+		readGlobal  bool
+		readTeam    bool
+		writeGlobal bool
+		writeTeam   bool
+	}{
+		{test.UserAdmin, true, true, true, true},
+		{test.UserMaintainer, true, true, true, true},
+		{test.UserObserver, true, true, false, false},
+		{test.UserTeamAdminTeam1, false, true, false, true},
+		{test.UserTeamMaintainerTeam1, false, true, false, true},
+		{test.UserTeamObserverTeam1, false, true, false, false},
+		{test.UserTeamAdminTeam2, false, false, false, false},
+		{test.UserNoRoles, false, false, false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.user.Email, func(t *testing.T) {
+			userCtx := test.UserContext(ctx, c.user)
+			for _, h := range []struct {
+				host        *fleet.Host
+				read, write bool
+			}{{globalHost, c.readGlobal, c.writeGlobal}, {teamHost, c.readTeam, c.writeTeam}} {
+				user, err := svc.GetHostAssignedUser(userCtx, h.host.ID)
+				if h.read {
+					require.NoError(t, err)
+					if h.host == globalHost {
+						require.Nil(t, user)
+					} else {
+						require.Equal(t, "jane@example.com", user.Email)
+					}
+				} else {
+					require.Error(t, err)
+					require.Contains(t, authz.ForbiddenErrorMessage, err.Error())
+				}
+
+				delete(setUsers, h.host.ID)
+				err = svc.SetHostAssignedUser(userCtx, h.host.ID, " john@example.com ", "John Doe")
+				if h.write {
+					require.NoError(t, err)
+					require.Equal(t, &fleet.HostAssignedUser{Email: "john@example.com", FullName: "John Doe", Source: fleet.HostAssignedUserSourceAPI}, setUsers[h.host.ID])
+				} else {
+					require.Error(t, err)
+					require.Contains(t, authz.ForbiddenErrorMessage, err.Error())
+				}
+
+				err = svc.DeleteHostAssignedUser(userCtx, h.host.ID)
+				if h.write {
+					require.NoError(t, err)
+					require.Contains(t, setUsers, h.host.ID)
+					require.Nil(t, setUsers[h.host.ID])
+				} else {
+					require.Error(t, err)
+					require.Contains(t, authz.ForbiddenErrorMessage, err.Error())
+				}
+
+				_, _, err = svc.SyncHostAssignedUsers(userCtx, []fleet.HostAssignedUserSync{{HostIdentifier: h.host.UUID, Email: "jane@example.com"}})
+				if h.write {
+					require.NoError(t, err)
+				} else {
+					require.Error(t, err)
+					require.Contains(t, authz.ForbiddenErrorMessage, err.Error())
+				}
+			}
+		})
+	}
+
+	adminCtx := test.UserContext(ctx, test.UserAdmin)
+
+	// invalid users are rejected
+	for _, u := range []struct{ email, fullName string }{
+		{"", "Jane"},
+		{"jane", "Jane"},
+		{"Jane <jane@example.com>", "Jane"},
+		{"jane@example.com", strings.Repeat("a", fleet.HostAssignedUserMaxFullNameLength+1)},
+	} {
+		err := svc.SetHostAssignedUser(adminCtx, globalHost.ID, u.email, u.fullName)
+		var iae *fleet.InvalidArgumentError
+		require.ErrorAs(t, err, &iae)
+	}
+
+	// sync reports the hosts not found and records the IdP sync source
+	setUsers = make(map[uint]*fleet.HostAssignedUser)
+	updated, notFound, err := svc.SyncHostAssignedUsers(adminCtx, []fleet.HostAssignedUserSync{
+		{HostIdentifier: globalHost.UUID, Email: "jane@example.com", FullName: "Jane Doe"},
+		{HostIdentifier: "no-such-host", Email: "john@example.com"},
+		{HostIdentifier: teamHost.UUID, Email: "john@example.com"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, updated)
+	require.Equal(t, []string{"no-such-host"}, notFound)
+	require.Equal(t, &fleet.HostAssignedUser{Email: "jane@example.com", FullName: "Jane Doe", Source: fleet.HostAssignedUserSourceIdPSync}, setUsers[globalHost.ID])
+	require.Equal(t, &fleet.HostAssignedUser{Email: "john@example.com", Source: fleet.HostAssignedUserSourceIdPSync}, setUsers[teamHost.ID])
+
+	// an invalid entry rejects the whole batch
+	setUsers = make(map[uint]*fleet.HostAssignedUser)
+	_, _, err = svc.SyncHostAssignedUsers(adminCtx, []fleet.HostAssignedUserSync{
+		{HostIdentifier: globalHost.UUID, Email: "jane@example.com"},
+		{HostIdentifier: teamHost.UUID, Email: "john"},
+	})
+	var iae *fleet.InvalidArgumentError
+	require.ErrorAs(t, err, &iae)
+	require.Empty(t, setUsers)
+	_, _, err = svc.SyncHostAssignedUsers(adminCtx, make([]fleet.HostAssignedUserSync, maxSyncHostAssignedUsers+1))
+	require.ErrorAs(t, err, &iae)
+}
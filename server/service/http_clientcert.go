@@ -0,0 +1,72 @@
+package service
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/clientcert"
+)
+
+// clientCertificates returns a middleware that stores the client
+// certificates of the request in the context: the ones presented in the TLS
+// handshake with the Fleet server or, if there are none, the one forwarded
+// in header by a trusted TLS-terminating proxy. It must run before publicIP,
+// which overwrites the remote address of the request.
+func clientCertificates(header string, trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var certs []*x509.Certificate
+			if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+				certs = r.TLS.PeerCertificates
+			} else if header != "" && isTrustedProxy(r.RemoteAddr, trustedProxies) {
+				certs = parseForwardedClientCert(r.Header.Get(header))
+			}
+			if len(certs) > 0 {
+				r = r.WithContext(clientcert.NewContext(r.Context(), certs))
+			}
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isTrustedProxy(remoteAddr string, trustedProxies []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwardedClientCert parses the URL-encoded PEM certificate forwarded
+// by a proxy, as nginx's $ssl_client_escaped_cert. It returns nil if the
+// value is not a valid certificate.
+func parseForwardedClientCert(v string) []*x509.Certificate {
+	if v == "" {
+		return nil
+	}
+	unescaped, err := url.QueryUnescape(v)
+	if err != nil {
+		return nil
+	}
+	block, _ := pem.Decode([]byte(unescaped))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return []*x509.Certificate{cert}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/clientcert"
+	microsoft_mdm "github.com/fleetdm/fleet/v4/server/mdm/microsoft"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCertificatesMiddleware(t *testing.T) {
+	cert, _, err := microsoft_mdm.NewWSTEPCACertKey()
+	require.NoError(t, err)
+	forwarded := url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	var got []*x509.Certificate
+	handler := clientCertificates("X-Client-Cert", []*net.IPNet{proxies})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = clientcert.FromContext(r.Context())
+	}))
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		tls        *tls.ConnectionState
+		want       bool
+	}{
+		{"no certificate", "10.0.0.1:1234", "", nil, false},
+		{"tls certificate", "192.168.0.1:1234", "", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, true},
+		{"forwarded by a trusted proxy", "10.0.0.1:1234", forwarded, nil, true},
+		{"forwarded by a trusted proxy over tls", "10.0.0.1:1234", forwarded, &tls.ConnectionState{}, true},
+		{"forwarded by an untrusted proxy", "192.168.0.1:1234", forwarded, nil, false},
+		{"invalid forwarded certificate", "10.0.0.1:1234", "not-a-certificate", nil, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got = nil
+			r := httptest.NewRequest(http.MethodPost, microsoft_mdm.MDE2ManagementPath, nil)
+			r.RemoteAddr = c.remoteAddr
+			r.TLS = c.tls
+			if c.header != "" {
+				r.Header.Set("X-Client-Cert", c.header)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if c.want {
+				require.Len(t, got, 1)
+				require.Equal(t, cert.Raw, got[0].Raw)
+			} else {
+				require.Empty(t, got)
+			}
+		})
+	}
+
+	// the header is ignored when it is not configured
+	handler = clientCertificates("", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = clientcert.FromContext(r.Context())
+	}))
+	r := httptest.NewRequest(http.MethodPost, microsoft_mdm.MDE2ManagementPath, nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Client-Cert", forwarded)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	require.Empty(t, got)
+}
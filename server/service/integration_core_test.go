@@ -2334,6 +2334,8 @@ func (s *integrationTestSuite) TestHostsAddToTeam() {
 		TeamID:  &tm1.ID,
 		HostIDs: []uint{hosts[0].ID, hosts[1].ID},
 	}, http.StatusOK, &addResp)
+	s.lastActivityMatches(fleet.ActivityTypeTransferredHostsToTeam{}.ActivityName(),
+		fmt.Sprintf(`{"team_id": %d, "team_name": %q, "host_ids": [%d, %d]}`, tm1.ID, tm1.Name, hosts[0].ID, hosts[1].ID), 0)
 
 	// check that hosts are now part of that team
 	s.DoJSON("GET", fmt.Sprintf("/api/latest/fleet/hosts/%d", hosts[0].ID), nil, http.StatusOK, &getResp)
@@ -130,6 +130,7 @@ func (s *integrationEnterpriseTestSuite) TestTeamSpecs() {
 			// null).
 			MacOSSetupAssistant: optjson.String{Set: true},
 			BootstrapPackage:    optjson.String{Set: true},
+			EULA:                optjson.String{Set: true},
 		},
 	}, team.Config.MDM)
 
@@ -371,6 +372,44 @@ func (s *integrationEnterpriseTestSuite) TestTeamSpecs() {
 
 	require.Len(t, team.Secrets, 1)
 	assert.Equal(t, "ABC", team.Secrets[0].Secret)
+
+	// invalid time zone
+	teamSpecs = map[string]any{
+		"specs": []any{
+			map[string]any{
+				"name": "team2",
+				"mdm":  map[string]any{"timezone": "Mars/Olympus_Mons"},
+			},
+		},
+	}
+	res = s.Do("POST", "/api/latest/fleet/spec/teams", teamSpecs, http.StatusUnprocessableEntity)
+	require.Contains(t, extractServerErrorText(res.Body), `invalid time zone "Mars/Olympus_Mons"`)
+
+	// valid time zone
+	teamSpecs = map[string]any{
+		"specs": []any{
+			map[string]any{
+				"name": "team2",
+				"mdm":  map[string]any{"timezone": "America/New_York"},
+			},
+		},
+	}
+	s.Do("POST", "/api/latest/fleet/spec/teams", teamSpecs, http.StatusOK)
+
+	team, err = s.ds.TeamByName(context.Background(), "team2")
+	require.NoError(t, err)
+	require.Equal(t, "America/New_York", team.Config.MDM.Timezone)
+
+	// the time zone can be modified via the team endpoint
+	var tmResp teamResponse
+	s.DoJSON("PATCH", fmt.Sprintf("/api/latest/fleet/teams/%d", team.ID), fleet.TeamPayload{
+		MDM: &fleet.TeamPayloadMDM{Timezone: ptr.String("Europe/Paris")},
+	}, http.StatusOK, &tmResp)
+	require.Equal(t, "Europe/Paris", tmResp.Team.Config.MDM.Timezone)
+
+	s.Do("PATCH", fmt.Sprintf("/api/latest/fleet/teams/%d", team.ID), fleet.TeamPayload{
+		MDM: &fleet.TeamPayloadMDM{Timezone: ptr.String("Local")},
+	}, http.StatusUnprocessableEntity)
 }
 
 func (s *integrationEnterpriseTestSuite) TestTeamSpecsPermissions() {
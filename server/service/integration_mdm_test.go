@@ -8,6 +8,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,6 +18,7 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httputil"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -152,7 +154,7 @@ func (s *integrationMDMTestSuite) SetupSuite() {
 							if s.onScheduleDone != nil {
 								defer s.onScheduleDone()
 							}
-							return ReconcileProfiles(ctx, ds, apple_mdm.NewMDMAppleCommander(mdmStorage, mdmPushService), logger)
+							return ReconcileProfiles(ctx, ds, apple_mdm.NewMDMAppleCommander(mdmStorage, mdmPushService), nil, logger)
 						}),
 					)
 					return profileSchedule, nil
@@ -592,7 +594,7 @@ func (s *integrationMDMTestSuite) TestDEPProfileAssignment() {
 			require.JSONEq(
 				t,
 				fmt.Sprintf(
-					`{"host_serial": "%s", "host_display_name": "%s (%s)", "installed_from_dep": true}`,
+					`{"host_serial": "%s", "host_display_name": "%s (%s)", "installed_from_dep": true, "mdm_platform": "apple"}`,
 					devices[0].SerialNumber, devices[0].Model, devices[0].SerialNumber,
 				),
 				string(*activity.Details),
@@ -663,8 +665,8 @@ func (s *integrationMDMTestSuite) TestAppleMDMDeviceEnrollment() {
 		}
 	}
 	require.Len(t, details, 2)
-	require.JSONEq(t, fmt.Sprintf(`{"host_serial": "%s", "host_display_name": "%s (%s)", "installed_from_dep": false}`, deviceA.serial, deviceA.model, deviceA.serial), string(*details[len(details)-2]))
-	require.JSONEq(t, fmt.Sprintf(`{"host_serial": "%s", "host_display_name": "%s (%s)", "installed_from_dep": false}`, deviceB.serial, deviceB.model, deviceB.serial), string(*details[len(details)-1]))
+	require.JSONEq(t, fmt.Sprintf(`{"host_serial": "%s", "host_display_name": "%s (%s)", "installed_from_dep": false, "mdm_platform": "apple"}`, deviceA.serial, deviceA.model, deviceA.serial), string(*details[len(details)-2]))
+	require.JSONEq(t, fmt.Sprintf(`{"host_serial": "%s", "host_display_name": "%s (%s)", "installed_from_dep": false, "mdm_platform": "apple"}`, deviceB.serial, deviceB.model, deviceB.serial), string(*details[len(details)-1]))
 
 	// set an enroll secret
 	var applyResp applyEnrollSecretSpecResponse
@@ -711,7 +713,7 @@ func (s *integrationMDMTestSuite) TestAppleMDMDeviceEnrollment() {
 			require.Nil(t, activity.ActorID)
 			require.Nil(t, activity.ActorFullName)
 			details = append(details, activity.Details)
-			require.JSONEq(t, fmt.Sprintf(`{"host_serial": "%s", "host_display_name": "%s (%s)", "installed_from_dep": false}`, deviceA.serial, deviceA.model, deviceA.serial), string(*activity.Details))
+			require.JSONEq(t, fmt.Sprintf(`{"host_serial": "%s", "host_display_name": "%s (%s)", "installed_from_dep": false, "mdm_platform": "apple"}`, deviceA.serial, deviceA.model, deviceA.serial), string(*activity.Details))
 		}
 	}
 	require.True(t, found)
@@ -820,14 +822,19 @@ func (s *integrationMDMTestSuite) TestMDMAppleUnenroll() {
 	// 3 profiles added + 1 profile with fleetd configuration
 	require.Len(t, *hostResp.Host.MDM.Profiles, 4)
 
-	// try to unenroll the host, fails since the host doesn't respond
-	s.Do("PATCH", fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/unenroll", h.ID), nil, http.StatusGatewayTimeout)
+	// unenroll the host, the command is queued but the host doesn't respond
+	var unenrollResp mdmAppleCommandRemoveEnrollmentProfileResponse
+	s.DoJSON("PATCH", fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/unenroll", h.ID), nil, http.StatusOK, &unenrollResp)
+	require.Equal(t, h.ID, unenrollResp.HostID)
+	require.NotEmpty(t, unenrollResp.CommandUUID)
+	require.Equal(t, fleet.MDMAppleUnenrollStatusPending, unenrollResp.Status)
+	require.False(t, unenrollResp.PushFailed)
 
 	// we're going to modify this mock, make sure we restore its default
 	originalPushMock := s.pushProvider.PushFunc
 	defer func() { s.pushProvider.PushFunc = originalPushMock }()
 
-	// if there's an error coming from APNs servers
+	// if there's an error coming from APNs servers, the command is still queued
 	s.pushProvider.PushFunc = func(pushes []*mdm.Push) (map[string]*push.Response, error) {
 		return map[string]*push.Response{
 			pushes[0].Token.String(): {
@@ -836,9 +843,12 @@ func (s *integrationMDMTestSuite) TestMDMAppleUnenroll() {
 			},
 		}, nil
 	}
-	s.Do("PATCH", fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/unenroll", h.ID), nil, http.StatusBadGateway)
+	unenrollResp = mdmAppleCommandRemoveEnrollmentProfileResponse{}
+	s.DoJSON("PATCH", fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/unenroll", h.ID), nil, http.StatusOK, &unenrollResp)
+	require.Equal(t, fleet.MDMAppleUnenrollStatusPending, unenrollResp.Status)
+	require.True(t, unenrollResp.PushFailed)
 
-	// if there was an error unrelated to APNs
+	// same if there was an error unrelated to APNs
 	s.pushProvider.PushFunc = func(pushes []*mdm.Push) (map[string]*push.Response, error) {
 		res := map[string]*push.Response{
 			pushes[0].Token.String(): {
@@ -848,21 +858,49 @@ func (s *integrationMDMTestSuite) TestMDMAppleUnenroll() {
 		}
 		return res, errors.New("baz")
 	}
-	s.Do("PATCH", fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/unenroll", h.ID), nil, http.StatusInternalServerError)
+	unenrollResp = mdmAppleCommandRemoveEnrollmentProfileResponse{}
+	s.DoJSON("PATCH", fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/unenroll", h.ID), nil, http.StatusOK, &unenrollResp)
+	require.Equal(t, fleet.MDMAppleUnenrollStatusPending, unenrollResp.Status)
+	require.True(t, unenrollResp.PushFailed)
+	pendingCmdUUID := unenrollResp.CommandUUID
 
-	// try again, but this time the host is online and answers
-	s.pushProvider.PushFunc = func(pushes []*mdm.Push) (map[string]*push.Response, error) {
-		res, err := mockSuccessfulPush(pushes)
-		d.checkout()
-		return res, err
+	// the host is still enrolled until it checks in
+	hostResp = getHostResponse{}
+	s.DoJSON("GET", fmt.Sprintf("/api/v1/fleet/hosts/%d", h.ID), getHostRequest{}, http.StatusOK, &hostResp)
+	require.NotEqual(t, "", hostResp.Host.MDM.Name)
+
+	// the host checks in later, gets the queued commands and checks out once
+	// the enrollment profile is removed
+	var gotPending bool
+	cmd := d.idle()
+	for cmd != nil {
+		if cmd.CommandUUID == pendingCmdUUID {
+			require.Equal(t, "RemoveProfile", cmd.Command.RequestType)
+			require.Equal(t, apple_mdm.FleetPayloadIdentifier, cmd.Command.RemoveProfile.Identifier)
+			gotPending = true
+		}
+		cmd = d.acknowledge(cmd.CommandUUID)
 	}
-	s.Do("PATCH", fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/unenroll", h.ID), nil, http.StatusOK)
+	require.True(t, gotPending)
+	d.checkout()
 
 	// profiles are removed and the host is no longer enrolled
 	hostResp = getHostResponse{}
 	s.DoJSON("GET", fmt.Sprintf("/api/v1/fleet/hosts/%d", h.ID), getHostRequest{}, http.StatusOK, &hostResp)
 	require.Nil(t, hostResp.Host.MDM.Profiles)
 	require.Equal(t, "", hostResp.Host.MDM.Name)
+
+	// enroll the device again, this time it is online and answers right away
+	d.mdmEnroll(s)
+	s.pushProvider.PushFunc = func(pushes []*mdm.Push) (map[string]*push.Response, error) {
+		res, err := mockSuccessfulPush(pushes)
+		d.checkout()
+		return res, err
+	}
+	unenrollResp = mdmAppleCommandRemoveEnrollmentProfileResponse{}
+	s.DoJSON("PATCH", fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/unenroll", h.ID), nil, http.StatusOK, &unenrollResp)
+	require.Equal(t, fleet.MDMAppleUnenrollStatusUnenrolled, unenrollResp.Status)
+	require.False(t, unenrollResp.PushFailed)
 }
 
 func (s *integrationMDMTestSuite) TestMDMAppleGetEncryptionKey() {
@@ -952,6 +990,23 @@ func (s *integrationMDMTestSuite) TestMDMAppleGetEncryptionKey() {
 	res := s.DoRawNoAuth("GET", fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/encryption_key", host.ID), nil, http.StatusUnauthorized)
 	res.Body.Close()
 
+	// request with a session that isn't in sudo mode
+	mysql.ExecAdhocSQL(t, s.ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, "UPDATE sessions SET created_at = ?, reauthenticated_at = NULL WHERE `key` = ?", time.Now().Add(-time.Hour), s.token)
+		return err
+	})
+	res = s.Do("GET", fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/encryption_key", host.ID), nil, http.StatusForbidden)
+	errMsg := extractServerErrorText(res.Body)
+	require.Contains(t, errMsg, "re-authentication required")
+
+	// re-authenticate with a wrong password
+	res = s.Do("POST", "/api/latest/fleet/sessions/reauthenticate", reauthenticateSessionRequest{Password: "nope"}, http.StatusUnprocessableEntity)
+	res.Body.Close()
+
+	var reauthResp reauthenticateSessionResponse
+	s.DoJSON("POST", "/api/latest/fleet/sessions/reauthenticate", reauthenticateSessionRequest{Password: test.GoodPassword}, http.StatusOK, &reauthResp)
+	require.WithinDuration(t, time.Now().Add(fleet.SessionSudoModeDuration), reauthResp.SudoModeExpiresAt, time.Minute)
+
 	// encryption key not processed yet
 	resp := getHostEncryptionKeyResponse{}
 	s.DoJSON("GET", fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/encryption_key", host.ID), nil, http.StatusNotFound, &resp)
@@ -986,9 +1041,13 @@ func (s *integrationMDMTestSuite) TestMDMAppleGetEncryptionKey() {
 	checkDecryptableKey := func(u fleet.User) {
 		err = s.ds.SetHostsDiskEncryptionKeyStatus(ctx, []uint{host.ID}, true, time.Now())
 		require.NoError(t, err)
+		s.Do("POST", "/api/latest/fleet/sessions/reauthenticate", reauthenticateSessionRequest{Password: test.GoodPassword}, http.StatusOK)
 		resp = getHostEncryptionKeyResponse{}
-		s.DoJSON("GET", fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/encryption_key", host.ID), nil, http.StatusOK, &resp)
+		res := s.Do("GET", fmt.Sprintf("/api/latest/fleet/mdm/hosts/%d/encryption_key", host.ID), nil, http.StatusOK)
+		require.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&resp))
 		require.Equal(t, recoveryKey, resp.EncryptionKey.DecryptedValue)
+		require.NotNil(t, resp.EncryptionKey.ExpiresAt)
 
 		// use the admin token to get the activities
 		currToken := s.token
@@ -1820,7 +1879,7 @@ func (s *integrationMDMTestSuite) TestBatchSetMDMAppleProfiles() {
 	s.Do("POST", "/api/v1/fleet/mdm/apple/profiles/batch", batchSetMDMAppleProfilesRequest{Profiles: nil}, http.StatusNoContent)
 	s.lastActivityMatches(
 		fleet.ActivityTypeEditedMacosProfile{}.ActivityName(),
-		`{"team_id": null, "team_name": null}`,
+		`{"team_id": null, "team_name": null, "added_profiles": [], "removed_profiles": [], "changed_profiles": [], "profiles_truncated": false}`,
 		0,
 	)
 
@@ -1866,13 +1925,57 @@ func (s *integrationMDMTestSuite) TestBatchSetMDMAppleProfiles() {
 		require.Contains(t, errMsg, fmt.Sprintf("Validation Failed: unsupported PayloadIdentifier(s): %s", p))
 	}
 
-	// successfully apply a profile for the team
+	// preview the profiles for the team, nothing is saved
+	var previewResp batchSetMDMAppleProfilesResponse
+	s.DoJSON("POST", "/api/v1/fleet/mdm/apple/profiles/batch", batchSetMDMAppleProfilesRequest{Profiles: [][]byte{
+		mobileconfigForTest("N1", "I1"),
+	}}, http.StatusOK, &previewResp, "team_id", strconv.Itoa(int(tm.ID)), "preview", "true")
+	require.NotNil(t, previewResp.Preview)
+	require.Equal(t, &fleet.MDMAppleProfilesPreview{TeamID: &tm.ID}, previewResp.Preview)
+	tmProfs, err := s.ds.ListMDMAppleConfigProfiles(ctx, &tm.ID)
+	require.NoError(t, err)
+	require.Empty(t, tmProfs)
+
+	// preview still validates the profiles
 	s.Do("POST", "/api/v1/fleet/mdm/apple/profiles/batch", batchSetMDMAppleProfilesRequest{Profiles: [][]byte{
 		mobileconfigForTest("N1", "I1"),
+		mobileconfigForTest("N1", "I2"),
+	}}, http.StatusUnprocessableEntity, "team_id", strconv.Itoa(int(tm.ID)), "preview", "true")
+
+	// successfully apply a profile for the team
+	n1 := mobileconfigForTest("N1", "I1")
+	s.Do("POST", "/api/v1/fleet/mdm/apple/profiles/batch", batchSetMDMAppleProfilesRequest{Profiles: [][]byte{
+		n1,
+	}}, http.StatusNoContent, "team_id", strconv.Itoa(int(tm.ID)))
+	s.lastActivityMatches(
+		fleet.ActivityTypeEditedMacosProfile{}.ActivityName(),
+		fmt.Sprintf(`{"team_id": %d, "team_name": %q, "added_profiles": [{"name": "N1", "identifier": "I1", "checksum": %q}],
+			"removed_profiles": [], "changed_profiles": [], "profiles_truncated": false}`, tm.ID, tm.Name, hex.EncodeToString(fleet.MDMProfileChecksumMD5.Sum(n1))), //nolint:gosec
+		0,
+	)
+
+	// replace it with another profile and change the content of the first one
+	n1b := mobileconfigForTest("N1b", "I1")
+	n2 := mobileconfigForTest("N2", "I2")
+	s.Do("POST", "/api/v1/fleet/mdm/apple/profiles/batch", batchSetMDMAppleProfilesRequest{Profiles: [][]byte{
+		n1b, n2,
 	}}, http.StatusNoContent, "team_id", strconv.Itoa(int(tm.ID)))
 	s.lastActivityMatches(
 		fleet.ActivityTypeEditedMacosProfile{}.ActivityName(),
-		fmt.Sprintf(`{"team_id": %d, "team_name": %q}`, tm.ID, tm.Name),
+		fmt.Sprintf(`{"team_id": %d, "team_name": %q, "added_profiles": [{"name": "N2", "identifier": "I2", "checksum": %q}],
+			"removed_profiles": [], "changed_profiles": [{"name": "N1b", "identifier": "I1", "checksum": %q, "previous_name": "N1", "previous_checksum": %q}],
+			"profiles_truncated": false}`, tm.ID, tm.Name, hex.EncodeToString(fleet.MDMProfileChecksumMD5.Sum(n2)), hex.EncodeToString(fleet.MDMProfileChecksumMD5.Sum(n1b)), hex.EncodeToString(fleet.MDMProfileChecksumMD5.Sum(n1))), //nolint:gosec
+		0,
+	)
+
+	// remove all profiles
+	s.Do("POST", "/api/v1/fleet/mdm/apple/profiles/batch", batchSetMDMAppleProfilesRequest{Profiles: nil},
+		http.StatusNoContent, "team_id", strconv.Itoa(int(tm.ID)))
+	s.lastActivityMatches(
+		fleet.ActivityTypeEditedMacosProfile{}.ActivityName(),
+		fmt.Sprintf(`{"team_id": %d, "team_name": %q, "added_profiles": [], "changed_profiles": [], "profiles_truncated": false,
+			"removed_profiles": [{"name": "N1b", "identifier": "I1", "checksum": %q}, {"name": "N2", "identifier": "I2", "checksum": %q}]}`,
+			tm.ID, tm.Name, hex.EncodeToString(fleet.MDMProfileChecksumMD5.Sum(n1b)), hex.EncodeToString(fleet.MDMProfileChecksumMD5.Sum(n2))), //nolint:gosec
 		0,
 	)
 }
@@ -2589,6 +2692,190 @@ func (s *integrationMDMTestSuite) TestEnqueueMDMCommand() {
 	}, listCmdResp.Results[0])
 }
 
+// TestMDMCheckinRoundRobinServers checks that the MDM traffic of a device can
+// be served by multiple Fleet servers sharing the same database, with no
+// sticky sessions: each request is sent to the next server in turn, as a
+// round-robin load balancer would do.
+func (s *integrationMDMTestSuite) TestMDMCheckinRoundRobinServers() {
+	t := s.T()
+	ctx := context.Background()
+
+	// start a second server with its own MDM storage and push service, as a
+	// separate replica would have
+	_, certPEM, keyPEM, err := s.fleetCfg.MDM.AppleSCEP()
+	require.NoError(t, err)
+	mdmStorage, err := s.ds.NewMDMAppleMDMStorage(certPEM, keyPEM)
+	require.NoError(t, err)
+	scepStorage, err := s.ds.NewSCEPDepot(certPEM, keyPEM)
+	require.NoError(t, err)
+	pushFactory, pushProvider := newMockAPNSPushProviderFactory()
+	var secondServerPushes atomic.Int64
+	pushProvider.PushFunc = func(pushes []*mdm.Push) (map[string]*push.Response, error) {
+		secondServerPushes.Add(int64(len(pushes)))
+		return mockSuccessfulPush(pushes)
+	}
+	fleetCfg := s.fleetCfg
+	_, secondServer := RunServerForTestsWithDS(t, s.ds, &TestServerOpts{
+		License:             &fleet.LicenseInfo{Tier: fleet.TierPremium},
+		FleetConfig:         &fleetCfg,
+		MDMStorage:          mdmStorage,
+		DEPStorage:          s.depStorage,
+		SCEPStorage:         scepStorage,
+		MDMPusher:           nanomdm_pushsvc.New(mdmStorage, mdmStorage, pushFactory, NewNanoMDMLogger(kitlog.NewNopLogger())),
+		SkipCreateTestUsers: true,
+	})
+
+	var firstServerPushes atomic.Int64
+	originalPushMock := s.pushProvider.PushFunc
+	s.pushProvider.PushFunc = func(pushes []*mdm.Push) (map[string]*push.Response, error) {
+		firstServerPushes.Add(int64(len(pushes)))
+		return mockSuccessfulPush(pushes)
+	}
+	defer func() { s.pushProvider.PushFunc = originalPushMock }()
+
+	// put both servers behind a round-robin load balancer, and send all the
+	// requests of the test through it
+	var backends []*url.URL
+	for _, srv := range []*httptest.Server{s.server, secondServer} {
+		u, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+		backends = append(backends, u)
+	}
+	var next atomic.Uint64
+	hits := make([]atomic.Int64, len(backends))
+	lb := httptest.NewServer(&httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			i := (next.Add(1) - 1) % uint64(len(backends))
+			hits[i].Add(1)
+			r.URL.Scheme = backends[i].Scheme
+			r.URL.Host = backends[i].Host
+		},
+	})
+	t.Cleanup(lb.Close)
+	originalServer := s.server
+	s.server = lb
+	defer func() { s.server = originalServer }()
+
+	// the SCEP enrollment, Authenticate and TokenUpdate requests are all
+	// served by different servers than the request before them
+	d := newMDMEnrolledDevice(s)
+	h, err := s.ds.HostByIdentifier(ctx, d.uuid)
+	require.NoError(t, err)
+	require.Equal(t, d.serial, h.HardwareSerial)
+
+	var hostResp getHostResponse
+	s.DoJSON("GET", fmt.Sprintf("/api/latest/fleet/hosts/%d", h.ID), nil, http.StatusOK, &hostResp)
+	require.NotNil(t, hostResp.Host.MDM.EnrollmentStatus)
+	require.Equal(t, "On (manual)", *hostResp.Host.MDM.EnrollmentStatus)
+
+	// enqueue a command on one server, the device receives it and reports
+	// its result through the other servers
+	cmdUUID := uuid.New().String()
+	rawCmd := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>Command</key>
+    <dict>
+        <key>RequestType</key>
+        <string>DeviceInformation</string>
+        <key>Queries</key>
+        <array>
+            <string>DeviceName</string>
+        </array>
+    </dict>
+    <key>CommandUUID</key>
+    <string>%s</string>
+</dict>
+</plist>`, cmdUUID)
+	var enqueueResp enqueueMDMAppleCommandResponse
+	s.DoJSON("POST", "/api/latest/fleet/mdm/apple/enqueue", enqueueMDMAppleCommandRequest{
+		Command:   base64.RawStdEncoding.EncodeToString([]byte(rawCmd)),
+		DeviceIDs: []string{d.uuid},
+	}, http.StatusOK, &enqueueResp)
+	require.Equal(t, cmdUUID, enqueueResp.CommandUUID)
+	require.Empty(t, enqueueResp.FailedUUIDs)
+	require.EqualValues(t, 1, firstServerPushes.Load()+secondServerPushes.Load())
+
+	var found bool
+	for cmd := d.idle(); cmd != nil; cmd = d.acknowledge(cmd.CommandUUID) {
+		if cmd.CommandUUID == cmdUUID {
+			found = true
+		}
+	}
+	require.True(t, found)
+
+	for i := 0; i < len(backends); i++ {
+		var cmdResResp getMDMAppleCommandResultsResponse
+		s.DoJSON("GET", "/api/latest/fleet/mdm/apple/commandresults", nil, http.StatusOK, &cmdResResp, "command_uuid", cmdUUID)
+		require.Len(t, cmdResResp.Results, 1)
+		require.Equal(t, "Acknowledged", cmdResResp.Results[0].Status)
+	}
+
+	// the device checks out through the load balancer too
+	d.checkout()
+	enrollment, err := s.ds.GetNanoMDMEnrollment(ctx, d.uuid)
+	require.NoError(t, err)
+	require.False(t, enrollment.Enabled)
+
+	for i := range hits {
+		require.NotZero(t, hits[i].Load(), "server %d", i)
+	}
+}
+
+func (s *integrationMDMTestSuite) TestPushMDMAppleHosts() {
+	t := s.T()
+	ctx := context.Background()
+
+	tm, err := s.ds.NewTeam(ctx, &fleet.Team{Name: t.Name()})
+	require.NoError(t, err)
+
+	// an enrolled host and a host not enrolled in Fleet's MDM in the team, and
+	// an enrolled host in no team
+	d := newMDMEnrolledDevice(s)
+	h, err := s.ds.HostByIdentifier(ctx, d.uuid)
+	require.NoError(t, err)
+	unenrolledHost := createHostAndDeviceToken(t, s.ds, "push-unenrolled")
+	require.NoError(t, s.ds.AddHostsToTeam(ctx, &tm.ID, []uint{h.ID, unenrolledHost.ID}))
+	newMDMEnrolledDevice(s)
+
+	var pushedTokens []string
+	originalPushMock := s.pushProvider.PushFunc
+	defer func() { s.pushProvider.PushFunc = originalPushMock }()
+	s.pushProvider.PushFunc = func(pushes []*mdm.Push) (map[string]*push.Response, error) {
+		for _, p := range pushes {
+			pushedTokens = append(pushedTokens, p.Token.String())
+		}
+		return mockSuccessfulPush(pushes)
+	}
+
+	var resp pushMDMAppleHostsResponse
+	s.DoJSON("POST", "/api/latest/fleet/mdm/apple/push", json.RawMessage(fmt.Sprintf(`{"team_id": %d}`, tm.ID)), http.StatusOK, &resp)
+	require.Equal(t, 2, resp.HostsCount)
+	require.Equal(t, 1, resp.PushesSent)
+	require.Empty(t, resp.FailedUUIDs)
+	require.Len(t, pushedTokens, 1)
+
+	// the pushes rejected by APNs are reported
+	s.pushProvider.PushFunc = func(pushes []*mdm.Push) (map[string]*push.Response, error) {
+		res := make(map[string]*push.Response, len(pushes))
+		for _, p := range pushes {
+			res[p.Token.String()] = &push.Response{Err: errors.New("push failed")}
+		}
+		return res, nil
+	}
+	resp = pushMDMAppleHostsResponse{}
+	s.DoJSON("POST", "/api/latest/fleet/mdm/apple/push", json.RawMessage(fmt.Sprintf(`{"team_id": %d, "filters": {"query": %q}}`, tm.ID, h.Hostname)), http.StatusOK, &resp)
+	require.Equal(t, 1, resp.HostsCount)
+	require.Zero(t, resp.PushesSent)
+	require.Equal(t, []string{d.uuid}, resp.FailedUUIDs)
+
+	// invalid filters
+	res := s.Do("POST", "/api/latest/fleet/mdm/apple/push", json.RawMessage(`{"filters": {"macos_settings": "nope"}}`), http.StatusUnprocessableEntity)
+	errMsg := extractServerErrorText(res.Body)
+	require.Contains(t, errMsg, "invalid macos_settings status")
+}
+
 func (s *integrationMDMTestSuite) TestBootstrapPackage() {
 	t := s.T()
 
@@ -3011,6 +3298,62 @@ func (s *integrationMDMTestSuite) TestEULA() {
 	s.DoJSON("DELETE", fmt.Sprintf("/api/latest/fleet/mdm/apple/setup/eula/%s", eulaToken), nil, http.StatusNotFound, &deleteResp)
 }
 
+func (s *integrationMDMTestSuite) TestMDMAppleOSUpdatesSummary() {
+	t := s.T()
+	ctx := context.Background()
+
+	acResp := appConfigResponse{}
+	s.DoJSON("PATCH", "/api/latest/fleet/config", json.RawMessage(`{
+		"mdm": { "macos_updates": { "minimum_version": "13.3.1", "deadline": "2020-01-01" } }
+	}`), http.StatusOK, &acResp)
+	t.Cleanup(func() {
+		s.DoJSON("PATCH", "/api/latest/fleet/config", json.RawMessage(`{
+			"mdm": { "macos_updates": { "minimum_version": "", "deadline": "" } }
+		}`), http.StatusOK, &appConfigResponse{})
+	})
+
+	tm, err := s.ds.NewTeam(ctx, &fleet.Team{Name: t.Name()})
+	require.NoError(t, err)
+	s.Do("PATCH", fmt.Sprintf("/api/latest/fleet/teams/%d", tm.ID), fleet.TeamPayload{
+		MDM: &fleet.TeamPayloadMDM{MacOSUpdates: &fleet.MacOSUpdates{MinimumVersion: "13.4", Deadline: "2099-01-01"}},
+	}, http.StatusOK)
+
+	behind := createOrbitEnrolledHost(t, "darwin", "behind", s.ds)
+	require.NoError(t, s.ds.SetOrUpdateHostOSUpdates(ctx, behind.ID, "13.2", 1))
+	compliant := createOrbitEnrolledHost(t, "darwin", "compliant", s.ds)
+	require.NoError(t, s.ds.SetOrUpdateHostOSUpdates(ctx, compliant.ID, "13.4", 0))
+	deferred := createOrbitEnrolledHost(t, "darwin", "deferred", s.ds)
+	require.NoError(t, s.ds.AddHostsToTeam(ctx, &tm.ID, []uint{deferred.ID}))
+	require.NoError(t, s.ds.SetOrUpdateHostOSUpdates(ctx, deferred.ID, "13.3.1", 0))
+
+	var summaryResp getMDMAppleOSUpdatesSummaryResponse
+	s.DoJSON("GET", "/api/latest/fleet/mdm/apple/os_updates/summary", nil, http.StatusOK, &summaryResp)
+	require.Equal(t, fleet.MDMAppleOSUpdatesSummary{Compliant: 1, Behind: 1, UpdatesAvailable: 1}, summaryResp.MDMAppleOSUpdatesSummary)
+
+	summaryResp = getMDMAppleOSUpdatesSummaryResponse{}
+	s.DoJSON("GET", fmt.Sprintf("/api/latest/fleet/mdm/apple/os_updates/summary?team_id=%d", tm.ID), nil, http.StatusOK, &summaryResp)
+	require.Equal(t, fleet.MDMAppleOSUpdatesSummary{Deferred: 1}, summaryResp.MDMAppleOSUpdatesSummary)
+
+	// unknown team
+	s.DoJSON("GET", fmt.Sprintf("/api/latest/fleet/mdm/apple/os_updates/summary?team_id=%d", tm.ID+1000), nil, http.StatusNotFound, &summaryResp)
+
+	for _, c := range []struct {
+		status string
+		host   *fleet.Host
+	}{
+		{"behind", behind},
+		{"compliant", compliant},
+		{"deferred", deferred},
+	} {
+		listHostsRes := listHostsResponse{}
+		s.DoJSON("GET", "/api/latest/fleet/hosts", nil, http.StatusOK, &listHostsRes, "os_update_status", c.status)
+		require.Len(t, listHostsRes.Hosts, 1, c.status)
+		require.Equal(t, c.host.ID, listHostsRes.Hosts[0].ID, c.status)
+	}
+
+	s.DoJSON("GET", "/api/latest/fleet/hosts", nil, http.StatusBadRequest, &listHostsResponse{}, "os_update_status", "invalid")
+}
+
 func (s *integrationMDMTestSuite) TestMacosSetupAssistant() {
 	ctx := context.Background()
 	t := s.T()
@@ -3165,6 +3508,18 @@ func (s *integrationMDMTestSuite) TestMacosSetupAssistant() {
 	s.lastActivityMatches(fleet.ActivityTypeChangedMacosSetupAssistant{}.ActivityName(),
 		fmt.Sprintf(`{"name": "team2", "team_id": %d, "team_name": %q}`, tm.ID, tm.Name), latestChangedActID)
 
+	// try to use an unsupported variable
+	tmProf = `{"department": "$FLEET_VAR_TEAM_NAME", "support_email_address": "${FLEET_VAR_SUPPORT_EMAIL}"}`
+	res = s.Do("POST", "/api/latest/fleet/mdm/apple/enrollment_profile", createMDMAppleSetupAssistantRequest{
+		TeamID:            &tm.ID,
+		Name:              "team5",
+		EnrollmentProfile: json.RawMessage(tmProf),
+	}, http.StatusUnprocessableEntity)
+	errMsg = extractServerErrorText(res.Body)
+	require.Contains(t, errMsg, `The automatic enrollment profile includes unsupported variable(s): FLEET_VAR_SUPPORT_EMAIL.`)
+	s.lastActivityMatches(fleet.ActivityTypeChangedMacosSetupAssistant{}.ActivityName(),
+		fmt.Sprintf(`{"name": "team2", "team_id": %d, "team_name": %q}`, tm.ID, tm.Name), latestChangedActID)
+
 	// try to set a non-object json value
 	tmProf = `true`
 	res = s.Do("POST", "/api/latest/fleet/mdm/apple/enrollment_profile", createMDMAppleSetupAssistantRequest{
@@ -3254,7 +3609,7 @@ func (s *integrationMDMTestSuite) assertConfigProfilesByIdentifier(teamID *uint,
 	}
 	var cfgProfs []*fleet.MDMAppleConfigProfile
 	mysql.ExecAdhocSQL(t, s.ds, func(q sqlx.ExtContext) error {
-		return sqlx.SelectContext(context.Background(), q, &cfgProfs, `SELECT * FROM mdm_apple_configuration_profiles WHERE team_id = ?`, teamID)
+		return sqlx.SelectContext(context.Background(), q, &cfgProfs, `SELECT profile_id, team_id, identifier, name, mobileconfig, checksum, created_at, updated_at FROM mdm_apple_configuration_profiles WHERE team_id = ?`, teamID)
 	})
 
 	label := "exist"
@@ -3867,8 +4222,47 @@ func (s *integrationMDMTestSuite) TestSSO() {
 	// without an EULA uploaded, only the profile token is provided
 	require.False(t, q.Has("eula_token"))
 	require.True(t, q.Has("profile_token"))
-	// the url retrieves a valid profile
-	s.downloadAndVerifyEnrollmentProfile("/api/mdm/apple/enroll?token=" + q.Get("profile_token"))
+	require.True(t, q.Has(apple_mdm.EnrollReferenceKey))
+	// the profile token is a single-use token, not the token of the automatic
+	// enrollment profile
+	require.NotContains(t, lastSubmittedProfile.URL, q.Get("profile_token"))
+
+	// the end user's account is recorded, and the profile downloaded with the
+	// token points the device to a check-in URL carrying it
+	idpAcc, err := s.ds.GetMDMIdPAccount(context.Background(), q.Get(apple_mdm.EnrollReferenceKey))
+	require.NoError(t, err)
+	require.Equal(t, "sso_user@example.com", idpAcc.Username)
+	resp := s.DoRaw("GET", "/api/mdm/apple/enroll?token="+q.Get("profile_token")+"&enrollment_reference="+idpAcc.UUID, nil, http.StatusOK)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Contains(t, string(body), "/mdm/apple/mdm?enrollment_reference="+idpAcc.UUID)
+
+	// the token can't be used again
+	s.DoRaw("GET", "/api/mdm/apple/enroll?token="+q.Get("profile_token"), nil, http.StatusUnauthorized)
+
+	// the tokens record the account they were issued to
+	var listTokResp listMDMAppleSSOEnrollmentTokensResponse
+	s.DoJSON("GET", "/api/latest/fleet/mdm/apple/sso_enrollment_tokens", nil, http.StatusOK, &listTokResp)
+	require.Len(t, listTokResp.SSOEnrollmentTokens, 1)
+	require.Equal(t, idpAcc.UUID, listTokResp.SSOEnrollmentTokens[0].IdPAccountUUID)
+	require.Equal(t, "sso_user@example.com", listTokResp.SSOEnrollmentTokens[0].IdPUsername)
+	require.NotNil(t, listTokResp.SSOEnrollmentTokens[0].ConsumedAt)
+
+	// a revoked token can't be used
+	res = s.LoginMDMSSOUser("sso_user", "user123#")
+	u, err = url.Parse(res.Header.Get("Location"))
+	require.NoError(t, err)
+	q = u.Query()
+	listTokResp = listMDMAppleSSOEnrollmentTokensResponse{}
+	s.DoJSON("GET", "/api/latest/fleet/mdm/apple/sso_enrollment_tokens", nil, http.StatusOK, &listTokResp)
+	require.Len(t, listTokResp.SSOEnrollmentTokens, 2)
+	revokedID := listTokResp.SSOEnrollmentTokens[0].ID
+	s.Do("DELETE", fmt.Sprintf("/api/latest/fleet/mdm/apple/sso_enrollment_tokens/%d", revokedID), nil, http.StatusNoContent)
+	s.lastActivityMatches(fleet.ActivityTypeRevokedMDMAppleSSOEnrollmentToken{}.ActivityName(),
+		fmt.Sprintf(`{"token_id": %d, "idp_username": "sso_user@example.com"}`, revokedID), 0)
+	s.DoRaw("GET", "/api/mdm/apple/enroll?token="+q.Get("profile_token"), nil, http.StatusUnauthorized)
+	s.Do("DELETE", fmt.Sprintf("/api/latest/fleet/mdm/apple/sso_enrollment_tokens/%d", revokedID), nil, http.StatusNotFound)
 
 	// upload an EULA
 	pdfBytes := []byte("%PDF-1.pdf-contents")
@@ -3887,7 +4281,7 @@ func (s *integrationMDMTestSuite) TestSSO() {
 	// the url retrieves a valid profile
 	s.downloadAndVerifyEnrollmentProfile("/api/mdm/apple/enroll?token=" + q.Get("profile_token"))
 	// the url retrieves a valid EULA
-	resp := s.DoRaw("GET", "/api/latest/fleet/mdm/apple/setup/eula/"+q.Get("eula_token"), nil, http.StatusOK)
+	resp = s.DoRaw("GET", "/api/latest/fleet/mdm/apple/setup/eula/"+q.Get("eula_token"), nil, http.StatusOK)
 	require.EqualValues(t, len(pdfBytes), resp.ContentLength)
 	require.Equal(t, "application/pdf", resp.Header.Get("content-type"))
 	respBytes, err := io.ReadAll(resp.Body)
@@ -13,6 +13,7 @@ import (
 	"github.com/docker/go-units"
 	"github.com/fleetdm/fleet/v4/pkg/fleethttp"
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/contexts/locale"
 	"github.com/fleetdm/fleet/v4/server/contexts/logging"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	apple_mdm "github.com/fleetdm/fleet/v4/server/mdm/apple"
@@ -214,6 +215,67 @@ func (svc *Service) VerifyMDMAppleConfigured(ctx context.Context) error {
 	return nil
 }
 
+func (svc *Service) VerifyMDMWindowsConfigured(ctx context.Context) error {
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		// skipauth: Authorization is currently for user endpoints only.
+		svc.authz.SkipAuthorization(ctx)
+		return err
+	}
+	if !appCfg.MDM.WindowsEnabledAndConfigured {
+		// skipauth: Authorization is currently for user endpoints only.
+		svc.authz.SkipAuthorization(ctx)
+		return fleet.ErrWindowsMDMNotConfigured
+	}
+
+	return nil
+}
+
+func (svc *Service) GetMDMEndUserStrings(ctx context.Context, teamID *uint) (*fleet.MDMEndUserStrings, error) {
+	// skipauth: The strings are rendered to the end users, on pages that
+	// aren't authenticated or are device-authenticated.
+	svc.authz.SkipAuthorization(ctx)
+
+	appCfg, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+	strs, err := svc.mdmEndUserStrings(ctx, appCfg, teamID)
+	if err != nil {
+		return nil, err
+	}
+	return &strs, nil
+}
+
+// mdmEndUserStrings returns the strings to render to the end users of the
+// team (nil for no team), in the languages accepted by the current request.
+// The migration message is only set if the migration of the team is enabled.
+func (svc *Service) mdmEndUserStrings(ctx context.Context, appCfg *fleet.AppConfig, teamID *uint) (fleet.MDMEndUserStrings, error) {
+	localizations := []fleet.MDMLocalization{appCfg.MDM.Localization}
+	var migration fleet.MacOSMigration
+	if teamID != nil && *teamID != 0 {
+		tm, err := svc.ds.Team(ctx, *teamID)
+		if err != nil {
+			return fleet.MDMEndUserStrings{}, ctxerr.Wrap(ctx, err, "get team")
+		}
+		// the translations of the team take precedence over the global ones.
+		localizations = []fleet.MDMLocalization{tm.Config.MDM.Localization, appCfg.MDM.Localization}
+		migration = tm.Config.MDM.MacOSMigration
+	}
+
+	strs := fleet.LocalizeMDMEndUserStrings(locale.FromContext(ctx), localizations...)
+	if strs.SSOErrorMessage == "" {
+		strs.SSOErrorMessage = fleet.DefaultMDMSSOErrorMessage
+	}
+	switch {
+	case !migration.Enable:
+		strs.MigrationMessage = ""
+	case strs.MigrationMessage == "":
+		strs.MigrationMessage = migration.EndUserMessage
+	}
+	return strs, nil
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // POST /mdm/apple/setup/eula
 ////////////////////////////////////////////////////////////////////////////////
@@ -292,7 +354,17 @@ type getMDMAppleEULAResponse struct {
 func (r getMDMAppleEULAResponse) error() error { return r.Err }
 
 func (r getMDMAppleEULAResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
-	w.Header().Set("Content-Length", strconv.Itoa(len(r.eula.Bytes)))
+	if r.eula.CDNURL != "" {
+		w.Header().Set("Location", r.eula.CDNURL)
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+	if r.eula.Content != nil {
+		defer r.eula.Content.Close()
+		w.Header().Set("Content-Length", strconv.FormatInt(r.eula.Size, 10))
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(r.eula.Bytes)))
+	}
 	w.Header().Set("Content-Type", "application/pdf")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 
@@ -300,6 +372,12 @@ func (r getMDMAppleEULAResponse) hijackRender(ctx context.Context, w http.Respon
 	// `http.ResponseWriter` sets the status code to 200 (and it can't be
 	// changed.) Clients should rely on matching content-length with the
 	// header provided
+	if r.eula.Content != nil {
+		if n, err := io.Copy(w, r.eula.Content); err != nil {
+			logging.WithExtras(ctx, "err", err, "bytes_copied", n)
+		}
+		return
+	}
 	if n, err := w.Write(r.eula.Bytes); err != nil {
 		logging.WithExtras(ctx, "err", err, "bytes_copied", n)
 	}
@@ -383,3 +461,176 @@ func (svc *Service) MDMAppleDeleteEULA(ctx context.Context, token string) error
 
 	return fleet.ErrMissingLicense
 }
+
+////////////////////////////////////////////////////////////////////////////////
+// GET /mdm/posture_score
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	defaultMDMPostureScoreHistoryDays = 30
+	maxMDMPostureScoreHistoryDays     = 365
+)
+
+type getMDMPostureScoreRequest struct {
+	TeamID *uint `query:"team_id,optional"`
+	Days   int   `query:"days,optional"`
+}
+
+type getMDMPostureScoreResponse struct {
+	PostureScore *fleet.MDMPostureScore   `json:"posture_score"`
+	History      []*fleet.MDMPostureScore `json:"history"`
+	Err          error                    `json:"error,omitempty"`
+}
+
+func (r getMDMPostureScoreResponse) error() error { return r.Err }
+
+func getMDMPostureScoreEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getMDMPostureScoreRequest)
+	scores, err := svc.ListMDMPostureScores(ctx, req.TeamID, req.Days)
+	if err != nil {
+		return getMDMPostureScoreResponse{Err: err}, nil
+	}
+	res := getMDMPostureScoreResponse{History: scores}
+	if len(scores) > 0 {
+		res.PostureScore = scores[len(scores)-1]
+	} else {
+		res.History = []*fleet.MDMPostureScore{}
+	}
+	return res, nil
+}
+
+func (svc *Service) ListMDMPostureScores(ctx context.Context, teamID *uint, days int) ([]*fleet.MDMPostureScore, error) {
+	authzTeamID := teamID
+	if teamID != nil && *teamID == 0 {
+		authzTeamID = nil
+	}
+	if err := svc.authz.Authorize(ctx, fleet.MDMAppleConfigProfile{TeamID: authzTeamID}, fleet.ActionRead); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	if days == 0 {
+		days = defaultMDMPostureScoreHistoryDays
+	}
+	if days < 1 || days > maxMDMPostureScoreHistoryDays {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("days", fmt.Sprintf("must be between 1 and %d", maxMDMPostureScoreHistoryDays)))
+	}
+
+	since := svc.clock.Now().UTC().AddDate(0, 0, 1-days)
+	scores, err := svc.ds.ListMDMPostureScores(ctx, teamID, since)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list mdm posture scores")
+	}
+	return scores, nil
+}
+
+// ComputeMDMPostureScores computes the MDM posture score of each team, of the
+// hosts in no team and of all hosts, and saves them as the scores of the day
+// of now. The components of the score of all hosts are measured from the
+// sums of the host counts of the teams' components.
+func ComputeMDMPostureScores(ctx context.Context, ds fleet.Datastore, now time.Time) error {
+	appCfg, err := ds.AppConfig(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get app config")
+	}
+	if !appCfg.MDM.EnabledAndConfigured {
+		return nil
+	}
+	weights := appCfg.MDM.PostureScore.Weights.OrDefault()
+
+	teams, err := ds.TeamsSummary(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list teams")
+	}
+	teamIDs := []uint{0}
+	for _, t := range teams {
+		teamIDs = append(teamIDs, t.ID)
+	}
+
+	var global fleet.MDMPostureScoreComponents
+	scores := make([]*fleet.MDMPostureScore, 0, len(teamIDs)+1)
+	for _, teamID := range teamIDs {
+		components, err := measureMDMPostureScoreComponents(ctx, ds, weights, teamID)
+		if err != nil {
+			return err
+		}
+		if global == nil {
+			global = make(fleet.MDMPostureScoreComponents, len(components))
+			copy(global, components)
+		} else {
+			for i, c := range components {
+				global[i].Hosts += c.Hosts
+				global[i].CompliantHosts += c.CompliantHosts
+			}
+		}
+		teamID := teamID
+		scores = append(scores, &fleet.MDMPostureScore{
+			TeamID:     &teamID,
+			Date:       now,
+			Score:      components.Score(),
+			Components: components,
+		})
+	}
+	scores = append(scores, &fleet.MDMPostureScore{
+		Date:       now,
+		Score:      global.Score(),
+		Components: global,
+	})
+
+	if err := ds.SaveMDMPostureScores(ctx, scores); err != nil {
+		return ctxerr.Wrap(ctx, err, "save mdm posture scores")
+	}
+	return nil
+}
+
+// measureMDMPostureScoreComponents measures the components of the MDM
+// posture score of the team, 0 for the hosts in no team.
+func measureMDMPostureScoreComponents(ctx context.Context, ds fleet.Datastore, weights fleet.MDMPostureScoreWeights, teamID uint) (fleet.MDMPostureScoreComponents, error) {
+	var tmID *uint
+	if teamID > 0 {
+		tmID = &teamID
+	}
+
+	fvs, err := ds.GetMDMAppleFileVaultSummary(ctx, tmID)
+	if err != nil {
+		return nil, ctxerr.Wrapf(ctx, err, "get filevault summary for team %d", teamID)
+	}
+	ps, err := ds.GetMDMAppleHostsProfilesSummary(ctx, tmID)
+	if err != nil {
+		return nil, ctxerr.Wrapf(ctx, err, "get profiles summary for team %d", teamID)
+	}
+	counts, err := ds.GetMDMPostureScoreHostCounts(ctx, tmID)
+	if err != nil {
+		return nil, ctxerr.Wrapf(ctx, err, "get host counts for team %d", teamID)
+	}
+	ous, err := ds.GetMDMAppleOSUpdatesSummary(ctx, teamID)
+	if err != nil {
+		return nil, ctxerr.Wrapf(ctx, err, "get os updates summary for team %d", teamID)
+	}
+
+	return fleet.MDMPostureScoreComponents{
+		{
+			Name:           fleet.MDMPostureScoreDiskEncryption,
+			Weight:         weights.DiskEncryption,
+			Hosts:          fvs.Verifying + fvs.ActionRequired + fvs.Enforcing + fvs.Failed,
+			CompliantHosts: fvs.Verifying,
+		},
+		{
+			Name:           fleet.MDMPostureScoreProfiles,
+			Weight:         weights.Profiles,
+			Hosts:          ps.Verifying + ps.Pending + ps.Failed,
+			CompliantHosts: ps.Verifying + ps.Pending,
+		},
+		{
+			Name:           fleet.MDMPostureScoreEnrollment,
+			Weight:         weights.Enrollment,
+			Hosts:          counts.MacOSHosts,
+			CompliantHosts: counts.EnrolledHosts,
+		},
+		{
+			Name:           fleet.MDMPostureScoreOSCurrency,
+			Weight:         weights.OSCurrency,
+			Hosts:          ous.Compliant + ous.Deferred + ous.Behind,
+			CompliantHosts: ous.Compliant,
+		},
+	}, nil
+}
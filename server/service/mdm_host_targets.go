@@ -0,0 +1,347 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/contexts/viewer"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// Create MDM host target
+////////////////////////////////////////////////////////////////////////////////
+
+type newMDMHostTargetRequest struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	Filters     fleet.MDMHostTargetFilters `json:"filters"`
+}
+
+type newMDMHostTargetResponse struct {
+	Target *fleet.MDMHostTarget `json:"target,omitempty"`
+	Err    error                `json:"error,omitempty"`
+}
+
+func (r newMDMHostTargetResponse) error() error { return r.Err }
+
+func newMDMHostTargetEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*newMDMHostTargetRequest)
+	target, err := svc.NewMDMHostTarget(ctx, req.Name, req.Description, req.Filters)
+	if err != nil {
+		return newMDMHostTargetResponse{Err: err}, nil
+	}
+	return newMDMHostTargetResponse{Target: target}, nil
+}
+
+func (svc *Service) NewMDMHostTarget(ctx context.Context, name, description string, filters fleet.MDMHostTargetFilters) (*fleet.MDMHostTarget, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMHostTarget{}, fleet.ActionWrite); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("name", "name is required"))
+	}
+	if err := filters.Validate(); err != nil {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("filters", err.Error()))
+	}
+	for _, tmID := range filters.TeamIDs {
+		if tmID == 0 {
+			continue
+		}
+		if _, err := svc.ds.Team(ctx, tmID); err != nil {
+			if fleet.IsNotFound(err) {
+				return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("filters.team_ids", "team does not exist"))
+			}
+			return nil, ctxerr.Wrap(ctx, err, "get team")
+		}
+	}
+
+	target, err := svc.ds.NewMDMHostTarget(ctx, &fleet.MDMHostTarget{
+		Name:                 name,
+		Description:          description,
+		MDMHostTargetFilters: filters,
+	})
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "create mdm host target")
+	}
+	return target, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// List MDM host targets
+////////////////////////////////////////////////////////////////////////////////
+
+type listMDMHostTargetsRequest struct{}
+
+type listMDMHostTargetsResponse struct {
+	Targets []*fleet.MDMHostTarget `json:"targets"`
+	Err     error                  `json:"error,omitempty"`
+}
+
+func (r listMDMHostTargetsResponse) error() error { return r.Err }
+
+func listMDMHostTargetsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	targets, err := svc.ListMDMHostTargets(ctx)
+	if err != nil {
+		return listMDMHostTargetsResponse{Err: err}, nil
+	}
+	return listMDMHostTargetsResponse{Targets: targets}, nil
+}
+
+func (svc *Service) ListMDMHostTargets(ctx context.Context) ([]*fleet.MDMHostTarget, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMHostTarget{}, fleet.ActionRead); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	targets, err := svc.ds.ListMDMHostTargets(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list mdm host targets")
+	}
+	return targets, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// List the hosts of an MDM host target
+////////////////////////////////////////////////////////////////////////////////
+
+type listMDMHostTargetHostsRequest struct {
+	ID uint `url:"id"`
+}
+
+type listMDMHostTargetHostsResponse struct {
+	Hosts []*fleet.MDMHostTargetHost `json:"hosts"`
+	Err   error                      `json:"error,omitempty"`
+}
+
+func (r listMDMHostTargetHostsResponse) error() error { return r.Err }
+
+func listMDMHostTargetHostsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listMDMHostTargetHostsRequest)
+	hosts, err := svc.ListMDMHostTargetHosts(ctx, req.ID)
+	if err != nil {
+		return listMDMHostTargetHostsResponse{Err: err}, nil
+	}
+	return listMDMHostTargetHostsResponse{Hosts: hosts}, nil
+}
+
+func (svc *Service) ListMDMHostTargetHosts(ctx context.Context, id uint) ([]*fleet.MDMHostTargetHost, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMHostTarget{}, fleet.ActionRead); err != nil {
+		return nil, ctxerr.Wrap(ctx, err)
+	}
+
+	if _, err := svc.ds.MDMHostTarget(ctx, id); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get mdm host target")
+	}
+	hosts, err := svc.ds.ListMDMHostTargetHosts(ctx, id)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list mdm host target hosts")
+	}
+
+	vc, ok := viewer.FromContext(ctx)
+	if !ok {
+		return nil, fleet.ErrNoContext
+	}
+	return filterMDMHostTargetHostsForUser(vc.User, hosts), nil
+}
+
+// filterMDMHostTargetHostsForUser returns the hosts of a target that the user
+// can see, a target matches hosts of any team but the team users only see the
+// hosts of their teams.
+func filterMDMHostTargetHostsForUser(user *fleet.User, hosts []*fleet.MDMHostTargetHost) []*fleet.MDMHostTargetHost {
+	if user.GlobalRole != nil {
+		return hosts
+	}
+	userTeams := make(map[uint]bool, len(user.Teams))
+	for _, t := range user.Teams {
+		userTeams[t.ID] = true
+	}
+	filtered := hosts[:0]
+	for _, h := range hosts {
+		if h.TeamID != nil && userTeams[*h.TeamID] {
+			filtered = append(filtered, h)
+		}
+	}
+	return filtered
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Delete MDM host target
+////////////////////////////////////////////////////////////////////////////////
+
+type deleteMDMHostTargetRequest struct {
+	ID uint `url:"id"`
+}
+
+type deleteMDMHostTargetResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r deleteMDMHostTargetResponse) error() error { return r.Err }
+
+func deleteMDMHostTargetEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*deleteMDMHostTargetRequest)
+	if err := svc.DeleteMDMHostTarget(ctx, req.ID); err != nil {
+		return deleteMDMHostTargetResponse{Err: err}, nil
+	}
+	return deleteMDMHostTargetResponse{}, nil
+}
+
+func (svc *Service) DeleteMDMHostTarget(ctx context.Context, id uint) error {
+	if err := svc.authz.Authorize(ctx, &fleet.MDMHostTarget{}, fleet.ActionWrite); err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+
+	if err := svc.ds.DeleteMDMHostTarget(ctx, id); err != nil {
+		if fleet.IsForeignKey(err) {
+			return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("id",
+				"Couldn't delete the target because configuration profiles are restricted to it."))
+		}
+		return ctxerr.Wrap(ctx, err, "delete mdm host target")
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Set the MDM host target of a profile
+////////////////////////////////////////////////////////////////////////////////
+
+type setMDMAppleConfigProfileTargetRequest struct {
+	ProfileID uint  `url:"profile_id"`
+	TargetID  *uint `json:"target_id"`
+}
+
+type setMDMAppleConfigProfileTargetResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r setMDMAppleConfigProfileTargetResponse) error() error { return r.Err }
+
+func setMDMAppleConfigProfileTargetEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*setMDMAppleConfigProfileTargetRequest)
+	if err := svc.SetMDMAppleConfigProfileTarget(ctx, req.ProfileID, req.TargetID); err != nil {
+		return setMDMAppleConfigProfileTargetResponse{Err: err}, nil
+	}
+	return setMDMAppleConfigProfileTargetResponse{}, nil
+}
+
+func (svc *Service) SetMDMAppleConfigProfileTarget(ctx context.Context, profileID uint, targetID *uint) error {
+	// first we perform a perform basic authz check
+	if err := svc.authz.Authorize(ctx, &fleet.Team{}, fleet.ActionRead); err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+
+	cp, err := svc.ds.GetMDMAppleConfigProfile(ctx, profileID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+	var teamID *uint
+	if cp.TeamID != nil && *cp.TeamID > 0 {
+		teamID = cp.TeamID
+	}
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleConfigProfile{TeamID: teamID}, fleet.ActionWrite); err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+
+	if targetID != nil {
+		if err := svc.authz.Authorize(ctx, &fleet.MDMHostTarget{}, fleet.ActionRead); err != nil {
+			return ctxerr.Wrap(ctx, err)
+		}
+		if _, err := svc.ds.MDMHostTarget(ctx, *targetID); err != nil {
+			if fleet.IsNotFound(err) {
+				return ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("target_id", "target does not exist"))
+			}
+			return ctxerr.Wrap(ctx, err, "get mdm host target")
+		}
+	}
+
+	if err := svc.ds.SetMDMAppleConfigProfileTarget(ctx, profileID, targetID); err != nil {
+		return ctxerr.Wrap(ctx, err, "set profile target")
+	}
+	// the profile is installed or removed on the hosts by the profiles
+	// reconciliation, mark the hosts that are affected as pending.
+	if err := svc.ds.BulkSetPendingMDMAppleHostProfiles(ctx, nil, nil, []uint{profileID}, nil); err != nil {
+		return ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Set the macOS versions of a profile
+////////////////////////////////////////////////////////////////////////////////
+
+type setMDMAppleConfigProfileMacOSVersionsRequest struct {
+	ProfileID       uint   `url:"profile_id"`
+	MinMacOSVersion string `json:"min_macos_version"`
+	MaxMacOSVersion string `json:"max_macos_version"`
+}
+
+type setMDMAppleConfigProfileMacOSVersionsResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r setMDMAppleConfigProfileMacOSVersionsResponse) error() error { return r.Err }
+
+func setMDMAppleConfigProfileMacOSVersionsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*setMDMAppleConfigProfileMacOSVersionsRequest)
+	if err := svc.SetMDMAppleConfigProfileMacOSVersions(ctx, req.ProfileID, req.MinMacOSVersion, req.MaxMacOSVersion); err != nil {
+		return setMDMAppleConfigProfileMacOSVersionsResponse{Err: err}, nil
+	}
+	return setMDMAppleConfigProfileMacOSVersionsResponse{}, nil
+}
+
+func (svc *Service) SetMDMAppleConfigProfileMacOSVersions(ctx context.Context, profileID uint, min, max string) error {
+	// first we perform a perform basic authz check
+	if err := svc.authz.Authorize(ctx, &fleet.Team{}, fleet.ActionRead); err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+
+	cp, err := svc.ds.GetMDMAppleConfigProfile(ctx, profileID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+	var teamID *uint
+	if cp.TeamID != nil && *cp.TeamID > 0 {
+		teamID = cp.TeamID
+	}
+	if err := svc.authz.Authorize(ctx, &fleet.MDMAppleConfigProfile{TeamID: teamID}, fleet.ActionWrite); err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+
+	min, max = strings.TrimSpace(min), strings.TrimSpace(max)
+	if err := fleet.ValidateMDMAppleProfileMacOSVersions(min, max); err != nil {
+		return ctxerr.Wrap(ctx, err)
+	}
+
+	if err := svc.ds.SetMDMAppleConfigProfileMacOSVersions(ctx, profileID, min, max); err != nil {
+		return ctxerr.Wrap(ctx, err, "set profile macOS versions")
+	}
+	// the hosts that are no longer in the range get the profile removed, and
+	// the ones that are now in the range get it installed.
+	if err := svc.ds.BulkSetPendingMDMAppleHostProfiles(ctx, nil, nil, []uint{profileID}, nil); err != nil {
+		return ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
+	}
+	return nil
+}
+
+// mdmHostTargetDeviceIDs returns the UUIDs of the hosts that currently match
+// the MDM host target.
+func (svc *Service) mdmHostTargetDeviceIDs(ctx context.Context, targetID uint) ([]string, error) {
+	if _, err := svc.ds.MDMHostTarget(ctx, targetID); err != nil {
+		if fleet.IsNotFound(err) {
+			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("target_id", "target does not exist"))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get mdm host target")
+	}
+	hosts, err := svc.ds.ListMDMHostTargetHosts(ctx, targetID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list mdm host target hosts")
+	}
+	uuids := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		uuids = append(uuids, h.UUID)
+	}
+	return uuids, nil
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/authz"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/fleetdm/fleet/v4/server/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMDMHostTargetsAuthz(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	ds.NewMDMHostTargetFunc = func(ctx context.Context, target *fleet.MDMHostTarget) (*fleet.MDMHostTarget, error) {
+		target.ID = 1
+		return target, nil
+	}
+	ds.ListMDMHostTargetsFunc = func(ctx context.Context) ([]*fleet.MDMHostTarget, error) {
+		return nil, nil
+	}
+	ds.MDMHostTargetFunc = func(ctx context.Context, id uint) (*fleet.MDMHostTarget, error) {
+		return &fleet.MDMHostTarget{ID: id}, nil
+	}
+	ds.ListMDMHostTargetHostsFunc = func(ctx context.Context, id uint) ([]*fleet.MDMHostTargetHost, error) {
+		return nil, nil
+	}
+	ds.DeleteMDMHostTargetFunc = func(ctx context.Context, id uint) error {
+		return nil
+	}
+
+	checkAuthErr := func(t *testing.T, shouldFailWithAuth bool, err error) {
+		t.Helper()
+		if shouldFailWithAuth {
+			require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+		} else {
+			require.NoError(t, err)
+		}
+	}
+
+	testCases := []struct {
+		name      string
+		user      *fleet.User
+		readFails bool
+		writeFail bool
+	}{
+		{"global admin", test.UserAdmin, false, false},
+		{"global maintainer", test.UserMaintainer, false, false},
+		{"global observer", test.UserObserver, false, true},
+		{"global gitops", test.UserGitOps, true, false},
+		{"team admin", test.UserTeamAdminTeam1, false, true},
+		{"team maintainer", test.UserTeamMaintainerTeam1, false, true},
+		{"team observer", test.UserTeamObserverTeam1, true, true},
+		{"user without roles", test.UserNoRoles, true, true},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := test.UserContext(ctx, tt.user)
+
+			_, err := svc.NewMDMHostTarget(ctx, "target", "", fleet.MDMHostTargetFilters{})
+			checkAuthErr(t, tt.writeFail, err)
+			err = svc.DeleteMDMHostTarget(ctx, 1)
+			checkAuthErr(t, tt.writeFail, err)
+
+			_, err = svc.ListMDMHostTargets(ctx)
+			checkAuthErr(t, tt.readFails, err)
+			_, err = svc.ListMDMHostTargetHosts(ctx, 1)
+			checkAuthErr(t, tt.readFails, err)
+		})
+	}
+}
+
+func TestNewMDMHostTarget(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+	ctx = test.UserContext(ctx, test.UserAdmin)
+
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		if tid != 1 {
+			return nil, &notFoundError{}
+		}
+		return &fleet.Team{ID: tid}, nil
+	}
+	ds.NewMDMHostTargetFunc = func(ctx context.Context, target *fleet.MDMHostTarget) (*fleet.MDMHostTarget, error) {
+		target.ID = 1
+		return target, nil
+	}
+
+	_, err := svc.NewMDMHostTarget(ctx, " ", "", fleet.MDMHostTargetFilters{})
+	require.ErrorContains(t, err, "name is required")
+	_, err = svc.NewMDMHostTarget(ctx, "target", "", fleet.MDMHostTargetFilters{OSVersionAtLeast: "thirteen"})
+	require.ErrorContains(t, err, "filters")
+	_, err = svc.NewMDMHostTarget(ctx, "target", "", fleet.MDMHostTargetFilters{TeamIDs: []uint{2}})
+	require.ErrorContains(t, err, "team does not exist")
+	require.False(t, ds.NewMDMHostTargetFuncInvoked)
+
+	target, err := svc.NewMDMHostTarget(ctx, " target ", "desc", fleet.MDMHostTargetFilters{
+		TeamIDs:          []uint{0, 1},
+		Architecture:     "arm64",
+		OSVersionAtLeast: "13.0",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "target", target.Name)
+	require.Equal(t, fleet.MDMHostTargetTeamIDs{0, 1}, target.TeamIDs)
+}
+
+func TestListMDMHostTargetHosts(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	ds.MDMHostTargetFunc = func(ctx context.Context, id uint) (*fleet.MDMHostTarget, error) {
+		return &fleet.MDMHostTarget{ID: id}, nil
+	}
+	ds.ListMDMHostTargetHostsFunc = func(ctx context.Context, id uint) ([]*fleet.MDMHostTargetHost, error) {
+		return []*fleet.MDMHostTargetHost{
+			{ID: 1, UUID: "host1", TeamID: ptr.Uint(1)},
+			{ID: 2, UUID: "host2", TeamID: ptr.Uint(2)},
+			{ID: 3, UUID: "host3"},
+		}, nil
+	}
+
+	hosts, err := svc.ListMDMHostTargetHosts(test.UserContext(ctx, test.UserAdmin), 1)
+	require.NoError(t, err)
+	require.Len(t, hosts, 3)
+
+	// team users only see the hosts of their teams
+	hosts, err = svc.ListMDMHostTargetHosts(test.UserContext(ctx, test.UserTeamMaintainerTeam1), 1)
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	require.Equal(t, "host1", hosts[0].UUID)
+}
+
+func TestSetMDMAppleConfigProfileTarget(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	ds.GetMDMAppleConfigProfileFunc = func(ctx context.Context, profileID uint) (*fleet.MDMAppleConfigProfile, error) {
+		return &fleet.MDMAppleConfigProfile{ProfileID: profileID, TeamID: ptr.Uint(1)}, nil
+	}
+	ds.MDMHostTargetFunc = func(ctx context.Context, id uint) (*fleet.MDMHostTarget, error) {
+		if id != 1 {
+			return nil, &notFoundError{}
+		}
+		return &fleet.MDMHostTarget{ID: id}, nil
+	}
+	var gotTargetID *uint
+	ds.SetMDMAppleConfigProfileTargetFunc = func(ctx context.Context, profileID uint, targetID *uint) error {
+		gotTargetID = targetID
+		return nil
+	}
+	var gotProfileIDs []uint
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, hostUUIDs []string) error {
+		gotProfileIDs = profileIDs
+		return nil
+	}
+
+	// team 2 users can't change the profiles of team 1
+	err := svc.SetMDMAppleConfigProfileTarget(test.UserContext(ctx, test.UserTeamAdminTeam2), 1, ptr.Uint(1))
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+
+	ctx = test.UserContext(ctx, test.UserTeamAdminTeam1)
+	err = svc.SetMDMAppleConfigProfileTarget(ctx, 1, ptr.Uint(2))
+	require.ErrorContains(t, err, "target does not exist")
+	require.False(t, ds.SetMDMAppleConfigProfileTargetFuncInvoked)
+
+	err = svc.SetMDMAppleConfigProfileTarget(ctx, 1, ptr.Uint(1))
+	require.NoError(t, err)
+	require.Equal(t, ptr.Uint(1), gotTargetID)
+	require.Equal(t, []uint{1}, gotProfileIDs)
+
+	// the target can be removed
+	err = svc.SetMDMAppleConfigProfileTarget(ctx, 1, nil)
+	require.NoError(t, err)
+	require.Nil(t, gotTargetID)
+}
+
+func TestSetMDMAppleConfigProfileMacOSVersions(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	ds.GetMDMAppleConfigProfileFunc = func(ctx context.Context, profileID uint) (*fleet.MDMAppleConfigProfile, error) {
+		return &fleet.MDMAppleConfigProfile{ProfileID: profileID, TeamID: ptr.Uint(1)}, nil
+	}
+	var gotMin, gotMax string
+	ds.SetMDMAppleConfigProfileMacOSVersionsFunc = func(ctx context.Context, profileID uint, min, max string) error {
+		gotMin, gotMax = min, max
+		return nil
+	}
+	var gotProfileIDs []uint
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, hostUUIDs []string) error {
+		gotProfileIDs = profileIDs
+		return nil
+	}
+
+	// team 2 users can't change the profiles of team 1
+	err := svc.SetMDMAppleConfigProfileMacOSVersions(test.UserContext(ctx, test.UserTeamAdminTeam2), 1, "13", "")
+	require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+
+	ctx = test.UserContext(ctx, test.UserTeamAdminTeam1)
+	err = svc.SetMDMAppleConfigProfileMacOSVersions(ctx, 1, "14", "13")
+	require.ErrorContains(t, err, "must not be older than min_macos_version")
+	err = svc.SetMDMAppleConfigProfileMacOSVersions(ctx, 1, "Ventura", "")
+	require.ErrorContains(t, err, "min_macos_version")
+	require.False(t, ds.SetMDMAppleConfigProfileMacOSVersionsFuncInvoked)
+
+	err = svc.SetMDMAppleConfigProfileMacOSVersions(ctx, 1, " 13.1 ", "14")
+	require.NoError(t, err)
+	require.Equal(t, "13.1", gotMin)
+	require.Equal(t, "14", gotMax)
+	require.Equal(t, []uint{1}, gotProfileIDs)
+}
@@ -3,14 +3,17 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/fleetdm/fleet/v4/server/authz"
 	"github.com/fleetdm/fleet/v4/server/config"
 	authz_ctx "github.com/fleetdm/fleet/v4/server/contexts/authz"
+	"github.com/fleetdm/fleet/v4/server/contexts/locale"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/mock"
+	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/test"
 	"github.com/stretchr/testify/require"
 )
@@ -129,3 +132,185 @@ func TestVerifyMDMAppleConfigured(t *testing.T) {
 	ds.AppConfigFuncInvoked = false
 	require.False(t, authzCtx.Checked())
 }
+
+func TestListMDMPostureScores(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	var gotTeamID *uint
+	var gotSince time.Time
+	ds.ListMDMPostureScoresFunc = func(ctx context.Context, teamID *uint, since time.Time) ([]*fleet.MDMPostureScore, error) {
+		gotTeamID, gotSince = teamID, since
+		return []*fleet.MDMPostureScore{{TeamID: teamID, Score: ptr.Float64(80)}}, nil
+	}
+
+	cases := []struct {
+		desc     string
+		user     *fleet.User
+		teamID   *uint
+		days     int
+		wantDays int
+		wantErr  string
+	}{
+		{"no role", test.UserNoRoles, nil, 0, 0, authz.ForbiddenErrorMessage},
+		{"team admin all hosts", test.UserTeamAdminTeam1, nil, 0, 0, authz.ForbiddenErrorMessage},
+		{"team admin no team", test.UserTeamAdminTeam1, ptr.Uint(0), 0, 0, authz.ForbiddenErrorMessage},
+		{"team admin other team", test.UserTeamAdminTeam1, ptr.Uint(2), 0, 0, authz.ForbiddenErrorMessage},
+		{"team admin", test.UserTeamAdminTeam1, ptr.Uint(1), 0, 30, ""},
+		{"global admin all hosts", test.UserAdmin, nil, 7, 7, ""},
+		{"global admin no team", test.UserAdmin, ptr.Uint(0), 365, 365, ""},
+		{"too many days", test.UserAdmin, nil, 366, 0, "days"},
+		{"negative days", test.UserAdmin, nil, -1, 0, "days"},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			ds.ListMDMPostureScoresFuncInvoked = false
+			scores, err := svc.ListMDMPostureScores(test.UserContext(ctx, c.user), c.teamID, c.days)
+			if c.wantErr != "" {
+				require.ErrorContains(t, err, c.wantErr)
+				require.False(t, ds.ListMDMPostureScoresFuncInvoked)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, scores, 1)
+			require.Equal(t, c.teamID, gotTeamID)
+			require.Equal(t, time.Now().UTC().AddDate(0, 0, 1-c.wantDays).Format("2006-01-02"), gotSince.Format("2006-01-02"))
+		})
+	}
+}
+
+func TestComputeMDMPostureScores(t *testing.T) {
+	ds := new(mock.Store)
+	ctx := context.Background()
+
+	appCfg := &fleet.AppConfig{MDM: fleet.MDM{EnabledAndConfigured: true}}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return appCfg, nil
+	}
+	ds.TeamsSummaryFunc = func(ctx context.Context) ([]*fleet.TeamSummary, error) {
+		return []*fleet.TeamSummary{{ID: 1, Name: "team1"}}, nil
+	}
+	ds.GetMDMAppleFileVaultSummaryFunc = func(ctx context.Context, teamID *uint) (*fleet.MDMAppleFileVaultSummary, error) {
+		if teamID == nil {
+			return &fleet.MDMAppleFileVaultSummary{}, nil
+		}
+		return &fleet.MDMAppleFileVaultSummary{Verifying: 3, ActionRequired: 1}, nil
+	}
+	ds.GetMDMAppleHostsProfilesSummaryFunc = func(ctx context.Context, teamID *uint) (*fleet.MDMAppleConfigProfilesSummary, error) {
+		if teamID == nil {
+			return &fleet.MDMAppleConfigProfilesSummary{Verifying: 1, Failed: 1}, nil
+		}
+		return &fleet.MDMAppleConfigProfilesSummary{Verifying: 2, Pending: 1, Failed: 1}, nil
+	}
+	ds.GetMDMPostureScoreHostCountsFunc = func(ctx context.Context, teamID *uint) (*fleet.MDMPostureScoreHostCounts, error) {
+		if teamID == nil {
+			return &fleet.MDMPostureScoreHostCounts{MacOSHosts: 4, EnrolledHosts: 2}, nil
+		}
+		return &fleet.MDMPostureScoreHostCounts{MacOSHosts: 4, EnrolledHosts: 4}, nil
+	}
+	ds.GetMDMAppleOSUpdatesSummaryFunc = func(ctx context.Context, teamID uint) (*fleet.MDMAppleOSUpdatesSummary, error) {
+		return &fleet.MDMAppleOSUpdatesSummary{}, nil
+	}
+	var saved []*fleet.MDMPostureScore
+	ds.SaveMDMPostureScoresFunc = func(ctx context.Context, scores []*fleet.MDMPostureScore) error {
+		saved = scores
+		return nil
+	}
+
+	now := time.Now()
+	err := ComputeMDMPostureScores(ctx, ds, now)
+	require.NoError(t, err)
+	require.Len(t, saved, 3)
+
+	scoresByTeam := make(map[string]*fleet.MDMPostureScore)
+	for _, s := range saved {
+		require.Equal(t, now, s.Date)
+		require.Len(t, s.Components, 4)
+		key := "global"
+		if s.TeamID != nil {
+			key = fmt.Sprint(*s.TeamID)
+		}
+		scoresByTeam[key] = s
+	}
+
+	// no team: profiles 50%, enrollment 50%, disk encryption and OS currency
+	// not measured
+	require.Equal(t, ptr.Float64(50), scoresByTeam["0"].Score)
+	require.Nil(t, scoresByTeam["0"].Components[0].Score)
+	require.Nil(t, scoresByTeam["0"].Components[3].Score)
+	// team 1: disk encryption 75%, profiles 75%, enrollment 100%
+	require.Equal(t, ptr.Float64(83.3), scoresByTeam["1"].Score)
+	// all hosts: disk encryption 3/4, profiles 4/6, enrollment 6/8
+	require.Equal(t, ptr.Float64(72.2), scoresByTeam["global"].Score)
+	require.Equal(t, fleet.MDMPostureScoreComponent{
+		Name:           fleet.MDMPostureScoreProfiles,
+		Weight:         25,
+		Hosts:          6,
+		CompliantHosts: 4,
+		Score:          ptr.Float64(66.7),
+	}, scoresByTeam["global"].Components[1])
+
+	// custom weights
+	appCfg.MDM.PostureScore.Weights = fleet.MDMPostureScoreWeights{Enrollment: 1}
+	require.NoError(t, ComputeMDMPostureScores(ctx, ds, now))
+	require.Equal(t, ptr.Float64(75), saved[len(saved)-1].Score)
+
+	// not computed if MDM is not configured
+	appCfg.MDM.EnabledAndConfigured = false
+	ds.SaveMDMPostureScoresFuncInvoked = false
+	require.NoError(t, ComputeMDMPostureScores(ctx, ds, now))
+	require.False(t, ds.SaveMDMPostureScoresFuncInvoked)
+}
+
+func TestGetMDMEndUserStrings(t *testing.T) {
+	svc, ctx, ds := setupAppleMDMService(t)
+
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{MDM: fleet.MDM{Localization: fleet.MDMLocalization{
+			Translations: []fleet.MDMEndUserStrings{
+				{Language: "fr", EnrollmentProfileDisplayName: "Inscription", SSOErrorMessage: "Erreur"},
+			},
+		}}}, nil
+	}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		tm := &fleet.Team{ID: tid}
+		tm.Config.MDM.Localization = fleet.MDMLocalization{
+			DefaultLanguage: "de",
+			Translations:    []fleet.MDMEndUserStrings{{Language: "de", EnrollmentProfileDisplayName: "Anmeldung"}},
+		}
+		tm.Config.MDM.MacOSMigration = fleet.MacOSMigration{Enable: tid == 1, Mode: fleet.MacOSMigrationModeVoluntary, EndUserMessage: "Please migrate"}
+		return tm, nil
+	}
+
+	// no team, English defaults
+	strs, err := svc.GetMDMEndUserStrings(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, fleet.DefaultMDMSSOErrorMessage, strs.SSOErrorMessage)
+	require.Empty(t, strs.EnrollmentProfileDisplayName)
+	require.Empty(t, strs.MigrationMessage)
+
+	// no team, accepted language
+	frCtx := locale.NewContext(ctx, "fr-FR,fr;q=0.9")
+	strs, err = svc.GetMDMEndUserStrings(frCtx, nil)
+	require.NoError(t, err)
+	require.Equal(t, "Inscription", strs.EnrollmentProfileDisplayName)
+	require.Equal(t, "Erreur", strs.SSOErrorMessage)
+
+	// the team's default language takes precedence over the global
+	// translations of a language that isn't accepted
+	strs, err = svc.GetMDMEndUserStrings(ctx, ptr.Uint(1))
+	require.NoError(t, err)
+	require.Equal(t, "Anmeldung", strs.EnrollmentProfileDisplayName)
+	require.Equal(t, fleet.DefaultMDMSSOErrorMessage, strs.SSOErrorMessage)
+	require.Equal(t, "Please migrate", strs.MigrationMessage)
+
+	// the accepted language takes precedence over the team's default language
+	strs, err = svc.GetMDMEndUserStrings(frCtx, ptr.Uint(1))
+	require.NoError(t, err)
+	require.Equal(t, "Inscription", strs.EnrollmentProfileDisplayName)
+	require.Equal(t, "Please migrate", strs.MigrationMessage)
+
+	// the migration message is only set if the migration is enabled
+	strs, err = svc.GetMDMEndUserStrings(ctx, ptr.Uint(2))
+	require.NoError(t, err)
+	require.Empty(t, strs.MigrationMessage)
+}
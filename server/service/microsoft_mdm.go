@@ -0,0 +1,430 @@
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/clientcert"
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/contexts/logging"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	microsoft_mdm "github.com/fleetdm/fleet/v4/server/mdm/microsoft"
+	"github.com/google/uuid"
+)
+
+// maxMicrosoftMDMMessageSize is the maximum size of the SOAP and SyncML
+// messages sent by the Windows devices.
+const maxMicrosoftMDMMessageSize = 2 << 20
+
+func decodeMicrosoftMDMMessage(r *http.Request, msg interface{}) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMicrosoftMDMMessageSize+1))
+	if err != nil {
+		return badRequestErr("failed to read message", err)
+	}
+	if len(body) > maxMicrosoftMDMMessageSize {
+		return badRequest(fmt.Sprintf("message is too large, the maximum size is %d bytes", maxMicrosoftMDMMessageSize))
+	}
+	if err := xml.Unmarshal(body, msg); err != nil {
+		return badRequestErr("failed to decode message", err)
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// MS-MDE2 enrollment (discovery, policy and enrollment services)
+////////////////////////////////////////////////////////////////////////////////
+
+type mdmMicrosoftSoapRequest struct {
+	Message *fleet.SoapRequest
+}
+
+func (mdmMicrosoftSoapRequest) DecodeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var msg fleet.SoapRequest
+	if err := decodeMicrosoftMDMMessage(r, &msg); err != nil {
+		return nil, err
+	}
+	return &mdmMicrosoftSoapRequest{Message: &msg}, nil
+}
+
+type mdmMicrosoftSoapResponse struct {
+	// Message is rendered as the body of the response, it is a SOAP fault if
+	// the request failed.
+	Message    *fleet.SoapResponse
+	StatusCode int
+}
+
+// the errors of the enrollment are returned to the device as SOAP faults
+// rendered by hijackRender.
+func (r mdmMicrosoftSoapResponse) error() error { return nil }
+
+func (r mdmMicrosoftSoapResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
+	b, err := xml.Marshal(r.Message)
+	if err != nil {
+		logging.WithErr(ctx, ctxerr.Wrap(ctx, err, "marshal soap response"))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", microsoft_mdm.SoapContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	if r.StatusCode != 0 {
+		w.WriteHeader(r.StatusCode)
+	}
+	if n, err := w.Write(b); err != nil {
+		logging.WithExtras(ctx, "err", err, "written", n)
+	}
+}
+
+// newMDMMicrosoftSoapResponse returns the response to the SOAP request,
+// which is a SOAP fault if err is not nil. The errors that are not Windows
+// MDM faults are logged and returned as internal service faults, with the
+// trace ID of the log.
+func newMDMMicrosoftSoapResponse(ctx context.Context, req *fleet.SoapRequest, resp *fleet.SoapResponse, err error) mdmMicrosoftSoapResponse {
+	if err == nil {
+		return mdmMicrosoftSoapResponse{Message: resp}
+	}
+
+	traceID := uuid.New().String()
+	logging.WithErr(ctx, err)
+	logging.WithExtras(ctx, "trace_id", traceID)
+
+	var fault *fleet.MicrosoftMDMFault
+	if !errors.As(err, &fault) {
+		fault = fleet.NewMicrosoftMDMFault(microsoft_mdm.ErrorTypeInternalServiceFault, false, "internal server error")
+	}
+	return mdmMicrosoftSoapResponse{
+		Message:    microsoft_mdm.NewSoapFault(req.Header.MessageID, fault, traceID),
+		StatusCode: fault.StatusCode(),
+	}
+}
+
+// mdmMicrosoftDiscoveryGetEndpoint responds to the GET request that the
+// Windows devices send to verify that the discovery service is reachable
+// before sending the discovery request.
+func mdmMicrosoftDiscoveryGetEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	if err := svc.VerifyMDMMicrosoftDiscovery(ctx); err != nil {
+		return mdmMicrosoftDiscoveryGetResponse{Err: err}, nil
+	}
+	return mdmMicrosoftDiscoveryGetResponse{}, nil
+}
+
+type mdmMicrosoftDiscoveryGetResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r mdmMicrosoftDiscoveryGetResponse) error() error { return r.Err }
+
+func (r mdmMicrosoftDiscoveryGetResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (svc *Service) VerifyMDMMicrosoftDiscovery(ctx context.Context) error {
+	// skipauth: The Windows MDM enrollment endpoints are unauthenticated, the
+	// device authenticates with an enroll secret in the enrollment messages.
+	svc.authz.SkipAuthorization(ctx)
+	return nil
+}
+
+func mdmMicrosoftDiscoveryEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*mdmMicrosoftSoapRequest)
+	resp, err := svc.GetMDMMicrosoftDiscoveryResponse(ctx, req.Message)
+	return newMDMMicrosoftSoapResponse(ctx, req.Message, resp, err), nil
+}
+
+func (svc *Service) GetMDMMicrosoftDiscoveryResponse(ctx context.Context, req *fleet.SoapRequest) (*fleet.SoapResponse, error) {
+	// skipauth: The Windows MDM enrollment endpoints are unauthenticated, the
+	// device authenticates with an enroll secret in the enrollment messages.
+	svc.authz.SkipAuthorization(ctx)
+
+	if req.Header.Action != microsoft_mdm.ActionDiscover || req.Body.Discover == nil {
+		return nil, fleet.NewMicrosoftMDMFault(microsoft_mdm.ErrorTypeMessageFormat, true, "invalid discovery request")
+	}
+
+	appConfig, err := svc.ds.AppConfig(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get app config")
+	}
+	policyURL, err := microsoft_mdm.ResolveMicrosoftMDMPolicyURL(appConfig.ServerSettings.ServerURL)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "resolve policy url")
+	}
+	enrollURL, err := microsoft_mdm.ResolveMicrosoftMDMEnrollURL(appConfig.ServerSettings.ServerURL)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "resolve enroll url")
+	}
+
+	version := microsoft_mdm.NegotiateEnrollmentVersion(req.Body.Discover.RequestVersion)
+	return microsoft_mdm.NewDiscoverResponse(req.Header.MessageID, version, policyURL, enrollURL), nil
+}
+
+func mdmMicrosoftPolicyEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*mdmMicrosoftSoapRequest)
+	resp, err := svc.GetMDMMicrosoftPolicyResponse(ctx, req.Message)
+	return newMDMMicrosoftSoapResponse(ctx, req.Message, resp, err), nil
+}
+
+func (svc *Service) GetMDMMicrosoftPolicyResponse(ctx context.Context, req *fleet.SoapRequest) (*fleet.SoapResponse, error) {
+	// skipauth: The Windows MDM enrollment endpoints are unauthenticated, the
+	// device authenticates with an enroll secret in the enrollment messages.
+	svc.authz.SkipAuthorization(ctx)
+
+	if req.Header.Action != microsoft_mdm.ActionGetPolicies || req.Body.GetPolicies == nil {
+		return nil, fleet.NewMicrosoftMDMFault(microsoft_mdm.ErrorTypeMessageFormat, true, "invalid policy request")
+	}
+	if _, err := svc.authenticateMDMMicrosoftRequest(ctx, req); err != nil {
+		return nil, err
+	}
+	return microsoft_mdm.NewGetPoliciesResponse(req.Header.MessageID), nil
+}
+
+func mdmMicrosoftEnrollEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*mdmMicrosoftSoapRequest)
+	resp, err := svc.GetMDMMicrosoftEnrollResponse(ctx, req.Message)
+	return newMDMMicrosoftSoapResponse(ctx, req.Message, resp, err), nil
+}
+
+func (svc *Service) GetMDMMicrosoftEnrollResponse(ctx context.Context, req *fleet.SoapRequest) (*fleet.SoapResponse, error) {
+	// skipauth: The Windows MDM enrollment endpoints are unauthenticated, the
+	// device authenticates with an enroll secret in the enrollment messages.
+	svc.authz.SkipAuthorization(ctx)
+
+	rst := req.Body.RequestSecurityToken
+	if req.Header.Action != microsoft_mdm.ActionRequestSecurityToken || rst == nil {
+		return nil, fleet.NewMicrosoftMDMFault(microsoft_mdm.ErrorTypeMessageFormat, true, "invalid enrollment request")
+	}
+	deviceID := rst.ContextItem(microsoft_mdm.ContextItemDeviceID)
+	if deviceID == "" {
+		return nil, fleet.NewMicrosoftMDMFault(microsoft_mdm.ErrorTypeMessageFormat, true, "missing device ID")
+	}
+
+	secret, err := svc.authenticateMDMMicrosoftRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if rst.BinarySecurityToken.ValueType != microsoft_mdm.ValueTypePKCS10 {
+		return nil, fleet.NewMicrosoftMDMFault(microsoft_mdm.ErrorTypeCertificateRequest, true, "unsupported certificate request type")
+	}
+	der, err := base64.StdEncoding.DecodeString(rst.BinarySecurityToken.Value)
+	if err != nil {
+		return nil, fleet.NewMicrosoftMDMFault(microsoft_mdm.ErrorTypeCertificateRequest, true, "invalid certificate request encoding")
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fleet.NewMicrosoftMDMFault(microsoft_mdm.ErrorTypeCertificateRequest, true, "invalid certificate request")
+	}
+
+	certManager, err := svc.mdmMicrosoftCertManager(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deviceCert, err := certManager.SignClientCSR(csr, deviceID, svc.clock.Now())
+	if err != nil {
+		return nil, fleet.NewMicrosoftMDMFault(microsoft_mdm.ErrorTypeCertificateRequest, true, err.Error())
+	}
+
+	managementURL, err := svc.mdmMicrosoftManagementURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	appAuth, err := microsoft_mdm.NewAppAuth(deviceID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "generate app auth credentials")
+	}
+	enrollType := rst.ContextItem(microsoft_mdm.ContextItemEnrollmentType)
+	provisioningDoc, err := microsoft_mdm.NewProvisioningDoc(certManager.IdentityCert(), deviceCert, deviceID, enrollType, managementURL, appAuth)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "create provisioning document")
+	}
+
+	// the device is not linked to a host yet, as the device can't be
+	// identified from the values of the request, which it controls. It is
+	// linked when the osquery agent of its host reports its MDM device ID.
+	device := &fleet.MDMWindowsEnrolledDevice{
+		MDMDeviceID:            deviceID,
+		MDMHardwareID:          rst.ContextItem(microsoft_mdm.ContextItemHWDevID),
+		MDMDeviceState:         fleet.MDMDeviceStateEnrolled,
+		MDMDeviceType:          rst.ContextItem(microsoft_mdm.ContextItemDeviceType),
+		MDMDeviceName:          rst.ContextItem(microsoft_mdm.ContextItemDeviceName),
+		MDMEnrollType:          enrollType,
+		MDMEnrollUserID:        req.Header.Security.Username,
+		MDMEnrollProtoVersion:  microsoft_mdm.NegotiateEnrollmentVersion(rst.ContextItem(microsoft_mdm.ContextItemRequestVersion)),
+		MDMEnrollClientVersion: rst.ContextItem(microsoft_mdm.ContextItemApplicationVersion),
+		MDMNotInOOBE:           rst.ContextItem(microsoft_mdm.ContextItemNotInOobe) == "true",
+		MDMIdentityCertSerial:  deviceCert.SerialNumber.Text(16),
+		TeamID:                 secret.TeamID,
+	}
+	if err := svc.ds.MDMWindowsInsertEnrolledDevice(ctx, device); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "insert enrolled device")
+	}
+
+	if err := svc.ds.NewActivity(ctx, nil, &fleet.ActivityTypeMDMEnrolled{
+		HostDisplayName: device.MDMDeviceName,
+		MDMPlatform:     fleet.MDMPlatformMicrosoft,
+	}); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "create mdm enrolled activity")
+	}
+
+	return microsoft_mdm.NewRequestSecurityTokenResponse(req.Header.MessageID, provisioningDoc, svc.clock.Now()), nil
+}
+
+// mdmMicrosoftManagementURL returns the URL of the management sessions of
+// the Windows devices, based on the Windows management URL if it is set, or
+// on the server URL otherwise.
+func (svc *Service) mdmMicrosoftManagementURL(ctx context.Context) (string, error) {
+	baseURL := svc.config.MDM.WindowsManagementURL
+	if baseURL == "" {
+		appConfig, err := svc.ds.AppConfig(ctx)
+		if err != nil {
+			return "", ctxerr.Wrap(ctx, err, "get app config")
+		}
+		baseURL = appConfig.ServerSettings.ServerURL
+	}
+	managementURL, err := microsoft_mdm.ResolveMicrosoftMDMManagementURL(baseURL)
+	if err != nil {
+		return "", ctxerr.Wrap(ctx, err, "resolve management url")
+	}
+	return managementURL, nil
+}
+
+// mdmMicrosoftCertManager returns the manager of the identity certificates of
+// the Windows devices, signed by the WSTEP certificate authority.
+func (svc *Service) mdmMicrosoftCertManager(ctx context.Context) (*microsoft_mdm.CertManager, error) {
+	identityCert, _, _, err := svc.config.MDM.MicrosoftWSTEP()
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get WSTEP identity certificate")
+	}
+	certManager, err := microsoft_mdm.NewCertManager(identityCert)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "create WSTEP certificate manager")
+	}
+	return certManager, nil
+}
+
+// authenticateMDMMicrosoftRequest verifies the credentials of the policy and
+// enrollment requests, the password being an enroll secret of Fleet. It
+// returns the enroll secret, which determines the team of the device.
+func (svc *Service) authenticateMDMMicrosoftRequest(ctx context.Context, req *fleet.SoapRequest) (*fleet.EnrollSecret, error) {
+	if req.Header.Security == nil || req.Header.Security.Password == "" {
+		return nil, fleet.NewMicrosoftMDMFault(microsoft_mdm.ErrorTypeAuthentication, true, "missing credentials")
+	}
+	secret, err := svc.ds.VerifyEnrollSecret(ctx, req.Header.Security.Password)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			return nil, fleet.NewMicrosoftMDMFault(microsoft_mdm.ErrorTypeAuthentication, true, "invalid credentials")
+		}
+		return nil, ctxerr.Wrap(ctx, err, "verify enroll secret")
+	}
+	return secret, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// MS-MDM management sessions
+////////////////////////////////////////////////////////////////////////////////
+
+type mdmMicrosoftManagementRequest struct {
+	Message *fleet.SyncMLMessage
+	// Certs are the client certificates of the device, presented in the TLS
+	// handshake or forwarded by a trusted proxy. The first one is its
+	// identity certificate.
+	Certs []*x509.Certificate
+}
+
+func (mdmMicrosoftManagementRequest) DecodeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var msg fleet.SyncMLMessage
+	if err := decodeMicrosoftMDMMessage(r, &msg); err != nil {
+		return nil, err
+	}
+	return &mdmMicrosoftManagementRequest{Message: &msg, Certs: clientcert.FromContext(r.Context())}, nil
+}
+
+type mdmMicrosoftManagementResponse struct {
+	Err     error `json:"error,omitempty"`
+	Message *fleet.SyncMLMessage
+}
+
+func (r mdmMicrosoftManagementResponse) error() error { return r.Err }
+
+func (r mdmMicrosoftManagementResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
+	b, err := xml.Marshal(r.Message)
+	if err != nil {
+		logging.WithErr(ctx, ctxerr.Wrap(ctx, err, "marshal syncml response"))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", microsoft_mdm.SyncMLContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	if n, err := w.Write(b); err != nil {
+		logging.WithExtras(ctx, "err", err, "written", n)
+	}
+}
+
+func mdmMicrosoftManagementEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*mdmMicrosoftManagementRequest)
+	resp, err := svc.GetMDMMicrosoftManagementResponse(ctx, req.Message, req.Certs)
+	if err != nil {
+		return mdmMicrosoftManagementResponse{Err: err}, nil
+	}
+	return mdmMicrosoftManagementResponse{Message: resp}, nil
+}
+
+func (svc *Service) GetMDMMicrosoftManagementResponse(ctx context.Context, msg *fleet.SyncMLMessage, certs []*x509.Certificate) (*fleet.SyncMLMessage, error) {
+	// skipauth: The Windows MDM management endpoint is not authenticated with
+	// a user, the device authenticates with its identity certificate.
+	svc.authz.SkipAuthorization(ctx)
+
+	// the device presents the identity certificate issued when it enrolled as
+	// TLS client certificate, it must be the one of the source device.
+	if len(certs) == 0 {
+		return nil, fleet.NewAuthFailedError("missing client certificate")
+	}
+	certManager, err := svc.mdmMicrosoftCertManager(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := certManager.VerifyClientCert(certs[0], svc.clock.Now()); err != nil {
+		return nil, fleet.NewAuthFailedError(err.Error())
+	}
+
+	deviceID := msg.Header.Source
+	if deviceID == "" {
+		return nil, fleet.NewInvalidArgumentError("SyncHdr", "missing source device ID")
+	}
+	device, err := svc.ds.MDMWindowsGetEnrolledDevice(ctx, deviceID)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			return nil, fleet.NewAuthFailedError("device is not enrolled in Windows MDM")
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get enrolled device")
+	}
+	if device.MDMIdentityCertSerial == "" || certs[0].SerialNumber.Text(16) != device.MDMIdentityCertSerial {
+		return nil, fleet.NewAuthFailedError("client certificate is not the identity certificate of the device")
+	}
+
+	if microsoft_mdm.IsUnenrollmentRequest(msg) {
+		if err := svc.ds.MDMWindowsDeleteEnrolledDevice(ctx, deviceID); err != nil && !fleet.IsNotFound(err) {
+			return nil, ctxerr.Wrap(ctx, err, "delete enrolled device")
+		}
+		if err := svc.ds.NewActivity(ctx, nil, &fleet.ActivityTypeMDMUnenrolled{
+			HostDisplayName: device.MDMDeviceName,
+			MDMPlatform:     fleet.MDMPlatformMicrosoft,
+		}); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "create mdm unenrolled activity")
+		}
+	}
+
+	managementURL, err := svc.mdmMicrosoftManagementURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return microsoft_mdm.NewSyncMLResponse(msg, managementURL), nil
+}
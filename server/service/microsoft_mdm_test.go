@@ -0,0 +1,352 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/config"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	microsoft_mdm "github.com/fleetdm/fleet/v4/server/mdm/microsoft"
+	"github.com/fleetdm/fleet/v4/server/mock"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func setupMicrosoftMDMService(t *testing.T) (fleet.Service, context.Context, *mock.Store, *microsoft_mdm.CertManager) {
+	return setupMicrosoftMDMServiceWithConfig(t, nil)
+}
+
+// setupMicrosoftMDMServiceWithConfig is like setupMicrosoftMDMService, with
+// the configuration modified by setConfig if it is not nil.
+func setupMicrosoftMDMServiceWithConfig(t *testing.T, setConfig func(cfg *config.FleetConfig)) (fleet.Service, context.Context, *mock.Store, *microsoft_mdm.CertManager) {
+	ds := new(mock.Store)
+
+	caCert, caKey, err := microsoft_mdm.NewWSTEPCACertKey()
+	require.NoError(t, err)
+	certManager, err := microsoft_mdm.NewCertManager(&tls.Certificate{
+		Certificate: [][]byte{caCert.Raw},
+		PrivateKey:  caKey,
+		Leaf:        caCert,
+	})
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(caKey)})
+
+	cfg := config.TestConfig()
+	config.SetTestWindowsMDMConfig(t, &cfg, certPEM, keyPEM)
+	if setConfig != nil {
+		setConfig(&cfg)
+	}
+	svc, ctx := newTestServiceWithConfig(t, ds, cfg, nil, nil)
+
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{
+			ServerSettings: fleet.ServerSettings{ServerURL: "https://example.com"},
+			MDM:            fleet.MDM{WindowsEnabledAndConfigured: true},
+		}, nil
+	}
+	ds.VerifyEnrollSecretFunc = func(ctx context.Context, secret string) (*fleet.EnrollSecret, error) {
+		if secret == "team-secret" {
+			return &fleet.EnrollSecret{Secret: secret, TeamID: ptr.Uint(1)}, nil
+		}
+		return nil, &notFoundError{}
+	}
+	return svc, ctx, ds, certManager
+}
+
+func newTestCSR(t *testing.T) *x509.CertificateRequest {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "device"},
+	}, key)
+	require.NoError(t, err)
+	csr, err := x509.ParseCertificateRequest(der)
+	require.NoError(t, err)
+	return csr
+}
+
+func newTestMicrosoftMDMEnrollRequest(t *testing.T, password string) *fleet.SoapRequest {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "device"},
+	}, key)
+	require.NoError(t, err)
+
+	return &fleet.SoapRequest{
+		Header: fleet.SoapRequestHeader{
+			Action:    microsoft_mdm.ActionRequestSecurityToken,
+			MessageID: "urn:uuid:1",
+			Security:  &fleet.WSSecurity{Username: "user@example.com", Password: password},
+		},
+		Body: fleet.SoapRequestBody{
+			RequestSecurityToken: &fleet.RequestSecurityToken{
+				TokenType: microsoft_mdm.TokenTypeDeviceEnrollment,
+				BinarySecurityToken: fleet.BinarySecurityToken{
+					ValueType:    microsoft_mdm.ValueTypePKCS10,
+					EncodingType: microsoft_mdm.EncodingTypeBase64,
+					Value:        base64.StdEncoding.EncodeToString(csr),
+				},
+				ContextItems: []fleet.ContextItem{
+					{Name: microsoft_mdm.ContextItemDeviceID, Value: "DEVICE-1"},
+					{Name: microsoft_mdm.ContextItemHWDevID, Value: "HW-1"},
+					{Name: microsoft_mdm.ContextItemEnrollmentType, Value: microsoft_mdm.EnrollmentTypeFull},
+					{Name: microsoft_mdm.ContextItemDeviceType, Value: "CIMClient_Windows"},
+					{Name: microsoft_mdm.ContextItemDeviceName, Value: "DESKTOP-1"},
+					{Name: microsoft_mdm.ContextItemApplicationVersion, Value: "10.0.19045.2965"},
+					{Name: microsoft_mdm.ContextItemNotInOobe, Value: "true"},
+				},
+			},
+		},
+	}
+}
+
+func TestMicrosoftMDMDiscoveryAndPolicy(t *testing.T) {
+	svc, ctx, _, _ := setupMicrosoftMDMService(t)
+
+	// not a discovery request
+	_, err := svc.GetMDMMicrosoftDiscoveryResponse(ctx, &fleet.SoapRequest{})
+	var fault *fleet.MicrosoftMDMFault
+	require.ErrorAs(t, err, &fault)
+	require.Equal(t, microsoft_mdm.ErrorTypeMessageFormat, fault.Type)
+
+	resp, err := svc.GetMDMMicrosoftDiscoveryResponse(ctx, &fleet.SoapRequest{
+		Header: fleet.SoapRequestHeader{Action: microsoft_mdm.ActionDiscover, MessageID: "urn:uuid:1"},
+		Body:   fleet.SoapRequestBody{Discover: &fleet.Discover{RequestVersion: "5.0"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "urn:uuid:1", resp.Header.RelatesTo)
+	require.Equal(t, "4.0", resp.Body.DiscoverResponse.EnrollmentVersion)
+	require.Equal(t, "https://example.com/api/mdm/microsoft/policy", resp.Body.DiscoverResponse.EnrollmentPolicyServiceURL)
+	require.Equal(t, "https://example.com/api/mdm/microsoft/enroll", resp.Body.DiscoverResponse.EnrollmentServiceURL)
+
+	policyReq := func(password string) *fleet.SoapRequest {
+		return &fleet.SoapRequest{
+			Header: fleet.SoapRequestHeader{
+				Action:    microsoft_mdm.ActionGetPolicies,
+				MessageID: "urn:uuid:2",
+				Security:  &fleet.WSSecurity{Username: "user@example.com", Password: password},
+			},
+			Body: fleet.SoapRequestBody{GetPolicies: &fleet.GetPolicies{}},
+		}
+	}
+
+	_, err = svc.GetMDMMicrosoftPolicyResponse(ctx, policyReq("invalid"))
+	require.ErrorAs(t, err, &fault)
+	require.Equal(t, microsoft_mdm.ErrorTypeAuthentication, fault.Type)
+	require.Equal(t, http.StatusBadRequest, fault.StatusCode())
+
+	resp, err = svc.GetMDMMicrosoftPolicyResponse(ctx, policyReq("team-secret"))
+	require.NoError(t, err)
+	require.Equal(t, "urn:uuid:2", resp.Header.RelatesTo)
+	require.NotNil(t, resp.Body.GetPoliciesResponse)
+}
+
+func TestMicrosoftMDMEnroll(t *testing.T) {
+	svc, ctx, ds, _ := setupMicrosoftMDMService(t)
+
+	var stored *fleet.MDMWindowsEnrolledDevice
+	ds.MDMWindowsInsertEnrolledDeviceFunc = func(ctx context.Context, device *fleet.MDMWindowsEnrolledDevice) error {
+		stored = device
+		return nil
+	}
+	var activities []fleet.ActivityDetails
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		activities = append(activities, activity)
+		return nil
+	}
+
+	// invalid enroll secret
+	_, err := svc.GetMDMMicrosoftEnrollResponse(ctx, newTestMicrosoftMDMEnrollRequest(t, "invalid"))
+	var fault *fleet.MicrosoftMDMFault
+	require.ErrorAs(t, err, &fault)
+	require.Equal(t, microsoft_mdm.ErrorTypeAuthentication, fault.Type)
+	require.False(t, ds.MDMWindowsInsertEnrolledDeviceFuncInvoked)
+
+	// invalid certificate request
+	req := newTestMicrosoftMDMEnrollRequest(t, "team-secret")
+	req.Body.RequestSecurityToken.BinarySecurityToken.Value = base64.StdEncoding.EncodeToString([]byte("not a csr"))
+	_, err = svc.GetMDMMicrosoftEnrollResponse(ctx, req)
+	require.ErrorAs(t, err, &fault)
+	require.Equal(t, microsoft_mdm.ErrorTypeCertificateRequest, fault.Type)
+	require.False(t, ds.MDMWindowsInsertEnrolledDeviceFuncInvoked)
+
+	// missing device ID
+	req = newTestMicrosoftMDMEnrollRequest(t, "team-secret")
+	req.Body.RequestSecurityToken.ContextItems = req.Body.RequestSecurityToken.ContextItems[1:]
+	_, err = svc.GetMDMMicrosoftEnrollResponse(ctx, req)
+	require.ErrorAs(t, err, &fault)
+	require.Equal(t, microsoft_mdm.ErrorTypeMessageFormat, fault.Type)
+
+	resp, err := svc.GetMDMMicrosoftEnrollResponse(ctx, newTestMicrosoftMDMEnrollRequest(t, "team-secret"))
+	require.NoError(t, err)
+	require.Equal(t, "urn:uuid:1", resp.Header.RelatesTo)
+	token := resp.Body.RequestSecurityTokenResponseCollection.RequestSecurityTokenResponse.RequestedSecurityToken
+	require.Equal(t, microsoft_mdm.ValueTypeProvisioningDoc, token.ValueType)
+	doc, err := base64.StdEncoding.DecodeString(token.Value)
+	require.NoError(t, err)
+	require.Contains(t, string(doc), "https://example.com/api/mdm/microsoft/management")
+	require.NotContains(t, string(doc), "dummy")
+
+	require.True(t, ds.MDMWindowsInsertEnrolledDeviceFuncInvoked)
+	require.Equal(t, "DEVICE-1", stored.MDMDeviceID)
+	require.Equal(t, "HW-1", stored.MDMHardwareID)
+	require.Equal(t, fleet.MDMDeviceStateEnrolled, stored.MDMDeviceState)
+	require.Equal(t, "DESKTOP-1", stored.MDMDeviceName)
+	require.Equal(t, "user@example.com", stored.MDMEnrollUserID)
+	require.True(t, stored.MDMNotInOOBE)
+	require.NotEmpty(t, stored.MDMIdentityCertSerial)
+	require.Equal(t, ptr.Uint(1), stored.TeamID)
+	// the device is linked to its host when the host reports it
+	require.Empty(t, stored.HostUUID)
+
+	require.Equal(t, []fleet.ActivityDetails{&fleet.ActivityTypeMDMEnrolled{
+		HostDisplayName: "DESKTOP-1",
+		MDMPlatform:     fleet.MDMPlatformMicrosoft,
+	}}, activities)
+}
+
+func TestMicrosoftMDMEnrollManagementURL(t *testing.T) {
+	svc, ctx, ds, _ := setupMicrosoftMDMServiceWithConfig(t, func(cfg *config.FleetConfig) {
+		cfg.MDM.WindowsManagementURL = "https://mdm.example.com"
+	})
+	ds.MDMWindowsInsertEnrolledDeviceFunc = func(ctx context.Context, device *fleet.MDMWindowsEnrolledDevice) error {
+		return nil
+	}
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		return nil
+	}
+
+	// the devices use the Windows management URL for their management
+	// sessions
+	resp, err := svc.GetMDMMicrosoftEnrollResponse(ctx, newTestMicrosoftMDMEnrollRequest(t, "team-secret"))
+	require.NoError(t, err)
+	token := resp.Body.RequestSecurityTokenResponseCollection.RequestSecurityTokenResponse.RequestedSecurityToken
+	doc, err := base64.StdEncoding.DecodeString(token.Value)
+	require.NoError(t, err)
+	require.Contains(t, string(doc), "https://mdm.example.com/api/mdm/microsoft/management")
+	require.NotContains(t, string(doc), "https://example.com/api/mdm/microsoft/management")
+}
+
+func TestMicrosoftMDMManagement(t *testing.T) {
+	svc, ctx, ds, certManager := setupMicrosoftMDMService(t)
+
+	now := time.Now()
+	cert1, err := certManager.SignClientCSR(newTestCSR(t), "DEVICE-1", now)
+	require.NoError(t, err)
+	cert2, err := certManager.SignClientCSR(newTestCSR(t), "DEVICE-2", now)
+	require.NoError(t, err)
+
+	// a certificate with the same serial number, issued by another CA
+	otherCACert, otherCAKey, err := microsoft_mdm.NewWSTEPCACertKey()
+	require.NoError(t, err)
+	otherCertManager, err := microsoft_mdm.NewCertManager(&tls.Certificate{
+		Certificate: [][]byte{otherCACert.Raw},
+		PrivateKey:  otherCAKey,
+		Leaf:        otherCACert,
+	})
+	require.NoError(t, err)
+	otherCert, err := otherCertManager.SignClientCSR(newTestCSR(t), "DEVICE-1", now)
+	require.NoError(t, err)
+	otherCert.SerialNumber = cert1.SerialNumber
+
+	devices := map[string]*fleet.MDMWindowsEnrolledDevice{
+		"DEVICE-1": {MDMDeviceID: "DEVICE-1", MDMDeviceName: "DESKTOP-1", MDMIdentityCertSerial: cert1.SerialNumber.Text(16)},
+		"DEVICE-2": {MDMDeviceID: "DEVICE-2", MDMDeviceName: "DESKTOP-2", MDMIdentityCertSerial: cert2.SerialNumber.Text(16)},
+	}
+	ds.MDMWindowsGetEnrolledDeviceFunc = func(ctx context.Context, mdmDeviceID string) (*fleet.MDMWindowsEnrolledDevice, error) {
+		device, ok := devices[mdmDeviceID]
+		if !ok {
+			return nil, &notFoundError{}
+		}
+		return device, nil
+	}
+	ds.MDMWindowsDeleteEnrolledDeviceFunc = func(ctx context.Context, mdmDeviceID string) error {
+		return nil
+	}
+	var activities []fleet.ActivityDetails
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		activities = append(activities, activity)
+		return nil
+	}
+
+	msg := func(source string, alerts ...fleet.SyncMLCmd) *fleet.SyncMLMessage {
+		return &fleet.SyncMLMessage{
+			Header: fleet.SyncMLHeader{SessionID: "1", MsgID: "1", Source: source},
+			Body:   fleet.SyncMLBody{Alert: alerts},
+		}
+	}
+	unenrollAlert := fleet.SyncMLCmd{
+		CmdID: "2",
+		Data:  microsoft_mdm.AlertCodeGeneric,
+		Items: []fleet.SyncMLItem{{MetaType: microsoft_mdm.AlertTypeUserUnenrollment, Data: "1"}},
+	}
+	requireAuthFailed := func(err error) {
+		t.Helper()
+		var authErr *fleet.AuthFailedError
+		require.True(t, errors.As(err, &authErr), err)
+	}
+
+	// the device is not enrolled
+	_, err = svc.GetMDMMicrosoftManagementResponse(ctx, msg("DEVICE-3"), []*x509.Certificate{cert1})
+	requireAuthFailed(err)
+
+	// the messages without a valid identity certificate of the device are
+	// rejected before being handled
+	for _, certs := range [][]*x509.Certificate{
+		nil,
+		{otherCert},
+		{cert2},
+	} {
+		_, err = svc.GetMDMMicrosoftManagementResponse(ctx, msg("DEVICE-1", unenrollAlert), certs)
+		requireAuthFailed(err)
+	}
+	require.False(t, ds.MDMWindowsDeleteEnrolledDeviceFuncInvoked)
+	require.Empty(t, activities)
+
+	resp, err := svc.GetMDMMicrosoftManagementResponse(ctx, msg("DEVICE-1", fleet.SyncMLCmd{CmdID: "2", Data: "1201"}), []*x509.Certificate{cert1})
+	require.NoError(t, err)
+	require.Equal(t, "DEVICE-1", resp.Header.Target)
+	require.Equal(t, "https://example.com/api/mdm/microsoft/management", resp.Header.Source)
+	require.Len(t, resp.Body.Status, 2)
+	require.False(t, ds.MDMWindowsDeleteEnrolledDeviceFuncInvoked)
+	require.Empty(t, activities)
+
+	// the user unenrolls the device
+	_, err = svc.GetMDMMicrosoftManagementResponse(ctx, msg("DEVICE-1", unenrollAlert), []*x509.Certificate{cert1})
+	require.NoError(t, err)
+	require.True(t, ds.MDMWindowsDeleteEnrolledDeviceFuncInvoked)
+	require.Equal(t, []fleet.ActivityDetails{&fleet.ActivityTypeMDMUnenrolled{
+		HostDisplayName: "DESKTOP-1",
+		MDMPlatform:     fleet.MDMPlatformMicrosoft,
+	}}, activities)
+}
+
+func TestMicrosoftMDMSoapFaultResponse(t *testing.T) {
+	ctx := context.Background()
+	req := &fleet.SoapRequest{Header: fleet.SoapRequestHeader{MessageID: "urn:uuid:1"}}
+
+	resp := newMDMMicrosoftSoapResponse(ctx, req, nil,
+		fleet.NewMicrosoftMDMFault(microsoft_mdm.ErrorTypeAuthentication, true, "invalid credentials"))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, "s:Sender", resp.Message.Body.Fault.Code)
+	require.Equal(t, "invalid credentials", resp.Message.Body.Fault.Reason.Value)
+
+	// other errors are not leaked to the device
+	resp = newMDMMicrosoftSoapResponse(ctx, req, nil, errors.New("database is down"))
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	require.Equal(t, "s:Receiver", resp.Message.Body.Fault.Code)
+	require.Equal(t, "internal server error", resp.Message.Body.Fault.Reason.Value)
+	require.NotEmpty(t, resp.Message.Body.Fault.Detail.Error.TraceID)
+}
@@ -10,17 +10,26 @@ import (
 )
 
 type Middleware struct {
-	svc fleet.Service
+	svc     fleet.Service
+	windows bool
 }
 
 func NewAppleMiddleware(svc fleet.Service) *Middleware {
 	return &Middleware{svc: svc}
 }
 
+func NewWindowsMiddleware(svc fleet.Service) *Middleware {
+	return &Middleware{svc: svc, windows: true}
+}
+
 func (m *Middleware) Verify() endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, req interface{}) (interface{}, error) {
-			if err := m.svc.VerifyMDMAppleConfigured(ctx); err != nil {
+			verify := m.svc.VerifyMDMAppleConfigured
+			if m.windows {
+				verify = m.svc.VerifyMDMWindowsConfigured
+			}
+			if err := verify(ctx); err != nil {
 				return nil, err
 			}
 
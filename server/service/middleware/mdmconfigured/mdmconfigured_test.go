@@ -14,7 +14,8 @@ type mockService struct {
 	mock.Mock
 	fleet.Service
 
-	mdmConfigured atomic.Bool
+	mdmConfigured        atomic.Bool
+	windowsMDMConfigured atomic.Bool
 }
 
 func (m *mockService) VerifyMDMAppleConfigured(ctx context.Context) error {
@@ -24,6 +25,13 @@ func (m *mockService) VerifyMDMAppleConfigured(ctx context.Context) error {
 	return nil
 }
 
+func (m *mockService) VerifyMDMWindowsConfigured(ctx context.Context) error {
+	if !m.windowsMDMConfigured.Load() {
+		return fleet.ErrWindowsMDMNotConfigured
+	}
+	return nil
+}
+
 func TestMDMConfigured(t *testing.T) {
 	svc := mockService{}
 	svc.mdmConfigured.Store(true)
@@ -57,3 +65,39 @@ func TestMDMNotConfigured(t *testing.T) {
 	require.ErrorIs(t, err, fleet.ErrMDMNotConfigured)
 	require.False(t, nextCalled)
 }
+
+func TestWindowsMDMConfigured(t *testing.T) {
+	svc := mockService{}
+	svc.mdmConfigured.Store(false)
+	svc.windowsMDMConfigured.Store(true)
+	mw := NewWindowsMiddleware(&svc)
+
+	nextCalled := false
+	next := func(ctx context.Context, req interface{}) (interface{}, error) {
+		nextCalled = true
+		return struct{}{}, nil
+	}
+
+	f := mw.Verify()(next)
+	_, err := f(context.Background(), struct{}{})
+	require.NoError(t, err)
+	require.True(t, nextCalled)
+}
+
+func TestWindowsMDMNotConfigured(t *testing.T) {
+	svc := mockService{}
+	svc.mdmConfigured.Store(true)
+	svc.windowsMDMConfigured.Store(false)
+	mw := NewWindowsMiddleware(&svc)
+
+	nextCalled := false
+	next := func(ctx context.Context, req interface{}) (interface{}, error) {
+		nextCalled = true
+		return struct{}{}, nil
+	}
+
+	f := mw.Verify()(next)
+	_, err := f(context.Background(), struct{}{})
+	require.ErrorIs(t, err, fleet.ErrWindowsMDMNotConfigured)
+	require.False(t, nextCalled)
+}
@@ -328,3 +328,49 @@ func (svc *Service) SetOrUpdateDeviceAuthToken(ctx context.Context, deviceAuthTo
 
 	return nil
 }
+
+/////////////////////////////////////////////////////////////////////////////////
+// SetOrbitMDMEnrollmentStatus endpoint
+/////////////////////////////////////////////////////////////////////////////////
+
+type setOrbitMDMEnrollmentStatusRequest struct {
+	OrbitNodeKey string `json:"orbit_node_key"`
+	fleet.OrbitMDMEnrollmentStatus
+}
+
+func (r *setOrbitMDMEnrollmentStatusRequest) setOrbitNodeKey(nodeKey string) {
+	r.OrbitNodeKey = nodeKey
+}
+
+func (r *setOrbitMDMEnrollmentStatusRequest) orbitHostNodeKey() string {
+	return r.OrbitNodeKey
+}
+
+type setOrbitMDMEnrollmentStatusResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r setOrbitMDMEnrollmentStatusResponse) error() error { return r.Err }
+
+func setOrbitMDMEnrollmentStatusEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*setOrbitMDMEnrollmentStatusRequest)
+	if err := svc.SetOrbitMDMEnrollmentStatus(ctx, req.OrbitMDMEnrollmentStatus); err != nil {
+		return setOrbitMDMEnrollmentStatusResponse{Err: err}, nil
+	}
+	return setOrbitMDMEnrollmentStatusResponse{}, nil
+}
+
+func (svc *Service) SetOrbitMDMEnrollmentStatus(ctx context.Context, status fleet.OrbitMDMEnrollmentStatus) error {
+	// this is not a user-authenticated endpoint
+	svc.authz.SkipAuthorization(ctx)
+
+	host, ok := hostctx.FromContext(ctx)
+	if !ok {
+		return newOsqueryError("internal error: missing host from request context")
+	}
+
+	if err := svc.ds.SetOrUpdateHostOrbitMDMStatus(ctx, host.ID, &status); err != nil {
+		return newOsqueryError(fmt.Sprintf("internal error: failed to set mdm enrollment status: %e", err))
+	}
+	return nil
+}
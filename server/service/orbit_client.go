@@ -131,6 +131,20 @@ func (oc *OrbitClient) SetOrUpdateDeviceToken(deviceAuthToken string) error {
 	return nil
 }
 
+// SetMDMEnrollmentStatus sends a request to the server to store the MDM
+// enrollment status read locally on the device.
+func (oc *OrbitClient) SetMDMEnrollmentStatus(status *fleet.OrbitMDMEnrollmentStatus) error {
+	verb, path := "POST", "/api/fleet/orbit/mdm_enrollment_status"
+	params := setOrbitMDMEnrollmentStatusRequest{
+		OrbitMDMEnrollmentStatus: *status,
+	}
+	var resp setOrbitMDMEnrollmentStatusResponse
+	if err := oc.authenticatedRequest(verb, path, &params, &resp); err != nil {
+		return err
+	}
+	return nil
+}
+
 // Ping sends a ping request to the orbit/ping endpoint.
 func (oc *OrbitClient) Ping() error {
 	verb, path := "HEAD", "/api/fleet/orbit/ping"
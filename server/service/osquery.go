@@ -17,6 +17,7 @@ import (
 	"github.com/fleetdm/fleet/v4/server/contexts/license"
 	"github.com/fleetdm/fleet/v4/server/contexts/logging"
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
 	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/pubsub"
 	"github.com/fleetdm/fleet/v4/server/service/osquery_utils"
@@ -873,8 +874,11 @@ func (svc *Service) SubmitDistributedQueryResults(
 	}
 
 	if len(policyResults) > 0 {
+		if err := svc.applyPolicyMDMConditions(ctx, host, policyResults); err != nil {
+			logging.WithErr(ctx, err)
+		}
 
-		// filter policy results for webhooks
+		// filter policy results for webhooks and MDM actions
 		var policyIDs []uint
 		if ac.WebhookSettings.FailingPoliciesWebhook.Enable {
 			policyIDs = append(policyIDs, ac.WebhookSettings.FailingPoliciesWebhook.PolicyIDs...)
@@ -891,6 +895,17 @@ func (svc *Service) SubmitDistributedQueryResults(
 			}
 		}
 
+		// policies with an MDM action are tracked regardless of the automations,
+		// for the macOS hosts on which the action can run.
+		if ac.MDM.EnabledAndConfigured && host.Platform == "darwin" {
+			mdmPolicyIDs, err := svc.ds.ListMDMApplePolicyActionPolicyIDs(ctx)
+			if err != nil {
+				logging.WithErr(ctx, err)
+			} else {
+				policyIDs = append(policyIDs, mdmPolicyIDs...)
+			}
+		}
+
 		filteredResults := filterPolicyResults(policyResults, policyIDs)
 		if len(filteredResults) > 0 {
 			if failingPolicies, passingPolicies, err := svc.ds.FlippingPoliciesForHost(ctx, host.ID, filteredResults); err != nil {
@@ -1164,6 +1179,63 @@ func (svc *Service) ingestDetailQuery(ctx context.Context, host *fleet.Host, nam
 }
 
 // filterPolicyResults filters out policies that aren't configured for webhook automation.
+// applyPolicyMDMConditions fails the passing policy results of the host that
+// don't meet the MDM conditions of their policy. The conditions are evaluated
+// with the MDM data Fleet has about the host, so that a policy can check it
+// without a query against the server's tables.
+func (svc *Service) applyPolicyMDMConditions(ctx context.Context, host *fleet.Host, results map[uint]*bool) error {
+	var passingIDs []uint
+	for policyID, passes := range results {
+		if passes != nil && *passes {
+			passingIDs = append(passingIDs, policyID)
+		}
+	}
+	if len(passingIDs) == 0 {
+		return nil
+	}
+
+	conds, err := svc.ds.PolicyMDMConditions(ctx, passingIDs)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get policy mdm conditions")
+	}
+	if len(conds) == 0 {
+		return nil
+	}
+
+	// if the MDM state of the host can't be loaded, the policies with MDM
+	// conditions are recorded as not executed rather than passing.
+	state, err := svc.hostPolicyMDMState(ctx, host)
+	if err != nil {
+		for policyID := range conds {
+			results[policyID] = nil
+		}
+		return err
+	}
+	for policyID, c := range conds {
+		if !state.Meets(c, mobileconfig.FleetFileVaultPayloadIdentifier) {
+			results[policyID] = ptr.Bool(false)
+		}
+	}
+	return nil
+}
+
+func (svc *Service) hostPolicyMDMState(ctx context.Context, host *fleet.Host) (*fleet.HostPolicyMDMState, error) {
+	profs, err := svc.ds.GetHostMDMProfiles(ctx, host.UUID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get host mdm profiles")
+	}
+	state := &fleet.HostPolicyMDMState{Profiles: profs}
+
+	key, err := svc.ds.GetHostDiskEncryptionKey(ctx, host.ID)
+	if err != nil && !fleet.IsNotFound(err) {
+		return nil, ctxerr.Wrap(ctx, err, "get host disk encryption key")
+	}
+	if key != nil {
+		state.DiskEncryptionKeyDecryptable = key.Decryptable
+	}
+	return state, nil
+}
+
 func filterPolicyResults(incoming map[uint]*bool, webhookPolicies []uint) map[uint]*bool {
 	wp := make(map[uint]struct{})
 	for _, policyID := range webhookPolicies {
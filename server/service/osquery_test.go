@@ -25,6 +25,7 @@ import (
 	"github.com/fleetdm/fleet/v4/server/datastore/redis/redistest"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/live_query/live_query_mock"
+	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
 	"github.com/fleetdm/fleet/v4/server/mock"
 	mockresult "github.com/fleetdm/fleet/v4/server/mock/mockresult"
 	"github.com/fleetdm/fleet/v4/server/ptr"
@@ -2330,6 +2331,9 @@ func TestPolicyQueries(t *testing.T) {
 		return map[string]string{"1": "select 1", "2": "select 42;"}, nil
 	}
 	recordedResults := make(map[uint]*bool)
+	ds.PolicyMDMConditionsFunc = func(ctx context.Context, policyIDs []uint) (map[uint]fleet.PolicyMDMConditions, error) {
+		return nil, nil
+	}
 	ds.RecordPolicyQueryExecutionsFunc = func(ctx context.Context, gotHost *fleet.Host, results map[uint]*bool, updated time.Time, deferred bool) error {
 		recordedResults = results
 		host = gotHost
@@ -2483,6 +2487,91 @@ func TestPolicyQueries(t *testing.T) {
 	noPolicyResults(queries)
 }
 
+func TestPolicyMDMConditions(t *testing.T) {
+	ds := new(mock.Store)
+	svc := &Service{ds: ds}
+	ctx := context.Background()
+	host := &fleet.Host{ID: 1, UUID: "uuid-1", Platform: "darwin"}
+
+	conds := map[uint]fleet.PolicyMDMConditions{
+		2: {{Kind: fleet.PolicyMDMConditionDiskEncryption, Status: fleet.MDMAppleDeliveryVerifying}},
+		3: {{Kind: fleet.PolicyMDMConditionProfile, ProfileName: "Kiosk", Status: fleet.MDMAppleDeliveryVerifying}},
+	}
+	ds.PolicyMDMConditionsFunc = func(ctx context.Context, policyIDs []uint) (map[uint]fleet.PolicyMDMConditions, error) {
+		res := make(map[uint]fleet.PolicyMDMConditions)
+		for _, id := range policyIDs {
+			if c, ok := conds[id]; ok {
+				res[id] = c
+			}
+		}
+		return res, nil
+	}
+	var profiles []fleet.HostMDMAppleProfile
+	ds.GetHostMDMProfilesFunc = func(ctx context.Context, hostUUID string) ([]fleet.HostMDMAppleProfile, error) {
+		return profiles, nil
+	}
+	var key *fleet.HostDiskEncryptionKey
+	ds.GetHostDiskEncryptionKeyFunc = func(ctx context.Context, hostID uint) (*fleet.HostDiskEncryptionKey, error) {
+		if key == nil {
+			return nil, &notFoundError{}
+		}
+		return key, nil
+	}
+
+	apply := func() map[uint]*bool {
+		results := map[uint]*bool{1: ptr.Bool(true), 2: ptr.Bool(true), 3: ptr.Bool(true), 4: ptr.Bool(false)}
+		require.NoError(t, svc.applyPolicyMDMConditions(ctx, host, results))
+		return results
+	}
+
+	// no profiles nor disk encryption key, the policies with conditions fail
+	results := apply()
+	require.True(t, *results[1])
+	require.False(t, *results[2])
+	require.False(t, *results[3])
+	require.False(t, *results[4])
+
+	// the profiles are installed but the key is not escrowed yet
+	profiles = []fleet.HostMDMAppleProfile{
+		{Name: "Disk encryption", Identifier: mobileconfig.FleetFileVaultPayloadIdentifier, Status: &fleet.MDMAppleDeliveryVerifying, OperationType: fleet.MDMAppleOperationTypeInstall},
+		{Name: "Kiosk", Identifier: "com.example.kiosk", Status: &fleet.MDMAppleDeliveryVerifying, OperationType: fleet.MDMAppleOperationTypeInstall},
+	}
+	results = apply()
+	require.False(t, *results[2])
+	require.True(t, *results[3])
+
+	// the key is escrowed and decryptable
+	key = &fleet.HostDiskEncryptionKey{HostID: 1, Decryptable: ptr.Bool(true)}
+	results = apply()
+	require.True(t, *results[1])
+	require.True(t, *results[2])
+	require.True(t, *results[3])
+	require.False(t, *results[4])
+
+	// the profile is being removed
+	profiles[1].OperationType = fleet.MDMAppleOperationTypeRemove
+	results = apply()
+	require.True(t, *results[2])
+	require.False(t, *results[3])
+
+	// the conditions are only evaluated for the passing results
+	ds.GetHostMDMProfilesFuncInvoked = false
+	results = map[uint]*bool{2: ptr.Bool(false), 3: nil}
+	require.NoError(t, svc.applyPolicyMDMConditions(ctx, host, results))
+	require.False(t, ds.GetHostMDMProfilesFuncInvoked)
+	require.False(t, *results[2])
+	require.Nil(t, results[3])
+
+	// the policies with conditions are not executed if the state can't be loaded
+	ds.GetHostMDMProfilesFunc = func(ctx context.Context, hostUUID string) ([]fleet.HostMDMAppleProfile, error) {
+		return nil, errors.New("boom")
+	}
+	results = map[uint]*bool{1: ptr.Bool(true), 2: ptr.Bool(true)}
+	require.Error(t, svc.applyPolicyMDMConditions(ctx, host, results))
+	require.True(t, *results[1])
+	require.Nil(t, results[2])
+}
+
 func TestPolicyWebhooks(t *testing.T) {
 	mockClock := clock.NewMockClock()
 	ds := new(mock.Store)
@@ -2534,6 +2623,9 @@ func TestPolicyWebhooks(t *testing.T) {
 		}, nil
 	}
 	recordedResults := make(map[uint]*bool)
+	ds.PolicyMDMConditionsFunc = func(ctx context.Context, policyIDs []uint) (map[uint]fleet.PolicyMDMConditions, error) {
+		return nil, nil
+	}
 	ds.RecordPolicyQueryExecutionsFunc = func(ctx context.Context, gotHost *fleet.Host, results map[uint]*bool, updated time.Time, deferred bool) error {
 		recordedResults = results
 		host = gotHost
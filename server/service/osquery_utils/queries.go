@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
@@ -16,7 +17,9 @@ import (
 	"github.com/fleetdm/fleet/v4/server/contexts/publicip"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	apple_mdm "github.com/fleetdm/fleet/v4/server/mdm/apple"
+	microsoft_mdm "github.com/fleetdm/fleet/v4/server/mdm/microsoft"
 	"github.com/fleetdm/fleet/v4/server/service/async"
+	"github.com/fleetdm/fleet/v4/server/worker"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/spf13/cast"
@@ -454,6 +457,12 @@ var extraDetailQueries = map[string]DetailQuery{
 				WHERE path = 'HKEY_LOCAL_MACHINE\SOFTWARE\Microsoft\Windows NT\CurrentVersion\InstallationType'
 				LIMIT 1
 			)
+			UNION ALL
+			SELECT * FROM (
+				SELECT "mdm_device_id" AS "key", data as "value" FROM registry
+				WHERE path = 'HKEY_LOCAL_MACHINE\SOFTWARE\Microsoft\Provisioning\OMADM\MDMDeviceID\DeviceClientId'
+				LIMIT 1
+			)
 			;
 		`,
 		DirectIngestFunc: directIngestMDMWindows,
@@ -577,6 +586,24 @@ var mdmQueries = map[string]DetailQuery{
 		DirectIngestFunc: directIngestDiskEncryptionKeyDarwin,
 		Discovery:        discoveryTable("file_lines"),
 	},
+	"mdm_os_updates_darwin": {
+		// The number of recommended updates is recorded by softwareupdate when
+		// it checks for updates, it is used along with the OS version to
+		// report the compliance of the host with the macOS updates settings.
+		Query: `
+	SELECT
+		os.major,
+		os.minor,
+		os.patch,
+		COALESCE((
+			SELECT value FROM plist
+			WHERE path = '/Library/Preferences/com.apple.SoftwareUpdate.plist' AND key = 'LastRecommendedUpdatesAvailable'
+		), 0) AS pending_updates
+	FROM
+		os_version os`,
+		Platforms:        []string{"darwin"},
+		DirectIngestFunc: directIngestOSUpdatesDarwin,
+	},
 }
 
 // discoveryTable returns a query to determine whether a table exists or not.
@@ -1249,14 +1276,34 @@ func directIngestMDMWindows(ctx context.Context, logger log.Logger, host *fleet.
 	_, autoPilot := data["autopilot"]
 	isServer := strings.Contains(strings.ToLower(data["installation_type"]), "server")
 	_, enrolled := data["provider_id"]
-	return ds.SetOrUpdateMDMData(ctx,
+	if err := ds.SetOrUpdateMDMData(ctx,
 		host.ID,
 		isServer,
 		enrolled,
 		data["discovery_service_url"],
 		autoPilot,
 		deduceMDMNameWindows(data),
-	)
+	); err != nil {
+		return err
+	}
+
+	// a host enrolled in Fleet's Windows MDM is linked to its enrolled device
+	// with the MDM device ID it reports, as the host is identified by its
+	// osquery agent.
+	if enrolled && data["mdm_device_id"] != "" && isFleetMDMWindowsDiscoveryURL(data["discovery_service_url"]) {
+		if err := ds.MDMWindowsLinkEnrolledDeviceToHost(ctx, data["mdm_device_id"], host.UUID); err != nil {
+			return ctxerr.Wrap(ctx, err, "link windows mdm enrolled device to host")
+		}
+	}
+	return nil
+}
+
+func isFleetMDMWindowsDiscoveryURL(discoveryURL string) bool {
+	u, err := url.Parse(discoveryURL)
+	if err != nil {
+		return false
+	}
+	return u.Path == microsoft_mdm.MDE2DiscoveryPath
 }
 
 func directIngestMunkiInfo(ctx context.Context, logger log.Logger, host *fleet.Host, ds fleet.Datastore, rows []map[string]string) error {
@@ -1328,9 +1375,57 @@ func directIngestDiskEncryptionKeyDarwin(
 		return nil
 	}
 
+	// the key is reported on every run of the query, so check if it changed to
+	// only deliver the MDM event when a new key is escrowed.
+	key := rows[0]["filevault_key"]
+	var escrowed bool
+	if key != "" {
+		prev, err := ds.GetHostDiskEncryptionKey(ctx, host.ID)
+		if err != nil && !fleet.IsNotFound(err) {
+			return ctxerr.Wrap(ctx, err, "get previous disk encryption key")
+		}
+		escrowed = prev == nil || prev.Base64Encrypted != key
+	}
+
 	// it's okay if the key comes empty, this can happen and if the disk is
 	// encrypted it means we need to reset the encryption key
-	return ds.SetOrUpdateHostDiskEncryptionKey(ctx, host.ID, rows[0]["filevault_key"])
+	if err := ds.SetOrUpdateHostDiskEncryptionKey(ctx, host.ID, key); err != nil {
+		return err
+	}
+
+	if escrowed {
+		if err := worker.QueueMDMWebhookEvent(ctx, ds, logger, fleet.MDMWebhookEventKeyEscrowed, host.UUID, map[string]interface{}{
+			"key_type": "filevault",
+		}); err != nil {
+			level.Error(logger).Log(
+				"component", "service",
+				"method", "directIngestDiskEncryptionKeyDarwin",
+				"msg", "queue MDM webhook event",
+				"host", host.Hostname,
+				"err", err,
+			)
+		}
+	}
+	return nil
+}
+
+// directIngestOSUpdatesDarwin ingests the OS version and the number of pending
+// software updates of a macOS host.
+func directIngestOSUpdatesDarwin(ctx context.Context, logger log.Logger, host *fleet.Host, ds fleet.Datastore, rows []map[string]string) error {
+	if len(rows) != 1 {
+		return ctxerr.Errorf(ctx, "directIngestOSUpdatesDarwin invalid number of rows: %d", len(rows))
+	}
+
+	version := fmt.Sprintf("%s.%s.%s", EmptyToZero(rows[0]["major"]), EmptyToZero(rows[0]["minor"]), EmptyToZero(rows[0]["patch"]))
+	pending, err := strconv.ParseUint(EmptyToZero(rows[0]["pending_updates"]), 10, 32)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "directIngestOSUpdatesDarwin parsing pending updates")
+	}
+
+	if err := ds.SetOrUpdateHostOSUpdates(ctx, host.ID, version, uint(pending)); err != nil {
+		return ctxerr.Wrap(ctx, err, "directIngestOSUpdatesDarwin update host os updates")
+	}
+	return nil
 }
 
 //go:generate go run gen_queries_doc.go ../../../docs/Using-Fleet/Detail-Queries-Summary.md
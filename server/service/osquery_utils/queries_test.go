@@ -15,6 +15,7 @@ import (
 	"github.com/fleetdm/fleet/v4/server/mock"
 	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/service/async"
+	"github.com/fleetdm/fleet/v4/server/worker"
 	"github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -481,6 +482,33 @@ func TestDirectIngestMDMWindows(t *testing.T) {
 	})
 	require.NoError(t, err)
 	require.True(t, ds.SetOrUpdateMDMDataFuncInvoked)
+	require.False(t, ds.MDMWindowsLinkEnrolledDeviceToHostFuncInvoked)
+
+	// a host enrolled in Fleet's Windows MDM is linked to its enrolled device
+	ds.SetOrUpdateMDMDataFunc = func(ctx context.Context, hostID uint, isServer, enrolled bool, serverURL string, installedFromDep bool, name string) error {
+		return nil
+	}
+	ds.MDMWindowsLinkEnrolledDeviceToHostFunc = func(ctx context.Context, mdmDeviceID, hostUUID string) error {
+		require.Equal(t, "DEVICE-CLIENT-ID", mdmDeviceID)
+		require.Equal(t, "host-uuid", hostUUID)
+		return nil
+	}
+	host.UUID = "host-uuid"
+	rows := []map[string]string{
+		{"key": "discovery_service_url", "value": "https://fleet.example.com/api/mdm/microsoft/discovery"},
+		{"key": "provider_id", "value": "Fleet"},
+		{"key": "mdm_device_id", "value": "DEVICE-CLIENT-ID"},
+	}
+	err = directIngestMDMWindows(context.Background(), log.NewNopLogger(), &host, ds, rows)
+	require.NoError(t, err)
+	require.True(t, ds.MDMWindowsLinkEnrolledDeviceToHostFuncInvoked)
+
+	// not when the host is enrolled in another MDM
+	ds.MDMWindowsLinkEnrolledDeviceToHostFuncInvoked = false
+	rows[0]["value"] = "https://mdm.example.com/EnrollmentServer/Discovery.svc"
+	err = directIngestMDMWindows(context.Background(), log.NewNopLogger(), &host, ds, rows)
+	require.NoError(t, err)
+	require.False(t, ds.MDMWindowsLinkEnrolledDeviceToHostFuncInvoked)
 }
 
 func TestDirectIngestChromeProfiles(t *testing.T) {
@@ -530,6 +558,37 @@ func TestDirectIngestBattery(t *testing.T) {
 	require.True(t, ds.ReplaceHostBatteriesFuncInvoked)
 }
 
+func TestDirectIngestOSUpdatesDarwin(t *testing.T) {
+	ds := new(mock.Store)
+	var gotVersion string
+	var gotPending uint
+	ds.SetOrUpdateHostOSUpdatesFunc = func(ctx context.Context, hostID uint, osVersion string, pendingUpdates uint) error {
+		require.Equal(t, uint(1), hostID)
+		gotVersion, gotPending = osVersion, pendingUpdates
+		return nil
+	}
+
+	host := fleet.Host{ID: 1}
+
+	err := directIngestOSUpdatesDarwin(context.Background(), log.NewNopLogger(), &host, ds, []map[string]string{
+		{"major": "13", "minor": "3", "patch": "1", "pending_updates": "2"},
+	})
+	require.NoError(t, err)
+	require.True(t, ds.SetOrUpdateHostOSUpdatesFuncInvoked)
+	require.Equal(t, "13.3.1", gotVersion)
+	require.Equal(t, uint(2), gotPending)
+
+	err = directIngestOSUpdatesDarwin(context.Background(), log.NewNopLogger(), &host, ds, []map[string]string{
+		{"major": "13", "minor": "4", "patch": "", "pending_updates": ""},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "13.4.0", gotVersion)
+	require.Zero(t, gotPending)
+
+	err = directIngestOSUpdatesDarwin(context.Background(), log.NewNopLogger(), &host, ds, nil)
+	require.Error(t, err)
+}
+
 func TestDirectIngestOSWindows(t *testing.T) {
 	ds := new(mock.Store)
 
@@ -901,7 +960,32 @@ func TestDirectIngestDiskEncryptionKeyDarwin(t *testing.T) {
 		return nil
 	}
 
+	var prevKey *fleet.HostDiskEncryptionKey
+	ds.GetHostDiskEncryptionKeyFunc = func(ctx context.Context, hostID uint) (*fleet.HostDiskEncryptionKey, error) {
+		if prevKey == nil {
+			return nil, &mock.Error{Message: "not found"}
+		}
+		return prevKey, nil
+	}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{WebhookSettings: fleet.WebhookSettings{
+			MDMEventsWebhook: fleet.MDMEventsWebhookSettings{Enable: true, DestinationURL: "https://example.com"},
+		}}, nil
+	}
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		require.Equal(t, worker.MDMWebhookName, job.Name)
+		return job, nil
+	}
+
 	err = directIngestDiskEncryptionKeyDarwin(ctx, logger, host, ds, []map[string]string{{"filevault_key": wantKey}})
 	require.NoError(t, err)
 	require.True(t, ds.SetOrUpdateHostDiskEncryptionKeyFuncInvoked)
+	require.True(t, ds.NewJobFuncInvoked)
+
+	// the same key is reported again, no MDM event is delivered
+	ds.NewJobFuncInvoked = false
+	prevKey = &fleet.HostDiskEncryptionKey{HostID: host.ID, Base64Encrypted: wantKey}
+	err = directIngestDiskEncryptionKeyDarwin(ctx, logger, host, ds, []map[string]string{{"filevault_key": wantKey}})
+	require.NoError(t, err)
+	require.False(t, ds.NewJobFuncInvoked)
 }
@@ -75,8 +75,8 @@ type CronStatsStore interface {
 	GetLatestCronStats(ctx context.Context, name string) ([]fleet.CronStats, error)
 	// InsertCronStats inserts cron stats for the named cron schedule
 	InsertCronStats(ctx context.Context, statsType fleet.CronStatsType, name string, instance string, status fleet.CronStatsStatus) (int, error)
-	// UpdateCronStats updates the status of the identified cron stats record
-	UpdateCronStats(ctx context.Context, id int, status fleet.CronStatsStatus) error
+	// UpdateCronStats updates the status and the job errors of the identified cron stats record
+	UpdateCronStats(ctx context.Context, id int, status fleet.CronStatsStatus, cronErrors fleet.CronScheduleErrors) error
 }
 
 // Option allows configuring a Schedule.
@@ -404,9 +404,14 @@ func (s *Schedule) Name() string {
 	return s.name
 }
 
+// Interval returns the current interval of the schedule.
+func (s *Schedule) Interval() time.Duration {
+	return s.getSchedInterval()
+}
+
 // runWithStats runs all jobs in the schedule. Prior to starting the run, it creates a
 // record in the database for the provided stats type with "pending" status. After completing the
-// run, the stats record is updated to "completed" status.
+// run, the stats record is updated to "completed" status along with the errors of the failed jobs.
 func (s *Schedule) runWithStats(statsType fleet.CronStatsType) {
 	statsID, err := s.insertStats(statsType, fleet.CronStatsStatusPending)
 	if err != nil {
@@ -416,9 +421,9 @@ func (s *Schedule) runWithStats(statsType fleet.CronStatsType) {
 	}
 	level.Info(s.logger).Log("status", "pending")
 
-	s.runAllJobs()
+	cronErrors := s.runAllJobs()
 
-	if err := s.updateStats(statsID, fleet.CronStatsStatusCompleted); err != nil {
+	if err := s.updateStats(statsID, fleet.CronStatsStatusCompleted, cronErrors); err != nil {
 		level.Error(s.logger).Log("err", fmt.Sprintf("update cron stats %s", s.name), "details", err)
 		sentry.CaptureException(err)
 		ctxerr.Handle(s.ctx, err)
@@ -426,16 +431,23 @@ func (s *Schedule) runWithStats(statsType fleet.CronStatsType) {
 	level.Info(s.logger).Log("status", "completed")
 }
 
-// runAllJobs runs all jobs in the schedule.
-func (s *Schedule) runAllJobs() {
+// runAllJobs runs all jobs in the schedule and returns the errors of the jobs that failed, keyed
+// by job ID.
+func (s *Schedule) runAllJobs() fleet.CronScheduleErrors {
+	var cronErrors fleet.CronScheduleErrors
 	for _, job := range s.jobs {
 		level.Debug(s.logger).Log("msg", "starting", "jobID", job.ID)
 		if err := runJob(s.ctx, job.Fn); err != nil {
 			level.Error(s.logger).Log("err", "running job", "details", err, "jobID", job.ID)
 			sentry.CaptureException(err)
 			ctxerr.Handle(s.ctx, err)
+			if cronErrors == nil {
+				cronErrors = make(fleet.CronScheduleErrors)
+			}
+			cronErrors[job.ID] = err.Error()
 		}
 	}
+	return cronErrors
 }
 
 // runJob executes the job function with panic recovery.
@@ -585,8 +597,8 @@ func (s *Schedule) insertStats(statsType fleet.CronStatsType, status fleet.CronS
 	return s.statsStore.InsertCronStats(s.ctx, statsType, s.name, s.instanceID, status)
 }
 
-func (s *Schedule) updateStats(id int, status fleet.CronStatsStatus) error {
-	return s.statsStore.UpdateCronStats(s.ctx, id, status)
+func (s *Schedule) updateStats(id int, status fleet.CronStatsStatus, cronErrors fleet.CronScheduleErrors) error {
+	return s.statsStore.UpdateCronStats(s.ctx, id, status, cronErrors)
 }
 
 func (s *Schedule) getLockName() string {
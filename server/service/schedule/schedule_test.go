@@ -254,6 +254,34 @@ func TestMultipleJobsInOrder(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestRunWithStatsRecordsJobErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	statsStore := SetUpMockStatsStore("test_schedule")
+	s := New(ctx, "test_schedule", "test_instance", 1*time.Second, NopLocker{}, statsStore,
+		WithJob("test_job_1", func(ctx context.Context) error {
+			return nil
+		}),
+		WithJob("test_job_2", func(ctx context.Context) error {
+			return errors.New("test_job_2 failed")
+		}),
+		WithJob("test_job_3", func(ctx context.Context) error {
+			panic("test_job_3 panicked")
+		}),
+	)
+	require.Equal(t, 1*time.Second, s.Interval())
+
+	s.runWithStats(fleet.CronStatsTypeTriggered)
+	require.Len(t, statsStore.stats, 1)
+	stats := statsStore.stats[1]
+	require.Equal(t, fleet.CronStatsStatusCompleted, stats.Status)
+	require.Equal(t, fleet.CronScheduleErrors{
+		"test_job_2": "test_job_2 failed",
+		"test_job_3": "test_job_3 panicked",
+	}, stats.Errors)
+}
+
 func TestConfigReloadCheck(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	initialSchedInterval := 1 * time.Millisecond
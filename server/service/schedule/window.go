@@ -0,0 +1,189 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	// embed the IANA time zone database so that team time zones can be loaded
+	// even when the host running Fleet doesn't have it installed.
+	_ "time/tzdata"
+)
+
+// TimeOfDay is a wall clock time, with minute precision.
+type TimeOfDay struct {
+	Hour   int
+	Minute int
+}
+
+// ParseTimeOfDay parses a time of day in the "HH:MM" 24-hour format.
+func ParseTimeOfDay(s string) (TimeOfDay, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil || len(s) != len("15:04") {
+		return TimeOfDay{}, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return TimeOfDay{Hour: t.Hour(), Minute: t.Minute()}, nil
+}
+
+func (t TimeOfDay) String() string {
+	return fmt.Sprintf("%02d:%02d", t.Hour, t.Minute)
+}
+
+func (t TimeOfDay) minutes() int {
+	return t.Hour*60 + t.Minute
+}
+
+// LoadLocation returns the location for the provided IANA time zone name (e.g.
+// "America/New_York"). An empty name is UTC.
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	// time.LoadLocation accepts "Local", which depends on the host running
+	// Fleet and so is not a valid time zone for a team.
+	if name == "Local" {
+		return nil, fmt.Errorf("invalid time zone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q", name)
+	}
+	return loc, nil
+}
+
+// Window is a daily window of wall clock time in a given location, e.g. from
+// 22:00 to 06:00 in America/New_York. It is used to run jobs at a time that is
+// local to the hosts they target, e.g. outside of a team's working hours.
+//
+// The window's bounds are evaluated in the location's local time on each day,
+// so a window keeps the same wall clock bounds across daylight saving time
+// changes (and its duration changes on those days instead). If End is not
+// after Start, the window crosses midnight and ends on the next day. If Start
+// falls in a wall clock time skipped by a daylight saving time change, the
+// window starts at the corresponding time after the change.
+type Window struct {
+	Start TimeOfDay
+	End   TimeOfDay
+	// Location is the location in which Start and End are evaluated. A nil
+	// Location is UTC.
+	Location *time.Location
+	// Weekdays restricts the days on which the window starts. If empty, the
+	// window starts every day.
+	Weekdays []time.Weekday
+}
+
+// NewWindow returns the Window from start to end (in the "HH:MM" format) in
+// the IANA time zone tz, that starts on the provided weekdays (or every day
+// if none is provided).
+func NewWindow(start, end, tz string, weekdays ...time.Weekday) (*Window, error) {
+	s, err := ParseTimeOfDay(start)
+	if err != nil {
+		return nil, err
+	}
+	e, err := ParseTimeOfDay(end)
+	if err != nil {
+		return nil, err
+	}
+	if s == e {
+		return nil, errors.New("window start and end must be different")
+	}
+	loc, err := LoadLocation(tz)
+	if err != nil {
+		return nil, err
+	}
+	return &Window{Start: s, End: e, Location: loc, Weekdays: weekdays}, nil
+}
+
+func (w *Window) location() *time.Location {
+	if w.Location == nil {
+		return time.UTC
+	}
+	return w.Location
+}
+
+func (w *Window) startsOn(day time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, d := range w.Weekdays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// bounds returns the start and end of the window that starts on the day of t
+// (in the window's location).
+func (w *Window) bounds(t time.Time) (start, end time.Time) {
+	y, m, d := t.Date()
+	start = wallClock(y, m, d, w.Start, w.location())
+	if w.End.minutes() <= w.Start.minutes() {
+		d++
+	}
+	end = wallClock(y, m, d, w.End, w.location())
+	return start, end
+}
+
+// wallClock returns the time at the wall clock time tod on the given day in
+// loc. If tod is skipped on that day by a daylight saving time change, it
+// returns the corresponding time after the change (e.g. 02:30 becomes 03:30
+// when clocks move forward from 02:00 to 03:00).
+func wallClock(y int, m time.Month, d int, tod TimeOfDay, loc *time.Location) time.Time {
+	t := time.Date(y, m, d, tod.Hour, tod.Minute, 0, 0, loc)
+	if t.Hour() == tod.Hour && t.Minute() == tod.Minute {
+		return t
+	}
+	// time.Date doesn't define which offset is used for a skipped time,
+	// explicitly use the one in effect before the change.
+	_, offset := time.Date(y, m, d-1, 12, 0, 0, 0, loc).Zone()
+	return time.Date(y, m, d, tod.Hour, tod.Minute, 0, 0, time.FixedZone("", offset)).In(loc)
+}
+
+// Contains returns true if t is within the window, start inclusive and end
+// exclusive.
+func (w *Window) Contains(t time.Time) bool {
+	start, end := w.current(t)
+	return !start.IsZero() && !t.Before(start) && t.Before(end)
+}
+
+// current returns the bounds of the window that contains t, or zero times if
+// t is not within a window.
+func (w *Window) current(t time.Time) (start, end time.Time) {
+	local := t.In(w.location())
+	// the window containing t started either on the same day as t or, if it
+	// crosses midnight, on the day before.
+	for _, day := range []time.Time{local.AddDate(0, 0, -1), local} {
+		if !w.startsOn(day.Weekday()) {
+			continue
+		}
+		s, e := w.bounds(day)
+		if !t.Before(s) && t.Before(e) {
+			return s, e
+		}
+	}
+	return time.Time{}, time.Time{}
+}
+
+// Next returns the bounds of the window that contains t, or of the next window
+// that starts after t if t is not within a window. The returned times are in
+// the window's location.
+func (w *Window) Next(t time.Time) (start, end time.Time) {
+	if s, e := w.current(t); !s.IsZero() {
+		return s, e
+	}
+	local := t.In(w.location())
+	y, m, d := local.Date()
+	// a window starts within the next 8 days, as there's at least one weekday
+	// on which it starts.
+	for i := 0; i <= 8; i++ {
+		day := time.Date(y, m, d+i, 12, 0, 0, 0, w.location())
+		if !w.startsOn(day.Weekday()) {
+			continue
+		}
+		s, e := w.bounds(day)
+		if s.After(t) {
+			return s, e
+		}
+	}
+	return time.Time{}, time.Time{}
+}
@@ -0,0 +1,116 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWindow(t *testing.T) {
+	cases := []struct {
+		start, end, tz string
+		wantErr        string
+	}{
+		{"22:00", "06:00", "America/New_York", ""},
+		{"09:30", "17:00", "", ""},
+		{"9:30", "17:00", "UTC", "invalid time of day"},
+		{"09:30", "24:00", "UTC", "invalid time of day"},
+		{"09:30", "09:30", "UTC", "must be different"},
+		{"09:30", "17:00", "Mars/Olympus_Mons", "invalid time zone"},
+		{"09:30", "17:00", "Local", "invalid time zone"},
+	}
+	for _, c := range cases {
+		t.Run(c.start+"-"+c.end+" "+c.tz, func(t *testing.T) {
+			w, err := NewWindow(c.start, c.end, c.tz)
+			if c.wantErr != "" {
+				require.ErrorContains(t, err, c.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.start, w.Start.String())
+			require.Equal(t, c.end, w.End.String())
+		})
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	overnight, err := NewWindow("22:00", "06:00", "America/New_York")
+	require.NoError(t, err)
+	weekdays, err := NewWindow("09:00", "17:00", "America/New_York", time.Monday, time.Friday)
+	require.NoError(t, err)
+
+	cases := []struct {
+		w    *Window
+		t    time.Time
+		want bool
+	}{
+		{overnight, time.Date(2023, 6, 1, 22, 0, 0, 0, ny), true},
+		{overnight, time.Date(2023, 6, 1, 23, 59, 0, 0, ny), true},
+		{overnight, time.Date(2023, 6, 2, 5, 59, 0, 0, ny), true},
+		{overnight, time.Date(2023, 6, 2, 6, 0, 0, 0, ny), false},
+		{overnight, time.Date(2023, 6, 2, 12, 0, 0, 0, ny), false},
+		// 02:30 UTC is 22:30 in New York during daylight saving time
+		{overnight, time.Date(2023, 6, 2, 2, 30, 0, 0, time.UTC), true},
+		// and 21:30 in New York during standard time
+		{overnight, time.Date(2023, 12, 2, 2, 30, 0, 0, time.UTC), false},
+
+		// June 5th 2023 is a Monday
+		{weekdays, time.Date(2023, 6, 5, 9, 0, 0, 0, ny), true},
+		{weekdays, time.Date(2023, 6, 6, 9, 0, 0, 0, ny), false},
+		{weekdays, time.Date(2023, 6, 9, 16, 59, 0, 0, ny), true},
+		{weekdays, time.Date(2023, 6, 9, 17, 0, 0, 0, ny), false},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.want, c.w.Contains(c.t), c.t.String())
+	}
+}
+
+func TestWindowNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	overnight, err := NewWindow("22:00", "06:00", "America/New_York")
+	require.NoError(t, err)
+
+	// within a window, returns the current window
+	start, end := overnight.Next(time.Date(2023, 6, 2, 1, 0, 0, 0, ny))
+	require.Equal(t, time.Date(2023, 6, 1, 22, 0, 0, 0, ny), start)
+	require.Equal(t, time.Date(2023, 6, 2, 6, 0, 0, 0, ny), end)
+
+	// outside a window, returns the next one
+	start, end = overnight.Next(time.Date(2023, 6, 2, 12, 0, 0, 0, ny))
+	require.Equal(t, time.Date(2023, 6, 2, 22, 0, 0, 0, ny), start)
+	require.Equal(t, time.Date(2023, 6, 3, 6, 0, 0, 0, ny), end)
+
+	// the window spanning the end of daylight saving time (November 5th 2023)
+	// keeps its wall clock bounds and lasts one hour more
+	start, end = overnight.Next(time.Date(2023, 11, 4, 12, 0, 0, 0, ny))
+	require.Equal(t, time.Date(2023, 11, 4, 22, 0, 0, 0, ny), start)
+	require.Equal(t, time.Date(2023, 11, 5, 6, 0, 0, 0, ny), end)
+	require.Equal(t, 9*time.Hour, end.Sub(start))
+
+	// and the one spanning its start (March 12th 2023) lasts one hour less
+	start, end = overnight.Next(time.Date(2023, 3, 11, 12, 0, 0, 0, ny))
+	require.Equal(t, 7*time.Hour, end.Sub(start))
+
+	// a window starting in the skipped hour starts after the change
+	skipped, err := NewWindow("02:30", "04:00", "America/New_York")
+	require.NoError(t, err)
+	start, _ = skipped.Next(time.Date(2023, 3, 12, 0, 0, 0, 0, ny))
+	require.Equal(t, time.Date(2023, 3, 12, 3, 30, 0, 0, ny), start)
+	require.True(t, skipped.Contains(start))
+
+	// restricted to some weekdays, skips to the next allowed day
+	weekly, err := NewWindow("09:00", "17:00", "Europe/Paris", time.Monday)
+	require.NoError(t, err)
+	paris := weekly.Location
+	// June 6th 2023 is a Tuesday
+	start, end = weekly.Next(time.Date(2023, 6, 6, 10, 0, 0, 0, paris))
+	require.Equal(t, time.Date(2023, 6, 12, 9, 0, 0, 0, paris), start)
+	require.Equal(t, time.Date(2023, 6, 12, 17, 0, 0, 0, paris), end)
+	require.Equal(t, paris, start.Location())
+}
@@ -0,0 +1,1082 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/contexts/logging"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/go-kit/kit/log/level"
+	kithttp "github.com/go-kit/kit/transport/http"
+)
+
+// The schemas of the SCIM resources and messages, see RFC 7643 and RFC 7644.
+const (
+	scimSchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimSchemaGroup        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimSchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimSchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+const (
+	// scimDefaultCount is the number of resources returned by a list request
+	// that does not specify a count.
+	scimDefaultCount = 100
+	// scimMaxCount is the maximum number of resources returned by a list
+	// request.
+	scimMaxCount = 1000
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// SCIM resources
+////////////////////////////////////////////////////////////////////////////////
+
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+type scimName struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// scimRef references a SCIM resource, the groups of a user or the members of
+// a group.
+type scimRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+type scimUserResource struct {
+	Schemas    []string    `json:"schemas"`
+	ID         string      `json:"id"`
+	ExternalID *string     `json:"externalId,omitempty"`
+	UserName   string      `json:"userName"`
+	Name       scimName    `json:"name"`
+	Emails     []scimEmail `json:"emails,omitempty"`
+	Active     bool        `json:"active"`
+	Groups     []scimRef   `json:"groups"`
+	Meta       scimMeta    `json:"meta"`
+}
+
+func newScimUserResource(user *fleet.ScimUser) scimUserResource {
+	res := scimUserResource{
+		Schemas:    []string{scimSchemaUser},
+		ID:         fmt.Sprint(user.ID),
+		ExternalID: user.ExternalID,
+		UserName:   user.UserName,
+		Name:       scimName{GivenName: user.GivenName, FamilyName: user.FamilyName},
+		Active:     user.Active,
+		Groups:     make([]scimRef, 0, len(user.Groups)),
+		Meta:       scimMeta{ResourceType: "User", Created: user.CreatedAt, LastModified: user.UpdatedAt},
+	}
+	if user.Email != "" {
+		res.Emails = []scimEmail{{Value: user.Email, Type: "work", Primary: true}}
+	}
+	for _, g := range user.Groups {
+		res.Groups = append(res.Groups, scimRef{Value: fmt.Sprint(g.ID), Display: g.DisplayName})
+	}
+	return res
+}
+
+type scimGroupResource struct {
+	Schemas     []string  `json:"schemas"`
+	ID          string    `json:"id"`
+	ExternalID  *string   `json:"externalId,omitempty"`
+	DisplayName string    `json:"displayName"`
+	Members     []scimRef `json:"members"`
+	Meta        scimMeta  `json:"meta"`
+}
+
+func newScimGroupResource(group *fleet.ScimGroup) scimGroupResource {
+	res := scimGroupResource{
+		Schemas:     []string{scimSchemaGroup},
+		ID:          fmt.Sprint(group.ID),
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Members:     make([]scimRef, 0, len(group.MemberIDs)),
+		Meta:        scimMeta{ResourceType: "Group", Created: group.CreatedAt, LastModified: group.UpdatedAt},
+	}
+	for _, id := range group.MemberIDs {
+		res.Members = append(res.Members, scimRef{Value: fmt.Sprint(id)})
+	}
+	return res
+}
+
+type scimListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   uint        `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// scimUserPayload is the user in the body of the requests that create or
+// replace a user.
+type scimUserPayload struct {
+	ExternalID *string     `json:"externalId"`
+	UserName   string      `json:"userName"`
+	Name       scimName    `json:"name"`
+	Emails     []scimEmail `json:"emails"`
+	Active     *bool       `json:"active"`
+}
+
+func (p scimUserPayload) scimUser() *fleet.ScimUser {
+	user := &fleet.ScimUser{
+		ExternalID: p.ExternalID,
+		UserName:   p.UserName,
+		GivenName:  p.Name.GivenName,
+		FamilyName: p.Name.FamilyName,
+		Email:      scimPrimaryEmail(p.Emails),
+		// users are active unless the IdP says otherwise
+		Active: true,
+	}
+	if p.Active != nil {
+		user.Active = *p.Active
+	}
+	return user
+}
+
+func scimPrimaryEmail(emails []scimEmail) string {
+	for _, e := range emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+// scimGroupPayload is the group in the body of the requests that create or
+// replace a group.
+type scimGroupPayload struct {
+	ExternalID  *string   `json:"externalId"`
+	DisplayName string    `json:"displayName"`
+	Members     []scimRef `json:"members"`
+}
+
+func decodeScimGroup(ctx context.Context, r io.Reader) (*fleet.ScimGroup, error) {
+	var p scimGroupPayload
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, badRequestErr("json decoder error", err)
+	}
+	memberIDs, err := parseScimMemberIDs(p.Members)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "parse group members")
+	}
+	return &fleet.ScimGroup{ExternalID: p.ExternalID, DisplayName: p.DisplayName, MemberIDs: memberIDs}, nil
+}
+
+func parseScimMemberIDs(refs []scimRef) ([]uint, error) {
+	ids := make([]uint, 0, len(refs))
+	for _, ref := range refs {
+		id, err := strconv.ParseUint(ref.Value, 10, 32)
+		if err != nil {
+			return nil, fleet.NewInvalidArgumentError("members", fmt.Sprintf("invalid SCIM user id %q", ref.Value))
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
+
+// scimError is an error with the SCIM type of the error response, see RFC
+// 7644 section 3.12.
+type scimError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *scimError) Error() string { return e.detail }
+
+func scimInvalidFilter(detail string) error {
+	return &scimError{status: http.StatusBadRequest, scimType: "invalidFilter", detail: detail}
+}
+
+func scimInvalidPath(path string) error {
+	return &scimError{status: http.StatusBadRequest, scimType: "invalidPath", detail: fmt.Sprintf("unsupported path %q", path)}
+}
+
+func scimInvalidValue(detail string) error {
+	return &scimError{status: http.StatusBadRequest, scimType: "invalidValue", detail: detail}
+}
+
+type scimErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+func newScimErrorResponse(err error) scimErrorResponse {
+	status, scimType := http.StatusInternalServerError, ""
+	cause := ctxerr.Cause(err)
+	switch e := cause.(type) {
+	case *scimError:
+		status, scimType = e.status, e.scimType
+	case validationErrorInterface:
+		status, scimType = http.StatusBadRequest, "invalidValue"
+	case badRequestErrorInterface:
+		status = http.StatusBadRequest
+	case permissionErrorInterface:
+		status = http.StatusForbidden
+	case notFoundErrorInterface:
+		status = http.StatusNotFound
+	case existsErrorInterface:
+		status, scimType = http.StatusConflict, "uniqueness"
+	default:
+		// same fallback as encodeError, e.g. for the authorization errors
+		var sce kithttp.StatusCoder
+		if errors.As(err, &sce) {
+			status = sce.StatusCode()
+		}
+	}
+
+	// the internal errors are not exposed to the IdP, they are logged by
+	// hijackRender.
+	detail := cause.Error()
+	if status >= http.StatusInternalServerError {
+		detail = http.StatusText(status)
+	}
+	return scimErrorResponse{
+		Schemas:  []string{scimSchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+// scimResponse is the response of the SCIM endpoints, it renders the
+// resource or the error as expected by the IdP.
+type scimResponse struct {
+	status   int
+	resource interface{}
+	err      error
+}
+
+// error returns nil so that the error is rendered as a SCIM error by
+// hijackRender.
+func (r scimResponse) error() error { return nil }
+
+func (r scimResponse) hijackRender(ctx context.Context, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/scim+json")
+
+	status, body := r.status, r.resource
+	if r.err != nil {
+		ctxerr.Handle(ctx, r.err)
+		logging.WithErr(ctx, r.err)
+
+		res := newScimErrorResponse(r.err)
+		status, _ = strconv.Atoi(res.Status)
+		body = res
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	if status == http.StatusNoContent {
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(body); err != nil {
+		logging.WithExtras(ctx, "scim_encode_error", err)
+	}
+}
+
+func scimListOptions(startIndex uint, count *uint, filter string) fleet.ScimListOptions {
+	opts := fleet.ScimListOptions{StartIndex: startIndex, Count: scimDefaultCount, Filter: filter}
+	if opts.StartIndex < 1 {
+		opts.StartIndex = 1
+	}
+	if count != nil {
+		opts.Count = *count
+	}
+	if opts.Count > scimMaxCount {
+		opts.Count = scimMaxCount
+	}
+	return opts
+}
+
+var scimEqFilterRegexp = regexp.MustCompile(`(?i)^\s*([a-z.]+)\s+eq\s+("(?:[^"\\]|\\.)*")\s*$`)
+
+// parseScimEqFilter parses the filter of a list request. Only the equality
+// filter on the attr attribute is supported, which is what the IdPs use to
+// check if a resource exists before creating it.
+func parseScimEqFilter(filter, attr string) (*string, error) {
+	if strings.TrimSpace(filter) == "" {
+		return nil, nil
+	}
+
+	m := scimEqFilterRegexp.FindStringSubmatch(filter)
+	if m == nil || !strings.EqualFold(m[1], attr) {
+		return nil, scimInvalidFilter(fmt.Sprintf("unsupported filter %q, only %s eq \"value\" is supported", filter, attr))
+	}
+	value, err := strconv.Unquote(m[2])
+	if err != nil {
+		return nil, scimInvalidFilter(fmt.Sprintf("invalid filter value %s", m[2]))
+	}
+	return &value, nil
+}
+
+// recordScimRequest records the outcome of a SCIM request that changes the
+// users or groups, for the SCIM details endpoint. It returns err unchanged.
+func (svc *Service) recordScimRequest(ctx context.Context, action string, err error) error {
+	req := &fleet.ScimLastRequest{Status: fleet.ScimRequestStatusSuccess, Details: action}
+	if err != nil {
+		req.Status = fleet.ScimRequestStatusError
+		req.Details = fmt.Sprintf("%s: %s", action, ctxerr.Cause(err).Error())
+	}
+	if recErr := svc.ds.SetScimLastRequest(ctx, req); recErr != nil {
+		level.Error(svc.logger).Log("err", "record SCIM last request", "details", recErr)
+	}
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// SCIM Users
+////////////////////////////////////////////////////////////////////////////////
+
+type listScimUsersRequest struct {
+	StartIndex uint   `query:"startIndex,optional"`
+	Count      *uint  `query:"count,optional"`
+	Filter     string `query:"filter,optional"`
+}
+
+func listScimUsersEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listScimUsersRequest)
+	opts := scimListOptions(req.StartIndex, req.Count, req.Filter)
+	users, total, err := svc.ListScimUsers(ctx, opts)
+	if err != nil {
+		return scimResponse{err: err}, nil
+	}
+
+	resources := make([]scimUserResource, 0, len(users))
+	for _, u := range users {
+		resources = append(resources, newScimUserResource(u))
+	}
+	return scimResponse{resource: scimListResponse{
+		Schemas:      []string{scimSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   opts.StartIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}}, nil
+}
+
+func (svc *Service) ListScimUsers(ctx context.Context, opts fleet.ScimListOptions) ([]*fleet.ScimUser, int, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.ScimAuthz{}, fleet.ActionRead); err != nil {
+		return nil, 0, err
+	}
+
+	userName, err := parseScimEqFilter(opts.Filter, "userName")
+	if err != nil {
+		return nil, 0, ctxerr.Wrap(ctx, err, "parse filter")
+	}
+	opts.UserNameFilter = userName
+
+	users, total, err := svc.ds.ListScimUsers(ctx, opts)
+	if err != nil {
+		return nil, 0, ctxerr.Wrap(ctx, err, "list scim users")
+	}
+	return users, total, nil
+}
+
+type getScimUserRequest struct {
+	ID uint `url:"id"`
+}
+
+func getScimUserEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getScimUserRequest)
+	user, err := svc.GetScimUser(ctx, req.ID)
+	if err != nil {
+		return scimResponse{err: err}, nil
+	}
+	return scimResponse{resource: newScimUserResource(user)}, nil
+}
+
+func (svc *Service) GetScimUser(ctx context.Context, id uint) (*fleet.ScimUser, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.ScimAuthz{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	user, err := svc.ds.ScimUserByID(ctx, id)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get scim user")
+	}
+	return user, nil
+}
+
+type createScimUserRequest struct {
+	scimUserPayload
+}
+
+func createScimUserEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*createScimUserRequest)
+	user, err := svc.CreateScimUser(ctx, req.scimUser())
+	if err != nil {
+		return scimResponse{err: err}, nil
+	}
+	return scimResponse{status: http.StatusCreated, resource: newScimUserResource(user)}, nil
+}
+
+func (svc *Service) CreateScimUser(ctx context.Context, user *fleet.ScimUser) (*fleet.ScimUser, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.ScimAuthz{}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	created, err := svc.createScimUser(ctx, user)
+	return created, svc.recordScimRequest(ctx, fmt.Sprintf("create user %q", user.UserName), err)
+}
+
+func (svc *Service) createScimUser(ctx context.Context, user *fleet.ScimUser) (*fleet.ScimUser, error) {
+	if err := validateScimUser(user); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "validate scim user")
+	}
+	created, err := svc.ds.CreateScimUser(ctx, user)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "create scim user")
+	}
+	return created, nil
+}
+
+func validateScimUser(user *fleet.ScimUser) error {
+	user.UserName = strings.TrimSpace(user.UserName)
+	if user.UserName == "" {
+		return fleet.NewInvalidArgumentError("userName", "must not be empty")
+	}
+	return nil
+}
+
+type replaceScimUserRequest struct {
+	ID uint `json:"-" url:"id"`
+	scimUserPayload
+}
+
+func replaceScimUserEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*replaceScimUserRequest)
+	user := req.scimUser()
+	user.ID = req.ID
+	user, err := svc.ReplaceScimUser(ctx, user)
+	if err != nil {
+		return scimResponse{err: err}, nil
+	}
+	return scimResponse{resource: newScimUserResource(user)}, nil
+}
+
+func (svc *Service) ReplaceScimUser(ctx context.Context, user *fleet.ScimUser) (*fleet.ScimUser, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.ScimAuthz{}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	replaced, err := svc.replaceScimUser(ctx, user)
+	return replaced, svc.recordScimRequest(ctx, fmt.Sprintf("replace user %d", user.ID), err)
+}
+
+func (svc *Service) replaceScimUser(ctx context.Context, user *fleet.ScimUser) (*fleet.ScimUser, error) {
+	if err := validateScimUser(user); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "validate scim user")
+	}
+	if err := svc.ds.ReplaceScimUser(ctx, user); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "replace scim user")
+	}
+	replaced, err := svc.ds.ScimUserByID(ctx, user.ID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get replaced scim user")
+	}
+	return replaced, nil
+}
+
+type patchScimRequest struct {
+	ID         uint                       `json:"-" url:"id"`
+	Operations []fleet.ScimPatchOperation `json:"Operations"`
+}
+
+func patchScimUserEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*patchScimRequest)
+	user, err := svc.PatchScimUser(ctx, req.ID, req.Operations)
+	if err != nil {
+		return scimResponse{err: err}, nil
+	}
+	return scimResponse{resource: newScimUserResource(user)}, nil
+}
+
+func (svc *Service) PatchScimUser(ctx context.Context, id uint, ops []fleet.ScimPatchOperation) (*fleet.ScimUser, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.ScimAuthz{}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	patched, err := svc.patchScimUser(ctx, id, ops)
+	return patched, svc.recordScimRequest(ctx, fmt.Sprintf("update user %d", id), err)
+}
+
+func (svc *Service) patchScimUser(ctx context.Context, id uint, ops []fleet.ScimPatchOperation) (*fleet.ScimUser, error) {
+	user, err := svc.ds.ScimUserByID(ctx, id)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get scim user")
+	}
+	for _, op := range ops {
+		if err := applyScimUserPatch(user, strings.ToLower(op.Op), op.Path, op.Value); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "apply patch operation")
+		}
+	}
+	return svc.replaceScimUser(ctx, user)
+}
+
+// applyScimUserPatch applies the PATCH operation op (in lowercase) on the
+// attribute at path of the user.
+func applyScimUserPatch(user *fleet.ScimUser, op, path string, value json.RawMessage) error {
+	if op != "add" && op != "replace" && op != "remove" {
+		return scimInvalidValue(fmt.Sprintf("unsupported operation %q", op))
+	}
+	remove := op == "remove"
+
+	lpath := strings.ToLower(path)
+	switch {
+	case lpath == "":
+		// the value holds the attributes to set
+		if remove {
+			return scimInvalidPath(path)
+		}
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(value, &attrs); err != nil {
+			return scimInvalidValue("value must be an object of attributes")
+		}
+		for k, v := range attrs {
+			switch strings.ToLower(k) {
+			case "id", "schemas", "meta", "groups":
+				// read-only attributes
+				continue
+			}
+			if err := applyScimUserPatch(user, op, k, v); err != nil {
+				return err
+			}
+		}
+
+	case lpath == "active":
+		if remove {
+			return scimInvalidPath(path)
+		}
+		active, err := scimBoolValue(value)
+		if err != nil {
+			return err
+		}
+		user.Active = active
+
+	case lpath == "username":
+		if remove {
+			return scimInvalidPath(path)
+		}
+		userName, err := scimStringValue(value)
+		if err != nil {
+			return err
+		}
+		user.UserName = userName
+
+	case lpath == "externalid":
+		user.ExternalID = nil
+		if !remove {
+			externalID, err := scimStringValue(value)
+			if err != nil {
+				return err
+			}
+			user.ExternalID = &externalID
+		}
+
+	case lpath == "name":
+		var name scimName
+		if !remove {
+			if err := json.Unmarshal(value, &name); err != nil {
+				return scimInvalidValue("name must be an object")
+			}
+		}
+		user.GivenName, user.FamilyName = name.GivenName, name.FamilyName
+
+	case lpath == "name.givenname", lpath == "name.familyname":
+		var s string
+		if !remove {
+			var err error
+			if s, err = scimStringValue(value); err != nil {
+				return err
+			}
+		}
+		if lpath == "name.givenname" {
+			user.GivenName = s
+		} else {
+			user.FamilyName = s
+		}
+
+	case lpath == "emails":
+		var emails []scimEmail
+		if !remove {
+			if err := json.Unmarshal(value, &emails); err != nil {
+				return scimInvalidValue("emails must be a list of emails")
+			}
+		}
+		user.Email = scimPrimaryEmail(emails)
+
+	case strings.HasPrefix(lpath, "emails[") && strings.HasSuffix(lpath, "].value"):
+		// e.g. emails[type eq "work"].value, the user has a single email
+		var email string
+		if !remove {
+			var err error
+			if email, err = scimStringValue(value); err != nil {
+				return err
+			}
+		}
+		user.Email = email
+
+	default:
+		return scimInvalidPath(path)
+	}
+	return nil
+}
+
+func scimStringValue(value json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return "", scimInvalidValue("value must be a string")
+	}
+	return s, nil
+}
+
+// scimBoolValue decodes a boolean value, some IdPs send booleans as strings.
+func scimBoolValue(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		if b, err := strconv.ParseBool(strings.ToLower(s)); err == nil {
+			return b, nil
+		}
+	}
+	return false, scimInvalidValue("value must be a boolean")
+}
+
+type deleteScimRequest struct {
+	ID uint `url:"id"`
+}
+
+func deleteScimUserEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*deleteScimRequest)
+	if err := svc.DeleteScimUser(ctx, req.ID); err != nil {
+		return scimResponse{err: err}, nil
+	}
+	return scimResponse{status: http.StatusNoContent}, nil
+}
+
+func (svc *Service) DeleteScimUser(ctx context.Context, id uint) error {
+	if err := svc.authz.Authorize(ctx, &fleet.ScimAuthz{}, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	err := svc.ds.DeleteScimUser(ctx, id)
+	if err != nil {
+		err = ctxerr.Wrap(ctx, err, "delete scim user")
+	}
+	return svc.recordScimRequest(ctx, fmt.Sprintf("delete user %d", id), err)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// SCIM Groups
+////////////////////////////////////////////////////////////////////////////////
+
+type listScimGroupsRequest struct {
+	StartIndex uint   `query:"startIndex,optional"`
+	Count      *uint  `query:"count,optional"`
+	Filter     string `query:"filter,optional"`
+}
+
+func listScimGroupsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listScimGroupsRequest)
+	opts := scimListOptions(req.StartIndex, req.Count, req.Filter)
+	groups, total, err := svc.ListScimGroups(ctx, opts)
+	if err != nil {
+		return scimResponse{err: err}, nil
+	}
+
+	resources := make([]scimGroupResource, 0, len(groups))
+	for _, g := range groups {
+		resources = append(resources, newScimGroupResource(g))
+	}
+	return scimResponse{resource: scimListResponse{
+		Schemas:      []string{scimSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   opts.StartIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}}, nil
+}
+
+func (svc *Service) ListScimGroups(ctx context.Context, opts fleet.ScimListOptions) ([]*fleet.ScimGroup, int, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.ScimAuthz{}, fleet.ActionRead); err != nil {
+		return nil, 0, err
+	}
+
+	displayName, err := parseScimEqFilter(opts.Filter, "displayName")
+	if err != nil {
+		return nil, 0, ctxerr.Wrap(ctx, err, "parse filter")
+	}
+	opts.DisplayNameFilter = displayName
+
+	groups, total, err := svc.ds.ListScimGroups(ctx, opts)
+	if err != nil {
+		return nil, 0, ctxerr.Wrap(ctx, err, "list scim groups")
+	}
+	return groups, total, nil
+}
+
+type getScimGroupRequest struct {
+	ID uint `url:"id"`
+}
+
+func getScimGroupEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getScimGroupRequest)
+	group, err := svc.GetScimGroup(ctx, req.ID)
+	if err != nil {
+		return scimResponse{err: err}, nil
+	}
+	return scimResponse{resource: newScimGroupResource(group)}, nil
+}
+
+func (svc *Service) GetScimGroup(ctx context.Context, id uint) (*fleet.ScimGroup, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.ScimAuthz{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	group, err := svc.ds.ScimGroupByID(ctx, id)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get scim group")
+	}
+	return group, nil
+}
+
+type createScimGroupRequest struct {
+	group *fleet.ScimGroup
+}
+
+func (req *createScimGroupRequest) DecodeBody(ctx context.Context, r io.Reader) error {
+	group, err := decodeScimGroup(ctx, r)
+	req.group = group
+	return err
+}
+
+func createScimGroupEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*createScimGroupRequest)
+	group, err := svc.CreateScimGroup(ctx, req.group)
+	if err != nil {
+		return scimResponse{err: err}, nil
+	}
+	return scimResponse{status: http.StatusCreated, resource: newScimGroupResource(group)}, nil
+}
+
+func (svc *Service) CreateScimGroup(ctx context.Context, group *fleet.ScimGroup) (*fleet.ScimGroup, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.ScimAuthz{}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	created, err := svc.createScimGroup(ctx, group)
+	return created, svc.recordScimRequest(ctx, fmt.Sprintf("create group %q", group.DisplayName), err)
+}
+
+func (svc *Service) createScimGroup(ctx context.Context, group *fleet.ScimGroup) (*fleet.ScimGroup, error) {
+	if err := validateScimGroup(group); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "validate scim group")
+	}
+	created, err := svc.ds.CreateScimGroup(ctx, group)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			// the group is new, so it's one of its members that doesn't exist
+			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("members", "must be existing SCIM users"))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "create scim group")
+	}
+	return created, nil
+}
+
+func validateScimGroup(group *fleet.ScimGroup) error {
+	group.DisplayName = strings.TrimSpace(group.DisplayName)
+	if group.DisplayName == "" {
+		return fleet.NewInvalidArgumentError("displayName", "must not be empty")
+	}
+	return nil
+}
+
+type replaceScimGroupRequest struct {
+	ID    uint `url:"id"`
+	group *fleet.ScimGroup
+}
+
+func (req *replaceScimGroupRequest) DecodeBody(ctx context.Context, r io.Reader) error {
+	group, err := decodeScimGroup(ctx, r)
+	req.group = group
+	return err
+}
+
+func replaceScimGroupEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*replaceScimGroupRequest)
+	req.group.ID = req.ID
+	group, err := svc.ReplaceScimGroup(ctx, req.group)
+	if err != nil {
+		return scimResponse{err: err}, nil
+	}
+	return scimResponse{resource: newScimGroupResource(group)}, nil
+}
+
+func (svc *Service) ReplaceScimGroup(ctx context.Context, group *fleet.ScimGroup) (*fleet.ScimGroup, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.ScimAuthz{}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	replaced, err := svc.replaceScimGroup(ctx, group)
+	return replaced, svc.recordScimRequest(ctx, fmt.Sprintf("replace group %d", group.ID), err)
+}
+
+func (svc *Service) replaceScimGroup(ctx context.Context, group *fleet.ScimGroup) (*fleet.ScimGroup, error) {
+	if err := validateScimGroup(group); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "validate scim group")
+	}
+	// the group must exist so that a NotFound error of the replace is a
+	// member that doesn't exist
+	if _, err := svc.ds.ScimGroupByID(ctx, group.ID); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get scim group")
+	}
+	if err := svc.ds.ReplaceScimGroup(ctx, group); err != nil {
+		if fleet.IsNotFound(err) {
+			return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("members", "must be existing SCIM users"))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "replace scim group")
+	}
+	replaced, err := svc.ds.ScimGroupByID(ctx, group.ID)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get replaced scim group")
+	}
+	return replaced, nil
+}
+
+func patchScimGroupEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*patchScimRequest)
+	group, err := svc.PatchScimGroup(ctx, req.ID, req.Operations)
+	if err != nil {
+		return scimResponse{err: err}, nil
+	}
+	return scimResponse{resource: newScimGroupResource(group)}, nil
+}
+
+func (svc *Service) PatchScimGroup(ctx context.Context, id uint, ops []fleet.ScimPatchOperation) (*fleet.ScimGroup, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.ScimAuthz{}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	patched, err := svc.patchScimGroup(ctx, id, ops)
+	return patched, svc.recordScimRequest(ctx, fmt.Sprintf("update group %d", id), err)
+}
+
+func (svc *Service) patchScimGroup(ctx context.Context, id uint, ops []fleet.ScimPatchOperation) (*fleet.ScimGroup, error) {
+	group, err := svc.ds.ScimGroupByID(ctx, id)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get scim group")
+	}
+	for _, op := range ops {
+		if err := applyScimGroupPatch(group, strings.ToLower(op.Op), op.Path, op.Value); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "apply patch operation")
+		}
+	}
+	return svc.replaceScimGroup(ctx, group)
+}
+
+var scimMemberFilterPathRegexp = regexp.MustCompile(`(?i)^members\[\s*value\s+eq\s+("(?:[^"\\]|\\.)*")\s*\]$`)
+
+// applyScimGroupPatch applies the PATCH operation op (in lowercase) on the
+// attribute at path of the group.
+func applyScimGroupPatch(group *fleet.ScimGroup, op, path string, value json.RawMessage) error {
+	if op != "add" && op != "replace" && op != "remove" {
+		return scimInvalidValue(fmt.Sprintf("unsupported operation %q", op))
+	}
+	remove := op == "remove"
+
+	lpath := strings.ToLower(path)
+	switch {
+	case lpath == "":
+		// the value holds the attributes to set
+		if remove {
+			return scimInvalidPath(path)
+		}
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(value, &attrs); err != nil {
+			return scimInvalidValue("value must be an object of attributes")
+		}
+		for k, v := range attrs {
+			switch strings.ToLower(k) {
+			case "id", "schemas", "meta":
+				// read-only attributes
+				continue
+			}
+			if err := applyScimGroupPatch(group, op, k, v); err != nil {
+				return err
+			}
+		}
+
+	case lpath == "displayname":
+		if remove {
+			return scimInvalidPath(path)
+		}
+		displayName, err := scimStringValue(value)
+		if err != nil {
+			return err
+		}
+		group.DisplayName = displayName
+
+	case lpath == "externalid":
+		group.ExternalID = nil
+		if !remove {
+			externalID, err := scimStringValue(value)
+			if err != nil {
+				return err
+			}
+			group.ExternalID = &externalID
+		}
+
+	case lpath == "members":
+		var ids []uint
+		if len(value) > 0 && string(value) != "null" {
+			var refs []scimRef
+			if err := json.Unmarshal(value, &refs); err != nil {
+				return scimInvalidValue("members must be a list of members")
+			}
+			var err error
+			if ids, err = parseScimMemberIDs(refs); err != nil {
+				return err
+			}
+		}
+		switch op {
+		case "add":
+			group.MemberIDs = append(group.MemberIDs, ids...)
+		case "replace":
+			group.MemberIDs = ids
+		case "remove":
+			if len(ids) == 0 {
+				// removes all the members
+				group.MemberIDs = nil
+			} else {
+				group.MemberIDs = removeScimMembers(group.MemberIDs, ids)
+			}
+		}
+
+	case scimMemberFilterPathRegexp.MatchString(path):
+		if !remove {
+			return scimInvalidPath(path)
+		}
+		quoted := scimMemberFilterPathRegexp.FindStringSubmatch(path)[1]
+		s, err := strconv.Unquote(quoted)
+		if err != nil {
+			return scimInvalidPath(path)
+		}
+		ids, err := parseScimMemberIDs([]scimRef{{Value: s}})
+		if err != nil {
+			return err
+		}
+		group.MemberIDs = removeScimMembers(group.MemberIDs, ids)
+
+	default:
+		return scimInvalidPath(path)
+	}
+	return nil
+}
+
+func removeScimMembers(memberIDs, removed []uint) []uint {
+	remove := make(map[uint]bool, len(removed))
+	for _, id := range removed {
+		remove[id] = true
+	}
+	kept := memberIDs[:0]
+	for _, id := range memberIDs {
+		if !remove[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+func deleteScimGroupEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*deleteScimRequest)
+	if err := svc.DeleteScimGroup(ctx, req.ID); err != nil {
+		return scimResponse{err: err}, nil
+	}
+	return scimResponse{status: http.StatusNoContent}, nil
+}
+
+func (svc *Service) DeleteScimGroup(ctx context.Context, id uint) error {
+	if err := svc.authz.Authorize(ctx, &fleet.ScimAuthz{}, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	err := svc.ds.DeleteScimGroup(ctx, id)
+	if err != nil {
+		err = ctxerr.Wrap(ctx, err, "delete scim group")
+	}
+	return svc.recordScimRequest(ctx, fmt.Sprintf("delete group %d", id), err)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// SCIM Details
+////////////////////////////////////////////////////////////////////////////////
+
+type getScimDetailsResponse struct {
+	*fleet.ScimDetails
+	Err error `json:"error,omitempty"`
+}
+
+func (r getScimDetailsResponse) error() error { return r.Err }
+
+func getScimDetailsEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	details, err := svc.GetScimDetails(ctx)
+	if err != nil {
+		return getScimDetailsResponse{Err: err}, nil
+	}
+	return getScimDetailsResponse{ScimDetails: details}, nil
+}
+
+func (svc *Service) GetScimDetails(ctx context.Context) (*fleet.ScimDetails, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.ScimAuthz{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	var details fleet.ScimDetails
+	lastReq, err := svc.ds.ScimLastRequest(ctx)
+	if err != nil && !fleet.IsNotFound(err) {
+		return nil, ctxerr.Wrap(ctx, err, "get scim last request")
+	}
+	details.LastRequest = lastReq
+
+	// a count of 0 only returns the totals
+	if _, details.UsersCount, err = svc.ds.ListScimUsers(ctx, fleet.ScimListOptions{}); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "count scim users")
+	}
+	if _, details.GroupsCount, err = svc.ds.ListScimGroups(ctx, fleet.ScimListOptions{}); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "count scim groups")
+	}
+	return &details, nil
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/mock"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/fleetdm/fleet/v4/server/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScimAuth(t *testing.T) {
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil)
+
+	ds.ListScimUsersFunc = func(ctx context.Context, opts fleet.ScimListOptions) ([]*fleet.ScimUser, int, error) {
+		return nil, 0, nil
+	}
+	ds.ListScimGroupsFunc = func(ctx context.Context, opts fleet.ScimListOptions) ([]*fleet.ScimGroup, int, error) {
+		return nil, 0, nil
+	}
+	ds.ScimUserByIDFunc = func(ctx context.Context, id uint) (*fleet.ScimUser, error) {
+		return &fleet.ScimUser{ID: id, UserName: "a@example.com"}, nil
+	}
+	ds.CreateScimUserFunc = func(ctx context.Context, user *fleet.ScimUser) (*fleet.ScimUser, error) {
+		return user, nil
+	}
+	ds.DeleteScimGroupFunc = func(ctx context.Context, id uint) error {
+		return nil
+	}
+	ds.ScimLastRequestFunc = func(ctx context.Context) (*fleet.ScimLastRequest, error) {
+		return nil, &notFoundError{}
+	}
+	ds.SetScimLastRequestFunc = func(ctx context.Context, req *fleet.ScimLastRequest) error {
+		return nil
+	}
+
+	testCases := []struct {
+		name            string
+		user            *fleet.User
+		shouldFailRead  bool
+		shouldFailWrite bool
+	}{
+		{"global admin", test.UserAdmin, false, false},
+		{"global maintainer", test.UserMaintainer, true, true},
+		{"global observer", test.UserObserver, true, true},
+		{"team admin", test.UserTeamAdminTeam1, true, true},
+		{"no roles", test.UserNoRoles, true, true},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := test.UserContext(ctx, tt.user)
+
+			_, _, err := svc.ListScimUsers(ctx, fleet.ScimListOptions{})
+			checkAuthErr(t, tt.shouldFailRead, err)
+			if tt.shouldFailRead {
+				require.Equal(t, "403", newScimErrorResponse(err).Status)
+			}
+			_, err = svc.GetScimUser(ctx, 1)
+			checkAuthErr(t, tt.shouldFailRead, err)
+			_, _, err = svc.ListScimGroups(ctx, fleet.ScimListOptions{})
+			checkAuthErr(t, tt.shouldFailRead, err)
+			_, err = svc.GetScimDetails(ctx)
+			checkAuthErr(t, tt.shouldFailRead, err)
+
+			_, err = svc.CreateScimUser(ctx, &fleet.ScimUser{UserName: "a@example.com"})
+			checkAuthErr(t, tt.shouldFailWrite, err)
+			err = svc.DeleteScimGroup(ctx, 1)
+			checkAuthErr(t, tt.shouldFailWrite, err)
+		})
+	}
+}
+
+func TestScimRequests(t *testing.T) {
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil)
+	ctx = test.UserContext(ctx, test.UserAdmin)
+
+	var lastReq *fleet.ScimLastRequest
+	ds.SetScimLastRequestFunc = func(ctx context.Context, req *fleet.ScimLastRequest) error {
+		lastReq = req
+		return nil
+	}
+	ds.CreateScimUserFunc = func(ctx context.Context, user *fleet.ScimUser) (*fleet.ScimUser, error) {
+		if user.UserName == "taken@example.com" {
+			return nil, &alreadyExistsError{}
+		}
+		return user, nil
+	}
+	var listOpts fleet.ScimListOptions
+	ds.ListScimUsersFunc = func(ctx context.Context, opts fleet.ScimListOptions) ([]*fleet.ScimUser, int, error) {
+		listOpts = opts
+		return nil, 0, nil
+	}
+
+	// the user name is required
+	_, err := svc.CreateScimUser(ctx, &fleet.ScimUser{UserName: " "})
+	require.Error(t, err)
+	require.Equal(t, fleet.ScimRequestStatusError, lastReq.Status)
+	require.False(t, ds.CreateScimUserFuncInvoked)
+
+	// a conflict is recorded and rendered as a uniqueness error
+	_, err = svc.CreateScimUser(ctx, &fleet.ScimUser{UserName: "taken@example.com"})
+	require.Error(t, err)
+	require.Equal(t, fleet.ScimRequestStatusError, lastReq.Status)
+	require.Contains(t, lastReq.Details, `create user "taken@example.com"`)
+	res := newScimErrorResponse(err)
+	require.Equal(t, "409", res.Status)
+	require.Equal(t, "uniqueness", res.ScimType)
+
+	user, err := svc.CreateScimUser(ctx, &fleet.ScimUser{UserName: "a@example.com"})
+	require.NoError(t, err)
+	require.Equal(t, "a@example.com", user.UserName)
+	require.Equal(t, &fleet.ScimLastRequest{Status: fleet.ScimRequestStatusSuccess, Details: `create user "a@example.com"`}, lastReq)
+
+	// only the equality filter on the user name is supported
+	_, _, err = svc.ListScimUsers(ctx, fleet.ScimListOptions{Count: 1, Filter: `userName eq "a@example.com"`})
+	require.NoError(t, err)
+	require.Equal(t, ptr.String("a@example.com"), listOpts.UserNameFilter)
+	_, _, err = svc.ListScimUsers(ctx, fleet.ScimListOptions{Count: 1, Filter: `displayName eq "a"`})
+	require.Error(t, err)
+	require.Equal(t, "invalidFilter", newScimErrorResponse(err).ScimType)
+
+	// members must be existing users
+	ds.CreateScimGroupFunc = func(ctx context.Context, group *fleet.ScimGroup) (*fleet.ScimGroup, error) {
+		return nil, &notFoundError{}
+	}
+	_, err = svc.CreateScimGroup(ctx, &fleet.ScimGroup{DisplayName: "g", MemberIDs: []uint{1}})
+	require.Error(t, err)
+	require.Equal(t, "400", newScimErrorResponse(err).Status)
+
+	// the internal errors are not exposed
+	res = newScimErrorResponse(ctxerr.Wrap(ctx, errors.New("connection refused"), "list users"))
+	require.Equal(t, "500", res.Status)
+	require.Equal(t, http.StatusText(http.StatusInternalServerError), res.Detail)
+}
+
+func TestScimPatch(t *testing.T) {
+	user := &fleet.ScimUser{UserName: "a@example.com", Active: true}
+	ops := []fleet.ScimPatchOperation{
+		{Op: "Replace", Path: "active", Value: json.RawMessage(`"False"`)},
+		{Op: "add", Path: `emails[type eq "work"].value`, Value: json.RawMessage(`"a@work.example.com"`)},
+		{Op: "replace", Value: json.RawMessage(`{"name": {"givenName": "A", "familyName": "B"}, "externalId": "ext"}`)},
+	}
+	for _, op := range ops {
+		require.NoError(t, applyScimUserPatch(user, strings.ToLower(op.Op), op.Path, op.Value))
+	}
+	require.Equal(t, &fleet.ScimUser{
+		UserName:   "a@example.com",
+		GivenName:  "A",
+		FamilyName: "B",
+		Email:      "a@work.example.com",
+		ExternalID: ptr.String("ext"),
+	}, user)
+
+	err := applyScimUserPatch(user, "replace", "nickName", json.RawMessage(`"x"`))
+	require.Equal(t, "invalidPath", newScimErrorResponse(err).ScimType)
+	err = applyScimUserPatch(user, "replace", "active", json.RawMessage(`"maybe"`))
+	require.Equal(t, "invalidValue", newScimErrorResponse(err).ScimType)
+
+	group := &fleet.ScimGroup{DisplayName: "g", MemberIDs: []uint{1, 2}}
+	require.NoError(t, applyScimGroupPatch(group, "add", "members", json.RawMessage(`[{"value": "3"}, {"value": "4"}]`)))
+	require.NoError(t, applyScimGroupPatch(group, "remove", `members[value eq "2"]`, nil))
+	require.NoError(t, applyScimGroupPatch(group, "remove", "members", json.RawMessage(`[{"value": "4"}]`)))
+	require.NoError(t, applyScimGroupPatch(group, "replace", "displayName", json.RawMessage(`"h"`)))
+	require.Equal(t, &fleet.ScimGroup{DisplayName: "h", MemberIDs: []uint{1, 3}}, group)
+
+	require.NoError(t, applyScimGroupPatch(group, "remove", "members", nil))
+	require.Empty(t, group.MemberIDs)
+
+	err = applyScimGroupPatch(group, "add", "members", json.RawMessage(`[{"value": "x"}]`))
+	require.Equal(t, "invalidValue", newScimErrorResponse(err).ScimType)
+}
+
+func TestScimResponseRender(t *testing.T) {
+	w := httptest.NewRecorder()
+	scimResponse{err: scimInvalidFilter("bad filter")}.hijackRender(context.Background(), w)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "application/scim+json", w.Header().Get("Content-Type"))
+	require.JSONEq(t, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:Error"],
+		"status": "400",
+		"scimType": "invalidFilter",
+		"detail": "bad filter"
+	}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	scimResponse{status: http.StatusNoContent}.hijackRender(context.Background(), w)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Empty(t, w.Body.String())
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// List security audit log
+////////////////////////////////////////////////////////////////////////////////
+
+type listSecurityAuditLogRequest struct {
+	ListOptions fleet.ListOptions `url:"list_options"`
+}
+
+type listSecurityAuditLogResponse struct {
+	Meta    *fleet.PaginationMetadata      `json:"meta"`
+	Entries []*fleet.SecurityAuditLogEntry `json:"entries"`
+	Err     error                          `json:"error,omitempty"`
+}
+
+func (r listSecurityAuditLogResponse) error() error { return r.Err }
+
+func listSecurityAuditLogEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*listSecurityAuditLogRequest)
+	entries, meta, err := svc.ListSecurityAuditLog(ctx, req.ListOptions)
+	if err != nil {
+		return listSecurityAuditLogResponse{Err: err}, nil
+	}
+	return listSecurityAuditLogResponse{Meta: meta, Entries: entries}, nil
+}
+
+func (svc *Service) ListSecurityAuditLog(ctx context.Context, opt fleet.ListOptions) ([]*fleet.SecurityAuditLogEntry, *fleet.PaginationMetadata, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.SecurityAuditLogEntry{}, fleet.ActionRead); err != nil {
+		return nil, nil, err
+	}
+
+	entries, meta, err := svc.ds.ListSecurityAuditLog(ctx, opt)
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "list security audit log")
+	}
+	return entries, meta, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Verify security audit log
+////////////////////////////////////////////////////////////////////////////////
+
+type verifySecurityAuditLogRequest struct{}
+
+type verifySecurityAuditLogResponse struct {
+	*fleet.SecurityAuditLogVerification
+	Err error `json:"error,omitempty"`
+}
+
+func (r verifySecurityAuditLogResponse) error() error { return r.Err }
+
+func verifySecurityAuditLogEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	res, err := svc.VerifySecurityAuditLog(ctx)
+	if err != nil {
+		return verifySecurityAuditLogResponse{Err: err}, nil
+	}
+	return verifySecurityAuditLogResponse{SecurityAuditLogVerification: res}, nil
+}
+
+func (svc *Service) VerifySecurityAuditLog(ctx context.Context) (*fleet.SecurityAuditLogVerification, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.SecurityAuditLogEntry{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	res, err := svc.ds.VerifySecurityAuditLog(ctx)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "verify security audit log")
+	}
+	return res, nil
+}
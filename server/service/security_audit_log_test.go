@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/authz"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/mock"
+	"github.com/fleetdm/fleet/v4/server/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityAuditLogAuth(t *testing.T) {
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil)
+
+	ds.ListSecurityAuditLogFunc = func(ctx context.Context, opt fleet.ListOptions) ([]*fleet.SecurityAuditLogEntry, *fleet.PaginationMetadata, error) {
+		return []*fleet.SecurityAuditLogEntry{{ID: 1}}, &fleet.PaginationMetadata{}, nil
+	}
+	ds.VerifySecurityAuditLogFunc = func(ctx context.Context) (*fleet.SecurityAuditLogVerification, error) {
+		return &fleet.SecurityAuditLogVerification{Valid: true, EntryCount: 1}, nil
+	}
+
+	testCases := []struct {
+		name       string
+		user       *fleet.User
+		shouldFail bool
+	}{
+		{"global admin", test.UserAdmin, false},
+		{"global maintainer", test.UserMaintainer, true},
+		{"global observer", test.UserObserver, true},
+		{"team admin", test.UserTeamAdminTeam1, true},
+		{"user without roles", test.UserNoRoles, true},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := test.UserContext(ctx, tt.user)
+
+			entries, _, err := svc.ListSecurityAuditLog(ctx, fleet.ListOptions{})
+			res, verifyErr := svc.VerifySecurityAuditLog(ctx)
+			if tt.shouldFail {
+				require.ErrorContains(t, err, authz.ForbiddenErrorMessage)
+				require.ErrorContains(t, verifyErr, authz.ForbiddenErrorMessage)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			require.NoError(t, verifyErr)
+			require.True(t, res.Valid)
+		})
+	}
+}
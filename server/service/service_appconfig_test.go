@@ -156,6 +156,7 @@ func TestService_LoggingConfig(t *testing.T) {
 		StatusLogFile:        logFile,
 		ResultLogFile:        logFile,
 		AuditLogFile:         logFile,
+		SecurityAuditLogFile: logFile,
 		EnableLogRotation:    false,
 		EnableLogCompression: false,
 		MaxSize:              500,
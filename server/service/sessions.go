@@ -708,3 +708,71 @@ func makeDemologinEndpoint(urlPrefix string) handlerFunc {
 		return resp, nil
 	}
 }
+
+////////////////////////////////////////////////////////////////////////////////
+// Re-authenticate session
+////////////////////////////////////////////////////////////////////////////////
+
+type reauthenticateSessionRequest struct {
+	Password string `json:"password"`
+}
+
+type reauthenticateSessionResponse struct {
+	SudoModeExpiresAt time.Time `json:"sudo_mode_expires_at"`
+	Err               error     `json:"error,omitempty"`
+}
+
+func (r reauthenticateSessionResponse) error() error { return r.Err }
+
+func reauthenticateSessionEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*reauthenticateSessionRequest)
+	session, err := svc.ReauthenticateSession(ctx, req.Password)
+	if err != nil {
+		return reauthenticateSessionResponse{Err: err}, nil
+	}
+	return reauthenticateSessionResponse{SudoModeExpiresAt: session.ReauthenticatedAt.Add(fleet.SessionSudoModeDuration)}, nil
+}
+
+func (svc *Service) ReauthenticateSession(ctx context.Context, password string) (*fleet.Session, error) {
+	vc, ok := viewer.FromContext(ctx)
+	if !ok {
+		return nil, fleet.ErrNoContext
+	}
+	if err := svc.authz.Authorize(ctx, &fleet.User{ID: vc.UserID()}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+	if !vc.IsLoggedIn() {
+		return nil, fleet.NewPermissionError("not logged in")
+	}
+
+	if vc.User.SSOEnabled {
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("password", "Single sign on users must log in again to re-authenticate"))
+	}
+	if err := vc.User.ValidatePassword(password); err != nil {
+		if err := svc.ds.NewActivity(ctx, nil, fleet.ActivityTypeUserFailedLogin{
+			Email:    vc.Email(),
+			PublicIP: publicip.FromContext(ctx),
+		}); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "create failed login activity")
+		}
+		return nil, ctxerr.Wrap(ctx, fleet.NewInvalidArgumentError("password", "Password is incorrect"))
+	}
+
+	if err := svc.ds.MarkSessionReauthenticated(ctx, vc.Session); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "mark session reauthenticated")
+	}
+	return vc.Session, nil
+}
+
+// requireSudoMode returns an error if the session of the current user isn't
+// in sudo mode.
+func (svc *Service) requireSudoMode(ctx context.Context) error {
+	vc, ok := viewer.FromContext(ctx)
+	if !ok || vc.Session == nil {
+		return fleet.ErrSudoModeRequired
+	}
+	if !vc.Session.InSudoMode(svc.clock.Now()) {
+		return fleet.ErrSudoModeRequired
+	}
+	return nil
+}
@@ -186,6 +186,52 @@ func TestGetSessionByKey(t *testing.T) {
 	}
 }
 
+func TestReauthenticateSession(t *testing.T) {
+	ds := new(mock.Store)
+	svc, ctx := newTestService(t, ds, nil, nil)
+
+	user := &fleet.User{ID: 1, Email: "a@b.c", GlobalRole: ptr.String(fleet.RoleObserver)}
+	require.NoError(t, user.SetPassword(test.GoodPassword, 10, 10))
+	session := &fleet.Session{ID: 2, UserID: user.ID, CreateTimestamp: fleet.CreateTimestamp{CreatedAt: time.Now().Add(-time.Hour)}}
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: user, Session: session})
+
+	ds.MarkSessionReauthenticatedFunc = func(ctx context.Context, ssn *fleet.Session) error {
+		now := time.Now()
+		ssn.ReauthenticatedAt = &now
+		return nil
+	}
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		act, ok := activity.(fleet.ActivityTypeUserFailedLogin)
+		require.True(t, ok)
+		require.Equal(t, "a@b.c", act.Email)
+		return nil
+	}
+
+	require.False(t, session.InSudoMode(time.Now()))
+
+	// wrong password
+	_, err := svc.ReauthenticateSession(ctx, "nope")
+	var iae *fleet.InvalidArgumentError
+	require.ErrorAs(t, err, &iae)
+	require.True(t, ds.NewActivityFuncInvoked)
+	require.False(t, ds.MarkSessionReauthenticatedFuncInvoked)
+	require.False(t, session.InSudoMode(time.Now()))
+
+	// right password
+	got, err := svc.ReauthenticateSession(ctx, test.GoodPassword)
+	require.NoError(t, err)
+	require.True(t, ds.MarkSessionReauthenticatedFuncInvoked)
+	require.True(t, got.InSudoMode(time.Now()))
+	require.False(t, got.InSudoMode(time.Now().Add(fleet.SessionSudoModeDuration)))
+
+	// sso users must log in again
+	ds.MarkSessionReauthenticatedFuncInvoked = false
+	user.SSOEnabled = true
+	_, err = svc.ReauthenticateSession(ctx, test.GoodPassword)
+	require.ErrorAs(t, err, &iae)
+	require.False(t, ds.MarkSessionReauthenticatedFuncInvoked)
+}
+
 type testAuth struct {
 	userID              string
 	userDisplayName     string
@@ -20,14 +20,15 @@ import (
 /////////////////////////////////////////////////////////////////////////////////
 
 type teamPolicyRequest struct {
-	TeamID      uint   `url:"team_id"`
-	QueryID     *uint  `json:"query_id"`
-	Query       string `json:"query"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Resolution  string `json:"resolution"`
-	Platform    string `json:"platform"`
-	Critical    bool   `json:"critical" premium:"true"`
+	TeamID        uint                      `url:"team_id"`
+	QueryID       *uint                     `json:"query_id"`
+	Query         string                    `json:"query"`
+	Name          string                    `json:"name"`
+	Description   string                    `json:"description"`
+	Resolution    string                    `json:"resolution"`
+	Platform      string                    `json:"platform"`
+	Critical      bool                      `json:"critical" premium:"true"`
+	MDMConditions fleet.PolicyMDMConditions `json:"mdm_conditions"`
 }
 
 type teamPolicyResponse struct {
@@ -40,13 +41,14 @@ func (r teamPolicyResponse) error() error { return r.Err }
 func teamPolicyEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
 	req := request.(*teamPolicyRequest)
 	resp, err := svc.NewTeamPolicy(ctx, req.TeamID, fleet.PolicyPayload{
-		QueryID:     req.QueryID,
-		Name:        req.Name,
-		Query:       req.Query,
-		Description: req.Description,
-		Resolution:  req.Resolution,
-		Platform:    req.Platform,
-		Critical:    req.Critical,
+		QueryID:       req.QueryID,
+		Name:          req.Name,
+		Query:         req.Query,
+		Description:   req.Description,
+		Resolution:    req.Resolution,
+		Platform:      req.Platform,
+		Critical:      req.Critical,
+		MDMConditions: req.MDMConditions,
 	})
 	if err != nil {
 		return teamPolicyResponse{Err: err}, nil
@@ -330,6 +332,17 @@ func (svc *Service) modifyPolicy(ctx context.Context, teamID *uint, id uint, p f
 	if p.Critical != nil {
 		policy.Critical = *p.Critical
 	}
+	if p.MDMConditions != nil {
+		policy.MDMConditions = *p.MDMConditions
+	}
+	if p.Query != nil || p.MDMConditions != nil {
+		// the query can only be empty if the policy has MDM conditions
+		if err := policy.VerifyQuery(); err != nil {
+			return nil, ctxerr.Wrap(ctx, &fleet.BadRequestError{
+				Message: fmt.Sprintf("policy payload verification: %s", err),
+			})
+		}
+	}
 	logging.WithExtras(ctx, "name", policy.Name, "sql", policy.Query)
 
 	err = svc.ds.SavePolicy(ctx, policy)
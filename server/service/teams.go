@@ -144,30 +144,114 @@ func (svc *Service) ModifyTeam(ctx context.Context, id uint, payload fleet.TeamP
 ////////////////////////////////////////////////////////////////////////////////
 
 type deleteTeamRequest struct {
-	ID uint `url:"id"`
+	ID                uint  `url:"id"`
+	DestinationTeamID *uint `query:"destination_team_id,optional"`
 }
 
 type deleteTeamResponse struct {
-	Err error `json:"error,omitempty"`
+	JobID *uint `json:"job_id,omitempty"`
+	Err   error `json:"error,omitempty"`
 }
 
 func (r deleteTeamResponse) error() error { return r.Err }
 
 func deleteTeamEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
 	req := request.(*deleteTeamRequest)
-	err := svc.DeleteTeam(ctx, req.ID)
+	var job *fleet.Job
+	var err error
+	if req.DestinationTeamID != nil {
+		job, err = svc.StageTeamDeletion(ctx, req.ID, *req.DestinationTeamID)
+	} else {
+		job, err = svc.DeleteTeam(ctx, req.ID)
+	}
 	if err != nil {
 		return deleteTeamResponse{Err: err}, nil
 	}
-	return deleteTeamResponse{}, nil
+	var resp deleteTeamResponse
+	if job != nil {
+		resp.JobID = &job.ID
+	}
+	return resp, nil
 }
 
-func (svc *Service) DeleteTeam(ctx context.Context, tid uint) error {
+func (svc *Service) DeleteTeam(ctx context.Context, tid uint) (*fleet.Job, error) {
 	// skipauth: No authorization check needed due to implementation returning
 	// only license error.
 	svc.authz.SkipAuthorization(ctx)
 
-	return fleet.ErrMissingLicense
+	return nil, fleet.ErrMissingLicense
+}
+
+func (svc *Service) StageTeamDeletion(ctx context.Context, tid uint, destinationTeamID uint) (*fleet.Job, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
+
+	return nil, fleet.ErrMissingLicense
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Get Team Deletion Report
+////////////////////////////////////////////////////////////////////////////////
+
+type getTeamDeletionReportRequest struct {
+	ID uint `url:"id"`
+}
+
+type getTeamDeletionReportResponse struct {
+	Report *fleet.TeamDeletionReport `json:"report,omitempty"`
+	Err    error                     `json:"error,omitempty"`
+}
+
+func (r getTeamDeletionReportResponse) error() error { return r.Err }
+
+func getTeamDeletionReportEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getTeamDeletionReportRequest)
+	report, err := svc.GetTeamDeletionReport(ctx, req.ID)
+	if err != nil {
+		return getTeamDeletionReportResponse{Err: err}, nil
+	}
+	return getTeamDeletionReportResponse{Report: report}, nil
+}
+
+func (svc *Service) GetTeamDeletionReport(ctx context.Context, tid uint) (*fleet.TeamDeletionReport, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
+
+	return nil, fleet.ErrMissingLicense
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Get Team Deletion Progress
+////////////////////////////////////////////////////////////////////////////////
+
+type getTeamDeletionProgressRequest struct {
+	JobID uint `url:"job_id"`
+}
+
+type getTeamDeletionProgressResponse struct {
+	Progress *fleet.TeamDeletionProgress `json:"progress,omitempty"`
+	Err      error                       `json:"error,omitempty"`
+}
+
+func (r getTeamDeletionProgressResponse) error() error { return r.Err }
+
+func getTeamDeletionProgressEndpoint(ctx context.Context, request interface{}, svc fleet.Service) (errorer, error) {
+	req := request.(*getTeamDeletionProgressRequest)
+	progress, err := svc.GetTeamDeletionProgress(ctx, req.JobID)
+	if err != nil {
+		return getTeamDeletionProgressResponse{Err: err}, nil
+	}
+	return getTeamDeletionProgressResponse{Progress: progress}, nil
+}
+
+func (svc *Service) GetTeamDeletionProgress(ctx context.Context, jobID uint) (*fleet.TeamDeletionProgress, error) {
+	// skipauth: No authorization check needed due to implementation returning
+	// only license error.
+	svc.authz.SkipAuthorization(ctx)
+
+	return nil, fleet.ErrMissingLicense
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -13,6 +13,7 @@ import (
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/mock"
 	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/fleetdm/fleet/v4/server/worker"
 	"github.com/stretchr/testify/require"
 )
 
@@ -54,12 +55,25 @@ func TestTeamAuth(t *testing.T) {
 	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hids, tids, pids []uint, uuids []string) error {
 		return nil
 	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hids, tids, pids []uint, limit int) (int, error) {
+		return 0, nil
+	}
 	ds.ListHostsFunc = func(ctx context.Context, filter fleet.TeamFilter, opt fleet.HostListOptions) ([]*fleet.Host, error) {
 		return []*fleet.Host{}, nil
 	}
 	ds.CleanupDiskEncryptionKeysOnTeamChangeFunc = func(ctx context.Context, hostIDs []uint, newTeamID *uint) error {
 		return nil
 	}
+	ds.TeamDeletionReportFunc = func(ctx context.Context, tid uint) (*fleet.TeamDeletionReport, error) {
+		return &fleet.TeamDeletionReport{TeamID: tid}, nil
+	}
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		return job, nil
+	}
+	ds.GetJobFunc = func(ctx context.Context, jobID uint) (*fleet.Job, error) {
+		args := json.RawMessage(`{"team_id": 1, "hosts_count": 2}`)
+		return &fleet.Job{ID: jobID, Name: worker.TeamDeletionName, Args: &args}, nil
+	}
 
 	ds.TeamByNameFunc = func(ctx context.Context, name string) (*fleet.Team, error) {
 		switch name {
@@ -179,7 +193,17 @@ func TestTeamAuth(t *testing.T) {
 			_, err = svc.GetTeam(ctx, 1)
 			checkAuthErr(t, tt.shouldFailRead, err)
 
-			err = svc.DeleteTeam(ctx, 1)
+			_, err = svc.DeleteTeam(ctx, 1)
+			checkAuthErr(t, tt.shouldFailTeamWrite, err)
+
+			_, err = svc.GetTeamDeletionReport(ctx, 1)
+			checkAuthErr(t, tt.shouldFailTeamWrite, err)
+
+			// moving the hosts to no team requires global write
+			_, err = svc.StageTeamDeletion(ctx, 1, 0)
+			checkAuthErr(t, tt.shouldFailGlobalWrite, err)
+
+			_, err = svc.GetTeamDeletionProgress(ctx, 1)
 			checkAuthErr(t, tt.shouldFailTeamWrite, err)
 
 			_, err = svc.TeamEnrollSecrets(ctx, 1)
@@ -194,6 +218,61 @@ func TestTeamAuth(t *testing.T) {
 	}
 }
 
+func TestStageTeamDeletion(t *testing.T) {
+	ds := new(mock.Store)
+	license := &fleet.LicenseInfo{Tier: fleet.TierPremium, Expiration: time.Now().Add(24 * time.Hour)}
+	svc, ctx := newTestService(t, ds, nil, nil, &TestServerOpts{License: license, SkipCreateTestUsers: true})
+	ctx = viewer.NewContext(ctx, viewer.Viewer{User: &fleet.User{ID: 3, GlobalRole: ptr.String(fleet.RoleAdmin)}})
+
+	teams := map[uint]*fleet.Team{1: {ID: 1, Name: "team1", HostCount: 5}, 2: {ID: 2, Name: "team2"}}
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		if tm, ok := teams[tid]; ok {
+			return tm, nil
+		}
+		return nil, &notFoundError{}
+	}
+	var queued *fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		job.ID = 7
+		queued = job
+		return job, nil
+	}
+	ds.GetJobFunc = func(ctx context.Context, jobID uint) (*fleet.Job, error) {
+		require.Equal(t, uint(7), jobID)
+		return queued, nil
+	}
+
+	_, err := svc.StageTeamDeletion(ctx, 1, 1)
+	require.ErrorContains(t, err, "must be different from the deleted team")
+	_, err = svc.StageTeamDeletion(ctx, 1, 3)
+	require.True(t, fleet.IsNotFound(err))
+	require.False(t, ds.NewJobFuncInvoked)
+
+	job, err := svc.StageTeamDeletion(ctx, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, worker.TeamDeletionName, job.Name)
+	require.JSONEq(t, `{"team_id": 1, "team_name": "team1", "destination_team_id": 2, "hosts_count": 5, "user_id": 3}`, string(*job.Args))
+
+	// some hosts were moved
+	teams[1].HostCount = 2
+	progress, err := svc.GetTeamDeletionProgress(ctx, job.ID)
+	require.NoError(t, err)
+	require.Equal(t, uint(3), progress.HostsMoved)
+	require.False(t, progress.TeamDeleted)
+
+	// the team is deleted
+	delete(teams, 1)
+	progress, err = svc.GetTeamDeletionProgress(ctx, job.ID)
+	require.NoError(t, err)
+	require.Equal(t, uint(5), progress.HostsMoved)
+	require.True(t, progress.TeamDeleted)
+
+	// other jobs are not team deletions
+	queued.Name = worker.MDMAppleBulkSetPendingName
+	_, err = svc.GetTeamDeletionProgress(ctx, job.ID)
+	require.True(t, fleet.IsNotFound(err))
+}
+
 func TestApplyTeamSpecs(t *testing.T) {
 	ds := new(mock.Store)
 	license := &fleet.LicenseInfo{Tier: fleet.TierPremium, Expiration: time.Now().Add(24 * time.Hour)}
@@ -59,6 +59,7 @@ func newTestServiceWithConfig(t *testing.T, ds fleet.Datastore, fleetConfig conf
 		failingPolicySet  fleet.FailingPolicySet  = NewMemFailingPolicySet()
 		enrollHostLimiter fleet.EnrollHostLimiter = nopEnrollHostLimiter{}
 		is                fleet.InstallerStore
+		mdmAssetStore     fleet.MDMAssetStore
 		mdmStorage        nanomdm_storage.AllStorage
 		depStorage        nanodep_storage.AllStorage = &nanodep_mock.Storage{}
 		mdmPusher         nanomdm_push.Pusher
@@ -110,6 +111,7 @@ func newTestServiceWithConfig(t *testing.T, ds fleet.Datastore, fleetConfig conf
 		}
 		// allow to explicitly set mdm pusher to nil
 		mdmPusher = opts[0].MDMPusher
+		mdmAssetStore = opts[0].MDMAssetStore
 	}
 
 	ctx := license.NewContext(context.Background(), lic)
@@ -161,6 +163,7 @@ func newTestServiceWithConfig(t *testing.T, ds fleet.Datastore, fleetConfig conf
 			apple_mdm.NewMDMAppleCommander(mdmStorage, mdmPusher),
 			"",
 			ssoStore,
+			mdmAssetStore,
 		)
 		if err != nil {
 			panic(err)
@@ -254,6 +257,7 @@ type TestServerOpts struct {
 	Task                *async.Task
 	EnrollHostLimiter   fleet.EnrollHostLimiter
 	Is                  fleet.InstallerStore
+	MDMAssetStore       fleet.MDMAssetStore
 	FleetConfig         *config.FleetConfig
 	MDMStorage          nanomdm_storage.AllStorage
 	DEPStorage          nanodep_storage.AllStorage
@@ -297,7 +301,9 @@ func RunServerForTestsWithDS(t *testing.T, ds fleet.Datastore, opts ...*TestServ
 		mdmStorage := opts[0].MDMStorage
 		scepStorage := opts[0].SCEPStorage
 		if mdmStorage != nil && scepStorage != nil {
-			err := RegisterAppleMDMProtocolServices(
+			scepCert, _, _, err := cfg.MDM.AppleSCEP()
+			require.NoError(t, err)
+			err = RegisterAppleMDMProtocolServices(
 				rootMux,
 				cfg.MDM,
 				mdmStorage,
@@ -306,6 +312,7 @@ func RunServerForTestsWithDS(t *testing.T, ds fleet.Datastore, opts ...*TestServ
 				&MDMAppleCheckinAndCommandService{
 					ds:        ds,
 					commander: apple_mdm.NewMDMAppleCommander(mdmStorage, mdmPusher),
+					scepCert:  scepCert.Leaf,
 					logger:    kitlog.NewNopLogger(),
 				},
 			)
@@ -559,6 +566,12 @@ func mdmAppleConfigurationRequiredEndpoints() [][2]string {
 	return [][2]string{
 		{"POST", "/api/latest/fleet/mdm/apple/enrollmentprofiles"},
 		{"GET", "/api/latest/fleet/mdm/apple/enrollmentprofiles"},
+		{"POST", "/api/latest/fleet/mdm/apple/enrollment_links"},
+		{"GET", "/api/latest/fleet/mdm/apple/enrollment_links/1"},
+		{"GET", "/api/latest/fleet/mdm/apple/sso_enrollment_tokens"},
+		{"DELETE", "/api/latest/fleet/mdm/apple/sso_enrollment_tokens/1"},
+		{"GET", "/api/latest/fleet/mdm/apple/team_enrollment_profile"},
+		{"POST", "/api/latest/fleet/mdm/apple/team_enrollment_profile/rotate"},
 		{"POST", "/api/latest/fleet/mdm/apple/enqueue"},
 		{"GET", "/api/latest/fleet/mdm/apple/commandresults"},
 		{"GET", "/api/latest/fleet/mdm/apple/installers/1"},
@@ -566,17 +579,65 @@ func mdmAppleConfigurationRequiredEndpoints() [][2]string {
 		{"GET", "/api/latest/fleet/mdm/apple/installers"},
 		{"GET", "/api/latest/fleet/mdm/apple/devices"},
 		{"GET", "/api/latest/fleet/mdm/apple/dep/devices"},
+		{"GET", "/api/latest/fleet/mdm/apple/dep/assignment_discrepancies"},
+		{"DELETE", "/api/latest/fleet/mdm/apple/dep/devices/ABC"},
+		{"POST", "/api/latest/fleet/mdm/apple/dep/team_assignments"},
+		{"GET", "/api/latest/fleet/mdm/apple/dep/team_assignments"},
+		{"GET", "/api/latest/fleet/mdm/apple/dep/hosts_report"},
 		{"GET", "/api/latest/fleet/mdm/apple/profiles"},
 		{"GET", "/api/latest/fleet/mdm/apple/profiles/1"},
 		{"DELETE", "/api/latest/fleet/mdm/apple/profiles/1"},
+		{"POST", "/api/latest/fleet/mdm/apple/profiles/delete"},
+		{"POST", "/api/latest/fleet/mdm/apple/profiles/1/copy"},
+		{"PATCH", "/api/latest/fleet/mdm/apple/profiles/1/retry"},
 		{"GET", "/api/latest/fleet/mdm/apple/profiles/summary"},
+		{"GET", "/api/latest/fleet/mdm/apple/profiles/summary/all"},
+		{"GET", "/api/latest/fleet/mdm/posture_score"},
+		{"PATCH", "/api/latest/fleet/mdm/apple/profiles/1/target"},
+		{"PATCH", "/api/latest/fleet/mdm/apple/profiles/1/os_versions"},
+		{"POST", "/api/latest/fleet/mdm/apple/host_targets"},
+		{"GET", "/api/latest/fleet/mdm/apple/host_targets"},
+		{"GET", "/api/latest/fleet/mdm/apple/host_targets/1/hosts"},
+		{"DELETE", "/api/latest/fleet/mdm/apple/host_targets/1"},
+		{"GET", "/api/latest/fleet/mdm/apple/profile_changes"},
+		{"POST", "/api/latest/fleet/mdm/apple/profile_changes/1/approve"},
+		{"POST", "/api/latest/fleet/mdm/apple/profile_changes/1/reject"},
+		{"GET", "/api/latest/fleet/mdm/apple/blocked_enrollments"},
+		{"POST", "/api/latest/fleet/mdm/apple/filevault/rotate"},
+		{"GET", "/api/latest/fleet/mdm/apple/enrollment_mismatches"},
+		{"GET", "/api/latest/fleet/mdm/apple/hosts_missing_fleetd"},
+		{"GET", "/api/latest/fleet/mdm/apple/scep/certificates"},
+		{"GET", "/api/latest/fleet/mdm/apple/nano_enrollments"},
+		{"DELETE", "/api/latest/fleet/mdm/apple/nano_enrollments/abc"},
+		{"POST", "/api/latest/fleet/mdm/apple/nano_enrollments/abc/link"},
 		{"PATCH", "/api/latest/fleet/mdm/hosts/1/unenroll"},
 		{"GET", "/api/latest/fleet/mdm/hosts/1/encryption_key"},
+		{"GET", "/api/latest/fleet/mdm/hosts/1/encryption_key/accesses"},
+		{"GET", "/api/latest/fleet/mdm/hosts/1/activation_lock_bypass_code"},
+		{"GET", "/api/latest/fleet/mdm/hosts/1/certificates"},
 		{"POST", "/api/latest/fleet/mdm/hosts/1/lock"},
+		{"GET", "/api/latest/fleet/mdm/hosts/1/unlock_pin"},
+		{"POST", "/api/latest/fleet/mdm/hosts/1/quarantine"},
 		{"POST", "/api/latest/fleet/mdm/hosts/1/wipe"},
+		{"POST", "/api/latest/fleet/mdm/hosts/1/install_fleetd"},
+		{"GET", "/api/latest/fleet/mdm/hosts/1/queued_commands"},
+		{"DELETE", "/api/latest/fleet/mdm/hosts/1/queued_commands/abc"},
+		{"GET", "/api/latest/fleet/mdm/hosts/1/commands"},
+		{"GET", "/api/latest/fleet/mdm/webhooks/dead_letters"},
+		{"GET", "/api/latest/fleet/mdm/changes"},
+		{"POST", "/api/latest/fleet/mdm/apple/push"},
+		{"POST", "/api/latest/fleet/mdm/hosts/1/purge"},
+		{"POST", "/api/latest/fleet/hosts/1/mdm/profiles"},
+		{"DELETE", "/api/latest/fleet/hosts/1/mdm/profiles/1"},
+		{"POST", "/api/latest/fleet/mdm/apple/server_url_migration"},
+		{"GET", "/api/latest/fleet/mdm/apple/server_url_migration"},
+		{"GET", "/api/latest/fleet/mdm/apple/scep/ca_rotation"},
+		{"GET", "/api/latest/fleet/mdm/schedules"},
+		{"POST", "/api/latest/fleet/mdm/schedules/mdm_apple_profile_manager/trigger"},
 		{"PATCH", "/api/latest/fleet/mdm/apple/settings"},
 		{"GET", "/api/latest/fleet/mdm/apple"},
 		{"GET", apple_mdm.EnrollPath + "?token=test"},
+		{"GET", apple_mdm.EnrollLinkPath + "?enrollment_link=test"},
 		{"GET", apple_mdm.InstallerPath + "?token=test"},
 		{"GET", "/api/latest/fleet/mdm/apple/setup/eula/token"},
 		{"DELETE", "/api/latest/fleet/mdm/apple/setup/eula/token"},
@@ -589,5 +650,8 @@ func mdmAppleConfigurationRequiredEndpoints() [][2]string {
 		{"GET", "/api/latest/fleet/mdm/apple/enrollment_profile"},
 		{"POST", "/api/latest/fleet/mdm/apple/enrollment_profile"},
 		{"DELETE", "/api/latest/fleet/mdm/apple/enrollment_profile"},
+		{"POST", "/api/latest/fleet/mdm/apple/policies/1/action"},
+		{"GET", "/api/latest/fleet/mdm/apple/policies/1/action"},
+		{"DELETE", "/api/latest/fleet/mdm/apple/policies/1/action"},
 	}
 }
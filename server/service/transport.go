@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/fleet"
@@ -353,6 +354,17 @@ func hostListOptionsFromRequest(r *http.Request) (fleet.HostListOptions, error)
 		return hopt, ctxerr.Errorf(r.Context(), "invalid bootstrap_package status %s", mdmBootstrapPackageStatus)
 	}
 
+	osUpdateStatus := r.URL.Query().Get("os_update_status")
+	switch fleet.OSUpdateStatus(osUpdateStatus) {
+	case fleet.OSUpdateCompliant, fleet.OSUpdateDeferred, fleet.OSUpdateBehind:
+		ous := fleet.OSUpdateStatus(osUpdateStatus)
+		hopt.OSUpdateStatusFilter = &ous
+	case "":
+		// No error when unset
+	default:
+		return hopt, ctxerr.Wrap(r.Context(), badRequest(fmt.Sprintf("invalid os_update_status %s", osUpdateStatus)))
+	}
+
 	munkiIssueID := r.URL.Query().Get("munki_issue_id")
 	if munkiIssueID != "" {
 		id, err := strconv.Atoi(munkiIssueID)
@@ -375,6 +387,67 @@ func hostListOptionsFromRequest(r *http.Request) (fleet.HostListOptions, error)
 		hopt.LowDiskSpaceFilter = &v
 	}
 
+	certExpiring := r.URL.Query().Get("certificate_expiring_within_days")
+	if certExpiring != "" {
+		v, err := strconv.Atoi(certExpiring)
+		if err != nil {
+			return hopt, err
+		}
+		if v < 0 {
+			return hopt, ctxerr.Errorf(r.Context(), "invalid certificate_expiring_within_days, must be a positive number: %s", certExpiring)
+		}
+		hopt.CertificateExpiringWithinDaysFilter = &v
+	}
+
+	mdmCheckinWithin := r.URL.Query().Get("last_mdm_checkin_within_days")
+	if mdmCheckinWithin != "" {
+		v, err := strconv.Atoi(mdmCheckinWithin)
+		if err != nil {
+			return hopt, err
+		}
+		if v < 0 {
+			return hopt, ctxerr.Errorf(r.Context(), "invalid last_mdm_checkin_within_days, must be a positive number: %s", mdmCheckinWithin)
+		}
+		hopt.LastMDMCheckinWithinDaysFilter = &v
+	}
+
+	keyEscrowed := r.URL.Query().Get("disk_encryption_key_escrowed")
+	if keyEscrowed != "" {
+		v, err := strconv.ParseBool(keyEscrowed)
+		if err != nil {
+			return hopt, ctxerr.Errorf(r.Context(), "invalid disk_encryption_key_escrowed, must be true or false: %s", keyEscrowed)
+		}
+		hopt.DiskEncryptionKeyEscrowedFilter = &v
+	}
+
+	keyEscrowedBefore := r.URL.Query().Get("key_escrowed_before")
+	if keyEscrowedBefore != "" {
+		v, err := time.Parse(time.RFC3339, keyEscrowedBefore)
+		if err != nil {
+			// also accept a date without a time, which means midnight UTC.
+			v, err = time.Parse("2006-01-02", keyEscrowedBefore)
+			if err != nil {
+				return hopt, ctxerr.Errorf(r.Context(), "invalid key_escrowed_before, must be a date (YYYY-MM-DD) or an RFC3339 timestamp: %s", keyEscrowedBefore)
+			}
+		}
+		if hopt.DiskEncryptionKeyEscrowedFilter != nil && !*hopt.DiskEncryptionKeyEscrowedFilter {
+			return hopt, ctxerr.Errorf(r.Context(), "key_escrowed_before cannot be used with disk_encryption_key_escrowed=false")
+		}
+		hopt.KeyEscrowedBeforeFilter = &v
+	}
+
+	customAttrName := r.URL.Query().Get("custom_attribute_name")
+	customAttrValue, hasCustomAttrValue := r.URL.Query()["custom_attribute_value"]
+	if customAttrName != "" {
+		customAttrName = strings.ToLower(customAttrName)
+		hopt.CustomAttributeNameFilter = &customAttrName
+		if hasCustomAttrValue {
+			hopt.CustomAttributeValueFilter = &customAttrValue[0]
+		}
+	} else if hasCustomAttrValue {
+		return hopt, ctxerr.Errorf(r.Context(), "invalid custom_attribute_value, custom_attribute_name must also be provided")
+	}
+
 	return hopt, nil
 }
 
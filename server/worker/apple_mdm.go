@@ -0,0 +1,284 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	apple_mdm "github.com/fleetdm/fleet/v4/server/mdm/apple"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/google/uuid"
+)
+
+// MDMAppleBulkSetPendingName is the name of the job as registered in the
+// worker.
+const MDMAppleBulkSetPendingName = "mdm_apple_bulk_set_pending"
+
+var (
+	// mdmAppleBulkSetPendingSyncMaxHosts is the maximum number of affected hosts
+	// for which the pending profiles are set synchronously, above that a job is
+	// queued.
+	mdmAppleBulkSetPendingSyncMaxHosts = 1000
+
+	// mdmAppleBulkSetPendingBatchSize is the number of hosts processed at once
+	// by the job.
+	mdmAppleBulkSetPendingBatchSize = 1000
+)
+
+// mdmAppleBulkSetPendingArgs are the arguments of the job, only one of the
+// fields is set.
+type mdmAppleBulkSetPendingArgs struct {
+	HostIDs    []uint `json:"host_ids,omitempty"`
+	TeamIDs    []uint `json:"team_ids,omitempty"`
+	ProfileIDs []uint `json:"profile_ids,omitempty"`
+}
+
+// MDMAppleBulkSetPending is the job processor that sets the status of the
+// Apple MDM profiles to install or remove to pending for the affected hosts.
+type MDMAppleBulkSetPending struct {
+	Datastore fleet.Datastore
+	Log       kitlog.Logger
+}
+
+// Name returns the name of the job.
+func (m *MDMAppleBulkSetPending) Name() string {
+	return MDMAppleBulkSetPendingName
+}
+
+// Run executes the job.
+func (m *MDMAppleBulkSetPending) Run(ctx context.Context, argsJSON json.RawMessage) error {
+	var args mdmAppleBulkSetPendingArgs
+	if err := json.Unmarshal(argsJSON, &args); err != nil {
+		return ctxerr.Wrap(ctx, err, "unmarshal args")
+	}
+
+	// the hosts are loaded when the job runs, as they may have changed since it
+	// was queued (e.g. moved to another team).
+	uuids, err := m.Datastore.ListMDMAppleBulkSetPendingHostUUIDs(ctx, args.HostIDs, args.TeamIDs, args.ProfileIDs)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list affected hosts")
+	}
+
+	m.updateProgress(ctx, 0, len(uuids))
+	for start := 0; start < len(uuids); start += mdmAppleBulkSetPendingBatchSize {
+		end := start + mdmAppleBulkSetPendingBatchSize
+		if end > len(uuids) {
+			end = len(uuids)
+		}
+		if err := m.Datastore.BulkSetPendingMDMAppleHostProfiles(ctx, nil, nil, nil, uuids[start:end]); err != nil {
+			return ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
+		}
+		m.updateProgress(ctx, end, len(uuids))
+	}
+	return nil
+}
+
+// updateProgress records the progress of the job so that it can be followed
+// via the API. It only logs the errors, as failing the job would process the
+// hosts again.
+func (m *MDMAppleBulkSetPending) updateProgress(ctx context.Context, hostsDone, hostsCount int) {
+	level.Debug(m.Log).Log("msg", "bulk set pending host profiles", "hosts_done", hostsDone, "hosts_count", hostsCount)
+
+	jobID, ok := jobIDFromContext(ctx)
+	if !ok {
+		return
+	}
+	if err := m.Datastore.UpdateJobProgress(ctx, jobID, hostsDone, hostsCount); err != nil {
+		level.Error(m.Log).Log("msg", "update job progress", "job_id", jobID, "err", err)
+	}
+}
+
+// BulkSetPendingMDMAppleHostProfiles sets the status of the Apple MDM profiles
+// to install or remove to pending for the hosts affected by the hostIDs,
+// teamIDs or profileIDs (only one of those can be provided). If few hosts are
+// affected, it is done synchronously and a nil job is returned, otherwise a job
+// is queued to do it asynchronously via the worker and is returned.
+func BulkSetPendingMDMAppleHostProfiles(ctx context.Context, ds fleet.Datastore, logger kitlog.Logger,
+	hostIDs, teamIDs, profileIDs []uint,
+) (*fleet.Job, error) {
+	// only count the affected hosts up to the synchronous limit, the hosts are
+	// resolved by the datastore when done synchronously and by the job
+	// otherwise.
+	count, err := ds.CountMDMAppleBulkSetPendingHosts(ctx, hostIDs, teamIDs, profileIDs, mdmAppleBulkSetPendingSyncMaxHosts+1)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "count affected hosts")
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	if count <= mdmAppleBulkSetPendingSyncMaxHosts {
+		if err := ds.BulkSetPendingMDMAppleHostProfiles(ctx, hostIDs, teamIDs, profileIDs, nil); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
+		}
+		return nil, nil
+	}
+
+	args := &mdmAppleBulkSetPendingArgs{
+		HostIDs:    hostIDs,
+		TeamIDs:    teamIDs,
+		ProfileIDs: profileIDs,
+	}
+	job, err := QueueJob(ctx, ds, MDMAppleBulkSetPendingName, args)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "queueing job")
+	}
+	level.Info(logger).Log("msg", "queued bulk set pending host profiles job", "job_id", job.ID)
+	return job, nil
+}
+
+// MDMApplePolicyActionName is the name of the job as registered in the worker.
+const MDMApplePolicyActionName = "mdm_apple_policy_action"
+
+// mdmApplePolicyActionArgs are the arguments of the policy MDM action job.
+type mdmApplePolicyActionArgs struct {
+	PolicyID   uint   `json:"policy_id"`
+	PolicyName string `json:"policy_name"`
+	HostIDs    []uint `json:"host_ids"`
+}
+
+// MDMApplePolicyAction is the job processor that runs the MDM action of a
+// policy on the hosts that started failing it.
+type MDMApplePolicyAction struct {
+	Datastore fleet.Datastore
+	Commander fleet.MDMAppleCommandIssuer
+	Log       kitlog.Logger
+}
+
+// Name returns the name of the job.
+func (m *MDMApplePolicyAction) Name() string {
+	return MDMApplePolicyActionName
+}
+
+// Run executes the job.
+func (m *MDMApplePolicyAction) Run(ctx context.Context, argsJSON json.RawMessage) error {
+	var args mdmApplePolicyActionArgs
+	if err := json.Unmarshal(argsJSON, &args); err != nil {
+		return ctxerr.Wrap(ctx, err, "unmarshal args")
+	}
+
+	// the action is loaded when the job runs, as it may have changed or have
+	// been removed since it was queued.
+	action, err := m.Datastore.GetMDMApplePolicyAction(ctx, args.PolicyID)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			level.Debug(m.Log).Log("msg", "skipping, policy has no MDM action", "policy_id", args.PolicyID)
+			return nil
+		}
+		return ctxerr.Wrap(ctx, err, "get policy mdm action")
+	}
+
+	enrolled, err := m.Datastore.ListMDMApplePolicyActionHosts(ctx, args.HostIDs)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list policy mdm action hosts")
+	}
+	var hosts []*fleet.MDMApplePolicyActionHost
+	for _, h := range enrolled {
+		if action.AppliesToTeam(h.TeamID) {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == 0 {
+		level.Debug(m.Log).Log("msg", "skipping, no targeted host enrolled in MDM", "policy_id", args.PolicyID)
+		return nil
+	}
+
+	if len(action.RemediationProfile) > 0 {
+		uuids := make([]string, 0, len(hosts))
+		for _, h := range hosts {
+			uuids = append(uuids, h.UUID)
+		}
+		err := m.Commander.InstallProfile(ctx, uuids, action.RemediationProfile, uuid.New().String())
+		if err := m.handleCommandErr(err); err != nil {
+			return ctxerr.Wrap(ctx, err, "install remediation profile")
+		}
+	}
+
+	if action.CommandTemplate != "" {
+		var customAttrs map[string]map[string]string
+		if apple_mdm.HasHostCustomAttributeVars([]byte(action.CommandTemplate)) {
+			uuids := make([]string, 0, len(hosts))
+			for _, h := range hosts {
+				uuids = append(uuids, h.UUID)
+			}
+			customAttrs, err = m.Datastore.ListHostCustomAttributesByHostUUIDs(ctx, uuids)
+			if err != nil {
+				return ctxerr.Wrap(ctx, err, "list hosts custom attributes")
+			}
+		}
+
+		for _, h := range hosts {
+			vars := apple_mdm.PolicyCommandVariables{
+				apple_mdm.PolicyCommandVarHostUUID:         h.UUID,
+				apple_mdm.PolicyCommandVarHostSerialNumber: h.HardwareSerial,
+				apple_mdm.PolicyCommandVarHostDisplayName:  h.DisplayName,
+				apple_mdm.PolicyCommandVarPolicyName:       args.PolicyName,
+			}
+			vars.SetHostCustomAttributes(customAttrs[h.UUID])
+			raw, err := apple_mdm.ExpandPolicyCommandTemplate(action.CommandTemplate, vars, uuid.New().String())
+			if err != nil {
+				return ctxerr.Wrap(ctx, err, "expand command template")
+			}
+			// the command remediates a failing policy, deliver it before the
+			// commands already queued for the host.
+			err = m.Commander.EnqueueCommandWithPriority(ctx, []string{h.UUID}, raw, fleet.MDMAppleCommandPriorityUrgent)
+			if err := m.handleCommandErr(err); err != nil {
+				return ctxerr.Wrapf(ctx, err, "enqueue command for host %d", h.ID)
+			}
+		}
+	}
+	level.Debug(m.Log).Log("msg", "ran policy mdm action", "policy_id", args.PolicyID, "hosts_count", len(hosts))
+	return nil
+}
+
+// handleCommandErr returns nil if the command was enqueued even though some
+// push notifications failed, as the hosts will get the command on their next
+// check-in.
+func (m *MDMApplePolicyAction) handleCommandErr(err error) error {
+	var apnsErr *apple_mdm.APNSDeliveryError
+	if errors.As(err, &apnsErr) {
+		level.Debug(m.Log).Log("err", "sending push notifications, command still enqueued", "details", err)
+		return nil
+	}
+	return err
+}
+
+// QueueMDMApplePolicyActionJob queues a job to run the MDM action of a failing
+// policy on the failing hosts asynchronously via the worker.
+func QueueMDMApplePolicyActionJob(ctx context.Context, ds fleet.Datastore, logger kitlog.Logger,
+	policy *fleet.Policy, hosts []fleet.PolicySetHost,
+) error {
+	attrs := []interface{}{
+		"mdm_action", "true",
+		"failing_policy", policy.ID,
+		"hosts_count", len(hosts),
+	}
+	if policy.TeamID != nil {
+		attrs = append(attrs, "team_id", *policy.TeamID)
+	}
+	if len(hosts) == 0 {
+		attrs = append(attrs, "msg", "skipping, no host")
+		level.Debug(logger).Log(attrs...)
+		return nil
+	}
+
+	level.Info(logger).Log(attrs...)
+
+	args := &mdmApplePolicyActionArgs{
+		PolicyID:   policy.ID,
+		PolicyName: policy.Name,
+		HostIDs:    make([]uint, 0, len(hosts)),
+	}
+	for _, h := range hosts {
+		args.HostIDs = append(args.HostIDs, h.ID)
+	}
+	job, err := QueueJob(ctx, ds, MDMApplePolicyActionName, args)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "queueing job")
+	}
+	level.Debug(logger).Log("job_id", job.ID)
+	return nil
+}
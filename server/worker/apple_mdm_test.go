@@ -0,0 +1,241 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/mdm/apple/mobileconfig"
+	"github.com/fleetdm/fleet/v4/server/mock"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMDMAppleBulkSetPending(t *testing.T) {
+	ctx := context.Background()
+	logger := kitlog.NewNopLogger()
+
+	origSyncMax, origBatchSize := mdmAppleBulkSetPendingSyncMaxHosts, mdmAppleBulkSetPendingBatchSize
+	t.Cleanup(func() {
+		mdmAppleBulkSetPendingSyncMaxHosts, mdmAppleBulkSetPendingBatchSize = origSyncMax, origBatchSize
+	})
+	mdmAppleBulkSetPendingSyncMaxHosts = 3
+	mdmAppleBulkSetPendingBatchSize = 2
+
+	ds := new(mock.Store)
+	var hostUUIDs []string
+	ds.ListMDMAppleBulkSetPendingHostUUIDsFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint) ([]string, error) {
+		require.Equal(t, []uint{1}, teamIDs)
+		require.Nil(t, hostIDs)
+		require.Nil(t, profileIDs)
+		return hostUUIDs, nil
+	}
+	ds.CountMDMAppleBulkSetPendingHostsFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, limit int) (int, error) {
+		require.Equal(t, []uint{1}, teamIDs)
+		require.Nil(t, hostIDs)
+		require.Nil(t, profileIDs)
+		require.Equal(t, 4, limit)
+		if len(hostUUIDs) > limit {
+			return limit, nil
+		}
+		return len(hostUUIDs), nil
+	}
+	var batches [][]string
+	var setPendingTeamIDs []uint
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, uuids []string) error {
+		setPendingTeamIDs = teamIDs
+		batches = append(batches, uuids)
+		return nil
+	}
+	type progress struct{ done, count int }
+	var progresses []progress
+	ds.UpdateJobProgressFunc = func(ctx context.Context, id uint, hostsDone, hostsCount int) error {
+		require.Equal(t, uint(1), id)
+		progresses = append(progresses, progress{hostsDone, hostsCount})
+		return nil
+	}
+	var queued *fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		job.ID = 1
+		queued = job
+		return job, nil
+	}
+
+	// no affected host, nothing to do
+	job, err := BulkSetPendingMDMAppleHostProfiles(ctx, ds, logger, nil, []uint{1}, nil)
+	require.NoError(t, err)
+	require.Nil(t, job)
+	require.False(t, ds.BulkSetPendingMDMAppleHostProfilesFuncInvoked)
+	require.False(t, ds.NewJobFuncInvoked)
+
+	// few affected hosts, done synchronously by the datastore which resolves
+	// the hosts itself
+	hostUUIDs = []string{"a", "b", "c"}
+	job, err = BulkSetPendingMDMAppleHostProfiles(ctx, ds, logger, nil, []uint{1}, nil)
+	require.NoError(t, err)
+	require.Nil(t, job)
+	require.Equal(t, [][]string{nil}, batches)
+	require.Equal(t, []uint{1}, setPendingTeamIDs)
+	require.False(t, ds.ListMDMAppleBulkSetPendingHostUUIDsFuncInvoked)
+	require.False(t, ds.NewJobFuncInvoked)
+
+	// many affected hosts, a job is queued
+	batches = nil
+	hostUUIDs = []string{"a", "b", "c", "d", "e"}
+	job, err = BulkSetPendingMDMAppleHostProfiles(ctx, ds, logger, nil, []uint{1}, nil)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	require.Equal(t, uint(1), job.ID)
+	require.Equal(t, MDMAppleBulkSetPendingName, job.Name)
+	require.Equal(t, fleet.JobStateQueued, job.State)
+	require.Empty(t, batches)
+	require.False(t, ds.ListMDMAppleBulkSetPendingHostUUIDsFuncInvoked)
+	require.JSONEq(t, `{"team_ids": [1]}`, string(*queued.Args))
+
+	// running the job resolves the hosts and processes them in batches,
+	// recording its progress
+	j := &MDMAppleBulkSetPending{Datastore: ds, Log: logger}
+	err = j.Run(context.WithValue(ctx, jobIDKey, job.ID), *queued.Args)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, batches)
+	require.Equal(t, []progress{{0, 5}, {2, 5}, {4, 5}, {5, 5}}, progresses)
+
+	// failing to record the progress does not fail the job
+	batches = nil
+	ds.UpdateJobProgressFunc = func(ctx context.Context, id uint, hostsDone, hostsCount int) error {
+		return fmt.Errorf("fail")
+	}
+	err = j.Run(context.WithValue(ctx, jobIDKey, job.ID), *queued.Args)
+	require.NoError(t, err)
+	require.Len(t, batches, 3)
+
+	// a failing batch fails the job so that it is retried
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, uuids []string) error {
+		return fmt.Errorf("fail")
+	}
+	err = j.Run(ctx, json.RawMessage(`{"team_ids": [1]}`))
+	require.ErrorContains(t, err, "fail")
+}
+
+type mockPolicyActionCommander struct {
+	fleet.MDMAppleCommandIssuer
+	installs   [][]string
+	commands   map[string]string
+	priorities map[string]fleet.MDMAppleCommandPriority
+}
+
+func (m *mockPolicyActionCommander) InstallProfile(ctx context.Context, hostUUIDs []string, profile mobileconfig.Mobileconfig, uuid string) error {
+	m.installs = append(m.installs, hostUUIDs)
+	return nil
+}
+
+func (m *mockPolicyActionCommander) EnqueueCommandWithPriority(ctx context.Context, hostUUIDs []string, rawCommand string, priority fleet.MDMAppleCommandPriority) error {
+	for _, u := range hostUUIDs {
+		m.commands[u] = rawCommand
+		m.priorities[u] = priority
+	}
+	return nil
+}
+
+func TestMDMApplePolicyAction(t *testing.T) {
+	ctx := context.Background()
+	logger := kitlog.NewNopLogger()
+
+	ds := new(mock.Store)
+	var queued *fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		job.ID = 1
+		queued = job
+		return job, nil
+	}
+
+	// no host, nothing queued
+	policy := &fleet.Policy{PolicyData: fleet.PolicyData{ID: 1, Name: "FileVault"}}
+	err := QueueMDMApplePolicyActionJob(ctx, ds, logger, policy, nil)
+	require.NoError(t, err)
+	require.False(t, ds.NewJobFuncInvoked)
+
+	err = QueueMDMApplePolicyActionJob(ctx, ds, logger, policy, []fleet.PolicySetHost{{ID: 1}, {ID: 2}, {ID: 3}})
+	require.NoError(t, err)
+	require.NotNil(t, queued)
+	require.Equal(t, MDMApplePolicyActionName, queued.Name)
+
+	var action *fleet.MDMApplePolicyAction
+	ds.GetMDMApplePolicyActionFunc = func(ctx context.Context, policyID uint) (*fleet.MDMApplePolicyAction, error) {
+		require.Equal(t, uint(1), policyID)
+		if action == nil {
+			return nil, &mock.Error{Message: "not found"}
+		}
+		return action, nil
+	}
+	ds.ListMDMApplePolicyActionHostsFunc = func(ctx context.Context, hostIDs []uint) ([]*fleet.MDMApplePolicyActionHost, error) {
+		require.Equal(t, []uint{1, 2, 3}, hostIDs)
+		// host 2 is not enrolled in MDM
+		return []*fleet.MDMApplePolicyActionHost{
+			{ID: 1, UUID: "uuid-1", HardwareSerial: "serial-1", DisplayName: "host1"},
+			{ID: 3, UUID: "uuid-3", HardwareSerial: "serial-3", DisplayName: "host3", TeamID: ptr.Uint(2)},
+		}, nil
+	}
+
+	commander := &mockPolicyActionCommander{commands: make(map[string]string), priorities: make(map[string]fleet.MDMAppleCommandPriority)}
+	job := &MDMApplePolicyAction{Datastore: ds, Commander: commander, Log: logger}
+
+	// the action was removed since the job was queued
+	err = job.Run(ctx, *queued.Args)
+	require.NoError(t, err)
+	require.False(t, ds.ListMDMApplePolicyActionHostsFuncInvoked)
+
+	action = &fleet.MDMApplePolicyAction{
+		PolicyID:           1,
+		RemediationProfile: []byte("profile"),
+		CommandTemplate: `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>DeviceLock</string>
+		<key>Message</key>
+		<string>$FLEET_VAR_HOST_SERIAL_NUMBER fails $FLEET_VAR_POLICY_NAME</string>
+	</dict>
+</dict>
+</plist>`,
+	}
+	err = job.Run(ctx, *queued.Args)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"uuid-1", "uuid-3"}}, commander.installs)
+	require.Len(t, commander.commands, 2)
+	require.Contains(t, commander.commands["uuid-1"], "serial-1 fails FileVault")
+	require.Contains(t, commander.commands["uuid-3"], "serial-3 fails FileVault")
+	require.Equal(t, fleet.MDMAppleCommandPriorityUrgent, commander.priorities["uuid-1"])
+	require.Equal(t, fleet.MDMAppleCommandPriorityUrgent, commander.priorities["uuid-3"])
+
+	// scoped to hosts in no team
+	commander.installs, commander.commands = nil, make(map[string]string)
+	action.TeamIDs = []uint{0}
+	err = job.Run(ctx, *queued.Args)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"uuid-1"}}, commander.installs)
+	require.Len(t, commander.commands, 1)
+	require.Contains(t, commander.commands, "uuid-1")
+	require.False(t, ds.ListHostCustomAttributesByHostUUIDsFuncInvoked)
+
+	// the command references a host custom attribute
+	ds.ListHostCustomAttributesByHostUUIDsFunc = func(ctx context.Context, hostUUIDs []string) (map[string]map[string]string, error) {
+		require.Equal(t, []string{"uuid-1", "uuid-3"}, hostUUIDs)
+		return map[string]map[string]string{"uuid-1": {"idp_full_name": "Jane Doe"}}, nil
+	}
+	commander.installs, commander.commands = nil, make(map[string]string)
+	action.TeamIDs = nil
+	action.CommandTemplate = strings.Replace(action.CommandTemplate, "$FLEET_VAR_POLICY_NAME", "$FLEET_VAR_HOST_CUSTOM_ATTRIBUTE_IDP_FULL_NAME", 1)
+	err = job.Run(ctx, *queued.Args)
+	require.NoError(t, err)
+	require.True(t, ds.ListHostCustomAttributesByHostUUIDsFuncInvoked)
+	require.Contains(t, commander.commands["uuid-1"], "<string>serial-1 fails Jane Doe</string>")
+	require.Contains(t, commander.commands["uuid-3"], "<string>serial-3 fails </string>")
+}
@@ -0,0 +1,196 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/pkg/fleethttp"
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/google/uuid"
+)
+
+// MDMWebhookName is the name of the job as registered in the worker.
+const MDMWebhookName = "mdm_webhook"
+
+const (
+	// mdmWebhookMaxRetries is the number of retries of a delivery before the
+	// event is considered undeliverable (i.e. a dead letter).
+	mdmWebhookMaxRetries = 10
+	// mdmWebhookBaseDelay is the delay before the first retry, it doubles for
+	// each subsequent retry up to mdmWebhookMaxDelay.
+	mdmWebhookBaseDelay = time.Minute
+	mdmWebhookMaxDelay  = 6 * time.Hour
+
+	// the headers set on each delivery.
+	mdmWebhookEventHeader     = "X-Fleet-Event"
+	mdmWebhookDeliveryHeader  = "X-Fleet-Delivery"
+	mdmWebhookSignatureHeader = "X-Fleet-Signature"
+)
+
+// mdmWebhookArgs are the arguments of the MDM webhook job.
+type mdmWebhookArgs struct {
+	Event fleet.MDMWebhookEvent `json:"event"`
+}
+
+// MDMWebhook is the job processor that delivers the MDM events to the
+// configured webhook. Failed deliveries are retried with an exponential
+// backoff, and once all retries are exhausted the job is left in the failure
+// state, where it can be listed as a dead letter.
+type MDMWebhook struct {
+	Datastore fleet.Datastore
+	Log       kitlog.Logger
+	// Client is the HTTP client used for the deliveries, a client with a 30s
+	// timeout is used if it is nil.
+	Client *http.Client
+}
+
+// Name returns the name of the job.
+func (m *MDMWebhook) Name() string {
+	return MDMWebhookName
+}
+
+// MaxRetries implements RetryPolicy.
+func (m *MDMWebhook) MaxRetries() int {
+	return mdmWebhookMaxRetries
+}
+
+// RetryDelay implements RetryPolicy.
+func (m *MDMWebhook) RetryDelay(retry int) time.Duration {
+	delay := mdmWebhookBaseDelay
+	for i := 1; i < retry && delay < mdmWebhookMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > mdmWebhookMaxDelay {
+		delay = mdmWebhookMaxDelay
+	}
+	return delay
+}
+
+// Run executes the job.
+func (m *MDMWebhook) Run(ctx context.Context, argsJSON json.RawMessage) error {
+	var args mdmWebhookArgs
+	if err := json.Unmarshal(argsJSON, &args); err != nil {
+		return ctxerr.Wrap(ctx, err, "unmarshal args")
+	}
+
+	// the settings are loaded when the job runs so that a retry uses the
+	// up-to-date destination and secret.
+	appConfig, err := m.Datastore.AppConfig(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get app config")
+	}
+	settings := appConfig.WebhookSettings.MDMEventsWebhook
+	if !settings.Enable || settings.DestinationURL == "" {
+		level.Debug(m.Log).Log("msg", "skipping, MDM events webhook is disabled", "event_id", args.Event.ID)
+		return nil
+	}
+
+	body, err := json.Marshal(args.Event)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "marshal event")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, settings.DestinationURL, bytes.NewReader(body))
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(mdmWebhookEventHeader, string(args.Event.Type))
+	req.Header.Set(mdmWebhookDeliveryHeader, args.Event.ID)
+	if settings.Secret != "" {
+		req.Header.Set(mdmWebhookSignatureHeader, SignMDMWebhookPayload(settings.Secret, body))
+	}
+
+	client := m.Client
+	if client == nil {
+		client = fleethttp.NewClient(fleethttp.WithTimeout(30 * time.Second))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "deliver MDM webhook event")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return ctxerr.New(ctx, fmt.Sprintf("deliver MDM webhook event: unexpected status %d: %s", resp.StatusCode, respBody))
+	}
+	return nil
+}
+
+// SignMDMWebhookPayload returns the value of the signature header of an MDM
+// webhook delivery, which is the hex-encoded HMAC-SHA256 of the body keyed
+// with the secret.
+func SignMDMWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// QueueMDMWebhookEvent queues the delivery of an MDM event to the MDM events
+// webhook. It does nothing if the webhook is not enabled. The details are
+// marshaled as JSON and sent as the details of the event.
+func QueueMDMWebhookEvent(ctx context.Context, ds fleet.Datastore, logger kitlog.Logger,
+	eventType fleet.MDMWebhookEventType, hostUUID string, details interface{},
+) error {
+	appConfig, err := ds.AppConfig(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get app config")
+	}
+	if !appConfig.WebhookSettings.MDMEventsWebhook.Enable {
+		return nil
+	}
+
+	var detailsJSON json.RawMessage
+	if details != nil {
+		b, err := json.Marshal(details)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "marshal event details")
+		}
+		detailsJSON = b
+	}
+	args := &mdmWebhookArgs{
+		Event: fleet.MDMWebhookEvent{
+			ID:        uuid.New().String(),
+			Type:      eventType,
+			Timestamp: time.Now().UTC(),
+			HostUUID:  hostUUID,
+			Details:   detailsJSON,
+		},
+	}
+	job, err := QueueJob(ctx, ds, MDMWebhookName, args)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "queueing job")
+	}
+	level.Debug(logger).Log("msg", "queued MDM webhook event", "job_id", job.ID, "event_type", eventType, "event_id", args.Event.ID)
+	return nil
+}
+
+// MDMWebhookDeadLetterFromJob returns the dead letter represented by a failed
+// MDM webhook job.
+func MDMWebhookDeadLetterFromJob(job *fleet.Job) (*fleet.MDMWebhookDeadLetter, error) {
+	var args mdmWebhookArgs
+	if job.Args != nil {
+		if err := json.Unmarshal(*job.Args, &args); err != nil {
+			return nil, fmt.Errorf("unmarshal args of job %d: %w", job.ID, err)
+		}
+	}
+	return &fleet.MDMWebhookDeadLetter{
+		JobID:         job.ID,
+		Event:         args.Event,
+		Attempts:      job.Retries + 1,
+		Error:         job.Error,
+		CreatedAt:     job.CreatedAt,
+		LastAttemptAt: job.UpdatedAt,
+	}, nil
+}
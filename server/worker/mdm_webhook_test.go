@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/mock"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMDMWebhookRetryDelay(t *testing.T) {
+	m := &MDMWebhook{}
+	require.Equal(t, time.Minute, m.RetryDelay(1))
+	require.Equal(t, 2*time.Minute, m.RetryDelay(2))
+	require.Equal(t, 4*time.Minute, m.RetryDelay(3))
+	require.Equal(t, 256*time.Minute, m.RetryDelay(9))
+	require.Equal(t, 6*time.Hour, m.RetryDelay(10))
+	require.Equal(t, 6*time.Hour, m.RetryDelay(100))
+}
+
+func TestMDMWebhook(t *testing.T) {
+	ctx := context.Background()
+	logger := kitlog.NewNopLogger()
+
+	var (
+		status   = http.StatusOK
+		reqs     int
+		lastReq  *http.Request
+		lastBody []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs++
+		lastReq = r
+		lastBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	ds := new(mock.Store)
+	settings := fleet.MDMEventsWebhookSettings{}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{WebhookSettings: fleet.WebhookSettings{MDMEventsWebhook: settings}}, nil
+	}
+	var jobs []*fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		job.ID = uint(len(jobs) + 1)
+		jobs = append(jobs, job)
+		return job, nil
+	}
+
+	// webhook disabled, nothing queued
+	err := QueueMDMWebhookEvent(ctx, ds, logger, fleet.MDMWebhookEventEnrolled, "uuid-1", nil)
+	require.NoError(t, err)
+	require.Empty(t, jobs)
+
+	settings = fleet.MDMEventsWebhookSettings{Enable: true, DestinationURL: srv.URL, Secret: "s3cr3t"}
+	err = QueueMDMWebhookEvent(ctx, ds, logger, fleet.MDMWebhookEventCommandResult, "uuid-1", map[string]string{"command_uuid": "cmd-1"})
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, MDMWebhookName, jobs[0].Name)
+
+	job := &MDMWebhook{Datastore: ds, Log: logger}
+	err = job.Run(ctx, *jobs[0].Args)
+	require.NoError(t, err)
+	require.Equal(t, 1, reqs)
+
+	var got fleet.MDMWebhookEvent
+	require.NoError(t, json.Unmarshal(lastBody, &got))
+	require.Equal(t, fleet.MDMWebhookEventCommandResult, got.Type)
+	require.Equal(t, "uuid-1", got.HostUUID)
+	require.NotEmpty(t, got.ID)
+	require.JSONEq(t, `{"command_uuid": "cmd-1"}`, string(got.Details))
+	require.Equal(t, string(fleet.MDMWebhookEventCommandResult), lastReq.Header.Get("X-Fleet-Event"))
+	require.Equal(t, got.ID, lastReq.Header.Get("X-Fleet-Delivery"))
+	require.Equal(t, SignMDMWebhookPayload("s3cr3t", lastBody), lastReq.Header.Get("X-Fleet-Signature"))
+
+	// a failed delivery returns an error so that it is retried
+	status = http.StatusBadGateway
+	err = job.Run(ctx, *jobs[0].Args)
+	require.ErrorContains(t, err, "unexpected status 502")
+
+	// no signature without a secret
+	status = http.StatusOK
+	settings.Secret = ""
+	err = job.Run(ctx, *jobs[0].Args)
+	require.NoError(t, err)
+	require.Empty(t, lastReq.Header.Get("X-Fleet-Signature"))
+
+	// the webhook was disabled since the event was queued, it is dropped
+	settings.Enable = false
+	err = job.Run(ctx, *jobs[0].Args)
+	require.NoError(t, err)
+	require.Equal(t, 3, reqs)
+
+	// a failed job is a dead letter
+	jobs[0].Retries = 10
+	jobs[0].Error = "boom"
+	dl, err := MDMWebhookDeadLetterFromJob(jobs[0])
+	require.NoError(t, err)
+	require.Equal(t, got, dl.Event)
+	require.Equal(t, 11, dl.Attempts)
+	require.Equal(t, "boom", dl.Error)
+}
+
+func TestSignMDMWebhookPayload(t *testing.T) {
+	// computed with: echo -n '{"a":1}' | openssl dgst -sha256 -hmac key
+	require.Equal(t, "sha256=88a67f24bbcdaed0e6c997404bb79a743baf44c6bab2f4c27328e3009d22e342", SignMDMWebhookPayload("key", []byte(`{"a":1}`)))
+}
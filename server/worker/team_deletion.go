@@ -0,0 +1,109 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// TeamDeletionName is the name of the job as registered in the worker.
+const TeamDeletionName = "team_deletion"
+
+// teamDeletionBatchSize is the number of hosts moved at once by the job.
+var teamDeletionBatchSize = 500
+
+// TeamDeletionArgs are the arguments of the staged team deletion job.
+type TeamDeletionArgs struct {
+	TeamID   uint   `json:"team_id"`
+	TeamName string `json:"team_name"`
+	// DestinationTeamID is the team the hosts are moved to, 0 for no team.
+	DestinationTeamID uint `json:"destination_team_id"`
+	// HostsCount is the number of hosts of the team when the deletion was
+	// staged, used to report the progress.
+	HostsCount uint `json:"hosts_count"`
+	// UserID is the user that staged the deletion, it is the actor of the
+	// activity created once the team is deleted.
+	UserID uint `json:"user_id,omitempty"`
+}
+
+// TeamDeletion is the job processor that moves the hosts of a team to the
+// destination team in batches, setting their profiles to pending as it goes,
+// and deletes the team once it has no hosts left. This spreads the profile
+// changes over time instead of flipping all of them at once.
+type TeamDeletion struct {
+	Datastore fleet.Datastore
+	Log       kitlog.Logger
+}
+
+// Name returns the name of the job.
+func (t *TeamDeletion) Name() string {
+	return TeamDeletionName
+}
+
+// Run executes the job.
+func (t *TeamDeletion) Run(ctx context.Context, argsJSON json.RawMessage) error {
+	var args TeamDeletionArgs
+	if err := json.Unmarshal(argsJSON, &args); err != nil {
+		return ctxerr.Wrap(ctx, err, "unmarshal args")
+	}
+
+	if _, err := t.Datastore.Team(ctx, args.TeamID); err != nil {
+		if fleet.IsNotFound(err) {
+			level.Debug(t.Log).Log("msg", "skipping, team already deleted", "team_id", args.TeamID)
+			return nil
+		}
+		return ctxerr.Wrap(ctx, err, "get team")
+	}
+
+	var destTeamID *uint
+	if args.DestinationTeamID != 0 {
+		destTeamID = &args.DestinationTeamID
+	}
+
+	// the hosts are moved until none is left, which also handles the hosts
+	// added to the team while the job runs, and resumes where it stopped if
+	// the job is retried.
+	var moved int
+	for {
+		hostIDs, err := t.Datastore.HostIDsByTeam(ctx, args.TeamID, teamDeletionBatchSize)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "list team hosts")
+		}
+		if len(hostIDs) == 0 {
+			break
+		}
+		if err := t.Datastore.AddHostsToTeam(ctx, destTeamID, hostIDs); err != nil {
+			return ctxerr.Wrap(ctx, err, "move hosts to destination team")
+		}
+		if err := t.Datastore.BulkSetPendingMDMAppleHostProfiles(ctx, hostIDs, nil, nil, nil); err != nil {
+			return ctxerr.Wrap(ctx, err, "bulk set pending host profiles")
+		}
+		moved += len(hostIDs)
+		level.Debug(t.Log).Log("msg", "moved team hosts", "team_id", args.TeamID, "hosts_done", moved, "hosts_count", args.HostsCount)
+	}
+
+	if err := t.Datastore.DeleteTeam(ctx, args.TeamID); err != nil {
+		return ctxerr.Wrap(ctx, err, "delete team")
+	}
+
+	var user *fleet.User
+	if args.UserID != 0 {
+		u, err := t.Datastore.UserByID(ctx, args.UserID)
+		if err != nil && !fleet.IsNotFound(err) {
+			return ctxerr.Wrap(ctx, err, "get user")
+		}
+		user = u
+	}
+	if err := t.Datastore.NewActivity(ctx, user, fleet.ActivityTypeDeletedTeam{
+		ID:   args.TeamID,
+		Name: args.TeamName,
+	}); err != nil {
+		return ctxerr.Wrap(ctx, err, "create activity for team deletion")
+	}
+	level.Info(t.Log).Log("msg", "deleted team", "team_id", args.TeamID, "hosts_moved", moved)
+	return nil
+}
@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/mock"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeamDeletion(t *testing.T) {
+	ctx := context.Background()
+
+	origBatchSize := teamDeletionBatchSize
+	t.Cleanup(func() { teamDeletionBatchSize = origBatchSize })
+	teamDeletionBatchSize = 2
+
+	ds := new(mock.Store)
+	teamHosts := []uint{1, 2, 3}
+	teamDeleted := false
+	ds.TeamFunc = func(ctx context.Context, tid uint) (*fleet.Team, error) {
+		require.Equal(t, uint(1), tid)
+		if teamDeleted {
+			return nil, &mock.Error{Message: "not found"}
+		}
+		return &fleet.Team{ID: tid}, nil
+	}
+	ds.HostIDsByTeamFunc = func(ctx context.Context, teamID uint, limit int) ([]uint, error) {
+		require.Equal(t, uint(1), teamID)
+		if len(teamHosts) < limit {
+			return teamHosts, nil
+		}
+		return teamHosts[:limit], nil
+	}
+	var moved [][]uint
+	ds.AddHostsToTeamFunc = func(ctx context.Context, teamID *uint, hostIDs []uint) error {
+		require.NotNil(t, teamID)
+		require.Equal(t, uint(2), *teamID)
+		moved = append(moved, hostIDs)
+		teamHosts = teamHosts[len(hostIDs):]
+		return nil
+	}
+	var pending [][]uint
+	ds.BulkSetPendingMDMAppleHostProfilesFunc = func(ctx context.Context, hostIDs, teamIDs, profileIDs []uint, uuids []string) error {
+		pending = append(pending, hostIDs)
+		return nil
+	}
+	ds.DeleteTeamFunc = func(ctx context.Context, tid uint) error {
+		require.Empty(t, teamHosts)
+		teamDeleted = true
+		return nil
+	}
+	ds.UserByIDFunc = func(ctx context.Context, id uint) (*fleet.User, error) {
+		return &fleet.User{ID: id}, nil
+	}
+	var actor *fleet.User
+	ds.NewActivityFunc = func(ctx context.Context, user *fleet.User, activity fleet.ActivityDetails) error {
+		actor = user
+		require.Equal(t, fleet.ActivityTypeDeletedTeam{ID: 1, Name: "tm"}, activity)
+		return nil
+	}
+
+	argsJSON, err := json.Marshal(TeamDeletionArgs{TeamID: 1, TeamName: "tm", DestinationTeamID: 2, HostsCount: 3, UserID: 42})
+	require.NoError(t, err)
+
+	job := &TeamDeletion{Datastore: ds, Log: kitlog.NewNopLogger()}
+	require.NoError(t, job.Run(ctx, argsJSON))
+	require.Equal(t, [][]uint{{1, 2}, {3}}, moved)
+	require.Equal(t, moved, pending)
+	require.True(t, teamDeleted)
+	require.Equal(t, uint(42), actor.ID)
+
+	// the team is already deleted, nothing to do
+	ds.DeleteTeamFuncInvoked = false
+	require.NoError(t, job.Run(ctx, argsJSON))
+	require.False(t, ds.DeleteTeamFuncInvoked)
+}
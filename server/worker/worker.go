@@ -35,6 +35,29 @@ type Job interface {
 	Run(ctx context.Context, argsJSON json.RawMessage) error
 }
 
+// RetryPolicy is implemented by the jobs that need a different retry schedule
+// than the worker's default one.
+type RetryPolicy interface {
+	// MaxRetries is the number of times a failed job is retried before its
+	// state is set to failure.
+	MaxRetries() int
+
+	// RetryDelay returns the minimum delay before the provided retry (starting
+	// at 1) is attempted.
+	RetryDelay(retry int) time.Duration
+}
+
+type ctxKey int
+
+const jobIDKey ctxKey = 0
+
+// jobIDFromContext returns the ID of the job being run, as set by the worker
+// in the context passed to Run.
+func jobIDFromContext(ctx context.Context) (uint, bool) {
+	id, ok := ctx.Value(jobIDKey).(uint)
+	return id, ok
+}
+
 // failingPolicyArgs are the args common to all integrations that can process
 // failing policies.
 type failingPolicyArgs struct {
@@ -147,12 +170,10 @@ func (w *Worker) ProcessJobs(ctx context.Context) error {
 			if err := w.processJob(ctx, job); err != nil {
 				level.Error(log).Log("msg", "process job", "err", err)
 				job.Error = err.Error()
-				if job.Retries < maxRetries {
+				if job.Retries < w.maxRetries(job) {
 					level.Debug(log).Log("msg", "will retry job")
 					job.Retries += 1
-					if job.Retries < len(delayPerRetry) {
-						job.NotBefore = time.Now().Add(delayPerRetry[job.Retries])
-					}
+					job.NotBefore = time.Now().Add(w.retryDelay(job))
 				} else {
 					job.State = fleet.JobStateFailure
 				}
@@ -173,6 +194,23 @@ func (w *Worker) ProcessJobs(ctx context.Context) error {
 	return nil
 }
 
+func (w *Worker) maxRetries(job *fleet.Job) int {
+	if rp, ok := w.registry[job.Name].(RetryPolicy); ok {
+		return rp.MaxRetries()
+	}
+	return maxRetries
+}
+
+func (w *Worker) retryDelay(job *fleet.Job) time.Duration {
+	if rp, ok := w.registry[job.Name].(RetryPolicy); ok {
+		return rp.RetryDelay(job.Retries)
+	}
+	if job.Retries < len(delayPerRetry) {
+		return delayPerRetry[job.Retries]
+	}
+	return 0
+}
+
 func (w *Worker) processJob(ctx context.Context, job *fleet.Job) error {
 	j, ok := w.registry[job.Name]
 	if !ok {
@@ -184,7 +222,7 @@ func (w *Worker) processJob(ctx context.Context, job *fleet.Job) error {
 		args = *job.Args
 	}
 
-	return j.Run(ctx, args)
+	return j.Run(context.WithValue(ctx, jobIDKey, job.ID), args)
 }
 
 type failingPoliciesTplArgs struct {
@@ -66,6 +66,9 @@ func TestWorker(t *testing.T) {
 			jobCalled = true
 
 			assert.Equal(t, json.RawMessage(`{"arg1":"foo"}`), argsJSON)
+			jobID, ok := jobIDFromContext(ctx)
+			assert.True(t, ok)
+			assert.Equal(t, uint(1), jobID)
 			return nil
 		},
 	}